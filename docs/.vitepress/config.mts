@@ -61,6 +61,7 @@ export default defineConfig({
             { text: 'Go', link: '/examples/go' },
             { text: 'TypeScript', link: '/examples/typescript' },
             { text: 'Python', link: '/examples/python' },
+            { text: 'C#', link: '/examples/csharp' },
           ]
         }
       ]
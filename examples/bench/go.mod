@@ -0,0 +1,20 @@
+module example
+
+go 1.25.3
+
+require (
+	github.com/nats-io/nats.go v1.47.0
+	github.com/toyz/protoc-gen-nats-micro v0.0.0-20251111043830-f26d09cffc8f
+	google.golang.org/genproto/googleapis/api v0.0.0-20251111163417-95abcf5c77ba
+	google.golang.org/protobuf v1.36.10
+)
+
+require (
+	github.com/klauspost/compress v1.18.0 // indirect
+	github.com/nats-io/nkeys v0.4.11 // indirect
+	github.com/nats-io/nuid v1.0.1 // indirect
+	golang.org/x/crypto v0.41.0 // indirect
+	golang.org/x/sys v0.35.0 // indirect
+)
+
+replace github.com/toyz/protoc-gen-nats-micro => ../../
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/nats-io/nats.go"
+
+	typesv1 "example/gen/common/types/v1"
+	productv1 "example/gen/product/v1"
+)
+
+func main() {
+	url := flag.String("url", nats.DefaultURL, "NATS server URL")
+	method := flag.String("method", "get", "ProductService method to load-test: create, get or search")
+	concurrency := flag.Int("c", 16, "requests in flight at once")
+	requests := flag.Int("n", 0, "requests to make (0 = run for -d)")
+	duration := flag.Duration("d", 10*time.Second, "how long to run when -n is 0")
+	warmup := flag.Int("warmup", 100, "requests made before measuring")
+	jsonOut := flag.String("json", "", "also write the report as JSON to this file (- = stdout)")
+	flag.Parse()
+
+	nc, err := nats.Connect(*url)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer nc.Close()
+
+	// Ctrl+C stops the run early; the report covers the requests made so far
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	// The bench calls through the generated client, so its encoding and any
+	// client interceptors are part of what is measured
+	client := productv1.NewProductServiceNatsClient(nc)
+	bench := productv1.NewProductServiceBench(client)
+	cfg := productv1.BenchConfig{Concurrency: *concurrency, Requests: *requests, Warmup: *warmup}
+	if *requests == 0 {
+		cfg.Duration = *duration
+	}
+
+	var report productv1.BenchReport
+	switch *method {
+	case "create":
+		report, err = bench.RunCreateProduct(ctx, cfg, newCreateRequest)
+	case "get":
+		// Create products to read first; one request in ten asks for a missing one
+		var ids []string
+		if ids, err = seedProducts(ctx, client, 100); err != nil {
+			log.Fatal(err)
+		}
+		report, err = bench.RunGetProduct(ctx, cfg, func(i int) *productv1.GetProductRequest {
+			if i%10 == 9 {
+				return &productv1.GetProductRequest{Id: "missing"}
+			}
+			return &productv1.GetProductRequest{Id: ids[i%len(ids)]}
+		})
+	case "search":
+		report, err = bench.RunSearchProducts(ctx, cfg, func(i int) *productv1.SearchProductsRequest {
+			return &productv1.SearchProductsRequest{Query: "widget", PageSize: 20}
+		})
+	default:
+		log.Fatalf("unknown method %q: want create, get or search", *method)
+	}
+	if err != nil && ctx.Err() == nil {
+		log.Fatal(err)
+	}
+
+	fmt.Println(report)
+	for code, n := range report.ErrorsByCode {
+		fmt.Printf("  %s: %d\n", code, n)
+	}
+	if *jsonOut != "" {
+		if err := writeReport(*jsonOut, report); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+// newCreateRequest returns the i-th product to create, each with its own SKU
+func newCreateRequest(i int) *productv1.CreateProductRequest {
+	return &productv1.CreateProductRequest{
+		Name:          fmt.Sprintf("Bench widget %d", i),
+		Sku:           fmt.Sprintf("BENCH-%06d", i),
+		Category:      productv1.ProductCategory_CATEGORY_HOME,
+		Price:         &typesv1.Money{CurrencyCode: "USD", Units: 9, Nanos: 990_000_000},
+		StockQuantity: 100,
+	}
+}
+
+// seedProducts creates n products and returns their IDs
+func seedProducts(ctx context.Context, client productv1.ProductServiceNatsClientInterface, n int) ([]string, error) {
+	ids := make([]string, 0, n)
+	for i := range n {
+		resp, err := client.CreateProduct(ctx, newCreateRequest(i))
+		if err != nil {
+			return nil, fmt.Errorf("failed to seed products: %w", err)
+		}
+		ids = append(ids, resp.Product.Id)
+	}
+	return ids, nil
+}
+
+// writeReport writes the report as JSON to path, or to stdout for "-"
+func writeReport(path string, report productv1.BenchReport) error {
+	if path == "-" {
+		return report.WriteJSON(os.Stdout)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return report.WriteJSON(f)
+}
@@ -342,7 +342,21 @@ func main() {
 	log.Printf("  Roles:    %v", binaryUserResp.User.Roles)
 	log.Printf("  Metadata: %v", binaryUserResp.User.Metadata)
 
-	log.Println("\n✅ All tests passed! v1, v2, JSON and Binary APIs all working!")
+	// Test mixed service: Echo is binary, Webhook is JSON, picked by codegen on both sides
+	log.Println("\n→ Testing mixed-encoding service...")
+	mixedClient := demov1.NewMixedServiceNatsClient(nc)
+	for name, call := range map[string]func(context.Context, *demov1.EchoRequest, ...demov1.CallOption) (*demov1.EchoResponse, error){
+		"Echo":    mixedClient.Echo,
+		"Webhook": mixedClient.Webhook,
+	} {
+		resp, err := call(ctx, &demov1.EchoRequest{Message: "Hello " + name + "!", Timestamp: time.Now().Unix()})
+		if err != nil {
+			log.Fatalf("Mixed %s failed: %v", name, err)
+		}
+		log.Printf("✓ Mixed %s Response: %s (%s)", name, resp.Message, resp.Encoding)
+	}
+
+	log.Println("\n✅ All tests passed! v1, v2, JSON, Binary and mixed APIs all working!")
 	log.Println("\n💡 Note: JSON encoding uses human-readable format (larger, slower)")
 	log.Println("   Binary encoding uses protobuf binary format (smaller, faster)")
 }
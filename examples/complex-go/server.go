@@ -314,6 +314,19 @@ func (s *binaryDemoService) GetUser(ctx context.Context, req *demov1.GetUserRequ
 	}, nil
 }
 
+// Mixed encoding demo service implementation
+type mixedDemoService struct{}
+
+func (s *mixedDemoService) Echo(ctx context.Context, req *demov1.EchoRequest) (*demov1.EchoResponse, error) {
+	log.Printf("✓ [MIXED] Echo: %s", req.Message)
+	return &demov1.EchoResponse{Message: "Mixed Echo: " + req.Message, Timestamp: time.Now().Unix(), Encoding: "binary"}, nil
+}
+
+func (s *mixedDemoService) Webhook(ctx context.Context, req *demov1.EchoRequest) (*demov1.EchoResponse, error) {
+	log.Printf("✓ [MIXED] Webhook: %s", req.Message)
+	return &demov1.EchoResponse{Message: "Mixed Webhook: " + req.Message, Timestamp: time.Now().Unix(), Encoding: "json"}, nil
+}
+
 // Example server interceptors
 
 // Product service interceptors - demonstrates reading request headers and setting response headers
@@ -376,11 +389,13 @@ func main() {
 	log.Println("✓ Connected to NATS")
 
 	// Register product service (subject prefix "api.v1" read from proto!)
-	// with logging and metrics interceptors
+	// with logging and metrics interceptors, and metadata `nats micro info` shows
 	productSvc := &productService{products: make(map[string]*productv1.Product)}
 	productService, err := productv1.RegisterProductServiceHandlers(nc, productSvc,
 		productv1.WithServerInterceptor(productLoggingInterceptor),
 		productv1.WithServerInterceptor(productMetricsInterceptor),
+		productv1.WithAdditionalMetadata(map[string]string{"team": "catalog", "region": "us-east-1"}),
+		productv1.WithEndpointMetadata("SearchProducts", map[string]string{"cost": "high"}),
 	)
 	if err != nil {
 		log.Fatal(err)
@@ -425,6 +440,13 @@ func main() {
 	}
 	log.Println("✓ Registered BinaryService (Binary encoding)")
 
+	// Register mixed demo service (binary Echo, JSON Webhook via (natsmicro.endpoint).encoding)
+	mixedService, err := demov1.RegisterMixedServiceHandlers(nc, &mixedDemoService{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Println("✓ Registered MixedService (per-method encoding)")
+
 	// Print all service endpoints
 	log.Println("\n📡 ProductService Endpoints:")
 	for _, ep := range productService.Endpoints() {
@@ -451,6 +473,11 @@ func main() {
 		log.Printf("  • %s → %s", ep.Name, ep.Subject)
 	}
 
+	log.Println("\n📡 MixedService Endpoints (per-method encoding):")
+	for _, ep := range mixedService.Endpoints() {
+		log.Printf("  • %s → %s", ep.Name, ep.Subject)
+	}
+
 	log.Println("\n✅ Server running. Press Ctrl+C to stop.")
 
 	sig := make(chan os.Signal, 1)
@@ -0,0 +1,13 @@
+module example
+
+go 1.25.3
+
+require (
+	github.com/nats-io/nats-server/v2 v2.10.22
+	github.com/nats-io/nats.go v1.47.0
+	github.com/toyz/protoc-gen-nats-micro v0.0.0-20251111043830-f26d09cffc8f
+	google.golang.org/genproto/googleapis/api v0.0.0-20250929231259-57b25ae835d4
+	google.golang.org/protobuf v1.36.10
+)
+
+replace github.com/toyz/protoc-gen-nats-micro => ../../
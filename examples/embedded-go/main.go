@@ -0,0 +1,74 @@
+// Command embedded runs ProductService and OrderService with an embedded NATS
+// server, as one binary with no NATS deployment of its own.
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/nats-io/nats.go"
+
+	orderv1 "example/gen/order/v1"
+	productv1 "example/gen/product/v1"
+
+	"example/natsembed"
+)
+
+// stack is the embedded server and the services registered on it
+type stack struct {
+	nc       *nats.Conn
+	shutdown func()
+	services []orderv1.Drainer
+}
+
+// startStack starts an embedded server and registers both services on it
+func startStack(opts natsembed.Options) (*stack, error) {
+	nc, shutdown, err := natsembed.Start(opts)
+	if err != nil {
+		return nil, err
+	}
+	data := newCatalog()
+	products, err := productv1.RegisterProductServiceHandlers(nc, productService{data})
+	if err != nil {
+		shutdown()
+		return nil, err
+	}
+	orders, err := orderv1.RegisterOrderServiceHandlers(nc, orderService{data})
+	if err != nil {
+		products.Stop()
+		shutdown()
+		return nil, err
+	}
+	return &stack{nc: nc, shutdown: shutdown, services: []orderv1.Drainer{products, orders}}, nil
+}
+
+// run serves until ctx ends, then drains the services before stopping the
+// server, so requests in flight are answered
+func (s *stack) run(ctx context.Context, drainTimeout time.Duration) error {
+	defer s.shutdown()
+	return orderv1.RunServices(ctx, drainTimeout, s.services...)
+}
+
+func main() {
+	port := flag.Int("port", 4222, "port for external NATS clients (0 picks a free one)")
+	storeDir := flag.String("store", "", "JetStream storage directory (default: a temporary one)")
+	flag.Parse()
+
+	s, err := startStack(natsembed.Options{ServerName: "appliance", Port: *port, StoreDir: *storeDir})
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("✓ Serving ProductService and OrderService on %s", s.nc.ConnectedUrl())
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := s.run(ctx, 10*time.Second); err != nil {
+		log.Printf("drain: %v", err)
+	}
+	log.Println("✓ Stopped")
+}
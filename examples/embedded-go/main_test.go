@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	locationv1 "example/gen/common/location/v1"
+	typesv1 "example/gen/common/types/v1"
+	orderv1 "example/gen/order/v1"
+	productv1 "example/gen/product/v1"
+
+	"example/natsembed"
+)
+
+// TestEmbeddedStack runs the complex example's client flows against services
+// served by an embedded server
+func TestEmbeddedStack(t *testing.T) {
+	s, err := startStack(natsembed.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- s.run(ctx, 5*time.Second) }()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	call, stop := context.WithTimeout(context.Background(), 10*time.Second)
+	defer stop()
+	products := productv1.NewProductServiceNatsClient(s.nc)
+	orders := orderv1.NewOrderServiceNatsClient(s.nc)
+
+	created, err := products.CreateProduct(call, &productv1.CreateProductRequest{
+		Name:          "Wireless Headphones",
+		Sku:           "HEADPHONES-001",
+		Category:      productv1.ProductCategory_CATEGORY_ELECTRONICS,
+		Price:         &typesv1.Money{CurrencyCode: "USD", Units: 299},
+		StockQuantity: 50,
+	})
+	if err != nil {
+		t.Fatalf("CreateProduct: %v", err)
+	}
+	product := created.Product
+	got, err := products.GetProduct(call, &productv1.GetProductRequest{Id: product.Id})
+	if err != nil || got.Product.Name != product.Name {
+		t.Fatalf("GetProduct = %v, %v", got, err)
+	}
+	if _, err := products.GetProduct(call, &productv1.GetProductRequest{Id: "missing"}); !productv1.IsNotFound(err) {
+		t.Fatalf("GetProduct(missing) err = %v, want NOT_FOUND", err)
+	}
+
+	order, err := orders.CreateOrder(call, &orderv1.CreateOrderRequest{
+		CustomerId:   "customer-123",
+		CustomerName: "Alice Johnson",
+		Items: []*orderv1.OrderItem{{
+			ProductId:  product.Id,
+			Quantity:   2,
+			UnitPrice:  product.Price,
+			TotalPrice: &typesv1.Money{CurrencyCode: "USD", Units: 598},
+		}},
+		ShippingAddress: &locationv1.Address{City: "San Francisco", Country: "USA"},
+	})
+	if err != nil {
+		t.Fatalf("CreateOrder: %v", err)
+	}
+	if order.Order.Total.Units != 657 {
+		t.Errorf("total = %d, want 657", order.Order.Total.Units)
+	}
+	updated, err := orders.UpdateOrderStatus(call, &orderv1.UpdateOrderStatusRequest{
+		Id:     order.Order.Id,
+		Status: typesv1.Status_STATUS_ACTIVE,
+	})
+	if err != nil || updated.Order.Status != typesv1.Status_STATUS_ACTIVE {
+		t.Fatalf("UpdateOrderStatus = %v, %v", updated, err)
+	}
+	listed, err := orders.ListOrders(call, &orderv1.ListOrdersRequest{CustomerId: "customer-123"})
+	if err != nil || listed.TotalCount != 1 {
+		t.Fatalf("ListOrders = %v, %v", listed, err)
+	}
+	found, err := products.SearchProducts(call, &productv1.SearchProductsRequest{
+		Category: productv1.ProductCategory_CATEGORY_ELECTRONICS,
+	})
+	if err != nil || found.TotalCount != 1 {
+		t.Fatalf("SearchProducts = %v, %v", found, err)
+	}
+}
+
+// TestStackShutdownOrder checks that a request in flight when the stack stops
+// is answered before the server goes away
+func TestStackShutdownOrder(t *testing.T) {
+	s, err := startStack(natsembed.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	release := make(chan struct{})
+	slow, err := productv1.RegisterProductServiceHandlers(s.nc, slowProducts{productService{newCatalog()}, release},
+		productv1.WithSubjectPrefix("slow"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.services = append(s.services, slow)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- s.run(ctx, 5*time.Second) }()
+
+	client := productv1.NewProductServiceNatsClient(s.nc, productv1.WithNatsClientSubjectPrefix("slow"))
+	answered := make(chan error, 1)
+	go func() {
+		_, err := client.GetProduct(context.Background(), &productv1.GetProductRequest{Id: "p"})
+		answered <- err
+	}()
+	time.Sleep(100 * time.Millisecond) // Let the request reach the handler
+	cancel()
+	time.Sleep(100 * time.Millisecond)
+	close(release)
+
+	if err := <-answered; !productv1.IsNotFound(err) {
+		t.Fatalf("in-flight request err = %v, want the handler's NOT_FOUND", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if !s.nc.IsClosed() {
+		t.Error("connection still open after shutdown")
+	}
+}
+
+// slowProducts holds GetProduct until release is closed
+type slowProducts struct {
+	productService
+	release chan struct{}
+}
+
+func (s slowProducts) GetProduct(ctx context.Context, req *productv1.GetProductRequest) (*productv1.GetProductResponse, error) {
+	select {
+	case <-s.release:
+	case <-ctx.Done():
+		return nil, errors.New("canceled before release")
+	}
+	return s.productService.GetProduct(ctx, req)
+}
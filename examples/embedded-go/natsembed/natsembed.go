@@ -0,0 +1,130 @@
+// Package natsembed runs a NATS server inside the process, so generated services
+// and their clients can ship as one binary.
+package natsembed
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+)
+
+// Options configures the embedded server. The zero value starts a JetStream
+// server on a free port of 127.0.0.1, storing into a temporary directory.
+type Options struct {
+	ServerName string
+	Host       string // Default 127.0.0.1
+	Port       int    // 0 picks a free port
+	// StoreDir is where JetStream keeps its data. Empty uses a temporary
+	// directory, which shutdown removes; a directory given here is kept.
+	StoreDir    string
+	NoJetStream bool
+	// InProcessOnly does not listen on a port at all. Only the returned
+	// connection, and others made with nats.InProcessServer, can reach it.
+	InProcessOnly bool
+	ReadyTimeout  time.Duration // Default 10s
+	Logging       bool          // Log to stderr, as nats-server does
+	// ConnectOptions configure the returned connection. A ClosedHandler given
+	// here runs before shutdown stops the server.
+	ConnectOptions []nats.Option
+}
+
+// Start starts an embedded NATS server and connects to it. shutdown drains the
+// connection, waits for it to close, then stops the server and removes a
+// temporary store directory. Drain the services on the connection first, e.g.,
+// with the generated RunServices, so in-flight handlers can still answer.
+// shutdown is safe to call more than once.
+func Start(opts Options) (nc *nats.Conn, shutdown func(), err error) {
+	storeDir, removeStore := opts.StoreDir, false
+	if storeDir == "" && !opts.NoJetStream {
+		if storeDir, err = os.MkdirTemp("", "natsembed-"); err != nil {
+			return nil, nil, fmt.Errorf("natsembed: create store directory: %w", err)
+		}
+		removeStore = true
+	}
+	cleanup := func() {
+		if removeStore {
+			os.RemoveAll(storeDir)
+		}
+	}
+
+	host, port := opts.Host, opts.Port
+	if host == "" {
+		host = "127.0.0.1"
+	}
+	if port == 0 {
+		port = server.RANDOM_PORT // nats-server takes 0 as its default, 4222
+	}
+	ns, err := server.NewServer(&server.Options{
+		ServerName: opts.ServerName,
+		Host:       host,
+		Port:       port,
+		JetStream:  !opts.NoJetStream,
+		StoreDir:   storeDir,
+		DontListen: opts.InProcessOnly,
+		NoSigs:     true,
+		NoLog:      !opts.Logging,
+	})
+	if err != nil {
+		cleanup()
+		return nil, nil, fmt.Errorf("natsembed: create server: %w", err)
+	}
+	if opts.Logging {
+		ns.ConfigureLogger()
+	}
+	stopServer := func() {
+		ns.Shutdown()
+		ns.WaitForShutdown()
+		cleanup()
+	}
+	ns.Start()
+	ready := opts.ReadyTimeout
+	if ready <= 0 {
+		ready = 10 * time.Second
+	}
+	if !ns.ReadyForConnections(ready) {
+		stopServer()
+		return nil, nil, fmt.Errorf("natsembed: server not ready after %v", ready)
+	}
+
+	connOpts := nats.GetDefaultOptions()
+	for _, opt := range opts.ConnectOptions {
+		if err := opt(&connOpts); err != nil {
+			stopServer()
+			return nil, nil, fmt.Errorf("natsembed: connect option: %w", err)
+		}
+	}
+	closed := make(chan struct{})
+	onClosed := connOpts.ClosedCB
+	connOpts.ClosedCB = func(nc *nats.Conn) {
+		if onClosed != nil {
+			onClosed(nc)
+		}
+		close(closed)
+	}
+	connOpts.Url = ns.ClientURL()
+	if opts.InProcessOnly {
+		connOpts.InProcessServer = ns
+	}
+	if nc, err = connOpts.Connect(); err != nil {
+		stopServer()
+		return nil, nil, fmt.Errorf("natsembed: connect: %w", err)
+	}
+
+	var once sync.Once
+	shutdown = func() {
+		once.Do(func() {
+			// Drain flushes what was published before closing, bounded by the
+			// connection's DrainTimeout
+			if err := nc.Drain(); err != nil {
+				nc.Close()
+			}
+			<-closed
+			stopServer()
+		})
+	}
+	return nc, shutdown, nil
+}
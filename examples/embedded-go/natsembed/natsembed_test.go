@@ -0,0 +1,128 @@
+package natsembed
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+func TestStartPicksFreePorts(t *testing.T) {
+	nc1, shutdown1, err := Start(Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer shutdown1()
+	nc2, shutdown2, err := Start(Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer shutdown2()
+
+	u1, _ := url.Parse(nc1.ConnectedUrl())
+	u2, _ := url.Parse(nc2.ConnectedUrl())
+	if u1.Port() == "4222" || u1.Port() == u2.Port() {
+		t.Fatalf("ports %s and %s, want two distinct free ports", u1.Port(), u2.Port())
+	}
+	// An external client reaches the server on its port
+	external, err := nats.Connect(nc1.ConnectedUrl())
+	if err != nil {
+		t.Fatal(err)
+	}
+	external.Close()
+}
+
+func TestShutdownRemovesTemporaryStore(t *testing.T) {
+	before, _ := filepath.Glob(filepath.Join(os.TempDir(), "natsembed-*"))
+	nc, shutdown, err := Start(Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	js, err := nc.JetStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := js.CreateKeyValue(&nats.KeyValueConfig{Bucket: "probe"}); err != nil {
+		t.Fatalf("JetStream not enabled: %v", err)
+	}
+	after, _ := filepath.Glob(filepath.Join(os.TempDir(), "natsembed-*"))
+	dirs := slices.DeleteFunc(after, func(dir string) bool { return slices.Contains(before, dir) })
+	if len(dirs) == 0 {
+		t.Fatal("no temporary store directory")
+	}
+
+	shutdown()
+	shutdown() // Safe to repeat
+	if !nc.IsClosed() {
+		t.Error("connection still open")
+	}
+	for _, dir := range dirs {
+		if _, err := os.Stat(dir); err == nil {
+			t.Errorf("%s still exists", dir)
+		}
+	}
+}
+
+func TestStoreDirIsKept(t *testing.T) {
+	dir := t.TempDir()
+	nc, shutdown, err := Start(Options{StoreDir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	js, _ := nc.JetStream()
+	kv, err := js.CreateKeyValue(&nats.KeyValueConfig{Bucket: "kept", Storage: nats.FileStorage})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := kv.PutString("k", "v"); err != nil {
+		t.Fatal(err)
+	}
+	shutdown()
+
+	// A restart on the same directory sees the data
+	nc, shutdown, err = Start(Options{StoreDir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer shutdown()
+	js, _ = nc.JetStream()
+	kv, err = js.KeyValue("kept")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry, err := kv.Get("k"); err != nil || string(entry.Value()) != "v" {
+		t.Fatalf("Get = %v, %v", entry, err)
+	}
+}
+
+func TestInProcessOnly(t *testing.T) {
+	closed := make(chan struct{})
+	nc, shutdown, err := Start(Options{
+		InProcessOnly:  true,
+		NoJetStream:    true,
+		ConnectOptions: []nats.Option{nats.ClosedHandler(func(*nats.Conn) { close(closed) })},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub, err := nc.SubscribeSync("ping")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := nc.Publish("ping", []byte("x")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sub.NextMsg(5 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	shutdown()
+	select {
+	case <-closed:
+	default:
+		t.Error("the given ClosedHandler did not run")
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	typesv1 "example/gen/common/types/v1"
+	orderv1 "example/gen/order/v1"
+	productv1 "example/gen/product/v1"
+)
+
+// catalog backs both services with in-memory maps
+type catalog struct {
+	mu       sync.Mutex
+	nextID   int
+	products map[string]*productv1.Product
+	orders   map[string]*orderv1.Order
+}
+
+func newCatalog() *catalog {
+	return &catalog{
+		products: make(map[string]*productv1.Product),
+		orders:   make(map[string]*orderv1.Order),
+	}
+}
+
+func (c *catalog) newID(kind string) string {
+	c.nextID++
+	return fmt.Sprintf("%s-%d", kind, c.nextID)
+}
+
+// productService implements productv1.ProductServiceNats
+type productService struct{ *catalog }
+
+func (s productService) CreateProduct(ctx context.Context, req *productv1.CreateProductRequest) (*productv1.CreateProductResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	product := &productv1.Product{
+		Id:            s.newID("product"),
+		Name:          req.Name,
+		Description:   req.Description,
+		Sku:           req.Sku,
+		Category:      req.Category,
+		Price:         req.Price,
+		StockQuantity: req.StockQuantity,
+		Status:        typesv1.Status_STATUS_ACTIVE,
+	}
+	s.products[product.Id] = product
+	return &productv1.CreateProductResponse{Product: product}, nil
+}
+
+func (s productService) GetProduct(ctx context.Context, req *productv1.GetProductRequest) (*productv1.GetProductResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	product, ok := s.products[req.Id]
+	if !ok {
+		return nil, productv1.Statusf(productv1.CodeNotFound, "product not found: %s", req.Id)
+	}
+	return &productv1.GetProductResponse{Product: product}, nil
+}
+
+func (s productService) UpdateProduct(ctx context.Context, req *productv1.UpdateProductRequest) (*productv1.UpdateProductResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	product, ok := s.products[req.Id]
+	if !ok {
+		return nil, productv1.Statusf(productv1.CodeNotFound, "product not found: %s", req.Id)
+	}
+	product.Name = req.Name
+	product.Description = req.Description
+	product.Price = req.Price
+	product.StockQuantity = req.StockQuantity
+	return &productv1.UpdateProductResponse{Product: product}, nil
+}
+
+func (s productService) DeleteProduct(ctx context.Context, req *productv1.DeleteProductRequest) (*productv1.DeleteProductResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.products, req.Id)
+	return &productv1.DeleteProductResponse{Success: true}, nil
+}
+
+func (s productService) SearchProducts(ctx context.Context, req *productv1.SearchProductsRequest) (*productv1.SearchProductsResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var results []*productv1.Product
+	for _, p := range s.products {
+		if req.Category == productv1.ProductCategory_CATEGORY_UNSPECIFIED || p.Category == req.Category {
+			results = append(results, p)
+		}
+	}
+	return &productv1.SearchProductsResponse{Products: results, TotalCount: int32(len(results))}, nil
+}
+
+// orderService implements orderv1.OrderServiceNats
+type orderService struct{ *catalog }
+
+func (s orderService) CreateOrder(ctx context.Context, req *orderv1.CreateOrderRequest) (*orderv1.CreateOrderResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var subtotal int64
+	for _, item := range req.Items {
+		if _, ok := s.products[item.ProductId]; !ok {
+			return nil, orderv1.Statusf(orderv1.CodeFailedPrecondition, "unknown product %s", item.ProductId)
+		}
+		subtotal += item.TotalPrice.GetUnits()
+	}
+	tax := subtotal / 10 // 10% tax
+	order := &orderv1.Order{
+		Id:              s.newID("order"),
+		CustomerId:      req.CustomerId,
+		CustomerName:    req.CustomerName,
+		Items:           req.Items,
+		Subtotal:        &typesv1.Money{CurrencyCode: "USD", Units: subtotal},
+		Tax:             &typesv1.Money{CurrencyCode: "USD", Units: tax},
+		Total:           &typesv1.Money{CurrencyCode: "USD", Units: subtotal + tax},
+		ShippingAddress: req.ShippingAddress,
+		Status:          typesv1.Status_STATUS_PENDING,
+	}
+	s.orders[order.Id] = order
+	return &orderv1.CreateOrderResponse{Order: order}, nil
+}
+
+func (s orderService) GetOrder(ctx context.Context, req *orderv1.GetOrderRequest) (*orderv1.GetOrderResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	order, ok := s.orders[req.Id]
+	if !ok {
+		return nil, orderv1.Statusf(orderv1.CodeNotFound, "order not found: %s", req.Id)
+	}
+	return &orderv1.GetOrderResponse{Order: order}, nil
+}
+
+func (s orderService) ListOrders(ctx context.Context, req *orderv1.ListOrdersRequest) (*orderv1.ListOrdersResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var results []*orderv1.Order
+	for _, o := range s.orders {
+		if req.CustomerId == "" || o.CustomerId == req.CustomerId {
+			results = append(results, o)
+		}
+	}
+	return &orderv1.ListOrdersResponse{Orders: results, TotalCount: int32(len(results))}, nil
+}
+
+func (s orderService) UpdateOrderStatus(ctx context.Context, req *orderv1.UpdateOrderStatusRequest) (*orderv1.UpdateOrderStatusResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	order, ok := s.orders[req.Id]
+	if !ok {
+		return nil, orderv1.Statusf(orderv1.CodeNotFound, "order not found: %s", req.Id)
+	}
+	order.Status = req.Status
+	return &orderv1.UpdateOrderStatusResponse{Order: order}, nil
+}
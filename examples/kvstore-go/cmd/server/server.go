@@ -133,5 +133,10 @@ func main() {
 	<-sigCh
 
 	log.Println("\n⏹ Shutting down...")
-	svc.Stop()
+	// Drain stops taking new requests and lets in-flight ones finish (up to 10s)
+	drainCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := svc.Drain(drainCtx); err != nil {
+		log.Printf("Drain did not finish cleanly: %v", err)
+	}
 }
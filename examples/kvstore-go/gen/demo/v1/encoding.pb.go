@@ -23,7 +23,7 @@ const (
 	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
 )
 
-// Shared messages used by both services
+// Shared messages used by the demo services
 type EchoRequest struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	Message       string                 `protobuf:"bytes,1,opt,name=message,proto3" json:"message,omitempty"`
@@ -334,7 +334,13 @@ const file_demo_v1_encoding_proto_rawDesc = "" +
 	"\x04Echo\x12\x14.demo.v1.EchoRequest\x1a\x15.demo.v1.EchoResponse\"\x1a\x82\xd3\xe4\x93\x02\x14:\x01*\"\x0f/v1/binary/echo\x12[\n" +
 	"\aGetUser\x12\x17.demo.v1.GetUserRequest\x1a\x18.demo.v1.GetUserResponse\"\x1d\x82\xd3\xe4\x93\x02\x17\x12\x15/v1/binary/users/{id}\x1ai\x8a\xb5\x18e\n" +
 	"\vdemo.binary\x12\x0ebinary_service\x1a\x051.0.0\"+Demo service using binary protobuf encoding*\x12\n" +
-	"\bencoding\x12\x06binaryB\x15Z\x13example/gen/demo/v1b\x06proto3"
+	"\bencoding\x12\x06binary2\xe0\x01\n" +
+	"\fMixedService\x125\n" +
+	"\x04Echo\x12\x14.demo.v1.EchoRequest\x1a\x15.demo.v1.EchoResponse\"\x00\x12B\n" +
+	"\aWebhook\x12\x14.demo.v1.EchoRequest\x1a\x15.demo.v1.EchoResponse\"\n" +
+	"\x92\xb5\x18\x06B\x04json\x1aU\x8a\xb5\x18Q\n" +
+	"\n" +
+	"demo.mixed\x12\rmixed_service\x1a\x051.0.0\"-Demo service mixing binary and JSON endpointsB\x15Z\x13example/gen/demo/v1b\x06proto3"
 
 var (
 	file_demo_v1_encoding_proto_rawDescOnce sync.Once
@@ -364,12 +370,16 @@ var file_demo_v1_encoding_proto_depIdxs = []int32{
 	2, // 3: demo.v1.JSONService.GetUser:input_type -> demo.v1.GetUserRequest
 	0, // 4: demo.v1.BinaryService.Echo:input_type -> demo.v1.EchoRequest
 	2, // 5: demo.v1.BinaryService.GetUser:input_type -> demo.v1.GetUserRequest
-	1, // 6: demo.v1.JSONService.Echo:output_type -> demo.v1.EchoResponse
-	3, // 7: demo.v1.JSONService.GetUser:output_type -> demo.v1.GetUserResponse
-	1, // 8: demo.v1.BinaryService.Echo:output_type -> demo.v1.EchoResponse
-	3, // 9: demo.v1.BinaryService.GetUser:output_type -> demo.v1.GetUserResponse
-	6, // [6:10] is the sub-list for method output_type
-	2, // [2:6] is the sub-list for method input_type
+	0, // 6: demo.v1.MixedService.Echo:input_type -> demo.v1.EchoRequest
+	0, // 7: demo.v1.MixedService.Webhook:input_type -> demo.v1.EchoRequest
+	1, // 8: demo.v1.JSONService.Echo:output_type -> demo.v1.EchoResponse
+	3, // 9: demo.v1.JSONService.GetUser:output_type -> demo.v1.GetUserResponse
+	1, // 10: demo.v1.BinaryService.Echo:output_type -> demo.v1.EchoResponse
+	3, // 11: demo.v1.BinaryService.GetUser:output_type -> demo.v1.GetUserResponse
+	1, // 12: demo.v1.MixedService.Echo:output_type -> demo.v1.EchoResponse
+	1, // 13: demo.v1.MixedService.Webhook:output_type -> demo.v1.EchoResponse
+	8, // [8:14] is the sub-list for method output_type
+	2, // [2:8] is the sub-list for method input_type
 	2, // [2:2] is the sub-list for extension type_name
 	2, // [2:2] is the sub-list for extension extendee
 	0, // [0:2] is the sub-list for field type_name
@@ -388,7 +398,7 @@ func file_demo_v1_encoding_proto_init() {
 			NumEnums:      0,
 			NumMessages:   6,
 			NumExtensions: 0,
-			NumServices:   2,
+			NumServices:   3,
 		},
 		GoTypes:           file_demo_v1_encoding_proto_goTypes,
 		DependencyIndexes: file_demo_v1_encoding_proto_depIdxs,
@@ -1,4 +1,8 @@
 // Code generated by protoc-gen-nats-micro. DO NOT EDIT.
+// versions:
+// 	protoc-gen-nats-micro v0.3.0
+// 	protoc                (unknown)
+// source: demo/v1/encoding.proto
 
 package v1
 
@@ -6,7 +10,6 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"log"
 	"os"
 	"time"
 	"github.com/nats-io/nats.go"
@@ -21,6 +24,21 @@ type JSONServiceError struct {
 	Code    string // Error code (e.g., "INVALID_ARGUMENT", "NOT_FOUND", "INTERNAL")
 	Message string // Human-readable error message
 	Method  string // The method that failed
+	Details []byte // Optional error data sent with the error
+
+	status *Status // Returned by Unwrap (nil = built on each call)
+}
+
+// newJSONServiceError creates an error that keeps its *Status, so Unwrap
+// returns the same value every time
+func newJSONServiceError(code, method, message string, details []byte) *JSONServiceError {
+	return &JSONServiceError{
+		Code:    code,
+		Method:  method,
+		Message: message,
+		Details: details,
+		status:  &Status{Code: ParseCode(code), Message: message, Details: details},
+	}
 }
 
 func (e *JSONServiceError) Error() string {
@@ -39,7 +57,17 @@ func (e *JSONServiceError) NatsErrorMessage() string {
 
 // NatsErrorData returns optional error data (nil for basic errors)
 func (e *JSONServiceError) NatsErrorData() []byte {
-	return nil
+	return e.Details
+}
+
+// Unwrap exposes the error as a *Status, so errors.As(err, &st) works on
+// client errors. Custom error codes map to CodeUnknown. Errors built as struct
+// literals get a new Status on each call.
+func (e *JSONServiceError) Unwrap() error {
+	if e.status != nil {
+		return e.status
+	}
+	return &Status{Code: ParseCode(e.Code), Message: e.Message, Details: e.Details}
 }
 
 // Service-specific error code constants (use shared constants from service_shared_nats.pb.go)
@@ -106,37 +134,37 @@ func GetJSONServiceErrorCode(err error) string {
 
 // NewJSONServiceInvalidArgumentError creates a new invalid argument error
 func NewJSONServiceInvalidArgumentError(method, message string) error {
-	return &JSONServiceError{Code: JSONServiceErrCodeInvalidArgument, Method: method, Message: message}
+	return newJSONServiceError(JSONServiceErrCodeInvalidArgument, method, message, nil)
 }
 
 // NewJSONServiceNotFoundError creates a new not found error
 func NewJSONServiceNotFoundError(method, message string) error {
-	return &JSONServiceError{Code: JSONServiceErrCodeNotFound, Method: method, Message: message}
+	return newJSONServiceError(JSONServiceErrCodeNotFound, method, message, nil)
 }
 
 // NewJSONServiceAlreadyExistsError creates a new already exists error
 func NewJSONServiceAlreadyExistsError(method, message string) error {
-	return &JSONServiceError{Code: JSONServiceErrCodeAlreadyExists, Method: method, Message: message}
+	return newJSONServiceError(JSONServiceErrCodeAlreadyExists, method, message, nil)
 }
 
 // NewJSONServicePermissionDeniedError creates a new permission denied error
 func NewJSONServicePermissionDeniedError(method, message string) error {
-	return &JSONServiceError{Code: JSONServiceErrCodePermissionDenied, Method: method, Message: message}
+	return newJSONServiceError(JSONServiceErrCodePermissionDenied, method, message, nil)
 }
 
 // NewJSONServiceUnauthenticatedError creates a new unauthenticated error
 func NewJSONServiceUnauthenticatedError(method, message string) error {
-	return &JSONServiceError{Code: JSONServiceErrCodeUnauthenticated, Method: method, Message: message}
+	return newJSONServiceError(JSONServiceErrCodeUnauthenticated, method, message, nil)
 }
 
 // NewJSONServiceInternalError creates a new internal error
 func NewJSONServiceInternalError(method, message string) error {
-	return &JSONServiceError{Code: JSONServiceErrCodeInternal, Method: method, Message: message}
+	return newJSONServiceError(JSONServiceErrCodeInternal, method, message, nil)
 }
 
 // NewJSONServiceUnavailableError creates a new unavailable error
 func NewJSONServiceUnavailableError(method, message string) error {
-	return &JSONServiceError{Code: JSONServiceErrCodeUnavailable, Method: method, Message: message}
+	return newJSONServiceError(JSONServiceErrCodeUnavailable, method, message, nil)
 }
 
 // JSONServiceNats is the NATS service interface for JSONService
@@ -147,8 +175,10 @@ type JSONServiceNats interface {
 
 // JSONServiceEndpointInfo describes a service endpoint
 type JSONServiceEndpointInfo struct {
-	Name    string `json:"name"`    // Method name (e.g., "CreateProduct")
-	Subject string `json:"subject"` // NATS subject (e.g., "api.v1.create_product")
+	Name           string `json:"name"`                       // Method name (e.g., "CreateProduct")
+	Subject        string `json:"subject"`                    // NATS subject (e.g., "api.v1.create_product")
+	QueueGroup     string `json:"queue_group,omitempty"`      // Queue group the endpoint joined (server only)
+	MaxRequestSize int    `json:"max_request_size,omitempty"` // Request payload limit in bytes (server only, 0 = unlimited)
 }
 
 // JSONServiceService is the interface for the registered NATS micro service
@@ -156,40 +186,130 @@ type JSONServiceEndpointInfo struct {
 type JSONServiceService interface {
 	micro.Service
 	Endpoints() []JSONServiceEndpointInfo
+	// Drain stops accepting requests, waits until in-flight handlers finish or
+	// ctx ends, and stops the service
+	Drain(ctx context.Context) error
+	// InterceptorChain names the interceptors a method's requests pass through,
+	// outermost first
+	InterceptorChain(method string) []string
+	// Reconfigure changes the settings that can change while the service runs,
+	// currently WithSampling and WithSamplingSalt
+	Reconfigure(opts ...RegisterOption) error
 }
 
 // jSONServiceService is the concrete implementation of JSONServiceService
 type jSONServiceService struct {
 	micro.Service
-	subjectPrefix string
+	subjectPrefix  string
+	queueGroup     string
+	maxRequestSize int
+	inflight       *inflightTracker
+	interceptors   []string // Names of the service-wide interceptors, outermost first
+	sampler        *sampler // Shared with the handlers, for Reconfigure
 }
 
 // Endpoints returns information about all service endpoints
 // This is useful for debugging, monitoring, and service discovery
 func (s *jSONServiceService) Endpoints() []JSONServiceEndpointInfo {
 	return []JSONServiceEndpointInfo{
-		{Name: "Echo", Subject: s.subjectPrefix + ".echo"},
-		{Name: "GetUser", Subject: s.subjectPrefix + ".get_user"},
+		{Name: "Echo", Subject: s.subjectPrefix + ".echo", QueueGroup: s.queueGroup, MaxRequestSize: s.maxRequestSize},
+		{Name: "GetUser", Subject: s.subjectPrefix + ".get_user", QueueGroup: s.queueGroup, MaxRequestSize: s.maxRequestSize},
+	}
+}
+
+// init lists the methods of JSONService in MethodDescriptors
+func init() {
+	MethodDescriptors["demo.v1.JSONService.Echo"] = MethodDescriptor{
+		Service: "JSONService",
+		Method:  "Echo",
+		Subject: "demo.json.echo",
+	}
+	MethodDescriptors["demo.v1.JSONService.GetUser"] = MethodDescriptor{
+		Service: "JSONService",
+		Method:  "GetUser",
+		Subject: "demo.json.get_user",
+	}
+}
+
+// JSONServiceSubjects maps each method of JSONService to its subject under
+// the default prefix. Clients and services of every language are generated from
+// the same table.
+var JSONServiceSubjects = map[string]string{
+	"Echo":    "demo.json.echo",
+	"GetUser": "demo.json.get_user",
+}
+
+// Drain unsubscribes every endpoint, so new requests get no responders, then waits
+// for in-flight handlers, and requests already delivered to the endpoints, to
+// finish before returning. Server and bidi streams are
+// sent a GOAWAY, which clients see as an *ErrServerDraining, and streaming handlers
+// have their context canceled right away, or after WithStreamDrainGrace, so
+// long-running streams can end cleanly. If ctx ends first, the remaining handlers'
+// contexts are canceled and ctx.Err() is returned. The service is stopped either way.
+// Drain fails if nats.go's micro left an endpoint subscribed; see stopEndpoints.
+func (s *jSONServiceService) Drain(ctx context.Context) error {
+	return s.inflight.drain(ctx, func() error { return stopEndpoints(s.Service) })
+}
+
+// InterceptorChain returns the names of the interceptors that requests to
+// method, a Go method name, pass through, outermost first: the service's
+// interceptors in the order they were added, then the method's
+// (natsmicro.endpoint).middlewares. Interceptors are named with Named, or else
+// by their function name.
+func (s *jSONServiceService) InterceptorChain(method string) []string {
+	chain := append([]string(nil), s.interceptors...)
+	return chain
+}
+
+// Reconfigure replaces the service's WithSampling and WithSamplingSalt settings
+// with those in opts, so sampling can be turned up, down or off without
+// re-registering; leaving WithSampling out stops sampling. Any other option is
+// rejected, as it only takes effect at registration.
+func (s *jSONServiceService) Reconfigure(opts ...RegisterOption) error {
+	cfg := &registerConfig{}
+	for _, opt := range opts {
+		opt(cfg)
 	}
+	return reconfigureSampling(s.sampler, cfg)
 }
 
+// JSONServiceSchemaHash identifies the schema JSONService was generated from.
+// Services advertise it as schema_hash INFO metadata; see SchemaHash.
+const JSONServiceSchemaHash = "sha256:3753ccc4b7602961c61c214c221a99d99ceaedee13a79c980c2d3a5f05f011a2"
+
+// jSONServiceSchema is the FileDescriptorSet JSONService serves from its
+// $reflect endpoint. The schema endpoint metadata is read from it too, so the two
+// always agree.
+const jSONServiceSchema = "\n\xed\n\n\x16demo/v1/encoding.proto\x12\ademo.v1\x1a\x1cgoogle/api/annotations.proto\x1a\x17natsmicro/options.proto\"E\n\vEchoRequest\x12\x18\n\amessage\x18\x01 \x01(\tR\amessage\x12\x1c\n\ttimestamp\x18\x02 \x01(\x03R\ttimestamp\"b\n\fEchoResponse\x12\x18\n\amessage\x18\x01 \x01(\tR\amessage\x12\x1c\n\ttimestamp\x18\x02 \x01(\x03R\ttimestamp\x12\x1a\n\bencoding\x18\x03 \x01(\tR\bencoding\" \n\x0eGetUserRequest\x12\x0e\n\x02id\x18\x01 \x01(\tR\x02id\"4\n\x0fGetUserResponse\x12!\n\x04user\x18\x01 \x01(\v2\r.demo.v1.UserR\x04user\"\xcc\x01\n\x04User\x12\x0e\n\x02id\x18\x01 \x01(\tR\x02id\x12\x12\n\x04name\x18\x02 \x01(\tR\x04name\x12\x14\n\x05email\x18\x03 \x01(\tR\x05email\x12\x14\n\x05roles\x18\x04 \x03(\tR\x05roles\x127\n\bmetadata\x18\x05 \x03(\v2\x1b.demo.v1.User.MetadataEntryR\bmetadata\x1a;\n\rMetadataEntry\x12\x10\n\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n\x05value\x18\x02 \x01(\tR\x05value:\x028\x012\x93\x02\n\vJSONService\x12M\n\x04Echo\x12\x14.demo.v1.EchoRequest\x1a\x15.demo.v1.EchoResponse\"\x18\x82\xd3\xe4\x93\x02\x12:\x01*\"\r/v1/json/echo\x12Y\n\aGetUser\x12\x17.demo.v1.GetUserRequest\x1a\x18.demo.v1.GetUserResponse\"\x1b\x82\xd3\xe4\x93\x02\x15\x12\x13/v1/json/users/{id}\x1aZ\x8a\xb5\x18V\n\tdemo.json\x12\fjson_service\x1a\x051.0.0\" Demo service using JSON encoding*\x10\n\bencoding\x12\x04json@\x012\xa8\x02\n\rBinaryService\x12O\n\x04Echo\x12\x14.demo.v1.EchoRequest\x1a\x15.demo.v1.EchoResponse\"\x1a\x82\xd3\xe4\x93\x02\x14:\x01*\"\x0f/v1/binary/echo\x12[\n\aGetUser\x12\x17.demo.v1.GetUserRequest\x1a\x18.demo.v1.GetUserResponse\"\x1d\x82\xd3\xe4\x93\x02\x17\x12\x15/v1/binary/users/{id}\x1ai\x8a\xb5\x18e\n\vdemo.binary\x12\x0ebinary_service\x1a\x051.0.0\"+Demo service using binary protobuf encoding*\x12\n\bencoding\x12\x06binary2\xe0\x01\n\fMixedService\x125\n\x04Echo\x12\x14.demo.v1.EchoRequest\x1a\x15.demo.v1.EchoResponse\"\x00\x12B\n\aWebhook\x12\x14.demo.v1.EchoRequest\x1a\x15.demo.v1.EchoResponse\"\n\x92\xb5\x18\x06B\x04json\x1aU\x8a\xb5\x18Q\n\ndemo.mixed\x12\rmixed_service\x1a\x051.0.0\"-Demo service mixing binary and JSON endpointsB\x15Z\x13example/gen/demo/v1b\x06proto3"
+
+// jSONServiceSchemaDocument is the JSON JSONService serves from its
+// $schema endpoint, before registration fills in its name, version and subjects
+const jSONServiceSchemaDocument = "{\"service\":\"demo.v1.JSONService\",\"schema_hash\":\"sha256:3753ccc4b7602961c61c214c221a99d99ceaedee13a79c980c2d3a5f05f011a2\",\"methods\":[{\"name\":\"Echo\",\"endpoint\":\"echo\",\"streaming\":\"unary\",\"request_type\":\"demo.v1.EchoRequest\",\"response_type\":\"demo.v1.EchoResponse\"},{\"name\":\"GetUser\",\"endpoint\":\"get_user\",\"streaming\":\"unary\",\"request_type\":\"demo.v1.GetUserRequest\",\"response_type\":\"demo.v1.GetUserResponse\"}],\"descriptor\":\"H4sIAAAAAAAC/6SUz27TThDHf3bSppk0bbVtfrXcIhmfqiLsNoBA5YIiKiSkUpGqIMoBbeIhXRrvGq8TtUJcOPZIX4IXyFtw4wU4cOUd0K7t1AlcCJfY8++7M5/ZGH4C/B9gKPzhro+8KwLGe14Ui0SQivJ7w117sydEr48+jZhPORcJTZjgMk2z1zlNZMi6sfBFVAi4+1Db756KNr4foEyIBZUQpaQ9tAzH2Kq2c5NsQjVhIcqEhpFlOsZWqX3tcDuwmMrISHCJs+oQGxbyAa2SLhzbrgNLTzA5lhjn3S6ByYLsAJMF7l1YHmdkjdyE8kBirJNqzbqX4fJ0kg65Xw0oK3NajhAocxqi7rLa1u9kDeYwpKyfdZcayhuLPkqr7JSUVxvkPiyEmNCAJtSac0pbtebGxPneQRbd50l80R4n2w+hPhEiK1A6w4usNfWqThzS/iBvLjX2zAdG88qE2tOjw2dHGA9ZF8kBlNVqyNr47MLC7caUN8XmWp++/bgyyZ6x7dbVrXsnBfdRybyCSsaYrI9rJ/diW78HMt0Nrdsgq2NRtQLpf2DBR/vkcmS9gKquVTGyqH7fyHQQe27X2/F2XOcxhsLJnM5AMt5z1LxOflG2V64vESkriUdG84sJ9RbjNL7IuRzOwsXW/a8pLstqhI6WTMm8/icyN7TyOmkUZAts2OXIQqjp6jRKltLnNJ9bf+CTZjr6L98ZvL1GRQqo5tOs5ncDFg/YOQY5qXuzkPqPtKDyEjunQpz9XSV8HlnzLb04+/hyZD0H0Hmh6onU9WN66NsTQ4fsvDA15UF+QYJIMJ7IVuNkFc9pGPXR7yH3s29rZ14DuvNrAG2CaTlwBQAA\"}"
+
 // RegisterJSONServiceHandlers registers the service with NATS micro handlers
 // Service: json_service v1.0.0
 // Description: Demo service using JSON encoding
 // Subject prefix: demo.json
 // Service Metadata: encoding=json
-// Configuration options: WithName(), WithVersion(), WithDescription(), WithSubjectPrefix(), WithTimeout()
-// Metadata options: WithMetadata() (replace), WithAdditionalMetadata() (merge)
+// Configuration options: WithName(), WithVersion(), WithDescription(), WithSubjectPrefix(), WithTimeout(), WithQueueGroup(), WithMaxRequestSize()
+// Metadata options: WithMetadata() (replace), WithAdditionalMetadata() (merge), WithEndpointMetadata() (per method)
 // Handler options: WithStatsHandler(), WithDoneHandler(), WithErrorHandler()
+// Interceptors: WithServerInterceptor() appends, WithServerInterceptorChain() replaces; the first runs outermost
+// Stream interceptors: WithServerStreamInterceptor()
+// Grouping: WithServiceGroup() registers on a ServiceGroup shared with other services
+// Existing services: AddJSONServiceEndpoints() registers on a micro.Service added elsewhere
 //
 // Endpoint metadata can be configured via proto options (nats.micro.endpoint).metadata
-func RegisterJSONServiceHandlers(nc *nats.Conn, impl JSONServiceNats, opts ...RegisterOption) (JSONServiceService, error) {
+func RegisterJSONServiceHandlers(nc *nats.Conn, impl JSONServiceNats, opts ...RegisterOption) (_ JSONServiceService, err error) {
 	cfg := &registerConfig{
-		name:          "json_service",
-		version:       "1.0.0",
-		description:   "Demo service using JSON encoding",
-		subjectPrefix: "demo.json",
-		timeout:       0 * time.Second, // Service-level timeout (0 = no timeout)
+		name:           "json_service",
+		version:        "1.0.0",
+		description:    "Demo service using JSON encoding",
+		subjectPrefix:  "demo.json",
+		queueGroup:     "",
+		timeout:        0 * time.Second, // Service-level timeout (0 = no timeout)
+		tokenSanitizer: SanitizeToken,
+		streamWindow:   defaultStreamWindow,
 		metadata: map[string]string{
 			"encoding": "json",
 		},
@@ -197,19 +317,97 @@ func RegisterJSONServiceHandlers(nc *nats.Conn, impl JSONServiceNats, opts ...Re
 	for _, opt := range opts {
 		opt(cfg)
 	}
+	return registerJSONServiceEndpoints(nc, impl, cfg, func() (*serviceHost, bool, error) {
+		// Register on the ServiceGroup, or add a micro.Service for this service
+		if cfg.group != nil {
+			return cfg.group.host, true, nil
+		}
+		host, err := addServiceHost(nc, cfg, mergeMetadata(cfg.metadata, map[string]string{"schema_hash": JSONServiceSchemaHash}))
+		return host, false, err
+	})
+}
 
-	svc, err := micro.AddService(nc, micro.Config{
-		Name:         cfg.name,
-		Version:      cfg.version,
-		Metadata:     cfg.metadata,
-		Description:  cfg.description,
-		StatsHandler: cfg.statsHandler,
-		DoneHandler:  cfg.doneHandler,
-		ErrorHandler: cfg.errorHandler,
+// AddJSONServiceEndpoints registers the JSONService endpoints on svc, a
+// micro.Service the caller added on nc, so they share its name, version and stats
+// with the endpoints of other services and the caller's own:
+//
+//	svc, err := micro.AddService(nc, micro.Config{Name: "catalog", Version: "1.0.0"})
+//	...
+//	err = AddJSONServiceEndpoints(nc, svc, impl, WithServerInterceptor(auth))
+//
+// As on a ServiceGroup, endpoint names are qualified with the service name
+// ("json_service-health") while subjects keep the subject prefix, and the
+// schema_hash is advertised as endpoint metadata. Options of the micro.Service
+// itself, which NewServiceGroup lists, are rejected with an error, as is
+// WithServiceGroup. Stopping svc stops the endpoints; there is no Drain.
+func AddJSONServiceEndpoints(nc *nats.Conn, svc micro.Service, impl JSONServiceNats, opts ...RegisterOption) error {
+	cfg := &registerConfig{
+		subjectPrefix:  "demo.json",
+		queueGroup:     "",
+		timeout:        0 * time.Second, // Service-level timeout (0 = no timeout)
+		tokenSanitizer: SanitizeToken,
+		streamWindow:   defaultStreamWindow,
+		metadata:       map[string]string{},
+	}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	host, err := existingServiceHost(nc, svc, cfg)
+	if err != nil {
+		return err
+	}
+	// The subjects and $schema document describe the proto service
+	cfg.name, cfg.version = "json_service", "1.0.0"
+	_, err = registerJSONServiceEndpoints(nc, impl, cfg, func() (*serviceHost, bool, error) {
+		return host, true, nil
 	})
+	return err
+}
+
+// registerJSONServiceEndpoints registers the JSONService endpoints cfg describes
+// on the host attach returns, once the configuration checks out. Endpoint names
+// are qualified when attach reports the host is shared with other services.
+func registerJSONServiceEndpoints(nc *nats.Conn, impl JSONServiceNats, cfg *registerConfig, attach func() (*serviceHost, bool, error)) (_ JSONServiceService, err error) {
+
+	// Describe each endpoint from the schema blob
+	schemaMetadata, err := schemaEndpointMetadata(jSONServiceSchema, "demo.v1.JSONService")
+	if err != nil {
+		return nil, err
+	}
+	endpointMethods := map[string]bool{
+		"Echo":    true,
+		"GetUser": true,
+	}
+	for method := range cfg.endpointMetadata {
+		if !endpointMethods[method] {
+			return nil, fmt.Errorf("WithEndpointMetadata: JSONService has no method %s", method)
+		}
+	}
+
+	// Streams are compressed with gzip unless WithCompression names another codec
+	compressor := compressorFor(streamEncodingGzip)
+	if cfg.compressionCodec != "" {
+		if compressor = compressorFor(cfg.compressionCodec); compressor == nil {
+			return nil, fmt.Errorf("WithCompression: codec %q is not registered; add it with RegisterCompressor", cfg.compressionCodec)
+		}
+	}
+
+	host, shared, err := attach()
 	if err != nil {
-		return nil, fmt.Errorf("failed to add service: %w", err)
+		return nil, err
 	}
+	endpointPrefix := "" // Qualifies endpoint names on a shared micro.Service
+	if shared {
+		endpointPrefix = "json_service-"
+	} else {
+		// Stop the micro.Service again if an endpoint fails to register
+		defer func() {
+			if err != nil {
+				host.svc.Stop()
+			}
+		}()
+	}
+	svc, pool := host.svc, host.pool
 
 	// Chain server interceptors
 	var chainedInterceptor UnaryServerInterceptor
@@ -218,27 +416,62 @@ func RegisterJSONServiceHandlers(nc *nats.Conn, impl JSONServiceNats, opts ...Re
 	}
 
 	handlers := &jSONServiceHandlers{
-		impl:           impl,
-		serviceTimeout: cfg.timeout,
-		useJSON:        true,
-		interceptor:    chainedInterceptor,
-		js:             cfg.js,
-	}
-
-	// Map of endpoint names to their handlers
+		nc:                nc,
+		impl:              impl,
+		serviceTimeout:    cfg.timeout,
+		useJSON:           true,
+		interceptor:       chainedInterceptor,
+		js:                cfg.js,
+		cancels:           host.cancels,
+		tokenSanitizer:    cfg.tokenSanitizer,
+		idGenerator:       cfg.idGenerator,
+		maxRequestSize:    cfg.maxRequestSize,
+		streamWindow:      cfg.streamWindow,
+		streamAllowGaps:   cfg.streamAllowGaps,
+		streamCompression: cfg.streamCompression,
+		compression:       cfg.compression,
+		compressor:        compressor,
+		responseHeaders:   cfg.responseHeaders,
+		requestCheck:      cfg.requestCheck,
+		streamInterceptor: chainStreamServerInterceptors(cfg.streamInterceptors),
+		endpointPrefix:    endpointPrefix,
+		inflight:          host.inflight,
+		sampler:           newSampler(cfg.sampling, cfg.samplingSalt),
+		maxServerDeadline: cfg.maxServerDeadline,
+		persistenceErrors: cfg.persistenceErrors,
+		panics:            cfg.panicDiagnostics,
+		failures:          newFailureRecorder(cfg),
+	}
+
+	// Map of endpoint names to their handlers; unary ones go through the handler pool,
+	// idempotent ones are coalesced first, and those expecting a response are
+	// deduplicated by idempotency key inside the pool
+	idempotency := newIdempotencyGuard(cfg)
 	endpoints := map[string]micro.Handler{
 
-		"echo": micro.HandlerFunc(handlers.Echo),
+		"echo": pool.wrap(endpointPrefix+"echo", idempotency.wrap(endpointPrefix+"echo", micro.HandlerFunc(handlers.Echo))),
 
-		"get_user": micro.HandlerFunc(handlers.GetUser),
+		"get_user": pool.wrap(endpointPrefix+"get_user", idempotency.wrap(endpointPrefix+"get_user", micro.HandlerFunc(handlers.GetUser))),
 	}
 
-	// Map of endpoint names to their metadata
+	// Map of endpoint names to their metadata: the schema's description of the
+	// method, overlaid with (natsmicro.endpoint).metadata, then WithEndpointMetadata
 	endpointMetadata := map[string]map[string]string{
 
-		"echo": {},
+		"echo": mergeMetadata(mergeMetadata(schemaMetadata["Echo"], map[string]string{}), cfg.endpointMetadata["Echo"]),
+
+		"get_user": mergeMetadata(mergeMetadata(schemaMetadata["GetUser"], map[string]string{}), cfg.endpointMetadata["GetUser"]),
+	}
+
+	// Map of endpoint names to exact subjects from (natsmicro.endpoint).subject,
+	// or the wildcard form of subject_template
+	// These endpoints are registered outside the subject prefix group
+	endpointSubjects := map[string]string{}
 
-		"get_user": {},
+	// Endpoints WithInstanceSubjects also serves on <subject>.<instance ID>
+	instanceEndpoints := map[string]bool{
+		"echo":     true,
+		"get_user": true,
 	}
 
 	// Use interface to handle both Service and Group
@@ -246,35 +479,146 @@ func RegisterJSONServiceHandlers(nc *nats.Conn, impl JSONServiceNats, opts ...Re
 		AddEndpoint(string, micro.Handler, ...micro.EndpointOpt) error
 	}
 
-	var adder endpointAdder = svc
+	var group endpointAdder = svc
 	if cfg.subjectPrefix != "" {
-		adder = svc.AddGroup(cfg.subjectPrefix)
+		group = svc.AddGroup(cfg.subjectPrefix)
 	}
 
 	// Register all endpoints with their metadata
 	for name, handler := range endpoints {
 		opts := []micro.EndpointOpt{}
-		if metadata, exists := endpointMetadata[name]; exists && len(metadata) > 0 {
+		metadata := endpointMetadata[name]
+		if cfg.maxRequestSize > 0 {
+			// Advertise the request size limit alongside the proto metadata
+			withLimit := map[string]string{"max_request_size": fmt.Sprint(cfg.maxRequestSize)}
+			for k, v := range metadata {
+				withLimit[k] = v
+			}
+			metadata = withLimit
+		}
+		if shared {
+			// Keep the unqualified subject; the shared service's metadata cannot
+			// carry every service's schema hash
+			opts = append(opts, micro.WithEndpointSubject(name))
+			metadata = mergeMetadata(metadata, map[string]string{"schema_hash": JSONServiceSchemaHash})
+			if cfg.queueGroup != "" {
+				opts = append(opts, micro.WithEndpointQueueGroup(cfg.queueGroup))
+			}
+		}
+		if len(metadata) > 0 {
 			opts = append(opts, micro.WithEndpointMetadata(metadata))
 		}
-		if err := adder.AddEndpoint(name, handler, opts...); err != nil {
+		adder := group
+		subject := name
+		if exact, exists := endpointSubjects[name]; exists {
+			adder = svc
+			subject = exact
+			opts = append(opts, micro.WithEndpointSubject(subject))
+		}
+		if cfg.instanceSubjects && instanceEndpoints[name] {
+			// Responses name the instance, for ResponderInstance
+			handler = respondAsInstance(svc.Info().ID, handler)
+		}
+		if err := adder.AddEndpoint(endpointPrefix+name, holdRequests(cfg.hold, handler), opts...); err != nil {
 			return nil, fmt.Errorf("failed to add endpoint %s: %w", name, err)
 		}
+		if cfg.instanceSubjects && instanceEndpoints[name] {
+			// The same handler on this instance's own subject, for WithTargetInstance
+			opts = append(opts, micro.WithEndpointSubject(subject+"."+svc.Info().ID))
+			if err := adder.AddEndpoint(endpointPrefix+name+"-instance", holdRequests(cfg.hold, handler), opts...); err != nil {
+				return nil, fmt.Errorf("failed to add instance endpoint %s: %w", name, err)
+			}
+		}
+	}
+
+	pool.start()
+
+	// Application health endpoint, registered outside the subject prefix group
+	if !cfg.noHealthEndpoint {
+		subject := healthSubject(cfg.subjectPrefix, "json_service")
+		if err := svc.AddEndpoint(endpointPrefix+"health", holdRequests(cfg.hold, newHealthHandler(impl, cfg.timeout)), micro.WithEndpointSubject(subject)); err != nil {
+			return nil, fmt.Errorf("failed to add health endpoint: %w", err)
+		}
+	}
+
+	// Schema reflection endpoint, registered outside the subject prefix group
+	if !cfg.noReflectEndpoint {
+		subject := reflectSubject(cfg.subjectPrefix, "json_service")
+		if err := svc.AddEndpoint(endpointPrefix+"reflect", holdRequests(cfg.hold, newReflectHandler(jSONServiceSchema, JSONServiceSchemaHash)), micro.WithEndpointSubject(subject)); err != nil {
+			return nil, fmt.Errorf("failed to add reflect endpoint: %w", err)
+		}
+	}
+
+	// Schema document endpoint, registered outside the subject prefix group
+	if !cfg.noSchemaEndpoint {
+		handler, err := newSchemaHandler(jSONServiceSchemaDocument, cfg)
+		if err != nil {
+			return nil, err
+		}
+		subject := schemaSubject(cfg.subjectPrefix, "json_service")
+		if err := svc.AddEndpoint(endpointPrefix+"schema", holdRequests(cfg.hold, handler), micro.WithEndpointSubject(subject)); err != nil {
+			return nil, fmt.Errorf("failed to add schema endpoint: %w", err)
+		}
+	}
+
+	queueGroup := cfg.queueGroup
+	if queueGroup == "" {
+		queueGroup = micro.DefaultQueueGroup
 	}
 
 	return &jSONServiceService{
-		Service:       svc,
-		subjectPrefix: cfg.subjectPrefix,
+		Service:        svc,
+		subjectPrefix:  cfg.subjectPrefix,
+		queueGroup:     queueGroup,
+		maxRequestSize: cfg.maxRequestSize,
+		inflight:       handlers.inflight,
+		interceptors:   interceptorChainNames(cfg.serverInterceptors),
+		sampler:        handlers.sampler,
 	}, nil
 }
 
+// JSONServiceRegistration describes a RegisterJSONServiceHandlers call for
+// RegisterGroup, which registers it together with other services, all or nothing
+func JSONServiceRegistration(impl JSONServiceNats, opts ...RegisterOption) Registration {
+	return registrationFunc(func(nc *nats.Conn, hold func(context.Context) error) (GroupService, error) {
+		return RegisterJSONServiceHandlers(nc, impl, append(opts[:len(opts):len(opts)], withRequestHold(hold))...)
+	})
+}
+
 // jSONServiceHandlers wraps the service implementation with NATS handlers
 type jSONServiceHandlers struct {
-	impl           JSONServiceNats
-	serviceTimeout time.Duration          // Default timeout for all endpoints
-	useJSON        bool                   // Use JSON encoding instead of binary protobuf
-	interceptor    UnaryServerInterceptor // Chained interceptors
-	js             jetstream.JetStream    // Optional JetStream context for KV/ObjectStore
+	nc                 *nats.Conn // NATS connection for streaming
+	impl               JSONServiceNats
+	serviceTimeout     time.Duration                     // Default timeout for all endpoints
+	useJSON            bool                              // Use JSON encoding instead of binary protobuf
+	interceptor        UnaryServerInterceptor            // Chained interceptors
+	methodInterceptors map[string]UnaryServerInterceptor // Interceptors plus (natsmicro.endpoint).middlewares, by method
+	js                 jetstream.JetStream               // Optional JetStream context for KV/ObjectStore
+	cancels            *cancelRegistry                   // Client cancel notices (nil unless WithCancelPropagation)
+	tokenSanitizer     func(string) string               // Escapes request fields in KV/Object Store keys
+	idGenerator        func() string                     // Mints stream inbox and persistent call IDs (nil = NUIDs)
+	maxRequestSize     int                               // Largest accepted request payload in bytes (0 = unlimited)
+	inflight           *inflightTracker                  // Running handlers, for Drain
+	persistentStreams  map[string]*persistentStream      // (natsmicro.stream).persistence streams, by method
+	streamWindow       int                               // Server-stream flow control cap (0 = none)
+	streamCompression  int                               // Smallest stream message compressed, once negotiated (0 = off)
+	compression        int                               // Smallest unary response compressed, if the client accepts it (0 = off)
+	compressor         Compressor                        // Codec for responses and stream messages
+	streamAllowGaps    bool                              // Skip lost client-stream messages instead of failing Recv
+	responseHeaders    *headerPolicy                     // Strips response headers (nil = allow all)
+	requestCheck       *requestImmutabilityCheck         // Reports handlers that modify their request (nil = off)
+	streamInterceptor  StreamServerInterceptor           // Chained stream interceptors
+	endpointPrefix     string                            // Qualifies endpoint names within a ServiceGroup
+	sampler            *sampler                          // Samples unary calls (WithSampling)
+	maxServerDeadline  time.Duration                     // Cap on propagated client deadlines (0 = none)
+	persistenceErrors  func(method string, err error)    // Receives failed KV/Object Store writes (nil = print)
+	panics             *panicDiagnostics                 // Recovers and reports handler panics (nil = off)
+	failures           *failureRecorder                  // Records failed calls for replay (nil = off)
+}
+
+// keyToken renders a request field for a key template through the token sanitizer
+func (h *jSONServiceHandlers) keyToken(v any) string {
+	return h.tokenSanitizer(fmt.Sprint(v))
 }
 
 func (h *jSONServiceHandlers) Echo(req micro.Request) {
@@ -282,14 +626,26 @@ func (h *jSONServiceHandlers) Echo(req micro.Request) {
 	// If endpoint timeout is set (> 0), use it; otherwise use service timeout
 	timeout := h.serviceTimeout
 
-	// Create context with timeout if configured, otherwise use background context
-	ctx := context.Background()
+	// Create context with timeout if configured, derived from the service's drain context
+	ctx, end := h.inflight.begin(false) // Tracked for Drain
+	defer end()
 	var cancel context.CancelFunc
 	if timeout > 0 {
 		ctx, cancel = context.WithTimeout(ctx, timeout)
 		defer cancel()
 	}
 
+	// Stop when the client gives up: honor the deadline it propagated
+	ctx, cancelDeadline := withPropagatedDeadline(ctx, req.Headers(), h.maxServerDeadline)
+	defer cancelDeadline()
+
+	// Cancel the handler early if the client abandons the request
+	if h.cancels != nil {
+		var release func()
+		ctx, release = h.cancels.watch(ctx, req.Headers())
+		defer release()
+	}
+
 	// Add incoming NATS headers to context so service methods can access them
 	if req.Headers() != nil {
 		ctx = WithIncomingHeaders(ctx, req.Headers())
@@ -297,62 +653,69 @@ func (h *jSONServiceHandlers) Echo(req micro.Request) {
 
 	// Initialize outgoing headers pointer in context so interceptors can set response headers
 	outgoingHeadersPtr := &nats.Header{}
-	ctx = context.WithValue(ctx, outgoingHeadersKey, outgoingHeadersPtr)
+	ctx = withPendingResponseHeaders(ctx, outgoingHeadersPtr)
+
+	// Reject oversized requests before decoding them, decompressing compressed ones
+	body, payloadErr := readPayload("request", req.Headers().Get(ContentEncodingHeader), req.Data(), h.maxRequestSize)
+	if payloadErr != nil {
+		code, message, data := natsErrorFields(payloadErr)
+		req.Error(code, message, data)
+		return
+	}
 
+	// Decode with the codec the client named; clients without Content-Type use the configured one
+	requestJSON, ctErr := payloadUsesJSON(req.Headers().Get(ContentTypeHeader), h.useJSON)
+	if ctErr != nil {
+		code, message, data := natsErrorFields(ctErr)
+		req.Error(code, message, data)
+		return
+	}
 	var msg EchoRequest
-	if h.useJSON {
-		if err := protojson.Unmarshal(req.Data(), &msg); err != nil {
+	if requestJSON {
+		if err := protojson.Unmarshal(body, &msg); err != nil {
 			req.Error(JSONServiceErrCodeInvalidArgument, fmt.Sprintf("failed to decode JSON request: %v", err), nil)
 			return
 		}
 	} else {
-		if err := proto.Unmarshal(req.Data(), &msg); err != nil {
+		if err := proto.Unmarshal(body, &msg); err != nil {
 			req.Error(JSONServiceErrCodeInvalidArgument, fmt.Sprintf("failed to decode request: %v", err), nil)
 			return
 		}
 	}
 
+	// Sample the call for WithSampling, capturing the request before the handler runs
+	sample := h.sampler.begin("JSONService", "Echo", incomingRequestID(req), false, nats.Header(req.Headers()), &msg, false)
+
 	// Define the handler function
 	handler := func(ctx context.Context, request interface{}) (interface{}, error) {
 		typedReq, ok := request.(*EchoRequest)
 		if !ok {
 			return nil, fmt.Errorf("invalid request type")
 		}
+		defer h.requestCheck.check("JSONService.Echo", typedReq)()
 		return h.impl.Echo(ctx, typedReq)
 	}
+	handler = h.panics.wrap("JSONService", "Echo", "demo.v1.JSONService.Echo", false, handler)
+	handler = h.failures.wrap("JSONService", "Echo", "demo.v1.JSONService.Echo", false, handler)
 
 	// Execute through interceptor chain if configured
 	var resp interface{}
 	var err error
-	if h.interceptor != nil {
+	interceptor := h.interceptor
+	if interceptor != nil {
 		info := &UnaryServerInfo{
-			Service: "JSONService",
-			Method:  "Echo",
-			Subject: "demo.json.echo",
+			Service:    "JSONService",
+			Method:     "Echo",
+			FullMethod: "demo.v1.JSONService.Echo",
+			Subject:    "demo.json.echo",
 		}
-		resp, err = h.interceptor(ctx, &msg, info, handler)
+		resp, err = interceptor(ctx, &msg, info, handler)
 	} else {
 		resp, err = handler(ctx, &msg)
 	}
+	sample.end(resp, err)
 	if err != nil {
-		// Check if error implements custom status code/message/data methods
-		code := JSONServiceErrCodeInternal
-		message := err.Error()
-		var data []byte
-
-		// Check for NatsErrorCode() string method
-		if coder, ok := err.(interface{ NatsErrorCode() string }); ok {
-			code = coder.NatsErrorCode()
-		}
-		// Check for NatsErrorMessage() string method
-		if messager, ok := err.(interface{ NatsErrorMessage() string }); ok {
-			message = messager.NatsErrorMessage()
-		}
-		// Check for NatsErrorData() []byte method
-		if dataProvider, ok := err.(interface{ NatsErrorData() []byte }); ok {
-			data = dataProvider.NatsErrorData()
-		}
-
+		code, message, data := natsErrorFields(err)
 		req.Error(code, message, data)
 		return
 	}
@@ -366,7 +729,7 @@ func (h *jSONServiceHandlers) Echo(req micro.Request) {
 
 	var data []byte
 	if h.useJSON {
-		data, err = protojson.Marshal(typedResp)
+		data, err = marshalJSON(typedResp, false)
 		if err != nil {
 			req.Error(JSONServiceErrCodeInternal, fmt.Sprintf("failed to encode JSON response: %v", err), nil)
 			return
@@ -381,22 +744,17 @@ func (h *jSONServiceHandlers) Echo(req micro.Request) {
 
 	// Check if context has outgoing headers set by interceptors
 	// Read from the pointer that was initialized at the start
-	var outgoingHeaders nats.Header
-	if headersPtr, ok := ctx.Value(outgoingHeadersKey).(*nats.Header); ok && headersPtr != nil {
-		outgoingHeaders = *headersPtr
-	}
+	outgoingHeaders := pendingResponseHeaders(ctx)
 
-	if len(outgoingHeaders) > 0 {
-		// Send response with headers using micro.WithHeaders
-		// Convert nats.Header to micro.Headers (they are the same underlying type)
-		if err := req.Respond(data, micro.WithHeaders(micro.Headers(outgoingHeaders))); err != nil {
-			fmt.Fprintf(os.Stderr, "failed to send response for Echo: %v\n", err)
-		}
-	} else {
-		// Send response without headers
-		if err := req.Respond(data); err != nil {
-			fmt.Fprintf(os.Stderr, "failed to send response for Echo: %v\n", err)
-		}
+	// Send response with headers set by interceptors plus the response codec
+	// Convert nats.Header to micro.Headers (they are the same underlying type)
+	outgoingHeaders = h.responseHeaders.strip(h.endpointPrefix+"echo", outgoingHeaders)
+	outgoingHeaders = withContentType(outgoingHeaders, h.useJSON)
+	if h.compression > 0 && acceptsEncoding(req.Headers().Get(acceptEncodingHeader), h.compressor.Name()) {
+		data = compressPayload(h.compressor, h.compression, data, outgoingHeaders)
+	}
+	if err := req.Respond(data, micro.WithHeaders(micro.Headers(outgoingHeaders))); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to send response for Echo: %v\n", err)
 	}
 }
 
@@ -405,14 +763,26 @@ func (h *jSONServiceHandlers) GetUser(req micro.Request) {
 	// If endpoint timeout is set (> 0), use it; otherwise use service timeout
 	timeout := h.serviceTimeout
 
-	// Create context with timeout if configured, otherwise use background context
-	ctx := context.Background()
+	// Create context with timeout if configured, derived from the service's drain context
+	ctx, end := h.inflight.begin(false) // Tracked for Drain
+	defer end()
 	var cancel context.CancelFunc
 	if timeout > 0 {
 		ctx, cancel = context.WithTimeout(ctx, timeout)
 		defer cancel()
 	}
 
+	// Stop when the client gives up: honor the deadline it propagated
+	ctx, cancelDeadline := withPropagatedDeadline(ctx, req.Headers(), h.maxServerDeadline)
+	defer cancelDeadline()
+
+	// Cancel the handler early if the client abandons the request
+	if h.cancels != nil {
+		var release func()
+		ctx, release = h.cancels.watch(ctx, req.Headers())
+		defer release()
+	}
+
 	// Add incoming NATS headers to context so service methods can access them
 	if req.Headers() != nil {
 		ctx = WithIncomingHeaders(ctx, req.Headers())
@@ -420,62 +790,69 @@ func (h *jSONServiceHandlers) GetUser(req micro.Request) {
 
 	// Initialize outgoing headers pointer in context so interceptors can set response headers
 	outgoingHeadersPtr := &nats.Header{}
-	ctx = context.WithValue(ctx, outgoingHeadersKey, outgoingHeadersPtr)
+	ctx = withPendingResponseHeaders(ctx, outgoingHeadersPtr)
+
+	// Reject oversized requests before decoding them, decompressing compressed ones
+	body, payloadErr := readPayload("request", req.Headers().Get(ContentEncodingHeader), req.Data(), h.maxRequestSize)
+	if payloadErr != nil {
+		code, message, data := natsErrorFields(payloadErr)
+		req.Error(code, message, data)
+		return
+	}
 
+	// Decode with the codec the client named; clients without Content-Type use the configured one
+	requestJSON, ctErr := payloadUsesJSON(req.Headers().Get(ContentTypeHeader), h.useJSON)
+	if ctErr != nil {
+		code, message, data := natsErrorFields(ctErr)
+		req.Error(code, message, data)
+		return
+	}
 	var msg GetUserRequest
-	if h.useJSON {
-		if err := protojson.Unmarshal(req.Data(), &msg); err != nil {
+	if requestJSON {
+		if err := protojson.Unmarshal(body, &msg); err != nil {
 			req.Error(JSONServiceErrCodeInvalidArgument, fmt.Sprintf("failed to decode JSON request: %v", err), nil)
 			return
 		}
 	} else {
-		if err := proto.Unmarshal(req.Data(), &msg); err != nil {
+		if err := proto.Unmarshal(body, &msg); err != nil {
 			req.Error(JSONServiceErrCodeInvalidArgument, fmt.Sprintf("failed to decode request: %v", err), nil)
 			return
 		}
 	}
 
+	// Sample the call for WithSampling, capturing the request before the handler runs
+	sample := h.sampler.begin("JSONService", "GetUser", incomingRequestID(req), false, nats.Header(req.Headers()), &msg, false)
+
 	// Define the handler function
 	handler := func(ctx context.Context, request interface{}) (interface{}, error) {
 		typedReq, ok := request.(*GetUserRequest)
 		if !ok {
 			return nil, fmt.Errorf("invalid request type")
 		}
+		defer h.requestCheck.check("JSONService.GetUser", typedReq)()
 		return h.impl.GetUser(ctx, typedReq)
 	}
+	handler = h.panics.wrap("JSONService", "GetUser", "demo.v1.JSONService.GetUser", false, handler)
+	handler = h.failures.wrap("JSONService", "GetUser", "demo.v1.JSONService.GetUser", false, handler)
 
 	// Execute through interceptor chain if configured
 	var resp interface{}
 	var err error
-	if h.interceptor != nil {
+	interceptor := h.interceptor
+	if interceptor != nil {
 		info := &UnaryServerInfo{
-			Service: "JSONService",
-			Method:  "GetUser",
-			Subject: "demo.json.get_user",
+			Service:    "JSONService",
+			Method:     "GetUser",
+			FullMethod: "demo.v1.JSONService.GetUser",
+			Subject:    "demo.json.get_user",
 		}
-		resp, err = h.interceptor(ctx, &msg, info, handler)
+		resp, err = interceptor(ctx, &msg, info, handler)
 	} else {
 		resp, err = handler(ctx, &msg)
 	}
+	sample.end(resp, err)
 	if err != nil {
-		// Check if error implements custom status code/message/data methods
-		code := JSONServiceErrCodeInternal
-		message := err.Error()
-		var data []byte
-
-		// Check for NatsErrorCode() string method
-		if coder, ok := err.(interface{ NatsErrorCode() string }); ok {
-			code = coder.NatsErrorCode()
-		}
-		// Check for NatsErrorMessage() string method
-		if messager, ok := err.(interface{ NatsErrorMessage() string }); ok {
-			message = messager.NatsErrorMessage()
-		}
-		// Check for NatsErrorData() []byte method
-		if dataProvider, ok := err.(interface{ NatsErrorData() []byte }); ok {
-			data = dataProvider.NatsErrorData()
-		}
-
+		code, message, data := natsErrorFields(err)
 		req.Error(code, message, data)
 		return
 	}
@@ -489,7 +866,7 @@ func (h *jSONServiceHandlers) GetUser(req micro.Request) {
 
 	var data []byte
 	if h.useJSON {
-		data, err = protojson.Marshal(typedResp)
+		data, err = marshalJSON(typedResp, false)
 		if err != nil {
 			req.Error(JSONServiceErrCodeInternal, fmt.Sprintf("failed to encode JSON response: %v", err), nil)
 			return
@@ -504,47 +881,120 @@ func (h *jSONServiceHandlers) GetUser(req micro.Request) {
 
 	// Check if context has outgoing headers set by interceptors
 	// Read from the pointer that was initialized at the start
-	var outgoingHeaders nats.Header
-	if headersPtr, ok := ctx.Value(outgoingHeadersKey).(*nats.Header); ok && headersPtr != nil {
-		outgoingHeaders = *headersPtr
-	}
+	outgoingHeaders := pendingResponseHeaders(ctx)
 
-	if len(outgoingHeaders) > 0 {
-		// Send response with headers using micro.WithHeaders
-		// Convert nats.Header to micro.Headers (they are the same underlying type)
-		if err := req.Respond(data, micro.WithHeaders(micro.Headers(outgoingHeaders))); err != nil {
-			fmt.Fprintf(os.Stderr, "failed to send response for GetUser: %v\n", err)
-		}
-	} else {
-		// Send response without headers
-		if err := req.Respond(data); err != nil {
-			fmt.Fprintf(os.Stderr, "failed to send response for GetUser: %v\n", err)
-		}
+	// Send response with headers set by interceptors plus the response codec
+	// Convert nats.Header to micro.Headers (they are the same underlying type)
+	outgoingHeaders = h.responseHeaders.strip(h.endpointPrefix+"get_user", outgoingHeaders)
+	outgoingHeaders = withContentType(outgoingHeaders, h.useJSON)
+	if h.compression > 0 && acceptsEncoding(req.Headers().Get(acceptEncodingHeader), h.compressor.Name()) {
+		data = compressPayload(h.compressor, h.compression, data, outgoingHeaders)
+	}
+	if err := req.Respond(data, micro.WithHeaders(micro.Headers(outgoingHeaders))); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to send response for GetUser: %v\n", err)
 	}
 }
 
 // JSONServiceNatsClientInterface is the interface for the NATS client
 // This interface allows for easier dependency injection and testing
 type JSONServiceNatsClientInterface interface {
-	Echo(context.Context, *EchoRequest) (*EchoResponse, error)
-	GetUser(context.Context, *GetUserRequest) (*GetUserResponse, error)
+	Echo(context.Context, *EchoRequest, ...CallOption) (*EchoResponse, error)
+	GetUser(context.Context, *GetUserRequest, ...CallOption) (*GetUserResponse, error)
+	Health(ctx context.Context) (*HealthResponse, error)
+	Schema(ctx context.Context) (*ServiceSchemaDocument, error)
 	Endpoints() []JSONServiceEndpointInfo
+	WithHeaders(headers nats.Header) JSONServiceNatsClientInterface
+	Close()
 }
 
 // JSONServiceNatsClient is the concrete implementation of JSONServiceNatsClientInterface
 type JSONServiceNatsClient struct {
-	nc            *nats.Conn
-	subjectPrefix string
-	useJSON       bool                   // Use JSON encoding instead of binary protobuf
-	interceptor   UnaryClientInterceptor // Chained interceptors
-	js            jetstream.JetStream    // Optional JetStream for KV/ObjectStore reads
+	nc                  *nats.Conn
+	subjectPrefix       string
+	useJSON             bool                    // Use JSON encoding instead of binary protobuf
+	interceptor         UnaryClientInterceptor  // Chained interceptors
+	streamInterceptor   StreamClientInterceptor // Chained stream interceptors
+	js                  jetstream.JetStream     // Optional JetStream for KV/ObjectStore reads
+	kvStaleAfter        time.Duration           // Age at which Get*Cached treats KV entries as misses (0 = never)
+	cancelPropagation   bool                    // Publish a cancel notice when ctx ends mid-request
+	timeout             time.Duration           // Default unary timeout when ctx has no deadline
+	retry               *retryPolicy            // Unary retry policy (nil = no retries)
+	tokenSanitizer      func(string) string     // Escapes request fields in key helpers
+	idGenerator         func() string           // Mints cancel subject and stream inbox IDs (nil = NUIDs)
+	maxResponseSize     int                     // Largest accepted response payload in bytes (0 = unlimited)
+	connSelector        func() *nats.Conn       // Picks the connection per call (nil = nc)
+	journal             *journal                // Records calls for replay (nil = no journal)
+	streamWindow        int                     // Server-stream flow control window (0 = none)
+	streamAllowGaps     bool                    // Skip lost stream messages instead of failing Recv
+	streamCompression   int                     // Smallest stream message compressed, once negotiated (0 = off)
+	compression         int                     // Smallest unary request compressed (0 = off)
+	compressionCodec    string                  // Codec unary requests are compressed with
+	streamResumeOnDrain bool                    // Reopen server streams when their server drains
+	headerPolicy        *headerPolicy           // Strips outgoing headers (nil = allow all)
+	sampler             *sampler                // Samples unary calls (WithClientSampling)
+	monitor             *connectionMonitor      // Samples the connections (WithClientConnectionMonitor)
+	headers             nats.Header             // Sent under each call's OutgoingHeaders (WithHeaders)
+}
+
+// compress returns a unary request payload compressed as WithClientCompression
+// asks, flagged in headers
+func (c *JSONServiceNatsClient) compress(data []byte, headers nats.Header) ([]byte, error) {
+	if c.compression == 0 {
+		return data, nil
+	}
+	codec := compressorFor(c.compressionCodec)
+	if codec == nil {
+		return nil, fmt.Errorf("WithClientCompression: codec %q is not registered; add it with RegisterCompressor", c.compressionCodec)
+	}
+	return compressPayload(codec, c.compression, data, headers), nil
+}
+
+// conn returns the connection for the next call or stream
+func (c *JSONServiceNatsClient) conn() *nats.Conn {
+	if c.connSelector != nil {
+		return c.connSelector()
+	}
+	return c.nc
+}
+
+// outgoingHeaders returns the headers of ctx that WithOutgoingHeaderPolicy lets a call send
+func (c *JSONServiceNatsClient) outgoingHeaders(ctx context.Context) nats.Header {
+	headers, _ := c.headerPolicy.filter(layerHeaders(c.headers, OutgoingHeaders(ctx)))
+	return headers
+}
+
+// WithHeaders returns a view of the client that sends headers with every call and
+// stream, beneath those set on the call's context with WithOutgoingHeaders. A view
+// shares the client's connections, interceptors, retry policy, journal and sampler
+// rather than copying them, so it is cheap to make per request, e.g. per tenant, and
+// like the client is safe for concurrent use. Views of views add to their parent's
+// headers. Closing a view does not stop the client's connection monitor.
+func (c *JSONServiceNatsClient) WithHeaders(headers nats.Header) JSONServiceNatsClientInterface {
+	layered := make(nats.Header, len(c.headers)+len(headers))
+	for name, values := range c.headers {
+		layered[name] = values
+	}
+	for name, values := range headers {
+		layered[name] = append([]string(nil), values...) // Later changes to headers stay out of the view
+	}
+	view := *c
+	view.headers = layered
+	view.monitor = nil
+	return &view
+}
+
+// keyToken renders a request field for a key template through the token sanitizer
+func (c *JSONServiceNatsClient) keyToken(v any) string {
+	return c.tokenSanitizer(fmt.Sprint(v))
 }
 
 // NewJSONServiceNatsClient creates a new NATS client for JSONService.
 // The client sends requests over NATS using protobuf serialization (or JSON if configured).
 func NewJSONServiceNatsClient(nc *nats.Conn, opts ...NatsClientOption) JSONServiceNatsClientInterface {
 	cfg := &natsClientConfig{
-		subjectPrefix: "demo.json",
+		subjectPrefix:  "demo.json",
+		tokenSanitizer: SanitizeToken,
+		streamWindow:   defaultStreamWindow,
 	}
 	for _, opt := range opts {
 		opt.applyNatsClientOption(cfg)
@@ -557,30 +1007,78 @@ func NewJSONServiceNatsClient(nc *nats.Conn, opts ...NatsClientOption) JSONServi
 	}
 
 	c := &JSONServiceNatsClient{
-		nc:            nc,
-		subjectPrefix: cfg.subjectPrefix,
-		useJSON:       true,
-		interceptor:   chainedInterceptor,
-		js:            cfg.js,
+		nc:                  nc,
+		subjectPrefix:       cfg.subjectPrefix,
+		useJSON:             true,
+		interceptor:         chainedInterceptor,
+		streamInterceptor:   chainStreamClientInterceptors(cfg.streamInterceptors),
+		js:                  cfg.js,
+		kvStaleAfter:        cfg.kvStaleAfter,
+		cancelPropagation:   cfg.cancelPropagation,
+		timeout:             cfg.timeout,
+		retry:               newRetryPolicy(cfg),
+		tokenSanitizer:      cfg.tokenSanitizer,
+		idGenerator:         cfg.idGenerator,
+		maxResponseSize:     cfg.maxResponseSize,
+		connSelector:        cfg.connSelector,
+		journal:             cfg.journal,
+		streamWindow:        cfg.streamWindow,
+		streamAllowGaps:     cfg.streamAllowGaps,
+		streamCompression:   cfg.streamCompression,
+		compression:         cfg.compression,
+		compressionCodec:    cfg.compressionCodec,
+		streamResumeOnDrain: cfg.streamResumeOnDrain,
+		headerPolicy:        cfg.headerPolicy,
+		sampler:             newSampler(cfg.sampling, cfg.samplingSalt),
+		monitor:             startConnectionMonitor(cfg.connMonitor, monitoredConns(nc, cfg.poolConns)...),
 	}
 	return c
 }
 
+// NewJSONServiceNatsClientPool creates a JSONService client that spreads calls
+// over conns round-robin (see RoundRobinConns). Streams stay on the connection they
+// opened on. A WithConnSelector in opts replaces the round-robin selector.
+func NewJSONServiceNatsClientPool(conns []*nats.Conn, opts ...NatsClientOption) JSONServiceNatsClientInterface {
+	selector := RoundRobinConns(conns)
+	opts = append([]NatsClientOption{natsClientOptionFunc(func(c *natsClientConfig) { c.poolConns = conns })}, opts...)
+	return NewJSONServiceNatsClient(conns[0], append([]NatsClientOption{WithConnSelector(selector)}, opts...)...)
+}
+
 // Echo sends a Echo request to the service via NATS.
-// Returns an error if the request fails or the service returns an error.
-func (c *JSONServiceNatsClient) Echo(ctx context.Context, req *EchoRequest) (*EchoResponse, error) {
+// Returns an error if the request fails or the service returns an error,
+// or a *TimeoutError if a WithCallTimeout/WithClientTimeout expires.
+func (c *JSONServiceNatsClient) Echo(ctx context.Context, req *EchoRequest, opts ...CallOption) (*EchoResponse, error) {
 	method := "Echo"
 
-	// Pointer to store response headers - stored in context so invoker can update it
-	responseHeadersPtr := &nats.Header{}
+	// Sample the call for WithClientSampling under a request ID the service sees too
+	var requestID string
+	if c.sampler.enabled() {
+		ctx, requestID = withRequestID(ctx, c.idGenerator)
+	}
+	sample := c.sampler.begin("JSONService", method, requestID, true, c.outgoingHeaders(ctx), req, false)
+
+	// Bound the call when the caller gave no deadline (or asked for a per-call timeout)
+	start := time.Now()
+	parentCtx := ctx
+	ctx, cancel, timeout := withCallTimeout(ctx, c.timeout, opts)
+	defer cancel()
 
-	// Add the response headers pointer to context so invoker can populate it
+	// Record sizes, attempts and duration for CallInfoFromContext
+	ctx, info := startCallInfo(ctx, "JSONService", c.subjectPrefix+".echo")
+	defer info.finish()
+	ctx = withIDGenerator(ctx, c.idGenerator) // For NewID in interceptors
+
+	// Give the invoker somewhere in the context to store the response headers
 	// Interceptors can then read the headers from the same context
-	ctx = context.WithValue(ctx, responseHeadersKey, responseHeadersPtr)
+	ctx = WithResponseHeaders(ctx, nats.Header{})
 
 	// Define the invoker function that performs the actual NATS call
 	invoker := func(invokerCtx context.Context, method string, request, reply interface{}) error {
-		subject := c.subjectPrefix + ".echo"
+		// WithTargetInstance sends the call to one instance's own subject
+		subject, subjectErr := targetSubject(c.subjectPrefix+".echo", opts)
+		if subjectErr != nil {
+			return newJSONServiceError(JSONServiceErrCodeInvalidArgument, method, subjectErr.Error(), nil)
+		}
 
 		// Marshal request
 		typedReq, ok := request.(*EchoRequest)
@@ -591,7 +1089,7 @@ func (c *JSONServiceNatsClient) Echo(ctx context.Context, req *EchoRequest) (*Ec
 		var data []byte
 		var err error
 		if c.useJSON {
-			data, err = protojson.Marshal(typedReq)
+			data, err = marshalJSON(typedReq, false)
 		} else {
 			data, err = proto.Marshal(typedReq)
 		}
@@ -599,83 +1097,124 @@ func (c *JSONServiceNatsClient) Echo(ctx context.Context, req *EchoRequest) (*Ec
 			return err
 		}
 
-		// Extract outgoing headers from context and attach to NATS message
-		var msg *nats.Msg
-		if headers := OutgoingHeaders(invokerCtx); headers != nil {
-			msg, err = c.nc.RequestMsgWithContext(invokerCtx, &nats.Msg{
-				Subject: subject,
-				Data:    data,
-				Header:  headers,
-			})
-		} else {
-			msg, err = c.nc.RequestWithContext(invokerCtx, subject, data)
+		// Extract outgoing headers from context and attach them, naming the codec, to the NATS message
+		nc := c.conn()
+		headers := withContentType(c.outgoingHeaders(invokerCtx), c.useJSON)
+		setDeadlineHeaders(invokerCtx, headers) // For deadline-aware scheduling and handler contexts
+		if data, err = c.compress(data, headers); err != nil {
+			return err
+		}
+		headers.Set(acceptEncodingHeader, compressorNames()) // Services compress responses only for clients that decompress them
+		if c.cancelPropagation {
+			var stop func() bool
+			headers, stop = propagateCancel(invokerCtx, nc, headers, mintID(c.idGenerator))
+			defer stop()
+		}
+
+		info.attempt(len(data))
+		msg, err := nc.RequestMsgWithContext(invokerCtx, &nats.Msg{
+			Subject: subject,
+			Data:    data,
+			Header:  headers,
+		})
+		if err != nil {
+			return err
 		}
+		info.received(len(msg.Data))
+		info.responder(msg.Header)
+		body, err := readPayload("response", msg.Header.Get(ContentEncodingHeader), msg.Data, c.maxResponseSize)
 		if err != nil {
 			return err
 		}
 
-		// Store response headers in the pointer from context
+		// Store response headers where ResponseHeaders reads them
 		if msg.Header != nil && len(msg.Header) > 0 {
-			if headersPtr, ok := invokerCtx.Value(responseHeadersKey).(*nats.Header); ok && headersPtr != nil {
-				*headersPtr = msg.Header
-			}
-		} // Check if this is an error response from the service
-		if msg.Header.Get("Status") != "" {
-			code := msg.Header.Get("Status")
-			description := msg.Header.Get("Description")
-			return &JSONServiceError{
-				Code:    code,
-				Method:  method,
-				Message: description,
+			storeResponseHeaders(invokerCtx, msg.Header)
+		}
+
+		// Check if this is an error response from the service (NATS micro headers)
+		if msg.Header.Get("Nats-Service-Error-Code") != "" {
+			code := msg.Header.Get("Nats-Service-Error-Code")
+			description := msg.Header.Get("Nats-Service-Error")
+			var details []byte
+			if len(msg.Data) > 0 {
+				details = msg.Data
 			}
+			return newJSONServiceError(code, method, description, details)
 		}
 
-		// Unmarshal response
+		// Unmarshal response with the codec the service named, if any
 		typedReply, ok := reply.(*EchoResponse)
 		if !ok {
 			return fmt.Errorf("invalid reply type")
 		}
-
-		if c.useJSON {
-			err = protojson.Unmarshal(msg.Data, typedReply)
+		replyJSON, err := payloadUsesJSON(msg.Header.Get(ContentTypeHeader), c.useJSON)
+		if err != nil {
+			return err
+		}
+		if replyJSON {
+			err = protojson.Unmarshal(body, typedReply)
 		} else {
-			err = proto.Unmarshal(msg.Data, typedReply)
+			err = proto.Unmarshal(body, typedReply)
 		}
 		return err
 	}
 
 	var resp EchoResponse
 
-	// Execute through interceptor chain if configured
-	var err error
-	if c.interceptor != nil {
-		err = c.interceptor(ctx, method, req, &resp, invoker)
-	} else {
-		err = invoker(ctx, method, req, &resp)
+	// Execute through interceptor chain if configured, once per retry attempt
+	err := c.retry.do(ctx, func(ctx context.Context) error {
+		if c.interceptor != nil {
+			return c.interceptor(ctx, method, req, &resp, invoker)
+		}
+		return invoker(ctx, method, req, &resp)
+	})
+	sample.end(&resp, err)
+	if c.journal != nil {
+		c.journal.record("JSONService", method, c.subjectPrefix+".echo", c.outgoingHeaders(parentCtx), req, c.useJSON, false, start, err)
 	}
-
 	if err != nil {
-		return nil, err
+		return nil, callTimeoutError(parentCtx, ctx, method, timeout, err)
 	}
 
 	return &resp, nil
 }
 
 // GetUser sends a GetUser request to the service via NATS.
-// Returns an error if the request fails or the service returns an error.
-func (c *JSONServiceNatsClient) GetUser(ctx context.Context, req *GetUserRequest) (*GetUserResponse, error) {
+// Returns an error if the request fails or the service returns an error,
+// or a *TimeoutError if a WithCallTimeout/WithClientTimeout expires.
+func (c *JSONServiceNatsClient) GetUser(ctx context.Context, req *GetUserRequest, opts ...CallOption) (*GetUserResponse, error) {
 	method := "GetUser"
 
-	// Pointer to store response headers - stored in context so invoker can update it
-	responseHeadersPtr := &nats.Header{}
+	// Sample the call for WithClientSampling under a request ID the service sees too
+	var requestID string
+	if c.sampler.enabled() {
+		ctx, requestID = withRequestID(ctx, c.idGenerator)
+	}
+	sample := c.sampler.begin("JSONService", method, requestID, true, c.outgoingHeaders(ctx), req, false)
+
+	// Bound the call when the caller gave no deadline (or asked for a per-call timeout)
+	start := time.Now()
+	parentCtx := ctx
+	ctx, cancel, timeout := withCallTimeout(ctx, c.timeout, opts)
+	defer cancel()
 
-	// Add the response headers pointer to context so invoker can populate it
+	// Record sizes, attempts and duration for CallInfoFromContext
+	ctx, info := startCallInfo(ctx, "JSONService", c.subjectPrefix+".get_user")
+	defer info.finish()
+	ctx = withIDGenerator(ctx, c.idGenerator) // For NewID in interceptors
+
+	// Give the invoker somewhere in the context to store the response headers
 	// Interceptors can then read the headers from the same context
-	ctx = context.WithValue(ctx, responseHeadersKey, responseHeadersPtr)
+	ctx = WithResponseHeaders(ctx, nats.Header{})
 
 	// Define the invoker function that performs the actual NATS call
 	invoker := func(invokerCtx context.Context, method string, request, reply interface{}) error {
-		subject := c.subjectPrefix + ".get_user"
+		// WithTargetInstance sends the call to one instance's own subject
+		subject, subjectErr := targetSubject(c.subjectPrefix+".get_user", opts)
+		if subjectErr != nil {
+			return newJSONServiceError(JSONServiceErrCodeInvalidArgument, method, subjectErr.Error(), nil)
+		}
 
 		// Marshal request
 		typedReq, ok := request.(*GetUserRequest)
@@ -686,7 +1225,7 @@ func (c *JSONServiceNatsClient) GetUser(ctx context.Context, req *GetUserRequest
 		var data []byte
 		var err error
 		if c.useJSON {
-			data, err = protojson.Marshal(typedReq)
+			data, err = marshalJSON(typedReq, false)
 		} else {
 			data, err = proto.Marshal(typedReq)
 		}
@@ -694,68 +1233,115 @@ func (c *JSONServiceNatsClient) GetUser(ctx context.Context, req *GetUserRequest
 			return err
 		}
 
-		// Extract outgoing headers from context and attach to NATS message
-		var msg *nats.Msg
-		if headers := OutgoingHeaders(invokerCtx); headers != nil {
-			msg, err = c.nc.RequestMsgWithContext(invokerCtx, &nats.Msg{
-				Subject: subject,
-				Data:    data,
-				Header:  headers,
-			})
-		} else {
-			msg, err = c.nc.RequestWithContext(invokerCtx, subject, data)
+		// Extract outgoing headers from context and attach them, naming the codec, to the NATS message
+		nc := c.conn()
+		headers := withContentType(c.outgoingHeaders(invokerCtx), c.useJSON)
+		setDeadlineHeaders(invokerCtx, headers) // For deadline-aware scheduling and handler contexts
+		if data, err = c.compress(data, headers); err != nil {
+			return err
+		}
+		headers.Set(acceptEncodingHeader, compressorNames()) // Services compress responses only for clients that decompress them
+		if c.cancelPropagation {
+			var stop func() bool
+			headers, stop = propagateCancel(invokerCtx, nc, headers, mintID(c.idGenerator))
+			defer stop()
+		}
+
+		info.attempt(len(data))
+		msg, err := nc.RequestMsgWithContext(invokerCtx, &nats.Msg{
+			Subject: subject,
+			Data:    data,
+			Header:  headers,
+		})
+		if err != nil {
+			return err
 		}
+		info.received(len(msg.Data))
+		info.responder(msg.Header)
+		body, err := readPayload("response", msg.Header.Get(ContentEncodingHeader), msg.Data, c.maxResponseSize)
 		if err != nil {
 			return err
 		}
 
-		// Store response headers in the pointer from context
+		// Store response headers where ResponseHeaders reads them
 		if msg.Header != nil && len(msg.Header) > 0 {
-			if headersPtr, ok := invokerCtx.Value(responseHeadersKey).(*nats.Header); ok && headersPtr != nil {
-				*headersPtr = msg.Header
-			}
-		} // Check if this is an error response from the service
-		if msg.Header.Get("Status") != "" {
-			code := msg.Header.Get("Status")
-			description := msg.Header.Get("Description")
-			return &JSONServiceError{
-				Code:    code,
-				Method:  method,
-				Message: description,
+			storeResponseHeaders(invokerCtx, msg.Header)
+		}
+
+		// Check if this is an error response from the service (NATS micro headers)
+		if msg.Header.Get("Nats-Service-Error-Code") != "" {
+			code := msg.Header.Get("Nats-Service-Error-Code")
+			description := msg.Header.Get("Nats-Service-Error")
+			var details []byte
+			if len(msg.Data) > 0 {
+				details = msg.Data
 			}
+			return newJSONServiceError(code, method, description, details)
 		}
 
-		// Unmarshal response
+		// Unmarshal response with the codec the service named, if any
 		typedReply, ok := reply.(*GetUserResponse)
 		if !ok {
 			return fmt.Errorf("invalid reply type")
 		}
-
-		if c.useJSON {
-			err = protojson.Unmarshal(msg.Data, typedReply)
+		replyJSON, err := payloadUsesJSON(msg.Header.Get(ContentTypeHeader), c.useJSON)
+		if err != nil {
+			return err
+		}
+		if replyJSON {
+			err = protojson.Unmarshal(body, typedReply)
 		} else {
-			err = proto.Unmarshal(msg.Data, typedReply)
+			err = proto.Unmarshal(body, typedReply)
 		}
 		return err
 	}
 
 	var resp GetUserResponse
 
-	// Execute through interceptor chain if configured
-	var err error
-	if c.interceptor != nil {
-		err = c.interceptor(ctx, method, req, &resp, invoker)
-	} else {
-		err = invoker(ctx, method, req, &resp)
+	// Execute through interceptor chain if configured, once per retry attempt
+	err := c.retry.do(ctx, func(ctx context.Context) error {
+		if c.interceptor != nil {
+			return c.interceptor(ctx, method, req, &resp, invoker)
+		}
+		return invoker(ctx, method, req, &resp)
+	})
+	sample.end(&resp, err)
+	if c.journal != nil {
+		c.journal.record("JSONService", method, c.subjectPrefix+".get_user", c.outgoingHeaders(parentCtx), req, c.useJSON, false, start, err)
 	}
-
 	if err != nil {
-		return nil, err
+		return nil, callTimeoutError(parentCtx, ctx, method, timeout, err)
 	}
 
 	return &resp, nil
 }
 
+// Health calls the service's health endpoint. A service that reports HealthNotServing
+// returns a HealthResponse, not an error.
+func (c *JSONServiceNatsClient) Health(ctx context.Context) (*HealthResponse, error) {
+	parentCtx := ctx
+	ctx, cancel, timeout := withCallTimeout(ctx, c.timeout, nil)
+	defer cancel()
+	resp, err := requestHealth(ctx, c.conn(), healthSubject(c.subjectPrefix, "json_service"))
+	if err != nil {
+		return nil, callTimeoutError(parentCtx, ctx, "Health", timeout, err)
+	}
+	return resp, nil
+}
+
+// Schema fetches the service's $schema document; see FetchServiceSchema
+func (c *JSONServiceNatsClient) Schema(ctx context.Context) (*ServiceSchemaDocument, error) {
+	ctx, cancel, _ := withCallTimeout(ctx, c.timeout, nil)
+	defer cancel()
+	return FetchServiceSchema(ctx, c.conn(), schemaSubject(c.subjectPrefix, "json_service"))
+}
+
+// Close stops the client's connection monitor. The NATS connection stays open,
+// and the client can still be used.
+func (c *JSONServiceNatsClient) Close() {
+	c.monitor.close()
+}
+
 // Endpoints returns information about all service endpoints this client can call.
 // This is useful for debugging, monitoring, and introspection.
 func (c *JSONServiceNatsClient) Endpoints() []JSONServiceEndpointInfo {
@@ -765,11 +1351,39 @@ func (c *JSONServiceNatsClient) Endpoints() []JSONServiceEndpointInfo {
 	}
 }
 
+// JSONServiceSelfTest checks that the NATS connection works and that a running
+// json_service instance has registered every endpoint the client calls. Client
+// options such as WithNatsClientSubjectPrefix select the expected subjects.
+// Exit non-zero when the report is not OK to use it as a liveness or deployment hook.
+func JSONServiceSelfTest(ctx context.Context, nc *nats.Conn, opts ...NatsClientOption) *SelfTestReport {
+	c := NewJSONServiceNatsClient(nc, opts...)
+	var endpoints []SelfTestEndpoint
+	for _, ep := range c.Endpoints() {
+		endpoints = append(endpoints, SelfTestEndpoint{Name: ep.Name, Subject: ep.Subject})
+	}
+	return runSelfTest(ctx, nc, "json_service", endpoints)
+}
+
 // BinaryServiceError represents a structured error from BinaryService
 type BinaryServiceError struct {
 	Code    string // Error code (e.g., "INVALID_ARGUMENT", "NOT_FOUND", "INTERNAL")
 	Message string // Human-readable error message
 	Method  string // The method that failed
+	Details []byte // Optional error data sent with the error
+
+	status *Status // Returned by Unwrap (nil = built on each call)
+}
+
+// newBinaryServiceError creates an error that keeps its *Status, so Unwrap
+// returns the same value every time
+func newBinaryServiceError(code, method, message string, details []byte) *BinaryServiceError {
+	return &BinaryServiceError{
+		Code:    code,
+		Method:  method,
+		Message: message,
+		Details: details,
+		status:  &Status{Code: ParseCode(code), Message: message, Details: details},
+	}
 }
 
 func (e *BinaryServiceError) Error() string {
@@ -788,7 +1402,17 @@ func (e *BinaryServiceError) NatsErrorMessage() string {
 
 // NatsErrorData returns optional error data (nil for basic errors)
 func (e *BinaryServiceError) NatsErrorData() []byte {
-	return nil
+	return e.Details
+}
+
+// Unwrap exposes the error as a *Status, so errors.As(err, &st) works on
+// client errors. Custom error codes map to CodeUnknown. Errors built as struct
+// literals get a new Status on each call.
+func (e *BinaryServiceError) Unwrap() error {
+	if e.status != nil {
+		return e.status
+	}
+	return &Status{Code: ParseCode(e.Code), Message: e.Message, Details: e.Details}
 }
 
 // Service-specific error code constants (use shared constants from service_shared_nats.pb.go)
@@ -855,37 +1479,37 @@ func GetBinaryServiceErrorCode(err error) string {
 
 // NewBinaryServiceInvalidArgumentError creates a new invalid argument error
 func NewBinaryServiceInvalidArgumentError(method, message string) error {
-	return &BinaryServiceError{Code: BinaryServiceErrCodeInvalidArgument, Method: method, Message: message}
+	return newBinaryServiceError(BinaryServiceErrCodeInvalidArgument, method, message, nil)
 }
 
 // NewBinaryServiceNotFoundError creates a new not found error
 func NewBinaryServiceNotFoundError(method, message string) error {
-	return &BinaryServiceError{Code: BinaryServiceErrCodeNotFound, Method: method, Message: message}
+	return newBinaryServiceError(BinaryServiceErrCodeNotFound, method, message, nil)
 }
 
 // NewBinaryServiceAlreadyExistsError creates a new already exists error
 func NewBinaryServiceAlreadyExistsError(method, message string) error {
-	return &BinaryServiceError{Code: BinaryServiceErrCodeAlreadyExists, Method: method, Message: message}
+	return newBinaryServiceError(BinaryServiceErrCodeAlreadyExists, method, message, nil)
 }
 
 // NewBinaryServicePermissionDeniedError creates a new permission denied error
 func NewBinaryServicePermissionDeniedError(method, message string) error {
-	return &BinaryServiceError{Code: BinaryServiceErrCodePermissionDenied, Method: method, Message: message}
+	return newBinaryServiceError(BinaryServiceErrCodePermissionDenied, method, message, nil)
 }
 
 // NewBinaryServiceUnauthenticatedError creates a new unauthenticated error
 func NewBinaryServiceUnauthenticatedError(method, message string) error {
-	return &BinaryServiceError{Code: BinaryServiceErrCodeUnauthenticated, Method: method, Message: message}
+	return newBinaryServiceError(BinaryServiceErrCodeUnauthenticated, method, message, nil)
 }
 
 // NewBinaryServiceInternalError creates a new internal error
 func NewBinaryServiceInternalError(method, message string) error {
-	return &BinaryServiceError{Code: BinaryServiceErrCodeInternal, Method: method, Message: message}
+	return newBinaryServiceError(BinaryServiceErrCodeInternal, method, message, nil)
 }
 
 // NewBinaryServiceUnavailableError creates a new unavailable error
 func NewBinaryServiceUnavailableError(method, message string) error {
-	return &BinaryServiceError{Code: BinaryServiceErrCodeUnavailable, Method: method, Message: message}
+	return newBinaryServiceError(BinaryServiceErrCodeUnavailable, method, message, nil)
 }
 
 // BinaryServiceNats is the NATS service interface for BinaryService
@@ -896,8 +1520,10 @@ type BinaryServiceNats interface {
 
 // BinaryServiceEndpointInfo describes a service endpoint
 type BinaryServiceEndpointInfo struct {
-	Name    string `json:"name"`    // Method name (e.g., "CreateProduct")
-	Subject string `json:"subject"` // NATS subject (e.g., "api.v1.create_product")
+	Name           string `json:"name"`                       // Method name (e.g., "CreateProduct")
+	Subject        string `json:"subject"`                    // NATS subject (e.g., "api.v1.create_product")
+	QueueGroup     string `json:"queue_group,omitempty"`      // Queue group the endpoint joined (server only)
+	MaxRequestSize int    `json:"max_request_size,omitempty"` // Request payload limit in bytes (server only, 0 = unlimited)
 }
 
 // BinaryServiceService is the interface for the registered NATS micro service
@@ -905,40 +1531,130 @@ type BinaryServiceEndpointInfo struct {
 type BinaryServiceService interface {
 	micro.Service
 	Endpoints() []BinaryServiceEndpointInfo
+	// Drain stops accepting requests, waits until in-flight handlers finish or
+	// ctx ends, and stops the service
+	Drain(ctx context.Context) error
+	// InterceptorChain names the interceptors a method's requests pass through,
+	// outermost first
+	InterceptorChain(method string) []string
+	// Reconfigure changes the settings that can change while the service runs,
+	// currently WithSampling and WithSamplingSalt
+	Reconfigure(opts ...RegisterOption) error
 }
 
 // binaryServiceService is the concrete implementation of BinaryServiceService
 type binaryServiceService struct {
 	micro.Service
-	subjectPrefix string
+	subjectPrefix  string
+	queueGroup     string
+	maxRequestSize int
+	inflight       *inflightTracker
+	interceptors   []string // Names of the service-wide interceptors, outermost first
+	sampler        *sampler // Shared with the handlers, for Reconfigure
 }
 
 // Endpoints returns information about all service endpoints
 // This is useful for debugging, monitoring, and service discovery
 func (s *binaryServiceService) Endpoints() []BinaryServiceEndpointInfo {
 	return []BinaryServiceEndpointInfo{
-		{Name: "Echo", Subject: s.subjectPrefix + ".echo"},
-		{Name: "GetUser", Subject: s.subjectPrefix + ".get_user"},
+		{Name: "Echo", Subject: s.subjectPrefix + ".echo", QueueGroup: s.queueGroup, MaxRequestSize: s.maxRequestSize},
+		{Name: "GetUser", Subject: s.subjectPrefix + ".get_user", QueueGroup: s.queueGroup, MaxRequestSize: s.maxRequestSize},
+	}
+}
+
+// init lists the methods of BinaryService in MethodDescriptors
+func init() {
+	MethodDescriptors["demo.v1.BinaryService.Echo"] = MethodDescriptor{
+		Service: "BinaryService",
+		Method:  "Echo",
+		Subject: "demo.binary.echo",
+	}
+	MethodDescriptors["demo.v1.BinaryService.GetUser"] = MethodDescriptor{
+		Service: "BinaryService",
+		Method:  "GetUser",
+		Subject: "demo.binary.get_user",
 	}
 }
 
+// BinaryServiceSubjects maps each method of BinaryService to its subject under
+// the default prefix. Clients and services of every language are generated from
+// the same table.
+var BinaryServiceSubjects = map[string]string{
+	"Echo":    "demo.binary.echo",
+	"GetUser": "demo.binary.get_user",
+}
+
+// Drain unsubscribes every endpoint, so new requests get no responders, then waits
+// for in-flight handlers, and requests already delivered to the endpoints, to
+// finish before returning. Server and bidi streams are
+// sent a GOAWAY, which clients see as an *ErrServerDraining, and streaming handlers
+// have their context canceled right away, or after WithStreamDrainGrace, so
+// long-running streams can end cleanly. If ctx ends first, the remaining handlers'
+// contexts are canceled and ctx.Err() is returned. The service is stopped either way.
+// Drain fails if nats.go's micro left an endpoint subscribed; see stopEndpoints.
+func (s *binaryServiceService) Drain(ctx context.Context) error {
+	return s.inflight.drain(ctx, func() error { return stopEndpoints(s.Service) })
+}
+
+// InterceptorChain returns the names of the interceptors that requests to
+// method, a Go method name, pass through, outermost first: the service's
+// interceptors in the order they were added, then the method's
+// (natsmicro.endpoint).middlewares. Interceptors are named with Named, or else
+// by their function name.
+func (s *binaryServiceService) InterceptorChain(method string) []string {
+	chain := append([]string(nil), s.interceptors...)
+	return chain
+}
+
+// Reconfigure replaces the service's WithSampling and WithSamplingSalt settings
+// with those in opts, so sampling can be turned up, down or off without
+// re-registering; leaving WithSampling out stops sampling. Any other option is
+// rejected, as it only takes effect at registration.
+func (s *binaryServiceService) Reconfigure(opts ...RegisterOption) error {
+	cfg := &registerConfig{}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	return reconfigureSampling(s.sampler, cfg)
+}
+
+// BinaryServiceSchemaHash identifies the schema BinaryService was generated from.
+// Services advertise it as schema_hash INFO metadata; see SchemaHash.
+const BinaryServiceSchemaHash = "sha256:3753ccc4b7602961c61c214c221a99d99ceaedee13a79c980c2d3a5f05f011a2"
+
+// binaryServiceSchema is the FileDescriptorSet BinaryService serves from its
+// $reflect endpoint. The schema endpoint metadata is read from it too, so the two
+// always agree.
+const binaryServiceSchema = "\n\xed\n\n\x16demo/v1/encoding.proto\x12\ademo.v1\x1a\x1cgoogle/api/annotations.proto\x1a\x17natsmicro/options.proto\"E\n\vEchoRequest\x12\x18\n\amessage\x18\x01 \x01(\tR\amessage\x12\x1c\n\ttimestamp\x18\x02 \x01(\x03R\ttimestamp\"b\n\fEchoResponse\x12\x18\n\amessage\x18\x01 \x01(\tR\amessage\x12\x1c\n\ttimestamp\x18\x02 \x01(\x03R\ttimestamp\x12\x1a\n\bencoding\x18\x03 \x01(\tR\bencoding\" \n\x0eGetUserRequest\x12\x0e\n\x02id\x18\x01 \x01(\tR\x02id\"4\n\x0fGetUserResponse\x12!\n\x04user\x18\x01 \x01(\v2\r.demo.v1.UserR\x04user\"\xcc\x01\n\x04User\x12\x0e\n\x02id\x18\x01 \x01(\tR\x02id\x12\x12\n\x04name\x18\x02 \x01(\tR\x04name\x12\x14\n\x05email\x18\x03 \x01(\tR\x05email\x12\x14\n\x05roles\x18\x04 \x03(\tR\x05roles\x127\n\bmetadata\x18\x05 \x03(\v2\x1b.demo.v1.User.MetadataEntryR\bmetadata\x1a;\n\rMetadataEntry\x12\x10\n\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n\x05value\x18\x02 \x01(\tR\x05value:\x028\x012\x93\x02\n\vJSONService\x12M\n\x04Echo\x12\x14.demo.v1.EchoRequest\x1a\x15.demo.v1.EchoResponse\"\x18\x82\xd3\xe4\x93\x02\x12:\x01*\"\r/v1/json/echo\x12Y\n\aGetUser\x12\x17.demo.v1.GetUserRequest\x1a\x18.demo.v1.GetUserResponse\"\x1b\x82\xd3\xe4\x93\x02\x15\x12\x13/v1/json/users/{id}\x1aZ\x8a\xb5\x18V\n\tdemo.json\x12\fjson_service\x1a\x051.0.0\" Demo service using JSON encoding*\x10\n\bencoding\x12\x04json@\x012\xa8\x02\n\rBinaryService\x12O\n\x04Echo\x12\x14.demo.v1.EchoRequest\x1a\x15.demo.v1.EchoResponse\"\x1a\x82\xd3\xe4\x93\x02\x14:\x01*\"\x0f/v1/binary/echo\x12[\n\aGetUser\x12\x17.demo.v1.GetUserRequest\x1a\x18.demo.v1.GetUserResponse\"\x1d\x82\xd3\xe4\x93\x02\x17\x12\x15/v1/binary/users/{id}\x1ai\x8a\xb5\x18e\n\vdemo.binary\x12\x0ebinary_service\x1a\x051.0.0\"+Demo service using binary protobuf encoding*\x12\n\bencoding\x12\x06binary2\xe0\x01\n\fMixedService\x125\n\x04Echo\x12\x14.demo.v1.EchoRequest\x1a\x15.demo.v1.EchoResponse\"\x00\x12B\n\aWebhook\x12\x14.demo.v1.EchoRequest\x1a\x15.demo.v1.EchoResponse\"\n\x92\xb5\x18\x06B\x04json\x1aU\x8a\xb5\x18Q\n\ndemo.mixed\x12\rmixed_service\x1a\x051.0.0\"-Demo service mixing binary and JSON endpointsB\x15Z\x13example/gen/demo/v1b\x06proto3"
+
+// binaryServiceSchemaDocument is the JSON BinaryService serves from its
+// $schema endpoint, before registration fills in its name, version and subjects
+const binaryServiceSchemaDocument = "{\"service\":\"demo.v1.BinaryService\",\"schema_hash\":\"sha256:3753ccc4b7602961c61c214c221a99d99ceaedee13a79c980c2d3a5f05f011a2\",\"methods\":[{\"name\":\"Echo\",\"endpoint\":\"echo\",\"streaming\":\"unary\",\"request_type\":\"demo.v1.EchoRequest\",\"response_type\":\"demo.v1.EchoResponse\"},{\"name\":\"GetUser\",\"endpoint\":\"get_user\",\"streaming\":\"unary\",\"request_type\":\"demo.v1.GetUserRequest\",\"response_type\":\"demo.v1.GetUserResponse\"}],\"descriptor\":\"H4sIAAAAAAAC/6SUz27TThDHf3bSppk0bbVtfrXcIhmfqiLsNoBA5YIiKiSkUpGqIMoBbeIhXRrvGq8TtUJcOPZIX4IXyFtw4wU4cOUd0K7t1AlcCJfY8++7M5/ZGH4C/B9gKPzhro+8KwLGe14Ui0SQivJ7w117sydEr48+jZhPORcJTZjgMk2z1zlNZMi6sfBFVAi4+1Db756KNr4foEyIBZUQpaQ9tAzH2Kq2c5NsQjVhIcqEhpFlOsZWqX3tcDuwmMrISHCJs+oQGxbyAa2SLhzbrgNLTzA5lhjn3S6ByYLsAJMF7l1YHmdkjdyE8kBirJNqzbqX4fJ0kg65Xw0oK3NajhAocxqi7rLa1u9kDeYwpKyfdZcayhuLPkqr7JSUVxvkPiyEmNCAJtSac0pbtebGxPneQRbd50l80R4n2w+hPhEiK1A6w4usNfWqThzS/iBvLjX2zAdG88qE2tOjw2dHGA9ZF8kBlNVqyNr47MLC7caUN8XmWp++/bgyyZ6x7dbVrXsnBfdRybyCSsaYrI9rJ/diW78HMt0Nrdsgq2NRtQLpf2DBR/vkcmS9gKquVTGyqH7fyHQQe27X2/F2XOcxhsLJnM5AMt5z1LxOflG2V64vESkriUdG84sJ9RbjNL7IuRzOwsXW/a8pLstqhI6WTMm8/icyN7TyOmkUZAts2OXIQqjp6jRKltLnNJ9bf+CTZjr6L98ZvL1GRQqo5tOs5ncDFg/YOQY5qXuzkPqPtKDyEjunQpz9XSV8HlnzLb04+/hyZD0H0Hmh6onU9WN66NsTQ4fsvDA15UF+QYJIMJ7IVuNkFc9pGPXR7yH3s29rZ14DuvNrAG2CaTlwBQAA\"}"
+
 // RegisterBinaryServiceHandlers registers the service with NATS micro handlers
 // Service: binary_service v1.0.0
 // Description: Demo service using binary protobuf encoding
 // Subject prefix: demo.binary
 // Service Metadata: encoding=binary
-// Configuration options: WithName(), WithVersion(), WithDescription(), WithSubjectPrefix(), WithTimeout()
-// Metadata options: WithMetadata() (replace), WithAdditionalMetadata() (merge)
+// Configuration options: WithName(), WithVersion(), WithDescription(), WithSubjectPrefix(), WithTimeout(), WithQueueGroup(), WithMaxRequestSize()
+// Metadata options: WithMetadata() (replace), WithAdditionalMetadata() (merge), WithEndpointMetadata() (per method)
 // Handler options: WithStatsHandler(), WithDoneHandler(), WithErrorHandler()
+// Interceptors: WithServerInterceptor() appends, WithServerInterceptorChain() replaces; the first runs outermost
+// Stream interceptors: WithServerStreamInterceptor()
+// Grouping: WithServiceGroup() registers on a ServiceGroup shared with other services
+// Existing services: AddBinaryServiceEndpoints() registers on a micro.Service added elsewhere
 //
 // Endpoint metadata can be configured via proto options (nats.micro.endpoint).metadata
-func RegisterBinaryServiceHandlers(nc *nats.Conn, impl BinaryServiceNats, opts ...RegisterOption) (BinaryServiceService, error) {
+func RegisterBinaryServiceHandlers(nc *nats.Conn, impl BinaryServiceNats, opts ...RegisterOption) (_ BinaryServiceService, err error) {
 	cfg := &registerConfig{
-		name:          "binary_service",
-		version:       "1.0.0",
-		description:   "Demo service using binary protobuf encoding",
-		subjectPrefix: "demo.binary",
-		timeout:       0 * time.Second, // Service-level timeout (0 = no timeout)
+		name:           "binary_service",
+		version:        "1.0.0",
+		description:    "Demo service using binary protobuf encoding",
+		subjectPrefix:  "demo.binary",
+		queueGroup:     "",
+		timeout:        0 * time.Second, // Service-level timeout (0 = no timeout)
+		tokenSanitizer: SanitizeToken,
+		streamWindow:   defaultStreamWindow,
 		metadata: map[string]string{
 			"encoding": "binary",
 		},
@@ -946,19 +1662,97 @@ func RegisterBinaryServiceHandlers(nc *nats.Conn, impl BinaryServiceNats, opts .
 	for _, opt := range opts {
 		opt(cfg)
 	}
+	return registerBinaryServiceEndpoints(nc, impl, cfg, func() (*serviceHost, bool, error) {
+		// Register on the ServiceGroup, or add a micro.Service for this service
+		if cfg.group != nil {
+			return cfg.group.host, true, nil
+		}
+		host, err := addServiceHost(nc, cfg, mergeMetadata(cfg.metadata, map[string]string{"schema_hash": BinaryServiceSchemaHash}))
+		return host, false, err
+	})
+}
 
-	svc, err := micro.AddService(nc, micro.Config{
-		Name:         cfg.name,
-		Version:      cfg.version,
-		Metadata:     cfg.metadata,
-		Description:  cfg.description,
-		StatsHandler: cfg.statsHandler,
-		DoneHandler:  cfg.doneHandler,
-		ErrorHandler: cfg.errorHandler,
+// AddBinaryServiceEndpoints registers the BinaryService endpoints on svc, a
+// micro.Service the caller added on nc, so they share its name, version and stats
+// with the endpoints of other services and the caller's own:
+//
+//	svc, err := micro.AddService(nc, micro.Config{Name: "catalog", Version: "1.0.0"})
+//	...
+//	err = AddBinaryServiceEndpoints(nc, svc, impl, WithServerInterceptor(auth))
+//
+// As on a ServiceGroup, endpoint names are qualified with the service name
+// ("binary_service-health") while subjects keep the subject prefix, and the
+// schema_hash is advertised as endpoint metadata. Options of the micro.Service
+// itself, which NewServiceGroup lists, are rejected with an error, as is
+// WithServiceGroup. Stopping svc stops the endpoints; there is no Drain.
+func AddBinaryServiceEndpoints(nc *nats.Conn, svc micro.Service, impl BinaryServiceNats, opts ...RegisterOption) error {
+	cfg := &registerConfig{
+		subjectPrefix:  "demo.binary",
+		queueGroup:     "",
+		timeout:        0 * time.Second, // Service-level timeout (0 = no timeout)
+		tokenSanitizer: SanitizeToken,
+		streamWindow:   defaultStreamWindow,
+		metadata:       map[string]string{},
+	}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	host, err := existingServiceHost(nc, svc, cfg)
+	if err != nil {
+		return err
+	}
+	// The subjects and $schema document describe the proto service
+	cfg.name, cfg.version = "binary_service", "1.0.0"
+	_, err = registerBinaryServiceEndpoints(nc, impl, cfg, func() (*serviceHost, bool, error) {
+		return host, true, nil
 	})
+	return err
+}
+
+// registerBinaryServiceEndpoints registers the BinaryService endpoints cfg describes
+// on the host attach returns, once the configuration checks out. Endpoint names
+// are qualified when attach reports the host is shared with other services.
+func registerBinaryServiceEndpoints(nc *nats.Conn, impl BinaryServiceNats, cfg *registerConfig, attach func() (*serviceHost, bool, error)) (_ BinaryServiceService, err error) {
+
+	// Describe each endpoint from the schema blob
+	schemaMetadata, err := schemaEndpointMetadata(binaryServiceSchema, "demo.v1.BinaryService")
+	if err != nil {
+		return nil, err
+	}
+	endpointMethods := map[string]bool{
+		"Echo":    true,
+		"GetUser": true,
+	}
+	for method := range cfg.endpointMetadata {
+		if !endpointMethods[method] {
+			return nil, fmt.Errorf("WithEndpointMetadata: BinaryService has no method %s", method)
+		}
+	}
+
+	// Streams are compressed with gzip unless WithCompression names another codec
+	compressor := compressorFor(streamEncodingGzip)
+	if cfg.compressionCodec != "" {
+		if compressor = compressorFor(cfg.compressionCodec); compressor == nil {
+			return nil, fmt.Errorf("WithCompression: codec %q is not registered; add it with RegisterCompressor", cfg.compressionCodec)
+		}
+	}
+
+	host, shared, err := attach()
 	if err != nil {
-		return nil, fmt.Errorf("failed to add service: %w", err)
+		return nil, err
+	}
+	endpointPrefix := "" // Qualifies endpoint names on a shared micro.Service
+	if shared {
+		endpointPrefix = "binary_service-"
+	} else {
+		// Stop the micro.Service again if an endpoint fails to register
+		defer func() {
+			if err != nil {
+				host.svc.Stop()
+			}
+		}()
 	}
+	svc, pool := host.svc, host.pool
 
 	// Chain server interceptors
 	var chainedInterceptor UnaryServerInterceptor
@@ -967,63 +1761,209 @@ func RegisterBinaryServiceHandlers(nc *nats.Conn, impl BinaryServiceNats, opts .
 	}
 
 	handlers := &binaryServiceHandlers{
-		impl:           impl,
-		serviceTimeout: cfg.timeout,
-		useJSON:        false,
-		interceptor:    chainedInterceptor,
-		js:             cfg.js,
-	}
-
-	// Map of endpoint names to their handlers
+		nc:                nc,
+		impl:              impl,
+		serviceTimeout:    cfg.timeout,
+		useJSON:           false,
+		interceptor:       chainedInterceptor,
+		js:                cfg.js,
+		cancels:           host.cancels,
+		tokenSanitizer:    cfg.tokenSanitizer,
+		idGenerator:       cfg.idGenerator,
+		maxRequestSize:    cfg.maxRequestSize,
+		streamWindow:      cfg.streamWindow,
+		streamAllowGaps:   cfg.streamAllowGaps,
+		streamCompression: cfg.streamCompression,
+		compression:       cfg.compression,
+		compressor:        compressor,
+		responseHeaders:   cfg.responseHeaders,
+		requestCheck:      cfg.requestCheck,
+		streamInterceptor: chainStreamServerInterceptors(cfg.streamInterceptors),
+		endpointPrefix:    endpointPrefix,
+		inflight:          host.inflight,
+		sampler:           newSampler(cfg.sampling, cfg.samplingSalt),
+		maxServerDeadline: cfg.maxServerDeadline,
+		persistenceErrors: cfg.persistenceErrors,
+		panics:            cfg.panicDiagnostics,
+		failures:          newFailureRecorder(cfg),
+	}
+
+	// Map of endpoint names to their handlers; unary ones go through the handler pool,
+	// idempotent ones are coalesced first, and those expecting a response are
+	// deduplicated by idempotency key inside the pool
+	idempotency := newIdempotencyGuard(cfg)
 	endpoints := map[string]micro.Handler{
 
-		"echo": micro.HandlerFunc(handlers.Echo),
+		"echo": pool.wrap(endpointPrefix+"echo", idempotency.wrap(endpointPrefix+"echo", micro.HandlerFunc(handlers.Echo))),
 
-		"get_user": micro.HandlerFunc(handlers.GetUser),
+		"get_user": pool.wrap(endpointPrefix+"get_user", idempotency.wrap(endpointPrefix+"get_user", micro.HandlerFunc(handlers.GetUser))),
 	}
 
-	// Map of endpoint names to their metadata
+	// Map of endpoint names to their metadata: the schema's description of the
+	// method, overlaid with (natsmicro.endpoint).metadata, then WithEndpointMetadata
 	endpointMetadata := map[string]map[string]string{
 
-		"echo": {},
+		"echo": mergeMetadata(mergeMetadata(schemaMetadata["Echo"], map[string]string{}), cfg.endpointMetadata["Echo"]),
 
-		"get_user": {},
+		"get_user": mergeMetadata(mergeMetadata(schemaMetadata["GetUser"], map[string]string{}), cfg.endpointMetadata["GetUser"]),
 	}
 
-	// Use interface to handle both Service and Group
-	type endpointAdder interface {
+	// Map of endpoint names to exact subjects from (natsmicro.endpoint).subject,
+	// or the wildcard form of subject_template
+	// These endpoints are registered outside the subject prefix group
+	endpointSubjects := map[string]string{}
+
+	// Endpoints WithInstanceSubjects also serves on <subject>.<instance ID>
+	instanceEndpoints := map[string]bool{
+		"echo":     true,
+		"get_user": true,
+	}
+
+	// Use interface to handle both Service and Group
+	type endpointAdder interface {
 		AddEndpoint(string, micro.Handler, ...micro.EndpointOpt) error
 	}
 
-	var adder endpointAdder = svc
+	var group endpointAdder = svc
 	if cfg.subjectPrefix != "" {
-		adder = svc.AddGroup(cfg.subjectPrefix)
+		group = svc.AddGroup(cfg.subjectPrefix)
 	}
 
 	// Register all endpoints with their metadata
 	for name, handler := range endpoints {
 		opts := []micro.EndpointOpt{}
-		if metadata, exists := endpointMetadata[name]; exists && len(metadata) > 0 {
+		metadata := endpointMetadata[name]
+		if cfg.maxRequestSize > 0 {
+			// Advertise the request size limit alongside the proto metadata
+			withLimit := map[string]string{"max_request_size": fmt.Sprint(cfg.maxRequestSize)}
+			for k, v := range metadata {
+				withLimit[k] = v
+			}
+			metadata = withLimit
+		}
+		if shared {
+			// Keep the unqualified subject; the shared service's metadata cannot
+			// carry every service's schema hash
+			opts = append(opts, micro.WithEndpointSubject(name))
+			metadata = mergeMetadata(metadata, map[string]string{"schema_hash": BinaryServiceSchemaHash})
+			if cfg.queueGroup != "" {
+				opts = append(opts, micro.WithEndpointQueueGroup(cfg.queueGroup))
+			}
+		}
+		if len(metadata) > 0 {
 			opts = append(opts, micro.WithEndpointMetadata(metadata))
 		}
-		if err := adder.AddEndpoint(name, handler, opts...); err != nil {
+		adder := group
+		subject := name
+		if exact, exists := endpointSubjects[name]; exists {
+			adder = svc
+			subject = exact
+			opts = append(opts, micro.WithEndpointSubject(subject))
+		}
+		if cfg.instanceSubjects && instanceEndpoints[name] {
+			// Responses name the instance, for ResponderInstance
+			handler = respondAsInstance(svc.Info().ID, handler)
+		}
+		if err := adder.AddEndpoint(endpointPrefix+name, holdRequests(cfg.hold, handler), opts...); err != nil {
 			return nil, fmt.Errorf("failed to add endpoint %s: %w", name, err)
 		}
+		if cfg.instanceSubjects && instanceEndpoints[name] {
+			// The same handler on this instance's own subject, for WithTargetInstance
+			opts = append(opts, micro.WithEndpointSubject(subject+"."+svc.Info().ID))
+			if err := adder.AddEndpoint(endpointPrefix+name+"-instance", holdRequests(cfg.hold, handler), opts...); err != nil {
+				return nil, fmt.Errorf("failed to add instance endpoint %s: %w", name, err)
+			}
+		}
+	}
+
+	pool.start()
+
+	// Application health endpoint, registered outside the subject prefix group
+	if !cfg.noHealthEndpoint {
+		subject := healthSubject(cfg.subjectPrefix, "binary_service")
+		if err := svc.AddEndpoint(endpointPrefix+"health", holdRequests(cfg.hold, newHealthHandler(impl, cfg.timeout)), micro.WithEndpointSubject(subject)); err != nil {
+			return nil, fmt.Errorf("failed to add health endpoint: %w", err)
+		}
+	}
+
+	// Schema reflection endpoint, registered outside the subject prefix group
+	if !cfg.noReflectEndpoint {
+		subject := reflectSubject(cfg.subjectPrefix, "binary_service")
+		if err := svc.AddEndpoint(endpointPrefix+"reflect", holdRequests(cfg.hold, newReflectHandler(binaryServiceSchema, BinaryServiceSchemaHash)), micro.WithEndpointSubject(subject)); err != nil {
+			return nil, fmt.Errorf("failed to add reflect endpoint: %w", err)
+		}
+	}
+
+	// Schema document endpoint, registered outside the subject prefix group
+	if !cfg.noSchemaEndpoint {
+		handler, err := newSchemaHandler(binaryServiceSchemaDocument, cfg)
+		if err != nil {
+			return nil, err
+		}
+		subject := schemaSubject(cfg.subjectPrefix, "binary_service")
+		if err := svc.AddEndpoint(endpointPrefix+"schema", holdRequests(cfg.hold, handler), micro.WithEndpointSubject(subject)); err != nil {
+			return nil, fmt.Errorf("failed to add schema endpoint: %w", err)
+		}
+	}
+
+	queueGroup := cfg.queueGroup
+	if queueGroup == "" {
+		queueGroup = micro.DefaultQueueGroup
 	}
 
 	return &binaryServiceService{
-		Service:       svc,
-		subjectPrefix: cfg.subjectPrefix,
+		Service:        svc,
+		subjectPrefix:  cfg.subjectPrefix,
+		queueGroup:     queueGroup,
+		maxRequestSize: cfg.maxRequestSize,
+		inflight:       handlers.inflight,
+		interceptors:   interceptorChainNames(cfg.serverInterceptors),
+		sampler:        handlers.sampler,
 	}, nil
 }
 
+// BinaryServiceRegistration describes a RegisterBinaryServiceHandlers call for
+// RegisterGroup, which registers it together with other services, all or nothing
+func BinaryServiceRegistration(impl BinaryServiceNats, opts ...RegisterOption) Registration {
+	return registrationFunc(func(nc *nats.Conn, hold func(context.Context) error) (GroupService, error) {
+		return RegisterBinaryServiceHandlers(nc, impl, append(opts[:len(opts):len(opts)], withRequestHold(hold))...)
+	})
+}
+
 // binaryServiceHandlers wraps the service implementation with NATS handlers
 type binaryServiceHandlers struct {
-	impl           BinaryServiceNats
-	serviceTimeout time.Duration          // Default timeout for all endpoints
-	useJSON        bool                   // Use JSON encoding instead of binary protobuf
-	interceptor    UnaryServerInterceptor // Chained interceptors
-	js             jetstream.JetStream    // Optional JetStream context for KV/ObjectStore
+	nc                 *nats.Conn // NATS connection for streaming
+	impl               BinaryServiceNats
+	serviceTimeout     time.Duration                     // Default timeout for all endpoints
+	useJSON            bool                              // Use JSON encoding instead of binary protobuf
+	interceptor        UnaryServerInterceptor            // Chained interceptors
+	methodInterceptors map[string]UnaryServerInterceptor // Interceptors plus (natsmicro.endpoint).middlewares, by method
+	js                 jetstream.JetStream               // Optional JetStream context for KV/ObjectStore
+	cancels            *cancelRegistry                   // Client cancel notices (nil unless WithCancelPropagation)
+	tokenSanitizer     func(string) string               // Escapes request fields in KV/Object Store keys
+	idGenerator        func() string                     // Mints stream inbox and persistent call IDs (nil = NUIDs)
+	maxRequestSize     int                               // Largest accepted request payload in bytes (0 = unlimited)
+	inflight           *inflightTracker                  // Running handlers, for Drain
+	persistentStreams  map[string]*persistentStream      // (natsmicro.stream).persistence streams, by method
+	streamWindow       int                               // Server-stream flow control cap (0 = none)
+	streamCompression  int                               // Smallest stream message compressed, once negotiated (0 = off)
+	compression        int                               // Smallest unary response compressed, if the client accepts it (0 = off)
+	compressor         Compressor                        // Codec for responses and stream messages
+	streamAllowGaps    bool                              // Skip lost client-stream messages instead of failing Recv
+	responseHeaders    *headerPolicy                     // Strips response headers (nil = allow all)
+	requestCheck       *requestImmutabilityCheck         // Reports handlers that modify their request (nil = off)
+	streamInterceptor  StreamServerInterceptor           // Chained stream interceptors
+	endpointPrefix     string                            // Qualifies endpoint names within a ServiceGroup
+	sampler            *sampler                          // Samples unary calls (WithSampling)
+	maxServerDeadline  time.Duration                     // Cap on propagated client deadlines (0 = none)
+	persistenceErrors  func(method string, err error)    // Receives failed KV/Object Store writes (nil = print)
+	panics             *panicDiagnostics                 // Recovers and reports handler panics (nil = off)
+	failures           *failureRecorder                  // Records failed calls for replay (nil = off)
+}
+
+// keyToken renders a request field for a key template through the token sanitizer
+func (h *binaryServiceHandlers) keyToken(v any) string {
+	return h.tokenSanitizer(fmt.Sprint(v))
 }
 
 func (h *binaryServiceHandlers) Echo(req micro.Request) {
@@ -1031,14 +1971,26 @@ func (h *binaryServiceHandlers) Echo(req micro.Request) {
 	// If endpoint timeout is set (> 0), use it; otherwise use service timeout
 	timeout := h.serviceTimeout
 
-	// Create context with timeout if configured, otherwise use background context
-	ctx := context.Background()
+	// Create context with timeout if configured, derived from the service's drain context
+	ctx, end := h.inflight.begin(false) // Tracked for Drain
+	defer end()
 	var cancel context.CancelFunc
 	if timeout > 0 {
 		ctx, cancel = context.WithTimeout(ctx, timeout)
 		defer cancel()
 	}
 
+	// Stop when the client gives up: honor the deadline it propagated
+	ctx, cancelDeadline := withPropagatedDeadline(ctx, req.Headers(), h.maxServerDeadline)
+	defer cancelDeadline()
+
+	// Cancel the handler early if the client abandons the request
+	if h.cancels != nil {
+		var release func()
+		ctx, release = h.cancels.watch(ctx, req.Headers())
+		defer release()
+	}
+
 	// Add incoming NATS headers to context so service methods can access them
 	if req.Headers() != nil {
 		ctx = WithIncomingHeaders(ctx, req.Headers())
@@ -1046,62 +1998,69 @@ func (h *binaryServiceHandlers) Echo(req micro.Request) {
 
 	// Initialize outgoing headers pointer in context so interceptors can set response headers
 	outgoingHeadersPtr := &nats.Header{}
-	ctx = context.WithValue(ctx, outgoingHeadersKey, outgoingHeadersPtr)
+	ctx = withPendingResponseHeaders(ctx, outgoingHeadersPtr)
 
+	// Reject oversized requests before decoding them, decompressing compressed ones
+	body, payloadErr := readPayload("request", req.Headers().Get(ContentEncodingHeader), req.Data(), h.maxRequestSize)
+	if payloadErr != nil {
+		code, message, data := natsErrorFields(payloadErr)
+		req.Error(code, message, data)
+		return
+	}
+
+	// Decode with the codec the client named; clients without Content-Type use the configured one
+	requestJSON, ctErr := payloadUsesJSON(req.Headers().Get(ContentTypeHeader), h.useJSON)
+	if ctErr != nil {
+		code, message, data := natsErrorFields(ctErr)
+		req.Error(code, message, data)
+		return
+	}
 	var msg EchoRequest
-	if h.useJSON {
-		if err := protojson.Unmarshal(req.Data(), &msg); err != nil {
+	if requestJSON {
+		if err := protojson.Unmarshal(body, &msg); err != nil {
 			req.Error(BinaryServiceErrCodeInvalidArgument, fmt.Sprintf("failed to decode JSON request: %v", err), nil)
 			return
 		}
 	} else {
-		if err := proto.Unmarshal(req.Data(), &msg); err != nil {
+		if err := proto.Unmarshal(body, &msg); err != nil {
 			req.Error(BinaryServiceErrCodeInvalidArgument, fmt.Sprintf("failed to decode request: %v", err), nil)
 			return
 		}
 	}
 
+	// Sample the call for WithSampling, capturing the request before the handler runs
+	sample := h.sampler.begin("BinaryService", "Echo", incomingRequestID(req), false, nats.Header(req.Headers()), &msg, false)
+
 	// Define the handler function
 	handler := func(ctx context.Context, request interface{}) (interface{}, error) {
 		typedReq, ok := request.(*EchoRequest)
 		if !ok {
 			return nil, fmt.Errorf("invalid request type")
 		}
+		defer h.requestCheck.check("BinaryService.Echo", typedReq)()
 		return h.impl.Echo(ctx, typedReq)
 	}
+	handler = h.panics.wrap("BinaryService", "Echo", "demo.v1.BinaryService.Echo", false, handler)
+	handler = h.failures.wrap("BinaryService", "Echo", "demo.v1.BinaryService.Echo", false, handler)
 
 	// Execute through interceptor chain if configured
 	var resp interface{}
 	var err error
-	if h.interceptor != nil {
+	interceptor := h.interceptor
+	if interceptor != nil {
 		info := &UnaryServerInfo{
-			Service: "BinaryService",
-			Method:  "Echo",
-			Subject: "demo.binary.echo",
+			Service:    "BinaryService",
+			Method:     "Echo",
+			FullMethod: "demo.v1.BinaryService.Echo",
+			Subject:    "demo.binary.echo",
 		}
-		resp, err = h.interceptor(ctx, &msg, info, handler)
+		resp, err = interceptor(ctx, &msg, info, handler)
 	} else {
 		resp, err = handler(ctx, &msg)
 	}
+	sample.end(resp, err)
 	if err != nil {
-		// Check if error implements custom status code/message/data methods
-		code := BinaryServiceErrCodeInternal
-		message := err.Error()
-		var data []byte
-
-		// Check for NatsErrorCode() string method
-		if coder, ok := err.(interface{ NatsErrorCode() string }); ok {
-			code = coder.NatsErrorCode()
-		}
-		// Check for NatsErrorMessage() string method
-		if messager, ok := err.(interface{ NatsErrorMessage() string }); ok {
-			message = messager.NatsErrorMessage()
-		}
-		// Check for NatsErrorData() []byte method
-		if dataProvider, ok := err.(interface{ NatsErrorData() []byte }); ok {
-			data = dataProvider.NatsErrorData()
-		}
-
+		code, message, data := natsErrorFields(err)
 		req.Error(code, message, data)
 		return
 	}
@@ -1115,7 +2074,7 @@ func (h *binaryServiceHandlers) Echo(req micro.Request) {
 
 	var data []byte
 	if h.useJSON {
-		data, err = protojson.Marshal(typedResp)
+		data, err = marshalJSON(typedResp, false)
 		if err != nil {
 			req.Error(BinaryServiceErrCodeInternal, fmt.Sprintf("failed to encode JSON response: %v", err), nil)
 			return
@@ -1130,22 +2089,17 @@ func (h *binaryServiceHandlers) Echo(req micro.Request) {
 
 	// Check if context has outgoing headers set by interceptors
 	// Read from the pointer that was initialized at the start
-	var outgoingHeaders nats.Header
-	if headersPtr, ok := ctx.Value(outgoingHeadersKey).(*nats.Header); ok && headersPtr != nil {
-		outgoingHeaders = *headersPtr
-	}
+	outgoingHeaders := pendingResponseHeaders(ctx)
 
-	if len(outgoingHeaders) > 0 {
-		// Send response with headers using micro.WithHeaders
-		// Convert nats.Header to micro.Headers (they are the same underlying type)
-		if err := req.Respond(data, micro.WithHeaders(micro.Headers(outgoingHeaders))); err != nil {
-			fmt.Fprintf(os.Stderr, "failed to send response for Echo: %v\n", err)
-		}
-	} else {
-		// Send response without headers
-		if err := req.Respond(data); err != nil {
-			fmt.Fprintf(os.Stderr, "failed to send response for Echo: %v\n", err)
-		}
+	// Send response with headers set by interceptors plus the response codec
+	// Convert nats.Header to micro.Headers (they are the same underlying type)
+	outgoingHeaders = h.responseHeaders.strip(h.endpointPrefix+"echo", outgoingHeaders)
+	outgoingHeaders = withContentType(outgoingHeaders, h.useJSON)
+	if h.compression > 0 && acceptsEncoding(req.Headers().Get(acceptEncodingHeader), h.compressor.Name()) {
+		data = compressPayload(h.compressor, h.compression, data, outgoingHeaders)
+	}
+	if err := req.Respond(data, micro.WithHeaders(micro.Headers(outgoingHeaders))); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to send response for Echo: %v\n", err)
 	}
 }
 
@@ -1154,14 +2108,26 @@ func (h *binaryServiceHandlers) GetUser(req micro.Request) {
 	// If endpoint timeout is set (> 0), use it; otherwise use service timeout
 	timeout := h.serviceTimeout
 
-	// Create context with timeout if configured, otherwise use background context
-	ctx := context.Background()
+	// Create context with timeout if configured, derived from the service's drain context
+	ctx, end := h.inflight.begin(false) // Tracked for Drain
+	defer end()
 	var cancel context.CancelFunc
 	if timeout > 0 {
 		ctx, cancel = context.WithTimeout(ctx, timeout)
 		defer cancel()
 	}
 
+	// Stop when the client gives up: honor the deadline it propagated
+	ctx, cancelDeadline := withPropagatedDeadline(ctx, req.Headers(), h.maxServerDeadline)
+	defer cancelDeadline()
+
+	// Cancel the handler early if the client abandons the request
+	if h.cancels != nil {
+		var release func()
+		ctx, release = h.cancels.watch(ctx, req.Headers())
+		defer release()
+	}
+
 	// Add incoming NATS headers to context so service methods can access them
 	if req.Headers() != nil {
 		ctx = WithIncomingHeaders(ctx, req.Headers())
@@ -1169,62 +2135,69 @@ func (h *binaryServiceHandlers) GetUser(req micro.Request) {
 
 	// Initialize outgoing headers pointer in context so interceptors can set response headers
 	outgoingHeadersPtr := &nats.Header{}
-	ctx = context.WithValue(ctx, outgoingHeadersKey, outgoingHeadersPtr)
+	ctx = withPendingResponseHeaders(ctx, outgoingHeadersPtr)
+
+	// Reject oversized requests before decoding them, decompressing compressed ones
+	body, payloadErr := readPayload("request", req.Headers().Get(ContentEncodingHeader), req.Data(), h.maxRequestSize)
+	if payloadErr != nil {
+		code, message, data := natsErrorFields(payloadErr)
+		req.Error(code, message, data)
+		return
+	}
 
+	// Decode with the codec the client named; clients without Content-Type use the configured one
+	requestJSON, ctErr := payloadUsesJSON(req.Headers().Get(ContentTypeHeader), h.useJSON)
+	if ctErr != nil {
+		code, message, data := natsErrorFields(ctErr)
+		req.Error(code, message, data)
+		return
+	}
 	var msg GetUserRequest
-	if h.useJSON {
-		if err := protojson.Unmarshal(req.Data(), &msg); err != nil {
+	if requestJSON {
+		if err := protojson.Unmarshal(body, &msg); err != nil {
 			req.Error(BinaryServiceErrCodeInvalidArgument, fmt.Sprintf("failed to decode JSON request: %v", err), nil)
 			return
 		}
 	} else {
-		if err := proto.Unmarshal(req.Data(), &msg); err != nil {
+		if err := proto.Unmarshal(body, &msg); err != nil {
 			req.Error(BinaryServiceErrCodeInvalidArgument, fmt.Sprintf("failed to decode request: %v", err), nil)
 			return
 		}
 	}
 
+	// Sample the call for WithSampling, capturing the request before the handler runs
+	sample := h.sampler.begin("BinaryService", "GetUser", incomingRequestID(req), false, nats.Header(req.Headers()), &msg, false)
+
 	// Define the handler function
 	handler := func(ctx context.Context, request interface{}) (interface{}, error) {
 		typedReq, ok := request.(*GetUserRequest)
 		if !ok {
 			return nil, fmt.Errorf("invalid request type")
 		}
+		defer h.requestCheck.check("BinaryService.GetUser", typedReq)()
 		return h.impl.GetUser(ctx, typedReq)
 	}
+	handler = h.panics.wrap("BinaryService", "GetUser", "demo.v1.BinaryService.GetUser", false, handler)
+	handler = h.failures.wrap("BinaryService", "GetUser", "demo.v1.BinaryService.GetUser", false, handler)
 
 	// Execute through interceptor chain if configured
 	var resp interface{}
 	var err error
-	if h.interceptor != nil {
+	interceptor := h.interceptor
+	if interceptor != nil {
 		info := &UnaryServerInfo{
-			Service: "BinaryService",
-			Method:  "GetUser",
-			Subject: "demo.binary.get_user",
+			Service:    "BinaryService",
+			Method:     "GetUser",
+			FullMethod: "demo.v1.BinaryService.GetUser",
+			Subject:    "demo.binary.get_user",
 		}
-		resp, err = h.interceptor(ctx, &msg, info, handler)
+		resp, err = interceptor(ctx, &msg, info, handler)
 	} else {
 		resp, err = handler(ctx, &msg)
 	}
+	sample.end(resp, err)
 	if err != nil {
-		// Check if error implements custom status code/message/data methods
-		code := BinaryServiceErrCodeInternal
-		message := err.Error()
-		var data []byte
-
-		// Check for NatsErrorCode() string method
-		if coder, ok := err.(interface{ NatsErrorCode() string }); ok {
-			code = coder.NatsErrorCode()
-		}
-		// Check for NatsErrorMessage() string method
-		if messager, ok := err.(interface{ NatsErrorMessage() string }); ok {
-			message = messager.NatsErrorMessage()
-		}
-		// Check for NatsErrorData() []byte method
-		if dataProvider, ok := err.(interface{ NatsErrorData() []byte }); ok {
-			data = dataProvider.NatsErrorData()
-		}
-
+		code, message, data := natsErrorFields(err)
 		req.Error(code, message, data)
 		return
 	}
@@ -1238,7 +2211,7 @@ func (h *binaryServiceHandlers) GetUser(req micro.Request) {
 
 	var data []byte
 	if h.useJSON {
-		data, err = protojson.Marshal(typedResp)
+		data, err = marshalJSON(typedResp, false)
 		if err != nil {
 			req.Error(BinaryServiceErrCodeInternal, fmt.Sprintf("failed to encode JSON response: %v", err), nil)
 			return
@@ -1253,47 +2226,120 @@ func (h *binaryServiceHandlers) GetUser(req micro.Request) {
 
 	// Check if context has outgoing headers set by interceptors
 	// Read from the pointer that was initialized at the start
-	var outgoingHeaders nats.Header
-	if headersPtr, ok := ctx.Value(outgoingHeadersKey).(*nats.Header); ok && headersPtr != nil {
-		outgoingHeaders = *headersPtr
-	}
+	outgoingHeaders := pendingResponseHeaders(ctx)
 
-	if len(outgoingHeaders) > 0 {
-		// Send response with headers using micro.WithHeaders
-		// Convert nats.Header to micro.Headers (they are the same underlying type)
-		if err := req.Respond(data, micro.WithHeaders(micro.Headers(outgoingHeaders))); err != nil {
-			fmt.Fprintf(os.Stderr, "failed to send response for GetUser: %v\n", err)
-		}
-	} else {
-		// Send response without headers
-		if err := req.Respond(data); err != nil {
-			fmt.Fprintf(os.Stderr, "failed to send response for GetUser: %v\n", err)
-		}
+	// Send response with headers set by interceptors plus the response codec
+	// Convert nats.Header to micro.Headers (they are the same underlying type)
+	outgoingHeaders = h.responseHeaders.strip(h.endpointPrefix+"get_user", outgoingHeaders)
+	outgoingHeaders = withContentType(outgoingHeaders, h.useJSON)
+	if h.compression > 0 && acceptsEncoding(req.Headers().Get(acceptEncodingHeader), h.compressor.Name()) {
+		data = compressPayload(h.compressor, h.compression, data, outgoingHeaders)
+	}
+	if err := req.Respond(data, micro.WithHeaders(micro.Headers(outgoingHeaders))); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to send response for GetUser: %v\n", err)
 	}
 }
 
 // BinaryServiceNatsClientInterface is the interface for the NATS client
 // This interface allows for easier dependency injection and testing
 type BinaryServiceNatsClientInterface interface {
-	Echo(context.Context, *EchoRequest) (*EchoResponse, error)
-	GetUser(context.Context, *GetUserRequest) (*GetUserResponse, error)
+	Echo(context.Context, *EchoRequest, ...CallOption) (*EchoResponse, error)
+	GetUser(context.Context, *GetUserRequest, ...CallOption) (*GetUserResponse, error)
+	Health(ctx context.Context) (*HealthResponse, error)
+	Schema(ctx context.Context) (*ServiceSchemaDocument, error)
 	Endpoints() []BinaryServiceEndpointInfo
+	WithHeaders(headers nats.Header) BinaryServiceNatsClientInterface
+	Close()
 }
 
 // BinaryServiceNatsClient is the concrete implementation of BinaryServiceNatsClientInterface
 type BinaryServiceNatsClient struct {
-	nc            *nats.Conn
-	subjectPrefix string
-	useJSON       bool                   // Use JSON encoding instead of binary protobuf
-	interceptor   UnaryClientInterceptor // Chained interceptors
-	js            jetstream.JetStream    // Optional JetStream for KV/ObjectStore reads
+	nc                  *nats.Conn
+	subjectPrefix       string
+	useJSON             bool                    // Use JSON encoding instead of binary protobuf
+	interceptor         UnaryClientInterceptor  // Chained interceptors
+	streamInterceptor   StreamClientInterceptor // Chained stream interceptors
+	js                  jetstream.JetStream     // Optional JetStream for KV/ObjectStore reads
+	kvStaleAfter        time.Duration           // Age at which Get*Cached treats KV entries as misses (0 = never)
+	cancelPropagation   bool                    // Publish a cancel notice when ctx ends mid-request
+	timeout             time.Duration           // Default unary timeout when ctx has no deadline
+	retry               *retryPolicy            // Unary retry policy (nil = no retries)
+	tokenSanitizer      func(string) string     // Escapes request fields in key helpers
+	idGenerator         func() string           // Mints cancel subject and stream inbox IDs (nil = NUIDs)
+	maxResponseSize     int                     // Largest accepted response payload in bytes (0 = unlimited)
+	connSelector        func() *nats.Conn       // Picks the connection per call (nil = nc)
+	journal             *journal                // Records calls for replay (nil = no journal)
+	streamWindow        int                     // Server-stream flow control window (0 = none)
+	streamAllowGaps     bool                    // Skip lost stream messages instead of failing Recv
+	streamCompression   int                     // Smallest stream message compressed, once negotiated (0 = off)
+	compression         int                     // Smallest unary request compressed (0 = off)
+	compressionCodec    string                  // Codec unary requests are compressed with
+	streamResumeOnDrain bool                    // Reopen server streams when their server drains
+	headerPolicy        *headerPolicy           // Strips outgoing headers (nil = allow all)
+	sampler             *sampler                // Samples unary calls (WithClientSampling)
+	monitor             *connectionMonitor      // Samples the connections (WithClientConnectionMonitor)
+	headers             nats.Header             // Sent under each call's OutgoingHeaders (WithHeaders)
+}
+
+// compress returns a unary request payload compressed as WithClientCompression
+// asks, flagged in headers
+func (c *BinaryServiceNatsClient) compress(data []byte, headers nats.Header) ([]byte, error) {
+	if c.compression == 0 {
+		return data, nil
+	}
+	codec := compressorFor(c.compressionCodec)
+	if codec == nil {
+		return nil, fmt.Errorf("WithClientCompression: codec %q is not registered; add it with RegisterCompressor", c.compressionCodec)
+	}
+	return compressPayload(codec, c.compression, data, headers), nil
+}
+
+// conn returns the connection for the next call or stream
+func (c *BinaryServiceNatsClient) conn() *nats.Conn {
+	if c.connSelector != nil {
+		return c.connSelector()
+	}
+	return c.nc
+}
+
+// outgoingHeaders returns the headers of ctx that WithOutgoingHeaderPolicy lets a call send
+func (c *BinaryServiceNatsClient) outgoingHeaders(ctx context.Context) nats.Header {
+	headers, _ := c.headerPolicy.filter(layerHeaders(c.headers, OutgoingHeaders(ctx)))
+	return headers
+}
+
+// WithHeaders returns a view of the client that sends headers with every call and
+// stream, beneath those set on the call's context with WithOutgoingHeaders. A view
+// shares the client's connections, interceptors, retry policy, journal and sampler
+// rather than copying them, so it is cheap to make per request, e.g. per tenant, and
+// like the client is safe for concurrent use. Views of views add to their parent's
+// headers. Closing a view does not stop the client's connection monitor.
+func (c *BinaryServiceNatsClient) WithHeaders(headers nats.Header) BinaryServiceNatsClientInterface {
+	layered := make(nats.Header, len(c.headers)+len(headers))
+	for name, values := range c.headers {
+		layered[name] = values
+	}
+	for name, values := range headers {
+		layered[name] = append([]string(nil), values...) // Later changes to headers stay out of the view
+	}
+	view := *c
+	view.headers = layered
+	view.monitor = nil
+	return &view
+}
+
+// keyToken renders a request field for a key template through the token sanitizer
+func (c *BinaryServiceNatsClient) keyToken(v any) string {
+	return c.tokenSanitizer(fmt.Sprint(v))
 }
 
 // NewBinaryServiceNatsClient creates a new NATS client for BinaryService.
 // The client sends requests over NATS using protobuf serialization (or JSON if configured).
 func NewBinaryServiceNatsClient(nc *nats.Conn, opts ...NatsClientOption) BinaryServiceNatsClientInterface {
 	cfg := &natsClientConfig{
-		subjectPrefix: "demo.binary",
+		subjectPrefix:  "demo.binary",
+		tokenSanitizer: SanitizeToken,
+		streamWindow:   defaultStreamWindow,
 	}
 	for _, opt := range opts {
 		opt.applyNatsClientOption(cfg)
@@ -1306,30 +2352,78 @@ func NewBinaryServiceNatsClient(nc *nats.Conn, opts ...NatsClientOption) BinaryS
 	}
 
 	c := &BinaryServiceNatsClient{
-		nc:            nc,
-		subjectPrefix: cfg.subjectPrefix,
-		useJSON:       false,
-		interceptor:   chainedInterceptor,
-		js:            cfg.js,
+		nc:                  nc,
+		subjectPrefix:       cfg.subjectPrefix,
+		useJSON:             false,
+		interceptor:         chainedInterceptor,
+		streamInterceptor:   chainStreamClientInterceptors(cfg.streamInterceptors),
+		js:                  cfg.js,
+		kvStaleAfter:        cfg.kvStaleAfter,
+		cancelPropagation:   cfg.cancelPropagation,
+		timeout:             cfg.timeout,
+		retry:               newRetryPolicy(cfg),
+		tokenSanitizer:      cfg.tokenSanitizer,
+		idGenerator:         cfg.idGenerator,
+		maxResponseSize:     cfg.maxResponseSize,
+		connSelector:        cfg.connSelector,
+		journal:             cfg.journal,
+		streamWindow:        cfg.streamWindow,
+		streamAllowGaps:     cfg.streamAllowGaps,
+		streamCompression:   cfg.streamCompression,
+		compression:         cfg.compression,
+		compressionCodec:    cfg.compressionCodec,
+		streamResumeOnDrain: cfg.streamResumeOnDrain,
+		headerPolicy:        cfg.headerPolicy,
+		sampler:             newSampler(cfg.sampling, cfg.samplingSalt),
+		monitor:             startConnectionMonitor(cfg.connMonitor, monitoredConns(nc, cfg.poolConns)...),
 	}
 	return c
 }
 
+// NewBinaryServiceNatsClientPool creates a BinaryService client that spreads calls
+// over conns round-robin (see RoundRobinConns). Streams stay on the connection they
+// opened on. A WithConnSelector in opts replaces the round-robin selector.
+func NewBinaryServiceNatsClientPool(conns []*nats.Conn, opts ...NatsClientOption) BinaryServiceNatsClientInterface {
+	selector := RoundRobinConns(conns)
+	opts = append([]NatsClientOption{natsClientOptionFunc(func(c *natsClientConfig) { c.poolConns = conns })}, opts...)
+	return NewBinaryServiceNatsClient(conns[0], append([]NatsClientOption{WithConnSelector(selector)}, opts...)...)
+}
+
 // Echo sends a Echo request to the service via NATS.
-// Returns an error if the request fails or the service returns an error.
-func (c *BinaryServiceNatsClient) Echo(ctx context.Context, req *EchoRequest) (*EchoResponse, error) {
+// Returns an error if the request fails or the service returns an error,
+// or a *TimeoutError if a WithCallTimeout/WithClientTimeout expires.
+func (c *BinaryServiceNatsClient) Echo(ctx context.Context, req *EchoRequest, opts ...CallOption) (*EchoResponse, error) {
 	method := "Echo"
 
-	// Pointer to store response headers - stored in context so invoker can update it
-	responseHeadersPtr := &nats.Header{}
+	// Sample the call for WithClientSampling under a request ID the service sees too
+	var requestID string
+	if c.sampler.enabled() {
+		ctx, requestID = withRequestID(ctx, c.idGenerator)
+	}
+	sample := c.sampler.begin("BinaryService", method, requestID, true, c.outgoingHeaders(ctx), req, false)
+
+	// Bound the call when the caller gave no deadline (or asked for a per-call timeout)
+	start := time.Now()
+	parentCtx := ctx
+	ctx, cancel, timeout := withCallTimeout(ctx, c.timeout, opts)
+	defer cancel()
+
+	// Record sizes, attempts and duration for CallInfoFromContext
+	ctx, info := startCallInfo(ctx, "BinaryService", c.subjectPrefix+".echo")
+	defer info.finish()
+	ctx = withIDGenerator(ctx, c.idGenerator) // For NewID in interceptors
 
-	// Add the response headers pointer to context so invoker can populate it
+	// Give the invoker somewhere in the context to store the response headers
 	// Interceptors can then read the headers from the same context
-	ctx = context.WithValue(ctx, responseHeadersKey, responseHeadersPtr)
+	ctx = WithResponseHeaders(ctx, nats.Header{})
 
 	// Define the invoker function that performs the actual NATS call
 	invoker := func(invokerCtx context.Context, method string, request, reply interface{}) error {
-		subject := c.subjectPrefix + ".echo"
+		// WithTargetInstance sends the call to one instance's own subject
+		subject, subjectErr := targetSubject(c.subjectPrefix+".echo", opts)
+		if subjectErr != nil {
+			return newBinaryServiceError(BinaryServiceErrCodeInvalidArgument, method, subjectErr.Error(), nil)
+		}
 
 		// Marshal request
 		typedReq, ok := request.(*EchoRequest)
@@ -1340,7 +2434,7 @@ func (c *BinaryServiceNatsClient) Echo(ctx context.Context, req *EchoRequest) (*
 		var data []byte
 		var err error
 		if c.useJSON {
-			data, err = protojson.Marshal(typedReq)
+			data, err = marshalJSON(typedReq, false)
 		} else {
 			data, err = proto.Marshal(typedReq)
 		}
@@ -1348,83 +2442,124 @@ func (c *BinaryServiceNatsClient) Echo(ctx context.Context, req *EchoRequest) (*
 			return err
 		}
 
-		// Extract outgoing headers from context and attach to NATS message
-		var msg *nats.Msg
-		if headers := OutgoingHeaders(invokerCtx); headers != nil {
-			msg, err = c.nc.RequestMsgWithContext(invokerCtx, &nats.Msg{
-				Subject: subject,
-				Data:    data,
-				Header:  headers,
-			})
-		} else {
-			msg, err = c.nc.RequestWithContext(invokerCtx, subject, data)
+		// Extract outgoing headers from context and attach them, naming the codec, to the NATS message
+		nc := c.conn()
+		headers := withContentType(c.outgoingHeaders(invokerCtx), c.useJSON)
+		setDeadlineHeaders(invokerCtx, headers) // For deadline-aware scheduling and handler contexts
+		if data, err = c.compress(data, headers); err != nil {
+			return err
+		}
+		headers.Set(acceptEncodingHeader, compressorNames()) // Services compress responses only for clients that decompress them
+		if c.cancelPropagation {
+			var stop func() bool
+			headers, stop = propagateCancel(invokerCtx, nc, headers, mintID(c.idGenerator))
+			defer stop()
+		}
+
+		info.attempt(len(data))
+		msg, err := nc.RequestMsgWithContext(invokerCtx, &nats.Msg{
+			Subject: subject,
+			Data:    data,
+			Header:  headers,
+		})
+		if err != nil {
+			return err
 		}
+		info.received(len(msg.Data))
+		info.responder(msg.Header)
+		body, err := readPayload("response", msg.Header.Get(ContentEncodingHeader), msg.Data, c.maxResponseSize)
 		if err != nil {
 			return err
 		}
 
-		// Store response headers in the pointer from context
+		// Store response headers where ResponseHeaders reads them
 		if msg.Header != nil && len(msg.Header) > 0 {
-			if headersPtr, ok := invokerCtx.Value(responseHeadersKey).(*nats.Header); ok && headersPtr != nil {
-				*headersPtr = msg.Header
-			}
-		} // Check if this is an error response from the service
-		if msg.Header.Get("Status") != "" {
-			code := msg.Header.Get("Status")
-			description := msg.Header.Get("Description")
-			return &BinaryServiceError{
-				Code:    code,
-				Method:  method,
-				Message: description,
+			storeResponseHeaders(invokerCtx, msg.Header)
+		}
+
+		// Check if this is an error response from the service (NATS micro headers)
+		if msg.Header.Get("Nats-Service-Error-Code") != "" {
+			code := msg.Header.Get("Nats-Service-Error-Code")
+			description := msg.Header.Get("Nats-Service-Error")
+			var details []byte
+			if len(msg.Data) > 0 {
+				details = msg.Data
 			}
+			return newBinaryServiceError(code, method, description, details)
 		}
 
-		// Unmarshal response
+		// Unmarshal response with the codec the service named, if any
 		typedReply, ok := reply.(*EchoResponse)
 		if !ok {
 			return fmt.Errorf("invalid reply type")
 		}
-
-		if c.useJSON {
-			err = protojson.Unmarshal(msg.Data, typedReply)
+		replyJSON, err := payloadUsesJSON(msg.Header.Get(ContentTypeHeader), c.useJSON)
+		if err != nil {
+			return err
+		}
+		if replyJSON {
+			err = protojson.Unmarshal(body, typedReply)
 		} else {
-			err = proto.Unmarshal(msg.Data, typedReply)
+			err = proto.Unmarshal(body, typedReply)
 		}
 		return err
 	}
 
 	var resp EchoResponse
 
-	// Execute through interceptor chain if configured
-	var err error
-	if c.interceptor != nil {
-		err = c.interceptor(ctx, method, req, &resp, invoker)
-	} else {
-		err = invoker(ctx, method, req, &resp)
+	// Execute through interceptor chain if configured, once per retry attempt
+	err := c.retry.do(ctx, func(ctx context.Context) error {
+		if c.interceptor != nil {
+			return c.interceptor(ctx, method, req, &resp, invoker)
+		}
+		return invoker(ctx, method, req, &resp)
+	})
+	sample.end(&resp, err)
+	if c.journal != nil {
+		c.journal.record("BinaryService", method, c.subjectPrefix+".echo", c.outgoingHeaders(parentCtx), req, c.useJSON, false, start, err)
 	}
-
 	if err != nil {
-		return nil, err
+		return nil, callTimeoutError(parentCtx, ctx, method, timeout, err)
 	}
 
 	return &resp, nil
 }
 
 // GetUser sends a GetUser request to the service via NATS.
-// Returns an error if the request fails or the service returns an error.
-func (c *BinaryServiceNatsClient) GetUser(ctx context.Context, req *GetUserRequest) (*GetUserResponse, error) {
+// Returns an error if the request fails or the service returns an error,
+// or a *TimeoutError if a WithCallTimeout/WithClientTimeout expires.
+func (c *BinaryServiceNatsClient) GetUser(ctx context.Context, req *GetUserRequest, opts ...CallOption) (*GetUserResponse, error) {
 	method := "GetUser"
 
-	// Pointer to store response headers - stored in context so invoker can update it
-	responseHeadersPtr := &nats.Header{}
+	// Sample the call for WithClientSampling under a request ID the service sees too
+	var requestID string
+	if c.sampler.enabled() {
+		ctx, requestID = withRequestID(ctx, c.idGenerator)
+	}
+	sample := c.sampler.begin("BinaryService", method, requestID, true, c.outgoingHeaders(ctx), req, false)
+
+	// Bound the call when the caller gave no deadline (or asked for a per-call timeout)
+	start := time.Now()
+	parentCtx := ctx
+	ctx, cancel, timeout := withCallTimeout(ctx, c.timeout, opts)
+	defer cancel()
+
+	// Record sizes, attempts and duration for CallInfoFromContext
+	ctx, info := startCallInfo(ctx, "BinaryService", c.subjectPrefix+".get_user")
+	defer info.finish()
+	ctx = withIDGenerator(ctx, c.idGenerator) // For NewID in interceptors
 
-	// Add the response headers pointer to context so invoker can populate it
+	// Give the invoker somewhere in the context to store the response headers
 	// Interceptors can then read the headers from the same context
-	ctx = context.WithValue(ctx, responseHeadersKey, responseHeadersPtr)
+	ctx = WithResponseHeaders(ctx, nats.Header{})
 
 	// Define the invoker function that performs the actual NATS call
 	invoker := func(invokerCtx context.Context, method string, request, reply interface{}) error {
-		subject := c.subjectPrefix + ".get_user"
+		// WithTargetInstance sends the call to one instance's own subject
+		subject, subjectErr := targetSubject(c.subjectPrefix+".get_user", opts)
+		if subjectErr != nil {
+			return newBinaryServiceError(BinaryServiceErrCodeInvalidArgument, method, subjectErr.Error(), nil)
+		}
 
 		// Marshal request
 		typedReq, ok := request.(*GetUserRequest)
@@ -1435,7 +2570,7 @@ func (c *BinaryServiceNatsClient) GetUser(ctx context.Context, req *GetUserReque
 		var data []byte
 		var err error
 		if c.useJSON {
-			data, err = protojson.Marshal(typedReq)
+			data, err = marshalJSON(typedReq, false)
 		} else {
 			data, err = proto.Marshal(typedReq)
 		}
@@ -1443,68 +2578,115 @@ func (c *BinaryServiceNatsClient) GetUser(ctx context.Context, req *GetUserReque
 			return err
 		}
 
-		// Extract outgoing headers from context and attach to NATS message
-		var msg *nats.Msg
-		if headers := OutgoingHeaders(invokerCtx); headers != nil {
-			msg, err = c.nc.RequestMsgWithContext(invokerCtx, &nats.Msg{
-				Subject: subject,
-				Data:    data,
-				Header:  headers,
-			})
-		} else {
-			msg, err = c.nc.RequestWithContext(invokerCtx, subject, data)
+		// Extract outgoing headers from context and attach them, naming the codec, to the NATS message
+		nc := c.conn()
+		headers := withContentType(c.outgoingHeaders(invokerCtx), c.useJSON)
+		setDeadlineHeaders(invokerCtx, headers) // For deadline-aware scheduling and handler contexts
+		if data, err = c.compress(data, headers); err != nil {
+			return err
+		}
+		headers.Set(acceptEncodingHeader, compressorNames()) // Services compress responses only for clients that decompress them
+		if c.cancelPropagation {
+			var stop func() bool
+			headers, stop = propagateCancel(invokerCtx, nc, headers, mintID(c.idGenerator))
+			defer stop()
 		}
+
+		info.attempt(len(data))
+		msg, err := nc.RequestMsgWithContext(invokerCtx, &nats.Msg{
+			Subject: subject,
+			Data:    data,
+			Header:  headers,
+		})
+		if err != nil {
+			return err
+		}
+		info.received(len(msg.Data))
+		info.responder(msg.Header)
+		body, err := readPayload("response", msg.Header.Get(ContentEncodingHeader), msg.Data, c.maxResponseSize)
 		if err != nil {
 			return err
 		}
 
-		// Store response headers in the pointer from context
+		// Store response headers where ResponseHeaders reads them
 		if msg.Header != nil && len(msg.Header) > 0 {
-			if headersPtr, ok := invokerCtx.Value(responseHeadersKey).(*nats.Header); ok && headersPtr != nil {
-				*headersPtr = msg.Header
-			}
-		} // Check if this is an error response from the service
-		if msg.Header.Get("Status") != "" {
-			code := msg.Header.Get("Status")
-			description := msg.Header.Get("Description")
-			return &BinaryServiceError{
-				Code:    code,
-				Method:  method,
-				Message: description,
+			storeResponseHeaders(invokerCtx, msg.Header)
+		}
+
+		// Check if this is an error response from the service (NATS micro headers)
+		if msg.Header.Get("Nats-Service-Error-Code") != "" {
+			code := msg.Header.Get("Nats-Service-Error-Code")
+			description := msg.Header.Get("Nats-Service-Error")
+			var details []byte
+			if len(msg.Data) > 0 {
+				details = msg.Data
 			}
+			return newBinaryServiceError(code, method, description, details)
 		}
 
-		// Unmarshal response
+		// Unmarshal response with the codec the service named, if any
 		typedReply, ok := reply.(*GetUserResponse)
 		if !ok {
 			return fmt.Errorf("invalid reply type")
 		}
-
-		if c.useJSON {
-			err = protojson.Unmarshal(msg.Data, typedReply)
+		replyJSON, err := payloadUsesJSON(msg.Header.Get(ContentTypeHeader), c.useJSON)
+		if err != nil {
+			return err
+		}
+		if replyJSON {
+			err = protojson.Unmarshal(body, typedReply)
 		} else {
-			err = proto.Unmarshal(msg.Data, typedReply)
+			err = proto.Unmarshal(body, typedReply)
 		}
 		return err
 	}
 
 	var resp GetUserResponse
 
-	// Execute through interceptor chain if configured
-	var err error
-	if c.interceptor != nil {
-		err = c.interceptor(ctx, method, req, &resp, invoker)
-	} else {
-		err = invoker(ctx, method, req, &resp)
+	// Execute through interceptor chain if configured, once per retry attempt
+	err := c.retry.do(ctx, func(ctx context.Context) error {
+		if c.interceptor != nil {
+			return c.interceptor(ctx, method, req, &resp, invoker)
+		}
+		return invoker(ctx, method, req, &resp)
+	})
+	sample.end(&resp, err)
+	if c.journal != nil {
+		c.journal.record("BinaryService", method, c.subjectPrefix+".get_user", c.outgoingHeaders(parentCtx), req, c.useJSON, false, start, err)
 	}
-
 	if err != nil {
-		return nil, err
+		return nil, callTimeoutError(parentCtx, ctx, method, timeout, err)
 	}
 
 	return &resp, nil
 }
 
+// Health calls the service's health endpoint. A service that reports HealthNotServing
+// returns a HealthResponse, not an error.
+func (c *BinaryServiceNatsClient) Health(ctx context.Context) (*HealthResponse, error) {
+	parentCtx := ctx
+	ctx, cancel, timeout := withCallTimeout(ctx, c.timeout, nil)
+	defer cancel()
+	resp, err := requestHealth(ctx, c.conn(), healthSubject(c.subjectPrefix, "binary_service"))
+	if err != nil {
+		return nil, callTimeoutError(parentCtx, ctx, "Health", timeout, err)
+	}
+	return resp, nil
+}
+
+// Schema fetches the service's $schema document; see FetchServiceSchema
+func (c *BinaryServiceNatsClient) Schema(ctx context.Context) (*ServiceSchemaDocument, error) {
+	ctx, cancel, _ := withCallTimeout(ctx, c.timeout, nil)
+	defer cancel()
+	return FetchServiceSchema(ctx, c.conn(), schemaSubject(c.subjectPrefix, "binary_service"))
+}
+
+// Close stops the client's connection monitor. The NATS connection stays open,
+// and the client can still be used.
+func (c *BinaryServiceNatsClient) Close() {
+	c.monitor.close()
+}
+
 // Endpoints returns information about all service endpoints this client can call.
 // This is useful for debugging, monitoring, and introspection.
 func (c *BinaryServiceNatsClient) Endpoints() []BinaryServiceEndpointInfo {
@@ -1513,3 +2695,1360 @@ func (c *BinaryServiceNatsClient) Endpoints() []BinaryServiceEndpointInfo {
 		{Name: "GetUser", Subject: c.subjectPrefix + ".get_user"},
 	}
 }
+
+// BinaryServiceSelfTest checks that the NATS connection works and that a running
+// binary_service instance has registered every endpoint the client calls. Client
+// options such as WithNatsClientSubjectPrefix select the expected subjects.
+// Exit non-zero when the report is not OK to use it as a liveness or deployment hook.
+func BinaryServiceSelfTest(ctx context.Context, nc *nats.Conn, opts ...NatsClientOption) *SelfTestReport {
+	c := NewBinaryServiceNatsClient(nc, opts...)
+	var endpoints []SelfTestEndpoint
+	for _, ep := range c.Endpoints() {
+		endpoints = append(endpoints, SelfTestEndpoint{Name: ep.Name, Subject: ep.Subject})
+	}
+	return runSelfTest(ctx, nc, "binary_service", endpoints)
+}
+
+// MixedServiceError represents a structured error from MixedService
+type MixedServiceError struct {
+	Code    string // Error code (e.g., "INVALID_ARGUMENT", "NOT_FOUND", "INTERNAL")
+	Message string // Human-readable error message
+	Method  string // The method that failed
+	Details []byte // Optional error data sent with the error
+
+	status *Status // Returned by Unwrap (nil = built on each call)
+}
+
+// newMixedServiceError creates an error that keeps its *Status, so Unwrap
+// returns the same value every time
+func newMixedServiceError(code, method, message string, details []byte) *MixedServiceError {
+	return &MixedServiceError{
+		Code:    code,
+		Method:  method,
+		Message: message,
+		Details: details,
+		status:  &Status{Code: ParseCode(code), Message: message, Details: details},
+	}
+}
+
+func (e *MixedServiceError) Error() string {
+	return fmt.Sprintf("[%s] %s: %s", e.Code, e.Method, e.Message)
+}
+
+// NatsErrorCode returns the NATS error code for this error
+func (e *MixedServiceError) NatsErrorCode() string {
+	return e.Code
+}
+
+// NatsErrorMessage returns the NATS error message for this error
+func (e *MixedServiceError) NatsErrorMessage() string {
+	return e.Message
+}
+
+// NatsErrorData returns optional error data (nil for basic errors)
+func (e *MixedServiceError) NatsErrorData() []byte {
+	return e.Details
+}
+
+// Unwrap exposes the error as a *Status, so errors.As(err, &st) works on
+// client errors. Custom error codes map to CodeUnknown. Errors built as struct
+// literals get a new Status on each call.
+func (e *MixedServiceError) Unwrap() error {
+	if e.status != nil {
+		return e.status
+	}
+	return &Status{Code: ParseCode(e.Code), Message: e.Message, Details: e.Details}
+}
+
+// Service-specific error code constants (use shared constants from service_shared_nats.pb.go)
+const (
+	MixedServiceErrCodeInvalidArgument  = ErrCodeInvalidArgument
+	MixedServiceErrCodeNotFound         = ErrCodeNotFound
+	MixedServiceErrCodeAlreadyExists    = ErrCodeAlreadyExists
+	MixedServiceErrCodePermissionDenied = ErrCodePermissionDenied
+	MixedServiceErrCodeUnauthenticated  = ErrCodeUnauthenticated
+	MixedServiceErrCodeInternal         = ErrCodeInternal
+	MixedServiceErrCodeUnavailable      = ErrCodeUnavailable
+)
+
+// IsMixedServiceInvalidArgument checks if the error is an invalid argument error
+func IsMixedServiceInvalidArgument(err error) bool {
+	var svcErr *MixedServiceError
+	return errors.As(err, &svcErr) && svcErr.Code == MixedServiceErrCodeInvalidArgument
+}
+
+// IsMixedServiceNotFound checks if the error is a not found error
+func IsMixedServiceNotFound(err error) bool {
+	var svcErr *MixedServiceError
+	return errors.As(err, &svcErr) && svcErr.Code == MixedServiceErrCodeNotFound
+}
+
+// IsMixedServiceAlreadyExists checks if the error is an already exists error
+func IsMixedServiceAlreadyExists(err error) bool {
+	var svcErr *MixedServiceError
+	return errors.As(err, &svcErr) && svcErr.Code == MixedServiceErrCodeAlreadyExists
+}
+
+// IsMixedServicePermissionDenied checks if the error is a permission denied error
+func IsMixedServicePermissionDenied(err error) bool {
+	var svcErr *MixedServiceError
+	return errors.As(err, &svcErr) && svcErr.Code == MixedServiceErrCodePermissionDenied
+}
+
+// IsMixedServiceUnauthenticated checks if the error is an unauthenticated error
+func IsMixedServiceUnauthenticated(err error) bool {
+	var svcErr *MixedServiceError
+	return errors.As(err, &svcErr) && svcErr.Code == MixedServiceErrCodeUnauthenticated
+}
+
+// IsMixedServiceInternal checks if the error is an internal error
+func IsMixedServiceInternal(err error) bool {
+	var svcErr *MixedServiceError
+	return errors.As(err, &svcErr) && svcErr.Code == MixedServiceErrCodeInternal
+}
+
+// IsMixedServiceUnavailable checks if the error is an unavailable error
+func IsMixedServiceUnavailable(err error) bool {
+	var svcErr *MixedServiceError
+	return errors.As(err, &svcErr) && svcErr.Code == MixedServiceErrCodeUnavailable
+}
+
+// GetMixedServiceErrorCode extracts the error code from an error, returns empty string if not a MixedServiceError
+func GetMixedServiceErrorCode(err error) string {
+	var svcErr *MixedServiceError
+	if errors.As(err, &svcErr) {
+		return svcErr.Code
+	}
+	return ""
+}
+
+// NewMixedServiceInvalidArgumentError creates a new invalid argument error
+func NewMixedServiceInvalidArgumentError(method, message string) error {
+	return newMixedServiceError(MixedServiceErrCodeInvalidArgument, method, message, nil)
+}
+
+// NewMixedServiceNotFoundError creates a new not found error
+func NewMixedServiceNotFoundError(method, message string) error {
+	return newMixedServiceError(MixedServiceErrCodeNotFound, method, message, nil)
+}
+
+// NewMixedServiceAlreadyExistsError creates a new already exists error
+func NewMixedServiceAlreadyExistsError(method, message string) error {
+	return newMixedServiceError(MixedServiceErrCodeAlreadyExists, method, message, nil)
+}
+
+// NewMixedServicePermissionDeniedError creates a new permission denied error
+func NewMixedServicePermissionDeniedError(method, message string) error {
+	return newMixedServiceError(MixedServiceErrCodePermissionDenied, method, message, nil)
+}
+
+// NewMixedServiceUnauthenticatedError creates a new unauthenticated error
+func NewMixedServiceUnauthenticatedError(method, message string) error {
+	return newMixedServiceError(MixedServiceErrCodeUnauthenticated, method, message, nil)
+}
+
+// NewMixedServiceInternalError creates a new internal error
+func NewMixedServiceInternalError(method, message string) error {
+	return newMixedServiceError(MixedServiceErrCodeInternal, method, message, nil)
+}
+
+// NewMixedServiceUnavailableError creates a new unavailable error
+func NewMixedServiceUnavailableError(method, message string) error {
+	return newMixedServiceError(MixedServiceErrCodeUnavailable, method, message, nil)
+}
+
+// MixedServiceNats is the NATS service interface for MixedService
+type MixedServiceNats interface {
+	Echo(context.Context, *EchoRequest) (*EchoResponse, error)
+	Webhook(context.Context, *EchoRequest) (*EchoResponse, error)
+}
+
+// MixedServiceEndpointInfo describes a service endpoint
+type MixedServiceEndpointInfo struct {
+	Name           string `json:"name"`                       // Method name (e.g., "CreateProduct")
+	Subject        string `json:"subject"`                    // NATS subject (e.g., "api.v1.create_product")
+	QueueGroup     string `json:"queue_group,omitempty"`      // Queue group the endpoint joined (server only)
+	MaxRequestSize int    `json:"max_request_size,omitempty"` // Request payload limit in bytes (server only, 0 = unlimited)
+}
+
+// MixedServiceService is the interface for the registered NATS micro service
+// This interface allows for easier dependency injection and testing
+type MixedServiceService interface {
+	micro.Service
+	Endpoints() []MixedServiceEndpointInfo
+	// Drain stops accepting requests, waits until in-flight handlers finish or
+	// ctx ends, and stops the service
+	Drain(ctx context.Context) error
+	// InterceptorChain names the interceptors a method's requests pass through,
+	// outermost first
+	InterceptorChain(method string) []string
+	// Reconfigure changes the settings that can change while the service runs,
+	// currently WithSampling and WithSamplingSalt
+	Reconfigure(opts ...RegisterOption) error
+}
+
+// mixedServiceService is the concrete implementation of MixedServiceService
+type mixedServiceService struct {
+	micro.Service
+	subjectPrefix  string
+	queueGroup     string
+	maxRequestSize int
+	inflight       *inflightTracker
+	interceptors   []string // Names of the service-wide interceptors, outermost first
+	sampler        *sampler // Shared with the handlers, for Reconfigure
+}
+
+// Endpoints returns information about all service endpoints
+// This is useful for debugging, monitoring, and service discovery
+func (s *mixedServiceService) Endpoints() []MixedServiceEndpointInfo {
+	return []MixedServiceEndpointInfo{
+		{Name: "Echo", Subject: s.subjectPrefix + ".echo", QueueGroup: s.queueGroup, MaxRequestSize: s.maxRequestSize},
+		{Name: "Webhook", Subject: s.subjectPrefix + ".webhook", QueueGroup: s.queueGroup, MaxRequestSize: s.maxRequestSize},
+	}
+}
+
+// init lists the methods of MixedService in MethodDescriptors
+func init() {
+	MethodDescriptors["demo.v1.MixedService.Echo"] = MethodDescriptor{
+		Service: "MixedService",
+		Method:  "Echo",
+		Subject: "demo.mixed.echo",
+	}
+	MethodDescriptors["demo.v1.MixedService.Webhook"] = MethodDescriptor{
+		Service: "MixedService",
+		Method:  "Webhook",
+		Subject: "demo.mixed.webhook",
+	}
+}
+
+// MixedServiceSubjects maps each method of MixedService to its subject under
+// the default prefix. Clients and services of every language are generated from
+// the same table.
+var MixedServiceSubjects = map[string]string{
+	"Echo":    "demo.mixed.echo",
+	"Webhook": "demo.mixed.webhook",
+}
+
+// Drain unsubscribes every endpoint, so new requests get no responders, then waits
+// for in-flight handlers, and requests already delivered to the endpoints, to
+// finish before returning. Server and bidi streams are
+// sent a GOAWAY, which clients see as an *ErrServerDraining, and streaming handlers
+// have their context canceled right away, or after WithStreamDrainGrace, so
+// long-running streams can end cleanly. If ctx ends first, the remaining handlers'
+// contexts are canceled and ctx.Err() is returned. The service is stopped either way.
+// Drain fails if nats.go's micro left an endpoint subscribed; see stopEndpoints.
+func (s *mixedServiceService) Drain(ctx context.Context) error {
+	return s.inflight.drain(ctx, func() error { return stopEndpoints(s.Service) })
+}
+
+// InterceptorChain returns the names of the interceptors that requests to
+// method, a Go method name, pass through, outermost first: the service's
+// interceptors in the order they were added, then the method's
+// (natsmicro.endpoint).middlewares. Interceptors are named with Named, or else
+// by their function name.
+func (s *mixedServiceService) InterceptorChain(method string) []string {
+	chain := append([]string(nil), s.interceptors...)
+	return chain
+}
+
+// Reconfigure replaces the service's WithSampling and WithSamplingSalt settings
+// with those in opts, so sampling can be turned up, down or off without
+// re-registering; leaving WithSampling out stops sampling. Any other option is
+// rejected, as it only takes effect at registration.
+func (s *mixedServiceService) Reconfigure(opts ...RegisterOption) error {
+	cfg := &registerConfig{}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	return reconfigureSampling(s.sampler, cfg)
+}
+
+// MixedServiceSchemaHash identifies the schema MixedService was generated from.
+// Services advertise it as schema_hash INFO metadata; see SchemaHash.
+const MixedServiceSchemaHash = "sha256:3753ccc4b7602961c61c214c221a99d99ceaedee13a79c980c2d3a5f05f011a2"
+
+// mixedServiceSchema is the FileDescriptorSet MixedService serves from its
+// $reflect endpoint. The schema endpoint metadata is read from it too, so the two
+// always agree.
+const mixedServiceSchema = "\n\xed\n\n\x16demo/v1/encoding.proto\x12\ademo.v1\x1a\x1cgoogle/api/annotations.proto\x1a\x17natsmicro/options.proto\"E\n\vEchoRequest\x12\x18\n\amessage\x18\x01 \x01(\tR\amessage\x12\x1c\n\ttimestamp\x18\x02 \x01(\x03R\ttimestamp\"b\n\fEchoResponse\x12\x18\n\amessage\x18\x01 \x01(\tR\amessage\x12\x1c\n\ttimestamp\x18\x02 \x01(\x03R\ttimestamp\x12\x1a\n\bencoding\x18\x03 \x01(\tR\bencoding\" \n\x0eGetUserRequest\x12\x0e\n\x02id\x18\x01 \x01(\tR\x02id\"4\n\x0fGetUserResponse\x12!\n\x04user\x18\x01 \x01(\v2\r.demo.v1.UserR\x04user\"\xcc\x01\n\x04User\x12\x0e\n\x02id\x18\x01 \x01(\tR\x02id\x12\x12\n\x04name\x18\x02 \x01(\tR\x04name\x12\x14\n\x05email\x18\x03 \x01(\tR\x05email\x12\x14\n\x05roles\x18\x04 \x03(\tR\x05roles\x127\n\bmetadata\x18\x05 \x03(\v2\x1b.demo.v1.User.MetadataEntryR\bmetadata\x1a;\n\rMetadataEntry\x12\x10\n\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n\x05value\x18\x02 \x01(\tR\x05value:\x028\x012\x93\x02\n\vJSONService\x12M\n\x04Echo\x12\x14.demo.v1.EchoRequest\x1a\x15.demo.v1.EchoResponse\"\x18\x82\xd3\xe4\x93\x02\x12:\x01*\"\r/v1/json/echo\x12Y\n\aGetUser\x12\x17.demo.v1.GetUserRequest\x1a\x18.demo.v1.GetUserResponse\"\x1b\x82\xd3\xe4\x93\x02\x15\x12\x13/v1/json/users/{id}\x1aZ\x8a\xb5\x18V\n\tdemo.json\x12\fjson_service\x1a\x051.0.0\" Demo service using JSON encoding*\x10\n\bencoding\x12\x04json@\x012\xa8\x02\n\rBinaryService\x12O\n\x04Echo\x12\x14.demo.v1.EchoRequest\x1a\x15.demo.v1.EchoResponse\"\x1a\x82\xd3\xe4\x93\x02\x14:\x01*\"\x0f/v1/binary/echo\x12[\n\aGetUser\x12\x17.demo.v1.GetUserRequest\x1a\x18.demo.v1.GetUserResponse\"\x1d\x82\xd3\xe4\x93\x02\x17\x12\x15/v1/binary/users/{id}\x1ai\x8a\xb5\x18e\n\vdemo.binary\x12\x0ebinary_service\x1a\x051.0.0\"+Demo service using binary protobuf encoding*\x12\n\bencoding\x12\x06binary2\xe0\x01\n\fMixedService\x125\n\x04Echo\x12\x14.demo.v1.EchoRequest\x1a\x15.demo.v1.EchoResponse\"\x00\x12B\n\aWebhook\x12\x14.demo.v1.EchoRequest\x1a\x15.demo.v1.EchoResponse\"\n\x92\xb5\x18\x06B\x04json\x1aU\x8a\xb5\x18Q\n\ndemo.mixed\x12\rmixed_service\x1a\x051.0.0\"-Demo service mixing binary and JSON endpointsB\x15Z\x13example/gen/demo/v1b\x06proto3"
+
+// mixedServiceSchemaDocument is the JSON MixedService serves from its
+// $schema endpoint, before registration fills in its name, version and subjects
+const mixedServiceSchemaDocument = "{\"service\":\"demo.v1.MixedService\",\"schema_hash\":\"sha256:3753ccc4b7602961c61c214c221a99d99ceaedee13a79c980c2d3a5f05f011a2\",\"methods\":[{\"name\":\"Echo\",\"endpoint\":\"echo\",\"streaming\":\"unary\",\"request_type\":\"demo.v1.EchoRequest\",\"response_type\":\"demo.v1.EchoResponse\"},{\"name\":\"Webhook\",\"endpoint\":\"webhook\",\"streaming\":\"unary\",\"request_type\":\"demo.v1.EchoRequest\",\"response_type\":\"demo.v1.EchoResponse\"}],\"descriptor\":\"H4sIAAAAAAAC/6SUz27TThDHf3bSppk0bbVtfrXcIhmfqiLsNoBA5YIiKiSkUpGqIMoBbeIhXRrvGq8TtUJcOPZIX4IXyFtw4wU4cOUd0K7t1AlcCJfY8++7M5/ZGH4C/B9gKPzhro+8KwLGe14Ui0SQivJ7w117sydEr48+jZhPORcJTZjgMk2z1zlNZMi6sfBFVAi4+1Db756KNr4foEyIBZUQpaQ9tAzH2Kq2c5NsQjVhIcqEhpFlOsZWqX3tcDuwmMrISHCJs+oQGxbyAa2SLhzbrgNLTzA5lhjn3S6ByYLsAJMF7l1YHmdkjdyE8kBirJNqzbqX4fJ0kg65Xw0oK3NajhAocxqi7rLa1u9kDeYwpKyfdZcayhuLPkqr7JSUVxvkPiyEmNCAJtSac0pbtebGxPneQRbd50l80R4n2w+hPhEiK1A6w4usNfWqThzS/iBvLjX2zAdG88qE2tOjw2dHGA9ZF8kBlNVqyNr47MLC7caUN8XmWp++/bgyyZ6x7dbVrXsnBfdRybyCSsaYrI9rJ/diW78HMt0Nrdsgq2NRtQLpf2DBR/vkcmS9gKquVTGyqH7fyHQQe27X2/F2XOcxhsLJnM5AMt5z1LxOflG2V64vESkriUdG84sJ9RbjNL7IuRzOwsXW/a8pLstqhI6WTMm8/icyN7TyOmkUZAts2OXIQqjp6jRKltLnNJ9bf+CTZjr6L98ZvL1GRQqo5tOs5ncDFg/YOQY5qXuzkPqPtKDyEjunQpz9XSV8HlnzLb04+/hyZD0H0Hmh6onU9WN66NsTQ4fsvDA15UF+QYJIMJ7IVuNkFc9pGPXR7yH3s29rZ14DuvNrAG2CaTlwBQAA\"}"
+
+// RegisterMixedServiceHandlers registers the service with NATS micro handlers
+// Service: mixed_service v1.0.0
+// Description: Demo service mixing binary and JSON endpoints
+// Subject prefix: demo.mixed
+// Configuration options: WithName(), WithVersion(), WithDescription(), WithSubjectPrefix(), WithTimeout(), WithQueueGroup(), WithMaxRequestSize()
+// Metadata options: WithMetadata() (replace), WithAdditionalMetadata() (merge), WithEndpointMetadata() (per method)
+// Handler options: WithStatsHandler(), WithDoneHandler(), WithErrorHandler()
+// Interceptors: WithServerInterceptor() appends, WithServerInterceptorChain() replaces; the first runs outermost
+// Stream interceptors: WithServerStreamInterceptor()
+// Grouping: WithServiceGroup() registers on a ServiceGroup shared with other services
+// Existing services: AddMixedServiceEndpoints() registers on a micro.Service added elsewhere
+//
+// Endpoint metadata can be configured via proto options (nats.micro.endpoint).metadata
+func RegisterMixedServiceHandlers(nc *nats.Conn, impl MixedServiceNats, opts ...RegisterOption) (_ MixedServiceService, err error) {
+	cfg := &registerConfig{
+		name:           "mixed_service",
+		version:        "1.0.0",
+		description:    "Demo service mixing binary and JSON endpoints",
+		subjectPrefix:  "demo.mixed",
+		queueGroup:     "",
+		timeout:        0 * time.Second, // Service-level timeout (0 = no timeout)
+		tokenSanitizer: SanitizeToken,
+		streamWindow:   defaultStreamWindow,
+		metadata:       map[string]string{},
+	}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	return registerMixedServiceEndpoints(nc, impl, cfg, func() (*serviceHost, bool, error) {
+		// Register on the ServiceGroup, or add a micro.Service for this service
+		if cfg.group != nil {
+			return cfg.group.host, true, nil
+		}
+		host, err := addServiceHost(nc, cfg, mergeMetadata(cfg.metadata, map[string]string{"schema_hash": MixedServiceSchemaHash}))
+		return host, false, err
+	})
+}
+
+// AddMixedServiceEndpoints registers the MixedService endpoints on svc, a
+// micro.Service the caller added on nc, so they share its name, version and stats
+// with the endpoints of other services and the caller's own:
+//
+//	svc, err := micro.AddService(nc, micro.Config{Name: "catalog", Version: "1.0.0"})
+//	...
+//	err = AddMixedServiceEndpoints(nc, svc, impl, WithServerInterceptor(auth))
+//
+// As on a ServiceGroup, endpoint names are qualified with the service name
+// ("mixed_service-health") while subjects keep the subject prefix, and the
+// schema_hash is advertised as endpoint metadata. Options of the micro.Service
+// itself, which NewServiceGroup lists, are rejected with an error, as is
+// WithServiceGroup. Stopping svc stops the endpoints; there is no Drain.
+func AddMixedServiceEndpoints(nc *nats.Conn, svc micro.Service, impl MixedServiceNats, opts ...RegisterOption) error {
+	cfg := &registerConfig{
+		subjectPrefix:  "demo.mixed",
+		queueGroup:     "",
+		timeout:        0 * time.Second, // Service-level timeout (0 = no timeout)
+		tokenSanitizer: SanitizeToken,
+		streamWindow:   defaultStreamWindow,
+		metadata:       map[string]string{},
+	}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	host, err := existingServiceHost(nc, svc, cfg)
+	if err != nil {
+		return err
+	}
+	// The subjects and $schema document describe the proto service
+	cfg.name, cfg.version = "mixed_service", "1.0.0"
+	_, err = registerMixedServiceEndpoints(nc, impl, cfg, func() (*serviceHost, bool, error) {
+		return host, true, nil
+	})
+	return err
+}
+
+// registerMixedServiceEndpoints registers the MixedService endpoints cfg describes
+// on the host attach returns, once the configuration checks out. Endpoint names
+// are qualified when attach reports the host is shared with other services.
+func registerMixedServiceEndpoints(nc *nats.Conn, impl MixedServiceNats, cfg *registerConfig, attach func() (*serviceHost, bool, error)) (_ MixedServiceService, err error) {
+
+	// Describe each endpoint from the schema blob
+	schemaMetadata, err := schemaEndpointMetadata(mixedServiceSchema, "demo.v1.MixedService")
+	if err != nil {
+		return nil, err
+	}
+	endpointMethods := map[string]bool{
+		"Echo":    true,
+		"Webhook": true,
+	}
+	for method := range cfg.endpointMetadata {
+		if !endpointMethods[method] {
+			return nil, fmt.Errorf("WithEndpointMetadata: MixedService has no method %s", method)
+		}
+	}
+
+	// Streams are compressed with gzip unless WithCompression names another codec
+	compressor := compressorFor(streamEncodingGzip)
+	if cfg.compressionCodec != "" {
+		if compressor = compressorFor(cfg.compressionCodec); compressor == nil {
+			return nil, fmt.Errorf("WithCompression: codec %q is not registered; add it with RegisterCompressor", cfg.compressionCodec)
+		}
+	}
+
+	host, shared, err := attach()
+	if err != nil {
+		return nil, err
+	}
+	endpointPrefix := "" // Qualifies endpoint names on a shared micro.Service
+	if shared {
+		endpointPrefix = "mixed_service-"
+	} else {
+		// Stop the micro.Service again if an endpoint fails to register
+		defer func() {
+			if err != nil {
+				host.svc.Stop()
+			}
+		}()
+	}
+	svc, pool := host.svc, host.pool
+
+	// Chain server interceptors
+	var chainedInterceptor UnaryServerInterceptor
+	if len(cfg.serverInterceptors) > 0 {
+		chainedInterceptor = chainUnaryServerInterceptors(cfg.serverInterceptors)
+	}
+
+	handlers := &mixedServiceHandlers{
+		nc:                nc,
+		impl:              impl,
+		serviceTimeout:    cfg.timeout,
+		useJSON:           false,
+		interceptor:       chainedInterceptor,
+		js:                cfg.js,
+		cancels:           host.cancels,
+		tokenSanitizer:    cfg.tokenSanitizer,
+		idGenerator:       cfg.idGenerator,
+		maxRequestSize:    cfg.maxRequestSize,
+		streamWindow:      cfg.streamWindow,
+		streamAllowGaps:   cfg.streamAllowGaps,
+		streamCompression: cfg.streamCompression,
+		compression:       cfg.compression,
+		compressor:        compressor,
+		responseHeaders:   cfg.responseHeaders,
+		requestCheck:      cfg.requestCheck,
+		streamInterceptor: chainStreamServerInterceptors(cfg.streamInterceptors),
+		endpointPrefix:    endpointPrefix,
+		inflight:          host.inflight,
+		sampler:           newSampler(cfg.sampling, cfg.samplingSalt),
+		maxServerDeadline: cfg.maxServerDeadline,
+		persistenceErrors: cfg.persistenceErrors,
+		panics:            cfg.panicDiagnostics,
+		failures:          newFailureRecorder(cfg),
+	}
+
+	// Map of endpoint names to their handlers; unary ones go through the handler pool,
+	// idempotent ones are coalesced first, and those expecting a response are
+	// deduplicated by idempotency key inside the pool
+	idempotency := newIdempotencyGuard(cfg)
+	endpoints := map[string]micro.Handler{
+
+		"echo": pool.wrap(endpointPrefix+"echo", idempotency.wrap(endpointPrefix+"echo", micro.HandlerFunc(handlers.Echo))),
+
+		"webhook": pool.wrap(endpointPrefix+"webhook", idempotency.wrap(endpointPrefix+"webhook", micro.HandlerFunc(handlers.Webhook))),
+	}
+
+	// Map of endpoint names to their metadata: the schema's description of the
+	// method, overlaid with (natsmicro.endpoint).metadata, then WithEndpointMetadata
+	endpointMetadata := map[string]map[string]string{
+
+		"echo": mergeMetadata(mergeMetadata(schemaMetadata["Echo"], map[string]string{}), cfg.endpointMetadata["Echo"]),
+
+		"webhook": mergeMetadata(mergeMetadata(schemaMetadata["Webhook"], map[string]string{}), cfg.endpointMetadata["Webhook"]),
+	}
+
+	// Map of endpoint names to exact subjects from (natsmicro.endpoint).subject,
+	// or the wildcard form of subject_template
+	// These endpoints are registered outside the subject prefix group
+	endpointSubjects := map[string]string{}
+
+	// Endpoints WithInstanceSubjects also serves on <subject>.<instance ID>
+	instanceEndpoints := map[string]bool{
+		"echo":    true,
+		"webhook": true,
+	}
+
+	// Use interface to handle both Service and Group
+	type endpointAdder interface {
+		AddEndpoint(string, micro.Handler, ...micro.EndpointOpt) error
+	}
+
+	var group endpointAdder = svc
+	if cfg.subjectPrefix != "" {
+		group = svc.AddGroup(cfg.subjectPrefix)
+	}
+
+	// Register all endpoints with their metadata
+	for name, handler := range endpoints {
+		opts := []micro.EndpointOpt{}
+		metadata := endpointMetadata[name]
+		if cfg.maxRequestSize > 0 {
+			// Advertise the request size limit alongside the proto metadata
+			withLimit := map[string]string{"max_request_size": fmt.Sprint(cfg.maxRequestSize)}
+			for k, v := range metadata {
+				withLimit[k] = v
+			}
+			metadata = withLimit
+		}
+		if shared {
+			// Keep the unqualified subject; the shared service's metadata cannot
+			// carry every service's schema hash
+			opts = append(opts, micro.WithEndpointSubject(name))
+			metadata = mergeMetadata(metadata, map[string]string{"schema_hash": MixedServiceSchemaHash})
+			if cfg.queueGroup != "" {
+				opts = append(opts, micro.WithEndpointQueueGroup(cfg.queueGroup))
+			}
+		}
+		if len(metadata) > 0 {
+			opts = append(opts, micro.WithEndpointMetadata(metadata))
+		}
+		adder := group
+		subject := name
+		if exact, exists := endpointSubjects[name]; exists {
+			adder = svc
+			subject = exact
+			opts = append(opts, micro.WithEndpointSubject(subject))
+		}
+		if cfg.instanceSubjects && instanceEndpoints[name] {
+			// Responses name the instance, for ResponderInstance
+			handler = respondAsInstance(svc.Info().ID, handler)
+		}
+		if err := adder.AddEndpoint(endpointPrefix+name, holdRequests(cfg.hold, handler), opts...); err != nil {
+			return nil, fmt.Errorf("failed to add endpoint %s: %w", name, err)
+		}
+		if cfg.instanceSubjects && instanceEndpoints[name] {
+			// The same handler on this instance's own subject, for WithTargetInstance
+			opts = append(opts, micro.WithEndpointSubject(subject+"."+svc.Info().ID))
+			if err := adder.AddEndpoint(endpointPrefix+name+"-instance", holdRequests(cfg.hold, handler), opts...); err != nil {
+				return nil, fmt.Errorf("failed to add instance endpoint %s: %w", name, err)
+			}
+		}
+	}
+
+	pool.start()
+
+	// Application health endpoint, registered outside the subject prefix group
+	if !cfg.noHealthEndpoint {
+		subject := healthSubject(cfg.subjectPrefix, "mixed_service")
+		if err := svc.AddEndpoint(endpointPrefix+"health", holdRequests(cfg.hold, newHealthHandler(impl, cfg.timeout)), micro.WithEndpointSubject(subject)); err != nil {
+			return nil, fmt.Errorf("failed to add health endpoint: %w", err)
+		}
+	}
+
+	// Schema reflection endpoint, registered outside the subject prefix group
+	if !cfg.noReflectEndpoint {
+		subject := reflectSubject(cfg.subjectPrefix, "mixed_service")
+		if err := svc.AddEndpoint(endpointPrefix+"reflect", holdRequests(cfg.hold, newReflectHandler(mixedServiceSchema, MixedServiceSchemaHash)), micro.WithEndpointSubject(subject)); err != nil {
+			return nil, fmt.Errorf("failed to add reflect endpoint: %w", err)
+		}
+	}
+
+	// Schema document endpoint, registered outside the subject prefix group
+	if !cfg.noSchemaEndpoint {
+		handler, err := newSchemaHandler(mixedServiceSchemaDocument, cfg)
+		if err != nil {
+			return nil, err
+		}
+		subject := schemaSubject(cfg.subjectPrefix, "mixed_service")
+		if err := svc.AddEndpoint(endpointPrefix+"schema", holdRequests(cfg.hold, handler), micro.WithEndpointSubject(subject)); err != nil {
+			return nil, fmt.Errorf("failed to add schema endpoint: %w", err)
+		}
+	}
+
+	queueGroup := cfg.queueGroup
+	if queueGroup == "" {
+		queueGroup = micro.DefaultQueueGroup
+	}
+
+	return &mixedServiceService{
+		Service:        svc,
+		subjectPrefix:  cfg.subjectPrefix,
+		queueGroup:     queueGroup,
+		maxRequestSize: cfg.maxRequestSize,
+		inflight:       handlers.inflight,
+		interceptors:   interceptorChainNames(cfg.serverInterceptors),
+		sampler:        handlers.sampler,
+	}, nil
+}
+
+// MixedServiceRegistration describes a RegisterMixedServiceHandlers call for
+// RegisterGroup, which registers it together with other services, all or nothing
+func MixedServiceRegistration(impl MixedServiceNats, opts ...RegisterOption) Registration {
+	return registrationFunc(func(nc *nats.Conn, hold func(context.Context) error) (GroupService, error) {
+		return RegisterMixedServiceHandlers(nc, impl, append(opts[:len(opts):len(opts)], withRequestHold(hold))...)
+	})
+}
+
+// mixedServiceHandlers wraps the service implementation with NATS handlers
+type mixedServiceHandlers struct {
+	nc                 *nats.Conn // NATS connection for streaming
+	impl               MixedServiceNats
+	serviceTimeout     time.Duration                     // Default timeout for all endpoints
+	useJSON            bool                              // Use JSON encoding instead of binary protobuf
+	interceptor        UnaryServerInterceptor            // Chained interceptors
+	methodInterceptors map[string]UnaryServerInterceptor // Interceptors plus (natsmicro.endpoint).middlewares, by method
+	js                 jetstream.JetStream               // Optional JetStream context for KV/ObjectStore
+	cancels            *cancelRegistry                   // Client cancel notices (nil unless WithCancelPropagation)
+	tokenSanitizer     func(string) string               // Escapes request fields in KV/Object Store keys
+	idGenerator        func() string                     // Mints stream inbox and persistent call IDs (nil = NUIDs)
+	maxRequestSize     int                               // Largest accepted request payload in bytes (0 = unlimited)
+	inflight           *inflightTracker                  // Running handlers, for Drain
+	persistentStreams  map[string]*persistentStream      // (natsmicro.stream).persistence streams, by method
+	streamWindow       int                               // Server-stream flow control cap (0 = none)
+	streamCompression  int                               // Smallest stream message compressed, once negotiated (0 = off)
+	compression        int                               // Smallest unary response compressed, if the client accepts it (0 = off)
+	compressor         Compressor                        // Codec for responses and stream messages
+	streamAllowGaps    bool                              // Skip lost client-stream messages instead of failing Recv
+	responseHeaders    *headerPolicy                     // Strips response headers (nil = allow all)
+	requestCheck       *requestImmutabilityCheck         // Reports handlers that modify their request (nil = off)
+	streamInterceptor  StreamServerInterceptor           // Chained stream interceptors
+	endpointPrefix     string                            // Qualifies endpoint names within a ServiceGroup
+	sampler            *sampler                          // Samples unary calls (WithSampling)
+	maxServerDeadline  time.Duration                     // Cap on propagated client deadlines (0 = none)
+	persistenceErrors  func(method string, err error)    // Receives failed KV/Object Store writes (nil = print)
+	panics             *panicDiagnostics                 // Recovers and reports handler panics (nil = off)
+	failures           *failureRecorder                  // Records failed calls for replay (nil = off)
+}
+
+// keyToken renders a request field for a key template through the token sanitizer
+func (h *mixedServiceHandlers) keyToken(v any) string {
+	return h.tokenSanitizer(fmt.Sprint(v))
+}
+
+func (h *mixedServiceHandlers) Echo(req micro.Request) {
+	// Determine effective timeout: endpoint-specific timeout overrides service timeout
+	// If endpoint timeout is set (> 0), use it; otherwise use service timeout
+	timeout := h.serviceTimeout
+
+	// Create context with timeout if configured, derived from the service's drain context
+	ctx, end := h.inflight.begin(false) // Tracked for Drain
+	defer end()
+	var cancel context.CancelFunc
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	// Stop when the client gives up: honor the deadline it propagated
+	ctx, cancelDeadline := withPropagatedDeadline(ctx, req.Headers(), h.maxServerDeadline)
+	defer cancelDeadline()
+
+	// Cancel the handler early if the client abandons the request
+	if h.cancels != nil {
+		var release func()
+		ctx, release = h.cancels.watch(ctx, req.Headers())
+		defer release()
+	}
+
+	// Add incoming NATS headers to context so service methods can access them
+	if req.Headers() != nil {
+		ctx = WithIncomingHeaders(ctx, req.Headers())
+	}
+
+	// Initialize outgoing headers pointer in context so interceptors can set response headers
+	outgoingHeadersPtr := &nats.Header{}
+	ctx = withPendingResponseHeaders(ctx, outgoingHeadersPtr)
+
+	// Reject oversized requests before decoding them, decompressing compressed ones
+	body, payloadErr := readPayload("request", req.Headers().Get(ContentEncodingHeader), req.Data(), h.maxRequestSize)
+	if payloadErr != nil {
+		code, message, data := natsErrorFields(payloadErr)
+		req.Error(code, message, data)
+		return
+	}
+
+	// Decode with the codec the client named; clients without Content-Type use the configured one
+	requestJSON, ctErr := payloadUsesJSON(req.Headers().Get(ContentTypeHeader), h.useJSON)
+	if ctErr != nil {
+		code, message, data := natsErrorFields(ctErr)
+		req.Error(code, message, data)
+		return
+	}
+	var msg EchoRequest
+	if requestJSON {
+		if err := protojson.Unmarshal(body, &msg); err != nil {
+			req.Error(MixedServiceErrCodeInvalidArgument, fmt.Sprintf("failed to decode JSON request: %v", err), nil)
+			return
+		}
+	} else {
+		if err := proto.Unmarshal(body, &msg); err != nil {
+			req.Error(MixedServiceErrCodeInvalidArgument, fmt.Sprintf("failed to decode request: %v", err), nil)
+			return
+		}
+	}
+
+	// Sample the call for WithSampling, capturing the request before the handler runs
+	sample := h.sampler.begin("MixedService", "Echo", incomingRequestID(req), false, nats.Header(req.Headers()), &msg, false)
+
+	// Define the handler function
+	handler := func(ctx context.Context, request interface{}) (interface{}, error) {
+		typedReq, ok := request.(*EchoRequest)
+		if !ok {
+			return nil, fmt.Errorf("invalid request type")
+		}
+		defer h.requestCheck.check("MixedService.Echo", typedReq)()
+		return h.impl.Echo(ctx, typedReq)
+	}
+	handler = h.panics.wrap("MixedService", "Echo", "demo.v1.MixedService.Echo", false, handler)
+	handler = h.failures.wrap("MixedService", "Echo", "demo.v1.MixedService.Echo", false, handler)
+
+	// Execute through interceptor chain if configured
+	var resp interface{}
+	var err error
+	interceptor := h.interceptor
+	if interceptor != nil {
+		info := &UnaryServerInfo{
+			Service:    "MixedService",
+			Method:     "Echo",
+			FullMethod: "demo.v1.MixedService.Echo",
+			Subject:    "demo.mixed.echo",
+		}
+		resp, err = interceptor(ctx, &msg, info, handler)
+	} else {
+		resp, err = handler(ctx, &msg)
+	}
+	sample.end(resp, err)
+	if err != nil {
+		code, message, data := natsErrorFields(err)
+		req.Error(code, message, data)
+		return
+	}
+
+	// Type assert response back to the expected type
+	typedResp, ok := resp.(*EchoResponse)
+	if !ok {
+		req.Error(MixedServiceErrCodeInternal, "invalid response type from handler", nil)
+		return
+	}
+
+	var data []byte
+	if h.useJSON {
+		data, err = marshalJSON(typedResp, false)
+		if err != nil {
+			req.Error(MixedServiceErrCodeInternal, fmt.Sprintf("failed to encode JSON response: %v", err), nil)
+			return
+		}
+	} else {
+		data, err = proto.Marshal(typedResp)
+		if err != nil {
+			req.Error(MixedServiceErrCodeInternal, fmt.Sprintf("failed to encode response: %v", err), nil)
+			return
+		}
+	}
+
+	// Check if context has outgoing headers set by interceptors
+	// Read from the pointer that was initialized at the start
+	outgoingHeaders := pendingResponseHeaders(ctx)
+
+	// Send response with headers set by interceptors plus the response codec
+	// Convert nats.Header to micro.Headers (they are the same underlying type)
+	outgoingHeaders = h.responseHeaders.strip(h.endpointPrefix+"echo", outgoingHeaders)
+	outgoingHeaders = withContentType(outgoingHeaders, h.useJSON)
+	if h.compression > 0 && acceptsEncoding(req.Headers().Get(acceptEncodingHeader), h.compressor.Name()) {
+		data = compressPayload(h.compressor, h.compression, data, outgoingHeaders)
+	}
+	if err := req.Respond(data, micro.WithHeaders(micro.Headers(outgoingHeaders))); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to send response for Echo: %v\n", err)
+	}
+}
+
+func (h *mixedServiceHandlers) Webhook(req micro.Request) {
+	useJSON := true // (natsmicro.endpoint).encoding
+	// Determine effective timeout: endpoint-specific timeout overrides service timeout
+	// If endpoint timeout is set (> 0), use it; otherwise use service timeout
+	timeout := h.serviceTimeout
+
+	// Create context with timeout if configured, derived from the service's drain context
+	ctx, end := h.inflight.begin(false) // Tracked for Drain
+	defer end()
+	var cancel context.CancelFunc
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	// Stop when the client gives up: honor the deadline it propagated
+	ctx, cancelDeadline := withPropagatedDeadline(ctx, req.Headers(), h.maxServerDeadline)
+	defer cancelDeadline()
+
+	// Cancel the handler early if the client abandons the request
+	if h.cancels != nil {
+		var release func()
+		ctx, release = h.cancels.watch(ctx, req.Headers())
+		defer release()
+	}
+
+	// Add incoming NATS headers to context so service methods can access them
+	if req.Headers() != nil {
+		ctx = WithIncomingHeaders(ctx, req.Headers())
+	}
+
+	// Initialize outgoing headers pointer in context so interceptors can set response headers
+	outgoingHeadersPtr := &nats.Header{}
+	ctx = withPendingResponseHeaders(ctx, outgoingHeadersPtr)
+
+	// Reject oversized requests before decoding them, decompressing compressed ones
+	body, payloadErr := readPayload("request", req.Headers().Get(ContentEncodingHeader), req.Data(), h.maxRequestSize)
+	if payloadErr != nil {
+		code, message, data := natsErrorFields(payloadErr)
+		req.Error(code, message, data)
+		return
+	}
+
+	// Decode with the codec the client named; clients without Content-Type use the configured one
+	requestJSON, ctErr := payloadUsesJSON(req.Headers().Get(ContentTypeHeader), useJSON)
+	if ctErr != nil {
+		code, message, data := natsErrorFields(ctErr)
+		req.Error(code, message, data)
+		return
+	}
+	var msg EchoRequest
+	if requestJSON {
+		if err := protojson.Unmarshal(body, &msg); err != nil {
+			req.Error(MixedServiceErrCodeInvalidArgument, fmt.Sprintf("failed to decode JSON request: %v", err), nil)
+			return
+		}
+	} else {
+		if err := proto.Unmarshal(body, &msg); err != nil {
+			req.Error(MixedServiceErrCodeInvalidArgument, fmt.Sprintf("failed to decode request: %v", err), nil)
+			return
+		}
+	}
+
+	// Sample the call for WithSampling, capturing the request before the handler runs
+	sample := h.sampler.begin("MixedService", "Webhook", incomingRequestID(req), false, nats.Header(req.Headers()), &msg, false)
+
+	// Define the handler function
+	handler := func(ctx context.Context, request interface{}) (interface{}, error) {
+		typedReq, ok := request.(*EchoRequest)
+		if !ok {
+			return nil, fmt.Errorf("invalid request type")
+		}
+		defer h.requestCheck.check("MixedService.Webhook", typedReq)()
+		return h.impl.Webhook(ctx, typedReq)
+	}
+	handler = h.panics.wrap("MixedService", "Webhook", "demo.v1.MixedService.Webhook", false, handler)
+	handler = h.failures.wrap("MixedService", "Webhook", "demo.v1.MixedService.Webhook", false, handler)
+
+	// Execute through interceptor chain if configured
+	var resp interface{}
+	var err error
+	interceptor := h.interceptor
+	if interceptor != nil {
+		info := &UnaryServerInfo{
+			Service:    "MixedService",
+			Method:     "Webhook",
+			FullMethod: "demo.v1.MixedService.Webhook",
+			Subject:    "demo.mixed.webhook",
+		}
+		resp, err = interceptor(ctx, &msg, info, handler)
+	} else {
+		resp, err = handler(ctx, &msg)
+	}
+	sample.end(resp, err)
+	if err != nil {
+		code, message, data := natsErrorFields(err)
+		req.Error(code, message, data)
+		return
+	}
+
+	// Type assert response back to the expected type
+	typedResp, ok := resp.(*EchoResponse)
+	if !ok {
+		req.Error(MixedServiceErrCodeInternal, "invalid response type from handler", nil)
+		return
+	}
+
+	var data []byte
+	if useJSON {
+		data, err = marshalJSON(typedResp, false)
+		if err != nil {
+			req.Error(MixedServiceErrCodeInternal, fmt.Sprintf("failed to encode JSON response: %v", err), nil)
+			return
+		}
+	} else {
+		data, err = proto.Marshal(typedResp)
+		if err != nil {
+			req.Error(MixedServiceErrCodeInternal, fmt.Sprintf("failed to encode response: %v", err), nil)
+			return
+		}
+	}
+
+	// Check if context has outgoing headers set by interceptors
+	// Read from the pointer that was initialized at the start
+	outgoingHeaders := pendingResponseHeaders(ctx)
+
+	// Send response with headers set by interceptors plus the response codec
+	// Convert nats.Header to micro.Headers (they are the same underlying type)
+	outgoingHeaders = h.responseHeaders.strip(h.endpointPrefix+"webhook", outgoingHeaders)
+	outgoingHeaders = withContentType(outgoingHeaders, useJSON)
+	if h.compression > 0 && acceptsEncoding(req.Headers().Get(acceptEncodingHeader), h.compressor.Name()) {
+		data = compressPayload(h.compressor, h.compression, data, outgoingHeaders)
+	}
+	if err := req.Respond(data, micro.WithHeaders(micro.Headers(outgoingHeaders))); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to send response for Webhook: %v\n", err)
+	}
+}
+
+// MixedServiceNatsClientInterface is the interface for the NATS client
+// This interface allows for easier dependency injection and testing
+type MixedServiceNatsClientInterface interface {
+	Echo(context.Context, *EchoRequest, ...CallOption) (*EchoResponse, error)
+	Webhook(context.Context, *EchoRequest, ...CallOption) (*EchoResponse, error)
+	Health(ctx context.Context) (*HealthResponse, error)
+	Schema(ctx context.Context) (*ServiceSchemaDocument, error)
+	Endpoints() []MixedServiceEndpointInfo
+	WithHeaders(headers nats.Header) MixedServiceNatsClientInterface
+	Close()
+}
+
+// MixedServiceNatsClient is the concrete implementation of MixedServiceNatsClientInterface
+type MixedServiceNatsClient struct {
+	nc                  *nats.Conn
+	subjectPrefix       string
+	useJSON             bool                    // Use JSON encoding instead of binary protobuf
+	interceptor         UnaryClientInterceptor  // Chained interceptors
+	streamInterceptor   StreamClientInterceptor // Chained stream interceptors
+	js                  jetstream.JetStream     // Optional JetStream for KV/ObjectStore reads
+	kvStaleAfter        time.Duration           // Age at which Get*Cached treats KV entries as misses (0 = never)
+	cancelPropagation   bool                    // Publish a cancel notice when ctx ends mid-request
+	timeout             time.Duration           // Default unary timeout when ctx has no deadline
+	retry               *retryPolicy            // Unary retry policy (nil = no retries)
+	tokenSanitizer      func(string) string     // Escapes request fields in key helpers
+	idGenerator         func() string           // Mints cancel subject and stream inbox IDs (nil = NUIDs)
+	maxResponseSize     int                     // Largest accepted response payload in bytes (0 = unlimited)
+	connSelector        func() *nats.Conn       // Picks the connection per call (nil = nc)
+	journal             *journal                // Records calls for replay (nil = no journal)
+	streamWindow        int                     // Server-stream flow control window (0 = none)
+	streamAllowGaps     bool                    // Skip lost stream messages instead of failing Recv
+	streamCompression   int                     // Smallest stream message compressed, once negotiated (0 = off)
+	compression         int                     // Smallest unary request compressed (0 = off)
+	compressionCodec    string                  // Codec unary requests are compressed with
+	streamResumeOnDrain bool                    // Reopen server streams when their server drains
+	headerPolicy        *headerPolicy           // Strips outgoing headers (nil = allow all)
+	sampler             *sampler                // Samples unary calls (WithClientSampling)
+	monitor             *connectionMonitor      // Samples the connections (WithClientConnectionMonitor)
+	headers             nats.Header             // Sent under each call's OutgoingHeaders (WithHeaders)
+}
+
+// compress returns a unary request payload compressed as WithClientCompression
+// asks, flagged in headers
+func (c *MixedServiceNatsClient) compress(data []byte, headers nats.Header) ([]byte, error) {
+	if c.compression == 0 {
+		return data, nil
+	}
+	codec := compressorFor(c.compressionCodec)
+	if codec == nil {
+		return nil, fmt.Errorf("WithClientCompression: codec %q is not registered; add it with RegisterCompressor", c.compressionCodec)
+	}
+	return compressPayload(codec, c.compression, data, headers), nil
+}
+
+// conn returns the connection for the next call or stream
+func (c *MixedServiceNatsClient) conn() *nats.Conn {
+	if c.connSelector != nil {
+		return c.connSelector()
+	}
+	return c.nc
+}
+
+// outgoingHeaders returns the headers of ctx that WithOutgoingHeaderPolicy lets a call send
+func (c *MixedServiceNatsClient) outgoingHeaders(ctx context.Context) nats.Header {
+	headers, _ := c.headerPolicy.filter(layerHeaders(c.headers, OutgoingHeaders(ctx)))
+	return headers
+}
+
+// WithHeaders returns a view of the client that sends headers with every call and
+// stream, beneath those set on the call's context with WithOutgoingHeaders. A view
+// shares the client's connections, interceptors, retry policy, journal and sampler
+// rather than copying them, so it is cheap to make per request, e.g. per tenant, and
+// like the client is safe for concurrent use. Views of views add to their parent's
+// headers. Closing a view does not stop the client's connection monitor.
+func (c *MixedServiceNatsClient) WithHeaders(headers nats.Header) MixedServiceNatsClientInterface {
+	layered := make(nats.Header, len(c.headers)+len(headers))
+	for name, values := range c.headers {
+		layered[name] = values
+	}
+	for name, values := range headers {
+		layered[name] = append([]string(nil), values...) // Later changes to headers stay out of the view
+	}
+	view := *c
+	view.headers = layered
+	view.monitor = nil
+	return &view
+}
+
+// keyToken renders a request field for a key template through the token sanitizer
+func (c *MixedServiceNatsClient) keyToken(v any) string {
+	return c.tokenSanitizer(fmt.Sprint(v))
+}
+
+// NewMixedServiceNatsClient creates a new NATS client for MixedService.
+// The client sends requests over NATS using protobuf serialization (or JSON if configured).
+func NewMixedServiceNatsClient(nc *nats.Conn, opts ...NatsClientOption) MixedServiceNatsClientInterface {
+	cfg := &natsClientConfig{
+		subjectPrefix:  "demo.mixed",
+		tokenSanitizer: SanitizeToken,
+		streamWindow:   defaultStreamWindow,
+	}
+	for _, opt := range opts {
+		opt.applyNatsClientOption(cfg)
+	}
+
+	// Chain client interceptors
+	var chainedInterceptor UnaryClientInterceptor
+	if len(cfg.clientInterceptors) > 0 {
+		chainedInterceptor = chainUnaryClientInterceptors(cfg.clientInterceptors)
+	}
+
+	c := &MixedServiceNatsClient{
+		nc:                  nc,
+		subjectPrefix:       cfg.subjectPrefix,
+		useJSON:             false,
+		interceptor:         chainedInterceptor,
+		streamInterceptor:   chainStreamClientInterceptors(cfg.streamInterceptors),
+		js:                  cfg.js,
+		kvStaleAfter:        cfg.kvStaleAfter,
+		cancelPropagation:   cfg.cancelPropagation,
+		timeout:             cfg.timeout,
+		retry:               newRetryPolicy(cfg),
+		tokenSanitizer:      cfg.tokenSanitizer,
+		idGenerator:         cfg.idGenerator,
+		maxResponseSize:     cfg.maxResponseSize,
+		connSelector:        cfg.connSelector,
+		journal:             cfg.journal,
+		streamWindow:        cfg.streamWindow,
+		streamAllowGaps:     cfg.streamAllowGaps,
+		streamCompression:   cfg.streamCompression,
+		compression:         cfg.compression,
+		compressionCodec:    cfg.compressionCodec,
+		streamResumeOnDrain: cfg.streamResumeOnDrain,
+		headerPolicy:        cfg.headerPolicy,
+		sampler:             newSampler(cfg.sampling, cfg.samplingSalt),
+		monitor:             startConnectionMonitor(cfg.connMonitor, monitoredConns(nc, cfg.poolConns)...),
+	}
+	return c
+}
+
+// NewMixedServiceNatsClientPool creates a MixedService client that spreads calls
+// over conns round-robin (see RoundRobinConns). Streams stay on the connection they
+// opened on. A WithConnSelector in opts replaces the round-robin selector.
+func NewMixedServiceNatsClientPool(conns []*nats.Conn, opts ...NatsClientOption) MixedServiceNatsClientInterface {
+	selector := RoundRobinConns(conns)
+	opts = append([]NatsClientOption{natsClientOptionFunc(func(c *natsClientConfig) { c.poolConns = conns })}, opts...)
+	return NewMixedServiceNatsClient(conns[0], append([]NatsClientOption{WithConnSelector(selector)}, opts...)...)
+}
+
+// Echo sends a Echo request to the service via NATS.
+// Returns an error if the request fails or the service returns an error,
+// or a *TimeoutError if a WithCallTimeout/WithClientTimeout expires.
+func (c *MixedServiceNatsClient) Echo(ctx context.Context, req *EchoRequest, opts ...CallOption) (*EchoResponse, error) {
+	method := "Echo"
+
+	// Sample the call for WithClientSampling under a request ID the service sees too
+	var requestID string
+	if c.sampler.enabled() {
+		ctx, requestID = withRequestID(ctx, c.idGenerator)
+	}
+	sample := c.sampler.begin("MixedService", method, requestID, true, c.outgoingHeaders(ctx), req, false)
+
+	// Bound the call when the caller gave no deadline (or asked for a per-call timeout)
+	start := time.Now()
+	parentCtx := ctx
+	ctx, cancel, timeout := withCallTimeout(ctx, c.timeout, opts)
+	defer cancel()
+
+	// Record sizes, attempts and duration for CallInfoFromContext
+	ctx, info := startCallInfo(ctx, "MixedService", c.subjectPrefix+".echo")
+	defer info.finish()
+	ctx = withIDGenerator(ctx, c.idGenerator) // For NewID in interceptors
+
+	// Give the invoker somewhere in the context to store the response headers
+	// Interceptors can then read the headers from the same context
+	ctx = WithResponseHeaders(ctx, nats.Header{})
+
+	// Define the invoker function that performs the actual NATS call
+	invoker := func(invokerCtx context.Context, method string, request, reply interface{}) error {
+		// WithTargetInstance sends the call to one instance's own subject
+		subject, subjectErr := targetSubject(c.subjectPrefix+".echo", opts)
+		if subjectErr != nil {
+			return newMixedServiceError(MixedServiceErrCodeInvalidArgument, method, subjectErr.Error(), nil)
+		}
+
+		// Marshal request
+		typedReq, ok := request.(*EchoRequest)
+		if !ok {
+			return fmt.Errorf("invalid request type")
+		}
+
+		var data []byte
+		var err error
+		if c.useJSON {
+			data, err = marshalJSON(typedReq, false)
+		} else {
+			data, err = proto.Marshal(typedReq)
+		}
+		if err != nil {
+			return err
+		}
+
+		// Extract outgoing headers from context and attach them, naming the codec, to the NATS message
+		nc := c.conn()
+		headers := withContentType(c.outgoingHeaders(invokerCtx), c.useJSON)
+		setDeadlineHeaders(invokerCtx, headers) // For deadline-aware scheduling and handler contexts
+		if data, err = c.compress(data, headers); err != nil {
+			return err
+		}
+		headers.Set(acceptEncodingHeader, compressorNames()) // Services compress responses only for clients that decompress them
+		if c.cancelPropagation {
+			var stop func() bool
+			headers, stop = propagateCancel(invokerCtx, nc, headers, mintID(c.idGenerator))
+			defer stop()
+		}
+
+		info.attempt(len(data))
+		msg, err := nc.RequestMsgWithContext(invokerCtx, &nats.Msg{
+			Subject: subject,
+			Data:    data,
+			Header:  headers,
+		})
+		if err != nil {
+			return err
+		}
+		info.received(len(msg.Data))
+		info.responder(msg.Header)
+		body, err := readPayload("response", msg.Header.Get(ContentEncodingHeader), msg.Data, c.maxResponseSize)
+		if err != nil {
+			return err
+		}
+
+		// Store response headers where ResponseHeaders reads them
+		if msg.Header != nil && len(msg.Header) > 0 {
+			storeResponseHeaders(invokerCtx, msg.Header)
+		}
+
+		// Check if this is an error response from the service (NATS micro headers)
+		if msg.Header.Get("Nats-Service-Error-Code") != "" {
+			code := msg.Header.Get("Nats-Service-Error-Code")
+			description := msg.Header.Get("Nats-Service-Error")
+			var details []byte
+			if len(msg.Data) > 0 {
+				details = msg.Data
+			}
+			return newMixedServiceError(code, method, description, details)
+		}
+
+		// Unmarshal response with the codec the service named, if any
+		typedReply, ok := reply.(*EchoResponse)
+		if !ok {
+			return fmt.Errorf("invalid reply type")
+		}
+		replyJSON, err := payloadUsesJSON(msg.Header.Get(ContentTypeHeader), c.useJSON)
+		if err != nil {
+			return err
+		}
+		if replyJSON {
+			err = protojson.Unmarshal(body, typedReply)
+		} else {
+			err = proto.Unmarshal(body, typedReply)
+		}
+		return err
+	}
+
+	var resp EchoResponse
+
+	// Execute through interceptor chain if configured, once per retry attempt
+	err := c.retry.do(ctx, func(ctx context.Context) error {
+		if c.interceptor != nil {
+			return c.interceptor(ctx, method, req, &resp, invoker)
+		}
+		return invoker(ctx, method, req, &resp)
+	})
+	sample.end(&resp, err)
+	if c.journal != nil {
+		c.journal.record("MixedService", method, c.subjectPrefix+".echo", c.outgoingHeaders(parentCtx), req, c.useJSON, false, start, err)
+	}
+	if err != nil {
+		return nil, callTimeoutError(parentCtx, ctx, method, timeout, err)
+	}
+
+	return &resp, nil
+}
+
+// Webhook sends a Webhook request to the service via NATS.
+// Returns an error if the request fails or the service returns an error,
+// or a *TimeoutError if a WithCallTimeout/WithClientTimeout expires.
+func (c *MixedServiceNatsClient) Webhook(ctx context.Context, req *EchoRequest, opts ...CallOption) (*EchoResponse, error) {
+	useJSON := true // (natsmicro.endpoint).encoding
+	method := "Webhook"
+
+	// Sample the call for WithClientSampling under a request ID the service sees too
+	var requestID string
+	if c.sampler.enabled() {
+		ctx, requestID = withRequestID(ctx, c.idGenerator)
+	}
+	sample := c.sampler.begin("MixedService", method, requestID, true, c.outgoingHeaders(ctx), req, false)
+
+	// Bound the call when the caller gave no deadline (or asked for a per-call timeout)
+	start := time.Now()
+	parentCtx := ctx
+	ctx, cancel, timeout := withCallTimeout(ctx, c.timeout, opts)
+	defer cancel()
+
+	// Record sizes, attempts and duration for CallInfoFromContext
+	ctx, info := startCallInfo(ctx, "MixedService", c.subjectPrefix+".webhook")
+	defer info.finish()
+	ctx = withIDGenerator(ctx, c.idGenerator) // For NewID in interceptors
+
+	// Give the invoker somewhere in the context to store the response headers
+	// Interceptors can then read the headers from the same context
+	ctx = WithResponseHeaders(ctx, nats.Header{})
+
+	// Define the invoker function that performs the actual NATS call
+	invoker := func(invokerCtx context.Context, method string, request, reply interface{}) error {
+		// WithTargetInstance sends the call to one instance's own subject
+		subject, subjectErr := targetSubject(c.subjectPrefix+".webhook", opts)
+		if subjectErr != nil {
+			return newMixedServiceError(MixedServiceErrCodeInvalidArgument, method, subjectErr.Error(), nil)
+		}
+
+		// Marshal request
+		typedReq, ok := request.(*EchoRequest)
+		if !ok {
+			return fmt.Errorf("invalid request type")
+		}
+
+		var data []byte
+		var err error
+		if useJSON {
+			data, err = marshalJSON(typedReq, false)
+		} else {
+			data, err = proto.Marshal(typedReq)
+		}
+		if err != nil {
+			return err
+		}
+
+		// Extract outgoing headers from context and attach them, naming the codec, to the NATS message
+		nc := c.conn()
+		headers := withContentType(c.outgoingHeaders(invokerCtx), useJSON)
+		setDeadlineHeaders(invokerCtx, headers) // For deadline-aware scheduling and handler contexts
+		if data, err = c.compress(data, headers); err != nil {
+			return err
+		}
+		headers.Set(acceptEncodingHeader, compressorNames()) // Services compress responses only for clients that decompress them
+		if c.cancelPropagation {
+			var stop func() bool
+			headers, stop = propagateCancel(invokerCtx, nc, headers, mintID(c.idGenerator))
+			defer stop()
+		}
+
+		info.attempt(len(data))
+		msg, err := nc.RequestMsgWithContext(invokerCtx, &nats.Msg{
+			Subject: subject,
+			Data:    data,
+			Header:  headers,
+		})
+		if err != nil {
+			return err
+		}
+		info.received(len(msg.Data))
+		info.responder(msg.Header)
+		body, err := readPayload("response", msg.Header.Get(ContentEncodingHeader), msg.Data, c.maxResponseSize)
+		if err != nil {
+			return err
+		}
+
+		// Store response headers where ResponseHeaders reads them
+		if msg.Header != nil && len(msg.Header) > 0 {
+			storeResponseHeaders(invokerCtx, msg.Header)
+		}
+
+		// Check if this is an error response from the service (NATS micro headers)
+		if msg.Header.Get("Nats-Service-Error-Code") != "" {
+			code := msg.Header.Get("Nats-Service-Error-Code")
+			description := msg.Header.Get("Nats-Service-Error")
+			var details []byte
+			if len(msg.Data) > 0 {
+				details = msg.Data
+			}
+			return newMixedServiceError(code, method, description, details)
+		}
+
+		// Unmarshal response with the codec the service named, if any
+		typedReply, ok := reply.(*EchoResponse)
+		if !ok {
+			return fmt.Errorf("invalid reply type")
+		}
+		replyJSON, err := payloadUsesJSON(msg.Header.Get(ContentTypeHeader), useJSON)
+		if err != nil {
+			return err
+		}
+		if replyJSON {
+			err = protojson.Unmarshal(body, typedReply)
+		} else {
+			err = proto.Unmarshal(body, typedReply)
+		}
+		return err
+	}
+
+	var resp EchoResponse
+
+	// Execute through interceptor chain if configured, once per retry attempt
+	err := c.retry.do(ctx, func(ctx context.Context) error {
+		if c.interceptor != nil {
+			return c.interceptor(ctx, method, req, &resp, invoker)
+		}
+		return invoker(ctx, method, req, &resp)
+	})
+	sample.end(&resp, err)
+	if c.journal != nil {
+		c.journal.record("MixedService", method, c.subjectPrefix+".webhook", c.outgoingHeaders(parentCtx), req, useJSON, false, start, err)
+	}
+	if err != nil {
+		return nil, callTimeoutError(parentCtx, ctx, method, timeout, err)
+	}
+
+	return &resp, nil
+}
+
+// Health calls the service's health endpoint. A service that reports HealthNotServing
+// returns a HealthResponse, not an error.
+func (c *MixedServiceNatsClient) Health(ctx context.Context) (*HealthResponse, error) {
+	parentCtx := ctx
+	ctx, cancel, timeout := withCallTimeout(ctx, c.timeout, nil)
+	defer cancel()
+	resp, err := requestHealth(ctx, c.conn(), healthSubject(c.subjectPrefix, "mixed_service"))
+	if err != nil {
+		return nil, callTimeoutError(parentCtx, ctx, "Health", timeout, err)
+	}
+	return resp, nil
+}
+
+// Schema fetches the service's $schema document; see FetchServiceSchema
+func (c *MixedServiceNatsClient) Schema(ctx context.Context) (*ServiceSchemaDocument, error) {
+	ctx, cancel, _ := withCallTimeout(ctx, c.timeout, nil)
+	defer cancel()
+	return FetchServiceSchema(ctx, c.conn(), schemaSubject(c.subjectPrefix, "mixed_service"))
+}
+
+// Close stops the client's connection monitor. The NATS connection stays open,
+// and the client can still be used.
+func (c *MixedServiceNatsClient) Close() {
+	c.monitor.close()
+}
+
+// Endpoints returns information about all service endpoints this client can call.
+// This is useful for debugging, monitoring, and introspection.
+func (c *MixedServiceNatsClient) Endpoints() []MixedServiceEndpointInfo {
+	return []MixedServiceEndpointInfo{
+		{Name: "Echo", Subject: c.subjectPrefix + ".echo"},
+		{Name: "Webhook", Subject: c.subjectPrefix + ".webhook"},
+	}
+}
+
+// MixedServiceSelfTest checks that the NATS connection works and that a running
+// mixed_service instance has registered every endpoint the client calls. Client
+// options such as WithNatsClientSubjectPrefix select the expected subjects.
+// Exit non-zero when the report is not OK to use it as a liveness or deployment hook.
+func MixedServiceSelfTest(ctx context.Context, nc *nats.Conn, opts ...NatsClientOption) *SelfTestReport {
+	c := NewMixedServiceNatsClient(nc, opts...)
+	var endpoints []SelfTestEndpoint
+	for _, ep := range c.Endpoints() {
+		endpoints = append(endpoints, SelfTestEndpoint{Name: ep.Name, Subject: ep.Subject})
+	}
+	return runSelfTest(ctx, nc, "mixed_service", endpoints)
+}
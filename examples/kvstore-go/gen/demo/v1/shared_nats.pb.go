@@ -1,13 +1,50 @@
 // Code generated by protoc-gen-nats-micro. DO NOT EDIT.
+// versions:
+// 	protoc-gen-nats-micro v0.3.0
+// 	protoc                (unknown)
 
 package v1
 
 import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"container/heap"
 	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/fnv"
+	"io"
+	"iter"
+	"log/slog"
+	"math/rand"
+	"os"
+	"reflect"
+	"regexp"
+	"runtime"
+	"runtime/debug"
+	"runtime/pprof"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
+	"unsafe"
 	"github.com/nats-io/nats.go"
 	"github.com/nats-io/nats.go/jetstream"
 	"github.com/nats-io/nats.go/micro"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"github.com/toyz/protoc-gen-nats-micro/natsrpc"
 )
 
 // Common error codes used across all NATS microservices
@@ -21,58 +58,410 @@ const (
 	ErrCodeUnavailable      = "UNAVAILABLE"
 )
 
-// Context keys for NATS headers
+// Code is a gRPC-style status code. On the wire it travels as its name
+// (e.g., "NOT_FOUND") in the Nats-Service-Error-Code header.
+type Code int
+
+// Status codes, numbered like their gRPC equivalents (the natsmicro.Code enum)
+const (
+	CodeOK                 Code = 0
+	CodeCanceled           Code = 1
+	CodeUnknown            Code = 2
+	CodeInvalidArgument    Code = 3
+	CodeDeadlineExceeded   Code = 4
+	CodeNotFound           Code = 5
+	CodeAlreadyExists      Code = 6
+	CodePermissionDenied   Code = 7
+	CodeResourceExhausted  Code = 8
+	CodeFailedPrecondition Code = 9
+	CodeAborted            Code = 10
+	CodeOutOfRange         Code = 11
+	CodeUnimplemented      Code = 12
+	CodeInternal           Code = 13
+	CodeUnavailable        Code = 14
+	CodeDataLoss           Code = 15
+	CodeUnauthenticated    Code = 16
+)
+
+var codeNames = [...]string{
+	CodeOK:                 "OK",
+	CodeCanceled:           "CANCELLED",
+	CodeUnknown:            "UNKNOWN",
+	CodeInvalidArgument:    "INVALID_ARGUMENT",
+	CodeDeadlineExceeded:   "DEADLINE_EXCEEDED",
+	CodeNotFound:           "NOT_FOUND",
+	CodeAlreadyExists:      "ALREADY_EXISTS",
+	CodePermissionDenied:   "PERMISSION_DENIED",
+	CodeResourceExhausted:  "RESOURCE_EXHAUSTED",
+	CodeFailedPrecondition: "FAILED_PRECONDITION",
+	CodeAborted:            "ABORTED",
+	CodeOutOfRange:         "OUT_OF_RANGE",
+	CodeUnimplemented:      "UNIMPLEMENTED",
+	CodeInternal:           "INTERNAL",
+	CodeUnavailable:        "UNAVAILABLE",
+	CodeDataLoss:           "DATA_LOSS",
+	CodeUnauthenticated:    "UNAUTHENTICATED",
+}
+
+// String returns the wire name of the code, e.g. "NOT_FOUND"
+func (c Code) String() string {
+	if c >= 0 && int(c) < len(codeNames) {
+		return codeNames[c]
+	}
+	return "CODE(" + strconv.Itoa(int(c)) + ")"
+}
+
+// ParseCode returns the Code with the given wire name, or CodeUnknown for names
+// it does not know, such as custom (natsmicro.service).error_codes.
+func ParseCode(name string) Code {
+	for c, n := range codeNames {
+		if n == name {
+			return Code(c)
+		}
+	}
+	return CodeUnknown
+}
+
+// codeHTTPStatuses maps codes to HTTP statuses as grpc-gateway does
+var codeHTTPStatuses = [...]int{
+	CodeOK:                 200,
+	CodeCanceled:           499,
+	CodeUnknown:            500,
+	CodeInvalidArgument:    400,
+	CodeDeadlineExceeded:   504,
+	CodeNotFound:           404,
+	CodeAlreadyExists:      409,
+	CodePermissionDenied:   403,
+	CodeResourceExhausted:  429,
+	CodeFailedPrecondition: 400,
+	CodeAborted:            409,
+	CodeOutOfRange:         400,
+	CodeUnimplemented:      501,
+	CodeInternal:           500,
+	CodeUnavailable:        503,
+	CodeDataLoss:           500,
+	CodeUnauthenticated:    401,
+}
+
+// HTTPStatus returns the HTTP status for c, e.g., 404 for CodeNotFound, or 500
+// for codes outside natsmicro.Code
+func (c Code) HTTPStatus() int {
+	if c >= 0 && int(c) < len(codeHTTPStatuses) {
+		return codeHTTPStatuses[c]
+	}
+	return 500
+}
+
+// CodeFromHTTPStatus returns the Code for an HTTP status, the inverse of
+// HTTPStatus. Statuses several codes share map to the most general one, e.g.,
+// 400 to CodeInvalidArgument; other 2xx statuses are CodeOK and the rest CodeUnknown.
+func CodeFromHTTPStatus(status int) Code {
+	switch status {
+	case 200:
+		return CodeOK
+	case 499:
+		return CodeCanceled
+	case 400:
+		return CodeInvalidArgument
+	case 504:
+		return CodeDeadlineExceeded
+	case 404:
+		return CodeNotFound
+	case 409:
+		return CodeAlreadyExists
+	case 403:
+		return CodePermissionDenied
+	case 429:
+		return CodeResourceExhausted
+	case 501:
+		return CodeUnimplemented
+	case 500:
+		return CodeInternal
+	case 503:
+		return CodeUnavailable
+	case 401:
+		return CodeUnauthenticated
+	}
+	if status >= 200 && status < 300 {
+		return CodeOK
+	}
+	return CodeUnknown
+}
+
+// Status is a structured error with a status code, message and optional details.
+// Returned from a handler (directly or wrapped with %w), it is sent to the client,
+// where errors.As(err, &st) recovers it from the call error.
+type Status struct {
+	Code    Code   // Status code
+	Message string // Human-readable error message
+	Details []byte // Optional details, e.g. a serialized proto message
+}
+
+// NewStatus creates a Status error
+func NewStatus(code Code, message string) *Status {
+	return &Status{Code: code, Message: message}
+}
+
+// Statusf creates a Status error with a formatted message
+func Statusf(code Code, format string, args ...any) *Status {
+	return &Status{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+func (s *Status) Error() string {
+	return fmt.Sprintf("[%s] %s", s.Code, s.Message)
+}
+
+// NatsErrorCode returns the wire name of the status code
+func (s *Status) NatsErrorCode() string {
+	return s.Code.String()
+}
+
+// NatsErrorMessage returns the status message
+func (s *Status) NatsErrorMessage() string {
+	return s.Message
+}
+
+// NatsErrorData returns the status details
+func (s *Status) NatsErrorData() []byte {
+	return s.Details
+}
+
+// natsErrorFields returns the error code, message and data a handler error is
+// sent with. Errors without NatsErrorCode() (and no *Status to unwrap) are INTERNAL.
+func natsErrorFields(err error) (code, message string, data []byte) {
+	code = ErrCodeInternal
+	message = err.Error()
+
+	// Send a *Status wrapped with %w as the status itself
+	if _, ok := err.(interface{ NatsErrorCode() string }); !ok {
+		var st *Status
+		if errors.As(err, &st) {
+			err = st
+		}
+	}
+
+	// Check for NatsErrorCode() string method
+	if coder, ok := err.(interface{ NatsErrorCode() string }); ok {
+		code = coder.NatsErrorCode()
+	}
+	// Check for NatsErrorMessage() string method
+	if messager, ok := err.(interface{ NatsErrorMessage() string }); ok {
+		message = messager.NatsErrorMessage()
+	}
+	// Check for NatsErrorData() []byte method
+	if dataProvider, ok := err.(interface{ NatsErrorData() []byte }); ok {
+		data = dataProvider.NatsErrorData()
+	}
+	return code, message, data
+}
+
+// CodeOf returns the status code of err: CodeOK for nil, the code of a *Status
+// in its chain, or CodeUnknown otherwise.
+func CodeOf(err error) Code {
+	if err == nil {
+		return CodeOK
+	}
+	var st *Status
+	if errors.As(err, &st) {
+		return st.Code
+	}
+	return CodeUnknown
+}
+
+// callErrorCode returns the code nearest to an error of a client call: the code
+// of a *Status in its chain, CodeDeadlineExceeded for timeouts, CodeCanceled for
+// cancellation, CodeUnavailable when no service responds, or CodeUnknown.
+func callErrorCode(err error) Code {
+	var st *Status
+	switch {
+	case err == nil:
+		return CodeOK
+	case errors.As(err, &st):
+		return st.Code
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, ErrTimeout), errors.Is(err, nats.ErrTimeout):
+		return CodeDeadlineExceeded
+	case errors.Is(err, context.Canceled):
+		return CodeCanceled
+	case errors.Is(err, nats.ErrNoResponders), errors.Is(err, nats.ErrConnectionClosed), errors.Is(err, ErrStreamBroken):
+		return CodeUnavailable
+	}
+	return CodeUnknown
+}
+
+// IsCanceled reports whether err has the CANCELLED code (CodeOf)
+func IsCanceled(err error) bool {
+	return CodeOf(err) == CodeCanceled
+}
+
+// IsUnknown reports whether err has the UNKNOWN code (CodeOf)
+func IsUnknown(err error) bool {
+	return CodeOf(err) == CodeUnknown
+}
+
+// IsInvalidArgument reports whether err has the INVALID_ARGUMENT code (CodeOf)
+func IsInvalidArgument(err error) bool {
+	return CodeOf(err) == CodeInvalidArgument
+}
+
+// IsDeadlineExceeded reports whether err has the DEADLINE_EXCEEDED code (CodeOf)
+func IsDeadlineExceeded(err error) bool {
+	return CodeOf(err) == CodeDeadlineExceeded
+}
+
+// IsNotFound reports whether err has the NOT_FOUND code (CodeOf)
+func IsNotFound(err error) bool {
+	return CodeOf(err) == CodeNotFound
+}
+
+// IsAlreadyExists reports whether err has the ALREADY_EXISTS code (CodeOf)
+func IsAlreadyExists(err error) bool {
+	return CodeOf(err) == CodeAlreadyExists
+}
+
+// IsPermissionDenied reports whether err has the PERMISSION_DENIED code (CodeOf)
+func IsPermissionDenied(err error) bool {
+	return CodeOf(err) == CodePermissionDenied
+}
+
+// IsResourceExhausted reports whether err has the RESOURCE_EXHAUSTED code (CodeOf)
+func IsResourceExhausted(err error) bool {
+	return CodeOf(err) == CodeResourceExhausted
+}
+
+// IsFailedPrecondition reports whether err has the FAILED_PRECONDITION code (CodeOf)
+func IsFailedPrecondition(err error) bool {
+	return CodeOf(err) == CodeFailedPrecondition
+}
+
+// IsAborted reports whether err has the ABORTED code (CodeOf)
+func IsAborted(err error) bool {
+	return CodeOf(err) == CodeAborted
+}
+
+// IsOutOfRange reports whether err has the OUT_OF_RANGE code (CodeOf)
+func IsOutOfRange(err error) bool {
+	return CodeOf(err) == CodeOutOfRange
+}
+
+// IsUnimplemented reports whether err has the UNIMPLEMENTED code (CodeOf)
+func IsUnimplemented(err error) bool {
+	return CodeOf(err) == CodeUnimplemented
+}
+
+// IsInternal reports whether err has the INTERNAL code (CodeOf)
+func IsInternal(err error) bool {
+	return CodeOf(err) == CodeInternal
+}
+
+// IsUnavailable reports whether err has the UNAVAILABLE code (CodeOf)
+func IsUnavailable(err error) bool {
+	return CodeOf(err) == CodeUnavailable
+}
+
+// IsDataLoss reports whether err has the DATA_LOSS code (CodeOf)
+func IsDataLoss(err error) bool {
+	return CodeOf(err) == CodeDataLoss
+}
+
+// IsUnauthenticated reports whether err has the UNAUTHENTICATED code (CodeOf)
+func IsUnauthenticated(err error) bool {
+	return CodeOf(err) == CodeUnauthenticated
+}
+
+// GeneratedWith returns the protoc-gen-nats-micro version that generated this package.
+// It is available even when generating with reproducible=true, which omits versions from file headers.
+func GeneratedWith() string {
+	return "protoc-gen-nats-micro v0.3.0"
+}
+
+// Context keys for call state. Headers live in natsrpc, shared with every
+// generated package.
 type contextKey int
 
 const (
-	incomingHeadersKey contextKey = iota
-	outgoingHeadersKey
-	responseHeadersKey
+	retryAttemptKey contextKey = iota
+	callInfoKey
+	principalKey
+	kvRevisionKey
 )
 
+// enrichContextKey keys messages loaded by (natsmicro.enrich), named by context_key
+type enrichContextKey string
+
 // IncomingHeaders extracts incoming NATS headers from the context (server-side)
-// Returns nil if no headers are present
+// Returns nil if no headers are present. Same as natsrpc.IncomingHeaders, so headers
+// are shared with every generated package.
 func IncomingHeaders(ctx context.Context) micro.Headers {
-	if headers, ok := ctx.Value(incomingHeadersKey).(micro.Headers); ok {
-		return headers
-	}
-	return nil
+	return natsrpc.IncomingHeaders(ctx)
 }
 
 // OutgoingHeaders extracts outgoing NATS headers from the context (client-side)
-// Returns nil if no headers are present
+// Returns nil if no headers are present. Same as natsrpc.OutgoingHeaders.
 func OutgoingHeaders(ctx context.Context) nats.Header {
-	if headers, ok := ctx.Value(outgoingHeadersKey).(nats.Header); ok {
-		return headers
+	return natsrpc.OutgoingHeaders(ctx)
+}
+
+// layerHeaders returns base with the values of over replacing those of the same
+// name, leaving both untouched. It returns over itself when base is empty.
+func layerHeaders(base, over nats.Header) nats.Header {
+	if len(base) == 0 {
+		return over
 	}
-	return nil
+	layered := make(nats.Header, len(base)+len(over))
+	for name, values := range base {
+		layered[name] = values
+	}
+	for name, values := range over {
+		layered[name] = values
+	}
+	return layered
+}
+
+// headerValue returns the first value of the named header, matching the name
+// case-insensitively, and whether the header was sent
+func headerValue(headers nats.Header, name string) (string, bool) {
+	if values, ok := headers[name]; ok && len(values) > 0 {
+		return values[0], true
+	}
+	for key, values := range headers {
+		if len(values) > 0 && strings.EqualFold(key, name) {
+			return values[0], true
+		}
+	}
+	return "", false
+}
+
+// missingHeader returns the first of the (natsmicro.endpoint).headers a request
+// must carry that it lacks or sent empty, or ""
+func missingHeader(headers nats.Header, required ...string) string {
+	for _, name := range required {
+		if value, _ := headerValue(headers, name); value == "" {
+			return name
+		}
+	}
+	return ""
 }
 
 // WithIncomingHeaders adds incoming NATS headers to the context (used internally by server)
 func WithIncomingHeaders(ctx context.Context, headers micro.Headers) context.Context {
-	return context.WithValue(ctx, incomingHeadersKey, headers)
+	return natsrpc.WithIncomingHeaders(ctx, headers)
 }
 
 // WithOutgoingHeaders adds outgoing NATS headers to the context (used by client)
 // Example: ctx := WithOutgoingHeaders(ctx, nats.Header{"Authorization": []string{"Bearer token"}})
 func WithOutgoingHeaders(ctx context.Context, headers nats.Header) context.Context {
-	return context.WithValue(ctx, outgoingHeadersKey, headers)
+	return natsrpc.WithOutgoingHeaders(ctx, headers)
 }
 
 // ResponseHeaders extracts response headers from the context (client-side, after call)
 // Returns nil if no response headers are present
 func ResponseHeaders(ctx context.Context) nats.Header {
-	// Response headers are stored as a pointer in the context
-	// The invoker updates the pointer, allowing interceptors to read the headers
-	if headersPtr, ok := ctx.Value(responseHeadersKey).(*nats.Header); ok && headersPtr != nil {
-		return *headersPtr
-	}
-	return nil
+	return natsrpc.ResponseHeaders(ctx)
 }
 
 // WithResponseHeaders adds response headers to the context (used internally by client)
 func WithResponseHeaders(ctx context.Context, headers nats.Header) context.Context {
-	return context.WithValue(ctx, responseHeadersKey, headers)
+	return natsrpc.WithResponseHeaders(ctx, &headers)
 }
 
 // SetResponseHeaders allows server interceptors/handlers to add response headers
@@ -80,226 +469,7569 @@ func WithResponseHeaders(ctx context.Context, headers nats.Header) context.Conte
 // Example: SetResponseHeaders(ctx, nats.Header{"X-Server-Version": []string{"1.0.0"}})
 // Note: This modifies a mutable pointer stored in the context, so you don't need to capture the return value
 func SetResponseHeaders(ctx context.Context, headers nats.Header) {
-	// Get the pointer to response headers from context and update it
-	if headersPtr, ok := ctx.Value(outgoingHeadersKey).(*nats.Header); ok && headersPtr != nil {
-		*headersPtr = headers
-	}
+	natsrpc.SetResponseHeaders(ctx, headers)
 }
 
-// UnaryServerInfo contains information about a unary RPC
-type UnaryServerInfo struct {
-	Service string // Service name
-	Method  string // Method name
-	Subject string // NATS subject
+// withPendingResponseHeaders points SetResponseHeaders at *headers for one request
+func withPendingResponseHeaders(ctx context.Context, headers *nats.Header) context.Context {
+	return natsrpc.WithPendingResponseHeaders(ctx, headers)
 }
 
-// UnaryHandler is the actual handler function to be called
-type UnaryHandler func(ctx context.Context, req interface{}) (interface{}, error)
-
-// UnaryServerInterceptor is middleware that can intercept unary RPC calls on the server
-// It receives the context, request, RPC info, and the handler to call.
-// The interceptor can inspect/modify the request, handle auth, logging, etc.
-// It must call handler(ctx, req) to continue the chain or return early.
-type UnaryServerInterceptor func(ctx context.Context, req interface{}, info *UnaryServerInfo, handler UnaryHandler) (interface{}, error)
+// pendingResponseHeaders returns the headers SetResponseHeaders set for a request
+func pendingResponseHeaders(ctx context.Context) nats.Header {
+	return natsrpc.PendingResponseHeaders(ctx)
+}
 
-// UnaryInvoker is called by a UnaryClientInterceptor to complete the RPC
-type UnaryInvoker func(ctx context.Context, method string, req, reply interface{}) error
+// storeResponseHeaders records a client call's response headers for ResponseHeaders
+func storeResponseHeaders(ctx context.Context, headers nats.Header) {
+	natsrpc.StoreResponseHeaders(ctx, headers)
+}
 
-// UnaryClientInterceptor is middleware that can intercept unary RPC calls on the client
-// It receives the context, method name, request, reply, and invoker.
-// The interceptor can inspect/modify requests, handle retries, logging, etc.
-// It must call invoker(ctx, method, req, reply) to continue the chain.
-type UnaryClientInterceptor func(ctx context.Context, method string, req, reply interface{}, invoker UnaryInvoker) error
+// CallInfo describes what a client call cost on the wire
+type CallInfo struct {
+	Service       string        // Service the call was made to, e.g., "OrderService"
+	Subject       string        // Subject the call was sent to
+	Duration      time.Duration // Time from the start of the call until it returned, or until the stream ended
+	RequestBytes  int           // Request payload size of the last attempt; for streams, the sum of all sent messages
+	ResponseBytes int           // Response payload size; for streams, the sum of all received messages
+	Attempts      int           // Number of requests sent, including retries
+	Instance      string        // ID of the service instance that answered, if it registered WithInstanceSubjects
+}
 
-// registerConfig holds configuration for service registration
-type registerConfig struct {
-	name               string
-	version            string
-	description        string
-	subjectPrefix      string
-	timeout            time.Duration
-	metadata           map[string]string
-	statsHandler       micro.StatsHandler
-	doneHandler        micro.DoneHandler
-	errorHandler       micro.ErrHandler
-	serverInterceptors []UnaryServerInterceptor
-	js                 jetstream.JetStream // Optional JetStream context for KV/ObjectStore
+// callInfoHolder is the mutable CallInfo a client call fills in through its context
+type callInfoHolder struct {
+	mu    sync.Mutex
+	info  CallInfo
+	start time.Time
 }
 
-// RegisterOption configures the service registration
-type RegisterOption func(*registerConfig)
+// WithCallInfo returns a context that collects the CallInfo of the client calls made with it.
+// Read it with CallInfoFromContext once a unary call returns, or once a stream has
+// reached EOF or been closed. Each call resets it, so use one context per call to keep them apart.
+// Example:
+//
+//	ctx = WithCallInfo(ctx)
+//	resp, err := client.GetOrder(ctx, req)
+//	info := CallInfoFromContext(ctx)
+//	log.Printf("%s: %s, %d bytes", info.Subject, info.Duration, info.ResponseBytes)
+func WithCallInfo(ctx context.Context) context.Context {
+	return context.WithValue(ctx, callInfoKey, &callInfoHolder{})
+}
 
-// WithName overrides the service name from the proto definition
-func WithName(name string) RegisterOption {
-	return func(c *registerConfig) { c.name = name }
+// CallInfoFromContext returns the CallInfo collected in a context prepared with WithCallInfo.
+// Client interceptors can also read it for the call they intercept.
+// Returns the zero CallInfo if ctx carries none.
+func CallInfoFromContext(ctx context.Context) CallInfo {
+	if holder, ok := ctx.Value(callInfoKey).(*callInfoHolder); ok && holder != nil {
+		holder.mu.Lock()
+		defer holder.mu.Unlock()
+		return holder.info
+	}
+	return CallInfo{}
 }
 
-// WithVersion overrides the service version from the proto definition
-func WithVersion(version string) RegisterOption {
-	return func(c *registerConfig) { c.version = version }
+// Principal identifies who a request acts for, e.g., the subject of a verified user JWT
+// or an nkey public key. Authentication interceptors store it with WithPrincipal.
+type Principal struct {
+	Subject        string     // Identity the request acts as
+	ImpersonatedBy *Principal // Real caller when the request was impersonated, nil otherwise
 }
 
-// WithDescription overrides the service description from the proto definition
-func WithDescription(desc string) RegisterOption {
-	return func(c *registerConfig) { c.description = desc }
+// WithPrincipal returns a context carrying p (server-side, set by authentication interceptors)
+func WithPrincipal(ctx context.Context, p Principal) context.Context {
+	return context.WithValue(ctx, principalKey, p)
 }
 
-// WithSubjectPrefix overrides the subject prefix from the proto definition
-func WithSubjectPrefix(prefix string) RegisterOption {
-	return func(c *registerConfig) { c.subjectPrefix = prefix }
+// PrincipalFromContext returns the Principal stored with WithPrincipal
+func PrincipalFromContext(ctx context.Context) (Principal, bool) {
+	p, ok := ctx.Value(principalKey).(Principal)
+	return p, ok
 }
 
-// WithTimeout sets the default timeout for all service methods.
-// This overrides the timeout configured in the proto definition.
-// Use 0 for no timeout (context.Background).
-func WithTimeout(timeout time.Duration) RegisterOption {
-	return func(c *registerConfig) { c.timeout = timeout }
+// maxKVConflictAttempts bounds how often a (natsmicro.kv_store) retry_on_conflict
+// method runs its handler before returning ABORTED
+const maxKVConflictAttempts = 5
+
+// KVRevisionFromContext returns the revision of the KV entry a REVISION_CHECK method's
+// response will replace, read before the handler ran (server-side). The revision is 0
+// if the key did not exist; ok is false for methods without a revision check.
+func KVRevisionFromContext(ctx context.Context) (revision uint64, ok bool) {
+	revision, ok = ctx.Value(kvRevisionKey).(uint64)
+	return revision, ok
 }
 
-// WithMetadata replaces all service metadata.
-// This completely overrides metadata defined in the proto definition.
-// Use WithAdditionalMetadata to merge with proto metadata instead.
-func WithMetadata(metadata map[string]string) RegisterOption {
-	return func(c *registerConfig) { c.metadata = metadata }
+// kvEntryRevision returns the current revision of key, 0 if it does not exist
+func kvEntryRevision(ctx context.Context, kv jetstream.KeyValue, key string) (uint64, error) {
+	entry, err := kv.Get(ctx, key)
+	if errors.Is(err, jetstream.ErrKeyNotFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return entry.Revision(), nil
 }
 
-// WithAdditionalMetadata adds or updates metadata entries.
-// This merges with metadata defined in the proto definition.
-// Duplicate keys will override proto values.
-func WithAdditionalMetadata(metadata map[string]string) RegisterOption {
-	return func(c *registerConfig) {
-		for k, v := range metadata {
-			c.metadata[k] = v
-		}
+// updateKVEntry writes value to key if the entry is still at revision (0: the key does
+// not exist). A concurrent write in between fails with an ABORTED *Status.
+func updateKVEntry(ctx context.Context, kv jetstream.KeyValue, key string, value []byte, revision uint64) error {
+	var err error
+	if revision == 0 {
+		_, err = kv.Create(ctx, key, value)
+	} else {
+		_, err = kv.Update(ctx, key, value, revision)
+	}
+	if errors.Is(err, jetstream.ErrKeyExists) {
+		return Statusf(CodeAborted, "KV entry %q was modified concurrently (expected revision %d)", key, revision)
 	}
+	return err
 }
 
-// WithStatsHandler sets a callback for service statistics.
-// The handler is called periodically with endpoint stats including
-// request counts, error counts, and processing times.
-func WithStatsHandler(handler micro.StatsHandler) RegisterOption {
-	return func(c *registerConfig) { c.statsHandler = handler }
+// Typed KV entries: a method with (natsmicro.kv_store).type_tag stores the tag in a
+// header of each entry it writes, and its readers check it before decoding, so
+// methods persisting different response types to one bucket never misread each other.
+const natsKVTypeTagHeader = "Nats-Micro-Type-Tag"
+
+// ErrTypeMismatch is returned by Get<Method>FromKV when the entry under the key was
+// written with a different type_tag, or without one
+var ErrTypeMismatch = errors.New("KV entry type mismatch")
+
+// typedKVEntryMsg returns the message that stores value under key in bucket, tagged
+func typedKVEntryMsg(bucket, key string, value []byte, tag string) *nats.Msg {
+	msg := nats.NewMsg("$KV." + bucket + "." + key)
+	msg.Data = value
+	msg.Header.Set(natsKVTypeTagHeader, tag)
+	return msg
 }
 
-// WithDoneHandler sets a callback invoked when the service stops
-func WithDoneHandler(handler micro.DoneHandler) RegisterOption {
-	return func(c *registerConfig) { c.doneHandler = handler }
+// putTypedKVEntry is kv.Put for an entry tagged with tag
+func putTypedKVEntry(ctx context.Context, js jetstream.JetStream, bucket, key string, value []byte, tag string) error {
+	_, err := js.PublishMsg(ctx, typedKVEntryMsg(bucket, key, value, tag))
+	return err
 }
 
-// WithErrorHandler sets a callback for handling service-level errors
-func WithErrorHandler(handler micro.ErrHandler) RegisterOption {
-	return func(c *registerConfig) { c.errorHandler = handler }
+// updateTypedKVEntry is updateKVEntry for an entry tagged with tag
+func updateTypedKVEntry(ctx context.Context, js jetstream.JetStream, bucket, key string, value []byte, tag string, revision uint64) error {
+	_, err := js.PublishMsg(ctx, typedKVEntryMsg(bucket, key, value, tag), jetstream.WithExpectLastSequencePerSubject(revision))
+	if revision == 0 && isWrongLastSequence(err) {
+		// As with kv.Create, a deleted key is written over its delete marker
+		if stream, streamErr := js.Stream(ctx, "KV_"+bucket); streamErr == nil {
+			last, lastErr := stream.GetLastMsgForSubject(ctx, "$KV."+bucket+"."+key)
+			if lastErr == nil && last.Header.Get("KV-Operation") != "" {
+				_, err = js.PublishMsg(ctx, typedKVEntryMsg(bucket, key, value, tag), jetstream.WithExpectLastSequencePerSubject(last.Sequence))
+			}
+		}
+	}
+	if isWrongLastSequence(err) {
+		return Statusf(CodeAborted, "KV entry %q was modified concurrently (expected revision %d)", key, revision)
+	}
+	return err
 }
 
-// WithJetStream provides a JetStream context for KV/ObjectStore operations.
-// Required only if any methods use (natsmicro.kv_store) or (natsmicro.object_store) options.
-// If not provided, KV/ObjectStore operations will be silently skipped.
-func WithJetStream(js jetstream.JetStream) RegisterOption {
-	return func(c *registerConfig) { c.js = js }
+// isWrongLastSequence reports whether err is JetStream rejecting a write whose
+// expected last subject sequence is out of date
+func isWrongLastSequence(err error) bool {
+	var apiErr *jetstream.APIError
+	return errors.As(err, &apiErr) && apiErr.ErrorCode == jetstream.JSErrCodeStreamWrongLastSequence
 }
 
-// WithServerInterceptor adds a unary server interceptor to the service.
-// Interceptors are executed in the order they are added.
-// Use for cross-cutting concerns like logging, auth, metrics, tracing.
-func WithServerInterceptor(interceptor UnaryServerInterceptor) RegisterOption {
-	return func(c *registerConfig) {
-		c.serverInterceptors = append(c.serverInterceptors, interceptor)
+// getTypedKVEntry is kv.Get for an entry that must be tagged with tag; any other
+// entry fails with ErrTypeMismatch
+func getTypedKVEntry(ctx context.Context, js jetstream.JetStream, kv jetstream.KeyValue, bucket, key, tag string) (jetstream.KeyValueEntry, error) {
+	entry, err := kv.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	stream, err := js.Stream(ctx, "KV_"+bucket)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkKVTypeTag(ctx, stream, key, entry.Revision(), tag); err != nil {
+		return nil, err
 	}
+	return entry, nil
 }
 
-// chainUnaryServerInterceptors creates a single interceptor that chains multiple interceptors
-func chainUnaryServerInterceptors(interceptors []UnaryServerInterceptor) UnaryServerInterceptor {
-	n := len(interceptors)
-
-	if n == 0 {
-		return nil
+// checkKVTypeTag fails with ErrTypeMismatch unless revision of key, read from the
+// bucket's stream, is tagged with tag
+func checkKVTypeTag(ctx context.Context, stream jetstream.Stream, key string, revision uint64, tag string) error {
+	msg, err := stream.GetMsg(ctx, revision)
+	if err != nil {
+		return err
 	}
+	if got := msg.Header.Get(natsKVTypeTagHeader); got != tag {
+		return fmt.Errorf("%w: key %q is tagged %q, want %q", ErrTypeMismatch, key, got, tag)
+	}
+	return nil
+}
 
-	if n == 1 {
-		return interceptors[0]
+// ensureKVBucket creates the KV bucket cfg declares from a method's
+// (natsmicro.kv_store) options. An existing bucket is left as it is, since an
+// operator may have set more than the options declare, e.g., replicas, and each
+// declared setting it does not match is warned about. limitMarkerTTL repeats
+// cfg.LimitMarkerTTL, which nats.go before v1.40 lacks.
+func ensureKVBucket(ctx context.Context, js jetstream.JetStream, cfg jetstream.KeyValueConfig, limitMarkerTTL time.Duration) error {
+	kv, err := js.KeyValue(ctx, cfg.Bucket)
+	if errors.Is(err, jetstream.ErrBucketNotFound) {
+		if _, err = js.CreateKeyValue(ctx, cfg); !errors.Is(err, jetstream.ErrBucketExists) {
+			return err
+		}
+		kv, err = js.KeyValue(ctx, cfg.Bucket) // Another instance created it first
 	}
+	if err != nil {
+		return err
+	}
+	status, err := kv.Status(ctx)
+	if err != nil {
+		return err
+	}
+	warn := func(setting string, has, declared any) {
+		fmt.Fprintf(os.Stderr, "[nats-micro] WARN: KV bucket %q has %s %v, but kv_store declares %v; update the bucket to match\n", cfg.Bucket, setting, has, declared)
+	}
+	if cfg.TTL != 0 && status.TTL() != cfg.TTL {
+		warn("ttl", status.TTL(), cfg.TTL)
+	}
+	if cfg.History != 0 && status.History() != int64(cfg.History) {
+		warn("history", status.History(), cfg.History)
+	}
+	if markers, ok := status.(interface{ LimitMarkerTTL() time.Duration }); ok && limitMarkerTTL != 0 && markers.LimitMarkerTTL() != limitMarkerTTL {
+		warn("limit marker ttl", markers.LimitMarkerTTL(), limitMarkerTTL)
+	}
+	return nil
+}
 
-	return func(ctx context.Context, req interface{}, info *UnaryServerInfo, handler UnaryHandler) (interface{}, error) {
-		// Build chain from last to first
-		chainedHandler := handler
-		for i := n - 1; i >= 0; i-- {
-			interceptor := interceptors[i]
-			nextHandler := chainedHandler
-			chainedHandler = func(currentCtx context.Context, currentReq interface{}) (interface{}, error) {
-				return interceptor(currentCtx, currentReq, info, nextHandler)
+// bucketSpec is a KV or Object Store bucket the method options of a service declare
+type bucketSpec struct {
+	methods        string // Methods declaring the bucket, for errors
+	kv             *jetstream.KeyValueConfig
+	limitMarkerTTL time.Duration // See ensureKVBucket
+	obj            *jetstream.ObjectStoreConfig
+}
+
+// provisionBuckets creates the buckets of specs that do not exist. An existing KV
+// bucket is checked by ensureKVBucket and an existing Object Store bucket updated.
+// With checkOnly, it only checks that each bucket exists. It stops at the first
+// bucket that fails.
+func provisionBuckets(ctx context.Context, js jetstream.JetStream, checkOnly bool, specs []bucketSpec) error {
+	for _, b := range specs {
+		var kind, name string
+		var err error
+		if b.kv != nil {
+			kind, name = "KV", b.kv.Bucket
+			if checkOnly {
+				_, err = js.KeyValue(ctx, name)
+			} else {
+				err = ensureKVBucket(ctx, js, *b.kv, b.limitMarkerTTL)
+			}
+		} else {
+			kind, name = "Object Store", b.obj.Bucket
+			if checkOnly {
+				_, err = js.ObjectStore(ctx, name)
+			} else {
+				_, err = js.CreateOrUpdateObjectStore(ctx, *b.obj)
 			}
 		}
-		return chainedHandler(ctx, req)
+		switch {
+		case err == nil:
+		case checkOnly && errors.Is(err, jetstream.ErrBucketNotFound):
+			return fmt.Errorf("%s bucket %q for %s does not exist; create it, or register without WithoutBucketProvisioning", kind, name, b.methods)
+		case checkOnly:
+			return fmt.Errorf("failed to check %s bucket %q for %s: %w", kind, name, b.methods, err)
+		default:
+			return fmt.Errorf("failed to provision %s bucket %q for %s: %w (use WithoutBucketProvisioning if the service may not create buckets)", kind, name, b.methods, err)
+		}
 	}
+	return nil
 }
 
-// natsClientConfig holds configuration for NATS clients
-type natsClientConfig struct {
-	subjectPrefix      string
-	clientInterceptors []UnaryClientInterceptor
-	js                 jetstream.JetStream // Optional JetStream for KV/ObjectStore reads
-}
+// ErrKVKeyExpired is returned by Get<Method>FromKV when the entry under the key
+// expired under its bucket's ttl, rather than never existing. It is only told
+// apart while the bucket keeps the entry's limit marker (kv_store limit_marker_ttl).
+// It wraps jetstream.ErrKeyNotFound, so checks for that still match.
+var ErrKVKeyExpired = fmt.Errorf("%w: entry expired", jetstream.ErrKeyNotFound)
 
-// NatsClientOption is a generic client configuration option
-// It uses an interface to allow configuration of any client type
-type NatsClientOption interface {
-	applyNatsClientOption(*natsClientConfig)
+// natsMarkerReasonHeader is the header of the marker JetStream leaves when it
+// removes a message, e.g., "MaxAge" when the message expired
+const natsMarkerReasonHeader = "Nats-Marker-Reason"
+
+// kvKeyExpired reports whether the last message for key in bucket is the marker
+// of its entry expiring
+func kvKeyExpired(ctx context.Context, js jetstream.JetStream, bucket, key string) bool {
+	stream, err := js.Stream(ctx, "KV_"+bucket)
+	if err != nil {
+		return false
+	}
+	last, err := stream.GetLastMsgForSubject(ctx, "$KV."+bucket+"."+key)
+	return err == nil && last.Header.Get(natsMarkerReasonHeader) == "MaxAge"
 }
 
-// natsClientOptionFunc is a function adapter for NatsClientOption
-type natsClientOptionFunc func(*natsClientConfig)
+// ObjectFramingHeader is set on Object Store objects that hold a body after the
+// encoded response (see SetObjectBody). Its value is the framing version, "1":
+// the object is the response's length as a uvarint, the encoded response, then
+// the body up to the end of the object. Objects without the header hold the
+// encoded response alone.
+const ObjectFramingHeader = "Nats-Micro-Framing"
 
-func (f natsClientOptionFunc) applyNatsClientOption(c *natsClientConfig) {
-	f(c)
+// maxFramedResponse bounds the response read ahead of a framed object's body
+const maxFramedResponse = 64 << 20
+
+// objectBodyKey is the context key of the slot SetObjectBody fills
+type objectBodyKey struct{}
+
+// objectBody is the body a handler streams into the Object Store
+type objectBody struct{ reader io.Reader }
+
+// SetObjectBody has the response of a method with (natsmicro.object_store)
+// persistence followed by body in its object, so a large payload is streamed into
+// the Object Store instead of held in the response message. Call it from the
+// handler and return the response without the payload; clients read both with
+// Open<Method>FromObjectStore. body is read after the handler returns, and
+// closed afterwards if it is an io.Closer, whether or not the response is
+// persisted. It reports false, and leaves body alone, outside such a handler or
+// without WithJetStream.
+func SetObjectBody(ctx context.Context, body io.Reader) bool {
+	slot, ok := ctx.Value(objectBodyKey{}).(*objectBody)
+	if !ok {
+		return false
+	}
+	slot.reader = body
+	return true
 }
 
-// WithNatsClientSubjectPrefix overrides the subject prefix for the client.
-// By default, the prefix from the proto definition is used.
-func WithNatsClientSubjectPrefix(prefix string) NatsClientOption {
-	return natsClientOptionFunc(func(c *natsClientConfig) {
-		c.subjectPrefix = prefix
-	})
+// close closes the body if it is an io.Closer
+func (b *objectBody) close() {
+	if closer, ok := b.reader.(io.Closer); ok {
+		closer.Close()
+	}
 }
 
-// WithClientInterceptor adds a unary client interceptor.
-// Interceptors are executed in the order they are added.
+// putObject stores an encoded response under key, framed and followed by body if
+// body is not nil (see ObjectFramingHeader)
+func putObject(ctx context.Context, obj jetstream.ObjectStore, key string, response []byte, body io.Reader) error {
+	if body == nil {
+		_, err := obj.PutBytes(ctx, key, response)
+		return err
+	}
+	meta := jetstream.ObjectMeta{Name: key, Headers: nats.Header{ObjectFramingHeader: {"1"}}}
+	size := binary.AppendUvarint(nil, uint64(len(response)))
+	_, err := obj.Put(ctx, meta, io.MultiReader(bytes.NewReader(size), bytes.NewReader(response), body))
+	return err
+}
+
+// objectReadAhead is how many chunks of an object a reader fetches ahead of its
+// caller, which bounds what a slow reader holds in memory
+const objectReadAhead = 4
+
+// getObject reads the metadata and encoded response stored under key. body yields
+// the rest of a framed object, and nothing for others; the caller must close it.
+func getObject(ctx context.Context, js jetstream.JetStream, obj jetstream.ObjectStore, key string) (info *jetstream.ObjectInfo, response []byte, body io.ReadCloser, err error) {
+	info, err = obj.GetInfo(ctx, key)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if link := info.Opts; link != nil && link.Link != nil {
+		if link.Link.Name == "" {
+			return nil, nil, nil, jetstream.ErrCantGetBucket
+		}
+		if obj, err = js.ObjectStore(ctx, link.Link.Bucket); err != nil {
+			return nil, nil, nil, err
+		}
+		return getObject(ctx, js, obj, link.Link.Name)
+	}
+	result, err := openObjectChunks(ctx, js, info)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	switch version := info.Headers.Get(ObjectFramingHeader); version {
+	case "":
+		response, err = io.ReadAll(result)
+		result.Close()
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		return info, response, io.NopCloser(bytes.NewReader(nil)), nil
+	case "1":
+	default:
+		result.Close()
+		return nil, nil, nil, fmt.Errorf("unsupported object framing %q", version)
+	}
+	r := bufio.NewReader(result)
+	size, err := binary.ReadUvarint(r)
+	if err == nil && size > maxFramedResponse {
+		err = fmt.Errorf("response of %d bytes exceeds %d", size, maxFramedResponse)
+	}
+	if err == nil {
+		response = make([]byte, size)
+		_, err = io.ReadFull(r, response)
+	}
+	if err != nil {
+		result.Close()
+		return nil, nil, nil, fmt.Errorf("read framed response: %w", err)
+	}
+	return info, response, struct {
+		io.Reader
+		io.Closer
+	}{r, result}, nil
+}
+
+// openObjectChunks returns a reader of the object info describes. Unlike
+// jetstream.ObjectStore.Get, whose subscription buffers chunks as fast as the
+// server sends them, it pulls objectReadAhead chunks at a time as they are read.
+func openObjectChunks(ctx context.Context, js jetstream.JetStream, info *jetstream.ObjectInfo) (io.ReadCloser, error) {
+	if info.NUID == "" {
+		return nil, jetstream.ErrBadObjectMeta
+	}
+	r := &objectChunkReader{info: info, left: info.Size, digest: sha256.New()}
+	if info.Size == 0 {
+		return r, nil
+	}
+	cons, err := js.OrderedConsumer(ctx, "OBJ_"+info.Bucket, jetstream.OrderedConsumerConfig{
+		FilterSubjects: []string{"$O." + info.Bucket + ".C." + info.NUID},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if r.chunks, err = cons.Messages(jetstream.PullMaxMessages(objectReadAhead)); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// objectChunkReader reads the chunks of an object, checking them against its
+// size and digest
+type objectChunkReader struct {
+	info   *jetstream.ObjectInfo
+	chunks jetstream.MessagesContext // nil for an empty object
+	chunk  []byte                    // Unread part of the current chunk
+	left   uint64                    // Bytes not yet fetched
+	digest hash.Hash
+	err    error // Sticky error, io.EOF once the object is read and verified
+}
+
+func (r *objectChunkReader) Read(p []byte) (int, error) {
+	for len(r.chunk) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		if r.left == 0 {
+			r.err = r.verify()
+			continue
+		}
+		msg, err := r.chunks.Next()
+		if err != nil {
+			r.err = err
+			continue
+		}
+		data := msg.Data()
+		if uint64(len(data)) > r.left {
+			r.err = fmt.Errorf("object %q holds more than its %d bytes", r.info.Name, r.info.Size)
+			continue
+		}
+		r.left -= uint64(len(data))
+		r.digest.Write(data)
+		r.chunk = data
+	}
+	n := copy(p, r.chunk)
+	r.chunk = r.chunk[n:]
+	return n, nil
+}
+
+// verify returns io.EOF if the chunks read match the object's digest
+func (r *objectChunkReader) verify() error {
+	want, err := jetstream.DecodeObjectDigest(r.info.Digest)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(r.digest.Sum(nil), want) {
+		return jetstream.ErrDigestMismatch
+	}
+	return io.EOF
+}
+
+func (r *objectChunkReader) Close() error {
+	if r.chunks != nil {
+		r.chunks.Stop()
+	}
+	return nil
+}
+
+// ObjectInfo describes a response persisted to an Object Store, as returned by
+// Open<Method>FromObjectStore
+type ObjectInfo[T any] struct {
+	Key      string
+	Response T           // The response stored ahead of the body, decoded
+	Size     uint64      // Size of the object, the framed response included
+	Digest   string      // Digest of the object (e.g., "SHA-256=...")
+	Modified time.Time   // When the object was written
+	Headers  nats.Header // Object headers, e.g., ObjectFramingHeader
+}
+
+// newObjectInfo returns the ObjectInfo of the object read under key
+func newObjectInfo[T any](key string, response T, info *jetstream.ObjectInfo) *ObjectInfo[T] {
+	return &ObjectInfo[T]{
+		Key:      key,
+		Response: response,
+		Size:     info.Size,
+		Digest:   info.Digest,
+		Modified: info.ModTime,
+		Headers:  info.Headers,
+	}
+}
+
+// contextReader is a reader that is closed when its context ends, so a Read
+// waiting for data returns; from then on Read returns the context's error
+type contextReader struct {
+	ctx    context.Context
+	r      io.ReadCloser
+	stop   func() bool // Stops the close on ctx end
+	once   sync.Once
+	closed error
+}
+
+// newContextReader returns r, closed when ctx ends
+func newContextReader(ctx context.Context, r io.ReadCloser) io.ReadCloser {
+	cr := &contextReader{ctx: ctx, r: r}
+	cr.stop = context.AfterFunc(ctx, func() { cr.close() })
+	return cr
+}
+
+func (r *contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	n, err := r.r.Read(p)
+	if err != nil && r.ctx.Err() != nil {
+		return n, r.ctx.Err()
+	}
+	return n, err
+}
+
+func (r *contextReader) Close() error {
+	r.stop()
+	return r.close()
+}
+
+func (r *contextReader) close() error {
+	r.once.Do(func() { r.closed = r.r.Close() })
+	return r.closed
+}
+
+// startCallInfo resets the CallInfo holder of ctx for a call to service on subject,
+// adding one to the returned context if the caller did not ask for it
+func startCallInfo(ctx context.Context, service, subject string) (context.Context, *callInfoHolder) {
+	holder, ok := ctx.Value(callInfoKey).(*callInfoHolder)
+	if !ok || holder == nil {
+		holder = &callInfoHolder{}
+		ctx = context.WithValue(ctx, callInfoKey, holder)
+	}
+	holder.mu.Lock()
+	holder.info = CallInfo{Service: service, Subject: subject}
+	holder.start = time.Now()
+	holder.mu.Unlock()
+	return ctx, holder
+}
+
+// attempt records a request of n bytes, replacing the sizes of an earlier attempt
+func (h *callInfoHolder) attempt(n int) {
+	h.mu.Lock()
+	h.info.Attempts++
+	h.info.RequestBytes = n
+	h.info.ResponseBytes = 0
+	h.mu.Unlock()
+}
+
+// sent adds a streamed request message of n bytes
+func (h *callInfoHolder) sent(n int) {
+	h.mu.Lock()
+	h.info.RequestBytes += n
+	h.mu.Unlock()
+}
+
+// received adds a response message of n bytes
+func (h *callInfoHolder) received(n int) {
+	h.mu.Lock()
+	h.info.ResponseBytes += n
+	h.info.Duration = time.Since(h.start)
+	h.mu.Unlock()
+}
+
+// responder records the instance a response names in ServiceInstanceHeader
+func (h *callInfoHolder) responder(headers nats.Header) {
+	h.mu.Lock()
+	h.info.Instance = headers.Get(ServiceInstanceHeader)
+	h.mu.Unlock()
+}
+
+// finish records the duration of the call
+func (h *callInfoHolder) finish() {
+	h.mu.Lock()
+	h.info.Duration = time.Since(h.start)
+	h.mu.Unlock()
+}
+
+// UnaryServerInfo contains information about a unary RPC
+type UnaryServerInfo struct {
+	Service            string // Service name
+	Method             string // Method name
+	FullMethod         string // Full proto name, the key of MethodDescriptors
+	Subject            string // NATS subject
+	AllowImpersonation bool   // (natsmicro.endpoint).allow_impersonation
+}
+
+// UnaryHandler is the actual handler function to be called
+type UnaryHandler func(ctx context.Context, req interface{}) (interface{}, error)
+
+// UnaryServerInterceptor is middleware that can intercept unary RPC calls on the server
+// It receives the context, request, RPC info, and the handler to call.
+// The interceptor can inspect/modify the request, handle auth, logging, etc.
+// It must call handler(ctx, req) to continue the chain or return early.
+type UnaryServerInterceptor func(ctx context.Context, req interface{}, info *UnaryServerInfo, handler UnaryHandler) (interface{}, error)
+
+// UnaryInvoker is called by a UnaryClientInterceptor to complete the RPC
+type UnaryInvoker func(ctx context.Context, method string, req, reply interface{}) error
+
+// UnaryClientInterceptor is middleware that can intercept unary RPC calls on the client
+// It receives the context, method name, request, reply, and invoker.
+// The interceptor can inspect/modify requests, handle retries, logging, etc.
+// It must call invoker(ctx, method, req, reply) to continue the chain.
+type UnaryClientInterceptor func(ctx context.Context, method string, req, reply interface{}, invoker UnaryInvoker) error
+
+// StreamInfo describes the stream a stream interceptor is called for
+type StreamInfo struct {
+	Service        string // Service name
+	Method         string // Method name
+	FullMethod     string // Full proto name, the key of MethodDescriptors
+	IsClientStream bool   // The client sends a stream of messages
+	IsServerStream bool   // The server sends a stream of messages
+}
+
+// ServerStream is a stream as server stream interceptors see it. The generated
+// <Service>_<Method>_Stream types implement it; an interceptor can pass the
+// handler a wrapper instead, and the handler's Send and Recv then go through it.
+type ServerStream interface {
+	// SendMsg sends a response message. On a client stream it sets the response
+	// sent once the handler returns.
+	SendMsg(msg proto.Message) error
+	// RecvMsg receives the next request message. On a server stream it returns
+	// the request the stream was opened with.
+	RecvMsg(ctx context.Context) (proto.Message, error)
+}
+
+// StreamHandler runs the handler of a stream
+type StreamHandler func(ctx context.Context, stream ServerStream) error
+
+// StreamServerInterceptor is middleware that can intercept server, client and bidi
+// streams on the server. It must call handler(ctx, stream) to continue the chain,
+// and may pass a wrapper of stream to observe or modify each message.
+type StreamServerInterceptor func(ctx context.Context, stream ServerStream, info *StreamInfo, handler StreamHandler) error
+
+// ClientStream is a stream as client stream interceptors see it. The generated
+// <Service>_<Method>_ClientStream types implement it; an interceptor can return a
+// wrapper instead, and the caller's Send, Recv and CloseAndRecv then go through it.
+type ClientStream interface {
+	// SendMsg sends a request message on a client or bidi stream
+	SendMsg(msg proto.Message) error
+	// RecvMsg receives the next response message. On a client stream it ends the
+	// stream and receives the response.
+	RecvMsg(ctx context.Context) (proto.Message, error)
+}
+
+// Streamer is called by a StreamClientInterceptor to open the stream
+type Streamer func(ctx context.Context, info *StreamInfo) (ClientStream, error)
+
+// StreamClientInterceptor is middleware that can intercept opening server, client
+// and bidi streams on the client. It must call streamer(ctx, info) to open the
+// stream, and returns that stream or a wrapper of it.
+type StreamClientInterceptor func(ctx context.Context, info *StreamInfo, streamer Streamer) (ClientStream, error)
+
+// registerConfig holds configuration for service registration
+type registerConfig struct {
+	name                 string
+	version              string
+	description          string
+	subjectPrefix        string
+	timeout              time.Duration
+	metadata             map[string]string
+	endpointMetadata     map[string]map[string]string // WithEndpointMetadata, by method name
+	statsHandler         micro.StatsHandler
+	doneHandler          micro.DoneHandler
+	errorHandler         micro.ErrHandler
+	serverInterceptors   []UnaryServerInterceptor
+	js                   jetstream.JetStream               // Optional JetStream context for KV/ObjectStore
+	cancelPropagation    bool                              // Cancel unary handlers when the client gives up
+	queueGroup           string                            // Endpoint queue group ("" = micro.DefaultQueueGroup)
+	noHealthEndpoint     bool                              // Skip registering the <prefix>.<service>.health endpoint
+	noReflectEndpoint    bool                              // Skip registering the <prefix>.<service>.$reflect endpoint
+	noSchemaEndpoint     bool                              // Skip registering the <prefix>.<service>.$schema endpoint
+	tokenSanitizer       func(string) string               // Escapes request fields interpolated into KV/Object Store keys
+	idGenerator          func() string                     // Mints stream inbox and persistent call IDs (nil = NUIDs)
+	maxRequestSize       int                               // Largest accepted request payload in bytes (0 = unlimited)
+	middlewares          map[string]UnaryServerInterceptor // Named middlewares for (natsmicro.endpoint).middlewares
+	streamWindow         int                               // Most unread server-stream messages before Send blocks (0 = no flow control)
+	streamAllowGaps      bool                              // Skip lost client-stream messages instead of failing Recv
+	streamCompression    int                               // Smallest stream message compressed, if the client accepts it (0 = off)
+	compression          int                               // Smallest unary response compressed, if the client accepts it (0 = off)
+	compressionCodec     string                            // Codec WithCompression named ("" = gzip)
+	insecureAllowed      bool                              // Skip the require_tls check
+	responseHeaders      *headerPolicy                     // Strips response headers (nil = allow all)
+	handlerWorkers       int                               // Unary handler pool size (0 = no pool, or one worker per endpoint if deadline-aware)
+	deadlineScheduling   bool                              // Run queued unary requests nearest their deadline first
+	group                *ServiceGroup                     // Register on this group's micro.Service instead of adding one
+	streamDrainGrace     time.Duration                     // How long streams run on after Drain sends their GOAWAY
+	requestCheck         *requestImmutabilityCheck         // Reports handlers that modify their request (nil = off)
+	streamInterceptors   []StreamServerInterceptor
+	sampling             *samplingConfig                // Samples unary calls for diagnostics (nil = off)
+	samplingSalt         string                         // Varies which calls are sampled
+	connMonitor          *connectionMonitorConfig       // Samples the connection (nil = off)
+	maxServerDeadline    time.Duration                  // Longest deadline honored from Nats-Deadline-Ms (0 = any)
+	coalesceWindow       time.Duration                  // Identical idempotent requests within it share a handler run (0 = off)
+	persistenceErrors    func(method string, err error) // Receives failed KV/Object Store writes (nil = print)
+	panicDiagnostics     *panicDiagnostics              // Recovers and reports handler panics (nil = off)
+	hold                 func(context.Context) error    // Holds requests until RegisterGroup activates the service (nil = serve at once)
+	noBucketProvisioning bool                           // Check that KV and Object Store buckets exist instead of creating them
+	idempotencyKV        jetstream.KeyValue             // Remembers requests by idempotency key (nil = off)
+	idempotencyTTL       time.Duration                  // How long responses stored in idempotencyKV are replayed
+	failureStore         RecorderStore                  // Records failed calls for replay (nil = off)
+	failureLimit         *int                           // Failures recorded per hour (nil = defaultFailureRecordLimit)
+	instanceSubjects     bool                           // Also serve unary endpoints on <subject>.<instance ID>
+}
+
+// RegisterOption configures the service registration
+type RegisterOption func(*registerConfig)
+
+// WithName overrides the service name from the proto definition
+func WithName(name string) RegisterOption {
+	return func(c *registerConfig) { c.name = name }
+}
+
+// WithVersion overrides the service version from the proto definition
+func WithVersion(version string) RegisterOption {
+	return func(c *registerConfig) { c.version = version }
+}
+
+// WithDescription overrides the service description from the proto definition
+func WithDescription(desc string) RegisterOption {
+	return func(c *registerConfig) { c.description = desc }
+}
+
+// WithSubjectPrefix overrides the subject prefix from the proto definition
+func WithSubjectPrefix(prefix string) RegisterOption {
+	return func(c *registerConfig) { c.subjectPrefix = prefix }
+}
+
+// WithTimeout sets the default timeout for all service methods.
+// This overrides the timeout configured in the proto definition.
+// Use 0 for no timeout (context.Background).
+func WithTimeout(timeout time.Duration) RegisterOption {
+	return func(c *registerConfig) { c.timeout = timeout }
+}
+
+// WithMetadata replaces all service metadata.
+// This completely overrides metadata defined in the proto definition.
+// Use WithAdditionalMetadata to merge with proto metadata instead.
+func WithMetadata(metadata map[string]string) RegisterOption {
+	return func(c *registerConfig) { c.metadata = metadata }
+}
+
+// WithAdditionalMetadata adds or updates metadata entries.
+// This merges with metadata defined in the proto definition.
+// Duplicate keys will override proto values.
+func WithAdditionalMetadata(metadata map[string]string) RegisterOption {
+	return func(c *registerConfig) {
+		for k, v := range metadata {
+			c.metadata[k] = v
+		}
+	}
+}
+
+// WithEndpointMetadata adds or updates metadata entries of one method's
+// endpoint, e.g. WithEndpointMetadata("GetProduct", map[string]string{"owner": "catalog"}).
+// It merges with the schema's description of the method and its
+// (natsmicro.endpoint).metadata, and `nats micro info` lists it. Registration
+// fails if the service has no such method.
+func WithEndpointMetadata(method string, metadata map[string]string) RegisterOption {
+	return func(c *registerConfig) {
+		if c.endpointMetadata == nil {
+			c.endpointMetadata = make(map[string]map[string]string)
+		}
+		c.endpointMetadata[method] = mergeMetadata(c.endpointMetadata[method], metadata)
+	}
+}
+
+// WithStatsHandler sets a callback for service statistics.
+// The handler is called periodically with endpoint stats including
+// request counts, error counts, and processing times.
+func WithStatsHandler(handler micro.StatsHandler) RegisterOption {
+	return func(c *registerConfig) { c.statsHandler = handler }
+}
+
+// WithDoneHandler sets a callback invoked when the service stops
+func WithDoneHandler(handler micro.DoneHandler) RegisterOption {
+	return func(c *registerConfig) { c.doneHandler = handler }
+}
+
+// WithErrorHandler sets a callback for handling service-level errors
+func WithErrorHandler(handler micro.ErrHandler) RegisterOption {
+	return func(c *registerConfig) { c.errorHandler = handler }
+}
+
+// WithJetStream provides a JetStream context for KV/ObjectStore operations.
+// Required only if any methods use (natsmicro.kv_store) or (natsmicro.object_store) options.
+// Registration then creates the buckets those options declare; see WithoutBucketProvisioning.
+// If not provided, KV/ObjectStore operations will be silently skipped.
+func WithJetStream(js jetstream.JetStream) RegisterOption {
+	return func(c *registerConfig) { c.js = js }
+}
+
+// WithoutBucketProvisioning has registration check that the KV and Object Store
+// buckets the method options declare exist, instead of creating them, for
+// services whose credentials may not manage JetStream streams. Registration fails
+// naming the first missing bucket. It has no effect without WithJetStream.
+func WithoutBucketProvisioning() RegisterOption {
+	return func(c *registerConfig) { c.noBucketProvisioning = true }
+}
+
+// WithPersistenceErrorHandler passes each failed write of a response to a KV or
+// Object Store bucket to handler, with the method's full proto name, instead of
+// printing a warning. Persistence is best-effort: the call still succeeds.
+func WithPersistenceErrorHandler(handler func(method string, err error)) RegisterOption {
+	return func(c *registerConfig) { c.persistenceErrors = handler }
+}
+
+// reportPersistenceError passes err to handler, or prints it without one
+func reportPersistenceError(handler func(method string, err error), method string, err error) {
+	if handler != nil {
+		handler(method, err)
+		return
+	}
+	fmt.Fprintf(os.Stderr, "[nats-micro] WARN: %s: %v\n", method, err)
+}
+
+// WithServerInterceptor adds a unary server interceptor to the service.
+// Interceptors run in the order they are added: the first one added is the
+// outermost, seeing the request first and the response last.
+// Use for cross-cutting concerns like logging, auth, metrics, tracing.
+func WithServerInterceptor(interceptor UnaryServerInterceptor) RegisterOption {
+	return func(c *registerConfig) {
+		c.serverInterceptors = append(c.serverInterceptors, interceptor)
+	}
+}
+
+// WithServerStreamInterceptor adds a server stream interceptor to the service.
+// Stream interceptors run around server, client and bidi stream handlers, in the
+// order they are added. WithServerInterceptor interceptors do not see streams.
+func WithServerStreamInterceptor(interceptor StreamServerInterceptor) RegisterOption {
+	return func(c *registerConfig) {
+		c.streamInterceptors = append(c.streamInterceptors, interceptor)
+	}
+}
+
+// WithServerInterceptorChain replaces the interceptors added so far, by
+// WithServerInterceptor or an earlier chain, with interceptors, outermost first.
+// Pass it after the options of libraries that add interceptors to decide where
+// yours run among theirs.
+func WithServerInterceptorChain(interceptors ...UnaryServerInterceptor) RegisterOption {
+	return func(c *registerConfig) {
+		c.serverInterceptors = append([]UnaryServerInterceptor(nil), interceptors...)
+	}
+}
+
+// interceptorNames holds the names given with Named, by closure
+var interceptorNames sync.Map // unsafe.Pointer -> string
+
+// Named gives interceptor a name, which the InterceptorChain method of
+// registered services reports
+func Named(name string, interceptor UnaryServerInterceptor) UnaryServerInterceptor {
+	named := UnaryServerInterceptor(func(ctx context.Context, req interface{}, info *UnaryServerInfo, handler UnaryHandler) (interface{}, error) {
+		return interceptor(ctx, req, info, handler)
+	})
+	interceptorNames.Store(closurePointer(named), name)
+	return named
+}
+
+// closurePointer returns the closure interceptor refers to. Unlike its code
+// pointer, it tells apart the interceptors returned by different Named calls.
+func closurePointer(interceptor UnaryServerInterceptor) unsafe.Pointer {
+	return *(*unsafe.Pointer)(unsafe.Pointer(&interceptor))
+}
+
+// interceptorName returns the name interceptor was given with Named, or else
+// the name of its function
+func interceptorName(interceptor UnaryServerInterceptor) string {
+	if name, ok := interceptorNames.Load(closurePointer(interceptor)); ok {
+		return name.(string)
+	}
+	if fn := runtime.FuncForPC(reflect.ValueOf(interceptor).Pointer()); fn != nil {
+		return fn.Name()
+	}
+	return "unknown"
+}
+
+// interceptorChainNames names each of interceptors, outermost first
+func interceptorChainNames(interceptors []UnaryServerInterceptor) []string {
+	names := make([]string, len(interceptors))
+	for i, interceptor := range interceptors {
+		names[i] = interceptorName(interceptor)
+	}
+	return names
+}
+
+// WithMiddlewareRegistry provides the interceptors that (natsmicro.endpoint).middlewares
+// refer to by name. A method's middlewares run in proto order, after the interceptors
+// from WithServerInterceptor. Registration fails if a method names a middleware that
+// is not in the registry. Repeated calls merge their registries.
+func WithMiddlewareRegistry(registry map[string]UnaryServerInterceptor) RegisterOption {
+	return func(c *registerConfig) {
+		if c.middlewares == nil {
+			c.middlewares = make(map[string]UnaryServerInterceptor, len(registry))
+		}
+		for name, interceptor := range registry {
+			c.middlewares[name] = interceptor
+		}
+	}
+}
+
+// WithQueueGroup sets the queue group joined by every endpoint of the service,
+// overriding (natsmicro.service).queue_group. Replicas in the same queue group
+// split requests; replicas in different groups each receive every request.
+// Streaming endpoints are safe to share: only the opening request is load-balanced,
+// later frames go to the inbox of the replica that accepted the stream.
+func WithQueueGroup(name string) RegisterOption {
+	return func(c *registerConfig) { c.queueGroup = name }
+}
+
+// WithInstanceSubjects also serves each unary endpoint on a subject of this
+// instance's own, <subject>.<instance ID>, so a client can send a call to exactly
+// this instance with WithTargetInstance. Every unary response then names the
+// instance in ServiceInstanceHeader, which clients read with ResponderInstance.
+// Methods with a subject_template keep their one subject.
+// Example:
+//
+//	RegisterOrderServiceHandlers(nc, impl, WithInstanceSubjects())
+func WithInstanceSubjects() RegisterOption {
+	return func(c *registerConfig) { c.instanceSubjects = true }
+}
+
+// WithCancelPropagation cancels a unary handler's context when the client
+// abandons the request (see WithNatsClientCancelPropagation).
+// The service subscribes to _NATS_MICRO.cancel.* and receives every
+// cancel notice on the connection, so only enable it when handlers are long-running.
+func WithCancelPropagation() RegisterOption {
+	return func(c *registerConfig) { c.cancelPropagation = true }
+}
+
+// WithTokenSanitizer replaces SanitizeToken as the function that escapes request
+// fields interpolated into key templates (KV, Object Store and enrichment keys).
+// Clients that build the same keys need WithNatsClientTokenSanitizer with the same function.
+func WithTokenSanitizer(sanitize func(string) string) RegisterOption {
+	return func(c *registerConfig) {
+		if sanitize != nil {
+			c.tokenSanitizer = sanitize
+		}
+	}
+}
+
+// WithIDGenerator replaces the NUIDs the service mints for stream inboxes and
+// persistent stream calls. IDs pass through SanitizeToken, so they stay single subject
+// tokens; an empty ID falls back to a NUID. Handlers and interceptors reach the
+// generator through NewID(ctx).
+func WithIDGenerator(gen func() string) RegisterOption {
+	return func(c *registerConfig) { c.idGenerator = gen }
+}
+
+// WithStreamWindow caps the number of messages a server stream may have sent but the
+// client not yet read; past it, Send blocks until the client catches up or the
+// handler's context ends. The window is the smaller of n and the client's
+// WithClientStreamWindow (default 64 for both). Clients that ask for no window, such
+// as other languages' clients, get no flow control. n <= 0 disables flow control.
+func WithStreamWindow(n int) RegisterOption {
+	return func(c *registerConfig) { c.streamWindow = n }
+}
+
+// WithStreamAllowGaps makes Recv on client and bidi streams skip messages lost in
+// transit, as it did before sequence checks, instead of returning an
+// *ErrStreamMessageLost. Clients use WithClientStreamAllowGaps.
+func WithStreamAllowGaps() RegisterOption {
+	return func(c *registerConfig) { c.streamAllowGaps = true }
+}
+
+// WithStreamCompression gzips stream messages of at least threshold bytes, in
+// both directions, on streams whose client accepts it (see
+// WithClientStreamCompression). Messages gzip does not make smaller are sent as
+// they are; after 8 of them in a row the stream doubles its threshold, up to 1 MiB,
+// and returns to threshold once one shrinks. Each message says whether it is
+// compressed, so clients without compression support, including other languages'
+// clients, get plain messages. Handlers of server streams find the outcome in
+// Options().Encoding. threshold <= 0 compresses every message that shrinks.
+// Persistent streams are not compressed.
+func WithStreamCompression(threshold int) RegisterOption {
+	return func(c *registerConfig) { c.streamCompression = max(threshold, 1) }
+}
+
+// WithCompression compresses unary responses of at least minSize bytes with codec,
+// "gzip" or one added with RegisterCompressor, for clients that list codec in their
+// Accept-Encoding, as generated Go clients list every codec they have registered.
+// Compressed responses name codec in ContentEncodingHeader; responses codec
+// doesn't make smaller are sent as they are. Streams are compressed with codec too,
+// as WithStreamCompression(minSize) does, unless WithStreamCompression sets their
+// threshold. Requests are decompressed whenever they name a registered codec, with
+// or without this option. Registration fails if codec is not registered.
+func WithCompression(codec string, minSize int) RegisterOption {
+	return func(c *registerConfig) {
+		c.compression, c.compressionCodec = max(minSize, 1), codec
+		if c.streamCompression == 0 {
+			c.streamCompression = c.compression
+		}
+	}
+}
+
+// WithInsecureServiceAllowed lets services with (natsmicro.service).require_tls
+// register on a plaintext connection. Meant for local development only.
+func WithInsecureServiceAllowed() RegisterOption {
+	return func(c *registerConfig) { c.insecureAllowed = true }
+}
+
+// ErrInsecureConnection is returned when a service with (natsmicro.service).require_tls
+// is registered on, or called over, a connection that does not use TLS
+var ErrInsecureConnection = errors.New("connection does not use TLS")
+
+// requireTLS returns an error wrapping ErrInsecureConnection if any of conns is
+// connected without TLS. Disconnected connections are not reported.
+func requireTLS(service string, conns ...*nats.Conn) error {
+	for _, nc := range conns {
+		if _, err := nc.TLSConnectionState(); errors.Is(err, nats.ErrConnectionNotTLS) {
+			return fmt.Errorf("%s requires a TLS connection (require_tls), connected to %s: %w", service, nc.ConnectedUrlRedacted(), ErrInsecureConnection)
+		}
+	}
+	return nil
+}
+
+// WithResponseHeaderPolicy strips response headers the service may not send: those
+// set with SetResponseHeaders, by handlers or interceptors, on unary responses and
+// on the first message of server and bidi streams. Names match case-insensitively,
+// as in NATS. deny wins over allow; an empty allow list allows every header not
+// denied. Content-Type and the stream and error headers the service adds itself are
+// never stripped. Stripped headers are counted per endpoint in the endpoint stats
+// Data, a HeaderPolicyStats. Without this option every header is sent.
+// Example:
+//
+//	RegisterOrderServiceHandlers(nc, impl,
+//		WithResponseHeaderPolicy(nil, []string{"X-Internal-Trace", "Set-Cookie"}))
+func WithResponseHeaderPolicy(allow, deny []string) RegisterOption {
+	return func(c *registerConfig) { c.responseHeaders = newHeaderPolicy(allow, deny) }
+}
+
+// HeaderPolicyStats is the endpoint stats Data of services registered with
+// WithResponseHeaderPolicy
+type HeaderPolicyStats struct {
+	ResponseHeadersStripped uint64 `json:"response_headers_stripped"`
+	Data                    any    `json:"data,omitempty"` // What the WithStatsHandler handler returned
+}
+
+// headerPolicy decides which headers may be sent, and counts the ones it strips
+// by endpoint
+type headerPolicy struct {
+	allow    map[string]bool // Lower-cased names (empty = allow all)
+	deny     map[string]bool // Lower-cased names
+	stripped sync.Map        // Endpoint name -> *atomic.Uint64
+}
+
+func newHeaderPolicy(allow, deny []string) *headerPolicy {
+	p := &headerPolicy{allow: map[string]bool{}, deny: map[string]bool{}}
+	for _, name := range allow {
+		p.allow[strings.ToLower(name)] = true
+	}
+	for _, name := range deny {
+		p.deny[strings.ToLower(name)] = true
+	}
+	return p
+}
+
+// allows reports whether the header name may be sent
+func (p *headerPolicy) allows(name string) bool {
+	name = strings.ToLower(name)
+	if p.deny[name] {
+		return false
+	}
+	return len(p.allow) == 0 || p.allow[name]
+}
+
+// filter returns headers without the ones p does not allow, and how many it
+// removed. headers is not modified. A nil p allows everything.
+func (p *headerPolicy) filter(headers nats.Header) (nats.Header, int) {
+	if p == nil || len(headers) == 0 {
+		return headers, 0
+	}
+	kept := make(nats.Header, len(headers))
+	for name, values := range headers {
+		if p.allows(name) {
+			kept[name] = values
+		}
+	}
+	return kept, len(headers) - len(kept)
+}
+
+// strip is filter for the responses of endpoint, counting what it removes
+func (p *headerPolicy) strip(endpoint string, headers nats.Header) nats.Header {
+	kept, n := p.filter(headers)
+	if n > 0 {
+		count, _ := p.stripped.LoadOrStore(endpoint, new(atomic.Uint64))
+		count.(*atomic.Uint64).Add(uint64(n))
+	}
+	return kept
+}
+
+// statsHandler reports the headers p stripped from each endpoint as a
+// HeaderPolicyStats, wrapping the Data of next
+func (p *headerPolicy) statsHandler(next micro.StatsHandler) micro.StatsHandler {
+	return func(e *micro.Endpoint) any {
+		stats := HeaderPolicyStats{}
+		if count, ok := p.stripped.Load(e.Name); ok {
+			stats.ResponseHeadersStripped = count.(*atomic.Uint64).Load()
+		}
+		if next != nil {
+			stats.Data = next(e)
+		}
+		return stats
+	}
+}
+
+// WithStreamDrainGrace lets server and bidi stream handlers run for grace after
+// Drain starts, instead of canceling their contexts right away. Drain first sends
+// each stream a GOAWAY with the deadline, which clients see as an
+// *ErrServerDraining; a client closing its stream ends the handler's context early.
+func WithStreamDrainGrace(grace time.Duration) RegisterOption {
+	return func(c *registerConfig) { c.streamDrainGrace = grace }
+}
+
+// WithoutHealthEndpoint skips registering the <prefix>.<service>.health endpoint
+func WithoutHealthEndpoint() RegisterOption {
+	return func(c *registerConfig) { c.noHealthEndpoint = true }
+}
+
+// WithoutReflectEndpoint skips registering the <prefix>.<service>.$reflect endpoint.
+// The schema_hash service metadata is still advertised.
+func WithoutReflectEndpoint() RegisterOption {
+	return func(c *registerConfig) { c.noReflectEndpoint = true }
+}
+
+// WithoutSchemaEndpoint skips registering the <prefix>.<service>.$schema endpoint
+func WithoutSchemaEndpoint() RegisterOption {
+	return func(c *registerConfig) { c.noSchemaEndpoint = true }
+}
+
+// WithMaxRequestSize rejects requests whose payload exceeds bytes with a
+// RESOURCE_EXHAUSTED error, before decoding them and without calling the
+// implementation. It also bounds each message of a client or bidi stream.
+// The limit is reported as max_request_size endpoint metadata. 0 means unlimited.
+func WithMaxRequestSize(bytes int) RegisterOption {
+	return func(c *registerConfig) { c.maxRequestSize = bytes }
+}
+
+// RequestMutationReporter receives WithRequestImmutabilityCheck reports.
+// testing.TB satisfies it, so a test using the check fails on the first mutation.
+type RequestMutationReporter interface {
+	Errorf(format string, args ...any)
+}
+
+// WithRequestImmutabilityCheck reports unary and fire-and-forget handlers that
+// modify their request message, which interceptors still hold once the handler
+// returns. The request is hashed before and after the handler, costing one
+// extra deterministic marshal each way; requests above maxBytes (0 = no limit)
+// are not checked. A nil reporter writes the reports to stderr as warnings.
+func WithRequestImmutabilityCheck(reporter RequestMutationReporter, maxBytes int) RegisterOption {
+	if reporter == nil {
+		reporter = stderrReporter{}
+	}
+	return func(c *registerConfig) {
+		c.requestCheck = &requestImmutabilityCheck{reporter: reporter, maxBytes: maxBytes}
+	}
+}
+
+// stderrReporter writes reports to stderr as warnings
+type stderrReporter struct{}
+
+func (stderrReporter) Errorf(format string, args ...any) {
+	fmt.Fprintf(os.Stderr, "[nats-micro] WARN: "+format+"\n", args...)
+}
+
+// requestImmutabilityCheck compares request hashes from before and after a handler
+type requestImmutabilityCheck struct {
+	reporter RequestMutationReporter
+	maxBytes int
+}
+
+// check hashes req and returns a function that hashes it again once method's
+// handler has returned, reporting any difference. A nil check does nothing.
+func (c *requestImmutabilityCheck) check(method string, req proto.Message) func() {
+	if c == nil {
+		return func() {}
+	}
+	before, ok := c.hash(req)
+	if !ok {
+		return func() {}
+	}
+	return func() {
+		if after, ok := c.hash(req); !ok || after != before {
+			c.reporter.Errorf("%s modified its request message", method)
+		}
+	}
+}
+
+// hash returns the FNV-1a hash of req's deterministic encoding, or false if req
+// is above the size threshold or cannot be encoded
+func (c *requestImmutabilityCheck) hash(req proto.Message) (uint64, bool) {
+	if c.maxBytes > 0 && proto.Size(req) > c.maxBytes {
+		return 0, false
+	}
+	data, err := proto.MarshalOptions{Deterministic: true}.Marshal(req)
+	if err != nil {
+		return 0, false
+	}
+	h := fnv.New64a()
+	h.Write(data)
+	return h.Sum64(), true
+}
+
+// serviceVersionRegexp matches the semantic versions micro.AddService accepts
+var serviceVersionRegexp = regexp.MustCompile(`^(0|[1-9]\d*)\.(0|[1-9]\d*)\.(0|[1-9]\d*)(?:-((?:0|[1-9]\d*|\d*[a-zA-Z-][0-9a-zA-Z-]*)(?:\.(?:0|[1-9]\d*|\d*[a-zA-Z-][0-9a-zA-Z-]*))*))?(?:\+([0-9a-zA-Z-]+(?:\.[0-9a-zA-Z-]+)*))?$`)
+
+// serviceHost is the micro.Service generated handlers are registered on, with
+// the state those handlers share
+type serviceHost struct {
+	svc       micro.Service
+	cancels   *cancelRegistry   // Client cancel notices (nil without WithCancelPropagation)
+	inflight  *inflightTracker  // Running handlers, for Drain
+	pool      *handlerPool      // Unary handler queue (nil without a handler pool)
+	coalescer *requestCoalescer // Shares handler runs between identical requests (nil = off)
+}
+
+// addServiceHost adds the micro.Service cfg describes, advertising metadata
+func addServiceHost(nc *nats.Conn, cfg *registerConfig, metadata map[string]string) (*serviceHost, error) {
+	// micro.AddService only reports a config validation error
+	if !serviceVersionRegexp.MatchString(cfg.version) {
+		return nil, fmt.Errorf("service %s: version %q is not a semantic version such as 1.2.3; set it with WithVersion or (natsmicro.service).version", cfg.name, cfg.version)
+	}
+
+	// Watch for client cancel notices; the subscription ends when the service stops
+	doneHandler := cfg.doneHandler
+	var cancels *cancelRegistry
+	if cfg.cancelPropagation {
+		var err error
+		if cancels, err = newCancelRegistry(nc); err != nil {
+			return nil, fmt.Errorf("failed to subscribe to cancel notices: %w", err)
+		}
+		doneHandler = func(s micro.Service) {
+			cancels.close()
+			if cfg.doneHandler != nil {
+				cfg.doneHandler(s)
+			}
+		}
+	}
+
+	// Queue unary requests on a handler pool if asked to; its workers finish the
+	// queue after the service stops. Handler contexts carry the ID generator for NewID.
+	inflight := newInflightTracker(withIDGenerator(context.Background(), cfg.idGenerator))
+	inflight.nc = nc
+	inflight.grace = cfg.streamDrainGrace
+	pool := newHandlerPool(cfg, inflight)
+	if pool != nil {
+		next := doneHandler
+		doneHandler = func(s micro.Service) {
+			pool.close()
+			if next != nil {
+				next(s)
+			}
+		}
+	}
+
+	coalescer := newRequestCoalescer(cfg)
+
+	// Sample the connection from when the service is added until it stops
+	var monitor *connectionMonitor
+	if cfg.connMonitor != nil {
+		next := doneHandler
+		doneHandler = func(s micro.Service) {
+			monitor.close()
+			if next != nil {
+				next(s)
+			}
+		}
+	}
+
+	// Report the headers the response header policy strips as endpoint stats
+	statsHandler := cfg.statsHandler
+	if cfg.responseHeaders != nil {
+		statsHandler = cfg.responseHeaders.statsHandler(cfg.statsHandler)
+	}
+	if pool != nil {
+		statsHandler = pool.statsHandler(statsHandler)
+	}
+	if coalescer != nil {
+		statsHandler = coalescer.statsHandler(statsHandler)
+	}
+
+	svc, err := micro.AddService(nc, micro.Config{
+		Name:         cfg.name,
+		Version:      cfg.version,
+		Metadata:     metadata,
+		Description:  cfg.description,
+		StatsHandler: statsHandler,
+		DoneHandler:  doneHandler,
+		ErrorHandler: cfg.errorHandler,
+		QueueGroup:   cfg.queueGroup,
+	})
+	if err != nil {
+		if cancels != nil {
+			cancels.close()
+		}
+		return nil, fmt.Errorf("failed to add service: %w", err)
+	}
+	monitor = startConnectionMonitor(cfg.connMonitor, nc)
+	return &serviceHost{svc: svc, cancels: cancels, inflight: inflight, pool: pool, coalescer: coalescer}, nil
+}
+
+// ServiceGroup is one micro.Service several generated services register their
+// endpoints on, so service discovery shows a single service with one name and
+// version listing all of them:
+//
+//	group, err := NewServiceGroup(nc, "catalog", "1.0.0", WithServerInterceptor(auth))
+//	...
+//	_, err = RegisterProductServiceHandlers(nc, products, WithServiceGroup(group))
+//	...
+//	_, err = RegisterInventoryServiceHandlers(nc, inventory, WithServiceGroup(group))
+//
+// Within a group, endpoint names are qualified with the service name
+// ("product_service-get_product", "product_service-health"), each service keeps
+// its subject prefix and queue group, and its schema_hash is advertised as
+// endpoint metadata.
+type ServiceGroup struct {
+	micro.Service
+	host *serviceHost
+	opts []RegisterOption
+}
+
+// NewServiceGroup adds the micro.Service of a ServiceGroup. opts configure it,
+// and apply to every service registered on the group before that service's own
+// options. Options of the micro.Service itself — WithName, WithVersion,
+// WithDescription, the metadata, stats, done and error handler options,
+// WithCancelPropagation, WithStreamDrainGrace, WithRequestCoalescing and the handler
+// pool options — only take effect here.
+func NewServiceGroup(nc *nats.Conn, name, version string, opts ...RegisterOption) (*ServiceGroup, error) {
+	cfg := &registerConfig{name: name, version: version, metadata: map[string]string{}}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	host, err := addServiceHost(nc, cfg, cfg.metadata)
+	if err != nil {
+		return nil, err
+	}
+	return &ServiceGroup{Service: host.svc, host: host, opts: opts}, nil
+}
+
+// Drain drains every service of the group, as the Drain of a registered service does
+func (g *ServiceGroup) Drain(ctx context.Context) error {
+	return g.host.inflight.drain(ctx, func() error { return stopEndpoints(g.Service) })
+}
+
+// WithServiceGroup registers the service's endpoints on group instead of adding
+// a micro.Service for it, after applying the options the group was created
+// with. Pass it first so the service's own options override the group's. Stop
+// and Drain of the returned service act on the whole group.
+func WithServiceGroup(group *ServiceGroup) RegisterOption {
+	return func(c *registerConfig) {
+		for _, opt := range group.opts {
+			opt(c)
+		}
+		c.group = group
+	}
+}
+
+// existingServiceHost hosts generated handlers on a micro.Service the caller
+// added, for the Add<Service>Endpoints functions. It rejects the options that
+// configure adding the micro.Service or need to know when it stops.
+func existingServiceHost(nc *nats.Conn, svc micro.Service, cfg *registerConfig) (*serviceHost, error) {
+	var rejected []string
+	for _, o := range []struct {
+		set  bool
+		name string
+	}{
+		{cfg.name != "", "WithName"},
+		{cfg.version != "", "WithVersion"},
+		{cfg.description != "", "WithDescription"},
+		{len(cfg.metadata) > 0, "WithMetadata/WithAdditionalMetadata"},
+		{cfg.statsHandler != nil, "WithStatsHandler"},
+		{cfg.doneHandler != nil, "WithDoneHandler"},
+		{cfg.errorHandler != nil, "WithErrorHandler"},
+		{cfg.cancelPropagation, "WithCancelPropagation"},
+		{cfg.streamDrainGrace != 0, "WithStreamDrainGrace"},
+		{cfg.handlerWorkers != 0 || cfg.deadlineScheduling, "WithHandlerPool/WithDeadlineAwareScheduling"},
+		{cfg.coalesceWindow != 0, "WithRequestCoalescing"},
+		{cfg.connMonitor != nil, "WithConnectionMonitor/WithConnectionAlarm"},
+		{cfg.group != nil, "WithServiceGroup"},
+	} {
+		if o.set {
+			rejected = append(rejected, o.name)
+		}
+	}
+	if len(rejected) > 0 {
+		return nil, fmt.Errorf("%s only apply when registration adds the micro.Service; configure the service passed in instead", strings.Join(rejected, ", "))
+	}
+	inflight := newInflightTracker(withIDGenerator(context.Background(), cfg.idGenerator))
+	inflight.nc = nc
+	return &serviceHost{svc: svc, inflight: inflight}, nil
+}
+
+// Drainer is a registered service or ServiceGroup, drained by RunServices.
+// Services of every generated package implement it.
+type Drainer interface {
+	Drain(ctx context.Context) error
+}
+
+// RunServices blocks until ctx ends, then drains services concurrently and
+// returns their errors joined. Draining waits at most drainTimeout for in-flight
+// handlers (0 = no limit). Close the connection, or stop an embedded server,
+// only after it returns, so handlers can still answer while they drain:
+//
+//	err := RunServices(ctx, 10*time.Second, products, orders)
+//	shutdown() // e.g., from natsembed.Start
+func RunServices(ctx context.Context, drainTimeout time.Duration, services ...Drainer) error {
+	<-ctx.Done()
+	drainCtx, cancel := context.Background(), context.CancelFunc(func() {})
+	if drainTimeout > 0 {
+		drainCtx, cancel = context.WithTimeout(drainCtx, drainTimeout)
+	}
+	defer cancel()
+	return drainServices(drainCtx, services)
+}
+
+// drainServices drains services concurrently and joins their errors
+func drainServices[S Drainer](ctx context.Context, services []S) error {
+	errs := make([]error, len(services))
+	var wg sync.WaitGroup
+	for i, svc := range services {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = svc.Drain(ctx)
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// GroupService is a service registered by RegisterGroup. Registered services of
+// every generated package are one.
+type GroupService = interface {
+	micro.Service
+	Drain(ctx context.Context) error
+}
+
+// Registration is a service registration RegisterGroup performs, made with the
+// <Service>Registration function of any generated package. Register registers the
+// service with every endpoint's requests passed to hold, which returns nil once
+// they may be served.
+type Registration interface {
+	Register(nc *nats.Conn, hold func(context.Context) error) (GroupService, error)
+}
+
+// registrationFunc is the Registration <Service>Registration returns
+type registrationFunc func(nc *nats.Conn, hold func(context.Context) error) (GroupService, error)
+
+func (f registrationFunc) Register(nc *nats.Conn, hold func(context.Context) error) (GroupService, error) {
+	return f(nc, hold)
+}
+
+// withRequestHold has every endpoint's requests wait for hold before they are handled
+func withRequestHold(hold func(context.Context) error) RegisterOption {
+	return func(c *registerConfig) { c.hold = hold }
+}
+
+// RegisteredGroup is the services RegisterGroup registered, which serve together
+type RegisteredGroup struct {
+	services []GroupService
+}
+
+// RegisterGroup registers services all or nothing, for processes that should not
+// run with some of their services missing:
+//
+//	group, err := RegisterGroup(nc,
+//		productv1.ProductServiceRegistration(products),
+//		orderv1.OrderServiceRegistration(orders, orderv1.WithJetStream(js)),
+//	)
+//
+// Every service subscribes as it registers, but requests that arrive before the
+// last registration succeeds wait without reaching any handler, health endpoints
+// included. Then all are served at once. If a registration fails, the services
+// registered before it are stopped, the waiting requests get UNAVAILABLE, and the
+// error is returned; no handler has run. A registration that fails on a
+// ServiceGroup leaves the endpoints it added on the group.
+func RegisterGroup(nc *nats.Conn, registrations ...Registration) (*RegisteredGroup, error) {
+	gate := &registrationGate{open: make(chan struct{}), abort: make(chan struct{})}
+	group := &RegisteredGroup{}
+	for i, r := range registrations {
+		svc, err := r.Register(nc, gate.wait)
+		if err != nil {
+			close(gate.abort)
+			err = fmt.Errorf("registration %d of %d failed: %w", i+1, len(registrations), err)
+			return nil, errors.Join(err, group.Stop())
+		}
+		group.services = append(group.services, svc)
+	}
+	close(gate.open)
+	return group, nil
+}
+
+// Services returns the registered services, in registration order
+func (g *RegisteredGroup) Services() []GroupService {
+	return append([]GroupService(nil), g.services...)
+}
+
+// Endpoints returns the endpoints of every service. Services sharing a
+// ServiceGroup list the group's endpoints once.
+func (g *RegisteredGroup) Endpoints() []micro.EndpointInfo {
+	var endpoints []micro.EndpointInfo
+	seen := make(map[string]bool)
+	for _, svc := range g.services {
+		for _, e := range svc.Info().Endpoints {
+			if key := e.Name + " " + e.Subject; !seen[key] {
+				seen[key] = true
+				endpoints = append(endpoints, e)
+			}
+		}
+	}
+	return endpoints
+}
+
+// Stop stops every service and joins their errors
+func (g *RegisteredGroup) Stop() error {
+	var errs []error
+	for _, svc := range g.services {
+		errs = append(errs, svc.Stop())
+	}
+	return errors.Join(errs...)
+}
+
+// Drain drains every service concurrently, as the Drain of a registered service
+// does, and joins their errors
+func (g *RegisteredGroup) Drain(ctx context.Context) error {
+	return drainServices(ctx, g.services)
+}
+
+// Run serves until ctx ends, then drains the group, as RunServices does
+func (g *RegisteredGroup) Run(ctx context.Context, drainTimeout time.Duration) error {
+	return RunServices(ctx, drainTimeout, g)
+}
+
+// registrationGate holds the requests of a RegisterGroup until every service is registered
+type registrationGate struct {
+	open  chan struct{} // Closed once every registration succeeded
+	abort chan struct{} // Closed when a registration failed
+}
+
+// wait blocks until the gate opens, returning an UNAVAILABLE *Status if the
+// registration is rolled back instead
+func (g *registrationGate) wait(ctx context.Context) error {
+	select {
+	case <-g.open:
+		return nil
+	default:
+	}
+	select {
+	case <-g.open:
+		return nil
+	case <-g.abort:
+		return Statusf(CodeUnavailable, "service registration was rolled back")
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// holdRequests has handler wait for hold before each request. A nil hold returns handler.
+func holdRequests(hold func(context.Context) error, handler micro.Handler) micro.Handler {
+	if hold == nil {
+		return handler
+	}
+	return micro.HandlerFunc(func(req micro.Request) {
+		if err := hold(context.Background()); err != nil {
+			code, message, data := natsErrorFields(err)
+			req.Error(code, message, data)
+			return
+		}
+		handler.Handle(req)
+	})
+}
+
+// checkPayloadSize returns a RESOURCE_EXHAUSTED *Status if a payload of size bytes
+// exceeds limit, or nil when it fits or limit is 0 (unlimited).
+func checkPayloadSize(kind string, size, limit int) error {
+	if limit <= 0 || size <= limit {
+		return nil
+	}
+	return Statusf(CodeResourceExhausted, "%s of %d bytes exceeds the limit of %d bytes", kind, size, limit)
+}
+
+// chainUnaryServerInterceptors creates a single interceptor that chains multiple interceptors
+func chainUnaryServerInterceptors(interceptors []UnaryServerInterceptor) UnaryServerInterceptor {
+	n := len(interceptors)
+
+	if n == 0 {
+		return nil
+	}
+
+	if n == 1 {
+		return interceptors[0]
+	}
+
+	return func(ctx context.Context, req interface{}, info *UnaryServerInfo, handler UnaryHandler) (interface{}, error) {
+		// Build chain from last to first
+		chainedHandler := handler
+		for i := n - 1; i >= 0; i-- {
+			interceptor := interceptors[i]
+			nextHandler := chainedHandler
+			chainedHandler = func(currentCtx context.Context, currentReq interface{}) (interface{}, error) {
+				return interceptor(currentCtx, currentReq, info, nextHandler)
+			}
+		}
+		return chainedHandler(ctx, req)
+	}
+}
+
+// chainStreamServerInterceptors creates a single interceptor that chains multiple
+// stream interceptors, the first outermost
+func chainStreamServerInterceptors(interceptors []StreamServerInterceptor) StreamServerInterceptor {
+	switch len(interceptors) {
+	case 0:
+		return nil
+	case 1:
+		return interceptors[0]
+	}
+	return func(ctx context.Context, stream ServerStream, info *StreamInfo, handler StreamHandler) error {
+		chainedHandler := handler
+		for i := len(interceptors) - 1; i >= 0; i-- {
+			interceptor, next := interceptors[i], chainedHandler
+			chainedHandler = func(ctx context.Context, stream ServerStream) error {
+				return interceptor(ctx, stream, info, next)
+			}
+		}
+		return chainedHandler(ctx, stream)
+	}
+}
+
+// interceptStream runs handler through interceptor, if there is one
+func interceptStream(ctx context.Context, interceptor StreamServerInterceptor, stream ServerStream, info *StreamInfo, handler StreamHandler) error {
+	if interceptor == nil {
+		return handler(ctx, stream)
+	}
+	return interceptor(ctx, stream, info, handler)
+}
+
+// chainStreamClientInterceptors creates a single interceptor that chains multiple
+// stream interceptors, the first outermost
+func chainStreamClientInterceptors(interceptors []StreamClientInterceptor) StreamClientInterceptor {
+	switch len(interceptors) {
+	case 0:
+		return nil
+	case 1:
+		return interceptors[0]
+	}
+	return func(ctx context.Context, info *StreamInfo, streamer Streamer) (ClientStream, error) {
+		chainedStreamer := streamer
+		for i := len(interceptors) - 1; i >= 0; i-- {
+			interceptor, next := interceptors[i], chainedStreamer
+			chainedStreamer = func(ctx context.Context, info *StreamInfo) (ClientStream, error) {
+				return interceptor(ctx, info, next)
+			}
+		}
+		return chainedStreamer(ctx, info)
+	}
+}
+
+// interceptableClientStream is a generated client stream. intercept makes it send
+// and receive through the stream its interceptors returned.
+type interceptableClientStream interface {
+	ClientStream
+	intercept(ClientStream)
+}
+
+// openClientStream opens a stream with open, through interceptor if there is one
+func openClientStream[S interceptableClientStream](ctx context.Context, interceptor StreamClientInterceptor, info *StreamInfo, open func(context.Context) (S, error)) (S, error) {
+	if interceptor == nil {
+		return open(ctx)
+	}
+	var opened S
+	var ok bool
+	stream, err := interceptor(ctx, info, func(ctx context.Context, info *StreamInfo) (ClientStream, error) {
+		s, err := open(ctx)
+		if err != nil {
+			return nil, err
+		}
+		opened, ok = s, true
+		return s, nil
+	})
+	if err == nil && !ok {
+		err = fmt.Errorf("stream interceptor for %s.%s returned without opening the stream", info.Service, info.Method)
+	}
+	if err != nil {
+		var zero S
+		return zero, err
+	}
+	opened.intercept(stream)
+	return opened, nil
+}
+
+// recvMessage turns the result of a typed receive into that of RecvMsg
+func recvMessage[T proto.Message](msg T, err error) (proto.Message, error) {
+	if err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// interceptedRecv receives a message through the stream an interceptor passed on
+func interceptedRecv[T proto.Message](ctx context.Context, stream interface {
+	RecvMsg(context.Context) (proto.Message, error)
+}) (T, error) {
+	var typed T
+	msg, err := stream.RecvMsg(ctx)
+	if err != nil {
+		return typed, err
+	}
+	typed, ok := msg.(T)
+	if !ok {
+		return typed, fmt.Errorf("stream interceptor passed on a %T message, want %T", msg, typed)
+	}
+	return typed, nil
+}
+
+// sendMessage sends msg with send, the typed send of a generated stream
+func sendMessage[T proto.Message](msg proto.Message, send func(T) error) error {
+	typed, ok := msg.(T)
+	if !ok {
+		return fmt.Errorf("cannot send a %T message on a stream of %T", msg, typed)
+	}
+	return send(typed)
+}
+
+// errStreamDirection is returned by the SendMsg or RecvMsg of a stream that
+// carries no messages that way
+func errStreamDirection(op string) error {
+	return fmt.Errorf("%s is not supported on this stream", op)
+}
+
+// resolveMiddlewares chains the service-wide interceptors with the named middlewares
+// of one method. Unknown names are reported together with the registered ones.
+func resolveMiddlewares(method string, names []string, interceptors []UnaryServerInterceptor, registry map[string]UnaryServerInterceptor) (UnaryServerInterceptor, error) {
+	chain := append([]UnaryServerInterceptor(nil), interceptors...)
+	for _, name := range names {
+		middleware := registry[name]
+		if middleware == nil {
+			registered := make([]string, 0, len(registry))
+			for n := range registry {
+				registered = append(registered, n)
+			}
+			sort.Strings(registered)
+			list := "none"
+			if len(registered) > 0 {
+				list = strings.Join(registered, ", ")
+			}
+			return nil, fmt.Errorf("%s: unknown middleware %q (registered: %s)", method, name, list)
+		}
+		chain = append(chain, middleware)
+	}
+	return chainUnaryServerInterceptors(chain), nil
+}
+
+// Headers carrying an impersonation request, set by WithImpersonation
+const (
+	ImpersonateHeader        = "X-Impersonate"       // Subject the caller acts as
+	ImpersonationProofHeader = "X-Impersonate-Proof" // Proof that the caller may do so, e.g., a signed JWT
+)
+
+// ImpersonationVerifier checks that caller may act as subject, given the proof sent in
+// ImpersonationProofHeader. A non-nil error rejects the request as UNAUTHENTICATED.
+type ImpersonationVerifier func(ctx context.Context, caller Principal, subject, proof string) error
+
+// ImpersonationEvent records an accepted impersonated request for auditing
+type ImpersonationEvent struct {
+	Service string    // Service name
+	Method  string    // Method name
+	Subject string    // Identity the request acts as
+	Caller  Principal // Real caller, as authenticated before impersonation
+	Time    time.Time // When the request was accepted
+}
+
+// ImpersonationInterceptor serves requests carrying ImpersonateHeader. On endpoints
+// with (natsmicro.endpoint).allow_impersonation it checks the proof with verify, replaces
+// the context Principal with the impersonated one (ImpersonatedBy holds the real caller)
+// and passes an ImpersonationEvent to audit, if set. Other endpoints reject the request
+// as PERMISSION_DENIED. Requests without the header pass through unchanged.
+//
+// The real caller is read from PrincipalFromContext, so add the interceptor after the
+// one that authenticates the connection.
+func ImpersonationInterceptor(verify ImpersonationVerifier, audit func(ctx context.Context, event ImpersonationEvent)) UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *UnaryServerInfo, handler UnaryHandler) (interface{}, error) {
+		headers := IncomingHeaders(ctx)
+		subject := headers.Get(ImpersonateHeader)
+		if subject == "" {
+			return handler(ctx, req)
+		}
+		if !info.AllowImpersonation {
+			return nil, Statusf(CodePermissionDenied, "%s does not allow impersonation", info.Method)
+		}
+		caller, _ := PrincipalFromContext(ctx)
+		if err := verify(ctx, caller, subject, headers.Get(ImpersonationProofHeader)); err != nil {
+			return nil, Statusf(CodeUnauthenticated, "impersonation of %q rejected: %v", subject, err)
+		}
+		if audit != nil {
+			audit(ctx, ImpersonationEvent{
+				Service: info.Service,
+				Method:  info.Method,
+				Subject: subject,
+				Caller:  caller,
+				Time:    time.Now(),
+			})
+		}
+		return handler(WithPrincipal(ctx, Principal{Subject: subject, ImpersonatedBy: &caller}), req)
+	}
+}
+
+// MethodDescriptor describes a generated method, for layers that enforce policy
+// generically, such as gateways and auth interceptors
+type MethodDescriptor struct {
+	Service         string   // Service name
+	Method          string   // Method name
+	Subject         string   // NATS subject under the service's default prefix
+	RequiredScopes  []string // (natsmicro.endpoint).required_scopes, empty for public methods
+	ClientStreaming bool     // The client sends a stream of messages
+	ServerStreaming bool     // The server sends a stream of messages
+}
+
+// MethodDescriptors maps the full proto name of each generated method in this package,
+// e.g., "order.v1.OrderService.CreateOrder", to its descriptor. Do not modify it.
+var MethodDescriptors = map[string]MethodDescriptor{}
+
+// ScopesFromHeader returns a scope extractor for NewScopeAuthInterceptor that reads the
+// caller's scopes from the named incoming header, separated by spaces or commas, e.g.,
+// the scope claim of a JWT that a lower layer verified and copied into the header
+func ScopesFromHeader(name string) func(ctx context.Context) []string {
+	return func(ctx context.Context) []string {
+		return strings.FieldsFunc(IncomingHeaders(ctx).Get(name), func(r rune) bool {
+			return r == ',' || r == ' ' || r == '\t'
+		})
+	}
+}
+
+// NewScopeAuthInterceptor rejects unary requests as PERMISSION_DENIED unless the
+// caller holds every (natsmicro.endpoint).required_scopes of the method. extractor
+// returns the caller's scopes, e.g., ScopesFromHeader. Methods without required
+// scopes are public and pass through without calling extractor.
+func NewScopeAuthInterceptor(extractor func(ctx context.Context) []string) UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *UnaryServerInfo, handler UnaryHandler) (interface{}, error) {
+		if err := checkScopes(ctx, extractor, info.Method, MethodDescriptors[info.FullMethod].RequiredScopes); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// NewScopeAuthStreamInterceptor is NewScopeAuthInterceptor for streams; the scopes are
+// checked once, when the stream opens
+func NewScopeAuthStreamInterceptor(extractor func(ctx context.Context) []string) StreamServerInterceptor {
+	return func(ctx context.Context, stream ServerStream, info *StreamInfo, handler StreamHandler) error {
+		if err := checkScopes(ctx, extractor, info.Method, MethodDescriptors[info.FullMethod].RequiredScopes); err != nil {
+			return err
+		}
+		return handler(ctx, stream)
+	}
+}
+
+// checkScopes returns a PERMISSION_DENIED error unless extractor's scopes include all of required
+func checkScopes(ctx context.Context, extractor func(ctx context.Context) []string, method string, required []string) error {
+	if len(required) == 0 {
+		return nil
+	}
+	held := make(map[string]bool)
+	for _, scope := range extractor(ctx) {
+		held[scope] = true
+	}
+	if len(held) == 0 {
+		return Statusf(CodePermissionDenied, "%s requires scopes %s", method, strings.Join(required, " "))
+	}
+	for _, scope := range required {
+		if !held[scope] {
+			return Statusf(CodePermissionDenied, "%s requires scope %q", method, scope)
+		}
+	}
+	return nil
+}
+
+// natsClientConfig holds configuration for NATS clients
+type natsClientConfig struct {
+	subjectPrefix       string
+	clientInterceptors  []UnaryClientInterceptor
+	streamInterceptors  []StreamClientInterceptor
+	js                  jetstream.JetStream      // Optional JetStream for KV/ObjectStore reads
+	cancelPropagation   bool                     // Publish a cancel notice when ctx ends mid-request
+	timeout             time.Duration            // Default unary timeout when ctx has no deadline
+	retry               *retryPolicy             // Unary retry policy (nil = no retries)
+	retryableErrors     []error                  // Errors that trigger a retry (nil = defaultRetryableErrors)
+	tokenSanitizer      func(string) string      // Escapes request fields in client-built keys
+	idGenerator         func() string            // Mints cancel subject and stream inbox IDs (nil = NUIDs)
+	maxResponseSize     int                      // Largest accepted response payload in bytes (0 = unlimited)
+	connSelector        func() *nats.Conn        // Picks the connection per call (nil = the constructor's)
+	journal             *journal                 // Records calls for replay (nil = no journal)
+	streamWindow        int                      // Server-stream window asked of the server (0 = no flow control)
+	streamAllowGaps     bool                     // Skip lost server-stream messages instead of failing Recv
+	streamCompression   int                      // Smallest stream message compressed, if the server agrees (0 = off)
+	compression         int                      // Smallest unary request compressed (0 = off)
+	compressionCodec    string                   // Codec WithClientCompression named
+	streamResumeOnDrain bool                     // Reopen server streams elsewhere when their server drains
+	insecureAllowed     bool                     // Skip the require_tls check
+	tlsConns            []*nats.Conn             // Connections require_tls checks besides the constructor's, e.g. a pool's
+	poolConns           []*nats.Conn             // A client pool's connections, which the connection monitor samples
+	headerPolicy        *headerPolicy            // Strips outgoing request headers (nil = allow all)
+	serviceVersion      string                   // Version called with version_in_subject ("" = the generated one)
+	sampling            *samplingConfig          // Samples unary calls for diagnostics (nil = off)
+	samplingSalt        string                   // Varies which calls are sampled
+	connMonitor         *connectionMonitorConfig // Samples the connection (nil = off)
+	kvStaleAfter        time.Duration            // Age at which Get*Cached treats KV entries as misses (0 = never)
+}
+
+// NatsClientOption is a generic client configuration option
+// It uses an interface to allow configuration of any client type
+type NatsClientOption interface {
+	applyNatsClientOption(*natsClientConfig)
+}
+
+// natsClientOptionFunc is a function adapter for NatsClientOption
+type natsClientOptionFunc func(*natsClientConfig)
+
+func (f natsClientOptionFunc) applyNatsClientOption(c *natsClientConfig) {
+	f(c)
+}
+
+// WithNatsClientSubjectPrefix overrides the subject prefix for the client.
+// By default, the prefix from the proto definition is used.
+func WithNatsClientSubjectPrefix(prefix string) NatsClientOption {
+	return natsClientOptionFunc(func(c *natsClientConfig) {
+		c.subjectPrefix = prefix
+	})
+}
+
+// WithServiceVersion calls another version of a service with
+// (natsmicro.service).version_in_subject, e.g. WithServiceVersion("v1") on a client
+// generated from version 2.0.0 calls <prefix>.v1.<method> during a migration. Only
+// the major version matters: "v1", "1" and "1.4.2" are the same. Clients of services
+// without version_in_subject ignore it.
+func WithServiceVersion(version string) NatsClientOption {
+	return natsClientOptionFunc(func(c *natsClientConfig) {
+		c.serviceVersion = version
+	})
+}
+
+// WithClientInterceptor adds a unary client interceptor.
+// Interceptors are executed in the order they are added.
 // Use for cross-cutting concerns like logging, retries, circuit breaking.
 func WithClientInterceptor(interceptor UnaryClientInterceptor) NatsClientOption {
 	return natsClientOptionFunc(func(c *natsClientConfig) {
-		c.clientInterceptors = append(c.clientInterceptors, interceptor)
+		c.clientInterceptors = append(c.clientInterceptors, interceptor)
+	})
+}
+
+// WithClientStreamInterceptor adds a client stream interceptor. Stream interceptors
+// run when a server, client or bidi stream is opened, in the order they are
+// added. WithClientInterceptor interceptors do not see streams.
+func WithClientStreamInterceptor(interceptor StreamClientInterceptor) NatsClientOption {
+	return natsClientOptionFunc(func(c *natsClientConfig) {
+		c.streamInterceptors = append(c.streamInterceptors, interceptor)
+	})
+}
+
+// WithNatsClientJetStream provides a JetStream context for client-side KV/ObjectStore reads.
+// Required only if using Get*FromKV, Get*Cached or Get*FromObjectStore convenience methods.
+func WithNatsClientJetStream(js jetstream.JetStream) NatsClientOption {
+	return natsClientOptionFunc(func(c *natsClientConfig) {
+		c.js = js
+	})
+}
+
+// WithKVStaleAfter makes Get*Cached call the service instead of returning KV
+// entries written more than d ago, by their revision timestamp
+func WithKVStaleAfter(d time.Duration) NatsClientOption {
+	return natsClientOptionFunc(func(c *natsClientConfig) {
+		c.kvStaleAfter = d
+	})
+}
+
+// WithNatsClientTokenSanitizer replaces SanitizeToken in the client's key helpers
+// (e.g., <Method>KVKey). Use the same function as the service's WithTokenSanitizer.
+func WithNatsClientTokenSanitizer(sanitize func(string) string) NatsClientOption {
+	return natsClientOptionFunc(func(c *natsClientConfig) {
+		if sanitize != nil {
+			c.tokenSanitizer = sanitize
+		}
+	})
+}
+
+// WithNatsClientIDGenerator replaces the NUIDs the client mints for cancel subjects
+// and stream inboxes, sanitized as with WithIDGenerator. Client interceptors reach
+// the generator through NewID(ctx).
+func WithNatsClientIDGenerator(gen func() string) NatsClientOption {
+	return natsClientOptionFunc(func(c *natsClientConfig) {
+		c.idGenerator = gen
+	})
+}
+
+// WithClientStreamWindow asks servers to stop sending on a server stream once n
+// messages are unread, and to resume as Recv reads them, instead of buffering the
+// whole stream in the client. The server may settle on a smaller window (see
+// WithStreamWindow). The default is 64; n <= 0 turns flow control off.
+func WithClientStreamWindow(n int) NatsClientOption {
+	return natsClientOptionFunc(func(c *natsClientConfig) {
+		c.streamWindow = n
+	})
+}
+
+// WithClientStreamCompression offers servers gzip compression of stream messages
+// and, on client and bidi streams a server registered with WithStreamCompression
+// agreed to, gzips the messages Send sends of at least threshold bytes, as the
+// server does (see WithStreamCompression). Servers that don't support it ignore the
+// offer, and the stream stays uncompressed. The encoding a server settled on is in
+// the StreamEncodingHeader of Header(). threshold <= 0 compresses every message
+// that shrinks.
+func WithClientStreamCompression(threshold int) NatsClientOption {
+	return natsClientOptionFunc(func(c *natsClientConfig) {
+		c.streamCompression = max(threshold, 1)
+	})
+}
+
+// WithClientCompression compresses unary requests of at least minSize bytes with
+// codec, "gzip" or one added with RegisterCompressor, naming it in
+// ContentEncodingHeader; requests codec doesn't make smaller are sent as they are.
+// Services generated before compression support can't decode compressed requests,
+// so regenerate them first. Streams are compressed too, as WithClientStreamCompression
+// (minSize) does, unless WithClientStreamCompression sets their threshold.
+// Responses are decompressed whenever they name a registered codec, with or
+// without this option. Calls fail if codec is not registered.
+func WithClientCompression(codec string, minSize int) NatsClientOption {
+	return natsClientOptionFunc(func(c *natsClientConfig) {
+		c.compression, c.compressionCodec = max(minSize, 1), codec
+		if c.streamCompression == 0 {
+			c.streamCompression = c.compression
+		}
+	})
+}
+
+// WithClientStreamAllowGaps makes Recv on server and bidi streams skip messages
+// lost in transit instead of returning an *ErrStreamMessageLost
+func WithClientStreamAllowGaps() NatsClientOption {
+	return natsClientOptionFunc(func(c *natsClientConfig) {
+		c.streamAllowGaps = true
+	})
+}
+
+// WithClientStreamResumeOnDrain makes Recv on server streams reopen the stream when
+// its server starts draining, with WithResumeFrom and the resume token of the last
+// message received, and read on from the new stream. Streams whose handler sets no
+// resume tokens are reopened only before their first message; otherwise, and when
+// reopening fails, Recv returns the *ErrServerDraining. Persistent streams resume
+// from JetStream instead.
+func WithClientStreamResumeOnDrain() NatsClientOption {
+	return natsClientOptionFunc(func(c *natsClientConfig) {
+		c.streamResumeOnDrain = true
+	})
+}
+
+// WithMaxResponseSize fails calls whose response payload exceeds bytes with a
+// RESOURCE_EXHAUSTED *Status, before decoding it. For streams it applies to each
+// received message. 0 means unlimited.
+func WithMaxResponseSize(bytes int) NatsClientOption {
+	return natsClientOptionFunc(func(c *natsClientConfig) {
+		c.maxResponseSize = bytes
+	})
+}
+
+// WithInsecureAllowed lets clients of services with (natsmicro.service).require_tls
+// call over a plaintext connection. Meant for local development only.
+func WithInsecureAllowed() NatsClientOption {
+	return natsClientOptionFunc(func(c *natsClientConfig) {
+		c.insecureAllowed = true
+	})
+}
+
+// WithOutgoingHeaderPolicy strips request headers the client may not send, with
+// the rules of WithResponseHeaderPolicy, from the headers of WithOutgoingHeaders,
+// including those added by interceptors. It applies to unary, fire-and-forget and
+// server-streaming calls and to WithJournal records. Without it every header is sent.
+func WithOutgoingHeaderPolicy(allow, deny []string) NatsClientOption {
+	return natsClientOptionFunc(func(c *natsClientConfig) {
+		c.headerPolicy = newHeaderPolicy(allow, deny)
+	})
+}
+
+// WithConnSelector has the client ask selector for a connection instead of always
+// using the one passed to the constructor, e.g. to spread load over several
+// connections. Unary and fire-and-forget calls ask once per attempt; a stream keeps
+// the connection it opened on until it ends. selector must be safe for concurrent use.
+func WithConnSelector(selector func() *nats.Conn) NatsClientOption {
+	return natsClientOptionFunc(func(c *natsClientConfig) {
+		c.connSelector = selector
+	})
+}
+
+// RoundRobinConns returns a selector for WithConnSelector that cycles through conns.
+// It panics if conns is empty.
+func RoundRobinConns(conns []*nats.Conn) func() *nats.Conn {
+	if len(conns) == 0 {
+		panic("RoundRobinConns: no connections")
+	}
+	conns = append([]*nats.Conn(nil), conns...)
+	var next atomic.Uint64
+	return func() *nats.Conn {
+		return conns[(next.Add(1)-1)%uint64(len(conns))]
+	}
+}
+
+// WithImpersonation makes unary and fire-and-forget calls act as subject, for admin
+// tooling. Each attempt sends ImpersonateHeader and ImpersonationProofHeader, with the
+// proof returned by proofProvider; a provider error fails the call before sending.
+// Services accept the request only on endpoints that allow it; see ImpersonationInterceptor.
+func WithImpersonation(subject string, proofProvider func(ctx context.Context) (string, error)) NatsClientOption {
+	return natsClientOptionFunc(func(c *natsClientConfig) {
+		c.clientInterceptors = append(c.clientInterceptors, func(ctx context.Context, method string, req, reply interface{}, invoker UnaryInvoker) error {
+			proof, err := proofProvider(ctx)
+			if err != nil {
+				return fmt.Errorf("impersonation proof for %s: %w", method, err)
+			}
+			headers := nats.Header{}
+			for k, v := range OutgoingHeaders(ctx) {
+				headers[k] = v
+			}
+			headers.Set(ImpersonateHeader, subject)
+			headers.Set(ImpersonationProofHeader, proof)
+			return invoker(WithOutgoingHeaders(ctx, headers), method, req, reply)
+		})
+	})
+}
+
+// WithClientTimeout bounds every unary call whose context has no deadline.
+// Without it (or a deadline), a call to a dead service waits until the
+// connection reports no responders, which may be never.
+// A context deadline always takes precedence over this default.
+func WithClientTimeout(timeout time.Duration) NatsClientOption {
+	return natsClientOptionFunc(func(c *natsClientConfig) {
+		c.timeout = timeout
+	})
+}
+
+// WithNatsClientCancelPropagation publishes a best-effort cancel notice when the
+// context of an in-flight unary request ends, so a service registered with
+// WithCancelPropagation can stop the handler early.
+// Each request carries its cancel subject in the Nats-Cancel-Subject header.
+func WithNatsClientCancelPropagation() NatsClientOption {
+	return natsClientOptionFunc(func(c *natsClientConfig) {
+		c.cancelPropagation = true
+	})
+}
+
+// WithClientRetry retries failed unary calls up to maxAttempts attempts in total,
+// waiting backoff(n) before retry n. A nil backoff uses DefaultBackoff.
+// Only errors matching WithRetryableErrors (by default nats.ErrNoResponders) are
+// retried; service errors are returned immediately. Timeouts are not retried by
+// default: all attempts share the call's deadline, so a timed-out attempt has
+// used it up.
+// Each attempt runs the whole client interceptor chain; see RetryAttempt.
+// Streaming methods are never retried.
+func WithClientRetry(maxAttempts int, backoff BackoffFunc) NatsClientOption {
+	return natsClientOptionFunc(func(c *natsClientConfig) {
+		if backoff == nil {
+			backoff = DefaultBackoff
+		}
+		c.retry = &retryPolicy{maxAttempts: maxAttempts, backoff: backoff}
+	})
+}
+
+// WithRetryableErrors replaces the errors WithClientRetry retries on.
+// An attempt is retried when its error matches one of errs with errors.Is.
+func WithRetryableErrors(errs ...error) NatsClientOption {
+	return natsClientOptionFunc(func(c *natsClientConfig) {
+		c.retryableErrors = errs
+	})
+}
+
+// callConfig holds per-call configuration for unary client methods
+type callConfig struct {
+	timeout  time.Duration
+	instance string // Service instance ID from WithTargetInstance
+}
+
+// CallOption configures a single unary client call
+type CallOption func(*callConfig)
+
+// WithCallTimeout bounds a single call, overriding WithClientTimeout.
+// It is combined with any context deadline: whichever expires first wins.
+func WithCallTimeout(timeout time.Duration) CallOption {
+	return func(c *callConfig) { c.timeout = timeout }
+}
+
+// WithTargetInstance sends a single call to the service instance with ID id, which
+// must have registered WithInstanceSubjects, instead of to whichever instance the
+// queue group picks. With no such instance the call fails with no responders.
+// Take id from ResponderInstance to send follow-up calls to the instance that
+// answered an earlier one, or from the service's $SRV.INFO.
+// Example:
+//
+//	ctx = WithCallInfo(ctx)
+//	resp, err := client.StartUpload(ctx, req)
+//	...
+//	_, err = client.FinishUpload(ctx, next, WithTargetInstance(ResponderInstance(ctx)))
+func WithTargetInstance(id string) CallOption {
+	return func(c *callConfig) { c.instance = id }
+}
+
+// targetInstance returns the instance ID WithTargetInstance set in opts, or ""
+func targetInstance(opts []CallOption) string {
+	cfg := callConfig{}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return cfg.instance
+}
+
+// targetSubject returns the subject a call with opts is sent to: subject, or its
+// variant for the instance WithTargetInstance names
+func targetSubject(subject string, opts []CallOption) (string, error) {
+	instance := targetInstance(opts)
+	if instance == "" {
+		return subject, nil
+	}
+	if strings.ContainsAny(instance, ".*> \t\r\n") {
+		return "", fmt.Errorf("target instance %q must be a single subject token", instance)
+	}
+	return subject + "." + instance, nil
+}
+
+// ResponderInstance returns the ID of the service instance that answered the last
+// unary call made with ctx, or "" if the service didn't register
+// WithInstanceSubjects. Prepare ctx with WithCallInfo to read it after the call;
+// client interceptors can read it for the call they intercept.
+func ResponderInstance(ctx context.Context) string {
+	return CallInfoFromContext(ctx).Instance
+}
+
+// ErrTimeout matches every *TimeoutError with errors.Is
+var ErrTimeout = errors.New("request timed out")
+
+// TimeoutError is returned when a unary call exceeds its WithCallTimeout or
+// WithClientTimeout. Expiry of the caller's own context deadline is returned as is.
+type TimeoutError struct {
+	Method  string        // Method name (e.g., "CreateProduct")
+	Timeout time.Duration // Timeout that expired
+	Err     error         // Underlying error from the request
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("%s: request timed out after %s", e.Method, e.Timeout)
+}
+
+// Is reports whether target is ErrTimeout
+func (e *TimeoutError) Is(target error) bool {
+	return target == ErrTimeout
+}
+
+func (e *TimeoutError) Unwrap() error {
+	return e.Err
+}
+
+// withCallTimeout bounds ctx by the per-call timeout, or by the client default when
+// ctx has no deadline. It returns the timeout applied (0 if none).
+func withCallTimeout(ctx context.Context, clientTimeout time.Duration, opts []CallOption) (context.Context, context.CancelFunc, time.Duration) {
+	cfg := callConfig{}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	timeout := cfg.timeout
+	if timeout <= 0 {
+		if _, ok := ctx.Deadline(); ok || clientTimeout <= 0 {
+			return ctx, func() {}, 0
+		}
+		timeout = clientTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	return ctx, cancel, timeout
+}
+
+// callTimeoutError wraps err in a *TimeoutError when the timeout applied by
+// withCallTimeout fired, rather than the caller's own context.
+func callTimeoutError(parent, ctx context.Context, method string, timeout time.Duration, err error) error {
+	if err == nil || timeout == 0 || parent.Err() != nil || ctx.Err() != context.DeadlineExceeded {
+		return err
+	}
+	return &TimeoutError{Method: method, Timeout: timeout, Err: err}
+}
+
+// paginate backs the <Method>All helpers of (natsmicro.endpoint).paginated methods.
+// It fetches pages starting from a copy of req, yields the items of each, and asks
+// for the next page until a response has no next page token. It stops after
+// yielding the first error, when ctx ends, or when the caller stops iterating.
+func paginate[Req proto.Message, Resp, Item any](ctx context.Context, req Req, fetch func(context.Context, Req) (Resp, error), setToken func(Req, string), page func(Resp) ([]Item, string)) iter.Seq2[Item, error] {
+	return func(yield func(Item, error) bool) {
+		var zero Item
+		next := proto.Clone(req).(Req)
+		sent := ""
+		for {
+			if err := ctx.Err(); err != nil {
+				yield(zero, err)
+				return
+			}
+			resp, err := fetch(ctx, next)
+			if err != nil {
+				yield(zero, err)
+				return
+			}
+			items, token := page(resp)
+			for _, item := range items {
+				if !yield(item, nil) {
+					return
+				}
+			}
+			if token == "" {
+				return
+			}
+			if token == sent {
+				// A server handing back the same token would be fetched forever
+				yield(zero, fmt.Errorf("pagination: next page token %q repeats the previous one", token))
+				return
+			}
+			sent = token
+			setToken(next, token)
+		}
+	}
+}
+
+// BackoffFunc returns how long to wait before retry attempt n (1 = first retry)
+type BackoffFunc func(attempt int) time.Duration
+
+// DefaultBackoff waits 50ms before the first retry, doubling up to 2s, with jitter
+var DefaultBackoff = ExponentialBackoff(50*time.Millisecond, 2*time.Second)
+
+// ExponentialBackoff returns a BackoffFunc that doubles base for every retry,
+// caps the delay at max, and picks a random delay in [d/2, d) to spread out
+// retries from many clients.
+func ExponentialBackoff(base, max time.Duration) BackoffFunc {
+	return func(attempt int) time.Duration {
+		d := base
+		for i := 1; i < attempt && d < max; i++ {
+			d *= 2
+		}
+		if d > max {
+			d = max
+		}
+		if half := int64(d / 2); half > 0 {
+			d = time.Duration(half + rand.Int63n(half))
+		}
+		return d
+	}
+}
+
+// defaultRetryableErrors are the transient errors WithClientRetry retries on.
+// Timeouts are left out: an attempt that times out has used up the deadline
+// the remaining attempts share, and nats reports it as context.DeadlineExceeded.
+var defaultRetryableErrors = []error{nats.ErrNoResponders}
+
+// retryPolicy holds the WithClientRetry settings of a client
+type retryPolicy struct {
+	maxAttempts int
+	backoff     BackoffFunc
+	retryable   []error
+}
+
+// newRetryPolicy resolves the retry settings of cfg, or returns nil without retries.
+func newRetryPolicy(cfg *natsClientConfig) *retryPolicy {
+	if cfg.retry == nil || cfg.retry.maxAttempts <= 1 {
+		return nil
+	}
+	policy := *cfg.retry
+	policy.retryable = cfg.retryableErrors
+	if policy.retryable == nil {
+		policy.retryable = defaultRetryableErrors
+	}
+	return &policy
+}
+
+// RetryAttempt returns the 1-based attempt number of the unary call running with
+// ctx, so client interceptors can tell retries apart. Returns 1 outside retries.
+func RetryAttempt(ctx context.Context) int {
+	if attempt, ok := ctx.Value(retryAttemptKey).(int); ok {
+		return attempt
+	}
+	return 1
+}
+
+// do runs call until it succeeds, fails with a non-retryable error, or the
+// attempts run out. It stops early, returning the last error, when ctx ends or
+// its deadline would pass before the next attempt. Callers pass the whole
+// interceptor chain as call, so interceptors run once per attempt.
+func (p *retryPolicy) do(ctx context.Context, call func(context.Context) error) error {
+	if p == nil {
+		return call(ctx)
+	}
+	for attempt := 1; ; attempt++ {
+		err := call(context.WithValue(ctx, retryAttemptKey, attempt))
+		if err == nil || attempt >= p.maxAttempts || !p.isRetryable(err) {
+			return err
+		}
+
+		delay := p.backoff(attempt)
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) <= delay {
+			return err
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+func (p *retryPolicy) isRetryable(err error) bool {
+	for _, target := range p.retryable {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// chainUnaryClientInterceptors creates a single interceptor that chains multiple interceptors
+func chainUnaryClientInterceptors(interceptors []UnaryClientInterceptor) UnaryClientInterceptor {
+	n := len(interceptors)
+
+	if n == 0 {
+		return nil
+	}
+
+	if n == 1 {
+		return interceptors[0]
+	}
+
+	return func(ctx context.Context, method string, req, reply interface{}, invoker UnaryInvoker) error {
+		// Build chain from last to first
+		chainedInvoker := invoker
+		for i := n - 1; i >= 0; i-- {
+			interceptor := interceptors[i]
+			nextInvoker := chainedInvoker
+			chainedInvoker = func(currentCtx context.Context, currentMethod string, currentReq, currentReply interface{}) error {
+				return interceptor(currentCtx, currentMethod, currentReq, currentReply, nextInvoker)
+			}
+		}
+		return chainedInvoker(ctx, method, req, reply)
+	}
+}
+
+// Cancel propagation protocol: the client sends the per-request cancel subject in
+// natsCancelSubjectHeader and publishes an empty message to it if it gives up.
+// Servers share one wildcard subscription and look up handlers by request ID.
+const (
+	natsCancelSubjectHeader = "Nats-Cancel-Subject"
+	natsCancelSubjectPrefix = "_NATS_MICRO.cancel"
+)
+
+// Content-Type header naming the codec of a request or response payload
+const (
+	ContentTypeHeader   = "Content-Type"
+	ContentTypeProtobuf = "application/protobuf"
+	ContentTypeJSON     = "application/json"
+)
+
+// contentType returns the Content-Type of payloads encoded as JSON or binary protobuf
+func contentType(useJSON bool) string {
+	if useJSON {
+		return ContentTypeJSON
+	}
+	return ContentTypeProtobuf
+}
+
+// withContentType returns a copy of headers naming the payload codec
+func withContentType(headers nats.Header, useJSON bool) nats.Header {
+	out := make(nats.Header, len(headers)+1)
+	for k, v := range headers {
+		out[k] = v
+	}
+	out.Set(ContentTypeHeader, contentType(useJSON))
+	return out
+}
+
+// payloadUsesJSON reports whether a payload is JSON according to its Content-Type
+// header. Senders that do not set the header use the configured encoding. Other
+// media types fail with an INVALID_ARGUMENT *Status naming both encodings.
+func payloadUsesJSON(header string, configured bool) (bool, error) {
+	mediaType, _, _ := strings.Cut(header, ";")
+	switch strings.ToLower(strings.TrimSpace(mediaType)) {
+	case "":
+		return configured, nil
+	case ContentTypeJSON:
+		return true, nil
+	case ContentTypeProtobuf, "application/x-protobuf":
+		return false, nil
+	}
+	return false, Statusf(CodeInvalidArgument, "unsupported Content-Type %q: this endpoint uses %s and also accepts %s",
+		header, contentType(configured), contentType(!configured))
+}
+
+// propagateCancel returns headers carrying a cancel subject named by id and arranges for a
+// cancel notice to be published if ctx ends before stop is called.
+// The caller's headers are copied, never modified.
+func propagateCancel(ctx context.Context, nc *nats.Conn, headers nats.Header, id string) (nats.Header, func() bool) {
+	cancelSubject := natsCancelSubjectPrefix + "." + id
+
+	withCancel := make(nats.Header, len(headers)+1)
+	for k, v := range headers {
+		withCancel[k] = v
+	}
+	withCancel.Set(natsCancelSubjectHeader, cancelSubject)
+
+	stop := context.AfterFunc(ctx, func() {
+		// Best-effort: the request is already failing with ctx.Err()
+		_ = nc.Publish(cancelSubject, nil)
+	})
+	return withCancel, stop
+}
+
+// Deadline propagation: clients send their context deadline, RFC 3339 with
+// nanoseconds, so a deadline-aware handler pool can order and reject by it.
+const natsDeadlineHeader = "Nats-Deadline"
+
+// natsDeadlineMsHeader carries the milliseconds a client had left when it sent a
+// request or opened a stream. Handlers run with that much time, measured from
+// arrival, so unlike Nats-Deadline it does not depend on the clocks agreeing.
+const natsDeadlineMsHeader = "Nats-Deadline-Ms"
+
+// setDeadlineHeaders adds the deadline of ctx, if it has one, to headers
+func setDeadlineHeaders(ctx context.Context, headers nats.Header) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return
+	}
+	headers.Set(natsDeadlineHeader, deadline.UTC().Format(time.RFC3339Nano))
+	// Round up, so a call with time left never arrives already expired
+	remaining := (time.Until(deadline) + time.Millisecond - 1) / time.Millisecond
+	headers.Set(natsDeadlineMsHeader, strconv.FormatInt(max(int64(remaining), 0), 10))
+}
+
+// WithMaxServerDeadline caps the deadline handlers take from their clients'
+// contexts at d, so a client cannot keep a handler busy for longer by sending a
+// far-off deadline. It does not shorten WithTimeout or endpoint timeouts, and
+// requests without a propagated deadline are unaffected.
+func WithMaxServerDeadline(d time.Duration) RegisterOption {
+	return func(c *registerConfig) { c.maxServerDeadline = d }
+}
+
+// propagatedTimeout returns the time the client had left, from Nats-Deadline-Ms, at
+// most maxDeadline if it is positive. ok is false without a valid header.
+func propagatedTimeout(headers micro.Headers, maxDeadline time.Duration) (timeout time.Duration, ok bool) {
+	ms, err := strconv.ParseInt(headers.Get(natsDeadlineMsHeader), 10, 64)
+	if err != nil || ms < 0 {
+		return 0, false
+	}
+	timeout = time.Duration(1<<63 - 1)
+	if ms < int64(timeout/time.Millisecond) {
+		timeout = time.Duration(ms) * time.Millisecond
+	}
+	if maxDeadline > 0 && timeout > maxDeadline {
+		timeout = maxDeadline
+	}
+	return timeout, true
+}
+
+// withPropagatedDeadline returns ctx bounded by propagatedTimeout. Requests without
+// a valid header keep ctx. The CancelFunc must be called.
+func withPropagatedDeadline(ctx context.Context, headers micro.Headers, maxDeadline time.Duration) (context.Context, context.CancelFunc) {
+	timeout, ok := propagatedTimeout(headers, maxDeadline)
+	if !ok {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// requestDeadline returns the deadline a client propagated in headers, if any
+func requestDeadline(headers micro.Headers) (time.Time, bool) {
+	value := headers.Get(natsDeadlineHeader)
+	if value == "" {
+		return time.Time{}, false
+	}
+	deadline, err := time.Parse(time.RFC3339Nano, value)
+	return deadline, err == nil
+}
+
+// WithHandlerPool runs unary handlers on workers goroutines shared by the
+// service's endpoints, queueing requests in arrival order while every worker is
+// busy. Without it, NATS micro runs each endpoint's requests one at a time.
+// Streams and the health endpoint are never queued. workers < 1 means 1.
+func WithHandlerPool(workers int) RegisterOption {
+	if workers < 1 {
+		workers = 1
+	}
+	return func(c *registerConfig) { c.handlerWorkers = workers }
+}
+
+// WithDeadlineAwareScheduling orders the handler pool's queue by deadline: the
+// request with the least time left before the deadline its client propagated runs
+// first, and requests without a deadline run after those with one, in arrival order.
+// A request whose deadline has passed, on arrival or while queued, is rejected with
+// DEADLINE_EXCEEDED without running. Without WithHandlerPool the pool has one worker
+// per unary endpoint. Queue times are reported in the endpoint stats Data, a
+// SchedulingStats. Clock skew between client and server shifts every deadline alike.
+func WithDeadlineAwareScheduling() RegisterOption {
+	return func(c *registerConfig) { c.deadlineScheduling = true }
+}
+
+// SchedulingStats is the endpoint stats Data of services registered with
+// WithHandlerPool or WithDeadlineAwareScheduling. Queue times cover requests that
+// ran. DeadlineUsed is the share of the time a request had left on arrival that it
+// spent queued, over requests with a deadline: 0.25 means a quarter.
+type SchedulingStats struct {
+	Queued              uint64        `json:"queued"`            // Requests that ran after queueing
+	DeadlineRejected    uint64        `json:"deadline_rejected"` // Requests rejected with DEADLINE_EXCEEDED without running
+	AverageQueueTime    time.Duration `json:"average_queue_time"`
+	MaxQueueTime        time.Duration `json:"max_queue_time"`
+	AverageDeadlineUsed float64       `json:"average_deadline_used"`
+	MaxDeadlineUsed     float64       `json:"max_deadline_used"`
+	Data                any           `json:"data,omitempty"` // What the wrapped StatsHandler returned
+}
+
+// schedulingCounters accumulates one endpoint's SchedulingStats
+type schedulingCounters struct {
+	mu           sync.Mutex
+	stats        SchedulingStats
+	queueTime    time.Duration // Sum over Queued requests
+	deadlineUsed float64       // Sum over withDeadline requests
+	withDeadline uint64
+}
+
+// record counts a request that waited queued before running. budget is the time
+// it had left before its deadline on arrival, or <= 0 without a deadline.
+func (c *schedulingCounters) record(queued, budget time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stats.Queued++
+	c.queueTime += queued
+	if queued > c.stats.MaxQueueTime {
+		c.stats.MaxQueueTime = queued
+	}
+	if budget > 0 {
+		used := float64(queued) / float64(budget)
+		c.withDeadline++
+		c.deadlineUsed += used
+		if used > c.stats.MaxDeadlineUsed {
+			c.stats.MaxDeadlineUsed = used
+		}
+	}
+}
+
+// snapshot returns the stats so far
+func (c *schedulingCounters) snapshot() SchedulingStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := c.stats
+	if stats.Queued > 0 {
+		stats.AverageQueueTime = c.queueTime / time.Duration(stats.Queued)
+	}
+	if c.withDeadline > 0 {
+		stats.AverageDeadlineUsed = c.deadlineUsed / float64(c.withDeadline)
+	}
+	return stats
+}
+
+// pooledRequest is a unary request waiting for a handler pool worker
+type pooledRequest struct {
+	endpoint string
+	req      micro.Request
+	handler  micro.Handler
+	deadline time.Time // Zero without a propagated deadline
+	queued   time.Time
+	seq      uint64 // Arrival order
+	end      func() // Releases the inflight count held while queued
+}
+
+// pooledQueue is a heap of queued requests: in arrival order, or nearest deadline
+// first when deadlineAware
+type pooledQueue struct {
+	items         []*pooledRequest
+	deadlineAware bool
+}
+
+func (q *pooledQueue) Len() int      { return len(q.items) }
+func (q *pooledQueue) Swap(i, j int) { q.items[i], q.items[j] = q.items[j], q.items[i] }
+func (q *pooledQueue) Push(x any)    { q.items = append(q.items, x.(*pooledRequest)) }
+
+func (q *pooledQueue) Less(i, j int) bool {
+	a, b := q.items[i], q.items[j]
+	if q.deadlineAware && !a.deadline.Equal(b.deadline) {
+		if a.deadline.IsZero() || b.deadline.IsZero() {
+			return b.deadline.IsZero()
+		}
+		return a.deadline.Before(b.deadline)
+	}
+	return a.seq < b.seq
+}
+
+func (q *pooledQueue) Pop() any {
+	last := q.items[len(q.items)-1]
+	q.items[len(q.items)-1] = nil
+	q.items = q.items[:len(q.items)-1]
+	return last
+}
+
+// handlerPool runs unary handlers on a fixed set of workers (see WithHandlerPool
+// and WithDeadlineAwareScheduling). Queued requests count as in flight for Drain,
+// and workers finish the queue after the service stops.
+type handlerPool struct {
+	mu        sync.Mutex
+	wake      *sync.Cond
+	queue     pooledQueue
+	seq       uint64
+	closed    bool
+	workers   int // Configured size (0 = one per wrapped endpoint)
+	endpoints int
+	started   int // Workers launched so far
+	inflight  *inflightTracker
+	counters  sync.Map // Endpoint name -> *schedulingCounters
+}
+
+// newHandlerPool returns the pool cfg asks for, or nil if it asks for none.
+// Workers start with start, once every endpoint is wrapped.
+func newHandlerPool(cfg *registerConfig, inflight *inflightTracker) *handlerPool {
+	if cfg.handlerWorkers == 0 && !cfg.deadlineScheduling {
+		return nil
+	}
+	p := &handlerPool{
+		queue:    pooledQueue{deadlineAware: cfg.deadlineScheduling},
+		workers:  cfg.handlerWorkers,
+		inflight: inflight,
+	}
+	p.wake = sync.NewCond(&p.mu)
+	return p
+}
+
+// wrap queues the requests of endpoint on the pool. A nil pool returns handler.
+func (p *handlerPool) wrap(endpoint string, handler micro.Handler) micro.Handler {
+	if p == nil {
+		return handler
+	}
+	p.endpoints++
+	p.countersFor(endpoint)
+	return micro.HandlerFunc(func(req micro.Request) { p.submit(endpoint, req, handler) })
+}
+
+// start launches the workers not yet running. A ServiceGroup pool starts once per
+// service registered on it, growing with the wrapped endpoints if sized per endpoint.
+func (p *handlerPool) start() {
+	if p == nil {
+		return
+	}
+	workers := p.workers
+	if workers == 0 {
+		workers = p.endpoints
+	}
+	for ; p.started < workers; p.started++ {
+		go p.work()
+	}
+}
+
+// close lets the workers exit once the queue is empty
+func (p *handlerPool) close() {
+	p.mu.Lock()
+	p.closed = true
+	p.mu.Unlock()
+	p.wake.Broadcast()
+}
+
+func (p *handlerPool) countersFor(endpoint string) *schedulingCounters {
+	counters, _ := p.counters.LoadOrStore(endpoint, &schedulingCounters{})
+	return counters.(*schedulingCounters)
+}
+
+// submit queues req, or rejects it if its deadline already passed
+func (p *handlerPool) submit(endpoint string, req micro.Request, handler micro.Handler) {
+	r := &pooledRequest{endpoint: endpoint, req: req, handler: handler, queued: time.Now()}
+	if p.queue.deadlineAware {
+		if deadline, ok := requestDeadline(req.Headers()); ok {
+			r.deadline = deadline
+			if !deadline.After(r.queued) {
+				p.rejectExpired(r, r.queued)
+				return
+			}
+		}
+	}
+	_, r.end = p.inflight.begin(false)
+
+	p.mu.Lock()
+	if p.closed {
+		// Stopped while this request was being delivered: no worker is left to run it
+		p.mu.Unlock()
+		defer r.end()
+		handler.Handle(req)
+		return
+	}
+	p.seq++
+	r.seq = p.seq
+	heap.Push(&p.queue, r)
+	p.mu.Unlock()
+	p.wake.Signal()
+}
+
+// work runs queued requests until the pool is closed and empty
+func (p *handlerPool) work() {
+	for {
+		p.mu.Lock()
+		for p.queue.Len() == 0 && !p.closed {
+			p.wake.Wait()
+		}
+		if p.queue.Len() == 0 {
+			p.mu.Unlock()
+			return
+		}
+		r := heap.Pop(&p.queue).(*pooledRequest)
+		p.mu.Unlock()
+		p.run(r)
+	}
+}
+
+// run calls a queued request's handler, unless its deadline passed while it waited
+func (p *handlerPool) run(r *pooledRequest) {
+	defer r.end()
+	now := time.Now()
+	if !r.deadline.IsZero() && !r.deadline.After(now) {
+		p.rejectExpired(r, now)
+		return
+	}
+	var budget time.Duration
+	if !r.deadline.IsZero() {
+		budget = r.deadline.Sub(r.queued)
+	}
+	p.countersFor(r.endpoint).record(now.Sub(r.queued), budget)
+	r.handler.Handle(r.req)
+}
+
+// rejectExpired answers a request whose deadline passed with DEADLINE_EXCEEDED
+func (p *handlerPool) rejectExpired(r *pooledRequest, now time.Time) {
+	counters := p.countersFor(r.endpoint)
+	counters.mu.Lock()
+	counters.stats.DeadlineRejected++
+	counters.mu.Unlock()
+	err := Statusf(CodeDeadlineExceeded, "deadline passed %s before the handler ran", now.Sub(r.deadline).Round(time.Microsecond))
+	code, message, data := natsErrorFields(err)
+	r.req.Error(code, message, data)
+}
+
+// statsHandler reports each endpoint's SchedulingStats, wrapping the Data of next
+func (p *handlerPool) statsHandler(next micro.StatsHandler) micro.StatsHandler {
+	return func(e *micro.Endpoint) any {
+		stats := SchedulingStats{}
+		if counters, ok := p.counters.Load(e.Name); ok {
+			stats = counters.(*schedulingCounters).snapshot()
+		}
+		if next != nil {
+			stats.Data = next(e)
+		}
+		return stats
+	}
+}
+
+// inflightTracker counts running handlers so Drain can wait for them. Handler
+// contexts derive from its contexts: stream contexts end as soon as draining
+// starts, unary contexts only when the drain deadline passes.
+type inflightTracker struct {
+	mu            sync.Mutex
+	count         int
+	idle          chan struct{} // Closed when count drops to zero during a drain
+	ctx           context.Context
+	cancel        context.CancelFunc
+	streamCtx     context.Context
+	cancelStreams context.CancelFunc
+	nc            *nats.Conn // Connection the endpoints subscribe on
+
+	grace         time.Duration           // How long streams run on after their GOAWAY
+	drainDeadline time.Time               // When stream contexts end (zero = not draining)
+	goAways       map[int]func(time.Time) // GOAWAY senders of running streams, by onDrain ID
+	nextGoAway    int
+}
+
+func newInflightTracker(base context.Context) *inflightTracker {
+	t := &inflightTracker{}
+	t.ctx, t.cancel = context.WithCancel(base)
+	t.streamCtx, t.cancelStreams = context.WithCancel(t.ctx)
+	return t
+}
+
+// begin records a running handler and returns the context it should derive from.
+// The returned end function must be called when the handler finishes.
+// A nil tracker returns context.Background().
+func (t *inflightTracker) begin(stream bool) (context.Context, func()) {
+	if t == nil {
+		return context.Background(), func() {}
+	}
+	t.mu.Lock()
+	t.count++
+	t.mu.Unlock()
+	ctx := t.ctx
+	if stream {
+		ctx = t.streamCtx
+	}
+	return ctx, func() {
+		t.mu.Lock()
+		t.count--
+		if t.count == 0 && t.idle != nil {
+			close(t.idle)
+			t.idle = nil
+		}
+		t.mu.Unlock()
+	}
+}
+
+// onDrain has goAway called with the deadline of stream contexts when draining
+// starts, or right away if it already has. The returned function stops watching.
+// A nil tracker never drains.
+func (t *inflightTracker) onDrain(goAway func(deadline time.Time)) (stop func()) {
+	if t == nil {
+		return func() {}
+	}
+	t.mu.Lock()
+	if !t.drainDeadline.IsZero() {
+		deadline := t.drainDeadline
+		t.mu.Unlock()
+		goAway(deadline)
+		return func() {}
+	}
+	if t.goAways == nil {
+		t.goAways = make(map[int]func(time.Time))
+	}
+	id := t.nextGoAway
+	t.nextGoAway++
+	t.goAways[id] = goAway
+	t.mu.Unlock()
+	return func() {
+		t.mu.Lock()
+		delete(t.goAways, id)
+		t.mu.Unlock()
+	}
+}
+
+// stopEndpoints stops svc, which drains its endpoint subscriptions, and fails if
+// any endpoint is still subscribed afterwards. micro.Service.Stop in nats.go
+// releases before v1.47 skips every other endpoint while removing them.
+func stopEndpoints(svc micro.Service) error {
+	if err := svc.Stop(); err != nil {
+		return err
+	}
+	if left := svc.Info().Endpoints; len(left) > 0 {
+		subjects := make([]string, len(left))
+		for i, e := range left {
+			subjects[i] = e.Subject
+		}
+		return fmt.Errorf("micro.Service.Stop left endpoints %s subscribed; upgrade nats.go to v1.47 or later", strings.Join(subjects, ", "))
+	}
+	return nil
+}
+
+// drain stops the endpoints taking requests, waits for the requests they already
+// took, then cancels handler contexts. In order, it:
+//   - calls unsubscribe, which drains the endpoint subscriptions (micro.Service.Stop
+//     is the only way to reach them, see stopEndpoints), so new requests get no responders;
+//   - sends running streams a GOAWAY, and cancels stream contexts once the grace
+//     period is over;
+//   - waits for the requests the drained subscriptions still held to reach their
+//     handlers, and then until no handler is running;
+//   - cancels the contexts of handlers that outlived it.
+//
+// If ctx ends first, it cancels the contexts of the remaining handlers and
+// returns ctx.Err().
+func (t *inflightTracker) drain(ctx context.Context, unsubscribe func() error) error {
+	defer t.cancel()
+	if err := unsubscribe(); err != nil {
+		t.cancelStreams()
+		return err
+	}
+
+	// Streams learn they are ending first, so clients can reopen them elsewhere
+	t.mu.Lock()
+	t.drainDeadline = time.Now().Add(t.grace)
+	goAways := make([]func(time.Time), 0, len(t.goAways))
+	for _, goAway := range t.goAways {
+		goAways = append(goAways, goAway)
+	}
+	t.goAways = nil
+	t.mu.Unlock()
+	for _, goAway := range goAways {
+		goAway(t.drainDeadline)
+	}
+	if t.grace > 0 {
+		time.AfterFunc(t.grace, t.cancelStreams)
+	} else {
+		t.cancelStreams()
+	}
+
+	if err := t.awaitDelivered(ctx); err != nil {
+		return err
+	}
+	t.mu.Lock()
+	if t.count == 0 {
+		t.mu.Unlock()
+		return nil
+	}
+	if t.idle == nil {
+		t.idle = make(chan struct{})
+	}
+	idle := t.idle
+	t.mu.Unlock()
+
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// awaitDelivered waits until the messages pending on the connection's
+// subscriptions, drained endpoint subscriptions included, have been handled, so
+// every request taken before the drain is counted. Handlers run in their
+// subscription's callback, so this also waits for the running ones. A tracker
+// without a connection returns right away.
+func (t *inflightTracker) awaitDelivered(ctx context.Context) error {
+	if t.nc == nil {
+		return nil
+	}
+	delivered := make(chan struct{})
+	if err := t.nc.Barrier(func() { close(delivered) }); err != nil {
+		if errors.Is(err, nats.ErrConnectionClosed) {
+			return nil // Nothing more will be delivered
+		}
+		return err
+	}
+	select {
+	case <-delivered:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// cancelRegistry maps in-flight request IDs to their handler cancel functions.
+type cancelRegistry struct {
+	mu      sync.Mutex
+	cancels map[string]context.CancelFunc
+	sub     *nats.Subscription
+}
+
+// newCancelRegistry subscribes to cancel notices for all requests on the connection
+func newCancelRegistry(nc *nats.Conn) (*cancelRegistry, error) {
+	r := &cancelRegistry{cancels: make(map[string]context.CancelFunc)}
+	sub, err := nc.Subscribe(natsCancelSubjectPrefix+".*", func(msg *nats.Msg) {
+		id := strings.TrimPrefix(msg.Subject, natsCancelSubjectPrefix+".")
+		r.mu.Lock()
+		cancel := r.cancels[id]
+		r.mu.Unlock()
+		if cancel != nil {
+			cancel()
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	r.sub = sub
+	return r, nil
+}
+
+// watch derives a context that is canceled when the client publishes a cancel notice
+// for this request. Requests without a cancel subject are returned unchanged.
+// The returned release function must be called when the handler finishes.
+func (r *cancelRegistry) watch(ctx context.Context, headers micro.Headers) (context.Context, func()) {
+	id, ok := strings.CutPrefix(headers.Get(natsCancelSubjectHeader), natsCancelSubjectPrefix+".")
+	if !ok || id == "" || strings.Contains(id, ".") {
+		return ctx, func() {}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	r.mu.Lock()
+	r.cancels[id] = cancel
+	r.mu.Unlock()
+
+	return ctx, func() {
+		r.mu.Lock()
+		delete(r.cancels, id)
+		r.mu.Unlock()
+		cancel()
+	}
+}
+
+// close stops receiving cancel notices
+func (r *cancelRegistry) close() {
+	_ = r.sub.Unsubscribe()
+}
+
+// marshalJSON encodes msg with protojson, which writes 64-bit integers as JSON strings
+// so JavaScript consumers keep full precision above 2^53. int64AsNumber rewrites them
+// as JSON numbers for services that set (natsmicro.service).json_int64_as_number.
+func marshalJSON(msg proto.Message, int64AsNumber bool) ([]byte, error) {
+	data, err := protojson.Marshal(msg)
+	if err != nil || !int64AsNumber {
+		return data, err
+	}
+
+	var tree map[string]any
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&tree); err != nil {
+		return nil, err
+	}
+	int64StringsToNumbers(msg.ProtoReflect().Descriptor(), tree)
+	return json.Marshal(tree)
+}
+
+// int64StringsToNumbers walks a protojson object and replaces the string form of
+// 64-bit integer fields with json.Number. Well-known types keep their JSON mapping.
+func int64StringsToNumbers(md protoreflect.MessageDescriptor, obj map[string]any) {
+	fields := md.Fields()
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		val, ok := obj[fd.JSONName()]
+		if !ok {
+			continue
+		}
+		switch {
+		case fd.IsMap():
+			entries, _ := val.(map[string]any)
+			for k, v := range entries {
+				entries[k] = int64FieldToNumber(fd.MapValue(), v)
+			}
+		case fd.IsList():
+			items, _ := val.([]any)
+			for j, v := range items {
+				items[j] = int64FieldToNumber(fd, v)
+			}
+		default:
+			obj[fd.JSONName()] = int64FieldToNumber(fd, val)
+		}
+	}
+}
+
+func int64FieldToNumber(fd protoreflect.FieldDescriptor, val any) any {
+	switch fd.Kind() {
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind,
+		protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		if s, ok := val.(string); ok {
+			return json.Number(s)
+		}
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		if nested, ok := val.(map[string]any); ok && fd.Message().ParentFile().Package() != "google.protobuf" {
+			int64StringsToNumbers(fd.Message(), nested)
+		}
+	}
+	return val
+}
+
+// defaultSelfTestTimeout bounds a self-test whose context has no deadline
+const defaultSelfTestTimeout = 5 * time.Second
+
+// SelfTestReport is the result of a generated <Service>SelfTest check. It encodes
+// as JSON for readiness probes and deployment hooks; OK is false if any check failed.
+type SelfTestReport struct {
+	Service   string             `json:"service"`
+	OK        bool               `json:"ok"`
+	Connected bool               `json:"connected"`
+	Instance  string             `json:"instance,omitempty"` // ID of the instance that answered discovery
+	Endpoints []SelfTestEndpoint `json:"endpoints"`
+	Error     string             `json:"error,omitempty"`
+}
+
+// SelfTestEndpoint reports whether an endpoint the client calls is registered
+type SelfTestEndpoint struct {
+	Name       string `json:"name"`
+	Subject    string `json:"subject"`
+	Registered bool   `json:"registered"`
+}
+
+// runSelfTest checks the connection, asks a running instance of service for its
+// micro INFO and marks each expected endpoint whose subject it serves.
+func runSelfTest(ctx context.Context, nc *nats.Conn, service string, endpoints []SelfTestEndpoint) *SelfTestReport {
+	report := &SelfTestReport{Service: service, Endpoints: endpoints}
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, defaultSelfTestTimeout)
+		defer cancel()
+	}
+
+	if err := nc.FlushWithContext(ctx); err != nil {
+		report.Error = fmt.Sprintf("nats connection: %v", err)
+		return report
+	}
+	report.Connected = true
+
+	subject, err := micro.ControlSubject(micro.InfoVerb, service, "")
+	if err != nil {
+		report.Error = err.Error()
+		return report
+	}
+	msg, err := nc.RequestWithContext(ctx, subject, nil)
+	if err != nil {
+		report.Error = fmt.Sprintf("service discovery: %v", err)
+		return report
+	}
+	var info micro.Info
+	if err := json.Unmarshal(msg.Data, &info); err != nil {
+		report.Error = fmt.Sprintf("service discovery: %v", err)
+		return report
+	}
+	report.Instance = info.ID
+
+	registered := make(map[string]bool, len(info.Endpoints))
+	for _, ep := range info.Endpoints {
+		registered[ep.Subject] = true
+	}
+	report.OK = true
+	for i := range report.Endpoints {
+		report.Endpoints[i].Registered = registered[report.Endpoints[i].Subject]
+		if !report.Endpoints[i].Registered {
+			report.OK = false
+		}
+	}
+	if !report.OK {
+		report.Error = "endpoints not registered"
+	}
+	return report
+}
+
+// Health statuses reported by the generated health endpoint
+const (
+	HealthServing    = "SERVING"
+	HealthNotServing = "NOT_SERVING"
+)
+
+// HealthChecker is implemented by service implementations that report their own
+// health on the generated health endpoint. Without it the endpoint always reports
+// HealthServing while the service is registered.
+type HealthChecker interface {
+	Healthy(ctx context.Context) error
+}
+
+// HealthResponse is the JSON body of the generated health endpoint
+type HealthResponse struct {
+	Status  string            `json:"status"`
+	Details map[string]string `json:"details,omitempty"` // Failing dependencies and their errors
+}
+
+// DependencyErrors maps dependency names to their failures. Return it from
+// Healthy to report each dependency in HealthResponse.Details.
+type DependencyErrors map[string]error
+
+func (e DependencyErrors) Error() string {
+	names := make([]string, 0, len(e))
+	for name := range e {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	parts := make([]string, len(names))
+	for i, name := range names {
+		parts[i] = name + ": " + e[name].Error()
+	}
+	return strings.Join(parts, "; ")
+}
+
+// versionedSubjectPrefix appends the (natsmicro.service).version_in_subject token of
+// version, "v" and its major component, to prefix: ("api.orders", "2.1.0") gives
+// "api.orders.v2"
+func versionedSubjectPrefix(prefix, version string) string {
+	major, _, _ := strings.Cut(strings.TrimPrefix(version, "v"), ".")
+	if prefix == "" {
+		return "v" + major
+	}
+	return prefix + ".v" + major
+}
+
+// healthSubject returns the subject of a service's health endpoint
+func healthSubject(subjectPrefix, service string) string {
+	if subjectPrefix == "" {
+		return service + ".health"
+	}
+	return subjectPrefix + "." + service + ".health"
+}
+
+// checkHealth asks impl for its health if it implements HealthChecker
+func checkHealth(ctx context.Context, impl any) *HealthResponse {
+	checker, ok := impl.(HealthChecker)
+	if !ok {
+		return &HealthResponse{Status: HealthServing}
+	}
+	err := checker.Healthy(ctx)
+	if err == nil {
+		return &HealthResponse{Status: HealthServing}
+	}
+	resp := &HealthResponse{Status: HealthNotServing, Details: map[string]string{}}
+	var deps DependencyErrors
+	if errors.As(err, &deps) {
+		for name, depErr := range deps {
+			resp.Details[name] = depErr.Error()
+		}
+	} else {
+		resp.Details["error"] = err.Error()
+	}
+	return resp
+}
+
+// newHealthHandler answers health requests with a JSON HealthResponse,
+// bounding Healthy by timeout when it is set.
+func newHealthHandler(impl any, timeout time.Duration) micro.HandlerFunc {
+	return func(req micro.Request) {
+		ctx := context.Background()
+		if timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+		data, err := json.Marshal(checkHealth(ctx, impl))
+		if err != nil {
+			req.Error(ErrCodeInternal, err.Error(), nil)
+			return
+		}
+		if err := req.Respond(data); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to send health response: %v\n", err)
+		}
+	}
+}
+
+// requestHealth calls a health endpoint and decodes its HealthResponse
+func requestHealth(ctx context.Context, nc *nats.Conn, subject string) (*HealthResponse, error) {
+	msg, err := nc.RequestWithContext(ctx, subject, nil)
+	if err != nil {
+		return nil, err
+	}
+	if code := msg.Header.Get("Nats-Service-Error-Code"); code != "" {
+		return nil, &Status{Code: ParseCode(code), Message: msg.Header.Get("Nats-Service-Error")}
+	}
+	var resp HealthResponse
+	if err := json.Unmarshal(msg.Data, &resp); err != nil {
+		return nil, fmt.Errorf("failed to decode health response: %w", err)
+	}
+	return &resp, nil
+}
+
+// SchemaHashHeader carries the schema hash on every $reflect and $schema response
+const SchemaHashHeader = "Nats-Schema-Hash"
+
+// ServiceInstanceHeader carries the ID of the instance that answered a unary call,
+// on every response of services registered WithInstanceSubjects
+const ServiceInstanceHeader = "Nats-Service-Instance"
+
+// instanceRequest is the request a handler of a service registered
+// WithInstanceSubjects runs with: its responses name the instance
+type instanceRequest struct {
+	micro.Request
+	id string
+}
+
+func (r *instanceRequest) Respond(data []byte, opts ...micro.RespondOpt) error {
+	return r.Request.Respond(data, append(opts, r.header())...)
+}
+
+func (r *instanceRequest) RespondJSON(v any, opts ...micro.RespondOpt) error {
+	return r.Request.RespondJSON(v, append(opts, r.header())...)
+}
+
+func (r *instanceRequest) Error(code, description string, data []byte, opts ...micro.RespondOpt) error {
+	return r.Request.Error(code, description, data, append(opts, r.header())...)
+}
+
+// header sets ServiceInstanceHeader, last so that no handler header replaces it
+func (r *instanceRequest) header() micro.RespondOpt {
+	return micro.WithHeaders(micro.Headers{ServiceInstanceHeader: []string{r.id}})
+}
+
+// respondAsInstance wraps handler so its responses name the instance with ID id
+func respondAsInstance(id string, handler micro.Handler) micro.Handler {
+	return micro.HandlerFunc(func(req micro.Request) {
+		handler.Handle(&instanceRequest{Request: req, id: id})
+	})
+}
+
+// reflectSubject returns the subject of a service's $reflect endpoint
+func reflectSubject(subjectPrefix, service string) string {
+	if subjectPrefix == "" {
+		return service + ".$reflect"
+	}
+	return subjectPrefix + "." + service + ".$reflect"
+}
+
+// newReflectHandler serves a service's schema blob, a FileDescriptorSet, with its hash
+func newReflectHandler(schema, hash string) micro.HandlerFunc {
+	headers := micro.Headers{
+		ContentTypeHeader: []string{ContentTypeProtobuf},
+		SchemaHashHeader:  []string{hash},
+	}
+	return func(req micro.Request) {
+		if err := req.Respond([]byte(schema), micro.WithHeaders(headers)); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to send reflect response: %v\n", err)
+		}
+	}
+}
+
+// ServiceSchemaDocument is what a service's $schema endpoint answers with: its
+// methods, their subjects and message types, and its descriptors, for tools that
+// discover an API at runtime. Fetch it with FetchServiceSchema.
+type ServiceSchemaDocument struct {
+	Service       string                `json:"service"` // Fully qualified proto name
+	Name          string                `json:"name"`    // Micro service name
+	Version       string                `json:"version"`
+	SubjectPrefix string                `json:"subject_prefix"`
+	SchemaHash    string                `json:"schema_hash"`
+	Methods       []ServiceSchemaMethod `json:"methods"`
+	Descriptor    []byte                `json:"descriptor"` // Gzipped FileDescriptorSet, as served by $reflect; see Files
+}
+
+// ServiceSchemaMethod describes one endpoint in a ServiceSchemaDocument
+type ServiceSchemaMethod struct {
+	Name         string                `json:"name"`              // Proto method name, e.g., "CreateOrder"
+	Endpoint     string                `json:"endpoint"`          // Micro endpoint name, e.g., "create_order"
+	Subject      string                `json:"subject"`           // Subject the endpoint listens on
+	Streaming    string                `json:"streaming"`         // unary, server, client or bidi
+	RequestType  string                `json:"request_type"`      // Fully qualified message name
+	ResponseType string                `json:"response_type"`     // Fully qualified message name
+	Headers      []ServiceSchemaHeader `json:"headers,omitempty"` // (natsmicro.endpoint).headers
+}
+
+// ServiceSchemaHeader is a request header a method declares with
+// (natsmicro.endpoint).headers
+type ServiceSchemaHeader struct {
+	Name     string `json:"name"`
+	Required bool   `json:"required,omitempty"` // Requests without it are rejected
+}
+
+// Files decompresses the document's descriptors
+func (d *ServiceSchemaDocument) Files() (*descriptorpb.FileDescriptorSet, error) {
+	r, err := gzip.NewReader(bytes.NewReader(d.Descriptor))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress schema descriptor: %w", err)
+	}
+	blob, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress schema descriptor: %w", err)
+	}
+	var set descriptorpb.FileDescriptorSet
+	if err := proto.Unmarshal(blob, &set); err != nil {
+		return nil, fmt.Errorf("failed to decode schema descriptor: %w", err)
+	}
+	return &set, nil
+}
+
+// schemaSubject returns the subject of a service's $schema endpoint
+func schemaSubject(subjectPrefix, service string) string {
+	if subjectPrefix == "" {
+		return service + ".$schema"
+	}
+	return subjectPrefix + "." + service + ".$schema"
+}
+
+// newSchemaHandler serves a service's $schema document, completed with the name,
+// version and subjects it registered with
+func newSchemaHandler(document string, cfg *registerConfig) (micro.HandlerFunc, error) {
+	var doc ServiceSchemaDocument
+	if err := json.Unmarshal([]byte(document), &doc); err != nil {
+		return nil, fmt.Errorf("failed to decode schema document: %w", err)
+	}
+	doc.Name, doc.Version, doc.SubjectPrefix = cfg.name, cfg.version, cfg.subjectPrefix
+	for i, m := range doc.Methods {
+		if m.Subject != "" {
+			continue // (natsmicro.endpoint).subject
+		}
+		if cfg.subjectPrefix == "" {
+			doc.Methods[i].Subject = m.Endpoint
+		} else {
+			doc.Methods[i].Subject = cfg.subjectPrefix + "." + m.Endpoint
+		}
+	}
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode schema document: %w", err)
+	}
+	headers := micro.Headers{
+		ContentTypeHeader: []string{ContentTypeJSON},
+		SchemaHashHeader:  []string{doc.SchemaHash},
+	}
+	return func(req micro.Request) {
+		if err := req.Respond(data, micro.WithHeaders(headers)); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to send schema response: %v\n", err)
+		}
+	}, nil
+}
+
+// FetchServiceSchema requests the ServiceSchemaDocument of the service whose
+// $schema endpoint is at subject, e.g., "api.orders.order_service.$schema"
+func FetchServiceSchema(ctx context.Context, nc *nats.Conn, subject string) (*ServiceSchemaDocument, error) {
+	msg, err := nc.RequestWithContext(ctx, subject, nil)
+	if err != nil {
+		return nil, err
+	}
+	if code := msg.Header.Get("Nats-Service-Error-Code"); code != "" {
+		return nil, &Status{Code: ParseCode(code), Message: msg.Header.Get("Nats-Service-Error")}
+	}
+	var doc ServiceSchemaDocument
+	if err := json.Unmarshal(msg.Data, &doc); err != nil {
+		return nil, fmt.Errorf("failed to decode schema document: %w", err)
+	}
+	return &doc, nil
+}
+
+// schemaEndpointMetadata describes each method of service, a fully qualified
+// name, from its schema blob. The result is keyed by method name and holds
+// request_type, response_type and, for streams, streaming (client, server or bidi).
+func schemaEndpointMetadata(schema, service string) (map[string]map[string]string, error) {
+	var set descriptorpb.FileDescriptorSet
+	if err := proto.Unmarshal([]byte(schema), &set); err != nil {
+		return nil, fmt.Errorf("failed to decode schema: %w", err)
+	}
+	pkg, name := "", service
+	if i := strings.LastIndex(service, "."); i >= 0 {
+		pkg, name = service[:i], service[i+1:]
+	}
+	for _, file := range set.File {
+		if file.GetPackage() != pkg {
+			continue
+		}
+		for _, sd := range file.Service {
+			if sd.GetName() != name {
+				continue
+			}
+			methods := make(map[string]map[string]string, len(sd.Method))
+			for _, md := range sd.Method {
+				metadata := map[string]string{
+					"request_type":  strings.TrimPrefix(md.GetInputType(), "."),
+					"response_type": strings.TrimPrefix(md.GetOutputType(), "."),
+				}
+				switch {
+				case md.GetClientStreaming() && md.GetServerStreaming():
+					metadata["streaming"] = "bidi"
+				case md.GetClientStreaming():
+					metadata["streaming"] = "client"
+				case md.GetServerStreaming():
+					metadata["streaming"] = "server"
+				}
+				methods[md.GetName()] = metadata
+			}
+			return methods, nil
+		}
+	}
+	return nil, fmt.Errorf("schema does not describe service %s", service)
+}
+
+// mergeMetadata returns base overlaid with over, leaving both untouched
+func mergeMetadata(base, over map[string]string) map[string]string {
+	merged := make(map[string]string, len(base)+len(over))
+	for k, v := range base {
+		merged[k] = v
+	}
+	for k, v := range over {
+		merged[k] = v
+	}
+	return merged
+}
+
+// SchemaHash returns the schema_hash a running service advertises in its INFO
+// metadata, so catalogs can tell whether its schema changed without fetching
+// the descriptors from its $reflect endpoint. service is the micro service name.
+// When several instances run, the first to answer wins.
+func SchemaHash(ctx context.Context, nc *nats.Conn, service string) (string, error) {
+	msg, err := nc.RequestWithContext(ctx, "$SRV.INFO."+service, nil)
+	if err != nil {
+		return "", err
+	}
+	var info micro.Info
+	if err := json.Unmarshal(msg.Data, &info); err != nil {
+		return "", fmt.Errorf("failed to decode service info: %w", err)
+	}
+	hash, ok := info.Metadata["schema_hash"]
+	if !ok {
+		return "", fmt.Errorf("service %s does not advertise a schema hash", service)
+	}
+	return hash, nil
+}
+
+// SanitizeToken is the default escaping for request fields interpolated into key
+// templates. Letters, digits, '-' and '_' are kept; every other byte, including
+// '.', '*', '>', '=' and whitespace, becomes '=' followed by two uppercase hex
+// digits. A field therefore always stays a single literal subject token and a
+// valid KV key segment: "a.b *" becomes "a=2Eb=20=2A". Empty fields stay empty.
+func SanitizeToken(token string) string {
+	var b strings.Builder
+	for i := 0; i < len(token); i++ {
+		c := token[i]
+		if 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '-' || c == '_' {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "=%02X", c)
+	}
+	return b.String()
+}
+
+// modifyKeyField renders a request field for a key template placeholder with
+// modifiers, e.g., {email:lower:sha256}, applying them left to right before the
+// token sanitizer. Generation only emits sha256, md5, lower, upper and trunc<N>.
+// Case mapping is ASCII-only and trunc counts code points, so Go, TypeScript and
+// Python services build the same keys.
+func modifyKeyField(v any, modifiers ...string) string {
+	s := fmt.Sprint(v)
+	for _, modifier := range modifiers {
+		switch modifier {
+		case "sha256":
+			sum := sha256.Sum256([]byte(s))
+			s = hex.EncodeToString(sum[:])
+		case "md5":
+			sum := md5.Sum([]byte(s))
+			s = hex.EncodeToString(sum[:])
+		case "lower":
+			s = strings.Map(func(r rune) rune {
+				if 'A' <= r && r <= 'Z' {
+					return r + 'a' - 'A'
+				}
+				return r
+			}, s)
+		case "upper":
+			s = strings.Map(func(r rune) rune {
+				if 'a' <= r && r <= 'z' {
+					return r - ('a' - 'A')
+				}
+				return r
+			}, s)
+		default: // trunc<N>
+			n, _ := strconv.Atoi(strings.TrimPrefix(modifier, "trunc"))
+			if runes := []rune(s); len(runes) > n {
+				s = string(runes[:n])
+			}
+		}
+	}
+	return s
+}
+
+// defaultID is the identifier generated code mints without WithIDGenerator: the
+// unique part of a new inbox
+func defaultID() string {
+	return strings.TrimPrefix(nats.NewInbox(), nats.InboxPrefix)
+}
+
+// mintID returns a new identifier from gen, passed through SanitizeToken so it
+// stays a single subject token. A nil gen, or one returning "", falls back to defaultID.
+func mintID(gen func() string) string {
+	if gen != nil {
+		if id := SanitizeToken(gen()); id != "" {
+			return id
+		}
+	}
+	return defaultID()
+}
+
+// mintInbox returns a new inbox subject named by mintID
+func mintInbox(gen func() string) string {
+	return nats.InboxPrefix + mintID(gen)
+}
+
+// idGeneratorKey is the context key for the ID generator of a service or client
+type idGeneratorKey struct{}
+
+// withIDGenerator returns ctx carrying gen for NewID
+func withIDGenerator(ctx context.Context, gen func() string) context.Context {
+	if gen == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, idGeneratorKey{}, gen)
+}
+
+// NewID returns a new identifier from the generator set with WithIDGenerator or
+// WithNatsClientIDGenerator, sanitized like every ID generated code mints. Handler
+// contexts and the contexts client interceptors see carry the generator, so
+// middleware can tag work with IDs from the same source. Without one it returns a NUID.
+func NewID(ctx context.Context) string {
+	gen, _ := ctx.Value(idGeneratorKey{}).(func() string)
+	return mintID(gen)
+}
+
+// Stream protocol header constants
+const (
+	natsStreamSeqHeader   = "Nats-Stream-Seq"
+	natsStreamEndHeader   = "Nats-Stream-End"
+	natsStreamInboxHeader = "Nats-Stream-Inbox"
+	natsStreamErrorHeader = "Nats-Stream-Error"
+	// Sent by clients to the server's inbox of a client or bidi stream to cancel it.
+	// The value is why: CANCELLED or DEADLINE_EXCEEDED.
+	natsStreamCancelHeader = "Nats-Stream-Cancel"
+	// Sent by a draining server on the client's inbox of a server or bidi stream (a
+	// GOAWAY). The value is the RFC 3339 deadline after which the handler's context
+	// ends; the client acknowledges on the inbox in natsStreamDrainAckHeader when it
+	// closes the stream, which ends the handler's context right away.
+	natsStreamDrainHeader    = "Nats-Stream-Drain"
+	natsStreamDrainAckHeader = "Nats-Stream-Drain-Ack"
+	// The handler's resume token for server-stream messages; see SetResumeToken
+	natsStreamResumeTokenHeader = "Nats-Stream-Resume-Token"
+)
+
+// Stream establishment option headers, sent with the request that opens a stream
+const (
+	natsStreamOptPrefix       = "Nats-Stream-Opt-"
+	natsStreamOptFrameSize    = "Frame-Size"
+	natsStreamOptResumeFrom   = "Resume-From"
+	natsStreamOptClientBuffer = "Client-Buffer"
+)
+
+// Flow control headers for server streams. The opening request carries the client's
+// window and the inbox it sends credits to; the first message carries the window the
+// server settled on; credit messages grant the server that many more messages.
+const (
+	natsStreamWindowHeader      = "Nats-Stream-Window"
+	natsStreamCreditInboxHeader = "Nats-Stream-Credit-Inbox"
+	natsStreamCreditHeader      = "Nats-Stream-Credit"
+)
+
+// Compression headers. The opening request lists the encodings the client decodes
+// in natsStreamAcceptEncodingHeader; the server names the one it picked in
+// StreamEncodingHeader, on the reply that opens a client or bidi stream and on the
+// first server-stream message. Each compressed message is flagged with
+// natsStreamFrameEncodingHeader, so compressed and plain messages mix in a stream.
+const (
+	natsStreamAcceptEncodingHeader = "Nats-Stream-Accept-Encoding"
+	StreamEncodingHeader           = "Nats-Stream-Encoding"
+	natsStreamFrameEncodingHeader  = "Nats-Stream-Frame-Encoding"
+	streamEncodingGzip             = "gzip"
+)
+
+// A stream compressor doubles its threshold after streamCompressionMisses messages
+// in a row that gzip did not shrink, up to maxStreamCompressionThreshold, and falls
+// back to the configured one once a message shrinks again
+const (
+	streamCompressionMisses       = 8
+	maxStreamCompressionThreshold = 1 << 20
+)
+
+// defaultStreamWindow is the number of unread server-stream messages after which Send
+// blocks, unless WithStreamWindow or WithClientStreamWindow says otherwise
+const defaultStreamWindow = 64
+
+// errStreamClosedByClient is returned by Send once the client has closed the stream
+var errStreamClosedByClient = errors.New("stream closed by client")
+
+// ErrStreamEOF is returned by Recv once the peer has ended the stream cleanly. It is
+// io.EOF, so code comparing err.Error() with "EOF" keeps working for this release;
+// use errors.Is(err, ErrStreamEOF) instead.
+var ErrStreamEOF = io.EOF
+
+// ErrStreamBroken is returned, wrapped, by Recv when the stream's transport fails:
+// its subscription is gone, or messages arrive out of order or go missing
+var ErrStreamBroken = errors.New("stream broken")
+
+// ErrStreamMessageLost is returned by Recv when messages of a stream went missing,
+// e.g. dropped by NATS for a slow consumer. Messages Expected to Got-1 were lost.
+// The stream carries on: the next Recv returns message Got, or ErrStreamEOF if the
+// loss came right before the end of the stream (Got is then one past the last
+// message sent). It wraps ErrStreamBroken. WithStreamAllowGaps and
+// WithClientStreamAllowGaps skip lost messages silently instead.
+type ErrStreamMessageLost struct {
+	Expected int // Sequence number of the first lost message
+	Got      int // Sequence number of the message that arrived instead
+}
+
+func (e *ErrStreamMessageLost) Error() string {
+	return fmt.Sprintf("stream message lost: expected seq %d, got %d", e.Expected, e.Got)
+}
+
+// Unwrap returns ErrStreamBroken
+func (e *ErrStreamMessageLost) Unwrap() error { return ErrStreamBroken }
+
+// ErrServerDraining is returned once by Recv on server and bidi streams when the
+// server started draining: it takes no new requests and ends the stream's handler
+// at Deadline, or as soon as the client closes the stream. Until then the stream
+// carries on, so the client can finish reading it, or reopen it on another instance
+// with WithResumeFrom(stream.ResumeToken()) and close this one.
+// WithClientStreamResumeOnDrain reopens server streams that way automatically.
+type ErrServerDraining struct {
+	Deadline time.Time // When the handler's context ends
+}
+
+func (e *ErrServerDraining) Error() string {
+	return fmt.Sprintf("server draining: stream ends at %s", e.Deadline.Format(time.RFC3339Nano))
+}
+
+// errStreamDrainAcknowledged is the context cause of stream handlers whose client
+// closed the stream after a GOAWAY
+var errStreamDrainAcknowledged = errors.New("client closed the stream after the server started draining")
+
+// publishStreamCancel tells the server, through its stream inbox, that the client
+// gave up on a client or bidi stream because of cause
+func publishStreamCancel(nc *nats.Conn, inbox string, cause error) {
+	reason := CodeCanceled
+	if errors.Is(cause, context.DeadlineExceeded) {
+		reason = CodeDeadlineExceeded
+	}
+	msg := nats.NewMsg(inbox)
+	msg.Header.Set(natsStreamCancelHeader, reason.String())
+	_ = nc.PublishMsg(msg)
+}
+
+// parseStreamWindow reads the flow control window a client asked for, capped at limit.
+// Returns a window of 0 when the client sent none or limit disables flow control.
+func parseStreamWindow(headers micro.Headers, limit int) (window int, creditInbox string, err error) {
+	value := headers.Get(natsStreamWindowHeader)
+	if value == "" || limit <= 0 {
+		return 0, "", nil
+	}
+	window, err = strconv.Atoi(value)
+	if err != nil || window <= 0 {
+		return 0, "", fmt.Errorf("invalid %s: %q", natsStreamWindowHeader, value)
+	}
+	creditInbox = headers.Get(natsStreamCreditInboxHeader)
+	if creditInbox == "" {
+		return 0, "", fmt.Errorf("%s without %s", natsStreamWindowHeader, natsStreamCreditInboxHeader)
+	}
+	if window > limit {
+		window = limit
+	}
+	return window, creditInbox, nil
+}
+
+// streamCredits counts the messages a flow-controlled sender may still send
+type streamCredits struct {
+	mu      sync.Mutex
+	n       int
+	closed  bool          // The client closed the stream
+	granted chan struct{} // Signalled when credits arrive or the client closes
+}
+
+// take uses one credit, waiting for the client to grant more if none are left
+func (c *streamCredits) take(ctx context.Context) error {
+	for {
+		c.mu.Lock()
+		switch {
+		case c.closed:
+			c.mu.Unlock()
+			return errStreamClosedByClient
+		case c.n > 0:
+			c.n--
+			c.mu.Unlock()
+			return nil
+		}
+		c.mu.Unlock()
+		select {
+		case <-c.granted:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (c *streamCredits) update(n int, closed bool) {
+	c.mu.Lock()
+	c.n += n
+	c.closed = c.closed || closed
+	c.mu.Unlock()
+	select {
+	case c.granted <- struct{}{}:
+	default:
+	}
+}
+
+// StreamOptions holds the establishment options a client sent when opening a stream.
+// Handlers treat them as hints; the zero value means the client sent none.
+type StreamOptions struct {
+	FrameSizeHint int    // Preferred frame size in bytes (0 = not set)
+	ResumeFrom    string // Token to resume a previous stream from ("" = start)
+	ClientBuffer  int    // Messages the client can buffer (0 = not set)
+
+	// Compression negotiated for the messages the handler sends: the encoding
+	// ("" = none) and the size from which messages are compressed
+	Encoding             string
+	CompressionThreshold int
+
+	// Raw holds every Nats-Stream-Opt-* header, recognized or not, keyed by the
+	// name after the prefix (e.g., "Frame-Size")
+	Raw map[string]string
+}
+
+// parseStreamOptions reads establishment options from request headers.
+// Returns an error if a recognized option has an invalid value.
+func parseStreamOptions(headers micro.Headers) (StreamOptions, error) {
+	opts := StreamOptions{Raw: map[string]string{}}
+	for key, values := range headers {
+		if name, ok := strings.CutPrefix(key, natsStreamOptPrefix); ok && len(values) > 0 {
+			opts.Raw[name] = values[0]
+		}
+	}
+
+	var err error
+	if opts.FrameSizeHint, err = parseStreamOptionInt(opts.Raw, natsStreamOptFrameSize); err != nil {
+		return StreamOptions{}, err
+	}
+	if opts.ClientBuffer, err = parseStreamOptionInt(opts.Raw, natsStreamOptClientBuffer); err != nil {
+		return StreamOptions{}, err
+	}
+	opts.ResumeFrom = opts.Raw[natsStreamOptResumeFrom]
+	return opts, nil
+}
+
+func parseStreamOptionInt(raw map[string]string, name string) (int, error) {
+	value, ok := raw[name]
+	if !ok {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid stream option %s%s: %q", natsStreamOptPrefix, name, value)
+	}
+	return n, nil
+}
+
+// acceptsStreamEncoding reports whether the opening request's headers list encoding
+func acceptsStreamEncoding(headers micro.Headers, encoding string) bool {
+	return acceptsEncoding(headers.Get(natsStreamAcceptEncodingHeader), encoding)
+}
+
+// negotiateStreamCompression returns the compressor for a stream opened with
+// headers, and the encoding it uses, or nil and "" when the client doesn't accept
+// codec or threshold is 0 (compression off)
+func negotiateStreamCompression(headers micro.Headers, threshold int, codec Compressor) (*streamCompressor, string) {
+	if threshold <= 0 || !acceptsStreamEncoding(headers, codec.Name()) {
+		return nil, ""
+	}
+	return newStreamCompressor(threshold, codec), codec.Name()
+}
+
+// acceptedStreamCompression returns the compressor for the messages a client sends
+// on a stream whose opening reply has header, or nil if the server agreed to no
+// registered encoding or threshold is 0 (compression off)
+func acceptedStreamCompression(header nats.Header, threshold int) *streamCompressor {
+	codec := compressorFor(header.Get(StreamEncodingHeader))
+	if threshold <= 0 || codec == nil {
+		return nil
+	}
+	return newStreamCompressor(threshold, codec)
+}
+
+// streamCompressor compresses stream messages of at least threshold bytes when that
+// makes them smaller, raising the threshold while messages don't compress
+type streamCompressor struct {
+	mu        sync.Mutex
+	codec     Compressor
+	base      int // Configured threshold
+	threshold int // Current threshold
+	misses    int // Messages in a row the codec did not shrink
+}
+
+// newStreamCompressor returns a compressor for messages of at least threshold bytes
+func newStreamCompressor(threshold int, codec Compressor) *streamCompressor {
+	return &streamCompressor{codec: codec, base: threshold, threshold: threshold}
+}
+
+// encode returns data to send, compressed and flagged in header if that pays off.
+// A nil compressor returns data.
+func (c *streamCompressor) encode(data []byte, header nats.Header) []byte {
+	if c == nil {
+		return data
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(data) < c.threshold {
+		return data
+	}
+	compressed, ok := compressWith(c.codec, data)
+	if !ok {
+		if c.misses++; c.misses >= streamCompressionMisses {
+			c.threshold, c.misses = min(c.threshold*2, maxStreamCompressionThreshold), 0
+		}
+		return data
+	}
+	c.threshold, c.misses = c.base, 0
+	header.Set(natsStreamFrameEncodingHeader, c.codec.Name())
+	return compressed
+}
+
+// decodeStreamFrame returns the payload of a message flagged with encoding, one of
+// the registered codecs. It stops reading one byte past limit, so the size check
+// that follows fails rather than inflating the whole message (0 = unlimited).
+func decodeStreamFrame(encoding string, data []byte, limit int) ([]byte, error) {
+	return decompressPayload(encoding, data, limit)
+}
+
+// StreamCallOption configures the opening request of a streaming call
+type StreamCallOption func(nats.Header)
+
+// WithFrameSizeHint asks the server to send frames of about n bytes
+func WithFrameSizeHint(n int) StreamCallOption {
+	return WithStreamOption(natsStreamOptFrameSize, strconv.Itoa(n))
+}
+
+// WithResumeFrom asks the server to resume a previous stream from token
+func WithResumeFrom(token string) StreamCallOption {
+	return WithStreamOption(natsStreamOptResumeFrom, token)
+}
+
+// WithClientBufferSize tells the server how many messages the client can buffer
+func WithClientBufferSize(n int) StreamCallOption {
+	return WithStreamOption(natsStreamOptClientBuffer, strconv.Itoa(n))
+}
+
+// WithStreamOption sends a custom establishment option, readable on the server
+// through StreamOptions.Raw[name]
+func WithStreamOption(name, value string) StreamCallOption {
+	return func(h nats.Header) {
+		h.Set(natsStreamOptPrefix+name, value)
+	}
+}
+
+// ServerStreamSender is the server-side interface for sending streaming responses
+type ServerStreamSender interface {
+	// Send publishes one message to the client
+	Send(data []byte) error
+	// SendMsg serializes and sends a proto message to the client
+	SendMsg(msg proto.Message, useJSON bool) error
+	// Close sends the end-of-stream marker to the client
+	Close() error
+	// CloseWithError sends an error and end-of-stream marker to the client
+	CloseWithError(code string, message string) error
+}
+
+// serverStreamSender implements ServerStreamSender using NATS publish
+type serverStreamSender struct {
+	nc      *nats.Conn
+	subject string // The client's reply inbox
+	seq     int
+	mu      sync.Mutex
+	closed  bool
+
+	// Headers for the first message, from SetResponseHeaders (nil = none)
+	responseHeaders func() nats.Header
+
+	resumeToken string             // Sent with each message (see SetResumeToken)
+	drainAckSub *nats.Subscription // Receives the client's acknowledgement of a GOAWAY
+
+	compressor *streamCompressor // Compresses messages (nil = negotiated none)
+	encoding   string            // Announced on the first message ("" = none)
+
+	// Flow control, set by enableFlowControl
+	ctx       context.Context    // Bounds waits for credits
+	window    int                // Announced on the first message
+	credits   *streamCredits     // nil = no flow control
+	creditSub *nats.Subscription // Receives credits from the client
+}
+
+func newServerStreamSender(nc *nats.Conn, replySubject string) *serverStreamSender {
+	return &serverStreamSender{
+		nc:      nc,
+		subject: replySubject,
+		seq:     0,
+	}
+}
+
+// rejectStream answers an opening request that fails before its stream starts.
+// Clients listen for the stream on the inbox in the request's Reply-To header,
+// so the error ends the stream there; requests without one get an error reply.
+func rejectStream(nc *nats.Conn, req micro.Request, code, message string, data []byte) {
+	if replyTo := req.Headers().Get("Reply-To"); replyTo != "" {
+		newServerStreamSender(nc, replyTo).closeWith(code, message, data)
+		return
+	}
+	req.Error(code, message, data)
+}
+
+// enableFlowControl makes Send wait for credits once window messages are unread.
+// The client grants more on creditInbox as it reads; waits end with ctx.
+func (s *serverStreamSender) enableFlowControl(ctx context.Context, window int, creditInbox string) error {
+	credits := &streamCredits{n: window, granted: make(chan struct{}, 1)}
+	sub, err := s.nc.Subscribe(creditInbox, func(msg *nats.Msg) {
+		n, err := strconv.Atoi(msg.Header.Get(natsStreamCreditHeader))
+		if err != nil || n < 0 {
+			n = 0
+		}
+		credits.update(n, msg.Header.Get(natsStreamEndHeader) == "true")
+	})
+	if err != nil {
+		return fmt.Errorf("failed to subscribe to stream credits: %w", err)
+	}
+	s.ctx, s.window, s.credits, s.creditSub = ctx, window, credits, sub
+	return nil
+}
+
+// stopFlowControl stops receiving credits and GOAWAY acknowledgements; callers hold s.mu
+func (s *serverStreamSender) stopFlowControl() {
+	if s.creditSub != nil {
+		_ = s.creditSub.Unsubscribe()
+		s.creditSub = nil
+	}
+	if s.drainAckSub != nil {
+		_ = s.drainAckSub.Unsubscribe()
+		s.drainAckSub = nil
+	}
+}
+
+// setResumeToken sets the resume token sent with the following messages
+func (s *serverStreamSender) setResumeToken(token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.resumeToken = token
+}
+
+// goAway tells the client the server is draining and the handler's context ends at
+// deadline. The client acknowledges on ackInbox by closing the stream, which calls
+// onAck. Closed streams get no GOAWAY.
+func (s *serverStreamSender) goAway(deadline time.Time, ackInbox string, onAck func()) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil
+	}
+	msg := nats.NewMsg(s.subject)
+	msg.Header.Set(natsStreamDrainHeader, deadline.UTC().Format(time.RFC3339Nano))
+	if sub, err := s.nc.Subscribe(ackInbox, func(*nats.Msg) { onAck() }); err == nil {
+		s.drainAckSub = sub
+		msg.Header.Set(natsStreamDrainAckHeader, ackInbox)
+	}
+	return s.nc.PublishMsg(msg)
+}
+
+func (s *serverStreamSender) Send(data []byte) error {
+	// Wait for a credit outside s.mu, so Close is never stuck behind a blocked Send
+	if s.credits != nil {
+		if err := s.credits.take(s.ctx); err != nil {
+			return err
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return errors.New("stream is closed")
+	}
+	s.seq++
+	msg := &nats.Msg{
+		Subject: s.subject,
+		Header:  nats.Header{},
+	}
+	msg.Data = s.compressor.encode(data, msg.Header)
+	if s.seq == 1 && s.responseHeaders != nil {
+		for k, v := range s.responseHeaders() {
+			msg.Header[k] = v
+		}
+	}
+	msg.Header.Set(natsStreamSeqHeader, strconv.Itoa(s.seq))
+	if s.resumeToken != "" {
+		msg.Header.Set(natsStreamResumeTokenHeader, s.resumeToken)
+	}
+	if s.seq == 1 && s.window > 0 {
+		msg.Header.Set(natsStreamWindowHeader, strconv.Itoa(s.window))
+	}
+	if s.seq == 1 && s.encoding != "" {
+		msg.Header.Set(StreamEncodingHeader, s.encoding)
+	}
+	return s.nc.PublishMsg(msg)
+}
+
+func (s *serverStreamSender) SendMsg(msg proto.Message, useJSON bool) error {
+	var data []byte
+	var err error
+	if useJSON {
+		data, err = marshalJSON(msg, false)
+	} else {
+		data, err = proto.Marshal(msg)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to marshal stream message: %w", err)
+	}
+	return s.Send(data)
+}
+
+func (s *serverStreamSender) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stopFlowControl()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	msg := &nats.Msg{
+		Subject: s.subject,
+		Data:    nil,
+		Header:  nats.Header{},
+	}
+	msg.Header.Set(natsStreamEndHeader, "true")
+	msg.Header.Set(natsStreamSeqHeader, strconv.Itoa(s.seq)) // Lets the client notice lost trailing messages
+	return s.nc.PublishMsg(msg)
+}
+
+func (s *serverStreamSender) CloseWithError(code string, message string) error {
+	return s.closeWith(code, message, nil)
+}
+
+// closeWithStatus ends the stream with the code, message and details a handler
+// error is sent with (see natsErrorFields)
+func (s *serverStreamSender) closeWithStatus(err error) error {
+	return s.closeWith(natsErrorFields(err))
+}
+
+func (s *serverStreamSender) closeWith(code, message string, details []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stopFlowControl()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	msg := &nats.Msg{
+		Subject: s.subject,
+		Data:    details,
+		Header:  nats.Header{},
+	}
+	msg.Header.Set(natsStreamEndHeader, "true")
+	msg.Header.Set("Nats-Service-Error-Code", code)
+	msg.Header.Set("Nats-Service-Error", message)
+	return s.nc.PublishMsg(msg)
+}
+
+// ClientStreamReceiver receives streaming messages from a server
+type ClientStreamReceiver struct {
+	sub       *nats.Subscription
+	msgCh     chan *nats.Msg
+	done      chan struct{}
+	closed    chan struct{} // Closed by Close; unblocks a delivery waiting on msgCh
+	closeOnce sync.Once
+	lastErr   error
+	allowGaps bool      // Skip lost messages instead of reporting them
+	lastSeq   int       // Sequence number of the last message received
+	endSeq    int       // Last sequence number the end marker says was sent (0 = unknown)
+	held      *nats.Msg // The message after a reported gap, for the next Recv
+	maxSize   int       // Largest accepted message payload in bytes (0 = unlimited)
+	mu        sync.Mutex
+
+	nc *nats.Conn // Publishes credits and GOAWAY acknowledgements
+
+	// Flow control, set by enableFlowControl
+	creditInbox string // Where credits go ("" = no flow control)
+	window      int    // Window the server announced, or the one requested
+	unacked     int    // Messages read since the last credit grant
+
+	header      nats.Header // Headers of the first message
+	resumeToken string      // Resume token of the last message received
+	drainAck    string      // Where to acknowledge a GOAWAY on Close ("" = none received)
+
+	// Client cancellation, on the server side of client and bidi streams
+	cancelled chan struct{}     // Closed by a cancel frame
+	cancelErr error             // CANCELLED *Status Recv returns once cancelled
+	onCancel  func(cause error) // Ends the handler's context (nil = none)
+
+	// Builds the error Recv returns for a handler error (nil = *Status)
+	remoteError func(code, message string, details []byte) error
+}
+
+func newClientStreamReceiver(nc *nats.Conn, inbox string, allowGaps bool) (*ClientStreamReceiver, error) {
+	msgCh := make(chan *nats.Msg, 64)
+	done := make(chan struct{})
+	r := &ClientStreamReceiver{
+		nc:        nc,
+		msgCh:     msgCh,
+		done:      done,
+		closed:    make(chan struct{}),
+		allowGaps: allowGaps,
+		cancelled: make(chan struct{}),
+	}
+
+	sub, err := nc.Subscribe(inbox, func(msg *nats.Msg) {
+		// A cancel frame from the client ends the stream at once
+		if reason := msg.Header.Get(natsStreamCancelHeader); reason != "" {
+			r.cancel(reason)
+			return
+		}
+		// Check for end-of-stream; an end with a handler error is delivered to Recv first.
+		// It comes on top of a full window, so a reader that stopped reading must not keep
+		// it waiting past Close.
+		if msg.Header.Get(natsStreamEndHeader) == "true" {
+			if msg.Header.Get("Nats-Service-Error-Code") != "" {
+				select {
+				case msgCh <- msg:
+				case <-r.closed:
+				}
+			} else if seq, err := strconv.Atoi(msg.Header.Get(natsStreamSeqHeader)); err == nil {
+				r.mu.Lock()
+				r.endSeq = seq
+				r.mu.Unlock()
+			}
+			close(done)
+			return
+		}
+		select {
+		case msgCh <- msg:
+		case <-done:
+		case <-r.closed:
+		}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to subscribe to stream inbox: %w", err)
+	}
+	r.sub = sub
+	return r, nil
+}
+
+// cancel handles a client's cancel frame: Recv fails with a CANCELLED *Status from
+// now on, and the handler's context ends with it as the cause
+func (r *ClientStreamReceiver) cancel(reason string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.cancelErr != nil {
+		return
+	}
+	r.cancelErr = Statusf(CodeCanceled, "stream cancelled by client (%s)", reason)
+	close(r.cancelled)
+	if r.onCancel != nil {
+		r.onCancel(r.cancelErr)
+	}
+}
+
+// setCancelFunc has a client's cancel frame call fn, e.g. to end the handler's context
+func (r *ClientStreamReceiver) setCancelFunc(fn func(cause error)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.onCancel = fn
+}
+
+// ended reports whether the peer has ended the stream
+func (r *ClientStreamReceiver) ended() bool {
+	select {
+	case <-r.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// enableFlowControl asks the server, through the opening request's headers, to keep
+// at most window messages unread, and grants credits on creditInbox as Recv reads them
+func (r *ClientStreamReceiver) enableFlowControl(nc *nats.Conn, window int, headers nats.Header, creditInbox string) {
+	r.nc, r.window, r.creditInbox = nc, window, creditInbox
+	headers.Set(natsStreamWindowHeader, strconv.Itoa(window))
+	headers.Set(natsStreamCreditInboxHeader, r.creditInbox)
+}
+
+// grantCredit counts msg as read and, every half window, returns the credits to the
+// server. Older servers ignore the credits.
+func (r *ClientStreamReceiver) grantCredit(msg *nats.Msg) {
+	if r.creditInbox == "" {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if w, err := strconv.Atoi(msg.Header.Get(natsStreamWindowHeader)); err == nil && w > 0 {
+		r.window = w
+	}
+	r.unacked++
+	if r.unacked < r.window/2 {
+		return
+	}
+	credit := nats.NewMsg(r.creditInbox)
+	credit.Header.Set(natsStreamCreditHeader, strconv.Itoa(r.unacked))
+	if r.nc.PublishMsg(credit) == nil {
+		r.unacked = 0
+	}
+}
+
+// Recv blocks until the next message arrives or the stream ends.
+// Returns the raw NATS message for caller to decode. It fails with ErrStreamEOF
+// once the stream is complete, ctx.Err() when ctx ends, an error wrapping
+// ErrStreamBroken when the transport fails, and the handler's error (see
+// remoteError) when the peer ended the stream with one. Lost messages are reported
+// once with an *ErrStreamMessageLost, unless allowGaps is set. On the server, a
+// client's cancel frame makes it fail with a CANCELLED *Status, ahead of queued messages.
+func (r *ClientStreamReceiver) Recv(ctx context.Context) (*nats.Msg, error) {
+	select {
+	case <-r.cancelled:
+		return nil, r.cancelErr
+	default:
+	}
+	// The message after a reported gap comes first, without another sequence check
+	r.mu.Lock()
+	msg, ok := r.held, r.held != nil
+	r.held = nil
+	r.mu.Unlock()
+	if !ok {
+		select {
+		case <-r.cancelled:
+			return nil, r.cancelErr
+		case msg, ok = <-r.msgCh:
+		case <-r.done:
+			// Messages sent before the end marker are queued by then; deliver them first
+			select {
+			case msg, ok = <-r.msgCh:
+			default:
+				return nil, r.endOfStream()
+			}
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		if !ok {
+			return nil, fmt.Errorf("%w: subscription closed", ErrStreamBroken)
+		}
+		if err := r.checkSeq(msg); err != nil {
+			return nil, err
+		}
+	}
+	// A draining server's GOAWAY is reported once, where it arrived in the stream
+	if deadline := msg.Header.Get(natsStreamDrainHeader); deadline != "" {
+		return nil, r.serverDraining(msg, deadline)
+	}
+	// Check for error in stream
+	if code := msg.Header.Get("Nats-Service-Error-Code"); code != "" {
+		message := msg.Header.Get("Nats-Service-Error")
+		var details []byte
+		if len(msg.Data) > 0 {
+			details = msg.Data
+		}
+		if r.remoteError != nil {
+			return nil, r.remoteError(code, message, details)
+		}
+		return nil, &Status{Code: ParseCode(code), Message: message, Details: details}
+	}
+	if encoding := msg.Header.Get(natsStreamFrameEncodingHeader); encoding != "" {
+		data, err := decodeStreamFrame(encoding, msg.Data, r.maxSize)
+		if err != nil {
+			return nil, fmt.Errorf("%w: undecodable stream message: %v", ErrStreamBroken, err)
+		}
+		msg.Data = data
+	}
+	if err := checkPayloadSize("stream message", len(msg.Data), r.maxSize); err != nil {
+		return nil, err
+	}
+	r.grantCredit(msg)
+	r.mu.Lock()
+	if r.header == nil {
+		r.header = msg.Header
+	}
+	if token := msg.Header.Get(natsStreamResumeTokenHeader); token != "" {
+		r.resumeToken = token
+	}
+	r.mu.Unlock()
+	return msg, nil
+}
+
+// serverDraining records the acknowledgement inbox of a GOAWAY and returns the
+// *ErrServerDraining Recv reports for it
+func (r *ClientStreamReceiver) serverDraining(msg *nats.Msg, deadline string) error {
+	r.mu.Lock()
+	r.drainAck = msg.Header.Get(natsStreamDrainAckHeader)
+	r.mu.Unlock()
+	at, err := time.Parse(time.RFC3339Nano, deadline)
+	if err != nil {
+		at = time.Now() // Unreadable deadline: assume the handler is ending now
+	}
+	return &ErrServerDraining{Deadline: at}
+}
+
+// ResumeToken returns the resume token of the last message received, or "" if the
+// handler set none (see SetResumeToken)
+func (r *ClientStreamReceiver) ResumeToken() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.resumeToken
+}
+
+// resumePoint returns the token to reopen the stream with so no message is
+// received twice, and false when there is none: messages arrived without a token
+func (r *ClientStreamReceiver) resumePoint() (string, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.resumeToken, r.resumeToken != "" || r.lastSeq == 0
+}
+
+// checkSeq tracks the sequence numbers of received messages. A message past the
+// next one expected is held for the next Recv behind an *ErrStreamMessageLost, and
+// one already passed fails with ErrStreamBroken, unless allowGaps is set. Messages
+// without a sequence number, such as error ends, are not checked.
+func (r *ClientStreamReceiver) checkSeq(msg *nats.Msg) error {
+	seq, err := strconv.Atoi(msg.Header.Get(natsStreamSeqHeader))
+	if err != nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	expected := r.lastSeq + 1
+	switch {
+	case seq < expected:
+		if r.allowGaps {
+			return nil
+		}
+		return fmt.Errorf("%w: out-of-order stream message: got seq %d, expected %d", ErrStreamBroken, seq, expected)
+	case seq > expected:
+		r.lastSeq = seq
+		r.unacked += seq - expected // Lost messages are never read, so credit them now
+		if r.allowGaps {
+			return nil
+		}
+		r.held = msg
+		return &ErrStreamMessageLost{Expected: expected, Got: seq}
+	}
+	r.lastSeq = seq
+	return nil
+}
+
+// endOfStream returns ErrStreamEOF once the stream has ended, after an
+// *ErrStreamMessageLost if the end marker shows messages were lost right before it
+func (r *ClientStreamReceiver) endOfStream() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.endSeq > r.lastSeq {
+		lost := &ErrStreamMessageLost{Expected: r.lastSeq + 1, Got: r.endSeq + 1}
+		r.lastSeq = r.endSeq
+		if !r.allowGaps {
+			return lost
+		}
+	}
+	return ErrStreamEOF
+}
+
+// Header returns the headers of the first message, which carry the server's
+// response headers, or nil before it is received
+func (r *ClientStreamReceiver) Header() nats.Header {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.header
+}
+
+// Close unsubscribes from the stream and, with flow control, tells the server to
+// stop sending. After a GOAWAY it acknowledges it, ending the handler's context.
+func (r *ClientStreamReceiver) Close() error {
+	if r.creditInbox != "" {
+		end := nats.NewMsg(r.creditInbox)
+		end.Header.Set(natsStreamEndHeader, "true")
+		_ = r.nc.PublishMsg(end)
+	}
+	r.mu.Lock()
+	drainAck := r.drainAck
+	r.mu.Unlock()
+	if drainAck != "" && !r.ended() {
+		_ = r.nc.Publish(drainAck, nil)
+	}
+	r.closeOnce.Do(func() { close(r.closed) })
+	return r.sub.Unsubscribe()
+}
+
+// Headers of the reply that opens a persistent server stream, naming the JetStream
+// stream and subject its messages are written to
+const (
+	natsStreamJSStreamHeader  = "Nats-Stream-JS-Stream"
+	natsStreamJSSubjectHeader = "Nats-Stream-JS-Subject"
+)
+
+// defaultPersistentStreamTTL is how long the messages of persistent server streams
+// are kept when (natsmicro.stream).persistence_ttl is unset
+const defaultPersistentStreamTTL = time.Hour
+
+// persistentStream is the JetStream stream holding the messages of a
+// (natsmicro.stream).persistence method, one subject per call
+type persistentStream struct {
+	js      jetstream.JetStream
+	name    string
+	subject string // Messages of a call go to subject.<call ID>
+}
+
+// newPersistentStream creates or updates the JetStream stream for the method at
+// methodSubject. Messages expire after ttl (0 = defaultPersistentStreamTTL).
+func newPersistentStream(ctx context.Context, js jetstream.JetStream, methodSubject string, ttl time.Duration) (*persistentStream, error) {
+	if ttl <= 0 {
+		ttl = defaultPersistentStreamTTL
+	}
+	p := &persistentStream{js: js, name: persistentStreamName(methodSubject), subject: methodSubject + ".$frames"}
+	if _, err := js.CreateOrUpdateStream(ctx, jetstream.StreamConfig{
+		Name:        p.name,
+		Description: "Persistent server streams of " + methodSubject,
+		Subjects:    []string{p.subject + ".*"},
+		MaxAge:      ttl,
+	}); err != nil {
+		return nil, fmt.Errorf("failed to create JetStream stream %s: %w", p.name, err)
+	}
+	return p, nil
+}
+
+// persistentStreamName derives a JetStream stream name from a method subject.
+// e.g., "api.exports.export_orders" -> "API_EXPORTS_EXPORT_ORDERS_FRAMES"
+func persistentStreamName(methodSubject string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-':
+			return r
+		}
+		return '_'
+	}, methodSubject)
+	return name + "_FRAMES"
+}
+
+// open starts the messages of a new call on a subject of their own, named by id
+func (p *persistentStream) open(ctx context.Context, id string) *persistentStreamSender {
+	return &persistentStreamSender{
+		ctx:     ctx,
+		js:      p.js,
+		stream:  p.name,
+		subject: p.subject + "." + id,
+	}
+}
+
+// persistentStreamSender implements ServerStreamSender by writing messages to
+// JetStream, where the client reads them, and can read them again after a disconnect
+type persistentStreamSender struct {
+	ctx     context.Context // Bounds waits for JetStream acks
+	js      jetstream.JetStream
+	stream  string
+	subject string // This call's subject
+	seq     int
+	mu      sync.Mutex
+	closed  bool
+
+	// Headers for the first message, from SetResponseHeaders (nil = none)
+	responseHeaders func() nats.Header
+}
+
+// announce returns the headers of the reply that tells the client where to read
+func (s *persistentStreamSender) announce() micro.Headers {
+	h := micro.Headers{}
+	h[natsStreamJSStreamHeader] = []string{s.stream}
+	h[natsStreamJSSubjectHeader] = []string{s.subject}
+	return h
+}
+
+func (s *persistentStreamSender) Send(data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return errors.New("stream is closed")
+	}
+	s.seq++
+	msg := nats.NewMsg(s.subject)
+	msg.Data = data
+	if s.seq == 1 && s.responseHeaders != nil {
+		for k, v := range s.responseHeaders() {
+			msg.Header[k] = v
+		}
+	}
+	msg.Header.Set(natsStreamSeqHeader, strconv.Itoa(s.seq))
+	if _, err := s.js.PublishMsg(s.ctx, msg); err != nil {
+		s.seq-- // Not stored, so the next message takes its place
+		return fmt.Errorf("failed to persist stream message: %w", err)
+	}
+	return nil
+}
+
+func (s *persistentStreamSender) SendMsg(msg proto.Message, useJSON bool) error {
+	var data []byte
+	var err error
+	if useJSON {
+		data, err = marshalJSON(msg, false)
+	} else {
+		data, err = proto.Marshal(msg)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to marshal stream message: %w", err)
+	}
+	return s.Send(data)
+}
+
+func (s *persistentStreamSender) Close() error {
+	return s.end(func(h nats.Header) {
+		h.Set(natsStreamSeqHeader, strconv.Itoa(s.seq))
+	}, nil)
+}
+
+func (s *persistentStreamSender) CloseWithError(code string, message string) error {
+	return s.closeWith(code, message, nil)
+}
+
+// closeWithStatus ends the stream with the code, message and details a handler
+// error is sent with (see natsErrorFields)
+func (s *persistentStreamSender) closeWithStatus(err error) error {
+	return s.closeWith(natsErrorFields(err))
+}
+
+func (s *persistentStreamSender) closeWith(code, message string, details []byte) error {
+	return s.end(func(h nats.Header) {
+		h.Set("Nats-Service-Error-Code", code)
+		h.Set("Nats-Service-Error", message)
+	}, details)
+}
+
+// end writes the end marker. It is written even when the handler's context has
+// ended, so a resuming client learns how the stream finished.
+func (s *persistentStreamSender) end(setHeaders func(nats.Header), data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	msg := nats.NewMsg(s.subject)
+	msg.Data = data
+	msg.Header.Set(natsStreamEndHeader, "true")
+	setHeaders(msg.Header)
+	_, err := s.js.PublishMsg(context.WithoutCancel(s.ctx), msg)
+	return err
+}
+
+// persistentStreamReceiver reads a persistent server stream from JetStream through an
+// ordered consumer, and can start reading again from any message still kept
+type persistentStreamReceiver struct {
+	js      jetstream.JetStream
+	stream  string
+	subject string
+	maxSize int // Largest accepted message payload in bytes (0 = unlimited)
+
+	// Builds the error Recv returns for a handler error (nil = *Status)
+	remoteError func(code, message string, details []byte) error
+
+	mu        sync.Mutex
+	msgs      chan jetstream.Msg
+	stop      func() // Stops the current consumer
+	lastSeq   uint64 // Sequence number of the last message received
+	streamSeq uint64 // JetStream sequence of that message (0 = none)
+	skipTo    uint64 // Messages up to this one are dropped after ResumeFrom
+	ended     bool   // The end marker has been read
+	header    nats.Header
+}
+
+// openPersistentStreamReceiver starts reading the stream a server's reply points to
+func openPersistentStreamReceiver(ctx context.Context, js jetstream.JetStream, reply *nats.Msg) (*persistentStreamReceiver, error) {
+	r := &persistentStreamReceiver{
+		js:      js,
+		stream:  reply.Header.Get(natsStreamJSStreamHeader),
+		subject: reply.Header.Get(natsStreamJSSubjectHeader),
+	}
+	if r.stream == "" || r.subject == "" {
+		return nil, errors.New("server did not open a persistent stream")
+	}
+	if err := r.consume(ctx, 0); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// consume replaces the consumer with one starting at JetStream sequence startSeq
+// (0 = the first message of the call)
+func (r *persistentStreamReceiver) consume(ctx context.Context, startSeq uint64) error {
+	cfg := jetstream.OrderedConsumerConfig{FilterSubjects: []string{r.subject}}
+	if startSeq > 0 {
+		cfg.DeliverPolicy = jetstream.DeliverByStartSequencePolicy
+		cfg.OptStartSeq = startSeq
+	}
+	consumer, err := r.js.OrderedConsumer(ctx, r.stream, cfg)
+	if err != nil {
+		return fmt.Errorf("failed to read persistent stream: %w", err)
+	}
+	msgs := make(chan jetstream.Msg, 64)
+	done := make(chan struct{})
+	cc, err := consumer.Consume(func(msg jetstream.Msg) {
+		select {
+		case msgs <- msg:
+		case <-done:
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("failed to read persistent stream: %w", err)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.stop != nil {
+		r.stop()
+	}
+	r.msgs = msgs
+	r.stop = func() {
+		cc.Stop()
+		close(done)
+	}
+	return nil
+}
+
+// Recv blocks until the next message arrives or the stream ends, and fails like
+// ClientStreamReceiver.Recv. Messages are kept in JetStream, so none are lost.
+func (r *persistentStreamReceiver) Recv(ctx context.Context) (*nats.Msg, error) {
+	for {
+		r.mu.Lock()
+		msgs, ended := r.msgs, r.ended
+		r.mu.Unlock()
+		if ended {
+			return nil, ErrStreamEOF
+		}
+		var msg jetstream.Msg
+		select {
+		case msg = <-msgs:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		seq, _ := strconv.ParseUint(msg.Headers().Get(natsStreamSeqHeader), 10, 64)
+		r.mu.Lock()
+		if msgs != r.msgs {
+			r.mu.Unlock()
+			continue // Read by a consumer ResumeFrom replaced
+		}
+		if msg.Headers().Get(natsStreamEndHeader) == "true" {
+			r.ended = true
+			r.mu.Unlock()
+			if code := msg.Headers().Get("Nats-Service-Error-Code"); code != "" {
+				message := msg.Headers().Get("Nats-Service-Error")
+				var details []byte
+				if len(msg.Data()) > 0 {
+					details = msg.Data()
+				}
+				if r.remoteError != nil {
+					return nil, r.remoteError(code, message, details)
+				}
+				return nil, &Status{Code: ParseCode(code), Message: message, Details: details}
+			}
+			return nil, ErrStreamEOF
+		}
+		if seq <= r.skipTo {
+			r.mu.Unlock()
+			continue
+		}
+		r.lastSeq = seq
+		if meta, err := msg.Metadata(); err == nil {
+			r.streamSeq = meta.Sequence.Stream
+		}
+		if r.header == nil {
+			r.header = msg.Headers()
+		}
+		r.mu.Unlock()
+		if err := checkPayloadSize("stream message", len(msg.Data()), r.maxSize); err != nil {
+			return nil, err
+		}
+		return &nats.Msg{Subject: msg.Subject(), Header: msg.Headers(), Data: msg.Data()}, nil
+	}
+}
+
+// Seq returns the sequence number of the last message received, starting at 1
+func (r *persistentStreamReceiver) Seq() uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.lastSeq
+}
+
+// ResumeFrom starts reading again after message seq, e.g. the Seq of the last
+// message received before a disconnect. Messages are kept for the method's
+// persistence_ttl; ResumeFrom(0) reads the stream from the start.
+func (r *persistentStreamReceiver) ResumeFrom(ctx context.Context, seq uint64) error {
+	r.mu.Lock()
+	var startSeq uint64
+	if seq > 0 && seq == r.lastSeq && r.streamSeq > 0 {
+		startSeq = r.streamSeq + 1 // Continue straight after the last message received
+	}
+	r.mu.Unlock()
+	if err := r.consume(ctx, startSeq); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.skipTo, r.ended = seq, false
+	return nil
+}
+
+// Header returns the headers of the first message, which carry the server's
+// response headers, or nil before it is received
+func (r *persistentStreamReceiver) Header() nats.Header {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.header
+}
+
+// Close stops reading. Once the end of the stream has been read, its messages are
+// purged; otherwise they are kept for ResumeFrom until persistence_ttl runs out.
+func (r *persistentStreamReceiver) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.stop != nil {
+		r.stop()
+		r.stop = nil
+	}
+	if !r.ended {
+		return nil
+	}
+	stream, err := r.js.Stream(context.Background(), r.stream)
+	if err != nil {
+		return err
+	}
+	return stream.Purge(context.Background(), jetstream.WithPurgeSubject(r.subject))
+}
+
+// Suppress unused import warnings
+var (
+	_ = strconv.Itoa
+	_ = sync.Mutex{}
+)
+
+// JournalRecord is one unary or fire-and-forget call written by WithJournal
+type JournalRecord struct {
+	Time        time.Time     `json:"time"`
+	Service     string        `json:"service"`
+	Method      string        `json:"method"`
+	Subject     string        `json:"subject"`
+	Headers     nats.Header   `json:"headers,omitempty"` // Outgoing headers of the call, without journalRedactedHeaders
+	ContentType string        `json:"content_type"`      // Codec of Request
+	Request     []byte        `json:"request"`           // Encoded request, after RedactMessage
+	Duration    time.Duration `json:"duration"`
+	Code        string        `json:"code"` // CodeOf the call's error; OK on success
+	Error       string        `json:"error,omitempty"`
+}
+
+// DecodeRequest decodes the recorded request into msg
+func (r *JournalRecord) DecodeRequest(msg proto.Message) error {
+	useJSON, err := payloadUsesJSON(r.ContentType, false)
+	if err != nil {
+		return err
+	}
+	if useJSON {
+		return protojson.Unmarshal(r.Request, msg)
+	}
+	return proto.Unmarshal(r.Request, msg)
+}
+
+// journalRedactedHeaders are credentials, left out of journal records
+var journalRedactedHeaders = []string{"Authorization", ImpersonationProofHeader}
+
+// journal writes records to w, one call at a time
+type journal struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// WithJournal appends a JournalRecord to w for every unary and fire-and-forget call,
+// after retries, for replay with the Replay<Service>Journal helpers of mocks=true.
+// Each record is a 4-byte big-endian length followed by that many bytes of JSON; read
+// them with ReadJournalRecord. Requests go through RedactMessage and credential headers
+// are dropped. Journaling never fails a call: write errors are ignored.
+func WithJournal(w io.Writer) NatsClientOption {
+	return natsClientOptionFunc(func(c *natsClientConfig) {
+		c.journal = &journal{w: w}
+	})
+}
+
+// record writes a call that started at start and ended with err
+func (j *journal) record(service, method, subject string, headers nats.Header, req proto.Message, useJSON, int64AsNumber bool, start time.Time, err error) {
+	rec := JournalRecord{
+		Time:        start,
+		Service:     service,
+		Method:      method,
+		Subject:     subject,
+		ContentType: contentType(useJSON),
+		Duration:    time.Since(start),
+		Code:        CodeOf(err).String(),
+	}
+	if err != nil {
+		rec.Error = err.Error()
+	}
+	if len(headers) > 0 {
+		rec.Headers = nats.Header{}
+		for k, v := range headers {
+			rec.Headers[k] = v
+		}
+		for _, k := range journalRedactedHeaders {
+			rec.Headers.Del(k)
+		}
+	}
+	redacted := RedactMessage(req)
+	if useJSON {
+		rec.Request, _ = marshalJSON(redacted, int64AsNumber)
+	} else {
+		rec.Request, _ = proto.Marshal(redacted)
+	}
+	data, jsonErr := json.Marshal(rec)
+	if jsonErr != nil {
+		return
+	}
+	buf := make([]byte, 4, 4+len(data))
+	binary.BigEndian.PutUint32(buf, uint32(len(data)))
+	buf = append(buf, data...)
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	_, _ = j.w.Write(buf)
+}
+
+// ReadJournalRecord reads the next record written by WithJournal. It returns io.EOF
+// at the end of the journal and io.ErrUnexpectedEOF for a truncated record.
+func ReadJournalRecord(r io.Reader) (*JournalRecord, error) {
+	var size [4]byte
+	if _, err := io.ReadFull(r, size[:]); err != nil {
+		return nil, err
+	}
+	data := make([]byte, binary.BigEndian.Uint32(size[:]))
+	if _, err := io.ReadFull(r, data); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	var rec JournalRecord
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return nil, fmt.Errorf("journal record: %w", err)
+	}
+	return &rec, nil
+}
+
+// RedactMessage returns a copy of msg with every field marked [debug_redact = true]
+// or (natsmicro.field).sensitive cleared, including fields of nested, repeated and
+// map-valued messages. Journals, samples, reports and the payload logging
+// interceptors record messages after it; call it before logging messages
+// yourself.
+func RedactMessage(msg proto.Message) proto.Message {
+	msg = proto.Clone(msg)
+	redactFields(msg.ProtoReflect())
+	return msg
+}
+
+// sensitiveFields holds the (natsmicro.field).sensitive fields, by full name. Each
+// service's init function adds those of its messages, since the option can only
+// be read while generating.
+var sensitiveFields = make(map[protoreflect.FullName]bool)
+
+// markSensitiveFields adds fields to sensitiveFields; call it from init only
+func markSensitiveFields(names ...protoreflect.FullName) {
+	for _, name := range names {
+		sensitiveFields[name] = true
+	}
+}
+
+func redactFields(m protoreflect.Message) {
+	m.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		if opts, ok := fd.Options().(*descriptorpb.FieldOptions); ok && opts.GetDebugRedact() || sensitiveFields[fd.FullName()] {
+			m.Clear(fd)
+			return true
+		}
+		switch {
+		case fd.IsMap():
+			if fd.MapValue().Message() != nil {
+				v.Map().Range(func(_ protoreflect.MapKey, mv protoreflect.Value) bool {
+					redactFields(mv.Message())
+					return true
+				})
+			}
+		case fd.IsList():
+			if fd.Message() != nil {
+				for i := 0; i < v.List().Len(); i++ {
+					redactFields(v.List().Get(i).Message())
+				}
+			}
+		case fd.Message() != nil:
+			redactFields(v.Message())
+		}
+		return true
+	})
+}
+
+// journalReplayConfig holds configuration for journal replays
+type journalReplayConfig struct {
+	speed  float64                     // Pace relative to the recording (0 = no delays)
+	result func(*JournalRecord, error) // Called with each replayed call's error
+}
+
+// JournalReplayOption configures Replay<Service>Journal
+type JournalReplayOption func(*journalReplayConfig)
+
+// WithReplaySpeed keeps the recorded gaps between calls, divided by speed: 1 replays
+// in real time, 2 twice as fast. Without it, calls are replayed back to back.
+func WithReplaySpeed(speed float64) JournalReplayOption {
+	return func(c *journalReplayConfig) {
+		c.speed = speed
+	}
+}
+
+// WithReplayResult calls fn after each replayed call with the record and the
+// error the call returned
+func WithReplayResult(fn func(rec *JournalRecord, err error)) JournalReplayOption {
+	return func(c *journalReplayConfig) {
+		c.result = fn
+	}
+}
+
+// replayJournal reads the journal in r and makes the calls recorded for service
+// through methods, keyed by method name, with the recorded outgoing headers. Call
+// errors go to WithReplayResult; an unreadable journal, an unknown method or ctx
+// ending stops the replay with an error.
+func replayJournal(ctx context.Context, r io.Reader, service string, methods map[string]func(context.Context, *JournalRecord) error, opts []JournalReplayOption) error {
+	cfg := &journalReplayConfig{}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	var last time.Time
+	for {
+		rec, err := ReadJournalRecord(r)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if rec.Service != service {
+			continue
+		}
+		call, ok := methods[rec.Method]
+		if !ok {
+			return fmt.Errorf("journal: %s has no method %s", service, rec.Method)
+		}
+		if cfg.speed > 0 && !last.IsZero() {
+			if gap := rec.Time.Sub(last); gap > 0 {
+				timer := time.NewTimer(time.Duration(float64(gap) / cfg.speed))
+				select {
+				case <-ctx.Done():
+					timer.Stop()
+					return ctx.Err()
+				case <-timer.C:
+				}
+			}
+		}
+		last = rec.Time
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		callCtx := ctx
+		if len(rec.Headers) > 0 {
+			callCtx = WithOutgoingHeaders(ctx, rec.Headers)
+		}
+		err = call(callCtx, rec)
+		if cfg.result != nil {
+			cfg.result(rec, err)
+		}
+	}
+}
+
+// StreamReceiver is a stream Pipe reads from, such as the client side of a
+// server-streaming call
+type StreamReceiver[T any] interface {
+	Recv(ctx context.Context) (T, error)
+}
+
+// StreamSender is a stream Pipe writes to, such as the client side of a
+// client-streaming call
+type StreamSender[T any] interface {
+	Send(msg T) error
+}
+
+// StreamSenderFunc adapts a function to StreamSender
+type StreamSenderFunc[T any] func(msg T) error
+
+// Send calls f(msg)
+func (f StreamSenderFunc[T]) Send(msg T) error { return f(msg) }
+
+// ErrPipeSkip, returned by a PipeMap transform, drops the message without treating
+// it as a failure
+var ErrPipeSkip = errors.New("pipe: skip message")
+
+// PipeErrorPolicy decides what a pipe does when a message fails to transform or send
+type PipeErrorPolicy int
+
+const (
+	// PipeStopOnError returns the first failure. This is the default.
+	PipeStopOnError PipeErrorPolicy = iota
+	// PipeSkipOnError drops the message that failed and carries on
+	PipeSkipOnError
+)
+
+// pipeConfig holds configuration for Pipe and PipeMap
+type pipeConfig struct {
+	policy PipeErrorPolicy
+	onSkip func(err error) // Called with each failure PipeSkipOnError drops
+}
+
+// PipeOption configures Pipe and PipeMap
+type PipeOption func(*pipeConfig)
+
+// WithPipeErrorPolicy sets what happens when a message fails to transform or send.
+// Receive errors always stop the pipe.
+func WithPipeErrorPolicy(policy PipeErrorPolicy) PipeOption {
+	return func(c *pipeConfig) {
+		c.policy = policy
+	}
+}
+
+// WithPipeSkipHandler calls fn with each failure dropped under PipeSkipOnError
+func WithPipeSkipHandler(fn func(err error)) PipeOption {
+	return func(c *pipeConfig) {
+		c.onSkip = fn
+	}
+}
+
+// Pipe forwards every message recv yields to send until recv's stream ends, and
+// returns the number of messages sent. It reads one message ahead of send at most,
+// so a slow sender holds back a flow-controlled stream (see WithClientStreamWindow)
+// instead of buffering it. Pipe neither closes recv nor finishes send: call Close and
+// CloseAndRecv afterwards. Errors from recv, and from send unless WithPipeErrorPolicy
+// says to skip them, stop the pipe and are returned wrapped. Generated streams need T
+// spelled out, or the typed Pipe<Service>_<Method>_To_<Service>_<Method> wrappers.
+// Example:
+//
+//	updates, _ := inventory.WatchStock(ctx, req)
+//	defer updates.Close()
+//	sink, _ := audit.RecordStock(ctx)
+//	n, err := Pipe[*StockUpdate](ctx, updates, sink)
+//	summary, err := sink.CloseAndRecv(ctx)
+func Pipe[T any](ctx context.Context, recv StreamReceiver[T], send StreamSender[T], opts ...PipeOption) (int, error) {
+	return PipeMap(ctx, recv, send, func(_ context.Context, msg T) (T, error) { return msg, nil }, opts...)
+}
+
+// PipeMap is Pipe with a transform applied to each message, for streams of different
+// message types or to rewrite messages on the way through. A transform returning
+// ErrPipeSkip drops the message; other transform errors follow the error policy.
+func PipeMap[In, Out any](ctx context.Context, recv StreamReceiver[In], send StreamSender[Out], transform func(context.Context, In) (Out, error), opts ...PipeOption) (int, error) {
+	cfg := &pipeConfig{}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	sent := 0
+	for received := 1; ; received++ {
+		in, err := recv.Recv(ctx)
+		if err != nil {
+			if isStreamEnd(err) {
+				return sent, nil
+			}
+			return sent, fmt.Errorf("pipe: receive message %d: %w", received, err)
+		}
+		out, err := transform(ctx, in)
+		if errors.Is(err, ErrPipeSkip) {
+			continue
+		}
+		if err != nil {
+			err = fmt.Errorf("pipe: transform message %d: %w", received, err)
+		} else if err = send.Send(out); err != nil {
+			err = fmt.Errorf("pipe: send message %d: %w", received, err)
+		} else {
+			sent++
+			continue
+		}
+		if cfg.policy != PipeSkipOnError {
+			return sent, err
+		}
+		if cfg.onSkip != nil {
+			cfg.onSkip(err)
+		}
+	}
+}
+
+// isStreamEnd reports whether err is a receiver's end-of-stream error
+func isStreamEnd(err error) bool {
+	return errors.Is(err, ErrStreamEOF)
+}
+
+// RequestIDHeader identifies a call for request sampling. Clients with
+// WithClientSampling send one when the caller has not; services sample by it, so a
+// client and a service with the same rate and salt sample the same calls.
+const RequestIDHeader = "X-Request-Id"
+
+// Sample is one sampled unary or fire-and-forget call, passed to the sink of
+// WithSampling or WithClientSampling
+type Sample struct {
+	Time      time.Time       `json:"time"`
+	Service   string          `json:"service"`
+	Method    string          `json:"method"`
+	RequestID string          `json:"request_id"`
+	Client    bool            `json:"client,omitempty"`   // Sampled by a client rather than a service
+	Headers   nats.Header     `json:"headers,omitempty"`  // Request headers, without credentials
+	Request   json.RawMessage `json:"request"`            // Request as JSON, after RedactMessage
+	Response  json.RawMessage `json:"response,omitempty"` // Response as JSON, after RedactMessage; empty on error
+	Duration  time.Duration   `json:"duration"`
+	Code      string          `json:"code"` // CodeOf the call's error; OK on success
+	Error     string          `json:"error,omitempty"`
+}
+
+// samplingConfig is what WithSampling and WithClientSampling set
+type samplingConfig struct {
+	rate float64
+	sink func(Sample)
+}
+
+// WithSampling passes a Sample of about rate (0 to 1) of the service's unary and
+// fire-and-forget calls to sink, e.g., 0.001 for 0.1%. Calls are picked by a hash of
+// their RequestIDHeader and the WithSamplingSalt salt, or of their reply subject
+// without one. sink runs on the handler goroutine before the reply is sent, so hand
+// expensive work off. Change the rate at runtime with the service's Reconfigure.
+func WithSampling(rate float64, sink func(Sample)) RegisterOption {
+	return func(c *registerConfig) {
+		c.sampling = &samplingConfig{rate: rate, sink: sink}
+	}
+}
+
+// WithSamplingSalt varies which calls WithSampling picks. Give clients the same salt
+// with WithClientSamplingSalt to sample the same calls on both sides.
+func WithSamplingSalt(salt string) RegisterOption {
+	return func(c *registerConfig) {
+		c.samplingSalt = salt
+	}
+}
+
+// WithClientSampling passes a Sample of about rate (0 to 1) of the client's unary and
+// fire-and-forget calls to sink, after retries. Calls without a RequestIDHeader in
+// their outgoing headers are given one, so services with the same rate and salt
+// sample the same calls.
+func WithClientSampling(rate float64, sink func(Sample)) NatsClientOption {
+	return natsClientOptionFunc(func(c *natsClientConfig) {
+		c.sampling = &samplingConfig{rate: rate, sink: sink}
+	})
+}
+
+// WithClientSamplingSalt is WithSamplingSalt for clients
+func WithClientSamplingSalt(salt string) NatsClientOption {
+	return natsClientOptionFunc(func(c *natsClientConfig) {
+		c.samplingSalt = salt
+	})
+}
+
+// sampler decides which calls to sample. Its settings can be swapped while calls run.
+type sampler struct {
+	settings atomic.Pointer[samplerSettings]
+}
+
+type samplerSettings struct {
+	threshold uint64 // Calls whose hash is below it are sampled
+	all       bool   // rate >= 1
+	salt      string
+	sink      func(Sample)
+}
+
+// newSampler returns a sampler for config, which may be nil (sample nothing)
+func newSampler(config *samplingConfig, salt string) *sampler {
+	s := &sampler{}
+	s.set(config, salt)
+	return s
+}
+
+// set replaces the sampler's settings; a nil config or sink stops sampling
+func (s *sampler) set(config *samplingConfig, salt string) {
+	if config == nil || config.sink == nil || config.rate <= 0 {
+		s.settings.Store(nil)
+		return
+	}
+	settings := &samplerSettings{all: config.rate >= 1, salt: salt, sink: config.sink}
+	if !settings.all {
+		settings.threshold = uint64(config.rate * (1 << 64))
+	}
+	s.settings.Store(settings)
+}
+
+// enabled reports whether the sampler samples anything
+func (s *sampler) enabled() bool {
+	return s != nil && s.settings.Load() != nil
+}
+
+// begin returns a recorder for the call with requestID if it is sampled, and nil
+// otherwise. The request is captured now, before a handler can modify it.
+func (s *sampler) begin(service, method, requestID string, client bool, headers nats.Header, req proto.Message, int64AsNumber bool) *sampleRecorder {
+	if s == nil {
+		return nil
+	}
+	settings := s.settings.Load()
+	if settings == nil || !settings.sampled(requestID) {
+		return nil
+	}
+	sample := Sample{
+		Time:      time.Now(),
+		Service:   service,
+		Method:    method,
+		RequestID: requestID,
+		Client:    client,
+	}
+	if len(headers) > 0 {
+		sample.Headers = nats.Header{}
+		for k, v := range headers {
+			sample.Headers[k] = v
+		}
+		for _, k := range journalRedactedHeaders {
+			sample.Headers.Del(k)
+		}
+	}
+	sample.Request, _ = marshalJSON(RedactMessage(req), int64AsNumber)
+	return &sampleRecorder{sample: sample, sink: settings.sink, int64AsNumber: int64AsNumber}
+}
+
+// sampled hashes requestID with the salt; the same ID, salt and rate always give
+// the same answer
+func (s *samplerSettings) sampled(requestID string) bool {
+	if s.all {
+		return true
+	}
+	h := fnv.New64a()
+	h.Write([]byte(s.salt))
+	h.Write([]byte{0})
+	h.Write([]byte(requestID))
+	// FNV leaves the high bits of similar IDs (req-1, req-2, ...) correlated; mix
+	// them with the murmur3 finalizer so the threshold sees uniform values
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x < s.threshold
+}
+
+// sampleRecorder completes the Sample of one call
+type sampleRecorder struct {
+	sample        Sample
+	sink          func(Sample)
+	int64AsNumber bool
+}
+
+// end records the outcome of the call and hands the Sample to the sink. resp is
+// nil for fire-and-forget calls. It does nothing on a nil recorder.
+func (r *sampleRecorder) end(resp any, err error) {
+	if r == nil {
+		return
+	}
+	r.sample.Duration = time.Since(r.sample.Time)
+	r.sample.Code = CodeOf(err).String()
+	if err != nil {
+		r.sample.Error = err.Error()
+	} else if msg, ok := resp.(proto.Message); ok && !reflect.ValueOf(msg).IsNil() {
+		r.sample.Response, _ = marshalJSON(RedactMessage(msg), r.int64AsNumber)
+	}
+	r.sink(r.sample)
+}
+
+// incomingRequestID is the ID services sample a request by: its RequestIDHeader,
+// or its reply subject without one
+func incomingRequestID(req micro.Request) string {
+	if id := req.Headers().Get(RequestIDHeader); id != "" {
+		return id
+	}
+	return req.Reply()
+}
+
+// withRequestID returns ctx with a RequestIDHeader among its outgoing headers,
+// minting one with idGenerator if there is none, and the ID
+func withRequestID(ctx context.Context, idGenerator func() string) (context.Context, string) {
+	headers := OutgoingHeaders(ctx)
+	if id := headers.Get(RequestIDHeader); id != "" {
+		return ctx, id
+	}
+	id := mintID(idGenerator)
+	withID := nats.Header{}
+	for k, v := range headers {
+		withID[k] = v
+	}
+	withID.Set(RequestIDHeader, id)
+	return WithOutgoingHeaders(ctx, withID), id
+}
+
+// reconfigureSampling applies the options of a service's Reconfigure to s. Only
+// sampling options can change after registration.
+func reconfigureSampling(s *sampler, cfg *registerConfig) error {
+	v := reflect.ValueOf(cfg).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		if name := v.Type().Field(i).Name; name != "sampling" && name != "samplingSalt" && !v.Field(i).IsZero() {
+			return fmt.Errorf("reconfigure: option setting %s can only be given at registration", name)
+		}
+	}
+	s.set(cfg.sampling, cfg.samplingSalt)
+	return nil
+}
+
+// defaultConnectionMonitorInterval is how often connections are sampled when only
+// an alarm is set
+const defaultConnectionMonitorInterval = 10 * time.Second
+
+// ConnectionSample is what a connection monitor saw over one interval. Counts are
+// for the interval, not since the connection opened.
+type ConnectionSample struct {
+	Time       time.Time
+	Interval   time.Duration // Time since the previous sample
+	URL        string        // Connected server, without credentials
+	Status     nats.Status
+	InMsgs     uint64
+	OutMsgs    uint64
+	InBytes    uint64
+	OutBytes   uint64
+	Buffered   int // Bytes waiting for the connection's flusher, e.g., 0 when it keeps up
+	Reconnects uint64
+	Exceeded   []string // ConnectionThresholds exceeded, e.g., "buffered"; set for alarms only
+}
+
+// ConnectionThresholds are the limits WithConnectionAlarm and WithClientConnectionAlarm
+// check each sample against. Zero fields are not checked.
+type ConnectionThresholds struct {
+	MaxBuffered   int    // Bytes waiting for the flusher ("buffered")
+	MaxOutMsgs    uint64 // Messages published per interval ("out_msgs")
+	MaxReconnects uint64 // Reconnects per interval ("reconnects")
+}
+
+// exceeded names the thresholds sample is over
+func (t ConnectionThresholds) exceeded(sample ConnectionSample) []string {
+	var names []string
+	if t.MaxBuffered > 0 && sample.Buffered > t.MaxBuffered {
+		names = append(names, "buffered")
+	}
+	if t.MaxOutMsgs > 0 && sample.OutMsgs > t.MaxOutMsgs {
+		names = append(names, "out_msgs")
+	}
+	if t.MaxReconnects > 0 && sample.Reconnects > t.MaxReconnects {
+		names = append(names, "reconnects")
+	}
+	return names
+}
+
+// connectionMonitorConfig is what the connection monitor options set
+type connectionMonitorConfig struct {
+	interval   time.Duration
+	sink       func(ConnectionSample)
+	thresholds ConnectionThresholds
+	alarm      func(ConnectionSample)
+}
+
+// WithConnectionMonitor samples the service's connection every interval, passing
+// each ConnectionSample to sink, e.g., a metrics exporter. Sampling stops when the
+// service stops or the connection closes.
+func WithConnectionMonitor(interval time.Duration, sink func(ConnectionSample)) RegisterOption {
+	return func(c *registerConfig) {
+		c.connMonitor = c.connMonitor.withSink(interval, sink)
+	}
+}
+
+// WithConnectionAlarm calls alarm with each sample of the service's connection
+// over thresholds. Samples are taken at the WithConnectionMonitor interval, or
+// every 10 seconds without one.
+func WithConnectionAlarm(thresholds ConnectionThresholds, alarm func(ConnectionSample)) RegisterOption {
+	return func(c *registerConfig) {
+		c.connMonitor = c.connMonitor.withAlarm(thresholds, alarm)
+	}
+}
+
+// WithClientConnectionMonitor is WithConnectionMonitor for clients. A client pool
+// samples each of its connections. Sampling stops when the client is closed or the
+// connection closes.
+func WithClientConnectionMonitor(interval time.Duration, sink func(ConnectionSample)) NatsClientOption {
+	return natsClientOptionFunc(func(c *natsClientConfig) {
+		c.connMonitor = c.connMonitor.withSink(interval, sink)
+	})
+}
+
+// WithClientConnectionAlarm is WithConnectionAlarm for clients
+func WithClientConnectionAlarm(thresholds ConnectionThresholds, alarm func(ConnectionSample)) NatsClientOption {
+	return natsClientOptionFunc(func(c *natsClientConfig) {
+		c.connMonitor = c.connMonitor.withAlarm(thresholds, alarm)
+	})
+}
+
+func (c *connectionMonitorConfig) withSink(interval time.Duration, sink func(ConnectionSample)) *connectionMonitorConfig {
+	updated := &connectionMonitorConfig{}
+	if c != nil {
+		*updated = *c
+	}
+	updated.interval, updated.sink = interval, sink
+	return updated
+}
+
+func (c *connectionMonitorConfig) withAlarm(thresholds ConnectionThresholds, alarm func(ConnectionSample)) *connectionMonitorConfig {
+	updated := &connectionMonitorConfig{}
+	if c != nil {
+		*updated = *c
+	}
+	updated.thresholds, updated.alarm = thresholds, alarm
+	return updated
+}
+
+// monitoredConn is the part of *nats.Conn a connection monitor samples
+type monitoredConn interface {
+	Stats() nats.Statistics
+	Buffered() (int, error)
+	Status() nats.Status
+	IsClosed() bool
+	ConnectedUrlRedacted() string
+}
+
+// monitoredConns returns the connections a client samples: its pool's, or nc
+func monitoredConns(nc *nats.Conn, pool []*nats.Conn) []monitoredConn {
+	if len(pool) == 0 {
+		return []monitoredConn{nc}
+	}
+	conns := make([]monitoredConn, len(pool))
+	for i, conn := range pool {
+		conns[i] = conn
+	}
+	return conns
+}
+
+// connectionMonitor samples connections until it is stopped
+type connectionMonitor struct {
+	stop chan struct{}
+	done sync.WaitGroup
+	once sync.Once
+}
+
+// startConnectionMonitor samples each of conns as config says. It returns nil,
+// which close accepts, when config is nil.
+func startConnectionMonitor(config *connectionMonitorConfig, conns ...monitoredConn) *connectionMonitor {
+	if config == nil || (config.sink == nil && config.alarm == nil) {
+		return nil
+	}
+	interval := config.interval
+	if interval <= 0 {
+		interval = defaultConnectionMonitorInterval
+	}
+	m := &connectionMonitor{stop: make(chan struct{})}
+	for _, conn := range conns {
+		m.done.Add(1)
+		go func() {
+			defer m.done.Done()
+			m.run(conn, interval, config)
+		}()
+	}
+	return m
+}
+
+func (m *connectionMonitor) run(conn monitoredConn, interval time.Duration, config *connectionMonitorConfig) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	last, lastTime := conn.Stats(), time.Now()
+	for {
+		select {
+		case <-m.stop:
+			return
+		case now := <-ticker.C:
+			if conn.IsClosed() {
+				return
+			}
+			stats := conn.Stats()
+			buffered, _ := conn.Buffered()
+			sample := ConnectionSample{
+				Time:       now,
+				Interval:   now.Sub(lastTime),
+				URL:        conn.ConnectedUrlRedacted(),
+				Status:     conn.Status(),
+				InMsgs:     stats.InMsgs - last.InMsgs,
+				OutMsgs:    stats.OutMsgs - last.OutMsgs,
+				InBytes:    stats.InBytes - last.InBytes,
+				OutBytes:   stats.OutBytes - last.OutBytes,
+				Buffered:   buffered,
+				Reconnects: stats.Reconnects - last.Reconnects,
+			}
+			last, lastTime = stats, now
+			if config.sink != nil {
+				config.sink(sample)
+			}
+			if config.alarm != nil {
+				if sample.Exceeded = config.thresholds.exceeded(sample); len(sample.Exceeded) > 0 {
+					config.alarm(sample)
+				}
+			}
+		}
+	}
+}
+
+// close stops sampling and waits for a sample in progress to finish
+func (m *connectionMonitor) close() {
+	if m == nil {
+		return
+	}
+	m.once.Do(func() { close(m.stop) })
+	m.done.Wait()
+}
+
+// defaultGatherTimeout bounds <Method>Gather calls without a context deadline,
+// WithGatherTimeout or WithClientTimeout
+const defaultGatherTimeout = 5 * time.Second
+
+// gatherConfig is what GatherOptions set
+type gatherConfig struct {
+	maxResponders int
+	stall         time.Duration
+	timeout       time.Duration
+}
+
+// GatherOption configures a <Method>Gather call
+type GatherOption func(*gatherConfig)
+
+// WithGatherMaxResponders returns as soon as n instances answered
+func WithGatherMaxResponders(n int) GatherOption {
+	return func(c *gatherConfig) { c.maxResponders = n }
+}
+
+// WithGatherStall returns once no instance answered for d after the first did, so
+// a call does not wait out its timeout for instances that are gone
+func WithGatherStall(d time.Duration) GatherOption {
+	return func(c *gatherConfig) { c.stall = d }
+}
+
+// WithGatherTimeout sets how long to collect responses, replacing the client's
+// WithClientTimeout. A context deadline that comes first still applies.
+func WithGatherTimeout(d time.Duration) GatherOption {
+	return func(c *gatherConfig) { c.timeout = d }
+}
+
+// newGatherConfig applies opts over the client's timeout
+func newGatherConfig(opts []GatherOption, clientTimeout time.Duration) gatherConfig {
+	cfg := gatherConfig{timeout: clientTimeout}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if cfg.timeout <= 0 {
+		cfg.timeout = defaultGatherTimeout
+	}
+	return cfg
+}
+
+// GatherError reports the instances that failed a <Method>Gather call. The call
+// still returns the responses of the others.
+type GatherError struct {
+	Errors []error // One per failed instance, e.g., a *<Service>Error
+}
+
+func (e *GatherError) Error() string {
+	if len(e.Errors) == 1 {
+		return fmt.Sprintf("gather: 1 responder failed: %v", e.Errors[0])
+	}
+	return fmt.Sprintf("gather: %d responders failed, first: %v", len(e.Errors), e.Errors[0])
+}
+
+// Unwrap lets errors.Is and errors.As match any responder's error
+func (e *GatherError) Unwrap() []error { return e.Errors }
+
+// gather publishes msg with a reply inbox and collects the responses until cfg
+// says to stop or ctx ends. Error responses become errors through newErr, and with
+// undecodable responses are returned as a *GatherError next to the rest.
+func gather[Resp any, PResp interface {
+	*Resp
+	proto.Message
+}](ctx context.Context, nc *nats.Conn, msg *nats.Msg, cfg gatherConfig, useJSON bool, maxResponseSize int, newErr func(code, message string, details []byte) error) ([]PResp, error) {
+	inbox := nc.NewInbox()
+	sub, err := nc.SubscribeSync(inbox)
+	if err != nil {
+		return nil, err
+	}
+	defer sub.Unsubscribe()
+	msg.Reply = inbox
+	if err := nc.PublishMsg(msg); err != nil {
+		return nil, err
+	}
+
+	var resps []PResp
+	var errs []error
+	for answered := 0; cfg.maxResponders <= 0 || answered < cfg.maxResponders; answered++ {
+		waitCtx, cancel := ctx, context.CancelFunc(func() {})
+		if cfg.stall > 0 && answered > 0 {
+			waitCtx, cancel = context.WithTimeout(ctx, cfg.stall)
+		}
+		reply, err := sub.NextMsgWithContext(waitCtx)
+		cancel()
+		if errors.Is(err, nats.ErrNoResponders) && answered == 0 {
+			return nil, err
+		}
+		if err != nil {
+			if errors.Is(ctx.Err(), context.Canceled) {
+				errs = append(errs, ctx.Err())
+			}
+			break // Timed out or stalled: everyone who answered in time is in
+		}
+		if code := reply.Header.Get("Nats-Service-Error-Code"); code != "" {
+			errs = append(errs, newErr(code, reply.Header.Get("Nats-Service-Error"), reply.Data))
+			continue
+		}
+		if err := checkPayloadSize("response", len(reply.Data), maxResponseSize); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		replyJSON, err := payloadUsesJSON(reply.Header.Get(ContentTypeHeader), useJSON)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		resp := PResp(new(Resp))
+		if replyJSON {
+			err = protojson.Unmarshal(reply.Data, resp)
+		} else {
+			err = proto.Unmarshal(reply.Data, resp)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to decode response: %w", err))
+			continue
+		}
+		resps = append(resps, resp)
+	}
+	if len(errs) > 0 {
+		return resps, &GatherError{Errors: errs}
+	}
+	return resps, nil
+}
+
+// instanceQueueGroup is the queue group of a service instance's scatter_gather
+// endpoints. No other instance shares it, so every instance gets each request.
+func instanceQueueGroup(queueGroup string, svc micro.Service) string {
+	if queueGroup == "" {
+		queueGroup = micro.DefaultQueueGroup
+	}
+	return queueGroup + "-" + svc.Info().ID
+}
+
+// maxCoalescedCalls bounds the distinct requests a service coalesces at once.
+// Requests beyond it run on their own.
+const maxCoalescedCalls = 1024
+
+// WithRequestCoalescing shares one handler execution between identical requests
+// to idempotent methods, those with option idempotency_level = NO_SIDE_EFFECTS or
+// IDEMPOTENT. Requests to the same endpoint with the same payload and headers that
+// arrive within window of the first get its response, each on its own reply
+// subject. Headers that differ on every call (X-Request-Id, trace context, the
+// deadline) are left out of the comparison; all others, such as credentials,
+// scopes, impersonation and declared headers, must match, so callers the
+// interceptors would tell apart never share a response. It runs under the endpoint timeout,
+// not the first caller's propagated deadline or cancellation. Coalesced counts are
+// reported in the endpoint stats Data, a CoalescingStats.
+func WithRequestCoalescing(window time.Duration) RegisterOption {
+	return func(c *registerConfig) { c.coalesceWindow = window }
+}
+
+// CoalescingStats is the endpoint stats Data of services registered with
+// WithRequestCoalescing
+type CoalescingStats struct {
+	Executions uint64 `json:"executions"`     // Handler runs whose response coalesced requests can share
+	Coalesced  uint64 `json:"coalesced"`      // Requests answered by another request's handler run
+	Bypassed   uint64 `json:"bypassed"`       // Requests run alone because too many were pending
+	Data       any    `json:"data,omitempty"` // What the wrapped StatsHandler returned
+}
+
+// coalescingCounters accumulates one endpoint's CoalescingStats
+type coalescingCounters struct {
+	executions atomic.Uint64
+	coalesced  atomic.Uint64
+	bypassed   atomic.Uint64
+}
+
+// coalescedKey identifies identical requests to one endpoint
+type coalescedKey struct {
+	endpoint string
+	sum      [sha256.Size]byte // Of the headers, per-call ones aside, and payload
+}
+
+// perCallHeaders are the request headers that differ between calls made alike,
+// which requests can differ in and still be coalesced
+var perCallHeaders = map[string]bool{
+	RequestIDHeader:         true,
+	natsCancelSubjectHeader: true,
+	natsDeadlineHeader:      true,
+	natsDeadlineMsHeader:    true,
+	"traceparent":           true,
+	"tracestate":            true,
+}
+
+// coalescedSum returns the hash of req's payload and of its headers other than
+// perCallHeaders
+func coalescedSum(req micro.Request) (sum [sha256.Size]byte) {
+	headers := req.Headers()
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		if !perCallHeaders[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	h := sha256.New()
+	for _, name := range names {
+		fmt.Fprintf(h, "%q%q\n", name, headers[name]) // Quoted, so no two header sets write the same
+	}
+	h.Write(req.Data())
+	h.Sum(sum[:0])
+	return sum
+}
+
+// coalescedCall is one handler run and the requests waiting for its response
+type coalescedCall struct {
+	expires  time.Time       // Requests arriving later start a new call
+	waiters  []micro.Request // Answered once the handler responds
+	done     bool
+	response capturedResponse
+}
+
+// capturedResponse is how a handler answered a coalesced call
+type capturedResponse struct {
+	data              []byte
+	headers           nats.Header
+	code, description string // Set when the handler answered with Error
+}
+
+// send answers req with the response. A RequestIDHeader the handler echoed is
+// replaced by req's own.
+func (r capturedResponse) send(req micro.Request) error {
+	headers := r.headers
+	if _, ok := headers[RequestIDHeader]; ok {
+		headers = make(nats.Header, len(r.headers))
+		for name, values := range r.headers {
+			headers[name] = values
+		}
+		if id := req.Headers().Get(RequestIDHeader); id != "" {
+			headers.Set(RequestIDHeader, id)
+		} else {
+			headers.Del(RequestIDHeader)
+		}
+	}
+	if r.code != "" {
+		return req.Error(r.code, r.description, r.data, micro.WithHeaders(micro.Headers(headers)))
+	}
+	return req.Respond(r.data, micro.WithHeaders(micro.Headers(headers)))
+}
+
+// requestCoalescer shares handler runs between identical requests (see
+// WithRequestCoalescing)
+type requestCoalescer struct {
+	window   time.Duration
+	mu       sync.Mutex
+	calls    map[coalescedKey]*coalescedCall
+	counters sync.Map // Endpoint name -> *coalescingCounters
+}
+
+// newRequestCoalescer returns the coalescer cfg asks for, or nil if it asks for none
+func newRequestCoalescer(cfg *registerConfig) *requestCoalescer {
+	if cfg.coalesceWindow <= 0 {
+		return nil
+	}
+	return &requestCoalescer{window: cfg.coalesceWindow, calls: make(map[coalescedKey]*coalescedCall)}
+}
+
+// wrap coalesces the requests of endpoint. A nil coalescer returns handler.
+func (c *requestCoalescer) wrap(endpoint string, handler micro.Handler) micro.Handler {
+	if c == nil {
+		return handler
+	}
+	c.countersFor(endpoint)
+	return micro.HandlerFunc(func(req micro.Request) { c.handle(endpoint, req, handler) })
+}
+
+func (c *requestCoalescer) countersFor(endpoint string) *coalescingCounters {
+	counters, _ := c.counters.LoadOrStore(endpoint, &coalescingCounters{})
+	return counters.(*coalescingCounters)
+}
+
+// handle answers req from a pending call for the same request, or runs handler
+// for a new one
+func (c *requestCoalescer) handle(endpoint string, req micro.Request, handler micro.Handler) {
+	if req.Reply() == "" {
+		handler.Handle(req) // Nobody to share a response with
+		return
+	}
+	counters := c.countersFor(endpoint)
+	key := coalescedKey{endpoint: endpoint, sum: coalescedSum(req)}
+
+	now := time.Now()
+	c.mu.Lock()
+	if call, ok := c.calls[key]; ok && now.Before(call.expires) {
+		counters.coalesced.Add(1)
+		if !call.done {
+			call.waiters = append(call.waiters, req)
+			c.mu.Unlock()
+			return
+		}
+		response := call.response
+		c.mu.Unlock()
+		if err := response.send(req); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to send coalesced response for %s: %v\n", endpoint, err)
+		}
+		return
+	}
+	if len(c.calls) >= maxCoalescedCalls {
+		c.mu.Unlock()
+		counters.bypassed.Add(1)
+		handler.Handle(req)
+		return
+	}
+	call := &coalescedCall{expires: now.Add(c.window), waiters: []micro.Request{req}}
+	c.calls[key] = call
+	c.mu.Unlock()
+	counters.executions.Add(1)
+
+	// The handler may answer later, e.g., from a handler pool worker
+	handler.Handle(&capturingRequest{
+		Request: req,
+		headers: sharedRequestHeaders(req.Headers()),
+		finish:  func(response capturedResponse) error { return c.finish(endpoint, key, call, response) },
+	})
+}
+
+// finish answers every request waiting on call, and keeps the response for those
+// arriving until the call expires. It returns the error of answering the first.
+func (c *requestCoalescer) finish(endpoint string, key coalescedKey, call *coalescedCall, response capturedResponse) error {
+	c.mu.Lock()
+	waiters := call.waiters
+	call.waiters, call.done, call.response = nil, true, response
+	if remaining := time.Until(call.expires); remaining > 0 {
+		time.AfterFunc(remaining, func() { c.forget(key, call) })
+	} else if c.calls[key] == call {
+		delete(c.calls, key)
+	}
+	c.mu.Unlock()
+
+	var first error
+	for i, waiter := range waiters {
+		err := response.send(waiter)
+		if i == 0 {
+			first = err
+		} else if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to send coalesced response for %s: %v\n", endpoint, err)
+		}
+	}
+	return first
+}
+
+// forget removes an expired call, unless a newer one took its place
+func (c *requestCoalescer) forget(key coalescedKey, call *coalescedCall) {
+	c.mu.Lock()
+	if c.calls[key] == call {
+		delete(c.calls, key)
+	}
+	c.mu.Unlock()
+}
+
+// statsHandler reports each endpoint's CoalescingStats, wrapping the Data of next
+func (c *requestCoalescer) statsHandler(next micro.StatsHandler) micro.StatsHandler {
+	return func(e *micro.Endpoint) any {
+		stats := CoalescingStats{}
+		if counters, ok := c.counters.Load(e.Name); ok {
+			counters := counters.(*coalescingCounters)
+			stats.Executions = counters.executions.Load()
+			stats.Coalesced = counters.coalesced.Load()
+			stats.Bypassed = counters.bypassed.Load()
+		}
+		if next != nil {
+			stats.Data = next(e)
+		}
+		return stats
+	}
+}
+
+// sharedRequestHeaders returns the headers a coalesced call's handler sees: the
+// first request's, without its deadline and cancel subject, which are not the
+// other callers'
+func sharedRequestHeaders(headers micro.Headers) micro.Headers {
+	shared := make(micro.Headers, len(headers))
+	for name, values := range headers {
+		switch name {
+		case natsCancelSubjectHeader, natsDeadlineHeader, natsDeadlineMsHeader:
+			continue
+		}
+		shared[name] = values
+	}
+	return shared
+}
+
+// capturingRequest is the request a handler runs with when its response is
+// captured rather than sent: by a coalesced call, for every request waiting on
+// it, or by an idempotent request, to store it first.
+type capturingRequest struct {
+	micro.Request
+	headers micro.Headers
+	once    sync.Once
+	finish  func(capturedResponse) error
+}
+
+func (r *capturingRequest) Headers() micro.Headers { return r.headers }
+
+func (r *capturingRequest) Respond(data []byte, opts ...micro.RespondOpt) error {
+	msg := &nats.Msg{}
+	for _, opt := range opts {
+		opt(msg)
+	}
+	return r.capture(capturedResponse{data: data, headers: msg.Header})
+}
+
+func (r *capturingRequest) RespondJSON(v any, opts ...micro.RespondOpt) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return micro.ErrMarshalResponse
+	}
+	return r.Respond(data, opts...)
+}
+
+func (r *capturingRequest) Error(code, description string, data []byte, opts ...micro.RespondOpt) error {
+	msg := &nats.Msg{}
+	for _, opt := range opts {
+		opt(msg)
+	}
+	return r.capture(capturedResponse{data: data, headers: msg.Header, code: code, description: description})
+}
+
+// capture finishes with the handler's first response
+func (r *capturingRequest) capture(response capturedResponse) error {
+	err := errors.New("request already answered")
+	r.once.Do(func() { err = r.finish(response) })
+	return err
+}
+
+// Idempotency keys: a client sends the same IdempotencyKeyHeader with every retry
+// of a request, and a service registered with WithIdempotencyStore runs the
+// handler for the first one only
+const (
+	IdempotencyKeyHeader   = "Idempotency-Key"
+	IdempotentReplayHeader = "Idempotent-Replayed" // "true" on responses replayed from the store
+)
+
+// idempotencyStoreTimeout bounds each read and write of the idempotency store
+const idempotencyStoreTimeout = 5 * time.Second
+
+// maxIdempotencyClaims bounds how often a request tries to claim its key when
+// other requests keep changing the entry
+const maxIdempotencyClaims = 10
+
+// WithIdempotencyKey returns a context whose client calls send key in the
+// IdempotencyKeyHeader. Retries of a call made with it send the same key, so a
+// service registered with WithIdempotencyStore runs the handler once however
+// often the request arrives. Use one key per logical operation, e.g., per order
+// a customer submits:
+//
+//	ctx = WithIdempotencyKey(ctx, checkoutID)
+//	resp, err := client.CreateOrder(ctx, req)
+func WithIdempotencyKey(ctx context.Context, key string) context.Context {
+	return WithOutgoingHeaders(ctx, layerHeaders(OutgoingHeaders(ctx), nats.Header{IdempotencyKeyHeader: []string{key}}))
+}
+
+// IdempotencyKey returns the IdempotencyKeyHeader of the request a handler serves
+func IdempotencyKey(ctx context.Context) string {
+	return IncomingHeaders(ctx).Get(IdempotencyKeyHeader)
+}
+
+// WithIdempotencyStore deduplicates unary requests that carry an
+// IdempotencyKeyHeader, using kv to remember them across instances. The first
+// request with a key claims it with a pending entry, runs the handler and
+// stores its response for ttl; requests with the same key get the stored
+// response, with IdempotentReplayHeader set, without running the handler. A
+// duplicate arriving while the handler runs waits for its response. Error
+// responses are not stored, so a retry after an error runs the handler again.
+//
+// A key reused with a different payload is answered with INVALID_ARGUMENT. A
+// pending entry whose handler never answered, e.g., because the instance
+// crashed, is taken over once the service timeout has passed (ttl without a
+// timeout). If kv cannot be read or written, requests with a key are answered
+// with UNAVAILABLE rather than risk running twice. Requests without a key, and
+// fire-and-forget and streaming methods, are not affected.
+//
+// Keys are stored as <service>.<endpoint>.<sha256 of the key>. Expired entries
+// are ignored, but only removed when overwritten, so give the bucket a TTL of
+// at least ttl.
+func WithIdempotencyStore(kv jetstream.KeyValue, ttl time.Duration) RegisterOption {
+	return func(c *registerConfig) { c.idempotencyKV, c.idempotencyTTL = kv, ttl }
+}
+
+// idempotentEntry is what the idempotency store holds under a key
+type idempotentEntry struct {
+	Pending bool        `json:"pending,omitempty"` // The handler has not answered yet
+	Request string      `json:"request"`           // SHA-256 of the Content-Type and payload
+	Expires time.Time   `json:"expires"`           // When the entry stops counting (zero = never)
+	Data    []byte      `json:"data,omitempty"`
+	Headers nats.Header `json:"headers,omitempty"`
+}
+
+// expired reports whether the entry no longer counts at now
+func (e *idempotentEntry) expired(now time.Time) bool {
+	return !e.Expires.IsZero() && now.After(e.Expires)
+}
+
+// idempotencyGuard runs the handler once per idempotency key (see
+// WithIdempotencyStore)
+type idempotencyGuard struct {
+	kv      jetstream.KeyValue
+	service string
+	ttl     time.Duration // How long stored responses are replayed
+	lease   time.Duration // How long a pending entry holds its key
+}
+
+// newIdempotencyGuard returns the guard cfg asks for, or nil if it asks for none
+func newIdempotencyGuard(cfg *registerConfig) *idempotencyGuard {
+	if cfg.idempotencyKV == nil {
+		return nil
+	}
+	lease := cfg.timeout
+	if lease <= 0 {
+		lease = cfg.idempotencyTTL
+	}
+	return &idempotencyGuard{kv: cfg.idempotencyKV, service: cfg.name, ttl: cfg.idempotencyTTL, lease: lease}
+}
+
+// wrap deduplicates the requests of endpoint. A nil guard returns handler.
+func (g *idempotencyGuard) wrap(endpoint string, handler micro.Handler) micro.Handler {
+	if g == nil {
+		return handler
+	}
+	return micro.HandlerFunc(func(req micro.Request) { g.handle(endpoint, req, handler) })
+}
+
+// idempotencyExpiry returns when an entry written at now for d stops counting
+func idempotencyExpiry(now time.Time, d time.Duration) time.Time {
+	if d <= 0 {
+		return time.Time{}
+	}
+	return now.Add(d)
+}
+
+// handle replays the stored response for req's idempotency key, or claims the
+// key and runs handler
+func (g *idempotencyGuard) handle(endpoint string, req micro.Request, handler micro.Handler) {
+	key := req.Headers().Get(IdempotencyKeyHeader)
+	if key == "" || req.Reply() == "" {
+		handler.Handle(req)
+		return
+	}
+	keySum := sha256.Sum256([]byte(key))
+	storeKey := g.service + "." + endpoint + "." + hex.EncodeToString(keySum[:])
+	h := sha256.New()
+	h.Write([]byte(req.Headers().Get(ContentTypeHeader)))
+	h.Write([]byte{0})
+	h.Write(req.Data())
+	requestSum := hex.EncodeToString(h.Sum(nil))
+
+	fail := func(err error) {
+		code, message, data := natsErrorFields(err)
+		if err := req.Error(code, message, data); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to send idempotency error for %s: %v\n", endpoint, err)
+		}
+	}
+	for claims := 0; claims < maxIdempotencyClaims; claims++ {
+		ctx, cancel := context.WithTimeout(context.Background(), idempotencyStoreTimeout)
+		pending, _ := json.Marshal(idempotentEntry{Pending: true, Request: requestSum, Expires: idempotencyExpiry(time.Now(), g.lease)})
+		revision, err := g.kv.Create(ctx, storeKey, pending)
+		if err == nil {
+			cancel()
+			g.run(endpoint, storeKey, revision, requestSum, req, handler)
+			return
+		}
+		if !errors.Is(err, jetstream.ErrKeyExists) {
+			cancel()
+			fail(Statusf(CodeUnavailable, "idempotency store: %v", err))
+			return
+		}
+
+		kvEntry, err := g.kv.Get(ctx, storeKey)
+		if errors.Is(err, jetstream.ErrKeyNotFound) {
+			cancel()
+			continue // Released since; claim it
+		}
+		if err != nil {
+			cancel()
+			fail(Statusf(CodeUnavailable, "idempotency store: %v", err))
+			return
+		}
+		var entry idempotentEntry
+		if err := json.Unmarshal(kvEntry.Value(), &entry); err != nil || entry.expired(time.Now()) {
+			// Take over an expired or unreadable entry, unless another request just did
+			revision, err := g.kv.Update(ctx, storeKey, pending, kvEntry.Revision())
+			cancel()
+			if err == nil {
+				g.run(endpoint, storeKey, revision, requestSum, req, handler)
+				return
+			}
+			if !errors.Is(err, jetstream.ErrKeyExists) {
+				fail(Statusf(CodeUnavailable, "idempotency store: %v", err))
+				return
+			}
+			continue
+		}
+		cancel()
+		if entry.Request != requestSum {
+			fail(Statusf(CodeInvalidArgument, "idempotency key %q was used for a different request", key))
+			return
+		}
+		if entry.Pending {
+			if err := g.awaitAnswer(storeKey, kvEntry.Revision(), entry.Expires); err != nil {
+				fail(Statusf(CodeUnavailable, "idempotency store: %v", err))
+				return
+			}
+			continue // Replay the answer, or claim the key if it was released
+		}
+		headers := layerHeaders(entry.Headers, nats.Header{IdempotentReplayHeader: []string{"true"}})
+		if err := (capturedResponse{data: entry.Data, headers: headers}).send(req); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to send replayed response for %s: %v\n", endpoint, err)
+		}
+		return
+	}
+	fail(Statusf(CodeUnavailable, "idempotency key %q is contended; retry the request", key))
+}
+
+// awaitAnswer waits until the pending entry at revision changes, or until it
+// expires
+func (g *idempotencyGuard) awaitAnswer(storeKey string, revision uint64, expires time.Time) error {
+	wait := g.lease
+	if !expires.IsZero() {
+		wait = time.Until(expires) + time.Millisecond
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), wait)
+	defer cancel()
+	watcher, err := g.kv.Watch(ctx, storeKey)
+	if err != nil {
+		return err
+	}
+	defer watcher.Stop()
+	for {
+		select {
+		case entry, ok := <-watcher.Updates():
+			if !ok {
+				return nil
+			}
+			if entry != nil && entry.Revision() > revision {
+				return nil
+			}
+		case <-ctx.Done():
+			return nil // Expired; the next claim takes it over
+		}
+	}
+}
+
+// run has handler serve req under the claimed key, storing a successful response
+// before sending it and releasing the key after an error
+func (g *idempotencyGuard) run(endpoint, storeKey string, revision uint64, requestSum string, req micro.Request, handler micro.Handler) {
+	handler.Handle(&capturingRequest{
+		Request: req,
+		headers: req.Headers(),
+		finish: func(response capturedResponse) error {
+			ctx, cancel := context.WithTimeout(context.Background(), idempotencyStoreTimeout)
+			defer cancel()
+			if response.code != "" {
+				if err := g.kv.Delete(ctx, storeKey, jetstream.LastRevision(revision)); err != nil {
+					fmt.Fprintf(os.Stderr, "[nats-micro] WARN: failed to release idempotency key for %s: %v\n", endpoint, err)
+				}
+			} else {
+				stored, _ := json.Marshal(idempotentEntry{
+					Request: requestSum,
+					Expires: idempotencyExpiry(time.Now(), g.ttl),
+					Data:    response.data,
+					Headers: response.headers,
+				})
+				if _, err := g.kv.Update(ctx, storeKey, stored, revision); err != nil {
+					fmt.Fprintf(os.Stderr, "[nats-micro] WARN: failed to store idempotent response for %s: %v\n", endpoint, err)
+				}
+			}
+			return response.send(req)
+		},
 	})
 }
 
-// WithNatsClientJetStream provides a JetStream context for client-side KV/ObjectStore reads.
-// Required only if using Get*FromKV or Get*FromObjectStore convenience methods.
-func WithNatsClientJetStream(js jetstream.JetStream) NatsClientOption {
-	return natsClientOptionFunc(func(c *natsClientConfig) {
-		c.js = js
-	})
+// panicReportWindow is the period WithPanicDiagnostics limits reports over
+const panicReportWindow = time.Hour
+
+// PanicReport describes a panic in a unary or fire-and-forget handler, passed to
+// the sink of WithPanicDiagnostics
+type PanicReport struct {
+	Time       time.Time       `json:"time"`
+	Service    string          `json:"service"`
+	Method     string          `json:"method"`
+	FullMethod string          `json:"full_method"` // Full proto name, e.g., "order.v1.OrderService.CreateOrder"
+	RequestID  string          `json:"request_id,omitempty"`
+	Value      any             `json:"-"`                    // What the handler panicked with
+	Panic      string          `json:"panic"`                // Value formatted with %v
+	Stack      string          `json:"stack"`                // The panicking goroutine's stack
+	Request    json.RawMessage `json:"request,omitempty"`    // Request as JSON, after RedactMessage
+	Goroutines string          `json:"goroutines"`           // Every goroutine's stack, taken as the report is made
+	Suppressed uint64          `json:"suppressed,omitempty"` // Panics over the limit since the previous report
+}
+
+// WithPanicDiagnostics recovers panics in unary and fire-and-forget handlers,
+// answering the call with INTERNAL instead of crashing the process, and passes a
+// PanicReport of each to sink. At most maxDumps reports are made per hour (0 =
+// unlimited); panics over the limit are counted in the next report's Suppressed.
+// sink runs on its own goroutine, so the reply is not delayed. Panics in
+// interceptors and streaming handlers are not recovered.
+func WithPanicDiagnostics(maxDumps int, sink func(PanicReport)) RegisterOption {
+	return func(c *registerConfig) {
+		c.panicDiagnostics = &panicDiagnostics{maxReports: maxDumps, sink: sink}
+	}
+}
+
+// panicDiagnostics recovers handler panics and reports them (see
+// WithPanicDiagnostics)
+type panicDiagnostics struct {
+	maxReports  int
+	sink        func(PanicReport)
+	mu          sync.Mutex
+	windowStart time.Time
+	reported    int    // Reports made since windowStart
+	suppressed  uint64 // Panics over the limit since the last report
+}
+
+// wrap returns handler with its panics recovered as INTERNAL errors and reported.
+// A nil panicDiagnostics returns handler.
+func (d *panicDiagnostics) wrap(service, method, fullMethod string, int64AsNumber bool, handler UnaryHandler) UnaryHandler {
+	if d == nil {
+		return handler
+	}
+	return func(ctx context.Context, req interface{}) (resp interface{}, err error) {
+		defer func() {
+			value := recover()
+			if value == nil {
+				return
+			}
+			fmt.Fprintf(os.Stderr, "[nats-micro] WARN: %s.%s panicked: %v\n", service, method, value)
+			d.report(PanicReport{
+				Time:       time.Now(),
+				Service:    service,
+				Method:     method,
+				FullMethod: fullMethod,
+				RequestID:  IncomingHeaders(ctx).Get(RequestIDHeader),
+				Value:      value,
+				Panic:      fmt.Sprint(value),
+				Stack:      string(debug.Stack()),
+			}, req, int64AsNumber)
+			resp, err = nil, &Status{Code: CodeInternal, Message: "internal error: handler panicked"}
+		}()
+		return handler(ctx, req)
+	}
+}
+
+// report hands report to the sink unless the hourly limit is reached. The
+// request and goroutine stacks are only captured for reports that are made.
+func (d *panicDiagnostics) report(report PanicReport, req interface{}, int64AsNumber bool) {
+	d.mu.Lock()
+	if report.Time.Sub(d.windowStart) >= panicReportWindow {
+		d.windowStart, d.reported = report.Time, 0
+	}
+	if d.sink == nil || (d.maxReports > 0 && d.reported >= d.maxReports) {
+		d.suppressed++
+		d.mu.Unlock()
+		return
+	}
+	d.reported++
+	report.Suppressed, d.suppressed = d.suppressed, 0
+	d.mu.Unlock()
+
+	if msg, ok := req.(proto.Message); ok && !reflect.ValueOf(msg).IsNil() {
+		report.Request, _ = marshalJSON(RedactMessage(msg), int64AsNumber)
+	}
+	go func() {
+		var goroutines bytes.Buffer
+		pprof.Lookup("goroutine").WriteTo(&goroutines, 2)
+		report.Goroutines = goroutines.String()
+		d.sink(report)
+	}()
+}
+
+// defaultFailureRecordLimit is the number of failures WithFailureRecorder records
+// per hour unless WithFailureRecordLimit says otherwise
+const defaultFailureRecordLimit = 60
+
+// failureRecordTimeout bounds each write of a FailureRecord to its store
+const failureRecordTimeout = 5 * time.Second
+
+// FailureRecord is a unary or fire-and-forget call whose handler failed, stored by
+// WithFailureRecorder with what it takes to run the handler again: the decoded
+// request, the request headers, the principal and the time the handler had left
+type FailureRecord struct {
+	ID         string          `json:"id"`
+	Time       time.Time       `json:"time"`
+	Service    string          `json:"service"`
+	Method     string          `json:"method"`
+	FullMethod string          `json:"full_method"` // Full proto name, e.g., "order.v1.OrderService.CreateOrder"
+	RequestID  string          `json:"request_id,omitempty"`
+	Headers    nats.Header     `json:"headers,omitempty"`   // Request headers, without credentials
+	Principal  *Principal      `json:"principal,omitempty"` // Set with WithPrincipal by an interceptor
+	Timeout    time.Duration   `json:"timeout,omitempty"`   // Time left until the handler's deadline when it started (0 = none)
+	Request    json.RawMessage `json:"request"`             // Request as JSON, after RedactMessage, as the handler got it
+	Duration   time.Duration   `json:"duration"`
+	Code       string          `json:"code"` // CodeOf the handler's error
+	Error      string          `json:"error"`
+	Suppressed uint64          `json:"suppressed,omitempty"` // Failures over the limit since the previous record
+}
+
+// DecodeRequest decodes the recorded request into msg. Fields msg no longer has
+// are ignored, so records replay against newer builds.
+func (r *FailureRecord) DecodeRequest(msg proto.Message) error {
+	return protojson.UnmarshalOptions{DiscardUnknown: true}.Unmarshal(r.Request, msg)
+}
+
+// RecorderStore keeps the FailureRecords of WithFailureRecorder. See
+// NewObjectRecorderStore.
+type RecorderStore interface {
+	Save(ctx context.Context, rec *FailureRecord) error
+	Load(ctx context.Context, id string) (*FailureRecord, error)
+}
+
+// objectRecorderStore keeps FailureRecords as JSON objects named by their ID
+type objectRecorderStore struct {
+	obs jetstream.ObjectStore
+}
+
+// NewObjectRecorderStore returns a RecorderStore keeping each FailureRecord as a
+// JSON object named by its ID in obs. List the bucket to find records, e.g.,
+// nats object ls <bucket>, and give it a TTL or size limit to bound it.
+func NewObjectRecorderStore(obs jetstream.ObjectStore) RecorderStore {
+	return objectRecorderStore{obs: obs}
+}
+
+func (s objectRecorderStore) Save(ctx context.Context, rec *FailureRecord) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	_, err = s.obs.PutBytes(ctx, rec.ID, data)
+	return err
+}
+
+func (s objectRecorderStore) Load(ctx context.Context, id string) (*FailureRecord, error) {
+	data, err := s.obs.GetBytes(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("failure record %q: %w", id, err)
+	}
+	var rec FailureRecord
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return nil, fmt.Errorf("failure record %q: %w", id, err)
+	}
+	return &rec, nil
+}
+
+// WithFailureRecorder stores a FailureRecord in store for unary and
+// fire-and-forget calls whose handler returns an error, for replay with the
+// Replay<Service>Failure helpers of mocks=true. Calls rejected before the handler
+// runs, e.g., by an authentication interceptor or as undecodable, are not
+// recorded. At most 60 records are made per hour (see WithFailureRecordLimit);
+// failures over the limit are counted in the next record's Suppressed. Requests go
+// through RedactMessage and credential headers are dropped. Records are written on
+// their own goroutine with the ID minted by WithIDGenerator, so the reply is not
+// delayed; write errors are printed.
+func WithFailureRecorder(store RecorderStore) RegisterOption {
+	return func(c *registerConfig) { c.failureStore = store }
+}
+
+// WithFailureRecordLimit sets how many failures WithFailureRecorder records per
+// hour (0 = unlimited)
+func WithFailureRecordLimit(perHour int) RegisterOption {
+	return func(c *registerConfig) { c.failureLimit = &perHour }
+}
+
+// failureRecorder records failed calls (see WithFailureRecorder)
+type failureRecorder struct {
+	store       RecorderStore
+	limit       int
+	idGenerator func() string
+	mu          sync.Mutex
+	windowStart time.Time
+	recorded    int    // Records made since windowStart
+	suppressed  uint64 // Failures over the limit since the last record
+}
+
+// newFailureRecorder returns the recorder cfg asks for, or nil if it asks for none
+func newFailureRecorder(cfg *registerConfig) *failureRecorder {
+	if cfg.failureStore == nil {
+		return nil
+	}
+	limit := defaultFailureRecordLimit
+	if cfg.failureLimit != nil {
+		limit = *cfg.failureLimit
+	}
+	return &failureRecorder{store: cfg.failureStore, limit: limit, idGenerator: cfg.idGenerator}
+}
+
+// full reports whether the hourly limit is reached at now
+func (r *failureRecorder) full(now time.Time) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.limit > 0 && r.recorded >= r.limit && now.Sub(r.windowStart) < time.Hour
+}
+
+// wrap returns handler with its failures recorded. The request is copied before
+// the handler runs, unless the limit is reached, so the record holds what the
+// handler got. A nil failureRecorder returns handler.
+func (r *failureRecorder) wrap(service, method, fullMethod string, int64AsNumber bool, handler UnaryHandler) UnaryHandler {
+	if r == nil {
+		return handler
+	}
+	return func(ctx context.Context, req interface{}) (interface{}, error) {
+		start := time.Now()
+		var snapshot proto.Message
+		if msg, ok := req.(proto.Message); ok && !reflect.ValueOf(msg).IsNil() && !r.full(start) {
+			snapshot = proto.Clone(msg)
+		}
+		var timeout time.Duration
+		if deadline, ok := ctx.Deadline(); ok {
+			timeout = deadline.Sub(start)
+		}
+		resp, err := handler(ctx, req)
+		if err != nil {
+			rec := &FailureRecord{
+				Time:       start,
+				Service:    service,
+				Method:     method,
+				FullMethod: fullMethod,
+				RequestID:  IncomingHeaders(ctx).Get(RequestIDHeader),
+				Timeout:    timeout,
+				Duration:   time.Since(start),
+				Code:       CodeOf(err).String(),
+				Error:      err.Error(),
+			}
+			if p, ok := PrincipalFromContext(ctx); ok {
+				rec.Principal = &p
+			}
+			if headers := IncomingHeaders(ctx); len(headers) > 0 {
+				rec.Headers = nats.Header{}
+				for k, v := range headers {
+					rec.Headers[k] = v
+				}
+				for _, k := range journalRedactedHeaders {
+					rec.Headers.Del(k)
+				}
+			}
+			r.record(rec, snapshot, int64AsNumber)
+		}
+		return resp, err
+	}
+}
+
+// record stores rec unless the hourly limit is reached or the request could not
+// be captured
+func (r *failureRecorder) record(rec *FailureRecord, req proto.Message, int64AsNumber bool) {
+	r.mu.Lock()
+	if rec.Time.Sub(r.windowStart) >= time.Hour {
+		r.windowStart, r.recorded = rec.Time, 0
+	}
+	if req == nil || (r.limit > 0 && r.recorded >= r.limit) {
+		r.suppressed++
+		r.mu.Unlock()
+		return
+	}
+	r.recorded++
+	rec.Suppressed, r.suppressed = r.suppressed, 0
+	r.mu.Unlock()
+
+	rec.ID = mintID(r.idGenerator)
+	rec.Request, _ = marshalJSON(RedactMessage(req), int64AsNumber)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), failureRecordTimeout)
+		defer cancel()
+		if err := r.store.Save(ctx, rec); err != nil {
+			fmt.Fprintf(os.Stderr, "[nats-micro] WARN: failed to record failure of %s.%s: %v\n", rec.Service, rec.Method, err)
+		}
+	}()
 }
 
-// chainUnaryClientInterceptors creates a single interceptor that chains multiple interceptors
-func chainUnaryClientInterceptors(interceptors []UnaryClientInterceptor) UnaryClientInterceptor {
-	n := len(interceptors)
+// FailureReplay is the outcome of running a FailureRecord's request again with a
+// Replay<Service>Failure helper
+type FailureReplay struct {
+	Record   *FailureRecord
+	Response proto.Message // What the handler answered; nil on error and for methods without a response
+	Code     string        // CodeOf the replayed handler's error; OK on success
+	Error    string
+	Diverged bool // Code or Error differ from the record's
+}
 
-	if n == 0 {
+// Divergence describes how the replay differs from the record, or is empty if it
+// does not
+func (r *FailureReplay) Divergence() string {
+	if !r.Diverged {
+		return ""
+	}
+	return fmt.Sprintf("%s.%s recorded %s, replayed %s", r.Record.Service, r.Record.Method,
+		describeOutcome(r.Record.Code, r.Record.Error), describeOutcome(r.Code, r.Error))
+}
+
+// describeOutcome names a call's outcome by its error, or by its code if the
+// error does not start with it
+func describeOutcome(code, err string) string {
+	switch {
+	case err == "":
+		return code
+	case strings.HasPrefix(err, "["+code+"]"):
+		return err
+	default:
+		return fmt.Sprintf("%s (%s)", code, err)
+	}
+}
+
+// failureReplayMethod runs one method's handler for replayFailure
+type failureReplayMethod struct {
+	request func() proto.Message // Returns a new request, or nil for methods that take none
+	call    func(ctx context.Context, req proto.Message) (proto.Message, error)
+}
+
+// replayFailure loads the record id from store and runs its request through the
+// method of service it names, in a context rebuilt from the record: its headers,
+// principal and deadline. A panic is reported as the INTERNAL error
+// WithPanicDiagnostics answers with. Errors are returned only when the record
+// cannot be loaded, decoded or matched to a method.
+func replayFailure(ctx context.Context, store RecorderStore, id, service string, methods map[string]failureReplayMethod) (*FailureReplay, error) {
+	rec, err := store.Load(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if rec.Service != service {
+		return nil, fmt.Errorf("failure record %q is of %s, not %s", id, rec.Service, service)
+	}
+	method, ok := methods[rec.Method]
+	if !ok {
+		return nil, fmt.Errorf("failure record %q: %s has no unary method %s", id, service, rec.Method)
+	}
+	var req proto.Message
+	if method.request != nil {
+		req = method.request()
+		if err := rec.DecodeRequest(req); err != nil {
+			return nil, fmt.Errorf("failure record %q: %w", id, err)
+		}
+	}
+
+	if len(rec.Headers) > 0 {
+		ctx = WithIncomingHeaders(ctx, micro.Headers(rec.Headers))
+	}
+	ctx = withPendingResponseHeaders(ctx, &nats.Header{})
+	if rec.Principal != nil {
+		ctx = WithPrincipal(ctx, *rec.Principal)
+	}
+	var cancel context.CancelFunc
+	if rec.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, rec.Timeout)
+	} else {
+		ctx, cancel = context.WithCancel(ctx)
+	}
+	defer cancel()
+
+	resp, err := func() (resp proto.Message, err error) {
+		defer func() {
+			if recover() != nil {
+				resp, err = nil, &Status{Code: CodeInternal, Message: "internal error: handler panicked"}
+			}
+		}()
+		return method.call(ctx, req)
+	}()
+	replay := &FailureReplay{Record: rec, Code: CodeOf(err).String()}
+	if err != nil {
+		replay.Error = err.Error()
+	} else if resp != nil && !reflect.ValueOf(resp).IsNil() {
+		replay.Response = resp
+	}
+	replay.Diverged = replay.Code != rec.Code || replay.Error != rec.Error
+	return replay, nil
+}
+
+// KVChange is a change to a KV entry, sent by a Watch<Method>KV channel
+type KVChange[T any] struct {
+	Key       string
+	Revision  uint64
+	Created   time.Time // When the revision was written
+	Operation jetstream.KeyValueOp
+	Value     T     // The decoded entry; nil for deletes and on Err
+	Deleted   bool  // The key was deleted or purged (WithKVWatchDeletes)
+	Err       error // The entry could not be decoded, e.g., ErrTypeMismatch
+}
+
+// kvWatchConfig is what KVWatchOptions set
+type kvWatchConfig struct {
+	deletes     bool
+	updatesOnly bool
+}
+
+// KVWatchOption configures a Watch<Method>KV call
+type KVWatchOption func(*kvWatchConfig)
+
+// WithKVWatchDeletes sends deletes and purges as KVChanges with Deleted set,
+// instead of skipping them
+func WithKVWatchDeletes() KVWatchOption {
+	return func(c *kvWatchConfig) { c.deletes = true }
+}
+
+// WithKVWatchUpdatesOnly skips the current entries, sending only later changes
+func WithKVWatchUpdatesOnly() KVWatchOption {
+	return func(c *kvWatchConfig) { c.updatesOnly = true }
+}
+
+// watchKV watches the keys of bucket matching keyPattern, sending each change on
+// the returned channel with its entry decoded by decode. Entries not tagged with
+// a non-empty tag fail with ErrTypeMismatch. The channel closes when ctx ends or
+// the watch fails.
+func watchKV[T any](ctx context.Context, js jetstream.JetStream, bucket, keyPattern, tag string, opts []KVWatchOption, decode func([]byte) (T, error)) (<-chan KVChange[T], error) {
+	if js == nil {
+		return nil, errors.New("JetStream not configured; use WithNatsClientJetStream to enable KV watches")
+	}
+	cfg := kvWatchConfig{}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	kv, err := js.KeyValue(ctx, bucket)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open KV bucket %q: %w", bucket, err)
+	}
+	var stream jetstream.Stream
+	if tag != "" {
+		if stream, err = js.Stream(ctx, "KV_"+bucket); err != nil {
+			return nil, fmt.Errorf("failed to open KV bucket %q: %w", bucket, err)
+		}
+	}
+	var watchOpts []jetstream.WatchOpt
+	if !cfg.deletes {
+		watchOpts = append(watchOpts, jetstream.IgnoreDeletes())
+	}
+	if cfg.updatesOnly {
+		watchOpts = append(watchOpts, jetstream.UpdatesOnly())
+	}
+	watcher, err := kv.Watch(ctx, keyPattern, watchOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("KV watch failed for %q: %w", keyPattern, err)
+	}
+
+	changes := make(chan KVChange[T])
+	go func() {
+		defer close(changes)
+		defer watcher.Stop()
+		for {
+			var entry jetstream.KeyValueEntry
+			select {
+			case <-ctx.Done():
+				return
+			case update, ok := <-watcher.Updates():
+				if !ok {
+					return
+				}
+				if update == nil {
+					continue // The current entries have all been sent
+				}
+				entry = update
+			}
+			change := KVChange[T]{Key: entry.Key(), Revision: entry.Revision(), Created: entry.Created(), Operation: entry.Operation()}
+			if entry.Operation() != jetstream.KeyValuePut {
+				change.Deleted = true
+			} else if stream != nil {
+				change.Err = checkKVTypeTag(ctx, stream, entry.Key(), entry.Revision(), tag)
+			}
+			if !change.Deleted && change.Err == nil {
+				if change.Value, change.Err = decode(entry.Value()); change.Err != nil {
+					change.Err = fmt.Errorf("failed to decode KV value: %w", change.Err)
+				}
+			}
+			select {
+			case changes <- change:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return changes, nil
+}
+
+// subjectParamsKey is the context key of the tokens SubjectParams returns
+type subjectParamsKey struct{}
+
+// SubjectParams returns the tokens of the request subject of a method with a
+// (natsmicro.endpoint).subject_template, by field name: {"region": "us-east"} for
+// "orders.{region}.create" called at "orders.us-east.create". The service checks
+// them against the request fields before the handler runs. It returns nil for
+// other methods.
+func SubjectParams(ctx context.Context) map[string]string {
+	params, _ := ctx.Value(subjectParamsKey{}).(map[string]string)
+	return params
+}
+
+// withSubjectParams adds the subject tokens of a subject_template request to ctx
+func withSubjectParams(ctx context.Context, params map[string]string) context.Context {
+	return context.WithValue(ctx, subjectParamsKey{}, params)
+}
+
+// subjectTemplateToken renders a request field as a subject token: enums by
+// value name, other fields as fmt prints them
+func subjectTemplateToken(v any) string {
+	if s, ok := v.(fmt.Stringer); ok {
+		return s.String()
+	}
+	return fmt.Sprint(v)
+}
+
+// fillSubjectTemplate builds a call's subject from a subject template and the
+// tokens of its placeholders by field name. A token that is empty or would not
+// stay a single literal subject token fails the call, as NATS would route it
+// elsewhere or not at all.
+func fillSubjectTemplate(template string, fields map[string]string) (string, error) {
+	tokens := strings.Split(template, ".")
+	for i, token := range tokens {
+		name, ok := subjectTemplatePlaceholder(token)
+		if !ok {
+			continue
+		}
+		value := fields[name]
+		if value == "" {
+			return "", fmt.Errorf("field %s is empty; subject %s needs it", name, template)
+		}
+		if strings.ContainsAny(value, ".*> \t\r\n") {
+			return "", fmt.Errorf("field %s is %q, which is not a single subject token; subject %s needs one", name, value, template)
+		}
+		tokens[i] = value
+	}
+	return strings.Join(tokens, "."), nil
+}
+
+// checkSubjectTemplate returns the placeholder tokens of a request subject by field
+// name, after checking each against the request's field, so a caller allowed to
+// publish only on some subjects cannot act on other values through the payload
+func checkSubjectTemplate(template, subject string, fields map[string]string) (map[string]string, error) {
+	tokens, subjectTokens := strings.Split(template, "."), strings.Split(subject, ".")
+	if len(tokens) != len(subjectTokens) {
+		return nil, fmt.Errorf("subject %s does not match %s", subject, template)
+	}
+	params := make(map[string]string)
+	for i, token := range tokens {
+		name, ok := subjectTemplatePlaceholder(token)
+		if !ok {
+			continue
+		}
+		if subjectTokens[i] != fields[name] {
+			return nil, fmt.Errorf("subject token %q does not match field %s of the request (%q)", subjectTokens[i], name, fields[name])
+		}
+		params[name] = subjectTokens[i]
+	}
+	return params, nil
+}
+
+// subjectTemplatePlaceholder returns the field name of a {field} template token
+func subjectTemplatePlaceholder(token string) (string, bool) {
+	if len(token) < 3 || token[0] != '{' || token[len(token)-1] != '}' {
+		return "", false
+	}
+	return token[1 : len(token)-1], true
+}
+
+// ContentEncodingHeader names the codec a unary request or response payload is
+// compressed with (see WithCompression and WithClientCompression). Receivers
+// decompress every payload that carries it, whatever their own settings, so
+// compression can be turned on one client or service at a time.
+const ContentEncodingHeader = "Content-Encoding"
+
+// acceptEncodingHeader lists the codecs a client decompresses; services only
+// compress responses to clients that list their codec
+const acceptEncodingHeader = "Accept-Encoding"
+
+// Compressor is a compression codec, named in ContentEncodingHeader and
+// StreamEncodingHeader by Name. gzip is built in; others, such as zstd, are added
+// with RegisterCompressor.
+type Compressor interface {
+	Name() string
+	// Compress returns a writer that compresses into w; Close flushes it
+	Compress(w io.Writer) (io.WriteCloser, error)
+	// Decompress returns a reader of what r decompresses to. Readers that are also
+	// io.Closers are closed once read.
+	Decompress(r io.Reader) (io.Reader, error)
+}
+
+// compressors holds the codecs this package compresses and decompresses with, by name
+var compressors = struct {
+	sync.RWMutex
+	byName map[string]Compressor
+}{byName: map[string]Compressor{streamEncodingGzip: gzipCompressor{}}}
+
+// RegisterCompressor adds c to the codecs WithCompression and WithClientCompression
+// can name and that payloads and stream messages are decompressed with, replacing
+// any registered under the same name. The registry belongs to this package, so
+// register c in each generated package whose clients or services use it, before
+// they do, e.g. in an init function:
+//
+//	type zstdCompressor struct{}
+//
+//	func (zstdCompressor) Name() string { return "zstd" }
+//	func (zstdCompressor) Compress(w io.Writer) (io.WriteCloser, error) { return zstd.NewWriter(w) }
+//	func (zstdCompressor) Decompress(r io.Reader) (io.Reader, error) {
+//		d, err := zstd.NewReader(r)
+//		if err != nil {
+//			return nil, err
+//		}
+//		return d.IOReadCloser(), nil
+//	}
+//
+//	func init() { orderv1.RegisterCompressor(zstdCompressor{}) }
+func RegisterCompressor(c Compressor) {
+	compressors.Lock()
+	defer compressors.Unlock()
+	compressors.byName[c.Name()] = c
+}
+
+// compressorFor returns the codec registered as name, or nil
+func compressorFor(name string) Compressor {
+	compressors.RLock()
+	defer compressors.RUnlock()
+	return compressors.byName[name]
+}
+
+// compressorNames lists the registered codecs for acceptEncodingHeader, sorted
+func compressorNames() string {
+	compressors.RLock()
+	names := make([]string, 0, len(compressors.byName))
+	for name := range compressors.byName {
+		names = append(names, name)
+	}
+	compressors.RUnlock()
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// acceptsEncoding reports whether the comma-separated list of codecs has encoding
+func acceptsEncoding(list, encoding string) bool {
+	for _, accepted := range strings.Split(list, ",") {
+		if strings.TrimSpace(accepted) == encoding {
+			return true
+		}
+	}
+	return false
+}
+
+// gzipCompressor is the built-in gzip codec. Its writers are pooled, since each
+// holds several hundred KiB of compression state.
+type gzipCompressor struct{}
+
+var gzipWriters = sync.Pool{New: func() any { return gzip.NewWriter(nil) }}
+
+func (gzipCompressor) Name() string { return streamEncodingGzip }
+
+func (gzipCompressor) Compress(w io.Writer) (io.WriteCloser, error) {
+	zw := gzipWriters.Get().(*gzip.Writer)
+	zw.Reset(w)
+	return &pooledGzipWriter{zw: zw}, nil
+}
+
+func (gzipCompressor) Decompress(r io.Reader) (io.Reader, error) {
+	return gzip.NewReader(r)
+}
+
+// pooledGzipWriter returns its gzip.Writer to gzipWriters when closed
+type pooledGzipWriter struct {
+	zw *gzip.Writer
+}
+
+func (w *pooledGzipWriter) Write(p []byte) (int, error) {
+	if w.zw == nil {
+		return 0, errors.New("gzip: write after close")
+	}
+	return w.zw.Write(p)
+}
+
+func (w *pooledGzipWriter) Close() error {
+	if w.zw == nil {
 		return nil
 	}
+	err := w.zw.Close()
+	gzipWriters.Put(w.zw)
+	w.zw = nil
+	return err
+}
 
-	if n == 1 {
-		return interceptors[0]
+// compressPayload returns data compressed with codec, naming codec in header's
+// ContentEncodingHeader, or data itself if it is under minSize bytes, codec is
+// nil or compressing doesn't make it smaller
+func compressPayload(codec Compressor, minSize int, data []byte, header nats.Header) []byte {
+	if codec == nil || len(data) < minSize {
+		return data
+	}
+	compressed, ok := compressWith(codec, data)
+	if !ok {
+		return data
+	}
+	header.Set(ContentEncodingHeader, codec.Name())
+	return compressed
+}
+
+// compressWith returns data compressed with codec, and whether that succeeded and
+// made it smaller
+func compressWith(codec Compressor, data []byte) ([]byte, bool) {
+	var buf bytes.Buffer
+	w, err := codec.Compress(&buf)
+	if err != nil {
+		return nil, false
+	}
+	_, err = w.Write(data)
+	if closeErr := w.Close(); err != nil || closeErr != nil || buf.Len() >= len(data) {
+		return nil, false
+	}
+	return buf.Bytes(), true
+}
+
+// decompressPayload returns what data decompresses to with the codec registered as
+// encoding. It stops reading one byte past limit, so the size check that follows
+// fails rather than inflating the whole payload (0 = unlimited).
+func decompressPayload(encoding string, data []byte, limit int) ([]byte, error) {
+	codec := compressorFor(encoding)
+	if codec == nil {
+		return nil, fmt.Errorf("unsupported encoding %q", encoding)
 	}
+	r, err := codec.Decompress(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if closer, ok := r.(io.Closer); ok {
+		defer closer.Close()
+	}
+	if limit > 0 {
+		r = io.LimitReader(r, int64(limit)+1)
+	}
+	return io.ReadAll(r)
+}
+
+// readPayload returns the payload of a unary request or response whose
+// ContentEncodingHeader is encoding ("" = uncompressed), checking its size against
+// limit before and after decompressing it. Payloads that don't decompress are
+// DATA_LOSS.
+func readPayload(kind, encoding string, data []byte, limit int) ([]byte, error) {
+	if err := checkPayloadSize(kind, len(data), limit); err != nil {
+		return nil, err
+	}
+	if encoding == "" {
+		return data, nil
+	}
+	data, err := decompressPayload(encoding, data, limit)
+	if err != nil {
+		return nil, Statusf(CodeDataLoss, "failed to decompress %s: %v", kind, err)
+	}
+	if err := checkPayloadSize(kind, len(data), limit); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// payloadLoggingConfig is what PayloadLoggingOptions set
+type payloadLoggingConfig struct {
+	level    slog.Level // Level calls are logged at
+	maxBytes int        // Longest payload JSON logged whole (0 = no limit)
+}
+
+// PayloadLoggingOption configures NewPayloadLoggingInterceptor and
+// NewPayloadLoggingClientInterceptor
+type PayloadLoggingOption func(*payloadLoggingConfig)
+
+// WithPayloadLogLevel logs calls at level instead of slog.LevelDebug
+func WithPayloadLogLevel(level slog.Level) PayloadLoggingOption {
+	return func(c *payloadLoggingConfig) { c.level = level }
+}
+
+// WithPayloadLogMaxBytes cuts request and response JSON longer than n bytes short,
+// e.g. for methods returning thousands of items
+func WithPayloadLogMaxBytes(n int) PayloadLoggingOption {
+	return func(c *payloadLoggingConfig) { c.maxBytes = max(n, 0) }
+}
+
+// NewPayloadLoggingInterceptor returns a UnaryServerInterceptor that logs each
+// call's request and response as JSON to logger, with its service, method,
+// subject, duration and code. Messages go through RedactMessage first, so fields
+// marked (natsmicro.field).sensitive or [debug_redact = true] are left out. Calls
+// are logged at slog.LevelDebug unless WithPayloadLogLevel says otherwise, and are
+// only copied and encoded while logger is enabled at that level, so the
+// interceptor can stay installed and be switched on with the logger's level. A nil
+// logger means slog.Default(). Example:
+//
+//	logger := slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug}))
+//	RegisterOrderServiceHandlers(nc, impl,
+//		WithServerInterceptor(NewPayloadLoggingInterceptor(logger, WithPayloadLogMaxBytes(4096))))
+func NewPayloadLoggingInterceptor(logger *slog.Logger, opts ...PayloadLoggingOption) UnaryServerInterceptor {
+	logger, cfg := newPayloadLogging(logger, opts)
+	return func(ctx context.Context, req interface{}, info *UnaryServerInfo, handler UnaryHandler) (interface{}, error) {
+		if !logger.Enabled(ctx, cfg.level) {
+			return handler(ctx, req)
+		}
+		request := cfg.payload(req) // Before the handler can change it
+		start := time.Now()
+		resp, err := handler(ctx, req)
+		code := CodeOK.String()
+		if err != nil {
+			code, _, _ = natsErrorFields(err)
+		}
+		cfg.log(ctx, logger, "nats-micro request handled", request, resp, err, start, code,
+			slog.String("service", info.Service), slog.String("method", info.Method), slog.String("subject", info.Subject))
+		return resp, err
+	}
+}
 
+// NewPayloadLoggingClientInterceptor returns a UnaryClientInterceptor that logs
+// each call as NewPayloadLoggingInterceptor does, once per retry attempt.
+// Example:
+//
+//	client := NewOrderServiceNatsClient(nc,
+//		WithClientInterceptor(NewPayloadLoggingClientInterceptor(logger)))
+func NewPayloadLoggingClientInterceptor(logger *slog.Logger, opts ...PayloadLoggingOption) UnaryClientInterceptor {
+	logger, cfg := newPayloadLogging(logger, opts)
 	return func(ctx context.Context, method string, req, reply interface{}, invoker UnaryInvoker) error {
-		// Build chain from last to first
-		chainedInvoker := invoker
-		for i := n - 1; i >= 0; i-- {
-			interceptor := interceptors[i]
-			nextInvoker := chainedInvoker
-			chainedInvoker = func(currentCtx context.Context, currentMethod string, currentReq, currentReply interface{}) error {
-				return interceptor(currentCtx, currentMethod, currentReq, currentReply, nextInvoker)
+		if !logger.Enabled(ctx, cfg.level) {
+			return invoker(ctx, method, req, reply)
+		}
+		request := cfg.payload(req)
+		start := time.Now()
+		err := invoker(ctx, method, req, reply)
+		info := CallInfoFromContext(ctx)
+		cfg.log(ctx, logger, "nats-micro request sent", request, reply, err, start, CodeOf(err).String(),
+			slog.String("service", info.Service), slog.String("method", method), slog.String("subject", info.Subject))
+		return err
+	}
+}
+
+// newPayloadLogging applies opts, and defaults a nil logger to slog.Default()
+func newPayloadLogging(logger *slog.Logger, opts []PayloadLoggingOption) (*slog.Logger, *payloadLoggingConfig) {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	cfg := &payloadLoggingConfig{level: slog.LevelDebug}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	return logger, cfg
+}
+
+// payload renders a request or response for the log: its JSON after RedactMessage,
+// cut short at maxBytes. Anything but a non-nil message renders as "".
+func (c *payloadLoggingConfig) payload(v interface{}) string {
+	msg, ok := v.(proto.Message)
+	if !ok || reflect.ValueOf(msg).IsNil() {
+		return ""
+	}
+	data, err := marshalJSON(RedactMessage(msg), false)
+	if err != nil {
+		return fmt.Sprintf("<unencodable: %v>", err)
+	}
+	if c.maxBytes > 0 && len(data) > c.maxBytes {
+		return strings.ToValidUTF8(string(data[:c.maxBytes]), "") + fmt.Sprintf("...(%d bytes)", len(data))
+	}
+	return string(data)
+}
+
+// log writes one call's record: the response on success, the error otherwise
+func (c *payloadLoggingConfig) log(ctx context.Context, logger *slog.Logger, message, request string, resp interface{}, err error, start time.Time, code string, attrs ...slog.Attr) {
+	attrs = append(attrs,
+		slog.String("request", request),
+		slog.Duration("duration", time.Since(start)),
+		slog.String("code", code))
+	if err != nil {
+		attrs = append(attrs, slog.String("error", err.Error()))
+	} else if response := c.payload(resp); response != "" {
+		attrs = append(attrs, slog.String("response", response))
+	}
+	logger.LogAttrs(ctx, c.level, message, attrs...)
+}
+
+// publishConfig is what PublishOptions set
+type publishConfig struct {
+	js               jetstream.JetStream      // Publish through JetStream and wait for the ack (nil = core NATS)
+	interceptors     []UnaryClientInterceptor // Outermost first
+	compression      int                      // Smallest payload compressed (0 = off)
+	compressionCodec string
+}
+
+// PublishOption configures a Publish<Event> call
+type PublishOption func(*publishConfig)
+
+// WithPublishJetStream publishes through js and waits for the stream to store the
+// event, so Publish<Event> fails when no stream captures its subject
+func WithPublishJetStream(js jetstream.JetStream) PublishOption {
+	return func(c *publishConfig) { c.js = js }
+}
+
+// WithPublishInterceptor adds a client interceptor, such as
+// NewPayloadLoggingClientInterceptor, around the publish. It is called with the
+// event's message name as the method and a nil reply. Interceptors run in the
+// order they are added, the first outermost.
+func WithPublishInterceptor(interceptor UnaryClientInterceptor) PublishOption {
+	return func(c *publishConfig) { c.interceptors = append(c.interceptors, interceptor) }
+}
+
+// WithPublishCompression compresses events of at least minSize bytes with codec,
+// "gzip" or one added with RegisterCompressor, naming it in ContentEncodingHeader
+func WithPublishCompression(codec string, minSize int) PublishOption {
+	return func(c *publishConfig) { c.compression, c.compressionCodec = max(minSize, 1), codec }
+}
+
+// subscribeConfig is what SubscribeOptions set
+type subscribeConfig struct {
+	queueGroup    string                   // Core NATS queue group ("" = every subscriber gets every event)
+	js            jetstream.JetStream      // Consume through a durable consumer (nil = core NATS)
+	stream        string                   // Stream the durable consumer reads
+	durable       string                   // Durable consumer name
+	interceptors  []UnaryServerInterceptor // Outermost first
+	errorHandler  func(event string, err error)
+	deadLetter    string // Subject failed events are moved to ("" = off)
+	maxDeliveries int    // Failed deliveries before an event is moved to deadLetter
+}
+
+// SubscribeOption configures a Subscribe<Event> call
+type SubscribeOption func(*subscribeConfig)
+
+// WithSubscribeQueueGroup joins the subscription to a queue group, so subscribers
+// in the group split the events between them instead of each handling every one
+func WithSubscribeQueueGroup(name string) SubscribeOption {
+	return func(c *subscribeConfig) { c.queueGroup = name }
+}
+
+// WithSubscribeDurable consumes the events through the durable consumer durable of
+// stream instead of a core NATS subscription, creating or updating it to filter on
+// the event's subject. Events a handler returns no error for are acknowledged; the
+// others are redelivered. Subscribers sharing the durable name split the events,
+// and events published while none runs wait in the stream.
+// Example:
+//
+//	sub, err := SubscribeOrderCreated(nc, handle, WithSubscribeDurable(js, "EVENTS", "billing"))
+func WithSubscribeDurable(js jetstream.JetStream, stream, durable string) SubscribeOption {
+	return func(c *subscribeConfig) { c.js, c.stream, c.durable = js, stream, durable }
+}
+
+// WithSubscribeInterceptor adds a server interceptor, such as
+// NewPayloadLoggingInterceptor, around the handler. It is called with the event as
+// the request, a UnaryServerInfo naming the event as its Method, and a nil
+// response. Interceptors run in the order they are added, the first outermost.
+func WithSubscribeInterceptor(interceptor UnaryServerInterceptor) SubscribeOption {
+	return func(c *subscribeConfig) { c.interceptors = append(c.interceptors, interceptor) }
+}
+
+// WithSubscribeErrorHandler passes events that fail to decode, and handler errors,
+// to handler instead of printing them
+func WithSubscribeErrorHandler(handler func(event string, err error)) SubscribeOption {
+	return func(c *subscribeConfig) { c.errorHandler = handler }
+}
+
+// WithDeadLetter moves an event whose handler failed maxDeliveries times to
+// subject instead of retrying it again. The dead letter carries the original
+// payload and headers, plus DeadLetterErrorHeader, DeadLetterCodeHeader,
+// DeadLetterDeliveriesHeader and DeadLetterSubjectHeader; read it back with
+// Subscribe<Event>DLQ. Once the dead letter is published the event is
+// acknowledged.
+//
+// With WithSubscribeDurable the count is JetStream's delivery count, and the dead
+// letter is published through JetStream, so a stream must capture subject. On core
+// NATS, which never redelivers, the handler is called up to maxDeliveries times in
+// a row instead. Example:
+//
+//	sub, err := SubscribeOrderCreated(nc, handle, WithDeadLetter("dlq.order.created", 5))
+func WithDeadLetter(subject string, maxDeliveries int) SubscribeOption {
+	return func(c *subscribeConfig) { c.deadLetter, c.maxDeliveries = subject, max(maxDeliveries, 1) }
+}
+
+// Headers WithDeadLetter adds to a dead-lettered event
+const (
+	DeadLetterErrorHeader      = "Nats-Dead-Letter-Error"      // Error message of the last failed delivery
+	DeadLetterCodeHeader       = "Nats-Dead-Letter-Code"       // Error code of the last failed delivery, e.g. "INTERNAL"
+	DeadLetterDeliveriesHeader = "Nats-Dead-Letter-Deliveries" // Failed deliveries before the event was moved
+	DeadLetterSubjectHeader    = "Nats-Dead-Letter-Subject"    // Subject the event was published on
+)
+
+// DeadLetter is why an event was dead-lettered, as Subscribe<Event>DLQ passes it
+type DeadLetter struct {
+	Subject    string // Subject the event was published on
+	Error      string // Error message of the last failed delivery
+	Code       string // Error code of the last failed delivery
+	Deliveries int    // Failed deliveries before the event was moved
+}
+
+// deadLetterFromHeaders reads the DeadLetter headers WithDeadLetter set
+func deadLetterFromHeaders(headers micro.Headers) DeadLetter {
+	deliveries, _ := strconv.Atoi(headers.Get(DeadLetterDeliveriesHeader))
+	return DeadLetter{
+		Subject:    headers.Get(DeadLetterSubjectHeader),
+		Error:      headers.Get(DeadLetterErrorHeader),
+		Code:       headers.Get(DeadLetterCodeHeader),
+		Deliveries: deliveries,
+	}
+}
+
+// Subscription is a running Subscribe<Event> subscription
+type Subscription interface {
+	// Unsubscribe stops delivering events at once. A durable consumer keeps its
+	// place in the stream for the next subscriber.
+	Unsubscribe() error
+	// Drain stops receiving events and lets the handler finish those received
+	Drain() error
+}
+
+// eventSubscription is a Subscription on core NATS or on a JetStream consumer
+type eventSubscription struct {
+	sub     *nats.Subscription
+	consume jetstream.ConsumeContext
+}
+
+func (s *eventSubscription) Unsubscribe() error {
+	if s.consume != nil {
+		s.consume.Stop()
+		return nil
+	}
+	return s.sub.Unsubscribe()
+}
+
+func (s *eventSubscription) Drain() error {
+	if s.consume != nil {
+		s.consume.Drain()
+		return nil
+	}
+	return s.sub.Drain()
+}
+
+// publishEvent backs the Publish<Event> functions: it encodes msg, attaches the
+// outgoing headers of ctx and publishes it on subject through opts' interceptors
+func publishEvent(ctx context.Context, nc *nats.Conn, subject, event string, msg proto.Message, useJSON bool, opts []PublishOption) error {
+	cfg := publishConfig{}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	publish := func(ctx context.Context, event string, req, _ interface{}) error {
+		msg, ok := req.(proto.Message)
+		if !ok {
+			return fmt.Errorf("invalid event type %T", req)
+		}
+		var data []byte
+		var err error
+		if useJSON {
+			data, err = marshalJSON(msg, false)
+		} else {
+			data, err = proto.Marshal(msg)
+		}
+		if err != nil {
+			return err
+		}
+		headers := withContentType(OutgoingHeaders(ctx), useJSON)
+		if cfg.compression > 0 {
+			codec := compressorFor(cfg.compressionCodec)
+			if codec == nil {
+				return fmt.Errorf("WithPublishCompression: codec %q is not registered; add it with RegisterCompressor", cfg.compressionCodec)
 			}
+			data = compressPayload(codec, cfg.compression, data, headers)
 		}
-		return chainedInvoker(ctx, method, req, reply)
+		out := &nats.Msg{Subject: subject, Data: data, Header: headers}
+		if cfg.js != nil {
+			_, err = cfg.js.PublishMsg(ctx, out)
+			return err
+		}
+		return nc.PublishMsg(out)
+	}
+	if interceptor := chainUnaryClientInterceptors(cfg.interceptors); interceptor != nil {
+		return interceptor(ctx, event, msg, nil, publish)
+	}
+	return publish(ctx, event, msg, nil)
+}
+
+// subscribeEvent backs the Subscribe<Event> functions: it decodes each event on
+// subject into newEvent() and calls handler with it through opts' interceptors.
+// The handler's context carries the event's headers as IncomingHeaders.
+func subscribeEvent[T proto.Message](nc *nats.Conn, subject, event, fullName string, useJSON bool, newEvent func() T, handler func(context.Context, T) error, opts []SubscribeOption) (Subscription, error) {
+	cfg := subscribeConfig{}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	info := &UnaryServerInfo{Method: event, FullMethod: fullName, Subject: subject}
+	interceptor := chainUnaryServerInterceptors(cfg.interceptors)
+	handle := func(header nats.Header, data []byte) error {
+		body, err := readPayload("event", header.Get(ContentEncodingHeader), data, 0)
+		if err != nil {
+			return err
+		}
+		eventJSON, err := payloadUsesJSON(header.Get(ContentTypeHeader), useJSON)
+		if err != nil {
+			return err
+		}
+		msg := newEvent()
+		if eventJSON {
+			err = protojson.Unmarshal(body, msg)
+		} else {
+			err = proto.Unmarshal(body, msg)
+		}
+		if err != nil {
+			return Statusf(CodeInvalidArgument, "failed to decode %s: %v", event, err)
+		}
+		ctx := WithIncomingHeaders(context.Background(), micro.Headers(header))
+		if interceptor == nil {
+			return handler(ctx, msg)
+		}
+		_, err = interceptor(ctx, msg, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+			typed, ok := req.(T)
+			if !ok {
+				return nil, fmt.Errorf("invalid event type %T", req)
+			}
+			return nil, handler(ctx, typed)
+		})
+		return err
+	}
+
+	if cfg.js != nil {
+		consumer, err := cfg.js.CreateOrUpdateConsumer(context.Background(), cfg.stream, jetstream.ConsumerConfig{
+			Durable:       cfg.durable,
+			FilterSubject: subject,
+			AckPolicy:     jetstream.AckExplicitPolicy,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to create consumer %q on stream %q: %w", cfg.durable, cfg.stream, err)
+		}
+		consume, err := consumer.Consume(func(msg jetstream.Msg) {
+			err := handle(msg.Headers(), msg.Data())
+			if err == nil {
+				msg.Ack()
+				return
+			}
+			reportEventError(cfg.errorHandler, event, err)
+			if cfg.deadLetter != "" {
+				deliveries := 1
+				if meta, metaErr := msg.Metadata(); metaErr == nil {
+					deliveries = int(meta.NumDelivered)
+				}
+				if deliveries >= cfg.maxDeliveries {
+					dlqErr := cfg.publishDeadLetter(nc, subject, msg.Headers(), msg.Data(), deliveries, err)
+					if dlqErr == nil {
+						msg.Ack()
+						return
+					}
+					reportEventError(cfg.errorHandler, event, fmt.Errorf("failed to dead-letter to %s: %w", cfg.deadLetter, dlqErr))
+				}
+			}
+			msg.Nak()
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to consume %s: %w", event, err)
+		}
+		return &eventSubscription{consume: consume}, nil
+	}
+
+	callback := func(msg *nats.Msg) {
+		for deliveries := 1; ; deliveries++ {
+			err := handle(msg.Header, msg.Data)
+			if err == nil {
+				return
+			}
+			reportEventError(cfg.errorHandler, event, err)
+			if cfg.deadLetter == "" {
+				return
+			}
+			if deliveries >= cfg.maxDeliveries {
+				if dlqErr := cfg.publishDeadLetter(nc, subject, msg.Header, msg.Data, deliveries, err); dlqErr != nil {
+					reportEventError(cfg.errorHandler, event, fmt.Errorf("failed to dead-letter to %s: %w", cfg.deadLetter, dlqErr))
+				}
+				return
+			}
+		}
+	}
+	var sub *nats.Subscription
+	var err error
+	if cfg.queueGroup != "" {
+		sub, err = nc.QueueSubscribe(subject, cfg.queueGroup, callback)
+	} else {
+		sub, err = nc.Subscribe(subject, callback)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to subscribe to %s: %w", event, err)
+	}
+	return &eventSubscription{sub: sub}, nil
+}
+
+// subscribeDeadLetters backs the Subscribe<Event>DLQ functions: it subscribes to
+// the dead letters on subject like subscribeEvent, passing handler why each failed
+func subscribeDeadLetters[T proto.Message](nc *nats.Conn, subject, event, fullName string, useJSON bool, newEvent func() T, handler func(context.Context, T, DeadLetter) error, opts []SubscribeOption) (Subscription, error) {
+	return subscribeEvent(nc, subject, event, fullName, useJSON, newEvent, func(ctx context.Context, msg T) error {
+		return handler(ctx, msg, deadLetterFromHeaders(IncomingHeaders(ctx)))
+	}, opts)
+}
+
+// publishDeadLetter publishes a failed event, received on subject, to the
+// WithDeadLetter subject with why it failed
+func (c *subscribeConfig) publishDeadLetter(nc *nats.Conn, subject string, header nats.Header, data []byte, deliveries int, err error) error {
+	out := &nats.Msg{Subject: c.deadLetter, Data: data, Header: make(nats.Header, len(header)+4)}
+	for name, values := range header {
+		out.Header[name] = values
+	}
+	code, message, _ := natsErrorFields(err)
+	out.Header.Set(DeadLetterErrorHeader, strings.NewReplacer("\r", " ", "\n", " ").Replace(message))
+	out.Header.Set(DeadLetterCodeHeader, code)
+	out.Header.Set(DeadLetterDeliveriesHeader, strconv.Itoa(deliveries))
+	out.Header.Set(DeadLetterSubjectHeader, subject)
+	if c.js != nil {
+		_, err := c.js.PublishMsg(context.Background(), out)
+		return err
+	}
+	return nc.PublishMsg(out)
+}
+
+// reportEventError passes err to handler, or prints it without one
+func reportEventError(handler func(event string, err error), event string, err error) {
+	if handler != nil {
+		handler(event, err)
+		return
 	}
+	fmt.Fprintf(os.Stderr, "[nats-micro] WARN: %s: %v\n", event, err)
 }
@@ -1,4 +1,8 @@
 // Code generated by protoc-gen-nats-micro. DO NOT EDIT.
+// versions:
+// 	protoc-gen-nats-micro v0.3.0
+// 	protoc                (unknown)
+// source: example/v1/service.proto
 
 package v1
 
@@ -6,7 +10,6 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"log"
 	"os"
 	"time"
 	"github.com/nats-io/nats.go"
@@ -21,6 +24,21 @@ type ExampleServiceError struct {
 	Code    string // Error code (e.g., "INVALID_ARGUMENT", "NOT_FOUND", "INTERNAL")
 	Message string // Human-readable error message
 	Method  string // The method that failed
+	Details []byte // Optional error data sent with the error
+
+	status *Status // Returned by Unwrap (nil = built on each call)
+}
+
+// newExampleServiceError creates an error that keeps its *Status, so Unwrap
+// returns the same value every time
+func newExampleServiceError(code, method, message string, details []byte) *ExampleServiceError {
+	return &ExampleServiceError{
+		Code:    code,
+		Method:  method,
+		Message: message,
+		Details: details,
+		status:  &Status{Code: ParseCode(code), Message: message, Details: details},
+	}
 }
 
 func (e *ExampleServiceError) Error() string {
@@ -39,7 +57,17 @@ func (e *ExampleServiceError) NatsErrorMessage() string {
 
 // NatsErrorData returns optional error data (nil for basic errors)
 func (e *ExampleServiceError) NatsErrorData() []byte {
-	return nil
+	return e.Details
+}
+
+// Unwrap exposes the error as a *Status, so errors.As(err, &st) works on
+// client errors. Custom error codes map to CodeUnknown. Errors built as struct
+// literals get a new Status on each call.
+func (e *ExampleServiceError) Unwrap() error {
+	if e.status != nil {
+		return e.status
+	}
+	return &Status{Code: ParseCode(e.Code), Message: e.Message, Details: e.Details}
 }
 
 // Service-specific error code constants (use shared constants from service_shared_nats.pb.go)
@@ -106,37 +134,37 @@ func GetExampleServiceErrorCode(err error) string {
 
 // NewExampleServiceInvalidArgumentError creates a new invalid argument error
 func NewExampleServiceInvalidArgumentError(method, message string) error {
-	return &ExampleServiceError{Code: ExampleServiceErrCodeInvalidArgument, Method: method, Message: message}
+	return newExampleServiceError(ExampleServiceErrCodeInvalidArgument, method, message, nil)
 }
 
 // NewExampleServiceNotFoundError creates a new not found error
 func NewExampleServiceNotFoundError(method, message string) error {
-	return &ExampleServiceError{Code: ExampleServiceErrCodeNotFound, Method: method, Message: message}
+	return newExampleServiceError(ExampleServiceErrCodeNotFound, method, message, nil)
 }
 
 // NewExampleServiceAlreadyExistsError creates a new already exists error
 func NewExampleServiceAlreadyExistsError(method, message string) error {
-	return &ExampleServiceError{Code: ExampleServiceErrCodeAlreadyExists, Method: method, Message: message}
+	return newExampleServiceError(ExampleServiceErrCodeAlreadyExists, method, message, nil)
 }
 
 // NewExampleServicePermissionDeniedError creates a new permission denied error
 func NewExampleServicePermissionDeniedError(method, message string) error {
-	return &ExampleServiceError{Code: ExampleServiceErrCodePermissionDenied, Method: method, Message: message}
+	return newExampleServiceError(ExampleServiceErrCodePermissionDenied, method, message, nil)
 }
 
 // NewExampleServiceUnauthenticatedError creates a new unauthenticated error
 func NewExampleServiceUnauthenticatedError(method, message string) error {
-	return &ExampleServiceError{Code: ExampleServiceErrCodeUnauthenticated, Method: method, Message: message}
+	return newExampleServiceError(ExampleServiceErrCodeUnauthenticated, method, message, nil)
 }
 
 // NewExampleServiceInternalError creates a new internal error
 func NewExampleServiceInternalError(method, message string) error {
-	return &ExampleServiceError{Code: ExampleServiceErrCodeInternal, Method: method, Message: message}
+	return newExampleServiceError(ExampleServiceErrCodeInternal, method, message, nil)
 }
 
 // NewExampleServiceUnavailableError creates a new unavailable error
 func NewExampleServiceUnavailableError(method, message string) error {
-	return &ExampleServiceError{Code: ExampleServiceErrCodeUnavailable, Method: method, Message: message}
+	return newExampleServiceError(ExampleServiceErrCodeUnavailable, method, message, nil)
 }
 
 // ExampleServiceNats is the NATS service interface for ExampleService
@@ -147,8 +175,10 @@ type ExampleServiceNats interface {
 
 // ExampleServiceEndpointInfo describes a service endpoint
 type ExampleServiceEndpointInfo struct {
-	Name    string `json:"name"`    // Method name (e.g., "CreateProduct")
-	Subject string `json:"subject"` // NATS subject (e.g., "api.v1.create_product")
+	Name           string `json:"name"`                       // Method name (e.g., "CreateProduct")
+	Subject        string `json:"subject"`                    // NATS subject (e.g., "api.v1.create_product")
+	QueueGroup     string `json:"queue_group,omitempty"`      // Queue group the endpoint joined (server only)
+	MaxRequestSize int    `json:"max_request_size,omitempty"` // Request payload limit in bytes (server only, 0 = unlimited)
 }
 
 // ExampleServiceService is the interface for the registered NATS micro service
@@ -156,57 +186,225 @@ type ExampleServiceEndpointInfo struct {
 type ExampleServiceService interface {
 	micro.Service
 	Endpoints() []ExampleServiceEndpointInfo
+	// Drain stops accepting requests, waits until in-flight handlers finish or
+	// ctx ends, and stops the service
+	Drain(ctx context.Context) error
+	// InterceptorChain names the interceptors a method's requests pass through,
+	// outermost first
+	InterceptorChain(method string) []string
+	// Reconfigure changes the settings that can change while the service runs,
+	// currently WithSampling and WithSamplingSalt
+	Reconfigure(opts ...RegisterOption) error
 }
 
 // exampleServiceService is the concrete implementation of ExampleServiceService
 type exampleServiceService struct {
 	micro.Service
-	subjectPrefix string
+	subjectPrefix  string
+	queueGroup     string
+	maxRequestSize int
+	inflight       *inflightTracker
+	interceptors   []string // Names of the service-wide interceptors, outermost first
+	sampler        *sampler // Shared with the handlers, for Reconfigure
 }
 
 // Endpoints returns information about all service endpoints
 // This is useful for debugging, monitoring, and service discovery
 func (s *exampleServiceService) Endpoints() []ExampleServiceEndpointInfo {
 	return []ExampleServiceEndpointInfo{
-		{Name: "Echo", Subject: s.subjectPrefix + ".echo"},
-		{Name: "GetGreeting", Subject: s.subjectPrefix + ".get_greeting"},
+		{Name: "Echo", Subject: s.subjectPrefix + ".echo", QueueGroup: s.queueGroup, MaxRequestSize: s.maxRequestSize},
+		{Name: "GetGreeting", Subject: s.subjectPrefix + ".get_greeting", QueueGroup: s.queueGroup, MaxRequestSize: s.maxRequestSize},
 	}
 }
 
+// init lists the methods of ExampleService in MethodDescriptors
+func init() {
+	MethodDescriptors["example.v1.ExampleService.Echo"] = MethodDescriptor{
+		Service: "ExampleService",
+		Method:  "Echo",
+		Subject: "example_service.echo",
+	}
+	MethodDescriptors["example.v1.ExampleService.GetGreeting"] = MethodDescriptor{
+		Service: "ExampleService",
+		Method:  "GetGreeting",
+		Subject: "example_service.get_greeting",
+	}
+}
+
+// ExampleServiceSubjects maps each method of ExampleService to its subject under
+// the default prefix. Clients and services of every language are generated from
+// the same table.
+var ExampleServiceSubjects = map[string]string{
+	"Echo":        "example_service.echo",
+	"GetGreeting": "example_service.get_greeting",
+}
+
+// Drain unsubscribes every endpoint, so new requests get no responders, then waits
+// for in-flight handlers, and requests already delivered to the endpoints, to
+// finish before returning. Server and bidi streams are
+// sent a GOAWAY, which clients see as an *ErrServerDraining, and streaming handlers
+// have their context canceled right away, or after WithStreamDrainGrace, so
+// long-running streams can end cleanly. If ctx ends first, the remaining handlers'
+// contexts are canceled and ctx.Err() is returned. The service is stopped either way.
+// Drain fails if nats.go's micro left an endpoint subscribed; see stopEndpoints.
+func (s *exampleServiceService) Drain(ctx context.Context) error {
+	return s.inflight.drain(ctx, func() error { return stopEndpoints(s.Service) })
+}
+
+// InterceptorChain returns the names of the interceptors that requests to
+// method, a Go method name, pass through, outermost first: the service's
+// interceptors in the order they were added, then the method's
+// (natsmicro.endpoint).middlewares. Interceptors are named with Named, or else
+// by their function name.
+func (s *exampleServiceService) InterceptorChain(method string) []string {
+	chain := append([]string(nil), s.interceptors...)
+	return chain
+}
+
+// Reconfigure replaces the service's WithSampling and WithSamplingSalt settings
+// with those in opts, so sampling can be turned up, down or off without
+// re-registering; leaving WithSampling out stops sampling. Any other option is
+// rejected, as it only takes effect at registration.
+func (s *exampleServiceService) Reconfigure(opts ...RegisterOption) error {
+	cfg := &registerConfig{}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	return reconfigureSampling(s.sampler, cfg)
+}
+
+// ExampleServiceSchemaHash identifies the schema ExampleService was generated from.
+// Services advertise it as schema_hash INFO metadata; see SchemaHash.
+const ExampleServiceSchemaHash = "sha256:930ea487882cfb8fdf623b582fa9d6b0a4d77ec456f09347e5c666239115aa81"
+
+// exampleServiceSchema is the FileDescriptorSet ExampleService serves from its
+// $reflect endpoint. The schema endpoint metadata is read from it too, so the two
+// always agree.
+const exampleServiceSchema = "\n\xc7\v\n\x18example/v1/service.proto\x12\nexample.v1\x1a\x1cgoogle/api/annotations.proto\x1a\x17natsmicro/options.proto\"'\n\vEchoRequest\x12\x18\n\amessage\x18\x01 \x01(\tR\amessage\"F\n\fEchoResponse\x12\x18\n\amessage\x18\x01 \x01(\tR\amessage\x12\x1c\n\ttimestamp\x18\x02 \x01(\x03R\ttimestamp\"(\n\x12GetGreetingRequest\x12\x12\n\x04name\x18\x01 \x01(\tR\x04name\"1\n\x13GetGreetingResponse\x12\x1a\n\bgreeting\x18\x01 \x01(\tR\bgreeting\"B\n\x11AdminResetRequest\x12-\n\x12confirmation_token\x18\x01 \x01(\tR\x11confirmationToken\"H\n\x12AdminResetResponse\x12\x18\n\asuccess\x18\x01 \x01(\bR\asuccess\x12\x18\n\amessage\x18\x02 \x01(\tR\amessage\"\x14\n\x12HealthCheckRequest\"-\n\x13HealthCheckResponse\x12\x16\n\x06status\x18\x01 \x01(\tR\x06status\"\xf4\x01\n\x1bDetailedHealthCheckResponse\x12\x16\n\x06status\x18\x01 \x01(\tR\x06status\x12W\n\ncomponents\x18\x02 \x03(\v27.example.v1.DetailedHealthCheckResponse.ComponentsEntryR\ncomponents\x12%\n\x0euptime_seconds\x18\x03 \x01(\x03R\ruptimeSeconds\x1a=\n\x0fComponentsEntry\x12\x10\n\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n\x05value\x18\x02 \x01(\tR\x05value:\x028\x012\xb9\x03\n\x0eExampleService\x12\x7f\n\x04Echo\x12\x17.example.v1.EchoRequest\x1a\x18.example.v1.EchoResponse\"D\x92\xb5\x18%\x1a\x13\n\bcategory\x12\atesting\x1a\x0e\n\x06public\x12\x04true\x82\xd3\xe4\x93\x02\x15:\x01*\"\x10/v1/example/echo\x12\xae\x01\n\vGetGreeting\x12\x1e.example.v1.GetGreetingRequest\x1a\x1f.example.v1.GetGreetingResponse\"^\x92\xb5\x187\x1a\x11\n\tcacheable\x12\x04true\x1a\x10\n\bcategory\x12\x04user\x1a\x10\n\breadonly\x12\x04true\x82\xd3\xe4\x93\x02\x1d\x12\x1b/v1/example/greeting/{name}\x12u\n\nAdminReset\x12\x1d.example.v1.AdminResetRequest\x1a\x1e.example.v1.AdminResetResponse\"(\x92\xb5\x18\x02\x10\x01\x82\xd3\xe4\x93\x02\x1c:\x01*\"\x17/v1/example/admin/reset2\xf1\x01\n\x12HealthCheckService\x12\\\n\x05Check\x12\x1e.example.v1.HealthCheckRequest\x1a\x1f.example.v1.HealthCheckResponse\"\x12\x82\xd3\xe4\x93\x02\f\x12\n/v1/health\x12u\n\rDetailedCheck\x12\x1e.example.v1.HealthCheckRequest\x1a'.example.v1.DetailedHealthCheckResponse\"\x1b\x82\xd3\xe4\x93\x02\x15\x12\x13/v1/health/detailed\x1a\x06\x8a\xb5\x18\x028\x01B\x18Z\x16example/gen/example/v1b\x06proto3"
+
+// exampleServiceSchemaDocument is the JSON ExampleService serves from its
+// $schema endpoint, before registration fills in its name, version and subjects
+const exampleServiceSchemaDocument = "{\"service\":\"example.v1.ExampleService\",\"schema_hash\":\"sha256:930ea487882cfb8fdf623b582fa9d6b0a4d77ec456f09347e5c666239115aa81\",\"methods\":[{\"name\":\"Echo\",\"endpoint\":\"echo\",\"streaming\":\"unary\",\"request_type\":\"example.v1.EchoRequest\",\"response_type\":\"example.v1.EchoResponse\"},{\"name\":\"GetGreeting\",\"endpoint\":\"get_greeting\",\"streaming\":\"unary\",\"request_type\":\"example.v1.GetGreetingRequest\",\"response_type\":\"example.v1.GetGreetingResponse\"}],\"descriptor\":\"H4sIAAAAAAAC/5RUTW7TQBSWnTRtX9qSTkprub+yVDVCaqyWRatKLOgP7TpFQkKIauo8Eqv2jPGMIyKEkFiyLPdglwUn4ABcgQsgcQBke9xMiILKzu/3++a97xm+V8HCdzSMAnR7e67AuOd72IxiLjkBFWn29uy1DuedAF0a+S5ljEsqfc5EnmmvMCpF6Hsxd3mkBZwdqJ55Xd7CtwkKSSyYDlEI2kHL2DIas63CdJ7BXJ4oIs4ETs4kazAr/RCFpGFkmVtGo9QaOpwGkHOU5zGi9FmnwCVQZjQsWmXfzh7URzIVsA0zHeVT6Xe2cwyLT9uhz1ooUBa9d4F4nL3x4zAbyZXkN8hU6aIeeZ4GnAsgeo/hc0XieShEVjnTKkx9EOboyJaAXCANZPeki96NouPsQn3EqwCWoSIklYlQzJTl/DZg9RQl9QNs/0cdeQHg8TDiDJkUlrlValT3D5pDwTT/0bR5cld5xmTcb2mtyDYsJFG6zyuBHmdtYZWyHc/n3svcaT+BB391ITUo3WBf8Uw/yRJM9WiQFJPLjSPz0Nj/VoKFs5zsZa558hHKqQLJiv4KTby2NR7I3+Oc3g6sbbsOMx6V2OFxn0xLFKlk7AWoRMl14HukLOMEP/34+cV8eGQ8cmrpvRWnhynwVwOqmiLJho43Lmp7c2Jc0Xp9O7AO7EWY9ajXRXodYE7CrmlMy4nAOPXESNucBX2N6DpZ1VkWd+C+Tw/oA0kAhlIm6zqdsTOxNyaFFdnG7cAya0aGu5YOaEWHpmmBG6cV+7+MEeEX+3sFU5k9OrfxC7E3J8YVFZKRmCOQMuhmCSSB+ULR90PZuecxOKu5Jkh9iOa2Vb5d+TywzEPj2Hq5fLcFZO7wl31dyX60j/8MAHaKzjTKBQAA\"}"
+
 // RegisterExampleServiceHandlers registers the service with NATS micro handlers
 // Service: ExampleService v1.0.0
 // Description: ExampleService - generated by protoc-gen-nats-micro
 // Subject prefix: example_service
-// Configuration options: WithName(), WithVersion(), WithDescription(), WithSubjectPrefix(), WithTimeout()
-// Metadata options: WithMetadata() (replace), WithAdditionalMetadata() (merge)
+// Configuration options: WithName(), WithVersion(), WithDescription(), WithSubjectPrefix(), WithTimeout(), WithQueueGroup(), WithMaxRequestSize()
+// Metadata options: WithMetadata() (replace), WithAdditionalMetadata() (merge), WithEndpointMetadata() (per method)
 // Handler options: WithStatsHandler(), WithDoneHandler(), WithErrorHandler()
+// Interceptors: WithServerInterceptor() appends, WithServerInterceptorChain() replaces; the first runs outermost
+// Stream interceptors: WithServerStreamInterceptor()
+// Grouping: WithServiceGroup() registers on a ServiceGroup shared with other services
+// Existing services: AddExampleServiceEndpoints() registers on a micro.Service added elsewhere
 //
 // Endpoint metadata can be configured via proto options (nats.micro.endpoint).metadata
-func RegisterExampleServiceHandlers(nc *nats.Conn, impl ExampleServiceNats, opts ...RegisterOption) (ExampleServiceService, error) {
+func RegisterExampleServiceHandlers(nc *nats.Conn, impl ExampleServiceNats, opts ...RegisterOption) (_ ExampleServiceService, err error) {
 	cfg := &registerConfig{
-		name:          "ExampleService",
-		version:       "1.0.0",
-		description:   "ExampleService - generated by protoc-gen-nats-micro",
-		subjectPrefix: "example_service",
-		timeout:       0 * time.Second, // Service-level timeout (0 = no timeout)
-		metadata:      map[string]string{},
+		name:           "ExampleService",
+		version:        "1.0.0",
+		description:    "ExampleService - generated by protoc-gen-nats-micro",
+		subjectPrefix:  "example_service",
+		queueGroup:     "",
+		timeout:        0 * time.Second, // Service-level timeout (0 = no timeout)
+		tokenSanitizer: SanitizeToken,
+		streamWindow:   defaultStreamWindow,
+		metadata:       map[string]string{},
 	}
 	for _, opt := range opts {
 		opt(cfg)
 	}
+	return registerExampleServiceEndpoints(nc, impl, cfg, func() (*serviceHost, bool, error) {
+		// Register on the ServiceGroup, or add a micro.Service for this service
+		if cfg.group != nil {
+			return cfg.group.host, true, nil
+		}
+		host, err := addServiceHost(nc, cfg, mergeMetadata(cfg.metadata, map[string]string{"schema_hash": ExampleServiceSchemaHash}))
+		return host, false, err
+	})
+}
 
-	svc, err := micro.AddService(nc, micro.Config{
-		Name:         cfg.name,
-		Version:      cfg.version,
-		Metadata:     cfg.metadata,
-		Description:  cfg.description,
-		StatsHandler: cfg.statsHandler,
-		DoneHandler:  cfg.doneHandler,
-		ErrorHandler: cfg.errorHandler,
+// AddExampleServiceEndpoints registers the ExampleService endpoints on svc, a
+// micro.Service the caller added on nc, so they share its name, version and stats
+// with the endpoints of other services and the caller's own:
+//
+//	svc, err := micro.AddService(nc, micro.Config{Name: "catalog", Version: "1.0.0"})
+//	...
+//	err = AddExampleServiceEndpoints(nc, svc, impl, WithServerInterceptor(auth))
+//
+// As on a ServiceGroup, endpoint names are qualified with the service name
+// ("example_service-health") while subjects keep the subject prefix, and the
+// schema_hash is advertised as endpoint metadata. Options of the micro.Service
+// itself, which NewServiceGroup lists, are rejected with an error, as is
+// WithServiceGroup. Stopping svc stops the endpoints; there is no Drain.
+func AddExampleServiceEndpoints(nc *nats.Conn, svc micro.Service, impl ExampleServiceNats, opts ...RegisterOption) error {
+	cfg := &registerConfig{
+		subjectPrefix:  "example_service",
+		queueGroup:     "",
+		timeout:        0 * time.Second, // Service-level timeout (0 = no timeout)
+		tokenSanitizer: SanitizeToken,
+		streamWindow:   defaultStreamWindow,
+		metadata:       map[string]string{},
+	}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	host, err := existingServiceHost(nc, svc, cfg)
+	if err != nil {
+		return err
+	}
+	// The subjects and $schema document describe the proto service
+	cfg.name, cfg.version = "ExampleService", "1.0.0"
+	_, err = registerExampleServiceEndpoints(nc, impl, cfg, func() (*serviceHost, bool, error) {
+		return host, true, nil
 	})
+	return err
+}
+
+// registerExampleServiceEndpoints registers the ExampleService endpoints cfg describes
+// on the host attach returns, once the configuration checks out. Endpoint names
+// are qualified when attach reports the host is shared with other services.
+func registerExampleServiceEndpoints(nc *nats.Conn, impl ExampleServiceNats, cfg *registerConfig, attach func() (*serviceHost, bool, error)) (_ ExampleServiceService, err error) {
+
+	// Describe each endpoint from the schema blob
+	schemaMetadata, err := schemaEndpointMetadata(exampleServiceSchema, "example.v1.ExampleService")
 	if err != nil {
-		return nil, fmt.Errorf("failed to add service: %w", err)
+		return nil, err
+	}
+	endpointMethods := map[string]bool{
+		"Echo":        true,
+		"GetGreeting": true,
+	}
+	for method := range cfg.endpointMetadata {
+		if !endpointMethods[method] {
+			return nil, fmt.Errorf("WithEndpointMetadata: ExampleService has no method %s", method)
+		}
+	}
+
+	// Streams are compressed with gzip unless WithCompression names another codec
+	compressor := compressorFor(streamEncodingGzip)
+	if cfg.compressionCodec != "" {
+		if compressor = compressorFor(cfg.compressionCodec); compressor == nil {
+			return nil, fmt.Errorf("WithCompression: codec %q is not registered; add it with RegisterCompressor", cfg.compressionCodec)
+		}
+	}
+
+	host, shared, err := attach()
+	if err != nil {
+		return nil, err
+	}
+	endpointPrefix := "" // Qualifies endpoint names on a shared micro.Service
+	if shared {
+		endpointPrefix = "example_service-"
+	} else {
+		// Stop the micro.Service again if an endpoint fails to register
+		defer func() {
+			if err != nil {
+				host.svc.Stop()
+			}
+		}()
 	}
+	svc, pool := host.svc, host.pool
 
 	// Chain server interceptors
 	var chainedInterceptor UnaryServerInterceptor
@@ -215,34 +413,69 @@ func RegisterExampleServiceHandlers(nc *nats.Conn, impl ExampleServiceNats, opts
 	}
 
 	handlers := &exampleServiceHandlers{
-		impl:           impl,
-		serviceTimeout: cfg.timeout,
-		useJSON:        false,
-		interceptor:    chainedInterceptor,
-		js:             cfg.js,
+		nc:                nc,
+		impl:              impl,
+		serviceTimeout:    cfg.timeout,
+		useJSON:           false,
+		interceptor:       chainedInterceptor,
+		js:                cfg.js,
+		cancels:           host.cancels,
+		tokenSanitizer:    cfg.tokenSanitizer,
+		idGenerator:       cfg.idGenerator,
+		maxRequestSize:    cfg.maxRequestSize,
+		streamWindow:      cfg.streamWindow,
+		streamAllowGaps:   cfg.streamAllowGaps,
+		streamCompression: cfg.streamCompression,
+		compression:       cfg.compression,
+		compressor:        compressor,
+		responseHeaders:   cfg.responseHeaders,
+		requestCheck:      cfg.requestCheck,
+		streamInterceptor: chainStreamServerInterceptors(cfg.streamInterceptors),
+		endpointPrefix:    endpointPrefix,
+		inflight:          host.inflight,
+		sampler:           newSampler(cfg.sampling, cfg.samplingSalt),
+		maxServerDeadline: cfg.maxServerDeadline,
+		persistenceErrors: cfg.persistenceErrors,
+		panics:            cfg.panicDiagnostics,
+		failures:          newFailureRecorder(cfg),
 	}
 
-	// Map of endpoint names to their handlers
+	// Map of endpoint names to their handlers; unary ones go through the handler pool,
+	// idempotent ones are coalesced first, and those expecting a response are
+	// deduplicated by idempotency key inside the pool
+	idempotency := newIdempotencyGuard(cfg)
 	endpoints := map[string]micro.Handler{
 
-		"echo": micro.HandlerFunc(handlers.Echo),
+		"echo": pool.wrap(endpointPrefix+"echo", idempotency.wrap(endpointPrefix+"echo", micro.HandlerFunc(handlers.Echo))),
 
-		"get_greeting": micro.HandlerFunc(handlers.GetGreeting),
+		"get_greeting": pool.wrap(endpointPrefix+"get_greeting", idempotency.wrap(endpointPrefix+"get_greeting", micro.HandlerFunc(handlers.GetGreeting))),
 	}
 
-	// Map of endpoint names to their metadata
+	// Map of endpoint names to their metadata: the schema's description of the
+	// method, overlaid with (natsmicro.endpoint).metadata, then WithEndpointMetadata
 	endpointMetadata := map[string]map[string]string{
 
-		"echo": {
+		"echo": mergeMetadata(mergeMetadata(schemaMetadata["Echo"], map[string]string{
 			"category": "testing",
 			"public":   "true",
-		},
+		}), cfg.endpointMetadata["Echo"]),
 
-		"get_greeting": {
+		"get_greeting": mergeMetadata(mergeMetadata(schemaMetadata["GetGreeting"], map[string]string{
 			"cacheable": "true",
 			"category":  "user",
 			"readonly":  "true",
-		},
+		}), cfg.endpointMetadata["GetGreeting"]),
+	}
+
+	// Map of endpoint names to exact subjects from (natsmicro.endpoint).subject,
+	// or the wildcard form of subject_template
+	// These endpoints are registered outside the subject prefix group
+	endpointSubjects := map[string]string{}
+
+	// Endpoints WithInstanceSubjects also serves on <subject>.<instance ID>
+	instanceEndpoints := map[string]bool{
+		"echo":         true,
+		"get_greeting": true,
 	}
 
 	// Use interface to handle both Service and Group
@@ -250,35 +483,146 @@ func RegisterExampleServiceHandlers(nc *nats.Conn, impl ExampleServiceNats, opts
 		AddEndpoint(string, micro.Handler, ...micro.EndpointOpt) error
 	}
 
-	var adder endpointAdder = svc
+	var group endpointAdder = svc
 	if cfg.subjectPrefix != "" {
-		adder = svc.AddGroup(cfg.subjectPrefix)
+		group = svc.AddGroup(cfg.subjectPrefix)
 	}
 
 	// Register all endpoints with their metadata
 	for name, handler := range endpoints {
 		opts := []micro.EndpointOpt{}
-		if metadata, exists := endpointMetadata[name]; exists && len(metadata) > 0 {
+		metadata := endpointMetadata[name]
+		if cfg.maxRequestSize > 0 {
+			// Advertise the request size limit alongside the proto metadata
+			withLimit := map[string]string{"max_request_size": fmt.Sprint(cfg.maxRequestSize)}
+			for k, v := range metadata {
+				withLimit[k] = v
+			}
+			metadata = withLimit
+		}
+		if shared {
+			// Keep the unqualified subject; the shared service's metadata cannot
+			// carry every service's schema hash
+			opts = append(opts, micro.WithEndpointSubject(name))
+			metadata = mergeMetadata(metadata, map[string]string{"schema_hash": ExampleServiceSchemaHash})
+			if cfg.queueGroup != "" {
+				opts = append(opts, micro.WithEndpointQueueGroup(cfg.queueGroup))
+			}
+		}
+		if len(metadata) > 0 {
 			opts = append(opts, micro.WithEndpointMetadata(metadata))
 		}
-		if err := adder.AddEndpoint(name, handler, opts...); err != nil {
+		adder := group
+		subject := name
+		if exact, exists := endpointSubjects[name]; exists {
+			adder = svc
+			subject = exact
+			opts = append(opts, micro.WithEndpointSubject(subject))
+		}
+		if cfg.instanceSubjects && instanceEndpoints[name] {
+			// Responses name the instance, for ResponderInstance
+			handler = respondAsInstance(svc.Info().ID, handler)
+		}
+		if err := adder.AddEndpoint(endpointPrefix+name, holdRequests(cfg.hold, handler), opts...); err != nil {
 			return nil, fmt.Errorf("failed to add endpoint %s: %w", name, err)
 		}
+		if cfg.instanceSubjects && instanceEndpoints[name] {
+			// The same handler on this instance's own subject, for WithTargetInstance
+			opts = append(opts, micro.WithEndpointSubject(subject+"."+svc.Info().ID))
+			if err := adder.AddEndpoint(endpointPrefix+name+"-instance", holdRequests(cfg.hold, handler), opts...); err != nil {
+				return nil, fmt.Errorf("failed to add instance endpoint %s: %w", name, err)
+			}
+		}
+	}
+
+	pool.start()
+
+	// Application health endpoint, registered outside the subject prefix group
+	if !cfg.noHealthEndpoint {
+		subject := healthSubject(cfg.subjectPrefix, "example_service")
+		if err := svc.AddEndpoint(endpointPrefix+"health", holdRequests(cfg.hold, newHealthHandler(impl, cfg.timeout)), micro.WithEndpointSubject(subject)); err != nil {
+			return nil, fmt.Errorf("failed to add health endpoint: %w", err)
+		}
+	}
+
+	// Schema reflection endpoint, registered outside the subject prefix group
+	if !cfg.noReflectEndpoint {
+		subject := reflectSubject(cfg.subjectPrefix, "example_service")
+		if err := svc.AddEndpoint(endpointPrefix+"reflect", holdRequests(cfg.hold, newReflectHandler(exampleServiceSchema, ExampleServiceSchemaHash)), micro.WithEndpointSubject(subject)); err != nil {
+			return nil, fmt.Errorf("failed to add reflect endpoint: %w", err)
+		}
+	}
+
+	// Schema document endpoint, registered outside the subject prefix group
+	if !cfg.noSchemaEndpoint {
+		handler, err := newSchemaHandler(exampleServiceSchemaDocument, cfg)
+		if err != nil {
+			return nil, err
+		}
+		subject := schemaSubject(cfg.subjectPrefix, "example_service")
+		if err := svc.AddEndpoint(endpointPrefix+"schema", holdRequests(cfg.hold, handler), micro.WithEndpointSubject(subject)); err != nil {
+			return nil, fmt.Errorf("failed to add schema endpoint: %w", err)
+		}
+	}
+
+	queueGroup := cfg.queueGroup
+	if queueGroup == "" {
+		queueGroup = micro.DefaultQueueGroup
 	}
 
 	return &exampleServiceService{
-		Service:       svc,
-		subjectPrefix: cfg.subjectPrefix,
+		Service:        svc,
+		subjectPrefix:  cfg.subjectPrefix,
+		queueGroup:     queueGroup,
+		maxRequestSize: cfg.maxRequestSize,
+		inflight:       handlers.inflight,
+		interceptors:   interceptorChainNames(cfg.serverInterceptors),
+		sampler:        handlers.sampler,
 	}, nil
 }
 
+// ExampleServiceRegistration describes a RegisterExampleServiceHandlers call for
+// RegisterGroup, which registers it together with other services, all or nothing
+func ExampleServiceRegistration(impl ExampleServiceNats, opts ...RegisterOption) Registration {
+	return registrationFunc(func(nc *nats.Conn, hold func(context.Context) error) (GroupService, error) {
+		return RegisterExampleServiceHandlers(nc, impl, append(opts[:len(opts):len(opts)], withRequestHold(hold))...)
+	})
+}
+
 // exampleServiceHandlers wraps the service implementation with NATS handlers
 type exampleServiceHandlers struct {
-	impl           ExampleServiceNats
-	serviceTimeout time.Duration          // Default timeout for all endpoints
-	useJSON        bool                   // Use JSON encoding instead of binary protobuf
-	interceptor    UnaryServerInterceptor // Chained interceptors
-	js             jetstream.JetStream    // Optional JetStream context for KV/ObjectStore
+	nc                 *nats.Conn // NATS connection for streaming
+	impl               ExampleServiceNats
+	serviceTimeout     time.Duration                     // Default timeout for all endpoints
+	useJSON            bool                              // Use JSON encoding instead of binary protobuf
+	interceptor        UnaryServerInterceptor            // Chained interceptors
+	methodInterceptors map[string]UnaryServerInterceptor // Interceptors plus (natsmicro.endpoint).middlewares, by method
+	js                 jetstream.JetStream               // Optional JetStream context for KV/ObjectStore
+	cancels            *cancelRegistry                   // Client cancel notices (nil unless WithCancelPropagation)
+	tokenSanitizer     func(string) string               // Escapes request fields in KV/Object Store keys
+	idGenerator        func() string                     // Mints stream inbox and persistent call IDs (nil = NUIDs)
+	maxRequestSize     int                               // Largest accepted request payload in bytes (0 = unlimited)
+	inflight           *inflightTracker                  // Running handlers, for Drain
+	persistentStreams  map[string]*persistentStream      // (natsmicro.stream).persistence streams, by method
+	streamWindow       int                               // Server-stream flow control cap (0 = none)
+	streamCompression  int                               // Smallest stream message compressed, once negotiated (0 = off)
+	compression        int                               // Smallest unary response compressed, if the client accepts it (0 = off)
+	compressor         Compressor                        // Codec for responses and stream messages
+	streamAllowGaps    bool                              // Skip lost client-stream messages instead of failing Recv
+	responseHeaders    *headerPolicy                     // Strips response headers (nil = allow all)
+	requestCheck       *requestImmutabilityCheck         // Reports handlers that modify their request (nil = off)
+	streamInterceptor  StreamServerInterceptor           // Chained stream interceptors
+	endpointPrefix     string                            // Qualifies endpoint names within a ServiceGroup
+	sampler            *sampler                          // Samples unary calls (WithSampling)
+	maxServerDeadline  time.Duration                     // Cap on propagated client deadlines (0 = none)
+	persistenceErrors  func(method string, err error)    // Receives failed KV/Object Store writes (nil = print)
+	panics             *panicDiagnostics                 // Recovers and reports handler panics (nil = off)
+	failures           *failureRecorder                  // Records failed calls for replay (nil = off)
+}
+
+// keyToken renders a request field for a key template through the token sanitizer
+func (h *exampleServiceHandlers) keyToken(v any) string {
+	return h.tokenSanitizer(fmt.Sprint(v))
 }
 
 func (h *exampleServiceHandlers) Echo(req micro.Request) {
@@ -286,14 +630,26 @@ func (h *exampleServiceHandlers) Echo(req micro.Request) {
 	// If endpoint timeout is set (> 0), use it; otherwise use service timeout
 	timeout := h.serviceTimeout
 
-	// Create context with timeout if configured, otherwise use background context
-	ctx := context.Background()
+	// Create context with timeout if configured, derived from the service's drain context
+	ctx, end := h.inflight.begin(false) // Tracked for Drain
+	defer end()
 	var cancel context.CancelFunc
 	if timeout > 0 {
 		ctx, cancel = context.WithTimeout(ctx, timeout)
 		defer cancel()
 	}
 
+	// Stop when the client gives up: honor the deadline it propagated
+	ctx, cancelDeadline := withPropagatedDeadline(ctx, req.Headers(), h.maxServerDeadline)
+	defer cancelDeadline()
+
+	// Cancel the handler early if the client abandons the request
+	if h.cancels != nil {
+		var release func()
+		ctx, release = h.cancels.watch(ctx, req.Headers())
+		defer release()
+	}
+
 	// Add incoming NATS headers to context so service methods can access them
 	if req.Headers() != nil {
 		ctx = WithIncomingHeaders(ctx, req.Headers())
@@ -301,62 +657,69 @@ func (h *exampleServiceHandlers) Echo(req micro.Request) {
 
 	// Initialize outgoing headers pointer in context so interceptors can set response headers
 	outgoingHeadersPtr := &nats.Header{}
-	ctx = context.WithValue(ctx, outgoingHeadersKey, outgoingHeadersPtr)
+	ctx = withPendingResponseHeaders(ctx, outgoingHeadersPtr)
+
+	// Reject oversized requests before decoding them, decompressing compressed ones
+	body, payloadErr := readPayload("request", req.Headers().Get(ContentEncodingHeader), req.Data(), h.maxRequestSize)
+	if payloadErr != nil {
+		code, message, data := natsErrorFields(payloadErr)
+		req.Error(code, message, data)
+		return
+	}
 
+	// Decode with the codec the client named; clients without Content-Type use the configured one
+	requestJSON, ctErr := payloadUsesJSON(req.Headers().Get(ContentTypeHeader), h.useJSON)
+	if ctErr != nil {
+		code, message, data := natsErrorFields(ctErr)
+		req.Error(code, message, data)
+		return
+	}
 	var msg EchoRequest
-	if h.useJSON {
-		if err := protojson.Unmarshal(req.Data(), &msg); err != nil {
+	if requestJSON {
+		if err := protojson.Unmarshal(body, &msg); err != nil {
 			req.Error(ExampleServiceErrCodeInvalidArgument, fmt.Sprintf("failed to decode JSON request: %v", err), nil)
 			return
 		}
 	} else {
-		if err := proto.Unmarshal(req.Data(), &msg); err != nil {
+		if err := proto.Unmarshal(body, &msg); err != nil {
 			req.Error(ExampleServiceErrCodeInvalidArgument, fmt.Sprintf("failed to decode request: %v", err), nil)
 			return
 		}
 	}
 
+	// Sample the call for WithSampling, capturing the request before the handler runs
+	sample := h.sampler.begin("ExampleService", "Echo", incomingRequestID(req), false, nats.Header(req.Headers()), &msg, false)
+
 	// Define the handler function
 	handler := func(ctx context.Context, request interface{}) (interface{}, error) {
 		typedReq, ok := request.(*EchoRequest)
 		if !ok {
 			return nil, fmt.Errorf("invalid request type")
 		}
+		defer h.requestCheck.check("ExampleService.Echo", typedReq)()
 		return h.impl.Echo(ctx, typedReq)
 	}
+	handler = h.panics.wrap("ExampleService", "Echo", "example.v1.ExampleService.Echo", false, handler)
+	handler = h.failures.wrap("ExampleService", "Echo", "example.v1.ExampleService.Echo", false, handler)
 
 	// Execute through interceptor chain if configured
 	var resp interface{}
 	var err error
-	if h.interceptor != nil {
+	interceptor := h.interceptor
+	if interceptor != nil {
 		info := &UnaryServerInfo{
-			Service: "ExampleService",
-			Method:  "Echo",
-			Subject: "example_service.echo",
+			Service:    "ExampleService",
+			Method:     "Echo",
+			FullMethod: "example.v1.ExampleService.Echo",
+			Subject:    "example_service.echo",
 		}
-		resp, err = h.interceptor(ctx, &msg, info, handler)
+		resp, err = interceptor(ctx, &msg, info, handler)
 	} else {
 		resp, err = handler(ctx, &msg)
 	}
+	sample.end(resp, err)
 	if err != nil {
-		// Check if error implements custom status code/message/data methods
-		code := ExampleServiceErrCodeInternal
-		message := err.Error()
-		var data []byte
-
-		// Check for NatsErrorCode() string method
-		if coder, ok := err.(interface{ NatsErrorCode() string }); ok {
-			code = coder.NatsErrorCode()
-		}
-		// Check for NatsErrorMessage() string method
-		if messager, ok := err.(interface{ NatsErrorMessage() string }); ok {
-			message = messager.NatsErrorMessage()
-		}
-		// Check for NatsErrorData() []byte method
-		if dataProvider, ok := err.(interface{ NatsErrorData() []byte }); ok {
-			data = dataProvider.NatsErrorData()
-		}
-
+		code, message, data := natsErrorFields(err)
 		req.Error(code, message, data)
 		return
 	}
@@ -370,7 +733,7 @@ func (h *exampleServiceHandlers) Echo(req micro.Request) {
 
 	var data []byte
 	if h.useJSON {
-		data, err = protojson.Marshal(typedResp)
+		data, err = marshalJSON(typedResp, false)
 		if err != nil {
 			req.Error(ExampleServiceErrCodeInternal, fmt.Sprintf("failed to encode JSON response: %v", err), nil)
 			return
@@ -385,22 +748,17 @@ func (h *exampleServiceHandlers) Echo(req micro.Request) {
 
 	// Check if context has outgoing headers set by interceptors
 	// Read from the pointer that was initialized at the start
-	var outgoingHeaders nats.Header
-	if headersPtr, ok := ctx.Value(outgoingHeadersKey).(*nats.Header); ok && headersPtr != nil {
-		outgoingHeaders = *headersPtr
+	outgoingHeaders := pendingResponseHeaders(ctx)
+
+	// Send response with headers set by interceptors plus the response codec
+	// Convert nats.Header to micro.Headers (they are the same underlying type)
+	outgoingHeaders = h.responseHeaders.strip(h.endpointPrefix+"echo", outgoingHeaders)
+	outgoingHeaders = withContentType(outgoingHeaders, h.useJSON)
+	if h.compression > 0 && acceptsEncoding(req.Headers().Get(acceptEncodingHeader), h.compressor.Name()) {
+		data = compressPayload(h.compressor, h.compression, data, outgoingHeaders)
 	}
-
-	if len(outgoingHeaders) > 0 {
-		// Send response with headers using micro.WithHeaders
-		// Convert nats.Header to micro.Headers (they are the same underlying type)
-		if err := req.Respond(data, micro.WithHeaders(micro.Headers(outgoingHeaders))); err != nil {
-			fmt.Fprintf(os.Stderr, "failed to send response for Echo: %v\n", err)
-		}
-	} else {
-		// Send response without headers
-		if err := req.Respond(data); err != nil {
-			fmt.Fprintf(os.Stderr, "failed to send response for Echo: %v\n", err)
-		}
+	if err := req.Respond(data, micro.WithHeaders(micro.Headers(outgoingHeaders))); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to send response for Echo: %v\n", err)
 	}
 }
 
@@ -409,14 +767,26 @@ func (h *exampleServiceHandlers) GetGreeting(req micro.Request) {
 	// If endpoint timeout is set (> 0), use it; otherwise use service timeout
 	timeout := h.serviceTimeout
 
-	// Create context with timeout if configured, otherwise use background context
-	ctx := context.Background()
+	// Create context with timeout if configured, derived from the service's drain context
+	ctx, end := h.inflight.begin(false) // Tracked for Drain
+	defer end()
 	var cancel context.CancelFunc
 	if timeout > 0 {
 		ctx, cancel = context.WithTimeout(ctx, timeout)
 		defer cancel()
 	}
 
+	// Stop when the client gives up: honor the deadline it propagated
+	ctx, cancelDeadline := withPropagatedDeadline(ctx, req.Headers(), h.maxServerDeadline)
+	defer cancelDeadline()
+
+	// Cancel the handler early if the client abandons the request
+	if h.cancels != nil {
+		var release func()
+		ctx, release = h.cancels.watch(ctx, req.Headers())
+		defer release()
+	}
+
 	// Add incoming NATS headers to context so service methods can access them
 	if req.Headers() != nil {
 		ctx = WithIncomingHeaders(ctx, req.Headers())
@@ -424,62 +794,69 @@ func (h *exampleServiceHandlers) GetGreeting(req micro.Request) {
 
 	// Initialize outgoing headers pointer in context so interceptors can set response headers
 	outgoingHeadersPtr := &nats.Header{}
-	ctx = context.WithValue(ctx, outgoingHeadersKey, outgoingHeadersPtr)
+	ctx = withPendingResponseHeaders(ctx, outgoingHeadersPtr)
+
+	// Reject oversized requests before decoding them, decompressing compressed ones
+	body, payloadErr := readPayload("request", req.Headers().Get(ContentEncodingHeader), req.Data(), h.maxRequestSize)
+	if payloadErr != nil {
+		code, message, data := natsErrorFields(payloadErr)
+		req.Error(code, message, data)
+		return
+	}
 
+	// Decode with the codec the client named; clients without Content-Type use the configured one
+	requestJSON, ctErr := payloadUsesJSON(req.Headers().Get(ContentTypeHeader), h.useJSON)
+	if ctErr != nil {
+		code, message, data := natsErrorFields(ctErr)
+		req.Error(code, message, data)
+		return
+	}
 	var msg GetGreetingRequest
-	if h.useJSON {
-		if err := protojson.Unmarshal(req.Data(), &msg); err != nil {
+	if requestJSON {
+		if err := protojson.Unmarshal(body, &msg); err != nil {
 			req.Error(ExampleServiceErrCodeInvalidArgument, fmt.Sprintf("failed to decode JSON request: %v", err), nil)
 			return
 		}
 	} else {
-		if err := proto.Unmarshal(req.Data(), &msg); err != nil {
+		if err := proto.Unmarshal(body, &msg); err != nil {
 			req.Error(ExampleServiceErrCodeInvalidArgument, fmt.Sprintf("failed to decode request: %v", err), nil)
 			return
 		}
 	}
 
+	// Sample the call for WithSampling, capturing the request before the handler runs
+	sample := h.sampler.begin("ExampleService", "GetGreeting", incomingRequestID(req), false, nats.Header(req.Headers()), &msg, false)
+
 	// Define the handler function
 	handler := func(ctx context.Context, request interface{}) (interface{}, error) {
 		typedReq, ok := request.(*GetGreetingRequest)
 		if !ok {
 			return nil, fmt.Errorf("invalid request type")
 		}
+		defer h.requestCheck.check("ExampleService.GetGreeting", typedReq)()
 		return h.impl.GetGreeting(ctx, typedReq)
 	}
+	handler = h.panics.wrap("ExampleService", "GetGreeting", "example.v1.ExampleService.GetGreeting", false, handler)
+	handler = h.failures.wrap("ExampleService", "GetGreeting", "example.v1.ExampleService.GetGreeting", false, handler)
 
 	// Execute through interceptor chain if configured
 	var resp interface{}
 	var err error
-	if h.interceptor != nil {
+	interceptor := h.interceptor
+	if interceptor != nil {
 		info := &UnaryServerInfo{
-			Service: "ExampleService",
-			Method:  "GetGreeting",
-			Subject: "example_service.get_greeting",
+			Service:    "ExampleService",
+			Method:     "GetGreeting",
+			FullMethod: "example.v1.ExampleService.GetGreeting",
+			Subject:    "example_service.get_greeting",
 		}
-		resp, err = h.interceptor(ctx, &msg, info, handler)
+		resp, err = interceptor(ctx, &msg, info, handler)
 	} else {
 		resp, err = handler(ctx, &msg)
 	}
+	sample.end(resp, err)
 	if err != nil {
-		// Check if error implements custom status code/message/data methods
-		code := ExampleServiceErrCodeInternal
-		message := err.Error()
-		var data []byte
-
-		// Check for NatsErrorCode() string method
-		if coder, ok := err.(interface{ NatsErrorCode() string }); ok {
-			code = coder.NatsErrorCode()
-		}
-		// Check for NatsErrorMessage() string method
-		if messager, ok := err.(interface{ NatsErrorMessage() string }); ok {
-			message = messager.NatsErrorMessage()
-		}
-		// Check for NatsErrorData() []byte method
-		if dataProvider, ok := err.(interface{ NatsErrorData() []byte }); ok {
-			data = dataProvider.NatsErrorData()
-		}
-
+		code, message, data := natsErrorFields(err)
 		req.Error(code, message, data)
 		return
 	}
@@ -493,7 +870,7 @@ func (h *exampleServiceHandlers) GetGreeting(req micro.Request) {
 
 	var data []byte
 	if h.useJSON {
-		data, err = protojson.Marshal(typedResp)
+		data, err = marshalJSON(typedResp, false)
 		if err != nil {
 			req.Error(ExampleServiceErrCodeInternal, fmt.Sprintf("failed to encode JSON response: %v", err), nil)
 			return
@@ -508,47 +885,120 @@ func (h *exampleServiceHandlers) GetGreeting(req micro.Request) {
 
 	// Check if context has outgoing headers set by interceptors
 	// Read from the pointer that was initialized at the start
-	var outgoingHeaders nats.Header
-	if headersPtr, ok := ctx.Value(outgoingHeadersKey).(*nats.Header); ok && headersPtr != nil {
-		outgoingHeaders = *headersPtr
+	outgoingHeaders := pendingResponseHeaders(ctx)
+
+	// Send response with headers set by interceptors plus the response codec
+	// Convert nats.Header to micro.Headers (they are the same underlying type)
+	outgoingHeaders = h.responseHeaders.strip(h.endpointPrefix+"get_greeting", outgoingHeaders)
+	outgoingHeaders = withContentType(outgoingHeaders, h.useJSON)
+	if h.compression > 0 && acceptsEncoding(req.Headers().Get(acceptEncodingHeader), h.compressor.Name()) {
+		data = compressPayload(h.compressor, h.compression, data, outgoingHeaders)
 	}
-
-	if len(outgoingHeaders) > 0 {
-		// Send response with headers using micro.WithHeaders
-		// Convert nats.Header to micro.Headers (they are the same underlying type)
-		if err := req.Respond(data, micro.WithHeaders(micro.Headers(outgoingHeaders))); err != nil {
-			fmt.Fprintf(os.Stderr, "failed to send response for GetGreeting: %v\n", err)
-		}
-	} else {
-		// Send response without headers
-		if err := req.Respond(data); err != nil {
-			fmt.Fprintf(os.Stderr, "failed to send response for GetGreeting: %v\n", err)
-		}
+	if err := req.Respond(data, micro.WithHeaders(micro.Headers(outgoingHeaders))); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to send response for GetGreeting: %v\n", err)
 	}
 }
 
 // ExampleServiceNatsClientInterface is the interface for the NATS client
 // This interface allows for easier dependency injection and testing
 type ExampleServiceNatsClientInterface interface {
-	Echo(context.Context, *EchoRequest) (*EchoResponse, error)
-	GetGreeting(context.Context, *GetGreetingRequest) (*GetGreetingResponse, error)
+	Echo(context.Context, *EchoRequest, ...CallOption) (*EchoResponse, error)
+	GetGreeting(context.Context, *GetGreetingRequest, ...CallOption) (*GetGreetingResponse, error)
+	Health(ctx context.Context) (*HealthResponse, error)
+	Schema(ctx context.Context) (*ServiceSchemaDocument, error)
 	Endpoints() []ExampleServiceEndpointInfo
+	WithHeaders(headers nats.Header) ExampleServiceNatsClientInterface
+	Close()
 }
 
 // ExampleServiceNatsClient is the concrete implementation of ExampleServiceNatsClientInterface
 type ExampleServiceNatsClient struct {
-	nc            *nats.Conn
-	subjectPrefix string
-	useJSON       bool                   // Use JSON encoding instead of binary protobuf
-	interceptor   UnaryClientInterceptor // Chained interceptors
-	js            jetstream.JetStream    // Optional JetStream for KV/ObjectStore reads
+	nc                  *nats.Conn
+	subjectPrefix       string
+	useJSON             bool                    // Use JSON encoding instead of binary protobuf
+	interceptor         UnaryClientInterceptor  // Chained interceptors
+	streamInterceptor   StreamClientInterceptor // Chained stream interceptors
+	js                  jetstream.JetStream     // Optional JetStream for KV/ObjectStore reads
+	kvStaleAfter        time.Duration           // Age at which Get*Cached treats KV entries as misses (0 = never)
+	cancelPropagation   bool                    // Publish a cancel notice when ctx ends mid-request
+	timeout             time.Duration           // Default unary timeout when ctx has no deadline
+	retry               *retryPolicy            // Unary retry policy (nil = no retries)
+	tokenSanitizer      func(string) string     // Escapes request fields in key helpers
+	idGenerator         func() string           // Mints cancel subject and stream inbox IDs (nil = NUIDs)
+	maxResponseSize     int                     // Largest accepted response payload in bytes (0 = unlimited)
+	connSelector        func() *nats.Conn       // Picks the connection per call (nil = nc)
+	journal             *journal                // Records calls for replay (nil = no journal)
+	streamWindow        int                     // Server-stream flow control window (0 = none)
+	streamAllowGaps     bool                    // Skip lost stream messages instead of failing Recv
+	streamCompression   int                     // Smallest stream message compressed, once negotiated (0 = off)
+	compression         int                     // Smallest unary request compressed (0 = off)
+	compressionCodec    string                  // Codec unary requests are compressed with
+	streamResumeOnDrain bool                    // Reopen server streams when their server drains
+	headerPolicy        *headerPolicy           // Strips outgoing headers (nil = allow all)
+	sampler             *sampler                // Samples unary calls (WithClientSampling)
+	monitor             *connectionMonitor      // Samples the connections (WithClientConnectionMonitor)
+	headers             nats.Header             // Sent under each call's OutgoingHeaders (WithHeaders)
+}
+
+// compress returns a unary request payload compressed as WithClientCompression
+// asks, flagged in headers
+func (c *ExampleServiceNatsClient) compress(data []byte, headers nats.Header) ([]byte, error) {
+	if c.compression == 0 {
+		return data, nil
+	}
+	codec := compressorFor(c.compressionCodec)
+	if codec == nil {
+		return nil, fmt.Errorf("WithClientCompression: codec %q is not registered; add it with RegisterCompressor", c.compressionCodec)
+	}
+	return compressPayload(codec, c.compression, data, headers), nil
+}
+
+// conn returns the connection for the next call or stream
+func (c *ExampleServiceNatsClient) conn() *nats.Conn {
+	if c.connSelector != nil {
+		return c.connSelector()
+	}
+	return c.nc
+}
+
+// outgoingHeaders returns the headers of ctx that WithOutgoingHeaderPolicy lets a call send
+func (c *ExampleServiceNatsClient) outgoingHeaders(ctx context.Context) nats.Header {
+	headers, _ := c.headerPolicy.filter(layerHeaders(c.headers, OutgoingHeaders(ctx)))
+	return headers
+}
+
+// WithHeaders returns a view of the client that sends headers with every call and
+// stream, beneath those set on the call's context with WithOutgoingHeaders. A view
+// shares the client's connections, interceptors, retry policy, journal and sampler
+// rather than copying them, so it is cheap to make per request, e.g. per tenant, and
+// like the client is safe for concurrent use. Views of views add to their parent's
+// headers. Closing a view does not stop the client's connection monitor.
+func (c *ExampleServiceNatsClient) WithHeaders(headers nats.Header) ExampleServiceNatsClientInterface {
+	layered := make(nats.Header, len(c.headers)+len(headers))
+	for name, values := range c.headers {
+		layered[name] = values
+	}
+	for name, values := range headers {
+		layered[name] = append([]string(nil), values...) // Later changes to headers stay out of the view
+	}
+	view := *c
+	view.headers = layered
+	view.monitor = nil
+	return &view
+}
+
+// keyToken renders a request field for a key template through the token sanitizer
+func (c *ExampleServiceNatsClient) keyToken(v any) string {
+	return c.tokenSanitizer(fmt.Sprint(v))
 }
 
 // NewExampleServiceNatsClient creates a new NATS client for ExampleService.
 // The client sends requests over NATS using protobuf serialization (or JSON if configured).
 func NewExampleServiceNatsClient(nc *nats.Conn, opts ...NatsClientOption) ExampleServiceNatsClientInterface {
 	cfg := &natsClientConfig{
-		subjectPrefix: "example_service",
+		subjectPrefix:  "example_service",
+		tokenSanitizer: SanitizeToken,
+		streamWindow:   defaultStreamWindow,
 	}
 	for _, opt := range opts {
 		opt.applyNatsClientOption(cfg)
@@ -561,30 +1011,78 @@ func NewExampleServiceNatsClient(nc *nats.Conn, opts ...NatsClientOption) Exampl
 	}
 
 	c := &ExampleServiceNatsClient{
-		nc:            nc,
-		subjectPrefix: cfg.subjectPrefix,
-		useJSON:       false,
-		interceptor:   chainedInterceptor,
-		js:            cfg.js,
+		nc:                  nc,
+		subjectPrefix:       cfg.subjectPrefix,
+		useJSON:             false,
+		interceptor:         chainedInterceptor,
+		streamInterceptor:   chainStreamClientInterceptors(cfg.streamInterceptors),
+		js:                  cfg.js,
+		kvStaleAfter:        cfg.kvStaleAfter,
+		cancelPropagation:   cfg.cancelPropagation,
+		timeout:             cfg.timeout,
+		retry:               newRetryPolicy(cfg),
+		tokenSanitizer:      cfg.tokenSanitizer,
+		idGenerator:         cfg.idGenerator,
+		maxResponseSize:     cfg.maxResponseSize,
+		connSelector:        cfg.connSelector,
+		journal:             cfg.journal,
+		streamWindow:        cfg.streamWindow,
+		streamAllowGaps:     cfg.streamAllowGaps,
+		streamCompression:   cfg.streamCompression,
+		compression:         cfg.compression,
+		compressionCodec:    cfg.compressionCodec,
+		streamResumeOnDrain: cfg.streamResumeOnDrain,
+		headerPolicy:        cfg.headerPolicy,
+		sampler:             newSampler(cfg.sampling, cfg.samplingSalt),
+		monitor:             startConnectionMonitor(cfg.connMonitor, monitoredConns(nc, cfg.poolConns)...),
 	}
 	return c
 }
 
+// NewExampleServiceNatsClientPool creates a ExampleService client that spreads calls
+// over conns round-robin (see RoundRobinConns). Streams stay on the connection they
+// opened on. A WithConnSelector in opts replaces the round-robin selector.
+func NewExampleServiceNatsClientPool(conns []*nats.Conn, opts ...NatsClientOption) ExampleServiceNatsClientInterface {
+	selector := RoundRobinConns(conns)
+	opts = append([]NatsClientOption{natsClientOptionFunc(func(c *natsClientConfig) { c.poolConns = conns })}, opts...)
+	return NewExampleServiceNatsClient(conns[0], append([]NatsClientOption{WithConnSelector(selector)}, opts...)...)
+}
+
 // Echo sends a Echo request to the service via NATS.
-// Returns an error if the request fails or the service returns an error.
-func (c *ExampleServiceNatsClient) Echo(ctx context.Context, req *EchoRequest) (*EchoResponse, error) {
+// Returns an error if the request fails or the service returns an error,
+// or a *TimeoutError if a WithCallTimeout/WithClientTimeout expires.
+func (c *ExampleServiceNatsClient) Echo(ctx context.Context, req *EchoRequest, opts ...CallOption) (*EchoResponse, error) {
 	method := "Echo"
 
-	// Pointer to store response headers - stored in context so invoker can update it
-	responseHeadersPtr := &nats.Header{}
+	// Sample the call for WithClientSampling under a request ID the service sees too
+	var requestID string
+	if c.sampler.enabled() {
+		ctx, requestID = withRequestID(ctx, c.idGenerator)
+	}
+	sample := c.sampler.begin("ExampleService", method, requestID, true, c.outgoingHeaders(ctx), req, false)
+
+	// Bound the call when the caller gave no deadline (or asked for a per-call timeout)
+	start := time.Now()
+	parentCtx := ctx
+	ctx, cancel, timeout := withCallTimeout(ctx, c.timeout, opts)
+	defer cancel()
 
-	// Add the response headers pointer to context so invoker can populate it
+	// Record sizes, attempts and duration for CallInfoFromContext
+	ctx, info := startCallInfo(ctx, "ExampleService", c.subjectPrefix+".echo")
+	defer info.finish()
+	ctx = withIDGenerator(ctx, c.idGenerator) // For NewID in interceptors
+
+	// Give the invoker somewhere in the context to store the response headers
 	// Interceptors can then read the headers from the same context
-	ctx = context.WithValue(ctx, responseHeadersKey, responseHeadersPtr)
+	ctx = WithResponseHeaders(ctx, nats.Header{})
 
 	// Define the invoker function that performs the actual NATS call
 	invoker := func(invokerCtx context.Context, method string, request, reply interface{}) error {
-		subject := c.subjectPrefix + ".echo"
+		// WithTargetInstance sends the call to one instance's own subject
+		subject, subjectErr := targetSubject(c.subjectPrefix+".echo", opts)
+		if subjectErr != nil {
+			return newExampleServiceError(ExampleServiceErrCodeInvalidArgument, method, subjectErr.Error(), nil)
+		}
 
 		// Marshal request
 		typedReq, ok := request.(*EchoRequest)
@@ -595,7 +1093,7 @@ func (c *ExampleServiceNatsClient) Echo(ctx context.Context, req *EchoRequest) (
 		var data []byte
 		var err error
 		if c.useJSON {
-			data, err = protojson.Marshal(typedReq)
+			data, err = marshalJSON(typedReq, false)
 		} else {
 			data, err = proto.Marshal(typedReq)
 		}
@@ -603,83 +1101,124 @@ func (c *ExampleServiceNatsClient) Echo(ctx context.Context, req *EchoRequest) (
 			return err
 		}
 
-		// Extract outgoing headers from context and attach to NATS message
-		var msg *nats.Msg
-		if headers := OutgoingHeaders(invokerCtx); headers != nil {
-			msg, err = c.nc.RequestMsgWithContext(invokerCtx, &nats.Msg{
-				Subject: subject,
-				Data:    data,
-				Header:  headers,
-			})
-		} else {
-			msg, err = c.nc.RequestWithContext(invokerCtx, subject, data)
+		// Extract outgoing headers from context and attach them, naming the codec, to the NATS message
+		nc := c.conn()
+		headers := withContentType(c.outgoingHeaders(invokerCtx), c.useJSON)
+		setDeadlineHeaders(invokerCtx, headers) // For deadline-aware scheduling and handler contexts
+		if data, err = c.compress(data, headers); err != nil {
+			return err
+		}
+		headers.Set(acceptEncodingHeader, compressorNames()) // Services compress responses only for clients that decompress them
+		if c.cancelPropagation {
+			var stop func() bool
+			headers, stop = propagateCancel(invokerCtx, nc, headers, mintID(c.idGenerator))
+			defer stop()
+		}
+
+		info.attempt(len(data))
+		msg, err := nc.RequestMsgWithContext(invokerCtx, &nats.Msg{
+			Subject: subject,
+			Data:    data,
+			Header:  headers,
+		})
+		if err != nil {
+			return err
 		}
+		info.received(len(msg.Data))
+		info.responder(msg.Header)
+		body, err := readPayload("response", msg.Header.Get(ContentEncodingHeader), msg.Data, c.maxResponseSize)
 		if err != nil {
 			return err
 		}
 
-		// Store response headers in the pointer from context
+		// Store response headers where ResponseHeaders reads them
 		if msg.Header != nil && len(msg.Header) > 0 {
-			if headersPtr, ok := invokerCtx.Value(responseHeadersKey).(*nats.Header); ok && headersPtr != nil {
-				*headersPtr = msg.Header
-			}
-		} // Check if this is an error response from the service
-		if msg.Header.Get("Status") != "" {
-			code := msg.Header.Get("Status")
-			description := msg.Header.Get("Description")
-			return &ExampleServiceError{
-				Code:    code,
-				Method:  method,
-				Message: description,
+			storeResponseHeaders(invokerCtx, msg.Header)
+		}
+
+		// Check if this is an error response from the service (NATS micro headers)
+		if msg.Header.Get("Nats-Service-Error-Code") != "" {
+			code := msg.Header.Get("Nats-Service-Error-Code")
+			description := msg.Header.Get("Nats-Service-Error")
+			var details []byte
+			if len(msg.Data) > 0 {
+				details = msg.Data
 			}
+			return newExampleServiceError(code, method, description, details)
 		}
 
-		// Unmarshal response
+		// Unmarshal response with the codec the service named, if any
 		typedReply, ok := reply.(*EchoResponse)
 		if !ok {
 			return fmt.Errorf("invalid reply type")
 		}
-
-		if c.useJSON {
-			err = protojson.Unmarshal(msg.Data, typedReply)
+		replyJSON, err := payloadUsesJSON(msg.Header.Get(ContentTypeHeader), c.useJSON)
+		if err != nil {
+			return err
+		}
+		if replyJSON {
+			err = protojson.Unmarshal(body, typedReply)
 		} else {
-			err = proto.Unmarshal(msg.Data, typedReply)
+			err = proto.Unmarshal(body, typedReply)
 		}
 		return err
 	}
 
 	var resp EchoResponse
 
-	// Execute through interceptor chain if configured
-	var err error
-	if c.interceptor != nil {
-		err = c.interceptor(ctx, method, req, &resp, invoker)
-	} else {
-		err = invoker(ctx, method, req, &resp)
+	// Execute through interceptor chain if configured, once per retry attempt
+	err := c.retry.do(ctx, func(ctx context.Context) error {
+		if c.interceptor != nil {
+			return c.interceptor(ctx, method, req, &resp, invoker)
+		}
+		return invoker(ctx, method, req, &resp)
+	})
+	sample.end(&resp, err)
+	if c.journal != nil {
+		c.journal.record("ExampleService", method, c.subjectPrefix+".echo", c.outgoingHeaders(parentCtx), req, c.useJSON, false, start, err)
 	}
-
 	if err != nil {
-		return nil, err
+		return nil, callTimeoutError(parentCtx, ctx, method, timeout, err)
 	}
 
 	return &resp, nil
 }
 
 // GetGreeting sends a GetGreeting request to the service via NATS.
-// Returns an error if the request fails or the service returns an error.
-func (c *ExampleServiceNatsClient) GetGreeting(ctx context.Context, req *GetGreetingRequest) (*GetGreetingResponse, error) {
+// Returns an error if the request fails or the service returns an error,
+// or a *TimeoutError if a WithCallTimeout/WithClientTimeout expires.
+func (c *ExampleServiceNatsClient) GetGreeting(ctx context.Context, req *GetGreetingRequest, opts ...CallOption) (*GetGreetingResponse, error) {
 	method := "GetGreeting"
 
-	// Pointer to store response headers - stored in context so invoker can update it
-	responseHeadersPtr := &nats.Header{}
+	// Sample the call for WithClientSampling under a request ID the service sees too
+	var requestID string
+	if c.sampler.enabled() {
+		ctx, requestID = withRequestID(ctx, c.idGenerator)
+	}
+	sample := c.sampler.begin("ExampleService", method, requestID, true, c.outgoingHeaders(ctx), req, false)
+
+	// Bound the call when the caller gave no deadline (or asked for a per-call timeout)
+	start := time.Now()
+	parentCtx := ctx
+	ctx, cancel, timeout := withCallTimeout(ctx, c.timeout, opts)
+	defer cancel()
 
-	// Add the response headers pointer to context so invoker can populate it
+	// Record sizes, attempts and duration for CallInfoFromContext
+	ctx, info := startCallInfo(ctx, "ExampleService", c.subjectPrefix+".get_greeting")
+	defer info.finish()
+	ctx = withIDGenerator(ctx, c.idGenerator) // For NewID in interceptors
+
+	// Give the invoker somewhere in the context to store the response headers
 	// Interceptors can then read the headers from the same context
-	ctx = context.WithValue(ctx, responseHeadersKey, responseHeadersPtr)
+	ctx = WithResponseHeaders(ctx, nats.Header{})
 
 	// Define the invoker function that performs the actual NATS call
 	invoker := func(invokerCtx context.Context, method string, request, reply interface{}) error {
-		subject := c.subjectPrefix + ".get_greeting"
+		// WithTargetInstance sends the call to one instance's own subject
+		subject, subjectErr := targetSubject(c.subjectPrefix+".get_greeting", opts)
+		if subjectErr != nil {
+			return newExampleServiceError(ExampleServiceErrCodeInvalidArgument, method, subjectErr.Error(), nil)
+		}
 
 		// Marshal request
 		typedReq, ok := request.(*GetGreetingRequest)
@@ -690,7 +1229,7 @@ func (c *ExampleServiceNatsClient) GetGreeting(ctx context.Context, req *GetGree
 		var data []byte
 		var err error
 		if c.useJSON {
-			data, err = protojson.Marshal(typedReq)
+			data, err = marshalJSON(typedReq, false)
 		} else {
 			data, err = proto.Marshal(typedReq)
 		}
@@ -698,68 +1237,115 @@ func (c *ExampleServiceNatsClient) GetGreeting(ctx context.Context, req *GetGree
 			return err
 		}
 
-		// Extract outgoing headers from context and attach to NATS message
-		var msg *nats.Msg
-		if headers := OutgoingHeaders(invokerCtx); headers != nil {
-			msg, err = c.nc.RequestMsgWithContext(invokerCtx, &nats.Msg{
-				Subject: subject,
-				Data:    data,
-				Header:  headers,
-			})
-		} else {
-			msg, err = c.nc.RequestWithContext(invokerCtx, subject, data)
+		// Extract outgoing headers from context and attach them, naming the codec, to the NATS message
+		nc := c.conn()
+		headers := withContentType(c.outgoingHeaders(invokerCtx), c.useJSON)
+		setDeadlineHeaders(invokerCtx, headers) // For deadline-aware scheduling and handler contexts
+		if data, err = c.compress(data, headers); err != nil {
+			return err
+		}
+		headers.Set(acceptEncodingHeader, compressorNames()) // Services compress responses only for clients that decompress them
+		if c.cancelPropagation {
+			var stop func() bool
+			headers, stop = propagateCancel(invokerCtx, nc, headers, mintID(c.idGenerator))
+			defer stop()
+		}
+
+		info.attempt(len(data))
+		msg, err := nc.RequestMsgWithContext(invokerCtx, &nats.Msg{
+			Subject: subject,
+			Data:    data,
+			Header:  headers,
+		})
+		if err != nil {
+			return err
 		}
+		info.received(len(msg.Data))
+		info.responder(msg.Header)
+		body, err := readPayload("response", msg.Header.Get(ContentEncodingHeader), msg.Data, c.maxResponseSize)
 		if err != nil {
 			return err
 		}
 
-		// Store response headers in the pointer from context
+		// Store response headers where ResponseHeaders reads them
 		if msg.Header != nil && len(msg.Header) > 0 {
-			if headersPtr, ok := invokerCtx.Value(responseHeadersKey).(*nats.Header); ok && headersPtr != nil {
-				*headersPtr = msg.Header
-			}
-		} // Check if this is an error response from the service
-		if msg.Header.Get("Status") != "" {
-			code := msg.Header.Get("Status")
-			description := msg.Header.Get("Description")
-			return &ExampleServiceError{
-				Code:    code,
-				Method:  method,
-				Message: description,
+			storeResponseHeaders(invokerCtx, msg.Header)
+		}
+
+		// Check if this is an error response from the service (NATS micro headers)
+		if msg.Header.Get("Nats-Service-Error-Code") != "" {
+			code := msg.Header.Get("Nats-Service-Error-Code")
+			description := msg.Header.Get("Nats-Service-Error")
+			var details []byte
+			if len(msg.Data) > 0 {
+				details = msg.Data
 			}
+			return newExampleServiceError(code, method, description, details)
 		}
 
-		// Unmarshal response
+		// Unmarshal response with the codec the service named, if any
 		typedReply, ok := reply.(*GetGreetingResponse)
 		if !ok {
 			return fmt.Errorf("invalid reply type")
 		}
-
-		if c.useJSON {
-			err = protojson.Unmarshal(msg.Data, typedReply)
+		replyJSON, err := payloadUsesJSON(msg.Header.Get(ContentTypeHeader), c.useJSON)
+		if err != nil {
+			return err
+		}
+		if replyJSON {
+			err = protojson.Unmarshal(body, typedReply)
 		} else {
-			err = proto.Unmarshal(msg.Data, typedReply)
+			err = proto.Unmarshal(body, typedReply)
 		}
 		return err
 	}
 
 	var resp GetGreetingResponse
 
-	// Execute through interceptor chain if configured
-	var err error
-	if c.interceptor != nil {
-		err = c.interceptor(ctx, method, req, &resp, invoker)
-	} else {
-		err = invoker(ctx, method, req, &resp)
+	// Execute through interceptor chain if configured, once per retry attempt
+	err := c.retry.do(ctx, func(ctx context.Context) error {
+		if c.interceptor != nil {
+			return c.interceptor(ctx, method, req, &resp, invoker)
+		}
+		return invoker(ctx, method, req, &resp)
+	})
+	sample.end(&resp, err)
+	if c.journal != nil {
+		c.journal.record("ExampleService", method, c.subjectPrefix+".get_greeting", c.outgoingHeaders(parentCtx), req, c.useJSON, false, start, err)
 	}
-
 	if err != nil {
-		return nil, err
+		return nil, callTimeoutError(parentCtx, ctx, method, timeout, err)
 	}
 
 	return &resp, nil
 }
 
+// Health calls the service's health endpoint. A service that reports HealthNotServing
+// returns a HealthResponse, not an error.
+func (c *ExampleServiceNatsClient) Health(ctx context.Context) (*HealthResponse, error) {
+	parentCtx := ctx
+	ctx, cancel, timeout := withCallTimeout(ctx, c.timeout, nil)
+	defer cancel()
+	resp, err := requestHealth(ctx, c.conn(), healthSubject(c.subjectPrefix, "example_service"))
+	if err != nil {
+		return nil, callTimeoutError(parentCtx, ctx, "Health", timeout, err)
+	}
+	return resp, nil
+}
+
+// Schema fetches the service's $schema document; see FetchServiceSchema
+func (c *ExampleServiceNatsClient) Schema(ctx context.Context) (*ServiceSchemaDocument, error) {
+	ctx, cancel, _ := withCallTimeout(ctx, c.timeout, nil)
+	defer cancel()
+	return FetchServiceSchema(ctx, c.conn(), schemaSubject(c.subjectPrefix, "example_service"))
+}
+
+// Close stops the client's connection monitor. The NATS connection stays open,
+// and the client can still be used.
+func (c *ExampleServiceNatsClient) Close() {
+	c.monitor.close()
+}
+
 // Endpoints returns information about all service endpoints this client can call.
 // This is useful for debugging, monitoring, and introspection.
 func (c *ExampleServiceNatsClient) Endpoints() []ExampleServiceEndpointInfo {
@@ -768,3 +1354,16 @@ func (c *ExampleServiceNatsClient) Endpoints() []ExampleServiceEndpointInfo {
 		{Name: "GetGreeting", Subject: c.subjectPrefix + ".get_greeting"},
 	}
 }
+
+// ExampleServiceSelfTest checks that the NATS connection works and that a running
+// ExampleService instance has registered every endpoint the client calls. Client
+// options such as WithNatsClientSubjectPrefix select the expected subjects.
+// Exit non-zero when the report is not OK to use it as a liveness or deployment hook.
+func ExampleServiceSelfTest(ctx context.Context, nc *nats.Conn, opts ...NatsClientOption) *SelfTestReport {
+	c := NewExampleServiceNatsClient(nc, opts...)
+	var endpoints []SelfTestEndpoint
+	for _, ep := range c.Endpoints() {
+		endpoints = append(endpoints, SelfTestEndpoint{Name: ep.Name, Subject: ep.Subject})
+	}
+	return runSelfTest(ctx, nc, "ExampleService", endpoints)
+}
@@ -1,4 +1,8 @@
 // Code generated by protoc-gen-nats-micro. DO NOT EDIT.
+// versions:
+// 	protoc-gen-nats-micro v0.3.0
+// 	protoc                (unknown)
+// source: kvstore_demo/v1/service.proto
 
 package v1
 
@@ -6,7 +10,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"log"
+	"io"
 	"os"
 	"time"
 	"github.com/nats-io/nats.go"
@@ -21,6 +25,21 @@ type KVStoreDemoServiceError struct {
 	Code    string // Error code (e.g., "INVALID_ARGUMENT", "NOT_FOUND", "INTERNAL")
 	Message string // Human-readable error message
 	Method  string // The method that failed
+	Details []byte // Optional error data sent with the error
+
+	status *Status // Returned by Unwrap (nil = built on each call)
+}
+
+// newKVStoreDemoServiceError creates an error that keeps its *Status, so Unwrap
+// returns the same value every time
+func newKVStoreDemoServiceError(code, method, message string, details []byte) *KVStoreDemoServiceError {
+	return &KVStoreDemoServiceError{
+		Code:    code,
+		Method:  method,
+		Message: message,
+		Details: details,
+		status:  &Status{Code: ParseCode(code), Message: message, Details: details},
+	}
 }
 
 func (e *KVStoreDemoServiceError) Error() string {
@@ -39,7 +58,17 @@ func (e *KVStoreDemoServiceError) NatsErrorMessage() string {
 
 // NatsErrorData returns optional error data (nil for basic errors)
 func (e *KVStoreDemoServiceError) NatsErrorData() []byte {
-	return nil
+	return e.Details
+}
+
+// Unwrap exposes the error as a *Status, so errors.As(err, &st) works on
+// client errors. Custom error codes map to CodeUnknown. Errors built as struct
+// literals get a new Status on each call.
+func (e *KVStoreDemoServiceError) Unwrap() error {
+	if e.status != nil {
+		return e.status
+	}
+	return &Status{Code: ParseCode(e.Code), Message: e.Message, Details: e.Details}
 }
 
 // Service-specific error code constants (use shared constants from service_shared_nats.pb.go)
@@ -106,37 +135,37 @@ func GetKVStoreDemoServiceErrorCode(err error) string {
 
 // NewKVStoreDemoServiceInvalidArgumentError creates a new invalid argument error
 func NewKVStoreDemoServiceInvalidArgumentError(method, message string) error {
-	return &KVStoreDemoServiceError{Code: KVStoreDemoServiceErrCodeInvalidArgument, Method: method, Message: message}
+	return newKVStoreDemoServiceError(KVStoreDemoServiceErrCodeInvalidArgument, method, message, nil)
 }
 
 // NewKVStoreDemoServiceNotFoundError creates a new not found error
 func NewKVStoreDemoServiceNotFoundError(method, message string) error {
-	return &KVStoreDemoServiceError{Code: KVStoreDemoServiceErrCodeNotFound, Method: method, Message: message}
+	return newKVStoreDemoServiceError(KVStoreDemoServiceErrCodeNotFound, method, message, nil)
 }
 
 // NewKVStoreDemoServiceAlreadyExistsError creates a new already exists error
 func NewKVStoreDemoServiceAlreadyExistsError(method, message string) error {
-	return &KVStoreDemoServiceError{Code: KVStoreDemoServiceErrCodeAlreadyExists, Method: method, Message: message}
+	return newKVStoreDemoServiceError(KVStoreDemoServiceErrCodeAlreadyExists, method, message, nil)
 }
 
 // NewKVStoreDemoServicePermissionDeniedError creates a new permission denied error
 func NewKVStoreDemoServicePermissionDeniedError(method, message string) error {
-	return &KVStoreDemoServiceError{Code: KVStoreDemoServiceErrCodePermissionDenied, Method: method, Message: message}
+	return newKVStoreDemoServiceError(KVStoreDemoServiceErrCodePermissionDenied, method, message, nil)
 }
 
 // NewKVStoreDemoServiceUnauthenticatedError creates a new unauthenticated error
 func NewKVStoreDemoServiceUnauthenticatedError(method, message string) error {
-	return &KVStoreDemoServiceError{Code: KVStoreDemoServiceErrCodeUnauthenticated, Method: method, Message: message}
+	return newKVStoreDemoServiceError(KVStoreDemoServiceErrCodeUnauthenticated, method, message, nil)
 }
 
 // NewKVStoreDemoServiceInternalError creates a new internal error
 func NewKVStoreDemoServiceInternalError(method, message string) error {
-	return &KVStoreDemoServiceError{Code: KVStoreDemoServiceErrCodeInternal, Method: method, Message: message}
+	return newKVStoreDemoServiceError(KVStoreDemoServiceErrCodeInternal, method, message, nil)
 }
 
 // NewKVStoreDemoServiceUnavailableError creates a new unavailable error
 func NewKVStoreDemoServiceUnavailableError(method, message string) error {
-	return &KVStoreDemoServiceError{Code: KVStoreDemoServiceErrCodeUnavailable, Method: method, Message: message}
+	return newKVStoreDemoServiceError(KVStoreDemoServiceErrCodeUnavailable, method, message, nil)
 }
 
 // KVStoreDemoServiceNats is the NATS service interface for KVStoreDemoService
@@ -148,8 +177,10 @@ type KVStoreDemoServiceNats interface {
 
 // KVStoreDemoServiceEndpointInfo describes a service endpoint
 type KVStoreDemoServiceEndpointInfo struct {
-	Name    string `json:"name"`    // Method name (e.g., "CreateProduct")
-	Subject string `json:"subject"` // NATS subject (e.g., "api.v1.create_product")
+	Name           string `json:"name"`                       // Method name (e.g., "CreateProduct")
+	Subject        string `json:"subject"`                    // NATS subject (e.g., "api.v1.create_product")
+	QueueGroup     string `json:"queue_group,omitempty"`      // Queue group the endpoint joined (server only)
+	MaxRequestSize int    `json:"max_request_size,omitempty"` // Request payload limit in bytes (server only, 0 = unlimited)
 }
 
 // KVStoreDemoServiceService is the interface for the registered NATS micro service
@@ -157,58 +188,248 @@ type KVStoreDemoServiceEndpointInfo struct {
 type KVStoreDemoServiceService interface {
 	micro.Service
 	Endpoints() []KVStoreDemoServiceEndpointInfo
+	// Drain stops accepting requests, waits until in-flight handlers finish or
+	// ctx ends, and stops the service
+	Drain(ctx context.Context) error
+	// InterceptorChain names the interceptors a method's requests pass through,
+	// outermost first
+	InterceptorChain(method string) []string
+	// Reconfigure changes the settings that can change while the service runs,
+	// currently WithSampling and WithSamplingSalt
+	Reconfigure(opts ...RegisterOption) error
 }
 
 // kVStoreDemoServiceService is the concrete implementation of KVStoreDemoServiceService
 type kVStoreDemoServiceService struct {
 	micro.Service
-	subjectPrefix string
+	subjectPrefix  string
+	queueGroup     string
+	maxRequestSize int
+	inflight       *inflightTracker
+	interceptors   []string // Names of the service-wide interceptors, outermost first
+	sampler        *sampler // Shared with the handlers, for Reconfigure
 }
 
 // Endpoints returns information about all service endpoints
 // This is useful for debugging, monitoring, and service discovery
 func (s *kVStoreDemoServiceService) Endpoints() []KVStoreDemoServiceEndpointInfo {
 	return []KVStoreDemoServiceEndpointInfo{
-		{Name: "SaveProfile", Subject: s.subjectPrefix + ".save_profile"},
-		{Name: "GetProfile", Subject: s.subjectPrefix + ".get_profile"},
-		{Name: "GenerateReport", Subject: s.subjectPrefix + ".generate_report"},
+		{Name: "SaveProfile", Subject: s.subjectPrefix + ".save_profile", QueueGroup: s.queueGroup, MaxRequestSize: s.maxRequestSize},
+		{Name: "GetProfile", Subject: s.subjectPrefix + ".get_profile", QueueGroup: s.queueGroup, MaxRequestSize: s.maxRequestSize},
+		{Name: "GenerateReport", Subject: s.subjectPrefix + ".generate_report", QueueGroup: s.queueGroup, MaxRequestSize: s.maxRequestSize},
+	}
+}
+
+// init lists the methods of KVStoreDemoService in MethodDescriptors
+func init() {
+	MethodDescriptors["kvstore_demo.v1.KVStoreDemoService.SaveProfile"] = MethodDescriptor{
+		Service: "KVStoreDemoService",
+		Method:  "SaveProfile",
+		Subject: "api.v1.kvdemo.save_profile",
+	}
+	MethodDescriptors["kvstore_demo.v1.KVStoreDemoService.GetProfile"] = MethodDescriptor{
+		Service: "KVStoreDemoService",
+		Method:  "GetProfile",
+		Subject: "api.v1.kvdemo.get_profile",
+	}
+	MethodDescriptors["kvstore_demo.v1.KVStoreDemoService.GenerateReport"] = MethodDescriptor{
+		Service: "KVStoreDemoService",
+		Method:  "GenerateReport",
+		Subject: "api.v1.kvdemo.generate_report",
+	}
+}
+
+// KVStoreDemoServiceSubjects maps each method of KVStoreDemoService to its subject under
+// the default prefix. Clients and services of every language are generated from
+// the same table.
+var KVStoreDemoServiceSubjects = map[string]string{
+	"SaveProfile":    "api.v1.kvdemo.save_profile",
+	"GetProfile":     "api.v1.kvdemo.get_profile",
+	"GenerateReport": "api.v1.kvdemo.generate_report",
+}
+
+// Drain unsubscribes every endpoint, so new requests get no responders, then waits
+// for in-flight handlers, and requests already delivered to the endpoints, to
+// finish before returning. Server and bidi streams are
+// sent a GOAWAY, which clients see as an *ErrServerDraining, and streaming handlers
+// have their context canceled right away, or after WithStreamDrainGrace, so
+// long-running streams can end cleanly. If ctx ends first, the remaining handlers'
+// contexts are canceled and ctx.Err() is returned. The service is stopped either way.
+// Drain fails if nats.go's micro left an endpoint subscribed; see stopEndpoints.
+func (s *kVStoreDemoServiceService) Drain(ctx context.Context) error {
+	return s.inflight.drain(ctx, func() error { return stopEndpoints(s.Service) })
+}
+
+// InterceptorChain returns the names of the interceptors that requests to
+// method, a Go method name, pass through, outermost first: the service's
+// interceptors in the order they were added, then the method's
+// (natsmicro.endpoint).middlewares. Interceptors are named with Named, or else
+// by their function name.
+func (s *kVStoreDemoServiceService) InterceptorChain(method string) []string {
+	chain := append([]string(nil), s.interceptors...)
+	return chain
+}
+
+// Reconfigure replaces the service's WithSampling and WithSamplingSalt settings
+// with those in opts, so sampling can be turned up, down or off without
+// re-registering; leaving WithSampling out stops sampling. Any other option is
+// rejected, as it only takes effect at registration.
+func (s *kVStoreDemoServiceService) Reconfigure(opts ...RegisterOption) error {
+	cfg := &registerConfig{}
+	for _, opt := range opts {
+		opt(cfg)
 	}
+	return reconfigureSampling(s.sampler, cfg)
 }
 
+// KVStoreDemoServiceSchemaHash identifies the schema KVStoreDemoService was generated from.
+// Services advertise it as schema_hash INFO metadata; see SchemaHash.
+const KVStoreDemoServiceSchemaHash = "sha256:784099d45d3162384f8664e23efdf9f9d27eaa46d2ce541eb0df057e35fd9021"
+
+// kVStoreDemoServiceSchema is the FileDescriptorSet KVStoreDemoService serves from its
+// $reflect endpoint. The schema endpoint metadata is read from it too, so the two
+// always agree.
+const kVStoreDemoServiceSchema = "\n\xb9\b\n\x1dkvstore_demo/v1/service.proto\x12\x0fkvstore_demo.v1\x1a\x17natsmicro/options.proto\"`\n\x12SaveProfileRequest\x12\x0e\n\x02id\x18\x01 \x01(\tR\x02id\x12\x12\n\x04name\x18\x02 \x01(\tR\x04name\x12\x14\n\x05email\x18\x03 \x01(\tR\x05email\x12\x10\n\x03bio\x18\x04 \x01(\tR\x03bio\"#\n\x11GetProfileRequest\x12\x0e\n\x02id\x18\x01 \x01(\tR\x02id\"|\n\x0fProfileResponse\x12\x0e\n\x02id\x18\x01 \x01(\tR\x02id\x12\x12\n\x04name\x18\x02 \x01(\tR\x04name\x12\x14\n\x05email\x18\x03 \x01(\tR\x05email\x12\x10\n\x03bio\x18\x04 \x01(\tR\x03bio\x12\x1d\n\nupdated_at\x18\x05 \x01(\tR\tupdatedAt\"U\n\x15GenerateReportRequest\x12\x0e\n\x02id\x18\x01 \x01(\tR\x02id\x12\x14\n\x05title\x18\x02 \x01(\tR\x05title\x12\x16\n\x06format\x18\x03 \x01(\tR\x06format\"\x92\x01\n\x0eReportResponse\x12\x0e\n\x02id\x18\x01 \x01(\tR\x02id\x12\x14\n\x05title\x18\x02 \x01(\tR\x05title\x12\x18\n\acontent\x18\x03 \x01(\fR\acontent\x12!\n\fcontent_type\x18\x04 \x01(\tR\vcontentType\x12\x1d\n\nsize_bytes\x18\x05 \x01(\x03R\tsizeBytes2\xd5\x03\n\x12KVStoreDemoService\x12|\n\vSaveProfile\x12#.kvstore_demo.v1.SaveProfileRequest\x1a .kvstore_demo.v1.ProfileResponse\"&\x92\xb5\x18\x04\n\x02\b\x05\x9a\xb5\x18\x1a\n\ruser_profiles\x12\tuser.{id}\x12\\\n\nGetProfile\x12\".kvstore_demo.v1.GetProfileRequest\x1a .kvstore_demo.v1.ProfileResponse\"\b\x92\xb5\x18\x04\n\x02\b\x05\x12}\n\x0eGenerateReport\x12&.kvstore_demo.v1.GenerateReportRequest\x1a\x1f.kvstore_demo.v1.ReportResponse\"\"\x92\xb5\x18\x04\n\x02\b\x1e\xa2\xb5\x18\x16\n\areports\x12\vreport.{id}\x1ad\x8a\xb5\x18`\n\rapi.v1.kvdemo\x12\x14kvstore_demo_service\x1a\x051.0.0\"2Demonstrates KV Store and Object Store integrationB\x1dZ\x1bexample/gen/kvstore_demo/v1b\x06proto3"
+
+// kVStoreDemoServiceSchemaDocument is the JSON KVStoreDemoService serves from its
+// $schema endpoint, before registration fills in its name, version and subjects
+const kVStoreDemoServiceSchemaDocument = "{\"service\":\"kvstore_demo.v1.KVStoreDemoService\",\"schema_hash\":\"sha256:784099d45d3162384f8664e23efdf9f9d27eaa46d2ce541eb0df057e35fd9021\",\"methods\":[{\"name\":\"SaveProfile\",\"endpoint\":\"save_profile\",\"streaming\":\"unary\",\"request_type\":\"kvstore_demo.v1.SaveProfileRequest\",\"response_type\":\"kvstore_demo.v1.ProfileResponse\"},{\"name\":\"GetProfile\",\"endpoint\":\"get_profile\",\"streaming\":\"unary\",\"request_type\":\"kvstore_demo.v1.GetProfileRequest\",\"response_type\":\"kvstore_demo.v1.ProfileResponse\"},{\"name\":\"GenerateReport\",\"endpoint\":\"generate_report\",\"streaming\":\"unary\",\"request_type\":\"kvstore_demo.v1.GenerateReportRequest\",\"response_type\":\"kvstore_demo.v1.ReportResponse\"}],\"descriptor\":\"H4sIAAAAAAAC/6xUzW7TQBBW/tpk0qawCsUyChhXqjg5TXkCKqQeegAl0ANCcjfxtFoa75rdiUWgfQGOPXLkHXLgQXgftLYDaZIiDtxmvh19M+PvG8OPOnQuU0NKYxhhrLppr2tQp2KEQaIVKbaz+BykPfeh5GRiMdKqqxISSpq80j8DNuApvtbqXIyxjx8naIi1oCwip+SVnjX6ZRExBlXJY3TKGZLFrA01jLkYO5UMzBN2DypDoZxqhtnQ34P7x0h/b+Bfwc7vCpMoafB/zsA6AJMk4oRRyMmpZQ+NAnlB/lt4cIwSNSfsY6I03fUd2lAjQeP5EHnCdmHjXOmYUzFGkfk3JWjN+e5Yaj2hA5sjJQllzrjVn6fsKWwVYUjTBIsdmwX2Zpqg3dWIzxgOp4Qm27XSb1jkyAKHPyvATk4H1h0vMVaD3DfsCpoLPmB7wZKDglWXuN5K0ZKG/v7NzKlCuV77NnNc2J4Y1GGS1xjWsGnwRUTX7D3AH48wf4V3xUD/0Ls+782uoXVbXra/psMa/d0nK3W39fT9osnj7zNnFzZ19mpYMw+y5dzo68w5g22eCEtwmVoq1l4kDovrdWu94CA48A+tMtKQHch4J6deJpfHZeS9Gn7AERWAkIQXmtt7Puq8e4SfeJyMsXuBsrv0fxhuZOf+/NcA6sa3lDwEAAA=\"}"
+
 // RegisterKVStoreDemoServiceHandlers registers the service with NATS micro handlers
 // Service: kvstore_demo_service v1.0.0
 // Description: Demonstrates KV Store and Object Store integration
 // Subject prefix: api.v1.kvdemo
-// Configuration options: WithName(), WithVersion(), WithDescription(), WithSubjectPrefix(), WithTimeout()
-// Metadata options: WithMetadata() (replace), WithAdditionalMetadata() (merge)
+// Configuration options: WithName(), WithVersion(), WithDescription(), WithSubjectPrefix(), WithTimeout(), WithQueueGroup(), WithMaxRequestSize()
+// Metadata options: WithMetadata() (replace), WithAdditionalMetadata() (merge), WithEndpointMetadata() (per method)
 // Handler options: WithStatsHandler(), WithDoneHandler(), WithErrorHandler()
+// Interceptors: WithServerInterceptor() appends, WithServerInterceptorChain() replaces; the first runs outermost
+// Stream interceptors: WithServerStreamInterceptor()
+// Grouping: WithServiceGroup() registers on a ServiceGroup shared with other services
+// Existing services: AddKVStoreDemoServiceEndpoints() registers on a micro.Service added elsewhere
 //
 // Endpoint metadata can be configured via proto options (nats.micro.endpoint).metadata
-func RegisterKVStoreDemoServiceHandlers(nc *nats.Conn, impl KVStoreDemoServiceNats, opts ...RegisterOption) (KVStoreDemoServiceService, error) {
+func RegisterKVStoreDemoServiceHandlers(nc *nats.Conn, impl KVStoreDemoServiceNats, opts ...RegisterOption) (_ KVStoreDemoServiceService, err error) {
 	cfg := &registerConfig{
-		name:          "kvstore_demo_service",
-		version:       "1.0.0",
-		description:   "Demonstrates KV Store and Object Store integration",
-		subjectPrefix: "api.v1.kvdemo",
-		timeout:       0 * time.Second, // Service-level timeout (0 = no timeout)
-		metadata:      map[string]string{},
+		name:           "kvstore_demo_service",
+		version:        "1.0.0",
+		description:    "Demonstrates KV Store and Object Store integration",
+		subjectPrefix:  "api.v1.kvdemo",
+		queueGroup:     "",
+		timeout:        0 * time.Second, // Service-level timeout (0 = no timeout)
+		tokenSanitizer: SanitizeToken,
+		streamWindow:   defaultStreamWindow,
+		metadata:       map[string]string{},
 	}
 	for _, opt := range opts {
 		opt(cfg)
 	}
+	return registerKVStoreDemoServiceEndpoints(nc, impl, cfg, func() (*serviceHost, bool, error) {
+		// Register on the ServiceGroup, or add a micro.Service for this service
+		if cfg.group != nil {
+			return cfg.group.host, true, nil
+		}
+		host, err := addServiceHost(nc, cfg, mergeMetadata(cfg.metadata, map[string]string{"schema_hash": KVStoreDemoServiceSchemaHash}))
+		return host, false, err
+	})
+}
 
-	svc, err := micro.AddService(nc, micro.Config{
-		Name:         cfg.name,
-		Version:      cfg.version,
-		Metadata:     cfg.metadata,
-		Description:  cfg.description,
-		StatsHandler: cfg.statsHandler,
-		DoneHandler:  cfg.doneHandler,
-		ErrorHandler: cfg.errorHandler,
+// AddKVStoreDemoServiceEndpoints registers the KVStoreDemoService endpoints on svc, a
+// micro.Service the caller added on nc, so they share its name, version and stats
+// with the endpoints of other services and the caller's own:
+//
+//	svc, err := micro.AddService(nc, micro.Config{Name: "catalog", Version: "1.0.0"})
+//	...
+//	err = AddKVStoreDemoServiceEndpoints(nc, svc, impl, WithServerInterceptor(auth))
+//
+// As on a ServiceGroup, endpoint names are qualified with the service name
+// ("kv_store_demo_service-health") while subjects keep the subject prefix, and the
+// schema_hash is advertised as endpoint metadata. Options of the micro.Service
+// itself, which NewServiceGroup lists, are rejected with an error, as is
+// WithServiceGroup. Stopping svc stops the endpoints; there is no Drain.
+func AddKVStoreDemoServiceEndpoints(nc *nats.Conn, svc micro.Service, impl KVStoreDemoServiceNats, opts ...RegisterOption) error {
+	cfg := &registerConfig{
+		subjectPrefix:  "api.v1.kvdemo",
+		queueGroup:     "",
+		timeout:        0 * time.Second, // Service-level timeout (0 = no timeout)
+		tokenSanitizer: SanitizeToken,
+		streamWindow:   defaultStreamWindow,
+		metadata:       map[string]string{},
+	}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	host, err := existingServiceHost(nc, svc, cfg)
+	if err != nil {
+		return err
+	}
+	// The subjects and $schema document describe the proto service
+	cfg.name, cfg.version = "kvstore_demo_service", "1.0.0"
+	_, err = registerKVStoreDemoServiceEndpoints(nc, impl, cfg, func() (*serviceHost, bool, error) {
+		return host, true, nil
 	})
+	return err
+}
+
+// registerKVStoreDemoServiceEndpoints registers the KVStoreDemoService endpoints cfg describes
+// on the host attach returns, once the configuration checks out. Endpoint names
+// are qualified when attach reports the host is shared with other services.
+func registerKVStoreDemoServiceEndpoints(nc *nats.Conn, impl KVStoreDemoServiceNats, cfg *registerConfig, attach func() (*serviceHost, bool, error)) (_ KVStoreDemoServiceService, err error) {
+
+	// Describe each endpoint from the schema blob
+	schemaMetadata, err := schemaEndpointMetadata(kVStoreDemoServiceSchema, "kvstore_demo.v1.KVStoreDemoService")
 	if err != nil {
-		return nil, fmt.Errorf("failed to add service: %w", err)
+		return nil, err
 	}
+	endpointMethods := map[string]bool{
+		"SaveProfile":    true,
+		"GetProfile":     true,
+		"GenerateReport": true,
+	}
+	for method := range cfg.endpointMetadata {
+		if !endpointMethods[method] {
+			return nil, fmt.Errorf("WithEndpointMetadata: KVStoreDemoService has no method %s", method)
+		}
+	}
+
+	// Streams are compressed with gzip unless WithCompression names another codec
+	compressor := compressorFor(streamEncodingGzip)
+	if cfg.compressionCodec != "" {
+		if compressor = compressorFor(cfg.compressionCodec); compressor == nil {
+			return nil, fmt.Errorf("WithCompression: codec %q is not registered; add it with RegisterCompressor", cfg.compressionCodec)
+		}
+	}
+
+	// Provision the KV and Object Store buckets the method options declare before
+	// anything subscribes, or only check them with WithoutBucketProvisioning
+	if cfg.js != nil {
+		if err := provisionBuckets(context.Background(), cfg.js, cfg.noBucketProvisioning, []bucketSpec{
+			{methods: "SaveProfile", kv: &jetstream.KeyValueConfig{
+				Bucket: "user_profiles",
+			}},
+			{methods: "GenerateReport", obj: &jetstream.ObjectStoreConfig{
+				Bucket: "reports",
+			}},
+		}); err != nil {
+			return nil, err
+		}
+	}
+
+	host, shared, err := attach()
+	if err != nil {
+		return nil, err
+	}
+	endpointPrefix := "" // Qualifies endpoint names on a shared micro.Service
+	if shared {
+		endpointPrefix = "kv_store_demo_service-"
+	} else {
+		// Stop the micro.Service again if an endpoint fails to register
+		defer func() {
+			if err != nil {
+				host.svc.Stop()
+			}
+		}()
+	}
+	svc, pool := host.svc, host.pool
 
 	// Chain server interceptors
 	var chainedInterceptor UnaryServerInterceptor
@@ -217,31 +438,67 @@ func RegisterKVStoreDemoServiceHandlers(nc *nats.Conn, impl KVStoreDemoServiceNa
 	}
 
 	handlers := &kVStoreDemoServiceHandlers{
-		impl:           impl,
-		serviceTimeout: cfg.timeout,
-		useJSON:        false,
-		interceptor:    chainedInterceptor,
-		js:             cfg.js,
-	}
-
-	// Map of endpoint names to their handlers
+		nc:                nc,
+		impl:              impl,
+		serviceTimeout:    cfg.timeout,
+		useJSON:           false,
+		interceptor:       chainedInterceptor,
+		js:                cfg.js,
+		cancels:           host.cancels,
+		tokenSanitizer:    cfg.tokenSanitizer,
+		idGenerator:       cfg.idGenerator,
+		maxRequestSize:    cfg.maxRequestSize,
+		streamWindow:      cfg.streamWindow,
+		streamAllowGaps:   cfg.streamAllowGaps,
+		streamCompression: cfg.streamCompression,
+		compression:       cfg.compression,
+		compressor:        compressor,
+		responseHeaders:   cfg.responseHeaders,
+		requestCheck:      cfg.requestCheck,
+		streamInterceptor: chainStreamServerInterceptors(cfg.streamInterceptors),
+		endpointPrefix:    endpointPrefix,
+		inflight:          host.inflight,
+		sampler:           newSampler(cfg.sampling, cfg.samplingSalt),
+		maxServerDeadline: cfg.maxServerDeadline,
+		persistenceErrors: cfg.persistenceErrors,
+		panics:            cfg.panicDiagnostics,
+		failures:          newFailureRecorder(cfg),
+	}
+
+	// Map of endpoint names to their handlers; unary ones go through the handler pool,
+	// idempotent ones are coalesced first, and those expecting a response are
+	// deduplicated by idempotency key inside the pool
+	idempotency := newIdempotencyGuard(cfg)
 	endpoints := map[string]micro.Handler{
 
-		"save_profile": micro.HandlerFunc(handlers.SaveProfile),
+		"save_profile": pool.wrap(endpointPrefix+"save_profile", idempotency.wrap(endpointPrefix+"save_profile", micro.HandlerFunc(handlers.SaveProfile))),
 
-		"get_profile": micro.HandlerFunc(handlers.GetProfile),
+		"get_profile": pool.wrap(endpointPrefix+"get_profile", idempotency.wrap(endpointPrefix+"get_profile", micro.HandlerFunc(handlers.GetProfile))),
 
-		"generate_report": micro.HandlerFunc(handlers.GenerateReport),
+		"generate_report": pool.wrap(endpointPrefix+"generate_report", idempotency.wrap(endpointPrefix+"generate_report", micro.HandlerFunc(handlers.GenerateReport))),
 	}
 
-	// Map of endpoint names to their metadata
+	// Map of endpoint names to their metadata: the schema's description of the
+	// method, overlaid with (natsmicro.endpoint).metadata, then WithEndpointMetadata
 	endpointMetadata := map[string]map[string]string{
 
-		"save_profile": {},
+		"save_profile": mergeMetadata(mergeMetadata(schemaMetadata["SaveProfile"], map[string]string{}), cfg.endpointMetadata["SaveProfile"]),
 
-		"get_profile": {},
+		"get_profile": mergeMetadata(mergeMetadata(schemaMetadata["GetProfile"], map[string]string{}), cfg.endpointMetadata["GetProfile"]),
 
-		"generate_report": {},
+		"generate_report": mergeMetadata(mergeMetadata(schemaMetadata["GenerateReport"], map[string]string{}), cfg.endpointMetadata["GenerateReport"]),
+	}
+
+	// Map of endpoint names to exact subjects from (natsmicro.endpoint).subject,
+	// or the wildcard form of subject_template
+	// These endpoints are registered outside the subject prefix group
+	endpointSubjects := map[string]string{}
+
+	// Endpoints WithInstanceSubjects also serves on <subject>.<instance ID>
+	instanceEndpoints := map[string]bool{
+		"save_profile":    true,
+		"get_profile":     true,
+		"generate_report": true,
 	}
 
 	// Use interface to handle both Service and Group
@@ -249,35 +506,146 @@ func RegisterKVStoreDemoServiceHandlers(nc *nats.Conn, impl KVStoreDemoServiceNa
 		AddEndpoint(string, micro.Handler, ...micro.EndpointOpt) error
 	}
 
-	var adder endpointAdder = svc
+	var group endpointAdder = svc
 	if cfg.subjectPrefix != "" {
-		adder = svc.AddGroup(cfg.subjectPrefix)
+		group = svc.AddGroup(cfg.subjectPrefix)
 	}
 
 	// Register all endpoints with their metadata
 	for name, handler := range endpoints {
 		opts := []micro.EndpointOpt{}
-		if metadata, exists := endpointMetadata[name]; exists && len(metadata) > 0 {
+		metadata := endpointMetadata[name]
+		if cfg.maxRequestSize > 0 {
+			// Advertise the request size limit alongside the proto metadata
+			withLimit := map[string]string{"max_request_size": fmt.Sprint(cfg.maxRequestSize)}
+			for k, v := range metadata {
+				withLimit[k] = v
+			}
+			metadata = withLimit
+		}
+		if shared {
+			// Keep the unqualified subject; the shared service's metadata cannot
+			// carry every service's schema hash
+			opts = append(opts, micro.WithEndpointSubject(name))
+			metadata = mergeMetadata(metadata, map[string]string{"schema_hash": KVStoreDemoServiceSchemaHash})
+			if cfg.queueGroup != "" {
+				opts = append(opts, micro.WithEndpointQueueGroup(cfg.queueGroup))
+			}
+		}
+		if len(metadata) > 0 {
 			opts = append(opts, micro.WithEndpointMetadata(metadata))
 		}
-		if err := adder.AddEndpoint(name, handler, opts...); err != nil {
+		adder := group
+		subject := name
+		if exact, exists := endpointSubjects[name]; exists {
+			adder = svc
+			subject = exact
+			opts = append(opts, micro.WithEndpointSubject(subject))
+		}
+		if cfg.instanceSubjects && instanceEndpoints[name] {
+			// Responses name the instance, for ResponderInstance
+			handler = respondAsInstance(svc.Info().ID, handler)
+		}
+		if err := adder.AddEndpoint(endpointPrefix+name, holdRequests(cfg.hold, handler), opts...); err != nil {
 			return nil, fmt.Errorf("failed to add endpoint %s: %w", name, err)
 		}
+		if cfg.instanceSubjects && instanceEndpoints[name] {
+			// The same handler on this instance's own subject, for WithTargetInstance
+			opts = append(opts, micro.WithEndpointSubject(subject+"."+svc.Info().ID))
+			if err := adder.AddEndpoint(endpointPrefix+name+"-instance", holdRequests(cfg.hold, handler), opts...); err != nil {
+				return nil, fmt.Errorf("failed to add instance endpoint %s: %w", name, err)
+			}
+		}
+	}
+
+	pool.start()
+
+	// Application health endpoint, registered outside the subject prefix group
+	if !cfg.noHealthEndpoint {
+		subject := healthSubject(cfg.subjectPrefix, "kv_store_demo_service")
+		if err := svc.AddEndpoint(endpointPrefix+"health", holdRequests(cfg.hold, newHealthHandler(impl, cfg.timeout)), micro.WithEndpointSubject(subject)); err != nil {
+			return nil, fmt.Errorf("failed to add health endpoint: %w", err)
+		}
+	}
+
+	// Schema reflection endpoint, registered outside the subject prefix group
+	if !cfg.noReflectEndpoint {
+		subject := reflectSubject(cfg.subjectPrefix, "kv_store_demo_service")
+		if err := svc.AddEndpoint(endpointPrefix+"reflect", holdRequests(cfg.hold, newReflectHandler(kVStoreDemoServiceSchema, KVStoreDemoServiceSchemaHash)), micro.WithEndpointSubject(subject)); err != nil {
+			return nil, fmt.Errorf("failed to add reflect endpoint: %w", err)
+		}
+	}
+
+	// Schema document endpoint, registered outside the subject prefix group
+	if !cfg.noSchemaEndpoint {
+		handler, err := newSchemaHandler(kVStoreDemoServiceSchemaDocument, cfg)
+		if err != nil {
+			return nil, err
+		}
+		subject := schemaSubject(cfg.subjectPrefix, "kv_store_demo_service")
+		if err := svc.AddEndpoint(endpointPrefix+"schema", holdRequests(cfg.hold, handler), micro.WithEndpointSubject(subject)); err != nil {
+			return nil, fmt.Errorf("failed to add schema endpoint: %w", err)
+		}
+	}
+
+	queueGroup := cfg.queueGroup
+	if queueGroup == "" {
+		queueGroup = micro.DefaultQueueGroup
 	}
 
 	return &kVStoreDemoServiceService{
-		Service:       svc,
-		subjectPrefix: cfg.subjectPrefix,
+		Service:        svc,
+		subjectPrefix:  cfg.subjectPrefix,
+		queueGroup:     queueGroup,
+		maxRequestSize: cfg.maxRequestSize,
+		inflight:       handlers.inflight,
+		interceptors:   interceptorChainNames(cfg.serverInterceptors),
+		sampler:        handlers.sampler,
 	}, nil
 }
 
+// KVStoreDemoServiceRegistration describes a RegisterKVStoreDemoServiceHandlers call for
+// RegisterGroup, which registers it together with other services, all or nothing
+func KVStoreDemoServiceRegistration(impl KVStoreDemoServiceNats, opts ...RegisterOption) Registration {
+	return registrationFunc(func(nc *nats.Conn, hold func(context.Context) error) (GroupService, error) {
+		return RegisterKVStoreDemoServiceHandlers(nc, impl, append(opts[:len(opts):len(opts)], withRequestHold(hold))...)
+	})
+}
+
 // kVStoreDemoServiceHandlers wraps the service implementation with NATS handlers
 type kVStoreDemoServiceHandlers struct {
-	impl           KVStoreDemoServiceNats
-	serviceTimeout time.Duration          // Default timeout for all endpoints
-	useJSON        bool                   // Use JSON encoding instead of binary protobuf
-	interceptor    UnaryServerInterceptor // Chained interceptors
-	js             jetstream.JetStream    // Optional JetStream context for KV/ObjectStore
+	nc                 *nats.Conn // NATS connection for streaming
+	impl               KVStoreDemoServiceNats
+	serviceTimeout     time.Duration                     // Default timeout for all endpoints
+	useJSON            bool                              // Use JSON encoding instead of binary protobuf
+	interceptor        UnaryServerInterceptor            // Chained interceptors
+	methodInterceptors map[string]UnaryServerInterceptor // Interceptors plus (natsmicro.endpoint).middlewares, by method
+	js                 jetstream.JetStream               // Optional JetStream context for KV/ObjectStore
+	cancels            *cancelRegistry                   // Client cancel notices (nil unless WithCancelPropagation)
+	tokenSanitizer     func(string) string               // Escapes request fields in KV/Object Store keys
+	idGenerator        func() string                     // Mints stream inbox and persistent call IDs (nil = NUIDs)
+	maxRequestSize     int                               // Largest accepted request payload in bytes (0 = unlimited)
+	inflight           *inflightTracker                  // Running handlers, for Drain
+	persistentStreams  map[string]*persistentStream      // (natsmicro.stream).persistence streams, by method
+	streamWindow       int                               // Server-stream flow control cap (0 = none)
+	streamCompression  int                               // Smallest stream message compressed, once negotiated (0 = off)
+	compression        int                               // Smallest unary response compressed, if the client accepts it (0 = off)
+	compressor         Compressor                        // Codec for responses and stream messages
+	streamAllowGaps    bool                              // Skip lost client-stream messages instead of failing Recv
+	responseHeaders    *headerPolicy                     // Strips response headers (nil = allow all)
+	requestCheck       *requestImmutabilityCheck         // Reports handlers that modify their request (nil = off)
+	streamInterceptor  StreamServerInterceptor           // Chained stream interceptors
+	endpointPrefix     string                            // Qualifies endpoint names within a ServiceGroup
+	sampler            *sampler                          // Samples unary calls (WithSampling)
+	maxServerDeadline  time.Duration                     // Cap on propagated client deadlines (0 = none)
+	persistenceErrors  func(method string, err error)    // Receives failed KV/Object Store writes (nil = print)
+	panics             *panicDiagnostics                 // Recovers and reports handler panics (nil = off)
+	failures           *failureRecorder                  // Records failed calls for replay (nil = off)
+}
+
+// keyToken renders a request field for a key template through the token sanitizer
+func (h *kVStoreDemoServiceHandlers) keyToken(v any) string {
+	return h.tokenSanitizer(fmt.Sprint(v))
 }
 
 func (h *kVStoreDemoServiceHandlers) SaveProfile(req micro.Request) {
@@ -286,14 +654,26 @@ func (h *kVStoreDemoServiceHandlers) SaveProfile(req micro.Request) {
 	timeout := h.serviceTimeout
 	timeout = 5 * time.Second // Endpoint-specific timeout
 
-	// Create context with timeout if configured, otherwise use background context
-	ctx := context.Background()
+	// Create context with timeout if configured, derived from the service's drain context
+	ctx, end := h.inflight.begin(false) // Tracked for Drain
+	defer end()
 	var cancel context.CancelFunc
 	if timeout > 0 {
 		ctx, cancel = context.WithTimeout(ctx, timeout)
 		defer cancel()
 	}
 
+	// Stop when the client gives up: honor the deadline it propagated
+	ctx, cancelDeadline := withPropagatedDeadline(ctx, req.Headers(), h.maxServerDeadline)
+	defer cancelDeadline()
+
+	// Cancel the handler early if the client abandons the request
+	if h.cancels != nil {
+		var release func()
+		ctx, release = h.cancels.watch(ctx, req.Headers())
+		defer release()
+	}
+
 	// Add incoming NATS headers to context so service methods can access them
 	if req.Headers() != nil {
 		ctx = WithIncomingHeaders(ctx, req.Headers())
@@ -301,62 +681,69 @@ func (h *kVStoreDemoServiceHandlers) SaveProfile(req micro.Request) {
 
 	// Initialize outgoing headers pointer in context so interceptors can set response headers
 	outgoingHeadersPtr := &nats.Header{}
-	ctx = context.WithValue(ctx, outgoingHeadersKey, outgoingHeadersPtr)
+	ctx = withPendingResponseHeaders(ctx, outgoingHeadersPtr)
+
+	// Reject oversized requests before decoding them, decompressing compressed ones
+	body, payloadErr := readPayload("request", req.Headers().Get(ContentEncodingHeader), req.Data(), h.maxRequestSize)
+	if payloadErr != nil {
+		code, message, data := natsErrorFields(payloadErr)
+		req.Error(code, message, data)
+		return
+	}
 
+	// Decode with the codec the client named; clients without Content-Type use the configured one
+	requestJSON, ctErr := payloadUsesJSON(req.Headers().Get(ContentTypeHeader), h.useJSON)
+	if ctErr != nil {
+		code, message, data := natsErrorFields(ctErr)
+		req.Error(code, message, data)
+		return
+	}
 	var msg SaveProfileRequest
-	if h.useJSON {
-		if err := protojson.Unmarshal(req.Data(), &msg); err != nil {
+	if requestJSON {
+		if err := protojson.Unmarshal(body, &msg); err != nil {
 			req.Error(KVStoreDemoServiceErrCodeInvalidArgument, fmt.Sprintf("failed to decode JSON request: %v", err), nil)
 			return
 		}
 	} else {
-		if err := proto.Unmarshal(req.Data(), &msg); err != nil {
+		if err := proto.Unmarshal(body, &msg); err != nil {
 			req.Error(KVStoreDemoServiceErrCodeInvalidArgument, fmt.Sprintf("failed to decode request: %v", err), nil)
 			return
 		}
 	}
 
+	// Sample the call for WithSampling, capturing the request before the handler runs
+	sample := h.sampler.begin("KVStoreDemoService", "SaveProfile", incomingRequestID(req), false, nats.Header(req.Headers()), &msg, false)
+
 	// Define the handler function
 	handler := func(ctx context.Context, request interface{}) (interface{}, error) {
 		typedReq, ok := request.(*SaveProfileRequest)
 		if !ok {
 			return nil, fmt.Errorf("invalid request type")
 		}
+		defer h.requestCheck.check("KVStoreDemoService.SaveProfile", typedReq)()
 		return h.impl.SaveProfile(ctx, typedReq)
 	}
+	handler = h.panics.wrap("KVStoreDemoService", "SaveProfile", "kvstore_demo.v1.KVStoreDemoService.SaveProfile", false, handler)
+	handler = h.failures.wrap("KVStoreDemoService", "SaveProfile", "kvstore_demo.v1.KVStoreDemoService.SaveProfile", false, handler)
 
 	// Execute through interceptor chain if configured
 	var resp interface{}
 	var err error
-	if h.interceptor != nil {
+	interceptor := h.interceptor
+	if interceptor != nil {
 		info := &UnaryServerInfo{
-			Service: "KVStoreDemoService",
-			Method:  "SaveProfile",
-			Subject: "api.v1.kvdemo.save_profile",
+			Service:    "KVStoreDemoService",
+			Method:     "SaveProfile",
+			FullMethod: "kvstore_demo.v1.KVStoreDemoService.SaveProfile",
+			Subject:    "api.v1.kvdemo.save_profile",
 		}
-		resp, err = h.interceptor(ctx, &msg, info, handler)
+		resp, err = interceptor(ctx, &msg, info, handler)
 	} else {
 		resp, err = handler(ctx, &msg)
 	}
+	sample.end(resp, err)
 	if err != nil {
-		// Check if error implements custom status code/message/data methods
-		code := KVStoreDemoServiceErrCodeInternal
-		message := err.Error()
-		var data []byte
-
-		// Check for NatsErrorCode() string method
-		if coder, ok := err.(interface{ NatsErrorCode() string }); ok {
-			code = coder.NatsErrorCode()
-		}
-		// Check for NatsErrorMessage() string method
-		if messager, ok := err.(interface{ NatsErrorMessage() string }); ok {
-			message = messager.NatsErrorMessage()
-		}
-		// Check for NatsErrorData() []byte method
-		if dataProvider, ok := err.(interface{ NatsErrorData() []byte }); ok {
-			data = dataProvider.NatsErrorData()
-		}
-
+		code, message, data := natsErrorFields(err)
 		req.Error(code, message, data)
 		return
 	}
@@ -370,7 +757,7 @@ func (h *kVStoreDemoServiceHandlers) SaveProfile(req micro.Request) {
 
 	var data []byte
 	if h.useJSON {
-		data, err = protojson.Marshal(typedResp)
+		data, err = marshalJSON(typedResp, false)
 		if err != nil {
 			req.Error(KVStoreDemoServiceErrCodeInternal, fmt.Sprintf("failed to encode JSON response: %v", err), nil)
 			return
@@ -385,34 +772,38 @@ func (h *kVStoreDemoServiceHandlers) SaveProfile(req micro.Request) {
 
 	// Check if context has outgoing headers set by interceptors
 	// Read from the pointer that was initialized at the start
-	var outgoingHeaders nats.Header
-	if headersPtr, ok := ctx.Value(outgoingHeadersKey).(*nats.Header); ok && headersPtr != nil {
-		outgoingHeaders = *headersPtr
+	outgoingHeaders := pendingResponseHeaders(ctx)
+
+	// An implementation with ShouldPersistSaveProfile decides which responses are persisted
+	persist := true
+	if filter, ok := h.impl.(interface {
+		ShouldPersistSaveProfile(*ProfileResponse) bool
+	}); ok {
+		persist = filter.ShouldPersistSaveProfile(typedResp)
 	}
 	// Auto-persist response to KV Store (bucket: "user_profiles")
-	if h.js != nil {
-		kvKey := fmt.Sprintf("user.%v", msg.GetId())
+	if h.js != nil && persist {
+		// Key "user.{id}": request fields are escaped by the token sanitizer
+		kvKey := fmt.Sprintf("user.%s", h.keyToken(msg.GetId()))
 		kv, kvErr := h.js.KeyValue(ctx, "user_profiles")
 		if kvErr != nil {
-			log.Printf("[nats-micro] WARN: KV bucket \"user_profiles\" not available for SaveProfile: %v", kvErr)
+			reportPersistenceError(h.persistenceErrors, "kvstore_demo.v1.KVStoreDemoService.SaveProfile", fmt.Errorf("KV bucket \"user_profiles\" not available: %w", kvErr))
 		} else {
 			if _, kvErr = kv.Put(ctx, kvKey, data); kvErr != nil {
-				log.Printf("[nats-micro] WARN: failed to persist SaveProfile response to KV: %v", kvErr)
+				reportPersistenceError(h.persistenceErrors, "kvstore_demo.v1.KVStoreDemoService.SaveProfile", fmt.Errorf("failed to persist response to KV: %w", kvErr))
 			}
 		}
 	}
 
-	if len(outgoingHeaders) > 0 {
-		// Send response with headers using micro.WithHeaders
-		// Convert nats.Header to micro.Headers (they are the same underlying type)
-		if err := req.Respond(data, micro.WithHeaders(micro.Headers(outgoingHeaders))); err != nil {
-			fmt.Fprintf(os.Stderr, "failed to send response for SaveProfile: %v\n", err)
-		}
-	} else {
-		// Send response without headers
-		if err := req.Respond(data); err != nil {
-			fmt.Fprintf(os.Stderr, "failed to send response for SaveProfile: %v\n", err)
-		}
+	// Send response with headers set by interceptors plus the response codec
+	// Convert nats.Header to micro.Headers (they are the same underlying type)
+	outgoingHeaders = h.responseHeaders.strip(h.endpointPrefix+"save_profile", outgoingHeaders)
+	outgoingHeaders = withContentType(outgoingHeaders, h.useJSON)
+	if h.compression > 0 && acceptsEncoding(req.Headers().Get(acceptEncodingHeader), h.compressor.Name()) {
+		data = compressPayload(h.compressor, h.compression, data, outgoingHeaders)
+	}
+	if err := req.Respond(data, micro.WithHeaders(micro.Headers(outgoingHeaders))); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to send response for SaveProfile: %v\n", err)
 	}
 }
 
@@ -422,14 +813,26 @@ func (h *kVStoreDemoServiceHandlers) GetProfile(req micro.Request) {
 	timeout := h.serviceTimeout
 	timeout = 5 * time.Second // Endpoint-specific timeout
 
-	// Create context with timeout if configured, otherwise use background context
-	ctx := context.Background()
+	// Create context with timeout if configured, derived from the service's drain context
+	ctx, end := h.inflight.begin(false) // Tracked for Drain
+	defer end()
 	var cancel context.CancelFunc
 	if timeout > 0 {
 		ctx, cancel = context.WithTimeout(ctx, timeout)
 		defer cancel()
 	}
 
+	// Stop when the client gives up: honor the deadline it propagated
+	ctx, cancelDeadline := withPropagatedDeadline(ctx, req.Headers(), h.maxServerDeadline)
+	defer cancelDeadline()
+
+	// Cancel the handler early if the client abandons the request
+	if h.cancels != nil {
+		var release func()
+		ctx, release = h.cancels.watch(ctx, req.Headers())
+		defer release()
+	}
+
 	// Add incoming NATS headers to context so service methods can access them
 	if req.Headers() != nil {
 		ctx = WithIncomingHeaders(ctx, req.Headers())
@@ -437,62 +840,69 @@ func (h *kVStoreDemoServiceHandlers) GetProfile(req micro.Request) {
 
 	// Initialize outgoing headers pointer in context so interceptors can set response headers
 	outgoingHeadersPtr := &nats.Header{}
-	ctx = context.WithValue(ctx, outgoingHeadersKey, outgoingHeadersPtr)
+	ctx = withPendingResponseHeaders(ctx, outgoingHeadersPtr)
 
+	// Reject oversized requests before decoding them, decompressing compressed ones
+	body, payloadErr := readPayload("request", req.Headers().Get(ContentEncodingHeader), req.Data(), h.maxRequestSize)
+	if payloadErr != nil {
+		code, message, data := natsErrorFields(payloadErr)
+		req.Error(code, message, data)
+		return
+	}
+
+	// Decode with the codec the client named; clients without Content-Type use the configured one
+	requestJSON, ctErr := payloadUsesJSON(req.Headers().Get(ContentTypeHeader), h.useJSON)
+	if ctErr != nil {
+		code, message, data := natsErrorFields(ctErr)
+		req.Error(code, message, data)
+		return
+	}
 	var msg GetProfileRequest
-	if h.useJSON {
-		if err := protojson.Unmarshal(req.Data(), &msg); err != nil {
+	if requestJSON {
+		if err := protojson.Unmarshal(body, &msg); err != nil {
 			req.Error(KVStoreDemoServiceErrCodeInvalidArgument, fmt.Sprintf("failed to decode JSON request: %v", err), nil)
 			return
 		}
 	} else {
-		if err := proto.Unmarshal(req.Data(), &msg); err != nil {
+		if err := proto.Unmarshal(body, &msg); err != nil {
 			req.Error(KVStoreDemoServiceErrCodeInvalidArgument, fmt.Sprintf("failed to decode request: %v", err), nil)
 			return
 		}
 	}
 
+	// Sample the call for WithSampling, capturing the request before the handler runs
+	sample := h.sampler.begin("KVStoreDemoService", "GetProfile", incomingRequestID(req), false, nats.Header(req.Headers()), &msg, false)
+
 	// Define the handler function
 	handler := func(ctx context.Context, request interface{}) (interface{}, error) {
 		typedReq, ok := request.(*GetProfileRequest)
 		if !ok {
 			return nil, fmt.Errorf("invalid request type")
 		}
+		defer h.requestCheck.check("KVStoreDemoService.GetProfile", typedReq)()
 		return h.impl.GetProfile(ctx, typedReq)
 	}
+	handler = h.panics.wrap("KVStoreDemoService", "GetProfile", "kvstore_demo.v1.KVStoreDemoService.GetProfile", false, handler)
+	handler = h.failures.wrap("KVStoreDemoService", "GetProfile", "kvstore_demo.v1.KVStoreDemoService.GetProfile", false, handler)
 
 	// Execute through interceptor chain if configured
 	var resp interface{}
 	var err error
-	if h.interceptor != nil {
+	interceptor := h.interceptor
+	if interceptor != nil {
 		info := &UnaryServerInfo{
-			Service: "KVStoreDemoService",
-			Method:  "GetProfile",
-			Subject: "api.v1.kvdemo.get_profile",
+			Service:    "KVStoreDemoService",
+			Method:     "GetProfile",
+			FullMethod: "kvstore_demo.v1.KVStoreDemoService.GetProfile",
+			Subject:    "api.v1.kvdemo.get_profile",
 		}
-		resp, err = h.interceptor(ctx, &msg, info, handler)
+		resp, err = interceptor(ctx, &msg, info, handler)
 	} else {
 		resp, err = handler(ctx, &msg)
 	}
+	sample.end(resp, err)
 	if err != nil {
-		// Check if error implements custom status code/message/data methods
-		code := KVStoreDemoServiceErrCodeInternal
-		message := err.Error()
-		var data []byte
-
-		// Check for NatsErrorCode() string method
-		if coder, ok := err.(interface{ NatsErrorCode() string }); ok {
-			code = coder.NatsErrorCode()
-		}
-		// Check for NatsErrorMessage() string method
-		if messager, ok := err.(interface{ NatsErrorMessage() string }); ok {
-			message = messager.NatsErrorMessage()
-		}
-		// Check for NatsErrorData() []byte method
-		if dataProvider, ok := err.(interface{ NatsErrorData() []byte }); ok {
-			data = dataProvider.NatsErrorData()
-		}
-
+		code, message, data := natsErrorFields(err)
 		req.Error(code, message, data)
 		return
 	}
@@ -506,7 +916,7 @@ func (h *kVStoreDemoServiceHandlers) GetProfile(req micro.Request) {
 
 	var data []byte
 	if h.useJSON {
-		data, err = protojson.Marshal(typedResp)
+		data, err = marshalJSON(typedResp, false)
 		if err != nil {
 			req.Error(KVStoreDemoServiceErrCodeInternal, fmt.Sprintf("failed to encode JSON response: %v", err), nil)
 			return
@@ -521,22 +931,17 @@ func (h *kVStoreDemoServiceHandlers) GetProfile(req micro.Request) {
 
 	// Check if context has outgoing headers set by interceptors
 	// Read from the pointer that was initialized at the start
-	var outgoingHeaders nats.Header
-	if headersPtr, ok := ctx.Value(outgoingHeadersKey).(*nats.Header); ok && headersPtr != nil {
-		outgoingHeaders = *headersPtr
-	}
+	outgoingHeaders := pendingResponseHeaders(ctx)
 
-	if len(outgoingHeaders) > 0 {
-		// Send response with headers using micro.WithHeaders
-		// Convert nats.Header to micro.Headers (they are the same underlying type)
-		if err := req.Respond(data, micro.WithHeaders(micro.Headers(outgoingHeaders))); err != nil {
-			fmt.Fprintf(os.Stderr, "failed to send response for GetProfile: %v\n", err)
-		}
-	} else {
-		// Send response without headers
-		if err := req.Respond(data); err != nil {
-			fmt.Fprintf(os.Stderr, "failed to send response for GetProfile: %v\n", err)
-		}
+	// Send response with headers set by interceptors plus the response codec
+	// Convert nats.Header to micro.Headers (they are the same underlying type)
+	outgoingHeaders = h.responseHeaders.strip(h.endpointPrefix+"get_profile", outgoingHeaders)
+	outgoingHeaders = withContentType(outgoingHeaders, h.useJSON)
+	if h.compression > 0 && acceptsEncoding(req.Headers().Get(acceptEncodingHeader), h.compressor.Name()) {
+		data = compressPayload(h.compressor, h.compression, data, outgoingHeaders)
+	}
+	if err := req.Respond(data, micro.WithHeaders(micro.Headers(outgoingHeaders))); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to send response for GetProfile: %v\n", err)
 	}
 }
 
@@ -546,14 +951,26 @@ func (h *kVStoreDemoServiceHandlers) GenerateReport(req micro.Request) {
 	timeout := h.serviceTimeout
 	timeout = 30 * time.Second // Endpoint-specific timeout
 
-	// Create context with timeout if configured, otherwise use background context
-	ctx := context.Background()
+	// Create context with timeout if configured, derived from the service's drain context
+	ctx, end := h.inflight.begin(false) // Tracked for Drain
+	defer end()
 	var cancel context.CancelFunc
 	if timeout > 0 {
 		ctx, cancel = context.WithTimeout(ctx, timeout)
 		defer cancel()
 	}
 
+	// Stop when the client gives up: honor the deadline it propagated
+	ctx, cancelDeadline := withPropagatedDeadline(ctx, req.Headers(), h.maxServerDeadline)
+	defer cancelDeadline()
+
+	// Cancel the handler early if the client abandons the request
+	if h.cancels != nil {
+		var release func()
+		ctx, release = h.cancels.watch(ctx, req.Headers())
+		defer release()
+	}
+
 	// Add incoming NATS headers to context so service methods can access them
 	if req.Headers() != nil {
 		ctx = WithIncomingHeaders(ctx, req.Headers())
@@ -561,62 +978,76 @@ func (h *kVStoreDemoServiceHandlers) GenerateReport(req micro.Request) {
 
 	// Initialize outgoing headers pointer in context so interceptors can set response headers
 	outgoingHeadersPtr := &nats.Header{}
-	ctx = context.WithValue(ctx, outgoingHeadersKey, outgoingHeadersPtr)
+	ctx = withPendingResponseHeaders(ctx, outgoingHeadersPtr)
 
+	// Reject oversized requests before decoding them, decompressing compressed ones
+	body, payloadErr := readPayload("request", req.Headers().Get(ContentEncodingHeader), req.Data(), h.maxRequestSize)
+	if payloadErr != nil {
+		code, message, data := natsErrorFields(payloadErr)
+		req.Error(code, message, data)
+		return
+	}
+
+	// Decode with the codec the client named; clients without Content-Type use the configured one
+	requestJSON, ctErr := payloadUsesJSON(req.Headers().Get(ContentTypeHeader), h.useJSON)
+	if ctErr != nil {
+		code, message, data := natsErrorFields(ctErr)
+		req.Error(code, message, data)
+		return
+	}
 	var msg GenerateReportRequest
-	if h.useJSON {
-		if err := protojson.Unmarshal(req.Data(), &msg); err != nil {
+	if requestJSON {
+		if err := protojson.Unmarshal(body, &msg); err != nil {
 			req.Error(KVStoreDemoServiceErrCodeInvalidArgument, fmt.Sprintf("failed to decode JSON request: %v", err), nil)
 			return
 		}
 	} else {
-		if err := proto.Unmarshal(req.Data(), &msg); err != nil {
+		if err := proto.Unmarshal(body, &msg); err != nil {
 			req.Error(KVStoreDemoServiceErrCodeInvalidArgument, fmt.Sprintf("failed to decode request: %v", err), nil)
 			return
 		}
 	}
 
+	// The handler may stream a body into the object after the response (SetObjectBody)
+	objBody := &objectBody{}
+	if h.js != nil {
+		ctx = context.WithValue(ctx, objectBodyKey{}, objBody)
+	}
+	defer objBody.close()
+
+	// Sample the call for WithSampling, capturing the request before the handler runs
+	sample := h.sampler.begin("KVStoreDemoService", "GenerateReport", incomingRequestID(req), false, nats.Header(req.Headers()), &msg, false)
+
 	// Define the handler function
 	handler := func(ctx context.Context, request interface{}) (interface{}, error) {
 		typedReq, ok := request.(*GenerateReportRequest)
 		if !ok {
 			return nil, fmt.Errorf("invalid request type")
 		}
+		defer h.requestCheck.check("KVStoreDemoService.GenerateReport", typedReq)()
 		return h.impl.GenerateReport(ctx, typedReq)
 	}
+	handler = h.panics.wrap("KVStoreDemoService", "GenerateReport", "kvstore_demo.v1.KVStoreDemoService.GenerateReport", false, handler)
+	handler = h.failures.wrap("KVStoreDemoService", "GenerateReport", "kvstore_demo.v1.KVStoreDemoService.GenerateReport", false, handler)
 
 	// Execute through interceptor chain if configured
 	var resp interface{}
 	var err error
-	if h.interceptor != nil {
+	interceptor := h.interceptor
+	if interceptor != nil {
 		info := &UnaryServerInfo{
-			Service: "KVStoreDemoService",
-			Method:  "GenerateReport",
-			Subject: "api.v1.kvdemo.generate_report",
+			Service:    "KVStoreDemoService",
+			Method:     "GenerateReport",
+			FullMethod: "kvstore_demo.v1.KVStoreDemoService.GenerateReport",
+			Subject:    "api.v1.kvdemo.generate_report",
 		}
-		resp, err = h.interceptor(ctx, &msg, info, handler)
+		resp, err = interceptor(ctx, &msg, info, handler)
 	} else {
 		resp, err = handler(ctx, &msg)
 	}
+	sample.end(resp, err)
 	if err != nil {
-		// Check if error implements custom status code/message/data methods
-		code := KVStoreDemoServiceErrCodeInternal
-		message := err.Error()
-		var data []byte
-
-		// Check for NatsErrorCode() string method
-		if coder, ok := err.(interface{ NatsErrorCode() string }); ok {
-			code = coder.NatsErrorCode()
-		}
-		// Check for NatsErrorMessage() string method
-		if messager, ok := err.(interface{ NatsErrorMessage() string }); ok {
-			message = messager.NatsErrorMessage()
-		}
-		// Check for NatsErrorData() []byte method
-		if dataProvider, ok := err.(interface{ NatsErrorData() []byte }); ok {
-			data = dataProvider.NatsErrorData()
-		}
-
+		code, message, data := natsErrorFields(err)
 		req.Error(code, message, data)
 		return
 	}
@@ -630,7 +1061,7 @@ func (h *kVStoreDemoServiceHandlers) GenerateReport(req micro.Request) {
 
 	var data []byte
 	if h.useJSON {
-		data, err = protojson.Marshal(typedResp)
+		data, err = marshalJSON(typedResp, false)
 		if err != nil {
 			req.Error(KVStoreDemoServiceErrCodeInternal, fmt.Sprintf("failed to encode JSON response: %v", err), nil)
 			return
@@ -645,62 +1076,153 @@ func (h *kVStoreDemoServiceHandlers) GenerateReport(req micro.Request) {
 
 	// Check if context has outgoing headers set by interceptors
 	// Read from the pointer that was initialized at the start
-	var outgoingHeaders nats.Header
-	if headersPtr, ok := ctx.Value(outgoingHeadersKey).(*nats.Header); ok && headersPtr != nil {
-		outgoingHeaders = *headersPtr
+	outgoingHeaders := pendingResponseHeaders(ctx)
+
+	// An implementation with ShouldPersistGenerateReport decides which responses are persisted
+	persist := true
+	if filter, ok := h.impl.(interface {
+		ShouldPersistGenerateReport(*ReportResponse) bool
+	}); ok {
+		persist = filter.ShouldPersistGenerateReport(typedResp)
 	}
 	// Auto-persist response to Object Store (bucket: "reports")
-	if h.js != nil {
-		objKey := fmt.Sprintf("report.%v", msg.GetId())
+	if h.js != nil && persist {
+		// Key "report.{id}": request fields are escaped by the token sanitizer
+		objKey := fmt.Sprintf("report.%s", h.keyToken(msg.GetId()))
 		obj, objErr := h.js.ObjectStore(ctx, "reports")
 		if objErr != nil {
-			log.Printf("[nats-micro] WARN: Object Store bucket \"reports\" not available for GenerateReport: %v", objErr)
+			reportPersistenceError(h.persistenceErrors, "kvstore_demo.v1.KVStoreDemoService.GenerateReport", fmt.Errorf("Object Store bucket \"reports\" not available: %w", objErr))
 		} else {
-			if _, objErr = obj.PutBytes(ctx, objKey, data); objErr != nil {
-				log.Printf("[nats-micro] WARN: failed to persist GenerateReport response to Object Store: %v", objErr)
+			if objErr = putObject(ctx, obj, objKey, data, objBody.reader); objErr != nil {
+				reportPersistenceError(h.persistenceErrors, "kvstore_demo.v1.KVStoreDemoService.GenerateReport", fmt.Errorf("failed to persist response to Object Store: %w", objErr))
 			}
 		}
 	}
 
-	if len(outgoingHeaders) > 0 {
-		// Send response with headers using micro.WithHeaders
-		// Convert nats.Header to micro.Headers (they are the same underlying type)
-		if err := req.Respond(data, micro.WithHeaders(micro.Headers(outgoingHeaders))); err != nil {
-			fmt.Fprintf(os.Stderr, "failed to send response for GenerateReport: %v\n", err)
-		}
-	} else {
-		// Send response without headers
-		if err := req.Respond(data); err != nil {
-			fmt.Fprintf(os.Stderr, "failed to send response for GenerateReport: %v\n", err)
-		}
+	// Send response with headers set by interceptors plus the response codec
+	// Convert nats.Header to micro.Headers (they are the same underlying type)
+	outgoingHeaders = h.responseHeaders.strip(h.endpointPrefix+"generate_report", outgoingHeaders)
+	outgoingHeaders = withContentType(outgoingHeaders, h.useJSON)
+	if h.compression > 0 && acceptsEncoding(req.Headers().Get(acceptEncodingHeader), h.compressor.Name()) {
+		data = compressPayload(h.compressor, h.compression, data, outgoingHeaders)
+	}
+	if err := req.Respond(data, micro.WithHeaders(micro.Headers(outgoingHeaders))); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to send response for GenerateReport: %v\n", err)
 	}
 }
 
 // KVStoreDemoServiceNatsClientInterface is the interface for the NATS client
 // This interface allows for easier dependency injection and testing
 type KVStoreDemoServiceNatsClientInterface interface {
-	SaveProfile(context.Context, *SaveProfileRequest) (*ProfileResponse, error)
+	SaveProfile(context.Context, *SaveProfileRequest, ...CallOption) (*ProfileResponse, error)
+	SaveProfileKVKey(req *SaveProfileRequest) string
 	GetSaveProfileFromKV(ctx context.Context, key string) (*ProfileResponse, error)
-	GetProfile(context.Context, *GetProfileRequest) (*ProfileResponse, error)
-	GenerateReport(context.Context, *GenerateReportRequest) (*ReportResponse, error)
+	PutSaveProfileToKV(ctx context.Context, key string, val *ProfileResponse) error
+	GetSaveProfileCached(ctx context.Context, key string, req *SaveProfileRequest, opts ...CallOption) (*ProfileResponse, error)
+	WatchSaveProfileKV(ctx context.Context, keyPattern string, opts ...KVWatchOption) (<-chan KVChange[*ProfileResponse], error)
+	GetProfile(context.Context, *GetProfileRequest, ...CallOption) (*ProfileResponse, error)
+	GenerateReport(context.Context, *GenerateReportRequest, ...CallOption) (*ReportResponse, error)
+	GenerateReportObjectStoreKey(req *GenerateReportRequest) string
 	GetGenerateReportFromObjectStore(ctx context.Context, key string) (*ReportResponse, error)
+	GetGenerateReportReaderFromObjectStore(ctx context.Context, key string) (io.ReadCloser, *ObjectInfo[*ReportResponse], error)
+	OpenGenerateReportFromObjectStore(ctx context.Context, key string) (io.ReadCloser, *ObjectInfo[*ReportResponse], error)
+	PutGenerateReportToObjectStore(ctx context.Context, key string, val *ReportResponse) error
+	PutGenerateReportReaderToObjectStore(ctx context.Context, key string, val *ReportResponse, body io.Reader) error
+	Health(ctx context.Context) (*HealthResponse, error)
+	Schema(ctx context.Context) (*ServiceSchemaDocument, error)
 	Endpoints() []KVStoreDemoServiceEndpointInfo
+	WithHeaders(headers nats.Header) KVStoreDemoServiceNatsClientInterface
+	Close()
 }
 
 // KVStoreDemoServiceNatsClient is the concrete implementation of KVStoreDemoServiceNatsClientInterface
 type KVStoreDemoServiceNatsClient struct {
-	nc            *nats.Conn
-	subjectPrefix string
-	useJSON       bool                   // Use JSON encoding instead of binary protobuf
-	interceptor   UnaryClientInterceptor // Chained interceptors
-	js            jetstream.JetStream    // Optional JetStream for KV/ObjectStore reads
+	nc                  *nats.Conn
+	subjectPrefix       string
+	useJSON             bool                    // Use JSON encoding instead of binary protobuf
+	interceptor         UnaryClientInterceptor  // Chained interceptors
+	streamInterceptor   StreamClientInterceptor // Chained stream interceptors
+	js                  jetstream.JetStream     // Optional JetStream for KV/ObjectStore reads
+	kvStaleAfter        time.Duration           // Age at which Get*Cached treats KV entries as misses (0 = never)
+	cancelPropagation   bool                    // Publish a cancel notice when ctx ends mid-request
+	timeout             time.Duration           // Default unary timeout when ctx has no deadline
+	retry               *retryPolicy            // Unary retry policy (nil = no retries)
+	tokenSanitizer      func(string) string     // Escapes request fields in key helpers
+	idGenerator         func() string           // Mints cancel subject and stream inbox IDs (nil = NUIDs)
+	maxResponseSize     int                     // Largest accepted response payload in bytes (0 = unlimited)
+	connSelector        func() *nats.Conn       // Picks the connection per call (nil = nc)
+	journal             *journal                // Records calls for replay (nil = no journal)
+	streamWindow        int                     // Server-stream flow control window (0 = none)
+	streamAllowGaps     bool                    // Skip lost stream messages instead of failing Recv
+	streamCompression   int                     // Smallest stream message compressed, once negotiated (0 = off)
+	compression         int                     // Smallest unary request compressed (0 = off)
+	compressionCodec    string                  // Codec unary requests are compressed with
+	streamResumeOnDrain bool                    // Reopen server streams when their server drains
+	headerPolicy        *headerPolicy           // Strips outgoing headers (nil = allow all)
+	sampler             *sampler                // Samples unary calls (WithClientSampling)
+	monitor             *connectionMonitor      // Samples the connections (WithClientConnectionMonitor)
+	headers             nats.Header             // Sent under each call's OutgoingHeaders (WithHeaders)
+}
+
+// compress returns a unary request payload compressed as WithClientCompression
+// asks, flagged in headers
+func (c *KVStoreDemoServiceNatsClient) compress(data []byte, headers nats.Header) ([]byte, error) {
+	if c.compression == 0 {
+		return data, nil
+	}
+	codec := compressorFor(c.compressionCodec)
+	if codec == nil {
+		return nil, fmt.Errorf("WithClientCompression: codec %q is not registered; add it with RegisterCompressor", c.compressionCodec)
+	}
+	return compressPayload(codec, c.compression, data, headers), nil
+}
+
+// conn returns the connection for the next call or stream
+func (c *KVStoreDemoServiceNatsClient) conn() *nats.Conn {
+	if c.connSelector != nil {
+		return c.connSelector()
+	}
+	return c.nc
+}
+
+// outgoingHeaders returns the headers of ctx that WithOutgoingHeaderPolicy lets a call send
+func (c *KVStoreDemoServiceNatsClient) outgoingHeaders(ctx context.Context) nats.Header {
+	headers, _ := c.headerPolicy.filter(layerHeaders(c.headers, OutgoingHeaders(ctx)))
+	return headers
+}
+
+// WithHeaders returns a view of the client that sends headers with every call and
+// stream, beneath those set on the call's context with WithOutgoingHeaders. A view
+// shares the client's connections, interceptors, retry policy, journal and sampler
+// rather than copying them, so it is cheap to make per request, e.g. per tenant, and
+// like the client is safe for concurrent use. Views of views add to their parent's
+// headers. Closing a view does not stop the client's connection monitor.
+func (c *KVStoreDemoServiceNatsClient) WithHeaders(headers nats.Header) KVStoreDemoServiceNatsClientInterface {
+	layered := make(nats.Header, len(c.headers)+len(headers))
+	for name, values := range c.headers {
+		layered[name] = values
+	}
+	for name, values := range headers {
+		layered[name] = append([]string(nil), values...) // Later changes to headers stay out of the view
+	}
+	view := *c
+	view.headers = layered
+	view.monitor = nil
+	return &view
+}
+
+// keyToken renders a request field for a key template through the token sanitizer
+func (c *KVStoreDemoServiceNatsClient) keyToken(v any) string {
+	return c.tokenSanitizer(fmt.Sprint(v))
 }
 
 // NewKVStoreDemoServiceNatsClient creates a new NATS client for KVStoreDemoService.
 // The client sends requests over NATS using protobuf serialization (or JSON if configured).
 func NewKVStoreDemoServiceNatsClient(nc *nats.Conn, opts ...NatsClientOption) KVStoreDemoServiceNatsClientInterface {
 	cfg := &natsClientConfig{
-		subjectPrefix: "api.v1.kvdemo",
+		subjectPrefix:  "api.v1.kvdemo",
+		tokenSanitizer: SanitizeToken,
+		streamWindow:   defaultStreamWindow,
 	}
 	for _, opt := range opts {
 		opt.applyNatsClientOption(cfg)
@@ -713,30 +1235,78 @@ func NewKVStoreDemoServiceNatsClient(nc *nats.Conn, opts ...NatsClientOption) KV
 	}
 
 	c := &KVStoreDemoServiceNatsClient{
-		nc:            nc,
-		subjectPrefix: cfg.subjectPrefix,
-		useJSON:       false,
-		interceptor:   chainedInterceptor,
-		js:            cfg.js,
+		nc:                  nc,
+		subjectPrefix:       cfg.subjectPrefix,
+		useJSON:             false,
+		interceptor:         chainedInterceptor,
+		streamInterceptor:   chainStreamClientInterceptors(cfg.streamInterceptors),
+		js:                  cfg.js,
+		kvStaleAfter:        cfg.kvStaleAfter,
+		cancelPropagation:   cfg.cancelPropagation,
+		timeout:             cfg.timeout,
+		retry:               newRetryPolicy(cfg),
+		tokenSanitizer:      cfg.tokenSanitizer,
+		idGenerator:         cfg.idGenerator,
+		maxResponseSize:     cfg.maxResponseSize,
+		connSelector:        cfg.connSelector,
+		journal:             cfg.journal,
+		streamWindow:        cfg.streamWindow,
+		streamAllowGaps:     cfg.streamAllowGaps,
+		streamCompression:   cfg.streamCompression,
+		compression:         cfg.compression,
+		compressionCodec:    cfg.compressionCodec,
+		streamResumeOnDrain: cfg.streamResumeOnDrain,
+		headerPolicy:        cfg.headerPolicy,
+		sampler:             newSampler(cfg.sampling, cfg.samplingSalt),
+		monitor:             startConnectionMonitor(cfg.connMonitor, monitoredConns(nc, cfg.poolConns)...),
 	}
 	return c
 }
 
+// NewKVStoreDemoServiceNatsClientPool creates a KVStoreDemoService client that spreads calls
+// over conns round-robin (see RoundRobinConns). Streams stay on the connection they
+// opened on. A WithConnSelector in opts replaces the round-robin selector.
+func NewKVStoreDemoServiceNatsClientPool(conns []*nats.Conn, opts ...NatsClientOption) KVStoreDemoServiceNatsClientInterface {
+	selector := RoundRobinConns(conns)
+	opts = append([]NatsClientOption{natsClientOptionFunc(func(c *natsClientConfig) { c.poolConns = conns })}, opts...)
+	return NewKVStoreDemoServiceNatsClient(conns[0], append([]NatsClientOption{WithConnSelector(selector)}, opts...)...)
+}
+
 // SaveProfile sends a SaveProfile request to the service via NATS.
-// Returns an error if the request fails or the service returns an error.
-func (c *KVStoreDemoServiceNatsClient) SaveProfile(ctx context.Context, req *SaveProfileRequest) (*ProfileResponse, error) {
+// Returns an error if the request fails or the service returns an error,
+// or a *TimeoutError if a WithCallTimeout/WithClientTimeout expires.
+func (c *KVStoreDemoServiceNatsClient) SaveProfile(ctx context.Context, req *SaveProfileRequest, opts ...CallOption) (*ProfileResponse, error) {
 	method := "SaveProfile"
 
-	// Pointer to store response headers - stored in context so invoker can update it
-	responseHeadersPtr := &nats.Header{}
+	// Sample the call for WithClientSampling under a request ID the service sees too
+	var requestID string
+	if c.sampler.enabled() {
+		ctx, requestID = withRequestID(ctx, c.idGenerator)
+	}
+	sample := c.sampler.begin("KVStoreDemoService", method, requestID, true, c.outgoingHeaders(ctx), req, false)
+
+	// Bound the call when the caller gave no deadline (or asked for a per-call timeout)
+	start := time.Now()
+	parentCtx := ctx
+	ctx, cancel, timeout := withCallTimeout(ctx, c.timeout, opts)
+	defer cancel()
+
+	// Record sizes, attempts and duration for CallInfoFromContext
+	ctx, info := startCallInfo(ctx, "KVStoreDemoService", c.subjectPrefix+".save_profile")
+	defer info.finish()
+	ctx = withIDGenerator(ctx, c.idGenerator) // For NewID in interceptors
 
-	// Add the response headers pointer to context so invoker can populate it
+	// Give the invoker somewhere in the context to store the response headers
 	// Interceptors can then read the headers from the same context
-	ctx = context.WithValue(ctx, responseHeadersKey, responseHeadersPtr)
+	ctx = WithResponseHeaders(ctx, nats.Header{})
 
 	// Define the invoker function that performs the actual NATS call
 	invoker := func(invokerCtx context.Context, method string, request, reply interface{}) error {
-		subject := c.subjectPrefix + ".save_profile"
+		// WithTargetInstance sends the call to one instance's own subject
+		subject, subjectErr := targetSubject(c.subjectPrefix+".save_profile", opts)
+		if subjectErr != nil {
+			return newKVStoreDemoServiceError(KVStoreDemoServiceErrCodeInvalidArgument, method, subjectErr.Error(), nil)
+		}
 
 		// Marshal request
 		typedReq, ok := request.(*SaveProfileRequest)
@@ -747,7 +1317,7 @@ func (c *KVStoreDemoServiceNatsClient) SaveProfile(ctx context.Context, req *Sav
 		var data []byte
 		var err error
 		if c.useJSON {
-			data, err = protojson.Marshal(typedReq)
+			data, err = marshalJSON(typedReq, false)
 		} else {
 			data, err = proto.Marshal(typedReq)
 		}
@@ -755,111 +1325,229 @@ func (c *KVStoreDemoServiceNatsClient) SaveProfile(ctx context.Context, req *Sav
 			return err
 		}
 
-		// Extract outgoing headers from context and attach to NATS message
-		var msg *nats.Msg
-		if headers := OutgoingHeaders(invokerCtx); headers != nil {
-			msg, err = c.nc.RequestMsgWithContext(invokerCtx, &nats.Msg{
-				Subject: subject,
-				Data:    data,
-				Header:  headers,
-			})
-		} else {
-			msg, err = c.nc.RequestWithContext(invokerCtx, subject, data)
+		// Extract outgoing headers from context and attach them, naming the codec, to the NATS message
+		nc := c.conn()
+		headers := withContentType(c.outgoingHeaders(invokerCtx), c.useJSON)
+		setDeadlineHeaders(invokerCtx, headers) // For deadline-aware scheduling and handler contexts
+		if data, err = c.compress(data, headers); err != nil {
+			return err
+		}
+		headers.Set(acceptEncodingHeader, compressorNames()) // Services compress responses only for clients that decompress them
+		if c.cancelPropagation {
+			var stop func() bool
+			headers, stop = propagateCancel(invokerCtx, nc, headers, mintID(c.idGenerator))
+			defer stop()
 		}
+
+		info.attempt(len(data))
+		msg, err := nc.RequestMsgWithContext(invokerCtx, &nats.Msg{
+			Subject: subject,
+			Data:    data,
+			Header:  headers,
+		})
+		if err != nil {
+			return err
+		}
+		info.received(len(msg.Data))
+		info.responder(msg.Header)
+		body, err := readPayload("response", msg.Header.Get(ContentEncodingHeader), msg.Data, c.maxResponseSize)
 		if err != nil {
 			return err
 		}
 
-		// Store response headers in the pointer from context
+		// Store response headers where ResponseHeaders reads them
 		if msg.Header != nil && len(msg.Header) > 0 {
-			if headersPtr, ok := invokerCtx.Value(responseHeadersKey).(*nats.Header); ok && headersPtr != nil {
-				*headersPtr = msg.Header
-			}
-		} // Check if this is an error response from the service
-		if msg.Header.Get("Status") != "" {
-			code := msg.Header.Get("Status")
-			description := msg.Header.Get("Description")
-			return &KVStoreDemoServiceError{
-				Code:    code,
-				Method:  method,
-				Message: description,
+			storeResponseHeaders(invokerCtx, msg.Header)
+		}
+
+		// Check if this is an error response from the service (NATS micro headers)
+		if msg.Header.Get("Nats-Service-Error-Code") != "" {
+			code := msg.Header.Get("Nats-Service-Error-Code")
+			description := msg.Header.Get("Nats-Service-Error")
+			var details []byte
+			if len(msg.Data) > 0 {
+				details = msg.Data
 			}
+			return newKVStoreDemoServiceError(code, method, description, details)
 		}
 
-		// Unmarshal response
+		// Unmarshal response with the codec the service named, if any
 		typedReply, ok := reply.(*ProfileResponse)
 		if !ok {
 			return fmt.Errorf("invalid reply type")
 		}
-
-		if c.useJSON {
-			err = protojson.Unmarshal(msg.Data, typedReply)
+		replyJSON, err := payloadUsesJSON(msg.Header.Get(ContentTypeHeader), c.useJSON)
+		if err != nil {
+			return err
+		}
+		if replyJSON {
+			err = protojson.Unmarshal(body, typedReply)
 		} else {
-			err = proto.Unmarshal(msg.Data, typedReply)
+			err = proto.Unmarshal(body, typedReply)
 		}
 		return err
 	}
 
 	var resp ProfileResponse
 
-	// Execute through interceptor chain if configured
-	var err error
-	if c.interceptor != nil {
-		err = c.interceptor(ctx, method, req, &resp, invoker)
-	} else {
-		err = invoker(ctx, method, req, &resp)
+	// Execute through interceptor chain if configured, once per retry attempt
+	err := c.retry.do(ctx, func(ctx context.Context) error {
+		if c.interceptor != nil {
+			return c.interceptor(ctx, method, req, &resp, invoker)
+		}
+		return invoker(ctx, method, req, &resp)
+	})
+	sample.end(&resp, err)
+	if c.journal != nil {
+		c.journal.record("KVStoreDemoService", method, c.subjectPrefix+".save_profile", c.outgoingHeaders(parentCtx), req, c.useJSON, false, start, err)
 	}
-
 	if err != nil {
-		return nil, err
+		return nil, callTimeoutError(parentCtx, ctx, method, timeout, err)
 	}
 
 	return &resp, nil
 }
 
+// SaveProfileKVKey returns the KV key the service persists a SaveProfile response under
+// for req, from key_template "user.{id}" with fields escaped by the token sanitizer.
+func (c *KVStoreDemoServiceNatsClient) SaveProfileKVKey(req *SaveProfileRequest) string {
+	return fmt.Sprintf("user.%s", c.keyToken(req.GetId()))
+}
+
 // GetSaveProfileFromKV reads a SaveProfile response directly from the KV Store.
 // The key should match the key_template pattern used when the response was persisted.
 // Requires the client to be created with WithNatsClientJetStream.
 func (c *KVStoreDemoServiceNatsClient) GetSaveProfileFromKV(ctx context.Context, key string) (*ProfileResponse, error) {
+	resp, _, err := c.readSaveProfileFromKV(ctx, key)
+	return resp, err
+}
+
+// readSaveProfileFromKV is GetSaveProfileFromKV, also returning the entry read
+func (c *KVStoreDemoServiceNatsClient) readSaveProfileFromKV(ctx context.Context, key string) (*ProfileResponse, jetstream.KeyValueEntry, error) {
 	if c.js == nil {
-		return nil, errors.New("JetStream not configured; use WithNatsClientJetStream to enable KV reads")
+		return nil, nil, errors.New("JetStream not configured; use WithNatsClientJetStream to enable KV reads")
 	}
 	kv, err := c.js.KeyValue(ctx, "user_profiles")
 	if err != nil {
-		return nil, fmt.Errorf("failed to open KV bucket \"user_profiles\": %w", err)
+		return nil, nil, fmt.Errorf("failed to open KV bucket \"user_profiles\": %w", err)
 	}
 	entry, err := kv.Get(ctx, key)
 	if err != nil {
-		return nil, fmt.Errorf("KV get failed for key %q: %w", key, err)
+		return nil, nil, fmt.Errorf("KV get failed for key %q: %w", key, err)
 	}
 	var resp ProfileResponse
 	if c.useJSON {
 		if err := protojson.Unmarshal(entry.Value(), &resp); err != nil {
-			return nil, fmt.Errorf("failed to decode KV value: %w", err)
+			return nil, nil, fmt.Errorf("failed to decode KV value: %w", err)
 		}
 	} else {
 		if err := proto.Unmarshal(entry.Value(), &resp); err != nil {
-			return nil, fmt.Errorf("failed to decode KV value: %w", err)
+			return nil, nil, fmt.Errorf("failed to decode KV value: %w", err)
 		}
 	}
-	return &resp, nil
+	return &resp, entry, nil
+}
+
+// GetSaveProfileCached reads a SaveProfile response from the KV Store, and calls
+// SaveProfile with req when key has no entry, or one older than WithKVStaleAfter.
+// Such calls refresh the entry, since the service persists their response.
+// Requires the client to be created with WithNatsClientJetStream.
+func (c *KVStoreDemoServiceNatsClient) GetSaveProfileCached(ctx context.Context, key string, req *SaveProfileRequest, opts ...CallOption) (*ProfileResponse, error) {
+	if c.js == nil {
+		return nil, errors.New("JetStream not configured; use WithNatsClientJetStream to enable cached reads")
+	}
+	resp, entry, err := c.readSaveProfileFromKV(ctx, key)
+	if err == nil && (c.kvStaleAfter <= 0 || time.Since(entry.Created()) <= c.kvStaleAfter) {
+		return resp, nil
+	}
+	if err != nil && !errors.Is(err, jetstream.ErrKeyNotFound) && !errors.Is(err, jetstream.ErrBucketNotFound) {
+		return nil, err
+	}
+	return c.SaveProfile(ctx, req, opts...)
+}
+
+// WatchSaveProfileKV watches the KV entries of keys matching keyPattern, e.g., "user.*"
+// or ">", sending each change with its entry decoded as a SaveProfile response. The
+// current entries come first, unless WithKVWatchUpdatesOnly; deletes are skipped,
+// unless WithKVWatchDeletes. Entries that fail to decode are sent with Err set.
+// The channel closes when ctx ends. Requires the client to be created with WithNatsClientJetStream.
+func (c *KVStoreDemoServiceNatsClient) WatchSaveProfileKV(ctx context.Context, keyPattern string, opts ...KVWatchOption) (<-chan KVChange[*ProfileResponse], error) {
+	return watchKV(ctx, c.js, "user_profiles", keyPattern, "", opts, func(data []byte) (*ProfileResponse, error) {
+		var resp ProfileResponse
+		var err error
+		if c.useJSON {
+			err = protojson.Unmarshal(data, &resp)
+		} else {
+			err = proto.Unmarshal(data, &resp)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return &resp, nil
+	})
+}
+
+// PutSaveProfileToKV writes a ProfileResponse directly to the KV Store.
+// Requires the client to be created with WithNatsClientJetStream.
+func (c *KVStoreDemoServiceNatsClient) PutSaveProfileToKV(ctx context.Context, key string, val *ProfileResponse) error {
+	if c.js == nil {
+		return errors.New("JetStream not configured; use WithNatsClientJetStream to enable KV writes")
+	}
+	var data []byte
+	var err error
+	if c.useJSON {
+		data, err = marshalJSON(val, false)
+	} else {
+		data, err = proto.Marshal(val)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to encode value: %w", err)
+	}
+	kv, err := c.js.KeyValue(ctx, "user_profiles")
+	if err != nil {
+		return fmt.Errorf("failed to open KV bucket \"user_profiles\": %w", err)
+	}
+	if _, err := kv.Put(ctx, key, data); err != nil {
+		return fmt.Errorf("KV put failed for key %q: %w", key, err)
+	}
+	return nil
 }
 
 // GetProfile sends a GetProfile request to the service via NATS.
-// Returns an error if the request fails or the service returns an error.
-func (c *KVStoreDemoServiceNatsClient) GetProfile(ctx context.Context, req *GetProfileRequest) (*ProfileResponse, error) {
+// Returns an error if the request fails or the service returns an error,
+// or a *TimeoutError if a WithCallTimeout/WithClientTimeout expires.
+func (c *KVStoreDemoServiceNatsClient) GetProfile(ctx context.Context, req *GetProfileRequest, opts ...CallOption) (*ProfileResponse, error) {
 	method := "GetProfile"
 
-	// Pointer to store response headers - stored in context so invoker can update it
-	responseHeadersPtr := &nats.Header{}
+	// Sample the call for WithClientSampling under a request ID the service sees too
+	var requestID string
+	if c.sampler.enabled() {
+		ctx, requestID = withRequestID(ctx, c.idGenerator)
+	}
+	sample := c.sampler.begin("KVStoreDemoService", method, requestID, true, c.outgoingHeaders(ctx), req, false)
+
+	// Bound the call when the caller gave no deadline (or asked for a per-call timeout)
+	start := time.Now()
+	parentCtx := ctx
+	ctx, cancel, timeout := withCallTimeout(ctx, c.timeout, opts)
+	defer cancel()
 
-	// Add the response headers pointer to context so invoker can populate it
+	// Record sizes, attempts and duration for CallInfoFromContext
+	ctx, info := startCallInfo(ctx, "KVStoreDemoService", c.subjectPrefix+".get_profile")
+	defer info.finish()
+	ctx = withIDGenerator(ctx, c.idGenerator) // For NewID in interceptors
+
+	// Give the invoker somewhere in the context to store the response headers
 	// Interceptors can then read the headers from the same context
-	ctx = context.WithValue(ctx, responseHeadersKey, responseHeadersPtr)
+	ctx = WithResponseHeaders(ctx, nats.Header{})
 
 	// Define the invoker function that performs the actual NATS call
 	invoker := func(invokerCtx context.Context, method string, request, reply interface{}) error {
-		subject := c.subjectPrefix + ".get_profile"
+		// WithTargetInstance sends the call to one instance's own subject
+		subject, subjectErr := targetSubject(c.subjectPrefix+".get_profile", opts)
+		if subjectErr != nil {
+			return newKVStoreDemoServiceError(KVStoreDemoServiceErrCodeInvalidArgument, method, subjectErr.Error(), nil)
+		}
 
 		// Marshal request
 		typedReq, ok := request.(*GetProfileRequest)
@@ -870,7 +1558,7 @@ func (c *KVStoreDemoServiceNatsClient) GetProfile(ctx context.Context, req *GetP
 		var data []byte
 		var err error
 		if c.useJSON {
-			data, err = protojson.Marshal(typedReq)
+			data, err = marshalJSON(typedReq, false)
 		} else {
 			data, err = proto.Marshal(typedReq)
 		}
@@ -878,83 +1566,124 @@ func (c *KVStoreDemoServiceNatsClient) GetProfile(ctx context.Context, req *GetP
 			return err
 		}
 
-		// Extract outgoing headers from context and attach to NATS message
-		var msg *nats.Msg
-		if headers := OutgoingHeaders(invokerCtx); headers != nil {
-			msg, err = c.nc.RequestMsgWithContext(invokerCtx, &nats.Msg{
-				Subject: subject,
-				Data:    data,
-				Header:  headers,
-			})
-		} else {
-			msg, err = c.nc.RequestWithContext(invokerCtx, subject, data)
+		// Extract outgoing headers from context and attach them, naming the codec, to the NATS message
+		nc := c.conn()
+		headers := withContentType(c.outgoingHeaders(invokerCtx), c.useJSON)
+		setDeadlineHeaders(invokerCtx, headers) // For deadline-aware scheduling and handler contexts
+		if data, err = c.compress(data, headers); err != nil {
+			return err
+		}
+		headers.Set(acceptEncodingHeader, compressorNames()) // Services compress responses only for clients that decompress them
+		if c.cancelPropagation {
+			var stop func() bool
+			headers, stop = propagateCancel(invokerCtx, nc, headers, mintID(c.idGenerator))
+			defer stop()
 		}
+
+		info.attempt(len(data))
+		msg, err := nc.RequestMsgWithContext(invokerCtx, &nats.Msg{
+			Subject: subject,
+			Data:    data,
+			Header:  headers,
+		})
+		if err != nil {
+			return err
+		}
+		info.received(len(msg.Data))
+		info.responder(msg.Header)
+		body, err := readPayload("response", msg.Header.Get(ContentEncodingHeader), msg.Data, c.maxResponseSize)
 		if err != nil {
 			return err
 		}
 
-		// Store response headers in the pointer from context
+		// Store response headers where ResponseHeaders reads them
 		if msg.Header != nil && len(msg.Header) > 0 {
-			if headersPtr, ok := invokerCtx.Value(responseHeadersKey).(*nats.Header); ok && headersPtr != nil {
-				*headersPtr = msg.Header
-			}
-		} // Check if this is an error response from the service
-		if msg.Header.Get("Status") != "" {
-			code := msg.Header.Get("Status")
-			description := msg.Header.Get("Description")
-			return &KVStoreDemoServiceError{
-				Code:    code,
-				Method:  method,
-				Message: description,
+			storeResponseHeaders(invokerCtx, msg.Header)
+		}
+
+		// Check if this is an error response from the service (NATS micro headers)
+		if msg.Header.Get("Nats-Service-Error-Code") != "" {
+			code := msg.Header.Get("Nats-Service-Error-Code")
+			description := msg.Header.Get("Nats-Service-Error")
+			var details []byte
+			if len(msg.Data) > 0 {
+				details = msg.Data
 			}
+			return newKVStoreDemoServiceError(code, method, description, details)
 		}
 
-		// Unmarshal response
+		// Unmarshal response with the codec the service named, if any
 		typedReply, ok := reply.(*ProfileResponse)
 		if !ok {
 			return fmt.Errorf("invalid reply type")
 		}
-
-		if c.useJSON {
-			err = protojson.Unmarshal(msg.Data, typedReply)
+		replyJSON, err := payloadUsesJSON(msg.Header.Get(ContentTypeHeader), c.useJSON)
+		if err != nil {
+			return err
+		}
+		if replyJSON {
+			err = protojson.Unmarshal(body, typedReply)
 		} else {
-			err = proto.Unmarshal(msg.Data, typedReply)
+			err = proto.Unmarshal(body, typedReply)
 		}
 		return err
 	}
 
 	var resp ProfileResponse
 
-	// Execute through interceptor chain if configured
-	var err error
-	if c.interceptor != nil {
-		err = c.interceptor(ctx, method, req, &resp, invoker)
-	} else {
-		err = invoker(ctx, method, req, &resp)
+	// Execute through interceptor chain if configured, once per retry attempt
+	err := c.retry.do(ctx, func(ctx context.Context) error {
+		if c.interceptor != nil {
+			return c.interceptor(ctx, method, req, &resp, invoker)
+		}
+		return invoker(ctx, method, req, &resp)
+	})
+	sample.end(&resp, err)
+	if c.journal != nil {
+		c.journal.record("KVStoreDemoService", method, c.subjectPrefix+".get_profile", c.outgoingHeaders(parentCtx), req, c.useJSON, false, start, err)
 	}
-
 	if err != nil {
-		return nil, err
+		return nil, callTimeoutError(parentCtx, ctx, method, timeout, err)
 	}
 
 	return &resp, nil
 }
 
 // GenerateReport sends a GenerateReport request to the service via NATS.
-// Returns an error if the request fails or the service returns an error.
-func (c *KVStoreDemoServiceNatsClient) GenerateReport(ctx context.Context, req *GenerateReportRequest) (*ReportResponse, error) {
+// Returns an error if the request fails or the service returns an error,
+// or a *TimeoutError if a WithCallTimeout/WithClientTimeout expires.
+func (c *KVStoreDemoServiceNatsClient) GenerateReport(ctx context.Context, req *GenerateReportRequest, opts ...CallOption) (*ReportResponse, error) {
 	method := "GenerateReport"
 
-	// Pointer to store response headers - stored in context so invoker can update it
-	responseHeadersPtr := &nats.Header{}
+	// Sample the call for WithClientSampling under a request ID the service sees too
+	var requestID string
+	if c.sampler.enabled() {
+		ctx, requestID = withRequestID(ctx, c.idGenerator)
+	}
+	sample := c.sampler.begin("KVStoreDemoService", method, requestID, true, c.outgoingHeaders(ctx), req, false)
+
+	// Bound the call when the caller gave no deadline (or asked for a per-call timeout)
+	start := time.Now()
+	parentCtx := ctx
+	ctx, cancel, timeout := withCallTimeout(ctx, c.timeout, opts)
+	defer cancel()
+
+	// Record sizes, attempts and duration for CallInfoFromContext
+	ctx, info := startCallInfo(ctx, "KVStoreDemoService", c.subjectPrefix+".generate_report")
+	defer info.finish()
+	ctx = withIDGenerator(ctx, c.idGenerator) // For NewID in interceptors
 
-	// Add the response headers pointer to context so invoker can populate it
+	// Give the invoker somewhere in the context to store the response headers
 	// Interceptors can then read the headers from the same context
-	ctx = context.WithValue(ctx, responseHeadersKey, responseHeadersPtr)
+	ctx = WithResponseHeaders(ctx, nats.Header{})
 
 	// Define the invoker function that performs the actual NATS call
 	invoker := func(invokerCtx context.Context, method string, request, reply interface{}) error {
-		subject := c.subjectPrefix + ".generate_report"
+		// WithTargetInstance sends the call to one instance's own subject
+		subject, subjectErr := targetSubject(c.subjectPrefix+".generate_report", opts)
+		if subjectErr != nil {
+			return newKVStoreDemoServiceError(KVStoreDemoServiceErrCodeInvalidArgument, method, subjectErr.Error(), nil)
+		}
 
 		// Marshal request
 		typedReq, ok := request.(*GenerateReportRequest)
@@ -965,7 +1694,7 @@ func (c *KVStoreDemoServiceNatsClient) GenerateReport(ctx context.Context, req *
 		var data []byte
 		var err error
 		if c.useJSON {
-			data, err = protojson.Marshal(typedReq)
+			data, err = marshalJSON(typedReq, false)
 		} else {
 			data, err = proto.Marshal(typedReq)
 		}
@@ -973,94 +1702,210 @@ func (c *KVStoreDemoServiceNatsClient) GenerateReport(ctx context.Context, req *
 			return err
 		}
 
-		// Extract outgoing headers from context and attach to NATS message
-		var msg *nats.Msg
-		if headers := OutgoingHeaders(invokerCtx); headers != nil {
-			msg, err = c.nc.RequestMsgWithContext(invokerCtx, &nats.Msg{
-				Subject: subject,
-				Data:    data,
-				Header:  headers,
-			})
-		} else {
-			msg, err = c.nc.RequestWithContext(invokerCtx, subject, data)
+		// Extract outgoing headers from context and attach them, naming the codec, to the NATS message
+		nc := c.conn()
+		headers := withContentType(c.outgoingHeaders(invokerCtx), c.useJSON)
+		setDeadlineHeaders(invokerCtx, headers) // For deadline-aware scheduling and handler contexts
+		if data, err = c.compress(data, headers); err != nil {
+			return err
 		}
+		headers.Set(acceptEncodingHeader, compressorNames()) // Services compress responses only for clients that decompress them
+		if c.cancelPropagation {
+			var stop func() bool
+			headers, stop = propagateCancel(invokerCtx, nc, headers, mintID(c.idGenerator))
+			defer stop()
+		}
+
+		info.attempt(len(data))
+		msg, err := nc.RequestMsgWithContext(invokerCtx, &nats.Msg{
+			Subject: subject,
+			Data:    data,
+			Header:  headers,
+		})
+		if err != nil {
+			return err
+		}
+		info.received(len(msg.Data))
+		info.responder(msg.Header)
+		body, err := readPayload("response", msg.Header.Get(ContentEncodingHeader), msg.Data, c.maxResponseSize)
 		if err != nil {
 			return err
 		}
 
-		// Store response headers in the pointer from context
+		// Store response headers where ResponseHeaders reads them
 		if msg.Header != nil && len(msg.Header) > 0 {
-			if headersPtr, ok := invokerCtx.Value(responseHeadersKey).(*nats.Header); ok && headersPtr != nil {
-				*headersPtr = msg.Header
-			}
-		} // Check if this is an error response from the service
-		if msg.Header.Get("Status") != "" {
-			code := msg.Header.Get("Status")
-			description := msg.Header.Get("Description")
-			return &KVStoreDemoServiceError{
-				Code:    code,
-				Method:  method,
-				Message: description,
+			storeResponseHeaders(invokerCtx, msg.Header)
+		}
+
+		// Check if this is an error response from the service (NATS micro headers)
+		if msg.Header.Get("Nats-Service-Error-Code") != "" {
+			code := msg.Header.Get("Nats-Service-Error-Code")
+			description := msg.Header.Get("Nats-Service-Error")
+			var details []byte
+			if len(msg.Data) > 0 {
+				details = msg.Data
 			}
+			return newKVStoreDemoServiceError(code, method, description, details)
 		}
 
-		// Unmarshal response
+		// Unmarshal response with the codec the service named, if any
 		typedReply, ok := reply.(*ReportResponse)
 		if !ok {
 			return fmt.Errorf("invalid reply type")
 		}
-
-		if c.useJSON {
-			err = protojson.Unmarshal(msg.Data, typedReply)
+		replyJSON, err := payloadUsesJSON(msg.Header.Get(ContentTypeHeader), c.useJSON)
+		if err != nil {
+			return err
+		}
+		if replyJSON {
+			err = protojson.Unmarshal(body, typedReply)
 		} else {
-			err = proto.Unmarshal(msg.Data, typedReply)
+			err = proto.Unmarshal(body, typedReply)
 		}
 		return err
 	}
 
 	var resp ReportResponse
 
-	// Execute through interceptor chain if configured
-	var err error
-	if c.interceptor != nil {
-		err = c.interceptor(ctx, method, req, &resp, invoker)
-	} else {
-		err = invoker(ctx, method, req, &resp)
+	// Execute through interceptor chain if configured, once per retry attempt
+	err := c.retry.do(ctx, func(ctx context.Context) error {
+		if c.interceptor != nil {
+			return c.interceptor(ctx, method, req, &resp, invoker)
+		}
+		return invoker(ctx, method, req, &resp)
+	})
+	sample.end(&resp, err)
+	if c.journal != nil {
+		c.journal.record("KVStoreDemoService", method, c.subjectPrefix+".generate_report", c.outgoingHeaders(parentCtx), req, c.useJSON, false, start, err)
 	}
-
 	if err != nil {
-		return nil, err
+		return nil, callTimeoutError(parentCtx, ctx, method, timeout, err)
 	}
 
 	return &resp, nil
 }
 
+// GenerateReportObjectStoreKey returns the object name the service persists a GenerateReport response under
+// for req, from key_template "report.{id}" with fields escaped by the token sanitizer.
+func (c *KVStoreDemoServiceNatsClient) GenerateReportObjectStoreKey(req *GenerateReportRequest) string {
+	return fmt.Sprintf("report.%s", c.keyToken(req.GetId()))
+}
+
 // GetGenerateReportFromObjectStore reads a GenerateReport response directly from the Object Store.
 // The key should match the key_template pattern used when the response was persisted.
-// Requires the client to be created with WithNatsClientJetStream.
+// The body of an object written with SetObjectBody is skipped; see
+// OpenGenerateReportFromObjectStore. Requires the client to be created with WithNatsClientJetStream.
 func (c *KVStoreDemoServiceNatsClient) GetGenerateReportFromObjectStore(ctx context.Context, key string) (*ReportResponse, error) {
+	body, info, err := c.OpenGenerateReportFromObjectStore(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	body.Close()
+	return info.Response, nil
+}
+
+// GetGenerateReportReaderFromObjectStore is OpenGenerateReportFromObjectStore, named to sit with
+// GetGenerateReportFromObjectStore and PutGenerateReportReaderToObjectStore.
+func (c *KVStoreDemoServiceNatsClient) GetGenerateReportReaderFromObjectStore(ctx context.Context, key string) (io.ReadCloser, *ObjectInfo[*ReportResponse], error) {
+	return c.OpenGenerateReportFromObjectStore(ctx, key)
+}
+
+// OpenGenerateReportFromObjectStore streams a persisted GenerateReport response from the Object Store
+// without loading it into memory, e.g. to copy a large artifact to disk or HTTP.
+// The reader yields the body a handler stored with SetObjectBody (nothing for
+// objects without one); info holds the decoded response and the object's
+// metadata. Ending ctx closes the reader, failing a Read in progress with
+// ctx.Err(); the caller must close it. A missing key or bucket returns an error
+// matching IsKVStoreDemoServiceNotFound. Requires the client to be created with WithNatsClientJetStream.
+func (c *KVStoreDemoServiceNatsClient) OpenGenerateReportFromObjectStore(ctx context.Context, key string) (io.ReadCloser, *ObjectInfo[*ReportResponse], error) {
 	if c.js == nil {
-		return nil, errors.New("JetStream not configured; use WithNatsClientJetStream to enable Object Store reads")
+		return nil, nil, errors.New("JetStream not configured; use WithNatsClientJetStream to enable Object Store reads")
 	}
+	notFound := NewKVStoreDemoServiceNotFoundError("GenerateReport", fmt.Sprintf("object %q not found in bucket \"reports\"", key))
 	obj, err := c.js.ObjectStore(ctx, "reports")
+	if errors.Is(err, jetstream.ErrBucketNotFound) {
+		return nil, nil, notFound
+	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to open Object Store bucket \"reports\": %w", err)
+		return nil, nil, fmt.Errorf("failed to open Object Store bucket \"reports\": %w", err)
+	}
+	meta, data, body, err := getObject(ctx, c.js, obj, key)
+	if errors.Is(err, jetstream.ErrObjectNotFound) {
+		return nil, nil, notFound
 	}
-	data, err := obj.GetBytes(ctx, key)
 	if err != nil {
-		return nil, fmt.Errorf("Object Store get failed for key %q: %w", key, err)
+		return nil, nil, fmt.Errorf("Object Store get failed for key %q: %w", key, err)
 	}
 	var resp ReportResponse
 	if c.useJSON {
-		if err := protojson.Unmarshal(data, &resp); err != nil {
-			return nil, fmt.Errorf("failed to decode Object Store value: %w", err)
-		}
+		err = protojson.Unmarshal(data, &resp)
 	} else {
-		if err := proto.Unmarshal(data, &resp); err != nil {
-			return nil, fmt.Errorf("failed to decode Object Store value: %w", err)
-		}
+		err = proto.Unmarshal(data, &resp)
 	}
-	return &resp, nil
+	if err != nil {
+		body.Close()
+		return nil, nil, fmt.Errorf("failed to decode Object Store value: %w", err)
+	}
+	return newContextReader(ctx, body), newObjectInfo(key, &resp, meta), nil
+}
+
+// PutGenerateReportToObjectStore writes a ReportResponse directly to the Object Store.
+// Requires the client to be created with WithNatsClientJetStream.
+func (c *KVStoreDemoServiceNatsClient) PutGenerateReportToObjectStore(ctx context.Context, key string, val *ReportResponse) error {
+	return c.PutGenerateReportReaderToObjectStore(ctx, key, val, nil)
+}
+
+// PutGenerateReportReaderToObjectStore writes a ReportResponse to the Object Store
+// followed by body, streamed until EOF, as a handler's SetObjectBody does. A nil
+// body writes val alone. Requires the client to be created with WithNatsClientJetStream.
+func (c *KVStoreDemoServiceNatsClient) PutGenerateReportReaderToObjectStore(ctx context.Context, key string, val *ReportResponse, body io.Reader) error {
+	if c.js == nil {
+		return errors.New("JetStream not configured; use WithNatsClientJetStream to enable Object Store writes")
+	}
+	var data []byte
+	var err error
+	if c.useJSON {
+		data, err = marshalJSON(val, false)
+	} else {
+		data, err = proto.Marshal(val)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to encode value: %w", err)
+	}
+	obj, err := c.js.ObjectStore(ctx, "reports")
+	if err != nil {
+		return fmt.Errorf("failed to open Object Store bucket \"reports\": %w", err)
+	}
+	if err := putObject(ctx, obj, key, data, body); err != nil {
+		return fmt.Errorf("Object Store put failed for key %q: %w", key, err)
+	}
+	return nil
+}
+
+// Health calls the service's health endpoint. A service that reports HealthNotServing
+// returns a HealthResponse, not an error.
+func (c *KVStoreDemoServiceNatsClient) Health(ctx context.Context) (*HealthResponse, error) {
+	parentCtx := ctx
+	ctx, cancel, timeout := withCallTimeout(ctx, c.timeout, nil)
+	defer cancel()
+	resp, err := requestHealth(ctx, c.conn(), healthSubject(c.subjectPrefix, "kv_store_demo_service"))
+	if err != nil {
+		return nil, callTimeoutError(parentCtx, ctx, "Health", timeout, err)
+	}
+	return resp, nil
+}
+
+// Schema fetches the service's $schema document; see FetchServiceSchema
+func (c *KVStoreDemoServiceNatsClient) Schema(ctx context.Context) (*ServiceSchemaDocument, error) {
+	ctx, cancel, _ := withCallTimeout(ctx, c.timeout, nil)
+	defer cancel()
+	return FetchServiceSchema(ctx, c.conn(), schemaSubject(c.subjectPrefix, "kv_store_demo_service"))
+}
+
+// Close stops the client's connection monitor. The NATS connection stays open,
+// and the client can still be used.
+func (c *KVStoreDemoServiceNatsClient) Close() {
+	c.monitor.close()
 }
 
 // Endpoints returns information about all service endpoints this client can call.
@@ -1072,3 +1917,16 @@ func (c *KVStoreDemoServiceNatsClient) Endpoints() []KVStoreDemoServiceEndpointI
 		{Name: "GenerateReport", Subject: c.subjectPrefix + ".generate_report"},
 	}
 }
+
+// KVStoreDemoServiceSelfTest checks that the NATS connection works and that a running
+// kvstore_demo_service instance has registered every endpoint the client calls. Client
+// options such as WithNatsClientSubjectPrefix select the expected subjects.
+// Exit non-zero when the report is not OK to use it as a liveness or deployment hook.
+func KVStoreDemoServiceSelfTest(ctx context.Context, nc *nats.Conn, opts ...NatsClientOption) *SelfTestReport {
+	c := NewKVStoreDemoServiceNatsClient(nc, opts...)
+	var endpoints []SelfTestEndpoint
+	for _, ep := range c.Endpoints() {
+		endpoints = append(endpoints, SelfTestEndpoint{Name: ep.Name, Subject: ep.Subject})
+	}
+	return runSelfTest(ctx, nc, "kvstore_demo_service", endpoints)
+}
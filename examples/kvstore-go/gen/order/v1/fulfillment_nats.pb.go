@@ -1,4 +1,8 @@
 // Code generated by protoc-gen-nats-micro. DO NOT EDIT.
+// versions:
+// 	protoc-gen-nats-micro v0.3.0
+// 	protoc                (unknown)
+// source: order/v1/fulfillment.proto
 
 package v1
 
@@ -6,7 +10,6 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"log"
 	"os"
 	"time"
 	"github.com/nats-io/nats.go"
@@ -21,6 +24,21 @@ type OrderFulfillmentServiceError struct {
 	Code    string // Error code (e.g., "INVALID_ARGUMENT", "NOT_FOUND", "INTERNAL")
 	Message string // Human-readable error message
 	Method  string // The method that failed
+	Details []byte // Optional error data sent with the error
+
+	status *Status // Returned by Unwrap (nil = built on each call)
+}
+
+// newOrderFulfillmentServiceError creates an error that keeps its *Status, so Unwrap
+// returns the same value every time
+func newOrderFulfillmentServiceError(code, method, message string, details []byte) *OrderFulfillmentServiceError {
+	return &OrderFulfillmentServiceError{
+		Code:    code,
+		Method:  method,
+		Message: message,
+		Details: details,
+		status:  &Status{Code: ParseCode(code), Message: message, Details: details},
+	}
 }
 
 func (e *OrderFulfillmentServiceError) Error() string {
@@ -39,7 +57,17 @@ func (e *OrderFulfillmentServiceError) NatsErrorMessage() string {
 
 // NatsErrorData returns optional error data (nil for basic errors)
 func (e *OrderFulfillmentServiceError) NatsErrorData() []byte {
-	return nil
+	return e.Details
+}
+
+// Unwrap exposes the error as a *Status, so errors.As(err, &st) works on
+// client errors. Custom error codes map to CodeUnknown. Errors built as struct
+// literals get a new Status on each call.
+func (e *OrderFulfillmentServiceError) Unwrap() error {
+	if e.status != nil {
+		return e.status
+	}
+	return &Status{Code: ParseCode(e.Code), Message: e.Message, Details: e.Details}
 }
 
 // Service-specific error code constants (use shared constants from service_shared_nats.pb.go)
@@ -106,37 +134,37 @@ func GetOrderFulfillmentServiceErrorCode(err error) string {
 
 // NewOrderFulfillmentServiceInvalidArgumentError creates a new invalid argument error
 func NewOrderFulfillmentServiceInvalidArgumentError(method, message string) error {
-	return &OrderFulfillmentServiceError{Code: OrderFulfillmentServiceErrCodeInvalidArgument, Method: method, Message: message}
+	return newOrderFulfillmentServiceError(OrderFulfillmentServiceErrCodeInvalidArgument, method, message, nil)
 }
 
 // NewOrderFulfillmentServiceNotFoundError creates a new not found error
 func NewOrderFulfillmentServiceNotFoundError(method, message string) error {
-	return &OrderFulfillmentServiceError{Code: OrderFulfillmentServiceErrCodeNotFound, Method: method, Message: message}
+	return newOrderFulfillmentServiceError(OrderFulfillmentServiceErrCodeNotFound, method, message, nil)
 }
 
 // NewOrderFulfillmentServiceAlreadyExistsError creates a new already exists error
 func NewOrderFulfillmentServiceAlreadyExistsError(method, message string) error {
-	return &OrderFulfillmentServiceError{Code: OrderFulfillmentServiceErrCodeAlreadyExists, Method: method, Message: message}
+	return newOrderFulfillmentServiceError(OrderFulfillmentServiceErrCodeAlreadyExists, method, message, nil)
 }
 
 // NewOrderFulfillmentServicePermissionDeniedError creates a new permission denied error
 func NewOrderFulfillmentServicePermissionDeniedError(method, message string) error {
-	return &OrderFulfillmentServiceError{Code: OrderFulfillmentServiceErrCodePermissionDenied, Method: method, Message: message}
+	return newOrderFulfillmentServiceError(OrderFulfillmentServiceErrCodePermissionDenied, method, message, nil)
 }
 
 // NewOrderFulfillmentServiceUnauthenticatedError creates a new unauthenticated error
 func NewOrderFulfillmentServiceUnauthenticatedError(method, message string) error {
-	return &OrderFulfillmentServiceError{Code: OrderFulfillmentServiceErrCodeUnauthenticated, Method: method, Message: message}
+	return newOrderFulfillmentServiceError(OrderFulfillmentServiceErrCodeUnauthenticated, method, message, nil)
 }
 
 // NewOrderFulfillmentServiceInternalError creates a new internal error
 func NewOrderFulfillmentServiceInternalError(method, message string) error {
-	return &OrderFulfillmentServiceError{Code: OrderFulfillmentServiceErrCodeInternal, Method: method, Message: message}
+	return newOrderFulfillmentServiceError(OrderFulfillmentServiceErrCodeInternal, method, message, nil)
 }
 
 // NewOrderFulfillmentServiceUnavailableError creates a new unavailable error
 func NewOrderFulfillmentServiceUnavailableError(method, message string) error {
-	return &OrderFulfillmentServiceError{Code: OrderFulfillmentServiceErrCodeUnavailable, Method: method, Message: message}
+	return newOrderFulfillmentServiceError(OrderFulfillmentServiceErrCodeUnavailable, method, message, nil)
 }
 
 // OrderFulfillmentServiceNats is the NATS service interface for OrderFulfillmentService
@@ -148,8 +176,10 @@ type OrderFulfillmentServiceNats interface {
 
 // OrderFulfillmentServiceEndpointInfo describes a service endpoint
 type OrderFulfillmentServiceEndpointInfo struct {
-	Name    string `json:"name"`    // Method name (e.g., "CreateProduct")
-	Subject string `json:"subject"` // NATS subject (e.g., "api.v1.create_product")
+	Name           string `json:"name"`                       // Method name (e.g., "CreateProduct")
+	Subject        string `json:"subject"`                    // NATS subject (e.g., "api.v1.create_product")
+	QueueGroup     string `json:"queue_group,omitempty"`      // Queue group the endpoint joined (server only)
+	MaxRequestSize int    `json:"max_request_size,omitempty"` // Request payload limit in bytes (server only, 0 = unlimited)
 }
 
 // OrderFulfillmentServiceService is the interface for the registered NATS micro service
@@ -157,59 +187,234 @@ type OrderFulfillmentServiceEndpointInfo struct {
 type OrderFulfillmentServiceService interface {
 	micro.Service
 	Endpoints() []OrderFulfillmentServiceEndpointInfo
+	// Drain stops accepting requests, waits until in-flight handlers finish or
+	// ctx ends, and stops the service
+	Drain(ctx context.Context) error
+	// InterceptorChain names the interceptors a method's requests pass through,
+	// outermost first
+	InterceptorChain(method string) []string
+	// Reconfigure changes the settings that can change while the service runs,
+	// currently WithSampling and WithSamplingSalt
+	Reconfigure(opts ...RegisterOption) error
 }
 
 // orderFulfillmentServiceService is the concrete implementation of OrderFulfillmentServiceService
 type orderFulfillmentServiceService struct {
 	micro.Service
-	subjectPrefix string
+	subjectPrefix  string
+	queueGroup     string
+	maxRequestSize int
+	inflight       *inflightTracker
+	interceptors   []string // Names of the service-wide interceptors, outermost first
+	sampler        *sampler // Shared with the handlers, for Reconfigure
 }
 
 // Endpoints returns information about all service endpoints
 // This is useful for debugging, monitoring, and service discovery
 func (s *orderFulfillmentServiceService) Endpoints() []OrderFulfillmentServiceEndpointInfo {
 	return []OrderFulfillmentServiceEndpointInfo{
-		{Name: "PrepareOrder", Subject: s.subjectPrefix + ".prepare_order"},
-		{Name: "ShipOrder", Subject: s.subjectPrefix + ".ship_order"},
-		{Name: "GetFulfillmentStatus", Subject: s.subjectPrefix + ".get_fulfillment_status"},
+		{Name: "PrepareOrder", Subject: s.subjectPrefix + ".prepare_order", QueueGroup: s.queueGroup, MaxRequestSize: s.maxRequestSize},
+		{Name: "ShipOrder", Subject: s.subjectPrefix + ".ship_order", QueueGroup: s.queueGroup, MaxRequestSize: s.maxRequestSize},
+		{Name: "GetFulfillmentStatus", Subject: s.subjectPrefix + ".get_fulfillment_status", QueueGroup: s.queueGroup, MaxRequestSize: s.maxRequestSize},
 	}
 }
 
+// init lists the methods of OrderFulfillmentService in MethodDescriptors
+func init() {
+	MethodDescriptors["order.v1.OrderFulfillmentService.PrepareOrder"] = MethodDescriptor{
+		Service: "OrderFulfillmentService",
+		Method:  "PrepareOrder",
+		Subject: "api.v1.prepare_order",
+	}
+	MethodDescriptors["order.v1.OrderFulfillmentService.ShipOrder"] = MethodDescriptor{
+		Service: "OrderFulfillmentService",
+		Method:  "ShipOrder",
+		Subject: "api.v1.ship_order",
+	}
+	MethodDescriptors["order.v1.OrderFulfillmentService.GetFulfillmentStatus"] = MethodDescriptor{
+		Service: "OrderFulfillmentService",
+		Method:  "GetFulfillmentStatus",
+		Subject: "api.v1.get_fulfillment_status",
+	}
+}
+
+// OrderFulfillmentServiceSubjects maps each method of OrderFulfillmentService to its subject under
+// the default prefix. Clients and services of every language are generated from
+// the same table.
+var OrderFulfillmentServiceSubjects = map[string]string{
+	"PrepareOrder":         "api.v1.prepare_order",
+	"ShipOrder":            "api.v1.ship_order",
+	"GetFulfillmentStatus": "api.v1.get_fulfillment_status",
+}
+
+// Drain unsubscribes every endpoint, so new requests get no responders, then waits
+// for in-flight handlers, and requests already delivered to the endpoints, to
+// finish before returning. Server and bidi streams are
+// sent a GOAWAY, which clients see as an *ErrServerDraining, and streaming handlers
+// have their context canceled right away, or after WithStreamDrainGrace, so
+// long-running streams can end cleanly. If ctx ends first, the remaining handlers'
+// contexts are canceled and ctx.Err() is returned. The service is stopped either way.
+// Drain fails if nats.go's micro left an endpoint subscribed; see stopEndpoints.
+func (s *orderFulfillmentServiceService) Drain(ctx context.Context) error {
+	return s.inflight.drain(ctx, func() error { return stopEndpoints(s.Service) })
+}
+
+// InterceptorChain returns the names of the interceptors that requests to
+// method, a Go method name, pass through, outermost first: the service's
+// interceptors in the order they were added, then the method's
+// (natsmicro.endpoint).middlewares. Interceptors are named with Named, or else
+// by their function name.
+func (s *orderFulfillmentServiceService) InterceptorChain(method string) []string {
+	chain := append([]string(nil), s.interceptors...)
+	return chain
+}
+
+// Reconfigure replaces the service's WithSampling and WithSamplingSalt settings
+// with those in opts, so sampling can be turned up, down or off without
+// re-registering; leaving WithSampling out stops sampling. Any other option is
+// rejected, as it only takes effect at registration.
+func (s *orderFulfillmentServiceService) Reconfigure(opts ...RegisterOption) error {
+	cfg := &registerConfig{}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	return reconfigureSampling(s.sampler, cfg)
+}
+
+// OrderFulfillmentServiceSchemaHash identifies the schema OrderFulfillmentService was generated from.
+// Services advertise it as schema_hash INFO metadata; see SchemaHash.
+const OrderFulfillmentServiceSchemaHash = "sha256:b8ebfeb4883d4cac62a0400e1570f501af9d2c7606432d6cf77b572827e8521f"
+
+// orderFulfillmentServiceSchema is the FileDescriptorSet OrderFulfillmentService serves from its
+// $reflect endpoint. The schema endpoint metadata is read from it too, so the two
+// always agree.
+const orderFulfillmentServiceSchema = "\n\xd5\n\n\x1aorder/v1/fulfillment.proto\x12\border.v1\x1a\x17natsmicro/options.proto\x1a\x1cgoogle/api/annotations.proto\"S\n\x13PrepareOrderRequest\x12\x19\n\border_id\x18\x01 \x01(\tR\aorderId\x12!\n\fwarehouse_id\x18\x02 \x01(\tR\vwarehouseId\"p\n\x14PrepareOrderResponse\x12\x19\n\border_id\x18\x01 \x01(\tR\aorderId\x12%\n\x0efulfillment_id\x18\x02 \x01(\tR\rfulfillmentId\x12\x16\n\x06status\x18\x03 \x01(\tR\x06status\"\x97\x01\n\x10ShipOrderRequest\x12\x19\n\border_id\x18\x01 \x01(\tR\aorderId\x12%\n\x0efulfillment_id\x18\x02 \x01(\tR\rfulfillmentId\x12\x18\n\acarrier\x18\x03 \x01(\tR\acarrier\x12'\n\x0ftracking_number\x18\x04 \x01(\tR\x0etrackingNumber\"\x8c\x01\n\x11ShipOrderResponse\x12\x19\n\border_id\x18\x01 \x01(\tR\aorderId\x12%\n\x0efulfillment_id\x18\x02 \x01(\tR\rfulfillmentId\x12\x16\n\x06status\x18\x03 \x01(\tR\x06status\x12\x1d\n\nshipped_at\x18\x04 \x01(\tR\tshippedAt\"8\n\x1bGetFulfillmentStatusRequest\x12\x19\n\border_id\x18\x01 \x01(\tR\aorderId\"\xde\x01\n\x1cGetFulfillmentStatusResponse\x12\x19\n\border_id\x18\x01 \x01(\tR\aorderId\x12%\n\x0efulfillment_id\x18\x02 \x01(\tR\rfulfillmentId\x12\x16\n\x06status\x18\x03 \x01(\tR\x06status\x12!\n\fwarehouse_id\x18\x04 \x01(\tR\vwarehouseId\x12\x18\n\acarrier\x18\x05 \x01(\tR\acarrier\x12'\n\x0ftracking_number\x18\x06 \x01(\tR\x0etrackingNumber2\xca\x03\n\x17OrderFulfillmentService\x12q\n\fPrepareOrder\x12\x1d.order.v1.PrepareOrderRequest\x1a\x1e.order.v1.PrepareOrderResponse\"\"\x82\xd3\xe4\x93\x02\x1c:\x01*\"\x17/v1/fulfillment/prepare\x12e\n\tShipOrder\x12\x1a.order.v1.ShipOrderRequest\x1a\x1b.order.v1.ShipOrderResponse\"\x1f\x82\xd3\xe4\x93\x02\x19:\x01*\"\x14/v1/fulfillment/ship\x12\x89\x01\n\x14GetFulfillmentStatus\x12%.order.v1.GetFulfillmentStatusRequest\x1a&.order.v1.GetFulfillmentStatusResponse\"\"\x82\xd3\xe4\x93\x02\x1c\x12\x1a/v1/fulfillment/{order_id}\x1aI\x8a\xb5\x18E\n\x06api.v1\x12\x19order_fulfillment_service\x1a\x051.0.0\"\x19Order fulfillment serviceB\x16Z\x14example/gen/order/v1b\x06proto3"
+
+// orderFulfillmentServiceSchemaDocument is the JSON OrderFulfillmentService serves from its
+// $schema endpoint, before registration fills in its name, version and subjects
+const orderFulfillmentServiceSchemaDocument = "{\"service\":\"order.v1.OrderFulfillmentService\",\"schema_hash\":\"sha256:b8ebfeb4883d4cac62a0400e1570f501af9d2c7606432d6cf77b572827e8521f\",\"methods\":[{\"name\":\"PrepareOrder\",\"endpoint\":\"prepare_order\",\"streaming\":\"unary\",\"request_type\":\"order.v1.PrepareOrderRequest\",\"response_type\":\"order.v1.PrepareOrderResponse\"},{\"name\":\"ShipOrder\",\"endpoint\":\"ship_order\",\"streaming\":\"unary\",\"request_type\":\"order.v1.ShipOrderRequest\",\"response_type\":\"order.v1.ShipOrderResponse\"},{\"name\":\"GetFulfillmentStatus\",\"endpoint\":\"get_fulfillment_status\",\"streaming\":\"unary\",\"request_type\":\"order.v1.GetFulfillmentStatusRequest\",\"response_type\":\"order.v1.GetFulfillmentStatusResponse\"}],\"descriptor\":\"H4sIAAAAAAAC/7RUzW4TPRTV9Cf9cr9SwERN4qYQLBUQi5kGNogdSICyAdTs2ERu5jaxmIxd2xOQEBt2IHYseBJegi1iy4oHQfHMNNNhaLrp0vdcn+vjc2z4CUClDlEHs15wlERHIoqmGFtfaWkl+c9h/qxHmzG3ZipGWgZSWSFjk7bQzljKcYQBVyLgcSwtL6BsANdealRc44s50wEeJ2gsaUPKPBRhy+t6d+oHG27dD8lN2HzDNU5kYnAOrzj4/5NaP2QKGqdJjZKxwbNY92CroG7Be6lQ7YdkG2rGcpuY1qqDsxX76sGVwUSo84o457gWbIy41gJ1Ni9fkttw2Wo+ei3i8TBOpoeoW2uuYysvP3dV9tmDq4WTXfRNkF0AMxFKYTjkNjtTPas8suwB7DxD+3RBNXDbll8Z++VBp3rrhWsqR27tr8gVnVpf6lStyql731eh6VwqSkQ9EyMkx7BZTDTZ9fOH51c8H3r9X3B6VYx9+PH7y0rnoXeXNUsPO1DpBoJQP8kNoQvCcszpTiWWTbrhJrXnkxrlSfNUkI8eNKpsJXsL2jMSQ28tazstmdDyKd7loXlP+5++tZ5AjSvhz3qknQLF4JjUDrre8/f9fdZ2WruFjm7W8Xj7VQPf8qmKMBhjHORf6GHNfXv3/wwAbSkgq1gFAAA=\"}"
+
 // RegisterOrderFulfillmentServiceHandlers registers the service with NATS micro handlers
 // Service: order_fulfillment_service v1.0.0
 // Description: Order fulfillment service
 // Subject prefix: api.v1
-// Configuration options: WithName(), WithVersion(), WithDescription(), WithSubjectPrefix(), WithTimeout()
-// Metadata options: WithMetadata() (replace), WithAdditionalMetadata() (merge)
+// Configuration options: WithName(), WithVersion(), WithDescription(), WithSubjectPrefix(), WithTimeout(), WithQueueGroup(), WithMaxRequestSize()
+// Metadata options: WithMetadata() (replace), WithAdditionalMetadata() (merge), WithEndpointMetadata() (per method)
 // Handler options: WithStatsHandler(), WithDoneHandler(), WithErrorHandler()
+// Interceptors: WithServerInterceptor() appends, WithServerInterceptorChain() replaces; the first runs outermost
+// Stream interceptors: WithServerStreamInterceptor()
+// Grouping: WithServiceGroup() registers on a ServiceGroup shared with other services
+// Existing services: AddOrderFulfillmentServiceEndpoints() registers on a micro.Service added elsewhere
 //
 // Endpoint metadata can be configured via proto options (nats.micro.endpoint).metadata
-func RegisterOrderFulfillmentServiceHandlers(nc *nats.Conn, impl OrderFulfillmentServiceNats, opts ...RegisterOption) (OrderFulfillmentServiceService, error) {
+func RegisterOrderFulfillmentServiceHandlers(nc *nats.Conn, impl OrderFulfillmentServiceNats, opts ...RegisterOption) (_ OrderFulfillmentServiceService, err error) {
 	cfg := &registerConfig{
-		name:          "order_fulfillment_service",
-		version:       "1.0.0",
-		description:   "Order fulfillment service",
-		subjectPrefix: "api.v1",
-		timeout:       0 * time.Second, // Service-level timeout (0 = no timeout)
-		metadata:      map[string]string{},
+		name:           "order_fulfillment_service",
+		version:        "1.0.0",
+		description:    "Order fulfillment service",
+		subjectPrefix:  "api.v1",
+		queueGroup:     "",
+		timeout:        0 * time.Second, // Service-level timeout (0 = no timeout)
+		tokenSanitizer: SanitizeToken,
+		streamWindow:   defaultStreamWindow,
+		metadata:       map[string]string{},
 	}
 	for _, opt := range opts {
 		opt(cfg)
 	}
+	return registerOrderFulfillmentServiceEndpoints(nc, impl, cfg, func() (*serviceHost, bool, error) {
+		// Register on the ServiceGroup, or add a micro.Service for this service
+		if cfg.group != nil {
+			return cfg.group.host, true, nil
+		}
+		host, err := addServiceHost(nc, cfg, mergeMetadata(cfg.metadata, map[string]string{"schema_hash": OrderFulfillmentServiceSchemaHash}))
+		return host, false, err
+	})
+}
 
-	svc, err := micro.AddService(nc, micro.Config{
-		Name:         cfg.name,
-		Version:      cfg.version,
-		Metadata:     cfg.metadata,
-		Description:  cfg.description,
-		StatsHandler: cfg.statsHandler,
-		DoneHandler:  cfg.doneHandler,
-		ErrorHandler: cfg.errorHandler,
+// AddOrderFulfillmentServiceEndpoints registers the OrderFulfillmentService endpoints on svc, a
+// micro.Service the caller added on nc, so they share its name, version and stats
+// with the endpoints of other services and the caller's own:
+//
+//	svc, err := micro.AddService(nc, micro.Config{Name: "catalog", Version: "1.0.0"})
+//	...
+//	err = AddOrderFulfillmentServiceEndpoints(nc, svc, impl, WithServerInterceptor(auth))
+//
+// As on a ServiceGroup, endpoint names are qualified with the service name
+// ("order_fulfillment_service-health") while subjects keep the subject prefix, and the
+// schema_hash is advertised as endpoint metadata. Options of the micro.Service
+// itself, which NewServiceGroup lists, are rejected with an error, as is
+// WithServiceGroup. Stopping svc stops the endpoints; there is no Drain.
+func AddOrderFulfillmentServiceEndpoints(nc *nats.Conn, svc micro.Service, impl OrderFulfillmentServiceNats, opts ...RegisterOption) error {
+	cfg := &registerConfig{
+		subjectPrefix:  "api.v1",
+		queueGroup:     "",
+		timeout:        0 * time.Second, // Service-level timeout (0 = no timeout)
+		tokenSanitizer: SanitizeToken,
+		streamWindow:   defaultStreamWindow,
+		metadata:       map[string]string{},
+	}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	host, err := existingServiceHost(nc, svc, cfg)
+	if err != nil {
+		return err
+	}
+	// The subjects and $schema document describe the proto service
+	cfg.name, cfg.version = "order_fulfillment_service", "1.0.0"
+	_, err = registerOrderFulfillmentServiceEndpoints(nc, impl, cfg, func() (*serviceHost, bool, error) {
+		return host, true, nil
 	})
+	return err
+}
+
+// registerOrderFulfillmentServiceEndpoints registers the OrderFulfillmentService endpoints cfg describes
+// on the host attach returns, once the configuration checks out. Endpoint names
+// are qualified when attach reports the host is shared with other services.
+func registerOrderFulfillmentServiceEndpoints(nc *nats.Conn, impl OrderFulfillmentServiceNats, cfg *registerConfig, attach func() (*serviceHost, bool, error)) (_ OrderFulfillmentServiceService, err error) {
+
+	// Describe each endpoint from the schema blob
+	schemaMetadata, err := schemaEndpointMetadata(orderFulfillmentServiceSchema, "order.v1.OrderFulfillmentService")
 	if err != nil {
-		return nil, fmt.Errorf("failed to add service: %w", err)
+		return nil, err
+	}
+	endpointMethods := map[string]bool{
+		"PrepareOrder":         true,
+		"ShipOrder":            true,
+		"GetFulfillmentStatus": true,
+	}
+	for method := range cfg.endpointMetadata {
+		if !endpointMethods[method] {
+			return nil, fmt.Errorf("WithEndpointMetadata: OrderFulfillmentService has no method %s", method)
+		}
 	}
 
+	// Streams are compressed with gzip unless WithCompression names another codec
+	compressor := compressorFor(streamEncodingGzip)
+	if cfg.compressionCodec != "" {
+		if compressor = compressorFor(cfg.compressionCodec); compressor == nil {
+			return nil, fmt.Errorf("WithCompression: codec %q is not registered; add it with RegisterCompressor", cfg.compressionCodec)
+		}
+	}
+
+	host, shared, err := attach()
+	if err != nil {
+		return nil, err
+	}
+	endpointPrefix := "" // Qualifies endpoint names on a shared micro.Service
+	if shared {
+		endpointPrefix = "order_fulfillment_service-"
+	} else {
+		// Stop the micro.Service again if an endpoint fails to register
+		defer func() {
+			if err != nil {
+				host.svc.Stop()
+			}
+		}()
+	}
+	svc, pool := host.svc, host.pool
+
 	// Chain server interceptors
 	var chainedInterceptor UnaryServerInterceptor
 	if len(cfg.serverInterceptors) > 0 {
@@ -217,31 +422,67 @@ func RegisterOrderFulfillmentServiceHandlers(nc *nats.Conn, impl OrderFulfillmen
 	}
 
 	handlers := &orderFulfillmentServiceHandlers{
-		impl:           impl,
-		serviceTimeout: cfg.timeout,
-		useJSON:        false,
-		interceptor:    chainedInterceptor,
-		js:             cfg.js,
+		nc:                nc,
+		impl:              impl,
+		serviceTimeout:    cfg.timeout,
+		useJSON:           false,
+		interceptor:       chainedInterceptor,
+		js:                cfg.js,
+		cancels:           host.cancels,
+		tokenSanitizer:    cfg.tokenSanitizer,
+		idGenerator:       cfg.idGenerator,
+		maxRequestSize:    cfg.maxRequestSize,
+		streamWindow:      cfg.streamWindow,
+		streamAllowGaps:   cfg.streamAllowGaps,
+		streamCompression: cfg.streamCompression,
+		compression:       cfg.compression,
+		compressor:        compressor,
+		responseHeaders:   cfg.responseHeaders,
+		requestCheck:      cfg.requestCheck,
+		streamInterceptor: chainStreamServerInterceptors(cfg.streamInterceptors),
+		endpointPrefix:    endpointPrefix,
+		inflight:          host.inflight,
+		sampler:           newSampler(cfg.sampling, cfg.samplingSalt),
+		maxServerDeadline: cfg.maxServerDeadline,
+		persistenceErrors: cfg.persistenceErrors,
+		panics:            cfg.panicDiagnostics,
+		failures:          newFailureRecorder(cfg),
 	}
 
-	// Map of endpoint names to their handlers
+	// Map of endpoint names to their handlers; unary ones go through the handler pool,
+	// idempotent ones are coalesced first, and those expecting a response are
+	// deduplicated by idempotency key inside the pool
+	idempotency := newIdempotencyGuard(cfg)
 	endpoints := map[string]micro.Handler{
 
-		"prepare_order": micro.HandlerFunc(handlers.PrepareOrder),
+		"prepare_order": pool.wrap(endpointPrefix+"prepare_order", idempotency.wrap(endpointPrefix+"prepare_order", micro.HandlerFunc(handlers.PrepareOrder))),
 
-		"ship_order": micro.HandlerFunc(handlers.ShipOrder),
+		"ship_order": pool.wrap(endpointPrefix+"ship_order", idempotency.wrap(endpointPrefix+"ship_order", micro.HandlerFunc(handlers.ShipOrder))),
 
-		"get_fulfillment_status": micro.HandlerFunc(handlers.GetFulfillmentStatus),
+		"get_fulfillment_status": pool.wrap(endpointPrefix+"get_fulfillment_status", idempotency.wrap(endpointPrefix+"get_fulfillment_status", micro.HandlerFunc(handlers.GetFulfillmentStatus))),
 	}
 
-	// Map of endpoint names to their metadata
+	// Map of endpoint names to their metadata: the schema's description of the
+	// method, overlaid with (natsmicro.endpoint).metadata, then WithEndpointMetadata
 	endpointMetadata := map[string]map[string]string{
 
-		"prepare_order": {},
+		"prepare_order": mergeMetadata(mergeMetadata(schemaMetadata["PrepareOrder"], map[string]string{}), cfg.endpointMetadata["PrepareOrder"]),
+
+		"ship_order": mergeMetadata(mergeMetadata(schemaMetadata["ShipOrder"], map[string]string{}), cfg.endpointMetadata["ShipOrder"]),
+
+		"get_fulfillment_status": mergeMetadata(mergeMetadata(schemaMetadata["GetFulfillmentStatus"], map[string]string{}), cfg.endpointMetadata["GetFulfillmentStatus"]),
+	}
 
-		"ship_order": {},
+	// Map of endpoint names to exact subjects from (natsmicro.endpoint).subject,
+	// or the wildcard form of subject_template
+	// These endpoints are registered outside the subject prefix group
+	endpointSubjects := map[string]string{}
 
-		"get_fulfillment_status": {},
+	// Endpoints WithInstanceSubjects also serves on <subject>.<instance ID>
+	instanceEndpoints := map[string]bool{
+		"prepare_order":          true,
+		"ship_order":             true,
+		"get_fulfillment_status": true,
 	}
 
 	// Use interface to handle both Service and Group
@@ -249,35 +490,146 @@ func RegisterOrderFulfillmentServiceHandlers(nc *nats.Conn, impl OrderFulfillmen
 		AddEndpoint(string, micro.Handler, ...micro.EndpointOpt) error
 	}
 
-	var adder endpointAdder = svc
+	var group endpointAdder = svc
 	if cfg.subjectPrefix != "" {
-		adder = svc.AddGroup(cfg.subjectPrefix)
+		group = svc.AddGroup(cfg.subjectPrefix)
 	}
 
 	// Register all endpoints with their metadata
 	for name, handler := range endpoints {
 		opts := []micro.EndpointOpt{}
-		if metadata, exists := endpointMetadata[name]; exists && len(metadata) > 0 {
+		metadata := endpointMetadata[name]
+		if cfg.maxRequestSize > 0 {
+			// Advertise the request size limit alongside the proto metadata
+			withLimit := map[string]string{"max_request_size": fmt.Sprint(cfg.maxRequestSize)}
+			for k, v := range metadata {
+				withLimit[k] = v
+			}
+			metadata = withLimit
+		}
+		if shared {
+			// Keep the unqualified subject; the shared service's metadata cannot
+			// carry every service's schema hash
+			opts = append(opts, micro.WithEndpointSubject(name))
+			metadata = mergeMetadata(metadata, map[string]string{"schema_hash": OrderFulfillmentServiceSchemaHash})
+			if cfg.queueGroup != "" {
+				opts = append(opts, micro.WithEndpointQueueGroup(cfg.queueGroup))
+			}
+		}
+		if len(metadata) > 0 {
 			opts = append(opts, micro.WithEndpointMetadata(metadata))
 		}
-		if err := adder.AddEndpoint(name, handler, opts...); err != nil {
+		adder := group
+		subject := name
+		if exact, exists := endpointSubjects[name]; exists {
+			adder = svc
+			subject = exact
+			opts = append(opts, micro.WithEndpointSubject(subject))
+		}
+		if cfg.instanceSubjects && instanceEndpoints[name] {
+			// Responses name the instance, for ResponderInstance
+			handler = respondAsInstance(svc.Info().ID, handler)
+		}
+		if err := adder.AddEndpoint(endpointPrefix+name, holdRequests(cfg.hold, handler), opts...); err != nil {
 			return nil, fmt.Errorf("failed to add endpoint %s: %w", name, err)
 		}
+		if cfg.instanceSubjects && instanceEndpoints[name] {
+			// The same handler on this instance's own subject, for WithTargetInstance
+			opts = append(opts, micro.WithEndpointSubject(subject+"."+svc.Info().ID))
+			if err := adder.AddEndpoint(endpointPrefix+name+"-instance", holdRequests(cfg.hold, handler), opts...); err != nil {
+				return nil, fmt.Errorf("failed to add instance endpoint %s: %w", name, err)
+			}
+		}
+	}
+
+	pool.start()
+
+	// Application health endpoint, registered outside the subject prefix group
+	if !cfg.noHealthEndpoint {
+		subject := healthSubject(cfg.subjectPrefix, "order_fulfillment_service")
+		if err := svc.AddEndpoint(endpointPrefix+"health", holdRequests(cfg.hold, newHealthHandler(impl, cfg.timeout)), micro.WithEndpointSubject(subject)); err != nil {
+			return nil, fmt.Errorf("failed to add health endpoint: %w", err)
+		}
+	}
+
+	// Schema reflection endpoint, registered outside the subject prefix group
+	if !cfg.noReflectEndpoint {
+		subject := reflectSubject(cfg.subjectPrefix, "order_fulfillment_service")
+		if err := svc.AddEndpoint(endpointPrefix+"reflect", holdRequests(cfg.hold, newReflectHandler(orderFulfillmentServiceSchema, OrderFulfillmentServiceSchemaHash)), micro.WithEndpointSubject(subject)); err != nil {
+			return nil, fmt.Errorf("failed to add reflect endpoint: %w", err)
+		}
+	}
+
+	// Schema document endpoint, registered outside the subject prefix group
+	if !cfg.noSchemaEndpoint {
+		handler, err := newSchemaHandler(orderFulfillmentServiceSchemaDocument, cfg)
+		if err != nil {
+			return nil, err
+		}
+		subject := schemaSubject(cfg.subjectPrefix, "order_fulfillment_service")
+		if err := svc.AddEndpoint(endpointPrefix+"schema", holdRequests(cfg.hold, handler), micro.WithEndpointSubject(subject)); err != nil {
+			return nil, fmt.Errorf("failed to add schema endpoint: %w", err)
+		}
+	}
+
+	queueGroup := cfg.queueGroup
+	if queueGroup == "" {
+		queueGroup = micro.DefaultQueueGroup
 	}
 
 	return &orderFulfillmentServiceService{
-		Service:       svc,
-		subjectPrefix: cfg.subjectPrefix,
+		Service:        svc,
+		subjectPrefix:  cfg.subjectPrefix,
+		queueGroup:     queueGroup,
+		maxRequestSize: cfg.maxRequestSize,
+		inflight:       handlers.inflight,
+		interceptors:   interceptorChainNames(cfg.serverInterceptors),
+		sampler:        handlers.sampler,
 	}, nil
 }
 
+// OrderFulfillmentServiceRegistration describes a RegisterOrderFulfillmentServiceHandlers call for
+// RegisterGroup, which registers it together with other services, all or nothing
+func OrderFulfillmentServiceRegistration(impl OrderFulfillmentServiceNats, opts ...RegisterOption) Registration {
+	return registrationFunc(func(nc *nats.Conn, hold func(context.Context) error) (GroupService, error) {
+		return RegisterOrderFulfillmentServiceHandlers(nc, impl, append(opts[:len(opts):len(opts)], withRequestHold(hold))...)
+	})
+}
+
 // orderFulfillmentServiceHandlers wraps the service implementation with NATS handlers
 type orderFulfillmentServiceHandlers struct {
-	impl           OrderFulfillmentServiceNats
-	serviceTimeout time.Duration          // Default timeout for all endpoints
-	useJSON        bool                   // Use JSON encoding instead of binary protobuf
-	interceptor    UnaryServerInterceptor // Chained interceptors
-	js             jetstream.JetStream    // Optional JetStream context for KV/ObjectStore
+	nc                 *nats.Conn // NATS connection for streaming
+	impl               OrderFulfillmentServiceNats
+	serviceTimeout     time.Duration                     // Default timeout for all endpoints
+	useJSON            bool                              // Use JSON encoding instead of binary protobuf
+	interceptor        UnaryServerInterceptor            // Chained interceptors
+	methodInterceptors map[string]UnaryServerInterceptor // Interceptors plus (natsmicro.endpoint).middlewares, by method
+	js                 jetstream.JetStream               // Optional JetStream context for KV/ObjectStore
+	cancels            *cancelRegistry                   // Client cancel notices (nil unless WithCancelPropagation)
+	tokenSanitizer     func(string) string               // Escapes request fields in KV/Object Store keys
+	idGenerator        func() string                     // Mints stream inbox and persistent call IDs (nil = NUIDs)
+	maxRequestSize     int                               // Largest accepted request payload in bytes (0 = unlimited)
+	inflight           *inflightTracker                  // Running handlers, for Drain
+	persistentStreams  map[string]*persistentStream      // (natsmicro.stream).persistence streams, by method
+	streamWindow       int                               // Server-stream flow control cap (0 = none)
+	streamCompression  int                               // Smallest stream message compressed, once negotiated (0 = off)
+	compression        int                               // Smallest unary response compressed, if the client accepts it (0 = off)
+	compressor         Compressor                        // Codec for responses and stream messages
+	streamAllowGaps    bool                              // Skip lost client-stream messages instead of failing Recv
+	responseHeaders    *headerPolicy                     // Strips response headers (nil = allow all)
+	requestCheck       *requestImmutabilityCheck         // Reports handlers that modify their request (nil = off)
+	streamInterceptor  StreamServerInterceptor           // Chained stream interceptors
+	endpointPrefix     string                            // Qualifies endpoint names within a ServiceGroup
+	sampler            *sampler                          // Samples unary calls (WithSampling)
+	maxServerDeadline  time.Duration                     // Cap on propagated client deadlines (0 = none)
+	persistenceErrors  func(method string, err error)    // Receives failed KV/Object Store writes (nil = print)
+	panics             *panicDiagnostics                 // Recovers and reports handler panics (nil = off)
+	failures           *failureRecorder                  // Records failed calls for replay (nil = off)
+}
+
+// keyToken renders a request field for a key template through the token sanitizer
+func (h *orderFulfillmentServiceHandlers) keyToken(v any) string {
+	return h.tokenSanitizer(fmt.Sprint(v))
 }
 
 func (h *orderFulfillmentServiceHandlers) PrepareOrder(req micro.Request) {
@@ -285,14 +637,26 @@ func (h *orderFulfillmentServiceHandlers) PrepareOrder(req micro.Request) {
 	// If endpoint timeout is set (> 0), use it; otherwise use service timeout
 	timeout := h.serviceTimeout
 
-	// Create context with timeout if configured, otherwise use background context
-	ctx := context.Background()
+	// Create context with timeout if configured, derived from the service's drain context
+	ctx, end := h.inflight.begin(false) // Tracked for Drain
+	defer end()
 	var cancel context.CancelFunc
 	if timeout > 0 {
 		ctx, cancel = context.WithTimeout(ctx, timeout)
 		defer cancel()
 	}
 
+	// Stop when the client gives up: honor the deadline it propagated
+	ctx, cancelDeadline := withPropagatedDeadline(ctx, req.Headers(), h.maxServerDeadline)
+	defer cancelDeadline()
+
+	// Cancel the handler early if the client abandons the request
+	if h.cancels != nil {
+		var release func()
+		ctx, release = h.cancels.watch(ctx, req.Headers())
+		defer release()
+	}
+
 	// Add incoming NATS headers to context so service methods can access them
 	if req.Headers() != nil {
 		ctx = WithIncomingHeaders(ctx, req.Headers())
@@ -300,62 +664,69 @@ func (h *orderFulfillmentServiceHandlers) PrepareOrder(req micro.Request) {
 
 	// Initialize outgoing headers pointer in context so interceptors can set response headers
 	outgoingHeadersPtr := &nats.Header{}
-	ctx = context.WithValue(ctx, outgoingHeadersKey, outgoingHeadersPtr)
+	ctx = withPendingResponseHeaders(ctx, outgoingHeadersPtr)
+
+	// Reject oversized requests before decoding them, decompressing compressed ones
+	body, payloadErr := readPayload("request", req.Headers().Get(ContentEncodingHeader), req.Data(), h.maxRequestSize)
+	if payloadErr != nil {
+		code, message, data := natsErrorFields(payloadErr)
+		req.Error(code, message, data)
+		return
+	}
 
+	// Decode with the codec the client named; clients without Content-Type use the configured one
+	requestJSON, ctErr := payloadUsesJSON(req.Headers().Get(ContentTypeHeader), h.useJSON)
+	if ctErr != nil {
+		code, message, data := natsErrorFields(ctErr)
+		req.Error(code, message, data)
+		return
+	}
 	var msg PrepareOrderRequest
-	if h.useJSON {
-		if err := protojson.Unmarshal(req.Data(), &msg); err != nil {
+	if requestJSON {
+		if err := protojson.Unmarshal(body, &msg); err != nil {
 			req.Error(OrderFulfillmentServiceErrCodeInvalidArgument, fmt.Sprintf("failed to decode JSON request: %v", err), nil)
 			return
 		}
 	} else {
-		if err := proto.Unmarshal(req.Data(), &msg); err != nil {
+		if err := proto.Unmarshal(body, &msg); err != nil {
 			req.Error(OrderFulfillmentServiceErrCodeInvalidArgument, fmt.Sprintf("failed to decode request: %v", err), nil)
 			return
 		}
 	}
 
+	// Sample the call for WithSampling, capturing the request before the handler runs
+	sample := h.sampler.begin("OrderFulfillmentService", "PrepareOrder", incomingRequestID(req), false, nats.Header(req.Headers()), &msg, false)
+
 	// Define the handler function
 	handler := func(ctx context.Context, request interface{}) (interface{}, error) {
 		typedReq, ok := request.(*PrepareOrderRequest)
 		if !ok {
 			return nil, fmt.Errorf("invalid request type")
 		}
+		defer h.requestCheck.check("OrderFulfillmentService.PrepareOrder", typedReq)()
 		return h.impl.PrepareOrder(ctx, typedReq)
 	}
+	handler = h.panics.wrap("OrderFulfillmentService", "PrepareOrder", "order.v1.OrderFulfillmentService.PrepareOrder", false, handler)
+	handler = h.failures.wrap("OrderFulfillmentService", "PrepareOrder", "order.v1.OrderFulfillmentService.PrepareOrder", false, handler)
 
 	// Execute through interceptor chain if configured
 	var resp interface{}
 	var err error
-	if h.interceptor != nil {
+	interceptor := h.interceptor
+	if interceptor != nil {
 		info := &UnaryServerInfo{
-			Service: "OrderFulfillmentService",
-			Method:  "PrepareOrder",
-			Subject: "api.v1.prepare_order",
+			Service:    "OrderFulfillmentService",
+			Method:     "PrepareOrder",
+			FullMethod: "order.v1.OrderFulfillmentService.PrepareOrder",
+			Subject:    "api.v1.prepare_order",
 		}
-		resp, err = h.interceptor(ctx, &msg, info, handler)
+		resp, err = interceptor(ctx, &msg, info, handler)
 	} else {
 		resp, err = handler(ctx, &msg)
 	}
+	sample.end(resp, err)
 	if err != nil {
-		// Check if error implements custom status code/message/data methods
-		code := OrderFulfillmentServiceErrCodeInternal
-		message := err.Error()
-		var data []byte
-
-		// Check for NatsErrorCode() string method
-		if coder, ok := err.(interface{ NatsErrorCode() string }); ok {
-			code = coder.NatsErrorCode()
-		}
-		// Check for NatsErrorMessage() string method
-		if messager, ok := err.(interface{ NatsErrorMessage() string }); ok {
-			message = messager.NatsErrorMessage()
-		}
-		// Check for NatsErrorData() []byte method
-		if dataProvider, ok := err.(interface{ NatsErrorData() []byte }); ok {
-			data = dataProvider.NatsErrorData()
-		}
-
+		code, message, data := natsErrorFields(err)
 		req.Error(code, message, data)
 		return
 	}
@@ -369,7 +740,7 @@ func (h *orderFulfillmentServiceHandlers) PrepareOrder(req micro.Request) {
 
 	var data []byte
 	if h.useJSON {
-		data, err = protojson.Marshal(typedResp)
+		data, err = marshalJSON(typedResp, false)
 		if err != nil {
 			req.Error(OrderFulfillmentServiceErrCodeInternal, fmt.Sprintf("failed to encode JSON response: %v", err), nil)
 			return
@@ -384,22 +755,17 @@ func (h *orderFulfillmentServiceHandlers) PrepareOrder(req micro.Request) {
 
 	// Check if context has outgoing headers set by interceptors
 	// Read from the pointer that was initialized at the start
-	var outgoingHeaders nats.Header
-	if headersPtr, ok := ctx.Value(outgoingHeadersKey).(*nats.Header); ok && headersPtr != nil {
-		outgoingHeaders = *headersPtr
+	outgoingHeaders := pendingResponseHeaders(ctx)
+
+	// Send response with headers set by interceptors plus the response codec
+	// Convert nats.Header to micro.Headers (they are the same underlying type)
+	outgoingHeaders = h.responseHeaders.strip(h.endpointPrefix+"prepare_order", outgoingHeaders)
+	outgoingHeaders = withContentType(outgoingHeaders, h.useJSON)
+	if h.compression > 0 && acceptsEncoding(req.Headers().Get(acceptEncodingHeader), h.compressor.Name()) {
+		data = compressPayload(h.compressor, h.compression, data, outgoingHeaders)
 	}
-
-	if len(outgoingHeaders) > 0 {
-		// Send response with headers using micro.WithHeaders
-		// Convert nats.Header to micro.Headers (they are the same underlying type)
-		if err := req.Respond(data, micro.WithHeaders(micro.Headers(outgoingHeaders))); err != nil {
-			fmt.Fprintf(os.Stderr, "failed to send response for PrepareOrder: %v\n", err)
-		}
-	} else {
-		// Send response without headers
-		if err := req.Respond(data); err != nil {
-			fmt.Fprintf(os.Stderr, "failed to send response for PrepareOrder: %v\n", err)
-		}
+	if err := req.Respond(data, micro.WithHeaders(micro.Headers(outgoingHeaders))); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to send response for PrepareOrder: %v\n", err)
 	}
 }
 
@@ -408,14 +774,26 @@ func (h *orderFulfillmentServiceHandlers) ShipOrder(req micro.Request) {
 	// If endpoint timeout is set (> 0), use it; otherwise use service timeout
 	timeout := h.serviceTimeout
 
-	// Create context with timeout if configured, otherwise use background context
-	ctx := context.Background()
+	// Create context with timeout if configured, derived from the service's drain context
+	ctx, end := h.inflight.begin(false) // Tracked for Drain
+	defer end()
 	var cancel context.CancelFunc
 	if timeout > 0 {
 		ctx, cancel = context.WithTimeout(ctx, timeout)
 		defer cancel()
 	}
 
+	// Stop when the client gives up: honor the deadline it propagated
+	ctx, cancelDeadline := withPropagatedDeadline(ctx, req.Headers(), h.maxServerDeadline)
+	defer cancelDeadline()
+
+	// Cancel the handler early if the client abandons the request
+	if h.cancels != nil {
+		var release func()
+		ctx, release = h.cancels.watch(ctx, req.Headers())
+		defer release()
+	}
+
 	// Add incoming NATS headers to context so service methods can access them
 	if req.Headers() != nil {
 		ctx = WithIncomingHeaders(ctx, req.Headers())
@@ -423,62 +801,69 @@ func (h *orderFulfillmentServiceHandlers) ShipOrder(req micro.Request) {
 
 	// Initialize outgoing headers pointer in context so interceptors can set response headers
 	outgoingHeadersPtr := &nats.Header{}
-	ctx = context.WithValue(ctx, outgoingHeadersKey, outgoingHeadersPtr)
+	ctx = withPendingResponseHeaders(ctx, outgoingHeadersPtr)
+
+	// Reject oversized requests before decoding them, decompressing compressed ones
+	body, payloadErr := readPayload("request", req.Headers().Get(ContentEncodingHeader), req.Data(), h.maxRequestSize)
+	if payloadErr != nil {
+		code, message, data := natsErrorFields(payloadErr)
+		req.Error(code, message, data)
+		return
+	}
 
+	// Decode with the codec the client named; clients without Content-Type use the configured one
+	requestJSON, ctErr := payloadUsesJSON(req.Headers().Get(ContentTypeHeader), h.useJSON)
+	if ctErr != nil {
+		code, message, data := natsErrorFields(ctErr)
+		req.Error(code, message, data)
+		return
+	}
 	var msg ShipOrderRequest
-	if h.useJSON {
-		if err := protojson.Unmarshal(req.Data(), &msg); err != nil {
+	if requestJSON {
+		if err := protojson.Unmarshal(body, &msg); err != nil {
 			req.Error(OrderFulfillmentServiceErrCodeInvalidArgument, fmt.Sprintf("failed to decode JSON request: %v", err), nil)
 			return
 		}
 	} else {
-		if err := proto.Unmarshal(req.Data(), &msg); err != nil {
+		if err := proto.Unmarshal(body, &msg); err != nil {
 			req.Error(OrderFulfillmentServiceErrCodeInvalidArgument, fmt.Sprintf("failed to decode request: %v", err), nil)
 			return
 		}
 	}
 
+	// Sample the call for WithSampling, capturing the request before the handler runs
+	sample := h.sampler.begin("OrderFulfillmentService", "ShipOrder", incomingRequestID(req), false, nats.Header(req.Headers()), &msg, false)
+
 	// Define the handler function
 	handler := func(ctx context.Context, request interface{}) (interface{}, error) {
 		typedReq, ok := request.(*ShipOrderRequest)
 		if !ok {
 			return nil, fmt.Errorf("invalid request type")
 		}
+		defer h.requestCheck.check("OrderFulfillmentService.ShipOrder", typedReq)()
 		return h.impl.ShipOrder(ctx, typedReq)
 	}
+	handler = h.panics.wrap("OrderFulfillmentService", "ShipOrder", "order.v1.OrderFulfillmentService.ShipOrder", false, handler)
+	handler = h.failures.wrap("OrderFulfillmentService", "ShipOrder", "order.v1.OrderFulfillmentService.ShipOrder", false, handler)
 
 	// Execute through interceptor chain if configured
 	var resp interface{}
 	var err error
-	if h.interceptor != nil {
+	interceptor := h.interceptor
+	if interceptor != nil {
 		info := &UnaryServerInfo{
-			Service: "OrderFulfillmentService",
-			Method:  "ShipOrder",
-			Subject: "api.v1.ship_order",
+			Service:    "OrderFulfillmentService",
+			Method:     "ShipOrder",
+			FullMethod: "order.v1.OrderFulfillmentService.ShipOrder",
+			Subject:    "api.v1.ship_order",
 		}
-		resp, err = h.interceptor(ctx, &msg, info, handler)
+		resp, err = interceptor(ctx, &msg, info, handler)
 	} else {
 		resp, err = handler(ctx, &msg)
 	}
+	sample.end(resp, err)
 	if err != nil {
-		// Check if error implements custom status code/message/data methods
-		code := OrderFulfillmentServiceErrCodeInternal
-		message := err.Error()
-		var data []byte
-
-		// Check for NatsErrorCode() string method
-		if coder, ok := err.(interface{ NatsErrorCode() string }); ok {
-			code = coder.NatsErrorCode()
-		}
-		// Check for NatsErrorMessage() string method
-		if messager, ok := err.(interface{ NatsErrorMessage() string }); ok {
-			message = messager.NatsErrorMessage()
-		}
-		// Check for NatsErrorData() []byte method
-		if dataProvider, ok := err.(interface{ NatsErrorData() []byte }); ok {
-			data = dataProvider.NatsErrorData()
-		}
-
+		code, message, data := natsErrorFields(err)
 		req.Error(code, message, data)
 		return
 	}
@@ -492,7 +877,7 @@ func (h *orderFulfillmentServiceHandlers) ShipOrder(req micro.Request) {
 
 	var data []byte
 	if h.useJSON {
-		data, err = protojson.Marshal(typedResp)
+		data, err = marshalJSON(typedResp, false)
 		if err != nil {
 			req.Error(OrderFulfillmentServiceErrCodeInternal, fmt.Sprintf("failed to encode JSON response: %v", err), nil)
 			return
@@ -507,22 +892,17 @@ func (h *orderFulfillmentServiceHandlers) ShipOrder(req micro.Request) {
 
 	// Check if context has outgoing headers set by interceptors
 	// Read from the pointer that was initialized at the start
-	var outgoingHeaders nats.Header
-	if headersPtr, ok := ctx.Value(outgoingHeadersKey).(*nats.Header); ok && headersPtr != nil {
-		outgoingHeaders = *headersPtr
+	outgoingHeaders := pendingResponseHeaders(ctx)
+
+	// Send response with headers set by interceptors plus the response codec
+	// Convert nats.Header to micro.Headers (they are the same underlying type)
+	outgoingHeaders = h.responseHeaders.strip(h.endpointPrefix+"ship_order", outgoingHeaders)
+	outgoingHeaders = withContentType(outgoingHeaders, h.useJSON)
+	if h.compression > 0 && acceptsEncoding(req.Headers().Get(acceptEncodingHeader), h.compressor.Name()) {
+		data = compressPayload(h.compressor, h.compression, data, outgoingHeaders)
 	}
-
-	if len(outgoingHeaders) > 0 {
-		// Send response with headers using micro.WithHeaders
-		// Convert nats.Header to micro.Headers (they are the same underlying type)
-		if err := req.Respond(data, micro.WithHeaders(micro.Headers(outgoingHeaders))); err != nil {
-			fmt.Fprintf(os.Stderr, "failed to send response for ShipOrder: %v\n", err)
-		}
-	} else {
-		// Send response without headers
-		if err := req.Respond(data); err != nil {
-			fmt.Fprintf(os.Stderr, "failed to send response for ShipOrder: %v\n", err)
-		}
+	if err := req.Respond(data, micro.WithHeaders(micro.Headers(outgoingHeaders))); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to send response for ShipOrder: %v\n", err)
 	}
 }
 
@@ -531,14 +911,26 @@ func (h *orderFulfillmentServiceHandlers) GetFulfillmentStatus(req micro.Request
 	// If endpoint timeout is set (> 0), use it; otherwise use service timeout
 	timeout := h.serviceTimeout
 
-	// Create context with timeout if configured, otherwise use background context
-	ctx := context.Background()
+	// Create context with timeout if configured, derived from the service's drain context
+	ctx, end := h.inflight.begin(false) // Tracked for Drain
+	defer end()
 	var cancel context.CancelFunc
 	if timeout > 0 {
 		ctx, cancel = context.WithTimeout(ctx, timeout)
 		defer cancel()
 	}
 
+	// Stop when the client gives up: honor the deadline it propagated
+	ctx, cancelDeadline := withPropagatedDeadline(ctx, req.Headers(), h.maxServerDeadline)
+	defer cancelDeadline()
+
+	// Cancel the handler early if the client abandons the request
+	if h.cancels != nil {
+		var release func()
+		ctx, release = h.cancels.watch(ctx, req.Headers())
+		defer release()
+	}
+
 	// Add incoming NATS headers to context so service methods can access them
 	if req.Headers() != nil {
 		ctx = WithIncomingHeaders(ctx, req.Headers())
@@ -546,62 +938,69 @@ func (h *orderFulfillmentServiceHandlers) GetFulfillmentStatus(req micro.Request
 
 	// Initialize outgoing headers pointer in context so interceptors can set response headers
 	outgoingHeadersPtr := &nats.Header{}
-	ctx = context.WithValue(ctx, outgoingHeadersKey, outgoingHeadersPtr)
+	ctx = withPendingResponseHeaders(ctx, outgoingHeadersPtr)
 
+	// Reject oversized requests before decoding them, decompressing compressed ones
+	body, payloadErr := readPayload("request", req.Headers().Get(ContentEncodingHeader), req.Data(), h.maxRequestSize)
+	if payloadErr != nil {
+		code, message, data := natsErrorFields(payloadErr)
+		req.Error(code, message, data)
+		return
+	}
+
+	// Decode with the codec the client named; clients without Content-Type use the configured one
+	requestJSON, ctErr := payloadUsesJSON(req.Headers().Get(ContentTypeHeader), h.useJSON)
+	if ctErr != nil {
+		code, message, data := natsErrorFields(ctErr)
+		req.Error(code, message, data)
+		return
+	}
 	var msg GetFulfillmentStatusRequest
-	if h.useJSON {
-		if err := protojson.Unmarshal(req.Data(), &msg); err != nil {
+	if requestJSON {
+		if err := protojson.Unmarshal(body, &msg); err != nil {
 			req.Error(OrderFulfillmentServiceErrCodeInvalidArgument, fmt.Sprintf("failed to decode JSON request: %v", err), nil)
 			return
 		}
 	} else {
-		if err := proto.Unmarshal(req.Data(), &msg); err != nil {
+		if err := proto.Unmarshal(body, &msg); err != nil {
 			req.Error(OrderFulfillmentServiceErrCodeInvalidArgument, fmt.Sprintf("failed to decode request: %v", err), nil)
 			return
 		}
 	}
 
+	// Sample the call for WithSampling, capturing the request before the handler runs
+	sample := h.sampler.begin("OrderFulfillmentService", "GetFulfillmentStatus", incomingRequestID(req), false, nats.Header(req.Headers()), &msg, false)
+
 	// Define the handler function
 	handler := func(ctx context.Context, request interface{}) (interface{}, error) {
 		typedReq, ok := request.(*GetFulfillmentStatusRequest)
 		if !ok {
 			return nil, fmt.Errorf("invalid request type")
 		}
+		defer h.requestCheck.check("OrderFulfillmentService.GetFulfillmentStatus", typedReq)()
 		return h.impl.GetFulfillmentStatus(ctx, typedReq)
 	}
+	handler = h.panics.wrap("OrderFulfillmentService", "GetFulfillmentStatus", "order.v1.OrderFulfillmentService.GetFulfillmentStatus", false, handler)
+	handler = h.failures.wrap("OrderFulfillmentService", "GetFulfillmentStatus", "order.v1.OrderFulfillmentService.GetFulfillmentStatus", false, handler)
 
 	// Execute through interceptor chain if configured
 	var resp interface{}
 	var err error
-	if h.interceptor != nil {
+	interceptor := h.interceptor
+	if interceptor != nil {
 		info := &UnaryServerInfo{
-			Service: "OrderFulfillmentService",
-			Method:  "GetFulfillmentStatus",
-			Subject: "api.v1.get_fulfillment_status",
+			Service:    "OrderFulfillmentService",
+			Method:     "GetFulfillmentStatus",
+			FullMethod: "order.v1.OrderFulfillmentService.GetFulfillmentStatus",
+			Subject:    "api.v1.get_fulfillment_status",
 		}
-		resp, err = h.interceptor(ctx, &msg, info, handler)
+		resp, err = interceptor(ctx, &msg, info, handler)
 	} else {
 		resp, err = handler(ctx, &msg)
 	}
+	sample.end(resp, err)
 	if err != nil {
-		// Check if error implements custom status code/message/data methods
-		code := OrderFulfillmentServiceErrCodeInternal
-		message := err.Error()
-		var data []byte
-
-		// Check for NatsErrorCode() string method
-		if coder, ok := err.(interface{ NatsErrorCode() string }); ok {
-			code = coder.NatsErrorCode()
-		}
-		// Check for NatsErrorMessage() string method
-		if messager, ok := err.(interface{ NatsErrorMessage() string }); ok {
-			message = messager.NatsErrorMessage()
-		}
-		// Check for NatsErrorData() []byte method
-		if dataProvider, ok := err.(interface{ NatsErrorData() []byte }); ok {
-			data = dataProvider.NatsErrorData()
-		}
-
+		code, message, data := natsErrorFields(err)
 		req.Error(code, message, data)
 		return
 	}
@@ -615,7 +1014,7 @@ func (h *orderFulfillmentServiceHandlers) GetFulfillmentStatus(req micro.Request
 
 	var data []byte
 	if h.useJSON {
-		data, err = protojson.Marshal(typedResp)
+		data, err = marshalJSON(typedResp, false)
 		if err != nil {
 			req.Error(OrderFulfillmentServiceErrCodeInternal, fmt.Sprintf("failed to encode JSON response: %v", err), nil)
 			return
@@ -630,48 +1029,121 @@ func (h *orderFulfillmentServiceHandlers) GetFulfillmentStatus(req micro.Request
 
 	// Check if context has outgoing headers set by interceptors
 	// Read from the pointer that was initialized at the start
-	var outgoingHeaders nats.Header
-	if headersPtr, ok := ctx.Value(outgoingHeadersKey).(*nats.Header); ok && headersPtr != nil {
-		outgoingHeaders = *headersPtr
+	outgoingHeaders := pendingResponseHeaders(ctx)
+
+	// Send response with headers set by interceptors plus the response codec
+	// Convert nats.Header to micro.Headers (they are the same underlying type)
+	outgoingHeaders = h.responseHeaders.strip(h.endpointPrefix+"get_fulfillment_status", outgoingHeaders)
+	outgoingHeaders = withContentType(outgoingHeaders, h.useJSON)
+	if h.compression > 0 && acceptsEncoding(req.Headers().Get(acceptEncodingHeader), h.compressor.Name()) {
+		data = compressPayload(h.compressor, h.compression, data, outgoingHeaders)
 	}
-
-	if len(outgoingHeaders) > 0 {
-		// Send response with headers using micro.WithHeaders
-		// Convert nats.Header to micro.Headers (they are the same underlying type)
-		if err := req.Respond(data, micro.WithHeaders(micro.Headers(outgoingHeaders))); err != nil {
-			fmt.Fprintf(os.Stderr, "failed to send response for GetFulfillmentStatus: %v\n", err)
-		}
-	} else {
-		// Send response without headers
-		if err := req.Respond(data); err != nil {
-			fmt.Fprintf(os.Stderr, "failed to send response for GetFulfillmentStatus: %v\n", err)
-		}
+	if err := req.Respond(data, micro.WithHeaders(micro.Headers(outgoingHeaders))); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to send response for GetFulfillmentStatus: %v\n", err)
 	}
 }
 
 // OrderFulfillmentServiceNatsClientInterface is the interface for the NATS client
 // This interface allows for easier dependency injection and testing
 type OrderFulfillmentServiceNatsClientInterface interface {
-	PrepareOrder(context.Context, *PrepareOrderRequest) (*PrepareOrderResponse, error)
-	ShipOrder(context.Context, *ShipOrderRequest) (*ShipOrderResponse, error)
-	GetFulfillmentStatus(context.Context, *GetFulfillmentStatusRequest) (*GetFulfillmentStatusResponse, error)
+	PrepareOrder(context.Context, *PrepareOrderRequest, ...CallOption) (*PrepareOrderResponse, error)
+	ShipOrder(context.Context, *ShipOrderRequest, ...CallOption) (*ShipOrderResponse, error)
+	GetFulfillmentStatus(context.Context, *GetFulfillmentStatusRequest, ...CallOption) (*GetFulfillmentStatusResponse, error)
+	Health(ctx context.Context) (*HealthResponse, error)
+	Schema(ctx context.Context) (*ServiceSchemaDocument, error)
 	Endpoints() []OrderFulfillmentServiceEndpointInfo
+	WithHeaders(headers nats.Header) OrderFulfillmentServiceNatsClientInterface
+	Close()
 }
 
 // OrderFulfillmentServiceNatsClient is the concrete implementation of OrderFulfillmentServiceNatsClientInterface
 type OrderFulfillmentServiceNatsClient struct {
-	nc            *nats.Conn
-	subjectPrefix string
-	useJSON       bool                   // Use JSON encoding instead of binary protobuf
-	interceptor   UnaryClientInterceptor // Chained interceptors
-	js            jetstream.JetStream    // Optional JetStream for KV/ObjectStore reads
+	nc                  *nats.Conn
+	subjectPrefix       string
+	useJSON             bool                    // Use JSON encoding instead of binary protobuf
+	interceptor         UnaryClientInterceptor  // Chained interceptors
+	streamInterceptor   StreamClientInterceptor // Chained stream interceptors
+	js                  jetstream.JetStream     // Optional JetStream for KV/ObjectStore reads
+	kvStaleAfter        time.Duration           // Age at which Get*Cached treats KV entries as misses (0 = never)
+	cancelPropagation   bool                    // Publish a cancel notice when ctx ends mid-request
+	timeout             time.Duration           // Default unary timeout when ctx has no deadline
+	retry               *retryPolicy            // Unary retry policy (nil = no retries)
+	tokenSanitizer      func(string) string     // Escapes request fields in key helpers
+	idGenerator         func() string           // Mints cancel subject and stream inbox IDs (nil = NUIDs)
+	maxResponseSize     int                     // Largest accepted response payload in bytes (0 = unlimited)
+	connSelector        func() *nats.Conn       // Picks the connection per call (nil = nc)
+	journal             *journal                // Records calls for replay (nil = no journal)
+	streamWindow        int                     // Server-stream flow control window (0 = none)
+	streamAllowGaps     bool                    // Skip lost stream messages instead of failing Recv
+	streamCompression   int                     // Smallest stream message compressed, once negotiated (0 = off)
+	compression         int                     // Smallest unary request compressed (0 = off)
+	compressionCodec    string                  // Codec unary requests are compressed with
+	streamResumeOnDrain bool                    // Reopen server streams when their server drains
+	headerPolicy        *headerPolicy           // Strips outgoing headers (nil = allow all)
+	sampler             *sampler                // Samples unary calls (WithClientSampling)
+	monitor             *connectionMonitor      // Samples the connections (WithClientConnectionMonitor)
+	headers             nats.Header             // Sent under each call's OutgoingHeaders (WithHeaders)
+}
+
+// compress returns a unary request payload compressed as WithClientCompression
+// asks, flagged in headers
+func (c *OrderFulfillmentServiceNatsClient) compress(data []byte, headers nats.Header) ([]byte, error) {
+	if c.compression == 0 {
+		return data, nil
+	}
+	codec := compressorFor(c.compressionCodec)
+	if codec == nil {
+		return nil, fmt.Errorf("WithClientCompression: codec %q is not registered; add it with RegisterCompressor", c.compressionCodec)
+	}
+	return compressPayload(codec, c.compression, data, headers), nil
+}
+
+// conn returns the connection for the next call or stream
+func (c *OrderFulfillmentServiceNatsClient) conn() *nats.Conn {
+	if c.connSelector != nil {
+		return c.connSelector()
+	}
+	return c.nc
+}
+
+// outgoingHeaders returns the headers of ctx that WithOutgoingHeaderPolicy lets a call send
+func (c *OrderFulfillmentServiceNatsClient) outgoingHeaders(ctx context.Context) nats.Header {
+	headers, _ := c.headerPolicy.filter(layerHeaders(c.headers, OutgoingHeaders(ctx)))
+	return headers
+}
+
+// WithHeaders returns a view of the client that sends headers with every call and
+// stream, beneath those set on the call's context with WithOutgoingHeaders. A view
+// shares the client's connections, interceptors, retry policy, journal and sampler
+// rather than copying them, so it is cheap to make per request, e.g. per tenant, and
+// like the client is safe for concurrent use. Views of views add to their parent's
+// headers. Closing a view does not stop the client's connection monitor.
+func (c *OrderFulfillmentServiceNatsClient) WithHeaders(headers nats.Header) OrderFulfillmentServiceNatsClientInterface {
+	layered := make(nats.Header, len(c.headers)+len(headers))
+	for name, values := range c.headers {
+		layered[name] = values
+	}
+	for name, values := range headers {
+		layered[name] = append([]string(nil), values...) // Later changes to headers stay out of the view
+	}
+	view := *c
+	view.headers = layered
+	view.monitor = nil
+	return &view
+}
+
+// keyToken renders a request field for a key template through the token sanitizer
+func (c *OrderFulfillmentServiceNatsClient) keyToken(v any) string {
+	return c.tokenSanitizer(fmt.Sprint(v))
 }
 
 // NewOrderFulfillmentServiceNatsClient creates a new NATS client for OrderFulfillmentService.
 // The client sends requests over NATS using protobuf serialization (or JSON if configured).
 func NewOrderFulfillmentServiceNatsClient(nc *nats.Conn, opts ...NatsClientOption) OrderFulfillmentServiceNatsClientInterface {
 	cfg := &natsClientConfig{
-		subjectPrefix: "api.v1",
+		subjectPrefix:  "api.v1",
+		tokenSanitizer: SanitizeToken,
+		streamWindow:   defaultStreamWindow,
 	}
 	for _, opt := range opts {
 		opt.applyNatsClientOption(cfg)
@@ -684,30 +1156,78 @@ func NewOrderFulfillmentServiceNatsClient(nc *nats.Conn, opts ...NatsClientOptio
 	}
 
 	c := &OrderFulfillmentServiceNatsClient{
-		nc:            nc,
-		subjectPrefix: cfg.subjectPrefix,
-		useJSON:       false,
-		interceptor:   chainedInterceptor,
-		js:            cfg.js,
+		nc:                  nc,
+		subjectPrefix:       cfg.subjectPrefix,
+		useJSON:             false,
+		interceptor:         chainedInterceptor,
+		streamInterceptor:   chainStreamClientInterceptors(cfg.streamInterceptors),
+		js:                  cfg.js,
+		kvStaleAfter:        cfg.kvStaleAfter,
+		cancelPropagation:   cfg.cancelPropagation,
+		timeout:             cfg.timeout,
+		retry:               newRetryPolicy(cfg),
+		tokenSanitizer:      cfg.tokenSanitizer,
+		idGenerator:         cfg.idGenerator,
+		maxResponseSize:     cfg.maxResponseSize,
+		connSelector:        cfg.connSelector,
+		journal:             cfg.journal,
+		streamWindow:        cfg.streamWindow,
+		streamAllowGaps:     cfg.streamAllowGaps,
+		streamCompression:   cfg.streamCompression,
+		compression:         cfg.compression,
+		compressionCodec:    cfg.compressionCodec,
+		streamResumeOnDrain: cfg.streamResumeOnDrain,
+		headerPolicy:        cfg.headerPolicy,
+		sampler:             newSampler(cfg.sampling, cfg.samplingSalt),
+		monitor:             startConnectionMonitor(cfg.connMonitor, monitoredConns(nc, cfg.poolConns)...),
 	}
 	return c
 }
 
+// NewOrderFulfillmentServiceNatsClientPool creates a OrderFulfillmentService client that spreads calls
+// over conns round-robin (see RoundRobinConns). Streams stay on the connection they
+// opened on. A WithConnSelector in opts replaces the round-robin selector.
+func NewOrderFulfillmentServiceNatsClientPool(conns []*nats.Conn, opts ...NatsClientOption) OrderFulfillmentServiceNatsClientInterface {
+	selector := RoundRobinConns(conns)
+	opts = append([]NatsClientOption{natsClientOptionFunc(func(c *natsClientConfig) { c.poolConns = conns })}, opts...)
+	return NewOrderFulfillmentServiceNatsClient(conns[0], append([]NatsClientOption{WithConnSelector(selector)}, opts...)...)
+}
+
 // PrepareOrder sends a PrepareOrder request to the service via NATS.
-// Returns an error if the request fails or the service returns an error.
-func (c *OrderFulfillmentServiceNatsClient) PrepareOrder(ctx context.Context, req *PrepareOrderRequest) (*PrepareOrderResponse, error) {
+// Returns an error if the request fails or the service returns an error,
+// or a *TimeoutError if a WithCallTimeout/WithClientTimeout expires.
+func (c *OrderFulfillmentServiceNatsClient) PrepareOrder(ctx context.Context, req *PrepareOrderRequest, opts ...CallOption) (*PrepareOrderResponse, error) {
 	method := "PrepareOrder"
 
-	// Pointer to store response headers - stored in context so invoker can update it
-	responseHeadersPtr := &nats.Header{}
+	// Sample the call for WithClientSampling under a request ID the service sees too
+	var requestID string
+	if c.sampler.enabled() {
+		ctx, requestID = withRequestID(ctx, c.idGenerator)
+	}
+	sample := c.sampler.begin("OrderFulfillmentService", method, requestID, true, c.outgoingHeaders(ctx), req, false)
+
+	// Bound the call when the caller gave no deadline (or asked for a per-call timeout)
+	start := time.Now()
+	parentCtx := ctx
+	ctx, cancel, timeout := withCallTimeout(ctx, c.timeout, opts)
+	defer cancel()
 
-	// Add the response headers pointer to context so invoker can populate it
+	// Record sizes, attempts and duration for CallInfoFromContext
+	ctx, info := startCallInfo(ctx, "OrderFulfillmentService", c.subjectPrefix+".prepare_order")
+	defer info.finish()
+	ctx = withIDGenerator(ctx, c.idGenerator) // For NewID in interceptors
+
+	// Give the invoker somewhere in the context to store the response headers
 	// Interceptors can then read the headers from the same context
-	ctx = context.WithValue(ctx, responseHeadersKey, responseHeadersPtr)
+	ctx = WithResponseHeaders(ctx, nats.Header{})
 
 	// Define the invoker function that performs the actual NATS call
 	invoker := func(invokerCtx context.Context, method string, request, reply interface{}) error {
-		subject := c.subjectPrefix + ".prepare_order"
+		// WithTargetInstance sends the call to one instance's own subject
+		subject, subjectErr := targetSubject(c.subjectPrefix+".prepare_order", opts)
+		if subjectErr != nil {
+			return newOrderFulfillmentServiceError(OrderFulfillmentServiceErrCodeInvalidArgument, method, subjectErr.Error(), nil)
+		}
 
 		// Marshal request
 		typedReq, ok := request.(*PrepareOrderRequest)
@@ -718,7 +1238,7 @@ func (c *OrderFulfillmentServiceNatsClient) PrepareOrder(ctx context.Context, re
 		var data []byte
 		var err error
 		if c.useJSON {
-			data, err = protojson.Marshal(typedReq)
+			data, err = marshalJSON(typedReq, false)
 		} else {
 			data, err = proto.Marshal(typedReq)
 		}
@@ -726,83 +1246,124 @@ func (c *OrderFulfillmentServiceNatsClient) PrepareOrder(ctx context.Context, re
 			return err
 		}
 
-		// Extract outgoing headers from context and attach to NATS message
-		var msg *nats.Msg
-		if headers := OutgoingHeaders(invokerCtx); headers != nil {
-			msg, err = c.nc.RequestMsgWithContext(invokerCtx, &nats.Msg{
-				Subject: subject,
-				Data:    data,
-				Header:  headers,
-			})
-		} else {
-			msg, err = c.nc.RequestWithContext(invokerCtx, subject, data)
+		// Extract outgoing headers from context and attach them, naming the codec, to the NATS message
+		nc := c.conn()
+		headers := withContentType(c.outgoingHeaders(invokerCtx), c.useJSON)
+		setDeadlineHeaders(invokerCtx, headers) // For deadline-aware scheduling and handler contexts
+		if data, err = c.compress(data, headers); err != nil {
+			return err
+		}
+		headers.Set(acceptEncodingHeader, compressorNames()) // Services compress responses only for clients that decompress them
+		if c.cancelPropagation {
+			var stop func() bool
+			headers, stop = propagateCancel(invokerCtx, nc, headers, mintID(c.idGenerator))
+			defer stop()
+		}
+
+		info.attempt(len(data))
+		msg, err := nc.RequestMsgWithContext(invokerCtx, &nats.Msg{
+			Subject: subject,
+			Data:    data,
+			Header:  headers,
+		})
+		if err != nil {
+			return err
 		}
+		info.received(len(msg.Data))
+		info.responder(msg.Header)
+		body, err := readPayload("response", msg.Header.Get(ContentEncodingHeader), msg.Data, c.maxResponseSize)
 		if err != nil {
 			return err
 		}
 
-		// Store response headers in the pointer from context
+		// Store response headers where ResponseHeaders reads them
 		if msg.Header != nil && len(msg.Header) > 0 {
-			if headersPtr, ok := invokerCtx.Value(responseHeadersKey).(*nats.Header); ok && headersPtr != nil {
-				*headersPtr = msg.Header
-			}
-		} // Check if this is an error response from the service
-		if msg.Header.Get("Status") != "" {
-			code := msg.Header.Get("Status")
-			description := msg.Header.Get("Description")
-			return &OrderFulfillmentServiceError{
-				Code:    code,
-				Method:  method,
-				Message: description,
+			storeResponseHeaders(invokerCtx, msg.Header)
+		}
+
+		// Check if this is an error response from the service (NATS micro headers)
+		if msg.Header.Get("Nats-Service-Error-Code") != "" {
+			code := msg.Header.Get("Nats-Service-Error-Code")
+			description := msg.Header.Get("Nats-Service-Error")
+			var details []byte
+			if len(msg.Data) > 0 {
+				details = msg.Data
 			}
+			return newOrderFulfillmentServiceError(code, method, description, details)
 		}
 
-		// Unmarshal response
+		// Unmarshal response with the codec the service named, if any
 		typedReply, ok := reply.(*PrepareOrderResponse)
 		if !ok {
 			return fmt.Errorf("invalid reply type")
 		}
-
-		if c.useJSON {
-			err = protojson.Unmarshal(msg.Data, typedReply)
+		replyJSON, err := payloadUsesJSON(msg.Header.Get(ContentTypeHeader), c.useJSON)
+		if err != nil {
+			return err
+		}
+		if replyJSON {
+			err = protojson.Unmarshal(body, typedReply)
 		} else {
-			err = proto.Unmarshal(msg.Data, typedReply)
+			err = proto.Unmarshal(body, typedReply)
 		}
 		return err
 	}
 
 	var resp PrepareOrderResponse
 
-	// Execute through interceptor chain if configured
-	var err error
-	if c.interceptor != nil {
-		err = c.interceptor(ctx, method, req, &resp, invoker)
-	} else {
-		err = invoker(ctx, method, req, &resp)
+	// Execute through interceptor chain if configured, once per retry attempt
+	err := c.retry.do(ctx, func(ctx context.Context) error {
+		if c.interceptor != nil {
+			return c.interceptor(ctx, method, req, &resp, invoker)
+		}
+		return invoker(ctx, method, req, &resp)
+	})
+	sample.end(&resp, err)
+	if c.journal != nil {
+		c.journal.record("OrderFulfillmentService", method, c.subjectPrefix+".prepare_order", c.outgoingHeaders(parentCtx), req, c.useJSON, false, start, err)
 	}
-
 	if err != nil {
-		return nil, err
+		return nil, callTimeoutError(parentCtx, ctx, method, timeout, err)
 	}
 
 	return &resp, nil
 }
 
 // ShipOrder sends a ShipOrder request to the service via NATS.
-// Returns an error if the request fails or the service returns an error.
-func (c *OrderFulfillmentServiceNatsClient) ShipOrder(ctx context.Context, req *ShipOrderRequest) (*ShipOrderResponse, error) {
+// Returns an error if the request fails or the service returns an error,
+// or a *TimeoutError if a WithCallTimeout/WithClientTimeout expires.
+func (c *OrderFulfillmentServiceNatsClient) ShipOrder(ctx context.Context, req *ShipOrderRequest, opts ...CallOption) (*ShipOrderResponse, error) {
 	method := "ShipOrder"
 
-	// Pointer to store response headers - stored in context so invoker can update it
-	responseHeadersPtr := &nats.Header{}
+	// Sample the call for WithClientSampling under a request ID the service sees too
+	var requestID string
+	if c.sampler.enabled() {
+		ctx, requestID = withRequestID(ctx, c.idGenerator)
+	}
+	sample := c.sampler.begin("OrderFulfillmentService", method, requestID, true, c.outgoingHeaders(ctx), req, false)
+
+	// Bound the call when the caller gave no deadline (or asked for a per-call timeout)
+	start := time.Now()
+	parentCtx := ctx
+	ctx, cancel, timeout := withCallTimeout(ctx, c.timeout, opts)
+	defer cancel()
+
+	// Record sizes, attempts and duration for CallInfoFromContext
+	ctx, info := startCallInfo(ctx, "OrderFulfillmentService", c.subjectPrefix+".ship_order")
+	defer info.finish()
+	ctx = withIDGenerator(ctx, c.idGenerator) // For NewID in interceptors
 
-	// Add the response headers pointer to context so invoker can populate it
+	// Give the invoker somewhere in the context to store the response headers
 	// Interceptors can then read the headers from the same context
-	ctx = context.WithValue(ctx, responseHeadersKey, responseHeadersPtr)
+	ctx = WithResponseHeaders(ctx, nats.Header{})
 
 	// Define the invoker function that performs the actual NATS call
 	invoker := func(invokerCtx context.Context, method string, request, reply interface{}) error {
-		subject := c.subjectPrefix + ".ship_order"
+		// WithTargetInstance sends the call to one instance's own subject
+		subject, subjectErr := targetSubject(c.subjectPrefix+".ship_order", opts)
+		if subjectErr != nil {
+			return newOrderFulfillmentServiceError(OrderFulfillmentServiceErrCodeInvalidArgument, method, subjectErr.Error(), nil)
+		}
 
 		// Marshal request
 		typedReq, ok := request.(*ShipOrderRequest)
@@ -813,7 +1374,7 @@ func (c *OrderFulfillmentServiceNatsClient) ShipOrder(ctx context.Context, req *
 		var data []byte
 		var err error
 		if c.useJSON {
-			data, err = protojson.Marshal(typedReq)
+			data, err = marshalJSON(typedReq, false)
 		} else {
 			data, err = proto.Marshal(typedReq)
 		}
@@ -821,83 +1382,124 @@ func (c *OrderFulfillmentServiceNatsClient) ShipOrder(ctx context.Context, req *
 			return err
 		}
 
-		// Extract outgoing headers from context and attach to NATS message
-		var msg *nats.Msg
-		if headers := OutgoingHeaders(invokerCtx); headers != nil {
-			msg, err = c.nc.RequestMsgWithContext(invokerCtx, &nats.Msg{
-				Subject: subject,
-				Data:    data,
-				Header:  headers,
-			})
-		} else {
-			msg, err = c.nc.RequestWithContext(invokerCtx, subject, data)
+		// Extract outgoing headers from context and attach them, naming the codec, to the NATS message
+		nc := c.conn()
+		headers := withContentType(c.outgoingHeaders(invokerCtx), c.useJSON)
+		setDeadlineHeaders(invokerCtx, headers) // For deadline-aware scheduling and handler contexts
+		if data, err = c.compress(data, headers); err != nil {
+			return err
+		}
+		headers.Set(acceptEncodingHeader, compressorNames()) // Services compress responses only for clients that decompress them
+		if c.cancelPropagation {
+			var stop func() bool
+			headers, stop = propagateCancel(invokerCtx, nc, headers, mintID(c.idGenerator))
+			defer stop()
 		}
+
+		info.attempt(len(data))
+		msg, err := nc.RequestMsgWithContext(invokerCtx, &nats.Msg{
+			Subject: subject,
+			Data:    data,
+			Header:  headers,
+		})
+		if err != nil {
+			return err
+		}
+		info.received(len(msg.Data))
+		info.responder(msg.Header)
+		body, err := readPayload("response", msg.Header.Get(ContentEncodingHeader), msg.Data, c.maxResponseSize)
 		if err != nil {
 			return err
 		}
 
-		// Store response headers in the pointer from context
+		// Store response headers where ResponseHeaders reads them
 		if msg.Header != nil && len(msg.Header) > 0 {
-			if headersPtr, ok := invokerCtx.Value(responseHeadersKey).(*nats.Header); ok && headersPtr != nil {
-				*headersPtr = msg.Header
-			}
-		} // Check if this is an error response from the service
-		if msg.Header.Get("Status") != "" {
-			code := msg.Header.Get("Status")
-			description := msg.Header.Get("Description")
-			return &OrderFulfillmentServiceError{
-				Code:    code,
-				Method:  method,
-				Message: description,
+			storeResponseHeaders(invokerCtx, msg.Header)
+		}
+
+		// Check if this is an error response from the service (NATS micro headers)
+		if msg.Header.Get("Nats-Service-Error-Code") != "" {
+			code := msg.Header.Get("Nats-Service-Error-Code")
+			description := msg.Header.Get("Nats-Service-Error")
+			var details []byte
+			if len(msg.Data) > 0 {
+				details = msg.Data
 			}
+			return newOrderFulfillmentServiceError(code, method, description, details)
 		}
 
-		// Unmarshal response
+		// Unmarshal response with the codec the service named, if any
 		typedReply, ok := reply.(*ShipOrderResponse)
 		if !ok {
 			return fmt.Errorf("invalid reply type")
 		}
-
-		if c.useJSON {
-			err = protojson.Unmarshal(msg.Data, typedReply)
+		replyJSON, err := payloadUsesJSON(msg.Header.Get(ContentTypeHeader), c.useJSON)
+		if err != nil {
+			return err
+		}
+		if replyJSON {
+			err = protojson.Unmarshal(body, typedReply)
 		} else {
-			err = proto.Unmarshal(msg.Data, typedReply)
+			err = proto.Unmarshal(body, typedReply)
 		}
 		return err
 	}
 
 	var resp ShipOrderResponse
 
-	// Execute through interceptor chain if configured
-	var err error
-	if c.interceptor != nil {
-		err = c.interceptor(ctx, method, req, &resp, invoker)
-	} else {
-		err = invoker(ctx, method, req, &resp)
+	// Execute through interceptor chain if configured, once per retry attempt
+	err := c.retry.do(ctx, func(ctx context.Context) error {
+		if c.interceptor != nil {
+			return c.interceptor(ctx, method, req, &resp, invoker)
+		}
+		return invoker(ctx, method, req, &resp)
+	})
+	sample.end(&resp, err)
+	if c.journal != nil {
+		c.journal.record("OrderFulfillmentService", method, c.subjectPrefix+".ship_order", c.outgoingHeaders(parentCtx), req, c.useJSON, false, start, err)
 	}
-
 	if err != nil {
-		return nil, err
+		return nil, callTimeoutError(parentCtx, ctx, method, timeout, err)
 	}
 
 	return &resp, nil
 }
 
 // GetFulfillmentStatus sends a GetFulfillmentStatus request to the service via NATS.
-// Returns an error if the request fails or the service returns an error.
-func (c *OrderFulfillmentServiceNatsClient) GetFulfillmentStatus(ctx context.Context, req *GetFulfillmentStatusRequest) (*GetFulfillmentStatusResponse, error) {
+// Returns an error if the request fails or the service returns an error,
+// or a *TimeoutError if a WithCallTimeout/WithClientTimeout expires.
+func (c *OrderFulfillmentServiceNatsClient) GetFulfillmentStatus(ctx context.Context, req *GetFulfillmentStatusRequest, opts ...CallOption) (*GetFulfillmentStatusResponse, error) {
 	method := "GetFulfillmentStatus"
 
-	// Pointer to store response headers - stored in context so invoker can update it
-	responseHeadersPtr := &nats.Header{}
+	// Sample the call for WithClientSampling under a request ID the service sees too
+	var requestID string
+	if c.sampler.enabled() {
+		ctx, requestID = withRequestID(ctx, c.idGenerator)
+	}
+	sample := c.sampler.begin("OrderFulfillmentService", method, requestID, true, c.outgoingHeaders(ctx), req, false)
+
+	// Bound the call when the caller gave no deadline (or asked for a per-call timeout)
+	start := time.Now()
+	parentCtx := ctx
+	ctx, cancel, timeout := withCallTimeout(ctx, c.timeout, opts)
+	defer cancel()
+
+	// Record sizes, attempts and duration for CallInfoFromContext
+	ctx, info := startCallInfo(ctx, "OrderFulfillmentService", c.subjectPrefix+".get_fulfillment_status")
+	defer info.finish()
+	ctx = withIDGenerator(ctx, c.idGenerator) // For NewID in interceptors
 
-	// Add the response headers pointer to context so invoker can populate it
+	// Give the invoker somewhere in the context to store the response headers
 	// Interceptors can then read the headers from the same context
-	ctx = context.WithValue(ctx, responseHeadersKey, responseHeadersPtr)
+	ctx = WithResponseHeaders(ctx, nats.Header{})
 
 	// Define the invoker function that performs the actual NATS call
 	invoker := func(invokerCtx context.Context, method string, request, reply interface{}) error {
-		subject := c.subjectPrefix + ".get_fulfillment_status"
+		// WithTargetInstance sends the call to one instance's own subject
+		subject, subjectErr := targetSubject(c.subjectPrefix+".get_fulfillment_status", opts)
+		if subjectErr != nil {
+			return newOrderFulfillmentServiceError(OrderFulfillmentServiceErrCodeInvalidArgument, method, subjectErr.Error(), nil)
+		}
 
 		// Marshal request
 		typedReq, ok := request.(*GetFulfillmentStatusRequest)
@@ -908,7 +1510,7 @@ func (c *OrderFulfillmentServiceNatsClient) GetFulfillmentStatus(ctx context.Con
 		var data []byte
 		var err error
 		if c.useJSON {
-			data, err = protojson.Marshal(typedReq)
+			data, err = marshalJSON(typedReq, false)
 		} else {
 			data, err = proto.Marshal(typedReq)
 		}
@@ -916,68 +1518,115 @@ func (c *OrderFulfillmentServiceNatsClient) GetFulfillmentStatus(ctx context.Con
 			return err
 		}
 
-		// Extract outgoing headers from context and attach to NATS message
-		var msg *nats.Msg
-		if headers := OutgoingHeaders(invokerCtx); headers != nil {
-			msg, err = c.nc.RequestMsgWithContext(invokerCtx, &nats.Msg{
-				Subject: subject,
-				Data:    data,
-				Header:  headers,
-			})
-		} else {
-			msg, err = c.nc.RequestWithContext(invokerCtx, subject, data)
+		// Extract outgoing headers from context and attach them, naming the codec, to the NATS message
+		nc := c.conn()
+		headers := withContentType(c.outgoingHeaders(invokerCtx), c.useJSON)
+		setDeadlineHeaders(invokerCtx, headers) // For deadline-aware scheduling and handler contexts
+		if data, err = c.compress(data, headers); err != nil {
+			return err
+		}
+		headers.Set(acceptEncodingHeader, compressorNames()) // Services compress responses only for clients that decompress them
+		if c.cancelPropagation {
+			var stop func() bool
+			headers, stop = propagateCancel(invokerCtx, nc, headers, mintID(c.idGenerator))
+			defer stop()
+		}
+
+		info.attempt(len(data))
+		msg, err := nc.RequestMsgWithContext(invokerCtx, &nats.Msg{
+			Subject: subject,
+			Data:    data,
+			Header:  headers,
+		})
+		if err != nil {
+			return err
 		}
+		info.received(len(msg.Data))
+		info.responder(msg.Header)
+		body, err := readPayload("response", msg.Header.Get(ContentEncodingHeader), msg.Data, c.maxResponseSize)
 		if err != nil {
 			return err
 		}
 
-		// Store response headers in the pointer from context
+		// Store response headers where ResponseHeaders reads them
 		if msg.Header != nil && len(msg.Header) > 0 {
-			if headersPtr, ok := invokerCtx.Value(responseHeadersKey).(*nats.Header); ok && headersPtr != nil {
-				*headersPtr = msg.Header
-			}
-		} // Check if this is an error response from the service
-		if msg.Header.Get("Status") != "" {
-			code := msg.Header.Get("Status")
-			description := msg.Header.Get("Description")
-			return &OrderFulfillmentServiceError{
-				Code:    code,
-				Method:  method,
-				Message: description,
+			storeResponseHeaders(invokerCtx, msg.Header)
+		}
+
+		// Check if this is an error response from the service (NATS micro headers)
+		if msg.Header.Get("Nats-Service-Error-Code") != "" {
+			code := msg.Header.Get("Nats-Service-Error-Code")
+			description := msg.Header.Get("Nats-Service-Error")
+			var details []byte
+			if len(msg.Data) > 0 {
+				details = msg.Data
 			}
+			return newOrderFulfillmentServiceError(code, method, description, details)
 		}
 
-		// Unmarshal response
+		// Unmarshal response with the codec the service named, if any
 		typedReply, ok := reply.(*GetFulfillmentStatusResponse)
 		if !ok {
 			return fmt.Errorf("invalid reply type")
 		}
-
-		if c.useJSON {
-			err = protojson.Unmarshal(msg.Data, typedReply)
+		replyJSON, err := payloadUsesJSON(msg.Header.Get(ContentTypeHeader), c.useJSON)
+		if err != nil {
+			return err
+		}
+		if replyJSON {
+			err = protojson.Unmarshal(body, typedReply)
 		} else {
-			err = proto.Unmarshal(msg.Data, typedReply)
+			err = proto.Unmarshal(body, typedReply)
 		}
 		return err
 	}
 
 	var resp GetFulfillmentStatusResponse
 
-	// Execute through interceptor chain if configured
-	var err error
-	if c.interceptor != nil {
-		err = c.interceptor(ctx, method, req, &resp, invoker)
-	} else {
-		err = invoker(ctx, method, req, &resp)
+	// Execute through interceptor chain if configured, once per retry attempt
+	err := c.retry.do(ctx, func(ctx context.Context) error {
+		if c.interceptor != nil {
+			return c.interceptor(ctx, method, req, &resp, invoker)
+		}
+		return invoker(ctx, method, req, &resp)
+	})
+	sample.end(&resp, err)
+	if c.journal != nil {
+		c.journal.record("OrderFulfillmentService", method, c.subjectPrefix+".get_fulfillment_status", c.outgoingHeaders(parentCtx), req, c.useJSON, false, start, err)
 	}
-
 	if err != nil {
-		return nil, err
+		return nil, callTimeoutError(parentCtx, ctx, method, timeout, err)
 	}
 
 	return &resp, nil
 }
 
+// Health calls the service's health endpoint. A service that reports HealthNotServing
+// returns a HealthResponse, not an error.
+func (c *OrderFulfillmentServiceNatsClient) Health(ctx context.Context) (*HealthResponse, error) {
+	parentCtx := ctx
+	ctx, cancel, timeout := withCallTimeout(ctx, c.timeout, nil)
+	defer cancel()
+	resp, err := requestHealth(ctx, c.conn(), healthSubject(c.subjectPrefix, "order_fulfillment_service"))
+	if err != nil {
+		return nil, callTimeoutError(parentCtx, ctx, "Health", timeout, err)
+	}
+	return resp, nil
+}
+
+// Schema fetches the service's $schema document; see FetchServiceSchema
+func (c *OrderFulfillmentServiceNatsClient) Schema(ctx context.Context) (*ServiceSchemaDocument, error) {
+	ctx, cancel, _ := withCallTimeout(ctx, c.timeout, nil)
+	defer cancel()
+	return FetchServiceSchema(ctx, c.conn(), schemaSubject(c.subjectPrefix, "order_fulfillment_service"))
+}
+
+// Close stops the client's connection monitor. The NATS connection stays open,
+// and the client can still be used.
+func (c *OrderFulfillmentServiceNatsClient) Close() {
+	c.monitor.close()
+}
+
 // Endpoints returns information about all service endpoints this client can call.
 // This is useful for debugging, monitoring, and introspection.
 func (c *OrderFulfillmentServiceNatsClient) Endpoints() []OrderFulfillmentServiceEndpointInfo {
@@ -987,3 +1636,16 @@ func (c *OrderFulfillmentServiceNatsClient) Endpoints() []OrderFulfillmentServic
 		{Name: "GetFulfillmentStatus", Subject: c.subjectPrefix + ".get_fulfillment_status"},
 	}
 }
+
+// OrderFulfillmentServiceSelfTest checks that the NATS connection works and that a running
+// order_fulfillment_service instance has registered every endpoint the client calls. Client
+// options such as WithNatsClientSubjectPrefix select the expected subjects.
+// Exit non-zero when the report is not OK to use it as a liveness or deployment hook.
+func OrderFulfillmentServiceSelfTest(ctx context.Context, nc *nats.Conn, opts ...NatsClientOption) *SelfTestReport {
+	c := NewOrderFulfillmentServiceNatsClient(nc, opts...)
+	var endpoints []SelfTestEndpoint
+	for _, ep := range c.Endpoints() {
+		endpoints = append(endpoints, SelfTestEndpoint{Name: ep.Name, Subject: ep.Subject})
+	}
+	return runSelfTest(ctx, nc, "order_fulfillment_service", endpoints)
+}
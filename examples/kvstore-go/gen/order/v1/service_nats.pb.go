@@ -1,4 +1,8 @@
 // Code generated by protoc-gen-nats-micro. DO NOT EDIT.
+// versions:
+// 	protoc-gen-nats-micro v0.3.0
+// 	protoc                (unknown)
+// source: order/v1/service.proto
 
 package v1
 
@@ -6,7 +10,6 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"log"
 	"os"
 	"time"
 	"github.com/nats-io/nats.go"
@@ -21,6 +24,21 @@ type OrderServiceError struct {
 	Code    string // Error code (e.g., "INVALID_ARGUMENT", "NOT_FOUND", "INTERNAL")
 	Message string // Human-readable error message
 	Method  string // The method that failed
+	Details []byte // Optional error data sent with the error
+
+	status *Status // Returned by Unwrap (nil = built on each call)
+}
+
+// newOrderServiceError creates an error that keeps its *Status, so Unwrap
+// returns the same value every time
+func newOrderServiceError(code, method, message string, details []byte) *OrderServiceError {
+	return &OrderServiceError{
+		Code:    code,
+		Method:  method,
+		Message: message,
+		Details: details,
+		status:  &Status{Code: ParseCode(code), Message: message, Details: details},
+	}
 }
 
 func (e *OrderServiceError) Error() string {
@@ -39,7 +57,17 @@ func (e *OrderServiceError) NatsErrorMessage() string {
 
 // NatsErrorData returns optional error data (nil for basic errors)
 func (e *OrderServiceError) NatsErrorData() []byte {
-	return nil
+	return e.Details
+}
+
+// Unwrap exposes the error as a *Status, so errors.As(err, &st) works on
+// client errors. Custom error codes map to CodeUnknown. Errors built as struct
+// literals get a new Status on each call.
+func (e *OrderServiceError) Unwrap() error {
+	if e.status != nil {
+		return e.status
+	}
+	return &Status{Code: ParseCode(e.Code), Message: e.Message, Details: e.Details}
 }
 
 // Service-specific error code constants (use shared constants from service_shared_nats.pb.go)
@@ -106,37 +134,37 @@ func GetOrderServiceErrorCode(err error) string {
 
 // NewOrderServiceInvalidArgumentError creates a new invalid argument error
 func NewOrderServiceInvalidArgumentError(method, message string) error {
-	return &OrderServiceError{Code: OrderServiceErrCodeInvalidArgument, Method: method, Message: message}
+	return newOrderServiceError(OrderServiceErrCodeInvalidArgument, method, message, nil)
 }
 
 // NewOrderServiceNotFoundError creates a new not found error
 func NewOrderServiceNotFoundError(method, message string) error {
-	return &OrderServiceError{Code: OrderServiceErrCodeNotFound, Method: method, Message: message}
+	return newOrderServiceError(OrderServiceErrCodeNotFound, method, message, nil)
 }
 
 // NewOrderServiceAlreadyExistsError creates a new already exists error
 func NewOrderServiceAlreadyExistsError(method, message string) error {
-	return &OrderServiceError{Code: OrderServiceErrCodeAlreadyExists, Method: method, Message: message}
+	return newOrderServiceError(OrderServiceErrCodeAlreadyExists, method, message, nil)
 }
 
 // NewOrderServicePermissionDeniedError creates a new permission denied error
 func NewOrderServicePermissionDeniedError(method, message string) error {
-	return &OrderServiceError{Code: OrderServiceErrCodePermissionDenied, Method: method, Message: message}
+	return newOrderServiceError(OrderServiceErrCodePermissionDenied, method, message, nil)
 }
 
 // NewOrderServiceUnauthenticatedError creates a new unauthenticated error
 func NewOrderServiceUnauthenticatedError(method, message string) error {
-	return &OrderServiceError{Code: OrderServiceErrCodeUnauthenticated, Method: method, Message: message}
+	return newOrderServiceError(OrderServiceErrCodeUnauthenticated, method, message, nil)
 }
 
 // NewOrderServiceInternalError creates a new internal error
 func NewOrderServiceInternalError(method, message string) error {
-	return &OrderServiceError{Code: OrderServiceErrCodeInternal, Method: method, Message: message}
+	return newOrderServiceError(OrderServiceErrCodeInternal, method, message, nil)
 }
 
 // NewOrderServiceUnavailableError creates a new unavailable error
 func NewOrderServiceUnavailableError(method, message string) error {
-	return &OrderServiceError{Code: OrderServiceErrCodeUnavailable, Method: method, Message: message}
+	return newOrderServiceError(OrderServiceErrCodeUnavailable, method, message, nil)
 }
 
 // OrderServiceNats is the NATS service interface for OrderService
@@ -149,8 +177,10 @@ type OrderServiceNats interface {
 
 // OrderServiceEndpointInfo describes a service endpoint
 type OrderServiceEndpointInfo struct {
-	Name    string `json:"name"`    // Method name (e.g., "CreateProduct")
-	Subject string `json:"subject"` // NATS subject (e.g., "api.v1.create_product")
+	Name           string `json:"name"`                       // Method name (e.g., "CreateProduct")
+	Subject        string `json:"subject"`                    // NATS subject (e.g., "api.v1.create_product")
+	QueueGroup     string `json:"queue_group,omitempty"`      // Queue group the endpoint joined (server only)
+	MaxRequestSize int    `json:"max_request_size,omitempty"` // Request payload limit in bytes (server only, 0 = unlimited)
 }
 
 // OrderServiceService is the interface for the registered NATS micro service
@@ -158,60 +188,242 @@ type OrderServiceEndpointInfo struct {
 type OrderServiceService interface {
 	micro.Service
 	Endpoints() []OrderServiceEndpointInfo
+	// Drain stops accepting requests, waits until in-flight handlers finish or
+	// ctx ends, and stops the service
+	Drain(ctx context.Context) error
+	// InterceptorChain names the interceptors a method's requests pass through,
+	// outermost first
+	InterceptorChain(method string) []string
+	// Reconfigure changes the settings that can change while the service runs,
+	// currently WithSampling and WithSamplingSalt
+	Reconfigure(opts ...RegisterOption) error
 }
 
 // orderServiceService is the concrete implementation of OrderServiceService
 type orderServiceService struct {
 	micro.Service
-	subjectPrefix string
+	subjectPrefix  string
+	queueGroup     string
+	maxRequestSize int
+	inflight       *inflightTracker
+	interceptors   []string // Names of the service-wide interceptors, outermost first
+	sampler        *sampler // Shared with the handlers, for Reconfigure
 }
 
 // Endpoints returns information about all service endpoints
 // This is useful for debugging, monitoring, and service discovery
 func (s *orderServiceService) Endpoints() []OrderServiceEndpointInfo {
 	return []OrderServiceEndpointInfo{
-		{Name: "CreateOrder", Subject: s.subjectPrefix + ".create_order"},
-		{Name: "GetOrder", Subject: s.subjectPrefix + ".get_order"},
-		{Name: "ListOrders", Subject: s.subjectPrefix + ".list_orders"},
-		{Name: "UpdateOrderStatus", Subject: s.subjectPrefix + ".update_order_status"},
+		{Name: "CreateOrder", Subject: s.subjectPrefix + ".create_order", QueueGroup: s.queueGroup, MaxRequestSize: s.maxRequestSize},
+		{Name: "GetOrder", Subject: s.subjectPrefix + ".get_order", QueueGroup: s.queueGroup, MaxRequestSize: s.maxRequestSize},
+		{Name: "ListOrders", Subject: s.subjectPrefix + ".list_orders", QueueGroup: s.queueGroup, MaxRequestSize: s.maxRequestSize},
+		{Name: "UpdateOrderStatus", Subject: s.subjectPrefix + ".update_order_status", QueueGroup: s.queueGroup, MaxRequestSize: s.maxRequestSize},
+	}
+}
+
+// init lists the methods of OrderService in MethodDescriptors
+func init() {
+	MethodDescriptors["order.v1.OrderService.CreateOrder"] = MethodDescriptor{
+		Service: "OrderService",
+		Method:  "CreateOrder",
+		Subject: "api.v1.create_order",
+	}
+	MethodDescriptors["order.v1.OrderService.GetOrder"] = MethodDescriptor{
+		Service: "OrderService",
+		Method:  "GetOrder",
+		Subject: "api.v1.get_order",
+	}
+	MethodDescriptors["order.v1.OrderService.ListOrders"] = MethodDescriptor{
+		Service: "OrderService",
+		Method:  "ListOrders",
+		Subject: "api.v1.list_orders",
+	}
+	MethodDescriptors["order.v1.OrderService.UpdateOrderStatus"] = MethodDescriptor{
+		Service: "OrderService",
+		Method:  "UpdateOrderStatus",
+		Subject: "api.v1.update_order_status",
+	}
+}
+
+// OrderServiceSubjects maps each method of OrderService to its subject under
+// the default prefix. Clients and services of every language are generated from
+// the same table.
+var OrderServiceSubjects = map[string]string{
+	"CreateOrder":       "api.v1.create_order",
+	"GetOrder":          "api.v1.get_order",
+	"ListOrders":        "api.v1.list_orders",
+	"UpdateOrderStatus": "api.v1.update_order_status",
+}
+
+// Drain unsubscribes every endpoint, so new requests get no responders, then waits
+// for in-flight handlers, and requests already delivered to the endpoints, to
+// finish before returning. Server and bidi streams are
+// sent a GOAWAY, which clients see as an *ErrServerDraining, and streaming handlers
+// have their context canceled right away, or after WithStreamDrainGrace, so
+// long-running streams can end cleanly. If ctx ends first, the remaining handlers'
+// contexts are canceled and ctx.Err() is returned. The service is stopped either way.
+// Drain fails if nats.go's micro left an endpoint subscribed; see stopEndpoints.
+func (s *orderServiceService) Drain(ctx context.Context) error {
+	return s.inflight.drain(ctx, func() error { return stopEndpoints(s.Service) })
+}
+
+// InterceptorChain returns the names of the interceptors that requests to
+// method, a Go method name, pass through, outermost first: the service's
+// interceptors in the order they were added, then the method's
+// (natsmicro.endpoint).middlewares. Interceptors are named with Named, or else
+// by their function name.
+func (s *orderServiceService) InterceptorChain(method string) []string {
+	chain := append([]string(nil), s.interceptors...)
+	return chain
+}
+
+// Reconfigure replaces the service's WithSampling and WithSamplingSalt settings
+// with those in opts, so sampling can be turned up, down or off without
+// re-registering; leaving WithSampling out stops sampling. Any other option is
+// rejected, as it only takes effect at registration.
+func (s *orderServiceService) Reconfigure(opts ...RegisterOption) error {
+	cfg := &registerConfig{}
+	for _, opt := range opts {
+		opt(cfg)
 	}
+	return reconfigureSampling(s.sampler, cfg)
 }
 
+// OrderServiceSchemaHash identifies the schema OrderService was generated from.
+// Services advertise it as schema_hash INFO metadata; see SchemaHash.
+const OrderServiceSchemaHash = "sha256:c67ccab6ed5a178ef91f7398ce25702a74e437b50575e3e6b7ed177b6198484a"
+
+// orderServiceSchema is the FileDescriptorSet OrderService serves from its
+// $reflect endpoint. The schema endpoint metadata is read from it too, so the two
+// always agree.
+const orderServiceSchema = "\n\xaf\x01\n\x1bcommon/types/v1/money.proto\x12\x0fcommon.types.v1\"X\n\x05Money\x12#\n\rcurrency_code\x18\x01 \x01(\tR\fcurrencyCode\x12\x14\n\x05units\x18\x02 \x01(\x03R\x05units\x12\x14\n\x05nanos\x18\x03 \x01(\x05R\x05nanosB\x1dZ\x1bexample/gen/common/types/v1b\x06proto3\n\xe3\x01\n common/location/v1/address.proto\x12\x12common.location.v1\"\x80\x01\n\aAddress\x12\x16\n\x06street\x18\x01 \x01(\tR\x06street\x12\x12\n\x04city\x18\x02 \x01(\tR\x04city\x12\x14\n\x05state\x18\x03 \x01(\tR\x05state\x12\x19\n\bzip_code\x18\x04 \x01(\tR\azipCode\x12\x18\n\acountry\x18\x05 \x01(\tR\acountryB Z\x1eexample/gen/common/location/v1b\x06proto3\n\xc8\x01\n\x1ccommon/types/v1/status.proto\x12\x0fcommon.types.v1*p\n\x06Status\x12\x16\n\x12STATUS_UNSPECIFIED\x10\x00\x12\x11\n\rSTATUS_ACTIVE\x10\x01\x12\x13\n\x0fSTATUS_INACTIVE\x10\x02\x12\x12\n\x0eSTATUS_PENDING\x10\x03\x12\x12\n\x0eSTATUS_DELETED\x10\x04B\x1dZ\x1bexample/gen/common/types/v1b\x06proto3\n\x96\x01\n\x1fcommon/types/v1/timestamp.proto\x12\x0fcommon.types.v1\";\n\tTimestamp\x12\x18\n\aseconds\x18\x01 \x01(\x03R\aseconds\x12\x14\n\x05nanos\x18\x02 \x01(\x05R\x05nanosB\x1dZ\x1bexample/gen/common/types/v1b\x06proto3\n\xb8\x03\n!common/metadata/v1/metadata.proto\x12\x12common.metadata.v1\x1a\x1fcommon/types/v1/timestamp.proto\"\xb3\x02\n\bMetadata\x129\n\ncreated_at\x18\x01 \x01(\v2\x1a.common.types.v1.TimestampR\tcreatedAt\x129\n\nupdated_at\x18\x02 \x01(\v2\x1a.common.types.v1.TimestampR\tupdatedAt\x12\x1d\n\ncreated_by\x18\x03 \x01(\tR\tcreatedBy\x12\x1d\n\nupdated_by\x18\x04 \x01(\tR\tupdatedBy\x12:\n\x04tags\x18\x05 \x03(\v2&.common.metadata.v1.Metadata.TagsEntryR\x04tags\x1a7\n\tTagsEntry\x12\x10\n\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n\x05value\x18\x02 \x01(\tR\x05value:\x028\x01B Z\x1eexample/gen/common/metadata/v1b\x06proto3\n\xbc\x18\n\x16order/v1/service.proto\x12\border.v1\x1a\x1bcommon/types/v1/money.proto\x1a common/location/v1/address.proto\x1a\x1ccommon/types/v1/status.proto\x1a!common/metadata/v1/metadata.proto\x1a\x17natsmicro/options.proto\x1a\x1cgoogle/api/annotations.proto\"\x83\x01\n\rTrackingEvent\x12\x1a\n\blocation\x18\x01 \x01(\tR\blocation\x12\x16\n\x06status\x18\x02 \x01(\tR\x06status\x12\x1c\n\ttimestamp\x18\x03 \x01(\tR\ttimestamp\x12 \n\vdescription\x18\x04 \x01(\tR\vdescription\".\n\x11TrackOrderRequest\x12\x19\n\border_id\x18\x01 \x01(\tR\aorderId\"\x89\x01\n\x12TrackOrderResponse\x12\x19\n\border_id\x18\x01 \x01(\tR\aorderId\x12'\n\x0ftracking_number\x18\x02 \x01(\tR\x0etrackingNumber\x12/\n\x06events\x18\x03 \x03(\v2\x17.order.v1.TrackingEventR\x06events\"a\n\x15UpdateTrackingRequest\x12\x19\n\border_id\x18\x01 \x01(\tR\aorderId\x12-\n\x05event\x18\x02 \x01(\v2\x17.order.v1.TrackingEventR\x05event\"\\\n\x16UpdateTrackingResponse\x12\x19\n\border_id\x18\x01 \x01(\tR\aorderId\x12'\n\x0ftracking_number\x18\x02 \x01(\tR\x0etrackingNumber\"\xd9\x01\n\tOrderItem\x12\x1d\n\nproduct_id\x18\x01 \x01(\tR\tproductId\x12!\n\fproduct_name\x18\x02 \x01(\tR\vproductName\x12\x1a\n\bquantity\x18\x03 \x01(\x05R\bquantity\x125\n\nunit_price\x18\x04 \x01(\v2\x16.common.types.v1.MoneyR\tunitPrice\x127\n\vtotal_price\x18\x05 \x01(\v2\x16.common.types.v1.MoneyR\ntotalPrice\"\xc7\x03\n\x05Order\x12\x0e\n\x02id\x18\x01 \x01(\tR\x02id\x12\x1f\n\vcustomer_id\x18\x02 \x01(\tR\ncustomerId\x12#\n\rcustomer_name\x18\x03 \x01(\tR\fcustomerName\x12)\n\x05items\x18\x04 \x03(\v2\x13.order.v1.OrderItemR\x05items\x122\n\bsubtotal\x18\x05 \x01(\v2\x16.common.types.v1.MoneyR\bsubtotal\x12(\n\x03tax\x18\x06 \x01(\v2\x16.common.types.v1.MoneyR\x03tax\x12,\n\x05total\x18\a \x01(\v2\x16.common.types.v1.MoneyR\x05total\x12F\n\x10shipping_address\x18\b \x01(\v2\x1b.common.location.v1.AddressR\x0fshippingAddress\x12/\n\x06status\x18\t \x01(\x0e2\x17.common.types.v1.StatusR\x06status\x128\n\bmetadata\x18\n \x01(\v2\x1c.common.metadata.v1.MetadataR\bmetadata\"\xcd\x01\n\x12CreateOrderRequest\x12\x1f\n\vcustomer_id\x18\x01 \x01(\tR\ncustomerId\x12#\n\rcustomer_name\x18\x02 \x01(\tR\fcustomerName\x12)\n\x05items\x18\x03 \x03(\v2\x13.order.v1.OrderItemR\x05items\x12F\n\x10shipping_address\x18\x04 \x01(\v2\x1b.common.location.v1.AddressR\x0fshippingAddress\"<\n\x13CreateOrderResponse\x12%\n\x05order\x18\x01 \x01(\v2\x0f.order.v1.OrderR\x05order\"!\n\x0fGetOrderRequest\x12\x0e\n\x02id\x18\x01 \x01(\tR\x02id\"9\n\x10GetOrderResponse\x12%\n\x05order\x18\x01 \x01(\v2\x0f.order.v1.OrderR\x05order\"\xae\x01\n\x11ListOrdersRequest\x12\x1f\n\vcustomer_id\x18\x01 \x01(\tR\ncustomerId\x12\x1b\n\tpage_size\x18\x02 \x01(\x05R\bpageSize\x12\x1d\n\npage_token\x18\x03 \x01(\tR\tpageToken\x12<\n\rstatus_filter\x18\x04 \x01(\x0e2\x17.common.types.v1.StatusR\fstatusFilter\"\x86\x01\n\x12ListOrdersResponse\x12'\n\x06orders\x18\x01 \x03(\v2\x0f.order.v1.OrderR\x06orders\x12&\n\x0fnext_page_token\x18\x02 \x01(\tR\rnextPageToken\x12\x1f\n\vtotal_count\x18\x03 \x01(\x05R\ntotalCount\"s\n\x18UpdateOrderStatusRequest\x12\x0e\n\x02id\x18\x01 \x01(\tR\x02id\x12/\n\x06status\x18\x02 \x01(\x0e2\x17.common.types.v1.StatusR\x06status\x12\x16\n\x06reason\x18\x03 \x01(\tR\x06reason\"B\n\x19UpdateOrderStatusResponse\x12%\n\x05order\x18\x01 \x01(\v2\x0f.order.v1.OrderR\x05order2\xec\x03\n\fOrderService\x12a\n\vCreateOrder\x12\x1c.order.v1.CreateOrderRequest\x1a\x1d.order.v1.CreateOrderResponse\"\x15\x82\xd3\xe4\x93\x02\x0f:\x01*\"\n/v1/orders\x12Z\n\bGetOrder\x12\x19.order.v1.GetOrderRequest\x1a\x1a.order.v1.GetOrderResponse\"\x17\x82\xd3\xe4\x93\x02\x11\x12\x0f/v1/orders/{id}\x12[\n\nListOrders\x12\x1b.order.v1.ListOrdersRequest\x1a\x1c.order.v1.ListOrdersResponse\"\x12\x82\xd3\xe4\x93\x02\f\x12\n/v1/orders\x12\x7f\n\x11UpdateOrderStatus\x12\".order.v1.UpdateOrderStatusRequest\x1a#.order.v1.UpdateOrderStatusResponse\"!\x82\xd3\xe4\x93\x02\x1b:\x01*2\x16/v1/orders/{id}/status\x1a?\x8a\xb5\x18;\n\x06api.v1\x12\rorder_service\x1a\x051.0.0\"\x1bOrder management service v12\xc9\x02\n\x14OrderTrackingService\x12l\n\nTrackOrder\x12\x1b.order.v1.TrackOrderRequest\x1a\x1c.order.v1.TrackOrderResponse\"#\x82\xd3\xe4\x93\x02\x1d\x12\x1b/v1/orders/{order_id}/track\x12~\n\x0eUpdateTracking\x12\x1f.order.v1.UpdateTrackingRequest\x1a .order.v1.UpdateTrackingResponse\")\x82\xd3\xe4\x93\x02#:\x01*\"\x1e/v1/orders/{order_id}/tracking\x1aC\x8a\xb5\x18?\n\x06api.v1\x12\x16order_tracking_service\x1a\x051.0.0\"\x16Order tracking serviceB\x16Z\x14example/gen/order/v1b\x06proto3"
+
+// orderServiceSchemaDocument is the JSON OrderService serves from its
+// $schema endpoint, before registration fills in its name, version and subjects
+const orderServiceSchemaDocument = "{\"service\":\"order.v1.OrderService\",\"schema_hash\":\"sha256:c67ccab6ed5a178ef91f7398ce25702a74e437b50575e3e6b7ed177b6198484a\",\"methods\":[{\"name\":\"CreateOrder\",\"endpoint\":\"create_order\",\"streaming\":\"unary\",\"request_type\":\"order.v1.CreateOrderRequest\",\"response_type\":\"order.v1.CreateOrderResponse\"},{\"name\":\"GetOrder\",\"endpoint\":\"get_order\",\"streaming\":\"unary\",\"request_type\":\"order.v1.GetOrderRequest\",\"response_type\":\"order.v1.GetOrderResponse\"},{\"name\":\"ListOrders\",\"endpoint\":\"list_orders\",\"streaming\":\"unary\",\"request_type\":\"order.v1.ListOrdersRequest\",\"response_type\":\"order.v1.ListOrdersResponse\"},{\"name\":\"UpdateOrderStatus\",\"endpoint\":\"update_order_status\",\"streaming\":\"unary\",\"request_type\":\"order.v1.UpdateOrderStatusRequest\",\"response_type\":\"order.v1.UpdateOrderStatusResponse\"}],\"descriptor\":\"H4sIAAAAAAAC/6xX22sbVxrfkSxZ+mRb9kkiKyM7lsds4oTdUaxlSeIEgi9KECReIyvL4l0QxzNn1SGeS2aOROSStrRQaF4L/TPahxLoQ9/bPvSpT31uH/tHlHMbyZIsJaVvc7775Xe+8w18pUHJ8l3X9yq0F5Co0t2quL5HemYQ+tRHecE0OdPsbhn/gdRTxkcbMG91wpB4Vq9l+TYpamVtM9uYU8Q93yboMqQ6nkOjYqKsbSYb4sCoHvb8qJgsa5uphjjsrh6XyEvsBqek0iZeZSiqkzQP6B/wiwZlyTv1LUwd32NBY9sOSRTJsJEMW0mwyD/SYHZHSKECpCMaEkJl1PKEEMxYDu3xcLMN/s2ijSimhEebbYgDugqZMycQmc9wxuyZE/CkizBr+R2Phr1iSnDkcbd8fG1MigNpxFl+r8HKcF+Y4050QWNuBZA+4gKoAOioudN8dtR6dnB0WNurP6rX9hf/gpZgXtJ39pr1f9cWNXQJ8pJUP5DEBEKwIImHtYP9+sHjxeQAbb/2pNas7S/OvG3DvtBgbTgV6rgkotgNLoLZfcg2lQwraEQs37Mj3q5kQx37SEr8ASR9k4R1yXMJxTammMNffg9BKSZ3t/Rp+RhfJyDzVCqgewBWSDAldgsLwOWqujmUsRmn28hK6R3KVDuBrVQT01Wl9A5Fq32vJz2JXWV5t8fYyvJJTyJYae/20DbMUNyOiqlycjNXvW6OFsFU+ZlN3I5qDN8NrqPfgWxMQouQfE568pqxT9azLj7tEHnJxGE7cVe74HoM9CZu3bdFKPihTUJ+L0jYdSwi+5XhdNalSYNNnzpD9In3T5+OHH3ZwzRyHSv0K37AfMSW277fPiUVHDgV7Hk+xQNc4xMN5pshtp47XrvWJR5FOmRUmLKQ8VlMMhaXLKc8oRXIxqBU3Y8JqAw5m0RW6PC4ZPsHSYYJSzyIf7FqNsiLDokoG3q8ui3HloHM8nPdNj7TAA0qRIHvRWSCBroBeSrTbHkd94SEMoUFRT7gVFSBNGF1YO8FQ+OyqVpsnqtTQ4oZGK4840hW7Onxo79DimvLS3ahDyFl/A8Kwy7+vIyNnzXI8jrWKXHZVQ1C3+5YtG8zKyl1G63DnGJ72FXXKidpB9glDEAvOtij7GkTb258Rv8EYK9yKwgdSzxluWphZMTwN7+RZZKHTBDdgRz1KT6VeqmJesBFuaLxXRJSPDe0AIk4n4RjozXIWZ2I+q4onsgDFKlui5VDCvBUk2rlEESe601IOZS4UXGGg+VSv5FxRRtCAlUhE3VOeGxTEojl0CYkKX5ZTE8UZyLob5ASpmcnygoh9AgWo/ecIGDYkHOomOGKJXN0mTHlItPIKyVJYJdFzoNsWdtcqC6PuBVbQjwo7kJGza0icIcrk6Z9I5Y2ftQA7fEH5dyQGGqjNr2NiUltTE5t47jSzbx76YwHcOlcOvJC/xVS3Ld8ufNDkTQE11iH/GNCz1ViCODGPVjsi7yb9S81WHriREI5eutSlyAb4DZpRc4ZkTtShhGOnDPC5wpjUv858dQbwShNRkAPYF5ApPV/55SSsDgzGVBzQvoRFzY+1QANBizTvQFpnhDb5JLj8pVsdB3yHnlJWwMRCpjMM/JhHOWamkN8x5bTTcybPUYxIiiKSc3ty1jHN2jg8iTe7vIUIB0SHPmqfPJk7MLVMU7fqeXV35IwJ9TFfoMw5AbwiVb6eqO3UF+9gCtiMK58/NOvnyfy29otA9jqIqt+DBmFUHS1b2EI2Lo+jiUtL3PLSyjfN1t537Ffof8C9AGBSn0TI7jWV8YzpQfEPcyhwcA/hKWRgiOjb+YiCOgbE2Wkx3XusbSt3aoWhtKSW6H+8PWb4n1I48Axu1toXqwAcjPVU1vmbfO2UeK2yy72cJu4xKNlKVDublV/SMBlzlcbhWr7KUB/sRqs28h+pq+MZ8osNngWq6g0mILaVV5V+A6CPoCF84sNWhuu0NBWpZcvFpCeb3LPGwxt1yY4d7y2vvf6TfFhXEex5LcUd7igBVFQxVbl3C0cXx78kVB/Cur34fcBAENg29X2EAAA\"}"
+
 // RegisterOrderServiceHandlers registers the service with NATS micro handlers
 // Service: order_service v1.0.0
 // Description: Order management service v1
 // Subject prefix: api.v1
-// Configuration options: WithName(), WithVersion(), WithDescription(), WithSubjectPrefix(), WithTimeout()
-// Metadata options: WithMetadata() (replace), WithAdditionalMetadata() (merge)
+// Configuration options: WithName(), WithVersion(), WithDescription(), WithSubjectPrefix(), WithTimeout(), WithQueueGroup(), WithMaxRequestSize()
+// Metadata options: WithMetadata() (replace), WithAdditionalMetadata() (merge), WithEndpointMetadata() (per method)
 // Handler options: WithStatsHandler(), WithDoneHandler(), WithErrorHandler()
+// Interceptors: WithServerInterceptor() appends, WithServerInterceptorChain() replaces; the first runs outermost
+// Stream interceptors: WithServerStreamInterceptor()
+// Grouping: WithServiceGroup() registers on a ServiceGroup shared with other services
+// Existing services: AddOrderServiceEndpoints() registers on a micro.Service added elsewhere
 //
 // Endpoint metadata can be configured via proto options (nats.micro.endpoint).metadata
-func RegisterOrderServiceHandlers(nc *nats.Conn, impl OrderServiceNats, opts ...RegisterOption) (OrderServiceService, error) {
+func RegisterOrderServiceHandlers(nc *nats.Conn, impl OrderServiceNats, opts ...RegisterOption) (_ OrderServiceService, err error) {
 	cfg := &registerConfig{
-		name:          "order_service",
-		version:       "1.0.0",
-		description:   "Order management service v1",
-		subjectPrefix: "api.v1",
-		timeout:       0 * time.Second, // Service-level timeout (0 = no timeout)
-		metadata:      map[string]string{},
+		name:           "order_service",
+		version:        "1.0.0",
+		description:    "Order management service v1",
+		subjectPrefix:  "api.v1",
+		queueGroup:     "",
+		timeout:        0 * time.Second, // Service-level timeout (0 = no timeout)
+		tokenSanitizer: SanitizeToken,
+		streamWindow:   defaultStreamWindow,
+		metadata:       map[string]string{},
 	}
 	for _, opt := range opts {
 		opt(cfg)
 	}
+	return registerOrderServiceEndpoints(nc, impl, cfg, func() (*serviceHost, bool, error) {
+		// Register on the ServiceGroup, or add a micro.Service for this service
+		if cfg.group != nil {
+			return cfg.group.host, true, nil
+		}
+		host, err := addServiceHost(nc, cfg, mergeMetadata(cfg.metadata, map[string]string{"schema_hash": OrderServiceSchemaHash}))
+		return host, false, err
+	})
+}
 
-	svc, err := micro.AddService(nc, micro.Config{
-		Name:         cfg.name,
-		Version:      cfg.version,
-		Metadata:     cfg.metadata,
-		Description:  cfg.description,
-		StatsHandler: cfg.statsHandler,
-		DoneHandler:  cfg.doneHandler,
-		ErrorHandler: cfg.errorHandler,
+// AddOrderServiceEndpoints registers the OrderService endpoints on svc, a
+// micro.Service the caller added on nc, so they share its name, version and stats
+// with the endpoints of other services and the caller's own:
+//
+//	svc, err := micro.AddService(nc, micro.Config{Name: "catalog", Version: "1.0.0"})
+//	...
+//	err = AddOrderServiceEndpoints(nc, svc, impl, WithServerInterceptor(auth))
+//
+// As on a ServiceGroup, endpoint names are qualified with the service name
+// ("order_service-health") while subjects keep the subject prefix, and the
+// schema_hash is advertised as endpoint metadata. Options of the micro.Service
+// itself, which NewServiceGroup lists, are rejected with an error, as is
+// WithServiceGroup. Stopping svc stops the endpoints; there is no Drain.
+func AddOrderServiceEndpoints(nc *nats.Conn, svc micro.Service, impl OrderServiceNats, opts ...RegisterOption) error {
+	cfg := &registerConfig{
+		subjectPrefix:  "api.v1",
+		queueGroup:     "",
+		timeout:        0 * time.Second, // Service-level timeout (0 = no timeout)
+		tokenSanitizer: SanitizeToken,
+		streamWindow:   defaultStreamWindow,
+		metadata:       map[string]string{},
+	}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	host, err := existingServiceHost(nc, svc, cfg)
+	if err != nil {
+		return err
+	}
+	// The subjects and $schema document describe the proto service
+	cfg.name, cfg.version = "order_service", "1.0.0"
+	_, err = registerOrderServiceEndpoints(nc, impl, cfg, func() (*serviceHost, bool, error) {
+		return host, true, nil
 	})
+	return err
+}
+
+// registerOrderServiceEndpoints registers the OrderService endpoints cfg describes
+// on the host attach returns, once the configuration checks out. Endpoint names
+// are qualified when attach reports the host is shared with other services.
+func registerOrderServiceEndpoints(nc *nats.Conn, impl OrderServiceNats, cfg *registerConfig, attach func() (*serviceHost, bool, error)) (_ OrderServiceService, err error) {
+
+	// Describe each endpoint from the schema blob
+	schemaMetadata, err := schemaEndpointMetadata(orderServiceSchema, "order.v1.OrderService")
 	if err != nil {
-		return nil, fmt.Errorf("failed to add service: %w", err)
+		return nil, err
+	}
+	endpointMethods := map[string]bool{
+		"CreateOrder":       true,
+		"GetOrder":          true,
+		"ListOrders":        true,
+		"UpdateOrderStatus": true,
+	}
+	for method := range cfg.endpointMetadata {
+		if !endpointMethods[method] {
+			return nil, fmt.Errorf("WithEndpointMetadata: OrderService has no method %s", method)
+		}
+	}
+
+	// Streams are compressed with gzip unless WithCompression names another codec
+	compressor := compressorFor(streamEncodingGzip)
+	if cfg.compressionCodec != "" {
+		if compressor = compressorFor(cfg.compressionCodec); compressor == nil {
+			return nil, fmt.Errorf("WithCompression: codec %q is not registered; add it with RegisterCompressor", cfg.compressionCodec)
+		}
 	}
 
+	host, shared, err := attach()
+	if err != nil {
+		return nil, err
+	}
+	endpointPrefix := "" // Qualifies endpoint names on a shared micro.Service
+	if shared {
+		endpointPrefix = "order_service-"
+	} else {
+		// Stop the micro.Service again if an endpoint fails to register
+		defer func() {
+			if err != nil {
+				host.svc.Stop()
+			}
+		}()
+	}
+	svc, pool := host.svc, host.pool
+
 	// Chain server interceptors
 	var chainedInterceptor UnaryServerInterceptor
 	if len(cfg.serverInterceptors) > 0 {
@@ -219,35 +431,72 @@ func RegisterOrderServiceHandlers(nc *nats.Conn, impl OrderServiceNats, opts ...
 	}
 
 	handlers := &orderServiceHandlers{
-		impl:           impl,
-		serviceTimeout: cfg.timeout,
-		useJSON:        false,
-		interceptor:    chainedInterceptor,
-		js:             cfg.js,
-	}
-
-	// Map of endpoint names to their handlers
+		nc:                nc,
+		impl:              impl,
+		serviceTimeout:    cfg.timeout,
+		useJSON:           false,
+		interceptor:       chainedInterceptor,
+		js:                cfg.js,
+		cancels:           host.cancels,
+		tokenSanitizer:    cfg.tokenSanitizer,
+		idGenerator:       cfg.idGenerator,
+		maxRequestSize:    cfg.maxRequestSize,
+		streamWindow:      cfg.streamWindow,
+		streamAllowGaps:   cfg.streamAllowGaps,
+		streamCompression: cfg.streamCompression,
+		compression:       cfg.compression,
+		compressor:        compressor,
+		responseHeaders:   cfg.responseHeaders,
+		requestCheck:      cfg.requestCheck,
+		streamInterceptor: chainStreamServerInterceptors(cfg.streamInterceptors),
+		endpointPrefix:    endpointPrefix,
+		inflight:          host.inflight,
+		sampler:           newSampler(cfg.sampling, cfg.samplingSalt),
+		maxServerDeadline: cfg.maxServerDeadline,
+		persistenceErrors: cfg.persistenceErrors,
+		panics:            cfg.panicDiagnostics,
+		failures:          newFailureRecorder(cfg),
+	}
+
+	// Map of endpoint names to their handlers; unary ones go through the handler pool,
+	// idempotent ones are coalesced first, and those expecting a response are
+	// deduplicated by idempotency key inside the pool
+	idempotency := newIdempotencyGuard(cfg)
 	endpoints := map[string]micro.Handler{
 
-		"create_order": micro.HandlerFunc(handlers.CreateOrder),
+		"create_order": pool.wrap(endpointPrefix+"create_order", idempotency.wrap(endpointPrefix+"create_order", micro.HandlerFunc(handlers.CreateOrder))),
 
-		"get_order": micro.HandlerFunc(handlers.GetOrder),
+		"get_order": pool.wrap(endpointPrefix+"get_order", idempotency.wrap(endpointPrefix+"get_order", micro.HandlerFunc(handlers.GetOrder))),
 
-		"list_orders": micro.HandlerFunc(handlers.ListOrders),
+		"list_orders": pool.wrap(endpointPrefix+"list_orders", idempotency.wrap(endpointPrefix+"list_orders", micro.HandlerFunc(handlers.ListOrders))),
 
-		"update_order_status": micro.HandlerFunc(handlers.UpdateOrderStatus),
+		"update_order_status": pool.wrap(endpointPrefix+"update_order_status", idempotency.wrap(endpointPrefix+"update_order_status", micro.HandlerFunc(handlers.UpdateOrderStatus))),
 	}
 
-	// Map of endpoint names to their metadata
+	// Map of endpoint names to their metadata: the schema's description of the
+	// method, overlaid with (natsmicro.endpoint).metadata, then WithEndpointMetadata
 	endpointMetadata := map[string]map[string]string{
 
-		"create_order": {},
+		"create_order": mergeMetadata(mergeMetadata(schemaMetadata["CreateOrder"], map[string]string{}), cfg.endpointMetadata["CreateOrder"]),
+
+		"get_order": mergeMetadata(mergeMetadata(schemaMetadata["GetOrder"], map[string]string{}), cfg.endpointMetadata["GetOrder"]),
+
+		"list_orders": mergeMetadata(mergeMetadata(schemaMetadata["ListOrders"], map[string]string{}), cfg.endpointMetadata["ListOrders"]),
 
-		"get_order": {},
+		"update_order_status": mergeMetadata(mergeMetadata(schemaMetadata["UpdateOrderStatus"], map[string]string{}), cfg.endpointMetadata["UpdateOrderStatus"]),
+	}
 
-		"list_orders": {},
+	// Map of endpoint names to exact subjects from (natsmicro.endpoint).subject,
+	// or the wildcard form of subject_template
+	// These endpoints are registered outside the subject prefix group
+	endpointSubjects := map[string]string{}
 
-		"update_order_status": {},
+	// Endpoints WithInstanceSubjects also serves on <subject>.<instance ID>
+	instanceEndpoints := map[string]bool{
+		"create_order":        true,
+		"get_order":           true,
+		"list_orders":         true,
+		"update_order_status": true,
 	}
 
 	// Use interface to handle both Service and Group
@@ -255,35 +504,146 @@ func RegisterOrderServiceHandlers(nc *nats.Conn, impl OrderServiceNats, opts ...
 		AddEndpoint(string, micro.Handler, ...micro.EndpointOpt) error
 	}
 
-	var adder endpointAdder = svc
+	var group endpointAdder = svc
 	if cfg.subjectPrefix != "" {
-		adder = svc.AddGroup(cfg.subjectPrefix)
+		group = svc.AddGroup(cfg.subjectPrefix)
 	}
 
 	// Register all endpoints with their metadata
 	for name, handler := range endpoints {
 		opts := []micro.EndpointOpt{}
-		if metadata, exists := endpointMetadata[name]; exists && len(metadata) > 0 {
+		metadata := endpointMetadata[name]
+		if cfg.maxRequestSize > 0 {
+			// Advertise the request size limit alongside the proto metadata
+			withLimit := map[string]string{"max_request_size": fmt.Sprint(cfg.maxRequestSize)}
+			for k, v := range metadata {
+				withLimit[k] = v
+			}
+			metadata = withLimit
+		}
+		if shared {
+			// Keep the unqualified subject; the shared service's metadata cannot
+			// carry every service's schema hash
+			opts = append(opts, micro.WithEndpointSubject(name))
+			metadata = mergeMetadata(metadata, map[string]string{"schema_hash": OrderServiceSchemaHash})
+			if cfg.queueGroup != "" {
+				opts = append(opts, micro.WithEndpointQueueGroup(cfg.queueGroup))
+			}
+		}
+		if len(metadata) > 0 {
 			opts = append(opts, micro.WithEndpointMetadata(metadata))
 		}
-		if err := adder.AddEndpoint(name, handler, opts...); err != nil {
+		adder := group
+		subject := name
+		if exact, exists := endpointSubjects[name]; exists {
+			adder = svc
+			subject = exact
+			opts = append(opts, micro.WithEndpointSubject(subject))
+		}
+		if cfg.instanceSubjects && instanceEndpoints[name] {
+			// Responses name the instance, for ResponderInstance
+			handler = respondAsInstance(svc.Info().ID, handler)
+		}
+		if err := adder.AddEndpoint(endpointPrefix+name, holdRequests(cfg.hold, handler), opts...); err != nil {
 			return nil, fmt.Errorf("failed to add endpoint %s: %w", name, err)
 		}
+		if cfg.instanceSubjects && instanceEndpoints[name] {
+			// The same handler on this instance's own subject, for WithTargetInstance
+			opts = append(opts, micro.WithEndpointSubject(subject+"."+svc.Info().ID))
+			if err := adder.AddEndpoint(endpointPrefix+name+"-instance", holdRequests(cfg.hold, handler), opts...); err != nil {
+				return nil, fmt.Errorf("failed to add instance endpoint %s: %w", name, err)
+			}
+		}
+	}
+
+	pool.start()
+
+	// Application health endpoint, registered outside the subject prefix group
+	if !cfg.noHealthEndpoint {
+		subject := healthSubject(cfg.subjectPrefix, "order_service")
+		if err := svc.AddEndpoint(endpointPrefix+"health", holdRequests(cfg.hold, newHealthHandler(impl, cfg.timeout)), micro.WithEndpointSubject(subject)); err != nil {
+			return nil, fmt.Errorf("failed to add health endpoint: %w", err)
+		}
+	}
+
+	// Schema reflection endpoint, registered outside the subject prefix group
+	if !cfg.noReflectEndpoint {
+		subject := reflectSubject(cfg.subjectPrefix, "order_service")
+		if err := svc.AddEndpoint(endpointPrefix+"reflect", holdRequests(cfg.hold, newReflectHandler(orderServiceSchema, OrderServiceSchemaHash)), micro.WithEndpointSubject(subject)); err != nil {
+			return nil, fmt.Errorf("failed to add reflect endpoint: %w", err)
+		}
+	}
+
+	// Schema document endpoint, registered outside the subject prefix group
+	if !cfg.noSchemaEndpoint {
+		handler, err := newSchemaHandler(orderServiceSchemaDocument, cfg)
+		if err != nil {
+			return nil, err
+		}
+		subject := schemaSubject(cfg.subjectPrefix, "order_service")
+		if err := svc.AddEndpoint(endpointPrefix+"schema", holdRequests(cfg.hold, handler), micro.WithEndpointSubject(subject)); err != nil {
+			return nil, fmt.Errorf("failed to add schema endpoint: %w", err)
+		}
+	}
+
+	queueGroup := cfg.queueGroup
+	if queueGroup == "" {
+		queueGroup = micro.DefaultQueueGroup
 	}
 
 	return &orderServiceService{
-		Service:       svc,
-		subjectPrefix: cfg.subjectPrefix,
+		Service:        svc,
+		subjectPrefix:  cfg.subjectPrefix,
+		queueGroup:     queueGroup,
+		maxRequestSize: cfg.maxRequestSize,
+		inflight:       handlers.inflight,
+		interceptors:   interceptorChainNames(cfg.serverInterceptors),
+		sampler:        handlers.sampler,
 	}, nil
 }
 
+// OrderServiceRegistration describes a RegisterOrderServiceHandlers call for
+// RegisterGroup, which registers it together with other services, all or nothing
+func OrderServiceRegistration(impl OrderServiceNats, opts ...RegisterOption) Registration {
+	return registrationFunc(func(nc *nats.Conn, hold func(context.Context) error) (GroupService, error) {
+		return RegisterOrderServiceHandlers(nc, impl, append(opts[:len(opts):len(opts)], withRequestHold(hold))...)
+	})
+}
+
 // orderServiceHandlers wraps the service implementation with NATS handlers
 type orderServiceHandlers struct {
-	impl           OrderServiceNats
-	serviceTimeout time.Duration          // Default timeout for all endpoints
-	useJSON        bool                   // Use JSON encoding instead of binary protobuf
-	interceptor    UnaryServerInterceptor // Chained interceptors
-	js             jetstream.JetStream    // Optional JetStream context for KV/ObjectStore
+	nc                 *nats.Conn // NATS connection for streaming
+	impl               OrderServiceNats
+	serviceTimeout     time.Duration                     // Default timeout for all endpoints
+	useJSON            bool                              // Use JSON encoding instead of binary protobuf
+	interceptor        UnaryServerInterceptor            // Chained interceptors
+	methodInterceptors map[string]UnaryServerInterceptor // Interceptors plus (natsmicro.endpoint).middlewares, by method
+	js                 jetstream.JetStream               // Optional JetStream context for KV/ObjectStore
+	cancels            *cancelRegistry                   // Client cancel notices (nil unless WithCancelPropagation)
+	tokenSanitizer     func(string) string               // Escapes request fields in KV/Object Store keys
+	idGenerator        func() string                     // Mints stream inbox and persistent call IDs (nil = NUIDs)
+	maxRequestSize     int                               // Largest accepted request payload in bytes (0 = unlimited)
+	inflight           *inflightTracker                  // Running handlers, for Drain
+	persistentStreams  map[string]*persistentStream      // (natsmicro.stream).persistence streams, by method
+	streamWindow       int                               // Server-stream flow control cap (0 = none)
+	streamCompression  int                               // Smallest stream message compressed, once negotiated (0 = off)
+	compression        int                               // Smallest unary response compressed, if the client accepts it (0 = off)
+	compressor         Compressor                        // Codec for responses and stream messages
+	streamAllowGaps    bool                              // Skip lost client-stream messages instead of failing Recv
+	responseHeaders    *headerPolicy                     // Strips response headers (nil = allow all)
+	requestCheck       *requestImmutabilityCheck         // Reports handlers that modify their request (nil = off)
+	streamInterceptor  StreamServerInterceptor           // Chained stream interceptors
+	endpointPrefix     string                            // Qualifies endpoint names within a ServiceGroup
+	sampler            *sampler                          // Samples unary calls (WithSampling)
+	maxServerDeadline  time.Duration                     // Cap on propagated client deadlines (0 = none)
+	persistenceErrors  func(method string, err error)    // Receives failed KV/Object Store writes (nil = print)
+	panics             *panicDiagnostics                 // Recovers and reports handler panics (nil = off)
+	failures           *failureRecorder                  // Records failed calls for replay (nil = off)
+}
+
+// keyToken renders a request field for a key template through the token sanitizer
+func (h *orderServiceHandlers) keyToken(v any) string {
+	return h.tokenSanitizer(fmt.Sprint(v))
 }
 
 func (h *orderServiceHandlers) CreateOrder(req micro.Request) {
@@ -291,14 +651,26 @@ func (h *orderServiceHandlers) CreateOrder(req micro.Request) {
 	// If endpoint timeout is set (> 0), use it; otherwise use service timeout
 	timeout := h.serviceTimeout
 
-	// Create context with timeout if configured, otherwise use background context
-	ctx := context.Background()
+	// Create context with timeout if configured, derived from the service's drain context
+	ctx, end := h.inflight.begin(false) // Tracked for Drain
+	defer end()
 	var cancel context.CancelFunc
 	if timeout > 0 {
 		ctx, cancel = context.WithTimeout(ctx, timeout)
 		defer cancel()
 	}
 
+	// Stop when the client gives up: honor the deadline it propagated
+	ctx, cancelDeadline := withPropagatedDeadline(ctx, req.Headers(), h.maxServerDeadline)
+	defer cancelDeadline()
+
+	// Cancel the handler early if the client abandons the request
+	if h.cancels != nil {
+		var release func()
+		ctx, release = h.cancels.watch(ctx, req.Headers())
+		defer release()
+	}
+
 	// Add incoming NATS headers to context so service methods can access them
 	if req.Headers() != nil {
 		ctx = WithIncomingHeaders(ctx, req.Headers())
@@ -306,62 +678,69 @@ func (h *orderServiceHandlers) CreateOrder(req micro.Request) {
 
 	// Initialize outgoing headers pointer in context so interceptors can set response headers
 	outgoingHeadersPtr := &nats.Header{}
-	ctx = context.WithValue(ctx, outgoingHeadersKey, outgoingHeadersPtr)
+	ctx = withPendingResponseHeaders(ctx, outgoingHeadersPtr)
+
+	// Reject oversized requests before decoding them, decompressing compressed ones
+	body, payloadErr := readPayload("request", req.Headers().Get(ContentEncodingHeader), req.Data(), h.maxRequestSize)
+	if payloadErr != nil {
+		code, message, data := natsErrorFields(payloadErr)
+		req.Error(code, message, data)
+		return
+	}
 
+	// Decode with the codec the client named; clients without Content-Type use the configured one
+	requestJSON, ctErr := payloadUsesJSON(req.Headers().Get(ContentTypeHeader), h.useJSON)
+	if ctErr != nil {
+		code, message, data := natsErrorFields(ctErr)
+		req.Error(code, message, data)
+		return
+	}
 	var msg CreateOrderRequest
-	if h.useJSON {
-		if err := protojson.Unmarshal(req.Data(), &msg); err != nil {
+	if requestJSON {
+		if err := protojson.Unmarshal(body, &msg); err != nil {
 			req.Error(OrderServiceErrCodeInvalidArgument, fmt.Sprintf("failed to decode JSON request: %v", err), nil)
 			return
 		}
 	} else {
-		if err := proto.Unmarshal(req.Data(), &msg); err != nil {
+		if err := proto.Unmarshal(body, &msg); err != nil {
 			req.Error(OrderServiceErrCodeInvalidArgument, fmt.Sprintf("failed to decode request: %v", err), nil)
 			return
 		}
 	}
 
+	// Sample the call for WithSampling, capturing the request before the handler runs
+	sample := h.sampler.begin("OrderService", "CreateOrder", incomingRequestID(req), false, nats.Header(req.Headers()), &msg, false)
+
 	// Define the handler function
 	handler := func(ctx context.Context, request interface{}) (interface{}, error) {
 		typedReq, ok := request.(*CreateOrderRequest)
 		if !ok {
 			return nil, fmt.Errorf("invalid request type")
 		}
+		defer h.requestCheck.check("OrderService.CreateOrder", typedReq)()
 		return h.impl.CreateOrder(ctx, typedReq)
 	}
+	handler = h.panics.wrap("OrderService", "CreateOrder", "order.v1.OrderService.CreateOrder", false, handler)
+	handler = h.failures.wrap("OrderService", "CreateOrder", "order.v1.OrderService.CreateOrder", false, handler)
 
 	// Execute through interceptor chain if configured
 	var resp interface{}
 	var err error
-	if h.interceptor != nil {
+	interceptor := h.interceptor
+	if interceptor != nil {
 		info := &UnaryServerInfo{
-			Service: "OrderService",
-			Method:  "CreateOrder",
-			Subject: "api.v1.create_order",
+			Service:    "OrderService",
+			Method:     "CreateOrder",
+			FullMethod: "order.v1.OrderService.CreateOrder",
+			Subject:    "api.v1.create_order",
 		}
-		resp, err = h.interceptor(ctx, &msg, info, handler)
+		resp, err = interceptor(ctx, &msg, info, handler)
 	} else {
 		resp, err = handler(ctx, &msg)
 	}
+	sample.end(resp, err)
 	if err != nil {
-		// Check if error implements custom status code/message/data methods
-		code := OrderServiceErrCodeInternal
-		message := err.Error()
-		var data []byte
-
-		// Check for NatsErrorCode() string method
-		if coder, ok := err.(interface{ NatsErrorCode() string }); ok {
-			code = coder.NatsErrorCode()
-		}
-		// Check for NatsErrorMessage() string method
-		if messager, ok := err.(interface{ NatsErrorMessage() string }); ok {
-			message = messager.NatsErrorMessage()
-		}
-		// Check for NatsErrorData() []byte method
-		if dataProvider, ok := err.(interface{ NatsErrorData() []byte }); ok {
-			data = dataProvider.NatsErrorData()
-		}
-
+		code, message, data := natsErrorFields(err)
 		req.Error(code, message, data)
 		return
 	}
@@ -375,7 +754,7 @@ func (h *orderServiceHandlers) CreateOrder(req micro.Request) {
 
 	var data []byte
 	if h.useJSON {
-		data, err = protojson.Marshal(typedResp)
+		data, err = marshalJSON(typedResp, false)
 		if err != nil {
 			req.Error(OrderServiceErrCodeInternal, fmt.Sprintf("failed to encode JSON response: %v", err), nil)
 			return
@@ -390,22 +769,17 @@ func (h *orderServiceHandlers) CreateOrder(req micro.Request) {
 
 	// Check if context has outgoing headers set by interceptors
 	// Read from the pointer that was initialized at the start
-	var outgoingHeaders nats.Header
-	if headersPtr, ok := ctx.Value(outgoingHeadersKey).(*nats.Header); ok && headersPtr != nil {
-		outgoingHeaders = *headersPtr
-	}
+	outgoingHeaders := pendingResponseHeaders(ctx)
 
-	if len(outgoingHeaders) > 0 {
-		// Send response with headers using micro.WithHeaders
-		// Convert nats.Header to micro.Headers (they are the same underlying type)
-		if err := req.Respond(data, micro.WithHeaders(micro.Headers(outgoingHeaders))); err != nil {
-			fmt.Fprintf(os.Stderr, "failed to send response for CreateOrder: %v\n", err)
-		}
-	} else {
-		// Send response without headers
-		if err := req.Respond(data); err != nil {
-			fmt.Fprintf(os.Stderr, "failed to send response for CreateOrder: %v\n", err)
-		}
+	// Send response with headers set by interceptors plus the response codec
+	// Convert nats.Header to micro.Headers (they are the same underlying type)
+	outgoingHeaders = h.responseHeaders.strip(h.endpointPrefix+"create_order", outgoingHeaders)
+	outgoingHeaders = withContentType(outgoingHeaders, h.useJSON)
+	if h.compression > 0 && acceptsEncoding(req.Headers().Get(acceptEncodingHeader), h.compressor.Name()) {
+		data = compressPayload(h.compressor, h.compression, data, outgoingHeaders)
+	}
+	if err := req.Respond(data, micro.WithHeaders(micro.Headers(outgoingHeaders))); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to send response for CreateOrder: %v\n", err)
 	}
 }
 
@@ -414,14 +788,26 @@ func (h *orderServiceHandlers) GetOrder(req micro.Request) {
 	// If endpoint timeout is set (> 0), use it; otherwise use service timeout
 	timeout := h.serviceTimeout
 
-	// Create context with timeout if configured, otherwise use background context
-	ctx := context.Background()
+	// Create context with timeout if configured, derived from the service's drain context
+	ctx, end := h.inflight.begin(false) // Tracked for Drain
+	defer end()
 	var cancel context.CancelFunc
 	if timeout > 0 {
 		ctx, cancel = context.WithTimeout(ctx, timeout)
 		defer cancel()
 	}
 
+	// Stop when the client gives up: honor the deadline it propagated
+	ctx, cancelDeadline := withPropagatedDeadline(ctx, req.Headers(), h.maxServerDeadline)
+	defer cancelDeadline()
+
+	// Cancel the handler early if the client abandons the request
+	if h.cancels != nil {
+		var release func()
+		ctx, release = h.cancels.watch(ctx, req.Headers())
+		defer release()
+	}
+
 	// Add incoming NATS headers to context so service methods can access them
 	if req.Headers() != nil {
 		ctx = WithIncomingHeaders(ctx, req.Headers())
@@ -429,62 +815,69 @@ func (h *orderServiceHandlers) GetOrder(req micro.Request) {
 
 	// Initialize outgoing headers pointer in context so interceptors can set response headers
 	outgoingHeadersPtr := &nats.Header{}
-	ctx = context.WithValue(ctx, outgoingHeadersKey, outgoingHeadersPtr)
+	ctx = withPendingResponseHeaders(ctx, outgoingHeadersPtr)
 
+	// Reject oversized requests before decoding them, decompressing compressed ones
+	body, payloadErr := readPayload("request", req.Headers().Get(ContentEncodingHeader), req.Data(), h.maxRequestSize)
+	if payloadErr != nil {
+		code, message, data := natsErrorFields(payloadErr)
+		req.Error(code, message, data)
+		return
+	}
+
+	// Decode with the codec the client named; clients without Content-Type use the configured one
+	requestJSON, ctErr := payloadUsesJSON(req.Headers().Get(ContentTypeHeader), h.useJSON)
+	if ctErr != nil {
+		code, message, data := natsErrorFields(ctErr)
+		req.Error(code, message, data)
+		return
+	}
 	var msg GetOrderRequest
-	if h.useJSON {
-		if err := protojson.Unmarshal(req.Data(), &msg); err != nil {
+	if requestJSON {
+		if err := protojson.Unmarshal(body, &msg); err != nil {
 			req.Error(OrderServiceErrCodeInvalidArgument, fmt.Sprintf("failed to decode JSON request: %v", err), nil)
 			return
 		}
 	} else {
-		if err := proto.Unmarshal(req.Data(), &msg); err != nil {
+		if err := proto.Unmarshal(body, &msg); err != nil {
 			req.Error(OrderServiceErrCodeInvalidArgument, fmt.Sprintf("failed to decode request: %v", err), nil)
 			return
 		}
 	}
 
+	// Sample the call for WithSampling, capturing the request before the handler runs
+	sample := h.sampler.begin("OrderService", "GetOrder", incomingRequestID(req), false, nats.Header(req.Headers()), &msg, false)
+
 	// Define the handler function
 	handler := func(ctx context.Context, request interface{}) (interface{}, error) {
 		typedReq, ok := request.(*GetOrderRequest)
 		if !ok {
 			return nil, fmt.Errorf("invalid request type")
 		}
+		defer h.requestCheck.check("OrderService.GetOrder", typedReq)()
 		return h.impl.GetOrder(ctx, typedReq)
 	}
+	handler = h.panics.wrap("OrderService", "GetOrder", "order.v1.OrderService.GetOrder", false, handler)
+	handler = h.failures.wrap("OrderService", "GetOrder", "order.v1.OrderService.GetOrder", false, handler)
 
 	// Execute through interceptor chain if configured
 	var resp interface{}
 	var err error
-	if h.interceptor != nil {
+	interceptor := h.interceptor
+	if interceptor != nil {
 		info := &UnaryServerInfo{
-			Service: "OrderService",
-			Method:  "GetOrder",
-			Subject: "api.v1.get_order",
+			Service:    "OrderService",
+			Method:     "GetOrder",
+			FullMethod: "order.v1.OrderService.GetOrder",
+			Subject:    "api.v1.get_order",
 		}
-		resp, err = h.interceptor(ctx, &msg, info, handler)
+		resp, err = interceptor(ctx, &msg, info, handler)
 	} else {
 		resp, err = handler(ctx, &msg)
 	}
+	sample.end(resp, err)
 	if err != nil {
-		// Check if error implements custom status code/message/data methods
-		code := OrderServiceErrCodeInternal
-		message := err.Error()
-		var data []byte
-
-		// Check for NatsErrorCode() string method
-		if coder, ok := err.(interface{ NatsErrorCode() string }); ok {
-			code = coder.NatsErrorCode()
-		}
-		// Check for NatsErrorMessage() string method
-		if messager, ok := err.(interface{ NatsErrorMessage() string }); ok {
-			message = messager.NatsErrorMessage()
-		}
-		// Check for NatsErrorData() []byte method
-		if dataProvider, ok := err.(interface{ NatsErrorData() []byte }); ok {
-			data = dataProvider.NatsErrorData()
-		}
-
+		code, message, data := natsErrorFields(err)
 		req.Error(code, message, data)
 		return
 	}
@@ -498,7 +891,7 @@ func (h *orderServiceHandlers) GetOrder(req micro.Request) {
 
 	var data []byte
 	if h.useJSON {
-		data, err = protojson.Marshal(typedResp)
+		data, err = marshalJSON(typedResp, false)
 		if err != nil {
 			req.Error(OrderServiceErrCodeInternal, fmt.Sprintf("failed to encode JSON response: %v", err), nil)
 			return
@@ -513,22 +906,17 @@ func (h *orderServiceHandlers) GetOrder(req micro.Request) {
 
 	// Check if context has outgoing headers set by interceptors
 	// Read from the pointer that was initialized at the start
-	var outgoingHeaders nats.Header
-	if headersPtr, ok := ctx.Value(outgoingHeadersKey).(*nats.Header); ok && headersPtr != nil {
-		outgoingHeaders = *headersPtr
-	}
+	outgoingHeaders := pendingResponseHeaders(ctx)
 
-	if len(outgoingHeaders) > 0 {
-		// Send response with headers using micro.WithHeaders
-		// Convert nats.Header to micro.Headers (they are the same underlying type)
-		if err := req.Respond(data, micro.WithHeaders(micro.Headers(outgoingHeaders))); err != nil {
-			fmt.Fprintf(os.Stderr, "failed to send response for GetOrder: %v\n", err)
-		}
-	} else {
-		// Send response without headers
-		if err := req.Respond(data); err != nil {
-			fmt.Fprintf(os.Stderr, "failed to send response for GetOrder: %v\n", err)
-		}
+	// Send response with headers set by interceptors plus the response codec
+	// Convert nats.Header to micro.Headers (they are the same underlying type)
+	outgoingHeaders = h.responseHeaders.strip(h.endpointPrefix+"get_order", outgoingHeaders)
+	outgoingHeaders = withContentType(outgoingHeaders, h.useJSON)
+	if h.compression > 0 && acceptsEncoding(req.Headers().Get(acceptEncodingHeader), h.compressor.Name()) {
+		data = compressPayload(h.compressor, h.compression, data, outgoingHeaders)
+	}
+	if err := req.Respond(data, micro.WithHeaders(micro.Headers(outgoingHeaders))); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to send response for GetOrder: %v\n", err)
 	}
 }
 
@@ -537,14 +925,26 @@ func (h *orderServiceHandlers) ListOrders(req micro.Request) {
 	// If endpoint timeout is set (> 0), use it; otherwise use service timeout
 	timeout := h.serviceTimeout
 
-	// Create context with timeout if configured, otherwise use background context
-	ctx := context.Background()
+	// Create context with timeout if configured, derived from the service's drain context
+	ctx, end := h.inflight.begin(false) // Tracked for Drain
+	defer end()
 	var cancel context.CancelFunc
 	if timeout > 0 {
 		ctx, cancel = context.WithTimeout(ctx, timeout)
 		defer cancel()
 	}
 
+	// Stop when the client gives up: honor the deadline it propagated
+	ctx, cancelDeadline := withPropagatedDeadline(ctx, req.Headers(), h.maxServerDeadline)
+	defer cancelDeadline()
+
+	// Cancel the handler early if the client abandons the request
+	if h.cancels != nil {
+		var release func()
+		ctx, release = h.cancels.watch(ctx, req.Headers())
+		defer release()
+	}
+
 	// Add incoming NATS headers to context so service methods can access them
 	if req.Headers() != nil {
 		ctx = WithIncomingHeaders(ctx, req.Headers())
@@ -552,62 +952,69 @@ func (h *orderServiceHandlers) ListOrders(req micro.Request) {
 
 	// Initialize outgoing headers pointer in context so interceptors can set response headers
 	outgoingHeadersPtr := &nats.Header{}
-	ctx = context.WithValue(ctx, outgoingHeadersKey, outgoingHeadersPtr)
+	ctx = withPendingResponseHeaders(ctx, outgoingHeadersPtr)
 
+	// Reject oversized requests before decoding them, decompressing compressed ones
+	body, payloadErr := readPayload("request", req.Headers().Get(ContentEncodingHeader), req.Data(), h.maxRequestSize)
+	if payloadErr != nil {
+		code, message, data := natsErrorFields(payloadErr)
+		req.Error(code, message, data)
+		return
+	}
+
+	// Decode with the codec the client named; clients without Content-Type use the configured one
+	requestJSON, ctErr := payloadUsesJSON(req.Headers().Get(ContentTypeHeader), h.useJSON)
+	if ctErr != nil {
+		code, message, data := natsErrorFields(ctErr)
+		req.Error(code, message, data)
+		return
+	}
 	var msg ListOrdersRequest
-	if h.useJSON {
-		if err := protojson.Unmarshal(req.Data(), &msg); err != nil {
+	if requestJSON {
+		if err := protojson.Unmarshal(body, &msg); err != nil {
 			req.Error(OrderServiceErrCodeInvalidArgument, fmt.Sprintf("failed to decode JSON request: %v", err), nil)
 			return
 		}
 	} else {
-		if err := proto.Unmarshal(req.Data(), &msg); err != nil {
+		if err := proto.Unmarshal(body, &msg); err != nil {
 			req.Error(OrderServiceErrCodeInvalidArgument, fmt.Sprintf("failed to decode request: %v", err), nil)
 			return
 		}
 	}
 
+	// Sample the call for WithSampling, capturing the request before the handler runs
+	sample := h.sampler.begin("OrderService", "ListOrders", incomingRequestID(req), false, nats.Header(req.Headers()), &msg, false)
+
 	// Define the handler function
 	handler := func(ctx context.Context, request interface{}) (interface{}, error) {
 		typedReq, ok := request.(*ListOrdersRequest)
 		if !ok {
 			return nil, fmt.Errorf("invalid request type")
 		}
+		defer h.requestCheck.check("OrderService.ListOrders", typedReq)()
 		return h.impl.ListOrders(ctx, typedReq)
 	}
+	handler = h.panics.wrap("OrderService", "ListOrders", "order.v1.OrderService.ListOrders", false, handler)
+	handler = h.failures.wrap("OrderService", "ListOrders", "order.v1.OrderService.ListOrders", false, handler)
 
 	// Execute through interceptor chain if configured
 	var resp interface{}
 	var err error
-	if h.interceptor != nil {
+	interceptor := h.interceptor
+	if interceptor != nil {
 		info := &UnaryServerInfo{
-			Service: "OrderService",
-			Method:  "ListOrders",
-			Subject: "api.v1.list_orders",
+			Service:    "OrderService",
+			Method:     "ListOrders",
+			FullMethod: "order.v1.OrderService.ListOrders",
+			Subject:    "api.v1.list_orders",
 		}
-		resp, err = h.interceptor(ctx, &msg, info, handler)
+		resp, err = interceptor(ctx, &msg, info, handler)
 	} else {
 		resp, err = handler(ctx, &msg)
 	}
+	sample.end(resp, err)
 	if err != nil {
-		// Check if error implements custom status code/message/data methods
-		code := OrderServiceErrCodeInternal
-		message := err.Error()
-		var data []byte
-
-		// Check for NatsErrorCode() string method
-		if coder, ok := err.(interface{ NatsErrorCode() string }); ok {
-			code = coder.NatsErrorCode()
-		}
-		// Check for NatsErrorMessage() string method
-		if messager, ok := err.(interface{ NatsErrorMessage() string }); ok {
-			message = messager.NatsErrorMessage()
-		}
-		// Check for NatsErrorData() []byte method
-		if dataProvider, ok := err.(interface{ NatsErrorData() []byte }); ok {
-			data = dataProvider.NatsErrorData()
-		}
-
+		code, message, data := natsErrorFields(err)
 		req.Error(code, message, data)
 		return
 	}
@@ -621,7 +1028,7 @@ func (h *orderServiceHandlers) ListOrders(req micro.Request) {
 
 	var data []byte
 	if h.useJSON {
-		data, err = protojson.Marshal(typedResp)
+		data, err = marshalJSON(typedResp, false)
 		if err != nil {
 			req.Error(OrderServiceErrCodeInternal, fmt.Sprintf("failed to encode JSON response: %v", err), nil)
 			return
@@ -636,22 +1043,17 @@ func (h *orderServiceHandlers) ListOrders(req micro.Request) {
 
 	// Check if context has outgoing headers set by interceptors
 	// Read from the pointer that was initialized at the start
-	var outgoingHeaders nats.Header
-	if headersPtr, ok := ctx.Value(outgoingHeadersKey).(*nats.Header); ok && headersPtr != nil {
-		outgoingHeaders = *headersPtr
-	}
+	outgoingHeaders := pendingResponseHeaders(ctx)
 
-	if len(outgoingHeaders) > 0 {
-		// Send response with headers using micro.WithHeaders
-		// Convert nats.Header to micro.Headers (they are the same underlying type)
-		if err := req.Respond(data, micro.WithHeaders(micro.Headers(outgoingHeaders))); err != nil {
-			fmt.Fprintf(os.Stderr, "failed to send response for ListOrders: %v\n", err)
-		}
-	} else {
-		// Send response without headers
-		if err := req.Respond(data); err != nil {
-			fmt.Fprintf(os.Stderr, "failed to send response for ListOrders: %v\n", err)
-		}
+	// Send response with headers set by interceptors plus the response codec
+	// Convert nats.Header to micro.Headers (they are the same underlying type)
+	outgoingHeaders = h.responseHeaders.strip(h.endpointPrefix+"list_orders", outgoingHeaders)
+	outgoingHeaders = withContentType(outgoingHeaders, h.useJSON)
+	if h.compression > 0 && acceptsEncoding(req.Headers().Get(acceptEncodingHeader), h.compressor.Name()) {
+		data = compressPayload(h.compressor, h.compression, data, outgoingHeaders)
+	}
+	if err := req.Respond(data, micro.WithHeaders(micro.Headers(outgoingHeaders))); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to send response for ListOrders: %v\n", err)
 	}
 }
 
@@ -660,14 +1062,26 @@ func (h *orderServiceHandlers) UpdateOrderStatus(req micro.Request) {
 	// If endpoint timeout is set (> 0), use it; otherwise use service timeout
 	timeout := h.serviceTimeout
 
-	// Create context with timeout if configured, otherwise use background context
-	ctx := context.Background()
+	// Create context with timeout if configured, derived from the service's drain context
+	ctx, end := h.inflight.begin(false) // Tracked for Drain
+	defer end()
 	var cancel context.CancelFunc
 	if timeout > 0 {
 		ctx, cancel = context.WithTimeout(ctx, timeout)
 		defer cancel()
 	}
 
+	// Stop when the client gives up: honor the deadline it propagated
+	ctx, cancelDeadline := withPropagatedDeadline(ctx, req.Headers(), h.maxServerDeadline)
+	defer cancelDeadline()
+
+	// Cancel the handler early if the client abandons the request
+	if h.cancels != nil {
+		var release func()
+		ctx, release = h.cancels.watch(ctx, req.Headers())
+		defer release()
+	}
+
 	// Add incoming NATS headers to context so service methods can access them
 	if req.Headers() != nil {
 		ctx = WithIncomingHeaders(ctx, req.Headers())
@@ -675,62 +1089,69 @@ func (h *orderServiceHandlers) UpdateOrderStatus(req micro.Request) {
 
 	// Initialize outgoing headers pointer in context so interceptors can set response headers
 	outgoingHeadersPtr := &nats.Header{}
-	ctx = context.WithValue(ctx, outgoingHeadersKey, outgoingHeadersPtr)
+	ctx = withPendingResponseHeaders(ctx, outgoingHeadersPtr)
 
+	// Reject oversized requests before decoding them, decompressing compressed ones
+	body, payloadErr := readPayload("request", req.Headers().Get(ContentEncodingHeader), req.Data(), h.maxRequestSize)
+	if payloadErr != nil {
+		code, message, data := natsErrorFields(payloadErr)
+		req.Error(code, message, data)
+		return
+	}
+
+	// Decode with the codec the client named; clients without Content-Type use the configured one
+	requestJSON, ctErr := payloadUsesJSON(req.Headers().Get(ContentTypeHeader), h.useJSON)
+	if ctErr != nil {
+		code, message, data := natsErrorFields(ctErr)
+		req.Error(code, message, data)
+		return
+	}
 	var msg UpdateOrderStatusRequest
-	if h.useJSON {
-		if err := protojson.Unmarshal(req.Data(), &msg); err != nil {
+	if requestJSON {
+		if err := protojson.Unmarshal(body, &msg); err != nil {
 			req.Error(OrderServiceErrCodeInvalidArgument, fmt.Sprintf("failed to decode JSON request: %v", err), nil)
 			return
 		}
 	} else {
-		if err := proto.Unmarshal(req.Data(), &msg); err != nil {
+		if err := proto.Unmarshal(body, &msg); err != nil {
 			req.Error(OrderServiceErrCodeInvalidArgument, fmt.Sprintf("failed to decode request: %v", err), nil)
 			return
 		}
 	}
 
+	// Sample the call for WithSampling, capturing the request before the handler runs
+	sample := h.sampler.begin("OrderService", "UpdateOrderStatus", incomingRequestID(req), false, nats.Header(req.Headers()), &msg, false)
+
 	// Define the handler function
 	handler := func(ctx context.Context, request interface{}) (interface{}, error) {
 		typedReq, ok := request.(*UpdateOrderStatusRequest)
 		if !ok {
 			return nil, fmt.Errorf("invalid request type")
 		}
+		defer h.requestCheck.check("OrderService.UpdateOrderStatus", typedReq)()
 		return h.impl.UpdateOrderStatus(ctx, typedReq)
 	}
+	handler = h.panics.wrap("OrderService", "UpdateOrderStatus", "order.v1.OrderService.UpdateOrderStatus", false, handler)
+	handler = h.failures.wrap("OrderService", "UpdateOrderStatus", "order.v1.OrderService.UpdateOrderStatus", false, handler)
 
 	// Execute through interceptor chain if configured
 	var resp interface{}
 	var err error
-	if h.interceptor != nil {
+	interceptor := h.interceptor
+	if interceptor != nil {
 		info := &UnaryServerInfo{
-			Service: "OrderService",
-			Method:  "UpdateOrderStatus",
-			Subject: "api.v1.update_order_status",
+			Service:    "OrderService",
+			Method:     "UpdateOrderStatus",
+			FullMethod: "order.v1.OrderService.UpdateOrderStatus",
+			Subject:    "api.v1.update_order_status",
 		}
-		resp, err = h.interceptor(ctx, &msg, info, handler)
+		resp, err = interceptor(ctx, &msg, info, handler)
 	} else {
 		resp, err = handler(ctx, &msg)
 	}
+	sample.end(resp, err)
 	if err != nil {
-		// Check if error implements custom status code/message/data methods
-		code := OrderServiceErrCodeInternal
-		message := err.Error()
-		var data []byte
-
-		// Check for NatsErrorCode() string method
-		if coder, ok := err.(interface{ NatsErrorCode() string }); ok {
-			code = coder.NatsErrorCode()
-		}
-		// Check for NatsErrorMessage() string method
-		if messager, ok := err.(interface{ NatsErrorMessage() string }); ok {
-			message = messager.NatsErrorMessage()
-		}
-		// Check for NatsErrorData() []byte method
-		if dataProvider, ok := err.(interface{ NatsErrorData() []byte }); ok {
-			data = dataProvider.NatsErrorData()
-		}
-
+		code, message, data := natsErrorFields(err)
 		req.Error(code, message, data)
 		return
 	}
@@ -744,7 +1165,7 @@ func (h *orderServiceHandlers) UpdateOrderStatus(req micro.Request) {
 
 	var data []byte
 	if h.useJSON {
-		data, err = protojson.Marshal(typedResp)
+		data, err = marshalJSON(typedResp, false)
 		if err != nil {
 			req.Error(OrderServiceErrCodeInternal, fmt.Sprintf("failed to encode JSON response: %v", err), nil)
 			return
@@ -759,49 +1180,122 @@ func (h *orderServiceHandlers) UpdateOrderStatus(req micro.Request) {
 
 	// Check if context has outgoing headers set by interceptors
 	// Read from the pointer that was initialized at the start
-	var outgoingHeaders nats.Header
-	if headersPtr, ok := ctx.Value(outgoingHeadersKey).(*nats.Header); ok && headersPtr != nil {
-		outgoingHeaders = *headersPtr
-	}
+	outgoingHeaders := pendingResponseHeaders(ctx)
 
-	if len(outgoingHeaders) > 0 {
-		// Send response with headers using micro.WithHeaders
-		// Convert nats.Header to micro.Headers (they are the same underlying type)
-		if err := req.Respond(data, micro.WithHeaders(micro.Headers(outgoingHeaders))); err != nil {
-			fmt.Fprintf(os.Stderr, "failed to send response for UpdateOrderStatus: %v\n", err)
-		}
-	} else {
-		// Send response without headers
-		if err := req.Respond(data); err != nil {
-			fmt.Fprintf(os.Stderr, "failed to send response for UpdateOrderStatus: %v\n", err)
-		}
+	// Send response with headers set by interceptors plus the response codec
+	// Convert nats.Header to micro.Headers (they are the same underlying type)
+	outgoingHeaders = h.responseHeaders.strip(h.endpointPrefix+"update_order_status", outgoingHeaders)
+	outgoingHeaders = withContentType(outgoingHeaders, h.useJSON)
+	if h.compression > 0 && acceptsEncoding(req.Headers().Get(acceptEncodingHeader), h.compressor.Name()) {
+		data = compressPayload(h.compressor, h.compression, data, outgoingHeaders)
+	}
+	if err := req.Respond(data, micro.WithHeaders(micro.Headers(outgoingHeaders))); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to send response for UpdateOrderStatus: %v\n", err)
 	}
 }
 
 // OrderServiceNatsClientInterface is the interface for the NATS client
 // This interface allows for easier dependency injection and testing
 type OrderServiceNatsClientInterface interface {
-	CreateOrder(context.Context, *CreateOrderRequest) (*CreateOrderResponse, error)
-	GetOrder(context.Context, *GetOrderRequest) (*GetOrderResponse, error)
-	ListOrders(context.Context, *ListOrdersRequest) (*ListOrdersResponse, error)
-	UpdateOrderStatus(context.Context, *UpdateOrderStatusRequest) (*UpdateOrderStatusResponse, error)
+	CreateOrder(context.Context, *CreateOrderRequest, ...CallOption) (*CreateOrderResponse, error)
+	GetOrder(context.Context, *GetOrderRequest, ...CallOption) (*GetOrderResponse, error)
+	ListOrders(context.Context, *ListOrdersRequest, ...CallOption) (*ListOrdersResponse, error)
+	UpdateOrderStatus(context.Context, *UpdateOrderStatusRequest, ...CallOption) (*UpdateOrderStatusResponse, error)
+	Health(ctx context.Context) (*HealthResponse, error)
+	Schema(ctx context.Context) (*ServiceSchemaDocument, error)
 	Endpoints() []OrderServiceEndpointInfo
+	WithHeaders(headers nats.Header) OrderServiceNatsClientInterface
+	Close()
 }
 
 // OrderServiceNatsClient is the concrete implementation of OrderServiceNatsClientInterface
 type OrderServiceNatsClient struct {
-	nc            *nats.Conn
-	subjectPrefix string
-	useJSON       bool                   // Use JSON encoding instead of binary protobuf
-	interceptor   UnaryClientInterceptor // Chained interceptors
-	js            jetstream.JetStream    // Optional JetStream for KV/ObjectStore reads
+	nc                  *nats.Conn
+	subjectPrefix       string
+	useJSON             bool                    // Use JSON encoding instead of binary protobuf
+	interceptor         UnaryClientInterceptor  // Chained interceptors
+	streamInterceptor   StreamClientInterceptor // Chained stream interceptors
+	js                  jetstream.JetStream     // Optional JetStream for KV/ObjectStore reads
+	kvStaleAfter        time.Duration           // Age at which Get*Cached treats KV entries as misses (0 = never)
+	cancelPropagation   bool                    // Publish a cancel notice when ctx ends mid-request
+	timeout             time.Duration           // Default unary timeout when ctx has no deadline
+	retry               *retryPolicy            // Unary retry policy (nil = no retries)
+	tokenSanitizer      func(string) string     // Escapes request fields in key helpers
+	idGenerator         func() string           // Mints cancel subject and stream inbox IDs (nil = NUIDs)
+	maxResponseSize     int                     // Largest accepted response payload in bytes (0 = unlimited)
+	connSelector        func() *nats.Conn       // Picks the connection per call (nil = nc)
+	journal             *journal                // Records calls for replay (nil = no journal)
+	streamWindow        int                     // Server-stream flow control window (0 = none)
+	streamAllowGaps     bool                    // Skip lost stream messages instead of failing Recv
+	streamCompression   int                     // Smallest stream message compressed, once negotiated (0 = off)
+	compression         int                     // Smallest unary request compressed (0 = off)
+	compressionCodec    string                  // Codec unary requests are compressed with
+	streamResumeOnDrain bool                    // Reopen server streams when their server drains
+	headerPolicy        *headerPolicy           // Strips outgoing headers (nil = allow all)
+	sampler             *sampler                // Samples unary calls (WithClientSampling)
+	monitor             *connectionMonitor      // Samples the connections (WithClientConnectionMonitor)
+	headers             nats.Header             // Sent under each call's OutgoingHeaders (WithHeaders)
+}
+
+// compress returns a unary request payload compressed as WithClientCompression
+// asks, flagged in headers
+func (c *OrderServiceNatsClient) compress(data []byte, headers nats.Header) ([]byte, error) {
+	if c.compression == 0 {
+		return data, nil
+	}
+	codec := compressorFor(c.compressionCodec)
+	if codec == nil {
+		return nil, fmt.Errorf("WithClientCompression: codec %q is not registered; add it with RegisterCompressor", c.compressionCodec)
+	}
+	return compressPayload(codec, c.compression, data, headers), nil
+}
+
+// conn returns the connection for the next call or stream
+func (c *OrderServiceNatsClient) conn() *nats.Conn {
+	if c.connSelector != nil {
+		return c.connSelector()
+	}
+	return c.nc
+}
+
+// outgoingHeaders returns the headers of ctx that WithOutgoingHeaderPolicy lets a call send
+func (c *OrderServiceNatsClient) outgoingHeaders(ctx context.Context) nats.Header {
+	headers, _ := c.headerPolicy.filter(layerHeaders(c.headers, OutgoingHeaders(ctx)))
+	return headers
+}
+
+// WithHeaders returns a view of the client that sends headers with every call and
+// stream, beneath those set on the call's context with WithOutgoingHeaders. A view
+// shares the client's connections, interceptors, retry policy, journal and sampler
+// rather than copying them, so it is cheap to make per request, e.g. per tenant, and
+// like the client is safe for concurrent use. Views of views add to their parent's
+// headers. Closing a view does not stop the client's connection monitor.
+func (c *OrderServiceNatsClient) WithHeaders(headers nats.Header) OrderServiceNatsClientInterface {
+	layered := make(nats.Header, len(c.headers)+len(headers))
+	for name, values := range c.headers {
+		layered[name] = values
+	}
+	for name, values := range headers {
+		layered[name] = append([]string(nil), values...) // Later changes to headers stay out of the view
+	}
+	view := *c
+	view.headers = layered
+	view.monitor = nil
+	return &view
+}
+
+// keyToken renders a request field for a key template through the token sanitizer
+func (c *OrderServiceNatsClient) keyToken(v any) string {
+	return c.tokenSanitizer(fmt.Sprint(v))
 }
 
 // NewOrderServiceNatsClient creates a new NATS client for OrderService.
 // The client sends requests over NATS using protobuf serialization (or JSON if configured).
 func NewOrderServiceNatsClient(nc *nats.Conn, opts ...NatsClientOption) OrderServiceNatsClientInterface {
 	cfg := &natsClientConfig{
-		subjectPrefix: "api.v1",
+		subjectPrefix:  "api.v1",
+		tokenSanitizer: SanitizeToken,
+		streamWindow:   defaultStreamWindow,
 	}
 	for _, opt := range opts {
 		opt.applyNatsClientOption(cfg)
@@ -814,30 +1308,78 @@ func NewOrderServiceNatsClient(nc *nats.Conn, opts ...NatsClientOption) OrderSer
 	}
 
 	c := &OrderServiceNatsClient{
-		nc:            nc,
-		subjectPrefix: cfg.subjectPrefix,
-		useJSON:       false,
-		interceptor:   chainedInterceptor,
-		js:            cfg.js,
+		nc:                  nc,
+		subjectPrefix:       cfg.subjectPrefix,
+		useJSON:             false,
+		interceptor:         chainedInterceptor,
+		streamInterceptor:   chainStreamClientInterceptors(cfg.streamInterceptors),
+		js:                  cfg.js,
+		kvStaleAfter:        cfg.kvStaleAfter,
+		cancelPropagation:   cfg.cancelPropagation,
+		timeout:             cfg.timeout,
+		retry:               newRetryPolicy(cfg),
+		tokenSanitizer:      cfg.tokenSanitizer,
+		idGenerator:         cfg.idGenerator,
+		maxResponseSize:     cfg.maxResponseSize,
+		connSelector:        cfg.connSelector,
+		journal:             cfg.journal,
+		streamWindow:        cfg.streamWindow,
+		streamAllowGaps:     cfg.streamAllowGaps,
+		streamCompression:   cfg.streamCompression,
+		compression:         cfg.compression,
+		compressionCodec:    cfg.compressionCodec,
+		streamResumeOnDrain: cfg.streamResumeOnDrain,
+		headerPolicy:        cfg.headerPolicy,
+		sampler:             newSampler(cfg.sampling, cfg.samplingSalt),
+		monitor:             startConnectionMonitor(cfg.connMonitor, monitoredConns(nc, cfg.poolConns)...),
 	}
 	return c
 }
 
+// NewOrderServiceNatsClientPool creates a OrderService client that spreads calls
+// over conns round-robin (see RoundRobinConns). Streams stay on the connection they
+// opened on. A WithConnSelector in opts replaces the round-robin selector.
+func NewOrderServiceNatsClientPool(conns []*nats.Conn, opts ...NatsClientOption) OrderServiceNatsClientInterface {
+	selector := RoundRobinConns(conns)
+	opts = append([]NatsClientOption{natsClientOptionFunc(func(c *natsClientConfig) { c.poolConns = conns })}, opts...)
+	return NewOrderServiceNatsClient(conns[0], append([]NatsClientOption{WithConnSelector(selector)}, opts...)...)
+}
+
 // CreateOrder sends a CreateOrder request to the service via NATS.
-// Returns an error if the request fails or the service returns an error.
-func (c *OrderServiceNatsClient) CreateOrder(ctx context.Context, req *CreateOrderRequest) (*CreateOrderResponse, error) {
+// Returns an error if the request fails or the service returns an error,
+// or a *TimeoutError if a WithCallTimeout/WithClientTimeout expires.
+func (c *OrderServiceNatsClient) CreateOrder(ctx context.Context, req *CreateOrderRequest, opts ...CallOption) (*CreateOrderResponse, error) {
 	method := "CreateOrder"
 
-	// Pointer to store response headers - stored in context so invoker can update it
-	responseHeadersPtr := &nats.Header{}
+	// Sample the call for WithClientSampling under a request ID the service sees too
+	var requestID string
+	if c.sampler.enabled() {
+		ctx, requestID = withRequestID(ctx, c.idGenerator)
+	}
+	sample := c.sampler.begin("OrderService", method, requestID, true, c.outgoingHeaders(ctx), req, false)
+
+	// Bound the call when the caller gave no deadline (or asked for a per-call timeout)
+	start := time.Now()
+	parentCtx := ctx
+	ctx, cancel, timeout := withCallTimeout(ctx, c.timeout, opts)
+	defer cancel()
+
+	// Record sizes, attempts and duration for CallInfoFromContext
+	ctx, info := startCallInfo(ctx, "OrderService", c.subjectPrefix+".create_order")
+	defer info.finish()
+	ctx = withIDGenerator(ctx, c.idGenerator) // For NewID in interceptors
 
-	// Add the response headers pointer to context so invoker can populate it
+	// Give the invoker somewhere in the context to store the response headers
 	// Interceptors can then read the headers from the same context
-	ctx = context.WithValue(ctx, responseHeadersKey, responseHeadersPtr)
+	ctx = WithResponseHeaders(ctx, nats.Header{})
 
 	// Define the invoker function that performs the actual NATS call
 	invoker := func(invokerCtx context.Context, method string, request, reply interface{}) error {
-		subject := c.subjectPrefix + ".create_order"
+		// WithTargetInstance sends the call to one instance's own subject
+		subject, subjectErr := targetSubject(c.subjectPrefix+".create_order", opts)
+		if subjectErr != nil {
+			return newOrderServiceError(OrderServiceErrCodeInvalidArgument, method, subjectErr.Error(), nil)
+		}
 
 		// Marshal request
 		typedReq, ok := request.(*CreateOrderRequest)
@@ -848,7 +1390,7 @@ func (c *OrderServiceNatsClient) CreateOrder(ctx context.Context, req *CreateOrd
 		var data []byte
 		var err error
 		if c.useJSON {
-			data, err = protojson.Marshal(typedReq)
+			data, err = marshalJSON(typedReq, false)
 		} else {
 			data, err = proto.Marshal(typedReq)
 		}
@@ -856,83 +1398,124 @@ func (c *OrderServiceNatsClient) CreateOrder(ctx context.Context, req *CreateOrd
 			return err
 		}
 
-		// Extract outgoing headers from context and attach to NATS message
-		var msg *nats.Msg
-		if headers := OutgoingHeaders(invokerCtx); headers != nil {
-			msg, err = c.nc.RequestMsgWithContext(invokerCtx, &nats.Msg{
-				Subject: subject,
-				Data:    data,
-				Header:  headers,
-			})
-		} else {
-			msg, err = c.nc.RequestWithContext(invokerCtx, subject, data)
+		// Extract outgoing headers from context and attach them, naming the codec, to the NATS message
+		nc := c.conn()
+		headers := withContentType(c.outgoingHeaders(invokerCtx), c.useJSON)
+		setDeadlineHeaders(invokerCtx, headers) // For deadline-aware scheduling and handler contexts
+		if data, err = c.compress(data, headers); err != nil {
+			return err
+		}
+		headers.Set(acceptEncodingHeader, compressorNames()) // Services compress responses only for clients that decompress them
+		if c.cancelPropagation {
+			var stop func() bool
+			headers, stop = propagateCancel(invokerCtx, nc, headers, mintID(c.idGenerator))
+			defer stop()
 		}
+
+		info.attempt(len(data))
+		msg, err := nc.RequestMsgWithContext(invokerCtx, &nats.Msg{
+			Subject: subject,
+			Data:    data,
+			Header:  headers,
+		})
+		if err != nil {
+			return err
+		}
+		info.received(len(msg.Data))
+		info.responder(msg.Header)
+		body, err := readPayload("response", msg.Header.Get(ContentEncodingHeader), msg.Data, c.maxResponseSize)
 		if err != nil {
 			return err
 		}
 
-		// Store response headers in the pointer from context
+		// Store response headers where ResponseHeaders reads them
 		if msg.Header != nil && len(msg.Header) > 0 {
-			if headersPtr, ok := invokerCtx.Value(responseHeadersKey).(*nats.Header); ok && headersPtr != nil {
-				*headersPtr = msg.Header
-			}
-		} // Check if this is an error response from the service
-		if msg.Header.Get("Status") != "" {
-			code := msg.Header.Get("Status")
-			description := msg.Header.Get("Description")
-			return &OrderServiceError{
-				Code:    code,
-				Method:  method,
-				Message: description,
+			storeResponseHeaders(invokerCtx, msg.Header)
+		}
+
+		// Check if this is an error response from the service (NATS micro headers)
+		if msg.Header.Get("Nats-Service-Error-Code") != "" {
+			code := msg.Header.Get("Nats-Service-Error-Code")
+			description := msg.Header.Get("Nats-Service-Error")
+			var details []byte
+			if len(msg.Data) > 0 {
+				details = msg.Data
 			}
+			return newOrderServiceError(code, method, description, details)
 		}
 
-		// Unmarshal response
+		// Unmarshal response with the codec the service named, if any
 		typedReply, ok := reply.(*CreateOrderResponse)
 		if !ok {
 			return fmt.Errorf("invalid reply type")
 		}
-
-		if c.useJSON {
-			err = protojson.Unmarshal(msg.Data, typedReply)
+		replyJSON, err := payloadUsesJSON(msg.Header.Get(ContentTypeHeader), c.useJSON)
+		if err != nil {
+			return err
+		}
+		if replyJSON {
+			err = protojson.Unmarshal(body, typedReply)
 		} else {
-			err = proto.Unmarshal(msg.Data, typedReply)
+			err = proto.Unmarshal(body, typedReply)
 		}
 		return err
 	}
 
 	var resp CreateOrderResponse
 
-	// Execute through interceptor chain if configured
-	var err error
-	if c.interceptor != nil {
-		err = c.interceptor(ctx, method, req, &resp, invoker)
-	} else {
-		err = invoker(ctx, method, req, &resp)
+	// Execute through interceptor chain if configured, once per retry attempt
+	err := c.retry.do(ctx, func(ctx context.Context) error {
+		if c.interceptor != nil {
+			return c.interceptor(ctx, method, req, &resp, invoker)
+		}
+		return invoker(ctx, method, req, &resp)
+	})
+	sample.end(&resp, err)
+	if c.journal != nil {
+		c.journal.record("OrderService", method, c.subjectPrefix+".create_order", c.outgoingHeaders(parentCtx), req, c.useJSON, false, start, err)
 	}
-
 	if err != nil {
-		return nil, err
+		return nil, callTimeoutError(parentCtx, ctx, method, timeout, err)
 	}
 
 	return &resp, nil
 }
 
 // GetOrder sends a GetOrder request to the service via NATS.
-// Returns an error if the request fails or the service returns an error.
-func (c *OrderServiceNatsClient) GetOrder(ctx context.Context, req *GetOrderRequest) (*GetOrderResponse, error) {
+// Returns an error if the request fails or the service returns an error,
+// or a *TimeoutError if a WithCallTimeout/WithClientTimeout expires.
+func (c *OrderServiceNatsClient) GetOrder(ctx context.Context, req *GetOrderRequest, opts ...CallOption) (*GetOrderResponse, error) {
 	method := "GetOrder"
 
-	// Pointer to store response headers - stored in context so invoker can update it
-	responseHeadersPtr := &nats.Header{}
+	// Sample the call for WithClientSampling under a request ID the service sees too
+	var requestID string
+	if c.sampler.enabled() {
+		ctx, requestID = withRequestID(ctx, c.idGenerator)
+	}
+	sample := c.sampler.begin("OrderService", method, requestID, true, c.outgoingHeaders(ctx), req, false)
+
+	// Bound the call when the caller gave no deadline (or asked for a per-call timeout)
+	start := time.Now()
+	parentCtx := ctx
+	ctx, cancel, timeout := withCallTimeout(ctx, c.timeout, opts)
+	defer cancel()
+
+	// Record sizes, attempts and duration for CallInfoFromContext
+	ctx, info := startCallInfo(ctx, "OrderService", c.subjectPrefix+".get_order")
+	defer info.finish()
+	ctx = withIDGenerator(ctx, c.idGenerator) // For NewID in interceptors
 
-	// Add the response headers pointer to context so invoker can populate it
+	// Give the invoker somewhere in the context to store the response headers
 	// Interceptors can then read the headers from the same context
-	ctx = context.WithValue(ctx, responseHeadersKey, responseHeadersPtr)
+	ctx = WithResponseHeaders(ctx, nats.Header{})
 
 	// Define the invoker function that performs the actual NATS call
 	invoker := func(invokerCtx context.Context, method string, request, reply interface{}) error {
-		subject := c.subjectPrefix + ".get_order"
+		// WithTargetInstance sends the call to one instance's own subject
+		subject, subjectErr := targetSubject(c.subjectPrefix+".get_order", opts)
+		if subjectErr != nil {
+			return newOrderServiceError(OrderServiceErrCodeInvalidArgument, method, subjectErr.Error(), nil)
+		}
 
 		// Marshal request
 		typedReq, ok := request.(*GetOrderRequest)
@@ -943,7 +1526,7 @@ func (c *OrderServiceNatsClient) GetOrder(ctx context.Context, req *GetOrderRequ
 		var data []byte
 		var err error
 		if c.useJSON {
-			data, err = protojson.Marshal(typedReq)
+			data, err = marshalJSON(typedReq, false)
 		} else {
 			data, err = proto.Marshal(typedReq)
 		}
@@ -951,83 +1534,124 @@ func (c *OrderServiceNatsClient) GetOrder(ctx context.Context, req *GetOrderRequ
 			return err
 		}
 
-		// Extract outgoing headers from context and attach to NATS message
-		var msg *nats.Msg
-		if headers := OutgoingHeaders(invokerCtx); headers != nil {
-			msg, err = c.nc.RequestMsgWithContext(invokerCtx, &nats.Msg{
-				Subject: subject,
-				Data:    data,
-				Header:  headers,
-			})
-		} else {
-			msg, err = c.nc.RequestWithContext(invokerCtx, subject, data)
+		// Extract outgoing headers from context and attach them, naming the codec, to the NATS message
+		nc := c.conn()
+		headers := withContentType(c.outgoingHeaders(invokerCtx), c.useJSON)
+		setDeadlineHeaders(invokerCtx, headers) // For deadline-aware scheduling and handler contexts
+		if data, err = c.compress(data, headers); err != nil {
+			return err
+		}
+		headers.Set(acceptEncodingHeader, compressorNames()) // Services compress responses only for clients that decompress them
+		if c.cancelPropagation {
+			var stop func() bool
+			headers, stop = propagateCancel(invokerCtx, nc, headers, mintID(c.idGenerator))
+			defer stop()
 		}
+
+		info.attempt(len(data))
+		msg, err := nc.RequestMsgWithContext(invokerCtx, &nats.Msg{
+			Subject: subject,
+			Data:    data,
+			Header:  headers,
+		})
+		if err != nil {
+			return err
+		}
+		info.received(len(msg.Data))
+		info.responder(msg.Header)
+		body, err := readPayload("response", msg.Header.Get(ContentEncodingHeader), msg.Data, c.maxResponseSize)
 		if err != nil {
 			return err
 		}
 
-		// Store response headers in the pointer from context
+		// Store response headers where ResponseHeaders reads them
 		if msg.Header != nil && len(msg.Header) > 0 {
-			if headersPtr, ok := invokerCtx.Value(responseHeadersKey).(*nats.Header); ok && headersPtr != nil {
-				*headersPtr = msg.Header
-			}
-		} // Check if this is an error response from the service
-		if msg.Header.Get("Status") != "" {
-			code := msg.Header.Get("Status")
-			description := msg.Header.Get("Description")
-			return &OrderServiceError{
-				Code:    code,
-				Method:  method,
-				Message: description,
+			storeResponseHeaders(invokerCtx, msg.Header)
+		}
+
+		// Check if this is an error response from the service (NATS micro headers)
+		if msg.Header.Get("Nats-Service-Error-Code") != "" {
+			code := msg.Header.Get("Nats-Service-Error-Code")
+			description := msg.Header.Get("Nats-Service-Error")
+			var details []byte
+			if len(msg.Data) > 0 {
+				details = msg.Data
 			}
+			return newOrderServiceError(code, method, description, details)
 		}
 
-		// Unmarshal response
+		// Unmarshal response with the codec the service named, if any
 		typedReply, ok := reply.(*GetOrderResponse)
 		if !ok {
 			return fmt.Errorf("invalid reply type")
 		}
-
-		if c.useJSON {
-			err = protojson.Unmarshal(msg.Data, typedReply)
+		replyJSON, err := payloadUsesJSON(msg.Header.Get(ContentTypeHeader), c.useJSON)
+		if err != nil {
+			return err
+		}
+		if replyJSON {
+			err = protojson.Unmarshal(body, typedReply)
 		} else {
-			err = proto.Unmarshal(msg.Data, typedReply)
+			err = proto.Unmarshal(body, typedReply)
 		}
 		return err
 	}
 
 	var resp GetOrderResponse
 
-	// Execute through interceptor chain if configured
-	var err error
-	if c.interceptor != nil {
-		err = c.interceptor(ctx, method, req, &resp, invoker)
-	} else {
-		err = invoker(ctx, method, req, &resp)
+	// Execute through interceptor chain if configured, once per retry attempt
+	err := c.retry.do(ctx, func(ctx context.Context) error {
+		if c.interceptor != nil {
+			return c.interceptor(ctx, method, req, &resp, invoker)
+		}
+		return invoker(ctx, method, req, &resp)
+	})
+	sample.end(&resp, err)
+	if c.journal != nil {
+		c.journal.record("OrderService", method, c.subjectPrefix+".get_order", c.outgoingHeaders(parentCtx), req, c.useJSON, false, start, err)
 	}
-
 	if err != nil {
-		return nil, err
+		return nil, callTimeoutError(parentCtx, ctx, method, timeout, err)
 	}
 
 	return &resp, nil
 }
 
 // ListOrders sends a ListOrders request to the service via NATS.
-// Returns an error if the request fails or the service returns an error.
-func (c *OrderServiceNatsClient) ListOrders(ctx context.Context, req *ListOrdersRequest) (*ListOrdersResponse, error) {
+// Returns an error if the request fails or the service returns an error,
+// or a *TimeoutError if a WithCallTimeout/WithClientTimeout expires.
+func (c *OrderServiceNatsClient) ListOrders(ctx context.Context, req *ListOrdersRequest, opts ...CallOption) (*ListOrdersResponse, error) {
 	method := "ListOrders"
 
-	// Pointer to store response headers - stored in context so invoker can update it
-	responseHeadersPtr := &nats.Header{}
+	// Sample the call for WithClientSampling under a request ID the service sees too
+	var requestID string
+	if c.sampler.enabled() {
+		ctx, requestID = withRequestID(ctx, c.idGenerator)
+	}
+	sample := c.sampler.begin("OrderService", method, requestID, true, c.outgoingHeaders(ctx), req, false)
+
+	// Bound the call when the caller gave no deadline (or asked for a per-call timeout)
+	start := time.Now()
+	parentCtx := ctx
+	ctx, cancel, timeout := withCallTimeout(ctx, c.timeout, opts)
+	defer cancel()
 
-	// Add the response headers pointer to context so invoker can populate it
+	// Record sizes, attempts and duration for CallInfoFromContext
+	ctx, info := startCallInfo(ctx, "OrderService", c.subjectPrefix+".list_orders")
+	defer info.finish()
+	ctx = withIDGenerator(ctx, c.idGenerator) // For NewID in interceptors
+
+	// Give the invoker somewhere in the context to store the response headers
 	// Interceptors can then read the headers from the same context
-	ctx = context.WithValue(ctx, responseHeadersKey, responseHeadersPtr)
+	ctx = WithResponseHeaders(ctx, nats.Header{})
 
 	// Define the invoker function that performs the actual NATS call
 	invoker := func(invokerCtx context.Context, method string, request, reply interface{}) error {
-		subject := c.subjectPrefix + ".list_orders"
+		// WithTargetInstance sends the call to one instance's own subject
+		subject, subjectErr := targetSubject(c.subjectPrefix+".list_orders", opts)
+		if subjectErr != nil {
+			return newOrderServiceError(OrderServiceErrCodeInvalidArgument, method, subjectErr.Error(), nil)
+		}
 
 		// Marshal request
 		typedReq, ok := request.(*ListOrdersRequest)
@@ -1038,7 +1662,7 @@ func (c *OrderServiceNatsClient) ListOrders(ctx context.Context, req *ListOrders
 		var data []byte
 		var err error
 		if c.useJSON {
-			data, err = protojson.Marshal(typedReq)
+			data, err = marshalJSON(typedReq, false)
 		} else {
 			data, err = proto.Marshal(typedReq)
 		}
@@ -1046,83 +1670,124 @@ func (c *OrderServiceNatsClient) ListOrders(ctx context.Context, req *ListOrders
 			return err
 		}
 
-		// Extract outgoing headers from context and attach to NATS message
-		var msg *nats.Msg
-		if headers := OutgoingHeaders(invokerCtx); headers != nil {
-			msg, err = c.nc.RequestMsgWithContext(invokerCtx, &nats.Msg{
-				Subject: subject,
-				Data:    data,
-				Header:  headers,
-			})
-		} else {
-			msg, err = c.nc.RequestWithContext(invokerCtx, subject, data)
+		// Extract outgoing headers from context and attach them, naming the codec, to the NATS message
+		nc := c.conn()
+		headers := withContentType(c.outgoingHeaders(invokerCtx), c.useJSON)
+		setDeadlineHeaders(invokerCtx, headers) // For deadline-aware scheduling and handler contexts
+		if data, err = c.compress(data, headers); err != nil {
+			return err
+		}
+		headers.Set(acceptEncodingHeader, compressorNames()) // Services compress responses only for clients that decompress them
+		if c.cancelPropagation {
+			var stop func() bool
+			headers, stop = propagateCancel(invokerCtx, nc, headers, mintID(c.idGenerator))
+			defer stop()
+		}
+
+		info.attempt(len(data))
+		msg, err := nc.RequestMsgWithContext(invokerCtx, &nats.Msg{
+			Subject: subject,
+			Data:    data,
+			Header:  headers,
+		})
+		if err != nil {
+			return err
 		}
+		info.received(len(msg.Data))
+		info.responder(msg.Header)
+		body, err := readPayload("response", msg.Header.Get(ContentEncodingHeader), msg.Data, c.maxResponseSize)
 		if err != nil {
 			return err
 		}
 
-		// Store response headers in the pointer from context
+		// Store response headers where ResponseHeaders reads them
 		if msg.Header != nil && len(msg.Header) > 0 {
-			if headersPtr, ok := invokerCtx.Value(responseHeadersKey).(*nats.Header); ok && headersPtr != nil {
-				*headersPtr = msg.Header
-			}
-		} // Check if this is an error response from the service
-		if msg.Header.Get("Status") != "" {
-			code := msg.Header.Get("Status")
-			description := msg.Header.Get("Description")
-			return &OrderServiceError{
-				Code:    code,
-				Method:  method,
-				Message: description,
+			storeResponseHeaders(invokerCtx, msg.Header)
+		}
+
+		// Check if this is an error response from the service (NATS micro headers)
+		if msg.Header.Get("Nats-Service-Error-Code") != "" {
+			code := msg.Header.Get("Nats-Service-Error-Code")
+			description := msg.Header.Get("Nats-Service-Error")
+			var details []byte
+			if len(msg.Data) > 0 {
+				details = msg.Data
 			}
+			return newOrderServiceError(code, method, description, details)
 		}
 
-		// Unmarshal response
+		// Unmarshal response with the codec the service named, if any
 		typedReply, ok := reply.(*ListOrdersResponse)
 		if !ok {
 			return fmt.Errorf("invalid reply type")
 		}
-
-		if c.useJSON {
-			err = protojson.Unmarshal(msg.Data, typedReply)
+		replyJSON, err := payloadUsesJSON(msg.Header.Get(ContentTypeHeader), c.useJSON)
+		if err != nil {
+			return err
+		}
+		if replyJSON {
+			err = protojson.Unmarshal(body, typedReply)
 		} else {
-			err = proto.Unmarshal(msg.Data, typedReply)
+			err = proto.Unmarshal(body, typedReply)
 		}
 		return err
 	}
 
 	var resp ListOrdersResponse
 
-	// Execute through interceptor chain if configured
-	var err error
-	if c.interceptor != nil {
-		err = c.interceptor(ctx, method, req, &resp, invoker)
-	} else {
-		err = invoker(ctx, method, req, &resp)
+	// Execute through interceptor chain if configured, once per retry attempt
+	err := c.retry.do(ctx, func(ctx context.Context) error {
+		if c.interceptor != nil {
+			return c.interceptor(ctx, method, req, &resp, invoker)
+		}
+		return invoker(ctx, method, req, &resp)
+	})
+	sample.end(&resp, err)
+	if c.journal != nil {
+		c.journal.record("OrderService", method, c.subjectPrefix+".list_orders", c.outgoingHeaders(parentCtx), req, c.useJSON, false, start, err)
 	}
-
 	if err != nil {
-		return nil, err
+		return nil, callTimeoutError(parentCtx, ctx, method, timeout, err)
 	}
 
 	return &resp, nil
 }
 
 // UpdateOrderStatus sends a UpdateOrderStatus request to the service via NATS.
-// Returns an error if the request fails or the service returns an error.
-func (c *OrderServiceNatsClient) UpdateOrderStatus(ctx context.Context, req *UpdateOrderStatusRequest) (*UpdateOrderStatusResponse, error) {
+// Returns an error if the request fails or the service returns an error,
+// or a *TimeoutError if a WithCallTimeout/WithClientTimeout expires.
+func (c *OrderServiceNatsClient) UpdateOrderStatus(ctx context.Context, req *UpdateOrderStatusRequest, opts ...CallOption) (*UpdateOrderStatusResponse, error) {
 	method := "UpdateOrderStatus"
 
-	// Pointer to store response headers - stored in context so invoker can update it
-	responseHeadersPtr := &nats.Header{}
+	// Sample the call for WithClientSampling under a request ID the service sees too
+	var requestID string
+	if c.sampler.enabled() {
+		ctx, requestID = withRequestID(ctx, c.idGenerator)
+	}
+	sample := c.sampler.begin("OrderService", method, requestID, true, c.outgoingHeaders(ctx), req, false)
+
+	// Bound the call when the caller gave no deadline (or asked for a per-call timeout)
+	start := time.Now()
+	parentCtx := ctx
+	ctx, cancel, timeout := withCallTimeout(ctx, c.timeout, opts)
+	defer cancel()
 
-	// Add the response headers pointer to context so invoker can populate it
+	// Record sizes, attempts and duration for CallInfoFromContext
+	ctx, info := startCallInfo(ctx, "OrderService", c.subjectPrefix+".update_order_status")
+	defer info.finish()
+	ctx = withIDGenerator(ctx, c.idGenerator) // For NewID in interceptors
+
+	// Give the invoker somewhere in the context to store the response headers
 	// Interceptors can then read the headers from the same context
-	ctx = context.WithValue(ctx, responseHeadersKey, responseHeadersPtr)
+	ctx = WithResponseHeaders(ctx, nats.Header{})
 
 	// Define the invoker function that performs the actual NATS call
 	invoker := func(invokerCtx context.Context, method string, request, reply interface{}) error {
-		subject := c.subjectPrefix + ".update_order_status"
+		// WithTargetInstance sends the call to one instance's own subject
+		subject, subjectErr := targetSubject(c.subjectPrefix+".update_order_status", opts)
+		if subjectErr != nil {
+			return newOrderServiceError(OrderServiceErrCodeInvalidArgument, method, subjectErr.Error(), nil)
+		}
 
 		// Marshal request
 		typedReq, ok := request.(*UpdateOrderStatusRequest)
@@ -1133,7 +1798,7 @@ func (c *OrderServiceNatsClient) UpdateOrderStatus(ctx context.Context, req *Upd
 		var data []byte
 		var err error
 		if c.useJSON {
-			data, err = protojson.Marshal(typedReq)
+			data, err = marshalJSON(typedReq, false)
 		} else {
 			data, err = proto.Marshal(typedReq)
 		}
@@ -1141,68 +1806,115 @@ func (c *OrderServiceNatsClient) UpdateOrderStatus(ctx context.Context, req *Upd
 			return err
 		}
 
-		// Extract outgoing headers from context and attach to NATS message
-		var msg *nats.Msg
-		if headers := OutgoingHeaders(invokerCtx); headers != nil {
-			msg, err = c.nc.RequestMsgWithContext(invokerCtx, &nats.Msg{
-				Subject: subject,
-				Data:    data,
-				Header:  headers,
-			})
-		} else {
-			msg, err = c.nc.RequestWithContext(invokerCtx, subject, data)
+		// Extract outgoing headers from context and attach them, naming the codec, to the NATS message
+		nc := c.conn()
+		headers := withContentType(c.outgoingHeaders(invokerCtx), c.useJSON)
+		setDeadlineHeaders(invokerCtx, headers) // For deadline-aware scheduling and handler contexts
+		if data, err = c.compress(data, headers); err != nil {
+			return err
+		}
+		headers.Set(acceptEncodingHeader, compressorNames()) // Services compress responses only for clients that decompress them
+		if c.cancelPropagation {
+			var stop func() bool
+			headers, stop = propagateCancel(invokerCtx, nc, headers, mintID(c.idGenerator))
+			defer stop()
+		}
+
+		info.attempt(len(data))
+		msg, err := nc.RequestMsgWithContext(invokerCtx, &nats.Msg{
+			Subject: subject,
+			Data:    data,
+			Header:  headers,
+		})
+		if err != nil {
+			return err
 		}
+		info.received(len(msg.Data))
+		info.responder(msg.Header)
+		body, err := readPayload("response", msg.Header.Get(ContentEncodingHeader), msg.Data, c.maxResponseSize)
 		if err != nil {
 			return err
 		}
 
-		// Store response headers in the pointer from context
+		// Store response headers where ResponseHeaders reads them
 		if msg.Header != nil && len(msg.Header) > 0 {
-			if headersPtr, ok := invokerCtx.Value(responseHeadersKey).(*nats.Header); ok && headersPtr != nil {
-				*headersPtr = msg.Header
-			}
-		} // Check if this is an error response from the service
-		if msg.Header.Get("Status") != "" {
-			code := msg.Header.Get("Status")
-			description := msg.Header.Get("Description")
-			return &OrderServiceError{
-				Code:    code,
-				Method:  method,
-				Message: description,
+			storeResponseHeaders(invokerCtx, msg.Header)
+		}
+
+		// Check if this is an error response from the service (NATS micro headers)
+		if msg.Header.Get("Nats-Service-Error-Code") != "" {
+			code := msg.Header.Get("Nats-Service-Error-Code")
+			description := msg.Header.Get("Nats-Service-Error")
+			var details []byte
+			if len(msg.Data) > 0 {
+				details = msg.Data
 			}
+			return newOrderServiceError(code, method, description, details)
 		}
 
-		// Unmarshal response
+		// Unmarshal response with the codec the service named, if any
 		typedReply, ok := reply.(*UpdateOrderStatusResponse)
 		if !ok {
 			return fmt.Errorf("invalid reply type")
 		}
-
-		if c.useJSON {
-			err = protojson.Unmarshal(msg.Data, typedReply)
+		replyJSON, err := payloadUsesJSON(msg.Header.Get(ContentTypeHeader), c.useJSON)
+		if err != nil {
+			return err
+		}
+		if replyJSON {
+			err = protojson.Unmarshal(body, typedReply)
 		} else {
-			err = proto.Unmarshal(msg.Data, typedReply)
+			err = proto.Unmarshal(body, typedReply)
 		}
 		return err
 	}
 
 	var resp UpdateOrderStatusResponse
 
-	// Execute through interceptor chain if configured
-	var err error
-	if c.interceptor != nil {
-		err = c.interceptor(ctx, method, req, &resp, invoker)
-	} else {
-		err = invoker(ctx, method, req, &resp)
+	// Execute through interceptor chain if configured, once per retry attempt
+	err := c.retry.do(ctx, func(ctx context.Context) error {
+		if c.interceptor != nil {
+			return c.interceptor(ctx, method, req, &resp, invoker)
+		}
+		return invoker(ctx, method, req, &resp)
+	})
+	sample.end(&resp, err)
+	if c.journal != nil {
+		c.journal.record("OrderService", method, c.subjectPrefix+".update_order_status", c.outgoingHeaders(parentCtx), req, c.useJSON, false, start, err)
 	}
-
 	if err != nil {
-		return nil, err
+		return nil, callTimeoutError(parentCtx, ctx, method, timeout, err)
 	}
 
 	return &resp, nil
 }
 
+// Health calls the service's health endpoint. A service that reports HealthNotServing
+// returns a HealthResponse, not an error.
+func (c *OrderServiceNatsClient) Health(ctx context.Context) (*HealthResponse, error) {
+	parentCtx := ctx
+	ctx, cancel, timeout := withCallTimeout(ctx, c.timeout, nil)
+	defer cancel()
+	resp, err := requestHealth(ctx, c.conn(), healthSubject(c.subjectPrefix, "order_service"))
+	if err != nil {
+		return nil, callTimeoutError(parentCtx, ctx, "Health", timeout, err)
+	}
+	return resp, nil
+}
+
+// Schema fetches the service's $schema document; see FetchServiceSchema
+func (c *OrderServiceNatsClient) Schema(ctx context.Context) (*ServiceSchemaDocument, error) {
+	ctx, cancel, _ := withCallTimeout(ctx, c.timeout, nil)
+	defer cancel()
+	return FetchServiceSchema(ctx, c.conn(), schemaSubject(c.subjectPrefix, "order_service"))
+}
+
+// Close stops the client's connection monitor. The NATS connection stays open,
+// and the client can still be used.
+func (c *OrderServiceNatsClient) Close() {
+	c.monitor.close()
+}
+
 // Endpoints returns information about all service endpoints this client can call.
 // This is useful for debugging, monitoring, and introspection.
 func (c *OrderServiceNatsClient) Endpoints() []OrderServiceEndpointInfo {
@@ -1214,11 +1926,39 @@ func (c *OrderServiceNatsClient) Endpoints() []OrderServiceEndpointInfo {
 	}
 }
 
+// OrderServiceSelfTest checks that the NATS connection works and that a running
+// order_service instance has registered every endpoint the client calls. Client
+// options such as WithNatsClientSubjectPrefix select the expected subjects.
+// Exit non-zero when the report is not OK to use it as a liveness or deployment hook.
+func OrderServiceSelfTest(ctx context.Context, nc *nats.Conn, opts ...NatsClientOption) *SelfTestReport {
+	c := NewOrderServiceNatsClient(nc, opts...)
+	var endpoints []SelfTestEndpoint
+	for _, ep := range c.Endpoints() {
+		endpoints = append(endpoints, SelfTestEndpoint{Name: ep.Name, Subject: ep.Subject})
+	}
+	return runSelfTest(ctx, nc, "order_service", endpoints)
+}
+
 // OrderTrackingServiceError represents a structured error from OrderTrackingService
 type OrderTrackingServiceError struct {
 	Code    string // Error code (e.g., "INVALID_ARGUMENT", "NOT_FOUND", "INTERNAL")
 	Message string // Human-readable error message
 	Method  string // The method that failed
+	Details []byte // Optional error data sent with the error
+
+	status *Status // Returned by Unwrap (nil = built on each call)
+}
+
+// newOrderTrackingServiceError creates an error that keeps its *Status, so Unwrap
+// returns the same value every time
+func newOrderTrackingServiceError(code, method, message string, details []byte) *OrderTrackingServiceError {
+	return &OrderTrackingServiceError{
+		Code:    code,
+		Method:  method,
+		Message: message,
+		Details: details,
+		status:  &Status{Code: ParseCode(code), Message: message, Details: details},
+	}
 }
 
 func (e *OrderTrackingServiceError) Error() string {
@@ -1237,7 +1977,17 @@ func (e *OrderTrackingServiceError) NatsErrorMessage() string {
 
 // NatsErrorData returns optional error data (nil for basic errors)
 func (e *OrderTrackingServiceError) NatsErrorData() []byte {
-	return nil
+	return e.Details
+}
+
+// Unwrap exposes the error as a *Status, so errors.As(err, &st) works on
+// client errors. Custom error codes map to CodeUnknown. Errors built as struct
+// literals get a new Status on each call.
+func (e *OrderTrackingServiceError) Unwrap() error {
+	if e.status != nil {
+		return e.status
+	}
+	return &Status{Code: ParseCode(e.Code), Message: e.Message, Details: e.Details}
 }
 
 // Service-specific error code constants (use shared constants from service_shared_nats.pb.go)
@@ -1304,37 +2054,37 @@ func GetOrderTrackingServiceErrorCode(err error) string {
 
 // NewOrderTrackingServiceInvalidArgumentError creates a new invalid argument error
 func NewOrderTrackingServiceInvalidArgumentError(method, message string) error {
-	return &OrderTrackingServiceError{Code: OrderTrackingServiceErrCodeInvalidArgument, Method: method, Message: message}
+	return newOrderTrackingServiceError(OrderTrackingServiceErrCodeInvalidArgument, method, message, nil)
 }
 
 // NewOrderTrackingServiceNotFoundError creates a new not found error
 func NewOrderTrackingServiceNotFoundError(method, message string) error {
-	return &OrderTrackingServiceError{Code: OrderTrackingServiceErrCodeNotFound, Method: method, Message: message}
+	return newOrderTrackingServiceError(OrderTrackingServiceErrCodeNotFound, method, message, nil)
 }
 
 // NewOrderTrackingServiceAlreadyExistsError creates a new already exists error
 func NewOrderTrackingServiceAlreadyExistsError(method, message string) error {
-	return &OrderTrackingServiceError{Code: OrderTrackingServiceErrCodeAlreadyExists, Method: method, Message: message}
+	return newOrderTrackingServiceError(OrderTrackingServiceErrCodeAlreadyExists, method, message, nil)
 }
 
 // NewOrderTrackingServicePermissionDeniedError creates a new permission denied error
 func NewOrderTrackingServicePermissionDeniedError(method, message string) error {
-	return &OrderTrackingServiceError{Code: OrderTrackingServiceErrCodePermissionDenied, Method: method, Message: message}
+	return newOrderTrackingServiceError(OrderTrackingServiceErrCodePermissionDenied, method, message, nil)
 }
 
 // NewOrderTrackingServiceUnauthenticatedError creates a new unauthenticated error
 func NewOrderTrackingServiceUnauthenticatedError(method, message string) error {
-	return &OrderTrackingServiceError{Code: OrderTrackingServiceErrCodeUnauthenticated, Method: method, Message: message}
+	return newOrderTrackingServiceError(OrderTrackingServiceErrCodeUnauthenticated, method, message, nil)
 }
 
 // NewOrderTrackingServiceInternalError creates a new internal error
 func NewOrderTrackingServiceInternalError(method, message string) error {
-	return &OrderTrackingServiceError{Code: OrderTrackingServiceErrCodeInternal, Method: method, Message: message}
+	return newOrderTrackingServiceError(OrderTrackingServiceErrCodeInternal, method, message, nil)
 }
 
 // NewOrderTrackingServiceUnavailableError creates a new unavailable error
 func NewOrderTrackingServiceUnavailableError(method, message string) error {
-	return &OrderTrackingServiceError{Code: OrderTrackingServiceErrCodeUnavailable, Method: method, Message: message}
+	return newOrderTrackingServiceError(OrderTrackingServiceErrCodeUnavailable, method, message, nil)
 }
 
 // OrderTrackingServiceNats is the NATS service interface for OrderTrackingService
@@ -1345,8 +2095,10 @@ type OrderTrackingServiceNats interface {
 
 // OrderTrackingServiceEndpointInfo describes a service endpoint
 type OrderTrackingServiceEndpointInfo struct {
-	Name    string `json:"name"`    // Method name (e.g., "CreateProduct")
-	Subject string `json:"subject"` // NATS subject (e.g., "api.v1.create_product")
+	Name           string `json:"name"`                       // Method name (e.g., "CreateProduct")
+	Subject        string `json:"subject"`                    // NATS subject (e.g., "api.v1.create_product")
+	QueueGroup     string `json:"queue_group,omitempty"`      // Queue group the endpoint joined (server only)
+	MaxRequestSize int    `json:"max_request_size,omitempty"` // Request payload limit in bytes (server only, 0 = unlimited)
 }
 
 // OrderTrackingServiceService is the interface for the registered NATS micro service
@@ -1354,57 +2106,225 @@ type OrderTrackingServiceEndpointInfo struct {
 type OrderTrackingServiceService interface {
 	micro.Service
 	Endpoints() []OrderTrackingServiceEndpointInfo
+	// Drain stops accepting requests, waits until in-flight handlers finish or
+	// ctx ends, and stops the service
+	Drain(ctx context.Context) error
+	// InterceptorChain names the interceptors a method's requests pass through,
+	// outermost first
+	InterceptorChain(method string) []string
+	// Reconfigure changes the settings that can change while the service runs,
+	// currently WithSampling and WithSamplingSalt
+	Reconfigure(opts ...RegisterOption) error
 }
 
 // orderTrackingServiceService is the concrete implementation of OrderTrackingServiceService
 type orderTrackingServiceService struct {
 	micro.Service
-	subjectPrefix string
+	subjectPrefix  string
+	queueGroup     string
+	maxRequestSize int
+	inflight       *inflightTracker
+	interceptors   []string // Names of the service-wide interceptors, outermost first
+	sampler        *sampler // Shared with the handlers, for Reconfigure
 }
 
 // Endpoints returns information about all service endpoints
 // This is useful for debugging, monitoring, and service discovery
 func (s *orderTrackingServiceService) Endpoints() []OrderTrackingServiceEndpointInfo {
 	return []OrderTrackingServiceEndpointInfo{
-		{Name: "TrackOrder", Subject: s.subjectPrefix + ".track_order"},
-		{Name: "UpdateTracking", Subject: s.subjectPrefix + ".update_tracking"},
+		{Name: "TrackOrder", Subject: s.subjectPrefix + ".track_order", QueueGroup: s.queueGroup, MaxRequestSize: s.maxRequestSize},
+		{Name: "UpdateTracking", Subject: s.subjectPrefix + ".update_tracking", QueueGroup: s.queueGroup, MaxRequestSize: s.maxRequestSize},
+	}
+}
+
+// init lists the methods of OrderTrackingService in MethodDescriptors
+func init() {
+	MethodDescriptors["order.v1.OrderTrackingService.TrackOrder"] = MethodDescriptor{
+		Service: "OrderTrackingService",
+		Method:  "TrackOrder",
+		Subject: "api.v1.track_order",
+	}
+	MethodDescriptors["order.v1.OrderTrackingService.UpdateTracking"] = MethodDescriptor{
+		Service: "OrderTrackingService",
+		Method:  "UpdateTracking",
+		Subject: "api.v1.update_tracking",
+	}
+}
+
+// OrderTrackingServiceSubjects maps each method of OrderTrackingService to its subject under
+// the default prefix. Clients and services of every language are generated from
+// the same table.
+var OrderTrackingServiceSubjects = map[string]string{
+	"TrackOrder":     "api.v1.track_order",
+	"UpdateTracking": "api.v1.update_tracking",
+}
+
+// Drain unsubscribes every endpoint, so new requests get no responders, then waits
+// for in-flight handlers, and requests already delivered to the endpoints, to
+// finish before returning. Server and bidi streams are
+// sent a GOAWAY, which clients see as an *ErrServerDraining, and streaming handlers
+// have their context canceled right away, or after WithStreamDrainGrace, so
+// long-running streams can end cleanly. If ctx ends first, the remaining handlers'
+// contexts are canceled and ctx.Err() is returned. The service is stopped either way.
+// Drain fails if nats.go's micro left an endpoint subscribed; see stopEndpoints.
+func (s *orderTrackingServiceService) Drain(ctx context.Context) error {
+	return s.inflight.drain(ctx, func() error { return stopEndpoints(s.Service) })
+}
+
+// InterceptorChain returns the names of the interceptors that requests to
+// method, a Go method name, pass through, outermost first: the service's
+// interceptors in the order they were added, then the method's
+// (natsmicro.endpoint).middlewares. Interceptors are named with Named, or else
+// by their function name.
+func (s *orderTrackingServiceService) InterceptorChain(method string) []string {
+	chain := append([]string(nil), s.interceptors...)
+	return chain
+}
+
+// Reconfigure replaces the service's WithSampling and WithSamplingSalt settings
+// with those in opts, so sampling can be turned up, down or off without
+// re-registering; leaving WithSampling out stops sampling. Any other option is
+// rejected, as it only takes effect at registration.
+func (s *orderTrackingServiceService) Reconfigure(opts ...RegisterOption) error {
+	cfg := &registerConfig{}
+	for _, opt := range opts {
+		opt(cfg)
 	}
+	return reconfigureSampling(s.sampler, cfg)
 }
 
+// OrderTrackingServiceSchemaHash identifies the schema OrderTrackingService was generated from.
+// Services advertise it as schema_hash INFO metadata; see SchemaHash.
+const OrderTrackingServiceSchemaHash = "sha256:8fb230c8dd9c3b65056086346e0d89b71d660414dfb1dab57ccc24a60cadeafc"
+
+// orderTrackingServiceSchema is the FileDescriptorSet OrderTrackingService serves from its
+// $reflect endpoint. The schema endpoint metadata is read from it too, so the two
+// always agree.
+const orderTrackingServiceSchema = "\n\xbc\x18\n\x16order/v1/service.proto\x12\border.v1\x1a\x1bcommon/types/v1/money.proto\x1a common/location/v1/address.proto\x1a\x1ccommon/types/v1/status.proto\x1a!common/metadata/v1/metadata.proto\x1a\x17natsmicro/options.proto\x1a\x1cgoogle/api/annotations.proto\"\x83\x01\n\rTrackingEvent\x12\x1a\n\blocation\x18\x01 \x01(\tR\blocation\x12\x16\n\x06status\x18\x02 \x01(\tR\x06status\x12\x1c\n\ttimestamp\x18\x03 \x01(\tR\ttimestamp\x12 \n\vdescription\x18\x04 \x01(\tR\vdescription\".\n\x11TrackOrderRequest\x12\x19\n\border_id\x18\x01 \x01(\tR\aorderId\"\x89\x01\n\x12TrackOrderResponse\x12\x19\n\border_id\x18\x01 \x01(\tR\aorderId\x12'\n\x0ftracking_number\x18\x02 \x01(\tR\x0etrackingNumber\x12/\n\x06events\x18\x03 \x03(\v2\x17.order.v1.TrackingEventR\x06events\"a\n\x15UpdateTrackingRequest\x12\x19\n\border_id\x18\x01 \x01(\tR\aorderId\x12-\n\x05event\x18\x02 \x01(\v2\x17.order.v1.TrackingEventR\x05event\"\\\n\x16UpdateTrackingResponse\x12\x19\n\border_id\x18\x01 \x01(\tR\aorderId\x12'\n\x0ftracking_number\x18\x02 \x01(\tR\x0etrackingNumber\"\xd9\x01\n\tOrderItem\x12\x1d\n\nproduct_id\x18\x01 \x01(\tR\tproductId\x12!\n\fproduct_name\x18\x02 \x01(\tR\vproductName\x12\x1a\n\bquantity\x18\x03 \x01(\x05R\bquantity\x125\n\nunit_price\x18\x04 \x01(\v2\x16.common.types.v1.MoneyR\tunitPrice\x127\n\vtotal_price\x18\x05 \x01(\v2\x16.common.types.v1.MoneyR\ntotalPrice\"\xc7\x03\n\x05Order\x12\x0e\n\x02id\x18\x01 \x01(\tR\x02id\x12\x1f\n\vcustomer_id\x18\x02 \x01(\tR\ncustomerId\x12#\n\rcustomer_name\x18\x03 \x01(\tR\fcustomerName\x12)\n\x05items\x18\x04 \x03(\v2\x13.order.v1.OrderItemR\x05items\x122\n\bsubtotal\x18\x05 \x01(\v2\x16.common.types.v1.MoneyR\bsubtotal\x12(\n\x03tax\x18\x06 \x01(\v2\x16.common.types.v1.MoneyR\x03tax\x12,\n\x05total\x18\a \x01(\v2\x16.common.types.v1.MoneyR\x05total\x12F\n\x10shipping_address\x18\b \x01(\v2\x1b.common.location.v1.AddressR\x0fshippingAddress\x12/\n\x06status\x18\t \x01(\x0e2\x17.common.types.v1.StatusR\x06status\x128\n\bmetadata\x18\n \x01(\v2\x1c.common.metadata.v1.MetadataR\bmetadata\"\xcd\x01\n\x12CreateOrderRequest\x12\x1f\n\vcustomer_id\x18\x01 \x01(\tR\ncustomerId\x12#\n\rcustomer_name\x18\x02 \x01(\tR\fcustomerName\x12)\n\x05items\x18\x03 \x03(\v2\x13.order.v1.OrderItemR\x05items\x12F\n\x10shipping_address\x18\x04 \x01(\v2\x1b.common.location.v1.AddressR\x0fshippingAddress\"<\n\x13CreateOrderResponse\x12%\n\x05order\x18\x01 \x01(\v2\x0f.order.v1.OrderR\x05order\"!\n\x0fGetOrderRequest\x12\x0e\n\x02id\x18\x01 \x01(\tR\x02id\"9\n\x10GetOrderResponse\x12%\n\x05order\x18\x01 \x01(\v2\x0f.order.v1.OrderR\x05order\"\xae\x01\n\x11ListOrdersRequest\x12\x1f\n\vcustomer_id\x18\x01 \x01(\tR\ncustomerId\x12\x1b\n\tpage_size\x18\x02 \x01(\x05R\bpageSize\x12\x1d\n\npage_token\x18\x03 \x01(\tR\tpageToken\x12<\n\rstatus_filter\x18\x04 \x01(\x0e2\x17.common.types.v1.StatusR\fstatusFilter\"\x86\x01\n\x12ListOrdersResponse\x12'\n\x06orders\x18\x01 \x03(\v2\x0f.order.v1.OrderR\x06orders\x12&\n\x0fnext_page_token\x18\x02 \x01(\tR\rnextPageToken\x12\x1f\n\vtotal_count\x18\x03 \x01(\x05R\ntotalCount\"s\n\x18UpdateOrderStatusRequest\x12\x0e\n\x02id\x18\x01 \x01(\tR\x02id\x12/\n\x06status\x18\x02 \x01(\x0e2\x17.common.types.v1.StatusR\x06status\x12\x16\n\x06reason\x18\x03 \x01(\tR\x06reason\"B\n\x19UpdateOrderStatusResponse\x12%\n\x05order\x18\x01 \x01(\v2\x0f.order.v1.OrderR\x05order2\xec\x03\n\fOrderService\x12a\n\vCreateOrder\x12\x1c.order.v1.CreateOrderRequest\x1a\x1d.order.v1.CreateOrderResponse\"\x15\x82\xd3\xe4\x93\x02\x0f:\x01*\"\n/v1/orders\x12Z\n\bGetOrder\x12\x19.order.v1.GetOrderRequest\x1a\x1a.order.v1.GetOrderResponse\"\x17\x82\xd3\xe4\x93\x02\x11\x12\x0f/v1/orders/{id}\x12[\n\nListOrders\x12\x1b.order.v1.ListOrdersRequest\x1a\x1c.order.v1.ListOrdersResponse\"\x12\x82\xd3\xe4\x93\x02\f\x12\n/v1/orders\x12\x7f\n\x11UpdateOrderStatus\x12\".order.v1.UpdateOrderStatusRequest\x1a#.order.v1.UpdateOrderStatusResponse\"!\x82\xd3\xe4\x93\x02\x1b:\x01*2\x16/v1/orders/{id}/status\x1a?\x8a\xb5\x18;\n\x06api.v1\x12\rorder_service\x1a\x051.0.0\"\x1bOrder management service v12\xc9\x02\n\x14OrderTrackingService\x12l\n\nTrackOrder\x12\x1b.order.v1.TrackOrderRequest\x1a\x1c.order.v1.TrackOrderResponse\"#\x82\xd3\xe4\x93\x02\x1d\x12\x1b/v1/orders/{order_id}/track\x12~\n\x0eUpdateTracking\x12\x1f.order.v1.UpdateTrackingRequest\x1a .order.v1.UpdateTrackingResponse\")\x82\xd3\xe4\x93\x02#:\x01*\"\x1e/v1/orders/{order_id}/tracking\x1aC\x8a\xb5\x18?\n\x06api.v1\x12\x16order_tracking_service\x1a\x051.0.0\"\x16Order tracking serviceB\x16Z\x14example/gen/order/v1b\x06proto3"
+
+// orderTrackingServiceSchemaDocument is the JSON OrderTrackingService serves from its
+// $schema endpoint, before registration fills in its name, version and subjects
+const orderTrackingServiceSchemaDocument = "{\"service\":\"order.v1.OrderTrackingService\",\"schema_hash\":\"sha256:8fb230c8dd9c3b65056086346e0d89b71d660414dfb1dab57ccc24a60cadeafc\",\"methods\":[{\"name\":\"TrackOrder\",\"endpoint\":\"track_order\",\"streaming\":\"unary\",\"request_type\":\"order.v1.TrackOrderRequest\",\"response_type\":\"order.v1.TrackOrderResponse\"},{\"name\":\"UpdateTracking\",\"endpoint\":\"update_tracking\",\"streaming\":\"unary\",\"request_type\":\"order.v1.UpdateTrackingRequest\",\"response_type\":\"order.v1.UpdateTrackingResponse\"}],\"descriptor\":\"H4sIAAAAAAAC/6xXz28bxRfX2rFrPydxM22dzdr5xtnoS9MKvI0RAkqlikYURYJSueVSkKyJdzCren90Z2wlRQUJJCR65f/g1ht3xIETJ+4c+SPQvJnZdRzHaSRu3vf5vF+feTN5gV9taMSpz1Jvsudxlk6CAeskaSxiUkF7Z7LnNAdxGMaRJ44TxiUxjCN2rGhOW4OjeEBFEEcSp76fMs41ozXrzgUVY4NuazRkgvpUUIyvf2vKekQFD4NBGntxInNkkYdxPBwxjyaBR6MoFnQKdX+wYOVxSgdPg2j40YRFgjhQMWXaVtvarfayb9KAsqrLLiCiv0gLqiIIGRc0TOwiQrmBtKHmMz5IA6zLXkJ82uR2YA2L+Eyq2WPPxowLsgFK3X7g60Iu4feB7/5kAZl24EkccbbAg1yHutBt9qNxeMhS3cKqMT9AK/GgzKQO3C62i7u17nrHHHHnhE49TXMpXPs88algBj6/fvIWlNAba1iQQ7HcL6Exm+K/69j9y4Iq6nggWEg2AZI09scDkcesasuBT7Zh2cARDZkOWdO2BzRkcoCejWkkAnGMs1DqZd/kHYBxFIh+kgYDhpNQ6zY6aro7OPtShE/l1elVJfOhJJJ3oSZiQUfar7TQD5CKju5vRShhb2QVClk/hcAnW1AbjLmIQyWe6gOM6cAnO7CSEbBVNdfLxoi93oBSIFjI7SUcliv5QWaK9hSDdKHCx4dY2zkNZDyyC0VBj+zyQrqkkDehpEJfWshVJHIfLvOvgySRs6HfIbuCjk3jaO689P1QUXp146QN8rLo96DatnZXu+un0j5COHso3oOKebdswIQt42LsWKz+3cvY7h8WkP2UUcFOPBIzx2idf4yFRcdYPPcY50m3dHHp3Dtw5UQ7+kL/H0qYGzupdeszlfQU6m5D/WMmTigxM+Du+3A5p1ws+i8WrH0ScOXMX1vqJlQTOmR9HjxXMpd6FWl4FDxn+K5IUMRPWWT+RkjLY2kgd2BFjUj/q2AkWGovLR6oZcW+j2T3RwvIdMG63etQxoa4bbWL8/rVMHkD6hE7Ev2pCtWYrEjzw6zKLfMODeJxJPTrpt6bfWlxOdjqpcb4utb5BzR1eQqvd3kaUE4Z5bGRT3+592BjTtILHXn3nyIsK3e13xAKtan5JK3c7/QtdDbPQFUN7rXv//z750L9tnXTBbm6aNWfQMVMKNnII8wMtuPMg3TkdYy8Rup5WO+bwH9BvgDIB4I08xCn5tppzQd1BoIZlsl04d/B2inBiZuHOWsEnJ2FHJ1xGzM2b1s3u42ZtvRW6Nx9+cr+AMo0CTqTPbKiVgC9mTqlvc6tzi23ibHbIY3okIUsEm1NaE/2ur8X4CriZqMwxz4CyBerad1O7WdOaz6ou9jBLjZJc7oFs6u88HAHId/C6snFhmzNKjSzVTntswk68w3MvCOn7X8LkgfR0Nl/+cq+m+molvy+QWcFbShBDWzkvNd4cpUd0TAZMW/IIs/8p3BYxg377X8HADWVWA4/DAAA\"}"
+
 // RegisterOrderTrackingServiceHandlers registers the service with NATS micro handlers
 // Service: order_tracking_service v1.0.0
 // Description: Order tracking service
 // Subject prefix: api.v1
-// Configuration options: WithName(), WithVersion(), WithDescription(), WithSubjectPrefix(), WithTimeout()
-// Metadata options: WithMetadata() (replace), WithAdditionalMetadata() (merge)
+// Configuration options: WithName(), WithVersion(), WithDescription(), WithSubjectPrefix(), WithTimeout(), WithQueueGroup(), WithMaxRequestSize()
+// Metadata options: WithMetadata() (replace), WithAdditionalMetadata() (merge), WithEndpointMetadata() (per method)
 // Handler options: WithStatsHandler(), WithDoneHandler(), WithErrorHandler()
+// Interceptors: WithServerInterceptor() appends, WithServerInterceptorChain() replaces; the first runs outermost
+// Stream interceptors: WithServerStreamInterceptor()
+// Grouping: WithServiceGroup() registers on a ServiceGroup shared with other services
+// Existing services: AddOrderTrackingServiceEndpoints() registers on a micro.Service added elsewhere
 //
 // Endpoint metadata can be configured via proto options (nats.micro.endpoint).metadata
-func RegisterOrderTrackingServiceHandlers(nc *nats.Conn, impl OrderTrackingServiceNats, opts ...RegisterOption) (OrderTrackingServiceService, error) {
+func RegisterOrderTrackingServiceHandlers(nc *nats.Conn, impl OrderTrackingServiceNats, opts ...RegisterOption) (_ OrderTrackingServiceService, err error) {
 	cfg := &registerConfig{
-		name:          "order_tracking_service",
-		version:       "1.0.0",
-		description:   "Order tracking service",
-		subjectPrefix: "api.v1",
-		timeout:       0 * time.Second, // Service-level timeout (0 = no timeout)
-		metadata:      map[string]string{},
+		name:           "order_tracking_service",
+		version:        "1.0.0",
+		description:    "Order tracking service",
+		subjectPrefix:  "api.v1",
+		queueGroup:     "",
+		timeout:        0 * time.Second, // Service-level timeout (0 = no timeout)
+		tokenSanitizer: SanitizeToken,
+		streamWindow:   defaultStreamWindow,
+		metadata:       map[string]string{},
 	}
 	for _, opt := range opts {
 		opt(cfg)
 	}
+	return registerOrderTrackingServiceEndpoints(nc, impl, cfg, func() (*serviceHost, bool, error) {
+		// Register on the ServiceGroup, or add a micro.Service for this service
+		if cfg.group != nil {
+			return cfg.group.host, true, nil
+		}
+		host, err := addServiceHost(nc, cfg, mergeMetadata(cfg.metadata, map[string]string{"schema_hash": OrderTrackingServiceSchemaHash}))
+		return host, false, err
+	})
+}
 
-	svc, err := micro.AddService(nc, micro.Config{
-		Name:         cfg.name,
-		Version:      cfg.version,
-		Metadata:     cfg.metadata,
-		Description:  cfg.description,
-		StatsHandler: cfg.statsHandler,
-		DoneHandler:  cfg.doneHandler,
-		ErrorHandler: cfg.errorHandler,
+// AddOrderTrackingServiceEndpoints registers the OrderTrackingService endpoints on svc, a
+// micro.Service the caller added on nc, so they share its name, version and stats
+// with the endpoints of other services and the caller's own:
+//
+//	svc, err := micro.AddService(nc, micro.Config{Name: "catalog", Version: "1.0.0"})
+//	...
+//	err = AddOrderTrackingServiceEndpoints(nc, svc, impl, WithServerInterceptor(auth))
+//
+// As on a ServiceGroup, endpoint names are qualified with the service name
+// ("order_tracking_service-health") while subjects keep the subject prefix, and the
+// schema_hash is advertised as endpoint metadata. Options of the micro.Service
+// itself, which NewServiceGroup lists, are rejected with an error, as is
+// WithServiceGroup. Stopping svc stops the endpoints; there is no Drain.
+func AddOrderTrackingServiceEndpoints(nc *nats.Conn, svc micro.Service, impl OrderTrackingServiceNats, opts ...RegisterOption) error {
+	cfg := &registerConfig{
+		subjectPrefix:  "api.v1",
+		queueGroup:     "",
+		timeout:        0 * time.Second, // Service-level timeout (0 = no timeout)
+		tokenSanitizer: SanitizeToken,
+		streamWindow:   defaultStreamWindow,
+		metadata:       map[string]string{},
+	}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	host, err := existingServiceHost(nc, svc, cfg)
+	if err != nil {
+		return err
+	}
+	// The subjects and $schema document describe the proto service
+	cfg.name, cfg.version = "order_tracking_service", "1.0.0"
+	_, err = registerOrderTrackingServiceEndpoints(nc, impl, cfg, func() (*serviceHost, bool, error) {
+		return host, true, nil
 	})
+	return err
+}
+
+// registerOrderTrackingServiceEndpoints registers the OrderTrackingService endpoints cfg describes
+// on the host attach returns, once the configuration checks out. Endpoint names
+// are qualified when attach reports the host is shared with other services.
+func registerOrderTrackingServiceEndpoints(nc *nats.Conn, impl OrderTrackingServiceNats, cfg *registerConfig, attach func() (*serviceHost, bool, error)) (_ OrderTrackingServiceService, err error) {
+
+	// Describe each endpoint from the schema blob
+	schemaMetadata, err := schemaEndpointMetadata(orderTrackingServiceSchema, "order.v1.OrderTrackingService")
+	if err != nil {
+		return nil, err
+	}
+	endpointMethods := map[string]bool{
+		"TrackOrder":     true,
+		"UpdateTracking": true,
+	}
+	for method := range cfg.endpointMetadata {
+		if !endpointMethods[method] {
+			return nil, fmt.Errorf("WithEndpointMetadata: OrderTrackingService has no method %s", method)
+		}
+	}
+
+	// Streams are compressed with gzip unless WithCompression names another codec
+	compressor := compressorFor(streamEncodingGzip)
+	if cfg.compressionCodec != "" {
+		if compressor = compressorFor(cfg.compressionCodec); compressor == nil {
+			return nil, fmt.Errorf("WithCompression: codec %q is not registered; add it with RegisterCompressor", cfg.compressionCodec)
+		}
+	}
+
+	host, shared, err := attach()
 	if err != nil {
-		return nil, fmt.Errorf("failed to add service: %w", err)
+		return nil, err
+	}
+	endpointPrefix := "" // Qualifies endpoint names on a shared micro.Service
+	if shared {
+		endpointPrefix = "order_tracking_service-"
+	} else {
+		// Stop the micro.Service again if an endpoint fails to register
+		defer func() {
+			if err != nil {
+				host.svc.Stop()
+			}
+		}()
 	}
+	svc, pool := host.svc, host.pool
 
 	// Chain server interceptors
 	var chainedInterceptor UnaryServerInterceptor
@@ -1413,27 +2333,62 @@ func RegisterOrderTrackingServiceHandlers(nc *nats.Conn, impl OrderTrackingServi
 	}
 
 	handlers := &orderTrackingServiceHandlers{
-		impl:           impl,
-		serviceTimeout: cfg.timeout,
-		useJSON:        false,
-		interceptor:    chainedInterceptor,
-		js:             cfg.js,
-	}
-
-	// Map of endpoint names to their handlers
+		nc:                nc,
+		impl:              impl,
+		serviceTimeout:    cfg.timeout,
+		useJSON:           false,
+		interceptor:       chainedInterceptor,
+		js:                cfg.js,
+		cancels:           host.cancels,
+		tokenSanitizer:    cfg.tokenSanitizer,
+		idGenerator:       cfg.idGenerator,
+		maxRequestSize:    cfg.maxRequestSize,
+		streamWindow:      cfg.streamWindow,
+		streamAllowGaps:   cfg.streamAllowGaps,
+		streamCompression: cfg.streamCompression,
+		compression:       cfg.compression,
+		compressor:        compressor,
+		responseHeaders:   cfg.responseHeaders,
+		requestCheck:      cfg.requestCheck,
+		streamInterceptor: chainStreamServerInterceptors(cfg.streamInterceptors),
+		endpointPrefix:    endpointPrefix,
+		inflight:          host.inflight,
+		sampler:           newSampler(cfg.sampling, cfg.samplingSalt),
+		maxServerDeadline: cfg.maxServerDeadline,
+		persistenceErrors: cfg.persistenceErrors,
+		panics:            cfg.panicDiagnostics,
+		failures:          newFailureRecorder(cfg),
+	}
+
+	// Map of endpoint names to their handlers; unary ones go through the handler pool,
+	// idempotent ones are coalesced first, and those expecting a response are
+	// deduplicated by idempotency key inside the pool
+	idempotency := newIdempotencyGuard(cfg)
 	endpoints := map[string]micro.Handler{
 
-		"track_order": micro.HandlerFunc(handlers.TrackOrder),
+		"track_order": pool.wrap(endpointPrefix+"track_order", idempotency.wrap(endpointPrefix+"track_order", micro.HandlerFunc(handlers.TrackOrder))),
 
-		"update_tracking": micro.HandlerFunc(handlers.UpdateTracking),
+		"update_tracking": pool.wrap(endpointPrefix+"update_tracking", idempotency.wrap(endpointPrefix+"update_tracking", micro.HandlerFunc(handlers.UpdateTracking))),
 	}
 
-	// Map of endpoint names to their metadata
+	// Map of endpoint names to their metadata: the schema's description of the
+	// method, overlaid with (natsmicro.endpoint).metadata, then WithEndpointMetadata
 	endpointMetadata := map[string]map[string]string{
 
-		"track_order": {},
+		"track_order": mergeMetadata(mergeMetadata(schemaMetadata["TrackOrder"], map[string]string{}), cfg.endpointMetadata["TrackOrder"]),
 
-		"update_tracking": {},
+		"update_tracking": mergeMetadata(mergeMetadata(schemaMetadata["UpdateTracking"], map[string]string{}), cfg.endpointMetadata["UpdateTracking"]),
+	}
+
+	// Map of endpoint names to exact subjects from (natsmicro.endpoint).subject,
+	// or the wildcard form of subject_template
+	// These endpoints are registered outside the subject prefix group
+	endpointSubjects := map[string]string{}
+
+	// Endpoints WithInstanceSubjects also serves on <subject>.<instance ID>
+	instanceEndpoints := map[string]bool{
+		"track_order":     true,
+		"update_tracking": true,
 	}
 
 	// Use interface to handle both Service and Group
@@ -1441,35 +2396,146 @@ func RegisterOrderTrackingServiceHandlers(nc *nats.Conn, impl OrderTrackingServi
 		AddEndpoint(string, micro.Handler, ...micro.EndpointOpt) error
 	}
 
-	var adder endpointAdder = svc
+	var group endpointAdder = svc
 	if cfg.subjectPrefix != "" {
-		adder = svc.AddGroup(cfg.subjectPrefix)
+		group = svc.AddGroup(cfg.subjectPrefix)
 	}
 
 	// Register all endpoints with their metadata
 	for name, handler := range endpoints {
 		opts := []micro.EndpointOpt{}
-		if metadata, exists := endpointMetadata[name]; exists && len(metadata) > 0 {
+		metadata := endpointMetadata[name]
+		if cfg.maxRequestSize > 0 {
+			// Advertise the request size limit alongside the proto metadata
+			withLimit := map[string]string{"max_request_size": fmt.Sprint(cfg.maxRequestSize)}
+			for k, v := range metadata {
+				withLimit[k] = v
+			}
+			metadata = withLimit
+		}
+		if shared {
+			// Keep the unqualified subject; the shared service's metadata cannot
+			// carry every service's schema hash
+			opts = append(opts, micro.WithEndpointSubject(name))
+			metadata = mergeMetadata(metadata, map[string]string{"schema_hash": OrderTrackingServiceSchemaHash})
+			if cfg.queueGroup != "" {
+				opts = append(opts, micro.WithEndpointQueueGroup(cfg.queueGroup))
+			}
+		}
+		if len(metadata) > 0 {
 			opts = append(opts, micro.WithEndpointMetadata(metadata))
 		}
-		if err := adder.AddEndpoint(name, handler, opts...); err != nil {
+		adder := group
+		subject := name
+		if exact, exists := endpointSubjects[name]; exists {
+			adder = svc
+			subject = exact
+			opts = append(opts, micro.WithEndpointSubject(subject))
+		}
+		if cfg.instanceSubjects && instanceEndpoints[name] {
+			// Responses name the instance, for ResponderInstance
+			handler = respondAsInstance(svc.Info().ID, handler)
+		}
+		if err := adder.AddEndpoint(endpointPrefix+name, holdRequests(cfg.hold, handler), opts...); err != nil {
 			return nil, fmt.Errorf("failed to add endpoint %s: %w", name, err)
 		}
+		if cfg.instanceSubjects && instanceEndpoints[name] {
+			// The same handler on this instance's own subject, for WithTargetInstance
+			opts = append(opts, micro.WithEndpointSubject(subject+"."+svc.Info().ID))
+			if err := adder.AddEndpoint(endpointPrefix+name+"-instance", holdRequests(cfg.hold, handler), opts...); err != nil {
+				return nil, fmt.Errorf("failed to add instance endpoint %s: %w", name, err)
+			}
+		}
+	}
+
+	pool.start()
+
+	// Application health endpoint, registered outside the subject prefix group
+	if !cfg.noHealthEndpoint {
+		subject := healthSubject(cfg.subjectPrefix, "order_tracking_service")
+		if err := svc.AddEndpoint(endpointPrefix+"health", holdRequests(cfg.hold, newHealthHandler(impl, cfg.timeout)), micro.WithEndpointSubject(subject)); err != nil {
+			return nil, fmt.Errorf("failed to add health endpoint: %w", err)
+		}
+	}
+
+	// Schema reflection endpoint, registered outside the subject prefix group
+	if !cfg.noReflectEndpoint {
+		subject := reflectSubject(cfg.subjectPrefix, "order_tracking_service")
+		if err := svc.AddEndpoint(endpointPrefix+"reflect", holdRequests(cfg.hold, newReflectHandler(orderTrackingServiceSchema, OrderTrackingServiceSchemaHash)), micro.WithEndpointSubject(subject)); err != nil {
+			return nil, fmt.Errorf("failed to add reflect endpoint: %w", err)
+		}
+	}
+
+	// Schema document endpoint, registered outside the subject prefix group
+	if !cfg.noSchemaEndpoint {
+		handler, err := newSchemaHandler(orderTrackingServiceSchemaDocument, cfg)
+		if err != nil {
+			return nil, err
+		}
+		subject := schemaSubject(cfg.subjectPrefix, "order_tracking_service")
+		if err := svc.AddEndpoint(endpointPrefix+"schema", holdRequests(cfg.hold, handler), micro.WithEndpointSubject(subject)); err != nil {
+			return nil, fmt.Errorf("failed to add schema endpoint: %w", err)
+		}
+	}
+
+	queueGroup := cfg.queueGroup
+	if queueGroup == "" {
+		queueGroup = micro.DefaultQueueGroup
 	}
 
 	return &orderTrackingServiceService{
-		Service:       svc,
-		subjectPrefix: cfg.subjectPrefix,
+		Service:        svc,
+		subjectPrefix:  cfg.subjectPrefix,
+		queueGroup:     queueGroup,
+		maxRequestSize: cfg.maxRequestSize,
+		inflight:       handlers.inflight,
+		interceptors:   interceptorChainNames(cfg.serverInterceptors),
+		sampler:        handlers.sampler,
 	}, nil
 }
 
+// OrderTrackingServiceRegistration describes a RegisterOrderTrackingServiceHandlers call for
+// RegisterGroup, which registers it together with other services, all or nothing
+func OrderTrackingServiceRegistration(impl OrderTrackingServiceNats, opts ...RegisterOption) Registration {
+	return registrationFunc(func(nc *nats.Conn, hold func(context.Context) error) (GroupService, error) {
+		return RegisterOrderTrackingServiceHandlers(nc, impl, append(opts[:len(opts):len(opts)], withRequestHold(hold))...)
+	})
+}
+
 // orderTrackingServiceHandlers wraps the service implementation with NATS handlers
 type orderTrackingServiceHandlers struct {
-	impl           OrderTrackingServiceNats
-	serviceTimeout time.Duration          // Default timeout for all endpoints
-	useJSON        bool                   // Use JSON encoding instead of binary protobuf
-	interceptor    UnaryServerInterceptor // Chained interceptors
-	js             jetstream.JetStream    // Optional JetStream context for KV/ObjectStore
+	nc                 *nats.Conn // NATS connection for streaming
+	impl               OrderTrackingServiceNats
+	serviceTimeout     time.Duration                     // Default timeout for all endpoints
+	useJSON            bool                              // Use JSON encoding instead of binary protobuf
+	interceptor        UnaryServerInterceptor            // Chained interceptors
+	methodInterceptors map[string]UnaryServerInterceptor // Interceptors plus (natsmicro.endpoint).middlewares, by method
+	js                 jetstream.JetStream               // Optional JetStream context for KV/ObjectStore
+	cancels            *cancelRegistry                   // Client cancel notices (nil unless WithCancelPropagation)
+	tokenSanitizer     func(string) string               // Escapes request fields in KV/Object Store keys
+	idGenerator        func() string                     // Mints stream inbox and persistent call IDs (nil = NUIDs)
+	maxRequestSize     int                               // Largest accepted request payload in bytes (0 = unlimited)
+	inflight           *inflightTracker                  // Running handlers, for Drain
+	persistentStreams  map[string]*persistentStream      // (natsmicro.stream).persistence streams, by method
+	streamWindow       int                               // Server-stream flow control cap (0 = none)
+	streamCompression  int                               // Smallest stream message compressed, once negotiated (0 = off)
+	compression        int                               // Smallest unary response compressed, if the client accepts it (0 = off)
+	compressor         Compressor                        // Codec for responses and stream messages
+	streamAllowGaps    bool                              // Skip lost client-stream messages instead of failing Recv
+	responseHeaders    *headerPolicy                     // Strips response headers (nil = allow all)
+	requestCheck       *requestImmutabilityCheck         // Reports handlers that modify their request (nil = off)
+	streamInterceptor  StreamServerInterceptor           // Chained stream interceptors
+	endpointPrefix     string                            // Qualifies endpoint names within a ServiceGroup
+	sampler            *sampler                          // Samples unary calls (WithSampling)
+	maxServerDeadline  time.Duration                     // Cap on propagated client deadlines (0 = none)
+	persistenceErrors  func(method string, err error)    // Receives failed KV/Object Store writes (nil = print)
+	panics             *panicDiagnostics                 // Recovers and reports handler panics (nil = off)
+	failures           *failureRecorder                  // Records failed calls for replay (nil = off)
+}
+
+// keyToken renders a request field for a key template through the token sanitizer
+func (h *orderTrackingServiceHandlers) keyToken(v any) string {
+	return h.tokenSanitizer(fmt.Sprint(v))
 }
 
 func (h *orderTrackingServiceHandlers) TrackOrder(req micro.Request) {
@@ -1477,14 +2543,26 @@ func (h *orderTrackingServiceHandlers) TrackOrder(req micro.Request) {
 	// If endpoint timeout is set (> 0), use it; otherwise use service timeout
 	timeout := h.serviceTimeout
 
-	// Create context with timeout if configured, otherwise use background context
-	ctx := context.Background()
+	// Create context with timeout if configured, derived from the service's drain context
+	ctx, end := h.inflight.begin(false) // Tracked for Drain
+	defer end()
 	var cancel context.CancelFunc
 	if timeout > 0 {
 		ctx, cancel = context.WithTimeout(ctx, timeout)
 		defer cancel()
 	}
 
+	// Stop when the client gives up: honor the deadline it propagated
+	ctx, cancelDeadline := withPropagatedDeadline(ctx, req.Headers(), h.maxServerDeadline)
+	defer cancelDeadline()
+
+	// Cancel the handler early if the client abandons the request
+	if h.cancels != nil {
+		var release func()
+		ctx, release = h.cancels.watch(ctx, req.Headers())
+		defer release()
+	}
+
 	// Add incoming NATS headers to context so service methods can access them
 	if req.Headers() != nil {
 		ctx = WithIncomingHeaders(ctx, req.Headers())
@@ -1492,62 +2570,69 @@ func (h *orderTrackingServiceHandlers) TrackOrder(req micro.Request) {
 
 	// Initialize outgoing headers pointer in context so interceptors can set response headers
 	outgoingHeadersPtr := &nats.Header{}
-	ctx = context.WithValue(ctx, outgoingHeadersKey, outgoingHeadersPtr)
+	ctx = withPendingResponseHeaders(ctx, outgoingHeadersPtr)
 
+	// Reject oversized requests before decoding them, decompressing compressed ones
+	body, payloadErr := readPayload("request", req.Headers().Get(ContentEncodingHeader), req.Data(), h.maxRequestSize)
+	if payloadErr != nil {
+		code, message, data := natsErrorFields(payloadErr)
+		req.Error(code, message, data)
+		return
+	}
+
+	// Decode with the codec the client named; clients without Content-Type use the configured one
+	requestJSON, ctErr := payloadUsesJSON(req.Headers().Get(ContentTypeHeader), h.useJSON)
+	if ctErr != nil {
+		code, message, data := natsErrorFields(ctErr)
+		req.Error(code, message, data)
+		return
+	}
 	var msg TrackOrderRequest
-	if h.useJSON {
-		if err := protojson.Unmarshal(req.Data(), &msg); err != nil {
+	if requestJSON {
+		if err := protojson.Unmarshal(body, &msg); err != nil {
 			req.Error(OrderTrackingServiceErrCodeInvalidArgument, fmt.Sprintf("failed to decode JSON request: %v", err), nil)
 			return
 		}
 	} else {
-		if err := proto.Unmarshal(req.Data(), &msg); err != nil {
+		if err := proto.Unmarshal(body, &msg); err != nil {
 			req.Error(OrderTrackingServiceErrCodeInvalidArgument, fmt.Sprintf("failed to decode request: %v", err), nil)
 			return
 		}
 	}
 
+	// Sample the call for WithSampling, capturing the request before the handler runs
+	sample := h.sampler.begin("OrderTrackingService", "TrackOrder", incomingRequestID(req), false, nats.Header(req.Headers()), &msg, false)
+
 	// Define the handler function
 	handler := func(ctx context.Context, request interface{}) (interface{}, error) {
 		typedReq, ok := request.(*TrackOrderRequest)
 		if !ok {
 			return nil, fmt.Errorf("invalid request type")
 		}
+		defer h.requestCheck.check("OrderTrackingService.TrackOrder", typedReq)()
 		return h.impl.TrackOrder(ctx, typedReq)
 	}
+	handler = h.panics.wrap("OrderTrackingService", "TrackOrder", "order.v1.OrderTrackingService.TrackOrder", false, handler)
+	handler = h.failures.wrap("OrderTrackingService", "TrackOrder", "order.v1.OrderTrackingService.TrackOrder", false, handler)
 
 	// Execute through interceptor chain if configured
 	var resp interface{}
 	var err error
-	if h.interceptor != nil {
+	interceptor := h.interceptor
+	if interceptor != nil {
 		info := &UnaryServerInfo{
-			Service: "OrderTrackingService",
-			Method:  "TrackOrder",
-			Subject: "api.v1.track_order",
+			Service:    "OrderTrackingService",
+			Method:     "TrackOrder",
+			FullMethod: "order.v1.OrderTrackingService.TrackOrder",
+			Subject:    "api.v1.track_order",
 		}
-		resp, err = h.interceptor(ctx, &msg, info, handler)
+		resp, err = interceptor(ctx, &msg, info, handler)
 	} else {
 		resp, err = handler(ctx, &msg)
 	}
+	sample.end(resp, err)
 	if err != nil {
-		// Check if error implements custom status code/message/data methods
-		code := OrderTrackingServiceErrCodeInternal
-		message := err.Error()
-		var data []byte
-
-		// Check for NatsErrorCode() string method
-		if coder, ok := err.(interface{ NatsErrorCode() string }); ok {
-			code = coder.NatsErrorCode()
-		}
-		// Check for NatsErrorMessage() string method
-		if messager, ok := err.(interface{ NatsErrorMessage() string }); ok {
-			message = messager.NatsErrorMessage()
-		}
-		// Check for NatsErrorData() []byte method
-		if dataProvider, ok := err.(interface{ NatsErrorData() []byte }); ok {
-			data = dataProvider.NatsErrorData()
-		}
-
+		code, message, data := natsErrorFields(err)
 		req.Error(code, message, data)
 		return
 	}
@@ -1561,7 +2646,7 @@ func (h *orderTrackingServiceHandlers) TrackOrder(req micro.Request) {
 
 	var data []byte
 	if h.useJSON {
-		data, err = protojson.Marshal(typedResp)
+		data, err = marshalJSON(typedResp, false)
 		if err != nil {
 			req.Error(OrderTrackingServiceErrCodeInternal, fmt.Sprintf("failed to encode JSON response: %v", err), nil)
 			return
@@ -1576,22 +2661,17 @@ func (h *orderTrackingServiceHandlers) TrackOrder(req micro.Request) {
 
 	// Check if context has outgoing headers set by interceptors
 	// Read from the pointer that was initialized at the start
-	var outgoingHeaders nats.Header
-	if headersPtr, ok := ctx.Value(outgoingHeadersKey).(*nats.Header); ok && headersPtr != nil {
-		outgoingHeaders = *headersPtr
-	}
+	outgoingHeaders := pendingResponseHeaders(ctx)
 
-	if len(outgoingHeaders) > 0 {
-		// Send response with headers using micro.WithHeaders
-		// Convert nats.Header to micro.Headers (they are the same underlying type)
-		if err := req.Respond(data, micro.WithHeaders(micro.Headers(outgoingHeaders))); err != nil {
-			fmt.Fprintf(os.Stderr, "failed to send response for TrackOrder: %v\n", err)
-		}
-	} else {
-		// Send response without headers
-		if err := req.Respond(data); err != nil {
-			fmt.Fprintf(os.Stderr, "failed to send response for TrackOrder: %v\n", err)
-		}
+	// Send response with headers set by interceptors plus the response codec
+	// Convert nats.Header to micro.Headers (they are the same underlying type)
+	outgoingHeaders = h.responseHeaders.strip(h.endpointPrefix+"track_order", outgoingHeaders)
+	outgoingHeaders = withContentType(outgoingHeaders, h.useJSON)
+	if h.compression > 0 && acceptsEncoding(req.Headers().Get(acceptEncodingHeader), h.compressor.Name()) {
+		data = compressPayload(h.compressor, h.compression, data, outgoingHeaders)
+	}
+	if err := req.Respond(data, micro.WithHeaders(micro.Headers(outgoingHeaders))); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to send response for TrackOrder: %v\n", err)
 	}
 }
 
@@ -1600,14 +2680,26 @@ func (h *orderTrackingServiceHandlers) UpdateTracking(req micro.Request) {
 	// If endpoint timeout is set (> 0), use it; otherwise use service timeout
 	timeout := h.serviceTimeout
 
-	// Create context with timeout if configured, otherwise use background context
-	ctx := context.Background()
+	// Create context with timeout if configured, derived from the service's drain context
+	ctx, end := h.inflight.begin(false) // Tracked for Drain
+	defer end()
 	var cancel context.CancelFunc
 	if timeout > 0 {
 		ctx, cancel = context.WithTimeout(ctx, timeout)
 		defer cancel()
 	}
 
+	// Stop when the client gives up: honor the deadline it propagated
+	ctx, cancelDeadline := withPropagatedDeadline(ctx, req.Headers(), h.maxServerDeadline)
+	defer cancelDeadline()
+
+	// Cancel the handler early if the client abandons the request
+	if h.cancels != nil {
+		var release func()
+		ctx, release = h.cancels.watch(ctx, req.Headers())
+		defer release()
+	}
+
 	// Add incoming NATS headers to context so service methods can access them
 	if req.Headers() != nil {
 		ctx = WithIncomingHeaders(ctx, req.Headers())
@@ -1615,62 +2707,69 @@ func (h *orderTrackingServiceHandlers) UpdateTracking(req micro.Request) {
 
 	// Initialize outgoing headers pointer in context so interceptors can set response headers
 	outgoingHeadersPtr := &nats.Header{}
-	ctx = context.WithValue(ctx, outgoingHeadersKey, outgoingHeadersPtr)
+	ctx = withPendingResponseHeaders(ctx, outgoingHeadersPtr)
+
+	// Reject oversized requests before decoding them, decompressing compressed ones
+	body, payloadErr := readPayload("request", req.Headers().Get(ContentEncodingHeader), req.Data(), h.maxRequestSize)
+	if payloadErr != nil {
+		code, message, data := natsErrorFields(payloadErr)
+		req.Error(code, message, data)
+		return
+	}
 
+	// Decode with the codec the client named; clients without Content-Type use the configured one
+	requestJSON, ctErr := payloadUsesJSON(req.Headers().Get(ContentTypeHeader), h.useJSON)
+	if ctErr != nil {
+		code, message, data := natsErrorFields(ctErr)
+		req.Error(code, message, data)
+		return
+	}
 	var msg UpdateTrackingRequest
-	if h.useJSON {
-		if err := protojson.Unmarshal(req.Data(), &msg); err != nil {
+	if requestJSON {
+		if err := protojson.Unmarshal(body, &msg); err != nil {
 			req.Error(OrderTrackingServiceErrCodeInvalidArgument, fmt.Sprintf("failed to decode JSON request: %v", err), nil)
 			return
 		}
 	} else {
-		if err := proto.Unmarshal(req.Data(), &msg); err != nil {
+		if err := proto.Unmarshal(body, &msg); err != nil {
 			req.Error(OrderTrackingServiceErrCodeInvalidArgument, fmt.Sprintf("failed to decode request: %v", err), nil)
 			return
 		}
 	}
 
+	// Sample the call for WithSampling, capturing the request before the handler runs
+	sample := h.sampler.begin("OrderTrackingService", "UpdateTracking", incomingRequestID(req), false, nats.Header(req.Headers()), &msg, false)
+
 	// Define the handler function
 	handler := func(ctx context.Context, request interface{}) (interface{}, error) {
 		typedReq, ok := request.(*UpdateTrackingRequest)
 		if !ok {
 			return nil, fmt.Errorf("invalid request type")
 		}
+		defer h.requestCheck.check("OrderTrackingService.UpdateTracking", typedReq)()
 		return h.impl.UpdateTracking(ctx, typedReq)
 	}
+	handler = h.panics.wrap("OrderTrackingService", "UpdateTracking", "order.v1.OrderTrackingService.UpdateTracking", false, handler)
+	handler = h.failures.wrap("OrderTrackingService", "UpdateTracking", "order.v1.OrderTrackingService.UpdateTracking", false, handler)
 
 	// Execute through interceptor chain if configured
 	var resp interface{}
 	var err error
-	if h.interceptor != nil {
+	interceptor := h.interceptor
+	if interceptor != nil {
 		info := &UnaryServerInfo{
-			Service: "OrderTrackingService",
-			Method:  "UpdateTracking",
-			Subject: "api.v1.update_tracking",
+			Service:    "OrderTrackingService",
+			Method:     "UpdateTracking",
+			FullMethod: "order.v1.OrderTrackingService.UpdateTracking",
+			Subject:    "api.v1.update_tracking",
 		}
-		resp, err = h.interceptor(ctx, &msg, info, handler)
+		resp, err = interceptor(ctx, &msg, info, handler)
 	} else {
 		resp, err = handler(ctx, &msg)
 	}
+	sample.end(resp, err)
 	if err != nil {
-		// Check if error implements custom status code/message/data methods
-		code := OrderTrackingServiceErrCodeInternal
-		message := err.Error()
-		var data []byte
-
-		// Check for NatsErrorCode() string method
-		if coder, ok := err.(interface{ NatsErrorCode() string }); ok {
-			code = coder.NatsErrorCode()
-		}
-		// Check for NatsErrorMessage() string method
-		if messager, ok := err.(interface{ NatsErrorMessage() string }); ok {
-			message = messager.NatsErrorMessage()
-		}
-		// Check for NatsErrorData() []byte method
-		if dataProvider, ok := err.(interface{ NatsErrorData() []byte }); ok {
-			data = dataProvider.NatsErrorData()
-		}
-
+		code, message, data := natsErrorFields(err)
 		req.Error(code, message, data)
 		return
 	}
@@ -1684,7 +2783,7 @@ func (h *orderTrackingServiceHandlers) UpdateTracking(req micro.Request) {
 
 	var data []byte
 	if h.useJSON {
-		data, err = protojson.Marshal(typedResp)
+		data, err = marshalJSON(typedResp, false)
 		if err != nil {
 			req.Error(OrderTrackingServiceErrCodeInternal, fmt.Sprintf("failed to encode JSON response: %v", err), nil)
 			return
@@ -1699,47 +2798,120 @@ func (h *orderTrackingServiceHandlers) UpdateTracking(req micro.Request) {
 
 	// Check if context has outgoing headers set by interceptors
 	// Read from the pointer that was initialized at the start
-	var outgoingHeaders nats.Header
-	if headersPtr, ok := ctx.Value(outgoingHeadersKey).(*nats.Header); ok && headersPtr != nil {
-		outgoingHeaders = *headersPtr
-	}
+	outgoingHeaders := pendingResponseHeaders(ctx)
 
-	if len(outgoingHeaders) > 0 {
-		// Send response with headers using micro.WithHeaders
-		// Convert nats.Header to micro.Headers (they are the same underlying type)
-		if err := req.Respond(data, micro.WithHeaders(micro.Headers(outgoingHeaders))); err != nil {
-			fmt.Fprintf(os.Stderr, "failed to send response for UpdateTracking: %v\n", err)
-		}
-	} else {
-		// Send response without headers
-		if err := req.Respond(data); err != nil {
-			fmt.Fprintf(os.Stderr, "failed to send response for UpdateTracking: %v\n", err)
-		}
+	// Send response with headers set by interceptors plus the response codec
+	// Convert nats.Header to micro.Headers (they are the same underlying type)
+	outgoingHeaders = h.responseHeaders.strip(h.endpointPrefix+"update_tracking", outgoingHeaders)
+	outgoingHeaders = withContentType(outgoingHeaders, h.useJSON)
+	if h.compression > 0 && acceptsEncoding(req.Headers().Get(acceptEncodingHeader), h.compressor.Name()) {
+		data = compressPayload(h.compressor, h.compression, data, outgoingHeaders)
+	}
+	if err := req.Respond(data, micro.WithHeaders(micro.Headers(outgoingHeaders))); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to send response for UpdateTracking: %v\n", err)
 	}
 }
 
 // OrderTrackingServiceNatsClientInterface is the interface for the NATS client
 // This interface allows for easier dependency injection and testing
 type OrderTrackingServiceNatsClientInterface interface {
-	TrackOrder(context.Context, *TrackOrderRequest) (*TrackOrderResponse, error)
-	UpdateTracking(context.Context, *UpdateTrackingRequest) (*UpdateTrackingResponse, error)
+	TrackOrder(context.Context, *TrackOrderRequest, ...CallOption) (*TrackOrderResponse, error)
+	UpdateTracking(context.Context, *UpdateTrackingRequest, ...CallOption) (*UpdateTrackingResponse, error)
+	Health(ctx context.Context) (*HealthResponse, error)
+	Schema(ctx context.Context) (*ServiceSchemaDocument, error)
 	Endpoints() []OrderTrackingServiceEndpointInfo
+	WithHeaders(headers nats.Header) OrderTrackingServiceNatsClientInterface
+	Close()
 }
 
 // OrderTrackingServiceNatsClient is the concrete implementation of OrderTrackingServiceNatsClientInterface
 type OrderTrackingServiceNatsClient struct {
-	nc            *nats.Conn
-	subjectPrefix string
-	useJSON       bool                   // Use JSON encoding instead of binary protobuf
-	interceptor   UnaryClientInterceptor // Chained interceptors
-	js            jetstream.JetStream    // Optional JetStream for KV/ObjectStore reads
+	nc                  *nats.Conn
+	subjectPrefix       string
+	useJSON             bool                    // Use JSON encoding instead of binary protobuf
+	interceptor         UnaryClientInterceptor  // Chained interceptors
+	streamInterceptor   StreamClientInterceptor // Chained stream interceptors
+	js                  jetstream.JetStream     // Optional JetStream for KV/ObjectStore reads
+	kvStaleAfter        time.Duration           // Age at which Get*Cached treats KV entries as misses (0 = never)
+	cancelPropagation   bool                    // Publish a cancel notice when ctx ends mid-request
+	timeout             time.Duration           // Default unary timeout when ctx has no deadline
+	retry               *retryPolicy            // Unary retry policy (nil = no retries)
+	tokenSanitizer      func(string) string     // Escapes request fields in key helpers
+	idGenerator         func() string           // Mints cancel subject and stream inbox IDs (nil = NUIDs)
+	maxResponseSize     int                     // Largest accepted response payload in bytes (0 = unlimited)
+	connSelector        func() *nats.Conn       // Picks the connection per call (nil = nc)
+	journal             *journal                // Records calls for replay (nil = no journal)
+	streamWindow        int                     // Server-stream flow control window (0 = none)
+	streamAllowGaps     bool                    // Skip lost stream messages instead of failing Recv
+	streamCompression   int                     // Smallest stream message compressed, once negotiated (0 = off)
+	compression         int                     // Smallest unary request compressed (0 = off)
+	compressionCodec    string                  // Codec unary requests are compressed with
+	streamResumeOnDrain bool                    // Reopen server streams when their server drains
+	headerPolicy        *headerPolicy           // Strips outgoing headers (nil = allow all)
+	sampler             *sampler                // Samples unary calls (WithClientSampling)
+	monitor             *connectionMonitor      // Samples the connections (WithClientConnectionMonitor)
+	headers             nats.Header             // Sent under each call's OutgoingHeaders (WithHeaders)
+}
+
+// compress returns a unary request payload compressed as WithClientCompression
+// asks, flagged in headers
+func (c *OrderTrackingServiceNatsClient) compress(data []byte, headers nats.Header) ([]byte, error) {
+	if c.compression == 0 {
+		return data, nil
+	}
+	codec := compressorFor(c.compressionCodec)
+	if codec == nil {
+		return nil, fmt.Errorf("WithClientCompression: codec %q is not registered; add it with RegisterCompressor", c.compressionCodec)
+	}
+	return compressPayload(codec, c.compression, data, headers), nil
+}
+
+// conn returns the connection for the next call or stream
+func (c *OrderTrackingServiceNatsClient) conn() *nats.Conn {
+	if c.connSelector != nil {
+		return c.connSelector()
+	}
+	return c.nc
+}
+
+// outgoingHeaders returns the headers of ctx that WithOutgoingHeaderPolicy lets a call send
+func (c *OrderTrackingServiceNatsClient) outgoingHeaders(ctx context.Context) nats.Header {
+	headers, _ := c.headerPolicy.filter(layerHeaders(c.headers, OutgoingHeaders(ctx)))
+	return headers
+}
+
+// WithHeaders returns a view of the client that sends headers with every call and
+// stream, beneath those set on the call's context with WithOutgoingHeaders. A view
+// shares the client's connections, interceptors, retry policy, journal and sampler
+// rather than copying them, so it is cheap to make per request, e.g. per tenant, and
+// like the client is safe for concurrent use. Views of views add to their parent's
+// headers. Closing a view does not stop the client's connection monitor.
+func (c *OrderTrackingServiceNatsClient) WithHeaders(headers nats.Header) OrderTrackingServiceNatsClientInterface {
+	layered := make(nats.Header, len(c.headers)+len(headers))
+	for name, values := range c.headers {
+		layered[name] = values
+	}
+	for name, values := range headers {
+		layered[name] = append([]string(nil), values...) // Later changes to headers stay out of the view
+	}
+	view := *c
+	view.headers = layered
+	view.monitor = nil
+	return &view
+}
+
+// keyToken renders a request field for a key template through the token sanitizer
+func (c *OrderTrackingServiceNatsClient) keyToken(v any) string {
+	return c.tokenSanitizer(fmt.Sprint(v))
 }
 
 // NewOrderTrackingServiceNatsClient creates a new NATS client for OrderTrackingService.
 // The client sends requests over NATS using protobuf serialization (or JSON if configured).
 func NewOrderTrackingServiceNatsClient(nc *nats.Conn, opts ...NatsClientOption) OrderTrackingServiceNatsClientInterface {
 	cfg := &natsClientConfig{
-		subjectPrefix: "api.v1",
+		subjectPrefix:  "api.v1",
+		tokenSanitizer: SanitizeToken,
+		streamWindow:   defaultStreamWindow,
 	}
 	for _, opt := range opts {
 		opt.applyNatsClientOption(cfg)
@@ -1752,30 +2924,78 @@ func NewOrderTrackingServiceNatsClient(nc *nats.Conn, opts ...NatsClientOption)
 	}
 
 	c := &OrderTrackingServiceNatsClient{
-		nc:            nc,
-		subjectPrefix: cfg.subjectPrefix,
-		useJSON:       false,
-		interceptor:   chainedInterceptor,
-		js:            cfg.js,
+		nc:                  nc,
+		subjectPrefix:       cfg.subjectPrefix,
+		useJSON:             false,
+		interceptor:         chainedInterceptor,
+		streamInterceptor:   chainStreamClientInterceptors(cfg.streamInterceptors),
+		js:                  cfg.js,
+		kvStaleAfter:        cfg.kvStaleAfter,
+		cancelPropagation:   cfg.cancelPropagation,
+		timeout:             cfg.timeout,
+		retry:               newRetryPolicy(cfg),
+		tokenSanitizer:      cfg.tokenSanitizer,
+		idGenerator:         cfg.idGenerator,
+		maxResponseSize:     cfg.maxResponseSize,
+		connSelector:        cfg.connSelector,
+		journal:             cfg.journal,
+		streamWindow:        cfg.streamWindow,
+		streamAllowGaps:     cfg.streamAllowGaps,
+		streamCompression:   cfg.streamCompression,
+		compression:         cfg.compression,
+		compressionCodec:    cfg.compressionCodec,
+		streamResumeOnDrain: cfg.streamResumeOnDrain,
+		headerPolicy:        cfg.headerPolicy,
+		sampler:             newSampler(cfg.sampling, cfg.samplingSalt),
+		monitor:             startConnectionMonitor(cfg.connMonitor, monitoredConns(nc, cfg.poolConns)...),
 	}
 	return c
 }
 
+// NewOrderTrackingServiceNatsClientPool creates a OrderTrackingService client that spreads calls
+// over conns round-robin (see RoundRobinConns). Streams stay on the connection they
+// opened on. A WithConnSelector in opts replaces the round-robin selector.
+func NewOrderTrackingServiceNatsClientPool(conns []*nats.Conn, opts ...NatsClientOption) OrderTrackingServiceNatsClientInterface {
+	selector := RoundRobinConns(conns)
+	opts = append([]NatsClientOption{natsClientOptionFunc(func(c *natsClientConfig) { c.poolConns = conns })}, opts...)
+	return NewOrderTrackingServiceNatsClient(conns[0], append([]NatsClientOption{WithConnSelector(selector)}, opts...)...)
+}
+
 // TrackOrder sends a TrackOrder request to the service via NATS.
-// Returns an error if the request fails or the service returns an error.
-func (c *OrderTrackingServiceNatsClient) TrackOrder(ctx context.Context, req *TrackOrderRequest) (*TrackOrderResponse, error) {
+// Returns an error if the request fails or the service returns an error,
+// or a *TimeoutError if a WithCallTimeout/WithClientTimeout expires.
+func (c *OrderTrackingServiceNatsClient) TrackOrder(ctx context.Context, req *TrackOrderRequest, opts ...CallOption) (*TrackOrderResponse, error) {
 	method := "TrackOrder"
 
-	// Pointer to store response headers - stored in context so invoker can update it
-	responseHeadersPtr := &nats.Header{}
+	// Sample the call for WithClientSampling under a request ID the service sees too
+	var requestID string
+	if c.sampler.enabled() {
+		ctx, requestID = withRequestID(ctx, c.idGenerator)
+	}
+	sample := c.sampler.begin("OrderTrackingService", method, requestID, true, c.outgoingHeaders(ctx), req, false)
+
+	// Bound the call when the caller gave no deadline (or asked for a per-call timeout)
+	start := time.Now()
+	parentCtx := ctx
+	ctx, cancel, timeout := withCallTimeout(ctx, c.timeout, opts)
+	defer cancel()
+
+	// Record sizes, attempts and duration for CallInfoFromContext
+	ctx, info := startCallInfo(ctx, "OrderTrackingService", c.subjectPrefix+".track_order")
+	defer info.finish()
+	ctx = withIDGenerator(ctx, c.idGenerator) // For NewID in interceptors
 
-	// Add the response headers pointer to context so invoker can populate it
+	// Give the invoker somewhere in the context to store the response headers
 	// Interceptors can then read the headers from the same context
-	ctx = context.WithValue(ctx, responseHeadersKey, responseHeadersPtr)
+	ctx = WithResponseHeaders(ctx, nats.Header{})
 
 	// Define the invoker function that performs the actual NATS call
 	invoker := func(invokerCtx context.Context, method string, request, reply interface{}) error {
-		subject := c.subjectPrefix + ".track_order"
+		// WithTargetInstance sends the call to one instance's own subject
+		subject, subjectErr := targetSubject(c.subjectPrefix+".track_order", opts)
+		if subjectErr != nil {
+			return newOrderTrackingServiceError(OrderTrackingServiceErrCodeInvalidArgument, method, subjectErr.Error(), nil)
+		}
 
 		// Marshal request
 		typedReq, ok := request.(*TrackOrderRequest)
@@ -1786,7 +3006,7 @@ func (c *OrderTrackingServiceNatsClient) TrackOrder(ctx context.Context, req *Tr
 		var data []byte
 		var err error
 		if c.useJSON {
-			data, err = protojson.Marshal(typedReq)
+			data, err = marshalJSON(typedReq, false)
 		} else {
 			data, err = proto.Marshal(typedReq)
 		}
@@ -1794,83 +3014,124 @@ func (c *OrderTrackingServiceNatsClient) TrackOrder(ctx context.Context, req *Tr
 			return err
 		}
 
-		// Extract outgoing headers from context and attach to NATS message
-		var msg *nats.Msg
-		if headers := OutgoingHeaders(invokerCtx); headers != nil {
-			msg, err = c.nc.RequestMsgWithContext(invokerCtx, &nats.Msg{
-				Subject: subject,
-				Data:    data,
-				Header:  headers,
-			})
-		} else {
-			msg, err = c.nc.RequestWithContext(invokerCtx, subject, data)
+		// Extract outgoing headers from context and attach them, naming the codec, to the NATS message
+		nc := c.conn()
+		headers := withContentType(c.outgoingHeaders(invokerCtx), c.useJSON)
+		setDeadlineHeaders(invokerCtx, headers) // For deadline-aware scheduling and handler contexts
+		if data, err = c.compress(data, headers); err != nil {
+			return err
+		}
+		headers.Set(acceptEncodingHeader, compressorNames()) // Services compress responses only for clients that decompress them
+		if c.cancelPropagation {
+			var stop func() bool
+			headers, stop = propagateCancel(invokerCtx, nc, headers, mintID(c.idGenerator))
+			defer stop()
+		}
+
+		info.attempt(len(data))
+		msg, err := nc.RequestMsgWithContext(invokerCtx, &nats.Msg{
+			Subject: subject,
+			Data:    data,
+			Header:  headers,
+		})
+		if err != nil {
+			return err
 		}
+		info.received(len(msg.Data))
+		info.responder(msg.Header)
+		body, err := readPayload("response", msg.Header.Get(ContentEncodingHeader), msg.Data, c.maxResponseSize)
 		if err != nil {
 			return err
 		}
 
-		// Store response headers in the pointer from context
+		// Store response headers where ResponseHeaders reads them
 		if msg.Header != nil && len(msg.Header) > 0 {
-			if headersPtr, ok := invokerCtx.Value(responseHeadersKey).(*nats.Header); ok && headersPtr != nil {
-				*headersPtr = msg.Header
-			}
-		} // Check if this is an error response from the service
-		if msg.Header.Get("Status") != "" {
-			code := msg.Header.Get("Status")
-			description := msg.Header.Get("Description")
-			return &OrderTrackingServiceError{
-				Code:    code,
-				Method:  method,
-				Message: description,
+			storeResponseHeaders(invokerCtx, msg.Header)
+		}
+
+		// Check if this is an error response from the service (NATS micro headers)
+		if msg.Header.Get("Nats-Service-Error-Code") != "" {
+			code := msg.Header.Get("Nats-Service-Error-Code")
+			description := msg.Header.Get("Nats-Service-Error")
+			var details []byte
+			if len(msg.Data) > 0 {
+				details = msg.Data
 			}
+			return newOrderTrackingServiceError(code, method, description, details)
 		}
 
-		// Unmarshal response
+		// Unmarshal response with the codec the service named, if any
 		typedReply, ok := reply.(*TrackOrderResponse)
 		if !ok {
 			return fmt.Errorf("invalid reply type")
 		}
-
-		if c.useJSON {
-			err = protojson.Unmarshal(msg.Data, typedReply)
+		replyJSON, err := payloadUsesJSON(msg.Header.Get(ContentTypeHeader), c.useJSON)
+		if err != nil {
+			return err
+		}
+		if replyJSON {
+			err = protojson.Unmarshal(body, typedReply)
 		} else {
-			err = proto.Unmarshal(msg.Data, typedReply)
+			err = proto.Unmarshal(body, typedReply)
 		}
 		return err
 	}
 
 	var resp TrackOrderResponse
 
-	// Execute through interceptor chain if configured
-	var err error
-	if c.interceptor != nil {
-		err = c.interceptor(ctx, method, req, &resp, invoker)
-	} else {
-		err = invoker(ctx, method, req, &resp)
+	// Execute through interceptor chain if configured, once per retry attempt
+	err := c.retry.do(ctx, func(ctx context.Context) error {
+		if c.interceptor != nil {
+			return c.interceptor(ctx, method, req, &resp, invoker)
+		}
+		return invoker(ctx, method, req, &resp)
+	})
+	sample.end(&resp, err)
+	if c.journal != nil {
+		c.journal.record("OrderTrackingService", method, c.subjectPrefix+".track_order", c.outgoingHeaders(parentCtx), req, c.useJSON, false, start, err)
 	}
-
 	if err != nil {
-		return nil, err
+		return nil, callTimeoutError(parentCtx, ctx, method, timeout, err)
 	}
 
 	return &resp, nil
 }
 
 // UpdateTracking sends a UpdateTracking request to the service via NATS.
-// Returns an error if the request fails or the service returns an error.
-func (c *OrderTrackingServiceNatsClient) UpdateTracking(ctx context.Context, req *UpdateTrackingRequest) (*UpdateTrackingResponse, error) {
+// Returns an error if the request fails or the service returns an error,
+// or a *TimeoutError if a WithCallTimeout/WithClientTimeout expires.
+func (c *OrderTrackingServiceNatsClient) UpdateTracking(ctx context.Context, req *UpdateTrackingRequest, opts ...CallOption) (*UpdateTrackingResponse, error) {
 	method := "UpdateTracking"
 
-	// Pointer to store response headers - stored in context so invoker can update it
-	responseHeadersPtr := &nats.Header{}
+	// Sample the call for WithClientSampling under a request ID the service sees too
+	var requestID string
+	if c.sampler.enabled() {
+		ctx, requestID = withRequestID(ctx, c.idGenerator)
+	}
+	sample := c.sampler.begin("OrderTrackingService", method, requestID, true, c.outgoingHeaders(ctx), req, false)
+
+	// Bound the call when the caller gave no deadline (or asked for a per-call timeout)
+	start := time.Now()
+	parentCtx := ctx
+	ctx, cancel, timeout := withCallTimeout(ctx, c.timeout, opts)
+	defer cancel()
 
-	// Add the response headers pointer to context so invoker can populate it
+	// Record sizes, attempts and duration for CallInfoFromContext
+	ctx, info := startCallInfo(ctx, "OrderTrackingService", c.subjectPrefix+".update_tracking")
+	defer info.finish()
+	ctx = withIDGenerator(ctx, c.idGenerator) // For NewID in interceptors
+
+	// Give the invoker somewhere in the context to store the response headers
 	// Interceptors can then read the headers from the same context
-	ctx = context.WithValue(ctx, responseHeadersKey, responseHeadersPtr)
+	ctx = WithResponseHeaders(ctx, nats.Header{})
 
 	// Define the invoker function that performs the actual NATS call
 	invoker := func(invokerCtx context.Context, method string, request, reply interface{}) error {
-		subject := c.subjectPrefix + ".update_tracking"
+		// WithTargetInstance sends the call to one instance's own subject
+		subject, subjectErr := targetSubject(c.subjectPrefix+".update_tracking", opts)
+		if subjectErr != nil {
+			return newOrderTrackingServiceError(OrderTrackingServiceErrCodeInvalidArgument, method, subjectErr.Error(), nil)
+		}
 
 		// Marshal request
 		typedReq, ok := request.(*UpdateTrackingRequest)
@@ -1881,7 +3142,7 @@ func (c *OrderTrackingServiceNatsClient) UpdateTracking(ctx context.Context, req
 		var data []byte
 		var err error
 		if c.useJSON {
-			data, err = protojson.Marshal(typedReq)
+			data, err = marshalJSON(typedReq, false)
 		} else {
 			data, err = proto.Marshal(typedReq)
 		}
@@ -1889,68 +3150,115 @@ func (c *OrderTrackingServiceNatsClient) UpdateTracking(ctx context.Context, req
 			return err
 		}
 
-		// Extract outgoing headers from context and attach to NATS message
-		var msg *nats.Msg
-		if headers := OutgoingHeaders(invokerCtx); headers != nil {
-			msg, err = c.nc.RequestMsgWithContext(invokerCtx, &nats.Msg{
-				Subject: subject,
-				Data:    data,
-				Header:  headers,
-			})
-		} else {
-			msg, err = c.nc.RequestWithContext(invokerCtx, subject, data)
+		// Extract outgoing headers from context and attach them, naming the codec, to the NATS message
+		nc := c.conn()
+		headers := withContentType(c.outgoingHeaders(invokerCtx), c.useJSON)
+		setDeadlineHeaders(invokerCtx, headers) // For deadline-aware scheduling and handler contexts
+		if data, err = c.compress(data, headers); err != nil {
+			return err
+		}
+		headers.Set(acceptEncodingHeader, compressorNames()) // Services compress responses only for clients that decompress them
+		if c.cancelPropagation {
+			var stop func() bool
+			headers, stop = propagateCancel(invokerCtx, nc, headers, mintID(c.idGenerator))
+			defer stop()
 		}
+
+		info.attempt(len(data))
+		msg, err := nc.RequestMsgWithContext(invokerCtx, &nats.Msg{
+			Subject: subject,
+			Data:    data,
+			Header:  headers,
+		})
+		if err != nil {
+			return err
+		}
+		info.received(len(msg.Data))
+		info.responder(msg.Header)
+		body, err := readPayload("response", msg.Header.Get(ContentEncodingHeader), msg.Data, c.maxResponseSize)
 		if err != nil {
 			return err
 		}
 
-		// Store response headers in the pointer from context
+		// Store response headers where ResponseHeaders reads them
 		if msg.Header != nil && len(msg.Header) > 0 {
-			if headersPtr, ok := invokerCtx.Value(responseHeadersKey).(*nats.Header); ok && headersPtr != nil {
-				*headersPtr = msg.Header
-			}
-		} // Check if this is an error response from the service
-		if msg.Header.Get("Status") != "" {
-			code := msg.Header.Get("Status")
-			description := msg.Header.Get("Description")
-			return &OrderTrackingServiceError{
-				Code:    code,
-				Method:  method,
-				Message: description,
+			storeResponseHeaders(invokerCtx, msg.Header)
+		}
+
+		// Check if this is an error response from the service (NATS micro headers)
+		if msg.Header.Get("Nats-Service-Error-Code") != "" {
+			code := msg.Header.Get("Nats-Service-Error-Code")
+			description := msg.Header.Get("Nats-Service-Error")
+			var details []byte
+			if len(msg.Data) > 0 {
+				details = msg.Data
 			}
+			return newOrderTrackingServiceError(code, method, description, details)
 		}
 
-		// Unmarshal response
+		// Unmarshal response with the codec the service named, if any
 		typedReply, ok := reply.(*UpdateTrackingResponse)
 		if !ok {
 			return fmt.Errorf("invalid reply type")
 		}
-
-		if c.useJSON {
-			err = protojson.Unmarshal(msg.Data, typedReply)
+		replyJSON, err := payloadUsesJSON(msg.Header.Get(ContentTypeHeader), c.useJSON)
+		if err != nil {
+			return err
+		}
+		if replyJSON {
+			err = protojson.Unmarshal(body, typedReply)
 		} else {
-			err = proto.Unmarshal(msg.Data, typedReply)
+			err = proto.Unmarshal(body, typedReply)
 		}
 		return err
 	}
 
 	var resp UpdateTrackingResponse
 
-	// Execute through interceptor chain if configured
-	var err error
-	if c.interceptor != nil {
-		err = c.interceptor(ctx, method, req, &resp, invoker)
-	} else {
-		err = invoker(ctx, method, req, &resp)
+	// Execute through interceptor chain if configured, once per retry attempt
+	err := c.retry.do(ctx, func(ctx context.Context) error {
+		if c.interceptor != nil {
+			return c.interceptor(ctx, method, req, &resp, invoker)
+		}
+		return invoker(ctx, method, req, &resp)
+	})
+	sample.end(&resp, err)
+	if c.journal != nil {
+		c.journal.record("OrderTrackingService", method, c.subjectPrefix+".update_tracking", c.outgoingHeaders(parentCtx), req, c.useJSON, false, start, err)
 	}
-
 	if err != nil {
-		return nil, err
+		return nil, callTimeoutError(parentCtx, ctx, method, timeout, err)
 	}
 
 	return &resp, nil
 }
 
+// Health calls the service's health endpoint. A service that reports HealthNotServing
+// returns a HealthResponse, not an error.
+func (c *OrderTrackingServiceNatsClient) Health(ctx context.Context) (*HealthResponse, error) {
+	parentCtx := ctx
+	ctx, cancel, timeout := withCallTimeout(ctx, c.timeout, nil)
+	defer cancel()
+	resp, err := requestHealth(ctx, c.conn(), healthSubject(c.subjectPrefix, "order_tracking_service"))
+	if err != nil {
+		return nil, callTimeoutError(parentCtx, ctx, "Health", timeout, err)
+	}
+	return resp, nil
+}
+
+// Schema fetches the service's $schema document; see FetchServiceSchema
+func (c *OrderTrackingServiceNatsClient) Schema(ctx context.Context) (*ServiceSchemaDocument, error) {
+	ctx, cancel, _ := withCallTimeout(ctx, c.timeout, nil)
+	defer cancel()
+	return FetchServiceSchema(ctx, c.conn(), schemaSubject(c.subjectPrefix, "order_tracking_service"))
+}
+
+// Close stops the client's connection monitor. The NATS connection stays open,
+// and the client can still be used.
+func (c *OrderTrackingServiceNatsClient) Close() {
+	c.monitor.close()
+}
+
 // Endpoints returns information about all service endpoints this client can call.
 // This is useful for debugging, monitoring, and introspection.
 func (c *OrderTrackingServiceNatsClient) Endpoints() []OrderTrackingServiceEndpointInfo {
@@ -1959,3 +3267,16 @@ func (c *OrderTrackingServiceNatsClient) Endpoints() []OrderTrackingServiceEndpo
 		{Name: "UpdateTracking", Subject: c.subjectPrefix + ".update_tracking"},
 	}
 }
+
+// OrderTrackingServiceSelfTest checks that the NATS connection works and that a running
+// order_tracking_service instance has registered every endpoint the client calls. Client
+// options such as WithNatsClientSubjectPrefix select the expected subjects.
+// Exit non-zero when the report is not OK to use it as a liveness or deployment hook.
+func OrderTrackingServiceSelfTest(ctx context.Context, nc *nats.Conn, opts ...NatsClientOption) *SelfTestReport {
+	c := NewOrderTrackingServiceNatsClient(nc, opts...)
+	var endpoints []SelfTestEndpoint
+	for _, ep := range c.Endpoints() {
+		endpoints = append(endpoints, SelfTestEndpoint{Name: ep.Name, Subject: ep.Subject})
+	}
+	return runSelfTest(ctx, nc, "order_tracking_service", endpoints)
+}
@@ -0,0 +1,110 @@
+package runtimetest
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	streamingv1 "example/gen/streaming/v1"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// TestCallInfo checks the sizes CallInfo reports against the payloads on the wire
+func TestCallInfo(t *testing.T) {
+	nc := connect(t, startServer(t, nil))
+	ping := streamDemoSubject("Ping")
+
+	t.Run("retries", func(t *testing.T) {
+		// The service comes up for the third attempt
+		var register sync.Once
+		client := streamingv1.NewStreamDemoServiceNatsClient(nc,
+			streamingv1.WithClientRetry(3, func(int) time.Duration { return 0 }),
+			streamingv1.WithClientInterceptor(func(ctx context.Context, method string, req, reply interface{}, invoker streamingv1.UnaryInvoker) error {
+				if streamingv1.RetryAttempt(ctx) == 3 {
+					register.Do(func() { serveStreamDemo(t, nc, &streamDemo{}) })
+				}
+				return invoker(ctx, method, req, reply)
+			}),
+		)
+
+		ctx := streamingv1.WithCallInfo(context.Background())
+		if _, err := client.Ping(ctx, &streamingv1.PingRequest{Payload: "again"}); err != nil {
+			t.Fatal(err)
+		}
+		if info := streamingv1.CallInfoFromContext(ctx); info.Subject != ping || info.Attempts != 3 {
+			t.Errorf("CallInfo = %+v, want 3 attempts on %s", info, ping)
+		}
+	})
+
+	t.Run("unary", func(t *testing.T) {
+		serveStreamDemo(t, nc, &streamDemo{})
+		client := streamingv1.NewStreamDemoServiceNatsClient(nc)
+		requests := wiretap(t, nc, ping)
+		replies := wiretap(t, nc, "_INBOX.>")
+
+		ctx := streamingv1.WithCallInfo(context.Background())
+		if _, err := client.Ping(ctx, &streamingv1.PingRequest{Payload: "how big is this"}); err != nil {
+			t.Fatal(err)
+		}
+		info := streamingv1.CallInfoFromContext(ctx)
+		sent, received := requests(), replies()
+		if len(sent) != 1 || len(received) != 1 {
+			t.Fatalf("saw %d requests and %d replies on the wire, want 1 each", len(sent), len(received))
+		}
+		if info.RequestBytes != len(sent[0]) || info.ResponseBytes != len(received[0]) {
+			t.Errorf("CallInfo sizes = %d/%d, want the wire's %d/%d", info.RequestBytes, info.ResponseBytes, len(sent[0]), len(received[0]))
+		}
+		if info.Attempts != 1 || info.Duration <= 0 {
+			t.Errorf("CallInfo = %+v, want one attempt and a duration", info)
+		}
+	})
+
+	t.Run("server stream", func(t *testing.T) {
+		serveStreamDemo(t, nc, &streamDemo{})
+		client := streamingv1.NewStreamDemoServiceNatsClient(nc)
+		requests := wiretap(t, nc, streamDemoSubject("CountUp"))
+		replies := wiretap(t, nc, "_INBOX.>")
+
+		ctx := streamingv1.WithCallInfo(context.Background())
+		stream, err := client.CountUp(ctx, &streamingv1.CountUpRequest{Start: 1 << 20, Count: 5})
+		if err != nil {
+			t.Fatal(err)
+		}
+		received := 0
+		for {
+			resp, err := stream.Recv(ctx)
+			if errors.Is(err, streamingv1.ErrStreamEOF) {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			received += proto.Size(resp)
+		}
+
+		info := streamingv1.CallInfoFromContext(ctx)
+		wire := 0
+		for _, payload := range replies() {
+			wire += len(payload)
+		}
+		sent := requests()
+		if len(sent) != 1 || info.RequestBytes != len(sent[0]) {
+			t.Errorf("CallInfo.RequestBytes = %d, want the %v bytes on the wire", info.RequestBytes, sent)
+		}
+		if info.ResponseBytes != received || info.ResponseBytes != wire {
+			t.Errorf("CallInfo.ResponseBytes = %d, want %d received and on the wire (%d)", info.ResponseBytes, received, wire)
+		}
+		if info.Attempts != 1 || info.Duration <= 0 {
+			t.Errorf("CallInfo = %+v, want one attempt and a duration", info)
+		}
+	})
+
+	t.Run("without WithCallInfo", func(t *testing.T) {
+		if got := streamingv1.CallInfoFromContext(context.Background()); got != (streamingv1.CallInfo{}) {
+			t.Errorf("CallInfoFromContext = %+v, want the zero value", got)
+		}
+	})
+}
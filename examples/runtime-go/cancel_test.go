@@ -0,0 +1,144 @@
+package runtimetest
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	streamingv1 "example/gen/streaming/v1"
+)
+
+// TestCancelPropagation cancels a call mid-handler and checks that the handler's
+// context ends long before the call's deadline, and only when both sides opt in
+func TestCancelPropagation(t *testing.T) {
+	nc := connect(t, startServer(t, nil))
+
+	for _, tt := range []struct {
+		name          string
+		server        []streamingv1.RegisterOption
+		client        []streamingv1.NatsClientOption
+		wantCancelled bool
+	}{
+		{"both opt in", []streamingv1.RegisterOption{streamingv1.WithCancelPropagation()}, []streamingv1.NatsClientOption{streamingv1.WithNatsClientCancelPropagation()}, true},
+		{"service only", []streamingv1.RegisterOption{streamingv1.WithCancelPropagation()}, nil, false},
+		{"client only", nil, []streamingv1.NatsClientOption{streamingv1.WithNatsClientCancelPropagation()}, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			started := make(chan struct{})
+			ended := make(chan error, 1)
+			svc := serveStreamDemo(t, nc, &streamDemo{ping: func(ctx context.Context, req *streamingv1.PingRequest) (*streamingv1.PingResponse, error) {
+				close(started)
+				select {
+				case <-ctx.Done():
+					ended <- context.Cause(ctx)
+				case <-time.After(time.Second):
+					ended <- nil
+				}
+				return &streamingv1.PingResponse{}, nil
+			}}, tt.server...)
+			defer svc.Stop()
+
+			client := streamingv1.NewStreamDemoServiceNatsClient(nc, tt.client...)
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			go func() {
+				<-started
+				cancel()
+			}()
+			if _, err := client.Ping(ctx, &streamingv1.PingRequest{}); err == nil {
+				t.Fatal("Ping succeeded after its context was cancelled")
+			}
+
+			cause := <-ended
+			if tt.wantCancelled && cause == nil {
+				t.Fatal("handler context was not cancelled")
+			}
+			if !tt.wantCancelled && cause != nil {
+				t.Fatalf("handler context ended with %v without cancel propagation", cause)
+			}
+		})
+	}
+}
+
+// TestStreamCancellation gives up on client-streaming and bidi streams while their
+// handlers wait in Recv, and checks that Recv fails with CANCELLED and the handler's
+// context ends soon after, with the client's reason
+func TestStreamCancellation(t *testing.T) {
+	nc := connect(t, startServer(t, nil))
+
+	// stopped is how a handler's wait in Recv ended
+	type stopped struct {
+		err, cause error
+	}
+	waiting := make(chan struct{}, 1)
+	ended := make(chan stopped, 1)
+	recvAll := func(ctx context.Context, recv func(context.Context) error) {
+		waiting <- struct{}{}
+		for {
+			if err := recv(ctx); err != nil {
+				ended <- stopped{err, context.Cause(ctx)}
+				return
+			}
+		}
+	}
+	serveStreamDemo(t, nc, &streamDemo{
+		sum: func(ctx context.Context, stream *streamingv1.StreamDemoService_Sum_Stream) (*streamingv1.SumResponse, error) {
+			recvAll(ctx, func(ctx context.Context) error { _, err := stream.Recv(ctx); return err })
+			return nil, ctx.Err()
+		},
+		chat: func(ctx context.Context, stream *streamingv1.StreamDemoService_Chat_Stream) error {
+			recvAll(ctx, func(ctx context.Context) error { _, err := stream.Recv(ctx); return err })
+			return ctx.Err()
+		},
+	})
+	client := streamingv1.NewStreamDemoServiceNatsClient(nc)
+
+	for _, tt := range []struct {
+		name   string
+		reason string             // In the cancel frame
+		run    func(t *testing.T) // Opens a stream and gives up on it once the handler waits
+	}{
+		{"sum cancelled", "CANCELLED", func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			stream, err := client.Sum(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			stream.Send(&streamingv1.SumRequest{Value: 1})
+			<-waiting
+			cancel()
+		}},
+		{"sum deadline", "DEADLINE_EXCEEDED", func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+			t.Cleanup(cancel) // Not before the deadline passes
+			if _, err := client.Sum(ctx); err != nil {
+				t.Fatal(err)
+			}
+			<-waiting
+		}},
+		{"chat closed", "CANCELLED", func(t *testing.T) {
+			stream, err := client.Chat(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			stream.Send(&streamingv1.ChatMessage{User: "a", Text: "hi"})
+			<-waiting
+			stream.Close()
+		}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.run(t)
+			select {
+			case s := <-ended:
+				if code := streamingv1.CodeOf(s.err); code != streamingv1.CodeCanceled {
+					t.Errorf("Recv failed with %v (%s), want CANCELLED", s.err, code)
+				}
+				if s.cause == nil || !strings.Contains(s.cause.Error(), "("+tt.reason+")") {
+					t.Errorf("handler context cause = %v, want the client's reason %s", s.cause, tt.reason)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("handler still waiting in Recv after the client gave up")
+			}
+		})
+	}
+}
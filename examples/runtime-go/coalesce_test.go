@@ -0,0 +1,127 @@
+package runtimetest
+
+import (
+	"context"
+	"encoding/json"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	runtimev1 "example/gen/runtime/v1"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
+)
+
+// scopesHeader carries the caller's scopes to NewScopeAuthInterceptor
+const scopesHeader = "X-Scopes"
+
+// accounts serves AccountService, holding each GetBalance until release is closed
+type accounts struct {
+	runs    atomic.Int32
+	release chan struct{}
+}
+
+func (a *accounts) GetBalance(ctx context.Context, req *runtimev1.GetBalanceRequest) (*runtimev1.Balance, error) {
+	a.runs.Add(1)
+	<-a.release
+	tenant, _ := runtimev1.TenantIDFromContext(ctx)
+	return &runtimev1.Balance{Tenant: tenant, Account: req.Account, Cents: 100}, nil
+}
+
+// serveAccounts registers impl with scopes checked from scopesHeader
+func serveAccounts(t *testing.T, nc *nats.Conn, impl *accounts, opts ...runtimev1.RegisterOption) micro.Service {
+	t.Helper()
+	opts = append(opts, runtimev1.WithServerInterceptor(runtimev1.NewScopeAuthInterceptor(runtimev1.ScopesFromHeader(scopesHeader))))
+	svc, err := runtimev1.RegisterAccountServiceHandlers(nc, impl, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { svc.Stop() })
+	if err := nc.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	return svc
+}
+
+// asCaller returns ctx for calls from tenant holding scopes, none if empty
+func asCaller(tenant, scopes string) context.Context {
+	ctx := context.Background()
+	if scopes != "" {
+		ctx = runtimev1.WithOutgoingHeaders(ctx, nats.Header{scopesHeader: {scopes}})
+	}
+	return runtimev1.WithTenantID(ctx, tenant)
+}
+
+// TestRequestCoalescingCredentials sends identical GetBalance requests from callers
+// with different scopes and tenants while the first is running, and checks that
+// only the callers the interceptors can't tell apart share its response
+func TestRequestCoalescingCredentials(t *testing.T) {
+	nc := connect(t, startServer(t, nil))
+	impl := &accounts{release: make(chan struct{})}
+	// The pool runs held handlers off the subscription, so later requests arrive
+	svc := serveAccounts(t, nc, impl, runtimev1.WithRequestCoalescing(time.Minute), runtimev1.WithHandlerPool(4))
+	client := runtimev1.NewAccountServiceNatsClient(nc, runtimev1.WithClientTimeout(5*time.Second))
+	req := &runtimev1.GetBalanceRequest{Account: "savings"}
+
+	type result struct {
+		resp *runtimev1.Balance
+		err  error
+	}
+	call := func(ctx context.Context) <-chan result {
+		done := make(chan result, 1)
+		go func() {
+			resp, err := client.GetBalance(ctx, req)
+			done <- result{resp, err}
+		}()
+		return done
+	}
+
+	first := call(asCaller("acme", "accounts:read"))
+	waitFor(t, "the first request to reach the handler", func() bool { return impl.runs.Load() == 1 })
+	same := call(asCaller("acme", "accounts:read"))
+	waitFor(t, "the same caller's request to be coalesced", func() bool { return coalesced(t, svc) == 1 })
+
+	// A caller without the scope is refused rather than handed the first response
+	select {
+	case r := <-call(asCaller("acme", "")):
+		if code := runtimev1.CodeOf(r.err); code != runtimev1.CodePermissionDenied {
+			t.Errorf("GetBalance without the scope = %v, %v; want PERMISSION_DENIED", r.resp, r.err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("GetBalance without the scope waited on the first caller's request")
+	}
+	// Another tenant gets its own handler run
+	other := call(asCaller("globex", "accounts:read"))
+	waitFor(t, "the other tenant's request to reach the handler", func() bool { return impl.runs.Load() == 2 })
+
+	close(impl.release)
+	for name, tt := range map[string]struct {
+		done   <-chan result
+		tenant string
+	}{"first": {first, "acme"}, "same caller": {same, "acme"}, "other tenant": {other, "globex"}} {
+		if r := <-tt.done; r.err != nil || r.resp.Tenant != tt.tenant {
+			t.Errorf("%s: GetBalance = %v, %v; want the balance of %s", name, r.resp, r.err, tt.tenant)
+		}
+	}
+	if n := impl.runs.Load(); n != 2 {
+		t.Errorf("handler ran %d times, want 2", n)
+	}
+}
+
+// coalesced returns how many GetBalance requests svc answered with another's response
+func coalesced(t *testing.T, svc micro.Service) uint64 {
+	t.Helper()
+	for _, e := range svc.Stats().Endpoints {
+		if e.Subject != "runtime.account.get_balance" {
+			continue
+		}
+		var stats runtimev1.CoalescingStats
+		if err := json.Unmarshal(e.Data, &stats); err != nil {
+			t.Fatal(err)
+		}
+		return stats.Coalesced
+	}
+	t.Fatal("no get_balance endpoint stats")
+	return 0
+}
@@ -0,0 +1,126 @@
+package runtimetest
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	streamingv1 "example/gen/streaming/v1"
+
+	"github.com/nats-io/nats.go"
+	"google.golang.org/protobuf/proto"
+)
+
+// TestCompression calls Ping and Chat with gzip turned on at both ends, and checks
+// which payloads are compressed on the wire and that all arrive intact
+func TestCompression(t *testing.T) {
+	nc := connect(t, startServer(t, nil))
+	serveStreamDemo(t, nc, &streamDemo{chat: func(ctx context.Context, stream *streamingv1.StreamDemoService_Chat_Stream) error {
+		for {
+			msg, err := stream.Recv(ctx)
+			if errors.Is(err, streamingv1.ErrStreamEOF) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if err := stream.Send(msg); err != nil {
+				return err
+			}
+		}
+	}}, streamingv1.WithCompression("gzip", 1024))
+	if err := nc.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	large := strings.Repeat("compressible ", 1000)
+
+	t.Run("unary", func(t *testing.T) {
+		client := streamingv1.NewStreamDemoServiceNatsClient(nc, streamingv1.WithClientCompression("gzip", 1024))
+		for _, tt := range []struct {
+			name, payload string
+			compressed    bool
+		}{
+			{"large", large, true},
+			{"under minSize", "small", false},
+		} {
+			t.Run(tt.name, func(t *testing.T) {
+				requests, replies := msgTap(t, nc, streamDemoSubject("Ping")), msgTap(t, nc, "_INBOX.>")
+				resp, err := client.Ping(context.Background(), &streamingv1.PingRequest{Payload: tt.payload})
+				if err != nil || resp.Payload != tt.payload {
+					t.Fatalf("Ping = %d bytes, %v; want the payload echoed", len(resp.GetPayload()), err)
+				}
+				sent, got := requests(), replies()
+				if len(sent) != 1 || len(got) != 1 {
+					t.Fatalf("saw %d requests and %d replies on the wire, want one each", len(sent), len(got))
+				}
+				for what, msg := range map[string]*nats.Msg{"request": sent[0], "response": got[0]} {
+					encoding := msg.Header.Get(streamingv1.ContentEncodingHeader)
+					if compressed := encoding == "gzip" && len(msg.Data) < len(tt.payload); compressed != tt.compressed {
+						t.Errorf("%s of %d bytes is %d on the wire with Content-Encoding %q, want compressed %t",
+							what, len(tt.payload), len(msg.Data), encoding, tt.compressed)
+					}
+				}
+			})
+		}
+	})
+
+	t.Run("callers without compression", func(t *testing.T) {
+		// A raw request sends no Accept-Encoding, so the response comes back plain
+		data, _ := proto.Marshal(&streamingv1.PingRequest{Payload: large})
+		msg := nats.NewMsg(streamDemoSubject("Ping"))
+		msg.Header.Set(streamingv1.ContentTypeHeader, streamingv1.ContentTypeProtobuf)
+		msg.Data = data
+		reply, err := nc.RequestMsg(msg, 5*time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var resp streamingv1.PingResponse
+		if reply.Header.Get(streamingv1.ContentEncodingHeader) != "" || proto.Unmarshal(reply.Data, &resp) != nil || resp.Payload != large {
+			t.Errorf("raw Ping answered with Content-Encoding %q, %d bytes", reply.Header.Get(streamingv1.ContentEncodingHeader), len(reply.Data))
+		}
+
+		// A client without the option still decodes the compressed responses it accepts
+		client := streamingv1.NewStreamDemoServiceNatsClient(nc)
+		if resp, err := client.Ping(context.Background(), &streamingv1.PingRequest{Payload: large}); err != nil || resp.Payload != large {
+			t.Errorf("Ping without WithClientCompression = %d bytes, %v", len(resp.GetPayload()), err)
+		}
+	})
+
+	t.Run("streams", func(t *testing.T) {
+		client := streamingv1.NewStreamDemoServiceNatsClient(nc, streamingv1.WithClientCompression("gzip", 1024))
+		frames := msgTap(t, nc, ">")
+		stream, err := client.Chat(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer stream.Close()
+		texts := []string{large, "small", large}
+		for _, text := range texts {
+			if err := stream.Send(&streamingv1.ChatMessage{User: "u", Text: text}); err != nil {
+				t.Fatal(err)
+			}
+			if msg, err := stream.Recv(context.Background()); err != nil || msg.Text != text {
+				t.Fatalf("Recv = %d bytes, %v; want the %d sent", len(msg.GetText()), err, len(text))
+			}
+		}
+		if err := stream.CloseSend(); err != nil {
+			t.Fatal(err)
+		}
+
+		// Each large message is compressed both ways
+		var compressed int
+		for _, msg := range frames() {
+			if msg.Header.Get("Nats-Stream-Frame-Encoding") == "gzip" {
+				compressed++
+				if len(msg.Data) >= len(large) {
+					t.Errorf("compressed frame of %d bytes", len(msg.Data))
+				}
+			}
+		}
+		if compressed != 4 {
+			t.Errorf("saw %d compressed stream frames, want 4", compressed)
+		}
+	})
+}
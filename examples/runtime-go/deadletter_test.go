@@ -0,0 +1,125 @@
+package runtimetest
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	runtimev1 "example/gen/runtime/v1"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// deadLetters collects what a Subscribe<Event>DLQ subscription handles
+type deadLetters struct {
+	mu      sync.Mutex
+	events  []*runtimev1.BalanceChanged
+	letters []runtimev1.DeadLetter
+}
+
+func (d *deadLetters) handle(ctx context.Context, event *runtimev1.BalanceChanged, dl runtimev1.DeadLetter) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.events = append(d.events, event)
+	d.letters = append(d.letters, dl)
+	return nil
+}
+
+func (d *deadLetters) count() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return len(d.events)
+}
+
+// TestDeadLetters fails the handler of "frozen" accounts' BalanceChanged events, and
+// checks that they are moved to the dead-letter subject after maxDeliveries, with
+// why, on core NATS and from a durable consumer
+func TestDeadLetters(t *testing.T) {
+	url := startServer(t, nil)
+	nc := connect(t, url)
+	js, err := jetstream.New(nc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const dlq = "runtime.dlq.balance_changed"
+
+	// failing fails events of the frozen account, counting their deliveries
+	failing := func(deliveries *atomic.Int32, handled *received) func(context.Context, *runtimev1.BalanceChanged) error {
+		return func(ctx context.Context, event *runtimev1.BalanceChanged) error {
+			if event.Account == "frozen" {
+				deliveries.Add(1)
+				return runtimev1.NewStatus(runtimev1.CodeFailedPrecondition, "account frozen")
+			}
+			return handled.handle(ctx, event)
+		}
+	}
+	check := func(t *testing.T, letters *deadLetters, deliveries *atomic.Int32) {
+		t.Helper()
+		waitFor(t, "the dead letter", func() bool { return letters.count() == 1 })
+		time.Sleep(100 * time.Millisecond) // No more deliveries, nor dead letters, follow
+		letters.mu.Lock()
+		defer letters.mu.Unlock()
+		want := runtimev1.DeadLetter{Subject: runtimev1.BalanceChangedSubject, Error: "account frozen", Code: "FAILED_PRECONDITION", Deliveries: 3}
+		if len(letters.events) != 1 || letters.events[0].Account != "frozen" || letters.letters[0] != want {
+			t.Errorf("dead letters = %v %+v, want the frozen account's event with %+v", letters.events, letters.letters, want)
+		}
+		if n := deliveries.Load(); n != 3 {
+			t.Errorf("handler ran %d times for the failing event, want 3", n)
+		}
+	}
+
+	t.Run("core NATS", func(t *testing.T) {
+		var deliveries atomic.Int32
+		var handled received
+		var letters deadLetters
+		dlqSub, err := runtimev1.SubscribeBalanceChangedDLQ(nc, dlq, letters.handle)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { dlqSub.Unsubscribe() })
+		subscribe(t, nc, failing(&deliveries, &handled), runtimev1.WithDeadLetter(dlq, 3),
+			runtimev1.WithSubscribeErrorHandler(func(string, error) {}))
+
+		publish(t, context.Background(), nc, "frozen")
+		publish(t, context.Background(), nc, "savings")
+		check(t, &letters, &deliveries)
+		if handled.count() != 1 {
+			t.Errorf("handler took %d events, want the one that succeeds", handled.count())
+		}
+	})
+
+	t.Run("durable", func(t *testing.T) {
+		createStream(t, js, eventsStream, "runtime.events.>")
+		dlqStream := createStream(t, js, "RUNTIME_DLQ", "runtime.dlq.>")
+		quiet := runtimev1.WithSubscribeErrorHandler(func(string, error) {})
+		var deliveries atomic.Int32
+		var handled received
+		var letters deadLetters
+		dlqSub, err := runtimev1.SubscribeBalanceChangedDLQ(nc, dlq, letters.handle, runtimev1.WithSubscribeDurable(js, "RUNTIME_DLQ", "dlq"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { dlqSub.Unsubscribe() })
+		subscribe(t, nc, failing(&deliveries, &handled), runtimev1.WithSubscribeDurable(js, eventsStream, "ledger"),
+			runtimev1.WithDeadLetter(dlq, 3), quiet)
+
+		publish(t, context.Background(), nc, "frozen", runtimev1.WithPublishJetStream(js))
+		check(t, &letters, &deliveries)
+		info, err := dlqStream.Info(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.State.Msgs != 1 {
+			t.Errorf("dead-letter stream holds %d messages, want 1", info.State.Msgs)
+		}
+
+		// Without a stream capturing the dead-letter subject, the event is redelivered
+		// past maxDeliveries. A new consumer reads the frozen event from the start.
+		var uncaptured atomic.Int32
+		subscribe(t, nc, failing(&uncaptured, &handled), runtimev1.WithSubscribeDurable(js, eventsStream, "audit"),
+			runtimev1.WithDeadLetter("runtime.uncaptured.balance_changed", 3), quiet)
+		waitFor(t, "redeliveries past maxDeliveries", func() bool { return uncaptured.Load() > 3 })
+	})
+}
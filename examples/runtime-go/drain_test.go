@@ -0,0 +1,109 @@
+package runtimetest
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	streamingv1 "example/gen/streaming/v1"
+
+	"github.com/nats-io/nats.go"
+)
+
+// TestDrain checks that Drain lets a request already running finish while new
+// requests get no responders
+func TestDrain(t *testing.T) {
+	url := startServer(t, nil)
+	server, caller := connect(t, url), connect(t, url)
+	started, release := make(chan struct{}), make(chan struct{})
+	svc := serveStreamDemo(t, server, &streamDemo{
+		ping: func(ctx context.Context, req *streamingv1.PingRequest) (*streamingv1.PingResponse, error) {
+			if req.Payload == "before" {
+				close(started)
+				<-release
+			}
+			return &streamingv1.PingResponse{Payload: req.Payload}, nil
+		},
+	})
+	if err := server.Flush(); err != nil { // The endpoints are subscribed before caller calls
+		t.Fatal(err)
+	}
+	client := streamingv1.NewStreamDemoServiceNatsClient(caller, streamingv1.WithClientTimeout(5*time.Second))
+
+	type result struct {
+		resp *streamingv1.PingResponse
+		err  error
+	}
+	before := make(chan result, 1)
+	go func() {
+		resp, err := client.Ping(context.Background(), &streamingv1.PingRequest{Payload: "before"})
+		before <- result{resp, err}
+	}()
+	select {
+	case <-started:
+	case r := <-before:
+		t.Fatalf("Ping before Drain finished early: %v, %v", r.resp, r.err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Ping before Drain never reached its handler")
+	}
+
+	drained := make(chan error, 1)
+	go func() { drained <- svc.Drain(context.Background()) }()
+	awaitUnsubscribed(t, server, svc)
+
+	// Requests made once Drain has unsubscribed the endpoints find no one
+	if _, err := client.Ping(context.Background(), &streamingv1.PingRequest{Payload: "after"}); !errors.Is(err, nats.ErrNoResponders) {
+		t.Errorf("Ping during Drain = %v, want nats.ErrNoResponders", err)
+	}
+	select {
+	case err := <-drained:
+		t.Fatalf("Drain returned %v while a handler was running", err)
+	default:
+	}
+
+	close(release)
+	if r := <-before; r.err != nil || r.resp.Payload != "before" {
+		t.Errorf("Ping started before Drain = %v, %v; want it to succeed", r.resp, r.err)
+	}
+	if err := <-drained; err != nil {
+		t.Errorf("Drain = %v", err)
+	}
+}
+
+// TestDrainEndpoints checks that Drain unsubscribes every endpoint of a service
+// with several, the streaming ones and those the runtime adds included
+func TestDrainEndpoints(t *testing.T) {
+	url := startServer(t, nil)
+	server, caller := connect(t, url), connect(t, url)
+	svc := serveStreamDemo(t, server, &streamDemo{})
+	if err := server.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	endpoints := svc.Info().Endpoints
+	if len(endpoints) < 3 {
+		t.Fatalf("StreamDemoService has %d endpoints, want at least 3", len(endpoints))
+	}
+	if err := svc.Drain(context.Background()); err != nil {
+		t.Fatalf("Drain = %v", err)
+	}
+	if err := server.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	for _, ep := range endpoints {
+		if _, err := caller.Request(ep.Subject, nil, 5*time.Second); !errors.Is(err, nats.ErrNoResponders) {
+			t.Errorf("request to %s after Drain = %v, want nats.ErrNoResponders", ep.Subject, err)
+		}
+	}
+}
+
+// awaitUnsubscribed waits until svc has stopped its endpoints and the server has
+// processed their unsubscribes
+func awaitUnsubscribed(t *testing.T, nc *nats.Conn, svc interface{ Stopped() bool }) {
+	t.Helper()
+	waitFor(t, "the endpoints to stop", svc.Stopped)
+	if err := nc.Flush(); err != nil {
+		t.Fatal(err)
+	}
+}
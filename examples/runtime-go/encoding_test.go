@@ -0,0 +1,128 @@
+package runtimetest
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	demov1 "example/gen/demo/v1"
+
+	"github.com/nats-io/nats.go"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+type mixedService struct{}
+
+func (mixedService) Echo(ctx context.Context, req *demov1.EchoRequest) (*demov1.EchoResponse, error) {
+	return &demov1.EchoResponse{Message: req.Message, Timestamp: req.Timestamp, Encoding: "binary"}, nil
+}
+
+func (mixedService) Webhook(ctx context.Context, req *demov1.EchoRequest) (*demov1.EchoResponse, error) {
+	return &demov1.EchoResponse{Message: req.Message, Timestamp: req.Timestamp, Encoding: "json"}, nil
+}
+
+// TestMixedEncoding calls MixedService, whose Webhook overrides the service's binary
+// encoding with JSON, and checks each method's payloads on the wire in both directions
+func TestMixedEncoding(t *testing.T) {
+	nc := connect(t, startServer(t, nil))
+	svc, err := demov1.RegisterMixedServiceHandlers(nc, mixedService{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { svc.Stop() })
+	client := demov1.NewMixedServiceNatsClient(nc)
+
+	subjects := map[string]string{}
+	for _, ep := range client.Endpoints() {
+		subjects[ep.Name] = ep.Subject
+	}
+
+	for _, tt := range []struct {
+		method string
+		call   func(context.Context, *demov1.EchoRequest, ...demov1.CallOption) (*demov1.EchoResponse, error)
+		json   bool
+	}{
+		{"Echo", client.Echo, false},
+		{"Webhook", client.Webhook, true},
+	} {
+		t.Run(tt.method, func(t *testing.T) {
+			requests := msgTap(t, nc, subjects[tt.method])
+			replies := msgTap(t, nc, "_INBOX.>")
+
+			req := &demov1.EchoRequest{Message: "hello", Timestamp: 1700000000}
+			resp, err := tt.call(context.Background(), req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.Message != req.Message || resp.Timestamp != req.Timestamp {
+				t.Errorf("%s = %v, want the request echoed", tt.method, resp)
+			}
+
+			sent, got := requests(), replies()
+			if len(sent) != 1 || len(got) != 1 {
+				t.Fatalf("saw %d requests and %d replies on the wire, want one each", len(sent), len(got))
+			}
+			checkEncoding(t, "request", sent[0], &demov1.EchoRequest{}, tt.json)
+			checkEncoding(t, "response", got[0], &demov1.EchoResponse{}, tt.json)
+		})
+	}
+
+	// A raw caller gets the encoding the method declares, without a Content-Type
+	t.Run("raw JSON request", func(t *testing.T) {
+		msg, err := nc.Request(subjects["Webhook"], []byte(`{"message":"raw"}`), 5*time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var resp demov1.EchoResponse
+		if err := protojson.Unmarshal(msg.Data, &resp); err != nil || resp.Message != "raw" {
+			t.Errorf("Webhook replied %q (%v), want JSON echoing the request", msg.Data, err)
+		}
+	})
+}
+
+// checkEncoding checks that msg carries into as JSON or binary protobuf, and says so
+// in its Content-Type header
+func checkEncoding(t *testing.T, what string, msg *nats.Msg, into proto.Message, wantJSON bool) {
+	t.Helper()
+	contentType, decode := demov1.ContentTypeProtobuf, proto.Unmarshal
+	if wantJSON {
+		contentType, decode = demov1.ContentTypeJSON, protojson.Unmarshal
+	}
+	if got := msg.Header.Get(demov1.ContentTypeHeader); got != contentType {
+		t.Errorf("%s Content-Type = %q, want %q", what, got, contentType)
+	}
+	if json.Valid(msg.Data) != wantJSON {
+		t.Errorf("%s payload %q: JSON = %v, want %v", what, msg.Data, !wantJSON, wantJSON)
+	}
+	if err := decode(msg.Data, into); err != nil {
+		t.Errorf("%s payload %q does not decode as %s: %v", what, msg.Data, contentType, err)
+	}
+}
+
+// msgTap is wiretap keeping the whole message, headers included
+func msgTap(t *testing.T, nc *nats.Conn, subject string) func() []*nats.Msg {
+	t.Helper()
+	var mu sync.Mutex
+	var msgs []*nats.Msg
+	sub, err := nc.Subscribe(subject, func(msg *nats.Msg) {
+		if len(msg.Data) > 0 {
+			mu.Lock()
+			msgs = append(msgs, msg)
+			mu.Unlock()
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sub.Unsubscribe() })
+	nc.Flush()
+	return func() []*nats.Msg {
+		nc.Flush()
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]*nats.Msg(nil), msgs...)
+	}
+}
@@ -0,0 +1,181 @@
+package runtimetest
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	runtimev1 "example/gen/runtime/v1"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// eventsStream captures every runtime event subject
+const eventsStream = "RUNTIME_EVENTS"
+
+// received collects the BalanceChanged events a subscription handles
+type received struct {
+	mu     sync.Mutex
+	events []*runtimev1.BalanceChanged
+	region []string // X-Region header of each event
+}
+
+func (r *received) handle(ctx context.Context, event *runtimev1.BalanceChanged) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, event)
+	r.region = append(r.region, runtimev1.IncomingHeaders(ctx).Get("X-Region"))
+	return nil
+}
+
+func (r *received) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.events)
+}
+
+// subscribe subscribes handler to BalanceChanged until the test ends
+func subscribe(t *testing.T, nc *nats.Conn, handler func(context.Context, *runtimev1.BalanceChanged) error, opts ...runtimev1.SubscribeOption) runtimev1.Subscription {
+	t.Helper()
+	sub, err := runtimev1.SubscribeBalanceChanged(nc, handler, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sub.Unsubscribe() })
+	if err := nc.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	return sub
+}
+
+// publish publishes a BalanceChanged event of account
+func publish(t *testing.T, ctx context.Context, nc *nats.Conn, account string, opts ...runtimev1.PublishOption) {
+	t.Helper()
+	if err := runtimev1.PublishBalanceChanged(ctx, nc, &runtimev1.BalanceChanged{Account: account, Cents: 100}, opts...); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// createStream creates a JetStream stream name capturing subjects
+func createStream(t *testing.T, js jetstream.JetStream, name string, subjects ...string) jetstream.Stream {
+	t.Helper()
+	stream, err := js.CreateStream(context.Background(), jetstream.StreamConfig{Name: name, Subjects: subjects})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return stream
+}
+
+// TestEvents publishes BalanceChanged events and checks that subscribers get them
+// with their headers, through interceptors, split between queue group members, and
+// from a durable JetStream consumer that redelivers failed events
+func TestEvents(t *testing.T) {
+	url := startServer(t, nil)
+	nc := connect(t, url)
+	js, err := jetstream.New(nc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("core NATS", func(t *testing.T) {
+		var got received
+		var subscribed, published atomic.Value
+		subscribe(t, nc, got.handle,
+			runtimev1.WithSubscribeInterceptor(func(ctx context.Context, req interface{}, info *runtimev1.UnaryServerInfo, handler runtimev1.UnaryHandler) (interface{}, error) {
+				subscribed.Store(info.FullMethod)
+				return handler(ctx, req)
+			}))
+		errs := make(chan error, 1)
+		subscribe(t, nc, func(context.Context, *runtimev1.BalanceChanged) error { return nil },
+			runtimev1.WithSubscribeErrorHandler(func(event string, err error) { errs <- err }))
+
+		frames := msgTap(t, nc, runtimev1.BalanceChangedSubject)
+		ctx := runtimev1.WithOutgoingHeaders(context.Background(), nats.Header{"X-Region": {"eu"}})
+		publish(t, ctx, nc, "savings", runtimev1.WithPublishInterceptor(func(ctx context.Context, method string, req, reply interface{}, invoker runtimev1.UnaryInvoker) error {
+			published.Store(method)
+			return invoker(ctx, method, req, reply)
+		}))
+		waitFor(t, "the event", func() bool { return got.count() == 1 })
+
+		if event := got.events[0]; event.Account != "savings" || event.Cents != 100 || got.region[0] != "eu" {
+			t.Errorf("handler got %v with X-Region %q, want the published event and its headers", event, got.region[0])
+		}
+		if frame := frames()[0]; frame.Header.Get(runtimev1.ContentTypeHeader) != runtimev1.ContentTypeProtobuf {
+			t.Errorf("event Content-Type = %q, want binary protobuf", frame.Header.Get(runtimev1.ContentTypeHeader))
+		}
+		if published.Load() != "BalanceChanged" || subscribed.Load() != "runtime.v1.BalanceChanged" {
+			t.Errorf("interceptors saw %v and %v, want the event's name", published.Load(), subscribed.Load())
+		}
+
+		// Events that don't decode go to the error handler
+		if err := nc.Publish(runtimev1.BalanceChangedSubject, []byte("\xff not protobuf")); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case err := <-errs:
+			if code := runtimev1.CodeOf(err); code != runtimev1.CodeInvalidArgument {
+				t.Errorf("undecodable event reported as %v, want INVALID_ARGUMENT", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("undecodable event was not reported")
+		}
+	})
+
+	t.Run("queue group", func(t *testing.T) {
+		var first, second, outside received
+		subscribe(t, nc, first.handle, runtimev1.WithSubscribeQueueGroup("billing"))
+		subscribe(t, nc, second.handle, runtimev1.WithSubscribeQueueGroup("billing"))
+		subscribe(t, nc, outside.handle)
+		for range 20 {
+			publish(t, context.Background(), nc, "savings")
+		}
+		waitFor(t, "every event", func() bool { return first.count()+second.count() == 20 && outside.count() == 20 })
+		time.Sleep(100 * time.Millisecond)
+		if n := first.count() + second.count(); n != 20 {
+			t.Errorf("queue group handled %d of 20 events, want each once", n)
+		}
+	})
+
+	t.Run("durable", func(t *testing.T) {
+		createStream(t, js, eventsStream, "runtime.events.>")
+		durable := runtimev1.WithSubscribeDurable(js, eventsStream, "ledger")
+		// The handler fails each event's first delivery; the redelivery succeeds
+		var mu sync.Mutex
+		deliveries := make(map[string]int)
+		var got received
+		var reported atomic.Int32
+		handler := func(ctx context.Context, event *runtimev1.BalanceChanged) error {
+			mu.Lock()
+			deliveries[event.Account]++
+			n := deliveries[event.Account]
+			mu.Unlock()
+			if n == 1 {
+				return errors.New("ledger busy")
+			}
+			return got.handle(ctx, event)
+		}
+		sub := subscribe(t, nc, handler, durable, runtimev1.WithSubscribeErrorHandler(func(string, error) { reported.Add(1) }))
+		publish(t, context.Background(), nc, "a", runtimev1.WithPublishJetStream(js))
+		waitFor(t, "the redelivered event", func() bool { return got.count() == 1 })
+		if n := reported.Load(); n != 1 {
+			t.Errorf("%d handler errors reported, want 1", n)
+		}
+
+		// Events published while no one subscribes wait in the stream
+		if err := sub.Unsubscribe(); err != nil {
+			t.Fatal(err)
+		}
+		publish(t, context.Background(), nc, "b", runtimev1.WithPublishJetStream(js))
+		subscribe(t, nc, handler, durable)
+		waitFor(t, "the event published while unsubscribed", func() bool { return got.count() == 2 })
+		mu.Lock()
+		defer mu.Unlock()
+		if deliveries["a"] != 2 || deliveries["b"] != 2 || got.events[1].Account != "b" {
+			t.Errorf("deliveries = %v, want each event twice", deliveries)
+		}
+	})
+}
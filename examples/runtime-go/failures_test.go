@@ -0,0 +1,147 @@
+package runtimetest
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	streamingv1 "example/gen/streaming/v1"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// recorded passes the IDs of the records saved to its RecorderStore to ids
+type recorded struct {
+	streamingv1.RecorderStore
+	ids chan string
+}
+
+func (r recorded) Save(ctx context.Context, rec *streamingv1.FailureRecord) error {
+	err := r.RecorderStore.Save(ctx, rec)
+	if err == nil {
+		r.ids <- rec.ID
+	}
+	return err
+}
+
+// TestFailureRecorder records failed Pings in a JetStream Object Store, checks what
+// the records hold and the record limit, and replays one against a failing and a
+// fixed implementation
+func TestFailureRecorder(t *testing.T) {
+	nc := connect(t, startServer(t, nil))
+	js, err := jetstream.New(nc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	obs, err := js.CreateObjectStore(context.Background(), jetstream.ObjectStoreConfig{Bucket: "runtime_failures"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := recorded{streamingv1.NewObjectRecorderStore(obs), make(chan string, 10)}
+	failing := &streamDemo{ping: func(ctx context.Context, req *streamingv1.PingRequest) (*streamingv1.PingResponse, error) {
+		if strings.HasPrefix(req.Payload, "missing") {
+			return nil, streamingv1.Statusf(streamingv1.CodeNotFound, "no account %s", req.Payload)
+		}
+		return &streamingv1.PingResponse{Payload: req.Payload}, nil
+	}}
+	serveStreamDemo(t, nc, failing, streamingv1.WithFailureRecorder(store), streamingv1.WithFailureRecordLimit(2))
+	if err := nc.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	client := streamingv1.NewStreamDemoServiceNatsClient(nc, streamingv1.WithClientTimeout(10*time.Second))
+	ping := func(payload string) error {
+		ctx := streamingv1.WithOutgoingHeaders(context.Background(), nats.Header{
+			streamingv1.RequestIDHeader: {"req-" + payload},
+			"Authorization":             {"Bearer secret"},
+			"X-Region":                  {"eu"},
+		})
+		_, err := client.Ping(ctx, &streamingv1.PingRequest{Payload: payload})
+		return err
+	}
+
+	if err := ping("ok"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ping("missing 1"); streamingv1.CodeOf(err) != streamingv1.CodeNotFound {
+		t.Fatalf("Ping = %v, want NOT_FOUND", err)
+	}
+	var id string
+	select {
+	case id = <-store.ids:
+	case <-time.After(5 * time.Second):
+		t.Fatal("failed Ping was not recorded")
+	}
+
+	rec, err := store.Load(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var req streamingv1.PingRequest
+	if err := rec.DecodeRequest(&req); err != nil || req.Payload != "missing 1" {
+		t.Errorf("recorded request = %v, %v; want the failed Ping's", &req, err)
+	}
+	if rec.ID != id || rec.Service != "StreamDemoService" || rec.Method != "Ping" || rec.RequestID != "req-missing 1" ||
+		rec.Code != streamingv1.CodeNotFound.String() || !strings.Contains(rec.Error, "no account missing 1") {
+		t.Errorf("record = %+v, want the failed Ping", rec)
+	}
+	if rec.Headers.Get("X-Region") != "eu" || rec.Headers.Get("Authorization") != "" {
+		t.Errorf("recorded headers = %v, want them without Authorization", rec.Headers)
+	}
+	if rec.Timeout <= 0 || rec.Timeout > 10*time.Second {
+		t.Errorf("recorded timeout = %s, want the time left of the 10s client timeout", rec.Timeout)
+	}
+
+	// Two records an hour: the second failure is recorded, the third is not
+	for _, payload := range []string{"missing 2", "missing 3"} {
+		if err := ping(payload); err == nil {
+			t.Fatalf("Ping(%s) succeeded", payload)
+		}
+	}
+	select {
+	case <-store.ids:
+	case <-time.After(5 * time.Second):
+		t.Fatal("second failed Ping was not recorded")
+	}
+	select {
+	case id := <-store.ids:
+		t.Errorf("failure over the limit recorded as %s", id)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	t.Run("replay still failing", func(t *testing.T) {
+		replay, err := streamingv1.ReplayStreamDemoServiceFailure(context.Background(), store, id, failing)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if replay.Diverged || replay.Code != streamingv1.CodeNotFound.String() || replay.Divergence() != "" {
+			t.Errorf("replay = %+v, want the recorded failure again", replay)
+		}
+	})
+
+	t.Run("replay fixed", func(t *testing.T) {
+		fixed := &streamDemo{ping: func(ctx context.Context, req *streamingv1.PingRequest) (*streamingv1.PingResponse, error) {
+			// The replayed call carries the recorded headers and deadline
+			if _, ok := ctx.Deadline(); !ok || streamingv1.IncomingHeaders(ctx).Get("X-Region") != "eu" {
+				return nil, streamingv1.NewStatus(streamingv1.CodeFailedPrecondition, "replayed without the recorded call's context")
+			}
+			return &streamingv1.PingResponse{Payload: req.Payload}, nil
+		}}
+		replay, err := streamingv1.ReplayStreamDemoServiceFailure(context.Background(), store, id, fixed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, _ := replay.Response.(*streamingv1.PingResponse)
+		if !replay.Diverged || replay.Code != "OK" || resp == nil || resp.Payload != "missing 1" {
+			t.Errorf("replay = %+v, want it to diverge with a response", replay)
+		}
+		if want := "StreamDemoService.Ping recorded [NOT_FOUND] no account missing 1, replayed OK"; replay.Divergence() != want {
+			t.Errorf("Divergence = %q, want %q", replay.Divergence(), want)
+		}
+	})
+
+	if _, err := streamingv1.ReplayStreamDemoServiceFailure(context.Background(), store, "no-such-record", failing); err == nil {
+		t.Error("replay of a missing record succeeded")
+	}
+}
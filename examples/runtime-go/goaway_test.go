@@ -0,0 +1,190 @@
+package runtimetest
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	streamingv1 "example/gen/streaming/v1"
+)
+
+// counter serves CountUp with resume tokens, starting after ResumeFrom when set.
+// With step set, it waits for a value on step before each number.
+type counter struct {
+	step   chan struct{}
+	mu     sync.Mutex
+	resume []string   // ResumeFrom of each stream opened
+	cause  chan error // Why the handler's context ended, if it did
+}
+
+func newCounter(paced bool) *counter {
+	c := &counter{cause: make(chan error, 1)}
+	if paced {
+		c.step = make(chan struct{})
+	}
+	return c
+}
+
+func (c *counter) countUp(ctx context.Context, req *streamingv1.CountUpRequest, stream *streamingv1.StreamDemoService_CountUp_Stream) error {
+	start := req.Start
+	token := stream.Options().ResumeFrom
+	c.mu.Lock()
+	c.resume = append(c.resume, token)
+	c.mu.Unlock()
+	if token != "" {
+		last, err := strconv.Atoi(token)
+		if err != nil {
+			return err
+		}
+		start = int32(last) + 1
+	}
+	for n := start; n < req.Start+req.Count; n++ {
+		if c.step != nil {
+			select {
+			case <-c.step:
+			case <-ctx.Done():
+				c.cause <- context.Cause(ctx)
+				return ctx.Err()
+			}
+		}
+		stream.SetResumeToken(strconv.Itoa(int(n)))
+		if err := stream.Send(&streamingv1.CountUpResponse{Number: n}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// TestStreamDrain drains a service with a CountUp stream open, and checks the GOAWAY
+// the client reads, the grace period, and resuming the stream on another instance
+func TestStreamDrain(t *testing.T) {
+	url := startServer(t, nil)
+
+	// open starts CountUp from 0 to 5 on a paced counter and reads the first two numbers
+	open := func(t *testing.T, impl *counter, opts ...streamingv1.NatsClientOption) *streamingv1.StreamDemoService_CountUp_ClientStream {
+		t.Helper()
+		client := streamingv1.NewStreamDemoServiceNatsClient(connect(t, url), opts...)
+		stream, err := client.CountUp(context.Background(), &streamingv1.CountUpRequest{Start: 0, Count: 5})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { stream.Close() })
+		for want := range int32(2) {
+			impl.step <- struct{}{}
+			if resp, err := stream.Recv(context.Background()); err != nil || resp.Number != want {
+				t.Fatalf("Recv = %v, %v; want %d", resp, err, want)
+			}
+		}
+		return stream
+	}
+	drain := func(svc streamingv1.StreamDemoServiceService) <-chan error {
+		drained := make(chan error, 1)
+		go func() { drained <- svc.Drain(context.Background()) }()
+		return drained
+	}
+	goAway := func(t *testing.T, stream *streamingv1.StreamDemoService_CountUp_ClientStream) *streamingv1.ErrServerDraining {
+		t.Helper()
+		resp, err := stream.Recv(context.Background())
+		var draining *streamingv1.ErrServerDraining
+		if !errors.As(err, &draining) {
+			t.Fatalf("Recv during Drain = %v, %v; want an *ErrServerDraining", resp, err)
+		}
+		return draining
+	}
+
+	t.Run("grace period", func(t *testing.T) {
+		impl := newCounter(true)
+		svc := serveStreamDemo(t, connect(t, url), &streamDemo{countUp: impl.countUp}, streamingv1.WithStreamDrainGrace(time.Minute))
+		stream := open(t, impl)
+		drained := drain(svc)
+		if draining := goAway(t, stream); time.Until(draining.Deadline) < 50*time.Second {
+			t.Errorf("GOAWAY deadline %s, want about a minute away", draining.Deadline)
+		}
+
+		// The handler carries on, and the client reads the rest of the stream
+		close(impl.step)
+		for want := int32(2); want < 5; want++ {
+			if resp, err := stream.Recv(context.Background()); err != nil || resp.Number != want {
+				t.Fatalf("Recv after the GOAWAY = %v, %v; want %d", resp, err, want)
+			}
+		}
+		if _, err := stream.Recv(context.Background()); !errors.Is(err, streamingv1.ErrStreamEOF) {
+			t.Errorf("Recv at the end = %v, want ErrStreamEOF", err)
+		}
+		if err := <-drained; err != nil {
+			t.Errorf("Drain = %v", err)
+		}
+	})
+
+	t.Run("client closes", func(t *testing.T) {
+		impl := newCounter(true)
+		svc := serveStreamDemo(t, connect(t, url), &streamDemo{countUp: impl.countUp}, streamingv1.WithStreamDrainGrace(time.Minute))
+		stream := open(t, impl)
+		drained := drain(svc)
+		goAway(t, stream)
+
+		// Closing acknowledges the GOAWAY, so the handler need not wait out the grace period
+		stream.Close()
+		select {
+		case <-impl.cause:
+		case <-time.After(5 * time.Second):
+			t.Fatal("handler's context did not end when the client closed the stream")
+		}
+		select {
+		case err := <-drained:
+			if err != nil {
+				t.Errorf("Drain = %v", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Drain waited for the grace period after the client closed the stream")
+		}
+	})
+
+	t.Run("no grace period", func(t *testing.T) {
+		impl := newCounter(true)
+		svc := serveStreamDemo(t, connect(t, url), &streamDemo{countUp: impl.countUp})
+		stream := open(t, impl)
+		drained := drain(svc)
+		goAway(t, stream)
+		select {
+		case <-impl.cause:
+		case <-time.After(5 * time.Second):
+			t.Fatal("handler's context did not end when Drain started")
+		}
+		if err := <-drained; err != nil {
+			t.Errorf("Drain = %v", err)
+		}
+	})
+
+	t.Run("resume elsewhere", func(t *testing.T) {
+		draining := newCounter(true)
+		svc := serveStreamDemo(t, connect(t, url), &streamDemo{countUp: draining.countUp})
+		stream := open(t, draining, streamingv1.WithClientStreamResumeOnDrain())
+
+		// Another instance comes up, and the first one drains
+		other := newCounter(false)
+		serveStreamDemo(t, connect(t, url), &streamDemo{countUp: other.countUp})
+		drained := drain(svc)
+
+		// Recv moves to the other instance without an error, after the last number read
+		for want := int32(2); want < 5; want++ {
+			if resp, err := stream.Recv(context.Background()); err != nil || resp.Number != want {
+				t.Fatalf("Recv across the drain = %v, %v; want %d", resp, err, want)
+			}
+		}
+		if _, err := stream.Recv(context.Background()); !errors.Is(err, streamingv1.ErrStreamEOF) {
+			t.Errorf("Recv at the end = %v, want ErrStreamEOF", err)
+		}
+		if err := <-drained; err != nil {
+			t.Errorf("Drain = %v", err)
+		}
+		other.mu.Lock()
+		defer other.mu.Unlock()
+		if len(other.resume) != 1 || other.resume[0] != "1" {
+			t.Errorf("other instance opened streams resuming from %q, want [\"1\"]", other.resume)
+		}
+	})
+}
@@ -0,0 +1,134 @@
+package runtimetest
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	runtimev1 "example/gen/runtime/v1"
+	streamingv1 "example/gen/streaming/v1"
+
+	"github.com/nats-io/nats.go"
+)
+
+// registration runs before as the last registration of a group, once the server
+// has the earlier ones' subscriptions, then registers next, or fails if next is nil
+type registration struct {
+	before func()
+	next   streamingv1.Registration
+}
+
+func (r registration) Register(nc *nats.Conn, hold func(context.Context) error) (streamingv1.GroupService, error) {
+	if err := nc.Flush(); err != nil {
+		return nil, err
+	}
+	r.before()
+	if r.next == nil {
+		return nil, errors.New("bucket unavailable")
+	}
+	return r.next.Register(nc, hold)
+}
+
+// TestRegisterGroup sends requests to the first services of a group while its last
+// registration runs, and checks that they wait for it: served once it succeeds,
+// refused with UNAVAILABLE without reaching a handler when it fails
+func TestRegisterGroup(t *testing.T) {
+	url := startServer(t, nil)
+	server, caller := connect(t, url), connect(t, url)
+	client := streamingv1.NewStreamDemoServiceNatsClient(caller, streamingv1.WithClientTimeout(5*time.Second))
+	const health = "api.v1.stream.stream_demo_service.health"
+
+	type result struct {
+		ping      error
+		health    *nats.Msg
+		healthErr error
+	}
+	var pings atomic.Int32
+	impl := &streamDemo{ping: func(ctx context.Context, req *streamingv1.PingRequest) (*streamingv1.PingResponse, error) {
+		pings.Add(1)
+		return &streamingv1.PingResponse{Payload: req.Payload}, nil
+	}}
+	// during calls Ping and the health endpoint of the registered StreamDemoService,
+	// and returns once they have been waiting a while
+	during := func(results chan<- result) func() {
+		return func() {
+			go func() {
+				var r result
+				var wg sync.WaitGroup
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					r.health, r.healthErr = caller.Request(health, nil, 5*time.Second)
+				}()
+				_, r.ping = client.Ping(context.Background(), &streamingv1.PingRequest{Payload: "early"})
+				wg.Wait()
+				results <- r
+			}()
+			time.Sleep(200 * time.Millisecond)
+			if len(results) != 0 || pings.Load() != 0 {
+				t.Error("a request was served before the group finished registering")
+			}
+		}
+	}
+
+	t.Run("registration fails", func(t *testing.T) {
+		results := make(chan result, 1)
+		group, err := streamingv1.RegisterGroup(server,
+			streamingv1.StreamDemoServiceRegistration(impl),
+			runtimev1.AccountServiceRegistration(&accounts{}),
+			registration{before: during(results)},
+		)
+		if err == nil || group != nil || !strings.Contains(err.Error(), "registration 3 of 3 failed: bucket unavailable") {
+			t.Fatalf("RegisterGroup = %v, %v; want the third registration's error", group, err)
+		}
+		r := <-results
+		if code := streamingv1.CodeOf(r.ping); code != streamingv1.CodeUnavailable {
+			t.Errorf("Ping waiting on the failed group = %v, want UNAVAILABLE", r.ping)
+		}
+		if r.healthErr != nil || r.health.Header.Get("Nats-Service-Error-Code") != streamingv1.CodeUnavailable.String() {
+			t.Errorf("health request waiting on the failed group = %v, %v; want UNAVAILABLE", r.health, r.healthErr)
+		}
+		if n := pings.Load(); n != 0 {
+			t.Errorf("Ping handler ran %d times in a group that failed to register", n)
+		}
+		if err := server.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		for _, subject := range []string{streamDemoSubject("Ping"), "runtime.account.get_balance"} {
+			if _, err := caller.Request(subject, nil, 5*time.Second); !errors.Is(err, nats.ErrNoResponders) {
+				t.Errorf("request to %s after the group failed = %v, want no responders", subject, err)
+			}
+		}
+	})
+
+	t.Run("registration succeeds", func(t *testing.T) {
+		results := make(chan result, 1)
+		group, err := streamingv1.RegisterGroup(server,
+			streamingv1.StreamDemoServiceRegistration(impl),
+			registration{before: during(results), next: runtimev1.AccountServiceRegistration(&accounts{})},
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { group.Stop() })
+
+		r := <-results
+		if r.ping != nil || r.healthErr != nil || r.health.Header.Get("Nats-Service-Error-Code") != "" {
+			t.Errorf("requests waiting on the group = %v, %v, %v; want them served", r.ping, r.health, r.healthErr)
+		}
+		if len(group.Services()) != 2 {
+			t.Errorf("group has %d services, want 2", len(group.Services()))
+		}
+		var accountEndpoint bool
+		for _, e := range group.Endpoints() {
+			accountEndpoint = accountEndpoint || e.Subject == "runtime.account.get_balance"
+		}
+		if !accountEndpoint {
+			t.Error("group endpoints miss the AccountService ones")
+		}
+	})
+}
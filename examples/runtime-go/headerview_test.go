@@ -0,0 +1,76 @@
+package runtimetest
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"testing"
+
+	streamingv1 "example/gen/streaming/v1"
+
+	"github.com/nats-io/nats.go"
+)
+
+// TestClientHeaderViews calls through many WithHeaders views of one client at once,
+// and checks that each call and stream carries its own view's headers only, layered
+// under the context's outgoing headers
+func TestClientHeaderViews(t *testing.T) {
+	nc := connect(t, startServer(t, nil))
+	// Ping answers with the headers it got; CountUp fails unless X-Tenant is t<start>
+	serveStreamDemo(t, nc, &streamDemo{
+		ping: func(ctx context.Context, req *streamingv1.PingRequest) (*streamingv1.PingResponse, error) {
+			headers := streamingv1.IncomingHeaders(ctx)
+			return &streamingv1.PingResponse{Payload: req.Payload + ":" + headers.Get("X-Tenant") + ":" + headers.Get("X-Region")}, nil
+		},
+		countUp: func(ctx context.Context, req *streamingv1.CountUpRequest, stream *streamingv1.StreamDemoService_CountUp_Stream) error {
+			if tenant, want := streamingv1.IncomingHeaders(ctx).Get("X-Tenant"), fmt.Sprintf("t%d", req.Start); tenant != want {
+				return streamingv1.Statusf(streamingv1.CodePermissionDenied, "stream of %s opened by %q", want, tenant)
+			}
+			return stream.Send(&streamingv1.CountUpResponse{Number: req.Start})
+		},
+	})
+	if err := nc.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	client := streamingv1.NewStreamDemoServiceNatsClient(nc)
+	ping := func(t *testing.T, client streamingv1.StreamDemoServiceNatsClientInterface, ctx context.Context, want string) {
+		t.Helper()
+		if resp, err := client.Ping(ctx, &streamingv1.PingRequest{Payload: "p"}); err != nil || resp.Payload != "p:"+want {
+			t.Errorf("Ping = %v, %v; want headers %q", resp, err, want)
+		}
+	}
+
+	t.Run("concurrent views", func(t *testing.T) {
+		var wg sync.WaitGroup
+		for i := range 100 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				tenant := "t" + strconv.Itoa(i)
+				view := client.WithHeaders(nats.Header{"X-Tenant": {tenant}})
+				ping(t, view, context.Background(), tenant+":")
+				stream, err := view.CountUp(context.Background(), &streamingv1.CountUpRequest{Start: int32(i), Count: 1})
+				if err != nil {
+					t.Errorf("CountUp as %s = %v", tenant, err)
+					return
+				}
+				defer stream.Close()
+				if resp, err := stream.Recv(context.Background()); err != nil || resp.Number != int32(i) {
+					t.Errorf("Recv as %s = %v, %v", tenant, resp, err)
+				}
+			}()
+		}
+		wg.Wait()
+	})
+
+	t.Run("layering", func(t *testing.T) {
+		headers := nats.Header{"X-Tenant": {"acme"}, "X-Region": {"eu"}}
+		view := client.WithHeaders(headers)
+		headers.Set("X-Tenant", "changed") // The view copied the map
+		ping(t, view, context.Background(), "acme:eu")
+		ping(t, view.WithHeaders(nats.Header{"X-Tenant": {"globex"}}), context.Background(), "globex:eu")
+		ping(t, view, streamingv1.WithOutgoingHeaders(context.Background(), nats.Header{"X-Tenant": {"initech"}}), "initech:eu")
+		ping(t, client, context.Background(), ":") // The client itself is untouched
+	})
+}
@@ -0,0 +1,77 @@
+package runtimetest
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	streamingv1 "example/gen/streaming/v1"
+
+	"github.com/nats-io/nats.go"
+)
+
+// checkedStreamDemo is a streamDemo that reports its health
+type checkedStreamDemo struct {
+	streamDemo
+	err error
+}
+
+func (s *checkedStreamDemo) Healthy(context.Context) error { return s.err }
+
+// TestHealthEndpoint checks what the generated health endpoint reports for
+// services with and without a HealthChecker, and that it can be turned off
+func TestHealthEndpoint(t *testing.T) {
+	ping := streamingv1.NewStreamDemoServiceNatsClient(nil).Endpoints()[0].Subject
+	subject := ping[:strings.LastIndex(ping, ".")] + ".stream_demo_service.health"
+
+	for _, tt := range []struct {
+		name string
+		impl streamingv1.StreamDemoServiceNats
+		// Wire form of the health response
+		want string
+	}{
+		{"default", &streamDemo{}, `{"status":"SERVING"}`},
+		{"healthy", &checkedStreamDemo{}, `{"status":"SERVING"}`},
+		{"unhealthy", &checkedStreamDemo{err: errors.New("boom")}, `{"status":"NOT_SERVING","details":{"error":"boom"}}`},
+		{"dependencies", &checkedStreamDemo{err: streamingv1.DependencyErrors{"db": errors.New("down"), "cache": errors.New("slow")}}, `{"status":"NOT_SERVING","details":{"cache":"slow","db":"down"}}`},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			nc := connect(t, startServer(t, nil))
+			svc, err := streamingv1.RegisterStreamDemoServiceHandlers(nc, tt.impl)
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { svc.Stop() })
+
+			msg, err := nc.Request(subject, nil, nats.DefaultTimeout)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(msg.Data) != tt.want {
+				t.Errorf("health response = %s, want %s", msg.Data, tt.want)
+			}
+
+			resp, err := streamingv1.NewStreamDemoServiceNatsClient(nc).Health(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			wantStatus := streamingv1.HealthServing
+			if strings.Contains(tt.want, "NOT_SERVING") {
+				wantStatus = streamingv1.HealthNotServing
+			}
+			if resp.Status != wantStatus {
+				t.Errorf("Health().Status = %q, want %q", resp.Status, wantStatus)
+			}
+		})
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		nc := connect(t, startServer(t, nil))
+		serveStreamDemo(t, nc, &streamDemo{}, streamingv1.WithoutHealthEndpoint())
+		_, err := streamingv1.NewStreamDemoServiceNatsClient(nc).Health(context.Background())
+		if !errors.Is(err, nats.ErrNoResponders) {
+			t.Errorf("Health() = %v, want nats.ErrNoResponders", err)
+		}
+	})
+}
@@ -0,0 +1,192 @@
+package runtimetest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	streamingv1 "example/gen/streaming/v1"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"google.golang.org/protobuf/proto"
+)
+
+// reply is a Ping answer as an idempotency-aware caller sees it
+type reply struct {
+	payload  string
+	replayed bool   // Idempotent-Replayed was set
+	code     string // Error code, "" on success
+	err      error  // Transport error
+}
+
+// TestIdempotencyKeys runs Ping with idempotency keys stored in a JetStream KV
+// bucket, and checks replays, duplicates arriving while the first request runs,
+// errors releasing the key, and a hung claim being taken over when its lease ends
+func TestIdempotencyKeys(t *testing.T) {
+	url := startServer(t, nil)
+	nc := connect(t, url)
+	js, err := jetstream.New(nc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kv, err := js.CreateKeyValue(context.Background(), jetstream.KeyValueConfig{Bucket: "runtime_idempotency"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// serve registers ping with the store, on a connection of its own
+	serve := func(t *testing.T, ping func(context.Context, *streamingv1.PingRequest) (*streamingv1.PingResponse, error), opts ...streamingv1.RegisterOption) streamingv1.StreamDemoServiceService {
+		t.Helper()
+		server := connect(t, url)
+		svc := serveStreamDemo(t, server, &streamDemo{ping: ping}, append(opts, streamingv1.WithIdempotencyStore(kv, time.Minute))...)
+		if err := server.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		return svc
+	}
+	// call sends Ping with key, keeping the response headers a generated client hides
+	call := func(key, payload string, timeout time.Duration) (r reply) {
+		msg := nats.NewMsg(streamDemoSubject("Ping"))
+		msg.Header.Set(streamingv1.ContentTypeHeader, streamingv1.ContentTypeProtobuf)
+		msg.Header.Set(streamingv1.IdempotencyKeyHeader, key)
+		msg.Data, _ = proto.Marshal(&streamingv1.PingRequest{Payload: payload})
+		resp, err := nc.RequestMsg(msg, timeout)
+		if err != nil {
+			return reply{err: err}
+		}
+		if r.code = resp.Header.Get("Nats-Service-Error-Code"); r.code != "" {
+			return r
+		}
+		var ping streamingv1.PingResponse
+		if r.err = proto.Unmarshal(resp.Data, &ping); r.err == nil {
+			r.payload, r.replayed = ping.Payload, resp.Header.Get(streamingv1.IdempotentReplayHeader) == "true"
+		}
+		return r
+	}
+	// numbered answers with the payload and the number of the run
+	numbered := func(runs *atomic.Int32) func(context.Context, *streamingv1.PingRequest) (*streamingv1.PingResponse, error) {
+		return func(ctx context.Context, req *streamingv1.PingRequest) (*streamingv1.PingResponse, error) {
+			return &streamingv1.PingResponse{Payload: fmt.Sprintf("%s #%d", req.Payload, runs.Add(1))}, nil
+		}
+	}
+
+	t.Run("replay", func(t *testing.T) {
+		var runs atomic.Int32
+		serve(t, numbered(&runs))
+		client := streamingv1.NewStreamDemoServiceNatsClient(nc)
+		resp, err := client.Ping(streamingv1.WithIdempotencyKey(context.Background(), "order-1"), &streamingv1.PingRequest{Payload: "a"})
+		if err != nil || resp.Payload != "a #1" {
+			t.Fatalf("first Ping = %v, %v; want a #1", resp, err)
+		}
+		if r := call("order-1", "a", 5*time.Second); r != (reply{payload: "a #1", replayed: true}) {
+			t.Errorf("retry = %+v, want the first response replayed", r)
+		}
+		if r := call("order-1", "b", 5*time.Second); r.code != streamingv1.CodeInvalidArgument.String() {
+			t.Errorf("key reused for another request = %+v, want INVALID_ARGUMENT", r)
+		}
+		if r := call("order-2", "a", 5*time.Second); r != (reply{payload: "a #2"}) {
+			t.Errorf("Ping with another key = %+v, want a run of its own", r)
+		}
+		if n := runs.Load(); n != 2 {
+			t.Errorf("handler ran %d times for two keys", n)
+		}
+	})
+
+	t.Run("duplicate while running", func(t *testing.T) {
+		var runs atomic.Int32
+		started, release := make(chan struct{}, 2), make(chan struct{})
+		run := numbered(&runs)
+		// The pool lets the duplicate arrive while the first request's handler waits
+		serve(t, func(ctx context.Context, req *streamingv1.PingRequest) (*streamingv1.PingResponse, error) {
+			started <- struct{}{}
+			<-release
+			return run(ctx, req)
+		}, streamingv1.WithHandlerPool(2))
+
+		var wg sync.WaitGroup
+		replies := make([]reply, 2)
+		for i := range replies {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				replies[i] = call("order-3", "a", 10*time.Second)
+			}()
+			if i == 0 {
+				<-started
+			}
+		}
+		time.Sleep(200 * time.Millisecond) // The duplicate waits on the claim
+		close(release)
+		wg.Wait()
+
+		if n := runs.Load(); n != 1 {
+			t.Errorf("handler ran %d times for one key", n)
+		}
+		first, duplicate := replies[0], replies[1]
+		if first != (reply{payload: "a #1"}) || duplicate != (reply{payload: "a #1", replayed: true}) {
+			t.Errorf("replies = %+v and %+v, want a #1 and its replay", first, duplicate)
+		}
+	})
+
+	t.Run("error releases the key", func(t *testing.T) {
+		var runs atomic.Int32
+		run := numbered(&runs)
+		var failed atomic.Bool
+		serve(t, func(ctx context.Context, req *streamingv1.PingRequest) (*streamingv1.PingResponse, error) {
+			if !failed.Swap(true) {
+				runs.Add(1)
+				return nil, streamingv1.NewStatus(streamingv1.CodeUnavailable, "try again")
+			}
+			return run(ctx, req)
+		})
+
+		if r := call("order-4", "a", 5*time.Second); r.code != streamingv1.CodeUnavailable.String() {
+			t.Fatalf("first Ping = %+v, want UNAVAILABLE", r)
+		}
+		if r := call("order-4", "a", 5*time.Second); r != (reply{payload: "a #2"}) {
+			t.Errorf("retry after the error = %+v, want the handler to run again", r)
+		}
+		if r := call("order-4", "a", 5*time.Second); r != (reply{payload: "a #2", replayed: true}) {
+			t.Errorf("retry after the success = %+v, want it replayed", r)
+		}
+	})
+
+	t.Run("lease expires", func(t *testing.T) {
+		// The first instance claims the key and hangs; its claim lasts the service timeout
+		hung, returned := make(chan struct{}), make(chan struct{})
+		stuck := serve(t, func(ctx context.Context, req *streamingv1.PingRequest) (*streamingv1.PingResponse, error) {
+			defer close(returned)
+			<-hung
+			return &streamingv1.PingResponse{Payload: "late"}, nil
+		}, streamingv1.WithTimeout(time.Second))
+		claimed := time.Now()
+		if r := call("order-5", "a", 300*time.Millisecond); !errors.Is(r.err, nats.ErrTimeout) {
+			t.Fatalf("Ping to the hung instance = %+v, want a timeout", r)
+		}
+		if err := stuck.Stop(); err != nil {
+			t.Fatal(err)
+		}
+
+		// The retry waits out the lease on another instance, then runs there
+		var runs atomic.Int32
+		serve(t, numbered(&runs), streamingv1.WithTimeout(time.Second))
+		if r := call("order-5", "a", 10*time.Second); r != (reply{payload: "a #1"}) {
+			t.Errorf("retry = %+v, want a run on the new instance", r)
+		}
+		if waited := time.Since(claimed); waited < time.Second {
+			t.Errorf("retry ran %s after the claim, before its lease ended", waited)
+		}
+
+		// The hung handler's late answer does not replace the stored one
+		close(hung)
+		<-returned
+		if r := call("order-5", "a", 5*time.Second); r != (reply{payload: "a #1", replayed: true}) {
+			t.Errorf("Ping after the hung handler returned = %+v, want the new instance's response", r)
+		}
+	})
+}
@@ -0,0 +1,143 @@
+package runtimetest
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	runtimev1 "example/gen/runtime/v1"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// counters serves CounterService, adding to the value persisted under the counter's
+// key. The first two increments wait for each other after reading the revision, so
+// both run against the same one.
+type counters struct {
+	store runtimev1.CounterServiceNatsClientInterface
+	calls atomic.Int32
+	both  chan struct{}
+}
+
+func (c *counters) Increment(ctx context.Context, req *runtimev1.IncrementRequest) (*runtimev1.Counter, error) {
+	return c.increment(ctx, req)
+}
+
+func (c *counters) IncrementRetrying(ctx context.Context, req *runtimev1.IncrementRequest) (*runtimev1.Counter, error) {
+	return c.increment(ctx, req)
+}
+
+func (c *counters) increment(ctx context.Context, req *runtimev1.IncrementRequest) (*runtimev1.Counter, error) {
+	if _, ok := runtimev1.KVRevisionFromContext(ctx); !ok {
+		return nil, errors.New("no KV revision in the handler context")
+	}
+	var value int64
+	current, err := c.store.GetIncrementFromKV(ctx, "counter."+req.Id)
+	switch {
+	case err == nil:
+		value = current.Value
+	case !errors.Is(err, jetstream.ErrKeyNotFound):
+		return nil, err
+	}
+
+	switch c.calls.Add(1) {
+	case 1:
+		<-c.both
+	case 2:
+		close(c.both)
+	}
+	return &runtimev1.Counter{Id: req.Id, Value: value + req.By}, nil
+}
+
+// TestKVRevisionCheck races two increments of one counter, which read the same
+// revision, and checks that exactly one wins: the other is ABORTED, or with
+// retry_on_conflict runs again on the winner's value
+func TestKVRevisionCheck(t *testing.T) {
+	url := startServer(t, nil)
+	nc := connect(t, url)
+	js, err := jetstream.New(nc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := runtimev1.NewCounterServiceNatsClient(nc, runtimev1.WithNatsClientJetStream(js), runtimev1.WithClientTimeout(5*time.Second))
+
+	race := func(t *testing.T, id string, increment func(context.Context, *runtimev1.IncrementRequest, ...runtimev1.CallOption) (*runtimev1.Counter, error)) ([]int64, []error) {
+		// Each race gets its own service, on its own connection, gone before the next
+		server := connect(t, url)
+		svc, err := runtimev1.RegisterCounterServiceHandlers(server, &counters{store: client, both: make(chan struct{})},
+			runtimev1.WithJetStream(js), runtimev1.WithHandlerPool(2))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			if err := svc.Drain(context.Background()); err != nil {
+				t.Errorf("Drain = %v", err)
+			}
+		})
+		if err := server.Flush(); err != nil {
+			t.Fatal(err)
+		}
+
+		var mu sync.Mutex
+		var values []int64
+		var errs []error
+		var wg sync.WaitGroup
+		for range 2 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				resp, err := increment(context.Background(), &runtimev1.IncrementRequest{Id: id, By: 1})
+				mu.Lock()
+				defer mu.Unlock()
+				if err != nil {
+					errs = append(errs, err)
+				} else {
+					values = append(values, resp.Value)
+				}
+			}()
+		}
+		wg.Wait()
+		sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+		return values, errs
+	}
+	stored := func(t *testing.T, id string) int64 {
+		t.Helper()
+		counter, err := client.GetIncrementFromKV(context.Background(), "counter."+id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return counter.Value
+	}
+
+	t.Run("conflict", func(t *testing.T) {
+		values, errs := race(t, "a", client.Increment)
+		if len(values) != 1 || len(errs) != 1 || values[0] != 1 {
+			t.Fatalf("increments returned %v and errors %v, want one 1 and one error", values, errs)
+		}
+		if code := runtimev1.CodeOf(errs[0]); code != runtimev1.CodeAborted {
+			t.Errorf("losing increment failed with %v (%s), want ABORTED", errs[0], code)
+		}
+		if got := stored(t, "a"); got != 1 {
+			t.Errorf("stored counter = %d, want 1", got)
+		}
+
+		// The next increment reads the winner's revision
+		if resp, err := client.Increment(context.Background(), &runtimev1.IncrementRequest{Id: "a", By: 1}); err != nil || resp.Value != 2 {
+			t.Errorf("Increment after the race = %v, %v, want 2", resp, err)
+		}
+	})
+
+	t.Run("retry on conflict", func(t *testing.T) {
+		values, errs := race(t, "b", client.IncrementRetrying)
+		if len(errs) != 0 || len(values) != 2 || values[0] != 1 || values[1] != 2 {
+			t.Fatalf("increments returned %v and errors %v, want 1 and 2", values, errs)
+		}
+		if got := stored(t, "b"); got != 2 {
+			t.Errorf("stored counter = %d, want 2", got)
+		}
+	})
+}
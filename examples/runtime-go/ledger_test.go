@@ -0,0 +1,181 @@
+package runtimetest
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	runtimev1 "example/gen/runtime/v1"
+
+	"github.com/nats-io/nats.go"
+	"google.golang.org/protobuf/proto"
+)
+
+// big is 2^53+1, the first integer a float64, and so JavaScript, cannot hold
+const big = int64(1<<53 + 1)
+
+// ledger keeps the entries posted to LedgerService and NumericLedgerService
+type ledger struct {
+	mu      sync.Mutex
+	entries map[string][]*runtimev1.Entry
+}
+
+func (l *ledger) post(req *runtimev1.Entry) *runtimev1.Entry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.entries == nil {
+		l.entries = map[string][]*runtimev1.Entry{}
+	}
+	l.entries[req.Account] = append(l.entries[req.Account], req)
+	return req
+}
+
+func (l *ledger) replay(account string, send func(*runtimev1.Entry) error) error {
+	l.mu.Lock()
+	entries := l.entries[account]
+	l.mu.Unlock()
+	for _, entry := range entries {
+		if err := send(entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type ledgerService struct{ ledger }
+
+func (s *ledgerService) Post(ctx context.Context, req *runtimev1.Entry) (*runtimev1.Entry, error) {
+	return s.post(req), nil
+}
+
+func (s *ledgerService) Replay(ctx context.Context, req *runtimev1.ReplayRequest, stream *runtimev1.LedgerService_Replay_Stream) error {
+	return s.replay(req.Account, stream.Send)
+}
+
+type numericLedgerService struct{ ledger }
+
+func (s *numericLedgerService) Post(ctx context.Context, req *runtimev1.Entry) (*runtimev1.Entry, error) {
+	return s.post(req), nil
+}
+
+func (s *numericLedgerService) Replay(ctx context.Context, req *runtimev1.ReplayRequest, stream *runtimev1.NumericLedgerService_Replay_Stream) error {
+	return s.replay(req.Account, stream.Send)
+}
+
+// wiretap collects the payloads published on subject
+func wiretap(t *testing.T, nc *nats.Conn, subject string) func() []string {
+	t.Helper()
+	var mu sync.Mutex
+	var payloads []string
+	sub, err := nc.Subscribe(subject, func(msg *nats.Msg) {
+		if len(msg.Data) > 0 {
+			mu.Lock()
+			payloads = append(payloads, string(msg.Data))
+			mu.Unlock()
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sub.Unsubscribe() })
+	nc.Flush()
+	return func() []string {
+		nc.Flush()
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), payloads...)
+	}
+}
+
+// TestLedgerInt64 round-trips 64-bit values above 2^53 through a JSON service's
+// unary and stream paths, and checks their JSON form on the wire
+func TestLedgerInt64(t *testing.T) {
+	nc := connect(t, startServer(t, nil))
+	stringSvc, err := runtimev1.RegisterLedgerServiceHandlers(nc, &ledgerService{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stringSvc.Stop()
+	numberSvc, err := runtimev1.RegisterNumericLedgerServiceHandlers(nc, &numericLedgerService{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer numberSvc.Stop()
+
+	type ledgerClient interface {
+		Post(context.Context, *runtimev1.Entry, ...runtimev1.CallOption) (*runtimev1.Entry, error)
+	}
+	stringClient := runtimev1.NewLedgerServiceNatsClient(nc)
+	numericClient := runtimev1.NewNumericLedgerServiceNatsClient(nc)
+	for _, tt := range []struct {
+		name   string
+		client ledgerClient
+		replay func(context.Context, *runtimev1.ReplayRequest) (func(context.Context) (*runtimev1.Entry, error), error)
+		// Wire form of big in a JSON payload
+		want string
+	}{
+		{"strings", stringClient, func(ctx context.Context, req *runtimev1.ReplayRequest) (func(context.Context) (*runtimev1.Entry, error), error) {
+			stream, err := stringClient.Replay(ctx, req)
+			if err != nil {
+				return nil, err
+			}
+			return stream.Recv, nil
+		}, `"amountCents":"9007199254740993"`},
+		{"numbers", numericClient, func(ctx context.Context, req *runtimev1.ReplayRequest) (func(context.Context) (*runtimev1.Entry, error), error) {
+			stream, err := numericClient.Replay(ctx, req)
+			if err != nil {
+				return nil, err
+			}
+			return stream.Recv, nil
+		}, `"amountCents":9007199254740993`},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			entry := &runtimev1.Entry{Account: "acct-" + tt.name, AmountCents: big, Sequence: 1<<63 + 5, History: []int64{-big, big}}
+
+			requests := wiretap(t, nc, "runtime.*.post")
+			got, err := tt.client.Post(ctx, entry)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !proto.Equal(got, entry) {
+				t.Errorf("Post returned %v, want %v", got, entry)
+			}
+			if sent := requests(); len(sent) != 1 || !strings.Contains(compact(sent[0]), tt.want) {
+				t.Errorf("request on the wire = %q, want it to contain %s", sent, tt.want)
+			}
+
+			replies := wiretap(t, nc, "_INBOX.>")
+			recv, err := tt.replay(ctx, &runtimev1.ReplayRequest{Account: entry.Account})
+			if err != nil {
+				t.Fatal(err)
+			}
+			streamed, err := recv(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !proto.Equal(streamed, entry) {
+				t.Errorf("Replay sent %v, want %v", streamed, entry)
+			}
+			if _, err := recv(ctx); !errors.Is(err, runtimev1.ErrStreamEOF) {
+				t.Errorf("Recv after the last entry = %v, want ErrStreamEOF", err)
+			}
+			found := false
+			for _, payload := range replies() {
+				found = found || strings.Contains(compact(payload), tt.want)
+			}
+			if !found {
+				t.Errorf("stream messages on the wire = %q, want one containing %s", replies(), tt.want)
+			}
+		})
+	}
+}
+
+// compact drops the spaces protojson may put between a key and its value
+func compact(payload string) string {
+	return strings.ReplaceAll(payload, " ", "")
+}
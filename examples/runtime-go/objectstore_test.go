@@ -0,0 +1,142 @@
+package runtimetest
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"runtime"
+	"runtime/debug"
+	"testing"
+	"time"
+
+	kvstorev1 "example/gen/kvstore_demo/v1"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// reportSize is the size of the body each test report streams into its object
+const reportSize = 64 << 20
+
+// reportBody returns the body stored for report id: reportSize bytes that are
+// the same on every call
+func reportBody(id string) io.Reader {
+	var seed [32]byte
+	copy(seed[:], id)
+	return io.LimitReader(rand.NewChaCha8(seed), reportSize)
+}
+
+// reports serves KVStoreDemoService, streaming a large body into each report
+type reports struct{}
+
+func (reports) SaveProfile(ctx context.Context, req *kvstorev1.SaveProfileRequest) (*kvstorev1.ProfileResponse, error) {
+	return nil, errors.New("not used by the tests")
+}
+
+func (reports) GetProfile(ctx context.Context, req *kvstorev1.GetProfileRequest) (*kvstorev1.ProfileResponse, error) {
+	return nil, errors.New("not used by the tests")
+}
+
+func (reports) GenerateReport(ctx context.Context, req *kvstorev1.GenerateReportRequest) (*kvstorev1.ReportResponse, error) {
+	kvstorev1.SetObjectBody(ctx, reportBody(req.Id))
+	return &kvstorev1.ReportResponse{Id: req.Id, Title: req.Title, SizeBytes: reportSize}, nil
+}
+
+// TestOpenFromObjectStore streams a large persisted report back, checking its
+// checksum and that reading it doesn't load it into memory
+func TestOpenFromObjectStore(t *testing.T) {
+	nc := connect(t, startServer(t, nil))
+	js, err := jetstream.New(nc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc, err := kvstorev1.RegisterKVStoreDemoServiceHandlers(nc, reports{}, kvstorev1.WithJetStream(js))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { svc.Stop() })
+	client := kvstorev1.NewKVStoreDemoServiceNatsClient(nc, kvstorev1.WithNatsClientJetStream(js))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	if _, err := client.GenerateReport(ctx, &kvstorev1.GenerateReportRequest{Id: "q3", Title: "Q3"}, kvstorev1.WithCallTimeout(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("checksum", func(t *testing.T) {
+		want := sha256.New()
+		if _, err := io.Copy(want, reportBody("q3")); err != nil {
+			t.Fatal(err)
+		}
+
+		body, info, err := client.OpenGenerateReportFromObjectStore(ctx, "report.q3")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer body.Close()
+		if info.Response.GetTitle() != "Q3" || info.Key != "report.q3" || info.Size <= reportSize {
+			t.Errorf("info = %+v, want report q3 and its framed size", info)
+		}
+		if got := info.Headers.Get(kvstorev1.ObjectFramingHeader); got != "1" {
+			t.Errorf("framing header = %q, want 1", got)
+		}
+
+		got := sha256.New()
+		peak := copyMeasuringHeap(t, got, body)
+		if !bytes.Equal(got.Sum(nil), want.Sum(nil)) {
+			t.Error("streamed body does not match the stored one")
+		}
+		if limit := uint64(reportSize / 2); peak > limit {
+			t.Errorf("heap grew by %d bytes while streaming a %d byte body, want at most %d", peak, reportSize, limit)
+		}
+	})
+
+	t.Run("cancel mid-read", func(t *testing.T) {
+		readCtx, cancelRead := context.WithCancel(ctx)
+		body, _, err := client.OpenGenerateReportFromObjectStore(readCtx, "report.q3")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer body.Close()
+		if _, err := io.CopyN(io.Discard, body, 1<<20); err != nil {
+			t.Fatal(err)
+		}
+		cancelRead()
+		if _, err := io.Copy(io.Discard, body); !errors.Is(err, context.Canceled) {
+			t.Errorf("reading after cancel = %v, want context.Canceled", err)
+		}
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		_, _, err := client.OpenGenerateReportFromObjectStore(ctx, "report.missing")
+		if !kvstorev1.IsKVStoreDemoServiceNotFound(err) {
+			t.Errorf("Open = %v, want a NOT_FOUND service error", err)
+		}
+	})
+}
+
+// copyMeasuringHeap copies src to dst and returns by how much the heap grew
+// over its size before the copy, sampled every MiB
+func copyMeasuringHeap(t *testing.T, dst io.Writer, src io.Reader) uint64 {
+	t.Helper()
+	defer debug.SetGCPercent(debug.SetGCPercent(20)) // Collect early, so the heap tracks live data
+	runtime.GC()
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	base, peak := stats.HeapAlloc, uint64(0)
+	for {
+		_, err := io.CopyN(dst, src, 1<<20)
+		runtime.ReadMemStats(&stats)
+		if stats.HeapAlloc > base {
+			peak = max(peak, stats.HeapAlloc-base)
+		}
+		if err == io.EOF {
+			return peak
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package runtimetest
+
+import (
+	"context"
+	"testing"
+
+	streamingv1 "example/gen/streaming/v1"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TestOTel traces calls with the generated interceptors on both sides into an
+// in-memory exporter, and checks that the server span continues the client's trace
+func TestOTel(t *testing.T) {
+	nc := connect(t, startServer(t, nil))
+	spans := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(spans))
+	t.Cleanup(func() { provider.Shutdown(context.Background()) })
+
+	serveStreamDemo(t, nc, &streamDemo{ping: func(ctx context.Context, req *streamingv1.PingRequest) (*streamingv1.PingResponse, error) {
+		if req.Payload == "missing" {
+			return nil, streamingv1.NewStreamDemoServiceNotFoundError("Ping", "no such payload")
+		}
+		return &streamingv1.PingResponse{Payload: req.Payload}, nil
+	}}, streamingv1.WithServerInterceptor(streamingv1.NewOTelServerInterceptor(provider.Tracer("server"))))
+	client := streamingv1.NewStreamDemoServiceNatsClient(nc,
+		streamingv1.WithClientInterceptor(streamingv1.NewOTelClientInterceptor(provider.Tracer("client"))))
+	subject := streamDemoSubject("Ping")
+
+	for _, tt := range []struct {
+		payload string
+		code    string // Status code recorded on failed spans
+	}{
+		{"hello", ""},
+		{"missing", "NOT_FOUND"},
+	} {
+		t.Run(tt.payload, func(t *testing.T) {
+			spans.Reset()
+			requests := msgTap(t, nc, subject)
+			_, err := client.Ping(context.Background(), &streamingv1.PingRequest{Payload: tt.payload})
+			if (err != nil) != (tt.code != "") {
+				t.Fatalf("Ping(%s) = %v", tt.payload, err)
+			}
+
+			ended := spans.GetSpans()
+			if len(ended) != 2 {
+				t.Fatalf("recorded %d spans, want a client and a server span", len(ended))
+			}
+			var clientSpan, serverSpan tracetest.SpanStub
+			for _, span := range ended {
+				switch span.SpanKind {
+				case trace.SpanKindClient:
+					clientSpan = span
+				case trace.SpanKindServer:
+					serverSpan = span
+				}
+			}
+
+			for _, span := range []tracetest.SpanStub{clientSpan, serverSpan} {
+				if span.Name != "StreamDemoService/Ping" {
+					t.Errorf("%s span named %q, want StreamDemoService/Ping", span.SpanKind, span.Name)
+				}
+				attrs := attribute.NewSet(span.Attributes...)
+				if v, _ := attrs.Value("messaging.system"); v.AsString() != "nats" {
+					t.Errorf("%s span messaging.system = %q, want nats", span.SpanKind, v.AsString())
+				}
+				if v, _ := attrs.Value("messaging.destination"); v.AsString() != subject {
+					t.Errorf("%s span messaging.destination = %q, want %q", span.SpanKind, v.AsString(), subject)
+				}
+				v, _ := attrs.Value("rpc.nats.status_code")
+				if tt.code == "" && span.Status.Code != codes.Unset {
+					t.Errorf("%s span status = %v, want unset", span.SpanKind, span.Status)
+				}
+				if tt.code != "" && (span.Status.Code != codes.Error || v.AsString() != tt.code || len(span.Events) == 0) {
+					t.Errorf("%s span status = %v, code %q, events %v, want the %s error recorded", span.SpanKind, span.Status, v.AsString(), span.Events, tt.code)
+				}
+			}
+
+			// The server span is the client span's child, linked by the traceparent header
+			if !serverSpan.Parent.IsRemote() || serverSpan.Parent.SpanID() != clientSpan.SpanContext.SpanID() ||
+				serverSpan.SpanContext.TraceID() != clientSpan.SpanContext.TraceID() {
+				t.Errorf("server span parent %v, trace %v; want the client span %v", serverSpan.Parent, serverSpan.SpanContext.TraceID(), clientSpan.SpanContext)
+			}
+			sent := requests()
+			want := "00-" + clientSpan.SpanContext.TraceID().String() + "-" + clientSpan.SpanContext.SpanID().String() + "-01"
+			if len(sent) != 1 || sent[0].Header.Get("traceparent") != want {
+				t.Errorf("request traceparent = %v, want %q", sent, want)
+			}
+		})
+	}
+}
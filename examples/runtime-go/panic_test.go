@@ -0,0 +1,79 @@
+package runtimetest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	streamingv1 "example/gen/streaming/v1"
+
+	"github.com/nats-io/nats.go"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// TestPanicDiagnostics panics in Ping more often than the report limit allows, and
+// checks that callers get INTERNAL, the service keeps serving, and the reports made
+// describe the panic
+func TestPanicDiagnostics(t *testing.T) {
+	nc := connect(t, startServer(t, nil))
+	reports := make(chan streamingv1.PanicReport, 10)
+	serveStreamDemo(t, nc, &streamDemo{ping: func(ctx context.Context, req *streamingv1.PingRequest) (*streamingv1.PingResponse, error) {
+		if strings.HasPrefix(req.Payload, "panic") {
+			panic(fmt.Errorf("boom: %s", req.Payload))
+		}
+		return &streamingv1.PingResponse{Payload: req.Payload}, nil
+	}}, streamingv1.WithPanicDiagnostics(2, func(r streamingv1.PanicReport) { reports <- r }))
+	if err := nc.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	client := streamingv1.NewStreamDemoServiceNatsClient(nc)
+
+	for i := range 4 {
+		payload := fmt.Sprintf("panic %d", i)
+		ctx := streamingv1.WithOutgoingHeaders(context.Background(), nats.Header{streamingv1.RequestIDHeader: {fmt.Sprintf("req-%d", i)}})
+		_, err := client.Ping(ctx, &streamingv1.PingRequest{Payload: payload})
+		var st *streamingv1.Status
+		if !errors.As(err, &st) || st.Code != streamingv1.CodeInternal || st.Message != "internal error: handler panicked" {
+			t.Errorf("Ping(%s) = %v, want INTERNAL without the panic value", payload, err)
+		}
+		if resp, err := client.Ping(context.Background(), &streamingv1.PingRequest{Payload: "ok"}); err != nil || resp.Payload != "ok" {
+			t.Fatalf("Ping after a panic = %v, %v", resp, err)
+		}
+	}
+
+	// Only the first two panics fit the limit of two an hour
+	for i := range 2 {
+		var r streamingv1.PanicReport
+		select {
+		case r = <-reports:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("got %d panic reports, want 2", i)
+		}
+		var request streamingv1.PingRequest
+		if err := protojson.Unmarshal(r.Request, &request); err != nil {
+			t.Fatalf("report request %s: %v", r.Request, err)
+		}
+		payload := request.Payload
+		if r.Method != "Ping" || r.FullMethod != "streaming.v1.StreamDemoService.Ping" || r.Panic != "boom: "+payload ||
+			r.RequestID != "req-"+strings.TrimPrefix(payload, "panic ") || r.Suppressed != 0 {
+			t.Errorf("report = %+v, want Ping's panic on %q", r, payload)
+		}
+		if err, ok := r.Value.(error); !ok || err.Error() != r.Panic {
+			t.Errorf("report value = %#v, want the error panicked with", r.Value)
+		}
+		if !strings.Contains(r.Stack, "TestPanicDiagnostics") {
+			t.Errorf("report stack does not reach the handler:\n%s", r.Stack)
+		}
+		if !strings.Contains(r.Goroutines, "goroutine ") {
+			t.Errorf("report goroutine dump = %q", r.Goroutines)
+		}
+	}
+	select {
+	case r := <-reports:
+		t.Errorf("report past the limit: %+v", r)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
@@ -0,0 +1,132 @@
+package runtimetest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	streamingv1 "example/gen/streaming/v1"
+
+	"github.com/nats-io/nats.go"
+)
+
+// connectN opens n connections to url
+func connectN(t testing.TB, url string, n int) []*nats.Conn {
+	t.Helper()
+	conns := make([]*nats.Conn, n)
+	for i := range conns {
+		conns[i] = connect(t, url)
+	}
+	return conns
+}
+
+// TestClientPool makes concurrent calls through a pooled client, which -race checks
+// for data races, and checks that calls are spread round-robin while a stream stays
+// on the connection it opened on
+func TestClientPool(t *testing.T) {
+	url := startServer(t, nil)
+	serveStreamDemo(t, connect(t, url), &streamDemo{})
+	conns := connectN(t, url, 3)
+
+	t.Run("unary", func(t *testing.T) {
+		client := streamingv1.NewStreamDemoServiceNatsClientPool(conns)
+		before := outMsgs(conns)
+
+		const calls = 90
+		var wg sync.WaitGroup
+		for i := range calls {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				payload := fmt.Sprint(i)
+				resp, err := client.Ping(context.Background(), &streamingv1.PingRequest{Payload: payload})
+				if err != nil || resp.GetPayload() != payload {
+					t.Errorf("Ping(%s) = %v, %v", payload, resp, err)
+				}
+			}()
+		}
+		wg.Wait()
+
+		for i, sent := range outMsgs(conns) {
+			if got := sent - before[i]; got != calls/uint64(len(conns)) {
+				t.Errorf("conn %d sent %d requests, want %d", i, got, calls/len(conns))
+			}
+		}
+	})
+
+	t.Run("stream", func(t *testing.T) {
+		var picks atomic.Int32
+		roundRobin := streamingv1.RoundRobinConns(conns)
+		client := streamingv1.NewStreamDemoServiceNatsClient(nil, streamingv1.WithConnSelector(func() *nats.Conn {
+			picks.Add(1)
+			return roundRobin()
+		}))
+		before := outMsgs(conns)
+
+		ctx := context.Background()
+		stream, err := client.CountUp(ctx, &streamingv1.CountUpRequest{Count: 50})
+		if err != nil {
+			t.Fatal(err)
+		}
+		received := 0
+		for {
+			_, err := stream.Recv(ctx)
+			if errors.Is(err, streamingv1.ErrStreamEOF) {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			received++
+		}
+
+		if received != 50 {
+			t.Errorf("received %d messages, want 50", received)
+		}
+		if n := picks.Load(); n != 1 {
+			t.Errorf("selector called %d times for one stream, want 1", n)
+		}
+		used := 0
+		for i, sent := range outMsgs(conns) {
+			if sent != before[i] {
+				used++
+			}
+		}
+		if used != 1 {
+			t.Errorf("stream sent on %d connections, want 1", used)
+		}
+	})
+}
+
+func outMsgs(conns []*nats.Conn) []uint64 {
+	out := make([]uint64, len(conns))
+	for i, nc := range conns {
+		out[i] = nc.Stats().OutMsgs
+	}
+	return out
+}
+
+// BenchmarkClientPool compares parallel unary calls over one connection with the
+// same calls spread over a pool of four
+func BenchmarkClientPool(b *testing.B) {
+	url := startServer(b, nil)
+	serveStreamDemo(b, connect(b, url), &streamDemo{}, streamingv1.WithHandlerPool(64))
+
+	for _, n := range []int{1, 4} {
+		b.Run(fmt.Sprintf("conns=%d", n), func(b *testing.B) {
+			client := streamingv1.NewStreamDemoServiceNatsClientPool(connectN(b, url, n))
+			req := &streamingv1.PingRequest{Payload: "ping"}
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					if _, err := client.Ping(context.Background(), req); err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
+		})
+	}
+}
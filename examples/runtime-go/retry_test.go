@@ -0,0 +1,95 @@
+package runtimetest
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	streamingv1 "example/gen/streaming/v1"
+
+	"github.com/nats-io/nats.go"
+)
+
+// TestRetry checks that WithClientRetry retries no-responders failures, running
+// the client interceptors once per attempt, and returns service errors at once
+func TestRetry(t *testing.T) {
+	const failN = 2
+	nc := connect(t, startServer(t, nil))
+	noBackoff := func(int) time.Duration { return 0 }
+
+	t.Run("service comes up", func(t *testing.T) {
+		// The service is registered only once failN attempts have found no responders
+		var register sync.Once
+		var attempts []int
+		client := streamingv1.NewStreamDemoServiceNatsClient(nc,
+			streamingv1.WithClientRetry(4, noBackoff),
+			streamingv1.WithClientInterceptor(func(ctx context.Context, method string, req, reply interface{}, invoker streamingv1.UnaryInvoker) error {
+				attempt := streamingv1.RetryAttempt(ctx)
+				attempts = append(attempts, attempt)
+				if attempt > failN {
+					register.Do(func() { serveStreamDemo(t, nc, &streamDemo{}) })
+				}
+				return invoker(ctx, method, req, reply)
+			}),
+		)
+
+		resp, err := client.Ping(context.Background(), &streamingv1.PingRequest{Payload: "up"})
+		if err != nil {
+			t.Fatalf("Ping = %v, want success on attempt %d", err, failN+1)
+		}
+		if resp.Payload != "up" {
+			t.Errorf("Ping payload = %q, want %q", resp.Payload, "up")
+		}
+		if want := []int{1, 2, 3}; !slices.Equal(attempts, want) {
+			t.Errorf("interceptor saw attempts %v, want %v", attempts, want)
+		}
+	})
+
+	t.Run("attempts run out", func(t *testing.T) {
+		other := connect(t, startServer(t, nil))
+		var attempts int
+		client := streamingv1.NewStreamDemoServiceNatsClient(other,
+			streamingv1.WithClientRetry(failN, noBackoff),
+			streamingv1.WithClientInterceptor(func(ctx context.Context, method string, req, reply interface{}, invoker streamingv1.UnaryInvoker) error {
+				attempts++
+				return invoker(ctx, method, req, reply)
+			}),
+		)
+
+		_, err := client.Ping(context.Background(), &streamingv1.PingRequest{})
+		if !errors.Is(err, nats.ErrNoResponders) {
+			t.Fatalf("Ping = %v, want nats.ErrNoResponders", err)
+		}
+		if attempts != failN {
+			t.Errorf("made %d attempts, want %d", attempts, failN)
+		}
+	})
+
+	t.Run("service error", func(t *testing.T) {
+		other := connect(t, startServer(t, nil))
+		serveStreamDemo(t, other, &streamDemo{
+			ping: func(ctx context.Context, req *streamingv1.PingRequest) (*streamingv1.PingResponse, error) {
+				return nil, streamingv1.NewStreamDemoServiceNotFoundError("Ping", "no such payload")
+			},
+		})
+		var attempts int
+		client := streamingv1.NewStreamDemoServiceNatsClient(other,
+			streamingv1.WithClientRetry(4, noBackoff),
+			streamingv1.WithClientInterceptor(func(ctx context.Context, method string, req, reply interface{}, invoker streamingv1.UnaryInvoker) error {
+				attempts++
+				return invoker(ctx, method, req, reply)
+			}),
+		)
+
+		_, err := client.Ping(context.Background(), &streamingv1.PingRequest{})
+		if !streamingv1.IsStreamDemoServiceNotFound(err) {
+			t.Fatalf("Ping = %v, want a NOT_FOUND service error", err)
+		}
+		if attempts != 1 {
+			t.Errorf("made %d attempts, want service errors returned without a retry", attempts)
+		}
+	})
+}
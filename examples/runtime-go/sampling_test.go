@@ -0,0 +1,94 @@
+package runtimetest
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+
+	streamingv1 "example/gen/streaming/v1"
+)
+
+// samples collects what a sampling sink is given
+type samples struct {
+	mu   sync.Mutex
+	list []streamingv1.Sample
+}
+
+func (s *samples) sink(sample streamingv1.Sample) {
+	s.mu.Lock()
+	s.list = append(s.list, sample)
+	s.mu.Unlock()
+}
+
+// take returns the samples collected so far and forgets them
+func (s *samples) take() []streamingv1.Sample {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := s.list
+	s.list = nil
+	return list
+}
+
+// TestSampling samples a quarter of Ping calls on a client and a service with the
+// same salt, and checks the share sampled, that both sides pick the same calls,
+// and that Reconfigure changes the rate of the running service
+func TestSampling(t *testing.T) {
+	nc := connect(t, startServer(t, nil))
+	var server, client samples
+	svc := serveStreamDemo(t, nc, &streamDemo{},
+		streamingv1.WithSampling(0.25, server.sink), streamingv1.WithSamplingSalt("runtime"))
+	caller := streamingv1.NewStreamDemoServiceNatsClient(nc,
+		streamingv1.WithClientSampling(0.25, client.sink), streamingv1.WithClientSamplingSalt("runtime"))
+	ping := func(n int) {
+		t.Helper()
+		for i := range n {
+			payload := strconv.Itoa(i)
+			if resp, err := caller.Ping(context.Background(), &streamingv1.PingRequest{Payload: payload}); err != nil || resp.Payload != payload {
+				t.Fatalf("Ping(%s) = %v, %v", payload, resp, err)
+			}
+		}
+	}
+
+	const calls = 800
+	ping(calls)
+	onServer, onClient := server.take(), client.take()
+	// 200 expected; the bounds are more than five standard deviations out
+	if n := len(onServer); n < 140 || n > 260 {
+		t.Errorf("service sampled %d of %d calls at rate 0.25", n, calls)
+	}
+	ids := make(map[string]bool)
+	for _, s := range onServer {
+		ids[s.RequestID] = true
+		if s.Client || s.Method != "Ping" || s.Code != "OK" || s.Request == nil || s.Response == nil {
+			t.Fatalf("service sample = %+v, want a successful Ping with its request and response", s)
+		}
+	}
+	if len(onClient) != len(onServer) {
+		t.Errorf("client sampled %d calls, service %d; want the same calls", len(onClient), len(onServer))
+	}
+	for _, s := range onClient {
+		if !s.Client || !ids[s.RequestID] {
+			t.Errorf("client sampled call %s, which the service did not", s.RequestID)
+		}
+	}
+
+	// Reconfigure applies to the next calls
+	if err := svc.Reconfigure(streamingv1.WithSampling(1, server.sink)); err != nil {
+		t.Fatal(err)
+	}
+	ping(20)
+	if n := len(server.take()); n != 20 {
+		t.Errorf("service sampled %d of 20 calls after Reconfigure to rate 1", n)
+	}
+	if err := svc.Reconfigure(); err != nil {
+		t.Fatal(err)
+	}
+	ping(20)
+	if n := len(server.take()); n != 0 {
+		t.Errorf("service sampled %d calls after Reconfigure without WithSampling", n)
+	}
+	if err := svc.Reconfigure(streamingv1.WithHandlerPool(2)); err == nil {
+		t.Error("Reconfigure accepted an option that only applies at registration")
+	}
+}
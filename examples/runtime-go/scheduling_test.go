@@ -0,0 +1,111 @@
+package runtimetest
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	streamingv1 "example/gen/streaming/v1"
+
+	"github.com/nats-io/nats.go"
+)
+
+// TestDeadlineAwareScheduling queues Pings with different deadlines behind a slow
+// one on a single worker, and checks that they run nearest deadline first and that
+// expired requests are rejected without running
+func TestDeadlineAwareScheduling(t *testing.T) {
+	url := startServer(t, nil)
+	server, caller := connect(t, url), connect(t, url)
+	started, release := make(chan struct{}), make(chan struct{})
+	var mu sync.Mutex
+	var ran []string
+	svc := serveStreamDemo(t, server, &streamDemo{ping: func(ctx context.Context, req *streamingv1.PingRequest) (*streamingv1.PingResponse, error) {
+		mu.Lock()
+		ran = append(ran, req.Payload)
+		mu.Unlock()
+		if req.Payload == "slow" {
+			close(started)
+			<-release
+		}
+		return &streamingv1.PingResponse{Payload: req.Payload}, nil
+	}}, streamingv1.WithHandlerPool(1), streamingv1.WithDeadlineAwareScheduling())
+	if err := server.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	client := streamingv1.NewStreamDemoServiceNatsClient(caller)
+
+	var wg sync.WaitGroup
+	errs := make(map[string]error)
+	ping := func(payload string, timeout time.Duration) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+			_, err := client.Ping(ctx, &streamingv1.PingRequest{Payload: payload})
+			mu.Lock()
+			errs[payload] = err
+			mu.Unlock()
+		}()
+	}
+	ping("slow", 30*time.Second)
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("slow Ping never reached its handler")
+	}
+	ping("far", 20*time.Second)
+	ping("near", 10*time.Second)
+	ping("expiring", 300*time.Millisecond)
+	time.Sleep(500 * time.Millisecond) // The requests queue, and the expiring one's deadline passes
+	close(release)
+	wg.Wait()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(ran) != 3 || ran[0] != "slow" || ran[1] != "near" || ran[2] != "far" {
+		t.Errorf("handlers ran %v, want [slow near far]", ran)
+	}
+	for _, payload := range []string{"slow", "near", "far"} {
+		if errs[payload] != nil {
+			t.Errorf("Ping(%s) = %v", payload, errs[payload])
+		}
+	}
+	if errs["expiring"] == nil {
+		t.Error("Ping whose deadline passed in the queue succeeded")
+	}
+
+	// A request whose deadline passed before it arrived is refused at once
+	msg := nats.NewMsg(streamDemoSubject("Ping"))
+	msg.Header.Set("Nats-Deadline", time.Now().Add(-time.Second).UTC().Format(time.RFC3339Nano))
+	msg.Header.Set(streamingv1.ContentTypeHeader, streamingv1.ContentTypeProtobuf)
+	reply, err := caller.RequestMsg(msg, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code := reply.Header.Get("Nats-Service-Error-Code"); code != streamingv1.CodeDeadlineExceeded.String() {
+		t.Errorf("expired request answered with code %q, want %s", code, streamingv1.CodeDeadlineExceeded)
+	}
+
+	if stats := schedulingStats(t, svc, streamDemoSubject("Ping")); stats.DeadlineRejected != 2 || stats.Queued != 3 {
+		t.Errorf("Ping stats = %+v, want the 3 that ran queued and 2 rejected", stats)
+	}
+}
+
+// schedulingStats returns the SchedulingStats of svc's endpoint on subject
+func schedulingStats(t *testing.T, svc streamingv1.StreamDemoServiceService, subject string) streamingv1.SchedulingStats {
+	t.Helper()
+	for _, e := range svc.Stats().Endpoints {
+		if e.Subject == subject {
+			var stats streamingv1.SchedulingStats
+			if err := json.Unmarshal(e.Data, &stats); err != nil {
+				t.Fatal(err)
+			}
+			return stats
+		}
+	}
+	t.Fatalf("no stats for %s", subject)
+	return streamingv1.SchedulingStats{}
+}
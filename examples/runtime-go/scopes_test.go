@@ -0,0 +1,49 @@
+package runtimetest
+
+import (
+	"testing"
+
+	runtimev1 "example/gen/runtime/v1"
+)
+
+// TestRequiredScopes calls GetBalance, which requires the accounts:read scope,
+// with and without it, and checks that callers lacking it are refused with
+// PERMISSION_DENIED before the handler runs
+func TestRequiredScopes(t *testing.T) {
+	nc := connect(t, startServer(t, nil))
+	impl := &accounts{release: make(chan struct{})}
+	close(impl.release)
+	serveAccounts(t, nc, impl)
+	client := runtimev1.NewAccountServiceNatsClient(nc)
+
+	for _, tt := range []struct {
+		name, scopes string
+		allowed      bool
+	}{
+		{"scope held", "accounts:read", true},
+		{"among others", "profile,accounts:read accounts:write", true},
+		{"other scopes", "accounts:write", false},
+		{"no scopes", "", false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			before := impl.runs.Load()
+			resp, err := client.GetBalance(asCaller("acme", tt.scopes), &runtimev1.GetBalanceRequest{Account: "savings"})
+			ran := impl.runs.Load() != before
+			if tt.allowed {
+				if err != nil || resp.Account != "savings" || !ran {
+					t.Errorf("GetBalance = %v, %v (handler ran: %t); want the balance", resp, err, ran)
+				}
+				return
+			}
+			if code := runtimev1.CodeOf(err); code != runtimev1.CodePermissionDenied {
+				t.Errorf("GetBalance = %v, %v; want a %s error", resp, err, runtimev1.CodePermissionDenied)
+			}
+			if !runtimev1.IsAccountServicePermissionDenied(err) {
+				t.Errorf("error %v does not match IsAccountServicePermissionDenied", err)
+			}
+			if ran {
+				t.Error("handler ran for a caller without the scope")
+			}
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package runtimetest
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	streamingv1 "example/gen/streaming/v1"
+
+	"github.com/nats-io/nats.go/micro"
+)
+
+// TestSelfTest checks the self-test report against a full service, one missing
+// an endpoint, and no service at all
+func TestSelfTest(t *testing.T) {
+	expected := streamingv1.NewStreamDemoServiceNatsClient(nil).Endpoints()
+
+	t.Run("healthy", func(t *testing.T) {
+		nc := connect(t, startServer(t, nil))
+		serveStreamDemo(t, nc, &streamDemo{})
+
+		report := streamingv1.StreamDemoServiceSelfTest(context.Background(), nc)
+		if !report.OK || !report.Connected || report.Instance == "" || report.Error != "" {
+			t.Fatalf("report = %+v, want OK from a discovered instance", report)
+		}
+		if len(report.Endpoints) != len(expected) {
+			t.Errorf("report lists %d endpoints, want %d", len(report.Endpoints), len(expected))
+		}
+	})
+
+	t.Run("missing endpoint", func(t *testing.T) {
+		nc := connect(t, startServer(t, nil))
+		// An older build of the service that doesn't serve the first endpoint yet
+		svc, err := micro.AddService(nc, micro.Config{Name: "stream_demo_service", Version: "0.9.0"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { svc.Stop() })
+		for _, ep := range expected[1:] {
+			noop := micro.HandlerFunc(func(micro.Request) {})
+			if err := svc.AddEndpoint(ep.Name, noop, micro.WithEndpointSubject(ep.Subject)); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		report := streamingv1.StreamDemoServiceSelfTest(context.Background(), nc)
+		if report.OK || !report.Connected {
+			t.Fatalf("report = %+v, want a connected, failing report", report)
+		}
+		if report.Endpoints[0].Registered || !report.Endpoints[1].Registered {
+			t.Errorf("endpoints = %+v, want only %s unregistered", report.Endpoints, expected[0].Name)
+		}
+		out, err := json.Marshal(report)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(out), `"ok":false`) || !strings.Contains(string(out), `"registered":false`) {
+			t.Errorf("JSON report = %s, want ok and registered false", out)
+		}
+	})
+
+	t.Run("no service", func(t *testing.T) {
+		nc := connect(t, startServer(t, nil))
+		report := streamingv1.StreamDemoServiceSelfTest(context.Background(), nc)
+		if report.OK || !report.Connected || !strings.Contains(report.Error, "service discovery") {
+			t.Fatalf("report = %+v, want a service discovery error", report)
+		}
+	})
+}
@@ -0,0 +1,75 @@
+package runtimetest
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	streamingv1 "example/gen/streaming/v1"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// TestSizeLimits checks that oversized requests are rejected before the handler
+// runs, and that clients refuse oversized responses
+func TestSizeLimits(t *testing.T) {
+	nc := connect(t, startServer(t, nil))
+	var calls atomic.Int32
+	serveStreamDemo(t, nc, &streamDemo{
+		ping: func(ctx context.Context, req *streamingv1.PingRequest) (*streamingv1.PingResponse, error) {
+			calls.Add(1)
+			return &streamingv1.PingResponse{Payload: req.Payload}, nil
+		},
+	}, streamingv1.WithMaxRequestSize(64))
+	client := streamingv1.NewStreamDemoServiceNatsClient(nc)
+
+	t.Run("request over the limit", func(t *testing.T) {
+		calls.Store(0)
+		_, err := client.Ping(context.Background(), &streamingv1.PingRequest{Payload: strings.Repeat("x", 100)})
+		if code := streamingv1.CodeOf(err); code != streamingv1.CodeResourceExhausted {
+			t.Errorf("Ping = %v (code %v), want RESOURCE_EXHAUSTED", err, code)
+		}
+		if calls.Load() != 0 {
+			t.Error("the handler ran for an oversized request")
+		}
+	})
+
+	t.Run("request at the limit", func(t *testing.T) {
+		calls.Store(0)
+		req := &streamingv1.PingRequest{Payload: strings.Repeat("x", 62)}
+		if size := proto.Size(req); size != 64 {
+			t.Fatalf("request is %d bytes, want 64", size)
+		}
+		if _, err := client.Ping(context.Background(), req); err != nil {
+			t.Fatal(err)
+		}
+		if calls.Load() != 1 {
+			t.Error("the handler did not run")
+		}
+	})
+
+	t.Run("response over the client limit", func(t *testing.T) {
+		limited := streamingv1.NewStreamDemoServiceNatsClient(nc, streamingv1.WithMaxResponseSize(8))
+		_, err := limited.Ping(context.Background(), &streamingv1.PingRequest{Payload: "longer than eight bytes"})
+		var st *streamingv1.Status
+		if !errors.As(err, &st) || st.Code != streamingv1.CodeResourceExhausted {
+			t.Errorf("Ping = %v, want a RESOURCE_EXHAUSTED status", err)
+		}
+		if _, err := limited.Ping(context.Background(), &streamingv1.PingRequest{Payload: "short"}); err != nil {
+			t.Errorf("Ping of a small response = %v", err)
+		}
+	})
+
+	t.Run("stream message over the client limit", func(t *testing.T) {
+		limited := streamingv1.NewStreamDemoServiceNatsClient(nc, streamingv1.WithMaxResponseSize(1))
+		stream, err := limited.CountUp(context.Background(), &streamingv1.CountUpRequest{Start: 1000, Count: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := stream.Recv(context.Background()); streamingv1.CodeOf(err) != streamingv1.CodeResourceExhausted {
+			t.Errorf("Recv = %v, want RESOURCE_EXHAUSTED", err)
+		}
+	})
+}
@@ -0,0 +1,58 @@
+package runtimetest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	streamingv1 "example/gen/streaming/v1"
+)
+
+// TestStatusErrors checks that the status a handler fails with reaches the
+// client, where errors.As finds it as a *Status
+func TestStatusErrors(t *testing.T) {
+	nc := connect(t, startServer(t, nil))
+	var handlerErr error
+	serveStreamDemo(t, nc, &streamDemo{
+		ping: func(ctx context.Context, req *streamingv1.PingRequest) (*streamingv1.PingResponse, error) {
+			return nil, handlerErr
+		},
+	})
+	client := streamingv1.NewStreamDemoServiceNatsClient(nc)
+
+	for _, tt := range []struct {
+		name        string
+		err         error
+		wantCode    streamingv1.Code
+		wantMessage string
+		wantDetails string
+	}{
+		{"wrapped status", fmt.Errorf("lookup: %w", &streamingv1.Status{Code: streamingv1.CodeNotFound, Message: "no payload p1", Details: []byte("d")}), streamingv1.CodeNotFound, "no payload p1", "d"},
+		{"statusf", streamingv1.Statusf(streamingv1.CodeFailedPrecondition, "n=%d", 1), streamingv1.CodeFailedPrecondition, "n=1", ""},
+		{"service error", streamingv1.NewStreamDemoServiceNotFoundError("Ping", "missing"), streamingv1.CodeNotFound, "missing", ""},
+		{"plain error", errors.New("boom"), streamingv1.CodeInternal, "boom", ""},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			handlerErr = tt.err
+			_, err := client.Ping(context.Background(), &streamingv1.PingRequest{})
+
+			var st *streamingv1.Status
+			if !errors.As(err, &st) {
+				t.Fatalf("Ping = %v, want an error holding a *Status", err)
+			}
+			if st.Code != tt.wantCode || st.Message != tt.wantMessage || string(st.Details) != tt.wantDetails {
+				t.Errorf("Status = %+v, want code %v, message %q and details %q", st, tt.wantCode, tt.wantMessage, tt.wantDetails)
+			}
+			if code := streamingv1.CodeOf(err); code != tt.wantCode {
+				t.Errorf("CodeOf = %v, want %v", code, tt.wantCode)
+			}
+
+			// errors.As finds the status the error holds, not a copy
+			var again *streamingv1.Status
+			if errors.As(err, &again); again != st {
+				t.Errorf("errors.As returned %p, then %p; want the same *Status", st, again)
+			}
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package runtimetest
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	streamingv1 "example/gen/streaming/v1"
+
+	"github.com/nats-io/nats.go"
+)
+
+// streamDemo serves StreamDemoService for the tests. Ping echoes the payload and
+// CountUp counts from start, unless a test replaces them; Sum and Chat fail unless
+// a test sets them.
+type streamDemo struct {
+	ping    func(context.Context, *streamingv1.PingRequest) (*streamingv1.PingResponse, error)
+	countUp func(context.Context, *streamingv1.CountUpRequest, *streamingv1.StreamDemoService_CountUp_Stream) error
+	sum     func(context.Context, *streamingv1.StreamDemoService_Sum_Stream) (*streamingv1.SumResponse, error)
+	chat    func(context.Context, *streamingv1.StreamDemoService_Chat_Stream) error
+}
+
+func (s *streamDemo) Ping(ctx context.Context, req *streamingv1.PingRequest) (*streamingv1.PingResponse, error) {
+	if s.ping != nil {
+		return s.ping(ctx, req)
+	}
+	return &streamingv1.PingResponse{Payload: req.Payload}, nil
+}
+
+func (s *streamDemo) CountUp(ctx context.Context, req *streamingv1.CountUpRequest, stream *streamingv1.StreamDemoService_CountUp_Stream) error {
+	if s.countUp != nil {
+		return s.countUp(ctx, req, stream)
+	}
+	for i := range req.Count {
+		if err := stream.Send(&streamingv1.CountUpResponse{Number: req.Start + i}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *streamDemo) Sum(ctx context.Context, stream *streamingv1.StreamDemoService_Sum_Stream) (*streamingv1.SumResponse, error) {
+	if s.sum != nil {
+		return s.sum(ctx, stream)
+	}
+	return nil, errors.New("not used by the tests")
+}
+
+func (s *streamDemo) Chat(ctx context.Context, stream *streamingv1.StreamDemoService_Chat_Stream) error {
+	if s.chat != nil {
+		return s.chat(ctx, stream)
+	}
+	return errors.New("not used by the tests")
+}
+
+// serveStreamDemo registers impl on nc and stops it when the test ends
+func serveStreamDemo(t testing.TB, nc *nats.Conn, impl *streamDemo, opts ...streamingv1.RegisterOption) streamingv1.StreamDemoServiceService {
+	t.Helper()
+	svc, err := streamingv1.RegisterStreamDemoServiceHandlers(nc, impl, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { svc.Stop() })
+	return svc
+}
+
+// streamDemoSubject returns the subject StreamDemoService serves method on
+func streamDemoSubject(method string) string {
+	for _, ep := range streamingv1.NewStreamDemoServiceNatsClient(nil).Endpoints() {
+		if ep.Name == method {
+			return ep.Subject
+		}
+	}
+	panic("no StreamDemoService endpoint " + method)
+}
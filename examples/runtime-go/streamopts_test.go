@@ -0,0 +1,74 @@
+package runtimetest
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	streamingv1 "example/gen/streaming/v1"
+)
+
+// TestStreamOptions checks that the establishment options a client opens a
+// stream with reach the handler, and that invalid ones fail the call
+func TestStreamOptions(t *testing.T) {
+	nc := connect(t, startServer(t, nil))
+	received := make(chan streamingv1.StreamOptions, 1)
+	serveStreamDemo(t, nc, &streamDemo{
+		countUp: func(ctx context.Context, req *streamingv1.CountUpRequest, stream *streamingv1.StreamDemoService_CountUp_Stream) error {
+			received <- stream.Options()
+			return nil
+		},
+	})
+	client := streamingv1.NewStreamDemoServiceNatsClient(nc)
+
+	// open runs CountUp to completion and returns the options its handler saw
+	open := func(t *testing.T, opts ...streamingv1.StreamCallOption) (streamingv1.StreamOptions, error) {
+		t.Helper()
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		stream, err := client.CountUp(ctx, &streamingv1.CountUpRequest{}, opts...)
+		if err == nil {
+			_, err = stream.Recv(ctx)
+		}
+		if errors.Is(err, streamingv1.ErrStreamEOF) {
+			return <-received, nil
+		}
+		return streamingv1.StreamOptions{}, err
+	}
+
+	t.Run("all", func(t *testing.T) {
+		got, err := open(t,
+			streamingv1.WithFrameSizeHint(4096),
+			streamingv1.WithResumeFrom("tok-7"),
+			streamingv1.WithClientBufferSize(32),
+			streamingv1.WithStreamOption("Trace-Id", "abc"),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.FrameSizeHint != 4096 || got.ResumeFrom != "tok-7" || got.ClientBuffer != 32 {
+			t.Errorf("Options() = %+v, want frame size 4096, resume from tok-7 and buffer 32", got)
+		}
+		if got.Raw["Trace-Id"] != "abc" || got.Raw["Frame-Size"] != "4096" {
+			t.Errorf("Options().Raw = %v, want Trace-Id=abc and Frame-Size=4096", got.Raw)
+		}
+	})
+
+	t.Run("none", func(t *testing.T) {
+		got, err := open(t)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.FrameSizeHint != 0 || got.ResumeFrom != "" || got.ClientBuffer != 0 || len(got.Raw) != 0 {
+			t.Errorf("Options() = %+v, want the zero value", got)
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		_, err := open(t, streamingv1.WithStreamOption("Frame-Size", "big"))
+		if code := streamingv1.CodeOf(err); code != streamingv1.CodeInvalidArgument {
+			t.Errorf("CountUp = %v (code %v), want INVALID_ARGUMENT", err, code)
+		}
+	})
+}
@@ -0,0 +1,113 @@
+package runtimetest
+
+import (
+	"bytes"
+	"context"
+	"runtime/pprof"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	streamingv1 "example/gen/streaming/v1"
+)
+
+// streamWindow is the window TestStreamWindow asks for, below the default so that
+// the client's receive buffer is not what holds the server back
+const streamWindow = 16
+
+// TestStreamWindow has a server stream 10k messages to a client that never calls
+// Recv, and checks that Send blocks once window messages are unread
+func TestStreamWindow(t *testing.T) {
+	nc := connect(t, startServer(t, nil))
+
+	var sent atomic.Int64
+	ended := make(chan error, 1)
+	serveStreamDemo(t, nc, &streamDemo{countUp: func(ctx context.Context, req *streamingv1.CountUpRequest, stream *streamingv1.StreamDemoService_CountUp_Stream) error {
+		for i := range req.Count {
+			if err := stream.Send(&streamingv1.CountUpResponse{Number: i}); err != nil {
+				ended <- err
+				return err
+			}
+			sent.Add(1)
+		}
+		ended <- nil
+		return nil
+	}})
+	client := streamingv1.NewStreamDemoServiceNatsClient(nc, streamingv1.WithClientStreamWindow(streamWindow))
+
+	stream, err := client.CountUp(context.Background(), &streamingv1.CountUpRequest{Count: 10_000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the window to fill", func() bool { return sent.Load() >= streamWindow })
+	time.Sleep(200 * time.Millisecond) // Time to run past the window, if it would
+	if n := sent.Load(); n != streamWindow {
+		t.Fatalf("server sent %d messages to a client that read none, want %d", n, streamWindow)
+	}
+
+	// Closing the stream ends the blocked Send
+	stream.Close()
+	select {
+	case err := <-ended:
+		if err == nil {
+			t.Error("server sent all messages after the client closed the stream")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Send still blocked after the client closed the stream")
+	}
+}
+
+// TestStreamWindowEndWithError ends a stream with an error once the default window
+// is full and unread, which fills the client's receive buffer too, and checks that
+// closing the stream releases the error frame's delivery instead of leaving it
+// blocked behind the window
+func TestStreamWindowEndWithError(t *testing.T) {
+	nc := connect(t, startServer(t, nil))
+	ended := make(chan struct{})
+	serveStreamDemo(t, nc, &streamDemo{countUp: func(ctx context.Context, req *streamingv1.CountUpRequest, stream *streamingv1.StreamDemoService_CountUp_Stream) error {
+		defer close(ended)
+		for i := range req.Count {
+			if err := stream.Send(&streamingv1.CountUpResponse{Number: i}); err != nil {
+				return err
+			}
+		}
+		return streamingv1.NewStreamDemoServiceNotFoundError("CountUp", "nothing more")
+	}})
+	client := streamingv1.NewStreamDemoServiceNatsClient(nc)
+
+	before := deliveries()
+	stream, err := client.CountUp(context.Background(), &streamingv1.CountUpRequest{Count: 64})
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-ended
+	nc.Flush()
+	waitFor(t, "the error frame to wait for room", func() bool { return deliveries() == before+1 })
+
+	stream.Close()
+	waitFor(t, "Close to release the error frame", func() bool { return deliveries() == before })
+}
+
+// deliveries counts the goroutines running a stream receiver's subscription
+// callback, i.e. waiting to hand a message to Recv
+func deliveries() int {
+	var stacks bytes.Buffer
+	pprof.Lookup("goroutine").WriteTo(&stacks, 2)
+	n := 0
+	for _, g := range bytes.Split(stacks.Bytes(), []byte("\n\n")) {
+		if bytes.Contains(g, []byte("newClientStreamReceiver.func")) {
+			n++
+		}
+	}
+	return n
+}
+
+// waitFor polls cond for up to 5 seconds
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); !cond(); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package runtimetest
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	streamingv1 "example/gen/streaming/v1"
+)
+
+// TestTimeouts checks which deadline bounds a call: the client default, a
+// per-call override, or a shorter context deadline
+func TestTimeouts(t *testing.T) {
+	nc := connect(t, startServer(t, nil))
+	release := make(chan struct{})
+	t.Cleanup(func() { close(release) })
+	serveStreamDemo(t, nc, &streamDemo{
+		ping: func(ctx context.Context, req *streamingv1.PingRequest) (*streamingv1.PingResponse, error) {
+			<-release
+			return &streamingv1.PingResponse{}, nil
+		},
+	})
+	client := streamingv1.NewStreamDemoServiceNatsClient(nc, streamingv1.WithClientTimeout(50*time.Millisecond))
+
+	t.Run("default", func(t *testing.T) {
+		_, err := client.Ping(context.Background(), &streamingv1.PingRequest{})
+		var te *streamingv1.TimeoutError
+		if !errors.As(err, &te) || te.Timeout != 50*time.Millisecond {
+			t.Fatalf("Ping = %v, want a TimeoutError after 50ms", err)
+		}
+		if !errors.Is(err, streamingv1.ErrTimeout) || !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("Ping = %v, want it to match ErrTimeout and context.DeadlineExceeded", err)
+		}
+	})
+
+	t.Run("per call", func(t *testing.T) {
+		start := time.Now()
+		_, err := client.Ping(context.Background(), &streamingv1.PingRequest{}, streamingv1.WithCallTimeout(10*time.Millisecond))
+		var te *streamingv1.TimeoutError
+		if !errors.As(err, &te) || te.Timeout != 10*time.Millisecond {
+			t.Fatalf("Ping = %v, want a TimeoutError after 10ms", err)
+		}
+		if elapsed := time.Since(start); elapsed >= 50*time.Millisecond {
+			t.Errorf("Ping took %v, want the per-call timeout to win over the default", elapsed)
+		}
+	})
+
+	t.Run("context deadline", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		_, err := client.Ping(ctx, &streamingv1.PingRequest{}, streamingv1.WithCallTimeout(time.Hour))
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("Ping = %v, want context.DeadlineExceeded", err)
+		}
+		if errors.Is(err, streamingv1.ErrTimeout) {
+			t.Errorf("Ping = %v, want the caller's deadline reported as is, not as ErrTimeout", err)
+		}
+	})
+}
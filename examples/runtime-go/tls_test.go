@@ -0,0 +1,111 @@
+package runtimetest
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	mathbig "math/big"
+	"net"
+	"testing"
+	"time"
+
+	runtimev1 "example/gen/runtime/v1"
+
+	"github.com/nats-io/nats.go"
+)
+
+type vault struct{}
+
+func (vault) GetSecret(ctx context.Context, req *runtimev1.GetSecretRequest) (*runtimev1.GetSecretResponse, error) {
+	return &runtimev1.GetSecretResponse{Value: "secret " + req.Name}, nil
+}
+
+// TestRequireTLS registers and calls VaultService, which sets require_tls, over a
+// TLS and a plaintext server, and checks that plaintext is refused on both sides
+// unless the WithInsecure* options allow it
+func TestRequireTLS(t *testing.T) {
+	serverTLS, clientTLS := selfSignedTLS(t)
+	secure := connect(t, startServer(t, serverTLS), nats.Secure(clientTLS))
+	plain := connect(t, startServer(t, nil))
+	req := &runtimev1.GetSecretRequest{Name: "db"}
+
+	t.Run("TLS", func(t *testing.T) {
+		svc, err := runtimev1.RegisterVaultServiceHandlers(secure, vault{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { svc.Stop() })
+
+		resp, err := runtimev1.NewVaultServiceNatsClient(secure).GetSecret(context.Background(), req)
+		if err != nil || resp.Value != "secret db" {
+			t.Fatalf("GetSecret over TLS = %v, %v", resp, err)
+		}
+		// A pool is refused if any of its connections is plaintext
+		pool := runtimev1.NewVaultServiceNatsClientPool([]*nats.Conn{secure, plain})
+		if _, err := pool.GetSecret(context.Background(), req); !errors.Is(err, runtimev1.ErrInsecureConnection) {
+			t.Errorf("GetSecret through a pool with a plaintext connection = %v, want ErrInsecureConnection", err)
+		}
+	})
+
+	t.Run("plaintext", func(t *testing.T) {
+		if _, err := runtimev1.RegisterVaultServiceHandlers(plain, vault{}); !errors.Is(err, runtimev1.ErrInsecureConnection) {
+			t.Fatalf("registering on a plaintext connection = %v, want ErrInsecureConnection", err)
+		}
+		svc, err := runtimev1.RegisterVaultServiceHandlers(plain, vault{}, runtimev1.WithInsecureServiceAllowed())
+		if err != nil {
+			t.Fatalf("registering with WithInsecureServiceAllowed: %v", err)
+		}
+		t.Cleanup(func() { svc.Stop() })
+
+		requests := wiretap(t, plain, "runtime.vault.>")
+		if _, err := runtimev1.NewVaultServiceNatsClient(plain).GetSecret(context.Background(), req); !errors.Is(err, runtimev1.ErrInsecureConnection) {
+			t.Errorf("GetSecret over plaintext = %v, want ErrInsecureConnection", err)
+		}
+		if sent := requests(); len(sent) != 0 {
+			t.Errorf("refused call sent %q", sent)
+		}
+		resp, err := runtimev1.NewVaultServiceNatsClient(plain, runtimev1.WithInsecureAllowed()).GetSecret(context.Background(), req)
+		if err != nil || resp.Value != "secret db" {
+			t.Errorf("GetSecret with WithInsecureAllowed = %v, %v", resp, err)
+		}
+	})
+}
+
+// selfSignedTLS returns a server configuration with a certificate for 127.0.0.1,
+// and a client configuration trusting it
+func selfSignedTLS(t *testing.T) (server, client *tls.Config) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: mathbig.NewInt(1),
+		Subject:      pkix.Name{CommonName: "runtime-go test"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IsCA:         true,
+
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+	server = &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key, Leaf: cert}}}
+	return server, &tls.Config{RootCAs: roots}
+}
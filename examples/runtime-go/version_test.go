@@ -0,0 +1,81 @@
+package runtimetest
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	runtimev1 "example/gen/runtime/v1"
+
+	"github.com/nats-io/nats.go"
+)
+
+// catalog serves CatalogService, describing itself as version
+type catalog struct{ version string }
+
+func (c catalog) Describe(context.Context, *runtimev1.DescribeRequest) (*runtimev1.Description, error) {
+	return &runtimev1.Description{Version: c.version}, nil
+}
+
+// TestVersionInSubject registers CatalogService at its generated version 2.1.0 and
+// again at 1.3.0 under the same prefix, and checks that clients reach the version
+// they ask for and nothing else
+func TestVersionInSubject(t *testing.T) {
+	nc := connect(t, startServer(t, nil))
+	for _, version := range []string{"", "1.3.0"} {
+		var opts []runtimev1.RegisterOption
+		impl := catalog{version: "2.1.0"}
+		if version != "" {
+			opts = append(opts, runtimev1.WithVersion(version))
+			impl.version = version
+		}
+		svc, err := runtimev1.RegisterCatalogServiceHandlers(nc, impl, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { svc.Stop() })
+	}
+	if err := nc.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name, serviceVersion, want string
+	}{
+		{"generated version", "", "2.1.0"},
+		{"major only", "v1", "1.3.0"},
+		{"full version", "1.0.0", "1.3.0"},
+		{"current major", "2", "2.1.0"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var opts []runtimev1.NatsClientOption
+			if tt.serviceVersion != "" {
+				opts = append(opts, runtimev1.WithServiceVersion(tt.serviceVersion))
+			}
+			client := runtimev1.NewCatalogServiceNatsClient(nc, opts...)
+			resp, err := client.Describe(context.Background(), &runtimev1.DescribeRequest{})
+			if err != nil || resp.Version != tt.want {
+				t.Errorf("Describe = %v, %v; want version %s", resp, err, tt.want)
+			}
+		})
+	}
+
+	// The versions own their own subjects, and there is nothing under v3 or the bare prefix
+	for subject, want := range map[string]string{"runtime.catalog.v1.describe": "1.3.0", "runtime.catalog.v2.describe": "2.1.0"} {
+		msg := nats.NewMsg(subject)
+		msg.Header.Set(runtimev1.ContentTypeHeader, runtimev1.ContentTypeProtobuf)
+		if _, err := nc.RequestMsg(msg, 5*time.Second); err != nil {
+			t.Errorf("request on %s (version %s) = %v", subject, want, err)
+		}
+	}
+	for _, subject := range []string{"runtime.catalog.v3.describe", "runtime.catalog.describe"} {
+		if _, err := nc.Request(subject, nil, time.Second); !errors.Is(err, nats.ErrNoResponders) {
+			t.Errorf("request on %s = %v, want no responders", subject, err)
+		}
+	}
+	v3 := runtimev1.NewCatalogServiceNatsClient(nc, runtimev1.WithServiceVersion("v3"), runtimev1.WithClientTimeout(time.Second))
+	if resp, err := v3.Describe(context.Background(), &runtimev1.DescribeRequest{}); err == nil {
+		t.Errorf("Describe on v3 = %v, want an error", resp)
+	}
+}
@@ -0,0 +1,122 @@
+// Simple NATS Micro example using protoc-gen-nats-micro's C# output.
+//
+//   dotnet run -- server   registers ExampleService
+//   dotnet run -- client   calls it
+using Example.V1;
+using NATS.Client.Core;
+
+await using var nats = new NatsConnection(new NatsOpts { Url = "nats://localhost:4222" });
+await nats.ConnectAsync();
+Console.WriteLine("Connected to NATS");
+
+if (args.Length > 0 && args[0] == "server")
+{
+    await RunServerAsync(nats);
+}
+else
+{
+    await RunClientAsync(nats);
+}
+
+static async Task RunServerAsync(INatsConnection nats)
+{
+    await using var service = await new MyExampleService().RegisterAsync(nats);
+    Console.WriteLine("ExampleService registered and running");
+    Console.WriteLine("\nServer is running. Press Ctrl+C to stop.");
+
+    var stop = new TaskCompletionSource();
+    Console.CancelKeyPress += (_, e) =>
+    {
+        e.Cancel = true;
+        stop.TrySetResult();
+    };
+    await stop.Task;
+    Console.WriteLine("\nShutting down...");
+}
+
+static async Task RunClientAsync(INatsConnection nats)
+{
+    var client = new ExampleServiceClient(nats);
+    Console.WriteLine("ExampleService client created");
+
+    // Test Echo
+    Console.WriteLine("\n=== Testing Echo ===");
+    try
+    {
+        var echo = await client.EchoAsync(new EchoRequest { Message = "Hello from C#!" });
+        Console.WriteLine($"Response: {echo.Message}");
+        Console.WriteLine($"Timestamp: {echo.Timestamp}");
+    }
+    catch (ExampleServiceException e)
+    {
+        Console.WriteLine($"Error: {e.Message}");
+    }
+
+    // Test Echo with custom headers
+    Console.WriteLine("\n=== Testing Echo with Headers ===");
+    try
+    {
+        var headers = new NatsHeaders
+        {
+            ["X-User-ID"] = "12345",
+            ["X-Request-ID"] = "abc-def",
+        };
+        var reply = await client.EchoWithHeadersAsync(new EchoRequest { Message = "Hello with headers!" }, headers);
+        Console.WriteLine($"Response: {reply.Message.Message}");
+        Console.WriteLine($"Response headers: {reply.Headers}");
+    }
+    catch (ExampleServiceException e)
+    {
+        Console.WriteLine($"Error: {e.Message}");
+    }
+
+    // Test GetGreeting
+    Console.WriteLine("\n=== Testing GetGreeting ===");
+    var greetingRequest = new GetGreetingRequest { Name = "C# Developer" };
+    try
+    {
+        var greeting = await client.GetGreetingAsync(greetingRequest);
+        Console.WriteLine($"Greeting: {greeting.Greeting}");
+    }
+    catch (ExampleServiceException e)
+    {
+        Console.WriteLine($"Error: {e.Message}");
+    }
+
+    // Test timeout
+    Console.WriteLine("\n=== Testing Timeout ===");
+    try
+    {
+        var greeting = await client.GetGreetingAsync(greetingRequest, timeout: TimeSpan.FromMilliseconds(1));
+        Console.WriteLine($"Greeting: {greeting.Greeting}");
+    }
+    catch (ExampleServiceException e)
+    {
+        Console.WriteLine($"Timeout error (expected): {e.Message}");
+    }
+
+    Console.WriteLine("\nClient done!");
+}
+
+// Implementation of ExampleService
+class MyExampleService : ExampleServiceBase
+{
+    // Echo the message back with a timestamp
+    public override Task<EchoResponse> EchoAsync(EchoRequest request, ServerInfo info)
+    {
+        Console.WriteLine($"Echo called with message: {request.Message}");
+        Console.WriteLine($"Request headers: {info.Headers}");
+        return Task.FromResult(new EchoResponse
+        {
+            Message = request.Message,
+            Timestamp = DateTimeOffset.UtcNow.ToUnixTimeSeconds(),
+        });
+    }
+
+    // Return a personalized greeting
+    public override Task<GetGreetingResponse> GetGreetingAsync(GetGreetingRequest request, ServerInfo info)
+    {
+        Console.WriteLine($"GetGreeting called for: {request.Name}");
+        return Task.FromResult(new GetGreetingResponse { Greeting = $"Hello, {request.Name}!" });
+    }
+}
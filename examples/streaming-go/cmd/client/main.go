@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"time"
@@ -38,10 +39,10 @@ func main() {
 	}
 	for {
 		resp, err := countStream.Recv(ctx)
+		if errors.Is(err, streamv1.ErrStreamEOF) {
+			break
+		}
 		if err != nil {
-			if err.Error() == "EOF" {
-				break
-			}
 			log.Fatalf("CountUp recv failed: %v", err)
 		}
 		log.Printf("  ← number=%d ts=%s", resp.Number, resp.Timestamp)
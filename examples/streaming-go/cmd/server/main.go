@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"os"
@@ -54,10 +55,12 @@ func (s *streamService) Sum(ctx context.Context, stream *streamv1.StreamDemoServ
 	var count int32
 	for {
 		msg, err := stream.Recv(ctx)
-		if err != nil {
-			// EOF or stream ended
+		if errors.Is(err, streamv1.ErrStreamEOF) {
 			break
 		}
+		if err != nil {
+			return nil, err
+		}
 		total += msg.Value
 		count++
 		log.Printf("  ← received %d (running total: %d)", msg.Value, total)
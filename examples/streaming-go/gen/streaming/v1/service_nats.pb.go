@@ -1,4 +1,8 @@
 // Code generated by protoc-gen-nats-micro. DO NOT EDIT.
+// versions:
+// 	protoc-gen-nats-micro v0.3.0
+// 	protoc                (unknown)
+// source: streaming/v1/service.proto
 
 package v1
 
@@ -6,7 +10,6 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"log"
 	"os"
 	"strconv"
 	"sync"
@@ -23,6 +26,21 @@ type StreamDemoServiceError struct {
 	Code    string // Error code (e.g., "INVALID_ARGUMENT", "NOT_FOUND", "INTERNAL")
 	Message string // Human-readable error message
 	Method  string // The method that failed
+	Details []byte // Optional error data sent with the error
+
+	status *Status // Returned by Unwrap (nil = built on each call)
+}
+
+// newStreamDemoServiceError creates an error that keeps its *Status, so Unwrap
+// returns the same value every time
+func newStreamDemoServiceError(code, method, message string, details []byte) *StreamDemoServiceError {
+	return &StreamDemoServiceError{
+		Code:    code,
+		Method:  method,
+		Message: message,
+		Details: details,
+		status:  &Status{Code: ParseCode(code), Message: message, Details: details},
+	}
 }
 
 func (e *StreamDemoServiceError) Error() string {
@@ -41,7 +59,17 @@ func (e *StreamDemoServiceError) NatsErrorMessage() string {
 
 // NatsErrorData returns optional error data (nil for basic errors)
 func (e *StreamDemoServiceError) NatsErrorData() []byte {
-	return nil
+	return e.Details
+}
+
+// Unwrap exposes the error as a *Status, so errors.As(err, &st) works on
+// client errors. Custom error codes map to CodeUnknown. Errors built as struct
+// literals get a new Status on each call.
+func (e *StreamDemoServiceError) Unwrap() error {
+	if e.status != nil {
+		return e.status
+	}
+	return &Status{Code: ParseCode(e.Code), Message: e.Message, Details: e.Details}
 }
 
 // Service-specific error code constants (use shared constants from service_shared_nats.pb.go)
@@ -108,225 +136,39 @@ func GetStreamDemoServiceErrorCode(err error) string {
 
 // NewStreamDemoServiceInvalidArgumentError creates a new invalid argument error
 func NewStreamDemoServiceInvalidArgumentError(method, message string) error {
-	return &StreamDemoServiceError{Code: StreamDemoServiceErrCodeInvalidArgument, Method: method, Message: message}
+	return newStreamDemoServiceError(StreamDemoServiceErrCodeInvalidArgument, method, message, nil)
 }
 
 // NewStreamDemoServiceNotFoundError creates a new not found error
 func NewStreamDemoServiceNotFoundError(method, message string) error {
-	return &StreamDemoServiceError{Code: StreamDemoServiceErrCodeNotFound, Method: method, Message: message}
+	return newStreamDemoServiceError(StreamDemoServiceErrCodeNotFound, method, message, nil)
 }
 
 // NewStreamDemoServiceAlreadyExistsError creates a new already exists error
 func NewStreamDemoServiceAlreadyExistsError(method, message string) error {
-	return &StreamDemoServiceError{Code: StreamDemoServiceErrCodeAlreadyExists, Method: method, Message: message}
+	return newStreamDemoServiceError(StreamDemoServiceErrCodeAlreadyExists, method, message, nil)
 }
 
 // NewStreamDemoServicePermissionDeniedError creates a new permission denied error
 func NewStreamDemoServicePermissionDeniedError(method, message string) error {
-	return &StreamDemoServiceError{Code: StreamDemoServiceErrCodePermissionDenied, Method: method, Message: message}
+	return newStreamDemoServiceError(StreamDemoServiceErrCodePermissionDenied, method, message, nil)
 }
 
 // NewStreamDemoServiceUnauthenticatedError creates a new unauthenticated error
 func NewStreamDemoServiceUnauthenticatedError(method, message string) error {
-	return &StreamDemoServiceError{Code: StreamDemoServiceErrCodeUnauthenticated, Method: method, Message: message}
+	return newStreamDemoServiceError(StreamDemoServiceErrCodeUnauthenticated, method, message, nil)
 }
 
 // NewStreamDemoServiceInternalError creates a new internal error
 func NewStreamDemoServiceInternalError(method, message string) error {
-	return &StreamDemoServiceError{Code: StreamDemoServiceErrCodeInternal, Method: method, Message: message}
+	return newStreamDemoServiceError(StreamDemoServiceErrCodeInternal, method, message, nil)
 }
 
 // NewStreamDemoServiceUnavailableError creates a new unavailable error
 func NewStreamDemoServiceUnavailableError(method, message string) error {
-	return &StreamDemoServiceError{Code: StreamDemoServiceErrCodeUnavailable, Method: method, Message: message}
-}
-
-// Stream protocol header constants
-const (
-	natsStreamSeqHeader   = "Nats-Stream-Seq"
-	natsStreamEndHeader   = "Nats-Stream-End"
-	natsStreamInboxHeader = "Nats-Stream-Inbox"
-	natsStreamErrorHeader = "Nats-Stream-Error"
-)
-
-// ServerStreamSender is the server-side interface for sending streaming responses
-type ServerStreamSender interface {
-	// Send publishes one message to the client
-	Send(data []byte) error
-	// SendMsg serializes and sends a proto message to the client
-	SendMsg(msg proto.Message, useJSON bool) error
-	// Close sends the end-of-stream marker to the client
-	Close() error
-	// CloseWithError sends an error and end-of-stream marker to the client
-	CloseWithError(code string, message string) error
-}
-
-// serverStreamSender implements ServerStreamSender using NATS publish
-type serverStreamSender struct {
-	nc      *nats.Conn
-	subject string // The client's reply inbox
-	seq     int
-	mu      sync.Mutex
-	closed  bool
-}
-
-func newServerStreamSender(nc *nats.Conn, replySubject string) *serverStreamSender {
-	return &serverStreamSender{
-		nc:      nc,
-		subject: replySubject,
-		seq:     0,
-	}
-}
-
-func (s *serverStreamSender) Send(data []byte) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	if s.closed {
-		return errors.New("stream is closed")
-	}
-	s.seq++
-	msg := &nats.Msg{
-		Subject: s.subject,
-		Data:    data,
-		Header:  nats.Header{},
-	}
-	msg.Header.Set(natsStreamSeqHeader, strconv.Itoa(s.seq))
-	return s.nc.PublishMsg(msg)
-}
-
-func (s *serverStreamSender) SendMsg(msg proto.Message, useJSON bool) error {
-	var data []byte
-	var err error
-	if useJSON {
-		data, err = protojson.Marshal(msg)
-	} else {
-		data, err = proto.Marshal(msg)
-	}
-	if err != nil {
-		return fmt.Errorf("failed to marshal stream message: %w", err)
-	}
-	return s.Send(data)
-}
-
-func (s *serverStreamSender) Close() error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	if s.closed {
-		return nil
-	}
-	s.closed = true
-	msg := &nats.Msg{
-		Subject: s.subject,
-		Data:    nil,
-		Header:  nats.Header{},
-	}
-	msg.Header.Set(natsStreamEndHeader, "true")
-	return s.nc.PublishMsg(msg)
-}
-
-func (s *serverStreamSender) CloseWithError(code string, message string) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	if s.closed {
-		return nil
-	}
-	s.closed = true
-	msg := &nats.Msg{
-		Subject: s.subject,
-		Data:    nil,
-		Header:  nats.Header{},
-	}
-	msg.Header.Set(natsStreamEndHeader, "true")
-	msg.Header.Set("Status", code)
-	msg.Header.Set("Description", message)
-	return s.nc.PublishMsg(msg)
+	return newStreamDemoServiceError(StreamDemoServiceErrCodeUnavailable, method, message, nil)
 }
 
-// ClientStreamReceiver receives streaming messages from a server
-type ClientStreamReceiver struct {
-	sub     *nats.Subscription
-	msgCh   chan *nats.Msg
-	done    chan struct{}
-	lastErr error
-	ordered bool
-	lastSeq int
-	mu      sync.Mutex
-}
-
-func newClientStreamReceiver(nc *nats.Conn, inbox string, ordered bool) (*ClientStreamReceiver, error) {
-	msgCh := make(chan *nats.Msg, 64)
-	done := make(chan struct{})
-
-	sub, err := nc.Subscribe(inbox, func(msg *nats.Msg) {
-		// Check for end-of-stream
-		if msg.Header.Get(natsStreamEndHeader) == "true" {
-			close(done)
-			return
-		}
-		select {
-		case msgCh <- msg:
-		case <-done:
-		}
-	})
-	if err != nil {
-		return nil, fmt.Errorf("failed to subscribe to stream inbox: %w", err)
-	}
-
-	return &ClientStreamReceiver{
-		sub:     sub,
-		msgCh:   msgCh,
-		done:    done,
-		ordered: ordered,
-	}, nil
-}
-
-// Recv blocks until the next message arrives or the stream ends.
-// Returns nil, io.EOF when the stream is complete.
-// Returns the raw NATS message for caller to decode.
-func (r *ClientStreamReceiver) Recv(ctx context.Context) (*nats.Msg, error) {
-	select {
-	case msg, ok := <-r.msgCh:
-		if !ok {
-			return nil, fmt.Errorf("stream closed")
-		}
-		// Check for error in stream
-		if status := msg.Header.Get("Status"); status != "" {
-			desc := msg.Header.Get("Description")
-			return nil, fmt.Errorf("stream error [%s]: %s", status, desc)
-		}
-		// Enforce ordering if requested
-		if r.ordered {
-			seqStr := msg.Header.Get(natsStreamSeqHeader)
-			if seqStr != "" {
-				seq, _ := strconv.Atoi(seqStr)
-				r.mu.Lock()
-				expected := r.lastSeq + 1
-				r.lastSeq = seq
-				r.mu.Unlock()
-				if seq != expected {
-					return nil, fmt.Errorf("out-of-order stream message: got seq %d, expected %d", seq, expected)
-				}
-			}
-		}
-		return msg, nil
-	case <-r.done:
-		return nil, fmt.Errorf("EOF")
-	case <-ctx.Done():
-		return nil, ctx.Err()
-	}
-}
-
-// Close unsubscribes from the stream
-func (r *ClientStreamReceiver) Close() error {
-	return r.sub.Unsubscribe()
-}
-
-// Suppress unused import warnings
-var (
-	_ = strconv.Itoa
-	_ = sync.Mutex{}
-)
-
 // StreamDemoServiceNats is the NATS service interface for StreamDemoService
 type StreamDemoServiceNats interface {
 	Ping(context.Context, *PingRequest) (*PingResponse, error)
@@ -337,8 +179,10 @@ type StreamDemoServiceNats interface {
 
 // StreamDemoServiceEndpointInfo describes a service endpoint
 type StreamDemoServiceEndpointInfo struct {
-	Name    string `json:"name"`    // Method name (e.g., "CreateProduct")
-	Subject string `json:"subject"` // NATS subject (e.g., "api.v1.create_product")
+	Name           string `json:"name"`                       // Method name (e.g., "CreateProduct")
+	Subject        string `json:"subject"`                    // NATS subject (e.g., "api.v1.create_product")
+	QueueGroup     string `json:"queue_group,omitempty"`      // Queue group the endpoint joined (server only)
+	MaxRequestSize int    `json:"max_request_size,omitempty"` // Request payload limit in bytes (server only, 0 = unlimited)
 }
 
 // StreamDemoServiceService is the interface for the registered NATS micro service
@@ -346,59 +190,245 @@ type StreamDemoServiceEndpointInfo struct {
 type StreamDemoServiceService interface {
 	micro.Service
 	Endpoints() []StreamDemoServiceEndpointInfo
+	// Drain stops accepting requests, waits until in-flight handlers finish or
+	// ctx ends, and stops the service
+	Drain(ctx context.Context) error
+	// InterceptorChain names the interceptors a method's requests pass through,
+	// outermost first
+	InterceptorChain(method string) []string
+	// Reconfigure changes the settings that can change while the service runs,
+	// currently WithSampling and WithSamplingSalt
+	Reconfigure(opts ...RegisterOption) error
 }
 
 // streamDemoServiceService is the concrete implementation of StreamDemoServiceService
 type streamDemoServiceService struct {
 	micro.Service
-	subjectPrefix string
+	subjectPrefix  string
+	queueGroup     string
+	maxRequestSize int
+	inflight       *inflightTracker
+	interceptors   []string // Names of the service-wide interceptors, outermost first
+	sampler        *sampler // Shared with the handlers, for Reconfigure
 }
 
 // Endpoints returns information about all service endpoints
 // This is useful for debugging, monitoring, and service discovery
 func (s *streamDemoServiceService) Endpoints() []StreamDemoServiceEndpointInfo {
 	return []StreamDemoServiceEndpointInfo{
-		{Name: "Ping", Subject: s.subjectPrefix + ".ping"},
-		{Name: "CountUp", Subject: s.subjectPrefix + ".count_up"},
-		{Name: "Sum", Subject: s.subjectPrefix + ".sum"},
-		{Name: "Chat", Subject: s.subjectPrefix + ".chat"},
+		{Name: "Ping", Subject: s.subjectPrefix + ".ping", QueueGroup: s.queueGroup, MaxRequestSize: s.maxRequestSize},
+		{Name: "CountUp", Subject: s.subjectPrefix + ".count_up", QueueGroup: s.queueGroup, MaxRequestSize: s.maxRequestSize},
+		{Name: "Sum", Subject: s.subjectPrefix + ".sum", QueueGroup: s.queueGroup, MaxRequestSize: s.maxRequestSize},
+		{Name: "Chat", Subject: s.subjectPrefix + ".chat", QueueGroup: s.queueGroup, MaxRequestSize: s.maxRequestSize},
+	}
+}
+
+// init lists the methods of StreamDemoService in MethodDescriptors
+func init() {
+	MethodDescriptors["streaming.v1.StreamDemoService.Ping"] = MethodDescriptor{
+		Service: "StreamDemoService",
+		Method:  "Ping",
+		Subject: "api.v1.stream.ping",
+	}
+	MethodDescriptors["streaming.v1.StreamDemoService.CountUp"] = MethodDescriptor{
+		Service:         "StreamDemoService",
+		Method:          "CountUp",
+		Subject:         "api.v1.stream.count_up",
+		ServerStreaming: true,
+	}
+	MethodDescriptors["streaming.v1.StreamDemoService.Sum"] = MethodDescriptor{
+		Service:         "StreamDemoService",
+		Method:          "Sum",
+		Subject:         "api.v1.stream.sum",
+		ClientStreaming: true,
+	}
+	MethodDescriptors["streaming.v1.StreamDemoService.Chat"] = MethodDescriptor{
+		Service:         "StreamDemoService",
+		Method:          "Chat",
+		Subject:         "api.v1.stream.chat",
+		ClientStreaming: true,
+		ServerStreaming: true,
+	}
+}
+
+// StreamDemoServiceSubjects maps each method of StreamDemoService to its subject under
+// the default prefix. Clients and services of every language are generated from
+// the same table.
+var StreamDemoServiceSubjects = map[string]string{
+	"Ping":    "api.v1.stream.ping",
+	"CountUp": "api.v1.stream.count_up",
+	"Sum":     "api.v1.stream.sum",
+	"Chat":    "api.v1.stream.chat",
+}
+
+// Drain unsubscribes every endpoint, so new requests get no responders, then waits
+// for in-flight handlers, and requests already delivered to the endpoints, to
+// finish before returning. Server and bidi streams are
+// sent a GOAWAY, which clients see as an *ErrServerDraining, and streaming handlers
+// have their context canceled right away, or after WithStreamDrainGrace, so
+// long-running streams can end cleanly. If ctx ends first, the remaining handlers'
+// contexts are canceled and ctx.Err() is returned. The service is stopped either way.
+// Drain fails if nats.go's micro left an endpoint subscribed; see stopEndpoints.
+func (s *streamDemoServiceService) Drain(ctx context.Context) error {
+	return s.inflight.drain(ctx, func() error { return stopEndpoints(s.Service) })
+}
+
+// InterceptorChain returns the names of the interceptors that requests to
+// method, a Go method name, pass through, outermost first: the service's
+// interceptors in the order they were added, then the method's
+// (natsmicro.endpoint).middlewares. Interceptors are named with Named, or else
+// by their function name.
+func (s *streamDemoServiceService) InterceptorChain(method string) []string {
+	chain := append([]string(nil), s.interceptors...)
+	return chain
+}
+
+// Reconfigure replaces the service's WithSampling and WithSamplingSalt settings
+// with those in opts, so sampling can be turned up, down or off without
+// re-registering; leaving WithSampling out stops sampling. Any other option is
+// rejected, as it only takes effect at registration.
+func (s *streamDemoServiceService) Reconfigure(opts ...RegisterOption) error {
+	cfg := &registerConfig{}
+	for _, opt := range opts {
+		opt(cfg)
 	}
+	return reconfigureSampling(s.sampler, cfg)
 }
 
+// StreamDemoServiceSchemaHash identifies the schema StreamDemoService was generated from.
+// Services advertise it as schema_hash INFO metadata; see SchemaHash.
+const StreamDemoServiceSchemaHash = "sha256:9eb49d312750bd97e285a965acc46d39e9d583b946d05bad5aa5f08f0e881f2c"
+
+// streamDemoServiceSchema is the FileDescriptorSet StreamDemoService serves from its
+// $reflect endpoint. The schema endpoint metadata is read from it too, so the two
+// always agree.
+const streamDemoServiceSchema = "\n\xa9\a\n\x1astreaming/v1/service.proto\x12\fstreaming.v1\x1a\x17natsmicro/options.proto\"'\n\vPingRequest\x12\x18\n\apayload\x18\x01 \x01(\tR\apayload\"F\n\fPingResponse\x12\x18\n\apayload\x18\x01 \x01(\tR\apayload\x12\x1c\n\ttimestamp\x18\x02 \x01(\x03R\ttimestamp\"<\n\x0eCountUpRequest\x12\x14\n\x05start\x18\x01 \x01(\x05R\x05start\x12\x14\n\x05count\x18\x02 \x01(\x05R\x05count\"G\n\x0fCountUpResponse\x12\x16\n\x06number\x18\x01 \x01(\x05R\x06number\x12\x1c\n\ttimestamp\x18\x02 \x01(\tR\ttimestamp\"\"\n\nSumRequest\x12\x14\n\x05value\x18\x01 \x01(\x03R\x05value\"9\n\vSumResponse\x12\x14\n\x05total\x18\x01 \x01(\x03R\x05total\x12\x14\n\x05count\x18\x02 \x01(\x05R\x05count\"S\n\vChatMessage\x12\x12\n\x04user\x18\x01 \x01(\tR\x04user\x12\x12\n\x04text\x18\x02 \x01(\tR\x04text\x12\x1c\n\ttimestamp\x18\x03 \x01(\tR\ttimestamp2\x93\x03\n\x11StreamDemoService\x12G\n\x04Ping\x12\x19.streaming.v1.PingRequest\x1a\x1a.streaming.v1.PingResponse\"\b\x92\xb5\x18\x04\n\x02\b\x05\x12J\n\aCountUp\x12\x1c.streaming.v1.CountUpRequest\x1a\x1d.streaming.v1.CountUpResponse\"\x000\x01\x12>\n\x03Sum\x12\x18.streaming.v1.SumRequest\x1a\x19.streaming.v1.SumResponse\"\x00(\x01\x12B\n\x04Chat\x12\x19.streaming.v1.ChatMessage\x1a\x19.streaming.v1.ChatMessage\"\x00(\x010\x01\x1ae\x8a\xb5\x18a\n\rapi.v1.stream\x12\x13stream_demo_service\x1a\x051.0.0\"4Demonstrates server, client, and bidi streaming RPCsB\x1aZ\x18example/gen/streaming/v1b\x06proto3"
+
+// streamDemoServiceSchemaDocument is the JSON StreamDemoService serves from its
+// $schema endpoint, before registration fills in its name, version and subjects
+const streamDemoServiceSchemaDocument = "{\"service\":\"streaming.v1.StreamDemoService\",\"schema_hash\":\"sha256:9eb49d312750bd97e285a965acc46d39e9d583b946d05bad5aa5f08f0e881f2c\",\"methods\":[{\"name\":\"Ping\",\"endpoint\":\"ping\",\"streaming\":\"unary\",\"request_type\":\"streaming.v1.PingRequest\",\"response_type\":\"streaming.v1.PingResponse\"},{\"name\":\"CountUp\",\"endpoint\":\"count_up\",\"streaming\":\"server\",\"request_type\":\"streaming.v1.CountUpRequest\",\"response_type\":\"streaming.v1.CountUpResponse\"},{\"name\":\"Sum\",\"endpoint\":\"sum\",\"streaming\":\"client\",\"request_type\":\"streaming.v1.SumRequest\",\"response_type\":\"streaming.v1.SumResponse\"},{\"name\":\"Chat\",\"endpoint\":\"chat\",\"streaming\":\"bidi\",\"request_type\":\"streaming.v1.ChatMessage\",\"response_type\":\"streaming.v1.ChatMessage\"}],\"descriptor\":\"H4sIAAAAAAAC/3RTTW/TQBDFddw0kwJiqcBdFcnyhRwqOwEuSIhDg6iEhFTZ4sKl2iSjsJJ313jHUfkLHOFX8AP6/5A/kjptcrLfvjezb+dp4F8fuKUChZJ6Ga8mscViJecY5YUhw443XLSa8JdakFVyXpjY5CSNto0sfA3DK6mXCf4s0RLzoZ+LX5kRC98JnNEgWcPwMxw3QpsbbXG/kp3BgKRCS0Ll/kHgjNzk7iD8AE+mptT0LV/feQKeJVFQ3cdLGlCdzitd3cFLGhBewtNNdWvkBRzqUs2waOtb9NDGoGsjBEhL1bGwElmJdQs3aUD4Hoa1pr3oBDwyJLK1qAZ7fKYwnP4Q9BWtFUtkDHqlbR0Okvq/OiO8odZa/b/t2b3n+c1fF56ldaqfUJm0iZtdQq8Khp1G3cSjTqqc76KaV4VHf279HhwceewL9NvZsrPtgu3A+Ks9bNvx0dhhH8FNS8X8beXdxPnpDmZdP3LYBfSq+d1/VGemfD9VdRg7HH/f+gIei1xWfCNmz5vv9QKVuW43hnuTaByNw3fVWLWlQhDaoCKxOA/mmURN54HQi2AmFzLYXBskV1N7wb/7eCNUnmG8RB13V3J2WC/Z2/8DAKV0LtCsAwAA\"}"
+
 // RegisterStreamDemoServiceHandlers registers the service with NATS micro handlers
 // Service: stream_demo_service v1.0.0
 // Description: Demonstrates server, client, and bidi streaming RPCs
 // Subject prefix: api.v1.stream
-// Configuration options: WithName(), WithVersion(), WithDescription(), WithSubjectPrefix(), WithTimeout()
-// Metadata options: WithMetadata() (replace), WithAdditionalMetadata() (merge)
+// Configuration options: WithName(), WithVersion(), WithDescription(), WithSubjectPrefix(), WithTimeout(), WithQueueGroup(), WithMaxRequestSize()
+// Metadata options: WithMetadata() (replace), WithAdditionalMetadata() (merge), WithEndpointMetadata() (per method)
 // Handler options: WithStatsHandler(), WithDoneHandler(), WithErrorHandler()
+// Interceptors: WithServerInterceptor() appends, WithServerInterceptorChain() replaces; the first runs outermost
+// Stream interceptors: WithServerStreamInterceptor()
+// Grouping: WithServiceGroup() registers on a ServiceGroup shared with other services
+// Existing services: AddStreamDemoServiceEndpoints() registers on a micro.Service added elsewhere
 //
 // Endpoint metadata can be configured via proto options (nats.micro.endpoint).metadata
-func RegisterStreamDemoServiceHandlers(nc *nats.Conn, impl StreamDemoServiceNats, opts ...RegisterOption) (StreamDemoServiceService, error) {
+func RegisterStreamDemoServiceHandlers(nc *nats.Conn, impl StreamDemoServiceNats, opts ...RegisterOption) (_ StreamDemoServiceService, err error) {
 	cfg := &registerConfig{
-		name:          "stream_demo_service",
-		version:       "1.0.0",
-		description:   "Demonstrates server, client, and bidi streaming RPCs",
-		subjectPrefix: "api.v1.stream",
-		timeout:       0 * time.Second, // Service-level timeout (0 = no timeout)
-		metadata:      map[string]string{},
+		name:           "stream_demo_service",
+		version:        "1.0.0",
+		description:    "Demonstrates server, client, and bidi streaming RPCs",
+		subjectPrefix:  "api.v1.stream",
+		queueGroup:     "",
+		timeout:        0 * time.Second, // Service-level timeout (0 = no timeout)
+		tokenSanitizer: SanitizeToken,
+		streamWindow:   defaultStreamWindow,
+		metadata:       map[string]string{},
 	}
 	for _, opt := range opts {
 		opt(cfg)
 	}
+	return registerStreamDemoServiceEndpoints(nc, impl, cfg, func() (*serviceHost, bool, error) {
+		// Register on the ServiceGroup, or add a micro.Service for this service
+		if cfg.group != nil {
+			return cfg.group.host, true, nil
+		}
+		host, err := addServiceHost(nc, cfg, mergeMetadata(cfg.metadata, map[string]string{"schema_hash": StreamDemoServiceSchemaHash}))
+		return host, false, err
+	})
+}
 
-	svc, err := micro.AddService(nc, micro.Config{
-		Name:         cfg.name,
-		Version:      cfg.version,
-		Metadata:     cfg.metadata,
-		Description:  cfg.description,
-		StatsHandler: cfg.statsHandler,
-		DoneHandler:  cfg.doneHandler,
-		ErrorHandler: cfg.errorHandler,
+// AddStreamDemoServiceEndpoints registers the StreamDemoService endpoints on svc, a
+// micro.Service the caller added on nc, so they share its name, version and stats
+// with the endpoints of other services and the caller's own:
+//
+//	svc, err := micro.AddService(nc, micro.Config{Name: "catalog", Version: "1.0.0"})
+//	...
+//	err = AddStreamDemoServiceEndpoints(nc, svc, impl, WithServerInterceptor(auth))
+//
+// As on a ServiceGroup, endpoint names are qualified with the service name
+// ("stream_demo_service-health") while subjects keep the subject prefix, and the
+// schema_hash is advertised as endpoint metadata. Options of the micro.Service
+// itself, which NewServiceGroup lists, are rejected with an error, as is
+// WithServiceGroup. Stopping svc stops the endpoints; there is no Drain.
+func AddStreamDemoServiceEndpoints(nc *nats.Conn, svc micro.Service, impl StreamDemoServiceNats, opts ...RegisterOption) error {
+	cfg := &registerConfig{
+		subjectPrefix:  "api.v1.stream",
+		queueGroup:     "",
+		timeout:        0 * time.Second, // Service-level timeout (0 = no timeout)
+		tokenSanitizer: SanitizeToken,
+		streamWindow:   defaultStreamWindow,
+		metadata:       map[string]string{},
+	}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	host, err := existingServiceHost(nc, svc, cfg)
+	if err != nil {
+		return err
+	}
+	// The subjects and $schema document describe the proto service
+	cfg.name, cfg.version = "stream_demo_service", "1.0.0"
+	_, err = registerStreamDemoServiceEndpoints(nc, impl, cfg, func() (*serviceHost, bool, error) {
+		return host, true, nil
 	})
+	return err
+}
+
+// registerStreamDemoServiceEndpoints registers the StreamDemoService endpoints cfg describes
+// on the host attach returns, once the configuration checks out. Endpoint names
+// are qualified when attach reports the host is shared with other services.
+func registerStreamDemoServiceEndpoints(nc *nats.Conn, impl StreamDemoServiceNats, cfg *registerConfig, attach func() (*serviceHost, bool, error)) (_ StreamDemoServiceService, err error) {
+
+	// Describe each endpoint from the schema blob
+	schemaMetadata, err := schemaEndpointMetadata(streamDemoServiceSchema, "streaming.v1.StreamDemoService")
+	if err != nil {
+		return nil, err
+	}
+	endpointMethods := map[string]bool{
+		"Ping":    true,
+		"CountUp": true,
+		"Sum":     true,
+		"Chat":    true,
+	}
+	for method := range cfg.endpointMetadata {
+		if !endpointMethods[method] {
+			return nil, fmt.Errorf("WithEndpointMetadata: StreamDemoService has no method %s", method)
+		}
+	}
+
+	// Streams are compressed with gzip unless WithCompression names another codec
+	compressor := compressorFor(streamEncodingGzip)
+	if cfg.compressionCodec != "" {
+		if compressor = compressorFor(cfg.compressionCodec); compressor == nil {
+			return nil, fmt.Errorf("WithCompression: codec %q is not registered; add it with RegisterCompressor", cfg.compressionCodec)
+		}
+	}
+
+	host, shared, err := attach()
 	if err != nil {
-		return nil, fmt.Errorf("failed to add service: %w", err)
+		return nil, err
+	}
+	endpointPrefix := "" // Qualifies endpoint names on a shared micro.Service
+	if shared {
+		endpointPrefix = "stream_demo_service-"
+	} else {
+		// Stop the micro.Service again if an endpoint fails to register
+		defer func() {
+			if err != nil {
+				host.svc.Stop()
+			}
+		}()
 	}
+	svc, pool := host.svc, host.pool
 
 	// Chain server interceptors
 	var chainedInterceptor UnaryServerInterceptor
@@ -407,24 +437,40 @@ func RegisterStreamDemoServiceHandlers(nc *nats.Conn, impl StreamDemoServiceNats
 	}
 
 	handlers := &streamDemoServiceHandlers{
-		nc:             nc,
-		impl:           impl,
-		serviceTimeout: cfg.timeout,
-		useJSON:        false,
-		interceptor:    chainedInterceptor,
-		js:             cfg.js,
-	}
-
-	// Auto-create KV and Object Store buckets if JetStream is available
-	if cfg.js != nil {
-		ctx := context.Background()
-		_ = ctx
-	}
-
-	// Map of endpoint names to their handlers
+		nc:                nc,
+		impl:              impl,
+		serviceTimeout:    cfg.timeout,
+		useJSON:           false,
+		interceptor:       chainedInterceptor,
+		js:                cfg.js,
+		cancels:           host.cancels,
+		tokenSanitizer:    cfg.tokenSanitizer,
+		idGenerator:       cfg.idGenerator,
+		maxRequestSize:    cfg.maxRequestSize,
+		streamWindow:      cfg.streamWindow,
+		streamAllowGaps:   cfg.streamAllowGaps,
+		streamCompression: cfg.streamCompression,
+		compression:       cfg.compression,
+		compressor:        compressor,
+		responseHeaders:   cfg.responseHeaders,
+		requestCheck:      cfg.requestCheck,
+		streamInterceptor: chainStreamServerInterceptors(cfg.streamInterceptors),
+		endpointPrefix:    endpointPrefix,
+		inflight:          host.inflight,
+		sampler:           newSampler(cfg.sampling, cfg.samplingSalt),
+		maxServerDeadline: cfg.maxServerDeadline,
+		persistenceErrors: cfg.persistenceErrors,
+		panics:            cfg.panicDiagnostics,
+		failures:          newFailureRecorder(cfg),
+	}
+
+	// Map of endpoint names to their handlers; unary ones go through the handler pool,
+	// idempotent ones are coalesced first, and those expecting a response are
+	// deduplicated by idempotency key inside the pool
+	idempotency := newIdempotencyGuard(cfg)
 	endpoints := map[string]micro.Handler{
 
-		"ping": micro.HandlerFunc(handlers.Ping),
+		"ping": pool.wrap(endpointPrefix+"ping", idempotency.wrap(endpointPrefix+"ping", micro.HandlerFunc(handlers.Ping))),
 
 		"count_up": micro.HandlerFunc(handlers.CountUp),
 
@@ -433,16 +479,27 @@ func RegisterStreamDemoServiceHandlers(nc *nats.Conn, impl StreamDemoServiceNats
 		"chat": micro.HandlerFunc(handlers.Chat),
 	}
 
-	// Map of endpoint names to their metadata
+	// Map of endpoint names to their metadata: the schema's description of the
+	// method, overlaid with (natsmicro.endpoint).metadata, then WithEndpointMetadata
 	endpointMetadata := map[string]map[string]string{
 
-		"ping": {},
+		"ping": mergeMetadata(mergeMetadata(schemaMetadata["Ping"], map[string]string{}), cfg.endpointMetadata["Ping"]),
 
-		"count_up": {},
+		"count_up": mergeMetadata(mergeMetadata(schemaMetadata["CountUp"], map[string]string{}), cfg.endpointMetadata["CountUp"]),
+
+		"sum": mergeMetadata(mergeMetadata(schemaMetadata["Sum"], map[string]string{}), cfg.endpointMetadata["Sum"]),
+
+		"chat": mergeMetadata(mergeMetadata(schemaMetadata["Chat"], map[string]string{}), cfg.endpointMetadata["Chat"]),
+	}
 
-		"sum": {},
+	// Map of endpoint names to exact subjects from (natsmicro.endpoint).subject,
+	// or the wildcard form of subject_template
+	// These endpoints are registered outside the subject prefix group
+	endpointSubjects := map[string]string{}
 
-		"chat": {},
+	// Endpoints WithInstanceSubjects also serves on <subject>.<instance ID>
+	instanceEndpoints := map[string]bool{
+		"ping": true,
 	}
 
 	// Use interface to handle both Service and Group
@@ -450,36 +507,146 @@ func RegisterStreamDemoServiceHandlers(nc *nats.Conn, impl StreamDemoServiceNats
 		AddEndpoint(string, micro.Handler, ...micro.EndpointOpt) error
 	}
 
-	var adder endpointAdder = svc
+	var group endpointAdder = svc
 	if cfg.subjectPrefix != "" {
-		adder = svc.AddGroup(cfg.subjectPrefix)
+		group = svc.AddGroup(cfg.subjectPrefix)
 	}
 
 	// Register all endpoints with their metadata
 	for name, handler := range endpoints {
 		opts := []micro.EndpointOpt{}
-		if metadata, exists := endpointMetadata[name]; exists && len(metadata) > 0 {
+		metadata := endpointMetadata[name]
+		if cfg.maxRequestSize > 0 {
+			// Advertise the request size limit alongside the proto metadata
+			withLimit := map[string]string{"max_request_size": fmt.Sprint(cfg.maxRequestSize)}
+			for k, v := range metadata {
+				withLimit[k] = v
+			}
+			metadata = withLimit
+		}
+		if shared {
+			// Keep the unqualified subject; the shared service's metadata cannot
+			// carry every service's schema hash
+			opts = append(opts, micro.WithEndpointSubject(name))
+			metadata = mergeMetadata(metadata, map[string]string{"schema_hash": StreamDemoServiceSchemaHash})
+			if cfg.queueGroup != "" {
+				opts = append(opts, micro.WithEndpointQueueGroup(cfg.queueGroup))
+			}
+		}
+		if len(metadata) > 0 {
 			opts = append(opts, micro.WithEndpointMetadata(metadata))
 		}
-		if err := adder.AddEndpoint(name, handler, opts...); err != nil {
+		adder := group
+		subject := name
+		if exact, exists := endpointSubjects[name]; exists {
+			adder = svc
+			subject = exact
+			opts = append(opts, micro.WithEndpointSubject(subject))
+		}
+		if cfg.instanceSubjects && instanceEndpoints[name] {
+			// Responses name the instance, for ResponderInstance
+			handler = respondAsInstance(svc.Info().ID, handler)
+		}
+		if err := adder.AddEndpoint(endpointPrefix+name, holdRequests(cfg.hold, handler), opts...); err != nil {
 			return nil, fmt.Errorf("failed to add endpoint %s: %w", name, err)
 		}
+		if cfg.instanceSubjects && instanceEndpoints[name] {
+			// The same handler on this instance's own subject, for WithTargetInstance
+			opts = append(opts, micro.WithEndpointSubject(subject+"."+svc.Info().ID))
+			if err := adder.AddEndpoint(endpointPrefix+name+"-instance", holdRequests(cfg.hold, handler), opts...); err != nil {
+				return nil, fmt.Errorf("failed to add instance endpoint %s: %w", name, err)
+			}
+		}
+	}
+
+	pool.start()
+
+	// Application health endpoint, registered outside the subject prefix group
+	if !cfg.noHealthEndpoint {
+		subject := healthSubject(cfg.subjectPrefix, "stream_demo_service")
+		if err := svc.AddEndpoint(endpointPrefix+"health", holdRequests(cfg.hold, newHealthHandler(impl, cfg.timeout)), micro.WithEndpointSubject(subject)); err != nil {
+			return nil, fmt.Errorf("failed to add health endpoint: %w", err)
+		}
+	}
+
+	// Schema reflection endpoint, registered outside the subject prefix group
+	if !cfg.noReflectEndpoint {
+		subject := reflectSubject(cfg.subjectPrefix, "stream_demo_service")
+		if err := svc.AddEndpoint(endpointPrefix+"reflect", holdRequests(cfg.hold, newReflectHandler(streamDemoServiceSchema, StreamDemoServiceSchemaHash)), micro.WithEndpointSubject(subject)); err != nil {
+			return nil, fmt.Errorf("failed to add reflect endpoint: %w", err)
+		}
+	}
+
+	// Schema document endpoint, registered outside the subject prefix group
+	if !cfg.noSchemaEndpoint {
+		handler, err := newSchemaHandler(streamDemoServiceSchemaDocument, cfg)
+		if err != nil {
+			return nil, err
+		}
+		subject := schemaSubject(cfg.subjectPrefix, "stream_demo_service")
+		if err := svc.AddEndpoint(endpointPrefix+"schema", holdRequests(cfg.hold, handler), micro.WithEndpointSubject(subject)); err != nil {
+			return nil, fmt.Errorf("failed to add schema endpoint: %w", err)
+		}
+	}
+
+	queueGroup := cfg.queueGroup
+	if queueGroup == "" {
+		queueGroup = micro.DefaultQueueGroup
 	}
 
 	return &streamDemoServiceService{
-		Service:       svc,
-		subjectPrefix: cfg.subjectPrefix,
+		Service:        svc,
+		subjectPrefix:  cfg.subjectPrefix,
+		queueGroup:     queueGroup,
+		maxRequestSize: cfg.maxRequestSize,
+		inflight:       handlers.inflight,
+		interceptors:   interceptorChainNames(cfg.serverInterceptors),
+		sampler:        handlers.sampler,
 	}, nil
 }
 
+// StreamDemoServiceRegistration describes a RegisterStreamDemoServiceHandlers call for
+// RegisterGroup, which registers it together with other services, all or nothing
+func StreamDemoServiceRegistration(impl StreamDemoServiceNats, opts ...RegisterOption) Registration {
+	return registrationFunc(func(nc *nats.Conn, hold func(context.Context) error) (GroupService, error) {
+		return RegisterStreamDemoServiceHandlers(nc, impl, append(opts[:len(opts):len(opts)], withRequestHold(hold))...)
+	})
+}
+
 // streamDemoServiceHandlers wraps the service implementation with NATS handlers
 type streamDemoServiceHandlers struct {
-	nc             *nats.Conn // NATS connection for streaming
-	impl           StreamDemoServiceNats
-	serviceTimeout time.Duration          // Default timeout for all endpoints
-	useJSON        bool                   // Use JSON encoding instead of binary protobuf
-	interceptor    UnaryServerInterceptor // Chained interceptors
-	js             jetstream.JetStream    // Optional JetStream context for KV/ObjectStore
+	nc                 *nats.Conn // NATS connection for streaming
+	impl               StreamDemoServiceNats
+	serviceTimeout     time.Duration                     // Default timeout for all endpoints
+	useJSON            bool                              // Use JSON encoding instead of binary protobuf
+	interceptor        UnaryServerInterceptor            // Chained interceptors
+	methodInterceptors map[string]UnaryServerInterceptor // Interceptors plus (natsmicro.endpoint).middlewares, by method
+	js                 jetstream.JetStream               // Optional JetStream context for KV/ObjectStore
+	cancels            *cancelRegistry                   // Client cancel notices (nil unless WithCancelPropagation)
+	tokenSanitizer     func(string) string               // Escapes request fields in KV/Object Store keys
+	idGenerator        func() string                     // Mints stream inbox and persistent call IDs (nil = NUIDs)
+	maxRequestSize     int                               // Largest accepted request payload in bytes (0 = unlimited)
+	inflight           *inflightTracker                  // Running handlers, for Drain
+	persistentStreams  map[string]*persistentStream      // (natsmicro.stream).persistence streams, by method
+	streamWindow       int                               // Server-stream flow control cap (0 = none)
+	streamCompression  int                               // Smallest stream message compressed, once negotiated (0 = off)
+	compression        int                               // Smallest unary response compressed, if the client accepts it (0 = off)
+	compressor         Compressor                        // Codec for responses and stream messages
+	streamAllowGaps    bool                              // Skip lost client-stream messages instead of failing Recv
+	responseHeaders    *headerPolicy                     // Strips response headers (nil = allow all)
+	requestCheck       *requestImmutabilityCheck         // Reports handlers that modify their request (nil = off)
+	streamInterceptor  StreamServerInterceptor           // Chained stream interceptors
+	endpointPrefix     string                            // Qualifies endpoint names within a ServiceGroup
+	sampler            *sampler                          // Samples unary calls (WithSampling)
+	maxServerDeadline  time.Duration                     // Cap on propagated client deadlines (0 = none)
+	persistenceErrors  func(method string, err error)    // Receives failed KV/Object Store writes (nil = print)
+	panics             *panicDiagnostics                 // Recovers and reports handler panics (nil = off)
+	failures           *failureRecorder                  // Records failed calls for replay (nil = off)
+}
+
+// keyToken renders a request field for a key template through the token sanitizer
+func (h *streamDemoServiceHandlers) keyToken(v any) string {
+	return h.tokenSanitizer(fmt.Sprint(v))
 }
 
 func (h *streamDemoServiceHandlers) Ping(req micro.Request) {
@@ -488,14 +655,26 @@ func (h *streamDemoServiceHandlers) Ping(req micro.Request) {
 	timeout := h.serviceTimeout
 	timeout = 5 * time.Second // Endpoint-specific timeout
 
-	// Create context with timeout if configured, otherwise use background context
-	ctx := context.Background()
+	// Create context with timeout if configured, derived from the service's drain context
+	ctx, end := h.inflight.begin(false) // Tracked for Drain
+	defer end()
 	var cancel context.CancelFunc
 	if timeout > 0 {
 		ctx, cancel = context.WithTimeout(ctx, timeout)
 		defer cancel()
 	}
 
+	// Stop when the client gives up: honor the deadline it propagated
+	ctx, cancelDeadline := withPropagatedDeadline(ctx, req.Headers(), h.maxServerDeadline)
+	defer cancelDeadline()
+
+	// Cancel the handler early if the client abandons the request
+	if h.cancels != nil {
+		var release func()
+		ctx, release = h.cancels.watch(ctx, req.Headers())
+		defer release()
+	}
+
 	// Add incoming NATS headers to context so service methods can access them
 	if req.Headers() != nil {
 		ctx = WithIncomingHeaders(ctx, req.Headers())
@@ -503,62 +682,69 @@ func (h *streamDemoServiceHandlers) Ping(req micro.Request) {
 
 	// Initialize outgoing headers pointer in context so interceptors can set response headers
 	outgoingHeadersPtr := &nats.Header{}
-	ctx = context.WithValue(ctx, outgoingHeadersKey, outgoingHeadersPtr)
+	ctx = withPendingResponseHeaders(ctx, outgoingHeadersPtr)
 
+	// Reject oversized requests before decoding them, decompressing compressed ones
+	body, payloadErr := readPayload("request", req.Headers().Get(ContentEncodingHeader), req.Data(), h.maxRequestSize)
+	if payloadErr != nil {
+		code, message, data := natsErrorFields(payloadErr)
+		req.Error(code, message, data)
+		return
+	}
+
+	// Decode with the codec the client named; clients without Content-Type use the configured one
+	requestJSON, ctErr := payloadUsesJSON(req.Headers().Get(ContentTypeHeader), h.useJSON)
+	if ctErr != nil {
+		code, message, data := natsErrorFields(ctErr)
+		req.Error(code, message, data)
+		return
+	}
 	var msg PingRequest
-	if h.useJSON {
-		if err := protojson.Unmarshal(req.Data(), &msg); err != nil {
+	if requestJSON {
+		if err := protojson.Unmarshal(body, &msg); err != nil {
 			req.Error(StreamDemoServiceErrCodeInvalidArgument, fmt.Sprintf("failed to decode JSON request: %v", err), nil)
 			return
 		}
 	} else {
-		if err := proto.Unmarshal(req.Data(), &msg); err != nil {
+		if err := proto.Unmarshal(body, &msg); err != nil {
 			req.Error(StreamDemoServiceErrCodeInvalidArgument, fmt.Sprintf("failed to decode request: %v", err), nil)
 			return
 		}
 	}
 
+	// Sample the call for WithSampling, capturing the request before the handler runs
+	sample := h.sampler.begin("StreamDemoService", "Ping", incomingRequestID(req), false, nats.Header(req.Headers()), &msg, false)
+
 	// Define the handler function
 	handler := func(ctx context.Context, request interface{}) (interface{}, error) {
 		typedReq, ok := request.(*PingRequest)
 		if !ok {
 			return nil, fmt.Errorf("invalid request type")
 		}
+		defer h.requestCheck.check("StreamDemoService.Ping", typedReq)()
 		return h.impl.Ping(ctx, typedReq)
 	}
+	handler = h.panics.wrap("StreamDemoService", "Ping", "streaming.v1.StreamDemoService.Ping", false, handler)
+	handler = h.failures.wrap("StreamDemoService", "Ping", "streaming.v1.StreamDemoService.Ping", false, handler)
 
 	// Execute through interceptor chain if configured
 	var resp interface{}
 	var err error
-	if h.interceptor != nil {
+	interceptor := h.interceptor
+	if interceptor != nil {
 		info := &UnaryServerInfo{
-			Service: "StreamDemoService",
-			Method:  "Ping",
-			Subject: "api.v1.stream.ping",
+			Service:    "StreamDemoService",
+			Method:     "Ping",
+			FullMethod: "streaming.v1.StreamDemoService.Ping",
+			Subject:    "api.v1.stream.ping",
 		}
-		resp, err = h.interceptor(ctx, &msg, info, handler)
+		resp, err = interceptor(ctx, &msg, info, handler)
 	} else {
 		resp, err = handler(ctx, &msg)
 	}
+	sample.end(resp, err)
 	if err != nil {
-		// Check if error implements custom status code/message/data methods
-		code := StreamDemoServiceErrCodeInternal
-		message := err.Error()
-		var data []byte
-
-		// Check for NatsErrorCode() string method
-		if coder, ok := err.(interface{ NatsErrorCode() string }); ok {
-			code = coder.NatsErrorCode()
-		}
-		// Check for NatsErrorMessage() string method
-		if messager, ok := err.(interface{ NatsErrorMessage() string }); ok {
-			message = messager.NatsErrorMessage()
-		}
-		// Check for NatsErrorData() []byte method
-		if dataProvider, ok := err.(interface{ NatsErrorData() []byte }); ok {
-			data = dataProvider.NatsErrorData()
-		}
-
+		code, message, data := natsErrorFields(err)
 		req.Error(code, message, data)
 		return
 	}
@@ -572,7 +758,7 @@ func (h *streamDemoServiceHandlers) Ping(req micro.Request) {
 
 	var data []byte
 	if h.useJSON {
-		data, err = protojson.Marshal(typedResp)
+		data, err = marshalJSON(typedResp, false)
 		if err != nil {
 			req.Error(StreamDemoServiceErrCodeInternal, fmt.Sprintf("failed to encode JSON response: %v", err), nil)
 			return
@@ -587,68 +773,139 @@ func (h *streamDemoServiceHandlers) Ping(req micro.Request) {
 
 	// Check if context has outgoing headers set by interceptors
 	// Read from the pointer that was initialized at the start
-	var outgoingHeaders nats.Header
-	if headersPtr, ok := ctx.Value(outgoingHeadersKey).(*nats.Header); ok && headersPtr != nil {
-		outgoingHeaders = *headersPtr
-	}
+	outgoingHeaders := pendingResponseHeaders(ctx)
 
-	if len(outgoingHeaders) > 0 {
-		// Send response with headers using micro.WithHeaders
-		// Convert nats.Header to micro.Headers (they are the same underlying type)
-		if err := req.Respond(data, micro.WithHeaders(micro.Headers(outgoingHeaders))); err != nil {
-			fmt.Fprintf(os.Stderr, "failed to send response for Ping: %v\n", err)
-		}
-	} else {
-		// Send response without headers
-		if err := req.Respond(data); err != nil {
-			fmt.Fprintf(os.Stderr, "failed to send response for Ping: %v\n", err)
-		}
+	// Send response with headers set by interceptors plus the response codec
+	// Convert nats.Header to micro.Headers (they are the same underlying type)
+	outgoingHeaders = h.responseHeaders.strip(h.endpointPrefix+"ping", outgoingHeaders)
+	outgoingHeaders = withContentType(outgoingHeaders, h.useJSON)
+	if h.compression > 0 && acceptsEncoding(req.Headers().Get(acceptEncodingHeader), h.compressor.Name()) {
+		data = compressPayload(h.compressor, h.compression, data, outgoingHeaders)
+	}
+	if err := req.Respond(data, micro.WithHeaders(micro.Headers(outgoingHeaders))); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to send response for Ping: %v\n", err)
 	}
 }
 
 // CountUp handles server-side streaming RPC.
 // Client sends a single request; server streams back multiple responses.
 func (h *streamDemoServiceHandlers) CountUp(req micro.Request) {
-	ctx := context.Background()
+	// Determine effective timeout: endpoint-specific timeout overrides service timeout
+	timeout := h.serviceTimeout
+
+	ctx, end := h.inflight.begin(true) // Tracked for Drain
+	defer end()
+	var cancel context.CancelFunc
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	// Stop when the client gives up: honor the deadline it propagated
+	ctx, cancelDeadline := withPropagatedDeadline(ctx, req.Headers(), h.maxServerDeadline)
+	defer cancelDeadline()
 
 	if req.Headers() != nil {
 		ctx = WithIncomingHeaders(ctx, req.Headers())
 	}
 
+	// Reject oversized requests before decoding them, decompressing compressed ones
+	body, payloadErr := readPayload("request", req.Headers().Get(ContentEncodingHeader), req.Data(), h.maxRequestSize)
+	if payloadErr != nil {
+		code, message, data := natsErrorFields(payloadErr)
+		rejectStream(h.nc, req, code, message, data)
+		return
+	}
+
+	requestJSON, ctErr := payloadUsesJSON(req.Headers().Get(ContentTypeHeader), h.useJSON)
+	if ctErr != nil {
+		code, message, data := natsErrorFields(ctErr)
+		rejectStream(h.nc, req, code, message, data)
+		return
+	}
 	var msg CountUpRequest
-	if h.useJSON {
-		if err := protojson.Unmarshal(req.Data(), &msg); err != nil {
-			req.Error(StreamDemoServiceErrCodeInvalidArgument, fmt.Sprintf("failed to decode request: %v", err), nil)
+	if requestJSON {
+		if err := protojson.Unmarshal(body, &msg); err != nil {
+			rejectStream(h.nc, req, StreamDemoServiceErrCodeInvalidArgument, fmt.Sprintf("failed to decode request: %v", err), nil)
 			return
 		}
 	} else {
-		if err := proto.Unmarshal(req.Data(), &msg); err != nil {
-			req.Error(StreamDemoServiceErrCodeInvalidArgument, fmt.Sprintf("failed to decode request: %v", err), nil)
+		if err := proto.Unmarshal(body, &msg); err != nil {
+			rejectStream(h.nc, req, StreamDemoServiceErrCodeInvalidArgument, fmt.Sprintf("failed to decode request: %v", err), nil)
 			return
 		}
 	}
 
+	streamOpts, err := parseStreamOptions(req.Headers())
+	if err != nil {
+		rejectStream(h.nc, req, StreamDemoServiceErrCodeInvalidArgument, err.Error(), nil)
+		return
+	}
+	window, creditInbox, err := parseStreamWindow(req.Headers(), h.streamWindow)
+	if err != nil {
+		rejectStream(h.nc, req, StreamDemoServiceErrCodeInvalidArgument, err.Error(), nil)
+		return
+	}
+
 	// Get the client's reply subject from the NATS request
-	replySubject := req.Headers().Get("Reply-To")
+	var replySubject string
+	if req.Headers() != nil {
+		replySubject = req.Headers().Get("Reply-To")
+	}
 	if replySubject == "" {
 		// Fall back to using the NATS request reply subject
 		// We need to signal to the client that we're starting a stream
 		// First, acknowledge the request by responding with the stream inbox
-		inbox := nats.NewInbox()
+		inbox := mintInbox(h.idGenerator)
 		replySubject = inbox
 		ackHeader := nats.Header{}
 		ackHeader.Set(natsStreamInboxHeader, inbox)
 		req.Respond(nil, micro.WithHeaders(micro.Headers(ackHeader)))
 	}
 
+	// Headers the handler sets with SetResponseHeaders go out on the first message
+	outgoingHeadersPtr := &nats.Header{}
+	ctx = withPendingResponseHeaders(ctx, outgoingHeadersPtr)
 	sender := newServerStreamSender(h.nc, replySubject)
+	sender.responseHeaders = func() nats.Header {
+		return h.responseHeaders.strip(h.endpointPrefix+"count_up", *outgoingHeadersPtr)
+	}
+	if window > 0 {
+		if err := sender.enableFlowControl(ctx, window, creditInbox); err != nil {
+			sender.CloseWithError(StreamDemoServiceErrCodeInternal, err.Error())
+			return
+		}
+	}
+	sender.compressor, sender.encoding = negotiateStreamCompression(req.Headers(), h.streamCompression, h.compressor)
+	if sender.compressor != nil {
+		streamOpts.Encoding, streamOpts.CompressionThreshold = sender.encoding, h.streamCompression
+	}
+
+	// Send a GOAWAY when the service drains; the client closing the stream after it
+	// ends the handler's context early
+	ctx, endStream := context.WithCancelCause(ctx)
+	defer endStream(nil)
+	defer h.inflight.onDrain(func(deadline time.Time) {
+		sender.goAway(deadline, mintInbox(h.idGenerator), func() { endStream(errStreamDrainAcknowledged) })
+	})()
 	stream := &StreamDemoService_CountUp_Stream{
 		sender:  sender,
 		useJSON: h.useJSON,
+		options: streamOpts,
+		request: &msg,
 	}
 
-	if err := h.impl.CountUp(ctx, &msg, stream); err != nil {
-		sender.CloseWithError(StreamDemoServiceErrCodeInternal, err.Error())
+	// Run through the stream interceptors, which may replace the request
+	err = interceptStream(ctx, h.streamInterceptor, stream, &StreamInfo{Service: "StreamDemoService", Method: "CountUp", FullMethod: "streaming.v1.StreamDemoService.CountUp", IsServerStream: true}, func(ctx context.Context, ss ServerStream) error {
+		stream.intercept(ss)
+		req, err := interceptedRecv[*CountUpRequest](ctx, ss)
+		if err != nil {
+			return err
+		}
+		return h.impl.CountUp(ctx, req, stream)
+	})
+	if err != nil {
+		sender.closeWithStatus(err) // Keeps the code and details of a *Status or service error
 		return
 	}
 	sender.Close()
@@ -657,24 +914,48 @@ func (h *streamDemoServiceHandlers) CountUp(req micro.Request) {
 // Sum handles client-side streaming RPC.
 // Client streams multiple requests; server responds once.
 func (h *streamDemoServiceHandlers) Sum(req micro.Request) {
-	ctx := context.Background()
+	// Determine effective timeout: endpoint-specific timeout overrides service timeout
+	timeout := h.serviceTimeout
+
+	ctx, end := h.inflight.begin(true) // Tracked for Drain
+	defer end()
+	var cancel context.CancelFunc
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
 
 	if req.Headers() != nil {
 		ctx = WithIncomingHeaders(ctx, req.Headers())
 	}
 
 	// Create an inbox for receiving the client's stream messages
-	inbox := nats.NewInbox()
-	receiver, err := newClientStreamReceiver(h.nc, inbox, false)
+	inbox := mintInbox(h.idGenerator)
+	receiver, err := newClientStreamReceiver(h.nc, inbox, h.streamAllowGaps)
 	if err != nil {
 		req.Error(StreamDemoServiceErrCodeInternal, fmt.Sprintf("failed to setup stream: %v", err), nil)
 		return
 	}
 	defer receiver.Close()
+	receiver.maxSize = h.maxRequestSize
 
-	// Tell the client where to send stream messages
+	// A client cancel frame ends the handler's context; context.Cause(ctx) says why
+	ctx, cancelStream := context.WithCancelCause(ctx)
+	defer cancelStream(nil)
+	receiver.setCancelFunc(cancelStream)
+
+	// The deadline the client propagated ends the stream as its cancel frame would
+	if timeout, ok := propagatedTimeout(req.Headers(), h.maxServerDeadline); ok {
+		expire := time.AfterFunc(timeout, func() { receiver.cancel("DEADLINE_EXCEEDED") })
+		defer expire.Stop()
+	}
+
+	// Tell the client where to send stream messages, and whether it may compress them
 	ackHeader := nats.Header{}
 	ackHeader.Set(natsStreamInboxHeader, inbox)
+	if h.streamCompression > 0 && acceptsStreamEncoding(req.Headers(), h.compressor.Name()) {
+		ackHeader.Set(StreamEncodingHeader, h.compressor.Name())
+	}
 	req.Respond(nil, micro.WithHeaders(micro.Headers(ackHeader)))
 
 	stream := &StreamDemoService_Sum_Stream{
@@ -682,28 +963,57 @@ func (h *streamDemoServiceHandlers) Sum(req micro.Request) {
 		useJSON:  h.useJSON,
 	}
 
-	resp, err := h.impl.Sum(ctx, stream)
+	// Run through the stream interceptors; the response reaches them through SendMsg
+	err = interceptStream(ctx, h.streamInterceptor, stream, &StreamInfo{Service: "StreamDemoService", Method: "Sum", FullMethod: "streaming.v1.StreamDemoService.Sum", IsClientStream: true}, func(ctx context.Context, ss ServerStream) error {
+		stream.intercept(ss)
+		resp, err := h.impl.Sum(ctx, stream)
+		if err != nil {
+			return err
+		}
+		return ss.SendMsg(resp)
+	})
+	resp := stream.response
 	if err != nil {
-		// Cannot send error via req since we already responded with ack
-		log.Printf("[nats-micro] ERROR: Sum client stream handler failed: %v", err)
+		// Ack was already sent, so we can't use req.Error().
+		// Publish the error back to the client's Reply-To inbox using the stream
+		// error protocol, so the client doesn't hang waiting for a response.
+		fmt.Fprintf(os.Stderr, "[nats-micro] ERROR: Sum client stream handler failed: %v\n", err)
+		var replySubject string
+		if req.Headers() != nil {
+			replySubject = req.Headers().Get("Reply-To")
+		}
+		if replySubject != "" {
+			code, message, details := natsErrorFields(err)
+			errMsg := &nats.Msg{
+				Subject: replySubject,
+				Data:    details,
+				Header:  nats.Header{},
+			}
+			errMsg.Header.Set("Nats-Service-Error-Code", code)
+			errMsg.Header.Set("Nats-Service-Error", message)
+			h.nc.PublishMsg(errMsg)
+		}
 		return
 	}
 
 	// Send final response back via the original reply subject
 	var data []byte
 	if h.useJSON {
-		data, err = protojson.Marshal(resp)
+		data, err = marshalJSON(resp, false)
 	} else {
 		data, err = proto.Marshal(resp)
 	}
 	if err != nil {
-		log.Printf("[nats-micro] ERROR: failed to marshal Sum response: %v", err)
+		fmt.Fprintf(os.Stderr, "[nats-micro] ERROR: failed to marshal Sum response: %v\n", err)
 		return
 	}
 
 	// Publish the final response to the client's reply inbox
 	// The client will have subscribed for the reply
-	replySubject := req.Headers().Get("Reply-To")
+	var replySubject string
+	if req.Headers() != nil {
+		replySubject = req.Headers().Get("Reply-To")
+	}
 	if replySubject != "" {
 		h.nc.Publish(replySubject, data)
 	}
@@ -712,41 +1022,86 @@ func (h *streamDemoServiceHandlers) Sum(req micro.Request) {
 // Chat handles bidirectional streaming RPC.
 // Both client and server can send and receive messages concurrently.
 func (h *streamDemoServiceHandlers) Chat(req micro.Request) {
-	ctx := context.Background()
+	// Determine effective timeout: endpoint-specific timeout overrides service timeout
+	timeout := h.serviceTimeout
+
+	ctx, end := h.inflight.begin(true) // Tracked for Drain
+	defer end()
+	var cancel context.CancelFunc
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
 
 	if req.Headers() != nil {
 		ctx = WithIncomingHeaders(ctx, req.Headers())
 	}
 
 	// Create inbox for receiving client stream messages
-	serverInbox := nats.NewInbox()
-	receiver, err := newClientStreamReceiver(h.nc, serverInbox, false)
+	serverInbox := mintInbox(h.idGenerator)
+	receiver, err := newClientStreamReceiver(h.nc, serverInbox, h.streamAllowGaps)
 	if err != nil {
 		req.Error(StreamDemoServiceErrCodeInternal, fmt.Sprintf("failed to setup stream: %v", err), nil)
 		return
 	}
 	defer receiver.Close()
+	receiver.maxSize = h.maxRequestSize
+
+	// A client cancel frame ends the handler's context; context.Cause(ctx) says why
+	ctx, cancelStream := context.WithCancelCause(ctx)
+	defer cancelStream(nil)
+	receiver.setCancelFunc(cancelStream)
+
+	// The deadline the client propagated ends the stream as its cancel frame would
+	if timeout, ok := propagatedTimeout(req.Headers(), h.maxServerDeadline); ok {
+		expire := time.AfterFunc(timeout, func() { receiver.cancel("DEADLINE_EXCEEDED") })
+		defer expire.Stop()
+	}
 
 	// Get/create the reply subject for server→client messages
-	clientInbox := req.Headers().Get("Reply-To")
+	var clientInbox string
+	if req.Headers() != nil {
+		clientInbox = req.Headers().Get("Reply-To")
+	}
 	if clientInbox == "" {
-		clientInbox = nats.NewInbox()
+		clientInbox = mintInbox(h.idGenerator)
 	}
 
-	// Tell the client where to send its stream messages and where we'll send ours
+	// Tell the client where to send its stream messages and where we'll send ours,
+	// and whether either side may compress them
+	compressor, encoding := negotiateStreamCompression(req.Headers(), h.streamCompression, h.compressor)
 	ackHeader := nats.Header{}
 	ackHeader.Set(natsStreamInboxHeader, serverInbox)
+	if encoding != "" {
+		ackHeader.Set(StreamEncodingHeader, encoding)
+	}
 	req.Respond(nil, micro.WithHeaders(micro.Headers(ackHeader)))
 
+	// Headers the handler sets with SetResponseHeaders go out on the first message
+	outgoingHeadersPtr := &nats.Header{}
+	ctx = withPendingResponseHeaders(ctx, outgoingHeadersPtr)
 	sender := newServerStreamSender(h.nc, clientInbox)
+	sender.compressor, sender.encoding = compressor, encoding
+	sender.responseHeaders = func() nats.Header {
+		return h.responseHeaders.strip(h.endpointPrefix+"chat", *outgoingHeadersPtr)
+	}
+	// Send a GOAWAY when the service drains; the client closing the stream after it
+	// ends the handler's context early
+	defer h.inflight.onDrain(func(deadline time.Time) {
+		sender.goAway(deadline, mintInbox(h.idGenerator), func() { cancelStream(errStreamDrainAcknowledged) })
+	})()
 	stream := &StreamDemoService_Chat_Stream{
 		sender:   sender,
 		receiver: receiver,
 		useJSON:  h.useJSON,
 	}
 
-	if err := h.impl.Chat(ctx, stream); err != nil {
-		sender.CloseWithError(StreamDemoServiceErrCodeInternal, err.Error())
+	err = interceptStream(ctx, h.streamInterceptor, stream, &StreamInfo{Service: "StreamDemoService", Method: "Chat", FullMethod: "streaming.v1.StreamDemoService.Chat", IsClientStream: true, IsServerStream: true}, func(ctx context.Context, ss ServerStream) error {
+		stream.intercept(ss)
+		return h.impl.Chat(ctx, stream)
+	})
+	if err != nil {
+		sender.closeWithStatus(err) // Keeps the code and details of a *Status or service error
 		return
 	}
 	sender.Close()
@@ -755,13 +1110,61 @@ func (h *streamDemoServiceHandlers) Chat(req micro.Request) {
 // StreamDemoService_CountUp_Stream is the server-side stream for CountUp.
 // The server calls Send() to push responses to the client.
 type StreamDemoService_CountUp_Stream struct {
-	sender  ServerStreamSender
-	useJSON bool
+	sender      ServerStreamSender
+	useJSON     bool
+	options     StreamOptions
+	request     *CountUpRequest // The opening request, returned by RecvMsg
+	intercepted ServerStream    // Passed on by stream interceptors (nil = none)
+}
+
+// Options returns the establishment options the client opened the stream with.
+func (s *StreamDemoService_CountUp_Stream) Options() StreamOptions {
+	return s.options
+}
+
+// SetResumeToken sets the token sent with the following messages. A client that
+// reopens the stream after one of them, e.g. when this server drains, passes it
+// back as Options().ResumeFrom, so the handler can carry on after that message.
+func (s *StreamDemoService_CountUp_Stream) SetResumeToken(token string) {
+	if sender, ok := s.sender.(*serverStreamSender); ok {
+		sender.setResumeToken(token)
+	}
 }
 
 // Send serializes and sends a response message to the client.
 func (s *StreamDemoService_CountUp_Stream) Send(msg *CountUpResponse) error {
-	return s.sender.SendMsg(msg, s.useJSON)
+	if s.intercepted != nil {
+		return s.intercepted.SendMsg(msg)
+	}
+	return s.send(msg)
+}
+
+// SendMsg sends a *CountUpResponse past the stream interceptors, which call it.
+func (s *StreamDemoService_CountUp_Stream) SendMsg(msg proto.Message) error {
+	return sendMessage(msg, s.send)
+}
+
+func (s *StreamDemoService_CountUp_Stream) send(msg *CountUpResponse) error {
+	if s.useJSON {
+		data, err := marshalJSON(msg, false)
+		if err != nil {
+			return fmt.Errorf("failed to marshal stream message: %w", err)
+		}
+		return s.sender.Send(data)
+	}
+	return s.sender.SendMsg(msg, false)
+}
+
+// RecvMsg returns the request the stream was opened with.
+func (s *StreamDemoService_CountUp_Stream) RecvMsg(context.Context) (proto.Message, error) {
+	return s.request, nil
+}
+
+// intercept makes Send go through stream, if a stream interceptor passed on another one
+func (s *StreamDemoService_CountUp_Stream) intercept(stream ServerStream) {
+	if own, ok := stream.(*StreamDemoService_CountUp_Stream); !ok || own != s {
+		s.intercepted = stream
+	}
 }
 
 // Close sends the end-of-stream marker.
@@ -777,12 +1180,26 @@ func (s *StreamDemoService_CountUp_Stream) CloseWithError(code string, message s
 // StreamDemoService_Sum_Stream is the server-side client-streaming handler for Sum.
 // The server calls Recv() to read messages from the client.
 type StreamDemoService_Sum_Stream struct {
-	receiver *ClientStreamReceiver
-	useJSON  bool
+	receiver    *ClientStreamReceiver
+	useJSON     bool
+	response    *SumResponse // Set by SendMsg, sent once the handler returns
+	intercepted ServerStream // Passed on by stream interceptors (nil = none)
 }
 
 // Recv blocks until the next client message arrives.
 func (s *StreamDemoService_Sum_Stream) Recv(ctx context.Context) (*SumRequest, error) {
+	if s.intercepted != nil {
+		return interceptedRecv[*SumRequest](ctx, s.intercepted)
+	}
+	return s.recv(ctx)
+}
+
+// RecvMsg receives the next client message past the stream interceptors, which call it.
+func (s *StreamDemoService_Sum_Stream) RecvMsg(ctx context.Context) (proto.Message, error) {
+	return recvMessage(s.recv(ctx))
+}
+
+func (s *StreamDemoService_Sum_Stream) recv(ctx context.Context) (*SumRequest, error) {
 	natsMsg, err := s.receiver.Recv(ctx)
 	if err != nil {
 		return nil, err
@@ -805,20 +1222,68 @@ func (s *StreamDemoService_Sum_Stream) Close() error {
 	return s.receiver.Close()
 }
 
+// SendMsg sets the response, a *SumResponse, sent once the handler returns.
+// The handler's return value is passed to it through the stream interceptors.
+func (s *StreamDemoService_Sum_Stream) SendMsg(msg proto.Message) error {
+	return sendMessage(msg, func(resp *SumResponse) error {
+		s.response = resp
+		return nil
+	})
+}
+
+// intercept makes Recv go through stream, if a stream interceptor passed on another one
+func (s *StreamDemoService_Sum_Stream) intercept(stream ServerStream) {
+	if own, ok := stream.(*StreamDemoService_Sum_Stream); !ok || own != s {
+		s.intercepted = stream
+	}
+}
+
 // StreamDemoService_Chat_Stream is the bidirectional stream for Chat.
 type StreamDemoService_Chat_Stream struct {
-	sender   ServerStreamSender
-	receiver *ClientStreamReceiver
-	useJSON  bool
+	sender      ServerStreamSender
+	receiver    *ClientStreamReceiver
+	useJSON     bool
+	intercepted ServerStream // Passed on by stream interceptors (nil = none)
 }
 
 // Send serializes and sends a response message to the client.
 func (s *StreamDemoService_Chat_Stream) Send(msg *ChatMessage) error {
-	return s.sender.SendMsg(msg, s.useJSON)
+	if s.intercepted != nil {
+		return s.intercepted.SendMsg(msg)
+	}
+	return s.send(msg)
+}
+
+// SendMsg sends a *ChatMessage past the stream interceptors, which call it.
+func (s *StreamDemoService_Chat_Stream) SendMsg(msg proto.Message) error {
+	return sendMessage(msg, s.send)
+}
+
+func (s *StreamDemoService_Chat_Stream) send(msg *ChatMessage) error {
+	if s.useJSON {
+		data, err := marshalJSON(msg, false)
+		if err != nil {
+			return fmt.Errorf("failed to marshal stream message: %w", err)
+		}
+		return s.sender.Send(data)
+	}
+	return s.sender.SendMsg(msg, false)
 }
 
 // Recv blocks until the next client message arrives.
 func (s *StreamDemoService_Chat_Stream) Recv(ctx context.Context) (*ChatMessage, error) {
+	if s.intercepted != nil {
+		return interceptedRecv[*ChatMessage](ctx, s.intercepted)
+	}
+	return s.recv(ctx)
+}
+
+// RecvMsg receives the next client message past the stream interceptors, which call it.
+func (s *StreamDemoService_Chat_Stream) RecvMsg(ctx context.Context) (proto.Message, error) {
+	return recvMessage(s.recv(ctx))
+}
+
+func (s *StreamDemoService_Chat_Stream) recv(ctx context.Context) (*ChatMessage, error) {
 	natsMsg, err := s.receiver.Recv(ctx)
 	if err != nil {
 		return nil, err
@@ -846,30 +1311,115 @@ func (s *StreamDemoService_Chat_Stream) CloseRecv() error {
 	return s.receiver.Close()
 }
 
+// intercept makes Send and Recv go through stream, if a stream interceptor passed on another one
+func (s *StreamDemoService_Chat_Stream) intercept(stream ServerStream) {
+	if own, ok := stream.(*StreamDemoService_Chat_Stream); !ok || own != s {
+		s.intercepted = stream
+	}
+}
+
 // StreamDemoServiceNatsClientInterface is the interface for the NATS client
 // This interface allows for easier dependency injection and testing
 type StreamDemoServiceNatsClientInterface interface {
-	Ping(context.Context, *PingRequest) (*PingResponse, error)
-	CountUp(ctx context.Context, req *CountUpRequest) (*StreamDemoService_CountUp_ClientStream, error)
+	Ping(context.Context, *PingRequest, ...CallOption) (*PingResponse, error)
+	CountUp(ctx context.Context, req *CountUpRequest, opts ...StreamCallOption) (*StreamDemoService_CountUp_ClientStream, error)
 	Sum(ctx context.Context) (*StreamDemoService_Sum_ClientStream, error)
 	Chat(ctx context.Context) (*StreamDemoService_Chat_ClientStream, error)
+	Health(ctx context.Context) (*HealthResponse, error)
+	Schema(ctx context.Context) (*ServiceSchemaDocument, error)
 	Endpoints() []StreamDemoServiceEndpointInfo
+	WithHeaders(headers nats.Header) StreamDemoServiceNatsClientInterface
+	Close()
 }
 
 // StreamDemoServiceNatsClient is the concrete implementation of StreamDemoServiceNatsClientInterface
 type StreamDemoServiceNatsClient struct {
-	nc            *nats.Conn
-	subjectPrefix string
-	useJSON       bool                   // Use JSON encoding instead of binary protobuf
-	interceptor   UnaryClientInterceptor // Chained interceptors
-	js            jetstream.JetStream    // Optional JetStream for KV/ObjectStore reads
+	nc                  *nats.Conn
+	subjectPrefix       string
+	useJSON             bool                    // Use JSON encoding instead of binary protobuf
+	interceptor         UnaryClientInterceptor  // Chained interceptors
+	streamInterceptor   StreamClientInterceptor // Chained stream interceptors
+	js                  jetstream.JetStream     // Optional JetStream for KV/ObjectStore reads
+	kvStaleAfter        time.Duration           // Age at which Get*Cached treats KV entries as misses (0 = never)
+	cancelPropagation   bool                    // Publish a cancel notice when ctx ends mid-request
+	timeout             time.Duration           // Default unary timeout when ctx has no deadline
+	retry               *retryPolicy            // Unary retry policy (nil = no retries)
+	tokenSanitizer      func(string) string     // Escapes request fields in key helpers
+	idGenerator         func() string           // Mints cancel subject and stream inbox IDs (nil = NUIDs)
+	maxResponseSize     int                     // Largest accepted response payload in bytes (0 = unlimited)
+	connSelector        func() *nats.Conn       // Picks the connection per call (nil = nc)
+	journal             *journal                // Records calls for replay (nil = no journal)
+	streamWindow        int                     // Server-stream flow control window (0 = none)
+	streamAllowGaps     bool                    // Skip lost stream messages instead of failing Recv
+	streamCompression   int                     // Smallest stream message compressed, once negotiated (0 = off)
+	compression         int                     // Smallest unary request compressed (0 = off)
+	compressionCodec    string                  // Codec unary requests are compressed with
+	streamResumeOnDrain bool                    // Reopen server streams when their server drains
+	headerPolicy        *headerPolicy           // Strips outgoing headers (nil = allow all)
+	sampler             *sampler                // Samples unary calls (WithClientSampling)
+	monitor             *connectionMonitor      // Samples the connections (WithClientConnectionMonitor)
+	headers             nats.Header             // Sent under each call's OutgoingHeaders (WithHeaders)
+}
+
+// compress returns a unary request payload compressed as WithClientCompression
+// asks, flagged in headers
+func (c *StreamDemoServiceNatsClient) compress(data []byte, headers nats.Header) ([]byte, error) {
+	if c.compression == 0 {
+		return data, nil
+	}
+	codec := compressorFor(c.compressionCodec)
+	if codec == nil {
+		return nil, fmt.Errorf("WithClientCompression: codec %q is not registered; add it with RegisterCompressor", c.compressionCodec)
+	}
+	return compressPayload(codec, c.compression, data, headers), nil
+}
+
+// conn returns the connection for the next call or stream
+func (c *StreamDemoServiceNatsClient) conn() *nats.Conn {
+	if c.connSelector != nil {
+		return c.connSelector()
+	}
+	return c.nc
+}
+
+// outgoingHeaders returns the headers of ctx that WithOutgoingHeaderPolicy lets a call send
+func (c *StreamDemoServiceNatsClient) outgoingHeaders(ctx context.Context) nats.Header {
+	headers, _ := c.headerPolicy.filter(layerHeaders(c.headers, OutgoingHeaders(ctx)))
+	return headers
+}
+
+// WithHeaders returns a view of the client that sends headers with every call and
+// stream, beneath those set on the call's context with WithOutgoingHeaders. A view
+// shares the client's connections, interceptors, retry policy, journal and sampler
+// rather than copying them, so it is cheap to make per request, e.g. per tenant, and
+// like the client is safe for concurrent use. Views of views add to their parent's
+// headers. Closing a view does not stop the client's connection monitor.
+func (c *StreamDemoServiceNatsClient) WithHeaders(headers nats.Header) StreamDemoServiceNatsClientInterface {
+	layered := make(nats.Header, len(c.headers)+len(headers))
+	for name, values := range c.headers {
+		layered[name] = values
+	}
+	for name, values := range headers {
+		layered[name] = append([]string(nil), values...) // Later changes to headers stay out of the view
+	}
+	view := *c
+	view.headers = layered
+	view.monitor = nil
+	return &view
+}
+
+// keyToken renders a request field for a key template through the token sanitizer
+func (c *StreamDemoServiceNatsClient) keyToken(v any) string {
+	return c.tokenSanitizer(fmt.Sprint(v))
 }
 
 // NewStreamDemoServiceNatsClient creates a new NATS client for StreamDemoService.
 // The client sends requests over NATS using protobuf serialization (or JSON if configured).
 func NewStreamDemoServiceNatsClient(nc *nats.Conn, opts ...NatsClientOption) StreamDemoServiceNatsClientInterface {
 	cfg := &natsClientConfig{
-		subjectPrefix: "api.v1.stream",
+		subjectPrefix:  "api.v1.stream",
+		tokenSanitizer: SanitizeToken,
+		streamWindow:   defaultStreamWindow,
 	}
 	for _, opt := range opts {
 		opt.applyNatsClientOption(cfg)
@@ -882,30 +1432,78 @@ func NewStreamDemoServiceNatsClient(nc *nats.Conn, opts ...NatsClientOption) Str
 	}
 
 	c := &StreamDemoServiceNatsClient{
-		nc:            nc,
-		subjectPrefix: cfg.subjectPrefix,
-		useJSON:       false,
-		interceptor:   chainedInterceptor,
-		js:            cfg.js,
+		nc:                  nc,
+		subjectPrefix:       cfg.subjectPrefix,
+		useJSON:             false,
+		interceptor:         chainedInterceptor,
+		streamInterceptor:   chainStreamClientInterceptors(cfg.streamInterceptors),
+		js:                  cfg.js,
+		kvStaleAfter:        cfg.kvStaleAfter,
+		cancelPropagation:   cfg.cancelPropagation,
+		timeout:             cfg.timeout,
+		retry:               newRetryPolicy(cfg),
+		tokenSanitizer:      cfg.tokenSanitizer,
+		idGenerator:         cfg.idGenerator,
+		maxResponseSize:     cfg.maxResponseSize,
+		connSelector:        cfg.connSelector,
+		journal:             cfg.journal,
+		streamWindow:        cfg.streamWindow,
+		streamAllowGaps:     cfg.streamAllowGaps,
+		streamCompression:   cfg.streamCompression,
+		compression:         cfg.compression,
+		compressionCodec:    cfg.compressionCodec,
+		streamResumeOnDrain: cfg.streamResumeOnDrain,
+		headerPolicy:        cfg.headerPolicy,
+		sampler:             newSampler(cfg.sampling, cfg.samplingSalt),
+		monitor:             startConnectionMonitor(cfg.connMonitor, monitoredConns(nc, cfg.poolConns)...),
 	}
 	return c
 }
 
+// NewStreamDemoServiceNatsClientPool creates a StreamDemoService client that spreads calls
+// over conns round-robin (see RoundRobinConns). Streams stay on the connection they
+// opened on. A WithConnSelector in opts replaces the round-robin selector.
+func NewStreamDemoServiceNatsClientPool(conns []*nats.Conn, opts ...NatsClientOption) StreamDemoServiceNatsClientInterface {
+	selector := RoundRobinConns(conns)
+	opts = append([]NatsClientOption{natsClientOptionFunc(func(c *natsClientConfig) { c.poolConns = conns })}, opts...)
+	return NewStreamDemoServiceNatsClient(conns[0], append([]NatsClientOption{WithConnSelector(selector)}, opts...)...)
+}
+
 // Ping sends a Ping request to the service via NATS.
-// Returns an error if the request fails or the service returns an error.
-func (c *StreamDemoServiceNatsClient) Ping(ctx context.Context, req *PingRequest) (*PingResponse, error) {
+// Returns an error if the request fails or the service returns an error,
+// or a *TimeoutError if a WithCallTimeout/WithClientTimeout expires.
+func (c *StreamDemoServiceNatsClient) Ping(ctx context.Context, req *PingRequest, opts ...CallOption) (*PingResponse, error) {
 	method := "Ping"
 
-	// Pointer to store response headers - stored in context so invoker can update it
-	responseHeadersPtr := &nats.Header{}
+	// Sample the call for WithClientSampling under a request ID the service sees too
+	var requestID string
+	if c.sampler.enabled() {
+		ctx, requestID = withRequestID(ctx, c.idGenerator)
+	}
+	sample := c.sampler.begin("StreamDemoService", method, requestID, true, c.outgoingHeaders(ctx), req, false)
+
+	// Bound the call when the caller gave no deadline (or asked for a per-call timeout)
+	start := time.Now()
+	parentCtx := ctx
+	ctx, cancel, timeout := withCallTimeout(ctx, c.timeout, opts)
+	defer cancel()
 
-	// Add the response headers pointer to context so invoker can populate it
+	// Record sizes, attempts and duration for CallInfoFromContext
+	ctx, info := startCallInfo(ctx, "StreamDemoService", c.subjectPrefix+".ping")
+	defer info.finish()
+	ctx = withIDGenerator(ctx, c.idGenerator) // For NewID in interceptors
+
+	// Give the invoker somewhere in the context to store the response headers
 	// Interceptors can then read the headers from the same context
-	ctx = context.WithValue(ctx, responseHeadersKey, responseHeadersPtr)
+	ctx = WithResponseHeaders(ctx, nats.Header{})
 
 	// Define the invoker function that performs the actual NATS call
 	invoker := func(invokerCtx context.Context, method string, request, reply interface{}) error {
-		subject := c.subjectPrefix + ".ping"
+		// WithTargetInstance sends the call to one instance's own subject
+		subject, subjectErr := targetSubject(c.subjectPrefix+".ping", opts)
+		if subjectErr != nil {
+			return newStreamDemoServiceError(StreamDemoServiceErrCodeInvalidArgument, method, subjectErr.Error(), nil)
+		}
 
 		// Marshal request
 		typedReq, ok := request.(*PingRequest)
@@ -916,7 +1514,7 @@ func (c *StreamDemoServiceNatsClient) Ping(ctx context.Context, req *PingRequest
 		var data []byte
 		var err error
 		if c.useJSON {
-			data, err = protojson.Marshal(typedReq)
+			data, err = marshalJSON(typedReq, false)
 		} else {
 			data, err = proto.Marshal(typedReq)
 		}
@@ -924,63 +1522,84 @@ func (c *StreamDemoServiceNatsClient) Ping(ctx context.Context, req *PingRequest
 			return err
 		}
 
-		// Extract outgoing headers from context and attach to NATS message
-		var msg *nats.Msg
-		if headers := OutgoingHeaders(invokerCtx); headers != nil {
-			msg, err = c.nc.RequestMsgWithContext(invokerCtx, &nats.Msg{
-				Subject: subject,
-				Data:    data,
-				Header:  headers,
-			})
-		} else {
-			msg, err = c.nc.RequestWithContext(invokerCtx, subject, data)
+		// Extract outgoing headers from context and attach them, naming the codec, to the NATS message
+		nc := c.conn()
+		headers := withContentType(c.outgoingHeaders(invokerCtx), c.useJSON)
+		setDeadlineHeaders(invokerCtx, headers) // For deadline-aware scheduling and handler contexts
+		if data, err = c.compress(data, headers); err != nil {
+			return err
+		}
+		headers.Set(acceptEncodingHeader, compressorNames()) // Services compress responses only for clients that decompress them
+		if c.cancelPropagation {
+			var stop func() bool
+			headers, stop = propagateCancel(invokerCtx, nc, headers, mintID(c.idGenerator))
+			defer stop()
+		}
+
+		info.attempt(len(data))
+		msg, err := nc.RequestMsgWithContext(invokerCtx, &nats.Msg{
+			Subject: subject,
+			Data:    data,
+			Header:  headers,
+		})
+		if err != nil {
+			return err
 		}
+		info.received(len(msg.Data))
+		info.responder(msg.Header)
+		body, err := readPayload("response", msg.Header.Get(ContentEncodingHeader), msg.Data, c.maxResponseSize)
 		if err != nil {
 			return err
 		}
 
-		// Store response headers in the pointer from context
+		// Store response headers where ResponseHeaders reads them
 		if msg.Header != nil && len(msg.Header) > 0 {
-			if headersPtr, ok := invokerCtx.Value(responseHeadersKey).(*nats.Header); ok && headersPtr != nil {
-				*headersPtr = msg.Header
-			}
-		} // Check if this is an error response from the service
-		if msg.Header.Get("Status") != "" {
-			code := msg.Header.Get("Status")
-			description := msg.Header.Get("Description")
-			return &StreamDemoServiceError{
-				Code:    code,
-				Method:  method,
-				Message: description,
+			storeResponseHeaders(invokerCtx, msg.Header)
+		}
+
+		// Check if this is an error response from the service (NATS micro headers)
+		if msg.Header.Get("Nats-Service-Error-Code") != "" {
+			code := msg.Header.Get("Nats-Service-Error-Code")
+			description := msg.Header.Get("Nats-Service-Error")
+			var details []byte
+			if len(msg.Data) > 0 {
+				details = msg.Data
 			}
+			return newStreamDemoServiceError(code, method, description, details)
 		}
 
-		// Unmarshal response
+		// Unmarshal response with the codec the service named, if any
 		typedReply, ok := reply.(*PingResponse)
 		if !ok {
 			return fmt.Errorf("invalid reply type")
 		}
-
-		if c.useJSON {
-			err = protojson.Unmarshal(msg.Data, typedReply)
+		replyJSON, err := payloadUsesJSON(msg.Header.Get(ContentTypeHeader), c.useJSON)
+		if err != nil {
+			return err
+		}
+		if replyJSON {
+			err = protojson.Unmarshal(body, typedReply)
 		} else {
-			err = proto.Unmarshal(msg.Data, typedReply)
+			err = proto.Unmarshal(body, typedReply)
 		}
 		return err
 	}
 
 	var resp PingResponse
 
-	// Execute through interceptor chain if configured
-	var err error
-	if c.interceptor != nil {
-		err = c.interceptor(ctx, method, req, &resp, invoker)
-	} else {
-		err = invoker(ctx, method, req, &resp)
+	// Execute through interceptor chain if configured, once per retry attempt
+	err := c.retry.do(ctx, func(ctx context.Context) error {
+		if c.interceptor != nil {
+			return c.interceptor(ctx, method, req, &resp, invoker)
+		}
+		return invoker(ctx, method, req, &resp)
+	})
+	sample.end(&resp, err)
+	if c.journal != nil {
+		c.journal.record("StreamDemoService", method, c.subjectPrefix+".ping", c.outgoingHeaders(parentCtx), req, c.useJSON, false, start, err)
 	}
-
 	if err != nil {
-		return nil, err
+		return nil, callTimeoutError(parentCtx, ctx, method, timeout, err)
 	}
 
 	return &resp, nil
@@ -989,16 +1608,69 @@ func (c *StreamDemoServiceNatsClient) Ping(ctx context.Context, req *PingRequest
 // StreamDemoService_CountUp_ClientStream is the client-side stream receiver for CountUp.
 type StreamDemoService_CountUp_ClientStream struct {
 	receiver *ClientStreamReceiver
-	useJSON  bool
+	// Reopens the stream from a resume token (nil without WithClientStreamResumeOnDrain)
+	reopen      func(ctx context.Context, token string) (*StreamDemoService_CountUp_ClientStream, error)
+	useJSON     bool
+	info        *callInfoHolder
+	intercepted ClientStream // Returned by stream interceptors (nil = none)
+}
+
+// ResumeToken returns the resume token of the last message received, or "" if the
+// handler set none. Reopening the stream with WithResumeFrom(token) continues
+// after that message, e.g. on another instance after an *ErrServerDraining.
+func (s *StreamDemoService_CountUp_ClientStream) ResumeToken() string {
+	return s.receiver.ResumeToken()
 }
 
 // Recv blocks until the next response message arrives from the server.
-// Returns an error containing "EOF" when the stream is complete.
+// Returns ErrStreamEOF when the stream is complete, ctx.Err() when ctx ends, an
+// error wrapping ErrStreamBroken on transport failure, and a *StreamDemoServiceError
+// (errors.As also finds its *Status) when the handler failed.
+// When the server starts draining it returns an *ErrServerDraining once, unless
+// WithClientStreamResumeOnDrain reopens the stream.
 func (s *StreamDemoService_CountUp_ClientStream) Recv(ctx context.Context) (*CountUpResponse, error) {
+	if s.intercepted != nil {
+		return interceptedRecv[*CountUpResponse](ctx, s.intercepted)
+	}
+	return s.recv(ctx)
+}
+
+// RecvMsg receives the next message past the stream interceptors, which call it.
+func (s *StreamDemoService_CountUp_ClientStream) RecvMsg(ctx context.Context) (proto.Message, error) {
+	return recvMessage(s.recv(ctx))
+}
+
+// SendMsg fails: the request of a server stream is sent when it opens.
+func (s *StreamDemoService_CountUp_ClientStream) SendMsg(proto.Message) error {
+	return errStreamDirection("SendMsg")
+}
+
+// intercept makes Recv go through stream, if a stream interceptor returned another one
+func (s *StreamDemoService_CountUp_ClientStream) intercept(stream ClientStream) {
+	if own, ok := stream.(*StreamDemoService_CountUp_ClientStream); !ok || own != s {
+		s.intercepted = stream
+	}
+}
+
+func (s *StreamDemoService_CountUp_ClientStream) recv(ctx context.Context) (*CountUpResponse, error) {
 	msg, err := s.receiver.Recv(ctx)
+	var draining *ErrServerDraining
+	if s.reopen != nil && errors.As(err, &draining) {
+		// Continue on another instance after the last message received; if that
+		// fails, the caller gets the GOAWAY and can keep reading this stream
+		if token, ok := s.receiver.resumePoint(); ok {
+			if next, reopenErr := s.reopen(ctx, token); reopenErr == nil {
+				s.receiver.Close() // Acknowledges the GOAWAY
+				s.receiver, s.reopen = next.receiver, next.reopen
+				msg, err = s.receiver.Recv(ctx)
+			}
+		}
+	}
 	if err != nil {
+		s.info.finish()
 		return nil, err
 	}
+	s.info.received(len(msg.Data))
 	var resp CountUpResponse
 	if s.useJSON {
 		if err := protojson.Unmarshal(msg.Data, &resp); err != nil {
@@ -1012,33 +1684,53 @@ func (s *StreamDemoService_CountUp_ClientStream) Recv(ctx context.Context) (*Cou
 	return &resp, nil
 }
 
+// Header returns the response headers the handler set with SetResponseHeaders,
+// once the first message has been received.
+func (s *StreamDemoService_CountUp_ClientStream) Header() nats.Header {
+	return s.receiver.Header()
+}
+
 // Close unsubscribes from the stream.
 func (s *StreamDemoService_CountUp_ClientStream) Close() error {
+	s.info.finish()
 	return s.receiver.Close()
 }
 
 // CountUp initiates a server-streaming RPC call.
 // Returns a stream that yields responses from the server.
-func (c *StreamDemoServiceNatsClient) CountUp(ctx context.Context, req *CountUpRequest) (*StreamDemoService_CountUp_ClientStream, error) {
+// Stream options (e.g., WithResumeFrom) are sent with the opening request.
+func (c *StreamDemoServiceNatsClient) CountUp(ctx context.Context, req *CountUpRequest, opts ...StreamCallOption) (*StreamDemoService_CountUp_ClientStream, error) {
+	info := &StreamInfo{Service: "StreamDemoService", Method: "CountUp", FullMethod: "streaming.v1.StreamDemoService.CountUp", IsServerStream: true}
+	return openClientStream(ctx, c.streamInterceptor, info, func(ctx context.Context) (*StreamDemoService_CountUp_ClientStream, error) {
+		return c.openCountUp(ctx, req, opts...)
+	})
+}
+
+// openCountUp opens a CountUp stream past the stream interceptors
+func (c *StreamDemoServiceNatsClient) openCountUp(ctx context.Context, req *CountUpRequest, opts ...StreamCallOption) (*StreamDemoService_CountUp_ClientStream, error) {
 	subject := c.subjectPrefix + ".count_up"
 
 	var data []byte
 	var err error
 	if c.useJSON {
-		data, err = protojson.Marshal(req)
+		data, err = marshalJSON(req, false)
 	} else {
 		data, err = proto.Marshal(req)
 	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to marshal request: %w", err)
 	}
-
-	// Create inbox for receiving streamed responses
-	inbox := nats.NewInbox()
-	receiver, err := newClientStreamReceiver(c.nc, inbox, false)
+	// Create inbox for receiving streamed responses; the stream stays on this connection
+	nc := c.conn()
+	inbox := mintInbox(c.idGenerator)
+	receiver, err := newClientStreamReceiver(nc, inbox, c.streamAllowGaps)
 	if err != nil {
 		return nil, fmt.Errorf("failed to setup stream: %w", err)
 	}
+	receiver.maxSize = c.maxResponseSize
+	receiver.remoteError = func(code, message string, details []byte) error {
+		return newStreamDemoServiceError(code, "CountUp", message, details)
+	}
 
 	// Send request with our inbox as Reply-To header
 	msg := &nats.Msg{
@@ -1047,43 +1739,93 @@ func (c *StreamDemoServiceNatsClient) CountUp(ctx context.Context, req *CountUpR
 		Header:  nats.Header{},
 	}
 	msg.Header.Set("Reply-To", inbox)
+	msg.Header.Set(ContentTypeHeader, contentType(c.useJSON))
+	if c.streamWindow > 0 {
+		receiver.enableFlowControl(nc, c.streamWindow, msg.Header, mintInbox(c.idGenerator))
+	}
+	if c.streamCompression > 0 {
+		msg.Header.Set(natsStreamAcceptEncodingHeader, compressorNames())
+	}
+	for _, opt := range opts {
+		opt(msg.Header)
+	}
 
 	// Add outgoing headers from context
-	if headers := OutgoingHeaders(ctx); headers != nil {
+	if headers := c.outgoingHeaders(ctx); headers != nil {
 		for k, v := range headers {
 			for _, val := range v {
 				msg.Header.Add(k, val)
 			}
 		}
 	}
+	setDeadlineHeaders(ctx, msg.Header) // The handler stops when ctx would
 
-	if err := c.nc.PublishMsg(msg); err != nil {
+	_, info := startCallInfo(ctx, "StreamDemoService", subject)
+	info.attempt(len(data))
+	if err := nc.PublishMsg(msg); err != nil {
 		receiver.Close()
 		return nil, fmt.Errorf("failed to send streaming request: %w", err)
 	}
 
-	return &StreamDemoService_CountUp_ClientStream{
+	stream := &StreamDemoService_CountUp_ClientStream{
 		receiver: receiver,
 		useJSON:  c.useJSON,
-	}, nil
+		info:     info,
+	}
+	if c.streamResumeOnDrain {
+		// Reopen with a copy of the request, so later changes to req don't leak in
+		req := proto.Clone(req).(*CountUpRequest)
+		stream.reopen = func(ctx context.Context, token string) (*StreamDemoService_CountUp_ClientStream, error) {
+			if token == "" {
+				return c.openCountUp(ctx, req, opts...)
+			}
+			return c.openCountUp(ctx, req, append(opts[:len(opts):len(opts)], WithResumeFrom(token))...)
+		}
+	}
+	return stream, nil
 }
 
 // StreamDemoService_Sum_ClientStream is the client-side sender stream for Sum.
 type StreamDemoService_Sum_ClientStream struct {
-	nc      *nats.Conn
-	sendTo  string // Server's inbox
-	replyTo string // Our inbox for final response
-	useJSON bool
-	seq     int
-	mu      sync.Mutex
+	nc              *nats.Conn
+	sendTo          string // Server's inbox
+	replyTo         string // Our inbox for final response
+	useJSON         bool
+	info            *callInfoHolder
+	maxResponseSize int
+	stopCancel      func() bool       // Stops the cancel frame sent when the opening ctx ends
+	compressor      *streamCompressor // Compresses messages (nil = the server agreed to none)
+	seq             int
+	mu              sync.Mutex
+	intercepted     ClientStream // Returned by stream interceptors (nil = none)
 }
 
 // Send sends a message to the server.
 func (s *StreamDemoService_Sum_ClientStream) Send(msg *SumRequest) error {
+	if s.intercepted != nil {
+		return s.intercepted.SendMsg(msg)
+	}
+	return s.send(msg)
+}
+
+// SendMsg sends a *SumRequest past the stream interceptors, which call it.
+func (s *StreamDemoService_Sum_ClientStream) SendMsg(msg proto.Message) error {
+	return sendMessage(msg, s.send)
+}
+
+// intercept makes the stream send and receive through stream, if a stream
+// interceptor returned another one
+func (s *StreamDemoService_Sum_ClientStream) intercept(stream ClientStream) {
+	if own, ok := stream.(*StreamDemoService_Sum_ClientStream); !ok || own != s {
+		s.intercepted = stream
+	}
+}
+
+func (s *StreamDemoService_Sum_ClientStream) send(msg *SumRequest) error {
 	var data []byte
 	var err error
 	if s.useJSON {
-		data, err = protojson.Marshal(msg)
+		data, err = marshalJSON(msg, false)
 	} else {
 		data, err = proto.Marshal(msg)
 	}
@@ -1096,21 +1838,44 @@ func (s *StreamDemoService_Sum_ClientStream) Send(msg *SumRequest) error {
 	s.mu.Unlock()
 	m := &nats.Msg{
 		Subject: s.sendTo,
-		Data:    data,
 		Header:  nats.Header{},
 	}
+	m.Data = s.compressor.encode(data, m.Header)
 	m.Header.Set(natsStreamSeqHeader, strconv.Itoa(seq))
-	return s.nc.PublishMsg(m)
+	if err := s.nc.PublishMsg(m); err != nil {
+		return err
+	}
+	s.info.sent(len(data))
+	return nil
 }
 
 // CloseAndRecv signals end of client messages and waits for the server's response.
+// A handler failure is returned as a *StreamDemoServiceError. If ctx ends first,
+// the stream is cancelled on the server too.
 func (s *StreamDemoService_Sum_ClientStream) CloseAndRecv(ctx context.Context) (*SumResponse, error) {
+	if s.intercepted != nil {
+		return interceptedRecv[*SumResponse](ctx, s.intercepted)
+	}
+	return s.closeAndRecv(ctx)
+}
+
+// RecvMsg ends the stream and receives the response past the stream
+// interceptors, which call it.
+func (s *StreamDemoService_Sum_ClientStream) RecvMsg(ctx context.Context) (proto.Message, error) {
+	return recvMessage(s.closeAndRecv(ctx))
+}
+
+func (s *StreamDemoService_Sum_ClientStream) closeAndRecv(ctx context.Context) (*SumResponse, error) {
+	defer s.stopCancel()
 	// Send end-of-stream marker
 	m := &nats.Msg{
 		Subject: s.sendTo,
 		Header:  nats.Header{},
 	}
 	m.Header.Set(natsStreamEndHeader, "true")
+	s.mu.Lock()
+	m.Header.Set(natsStreamSeqHeader, strconv.Itoa(s.seq)) // Lets the server notice lost trailing messages
+	s.mu.Unlock()
 	if err := s.nc.PublishMsg(m); err != nil {
 		return nil, fmt.Errorf("failed to close send: %w", err)
 	}
@@ -1124,8 +1889,23 @@ func (s *StreamDemoService_Sum_ClientStream) CloseAndRecv(ctx context.Context) (
 
 	natsMsg, err := sub.NextMsgWithContext(ctx)
 	if err != nil {
+		s.info.finish()
+		if ctx.Err() != nil && s.stopCancel() {
+			publishStreamCancel(s.nc, s.sendTo, ctx.Err())
+		}
 		return nil, fmt.Errorf("failed to receive response: %w", err)
 	}
+	s.info.received(len(natsMsg.Data))
+	if code := natsMsg.Header.Get("Nats-Service-Error-Code"); code != "" {
+		var details []byte
+		if len(natsMsg.Data) > 0 {
+			details = natsMsg.Data
+		}
+		return nil, newStreamDemoServiceError(code, "Sum", natsMsg.Header.Get("Nats-Service-Error"), details)
+	}
+	if err := checkPayloadSize("response", len(natsMsg.Data), s.maxResponseSize); err != nil {
+		return nil, err
+	}
 
 	var resp SumResponse
 	if s.useJSON {
@@ -1142,10 +1922,17 @@ func (s *StreamDemoService_Sum_ClientStream) CloseAndRecv(ctx context.Context) (
 
 // Sum initiates a client-streaming RPC call.
 func (c *StreamDemoServiceNatsClient) Sum(ctx context.Context) (*StreamDemoService_Sum_ClientStream, error) {
+	info := &StreamInfo{Service: "StreamDemoService", Method: "Sum", FullMethod: "streaming.v1.StreamDemoService.Sum", IsClientStream: true}
+	return openClientStream(ctx, c.streamInterceptor, info, c.openSum)
+}
+
+// openSum opens a Sum stream past the stream interceptors
+func (c *StreamDemoServiceNatsClient) openSum(ctx context.Context) (*StreamDemoService_Sum_ClientStream, error) {
 	subject := c.subjectPrefix + ".sum"
 
-	// Create inbox for receiving the final response
-	replyInbox := nats.NewInbox()
+	// Create inbox for receiving the final response; the stream stays on this connection
+	nc := c.conn()
+	replyInbox := mintInbox(c.idGenerator)
 
 	// Send initial handshake to get server's inbox
 	msg := &nats.Msg{
@@ -1153,8 +1940,14 @@ func (c *StreamDemoServiceNatsClient) Sum(ctx context.Context) (*StreamDemoServi
 		Header:  nats.Header{},
 	}
 	msg.Header.Set("Reply-To", replyInbox)
+	setDeadlineHeaders(ctx, msg.Header) // The handler stops when ctx would
+	if c.streamCompression > 0 {
+		msg.Header.Set(natsStreamAcceptEncodingHeader, compressorNames())
+	}
 
-	ackMsg, err := c.nc.RequestMsgWithContext(ctx, msg)
+	_, info := startCallInfo(ctx, "StreamDemoService", subject)
+	info.attempt(0)
+	ackMsg, err := nc.RequestMsgWithContext(ctx, msg)
 	if err != nil {
 		return nil, fmt.Errorf("failed to initiate client stream: %w", err)
 	}
@@ -1165,29 +1958,58 @@ func (c *StreamDemoServiceNatsClient) Sum(ctx context.Context) (*StreamDemoServi
 	}
 
 	return &StreamDemoService_Sum_ClientStream{
-		nc:      c.nc,
-		sendTo:  serverInbox,
-		replyTo: replyInbox,
-		useJSON: c.useJSON,
+		nc:              nc,
+		sendTo:          serverInbox,
+		replyTo:         replyInbox,
+		useJSON:         c.useJSON,
+		info:            info,
+		maxResponseSize: c.maxResponseSize,
+		compressor:      acceptedStreamCompression(ackMsg.Header, c.streamCompression),
+		// Cancel the handler if ctx ends before CloseAndRecv returns
+		stopCancel: context.AfterFunc(ctx, func() { publishStreamCancel(nc, serverInbox, ctx.Err()) }),
 	}, nil
 }
 
 // StreamDemoService_Chat_ClientStream is the client-side bidi stream for Chat.
 type StreamDemoService_Chat_ClientStream struct {
-	nc       *nats.Conn
-	sendTo   string                // Server's inbox for sending messages
-	receiver *ClientStreamReceiver // For receiving server messages
-	useJSON  bool
-	seq      int
-	mu       sync.Mutex
+	nc          *nats.Conn
+	sendTo      string                // Server's inbox for sending messages
+	receiver    *ClientStreamReceiver // For receiving server messages
+	useJSON     bool
+	info        *callInfoHolder
+	stopCancel  func() bool       // Stops the cancel frame sent when the opening ctx ends
+	compressor  *streamCompressor // Compresses messages (nil = the server agreed to none)
+	seq         int
+	mu          sync.Mutex
+	intercepted ClientStream // Returned by stream interceptors (nil = none)
 }
 
 // Send sends a message to the server.
 func (s *StreamDemoService_Chat_ClientStream) Send(msg *ChatMessage) error {
+	if s.intercepted != nil {
+		return s.intercepted.SendMsg(msg)
+	}
+	return s.send(msg)
+}
+
+// SendMsg sends a *ChatMessage past the stream interceptors, which call it.
+func (s *StreamDemoService_Chat_ClientStream) SendMsg(msg proto.Message) error {
+	return sendMessage(msg, s.send)
+}
+
+// intercept makes the stream send and receive through stream, if a stream
+// interceptor returned another one
+func (s *StreamDemoService_Chat_ClientStream) intercept(stream ClientStream) {
+	if own, ok := stream.(*StreamDemoService_Chat_ClientStream); !ok || own != s {
+		s.intercepted = stream
+	}
+}
+
+func (s *StreamDemoService_Chat_ClientStream) send(msg *ChatMessage) error {
 	var data []byte
 	var err error
 	if s.useJSON {
-		data, err = protojson.Marshal(msg)
+		data, err = marshalJSON(msg, false)
 	} else {
 		data, err = proto.Marshal(msg)
 	}
@@ -1200,19 +2022,38 @@ func (s *StreamDemoService_Chat_ClientStream) Send(msg *ChatMessage) error {
 	s.mu.Unlock()
 	m := &nats.Msg{
 		Subject: s.sendTo,
-		Data:    data,
 		Header:  nats.Header{},
 	}
+	m.Data = s.compressor.encode(data, m.Header)
 	m.Header.Set(natsStreamSeqHeader, strconv.Itoa(seq))
-	return s.nc.PublishMsg(m)
+	if err := s.nc.PublishMsg(m); err != nil {
+		return err
+	}
+	s.info.sent(len(data))
+	return nil
 }
 
 // Recv blocks until the next response arrives from the server.
+// It fails like the Recv of server-streaming calls, with ErrStreamEOF at the end.
 func (s *StreamDemoService_Chat_ClientStream) Recv(ctx context.Context) (*ChatMessage, error) {
+	if s.intercepted != nil {
+		return interceptedRecv[*ChatMessage](ctx, s.intercepted)
+	}
+	return s.recv(ctx)
+}
+
+// RecvMsg receives the next response past the stream interceptors, which call it.
+func (s *StreamDemoService_Chat_ClientStream) RecvMsg(ctx context.Context) (proto.Message, error) {
+	return recvMessage(s.recv(ctx))
+}
+
+func (s *StreamDemoService_Chat_ClientStream) recv(ctx context.Context) (*ChatMessage, error) {
 	natsMsg, err := s.receiver.Recv(ctx)
 	if err != nil {
+		s.info.finish()
 		return nil, err
 	}
+	s.info.received(len(natsMsg.Data))
 	var resp ChatMessage
 	if s.useJSON {
 		if err := protojson.Unmarshal(natsMsg.Data, &resp); err != nil {
@@ -1233,24 +2074,49 @@ func (s *StreamDemoService_Chat_ClientStream) CloseSend() error {
 		Header:  nats.Header{},
 	}
 	m.Header.Set(natsStreamEndHeader, "true")
+	s.mu.Lock()
+	m.Header.Set(natsStreamSeqHeader, strconv.Itoa(s.seq)) // Lets the server notice lost trailing messages
+	s.mu.Unlock()
 	return s.nc.PublishMsg(m)
 }
 
-// Close unsubscribes from server messages.
+// Header returns the response headers the handler set with SetResponseHeaders,
+// once the first message has been received.
+func (s *StreamDemoService_Chat_ClientStream) Header() nats.Header {
+	return s.receiver.Header()
+}
+
+// Close unsubscribes from server messages. Closing before the server has ended the
+// stream cancels it: the handler's Recv fails and its context ends.
 func (s *StreamDemoService_Chat_ClientStream) Close() error {
+	s.info.finish()
+	if s.stopCancel() && !s.receiver.ended() {
+		publishStreamCancel(s.nc, s.sendTo, context.Canceled)
+	}
 	return s.receiver.Close()
 }
 
 // Chat initiates a bidirectional streaming RPC call.
 func (c *StreamDemoServiceNatsClient) Chat(ctx context.Context) (*StreamDemoService_Chat_ClientStream, error) {
+	info := &StreamInfo{Service: "StreamDemoService", Method: "Chat", FullMethod: "streaming.v1.StreamDemoService.Chat", IsClientStream: true, IsServerStream: true}
+	return openClientStream(ctx, c.streamInterceptor, info, c.openChat)
+}
+
+// openChat opens a Chat stream past the stream interceptors
+func (c *StreamDemoServiceNatsClient) openChat(ctx context.Context) (*StreamDemoService_Chat_ClientStream, error) {
 	subject := c.subjectPrefix + ".chat"
 
-	// Create inbox for receiving server responses
-	clientInbox := nats.NewInbox()
-	receiver, err := newClientStreamReceiver(c.nc, clientInbox, false)
+	// Create inbox for receiving server responses; the stream stays on this connection
+	nc := c.conn()
+	clientInbox := mintInbox(c.idGenerator)
+	receiver, err := newClientStreamReceiver(nc, clientInbox, c.streamAllowGaps)
 	if err != nil {
 		return nil, fmt.Errorf("failed to setup stream: %w", err)
 	}
+	receiver.maxSize = c.maxResponseSize
+	receiver.remoteError = func(code, message string, details []byte) error {
+		return newStreamDemoServiceError(code, "Chat", message, details)
+	}
 
 	// Send initial handshake to get server's inbox
 	msg := &nats.Msg{
@@ -1258,8 +2124,14 @@ func (c *StreamDemoServiceNatsClient) Chat(ctx context.Context) (*StreamDemoServ
 		Header:  nats.Header{},
 	}
 	msg.Header.Set("Reply-To", clientInbox)
+	setDeadlineHeaders(ctx, msg.Header) // The handler stops when ctx would
+	if c.streamCompression > 0 {
+		msg.Header.Set(natsStreamAcceptEncodingHeader, compressorNames())
+	}
 
-	ackMsg, err := c.nc.RequestMsgWithContext(ctx, msg)
+	_, info := startCallInfo(ctx, "StreamDemoService", subject)
+	info.attempt(0)
+	ackMsg, err := nc.RequestMsgWithContext(ctx, msg)
 	if err != nil {
 		receiver.Close()
 		return nil, fmt.Errorf("failed to initiate bidi stream: %w", err)
@@ -1272,13 +2144,43 @@ func (c *StreamDemoServiceNatsClient) Chat(ctx context.Context) (*StreamDemoServ
 	}
 
 	return &StreamDemoService_Chat_ClientStream{
-		nc:       c.nc,
-		sendTo:   serverInbox,
-		receiver: receiver,
-		useJSON:  c.useJSON,
+		nc:         nc,
+		sendTo:     serverInbox,
+		receiver:   receiver,
+		useJSON:    c.useJSON,
+		info:       info,
+		compressor: acceptedStreamCompression(ackMsg.Header, c.streamCompression),
+		// Cancel the handler if ctx ends before the stream is closed
+		stopCancel: context.AfterFunc(ctx, func() { publishStreamCancel(nc, serverInbox, ctx.Err()) }),
 	}, nil
 }
 
+// Health calls the service's health endpoint. A service that reports HealthNotServing
+// returns a HealthResponse, not an error.
+func (c *StreamDemoServiceNatsClient) Health(ctx context.Context) (*HealthResponse, error) {
+	parentCtx := ctx
+	ctx, cancel, timeout := withCallTimeout(ctx, c.timeout, nil)
+	defer cancel()
+	resp, err := requestHealth(ctx, c.conn(), healthSubject(c.subjectPrefix, "stream_demo_service"))
+	if err != nil {
+		return nil, callTimeoutError(parentCtx, ctx, "Health", timeout, err)
+	}
+	return resp, nil
+}
+
+// Schema fetches the service's $schema document; see FetchServiceSchema
+func (c *StreamDemoServiceNatsClient) Schema(ctx context.Context) (*ServiceSchemaDocument, error) {
+	ctx, cancel, _ := withCallTimeout(ctx, c.timeout, nil)
+	defer cancel()
+	return FetchServiceSchema(ctx, c.conn(), schemaSubject(c.subjectPrefix, "stream_demo_service"))
+}
+
+// Close stops the client's connection monitor. The NATS connection stays open,
+// and the client can still be used.
+func (c *StreamDemoServiceNatsClient) Close() {
+	c.monitor.close()
+}
+
 // Endpoints returns information about all service endpoints this client can call.
 // This is useful for debugging, monitoring, and introspection.
 func (c *StreamDemoServiceNatsClient) Endpoints() []StreamDemoServiceEndpointInfo {
@@ -1289,3 +2191,16 @@ func (c *StreamDemoServiceNatsClient) Endpoints() []StreamDemoServiceEndpointInf
 		{Name: "Chat", Subject: c.subjectPrefix + ".chat"},
 	}
 }
+
+// StreamDemoServiceSelfTest checks that the NATS connection works and that a running
+// stream_demo_service instance has registered every endpoint the client calls. Client
+// options such as WithNatsClientSubjectPrefix select the expected subjects.
+// Exit non-zero when the report is not OK to use it as a liveness or deployment hook.
+func StreamDemoServiceSelfTest(ctx context.Context, nc *nats.Conn, opts ...NatsClientOption) *SelfTestReport {
+	c := NewStreamDemoServiceNatsClient(nc, opts...)
+	var endpoints []SelfTestEndpoint
+	for _, ep := range c.Endpoints() {
+		endpoints = append(endpoints, SelfTestEndpoint{Name: ep.Name, Subject: ep.Subject})
+	}
+	return runSelfTest(ctx, nc, "stream_demo_service", endpoints)
+}
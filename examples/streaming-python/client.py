@@ -0,0 +1,76 @@
+"""
+Streaming NATS Micro client example using protoc-gen-nats-micro
+
+Calls every kind of RPC of StreamDemoService, served by server.py or by the Go
+server in examples/streaming-go.
+"""
+
+import asyncio
+import sys
+from datetime import datetime, timezone
+from pathlib import Path
+
+# Add generated code to path
+sys.path.insert(0, str(Path(__file__).parent / "gen"))
+
+import nats
+from streaming.v1.service_nats_pb2 import StreamDemoServiceClient
+from streaming.v1 import service_pb2 as pb
+
+
+async def main():
+    """Main client function"""
+    nc = await nats.connect("nats://localhost:4222")
+    print("✓ Connected to NATS")
+
+    client = StreamDemoServiceClient(nc)
+
+    # ── 1. Unary: Ping ──────────────────────────────────────────────────
+    print("\n── Ping (unary) ──")
+    ping_resp, _ = await client.ping(pb.PingRequest(payload="hello"))
+    print(f"  ← {ping_resp.payload} (ts={ping_resp.timestamp})")
+
+    # ── 2. Server-streaming: CountUp ────────────────────────────────────
+    print("\n── CountUp (server-streaming) ──")
+    count_stream = await client.count_up(pb.CountUpRequest(start=1, count=5))
+    async for resp in count_stream:
+        print(f"  ← number={resp.number} ts={resp.timestamp}")
+    print("  ✓ Stream complete")
+
+    # ── 3. Client-streaming: Sum ────────────────────────────────────────
+    print("\n── Sum (client-streaming) ──")
+    sum_stream = await client.sum()
+    for value in [10, 20, 30, 40, 50]:
+        print(f"  → sending {value}")
+        await sum_stream.send(pb.SumRequest(value=value))
+        await asyncio.sleep(0.1)
+    sum_resp = await sum_stream.close_and_recv()
+    print(f"  ← total={sum_resp.total} count={sum_resp.count}")
+
+    # ── 4. Bidirectional streaming: Chat ────────────────────────────────
+    print("\n── Chat (bidi-streaming) ──")
+    chat_stream = await client.chat()
+    for text in ["hello", "how are you", "goodbye"]:
+        print(f"  → [client] {text}")
+        await chat_stream.send(pb.ChatMessage(
+            user="client",
+            text=text,
+            timestamp=datetime.now(timezone.utc).isoformat(),
+        ))
+
+        # Read echo back
+        reply = await chat_stream.recv()
+        print(f"  ← [{reply.user}] {reply.text}")
+    await chat_stream.close_send()
+
+    # Wait for the server to end its side too
+    async for reply in chat_stream:
+        print(f"  ← [{reply.user}] {reply.text}")
+    print("  ✓ Chat complete")
+
+    await nc.close()
+    print("\n✅ All streaming demos completed successfully!")
+
+
+if __name__ == "__main__":
+    asyncio.run(main())
@@ -0,0 +1,67 @@
+#!/usr/bin/env bash
+# Runs the streaming demo across languages against a local nats-server:
+# Go server with the Python client, Python server with the Go client, and
+# Python on both sides. Each client exits non-zero on any failed call.
+#
+# Needs nats-server, go and python3 (with requirements.txt installed) on PATH,
+# and the generated code: task generate:python, or from the repository root
+#   buf generate --template examples/buf-configs/buf.gen.streaming-py.yaml extensions/proto
+#   buf generate --template examples/buf-configs/buf.gen.streaming-py.yaml examples/protos
+set -euo pipefail
+
+cd "$(dirname "$0")"
+PYTHON=${PYTHON:-python3}
+GO_DIR=../streaming-go
+PIDS=()
+
+cleanup() {
+    for pid in "${PIDS[@]}"; do
+        kill "$pid" 2>/dev/null || true
+    done
+    wait 2>/dev/null || true
+}
+trap cleanup EXIT
+
+# Use a running server on the default port, or start one
+if ! (exec 3<>/dev/tcp/127.0.0.1/4222) 2>/dev/null; then
+    nats-server -p 4222 >/dev/null 2>&1 &
+    PIDS+=($!)
+    sleep 1
+fi
+
+# Build the Go binaries up front so startup time doesn't race the clients
+BIN=$(mktemp -d)
+go build -C "$GO_DIR" -o "$BIN/server" ./cmd/server
+go build -C "$GO_DIR" -o "$BIN/client" ./cmd/client
+
+# run_case NAME SERVER... -- CLIENT...
+run_case() {
+    local name=$1
+    shift
+    local server=()
+    while [ "$1" != "--" ]; do
+        server+=("$1")
+        shift
+    done
+    shift
+
+    echo "=== $name ==="
+    "${server[@]}" >"$BIN/server.log" 2>&1 &
+    local server_pid=$!
+    sleep 2 # Give the service time to register
+    if ! "$@"; then
+        echo "✗ $name failed; server output:"
+        cat "$BIN/server.log"
+        kill "$server_pid" 2>/dev/null || true
+        exit 1
+    fi
+    kill "$server_pid" 2>/dev/null || true
+    wait "$server_pid" 2>/dev/null || true
+    echo "✓ $name passed"
+}
+
+run_case "Go server, Python client" "$BIN/server" -- "$PYTHON" client.py
+run_case "Python server, Go client" "$PYTHON" server.py -- "$BIN/client"
+run_case "Python server, Python client" "$PYTHON" server.py -- "$PYTHON" client.py
+
+echo "✅ All interop cases passed"
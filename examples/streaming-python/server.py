@@ -0,0 +1,96 @@
+"""
+Streaming NATS Micro server example using protoc-gen-nats-micro
+
+Serves StreamDemoService to client.py or to the Go client in examples/streaming-go.
+"""
+
+import asyncio
+import sys
+import time
+from datetime import datetime, timezone
+from pathlib import Path
+
+# Add generated code to path
+sys.path.insert(0, str(Path(__file__).parent / "gen"))
+
+import nats
+from streaming.v1.service_nats_pb2 import (
+    StreamDemoServiceHandler,
+    register_stream_demo_service,
+)
+from streaming.v1 import service_pb2 as pb
+from streaming.v1.shared_nats_pb2 import (
+    BidiStream,
+    ClientStreamReceiver,
+    ServerInfo,
+    StreamSender,
+)
+
+
+def now() -> str:
+    return datetime.now(timezone.utc).isoformat()
+
+
+class StreamService(StreamDemoServiceHandler):
+    """Implementation of StreamDemoService"""
+
+    async def ping(self, req: pb.PingRequest, info: ServerInfo) -> pb.PingResponse:
+        """Standard unary RPC"""
+        print(f"✓ Ping: {req.payload}")
+        return pb.PingResponse(payload=f"pong: {req.payload}", timestamp=int(time.time()))
+
+    async def count_up(self, req: pb.CountUpRequest, stream: StreamSender, info: ServerInfo) -> None:
+        """Server-streaming RPC: emits `count` numbers starting from `start`"""
+        print(f"→ CountUp: start={req.start} count={req.count}")
+        for i in range(req.count):
+            number = req.start + i
+            await stream.send_msg(pb.CountUpResponse(number=number, timestamp=now()))
+            print(f"  → sent {number}")
+            await asyncio.sleep(0.2)  # Simulate work
+        print(f"✓ CountUp complete ({req.count} numbers)")
+
+    async def sum(self, stream: ClientStreamReceiver, info: ServerInfo) -> pb.SumResponse:
+        """Client-streaming RPC: reads all values and returns the total"""
+        print("→ Sum: waiting for values...")
+        total = 0
+        count = 0
+        async for msg in stream:
+            total += msg.value
+            count += 1
+            print(f"  ← received {msg.value} (running total: {total})")
+        print(f"✓ Sum complete: total={total} count={count}")
+        return pb.SumResponse(total=total, count=count)
+
+    async def chat(self, stream: BidiStream, info: ServerInfo) -> None:
+        """Bidirectional streaming RPC: echoes back each message"""
+        print("→ Chat: session started")
+        async for msg in stream:
+            print(f"  ← [{msg.user}] {msg.text}")
+            await stream.send(pb.ChatMessage(user="server", text=f"echo: {msg.text}", timestamp=now()))
+        print("✓ Chat: session ended")
+
+
+async def main():
+    """Main server function"""
+    nc = await nats.connect("nats://localhost:4222")
+    print("✓ Connected to NATS")
+
+    service = await register_stream_demo_service(nc, StreamService())
+
+    print("\n📡 StreamDemoService Endpoints:")
+    for ep in service.endpoints():
+        print(f"  • {ep.name} → {ep.subject}")
+
+    print("\n✅ Streaming server running. Press Ctrl+C to stop.")
+    try:
+        await asyncio.Event().wait()
+    finally:
+        await service.stop()
+        await nc.close()
+
+
+if __name__ == "__main__":
+    try:
+        asyncio.run(main())
+    except KeyboardInterrupt:
+        print("\n✓ Shutting down...")
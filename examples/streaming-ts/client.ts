@@ -0,0 +1,73 @@
+#!/usr/bin/env -S npx tsx
+/**
+ * Streaming TypeScript NATS Microservice Client Example
+ *
+ * Calls every kind of RPC of StreamDemoService, served by server.ts or by the
+ * Go server in examples/streaming-go.
+ *
+ * Run: npm run client
+ */
+
+import { connect } from 'nats';
+import * as pb from './gen/streaming/v1/service';
+import { StreamDemoServiceNatsClient } from './gen/streaming/v1/service_nats.pb';
+
+const sleep = (ms: number) => new Promise((resolve) => setTimeout(resolve, ms));
+
+async function main() {
+  const nc = await connect({ servers: 'nats://localhost:4222' });
+  console.log('✓ Connected to NATS');
+
+  const client = new StreamDemoServiceNatsClient(nc);
+
+  // ── 1. Unary: Ping ──────────────────────────────────────────────────
+  console.log('\n── Ping (unary) ──');
+  const pingResp = await client.ping(pb.PingRequest.create({ payload: 'hello' }));
+  console.log(`  ← ${pingResp.payload} (ts=${pingResp.timestamp})`);
+
+  // ── 2. Server-streaming: CountUp ────────────────────────────────────
+  console.log('\n── CountUp (server-streaming) ──');
+  const countStream = await client.countUp(pb.CountUpRequest.create({ start: 1, count: 5 }));
+  for await (const resp of countStream) {
+    console.log(`  ← number=${resp.number} ts=${resp.timestamp}`);
+  }
+  console.log('  ✓ Stream complete');
+
+  // ── 3. Client-streaming: Sum ────────────────────────────────────────
+  console.log('\n── Sum (client-streaming) ──');
+  const sumStream = await client.sum();
+  for (const value of [10, 20, 30, 40, 50]) {
+    console.log(`  → sending ${value}`);
+    await sumStream.send(pb.SumRequest.create({ value: String(value) }));
+    await sleep(100);
+  }
+  const sumResp = await sumStream.closeAndRecv();
+  console.log(`  ← total=${sumResp.total} count=${sumResp.count}`);
+
+  // ── 4. Bidirectional streaming: Chat ────────────────────────────────
+  console.log('\n── Chat (bidi-streaming) ──');
+  const chatStream = await client.chat();
+  for (const text of ['hello', 'how are you', 'goodbye']) {
+    console.log(`  → [client] ${text}`);
+    await chatStream.send(pb.ChatMessage.create({ user: 'client', text, timestamp: new Date().toISOString() }));
+
+    // Read echo back
+    const reply = await chatStream.recv();
+    console.log(`  ← [${reply.user}] ${reply.text}`);
+  }
+  await chatStream.closeSend();
+
+  // Wait for the server to end its side too
+  for await (const reply of chatStream) {
+    console.log(`  ← [${reply.user}] ${reply.text}`);
+  }
+  console.log('  ✓ Chat complete');
+
+  await nc.close();
+  console.log('\n✅ All streaming demos completed successfully!');
+}
+
+main().catch((err) => {
+  console.error('✗', err);
+  process.exit(1);
+});
@@ -0,0 +1,66 @@
+#!/usr/bin/env bash
+# Runs the streaming demo across languages against a local nats-server:
+# Go server with the TypeScript client, TypeScript server with the Go client,
+# and TypeScript on both sides. Each client exits non-zero on any failed call.
+#
+# Needs nats-server, go and node (with npm install run here) on PATH, and the
+# generated code: task generate:ts, or from the repository root
+#   buf generate --template examples/buf-configs/buf.gen.streaming-ts.yaml examples/protos
+set -euo pipefail
+
+cd "$(dirname "$0")"
+TSX=${TSX:-./node_modules/.bin/tsx}
+GO_DIR=../streaming-go
+PIDS=()
+
+cleanup() {
+    for pid in "${PIDS[@]}"; do
+        kill "$pid" 2>/dev/null || true
+    done
+    wait 2>/dev/null || true
+}
+trap cleanup EXIT
+
+# Use a running server on the default port, or start one
+if ! (exec 3<>/dev/tcp/127.0.0.1/4222) 2>/dev/null; then
+    nats-server -p 4222 >/dev/null 2>&1 &
+    PIDS+=($!)
+    sleep 1
+fi
+
+# Build the Go binaries up front so startup time doesn't race the clients
+BIN=$(mktemp -d)
+go build -C "$GO_DIR" -o "$BIN/server" ./cmd/server
+go build -C "$GO_DIR" -o "$BIN/client" ./cmd/client
+
+# run_case NAME SERVER... -- CLIENT...
+run_case() {
+    local name=$1
+    shift
+    local server=()
+    while [ "$1" != "--" ]; do
+        server+=("$1")
+        shift
+    done
+    shift
+
+    echo "=== $name ==="
+    "${server[@]}" >"$BIN/server.log" 2>&1 &
+    local server_pid=$!
+    sleep 2 # Give the service time to register
+    if ! "$@"; then
+        echo "✗ $name failed; server output:"
+        cat "$BIN/server.log"
+        kill "$server_pid" 2>/dev/null || true
+        exit 1
+    fi
+    kill "$server_pid" 2>/dev/null || true
+    wait "$server_pid" 2>/dev/null || true
+    echo "✓ $name passed"
+}
+
+run_case "Go server, TypeScript client" "$BIN/server" -- "$TSX" client.ts
+run_case "TypeScript server, Go client" "$TSX" server.ts -- "$BIN/client"
+run_case "TypeScript server, TypeScript client" "$TSX" server.ts -- "$TSX" client.ts
+
+echo "✅ All interop cases passed"
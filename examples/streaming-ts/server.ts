@@ -0,0 +1,91 @@
+#!/usr/bin/env -S npx tsx
+/**
+ * Streaming TypeScript NATS Microservice Server Example
+ *
+ * Serves StreamDemoService to client.ts or to the Go client in
+ * examples/streaming-go.
+ *
+ * Run: npm run server
+ */
+
+import { connect } from 'nats';
+import * as pb from './gen/streaming/v1/service';
+import {
+  registerStreamDemoServiceHandlers,
+  type ClientStreamReceiver,
+  type IStreamDemoServiceNats,
+  type ServerStreamSender,
+} from './gen/streaming/v1/service_nats.pb';
+
+const sleep = (ms: number) => new Promise((resolve) => setTimeout(resolve, ms));
+
+class StreamService implements IStreamDemoServiceNats {
+  // Standard unary RPC
+  async ping(req: pb.PingRequest): Promise<pb.PingResponse> {
+    console.log(`✓ Ping: ${req.payload}`);
+    return pb.PingResponse.create({ payload: `pong: ${req.payload}`, timestamp: String(Math.floor(Date.now() / 1000)) });
+  }
+
+  // Server-streaming RPC: emits `count` numbers starting from `start`
+  async countUp(req: pb.CountUpRequest, stream: ServerStreamSender<pb.CountUpResponse>): Promise<void> {
+    console.log(`→ CountUp: start=${req.start} count=${req.count}`);
+    for (let i = 0; i < req.count; i++) {
+      const number = req.start + i;
+      await stream.send(pb.CountUpResponse.create({ number, timestamp: new Date().toISOString() }));
+      console.log(`  → sent ${number}`);
+      await sleep(200); // Simulate work
+    }
+    console.log(`✓ CountUp complete (${req.count} numbers)`);
+  }
+
+  // Client-streaming RPC: reads all values and returns the total
+  async sum(stream: ClientStreamReceiver<pb.SumRequest>): Promise<pb.SumResponse> {
+    console.log('→ Sum: waiting for values...');
+    let total = 0n;
+    let count = 0;
+    for await (const msg of stream) {
+      total += BigInt(msg.value);
+      count++;
+      console.log(`  ← received ${msg.value} (running total: ${total})`);
+    }
+    console.log(`✓ Sum complete: total=${total} count=${count}`);
+    return pb.SumResponse.create({ total: total.toString(), count });
+  }
+
+  // Bidirectional streaming RPC: echoes back each message
+  async chat(recvStream: ClientStreamReceiver<pb.ChatMessage>, sendStream: ServerStreamSender<pb.ChatMessage>): Promise<void> {
+    console.log('→ Chat: session started');
+    for await (const msg of recvStream) {
+      console.log(`  ← [${msg.user}] ${msg.text}`);
+      await sendStream.send(pb.ChatMessage.create({ user: 'server', text: `echo: ${msg.text}`, timestamp: new Date().toISOString() }));
+    }
+    console.log('✓ Chat: session ended');
+  }
+}
+
+async function main() {
+  const nc = await connect({ servers: 'nats://localhost:4222' });
+  console.log('✓ Connected to NATS');
+
+  const service = await registerStreamDemoServiceHandlers(nc, new StreamService());
+
+  console.log('\n📡 StreamDemoService Endpoints:');
+  for (const ep of service.endpoints()) {
+    console.log(`  • ${ep.name} → ${ep.subject}`);
+  }
+  console.log('\n🚀 Streaming server running. Press Ctrl+C to stop.');
+
+  const shutdown = async () => {
+    console.log('\n🛑 Shutting down...');
+    await service.stop();
+    await nc.drain();
+    process.exit(0);
+  };
+  process.on('SIGINT', shutdown);
+  process.on('SIGTERM', shutdown);
+}
+
+main().catch((err) => {
+  console.error('✗', err);
+  process.exit(1);
+});
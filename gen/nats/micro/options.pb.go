@@ -23,6 +23,202 @@ const (
 	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
 )
 
+// Where JetStream keeps the data of a KV or Object Store bucket
+type StorageType int32
+
+const (
+	// On disk, the JetStream default
+	StorageType_FILE_STORAGE StorageType = 0
+	// In memory, lost when the server restarts
+	StorageType_MEMORY_STORAGE StorageType = 1
+)
+
+// Enum value maps for StorageType.
+var (
+	StorageType_name = map[int32]string{
+		0: "FILE_STORAGE",
+		1: "MEMORY_STORAGE",
+	}
+	StorageType_value = map[string]int32{
+		"FILE_STORAGE":   0,
+		"MEMORY_STORAGE": 1,
+	}
+)
+
+func (x StorageType) Enum() *StorageType {
+	p := new(StorageType)
+	*p = x
+	return p
+}
+
+func (x StorageType) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (StorageType) Descriptor() protoreflect.EnumDescriptor {
+	return file_natsmicro_options_proto_enumTypes[0].Descriptor()
+}
+
+func (StorageType) Type() protoreflect.EnumType {
+	return &file_natsmicro_options_proto_enumTypes[0]
+}
+
+func (x StorageType) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use StorageType.Descriptor instead.
+func (StorageType) EnumDescriptor() ([]byte, []int) {
+	return file_natsmicro_options_proto_rawDescGZIP(), []int{0}
+}
+
+// Status codes of failed calls, numbered like gRPC's google.rpc.Code. Errors
+// travel as the code's name in the Nats-Service-Error-Code header (e.g.,
+// "NOT_FOUND"); every generated language maps names to these numbers, and
+// numbers to gRPC codes and HTTP statuses, the same way. Custom
+// (natsmicro.service).error_codes are not listed and map to UNKNOWN.
+type Code int32
+
+const (
+	Code_OK                  Code = 0
+	Code_CANCELLED           Code = 1
+	Code_UNKNOWN             Code = 2
+	Code_INVALID_ARGUMENT    Code = 3
+	Code_DEADLINE_EXCEEDED   Code = 4
+	Code_NOT_FOUND           Code = 5
+	Code_ALREADY_EXISTS      Code = 6
+	Code_PERMISSION_DENIED   Code = 7
+	Code_RESOURCE_EXHAUSTED  Code = 8
+	Code_FAILED_PRECONDITION Code = 9
+	Code_ABORTED             Code = 10
+	Code_OUT_OF_RANGE        Code = 11
+	Code_UNIMPLEMENTED       Code = 12
+	Code_INTERNAL            Code = 13
+	Code_UNAVAILABLE         Code = 14
+	Code_DATA_LOSS           Code = 15
+	Code_UNAUTHENTICATED     Code = 16
+)
+
+// Enum value maps for Code.
+var (
+	Code_name = map[int32]string{
+		0:  "OK",
+		1:  "CANCELLED",
+		2:  "UNKNOWN",
+		3:  "INVALID_ARGUMENT",
+		4:  "DEADLINE_EXCEEDED",
+		5:  "NOT_FOUND",
+		6:  "ALREADY_EXISTS",
+		7:  "PERMISSION_DENIED",
+		8:  "RESOURCE_EXHAUSTED",
+		9:  "FAILED_PRECONDITION",
+		10: "ABORTED",
+		11: "OUT_OF_RANGE",
+		12: "UNIMPLEMENTED",
+		13: "INTERNAL",
+		14: "UNAVAILABLE",
+		15: "DATA_LOSS",
+		16: "UNAUTHENTICATED",
+	}
+	Code_value = map[string]int32{
+		"OK":                  0,
+		"CANCELLED":           1,
+		"UNKNOWN":             2,
+		"INVALID_ARGUMENT":    3,
+		"DEADLINE_EXCEEDED":   4,
+		"NOT_FOUND":           5,
+		"ALREADY_EXISTS":      6,
+		"PERMISSION_DENIED":   7,
+		"RESOURCE_EXHAUSTED":  8,
+		"FAILED_PRECONDITION": 9,
+		"ABORTED":             10,
+		"OUT_OF_RANGE":        11,
+		"UNIMPLEMENTED":       12,
+		"INTERNAL":            13,
+		"UNAVAILABLE":         14,
+		"DATA_LOSS":           15,
+		"UNAUTHENTICATED":     16,
+	}
+)
+
+func (x Code) Enum() *Code {
+	p := new(Code)
+	*p = x
+	return p
+}
+
+func (x Code) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (Code) Descriptor() protoreflect.EnumDescriptor {
+	return file_natsmicro_options_proto_enumTypes[1].Descriptor()
+}
+
+func (Code) Type() protoreflect.EnumType {
+	return &file_natsmicro_options_proto_enumTypes[1]
+}
+
+func (x Code) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use Code.Descriptor instead.
+func (Code) EnumDescriptor() ([]byte, []int) {
+	return file_natsmicro_options_proto_rawDescGZIP(), []int{1}
+}
+
+// Concurrency modes for server-side persistence
+type KVStoreOptions_Concurrency int32
+
+const (
+	// Every response is written with Put; the last write wins
+	KVStoreOptions_LAST_WRITE_WINS KVStoreOptions_Concurrency = 0
+	// The entry's revision is read before the handler runs and the response
+	// is written only if the entry is still at that revision. A concurrent
+	// write makes the call fail with ABORTED.
+	KVStoreOptions_REVISION_CHECK KVStoreOptions_Concurrency = 1
+)
+
+// Enum value maps for KVStoreOptions_Concurrency.
+var (
+	KVStoreOptions_Concurrency_name = map[int32]string{
+		0: "LAST_WRITE_WINS",
+		1: "REVISION_CHECK",
+	}
+	KVStoreOptions_Concurrency_value = map[string]int32{
+		"LAST_WRITE_WINS": 0,
+		"REVISION_CHECK":  1,
+	}
+)
+
+func (x KVStoreOptions_Concurrency) Enum() *KVStoreOptions_Concurrency {
+	p := new(KVStoreOptions_Concurrency)
+	*p = x
+	return p
+}
+
+func (x KVStoreOptions_Concurrency) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (KVStoreOptions_Concurrency) Descriptor() protoreflect.EnumDescriptor {
+	return file_natsmicro_options_proto_enumTypes[2].Descriptor()
+}
+
+func (KVStoreOptions_Concurrency) Type() protoreflect.EnumType {
+	return &file_natsmicro_options_proto_enumTypes[2]
+}
+
+func (x KVStoreOptions_Concurrency) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use KVStoreOptions_Concurrency.Descriptor instead.
+func (KVStoreOptions_Concurrency) EnumDescriptor() ([]byte, []int) {
+	return file_natsmicro_options_proto_rawDescGZIP(), []int{3, 0}
+}
+
 // Service-level options for NATS microservices
 type ServiceOptions struct {
 	state protoimpl.MessageState `protogen:"open.v1"`
@@ -52,9 +248,28 @@ type ServiceOptions struct {
 	// "PAYMENT_FAILED") These are generated as additional constants,
 	// constructors, and checkers alongside the 7 built-in error codes
 	// (INVALID_ARGUMENT, NOT_FOUND, etc.)
-	ErrorCodes    []string `protobuf:"bytes,9,rep,name=error_codes,json=errorCodes,proto3" json:"error_codes,omitempty"`
-	unknownFields protoimpl.UnknownFields
-	sizeCache     protoimpl.SizeCache
+	ErrorCodes []string `protobuf:"bytes,9,rep,name=error_codes,json=errorCodes,proto3" json:"error_codes,omitempty"`
+	// Encode 64-bit integer fields as JSON numbers instead of strings when json
+	// is true (optional, defaults to false) The default protojson string form
+	// keeps values above 2^53 intact for JavaScript consumers; only enable this
+	// for consumers that cannot parse strings. Affects generated Go code only
+	JsonInt64AsNumber bool `protobuf:"varint,10,opt,name=json_int64_as_number,json=jsonInt64AsNumber,proto3" json:"json_int64_as_number,omitempty"`
+	// Queue group for all endpoints of this service (optional, defaults to the
+	// NATS micro default "q") Replicas sharing a queue group split requests
+	// between them. Can be overridden at runtime with WithQueueGroup
+	QueueGroup string `protobuf:"bytes,11,opt,name=queue_group,json=queueGroup,proto3" json:"queue_group,omitempty"`
+	// Refuse plaintext NATS connections (optional, defaults to false) Generated
+	// Go registration fails, and generated Go client calls fail, unless the
+	// connection uses TLS. WithInsecureServiceAllowed and WithInsecureAllowed
+	// lift the check for local development
+	RequireTls bool `protobuf:"varint,12,opt,name=require_tls,json=requireTls,proto3" json:"require_tls,omitempty"`
+	// Insert the major component of version as a subject token (optional,
+	// defaults to false), e.g. "api.orders.v2.create_order" for version "2.1.0",
+	// so several versions of a service can share subject_prefix. Generated clients
+	// call the version they were generated from unless told otherwise
+	VersionInSubject bool `protobuf:"varint,13,opt,name=version_in_subject,json=versionInSubject,proto3" json:"version_in_subject,omitempty"`
+	unknownFields    protoimpl.UnknownFields
+	sizeCache        protoimpl.SizeCache
 }
 
 func (x *ServiceOptions) Reset() {
@@ -150,6 +365,34 @@ func (x *ServiceOptions) GetErrorCodes() []string {
 	return nil
 }
 
+func (x *ServiceOptions) GetJsonInt64AsNumber() bool {
+	if x != nil {
+		return x.JsonInt64AsNumber
+	}
+	return false
+}
+
+func (x *ServiceOptions) GetQueueGroup() string {
+	if x != nil {
+		return x.QueueGroup
+	}
+	return ""
+}
+
+func (x *ServiceOptions) GetRequireTls() bool {
+	if x != nil {
+		return x.RequireTls
+	}
+	return false
+}
+
+func (x *ServiceOptions) GetVersionInSubject() bool {
+	if x != nil {
+		return x.VersionInSubject
+	}
+	return false
+}
+
 // Endpoint-level options for individual RPC methods
 type EndpointOptions struct {
 	state protoimpl.MessageState `protogen:"open.v1"`
@@ -161,7 +404,52 @@ type EndpointOptions struct {
 	Skip bool `protobuf:"varint,2,opt,name=skip,proto3" json:"skip,omitempty"`
 	// Endpoint metadata (optional, key-value pairs for endpoint-specific
 	// metadata) This metadata is passed to the NATS micro endpoint registration
-	Metadata      map[string]string `protobuf:"bytes,3,rep,name=metadata,proto3" json:"metadata,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	Metadata map[string]string `protobuf:"bytes,3,rep,name=metadata,proto3" json:"metadata,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	// Exact NATS subject for this endpoint (optional, e.g.,
+	// "orders.legacy.create") Overrides the default "<subject_prefix>.<method>"
+	// subject; the service subject prefix is not applied. Must not contain
+	// whitespace or wildcard tokens
+	Subject string `protobuf:"bytes,4,opt,name=subject,proto3" json:"subject,omitempty"`
+	// Publish requests without waiting for a response (optional, defaults to
+	// false) The Go client method returns only an error and the handler's
+	// result is not sent back. The output type must be google.protobuf.Empty or
+	// a message without fields
+	FireAndForget bool `protobuf:"varint,5,opt,name=fire_and_forget,json=fireAndForget,proto3" json:"fire_and_forget,omitempty"`
+	// Named server middlewares to run for this unary endpoint, in order (optional,
+	// e.g., ["auth", "audit"]) Go services resolve the names against
+	// WithMiddlewareRegistry at registration, after the service-wide interceptors
+	Middlewares []string `protobuf:"bytes,6,rep,name=middlewares,proto3" json:"middlewares,omitempty"`
+	// Accept impersonated calls on this unary endpoint (optional, default false).
+	// A Go ImpersonationInterceptor rejects X-Impersonate requests to every other endpoint
+	AllowImpersonation bool `protobuf:"varint,7,opt,name=allow_impersonation,json=allowImpersonation,proto3" json:"allow_impersonation,omitempty"`
+	// Wire encoding of this endpoint's messages: "json" or "binary" (optional,
+	// defaults to the service's json option). Clients and services generated from
+	// the same proto agree on it without negotiation
+	Encoding string `protobuf:"bytes,8,opt,name=encoding,proto3" json:"encoding,omitempty"`
+	// Generate a Go <Method>All client helper that follows page tokens (optional,
+	// defaults to false). The request needs a string page_token field, and the
+	// response a string next_page_token field and exactly one repeated field
+	Paginated bool `protobuf:"varint,9,opt,name=paginated,proto3" json:"paginated,omitempty"`
+	// Scopes a caller must all hold to call this endpoint (optional, e.g.,
+	// ["orders:write"]). Empty means public. Go services enforce them with
+	// NewScopeAuthInterceptor; MethodDescriptors lists them for other layers
+	RequiredScopes []string `protobuf:"bytes,10,rep,name=required_scopes,json=requiredScopes,proto3" json:"required_scopes,omitempty"`
+	// Generate a Go <Method>Gather client call that collects a response from every
+	// instance of the service, e.g., one per data shard (optional, defaults to
+	// false). Each instance then answers in a queue group of its own
+	ScatterGather bool `protobuf:"varint,11,opt,name=scatter_gather,json=scatterGather,proto3" json:"scatter_gather,omitempty"`
+	// Subject built from request fields (optional, e.g.,
+	// "orders.{region}.create") Each {field} placeholder is a whole token, filled
+	// from a string, integer, bool or enum field of the request, so NATS subject
+	// permissions can restrict callers per value. The service subscribes to the
+	// wildcard form ("orders.*.create"); the service subject prefix is not
+	// applied. Unary methods only, Go only
+	SubjectTemplate string `protobuf:"bytes,12,opt,name=subject_template,json=subjectTemplate,proto3" json:"subject_template,omitempty"`
+	// Request headers the endpoint reads (optional, Go only), e.g.,
+	// [{name: "x-tenant-id", required: true}]. Each header generates a
+	// <Accessor>FromContext accessor for handlers and a With<Accessor> client
+	// helper, and is listed in the schema document and endpoint metadata
+	Headers       []*HeaderOptions `protobuf:"bytes,13,rep,name=headers,proto3" json:"headers,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -217,10 +505,151 @@ func (x *EndpointOptions) GetMetadata() map[string]string {
 	return nil
 }
 
+func (x *EndpointOptions) GetSubject() string {
+	if x != nil {
+		return x.Subject
+	}
+	return ""
+}
+
+func (x *EndpointOptions) GetFireAndForget() bool {
+	if x != nil {
+		return x.FireAndForget
+	}
+	return false
+}
+
+func (x *EndpointOptions) GetMiddlewares() []string {
+	if x != nil {
+		return x.Middlewares
+	}
+	return nil
+}
+
+func (x *EndpointOptions) GetAllowImpersonation() bool {
+	if x != nil {
+		return x.AllowImpersonation
+	}
+	return false
+}
+
+func (x *EndpointOptions) GetEncoding() string {
+	if x != nil {
+		return x.Encoding
+	}
+	return ""
+}
+
+func (x *EndpointOptions) GetPaginated() bool {
+	if x != nil {
+		return x.Paginated
+	}
+	return false
+}
+
+func (x *EndpointOptions) GetRequiredScopes() []string {
+	if x != nil {
+		return x.RequiredScopes
+	}
+	return nil
+}
+
+func (x *EndpointOptions) GetScatterGather() bool {
+	if x != nil {
+		return x.ScatterGather
+	}
+	return false
+}
+
+func (x *EndpointOptions) GetSubjectTemplate() string {
+	if x != nil {
+		return x.SubjectTemplate
+	}
+	return ""
+}
+
+func (x *EndpointOptions) GetHeaders() []*HeaderOptions {
+	if x != nil {
+		return x.Headers
+	}
+	return nil
+}
+
+// A request header declared by (natsmicro.endpoint).headers (Go only)
+type HeaderOptions struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Header name (e.g., "x-tenant-id"): letters and digits, separated by single
+	// hyphens. Names starting with "Nats-" are reserved by NATS
+	Name string `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	// Reject requests without the header, or with an empty one, with
+	// INVALID_ARGUMENT before the handler runs (optional, defaults to false).
+	// Unary methods that respond only
+	Required bool `protobuf:"varint,2,opt,name=required,proto3" json:"required,omitempty"`
+	// Name of the generated accessors (optional, e.g., "Tenant" generates
+	// TenantFromContext and WithTenant). Defaults to the header name in camel
+	// case without an "x-" prefix, e.g., "TenantID" for "x-tenant-id"
+	Accessor      string `protobuf:"bytes,3,opt,name=accessor,proto3" json:"accessor,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *HeaderOptions) Reset() {
+	*x = HeaderOptions{}
+	mi := &file_natsmicro_options_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *HeaderOptions) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*HeaderOptions) ProtoMessage() {}
+
+func (x *HeaderOptions) ProtoReflect() protoreflect.Message {
+	mi := &file_natsmicro_options_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use HeaderOptions.ProtoReflect.Descriptor instead.
+func (*HeaderOptions) Descriptor() ([]byte, []int) {
+	return file_natsmicro_options_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *HeaderOptions) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *HeaderOptions) GetRequired() bool {
+	if x != nil {
+		return x.Required
+	}
+	return false
+}
+
+func (x *HeaderOptions) GetAccessor() string {
+	if x != nil {
+		return x.Accessor
+	}
+	return ""
+}
+
 // KV Store options for RPC methods
 // When set, the server handler automatically persists the response
 // into a NATS JetStream KV bucket after processing.
 // The bucket is auto-created during service registration if it doesn't exist.
+// An existing bucket is left as it is, with a warning for each declared
+// setting it does not match.
 type KVStoreOptions struct {
 	state protoimpl.MessageState `protogen:"open.v1"`
 	// KV bucket name (e.g., "user_profiles")
@@ -229,6 +658,8 @@ type KVStoreOptions struct {
 	// e.g., "user.{id}" extracts the 'id' field from the request
 	KeyTemplate string `protobuf:"bytes,2,opt,name=key_template,json=keyTemplate,proto3" json:"key_template,omitempty"`
 	// TTL for entries — auto-expire cached data after this duration (optional)
+	// Methods sharing a bucket must declare the same ttl, max_history,
+	// limit_marker_ttl, replicas and storage
 	Ttl *durationpb.Duration `protobuf:"bytes,3,opt,name=ttl,proto3" json:"ttl,omitempty"`
 	// Human-readable description for the bucket (optional)
 	Description string `protobuf:"bytes,4,opt,name=description,proto3" json:"description,omitempty"`
@@ -238,14 +669,38 @@ type KVStoreOptions struct {
 	// If true, skip server-side auto-persist — only generate client read/write
 	// methods Use this when you want direct client access to KV without RPC
 	// involvement
-	ClientOnly    bool `protobuf:"varint,6,opt,name=client_only,json=clientOnly,proto3" json:"client_only,omitempty"`
+	ClientOnly bool `protobuf:"varint,6,opt,name=client_only,json=clientOnly,proto3" json:"client_only,omitempty"`
+	// How concurrent calls writing the same key are resolved (optional, default
+	// LAST_WRITE_WINS)
+	Concurrency KVStoreOptions_Concurrency `protobuf:"varint,7,opt,name=concurrency,proto3,enum=natsmicro.KVStoreOptions_Concurrency" json:"concurrency,omitempty"`
+	// With REVISION_CHECK, re-run the handler on a conflict instead of returning
+	// ABORTED (optional)
+	RetryOnConflict bool `protobuf:"varint,8,opt,name=retry_on_conflict,json=retryOnConflict,proto3" json:"retry_on_conflict,omitempty"`
+	// Tag stored with each entry and checked by the generated KV readers
+	// (optional). Required on every method when methods persisting different
+	// response types share a bucket, one distinct tag per type.
+	TypeTag string `protobuf:"bytes,9,opt,name=type_tag,json=typeTag,proto3" json:"type_tag,omitempty"`
+	// How long the bucket keeps a marker for each entry its ttl expires
+	// (optional, requires ttl and nats-server 2.11+). While the marker is kept,
+	// the generated KV readers return ErrKVKeyExpired instead of a plain
+	// not-found error for the key.
+	LimitMarkerTtl *durationpb.Duration `protobuf:"bytes,10,opt,name=limit_marker_ttl,json=limitMarkerTtl,proto3" json:"limit_marker_ttl,omitempty"`
+	// Name of a bool field of the response; the response is persisted only when
+	// it is true, e.g., "complete" to skip partial results (optional)
+	PersistIf string `protobuf:"bytes,11,opt,name=persist_if,json=persistIf,proto3" json:"persist_if,omitempty"`
+	// Number of replicas of the bucket in a clustered JetStream, 1 to 5
+	// (optional, default 1). Only applies when registration creates the bucket.
+	Replicas int32 `protobuf:"varint,12,opt,name=replicas,proto3" json:"replicas,omitempty"`
+	// Where the bucket's data is kept (optional, default FILE_STORAGE). Only
+	// applies when registration creates the bucket.
+	Storage       StorageType `protobuf:"varint,13,opt,name=storage,proto3,enum=natsmicro.StorageType" json:"storage,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
 func (x *KVStoreOptions) Reset() {
 	*x = KVStoreOptions{}
-	mi := &file_natsmicro_options_proto_msgTypes[2]
+	mi := &file_natsmicro_options_proto_msgTypes[3]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -257,7 +712,7 @@ func (x *KVStoreOptions) String() string {
 func (*KVStoreOptions) ProtoMessage() {}
 
 func (x *KVStoreOptions) ProtoReflect() protoreflect.Message {
-	mi := &file_natsmicro_options_proto_msgTypes[2]
+	mi := &file_natsmicro_options_proto_msgTypes[3]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -270,7 +725,7 @@ func (x *KVStoreOptions) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use KVStoreOptions.ProtoReflect.Descriptor instead.
 func (*KVStoreOptions) Descriptor() ([]byte, []int) {
-	return file_natsmicro_options_proto_rawDescGZIP(), []int{2}
+	return file_natsmicro_options_proto_rawDescGZIP(), []int{3}
 }
 
 func (x *KVStoreOptions) GetBucket() string {
@@ -315,6 +770,55 @@ func (x *KVStoreOptions) GetClientOnly() bool {
 	return false
 }
 
+func (x *KVStoreOptions) GetConcurrency() KVStoreOptions_Concurrency {
+	if x != nil {
+		return x.Concurrency
+	}
+	return KVStoreOptions_LAST_WRITE_WINS
+}
+
+func (x *KVStoreOptions) GetRetryOnConflict() bool {
+	if x != nil {
+		return x.RetryOnConflict
+	}
+	return false
+}
+
+func (x *KVStoreOptions) GetTypeTag() string {
+	if x != nil {
+		return x.TypeTag
+	}
+	return ""
+}
+
+func (x *KVStoreOptions) GetLimitMarkerTtl() *durationpb.Duration {
+	if x != nil {
+		return x.LimitMarkerTtl
+	}
+	return nil
+}
+
+func (x *KVStoreOptions) GetPersistIf() string {
+	if x != nil {
+		return x.PersistIf
+	}
+	return ""
+}
+
+func (x *KVStoreOptions) GetReplicas() int32 {
+	if x != nil {
+		return x.Replicas
+	}
+	return 0
+}
+
+func (x *KVStoreOptions) GetStorage() StorageType {
+	if x != nil {
+		return x.Storage
+	}
+	return StorageType_FILE_STORAGE
+}
+
 // Object Store options for RPC methods
 // When set, the handler stores/retrieves large binary objects
 // from a NATS JetStream Object Store bucket.
@@ -331,14 +835,22 @@ type ObjectStoreOptions struct {
 	Description string `protobuf:"bytes,4,opt,name=description,proto3" json:"description,omitempty"`
 	// If true, skip server-side auto-persist — only generate client read/write
 	// methods
-	ClientOnly    bool `protobuf:"varint,5,opt,name=client_only,json=clientOnly,proto3" json:"client_only,omitempty"`
+	ClientOnly bool `protobuf:"varint,5,opt,name=client_only,json=clientOnly,proto3" json:"client_only,omitempty"`
+	// Name of a bool field of the response; the response is persisted only when
+	// it is true (optional)
+	PersistIf string `protobuf:"bytes,6,opt,name=persist_if,json=persistIf,proto3" json:"persist_if,omitempty"`
+	// Number of replicas of the bucket in a clustered JetStream, 1 to 5
+	// (optional, default 1)
+	Replicas int32 `protobuf:"varint,7,opt,name=replicas,proto3" json:"replicas,omitempty"`
+	// Where the bucket's data is kept (optional, default FILE_STORAGE)
+	Storage       StorageType `protobuf:"varint,8,opt,name=storage,proto3,enum=natsmicro.StorageType" json:"storage,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
 func (x *ObjectStoreOptions) Reset() {
 	*x = ObjectStoreOptions{}
-	mi := &file_natsmicro_options_proto_msgTypes[3]
+	mi := &file_natsmicro_options_proto_msgTypes[4]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -350,7 +862,7 @@ func (x *ObjectStoreOptions) String() string {
 func (*ObjectStoreOptions) ProtoMessage() {}
 
 func (x *ObjectStoreOptions) ProtoReflect() protoreflect.Message {
-	mi := &file_natsmicro_options_proto_msgTypes[3]
+	mi := &file_natsmicro_options_proto_msgTypes[4]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -363,7 +875,7 @@ func (x *ObjectStoreOptions) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use ObjectStoreOptions.ProtoReflect.Descriptor instead.
 func (*ObjectStoreOptions) Descriptor() ([]byte, []int) {
-	return file_natsmicro_options_proto_rawDescGZIP(), []int{3}
+	return file_natsmicro_options_proto_rawDescGZIP(), []int{4}
 }
 
 func (x *ObjectStoreOptions) GetBucket() string {
@@ -401,20 +913,50 @@ func (x *ObjectStoreOptions) GetClientOnly() bool {
 	return false
 }
 
+func (x *ObjectStoreOptions) GetPersistIf() string {
+	if x != nil {
+		return x.PersistIf
+	}
+	return ""
+}
+
+func (x *ObjectStoreOptions) GetReplicas() int32 {
+	if x != nil {
+		return x.Replicas
+	}
+	return 0
+}
+
+func (x *ObjectStoreOptions) GetStorage() StorageType {
+	if x != nil {
+		return x.Storage
+	}
+	return StorageType_FILE_STORAGE
+}
+
 // Streaming options for fine-tuning streaming RPC behavior
 type StreamOptions struct {
 	state protoimpl.MessageState `protogen:"open.v1"`
 	// Max concurrent in-flight messages (backpressure, 0 = unlimited)
 	MaxInflight int32 `protobuf:"varint,1,opt,name=max_inflight,json=maxInflight,proto3" json:"max_inflight,omitempty"`
 	// Guarantee message ordering via sequence headers
-	Ordered       bool `protobuf:"varint,2,opt,name=ordered,proto3" json:"ordered,omitempty"`
-	unknownFields protoimpl.UnknownFields
-	sizeCache     protoimpl.SizeCache
+	Ordered bool `protobuf:"varint,2,opt,name=ordered,proto3" json:"ordered,omitempty"`
+	// Back a server stream with JetStream (optional, defaults to false, Go only).
+	// The server writes each message to a JetStream stream instead of the client's
+	// inbox, and the client reads them through an ordered consumer, so a client
+	// that disconnects can resume from the last message it received. Requires
+	// WithJetStream on the server and WithNatsClientJetStream on the client.
+	// Only applies to server-streaming methods
+	Persistence bool `protobuf:"varint,3,opt,name=persistence,proto3" json:"persistence,omitempty"`
+	// How long persisted messages are kept (optional, defaults to one hour)
+	PersistenceTtl *durationpb.Duration `protobuf:"bytes,4,opt,name=persistence_ttl,json=persistenceTtl,proto3" json:"persistence_ttl,omitempty"`
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
 }
 
 func (x *StreamOptions) Reset() {
 	*x = StreamOptions{}
-	mi := &file_natsmicro_options_proto_msgTypes[4]
+	mi := &file_natsmicro_options_proto_msgTypes[5]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -426,7 +968,7 @@ func (x *StreamOptions) String() string {
 func (*StreamOptions) ProtoMessage() {}
 
 func (x *StreamOptions) ProtoReflect() protoreflect.Message {
-	mi := &file_natsmicro_options_proto_msgTypes[4]
+	mi := &file_natsmicro_options_proto_msgTypes[5]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -439,7 +981,7 @@ func (x *StreamOptions) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use StreamOptions.ProtoReflect.Descriptor instead.
 func (*StreamOptions) Descriptor() ([]byte, []int) {
-	return file_natsmicro_options_proto_rawDescGZIP(), []int{4}
+	return file_natsmicro_options_proto_rawDescGZIP(), []int{5}
 }
 
 func (x *StreamOptions) GetMaxInflight() int32 {
@@ -456,6 +998,209 @@ func (x *StreamOptions) GetOrdered() bool {
 	return false
 }
 
+func (x *StreamOptions) GetPersistence() bool {
+	if x != nil {
+		return x.Persistence
+	}
+	return false
+}
+
+func (x *StreamOptions) GetPersistenceTtl() *durationpb.Duration {
+	if x != nil {
+		return x.PersistenceTtl
+	}
+	return nil
+}
+
+// Enrichment options for unary RPC methods
+// When set, the server reads an entry from a NATS JetStream KV bucket before
+// the handler runs and exposes the decoded message on the handler context
+// through a generated <ContextKey>FromContext accessor (Go only).
+type EnrichOptions struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// KV bucket to read from (e.g., "user_profiles")
+	Bucket string `protobuf:"bytes,1,opt,name=bucket,proto3" json:"bucket,omitempty"`
+	// Key template with {field} placeholders resolved from the request message
+	// e.g., "user.{customer_id}"
+	KeyTemplate string `protobuf:"bytes,2,opt,name=key_template,json=keyTemplate,proto3" json:"key_template,omitempty"`
+	// Name of the context value, used for the accessor name (e.g., "profile"
+	// generates ProfileFromContext)
+	ContextKey string `protobuf:"bytes,3,opt,name=context_key,json=contextKey,proto3" json:"context_key,omitempty"`
+	// Fully-qualified message type stored in the bucket (e.g.,
+	// "kvstore_demo.v1.ProfileResponse") Must be in the same proto package as
+	// the service
+	Type          string `protobuf:"bytes,4,opt,name=type,proto3" json:"type,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *EnrichOptions) Reset() {
+	*x = EnrichOptions{}
+	mi := &file_natsmicro_options_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *EnrichOptions) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*EnrichOptions) ProtoMessage() {}
+
+func (x *EnrichOptions) ProtoReflect() protoreflect.Message {
+	mi := &file_natsmicro_options_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use EnrichOptions.ProtoReflect.Descriptor instead.
+func (*EnrichOptions) Descriptor() ([]byte, []int) {
+	return file_natsmicro_options_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *EnrichOptions) GetBucket() string {
+	if x != nil {
+		return x.Bucket
+	}
+	return ""
+}
+
+func (x *EnrichOptions) GetKeyTemplate() string {
+	if x != nil {
+		return x.KeyTemplate
+	}
+	return ""
+}
+
+func (x *EnrichOptions) GetContextKey() string {
+	if x != nil {
+		return x.ContextKey
+	}
+	return ""
+}
+
+func (x *EnrichOptions) GetType() string {
+	if x != nil {
+		return x.Type
+	}
+	return ""
+}
+
+// Field-level options for request and response messages (Go only)
+type FieldOptions struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Clear this field wherever generated Go code logs or records a message:
+	// RedactMessage, the payload logging interceptors, journals, samples and
+	// panic and failure reports (optional, defaults to false). Works like
+	// [debug_redact = true], in nested, repeated and map-valued messages too.
+	Sensitive     bool `protobuf:"varint,1,opt,name=sensitive,proto3" json:"sensitive,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *FieldOptions) Reset() {
+	*x = FieldOptions{}
+	mi := &file_natsmicro_options_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *FieldOptions) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*FieldOptions) ProtoMessage() {}
+
+func (x *FieldOptions) ProtoReflect() protoreflect.Message {
+	mi := &file_natsmicro_options_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use FieldOptions.ProtoReflect.Descriptor instead.
+func (*FieldOptions) Descriptor() ([]byte, []int) {
+	return file_natsmicro_options_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *FieldOptions) GetSensitive() bool {
+	if x != nil {
+		return x.Sensitive
+	}
+	return false
+}
+
+// Message-level options that make a message an event, published and
+// subscribed to on a subject of its own rather than sent to a service (Go only).
+// Generated Go code gets Publish<Message> and Subscribe<Message> functions, also
+// for protos that declare no services.
+type EventOptions struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Subject the events are published on (e.g., "events.order.created"), without
+	// wildcards. No two events of a proto file may share one.
+	Subject string `protobuf:"bytes,1,opt,name=subject,proto3" json:"subject,omitempty"`
+	// Wire encoding of the events: "json" or "binary" (optional, defaults to
+	// "binary"). Subscribers also decode events whose Content-Type names the other.
+	Encoding      string `protobuf:"bytes,2,opt,name=encoding,proto3" json:"encoding,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *EventOptions) Reset() {
+	*x = EventOptions{}
+	mi := &file_natsmicro_options_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *EventOptions) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*EventOptions) ProtoMessage() {}
+
+func (x *EventOptions) ProtoReflect() protoreflect.Message {
+	mi := &file_natsmicro_options_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use EventOptions.ProtoReflect.Descriptor instead.
+func (*EventOptions) Descriptor() ([]byte, []int) {
+	return file_natsmicro_options_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *EventOptions) GetSubject() string {
+	if x != nil {
+		return x.Subject
+	}
+	return ""
+}
+
+func (x *EventOptions) GetEncoding() string {
+	if x != nil {
+		return x.Encoding
+	}
+	return ""
+}
+
 var file_natsmicro_options_proto_extTypes = []protoimpl.ExtensionInfo{
 	{
 		ExtendedType:  (*descriptorpb.ServiceOptions)(nil),
@@ -497,6 +1242,30 @@ var file_natsmicro_options_proto_extTypes = []protoimpl.ExtensionInfo{
 		Tag:           "bytes,50005,opt,name=stream",
 		Filename:      "natsmicro/options.proto",
 	},
+	{
+		ExtendedType:  (*descriptorpb.MethodOptions)(nil),
+		ExtensionType: (*EnrichOptions)(nil),
+		Field:         50006,
+		Name:          "natsmicro.enrich",
+		Tag:           "bytes,50006,opt,name=enrich",
+		Filename:      "natsmicro/options.proto",
+	},
+	{
+		ExtendedType:  (*descriptorpb.FieldOptions)(nil),
+		ExtensionType: (*FieldOptions)(nil),
+		Field:         50007,
+		Name:          "natsmicro.field",
+		Tag:           "bytes,50007,opt,name=field",
+		Filename:      "natsmicro/options.proto",
+	},
+	{
+		ExtendedType:  (*descriptorpb.MessageOptions)(nil),
+		ExtensionType: (*EventOptions)(nil),
+		Field:         50008,
+		Name:          "natsmicro.event",
+		Tag:           "bytes,50008,opt,name=event",
+		Filename:      "natsmicro/options.proto",
+	},
 }
 
 // Extension fields to descriptorpb.ServiceOptions.
@@ -515,13 +1284,27 @@ var (
 	E_ObjectStore = &file_natsmicro_options_proto_extTypes[3]
 	// optional natsmicro.StreamOptions stream = 50005;
 	E_Stream = &file_natsmicro_options_proto_extTypes[4]
+	// optional natsmicro.EnrichOptions enrich = 50006;
+	E_Enrich = &file_natsmicro_options_proto_extTypes[5]
+)
+
+// Extension fields to descriptorpb.FieldOptions.
+var (
+	// optional natsmicro.FieldOptions field = 50007;
+	E_Field = &file_natsmicro_options_proto_extTypes[6]
+)
+
+// Extension fields to descriptorpb.MessageOptions.
+var (
+	// optional natsmicro.EventOptions event = 50008;
+	E_Event = &file_natsmicro_options_proto_extTypes[7]
 )
 
 var File_natsmicro_options_proto protoreflect.FileDescriptor
 
 const file_natsmicro_options_proto_rawDesc = "" +
 	"\n" +
-	"\x17natsmicro/options.proto\x12\tnatsmicro\x1a google/protobuf/descriptor.proto\x1a\x1egoogle/protobuf/duration.proto\"\x87\x03\n" +
+	"\x17natsmicro/options.proto\x12\tnatsmicro\x1a google/protobuf/descriptor.proto\x1a\x1egoogle/protobuf/duration.proto\"\xa8\x04\n" +
 	"\x0eServiceOptions\x12%\n" +
 	"\x0esubject_prefix\x18\x01 \x01(\tR\rsubjectPrefix\x12\x12\n" +
 	"\x04name\x18\x02 \x01(\tR\x04name\x12\x18\n" +
@@ -532,17 +1315,39 @@ const file_natsmicro_options_proto_rawDesc = "" +
 	"\x04skip\x18\a \x01(\bR\x04skip\x12\x12\n" +
 	"\x04json\x18\b \x01(\bR\x04json\x12\x1f\n" +
 	"\verror_codes\x18\t \x03(\tR\n" +
-	"errorCodes\x1a;\n" +
+	"errorCodes\x12/\n" +
+	"\x14json_int64_as_number\x18\n" +
+	" \x01(\bR\x11jsonInt64AsNumber\x12\x1f\n" +
+	"\vqueue_group\x18\v \x01(\tR\n" +
+	"queueGroup\x12\x1f\n" +
+	"\vrequire_tls\x18\f \x01(\bR\n" +
+	"requireTls\x12,\n" +
+	"\x12version_in_subject\x18\r \x01(\bR\x10versionInSubject\x1a;\n" +
 	"\rMetadataEntry\x12\x10\n" +
 	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
-	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"\xdd\x01\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"\xdb\x04\n" +
 	"\x0fEndpointOptions\x123\n" +
 	"\atimeout\x18\x01 \x01(\v2\x19.google.protobuf.DurationR\atimeout\x12\x12\n" +
 	"\x04skip\x18\x02 \x01(\bR\x04skip\x12D\n" +
-	"\bmetadata\x18\x03 \x03(\v2(.natsmicro.EndpointOptions.MetadataEntryR\bmetadata\x1a;\n" +
+	"\bmetadata\x18\x03 \x03(\v2(.natsmicro.EndpointOptions.MetadataEntryR\bmetadata\x12\x18\n" +
+	"\asubject\x18\x04 \x01(\tR\asubject\x12&\n" +
+	"\x0ffire_and_forget\x18\x05 \x01(\bR\rfireAndForget\x12 \n" +
+	"\vmiddlewares\x18\x06 \x03(\tR\vmiddlewares\x12/\n" +
+	"\x13allow_impersonation\x18\a \x01(\bR\x12allowImpersonation\x12\x1a\n" +
+	"\bencoding\x18\b \x01(\tR\bencoding\x12\x1c\n" +
+	"\tpaginated\x18\t \x01(\bR\tpaginated\x12'\n" +
+	"\x0frequired_scopes\x18\n" +
+	" \x03(\tR\x0erequiredScopes\x12%\n" +
+	"\x0escatter_gather\x18\v \x01(\bR\rscatterGather\x12)\n" +
+	"\x10subject_template\x18\f \x01(\tR\x0fsubjectTemplate\x122\n" +
+	"\aheaders\x18\r \x03(\v2\x18.natsmicro.HeaderOptionsR\aheaders\x1a;\n" +
 	"\rMetadataEntry\x12\x10\n" +
 	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
-	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"\xdc\x01\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"[\n" +
+	"\rHeaderOptions\x12\x12\n" +
+	"\x04name\x18\x01 \x01(\tR\x04name\x12\x1a\n" +
+	"\brequired\x18\x02 \x01(\bR\brequired\x12\x1a\n" +
+	"\baccessor\x18\x03 \x01(\tR\baccessor\"\xd6\x04\n" +
 	"\x0eKVStoreOptions\x12\x16\n" +
 	"\x06bucket\x18\x01 \x01(\tR\x06bucket\x12!\n" +
 	"\fkey_template\x18\x02 \x01(\tR\vkeyTemplate\x12+\n" +
@@ -551,22 +1356,76 @@ const file_natsmicro_options_proto_rawDesc = "" +
 	"\vmax_history\x18\x05 \x01(\x05R\n" +
 	"maxHistory\x12\x1f\n" +
 	"\vclient_only\x18\x06 \x01(\bR\n" +
-	"clientOnly\"\xbf\x01\n" +
+	"clientOnly\x12G\n" +
+	"\vconcurrency\x18\a \x01(\x0e2%.natsmicro.KVStoreOptions.ConcurrencyR\vconcurrency\x12*\n" +
+	"\x11retry_on_conflict\x18\b \x01(\bR\x0fretryOnConflict\x12\x19\n" +
+	"\btype_tag\x18\t \x01(\tR\atypeTag\x12C\n" +
+	"\x10limit_marker_ttl\x18\n" +
+	" \x01(\v2\x19.google.protobuf.DurationR\x0elimitMarkerTtl\x12\x1d\n" +
+	"\n" +
+	"persist_if\x18\v \x01(\tR\tpersistIf\x12\x1a\n" +
+	"\breplicas\x18\f \x01(\x05R\breplicas\x120\n" +
+	"\astorage\x18\r \x01(\x0e2\x16.natsmicro.StorageTypeR\astorage\"6\n" +
+	"\vConcurrency\x12\x13\n" +
+	"\x0fLAST_WRITE_WINS\x10\x00\x12\x12\n" +
+	"\x0eREVISION_CHECK\x10\x01\"\xac\x02\n" +
 	"\x12ObjectStoreOptions\x12\x16\n" +
 	"\x06bucket\x18\x01 \x01(\tR\x06bucket\x12!\n" +
 	"\fkey_template\x18\x02 \x01(\tR\vkeyTemplate\x12+\n" +
 	"\x03ttl\x18\x03 \x01(\v2\x19.google.protobuf.DurationR\x03ttl\x12 \n" +
 	"\vdescription\x18\x04 \x01(\tR\vdescription\x12\x1f\n" +
 	"\vclient_only\x18\x05 \x01(\bR\n" +
-	"clientOnly\"L\n" +
+	"clientOnly\x12\x1d\n" +
+	"\n" +
+	"persist_if\x18\x06 \x01(\tR\tpersistIf\x12\x1a\n" +
+	"\breplicas\x18\a \x01(\x05R\breplicas\x120\n" +
+	"\astorage\x18\b \x01(\x0e2\x16.natsmicro.StorageTypeR\astorage\"\xb2\x01\n" +
 	"\rStreamOptions\x12!\n" +
 	"\fmax_inflight\x18\x01 \x01(\x05R\vmaxInflight\x12\x18\n" +
-	"\aordered\x18\x02 \x01(\bR\aordered:V\n" +
+	"\aordered\x18\x02 \x01(\bR\aordered\x12 \n" +
+	"\vpersistence\x18\x03 \x01(\bR\vpersistence\x12B\n" +
+	"\x0fpersistence_ttl\x18\x04 \x01(\v2\x19.google.protobuf.DurationR\x0epersistenceTtl\"\x7f\n" +
+	"\rEnrichOptions\x12\x16\n" +
+	"\x06bucket\x18\x01 \x01(\tR\x06bucket\x12!\n" +
+	"\fkey_template\x18\x02 \x01(\tR\vkeyTemplate\x12\x1f\n" +
+	"\vcontext_key\x18\x03 \x01(\tR\n" +
+	"contextKey\x12\x12\n" +
+	"\x04type\x18\x04 \x01(\tR\x04type\",\n" +
+	"\fFieldOptions\x12\x1c\n" +
+	"\tsensitive\x18\x01 \x01(\bR\tsensitive\"D\n" +
+	"\fEventOptions\x12\x18\n" +
+	"\asubject\x18\x01 \x01(\tR\asubject\x12\x1a\n" +
+	"\bencoding\x18\x02 \x01(\tR\bencoding*3\n" +
+	"\vStorageType\x12\x10\n" +
+	"\fFILE_STORAGE\x10\x00\x12\x12\n" +
+	"\x0eMEMORY_STORAGE\x10\x01*\xb7\x02\n" +
+	"\x04Code\x12\x06\n" +
+	"\x02OK\x10\x00\x12\r\n" +
+	"\tCANCELLED\x10\x01\x12\v\n" +
+	"\aUNKNOWN\x10\x02\x12\x14\n" +
+	"\x10INVALID_ARGUMENT\x10\x03\x12\x15\n" +
+	"\x11DEADLINE_EXCEEDED\x10\x04\x12\r\n" +
+	"\tNOT_FOUND\x10\x05\x12\x12\n" +
+	"\x0eALREADY_EXISTS\x10\x06\x12\x15\n" +
+	"\x11PERMISSION_DENIED\x10\a\x12\x16\n" +
+	"\x12RESOURCE_EXHAUSTED\x10\b\x12\x17\n" +
+	"\x13FAILED_PRECONDITION\x10\t\x12\v\n" +
+	"\aABORTED\x10\n" +
+	"\x12\x10\n" +
+	"\fOUT_OF_RANGE\x10\v\x12\x11\n" +
+	"\rUNIMPLEMENTED\x10\f\x12\f\n" +
+	"\bINTERNAL\x10\r\x12\x0f\n" +
+	"\vUNAVAILABLE\x10\x0e\x12\r\n" +
+	"\tDATA_LOSS\x10\x0f\x12\x13\n" +
+	"\x0fUNAUTHENTICATED\x10\x10:V\n" +
 	"\aservice\x12\x1f.google.protobuf.ServiceOptions\x18ц\x03 \x01(\v2\x19.natsmicro.ServiceOptionsR\aservice:X\n" +
 	"\bendpoint\x12\x1e.google.protobuf.MethodOptions\x18҆\x03 \x01(\v2\x1a.natsmicro.EndpointOptionsR\bendpoint:V\n" +
 	"\bkv_store\x12\x1e.google.protobuf.MethodOptions\x18ӆ\x03 \x01(\v2\x19.natsmicro.KVStoreOptionsR\akvStore:b\n" +
 	"\fobject_store\x12\x1e.google.protobuf.MethodOptions\x18Ԇ\x03 \x01(\v2\x1d.natsmicro.ObjectStoreOptionsR\vobjectStore:R\n" +
-	"\x06stream\x12\x1e.google.protobuf.MethodOptions\x18Ն\x03 \x01(\v2\x18.natsmicro.StreamOptionsR\x06streamB6Z4github.com/toyz/protoc-gen-nats-micro/gen/nats/microb\x06proto3"
+	"\x06stream\x12\x1e.google.protobuf.MethodOptions\x18Ն\x03 \x01(\v2\x18.natsmicro.StreamOptionsR\x06stream:R\n" +
+	"\x06enrich\x12\x1e.google.protobuf.MethodOptions\x18ֆ\x03 \x01(\v2\x18.natsmicro.EnrichOptionsR\x06enrich:N\n" +
+	"\x05field\x12\x1d.google.protobuf.FieldOptions\x18׆\x03 \x01(\v2\x17.natsmicro.FieldOptionsR\x05field:P\n" +
+	"\x05event\x12\x1f.google.protobuf.MessageOptions\x18؆\x03 \x01(\v2\x17.natsmicro.EventOptionsR\x05eventB6Z4github.com/toyz/protoc-gen-nats-micro/gen/nats/microb\x06proto3"
 
 var (
 	file_natsmicro_options_proto_rawDescOnce sync.Once
@@ -580,41 +1439,63 @@ func file_natsmicro_options_proto_rawDescGZIP() []byte {
 	return file_natsmicro_options_proto_rawDescData
 }
 
-var file_natsmicro_options_proto_msgTypes = make([]protoimpl.MessageInfo, 7)
+var file_natsmicro_options_proto_enumTypes = make([]protoimpl.EnumInfo, 3)
+var file_natsmicro_options_proto_msgTypes = make([]protoimpl.MessageInfo, 11)
 var file_natsmicro_options_proto_goTypes = []any{
-	(*ServiceOptions)(nil),              // 0: natsmicro.ServiceOptions
-	(*EndpointOptions)(nil),             // 1: natsmicro.EndpointOptions
-	(*KVStoreOptions)(nil),              // 2: natsmicro.KVStoreOptions
-	(*ObjectStoreOptions)(nil),          // 3: natsmicro.ObjectStoreOptions
-	(*StreamOptions)(nil),               // 4: natsmicro.StreamOptions
-	nil,                                 // 5: natsmicro.ServiceOptions.MetadataEntry
-	nil,                                 // 6: natsmicro.EndpointOptions.MetadataEntry
-	(*durationpb.Duration)(nil),         // 7: google.protobuf.Duration
-	(*descriptorpb.ServiceOptions)(nil), // 8: google.protobuf.ServiceOptions
-	(*descriptorpb.MethodOptions)(nil),  // 9: google.protobuf.MethodOptions
+	(StorageType)(0),                    // 0: natsmicro.StorageType
+	(Code)(0),                           // 1: natsmicro.Code
+	(KVStoreOptions_Concurrency)(0),     // 2: natsmicro.KVStoreOptions.Concurrency
+	(*ServiceOptions)(nil),              // 3: natsmicro.ServiceOptions
+	(*EndpointOptions)(nil),             // 4: natsmicro.EndpointOptions
+	(*HeaderOptions)(nil),               // 5: natsmicro.HeaderOptions
+	(*KVStoreOptions)(nil),              // 6: natsmicro.KVStoreOptions
+	(*ObjectStoreOptions)(nil),          // 7: natsmicro.ObjectStoreOptions
+	(*StreamOptions)(nil),               // 8: natsmicro.StreamOptions
+	(*EnrichOptions)(nil),               // 9: natsmicro.EnrichOptions
+	(*FieldOptions)(nil),                // 10: natsmicro.FieldOptions
+	(*EventOptions)(nil),                // 11: natsmicro.EventOptions
+	nil,                                 // 12: natsmicro.ServiceOptions.MetadataEntry
+	nil,                                 // 13: natsmicro.EndpointOptions.MetadataEntry
+	(*durationpb.Duration)(nil),         // 14: google.protobuf.Duration
+	(*descriptorpb.ServiceOptions)(nil), // 15: google.protobuf.ServiceOptions
+	(*descriptorpb.MethodOptions)(nil),  // 16: google.protobuf.MethodOptions
+	(*descriptorpb.FieldOptions)(nil),   // 17: google.protobuf.FieldOptions
+	(*descriptorpb.MessageOptions)(nil), // 18: google.protobuf.MessageOptions
 }
 var file_natsmicro_options_proto_depIdxs = []int32{
-	5,  // 0: natsmicro.ServiceOptions.metadata:type_name -> natsmicro.ServiceOptions.MetadataEntry
-	7,  // 1: natsmicro.ServiceOptions.timeout:type_name -> google.protobuf.Duration
-	7,  // 2: natsmicro.EndpointOptions.timeout:type_name -> google.protobuf.Duration
-	6,  // 3: natsmicro.EndpointOptions.metadata:type_name -> natsmicro.EndpointOptions.MetadataEntry
-	7,  // 4: natsmicro.KVStoreOptions.ttl:type_name -> google.protobuf.Duration
-	7,  // 5: natsmicro.ObjectStoreOptions.ttl:type_name -> google.protobuf.Duration
-	8,  // 6: natsmicro.service:extendee -> google.protobuf.ServiceOptions
-	9,  // 7: natsmicro.endpoint:extendee -> google.protobuf.MethodOptions
-	9,  // 8: natsmicro.kv_store:extendee -> google.protobuf.MethodOptions
-	9,  // 9: natsmicro.object_store:extendee -> google.protobuf.MethodOptions
-	9,  // 10: natsmicro.stream:extendee -> google.protobuf.MethodOptions
-	0,  // 11: natsmicro.service:type_name -> natsmicro.ServiceOptions
-	1,  // 12: natsmicro.endpoint:type_name -> natsmicro.EndpointOptions
-	2,  // 13: natsmicro.kv_store:type_name -> natsmicro.KVStoreOptions
-	3,  // 14: natsmicro.object_store:type_name -> natsmicro.ObjectStoreOptions
-	4,  // 15: natsmicro.stream:type_name -> natsmicro.StreamOptions
-	16, // [16:16] is the sub-list for method output_type
-	16, // [16:16] is the sub-list for method input_type
-	11, // [11:16] is the sub-list for extension type_name
-	6,  // [6:11] is the sub-list for extension extendee
-	0,  // [0:6] is the sub-list for field type_name
+	12, // 0: natsmicro.ServiceOptions.metadata:type_name -> natsmicro.ServiceOptions.MetadataEntry
+	14, // 1: natsmicro.ServiceOptions.timeout:type_name -> google.protobuf.Duration
+	14, // 2: natsmicro.EndpointOptions.timeout:type_name -> google.protobuf.Duration
+	13, // 3: natsmicro.EndpointOptions.metadata:type_name -> natsmicro.EndpointOptions.MetadataEntry
+	5,  // 4: natsmicro.EndpointOptions.headers:type_name -> natsmicro.HeaderOptions
+	14, // 5: natsmicro.KVStoreOptions.ttl:type_name -> google.protobuf.Duration
+	2,  // 6: natsmicro.KVStoreOptions.concurrency:type_name -> natsmicro.KVStoreOptions.Concurrency
+	14, // 7: natsmicro.KVStoreOptions.limit_marker_ttl:type_name -> google.protobuf.Duration
+	0,  // 8: natsmicro.KVStoreOptions.storage:type_name -> natsmicro.StorageType
+	14, // 9: natsmicro.ObjectStoreOptions.ttl:type_name -> google.protobuf.Duration
+	0,  // 10: natsmicro.ObjectStoreOptions.storage:type_name -> natsmicro.StorageType
+	14, // 11: natsmicro.StreamOptions.persistence_ttl:type_name -> google.protobuf.Duration
+	15, // 12: natsmicro.service:extendee -> google.protobuf.ServiceOptions
+	16, // 13: natsmicro.endpoint:extendee -> google.protobuf.MethodOptions
+	16, // 14: natsmicro.kv_store:extendee -> google.protobuf.MethodOptions
+	16, // 15: natsmicro.object_store:extendee -> google.protobuf.MethodOptions
+	16, // 16: natsmicro.stream:extendee -> google.protobuf.MethodOptions
+	16, // 17: natsmicro.enrich:extendee -> google.protobuf.MethodOptions
+	17, // 18: natsmicro.field:extendee -> google.protobuf.FieldOptions
+	18, // 19: natsmicro.event:extendee -> google.protobuf.MessageOptions
+	3,  // 20: natsmicro.service:type_name -> natsmicro.ServiceOptions
+	4,  // 21: natsmicro.endpoint:type_name -> natsmicro.EndpointOptions
+	6,  // 22: natsmicro.kv_store:type_name -> natsmicro.KVStoreOptions
+	7,  // 23: natsmicro.object_store:type_name -> natsmicro.ObjectStoreOptions
+	8,  // 24: natsmicro.stream:type_name -> natsmicro.StreamOptions
+	9,  // 25: natsmicro.enrich:type_name -> natsmicro.EnrichOptions
+	10, // 26: natsmicro.field:type_name -> natsmicro.FieldOptions
+	11, // 27: natsmicro.event:type_name -> natsmicro.EventOptions
+	28, // [28:28] is the sub-list for method output_type
+	28, // [28:28] is the sub-list for method input_type
+	20, // [20:28] is the sub-list for extension type_name
+	12, // [12:20] is the sub-list for extension extendee
+	0,  // [0:12] is the sub-list for field type_name
 }
 
 func init() { file_natsmicro_options_proto_init() }
@@ -627,13 +1508,14 @@ func file_natsmicro_options_proto_init() {
 		File: protoimpl.DescBuilder{
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_natsmicro_options_proto_rawDesc), len(file_natsmicro_options_proto_rawDesc)),
-			NumEnums:      0,
-			NumMessages:   7,
-			NumExtensions: 5,
+			NumEnums:      3,
+			NumMessages:   11,
+			NumExtensions: 8,
 			NumServices:   0,
 		},
 		GoTypes:           file_natsmicro_options_proto_goTypes,
 		DependencyIndexes: file_natsmicro_options_proto_depIdxs,
+		EnumInfos:         file_natsmicro_options_proto_enumTypes,
 		MessageInfos:      file_natsmicro_options_proto_msgTypes,
 		ExtensionInfos:    file_natsmicro_options_proto_extTypes,
 	}.Build()
@@ -0,0 +1,109 @@
+// Package natsrpc holds the request and response headers that code generated by
+// protoc-gen-nats-micro carries in a context.Context. Every generated package
+// stores them here, so an interceptor written against one package, or against
+// this one, reads headers set through any other:
+//
+//	func logRequests(ctx context.Context, req interface{}, info *orderv1.UnaryServerInfo, handler orderv1.UnaryHandler) (interface{}, error) {
+//		log.Printf("%s from %s", info.Method, natsrpc.IncomingHeaders(ctx).Get("X-Caller"))
+//		return handler(ctx, req)
+//	}
+//
+// Generated packages keep their IncomingHeaders, WithOutgoingHeaders,
+// SetResponseHeaders and related functions, which call these.
+package natsrpc
+
+import (
+	"context"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
+)
+
+// contextKey keys the headers in a context
+type contextKey int
+
+const (
+	incomingHeadersKey contextKey = iota
+	outgoingHeadersKey
+	responseHeadersKey
+	pendingResponseHeadersKey
+)
+
+// IncomingHeaders returns the headers of the request a handler is serving, or nil
+func IncomingHeaders(ctx context.Context) micro.Headers {
+	if headers, ok := ctx.Value(incomingHeadersKey).(micro.Headers); ok {
+		return headers
+	}
+	return nil
+}
+
+// WithIncomingHeaders returns a copy of ctx carrying a request's headers, for
+// IncomingHeaders. Generated services call it for each request.
+func WithIncomingHeaders(ctx context.Context, headers micro.Headers) context.Context {
+	return context.WithValue(ctx, incomingHeadersKey, headers)
+}
+
+// OutgoingHeaders returns the headers client calls made with ctx send, or nil
+func OutgoingHeaders(ctx context.Context) nats.Header {
+	if headers, ok := ctx.Value(outgoingHeadersKey).(nats.Header); ok {
+		return headers
+	}
+	return nil
+}
+
+// WithOutgoingHeaders returns a copy of ctx whose client calls send headers,
+// replacing any set before.
+// Example: ctx := natsrpc.WithOutgoingHeaders(ctx, nats.Header{"Authorization": []string{"Bearer token"}})
+func WithOutgoingHeaders(ctx context.Context, headers nats.Header) context.Context {
+	return context.WithValue(ctx, outgoingHeadersKey, headers)
+}
+
+// ResponseHeaders returns the headers of the response to a client call, for
+// client interceptors once the call returns. It returns nil before then, or if
+// the response had none.
+func ResponseHeaders(ctx context.Context) nats.Header {
+	if headers, ok := ctx.Value(responseHeadersKey).(*nats.Header); ok && headers != nil {
+		return *headers
+	}
+	return nil
+}
+
+// WithResponseHeaders returns a copy of ctx in which StoreResponseHeaders stores a
+// call's response headers to *headers. Generated clients call it for each call.
+func WithResponseHeaders(ctx context.Context, headers *nats.Header) context.Context {
+	return context.WithValue(ctx, responseHeadersKey, headers)
+}
+
+// StoreResponseHeaders records the headers of the response to the call ctx was
+// made for, so ResponseHeaders returns them. It does nothing if ctx didn't come
+// from WithResponseHeaders.
+func StoreResponseHeaders(ctx context.Context, headers nats.Header) {
+	if target, ok := ctx.Value(responseHeadersKey).(*nats.Header); ok && target != nil {
+		*target = headers
+	}
+}
+
+// SetResponseHeaders sets the headers sent back with the response to the request
+// a handler is serving, replacing any set before. Server interceptors and
+// handlers call it; it changes ctx in place, so there is nothing to return.
+// Example: natsrpc.SetResponseHeaders(ctx, nats.Header{"X-Server-Version": []string{"1.0.0"}})
+func SetResponseHeaders(ctx context.Context, headers nats.Header) {
+	if target, ok := ctx.Value(pendingResponseHeadersKey).(*nats.Header); ok && target != nil {
+		*target = headers
+	}
+}
+
+// WithPendingResponseHeaders returns a copy of ctx in which SetResponseHeaders
+// stores a handler's response headers to *headers. Generated services call it for
+// each request.
+func WithPendingResponseHeaders(ctx context.Context, headers *nats.Header) context.Context {
+	return context.WithValue(ctx, pendingResponseHeadersKey, headers)
+}
+
+// PendingResponseHeaders returns what SetResponseHeaders last set for ctx, or nil
+func PendingResponseHeaders(ctx context.Context) nats.Header {
+	if headers, ok := ctx.Value(pendingResponseHeadersKey).(*nats.Header); ok && headers != nil {
+		return *headers
+	}
+	return nil
+}
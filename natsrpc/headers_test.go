@@ -0,0 +1,48 @@
+package natsrpc
+
+import (
+	"context"
+	"testing"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
+)
+
+func TestHeaders(t *testing.T) {
+	ctx := context.Background()
+	if IncomingHeaders(ctx) != nil || OutgoingHeaders(ctx) != nil || ResponseHeaders(ctx) != nil || PendingResponseHeaders(ctx) != nil {
+		t.Fatal("expected no headers in an empty context")
+	}
+	// Setting headers a context wasn't prepared for is a no-op
+	SetResponseHeaders(ctx, nats.Header{"X-Ignored": []string{"1"}})
+	StoreResponseHeaders(ctx, nats.Header{"X-Ignored": []string{"1"}})
+
+	ctx = WithIncomingHeaders(ctx, micro.Headers{"X-Caller": []string{"billing"}})
+	ctx = WithOutgoingHeaders(ctx, nats.Header{"Authorization": []string{"Bearer token"}})
+	if got := IncomingHeaders(ctx).Get("X-Caller"); got != "billing" {
+		t.Errorf("IncomingHeaders: got %q, want billing", got)
+	}
+	if got := OutgoingHeaders(ctx).Get("Authorization"); got != "Bearer token" {
+		t.Errorf("OutgoingHeaders: got %q, want Bearer token", got)
+	}
+
+	var pending nats.Header
+	serverCtx := WithPendingResponseHeaders(ctx, &pending)
+	SetResponseHeaders(serverCtx, nats.Header{"X-Server-Version": []string{"1.0.0"}})
+	if pending.Get("X-Server-Version") != "1.0.0" || PendingResponseHeaders(serverCtx).Get("X-Server-Version") != "1.0.0" {
+		t.Errorf("SetResponseHeaders: got %v", pending)
+	}
+	if ResponseHeaders(serverCtx) != nil {
+		t.Error("expected a handler's response headers not to be a client call's")
+	}
+
+	var response nats.Header
+	clientCtx := WithResponseHeaders(ctx, &response)
+	StoreResponseHeaders(clientCtx, nats.Header{"X-Request-Id": []string{"abc"}})
+	if got := ResponseHeaders(clientCtx).Get("X-Request-Id"); got != "abc" || response.Get("X-Request-Id") != "abc" {
+		t.Errorf("ResponseHeaders: got %q, want abc", got)
+	}
+	if PendingResponseHeaders(clientCtx) != nil {
+		t.Error("expected a client call's response headers not to be a handler's")
+	}
+}
@@ -0,0 +1,77 @@
+// Command nats-micro-migrate rewrites Go code that references generated identifiers
+// renamed between protoc-gen-nats-micro versions. It prints a diff by default and
+// only touches files with -w.
+//
+//	nats-micro-migrate -from 0.2.0 [-to 0.3.0] [-w] [-C dir] [packages]
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/toyz/protoc-gen-nats-micro/tools/nats-micro-migrate/migrate"
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// run returns the process exit code: 0 on success, 1 on migration failures, 2 on usage failures
+func run(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("nats-micro-migrate", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	from := fs.String("from", "", "plugin version the code was generated with")
+	to := fs.String("to", migrate.Latest(), "plugin version to migrate to")
+	write := fs.Bool("w", false, "rewrite files instead of printing a diff")
+	dir := fs.String("C", ".", "module directory")
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "usage: nats-micro-migrate -from version [-to version] [-w] [-C dir] [packages]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *from == "" {
+		fs.Usage()
+		return 2
+	}
+
+	renames, err := migrate.Plan(*from, *to)
+	if err != nil {
+		fmt.Fprintf(stderr, "nats-micro-migrate: %v\n", err)
+		return 2
+	}
+	changes, err := migrate.Run(*dir, renames, fs.Args()...)
+	if err != nil {
+		fmt.Fprintf(stderr, "nats-micro-migrate: %v\n", err)
+		return 1
+	}
+
+	if *write {
+		if err := migrate.Apply(changes); err != nil {
+			fmt.Fprintf(stderr, "nats-micro-migrate: %v\n", err)
+			return 1
+		}
+	}
+	root, err := filepath.Abs(*dir)
+	if err != nil {
+		fmt.Fprintf(stderr, "nats-micro-migrate: %v\n", err)
+		return 1
+	}
+	for _, c := range changes {
+		rel, err := filepath.Rel(root, c.Path)
+		if err != nil {
+			rel = c.Path
+		}
+		rel = filepath.ToSlash(rel)
+		if *write {
+			fmt.Fprintf(stdout, "%s: %d renamed\n", rel, len(c.Edits))
+		} else {
+			fmt.Fprint(stdout, migrate.Diff(c, rel))
+		}
+	}
+	return 0
+}
@@ -0,0 +1,33 @@
+package migrate
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Diff renders c as a unified diff with paths relative to the module. Renames never
+// add or remove lines, so each hunk is a run of changed lines without context.
+func Diff(c FileChange, rel string) string {
+	before := strings.Split(string(c.Before), "\n")
+	after := strings.Split(string(c.After), "\n")
+	var b strings.Builder
+	fmt.Fprintf(&b, "--- a/%s\n+++ b/%s\n", rel, rel)
+	for i := 0; i < len(before); {
+		if before[i] == after[i] {
+			i++
+			continue
+		}
+		start := i
+		for i < len(before) && before[i] != after[i] {
+			i++
+		}
+		fmt.Fprintf(&b, "@@ -%d,%d +%d,%d @@\n", start+1, i-start, start+1, i-start)
+		for _, line := range before[start:i] {
+			fmt.Fprintf(&b, "-%s\n", line)
+		}
+		for _, line := range after[start:i] {
+			fmt.Fprintf(&b, "+%s\n", line)
+		}
+	}
+	return b.String()
+}
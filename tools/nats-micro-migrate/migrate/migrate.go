@@ -0,0 +1,217 @@
+// Package migrate rewrites Go code that references generated identifiers renamed
+// by a protoc-gen-nats-micro upgrade.
+package migrate
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// generatedSuffix names the files protoc-gen-nats-micro writes for Go
+const generatedSuffix = "_nats.pb.go"
+
+// Edit is one identifier rewrite
+type Edit struct {
+	Pos      token.Position
+	Old, New string
+}
+
+// FileChange holds the original and rewritten source of one file
+type FileChange struct {
+	Path          string
+	Before, After []byte
+	Edits         []Edit
+}
+
+// Run loads the packages matching patterns in the module at dir and returns every
+// file that references a renamed generated identifier, rewritten in memory.
+// References are resolved through type information, so aliased and dot imports are
+// followed; a generated package may come from the module or its vendor directory.
+// Generated and vendored files are never rewritten.
+func Run(dir string, renames map[string]string, patterns ...string) ([]FileChange, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	if len(patterns) == 0 {
+		patterns = []string{"./..."}
+	}
+	cfg := &packages.Config{
+		// Dependencies are type-checked from source: a generated package with a
+		// hand-written file using an old name has no export data to load
+		Mode: packages.NeedName | packages.NeedFiles | packages.NeedImports | packages.NeedDeps |
+			packages.NeedTypes | packages.NeedSyntax | packages.NeedTypesInfo,
+		Dir:   dir,
+		Tests: true,
+	}
+	// Resolve imports from vendor/ even when GOFLAGS says otherwise
+	if _, err := os.Stat(filepath.Join(dir, "vendor", "modules.txt")); err == nil {
+		cfg.BuildFlags = []string{"-mod=vendor"}
+	}
+	pkgs, err := packages.Load(cfg, patterns...)
+	if err != nil {
+		return nil, fmt.Errorf("load packages: %w", err)
+	}
+	// Code referencing a removed identifier no longer type-checks, so type errors are
+	// expected; only packages that failed to load at all are fatal
+	for _, pkg := range pkgs {
+		if pkg.Types == nil || pkg.TypesInfo == nil {
+			return nil, fmt.Errorf("load %s: %v", pkg.PkgPath, pkg.Errors)
+		}
+	}
+
+	generated := generatedPackages(pkgs)
+
+	var changes []FileChange
+	seen := make(map[string]bool)
+	for _, pkg := range pkgs {
+		for _, file := range pkg.Syntax {
+			path := pkg.Fset.File(file.Pos()).Name()
+			// Test variants repeat the package's own files
+			if seen[path] || ast.IsGenerated(file) || isVendored(dir, path) {
+				continue
+			}
+			seen[path] = true
+			edits := findEdits(pkg, file, generated, renames)
+			if len(edits) == 0 {
+				continue
+			}
+			change, err := rewrite(path, edits)
+			if err != nil {
+				return nil, err
+			}
+			changes = append(changes, change)
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	return changes, nil
+}
+
+// Apply writes every change back to disk
+func Apply(changes []FileChange) error {
+	for _, c := range changes {
+		info, err := os.Stat(c.Path)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(c.Path, c.After, info.Mode().Perm()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// generatedPackages returns the import paths of the loaded packages and their direct
+// imports that contain protoc-gen-nats-micro output
+func generatedPackages(pkgs []*packages.Package) map[string]bool {
+	generated := make(map[string]bool)
+	for _, pkg := range pkgs {
+		if hasGeneratedFile(pkg.GoFiles) {
+			generated[pkg.PkgPath] = true
+		}
+		for _, imp := range pkg.Imports {
+			if hasGeneratedFile(imp.GoFiles) {
+				generated[imp.PkgPath] = true
+			}
+		}
+	}
+	return generated
+}
+
+func hasGeneratedFile(files []string) bool {
+	for _, f := range files {
+		if strings.HasSuffix(f, generatedSuffix) {
+			return true
+		}
+	}
+	return false
+}
+
+func isVendored(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && strings.HasPrefix(filepath.ToSlash(rel), "vendor/")
+}
+
+// findEdits returns the renamed identifiers file refers to in generated packages
+func findEdits(pkg *packages.Package, file *ast.File, generated map[string]bool, renames map[string]string) []Edit {
+	info := pkg.TypesInfo
+	// Unresolved bare names can only come from a generated package when the file
+	// sits in one or dot-imports one
+	bareFromGenerated := generated[pkg.PkgPath]
+	for _, imp := range file.Imports {
+		if imp.Name != nil && imp.Name.Name == "." && generated[strings.Trim(imp.Path.Value, `"`)] {
+			bareFromGenerated = true
+		}
+	}
+
+	var edits []Edit
+	rename := func(id *ast.Ident) {
+		if renamed, ok := renames[id.Name]; ok {
+			edits = append(edits, Edit{Pos: pkg.Fset.Position(id.Pos()), Old: id.Name, New: renamed})
+		}
+	}
+	var visit func(ast.Node) bool
+	visit = func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.SelectorExpr:
+			// pkg.Name, whatever local name the import uses
+			if x, ok := n.X.(*ast.Ident); ok {
+				if name, ok := info.Uses[x].(*types.PkgName); ok {
+					if generated[name.Imported().Path()] {
+						rename(n.Sel)
+					}
+					return false
+				}
+			}
+			// Field and method selectors are never package-level identifiers
+			ast.Inspect(n.X, visit)
+			return false
+		case *ast.Ident:
+			if _, ok := info.Defs[n]; ok {
+				return false
+			}
+			obj := info.Uses[n]
+			switch {
+			case obj == nil:
+				if bareFromGenerated {
+					rename(n)
+				}
+			case obj.Pkg() != nil && generated[obj.Pkg().Path()] && obj.Parent() == obj.Pkg().Scope():
+				rename(n)
+			}
+		}
+		return true
+	}
+	ast.Inspect(file, visit)
+	return edits
+}
+
+// rewrite applies edits to the source of path
+func rewrite(path string, edits []Edit) (FileChange, error) {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return FileChange{}, err
+	}
+	sort.Slice(edits, func(i, j int) bool { return edits[i].Pos.Offset < edits[j].Pos.Offset })
+	var out bytes.Buffer
+	last := 0
+	for _, e := range edits {
+		if !bytes.HasPrefix(src[e.Pos.Offset:], []byte(e.Old)) {
+			return FileChange{}, fmt.Errorf("%s: source changed while migrating", e.Pos)
+		}
+		out.Write(src[last:e.Pos.Offset])
+		out.WriteString(e.New)
+		last = e.Pos.Offset + len(e.Old)
+	}
+	out.Write(src[last:])
+	return FileChange{Path: path, Before: src, After: out.Bytes(), Edits: edits}, nil
+}
@@ -0,0 +1,84 @@
+package migrate
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRunFixture(t *testing.T) {
+	renames, err := Plan("0.2.0", "0.3.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := filepath.Abs("testdata/fixture")
+	if err != nil {
+		t.Fatal(err)
+	}
+	changes, err := Run(dir, renames)
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+
+	// Generated and vendored files stay untouched, as do the application's own
+	// WithClientSubjectPrefix and builder.WithClientJetStream
+	var got []string
+	var diff strings.Builder
+	for _, c := range changes {
+		rel, err := filepath.Rel(dir, c.Path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rel = filepath.ToSlash(rel)
+		got = append(got, rel)
+		diff.WriteString(Diff(c, rel))
+
+		want, err := os.ReadFile(filepath.Join("testdata/golden", rel+".golden"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(c.After) != string(want) {
+			t.Errorf("%s rewritten as:\n%s\nwant:\n%s", rel, c.After, want)
+		}
+	}
+	want := []string{"app/main.go", "app/main_test.go", "gen/orders/v1/defaults.go", "worker/worker.go"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("rewrote %v, want %v", got, want)
+	}
+
+	wantDiff, err := os.ReadFile("testdata/fixture.diff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff.String() != string(wantDiff) {
+		t.Errorf("diff:\n%s\nwant:\n%s", diff.String(), wantDiff)
+	}
+}
+
+func TestPlan(t *testing.T) {
+	saved := Steps
+	defer func() { Steps = saved }()
+	Steps = []Step{
+		{From: "1.0.0", To: "1.1.0", Renames: map[string]string{"A": "B", "X": "Y"}},
+		{From: "1.1.0", To: "2.0.0", Renames: map[string]string{"B": "C", "M": "N"}},
+	}
+
+	renames, err := Plan("1.0.0", "2.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]string{"A": "C", "B": "C", "X": "Y", "M": "N"}; !reflect.DeepEqual(renames, want) {
+		t.Errorf("Plan(1.0.0, 2.0.0) = %v, want %v", renames, want)
+	}
+	if renames, err := Plan("2.0.0", "2.0.0"); err != nil || len(renames) != 0 {
+		t.Errorf("Plan to the same version = %v, %v, want no renames", renames, err)
+	}
+	if _, err := Plan("2.0.0", "1.0.0"); err == nil {
+		t.Error("Plan backwards should fail")
+	}
+	if _, err := Plan("0.9.0", "2.0.0"); err == nil {
+		t.Error("Plan from an unknown version should fail")
+	}
+}
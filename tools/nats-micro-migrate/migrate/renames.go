@@ -0,0 +1,62 @@
+package migrate
+
+import "fmt"
+
+// Step renames generated identifiers between two consecutive plugin versions
+type Step struct {
+	From, To string
+	// Renames maps an old generated identifier to its replacement
+	Renames map[string]string
+}
+
+// Steps lists every plugin release that renamed generated identifiers, oldest first
+var Steps = []Step{
+	{
+		From: "0.2.0",
+		To:   "0.3.0",
+		// Client options gained the Nats prefix so they no longer read like server options
+		Renames: map[string]string{
+			"WithClientSubjectPrefix": "WithNatsClientSubjectPrefix",
+			"WithClientJetStream":     "WithNatsClientJetStream",
+		},
+	},
+}
+
+// Latest returns the newest plugin version the rename table knows about
+func Latest() string {
+	return Steps[len(Steps)-1].To
+}
+
+// Plan chains the steps leading from one plugin version to another into a single
+// rename table. An identifier renamed twice maps straight to its final name.
+func Plan(from, to string) (map[string]string, error) {
+	renames := make(map[string]string)
+	version := from
+	for version != to {
+		step, ok := stepFrom(version)
+		if !ok {
+			return nil, fmt.Errorf("no migration from %s to %s", from, to)
+		}
+		for old, renamed := range renames {
+			if next, ok := step.Renames[renamed]; ok {
+				renames[old] = next
+			}
+		}
+		for old, renamed := range step.Renames {
+			if _, ok := renames[old]; !ok {
+				renames[old] = renamed
+			}
+		}
+		version = step.To
+	}
+	return renames, nil
+}
+
+func stepFrom(version string) (Step, bool) {
+	for _, s := range Steps {
+		if s.From == version {
+			return s, true
+		}
+	}
+	return Step{}, false
+}
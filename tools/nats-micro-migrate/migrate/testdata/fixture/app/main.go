@@ -0,0 +1,23 @@
+package main
+
+import (
+	billing "example.com/billing/gen/billing/v1"
+	orders "example.com/shop/gen/orders/v1"
+)
+
+// builder has a method that happens to share a renamed identifier
+type builder struct{}
+
+func (builder) WithClientJetStream() builder { return builder{} }
+
+// WithClientSubjectPrefix is the application's own function and keeps its name
+func WithClientSubjectPrefix(prefix string) string { return prefix }
+
+func main() {
+	_ = orders.NewOrderServiceNatsClient(
+		orders.WithClientSubjectPrefix(WithClientSubjectPrefix("staging")),
+		orders.WithClientJetStream(nil),
+	)
+	_ = billing.NewInvoiceServiceNatsClient(billing.WithClientJetStream(nil))
+	_ = builder{}.WithClientJetStream()
+}
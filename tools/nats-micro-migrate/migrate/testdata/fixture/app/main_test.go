@@ -0,0 +1,11 @@
+package main
+
+import (
+	"testing"
+
+	ordersv1 "example.com/shop/gen/orders/v1"
+)
+
+func TestClient(t *testing.T) {
+	_ = ordersv1.NewOrderServiceNatsClient(ordersv1.WithClientJetStream(nil))
+}
@@ -0,0 +1,4 @@
+package ordersv1
+
+// DefaultOptions live beside the generated code, so they use bare names
+var DefaultOptions = []NatsClientOption{WithClientSubjectPrefix("shop")}
@@ -0,0 +1,5 @@
+module example.com/shop
+
+go 1.22
+
+require example.com/billing v1.0.0
@@ -0,0 +1,10 @@
+package worker
+
+import (
+	. "example.com/shop/gen/orders/v1"
+)
+
+// New creates the worker's orders client
+func New() *OrderServiceNatsClient {
+	return NewOrderServiceNatsClient(WithClientSubjectPrefix("worker"), WithClientJetStream(nil))
+}
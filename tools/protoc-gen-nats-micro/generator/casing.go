@@ -0,0 +1,152 @@
+package generator
+
+import (
+	"strings"
+	"unicode"
+)
+
+// legacyCasing makes ToSnakeCase and ToKebabCase split words as releases before
+// run detection did, for legacy_casing=true. Run sets it for each request.
+var legacyCasing bool
+
+// commonInitialisms are the initialisms splitWords separates when they run
+// together, as in "HTTPSURL". Mostly golint's list.
+var commonInitialisms = []string{
+	"ACL", "API", "ASCII", "CPU", "CSS", "DB", "DNS", "EOF", "GRPC", "GUID",
+	"HTML", "HTTP", "HTTPS", "ID", "IO", "IP", "JSON", "JWT", "KV", "LHS",
+	"NATS", "QPS", "RAM", "RHS", "RPC", "SDK", "SLA", "SMTP", "SQL", "SSH",
+	"TCP", "TLS", "TTL", "UDP", "UI", "UID", "URI", "URL", "UTF8", "UUID",
+	"VM", "XML", "XMPP", "XSRF", "XSS",
+}
+
+// ToSnakeCase converts CamelCase to snake_case, handling acronyms correctly.
+// e.g., "HTTPServer" -> "http_server", "getHTTPSURL" -> "get_https_url",
+// "MyAPIV2Service" -> "my_api_v2_service"
+func ToSnakeCase(s string) string {
+	if legacyCasing {
+		return legacySnakeCase(s)
+	}
+	return strings.ToLower(strings.Join(splitWords(s), "_"))
+}
+
+// ToKebabCase converts CamelCase to kebab-case, splitting words as ToSnakeCase does.
+// e.g., "GetHTTPSURL" -> "get-https-url"
+func ToKebabCase(s string) string {
+	if legacyCasing {
+		return legacyKebabCase(s)
+	}
+	return strings.ToLower(strings.Join(splitWords(s), "-"))
+}
+
+// splitWords splits a CamelCase name into its words. A word starts at an upper
+// case letter following a lower case letter or a digit, and before the last
+// letter of an upper case run followed by a lower case one ("HTTPServer" ->
+// HTTP, Server). Digits belong to the word before them ("HTTP2Server" -> HTTP2,
+// Server), a plural "s" to the acronym before it ("UserIDs" -> User, IDs), and
+// underscores only separate words. Upper case runs are split further into
+// commonInitialisms ("HTTPSURL" -> HTTPS, URL; "APIV2" -> API, V2).
+func splitWords(s string) []string {
+	var words []string
+	runes := []rune(s)
+	start := 0
+	flush := func(end int) {
+		if end > start {
+			words = append(words, splitInitialisms(string(runes[start:end]))...)
+		}
+	}
+	for i, r := range runes {
+		if r == '_' {
+			flush(i)
+			start = i + 1
+			continue
+		}
+		if i == start || !unicode.IsUpper(r) {
+			continue
+		}
+		prev := runes[i-1]
+		endsRun := unicode.IsUpper(prev) && i+1 < len(runes) && unicode.IsLower(runes[i+1]) && !isPluralS(runes, i+1)
+		if unicode.IsLower(prev) || unicode.IsDigit(prev) || endsRun {
+			flush(i)
+			start = i
+		}
+	}
+	flush(len(runes))
+	return words
+}
+
+// isPluralS reports whether runes[i] is a lower case "s" ending an upper case
+// run of two or more letters, as in "IDs" or "URLs"
+func isPluralS(runes []rune, i int) bool {
+	if runes[i] != 's' || i < 2 || !unicode.IsUpper(runes[i-1]) || !unicode.IsUpper(runes[i-2]) {
+		return false
+	}
+	return i+1 == len(runes) || !unicode.IsLower(runes[i+1])
+}
+
+// splitInitialisms splits an upper case word that starts with commonInitialisms,
+// longest first, leaving the rest as one word. An initialism followed by a digit
+// stays whole ("HTTP2"), a plural "s" stays with the last word ("URLIDs" -> URL,
+// IDs), and words with other lower case letters are not split.
+func splitInitialisms(word string) []string {
+	if plural, ok := strings.CutSuffix(word, "s"); ok && len(plural) > 1 && strings.ToUpper(plural) == plural {
+		words := splitInitialisms(plural)
+		words[len(words)-1] += "s"
+		return words
+	}
+	if strings.ToUpper(word) != word {
+		return []string{word}
+	}
+	var words []string
+	for {
+		n := 0
+		for _, initialism := range commonInitialisms {
+			if len(initialism) > n && len(initialism) < len(word) && strings.HasPrefix(word, initialism) && !unicode.IsDigit(rune(word[len(initialism)])) {
+				n = len(initialism)
+			}
+		}
+		if n == 0 {
+			return append(words, word)
+		}
+		words = append(words, word[:n])
+		word = word[n:]
+	}
+}
+
+// legacySnakeCase is ToSnakeCase before run detection. It keeps adjacent
+// acronyms and digits together, e.g., "MyAPIV2Service" -> "my_apiv2_service".
+func legacySnakeCase(s string) string {
+	var result strings.Builder
+	runes := []rune(s)
+	for i, r := range runes {
+		if i > 0 && r >= 'A' && r <= 'Z' {
+			prev := runes[i-1]
+			if prev >= 'a' && prev <= 'z' || prev >= '0' && prev <= '9' {
+				// "getH" -> "get_h", "V2O" -> "v2_o"
+				result.WriteByte('_')
+			} else if prev >= 'A' && prev <= 'Z' && i+1 < len(runes) && runes[i+1] >= 'a' && runes[i+1] <= 'z' {
+				// End of acronym before lowercase: "HTTPSe" -> "http_se"
+				result.WriteByte('_')
+			}
+		}
+		result.WriteRune(r)
+	}
+	return strings.ToLower(result.String())
+}
+
+// legacyKebabCase is ToKebabCase before run detection
+func legacyKebabCase(s string) string {
+	var result strings.Builder
+	runes := []rune(s)
+	for i, r := range runes {
+		if i > 0 && r >= 'A' && r <= 'Z' {
+			prev := runes[i-1]
+			if prev >= 'a' && prev <= 'z' {
+				result.WriteByte('-')
+			} else if i+1 < len(runes) && runes[i+1] >= 'a' && runes[i+1] <= 'z' {
+				result.WriteByte('-')
+			}
+		}
+		result.WriteRune(r)
+	}
+	return strings.ToLower(result.String())
+}
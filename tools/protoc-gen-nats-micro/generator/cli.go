@@ -0,0 +1,46 @@
+package generator
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"google.golang.org/protobuf/compiler/protogen"
+)
+
+// CLIFileSuffix is appended to the snake_case service name to name its cli=true file
+const CLIFileSuffix = "_cli.pb.go"
+
+// CLIName returns the command name of a service's CLI: its name without the
+// "Service" suffix, lowercased, plus "cli" (e.g., OrderService -> "ordercli")
+func CLIName(service *protogen.Service) string {
+	name := strings.TrimSuffix(service.GoName, "Service")
+	if name == "" {
+		name = service.GoName
+	}
+	return strings.ToLower(name) + "cli"
+}
+
+// GenerateCLIFiles generates the cli=true command-line client of every service in
+// file. Each is a main package of its own, in cmd/<name> under the directory of the
+// generated NATS code, so `go run ./<pkg>/cmd/ordercli` builds it.
+func GenerateCLIFiles(gen *protogen.Plugin, file *protogen.File, lang CLILanguage) error {
+	layout := newOutputLayout(file, lang.Params(), lang.FileExtension())
+	for _, service := range file.Services {
+		if GetServiceOptions(service).Skip {
+			continue
+		}
+		name := CLIName(service)
+		importPath := protogen.GoImportPath(path.Join(string(layout.GoImportPath), "cmd", name))
+		if err := lang.GenerateCLI(gen.NewGeneratedFile(cliFilename(file, service, lang), importPath), file, service); err != nil {
+			return fmt.Errorf("cli for %s: %w", service.Desc.FullName(), err)
+		}
+	}
+	return nil
+}
+
+// cliFilename returns the path of the cli=true client of service, in cmd/<name>
+// under the directory of the generated code
+func cliFilename(file *protogen.File, service *protogen.Service, lang Language) string {
+	return path.Join(outputDir(file, lang), "cmd", CLIName(service), ToSnakeCase(service.GoName)+CLIFileSuffix)
+}
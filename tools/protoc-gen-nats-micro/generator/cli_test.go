@@ -0,0 +1,82 @@
+package generator
+
+import (
+	"strings"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+
+	natspb "github.com/toyz/protoc-gen-nats-micro/tools/protoc-gen-nats-micro/nats/micro"
+)
+
+// generateCLI runs GenerateCLIFiles over set and returns the output files by name
+func generateCLI(t *testing.T, set *descriptorpb.FileDescriptorSet) map[string]string {
+	t.Helper()
+	gen := goPlugin(t, set)
+	lang := NewGoLanguage()
+	lang.SetParams(Params{Reproducible: true, CLI: true, EmptyShortcuts: true})
+	if err := GenerateCLIFiles(gen, gen.Files[len(gen.Files)-1], lang); err != nil {
+		t.Fatalf("GenerateCLIFiles: %v", err)
+	}
+	resp := gen.Response()
+	if resp.Error != nil {
+		t.Fatalf("generated code is not valid Go: %s", resp.GetError())
+	}
+	files := make(map[string]string)
+	for _, f := range resp.File {
+		files[f.GetName()] = f.GetContent()
+	}
+	return files
+}
+
+func TestGenerateCLI(t *testing.T) {
+	watch := lintMethod("WatchOrders", nil)
+	watch.ServerStreaming = proto.Bool(true)
+	upload := lintMethod("UploadOrders", nil)
+	upload.ClientStreaming = proto.Bool(true)
+	set := lintFixture(
+		lintService("OrderService", "api.orders",
+			lintMethod("CreateOrder", nil),
+			lintMethod("NotifyShipped", func(o *descriptorpb.MethodOptions) {
+				proto.SetExtension(o, natspb.E_Endpoint, &natspb.EndpointOptions{FireAndForget: true})
+			}),
+			lintMethod("Internal", func(o *descriptorpb.MethodOptions) {
+				proto.SetExtension(o, natspb.E_Endpoint, &natspb.EndpointOptions{Skip: true})
+			}),
+			watch, upload,
+		),
+		lintService("AuditService", "api.audit", lintMethod("Record", nil)),
+	)
+	set.File[0].Service[1].Options = &descriptorpb.ServiceOptions{}
+	proto.SetExtension(set.File[0].Service[1].Options, natspb.E_Service, &natspb.ServiceOptions{Skip: true})
+
+	files := generateCLI(t, set)
+	if len(files) != 1 {
+		t.Fatalf("generated %d files, want only OrderService's", len(files))
+	}
+	out, ok := files["example.com/fixture/v1/cmd/ordercli/order_service_cli.pb.go"]
+	if !ok {
+		t.Fatalf("no ordercli file in %v", files)
+	}
+	for _, want := range []string{
+		"package main",
+		`pb "example.com/fixture/v1"`,
+		`"create-order": {"CreateOrder", func(`,
+		`"notify-shipped": {"NotifyShipped", func(`,
+		`"watch-orders": {"WatchOrders (server stream)", func(`,
+		"return client.NotifyShipped(ctx, req)",
+		"if errors.Is(err, pb.ErrStreamEOF) {",
+		"client := pb.NewOrderServiceNatsClient(nc, opts...)",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("CLI missing %q", want)
+		}
+	}
+	// Skipped methods and client streams have no command
+	for _, unwanted := range []string{`"internal"`, `"upload-orders"`, "emptypb"} {
+		if strings.Contains(out, unwanted) {
+			t.Errorf("CLI contains %q", unwanted)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package generator
+
+import (
+	"strings"
+
+	natspb "github.com/toyz/protoc-gen-nats-micro/tools/protoc-gen-nats-micro/nats/micro"
+)
+
+// StatusCode is one value of the natsmicro.Code enum, with what every language
+// maps it to
+type StatusCode struct {
+	Name       string // Wire name, e.g., "NOT_FOUND"
+	Number     int32  // Enum number, which is also the gRPC code
+	GoName     string // Suffix of the Go Code constant, e.g., "NotFound" for CodeNotFound
+	HTTPStatus int    // HTTP status a gateway answers with
+	FromHTTP   bool   // The code an HTTP status maps back to, when several share it
+}
+
+// codeHTTPStatuses follows grpc-gateway's mapping of gRPC codes to HTTP statuses
+var codeHTTPStatuses = map[natspb.Code]int{
+	natspb.Code_OK:                  200,
+	natspb.Code_CANCELLED:           499,
+	natspb.Code_UNKNOWN:             500,
+	natspb.Code_INVALID_ARGUMENT:    400,
+	natspb.Code_DEADLINE_EXCEEDED:   504,
+	natspb.Code_NOT_FOUND:           404,
+	natspb.Code_ALREADY_EXISTS:      409,
+	natspb.Code_PERMISSION_DENIED:   403,
+	natspb.Code_RESOURCE_EXHAUSTED:  429,
+	natspb.Code_FAILED_PRECONDITION: 400,
+	natspb.Code_ABORTED:             409,
+	natspb.Code_OUT_OF_RANGE:        400,
+	natspb.Code_UNIMPLEMENTED:       501,
+	natspb.Code_INTERNAL:            500,
+	natspb.Code_UNAVAILABLE:         503,
+	natspb.Code_DATA_LOSS:           500,
+	natspb.Code_UNAUTHENTICATED:     401,
+}
+
+// codesFromHTTP picks the code for HTTP statuses several codes share
+var codesFromHTTP = map[int]natspb.Code{
+	400: natspb.Code_INVALID_ARGUMENT,
+	409: natspb.Code_ALREADY_EXISTS,
+	500: natspb.Code_INTERNAL,
+}
+
+// StatusCodes returns the natsmicro.Code values in number order. Templates render
+// every language's code table from it, so they agree on names and numbers.
+func StatusCodes() []StatusCode {
+	values := natspb.File_natsmicro_options_proto.Enums().ByName("Code").Values()
+	codes := make([]StatusCode, values.Len())
+	for i := range codes {
+		v := values.Get(i)
+		code := natspb.Code(v.Number())
+		status := codeHTTPStatuses[code]
+		fromHTTP, shared := codesFromHTTP[status]
+		codes[i] = StatusCode{
+			Name:       string(v.Name()),
+			Number:     int32(v.Number()),
+			GoName:     codeGoName(string(v.Name())),
+			HTTPStatus: status,
+			FromHTTP:   !shared || fromHTTP == code,
+		}
+	}
+	return codes
+}
+
+// codeGoName returns the Go constant suffix of a code name. The Go names predate
+// the enum: CANCELLED is CodeCanceled, as in gRPC's codes package.
+func codeGoName(name string) string {
+	switch name {
+	case "OK":
+		return "OK"
+	case "CANCELLED":
+		return "Canceled"
+	}
+	var b strings.Builder
+	for _, part := range strings.Split(name, "_") {
+		b.WriteString(part[:1] + strings.ToLower(part[1:]))
+	}
+	return b.String()
+}
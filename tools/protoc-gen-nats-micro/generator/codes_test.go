@@ -0,0 +1,92 @@
+package generator
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+
+	natspb "github.com/toyz/protoc-gen-nats-micro/tools/protoc-gen-nats-micro/nats/micro"
+)
+
+func TestStatusCodes(t *testing.T) {
+	codes := StatusCodes()
+	if len(codes) != len(natspb.Code_name) {
+		t.Fatalf("%d codes, want every natsmicro.Code value (%d)", len(codes), len(natspb.Code_name))
+	}
+	for i, c := range codes {
+		if c.Number != int32(i) || natspb.Code_name[c.Number] != c.Name {
+			t.Errorf("code %d is %s = %d", i, c.Name, c.Number)
+		}
+		if c.HTTPStatus == 0 {
+			t.Errorf("%s has no HTTP status", c.Name)
+		}
+	}
+
+	// The Go names predate the enum and must not change
+	for name, want := range map[string]string{"OK": "OK", "CANCELLED": "Canceled", "NOT_FOUND": "NotFound", "DEADLINE_EXCEEDED": "DeadlineExceeded"} {
+		if got := codeGoName(name); got != want {
+			t.Errorf("codeGoName(%s) = %s, want %s", name, got, want)
+		}
+	}
+
+	// Each HTTP status maps back to exactly one code
+	back := make(map[int]string)
+	for _, c := range codes {
+		if !c.FromHTTP {
+			continue
+		}
+		if prev, ok := back[c.HTTPStatus]; ok {
+			t.Errorf("HTTP %d maps back to both %s and %s", c.HTTPStatus, prev, c.Name)
+		}
+		back[c.HTTPStatus] = c.Name
+	}
+	for _, c := range codes {
+		if _, ok := back[c.HTTPStatus]; !ok {
+			t.Errorf("HTTP %d (%s) maps back to no code", c.HTTPStatus, c.Name)
+		}
+	}
+	if back[400] != "INVALID_ARGUMENT" || back[500] != "INTERNAL" || back[409] != "ALREADY_EXISTS" {
+		t.Errorf("shared HTTP statuses map back to %s, %s, %s", back[400], back[500], back[409])
+	}
+}
+
+// TestStatusCodesConformance checks that every language's shared file gives each
+// code the same number and HTTP status, and parses the name sent in headers
+func TestStatusCodesConformance(t *testing.T) {
+	for _, tt := range []struct {
+		language string
+		suffix   string
+		number   string // Pattern of a code's number, from %[1]s (name), %[2]s (Go name) and %[3]d (number)
+		status   string // Pattern of a code's HTTP status, from the same and %[4]d (status)
+		parse    string // How the language parses header code names
+	}{
+		{"go", "shared_nats.pb.go", `Code%[2]s\s+Code = %[3]d\n`, `Code%[2]s:\s+%[4]d,`, `func ParseCode(name string) Code {`},
+		{"python", "shared_nats_pb2.py", `    %[1]s = %[3]d\n`, `    Code\.%[1]s: %[4]d,`, `return Code[name] if name else Code.UNKNOWN`},
+		{"ts", "shared_nats.pb.ts", `  %[1]s = %[3]d,\n`, `  \[Code\.%[1]s\]: %[4]d,`, `export function parseCode(name: string | null | undefined): Code {`},
+		{"web-ts", "shared_nats.pb.ts", `  %[1]s = %[3]d,\n`, `  \[Code\.%[1]s\]: %[4]d,`, `export function parseCode(name: string | null | undefined): Code {`},
+		{"csharp", "shared_nats.pb.cs", `    %[2]s = %[3]d,\n`, `        Code\.%[2]s => %[4]d,`, `public static Code ParseCode(string? name) => name switch`},
+	} {
+		t.Run(tt.language, func(t *testing.T) {
+			_, out := runPlugin(t, "language="+tt.language, runFile("order/v1/order.proto", "order.v1", "example.com/api/gen/order/v1;orderv1"))
+			shared := out["order/v1/"+tt.suffix]
+			if tt.language == "go" {
+				shared = out["example.com/api/gen/order/v1/"+tt.suffix]
+			}
+			if shared == "" {
+				t.Fatalf("no shared file among %d outputs", len(out))
+			}
+			for _, c := range StatusCodes() {
+				for _, pattern := range []string{tt.number, tt.status} {
+					re := regexp.MustCompile(fmt.Sprintf(pattern, c.Name, c.GoName, c.Number, c.HTTPStatus))
+					if !re.MatchString(shared) {
+						t.Errorf("shared file does not match %s", re)
+					}
+				}
+			}
+			if !strings.Contains(shared, tt.parse) {
+				t.Errorf("shared file missing %q", tt.parse)
+			}
+		})
+	}
+}
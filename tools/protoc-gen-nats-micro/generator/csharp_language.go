@@ -0,0 +1,90 @@
+package generator
+
+import (
+	"fmt"
+	"strings"
+
+	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// CSharpLanguage implements Language for C# code generation on NATS.Net
+// (NATS.Client.Core and NATS.Client.Services) and Google.Protobuf messages
+type CSharpLanguage struct{ BaseLanguage }
+
+// NewCSharpLanguage creates a new C# language generator
+func NewCSharpLanguage() *CSharpLanguage {
+	return &CSharpLanguage{newBaseLanguage("csharp", "_nats.pb.cs", "templates/csharp/*.tmpl",
+		[]string{"header.cs.tmpl"},
+		[]string{"shared_header.cs.tmpl", "shared.cs.tmpl"},
+		[]string{"errors.cs.tmpl", "service.cs.tmpl", "client.cs.tmpl"},
+	)}
+}
+
+// CsNamespace returns the C# namespace protoc --csharp_out puts the messages of
+// file in: its csharp_namespace option, or its package in PascalCase, e.g.
+// "order_service.v1" -> "OrderService.V1". Generated files share it so message
+// types resolve without usings.
+func CsNamespace(file *protogen.File) string {
+	return csNamespace(file.Desc)
+}
+
+func csNamespace(file protoreflect.FileDescriptor) string {
+	if opts, ok := file.Options().(*descriptorpb.FileOptions); ok && opts.CsharpNamespace != nil {
+		return opts.GetCsharpNamespace()
+	}
+	return csPascalCase(string(file.Package()))
+}
+
+// CsMessageType returns the fully qualified C# type of msg as protoc --csharp_out
+// names it. Nested messages live in the Types class of their parent.
+func CsMessageType(msg *protogen.Message) string {
+	name := string(msg.Desc.Name())
+	for parent, ok := msg.Desc.Parent().(protoreflect.MessageDescriptor); ok; parent, ok = parent.Parent().(protoreflect.MessageDescriptor) {
+		name = string(parent.Name()) + ".Types." + name
+	}
+	if ns := csNamespace(msg.Desc.ParentFile()); ns != "" {
+		name = ns + "." + name
+	}
+	return "global::" + name
+}
+
+// SubjectExprCs returns a C# expression evaluating to the method's subject,
+// where prefixExpr is a C# expression holding the runtime subject prefix.
+// e.g., $"{SubjectPrefix}.create_product"
+func SubjectExprCs(method *protogen.Method, prefixExpr string) string {
+	if subject := GetEndpointOptions(method).ExactSubject(); subject != "" {
+		return fmt.Sprintf("%q", subject)
+	}
+	return fmt.Sprintf("$\"{%s}.%s\"", prefixExpr, EndpointName(method))
+}
+
+// csPascalCase follows protoc's UnderscoresToCamelCase for namespaces: letters
+// after an underscore, digit or period are capitalized, and periods are kept
+func csPascalCase(s string) string {
+	var b strings.Builder
+	capNext := true
+	for _, r := range s {
+		switch {
+		case r >= 'a' && r <= 'z':
+			if capNext {
+				r -= 'a' - 'A'
+			}
+			b.WriteRune(r)
+			capNext = false
+		case r >= 'A' && r <= 'Z':
+			b.WriteRune(r)
+			capNext = false
+		case r >= '0' && r <= '9':
+			b.WriteRune(r)
+			capNext = true
+		default:
+			if r == '.' {
+				b.WriteRune(r)
+			}
+			capNext = true
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,56 @@
+package generator
+
+import (
+	"strings"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+func TestCsPascalCase(t *testing.T) {
+	for _, tt := range []struct{ in, want string }{
+		{"order.v1", "Order.V1"},
+		{"order_service.v1beta1", "OrderService.V1Beta1"},
+		{"acme.API.v2", "Acme.API.V2"},
+		{"", ""},
+	} {
+		if got := csPascalCase(tt.in); got != tt.want {
+			t.Errorf("csPascalCase(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestGenerateCSharpTypes(t *testing.T) {
+	// Messages are named as protoc --csharp_out names them: in the file's
+	// namespace, with nested messages in their parent's Types class
+	set := lintFixture(lintService("OrderService", "api.orders", lintMethod("GetOrder", nil), lintMethod("GetLine", nil)))
+	file := set.File[0]
+	file.Options = &descriptorpb.FileOptions{GoPackage: proto.String("example.com/fixture/v1;fixturev1")}
+	file.MessageType[0].NestedType = []*descriptorpb.DescriptorProto{{Name: proto.String("Line")}}
+	file.Service[0].Method[1].InputType = proto.String(".fixture.v1.Req.Line")
+
+	_, out := runPlugin(t, "language=csharp", set.File...)
+	cs := out["fixture/v1/service_nats.pb.cs"]
+	for _, want := range []string{
+		"namespace Fixture.V1;",
+		"public virtual Task<global::Fixture.V1.Resp> GetOrderAsync(global::Fixture.V1.Req request, ServerInfo info)",
+		"public async Task<global::Fixture.V1.Resp> GetLineAsync(global::Fixture.V1.Req.Types.Line request,",
+		`var getOrderSubject = $"{subjectPrefix}.get_order";`,
+	} {
+		if !strings.Contains(cs, want) {
+			t.Errorf("C# output missing %q", want)
+		}
+	}
+
+	file.Options.CsharpNamespace = proto.String("Acme.Orders")
+	_, out = runPlugin(t, "language=csharp", set.File...)
+	for name, content := range out {
+		if !strings.Contains(content, "namespace Acme.Orders;") {
+			t.Errorf("%s does not use csharp_namespace", name)
+		}
+	}
+	if !strings.Contains(out["fixture/v1/service_nats.pb.cs"], "global::Acme.Orders.Req request") {
+		t.Error("C# output does not qualify messages with csharp_namespace")
+	}
+}
@@ -0,0 +1,259 @@
+package generator
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"path"
+	"strings"
+
+	"google.golang.org/protobuf/compiler/protogen"
+)
+
+// Metric and label names the generated dashboards query. Runtime metrics exporters
+// must use the same names, labelling every series with service and method as in
+// UnaryServerInfo (the Go names, e.g., "OrderService" and "GetOrder").
+const (
+	metricRequestsTotal   = "nats_micro_requests_total"           // Counter of handled requests
+	metricErrorsTotal     = "nats_micro_request_errors_total"     // Counter of failed requests, also labelled by code
+	metricRequestDuration = "nats_micro_request_duration_seconds" // Histogram of handler latency
+	metricInFlight        = "nats_micro_requests_in_flight"       // Gauge of requests being handled
+	metricStreamsActive   = "nats_micro_streams_active"           // Gauge of open streams
+
+	labelService = "service"
+	labelMethod  = "method"
+	labelCode    = "code"
+)
+
+// grafanaSchemaVersion is the dashboard JSON model version the output targets
+const grafanaSchemaVersion = 39
+
+// DashboardFileExtension is appended to the per-service output name, e.g., "order/v1/order_order_service.grafana.json"
+const DashboardFileExtension = ".grafana.json"
+
+type grafanaDashboard struct {
+	UID           string           `json:"uid"`
+	Title         string           `json:"title"`
+	Description   string           `json:"description"`
+	Tags          []string         `json:"tags"`
+	Editable      bool             `json:"editable"`
+	Refresh       string           `json:"refresh"`
+	SchemaVersion int              `json:"schemaVersion"`
+	Time          grafanaTimeRange `json:"time"`
+	Templating    grafanaTemplates `json:"templating"`
+	Panels        []grafanaPanel   `json:"panels"`
+}
+
+type grafanaTimeRange struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+type grafanaTemplates struct {
+	List []grafanaVariable `json:"list"`
+}
+
+type grafanaVariable struct {
+	Name       string          `json:"name"`
+	Label      string          `json:"label"`
+	Type       string          `json:"type"`
+	Query      string          `json:"query"`
+	Multi      bool            `json:"multi,omitempty"`
+	IncludeAll bool            `json:"includeAll,omitempty"`
+	Current    *grafanaOption  `json:"current,omitempty"`
+	Options    []grafanaOption `json:"options,omitempty"`
+}
+
+type grafanaOption struct {
+	Text     string `json:"text"`
+	Value    string `json:"value"`
+	Selected bool   `json:"selected"`
+}
+
+type grafanaPanel struct {
+	ID          int                `json:"id"`
+	Type        string             `json:"type"`
+	Title       string             `json:"title"`
+	Description string             `json:"description"`
+	GridPos     grafanaGridPos     `json:"gridPos"`
+	Datasource  grafanaDatasource  `json:"datasource"`
+	FieldConfig grafanaFieldConfig `json:"fieldConfig"`
+	Targets     []grafanaTarget    `json:"targets"`
+}
+
+type grafanaGridPos struct {
+	H int `json:"h"`
+	W int `json:"w"`
+	X int `json:"x"`
+	Y int `json:"y"`
+}
+
+type grafanaDatasource struct {
+	Type string `json:"type"`
+	UID  string `json:"uid"`
+}
+
+type grafanaFieldConfig struct {
+	Defaults  grafanaFieldDefaults `json:"defaults"`
+	Overrides []any                `json:"overrides"`
+}
+
+type grafanaFieldDefaults struct {
+	Unit string `json:"unit,omitempty"`
+}
+
+type grafanaTarget struct {
+	RefID        string            `json:"refId"`
+	Datasource   grafanaDatasource `json:"datasource"`
+	Expr         string            `json:"expr"`
+	LegendFormat string            `json:"legendFormat"`
+	Format       string            `json:"format,omitempty"`
+}
+
+// GenerateDashboards writes a Grafana dashboard per generated service in file (dashboards=grafana).
+// Dashboards are placed next to lang's output for the file.
+func GenerateDashboards(gen *protogen.Plugin, file *protogen.File, lang Language) error {
+	for _, service := range file.Services {
+		if GetServiceOptions(service).Skip {
+			continue
+		}
+		content, err := grafanaDashboardJSON(file, service)
+		if err != nil {
+			return fmt.Errorf("dashboard for %s: %w", service.Desc.FullName(), err)
+		}
+		gen.NewGeneratedFile(dashboardFilename(file, service, lang), "").P(string(content))
+	}
+	return nil
+}
+
+// dashboardFilename returns the path of the dashboard of service, next to lang's output for file
+func dashboardFilename(file *protogen.File, service *protogen.Service, lang Language) string {
+	return path.Join(outputDir(file, lang), path.Base(outputFilenamePrefix(file, lang))+"_"+ToSnakeCase(service.GoName)+DashboardFileExtension)
+}
+
+// grafanaDashboardJSON renders the dashboard for service, with one "method" variable
+// option per generated method
+func grafanaDashboardJSON(file *protogen.File, service *protogen.Service) ([]byte, error) {
+	var methods []string
+	streaming := false
+	for _, method := range service.Methods {
+		if GetEndpointOptions(method).Skip {
+			continue
+		}
+		methods = append(methods, method.GoName)
+		if !IsUnary(method) {
+			streaming = true
+		}
+	}
+
+	sel := fmt.Sprintf(`%s="%s",%s=~"$%s"`, labelService, service.GoName, labelMethod, labelMethod)
+	byMethod := "sum by (" + labelMethod + ") "
+	panels := []grafanaPanel{
+		{
+			Type:        "timeseries",
+			Title:       "Request rate",
+			Description: "Requests handled per second",
+			FieldConfig: grafanaFieldConfig{Defaults: grafanaFieldDefaults{Unit: "reqps"}},
+			Targets: []grafanaTarget{{
+				Expr:         byMethod + "(rate(" + metricRequestsTotal + "{" + sel + "}[$__rate_interval]))",
+				LegendFormat: "{{" + labelMethod + "}}",
+			}},
+		},
+		{
+			Type:        "timeseries",
+			Title:       "Error rate",
+			Description: "Share of requests that returned an error",
+			FieldConfig: grafanaFieldConfig{Defaults: grafanaFieldDefaults{Unit: "percentunit"}},
+			Targets: []grafanaTarget{{
+				Expr: byMethod + "(rate(" + metricErrorsTotal + "{" + sel + "}[$__rate_interval])) / " +
+					byMethod + "(rate(" + metricRequestsTotal + "{" + sel + "}[$__rate_interval]))",
+				LegendFormat: "{{" + labelMethod + "}}",
+			}},
+		},
+		{
+			Type:        "heatmap",
+			Title:       "Latency",
+			Description: "Handler latency distribution",
+			FieldConfig: grafanaFieldConfig{Defaults: grafanaFieldDefaults{Unit: "s"}},
+			Targets: []grafanaTarget{{
+				Expr:         "sum by (le) (rate(" + metricRequestDuration + "_bucket{" + sel + "}[$__rate_interval]))",
+				LegendFormat: "{{le}}",
+				Format:       "heatmap",
+			}},
+		},
+		{
+			Type:        "timeseries",
+			Title:       "In-flight requests",
+			Description: "Requests being handled",
+			FieldConfig: grafanaFieldConfig{Defaults: grafanaFieldDefaults{Unit: "short"}},
+			Targets: []grafanaTarget{{
+				Expr:         byMethod + "(" + metricInFlight + "{" + sel + "})",
+				LegendFormat: "{{" + labelMethod + "}}",
+			}},
+		},
+	}
+	if streaming {
+		panels = append(panels, grafanaPanel{
+			Type:        "timeseries",
+			Title:       "Open streams",
+			Description: "Streams currently open",
+			FieldConfig: grafanaFieldConfig{Defaults: grafanaFieldDefaults{Unit: "short"}},
+			Targets: []grafanaTarget{{
+				Expr:         byMethod + "(" + metricStreamsActive + "{" + sel + "})",
+				LegendFormat: "{{" + labelMethod + "}}",
+			}},
+		})
+	}
+
+	// Two panels per row, each half the 24-column grid
+	datasource := grafanaDatasource{Type: "prometheus", UID: "${datasource}"}
+	for i := range panels {
+		panels[i].ID = i + 1
+		panels[i].GridPos = grafanaGridPos{H: 8, W: 12, X: (i % 2) * 12, Y: (i / 2) * 8}
+		panels[i].Datasource = datasource
+		panels[i].FieldConfig.Overrides = []any{}
+		for j := range panels[i].Targets {
+			panels[i].Targets[j].RefID = string(rune('A' + j))
+			panels[i].Targets[j].Datasource = datasource
+		}
+	}
+
+	methodVar := grafanaVariable{
+		Name:       labelMethod,
+		Label:      "Method",
+		Type:       "custom",
+		Query:      strings.Join(methods, ","),
+		Multi:      true,
+		IncludeAll: true,
+		Current:    &grafanaOption{Text: "All", Value: "$__all", Selected: true},
+		Options:    []grafanaOption{{Text: "All", Value: "$__all", Selected: true}},
+	}
+	for _, m := range methods {
+		methodVar.Options = append(methodVar.Options, grafanaOption{Text: m, Value: m})
+	}
+
+	dashboard := grafanaDashboard{
+		UID:           dashboardUID(string(service.Desc.FullName())),
+		Title:         fmt.Sprintf("%s (%s)", service.GoName, file.Desc.Package()),
+		Description:   fmt.Sprintf("%s - generated by protoc-gen-nats-micro from %s", service.Desc.FullName(), SourcePath(file.Desc.Path())),
+		Tags:          []string{"nats-micro", string(file.Desc.Package())},
+		Editable:      true,
+		Refresh:       "30s",
+		SchemaVersion: grafanaSchemaVersion,
+		Time:          grafanaTimeRange{From: "now-1h", To: "now"},
+		Templating: grafanaTemplates{List: []grafanaVariable{
+			{Name: "datasource", Label: "Data source", Type: "datasource", Query: "prometheus"},
+			methodVar,
+		}},
+		Panels: panels,
+	}
+	return json.MarshalIndent(dashboard, "", "  ")
+}
+
+// dashboardUID derives a stable UID (Grafana allows at most 40 characters) from the
+// service's full name, so regenerating and re-importing updates the same dashboard
+func dashboardUID(fullName string) string {
+	h := fnv.New64a()
+	h.Write([]byte(fullName))
+	return fmt.Sprintf("nats-micro-%016x", h.Sum64())
+}
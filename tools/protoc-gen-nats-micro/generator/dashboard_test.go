@@ -0,0 +1,228 @@
+package generator
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/pluginpb"
+
+	natspb "github.com/toyz/protoc-gen-nats-micro/tools/protoc-gen-nats-micro/nats/micro"
+)
+
+// generateDashboards runs GenerateDashboards over set and returns the output files by name
+func generateDashboards(t *testing.T, set *descriptorpb.FileDescriptorSet, lang Language) map[string]string {
+	t.Helper()
+	for _, f := range set.File {
+		f.Options = &descriptorpb.FileOptions{GoPackage: proto.String("example.com/fixture/v1;fixturev1")}
+	}
+	gen, err := protogen.Options{}.New(&pluginpb.CodeGeneratorRequest{
+		FileToGenerate: []string{set.File[0].GetName()},
+		ProtoFile:      set.File,
+	})
+	if err != nil {
+		t.Fatalf("protogen: %v", err)
+	}
+	if err := GenerateDashboards(gen, gen.Files[0], lang); err != nil {
+		t.Fatalf("GenerateDashboards: %v", err)
+	}
+	resp := gen.Response()
+	if resp.Error != nil {
+		t.Fatalf("response error: %s", resp.GetError())
+	}
+	files := make(map[string]string)
+	for _, f := range resp.File {
+		files[f.GetName()] = f.GetContent()
+	}
+	return files
+}
+
+func TestGenerateDashboards(t *testing.T) {
+	watch := lintMethod("WatchOrders", nil)
+	watch.ServerStreaming = proto.Bool(true)
+	skipped := lintService("HiddenService", "api.hidden", lintMethod("Blob", nil))
+	skipped.Options = &descriptorpb.ServiceOptions{}
+	proto.SetExtension(skipped.Options, natspb.E_Service, &natspb.ServiceOptions{Skip: true})
+	set := lintFixture(
+		lintService("OrderService", "api.orders",
+			lintMethod("GetOrder", nil),
+			lintMethod("Internal", func(o *descriptorpb.MethodOptions) {
+				proto.SetExtension(o, natspb.E_Endpoint, &natspb.EndpointOptions{Skip: true})
+			}),
+			watch,
+		),
+		lintService("PingService", "", lintMethod("Ping", nil)),
+		skipped,
+	)
+
+	files := generateDashboards(t, set, NewGoLanguage())
+	if len(files) != 2 {
+		t.Fatalf("got files %v, want one per generated service", keys(files))
+	}
+	orders, ok := files["example.com/fixture/v1/service_order_service.grafana.json"]
+	if !ok {
+		t.Fatalf("missing OrderService dashboard in %v", keys(files))
+	}
+	if _, ok := generateDashboards(t, lintFixture(lintService("PingService", "", lintMethod("Ping", nil))), NewTypeScriptLanguage())["fixture/v1/service_ping_service.grafana.json"]; !ok {
+		t.Error("TypeScript output is not named after the proto path")
+	}
+
+	schema := loadSchema(t, "testdata/grafana_dashboard.schema.json")
+	for name, content := range files {
+		var doc any
+		if err := json.Unmarshal([]byte(content), &doc); err != nil {
+			t.Fatalf("%s is not JSON: %v", name, err)
+		}
+		if err := validateSchema(schema, doc, "$"); err != nil {
+			t.Errorf("%s does not match the schema: %v", name, err)
+		}
+	}
+
+	var dashboard grafanaDashboard
+	if err := json.Unmarshal([]byte(orders), &dashboard); err != nil {
+		t.Fatal(err)
+	}
+	method := dashboard.Templating.List[1]
+	if method.Name != "method" || method.Query != "GetOrder,WatchOrders" {
+		t.Errorf("method variable = %+v, want the generated method names", method)
+	}
+	var titles []string
+	for _, p := range dashboard.Panels {
+		titles = append(titles, p.Title)
+	}
+	if got := strings.Join(titles, ","); got != "Request rate,Error rate,Latency,In-flight requests,Open streams" {
+		t.Errorf("panels = %s", got)
+	}
+	for _, want := range []string{
+		`nats_micro_requests_total{service=\"OrderService\",method=~\"$method\"}`,
+		`nats_micro_request_errors_total{service=\"OrderService\"`,
+		`nats_micro_request_duration_seconds_bucket{service=\"OrderService\"`,
+		`nats_micro_requests_in_flight{service=\"OrderService\"`,
+		`nats_micro_streams_active{service=\"OrderService\"`,
+	} {
+		if !strings.Contains(orders, want) {
+			t.Errorf("OrderService dashboard missing %s", want)
+		}
+	}
+
+	ping := files["example.com/fixture/v1/service_ping_service.grafana.json"]
+	if strings.Contains(ping, "nats_micro_streams_active") {
+		t.Error("unary-only service has a streams panel")
+	}
+	if again := generateDashboards(t, set, NewGoLanguage()); again["example.com/fixture/v1/service_order_service.grafana.json"] != orders {
+		t.Error("dashboard output is not deterministic")
+	}
+}
+
+func keys(m map[string]string) []string {
+	var out []string
+	for k := range m {
+		out = append(out, k)
+	}
+	return out
+}
+
+func loadSchema(t *testing.T, path string) map[string]any {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var schema map[string]any
+	if err := json.Unmarshal(data, &schema); err != nil {
+		t.Fatalf("%s: %v", path, err)
+	}
+	return schema
+}
+
+// validateSchema checks doc against the JSON Schema keywords the dashboard schema uses:
+// type, enum, required, properties, items, minItems, minLength, maxLength, minimum and maximum
+func validateSchema(schema map[string]any, doc any, path string) error {
+	if want, ok := schema["type"].(string); ok && !schemaTypeMatches(want, doc) {
+		return fmt.Errorf("%s: got %T, want %s", path, doc, want)
+	}
+	if enum, ok := schema["enum"].([]any); ok {
+		found := false
+		for _, v := range enum {
+			if v == doc {
+				found = true
+			}
+		}
+		if !found {
+			return fmt.Errorf("%s: %v is not one of %v", path, doc, enum)
+		}
+	}
+	switch v := doc.(type) {
+	case map[string]any:
+		if required, ok := schema["required"].([]any); ok {
+			for _, name := range required {
+				if _, ok := v[name.(string)]; !ok {
+					return fmt.Errorf("%s: missing %s", path, name)
+				}
+			}
+		}
+		props, _ := schema["properties"].(map[string]any)
+		for name, sub := range props {
+			if value, ok := v[name]; ok {
+				if err := validateSchema(sub.(map[string]any), value, path+"."+name); err != nil {
+					return err
+				}
+			}
+		}
+	case []any:
+		if min, ok := schema["minItems"].(float64); ok && float64(len(v)) < min {
+			return fmt.Errorf("%s: %d items, want at least %v", path, len(v), min)
+		}
+		if items, ok := schema["items"].(map[string]any); ok {
+			for i, item := range v {
+				if err := validateSchema(items, item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+					return err
+				}
+			}
+		}
+	case string:
+		if min, ok := schema["minLength"].(float64); ok && float64(len(v)) < min {
+			return fmt.Errorf("%s: %q is shorter than %v", path, v, min)
+		}
+		if max, ok := schema["maxLength"].(float64); ok && float64(len(v)) > max {
+			return fmt.Errorf("%s: %q is longer than %v", path, v, max)
+		}
+	case float64:
+		if min, ok := schema["minimum"].(float64); ok && v < min {
+			return fmt.Errorf("%s: %v is below %v", path, v, min)
+		}
+		if max, ok := schema["maximum"].(float64); ok && v > max {
+			return fmt.Errorf("%s: %v is above %v", path, v, max)
+		}
+	}
+	return nil
+}
+
+func schemaTypeMatches(want string, doc any) bool {
+	switch want {
+	case "object":
+		_, ok := doc.(map[string]any)
+		return ok
+	case "array":
+		_, ok := doc.([]any)
+		return ok
+	case "string":
+		_, ok := doc.(string)
+		return ok
+	case "boolean":
+		_, ok := doc.(bool)
+		return ok
+	case "integer":
+		f, ok := doc.(float64)
+		return ok && f == float64(int64(f))
+	case "number":
+		_, ok := doc.(float64)
+		return ok
+	}
+	return false
+}
@@ -0,0 +1,55 @@
+package generator
+
+import "google.golang.org/protobuf/compiler/protogen"
+
+// IsEmptyMessage reports whether msg is google.protobuf.Empty
+func IsEmptyMessage(msg *protogen.Message) bool {
+	return msg.Desc.FullName() == emptyMessageName
+}
+
+// GoMessageType returns the Go type of msg as written in generated Go code.
+// google.protobuf.Empty lives in emptypb, which the Go headers import when it is used.
+func GoMessageType(msg *protogen.Message) string {
+	return goMessageType(msg, func(ident protogen.GoIdent) string { return ident.GoName })
+}
+
+// goMessageType is GoMessageType with the message's identifier written by qualify
+func goMessageType(msg *protogen.Message, qualify func(protogen.GoIdent) string) string {
+	if IsEmptyMessage(msg) {
+		return "emptypb.Empty"
+	}
+	return qualify(msg.GoIdent)
+}
+
+// PyMessageType returns the Python type of msg as written in generated Python code.
+// google.protobuf.Empty comes from empty_pb2, which the Python header always imports.
+func PyMessageType(msg *protogen.Message) string {
+	if IsEmptyMessage(msg) {
+		return "empty_pb2.Empty"
+	}
+	return "pb." + msg.GoIdent.GoName
+}
+
+// EmptyShortcut reports which sides of a method leave google.protobuf.Empty out of
+// the generated signatures (empty_shortcuts, on by default)
+type EmptyShortcut struct {
+	In  bool // The request parameter is dropped; the wrapper sends or decodes an empty message
+	Out bool // Only an error is returned; the wrapper sends or ignores an empty response
+}
+
+// EmptyShortcuts returns the shortcuts applied to method. Only unary methods without
+// KV or Object Store persistence qualify, since those need a message to key or store.
+// Fire-and-forget methods never return a message, so Out is always false for them.
+func EmptyShortcuts(method *protogen.Method, params Params) EmptyShortcut {
+	if !params.EmptyShortcuts || !IsUnary(method) {
+		return EmptyShortcut{}
+	}
+	eopts := GetEndpointOptions(method)
+	if eopts.KVStore != nil || eopts.ObjectStore != nil {
+		return EmptyShortcut{}
+	}
+	return EmptyShortcut{
+		In:  IsEmptyMessage(method.Input),
+		Out: IsEmptyMessage(method.Output) && !eopts.FireAndForget,
+	}
+}
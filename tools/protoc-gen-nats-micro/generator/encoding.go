@@ -0,0 +1,34 @@
+package generator
+
+import (
+	"fmt"
+
+	"google.golang.org/protobuf/compiler/protogen"
+)
+
+// Values of (natsmicro.endpoint).encoding
+const (
+	EncodingJSON   = "json"
+	EncodingBinary = "binary"
+)
+
+// validateEncoding checks an (natsmicro.endpoint).encoding value; "" keeps the service default
+func validateEncoding(encoding string) error {
+	switch encoding {
+	case "", EncodingJSON, EncodingBinary:
+		return nil
+	}
+	return fmt.Errorf("unknown encoding %q (want %q or %q)", encoding, EncodingJSON, EncodingBinary)
+}
+
+// MethodUseJSON reports whether method's messages are encoded as JSON: its
+// (natsmicro.endpoint).encoding if set, otherwise the service's json option
+func MethodUseJSON(method *protogen.Method, svcOpts ServiceOptions) bool {
+	switch GetEndpointOptions(method).Encoding {
+	case EncodingJSON:
+		return true
+	case EncodingBinary:
+		return false
+	}
+	return svcOpts.UseJSON
+}
@@ -0,0 +1,158 @@
+package generator
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+var contextKeyRe = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]*$`)
+
+// validateEnrich checks a (natsmicro.enrich) option against its method: all fields
+// are set, the key template matches the request, and the type resolves to a message
+// in the service's own proto package.
+func validateEnrich(method protoreflect.MethodDescriptor, enrich *EnrichOpts) error {
+	switch {
+	case method.IsStreamingClient() || method.IsStreamingServer():
+		return fmt.Errorf("(natsmicro.enrich) only applies to unary methods")
+	case enrich.Bucket == "":
+		return fmt.Errorf("(natsmicro.enrich) has no bucket")
+	case enrich.KeyTemplate == "":
+		return fmt.Errorf("(natsmicro.enrich) has no key_template")
+	case !contextKeyRe.MatchString(enrich.ContextKey):
+		return fmt.Errorf("(natsmicro.enrich) context_key %q must be a letter followed by letters, digits or underscores", enrich.ContextKey)
+	case enrich.Type == "":
+		return fmt.Errorf("(natsmicro.enrich) has no type")
+	}
+	if err := validateKeyTemplate(enrich.KeyTemplate, method.Input(), string(method.Input().Name())); err != nil {
+		return err
+	}
+
+	msg := findMessage(method.ParentFile(), protoreflect.FullName(enrich.Type))
+	if msg == nil {
+		return fmt.Errorf("(natsmicro.enrich) type %q is not defined in %s or its imports", enrich.Type, method.ParentFile().Path())
+	}
+	if pkg := method.ParentFile().Package(); msg.ParentFile().Package() != pkg {
+		return fmt.Errorf("(natsmicro.enrich) type %q must be in package %s", enrich.Type, pkg)
+	}
+	return nil
+}
+
+// EnrichAccessors returns one enrichment option per distinct context_key used by
+// the file's generated services, in declaration order. Each entry produces a
+// <Accessor>FromContext function in the generated Go code.
+func EnrichAccessors(file *protogen.File) []*EnrichOpts {
+	accessors, _ := enrichAccessors(file)
+	return accessors
+}
+
+// enrichAccessors collects enrichment options per context_key and reports a
+// context_key that is bound to two different message types.
+func enrichAccessors(file *protogen.File) ([]*EnrichOpts, error) {
+	var accessors []*EnrichOpts
+	seen := make(map[string]*EnrichOpts)
+	for _, service := range file.Services {
+		if GetServiceOptions(service).Skip {
+			continue
+		}
+		for _, method := range service.Methods {
+			eopts := GetEndpointOptions(method)
+			if eopts.Skip || eopts.Enrich == nil {
+				continue
+			}
+			enrich := eopts.Enrich
+			if prev, ok := seen[enrich.ContextKey]; ok {
+				if prev.Type != enrich.Type {
+					return nil, fmt.Errorf("%s: (natsmicro.enrich) context_key %q is already bound to %s", method.Desc.FullName(), enrich.ContextKey, prev.Type)
+				}
+				continue
+			}
+			seen[enrich.ContextKey] = enrich
+			accessors = append(accessors, enrich)
+		}
+	}
+	return accessors, nil
+}
+
+// findMessage looks up a message by full name in file and its transitive imports.
+func findMessage(file protoreflect.FileDescriptor, name protoreflect.FullName) protoreflect.MessageDescriptor {
+	visited := make(map[string]bool)
+	var search func(fd protoreflect.FileDescriptor) protoreflect.MessageDescriptor
+	search = func(fd protoreflect.FileDescriptor) protoreflect.MessageDescriptor {
+		if visited[fd.Path()] {
+			return nil
+		}
+		visited[fd.Path()] = true
+		if msg := findNestedMessage(fd.Messages(), name); msg != nil {
+			return msg
+		}
+		imports := fd.Imports()
+		for i := 0; i < imports.Len(); i++ {
+			if msg := search(imports.Get(i).FileDescriptor); msg != nil {
+				return msg
+			}
+		}
+		return nil
+	}
+	return search(file)
+}
+
+func findNestedMessage(msgs protoreflect.MessageDescriptors, name protoreflect.FullName) protoreflect.MessageDescriptor {
+	for i := 0; i < msgs.Len(); i++ {
+		msg := msgs.Get(i)
+		if msg.FullName() == name {
+			return msg
+		}
+		if nested := findNestedMessage(msg.Messages(), name); nested != nil {
+			return nested
+		}
+	}
+	return nil
+}
+
+// goMessageName returns the Go type name protoc-gen-go generates for a message,
+// e.g., "kvstore_demo.v1.Outer.Inner" -> "Outer_Inner".
+func goMessageName(msg protoreflect.MessageDescriptor) string {
+	name := strings.TrimPrefix(string(msg.FullName()), string(msg.ParentFile().Package())+".")
+	return goCamelCase(name)
+}
+
+// goCamelCase mirrors protoc-gen-go's identifier conversion so enrichment types
+// resolve to the same Go names as the generated messages.
+func goCamelCase(s string) string {
+	var b []byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '.' && i+1 < len(s) && isASCIILower(s[i+1]):
+			// Skip over '.' in ".{{lowercase}}".
+		case c == '.':
+			b = append(b, '_')
+		case c == '_' && (i == 0 || s[i-1] == '.'):
+			// Convert initial '_' to ensure we start with a capital letter.
+			b = append(b, 'X')
+		case c == '_' && i+1 < len(s) && isASCIILower(s[i+1]):
+			// Skip over '_' in "_{{lowercase}}".
+		case isASCIIDigit(c):
+			b = append(b, c)
+		default:
+			// Assume we have a letter now - if not, it's a bogus identifier.
+			if isASCIILower(c) {
+				c -= 'a' - 'A'
+			}
+			b = append(b, c)
+			// Accept lower case sequence that follows.
+			for ; i+1 < len(s) && isASCIILower(s[i+1]); i++ {
+				b = append(b, s[i+1])
+			}
+		}
+	}
+	return string(b)
+}
+
+func isASCIILower(c byte) bool { return 'a' <= c && c <= 'z' }
+
+func isASCIIDigit(c byte) bool { return '0' <= c && c <= '9' }
@@ -0,0 +1,26 @@
+package generator
+
+import "testing"
+
+func TestGoCamelCase(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"ProfileResponse", "ProfileResponse"},
+		{"user_profile", "UserProfile"},
+		{"Outer.Inner", "Outer_Inner"},
+		{"Outer.inner_item", "OuterInnerItem"},
+		{"Ledger.Entry_item", "Ledger_EntryItem"},
+		{"_private", "XPrivate"},
+		{"Item2go", "Item2Go"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			if got := goCamelCase(tt.input); got != tt.want {
+				t.Errorf("goCamelCase(%q) = %q, want %q", tt.input, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package generator
+
+import (
+	"fmt"
+
+	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// Events returns the messages of file with (natsmicro.event) options, nested ones
+// included, in declaration order. Each gets a Publish<Message> and a
+// Subscribe<Message> function in the generated Go code.
+func Events(file *protogen.File) []*protogen.Message {
+	var events []*protogen.Message
+	for _, msg := range RandomMessages(file) {
+		if _, ok := GetEventOptions(msg); ok {
+			events = append(events, msg)
+		}
+	}
+	return events
+}
+
+// validateEvents checks the (natsmicro.event) options of file's messages: a
+// subject without wildcards that no other event of the file uses, and a known
+// encoding.
+func validateEvents(file *protogen.File) error {
+	subjects := make(map[string]protoreflect.FullName)
+	for _, msg := range Events(file) {
+		opts, _ := GetEventOptions(msg)
+		if err := ValidateSubject(opts.Subject); err != nil {
+			return fmt.Errorf("%s: invalid (natsmicro.event).subject: %w", msg.Desc.FullName(), err)
+		}
+		if other, ok := subjects[opts.Subject]; ok {
+			return fmt.Errorf("%s: (natsmicro.event).subject %q is also the subject of %s; subscribers could not tell the events apart", msg.Desc.FullName(), opts.Subject, other)
+		}
+		subjects[opts.Subject] = msg.Desc.FullName()
+		if err := validateEncoding(opts.Encoding); err != nil {
+			return fmt.Errorf("%s: (natsmicro.event).%w", msg.Desc.FullName(), err)
+		}
+	}
+	return nil
+}
+
+// EventSubject returns the subject an event is published on
+func EventSubject(msg *protogen.Message) string {
+	opts, _ := GetEventOptions(msg)
+	return opts.Subject
+}
+
+// EventUseJSON reports whether an event is published as JSON
+func EventUseJSON(msg *protogen.Message) bool {
+	opts, _ := GetEventOptions(msg)
+	return opts.Encoding == EncodingJSON
+}
+
+// EventSensitiveFields returns the full names of the (natsmicro.field).sensitive
+// fields of file's events and the messages they contain, sorted
+func EventSensitiveFields(file *protogen.File) []string {
+	var roots []protoreflect.MessageDescriptor
+	for _, msg := range Events(file) {
+		roots = append(roots, msg.Desc)
+	}
+	return sensitiveFields(roots)
+}
+
+// hasGeneratedCode reports whether file generates code: it declares services or
+// events
+func hasGeneratedCode(file *protogen.File) bool {
+	return len(file.Services) > 0 || len(Events(file)) > 0
+}
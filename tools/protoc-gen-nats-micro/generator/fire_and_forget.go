@@ -0,0 +1,25 @@
+package generator
+
+import (
+	"fmt"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// emptyMessageName is the well-known empty message accepted as a fire-and-forget output
+const emptyMessageName protoreflect.FullName = "google.protobuf.Empty"
+
+// validateFireAndForget checks that a (natsmicro.endpoint).fire_and_forget method is
+// unary, returns an empty message, and does not persist a response it never gets.
+func validateFireAndForget(method protoreflect.MethodDescriptor, eopts EndpointOptions) error {
+	if method.IsStreamingClient() || method.IsStreamingServer() {
+		return fmt.Errorf("fire_and_forget only applies to unary methods")
+	}
+	if output := method.Output(); output.FullName() != emptyMessageName && output.Fields().Len() > 0 {
+		return fmt.Errorf("fire_and_forget requires output type google.protobuf.Empty or a message without fields, got %s", output.FullName())
+	}
+	if eopts.KVStore != nil || eopts.ObjectStore != nil {
+		return fmt.Errorf("fire_and_forget cannot be combined with (natsmicro.kv_store) or (natsmicro.object_store)")
+	}
+	return nil
+}
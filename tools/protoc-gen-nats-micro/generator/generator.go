@@ -2,32 +2,147 @@ package generator
 
 import (
 	"fmt"
+	"os"
+	"path"
 	"strings"
 
 	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/reflect/protoreflect"
 )
 
 // GenerateFile generates NATS microservice code for a protobuf file.
 // The Language must be resolved by the caller (main.go).
 func GenerateFile(gen *protogen.Plugin, file *protogen.File, lang Language) error {
-	if len(file.Services) == 0 {
+	if !hasGeneratedCode(file) {
 		return nil
 	}
 
+	// Events need no service, so a file may declare nothing else
+	if err := validateEvents(file); err != nil {
+		return err
+	}
+	if events := Events(file); len(events) > 0 && lang.Name() != "go" {
+		return fmt.Errorf("%s: (natsmicro.event) is not supported for language %s", events[0].Desc.FullName(), lang.Name())
+	}
+
+	// Validate per-method options before emitting anything
+	buckets, objBuckets := make(kvBuckets), make(objectBuckets)
+	for _, service := range file.Services {
+		if queueGroup := GetServiceOptions(service).QueueGroup; queueGroup != "" {
+			if err := ValidateSubject(queueGroup); err != nil {
+				return fmt.Errorf("%s: invalid (natsmicro.service).queue_group: %w", service.Desc.FullName(), err)
+			}
+		}
+		for _, method := range service.Methods {
+			eopts := GetEndpointOptions(method)
+			if eopts.Subject != "" {
+				if err := ValidateSubject(eopts.Subject); err != nil {
+					return fmt.Errorf("%s: invalid (natsmicro.endpoint).subject: %w", method.Desc.FullName(), err)
+				}
+			}
+			if eopts.SubjectTemplate != "" {
+				if err := validateSubjectTemplate(method.Desc, eopts); err != nil {
+					return fmt.Errorf("%s: %w", method.Desc.FullName(), err)
+				}
+				if lang.Name() != "go" {
+					return fmt.Errorf("%s: subject_template is not supported for language %s", method.Desc.FullName(), lang.Name())
+				}
+			}
+			if eopts.FireAndForget {
+				if err := validateFireAndForget(method.Desc, eopts); err != nil {
+					return fmt.Errorf("%s: %w", method.Desc.FullName(), err)
+				}
+			}
+			if len(eopts.Headers) > 0 {
+				if err := validateHeaders(method.Desc, eopts); err != nil {
+					return fmt.Errorf("%s: %w", method.Desc.FullName(), err)
+				}
+				if lang.Name() != "go" {
+					return fmt.Errorf("%s: headers is not supported for language %s", method.Desc.FullName(), lang.Name())
+				}
+			}
+			if len(eopts.Middlewares) > 0 {
+				if err := validateMiddlewares(method.Desc, eopts.Middlewares); err != nil {
+					return fmt.Errorf("%s: %w", method.Desc.FullName(), err)
+				}
+			}
+			if err := validateRequiredScopes(eopts.RequiredScopes); err != nil {
+				return fmt.Errorf("%s: %w", method.Desc.FullName(), err)
+			}
+			if err := validateEncoding(eopts.Encoding); err != nil {
+				return fmt.Errorf("%s: %w", method.Desc.FullName(), err)
+			}
+			if eopts.AllowImpersonation {
+				if err := validateImpersonation(method.Desc); err != nil {
+					return fmt.Errorf("%s: %w", method.Desc.FullName(), err)
+				}
+			}
+			if eopts.KVStore != nil {
+				if err := validateKVConcurrency(eopts.KVStore); err != nil {
+					return fmt.Errorf("%s: %w", method.Desc.FullName(), err)
+				}
+				if err := buckets.add(method.Desc, eopts.KVStore); err != nil {
+					return fmt.Errorf("%s: %w", method.Desc.FullName(), err)
+				}
+				if err := validatePersistIf(method.Desc, "kv_store", eopts.KVStore.PersistIf, eopts.KVStore.ClientOnly); err != nil {
+					return fmt.Errorf("%s: %w", method.Desc.FullName(), err)
+				}
+			}
+			if eopts.ObjectStore != nil {
+				if err := validatePersistIf(method.Desc, "object_store", eopts.ObjectStore.PersistIf, eopts.ObjectStore.ClientOnly); err != nil {
+					return fmt.Errorf("%s: %w", method.Desc.FullName(), err)
+				}
+				if err := objBuckets.add(method.Desc, eopts.ObjectStore); err != nil {
+					return fmt.Errorf("%s: %w", method.Desc.FullName(), err)
+				}
+			}
+			if eopts.Paginated {
+				if err := validatePagination(method.Desc); err != nil {
+					return fmt.Errorf("%s: %w", method.Desc.FullName(), err)
+				}
+			}
+			if eopts.ScatterGather {
+				if err := validateScatterGather(method.Desc, eopts); err != nil {
+					return fmt.Errorf("%s: %w", method.Desc.FullName(), err)
+				}
+			}
+			if eopts.Stream != nil {
+				if err := validateStreamPersistence(method.Desc, eopts.Stream); err != nil {
+					return fmt.Errorf("%s: %w", method.Desc.FullName(), err)
+				}
+			}
+			if eopts.Enrich != nil {
+				if err := validateEnrich(method.Desc, eopts.Enrich); err != nil {
+					return fmt.Errorf("%s: %w", method.Desc.FullName(), err)
+				}
+			}
+		}
+	}
+	if _, err := enrichAccessors(file); err != nil {
+		return err
+	}
+	if _, err := headerAccessors(file); err != nil {
+		return err
+	}
+
+	// Warn about JSON numbers that JavaScript cannot represent exactly
+	for _, service := range file.Services {
+		if msg := jsonInt64Warning(service.Desc, GetServiceOptions(service)); msg != "" {
+			fmt.Fprintf(os.Stderr, "protoc-gen-nats-micro: warning: %s\n", msg)
+		}
+	}
+
 	// Only Go-like languages use Go import paths
 	var importPath protogen.GoImportPath
 	if lang.IsGoLike() {
-		importPath = file.GoImportPath
+		importPath = newOutputLayout(file, lang.Params(), lang.FileExtension()).GoImportPath
 	}
 
-	// Go-like: use GeneratedFilenamePrefix (derived from go_package)
-	// Others: use the proto source path (e.g., "auth/v1/auth.proto" -> "auth/v1/auth")
-	filenamePrefix := file.GeneratedFilenamePrefix
-	if !lang.IsGoLike() {
-		filenamePrefix = strings.TrimSuffix(file.Proto.GetName(), ".proto")
+	if lang.Params().FilePerService {
+		return generateServiceFiles(gen, file, lang, importPath)
 	}
-	filename := filenamePrefix + lang.FileExtension()
-	g := gen.NewGeneratedFile(filename, importPath)
+
+	g := gen.NewGeneratedFile(outputFilename(file, nil, lang), importPath)
 
 	// Generate header (package, imports)
 	if err := lang.GenerateHeader(g, file); err != nil {
@@ -51,32 +166,91 @@ func GenerateFile(gen *protogen.Plugin, file *protogen.File, lang Language) erro
 	return nil
 }
 
-// ToSnakeCase converts CamelCase to snake_case, handling acronyms correctly.
-// e.g., "HTTPServer" -> "http_server", "getHTTPSURL" -> "get_https_url"
-func ToSnakeCase(s string) string {
-	var result strings.Builder
-	runes := []rune(s)
-	for i, r := range runes {
-		if i > 0 {
-			prev := runes[i-1]
-			if r >= 'A' && r <= 'Z' {
-				if prev >= 'a' && prev <= 'z' {
-					// lowercase → uppercase: "getH" → "get_h"
-					result.WriteByte('_')
-				} else if prev >= '0' && prev <= '9' {
-					// digit → uppercase: "V2O" → "v2_o"
-					result.WriteByte('_')
-				} else if prev >= 'A' && prev <= 'Z' && i+1 < len(runes) && runes[i+1] >= 'a' && runes[i+1] <= 'z' {
-					// End of acronym before lowercase: "HTTPSe" → "http_se"
-					result.WriteByte('_')
-				}
-			} else if r >= '0' && r <= '9' && prev >= 'a' && prev <= 'z' {
-				// lowercase → digit: "Order2" → kept as-is (no separator needed, handled by context)
+// generateServiceFiles generates one file per service of file (file_per_service=true).
+// Each file's header sees only its own service; the declarations derived from the
+// file's messages, such as fuzz_helpers constructors and events, go to the first
+// service's file, or to the file's own output file when it generates no services.
+func generateServiceFiles(gen *protogen.Plugin, file *protogen.File, lang Language, importPath protogen.GoImportPath) error {
+	first := true
+	contextKeys := make(map[string]protoreflect.FullName)
+	headerKeys := make(map[string]protoreflect.FullName)
+	for _, service := range file.Services {
+		opts := GetServiceOptions(service)
+		if opts.Skip {
+			continue
+		}
+
+		view := *file
+		view.Services = []*protogen.Service{service}
+		if !first {
+			view.Messages = nil
+		}
+		first = false
+
+		// Each Go file declares the enrichment and header accessors of its own service
+		if lang.IsGoLike() {
+			for _, enrich := range EnrichAccessors(&view) {
+				if other, ok := contextKeys[enrich.ContextKey]; ok {
+					return fmt.Errorf("%s: (natsmicro.enrich) context_key %q is also used by %s, which file_per_service=true generates into another file", service.Desc.FullName(), enrich.ContextKey, other)
+				}
+				contextKeys[enrich.ContextKey] = service.Desc.FullName()
+			}
+			for _, header := range HeaderAccessors(&view) {
+				if other, ok := headerKeys[header.Accessor]; ok {
+					return fmt.Errorf("%s: header %q generates %sFromContext, which %s also declares and file_per_service=true generates into another file", service.Desc.FullName(), header.Name, header.Accessor, other)
+				}
+				headerKeys[header.Accessor] = service.Desc.FullName()
 			}
 		}
-		result.WriteRune(r)
+
+		g := gen.NewGeneratedFile(outputFilename(file, service, lang), importPath)
+		if err := lang.GenerateHeader(g, &view); err != nil {
+			return fmt.Errorf("generate header: %w", err)
+		}
+		if err := lang.Generate(g, &view, service, opts); err != nil {
+			return fmt.Errorf("generate service %s: %w", service.GoName, err)
+		}
+	}
+	if first && len(Events(file)) > 0 {
+		g := gen.NewGeneratedFile(outputFilename(file, nil, lang), importPath)
+		if err := lang.GenerateHeader(g, file); err != nil {
+			return fmt.Errorf("generate header: %w", err)
+		}
+	}
+	return nil
+}
+
+// outputFilenamePrefix returns the path, without extension, of lang's output for file.
+// Go-like: GeneratedFilenamePrefix (derived from go_package).
+// Others: the proto source path (e.g., "auth/v1/auth.proto" -> "auth/v1/auth").
+func outputFilenamePrefix(file *protogen.File, lang Language) string {
+	if lang.IsGoLike() {
+		return file.GeneratedFilenamePrefix
 	}
-	return strings.ToLower(result.String())
+	return strings.TrimSuffix(file.Proto.GetName(), ".proto")
+}
+
+// GenerateMockFile generates the mocks=true test doubles for a protobuf file.
+// Files without generated services produce no output.
+func GenerateMockFile(gen *protogen.Plugin, file *protogen.File, lang MockLanguage) error {
+	generated := false
+	for _, service := range file.Services {
+		if !GetServiceOptions(service).Skip {
+			generated = true
+		}
+	}
+	if !generated {
+		return nil
+	}
+
+	layout := newOutputLayout(file, lang.Params(), lang.FileExtension())
+	g := gen.NewGeneratedFile(mockFilename(file, lang), layout.GoImportPath)
+	return lang.GenerateMocks(g, file)
+}
+
+// mockFilename returns the path of the mocks=true file of file, next to its generated code
+func mockFilename(file *protogen.File, lang MockLanguage) string {
+	return path.Join(outputDir(file, lang), path.Base(file.GeneratedFilenamePrefix)+lang.MockFileExtension())
 }
 
 // ToLowerFirst converts first character to lowercase
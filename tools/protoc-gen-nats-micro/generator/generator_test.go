@@ -1,47 +1,97 @@
 package generator
 
-import "testing"
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"google.golang.org/genproto/googleapis/api/annotations"
+	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/pluginpb"
+
+	natspb "github.com/toyz/protoc-gen-nats-micro/tools/protoc-gen-nats-micro/nats/micro"
+)
 
 func TestToSnakeCase(t *testing.T) {
 	tests := []struct {
 		input    string
 		expected string
+		legacy   string // legacy_casing=true result, if different
 	}{
 		// Basic cases
-		{"", ""},
-		{"a", "a"},
-		{"A", "a"},
+		{"", "", ""},
+		{"a", "a", ""},
+		{"A", "a", ""},
 
 		// Simple CamelCase
-		{"FooBar", "foo_bar"},
-		{"fooBar", "foo_bar"},
-		{"CreateProduct", "create_product"},
-		{"GetOrder", "get_order"},
+		{"FooBar", "foo_bar", ""},
+		{"fooBar", "foo_bar", ""},
+		{"CreateProduct", "create_product", ""},
+		{"GetOrder", "get_order", ""},
 
 		// Acronyms
-		{"HTTPServer", "http_server"},
-		{"APIGateway", "api_gateway"},
-		{"DBService", "db_service"},
-		{"getHTTPSURL", "get_httpsurl"}, // Adjacent acronyms are ambiguous without a dictionary
-		{"XMLParser", "xml_parser"},
-		{"parseJSON", "parse_json"},
-		{"IOReader", "io_reader"},
+		{"HTTPServer", "http_server", ""},
+		{"APIGateway", "api_gateway", ""},
+		{"DBService", "db_service", ""},
+		{"XMLParser", "xml_parser", ""},
+		{"parseJSON", "parse_json", ""},
+		{"IOReader", "io_reader", ""},
+		{"UUID", "uuid", ""},
+		{"ServeHTTP", "serve_http", ""},
+
+		// Consecutive acronyms
+		{"getHTTPSURL", "get_https_url", "get_httpsurl"},
+		{"JSONAPIClient", "json_api_client", "jsonapi_client"},
+		{"CPUID", "cpu_id", "cpuid"},
+		{"SQLDBService", "sql_db_service", "sqldb_service"},
+		{"ParseXMLHTTPRequest", "parse_xml_http_request", "parse_xmlhttp_request"},
+		{"ABCService", "abc_service", ""},
+
+		// Plural acronyms
+		{"UserIDs", "user_ids", "user_i_ds"},
+		{"ListURLsRequest", "list_urls_request", "list_ur_ls_request"},
+		{"GetURLIDs", "get_url_ids", "get_urli_ds"},
 
 		// Already snake_case
-		{"foo_bar", "foo_bar"},
-		{"already_snake", "already_snake"},
+		{"foo_bar", "foo_bar", ""},
+		{"already_snake", "already_snake", ""},
+		{"Foo_Bar", "foo_bar", ""},
 
 		// Single word
-		{"Product", "product"},
-		{"order", "order"},
+		{"Product", "product", ""},
+		{"order", "order", ""},
 
-		// Numbers (should pass through)
-		{"V2Order", "v2_order"},
-		{"OrderV2", "order_v2"},
+		// Digits belong to the word before them
+		{"V2Order", "v2_order", ""},
+		{"OrderV2", "order_v2", ""},
+		{"Order2Items", "order2_items", ""},
+		{"MyAPIV2Service", "my_api_v2_service", "my_apiv2_service"},
+		{"APIV2", "api_v2", "apiv2"},
+		{"HTTP2Server", "http2_server", ""},
+		{"S3Bucket", "s3_bucket", ""},
+		{"OAuth2Token", "o_auth2_token", ""},
+		{"UTF8Decoder", "utf8_decoder", ""},
+		{"Base64URLEncode", "base64_url_encode", ""},
 
 		// Consecutive uppercase at end
-		{"MyAPI", "my_api"},
-		{"TestHTTP", "test_http"},
+		{"MyAPI", "my_api", ""},
+		{"TestHTTP", "test_http", ""},
 	}
 
 	for _, tt := range tests {
@@ -50,6 +100,58 @@ func TestToSnakeCase(t *testing.T) {
 			if got != tt.expected {
 				t.Errorf("ToSnakeCase(%q) = %q, want %q", tt.input, got, tt.expected)
 			}
+
+			legacy := tt.legacy
+			if legacy == "" {
+				legacy = tt.expected
+			}
+			legacyCasing = true
+			defer func() { legacyCasing = false }()
+			if got := ToSnakeCase(tt.input); got != legacy {
+				t.Errorf("legacy ToSnakeCase(%q) = %q, want %q", tt.input, got, legacy)
+			}
+		})
+	}
+}
+
+func TestToKebabCase(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+		legacy   string // legacy_casing=true result, if different
+	}{
+		{"", "", ""},
+		{"FooBar", "foo-bar", ""},
+		{"CreateProduct", "create-product", ""},
+		{"HTTPServer", "http-server", ""},
+		{"APIGateway", "api-gateway", ""},
+		{"parseJSON", "parse-json", ""},
+		{"Product", "product", ""},
+		{"listProducts", "list-products", ""},
+		{"GetHTTPSURL", "get-https-url", "get-httpsurl"},
+		{"ServeHTTP", "serve-http", ""},
+		{"GetOrderV2", "get-order-v2", ""},
+		{"V2Order", "v2-order", ""},
+		{"MyAPIV2Service", "my-api-v2-service", "my-apiv2-service"},
+		{"FetchUserIDs", "fetch-user-ids", "fetch-user-i-ds"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got := ToKebabCase(tt.input)
+			if got != tt.expected {
+				t.Errorf("ToKebabCase(%q) = %q, want %q", tt.input, got, tt.expected)
+			}
+
+			legacy := tt.legacy
+			if legacy == "" {
+				legacy = tt.expected
+			}
+			legacyCasing = true
+			defer func() { legacyCasing = false }()
+			if got := ToKebabCase(tt.input); got != legacy {
+				t.Errorf("legacy ToKebabCase(%q) = %q, want %q", tt.input, got, legacy)
+			}
 		})
 	}
 }
@@ -121,30 +223,6 @@ func TestToCamelCase(t *testing.T) {
 	}
 }
 
-func TestToKebabCase(t *testing.T) {
-	tests := []struct {
-		input    string
-		expected string
-	}{
-		{"", ""},
-		{"FooBar", "foo-bar"},
-		{"CreateProduct", "create-product"},
-		{"HTTPServer", "http-server"},
-		{"APIGateway", "api-gateway"},
-		{"parseJSON", "parse-json"},
-		{"Product", "product"},
-	}
-
-	for _, tt := range tests {
-		t.Run(tt.input, func(t *testing.T) {
-			got := ToKebabCase(tt.input)
-			if got != tt.expected {
-				t.Errorf("ToKebabCase(%q) = %q, want %q", tt.input, got, tt.expected)
-			}
-		})
-	}
-}
-
 func TestProtoBasename(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -181,6 +259,9 @@ func TestGetLanguage(t *testing.T) {
 		{"py", "python"},
 		{"web-ts", "web-ts"},
 		{"webts", "web-ts"},
+		{"csharp", "csharp"},
+		{"cs", "csharp"},
+		{"c#", "csharp"},
 	}
 
 	for _, tt := range validCases {
@@ -206,3 +287,2491 @@ func TestGetLanguage(t *testing.T) {
 		t.Error("GetLanguage(\"java\") should return error for unsupported language")
 	}
 }
+
+func TestParseTemplatesNormalizesLineEndings(t *testing.T) {
+	render := func(text string) string {
+		t.Helper()
+		fsys := fstest.MapFS{"templates/x/header.tmpl": {Data: []byte(text)}}
+		tmpl, err := parseTemplates(fsys, "x", "templates/x/*.tmpl")
+		if err != nil {
+			t.Fatalf("parseTemplates: %v", err)
+		}
+		var buf bytes.Buffer
+		if err := tmpl.ExecuteTemplate(&buf, "header.tmpl", TemplateData{Params: Params{Version: "1.2.3"}}); err != nil {
+			t.Fatalf("execute: %v", err)
+		}
+		return buf.String()
+	}
+
+	lf := render("// line one\n{{- if not .Params.Reproducible}}\n// v{{.Params.Version}}\n{{- end}}\n")
+	crlf := render("// line one\r\n{{- if not .Params.Reproducible}}\r\n// v{{.Params.Version}}\r\n{{- end}}\r\n")
+	if lf != crlf {
+		t.Errorf("CRLF template output %q differs from LF output %q", crlf, lf)
+	}
+}
+
+// goPlugin prepares a protogen plugin run over set; the fixture is its last file
+func goPlugin(t *testing.T, set *descriptorpb.FileDescriptorSet) *protogen.Plugin {
+	t.Helper()
+	for _, f := range set.File {
+		if f.Options == nil {
+			f.Options = &descriptorpb.FileOptions{GoPackage: proto.String("example.com/fixture/v1;fixturev1")}
+		}
+	}
+	// Dependencies come first, as protoc orders them, so the fixture is the last file
+	last := len(set.File) - 1
+	gen, err := protogen.Options{}.New(&pluginpb.CodeGeneratorRequest{
+		FileToGenerate: []string{set.File[last].GetName()},
+		ProtoFile:      set.File,
+	})
+	if err != nil {
+		t.Fatalf("protogen: %v", err)
+	}
+	return gen
+}
+
+// generateGo runs the Go generator over set and returns the generated service file
+func generateGo(t *testing.T, set *descriptorpb.FileDescriptorSet, params Params) string {
+	t.Helper()
+	gen := goPlugin(t, set)
+	lang := NewGoLanguage()
+	lang.SetParams(params)
+	if err := GenerateFile(gen, gen.Files[len(gen.Files)-1], lang); err != nil {
+		t.Fatalf("GenerateFile: %v", err)
+	}
+	// protogen gofmts Go output and reports code that does not parse as an error
+	resp := gen.Response()
+	if resp.Error != nil {
+		t.Fatalf("generated code is not valid Go: %s", resp.GetError())
+	}
+	return resp.File[0].GetContent()
+}
+
+// generateGoErr runs the Go generator over set and returns the error it stops with
+func generateGoErr(t *testing.T, set *descriptorpb.FileDescriptorSet) error {
+	t.Helper()
+	gen := goPlugin(t, set)
+	return GenerateFile(gen, gen.Files[len(gen.Files)-1], NewGoLanguage())
+}
+
+func TestGenerateServiceOptions(t *testing.T) {
+	fixture := func() *descriptorpb.FileDescriptorSet {
+		return lintFixture(
+			lintService("OrderService", "api.orders", lintMethod("GetOrder", nil)),
+			lintService("ProductService", "api.products", lintMethod("GetProduct", nil)),
+		)
+	}
+
+	shared := generateGo(t, fixture(), Params{Reproducible: true})
+	for _, want := range []string{
+		"func RegisterOrderServiceHandlers(nc *nats.Conn, impl OrderServiceNats, opts ...RegisterOption)",
+		"func RegisterProductServiceHandlers(nc *nats.Conn, impl ProductServiceNats, opts ...RegisterOption)",
+	} {
+		if !strings.Contains(shared, want) {
+			t.Errorf("default output missing %q", want)
+		}
+	}
+	if strings.Contains(shared, "WithOrderServiceServerInterceptor") {
+		t.Error("default output has per-service options")
+	}
+
+	namespaced := generateGo(t, fixture(), Params{Reproducible: true, ServiceOptions: true})
+	for _, want := range []string{
+		"func RegisterOrderServiceHandlers(nc *nats.Conn, impl OrderServiceNats, opts ...OrderServiceRegisterOption)",
+		"func RegisterProductServiceHandlers(nc *nats.Conn, impl ProductServiceNats, opts ...ProductServiceRegisterOption)",
+		"func WithOrderServiceServerInterceptor(interceptor UnaryServerInterceptor) OrderServiceRegisterOption",
+		"func WithProductServiceServerInterceptor(interceptor UnaryServerInterceptor) ProductServiceRegisterOption",
+		"func (o RegisterOption) applyOrderServiceRegisterOption(c *registerConfig)",
+		"func (o orderServiceRegisterOption) applyOrderServiceRegisterOption(c *registerConfig)",
+	} {
+		if !strings.Contains(namespaced, want) {
+			t.Errorf("service_options output missing %q", want)
+		}
+	}
+	if strings.Contains(namespaced, "func (o orderServiceRegisterOption) applyProductServiceRegisterOption") {
+		t.Error("OrderService options satisfy ProductServiceRegisterOption")
+	}
+}
+
+func TestGenerateAllowImpersonation(t *testing.T) {
+	out := generateGo(t, lintFixture(lintService("AdminService", "api.admin",
+		lintMethod("GetUser", func(o *descriptorpb.MethodOptions) {
+			proto.SetExtension(o, natspb.E_Endpoint, &natspb.EndpointOptions{AllowImpersonation: true})
+		}),
+		lintMethod("DeleteUser", nil),
+	)), Params{Reproducible: true})
+	if got := strings.Count(out, "AllowImpersonation: true,"); got != 1 {
+		t.Errorf("AllowImpersonation set in %d handlers, want only GetUser's", got)
+	}
+}
+
+func TestGenerateKVTypeTags(t *testing.T) {
+	withKV := func(tag string) func(*descriptorpb.MethodOptions) {
+		return func(o *descriptorpb.MethodOptions) {
+			proto.SetExtension(o, natspb.E_KvStore, &natspb.KVStoreOptions{Bucket: "profiles", KeyTemplate: "p.{id}", TypeTag: tag})
+		}
+	}
+	fixture := func(respTag, reqTag string) *descriptorpb.FileDescriptorSet {
+		echo := lintMethod("Echo", withKV(reqTag)) // Persists a Req into the same bucket
+		echo.OutputType = proto.String(".fixture.v1.Req")
+		return lintFixture(lintService("ProfileService", "api.profiles", lintMethod("Save", withKV(respTag)), echo))
+	}
+
+	for _, tt := range []struct{ respTag, reqTag, want string }{
+		{"", "", "set a distinct type_tag on both methods"},
+		{"resp", "", "set a distinct type_tag on both methods"},
+		{"same", "same", `type_tag "same" in bucket "profiles" already marks fixture.v1.Resp`},
+		{"a b", "req", "may only contain letters"},
+	} {
+		err := generateGoErr(t, fixture(tt.respTag, tt.reqTag))
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("tags %q/%q: GenerateFile = %v, want %q", tt.respTag, tt.reqTag, err, tt.want)
+		}
+	}
+
+	out := generateGo(t, fixture("resp", "req"), Params{Reproducible: true})
+	for _, want := range []string{
+		`putTypedKVEntry(ctx, h.js, "profiles", kvKey, data, "resp")`,
+		`entry, err := getTypedKVEntry(ctx, c.js, kv, "profiles", key, "req")`,
+		`putTypedKVEntry(ctx, c.js, "profiles", key, data, "req")`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output missing %q", want)
+		}
+	}
+	if strings.Contains(out, "kv.Put(") {
+		t.Error("tagged methods write untagged entries")
+	}
+}
+
+func TestGenerateKVBucketSettings(t *testing.T) {
+	withKV := func(kv *natspb.KVStoreOptions) func(*descriptorpb.MethodOptions) {
+		return func(o *descriptorpb.MethodOptions) {
+			kv.Bucket, kv.KeyTemplate = "profiles", "p.{id}"
+			proto.SetExtension(o, natspb.E_KvStore, kv)
+		}
+	}
+	hour := durationpb.New(time.Hour)
+	fixture := func(save, load *natspb.KVStoreOptions) *descriptorpb.FileDescriptorSet {
+		return lintFixture(lintService("ProfileService", "api.profiles", lintMethod("SaveProfile", withKV(save)), lintMethod("LoadProfile", withKV(load))))
+	}
+
+	for _, tt := range []struct {
+		save, load *natspb.KVStoreOptions
+		want       string
+	}{
+		{&natspb.KVStoreOptions{Ttl: hour}, &natspb.KVStoreOptions{}, `bucket "profiles" is declared with ttl 1h0m0s by fixture.v1.ProfileService.SaveProfile`},
+		{&natspb.KVStoreOptions{MaxHistory: 5}, &natspb.KVStoreOptions{MaxHistory: 1}, "declared with max_history 5"},
+		{&natspb.KVStoreOptions{MaxHistory: 65}, &natspb.KVStoreOptions{}, "max_history 65 is out of range"},
+		{&natspb.KVStoreOptions{LimitMarkerTtl: hour}, &natspb.KVStoreOptions{}, "limit_marker_ttl requires a ttl"},
+		{&natspb.KVStoreOptions{Ttl: hour, LimitMarkerTtl: durationpb.New(time.Millisecond)}, &natspb.KVStoreOptions{}, "limit_marker_ttl must be at least 1s"},
+	} {
+		err := generateGoErr(t, fixture(tt.save, tt.load))
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("GenerateFile = %v, want %q", err, tt.want)
+		}
+	}
+
+	// Unset max_history is the default of 1 revision
+	generateGo(t, fixture(&natspb.KVStoreOptions{MaxHistory: 1}, &natspb.KVStoreOptions{}), Params{Reproducible: true})
+
+	settings := &natspb.KVStoreOptions{Ttl: hour, MaxHistory: 5, LimitMarkerTtl: durationpb.New(time.Minute)}
+	out := generateGo(t, fixture(settings, proto.Clone(settings).(*natspb.KVStoreOptions)), Params{Reproducible: true})
+	for _, want := range []struct {
+		text  string
+		count int
+	}{
+		// Provisioned once for both methods
+		{`{methods: "SaveProfile, LoadProfile", kv: &jetstream.KeyValueConfig{`, 1},
+		{"History:        5,", 1},
+		{"TTL:            3600000000000 * time.Nanosecond,", 1},
+		{"LimitMarkerTTL: 60000000000 * time.Nanosecond,", 1},
+		{"}, limitMarkerTTL: 60000000000 * time.Nanosecond},", 1},
+		{`if errors.Is(err, jetstream.ErrKeyNotFound) && kvKeyExpired(ctx, c.js, "profiles", key) {`, 2},
+	} {
+		if got := strings.Count(out, want.text); got != want.count {
+			t.Errorf("%q appears %d times, want %d", want.text, got, want.count)
+		}
+	}
+	if strings.Contains(out, "CreateOrUpdateKeyValue") {
+		t.Error("registration overwrites existing KV buckets")
+	}
+
+	// Without limit markers, an expired key cannot be told apart
+	out = generateGo(t, fixture(&natspb.KVStoreOptions{Ttl: hour}, &natspb.KVStoreOptions{Ttl: hour}), Params{Reproducible: true})
+	if strings.Contains(out, "kvKeyExpired(") || strings.Contains(out, "LimitMarkerTTL") {
+		t.Error("buckets without limit_marker_ttl look for expiry markers")
+	}
+	if strings.Contains(out, "limitMarkerTTL:") {
+		t.Error("registration passes a limit marker TTL for a bucket without one")
+	}
+}
+
+func TestGenerateBucketProvisioning(t *testing.T) {
+	fixture := func(kv *natspb.KVStoreOptions, objs ...*natspb.ObjectStoreOptions) *descriptorpb.FileDescriptorSet {
+		methods := []*descriptorpb.MethodDescriptorProto{lintMethod("SaveProfile", func(o *descriptorpb.MethodOptions) {
+			kv.Bucket, kv.KeyTemplate = "profiles", "p.{id}"
+			proto.SetExtension(o, natspb.E_KvStore, kv)
+		})}
+		for i, obj := range objs {
+			obj.Bucket = "avatars"
+			methods = append(methods, lintMethod(fmt.Sprintf("SaveAvatar%d", i), func(o *descriptorpb.MethodOptions) {
+				proto.SetExtension(o, natspb.E_ObjectStore, obj)
+			}))
+		}
+		return lintFixture(lintService("ProfileService", "api.profiles", methods...))
+	}
+
+	for _, tt := range []struct {
+		set  *descriptorpb.FileDescriptorSet
+		want string
+	}{
+		{fixture(&natspb.KVStoreOptions{Replicas: 6}), "kv_store replicas 6 is out of range (0-5)"},
+		{fixture(&natspb.KVStoreOptions{}, &natspb.ObjectStoreOptions{Replicas: -1}), "object_store replicas -1 is out of range"},
+		{fixture(&natspb.KVStoreOptions{}, &natspb.ObjectStoreOptions{Replicas: 3}, &natspb.ObjectStoreOptions{}),
+			`object_store bucket "avatars" is declared with replicas 3 by fixture.v1.ProfileService.SaveAvatar0`},
+		{fixture(&natspb.KVStoreOptions{}, &natspb.ObjectStoreOptions{}, &natspb.ObjectStoreOptions{Storage: natspb.StorageType_MEMORY_STORAGE}),
+			"declared with storage FILE_STORAGE"},
+	} {
+		err := generateGoErr(t, tt.set)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("GenerateFile = %v, want %q", err, tt.want)
+		}
+	}
+
+	set := fixture(&natspb.KVStoreOptions{Replicas: 3, Storage: natspb.StorageType_MEMORY_STORAGE},
+		&natspb.ObjectStoreOptions{Replicas: 1}, &natspb.ObjectStoreOptions{})
+	out := generateGo(t, set, Params{Reproducible: true})
+	for _, want := range []string{
+		"if err := provisionBuckets(context.Background(), cfg.js, cfg.noBucketProvisioning, []bucketSpec{",
+		"Replicas: 3,\n\t\t\t\tStorage:  jetstream.MemoryStorage,",
+		// One Object Store bucket for both methods; replicas 1 is the default
+		`{methods: "SaveAvatar0, SaveAvatar1", obj: &jetstream.ObjectStoreConfig{`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output missing %q", want)
+		}
+	}
+	if strings.Count(out, "ObjectStoreConfig{") != 1 {
+		t.Error("Object Store bucket provisioned more than once")
+	}
+	// Buckets exist before any endpoint subscribes
+	if provision, add := strings.Index(out, "provisionBuckets("), strings.Index(out, "host, shared, err := attach()"); provision < 0 || provision > add {
+		t.Error("buckets are provisioned after the service is added")
+	}
+
+	shared := generateGoShared(t, set, Params{Reproducible: true})
+	for _, want := range []string{
+		"func WithoutBucketProvisioning() RegisterOption {",
+		`return fmt.Errorf("%s bucket %q for %s does not exist; create it, or register without WithoutBucketProvisioning", kind, name, b.methods)`,
+	} {
+		if !strings.Contains(shared, want) {
+			t.Errorf("shared file missing %q", want)
+		}
+	}
+
+	if findings := runLintFixture(t, fixture(&natspb.KVStoreOptions{}, &natspb.ObjectStoreOptions{Replicas: 2}, &natspb.ObjectStoreOptions{})); len(findingsByRule(findings, RulePersistenceConfig)) == 0 {
+		t.Error("lint accepts an Object Store bucket declared differently")
+	}
+}
+
+func TestGeneratePersistIf(t *testing.T) {
+	fixture := func(kv *natspb.KVStoreOptions, obj *natspb.ObjectStoreOptions) *descriptorpb.FileDescriptorSet {
+		set := lintFixture(lintService("ProfileService", "api.profiles", lintMethod("SaveProfile", func(o *descriptorpb.MethodOptions) {
+			kv.Bucket, kv.KeyTemplate = "profiles", "p.{id}"
+			proto.SetExtension(o, natspb.E_KvStore, kv)
+			if obj != nil {
+				obj.Bucket = "avatars"
+				proto.SetExtension(o, natspb.E_ObjectStore, obj)
+			}
+		})))
+		resp := set.File[0].MessageType[1]
+		resp.Field = append(resp.Field, &descriptorpb.FieldDescriptorProto{
+			Name:     proto.String("is_complete"),
+			Number:   proto.Int32(1),
+			Type:     descriptorpb.FieldDescriptorProto_TYPE_BOOL.Enum(),
+			Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+			JsonName: proto.String("isComplete"),
+		}, &descriptorpb.FieldDescriptorProto{
+			Name:     proto.String("name"),
+			Number:   proto.Int32(2),
+			Type:     descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum(),
+			Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+			JsonName: proto.String("name"),
+		})
+		return set
+	}
+
+	for _, tt := range []struct {
+		kv   *natspb.KVStoreOptions
+		obj  *natspb.ObjectStoreOptions
+		want string
+	}{
+		{&natspb.KVStoreOptions{PersistIf: "missing"}, nil, `kv_store persist_if "missing" is not a field of fixture.v1.Resp`},
+		{&natspb.KVStoreOptions{PersistIf: "name"}, nil, `kv_store persist_if "name" must name a bool field`},
+		{&natspb.KVStoreOptions{PersistIf: "is_complete", ClientOnly: true}, nil, "persist_if does not apply to client_only buckets"},
+		{&natspb.KVStoreOptions{}, &natspb.ObjectStoreOptions{PersistIf: "name"}, `object_store persist_if "name" must name a bool field`},
+	} {
+		err := generateGoErr(t, fixture(tt.kv, tt.obj))
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("GenerateFile = %v, want %q", err, tt.want)
+		}
+	}
+
+	out := generateGo(t, fixture(&natspb.KVStoreOptions{PersistIf: "is_complete"}, &natspb.ObjectStoreOptions{}), Params{Reproducible: true})
+	for _, want := range []string{
+		"ShouldPersistSaveProfile(*Resp) bool",
+		"persist = filter.ShouldPersistSaveProfile(typedResp)",
+		"if h.js != nil && persist && typedResp.GetIsComplete() {",
+		"if h.js != nil && persist {", // The object store has no persist_if
+		`reportPersistenceError(h.persistenceErrors, "fixture.v1.ProfileService.SaveProfile", fmt.Errorf("failed to persist response to KV: %w", kvErr))`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output missing %q", want)
+		}
+	}
+	if strings.Contains(out, "WARN: failed to persist") {
+		t.Error("persistence failures bypass WithPersistenceErrorHandler")
+	}
+
+	// Methods without server-side persistence have nothing to filter
+	out = generateGo(t, fixture(&natspb.KVStoreOptions{ClientOnly: true}, nil), Params{Reproducible: true})
+	if strings.Contains(out, "ShouldPersist") {
+		t.Error("client_only method consults ShouldPersistSaveProfile")
+	}
+
+	if got := PersistIfTS("is_complete"); got != "response.isComplete" {
+		t.Errorf("PersistIfTS = %q", got)
+	}
+	if got := PersistIfPy("is_complete"); got != "response_msg.is_complete" {
+		t.Errorf("PersistIfPy = %q", got)
+	}
+}
+
+func TestGenerateKVCached(t *testing.T) {
+	withKV := func(bucket string, clientOnly bool) func(*descriptorpb.MethodOptions) {
+		return func(o *descriptorpb.MethodOptions) {
+			proto.SetExtension(o, natspb.E_KvStore, &natspb.KVStoreOptions{Bucket: bucket, KeyTemplate: "p.{id}", ClientOnly: clientOnly})
+		}
+	}
+	fixture := lintFixture(lintService("ProfileService", "api.profiles",
+		lintMethod("SaveProfile", withKV("profiles", false)),
+		lintMethod("LookupProfile", withKV("lookups", true)),
+	))
+	out := generateGo(t, fixture, Params{Reproducible: true})
+	for _, want := range []string{
+		"GetSaveProfileCached(ctx context.Context, key string, req *Req, opts ...CallOption) (*Resp, error)\n",
+		`return nil, errors.New("JetStream not configured; use WithNatsClientJetStream to enable cached reads")`,
+		"if err == nil && (c.kvStaleAfter <= 0 || time.Since(entry.Created()) <= c.kvStaleAfter) {",
+		"return c.SaveProfile(ctx, req, opts...)",
+		// The service does not persist client_only responses, so the client does
+		"if err := c.PutLookupProfileToKV(ctx, key, resp); err != nil {",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output missing %q", want)
+		}
+	}
+	shared := generateGoShared(t, fixture, Params{Reproducible: true})
+	if !strings.Contains(shared, "func WithKVStaleAfter(d time.Duration) NatsClientOption {") {
+		t.Error("shared file missing WithKVStaleAfter")
+	}
+}
+
+func TestGenerateKVWatch(t *testing.T) {
+	withKV := func(bucket, tag string) func(*descriptorpb.MethodOptions) {
+		return func(o *descriptorpb.MethodOptions) {
+			proto.SetExtension(o, natspb.E_KvStore, &natspb.KVStoreOptions{Bucket: bucket, KeyTemplate: "p.{id}", TypeTag: tag})
+		}
+	}
+	fixture := lintFixture(lintService("ProfileService", "api.profiles",
+		lintMethod("SaveProfile", withKV("profiles", "")),
+		lintMethod("SaveTaggedProfile", withKV("tagged", "profile")),
+	))
+	out := generateGo(t, fixture, Params{Reproducible: true})
+	for _, want := range []string{
+		"WatchSaveProfileKV(ctx context.Context, keyPattern string, opts ...KVWatchOption) (<-chan KVChange[*Resp], error)\n",
+		`return watchKV(ctx, c.js, "profiles", keyPattern, "", opts, func(data []byte) (*Resp, error) {`,
+		`return watchKV(ctx, c.js, "tagged", keyPattern, "profile", opts, func(data []byte) (*Resp, error) {`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output missing %q", want)
+		}
+	}
+	shared := generateGoShared(t, fixture, Params{Reproducible: true})
+	for _, want := range []string{
+		"type KVChange[T any] struct {",
+		"func WithKVWatchDeletes() KVWatchOption {",
+		"watchOpts = append(watchOpts, jetstream.IgnoreDeletes())",
+		"change.Err = checkKVTypeTag(ctx, stream, entry.Key(), entry.Revision(), tag)",
+	} {
+		if !strings.Contains(shared, want) {
+			t.Errorf("shared file missing %q", want)
+		}
+	}
+}
+
+func TestGenerateOTel(t *testing.T) {
+	fixture := lintFixture(lintService("OrderService", "api.orders", lintMethod("GetOrder", nil)))
+	if out := generateGoShared(t, fixture, Params{Reproducible: true}); strings.Contains(out, "go.opentelemetry.io") {
+		t.Error("default shared file imports OpenTelemetry")
+	}
+	if out := generateGoShared(t, fixture, Params{Reproducible: true, OTel: true}); !strings.Contains(out, `"go.opentelemetry.io/otel/trace"`) {
+		t.Error("otel=true shared file does not import OpenTelemetry")
+	}
+}
+
+func TestGenerateValidate(t *testing.T) {
+	fixture := func() *descriptorpb.FileDescriptorSet {
+		watch := lintMethod("WatchOrders", nil)
+		watch.ServerStreaming = proto.Bool(true)
+		return lintFixture(lintService("OrderService", "api.orders", lintMethod("GetOrder", nil), watch))
+	}
+	shared := generateGoShared(t, fixture(), Params{Reproducible: true})
+	out := generateGo(t, fixture(), Params{Reproducible: true})
+	if strings.Contains(shared, "protovalidate") || strings.Contains(out, "validateRequest") {
+		t.Error("default output validates requests")
+	}
+
+	shared = generateGoShared(t, fixture(), Params{Reproducible: true, Validate: true})
+	for _, want := range []string{
+		`"buf.build/go/protovalidate"`,
+		"func validateRequest(req proto.Message) error {",
+		"func WithClientValidation() NatsClientOption {",
+	} {
+		if !strings.Contains(shared, want) {
+			t.Errorf("validate=true shared file missing %q", want)
+		}
+	}
+	out = generateGo(t, fixture(), Params{Reproducible: true, Validate: true})
+	// Server: the unary handler and the stream opening request; client: both calls
+	if n := strings.Count(out, "validateRequest(typedReq)"); n != 2 {
+		t.Errorf("unary request validated %d times, want 2", n)
+	}
+	// The stream handler checks the request the stream interceptors pass on
+	if n := strings.Count(out, "if err := validateRequest(req); err != nil {"); n != 2 {
+		t.Errorf("stream opening request validated %d times, want 2", n)
+	}
+}
+
+func TestGenerateGRPCShim(t *testing.T) {
+	fixture := func() *descriptorpb.FileDescriptorSet {
+		watch := lintMethod("WatchOrders", nil)
+		watch.ServerStreaming = proto.Bool(true)
+		return lintFixture(lintService("OrderService", "api.orders",
+			lintMethod("GetOrder", nil),
+			lintMethod("Internal", func(o *descriptorpb.MethodOptions) {
+				proto.SetExtension(o, natspb.E_Endpoint, &natspb.EndpointOptions{Skip: true})
+			}),
+			watch,
+		))
+	}
+	out := generateGo(t, fixture(), Params{Reproducible: true})
+	shared := generateGoShared(t, fixture(), Params{Reproducible: true})
+	if strings.Contains(out, "google.golang.org/grpc") || strings.Contains(shared, "google.golang.org/grpc") {
+		t.Error("default output imports grpc")
+	}
+
+	out = generateGo(t, fixture(), Params{Reproducible: true, GRPCShim: true})
+	for _, want := range []string{
+		"func NewOrderServiceGRPCShim(nc *nats.Conn, opts ...NatsClientOption) *OrderServiceGRPCShim {",
+		"func (s *OrderServiceGRPCShim) GetOrder(ctx context.Context, in *Req, opts ...grpc.CallOption) (*Resp, error) {",
+		"func (s *OrderServiceGRPCShim) WatchOrders(ctx context.Context, in *Req, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Resp], error) {",
+		// Skipped methods stay in the interface, unimplemented
+		`return nil, errGRPCSkipped("fixture.v1.OrderService.Internal")`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("grpc_shim=true output missing %q", want)
+		}
+	}
+	shared = generateGoShared(t, fixture(), Params{Reproducible: true, GRPCShim: true})
+	for _, want := range []string{
+		`grpcstatus "google.golang.org/grpc/status"`,
+		"func grpcError(err error) error {",
+		"type grpcShimStream[Req, Resp any] struct {",
+	} {
+		if !strings.Contains(shared, want) {
+			t.Errorf("grpc_shim=true shared file missing %q", want)
+		}
+	}
+}
+
+func TestGenerateGRPCBridge(t *testing.T) {
+	fixture := func(streaming bool) *descriptorpb.FileDescriptorSet {
+		methods := []*descriptorpb.MethodDescriptorProto{
+			lintMethod("GetOrder", nil),
+			lintMethod("Internal", func(o *descriptorpb.MethodOptions) {
+				proto.SetExtension(o, natspb.E_Endpoint, &natspb.EndpointOptions{Skip: true})
+			}),
+		}
+		if streaming {
+			watch := lintMethod("WatchOrders", nil)
+			watch.ServerStreaming = proto.Bool(true)
+			methods = append(methods, watch)
+		}
+		return lintFixture(lintService("OrderService", "api.orders", methods...))
+	}
+	out := generateGo(t, fixture(true), Params{Reproducible: true})
+	if strings.Contains(out, "GRPCBridge") || strings.Contains(out, "captureGRPCHeaders") {
+		t.Error("default output has a gRPC bridge")
+	}
+
+	out = generateGo(t, fixture(true), Params{Reproducible: true, GRPCBridge: true})
+	for _, want := range []string{
+		"UnimplementedOrderServiceServer // Answers the methods not served over NATS",
+		"func NewOrderServiceGRPCBridge(client OrderServiceNatsClientInterface) *OrderServiceGRPCBridge {",
+		"func (b *OrderServiceGRPCBridge) GetOrder(ctx context.Context, in *Req) (*Resp, error) {",
+		"func (b *OrderServiceGRPCBridge) WatchOrders(in *Req, stream grpc.ServerStreamingServer[Resp]) error {",
+		// Clients hand response headers to the bridge
+		"cfg.clientInterceptors = append([]UnaryClientInterceptor{captureGRPCHeaders}, cfg.clientInterceptors...)",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("grpc_bridge=true output missing %q", want)
+		}
+	}
+	if strings.Contains(out, "func (b *OrderServiceGRPCBridge) Internal(") {
+		t.Error("skipped method bridged")
+	}
+	// Unary bridges need no grpc import in the service file
+	if out := generateGo(t, fixture(false), Params{Reproducible: true, GRPCBridge: true}); strings.Contains(out, `"google.golang.org/grpc"`) {
+		t.Error("unary-only bridge imports grpc")
+	}
+	shared := generateGoShared(t, fixture(true), Params{Reproducible: true, GRPCBridge: true})
+	for _, want := range []string{
+		"func startGRPCBridgeCall(ctx context.Context) (context.Context, *nats.Header) {",
+		"func forwardGRPCStream[Resp any](",
+		"func grpcError(err error) error {",
+	} {
+		if !strings.Contains(shared, want) {
+			t.Errorf("grpc_bridge=true shared file missing %q", want)
+		}
+	}
+}
+
+func TestGenerateHTTPGateway(t *testing.T) {
+	fixture := func(rule *annotations.HttpRule) *descriptorpb.FileDescriptorSet {
+		watch := lintMethod("WatchOrders", func(o *descriptorpb.MethodOptions) {
+			proto.SetExtension(o, annotations.E_Http, &annotations.HttpRule{Pattern: &annotations.HttpRule_Get{Get: "/v1/orders:watch"}})
+		})
+		watch.ServerStreaming = proto.Bool(true)
+		return lintFixture(lintService("OrderService", "api.orders",
+			lintMethod("GetOrder", func(o *descriptorpb.MethodOptions) {
+				proto.SetExtension(o, annotations.E_Http, rule)
+			}),
+			lintMethod("Internal", func(o *descriptorpb.MethodOptions) {
+				proto.SetExtension(o, natspb.E_Endpoint, &natspb.EndpointOptions{Skip: true})
+				proto.SetExtension(o, annotations.E_Http, &annotations.HttpRule{Pattern: &annotations.HttpRule_Get{Get: "/v1/internal"}})
+			}),
+			watch,
+		))
+	}
+	getOrder := &annotations.HttpRule{
+		Pattern:            &annotations.HttpRule_Get{Get: "/v1/orders/{id}"},
+		AdditionalBindings: []*annotations.HttpRule{{Pattern: &annotations.HttpRule_Post{Post: "/v1/orders:get"}, Body: "*"}},
+	}
+	out := generateGo(t, fixture(getOrder), Params{Reproducible: true})
+	shared := generateGoShared(t, fixture(getOrder), Params{Reproducible: true})
+	if strings.Contains(out, "HTTPHandlers") || strings.Contains(out, `"net/http"`) || strings.Contains(shared, "httpUnaryHandler") {
+		t.Error("default output has HTTP handlers")
+	}
+
+	out = generateGo(t, fixture(getOrder), Params{Reproducible: true, HTTPGateway: true})
+	for _, want := range []string{
+		`"net/http"`,
+		"func RegisterOrderServiceHTTPHandlers(mux *http.ServeMux, client OrderServiceNatsClientInterface) {",
+		`mux.Handle("GET /v1/orders/{id}", httpUnaryHandler(httpRoute{`,
+		`{field: "id", segments: []string{"{id}"}},`,
+		// Additional bindings
+		`mux.Handle("POST /v1/orders:get", httpUnaryHandler(httpRoute{`,
+		`mux.Handle("GET /v1/orders:watch", httpStreamHandler(httpRoute{`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("http_gateway=true output missing %q", want)
+		}
+	}
+	if strings.Contains(out, `mux.Handle("GET /v1/internal"`) {
+		t.Error("skipped method served over HTTP")
+	}
+	shared = generateGoShared(t, fixture(getOrder), Params{Reproducible: true, HTTPGateway: true})
+	for _, want := range []string{
+		"func httpUnaryHandler(rt httpRoute,",
+		"func httpStreamHandler(rt httpRoute,",
+		"func writeHTTPError(w http.ResponseWriter, err error) {",
+	} {
+		if !strings.Contains(shared, want) {
+			t.Errorf("http_gateway=true shared file missing %q", want)
+		}
+	}
+
+	// Bindings the handlers cannot serve fail generation
+	for name, rule := range map[string]*annotations.HttpRule{
+		"unknown path field":     {Pattern: &annotations.HttpRule_Get{Get: "/v1/orders/{order_id}"}},
+		"verb after a variable":  {Pattern: &annotations.HttpRule_Post{Post: "/v1/orders/{id}:cancel"}},
+		"scalar body field":      {Pattern: &annotations.HttpRule_Post{Post: "/v1/orders"}, Body: "id"},
+		"unknown response field": {Pattern: &annotations.HttpRule_Get{Get: "/v1/orders"}, ResponseBody: "order"},
+	} {
+		gen := goPlugin(t, fixture(rule))
+		if err := validateHTTPGateway(gen.Files[len(gen.Files)-1]); err == nil {
+			t.Errorf("%s: validateHTTPGateway accepted %v", name, rule)
+		}
+	}
+}
+
+func TestGenerateFuzzHelpers(t *testing.T) {
+	fixture := func() *descriptorpb.FileDescriptorSet {
+		return lintFixture(lintService("OrderService", "api.orders",
+			lintMethod("GetOrder", nil),
+			lintMethod("Internal", func(o *descriptorpb.MethodOptions) {
+				proto.SetExtension(o, natspb.E_Endpoint, &natspb.EndpointOptions{Skip: true})
+			}),
+		))
+	}
+	out := generateGo(t, fixture(), Params{Reproducible: true})
+	shared := generateGoShared(t, fixture(), Params{Reproducible: true})
+	if strings.Contains(out, "NewRandom") || strings.Contains(out, `"math/rand"`) || strings.Contains(shared, "func RandomMessage") {
+		t.Error("default output has fuzz helpers")
+	}
+
+	out = generateGo(t, fixture(), Params{Reproducible: true, FuzzHelpers: true})
+	for _, want := range []string{
+		`"math/rand"`,
+		// A constructor for each message of the file
+		"func NewRandomReq(r *rand.Rand) *Req {\n\treturn RandomMessage(r, &Req{})\n}",
+		"func NewRandomResp(r *rand.Rand) *Resp {",
+		"func OrderServiceRandomRequestFor(method string, r *rand.Rand) (proto.Message, error) {",
+		"case \"GetOrder\":\n\t\treq = &Req{}",
+		`return nil, fmt.Errorf("OrderService has no method %q", method)`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("fuzz_helpers=true output missing %q", want)
+		}
+	}
+	if strings.Contains(out, `case "Internal":`) {
+		t.Error("skipped method has a random request")
+	}
+	shared = generateGoShared(t, fixture(), Params{Reproducible: true, FuzzHelpers: true})
+	for _, want := range []string{
+		"func RandomMessage[T proto.Message](r *rand.Rand, msg T) T {",
+		"func randomRequest(r *rand.Rand, req proto.Message) (proto.Message, error) {\n\tfillRandom(r, req.ProtoReflect(), 0)\n\treturn req, nil\n}",
+	} {
+		if !strings.Contains(shared, want) {
+			t.Errorf("fuzz_helpers=true shared file missing %q", want)
+		}
+	}
+	// With validation, requests are redrawn until they pass
+	shared = generateGoShared(t, fixture(), Params{Reproducible: true, FuzzHelpers: true, Validate: true})
+	if want := "if err = validateRequest(req); err == nil {"; !strings.Contains(shared, want) {
+		t.Errorf("fuzz_helpers=true,validate=true shared file missing %q", want)
+	}
+}
+
+func TestGenerateBench(t *testing.T) {
+	build := func(params Params) (string, string) {
+		svc := lintService("JobService", "api.jobs")
+		set := lintFixture(svc)
+		method := emptyFixture(set)
+		notify := lintMethod("Notify", func(o *descriptorpb.MethodOptions) {
+			proto.SetExtension(o, natspb.E_Endpoint, &natspb.EndpointOptions{FireAndForget: true})
+		})
+		watch := lintMethod("Watch", nil)
+		watch.ServerStreaming = proto.Bool(true)
+		svc.Method = []*descriptorpb.MethodDescriptorProto{method("Count", true, false), method("Get", false, false), notify, watch}
+		return generateGo(t, set, params), generateGoShared(t, set, params)
+	}
+	out, shared := build(Params{Reproducible: true, EmptyShortcuts: true})
+	if strings.Contains(out, "JobServiceBench") || strings.Contains(shared, "BenchConfig") {
+		t.Error("default output has load tests")
+	}
+
+	out, shared = build(Params{Reproducible: true, EmptyShortcuts: true, Bench: true})
+	for _, want := range []string{
+		"func NewJobServiceBench(client JobServiceNatsClientInterface) *JobServiceBench {",
+		// Requests come from the factory, through the generated client
+		"func (b *JobServiceBench) RunGet(ctx context.Context, cfg BenchConfig, newReq func(i int) *Req) (BenchReport, error) {",
+		"_, err := b.client.Get(ctx, req)",
+		// Empty requests need no factory
+		"func (b *JobServiceBench) RunCount(ctx context.Context, cfg BenchConfig) (BenchReport, error) {",
+		"return runBench(ctx, \"Notify\", cfg, newReq, func(ctx context.Context, req *Req) error {\n\t\treturn b.client.Notify(ctx, req)",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("bench=true output missing %q", want)
+		}
+	}
+	if strings.Contains(out, "RunWatch") {
+		t.Error("streaming method has a load test")
+	}
+	for _, want := range []string{
+		"func runBench[Req any](ctx context.Context, method string, cfg BenchConfig, newReq func(i int) Req, call func(context.Context, Req) error) (BenchReport, error) {",
+		"func (r BenchReport) WriteJSON(w io.Writer) error {",
+		"P99  time.Duration `json:\"p99_ns\"`",
+	} {
+		if !strings.Contains(shared, want) {
+			t.Errorf("bench=true shared file missing %q", want)
+		}
+	}
+}
+
+func TestGenerateObjectStoreStreaming(t *testing.T) {
+	fixture := lintFixture(lintService("ReportService", "api.reports", lintMethod("RenderReport", func(o *descriptorpb.MethodOptions) {
+		proto.SetExtension(o, natspb.E_ObjectStore, &natspb.ObjectStoreOptions{Bucket: "reports", KeyTemplate: "r.{id}"})
+	})))
+	out := generateGo(t, fixture, Params{Reproducible: true})
+	for _, want := range []string{
+		"objBody := &objectBody{}",
+		"ctx = context.WithValue(ctx, objectBodyKey{}, objBody)",
+		"putObject(ctx, obj, objKey, data, objBody.reader)",
+		"GetRenderReportReaderFromObjectStore(ctx context.Context, key string) (io.ReadCloser, *ObjectInfo[*Resp], error) {\n\treturn c.OpenRenderReportFromObjectStore(ctx, key)\n}",
+		"PutRenderReportReaderToObjectStore(ctx context.Context, key string, val *Resp, body io.Reader) error\n",
+		"OpenRenderReportFromObjectStore(ctx context.Context, key string) (io.ReadCloser, *ObjectInfo[*Resp], error)\n",
+		"meta, data, body, err := getObject(ctx, c.js, obj, key)",
+		"if errors.Is(err, jetstream.ErrObjectNotFound) {",
+		"return c.PutRenderReportReaderToObjectStore(ctx, key, val, nil)",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output missing %q", want)
+		}
+	}
+	if strings.Contains(out, "obj.PutBytes(") || strings.Contains(out, "obj.GetBytes(") {
+		t.Error("Object Store calls bypass the body framing")
+	}
+
+	shared := generateGoShared(t, fixture, Params{Reproducible: true})
+	for _, want := range []string{
+		`const ObjectFramingHeader = "Nats-Micro-Framing"`,
+		"func SetObjectBody(ctx context.Context, body io.Reader) bool {",
+		"func putObject(ctx context.Context, obj jetstream.ObjectStore, key string, response []byte, body io.Reader) error {",
+		"func getObject(ctx context.Context, js jetstream.JetStream, obj jetstream.ObjectStore, key string) (info *jetstream.ObjectInfo, response []byte, body io.ReadCloser, err error) {",
+		// Chunks are pulled as the body is read, not pushed as fast as the server sends them
+		"r.chunks, err = cons.Messages(jetstream.PullMaxMessages(objectReadAhead))",
+	} {
+		if !strings.Contains(shared, want) {
+			t.Errorf("shared file missing %q", want)
+		}
+	}
+	if strings.Contains(shared, "obj.Get(") {
+		t.Error("Object Store reads subscribe to the chunks without flow control")
+	}
+}
+
+func TestGenerateServiceGroup(t *testing.T) {
+	fixture := lintFixture(lintService("OrderService", "api.orders", lintMethod("GetOrder", nil)))
+	out := generateGo(t, fixture, Params{Reproducible: true})
+	for _, want := range []string{
+		// Endpoints are qualified with the service name within a group
+		"if cfg.group != nil {\n\t\t\treturn cfg.group.host, true, nil",
+		`endpointPrefix = "order_service-"`,
+		`"get_order": pool.wrap(endpointPrefix+"get_order", idempotency.wrap(endpointPrefix+"get_order", micro.HandlerFunc(handlers.GetOrder))),`,
+		`svc.AddEndpoint(endpointPrefix+"health", holdRequests(cfg.hold, newHealthHandler(impl, cfg.timeout)), micro.WithEndpointSubject(subject))`,
+		`metadata = mergeMetadata(metadata, map[string]string{"schema_hash": OrderServiceSchemaHash})`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output missing %q", want)
+		}
+	}
+	shared := generateGoShared(t, fixture, Params{Reproducible: true})
+	for _, want := range []string{
+		"func NewServiceGroup(nc *nats.Conn, name, version string, opts ...RegisterOption) (*ServiceGroup, error) {",
+		"func WithServiceGroup(group *ServiceGroup) RegisterOption {",
+		// Registered services and groups share one lifecycle
+		"func RunServices(ctx context.Context, drainTimeout time.Duration, services ...Drainer) error {",
+	} {
+		if !strings.Contains(shared, want) {
+			t.Errorf("shared file missing %q", want)
+		}
+	}
+}
+
+func TestGenerateServiceConfigOptions(t *testing.T) {
+	fixture := lintFixture(lintService("OrderService", "api.orders", lintMethod("GetOrder", nil), lintMethod("ListOrders", nil)))
+	out := generateGo(t, fixture, Params{Reproducible: true})
+	for _, want := range []string{
+		// WithEndpointMetadata names a generated method and overrides the proto metadata
+		"endpointMethods := map[string]bool{\n\t\t\"GetOrder\":   true,\n\t\t\"ListOrders\": true,\n\t}",
+		`return nil, fmt.Errorf("WithEndpointMetadata: OrderService has no method %s", method)`,
+		`}), cfg.endpointMetadata["ListOrders"]),`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output missing %q", want)
+		}
+	}
+	out = generateGo(t, fixture, Params{Reproducible: true, ServiceOptions: true})
+	if !strings.Contains(out, "func WithOrderServiceEndpointMetadata(method string, metadata map[string]string) OrderServiceRegisterOption {") {
+		t.Error("service_options has no WithOrderServiceEndpointMetadata")
+	}
+
+	shared := generateGoShared(t, fixture, Params{Reproducible: true})
+	for _, want := range []string{
+		"func WithEndpointMetadata(method string, metadata map[string]string) RegisterOption {",
+		// Bad versions are explained before micro.AddService rejects them
+		"if !serviceVersionRegexp.MatchString(cfg.version) {",
+		`version %q is not a semantic version such as 1.2.3`,
+	} {
+		if !strings.Contains(shared, want) {
+			t.Errorf("shared file missing %q", want)
+		}
+	}
+	if check, add := strings.Index(shared, "serviceVersionRegexp.MatchString"), strings.Index(shared, "micro.AddService(nc, micro.Config{"); check < 0 || check > add {
+		t.Error("version is checked after the service is added")
+	}
+}
+
+func TestGenerateAddEndpoints(t *testing.T) {
+	fixture := lintFixture(lintService("OrderService", "api.orders", lintMethod("GetOrder", nil)))
+	out := generateGo(t, fixture, Params{Reproducible: true})
+	for _, want := range []string{
+		"func AddOrderServiceEndpoints(nc *nats.Conn, svc micro.Service, impl OrderServiceNats, opts ...RegisterOption) error {",
+		// Register and Add share the endpoint registration
+		"return registerOrderServiceEndpoints(nc, impl, cfg, func() (*serviceHost, bool, error) {",
+		"host, err := existingServiceHost(nc, svc, cfg)",
+		"func registerOrderServiceEndpoints(nc *nats.Conn, impl OrderServiceNats, cfg *registerConfig, attach func() (*serviceHost, bool, error)) (_ OrderServiceService, err error) {",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output missing %q", want)
+		}
+	}
+
+	out = generateGo(t, fixture, Params{Reproducible: true, ServiceOptions: true})
+	if !strings.Contains(out, "func AddOrderServiceEndpoints(nc *nats.Conn, svc micro.Service, impl OrderServiceNats, opts ...OrderServiceRegisterOption) error {") {
+		t.Error("Add does not take the service's own options with service_options")
+	}
+
+	shared := generateGoShared(t, fixture, Params{Reproducible: true})
+	for _, want := range []string{
+		"func existingServiceHost(nc *nats.Conn, svc micro.Service, cfg *registerConfig) (*serviceHost, error) {",
+		`{cfg.name != "", "WithName"},`,
+		`{cfg.version != "", "WithVersion"},`,
+		`{cfg.group != nil, "WithServiceGroup"},`,
+	} {
+		if !strings.Contains(shared, want) {
+			t.Errorf("shared file missing %q", want)
+		}
+	}
+}
+
+func TestGenerateRegisterGroup(t *testing.T) {
+	fixture := lintFixture(lintService("OrderService", "api.orders", lintMethod("GetOrder", nil)))
+	for _, params := range []Params{{Reproducible: true}, {Reproducible: true, ServiceOptions: true}} {
+		out := generateGo(t, fixture, params)
+		opts := "RegisterOption"
+		if params.ServiceOptions {
+			opts = "OrderServiceRegisterOption"
+		}
+		for _, want := range []string{
+			"func OrderServiceRegistration(impl OrderServiceNats, opts ..." + opts + ") Registration {",
+			"return RegisterOrderServiceHandlers(nc, impl, append(opts[:len(opts):len(opts)], withRequestHold(hold))...)",
+			"(_ OrderServiceService, err error) {",
+			// Every endpoint waits for the group, and a failed registration stops its service
+			"adder.AddEndpoint(endpointPrefix+name, holdRequests(cfg.hold, handler), opts...)",
+			"if err != nil {\n\t\t\t\thost.svc.Stop()",
+		} {
+			if !strings.Contains(out, want) {
+				t.Errorf("ServiceOptions=%v: output missing %q", params.ServiceOptions, want)
+			}
+		}
+	}
+	shared := generateGoShared(t, fixture, Params{Reproducible: true})
+	for _, want := range []string{
+		"type GroupService = interface {",
+		"Register(nc *nats.Conn, hold func(context.Context) error) (GroupService, error)",
+		"func RegisterGroup(nc *nats.Conn, registrations ...Registration) (*RegisteredGroup, error) {",
+		"close(gate.abort)",
+		`return Statusf(CodeUnavailable, "service registration was rolled back")`,
+		"func (g *RegisteredGroup) Run(ctx context.Context, drainTimeout time.Duration) error {",
+	} {
+		if !strings.Contains(shared, want) {
+			t.Errorf("shared file missing %q", want)
+		}
+	}
+}
+
+func TestGenerateJournal(t *testing.T) {
+	fixture := func() *descriptorpb.FileDescriptorSet {
+		return lintFixture(lintService("OrderService", "api.orders",
+			lintMethod("GetOrder", nil),
+			lintMethod("Notify", func(o *descriptorpb.MethodOptions) {
+				proto.SetExtension(o, natspb.E_Endpoint, &natspb.EndpointOptions{FireAndForget: true})
+			}),
+		))
+	}
+	// Unary and fire-and-forget calls are journaled once, after retries
+	out := generateGo(t, fixture(), Params{Reproducible: true})
+	for _, want := range []string{
+		`c.journal.record("OrderService", method, c.subjectPrefix+".get_order", c.outgoingHeaders(parentCtx), req, c.useJSON, false, start, err)`,
+		`c.journal.record("OrderService", method, c.subjectPrefix+".notify", c.outgoingHeaders(ctx), req, c.useJSON, false, start, err)`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output missing %q", want)
+		}
+	}
+	shared := generateGoShared(t, fixture(), Params{Reproducible: true})
+	for _, want := range []string{
+		"func WithJournal(w io.Writer) NatsClientOption {",
+		"func ReadJournalRecord(r io.Reader) (*JournalRecord, error) {",
+		"func RedactMessage(msg proto.Message) proto.Message {",
+	} {
+		if !strings.Contains(shared, want) {
+			t.Errorf("shared file missing %q", want)
+		}
+	}
+}
+
+func TestGenerateMetrics(t *testing.T) {
+	fixture := func() *descriptorpb.FileDescriptorSet {
+		return lintFixture(lintService("OrderService", "api.orders", lintMethod("GetOrder", nil)))
+	}
+	if out := generateGoShared(t, fixture(), Params{Reproducible: true}); strings.Contains(out, "prometheus") {
+		t.Error("default shared file imports Prometheus")
+	}
+	out := generateGoShared(t, fixture(), Params{Reproducible: true, Metrics: "prometheus"})
+	for _, want := range []string{
+		`"github.com/prometheus/client_golang/prometheus"`,
+		"func NewPrometheusServerInterceptor(reg prometheus.Registerer) UnaryServerInterceptor {",
+		"func NewPrometheusClientInterceptor(reg prometheus.Registerer) UnaryClientInterceptor {",
+		// Metric names the generated Grafana dashboards query
+		`newPromMetrics(reg, "nats_micro_", "handled")`,
+		`prefix + "request_duration_seconds"`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("metrics=prometheus shared file missing %q", want)
+		}
+	}
+}
+
+func TestGenerateStreamRecvErrors(t *testing.T) {
+	watch := lintMethod("WatchOrders", nil)
+	watch.ServerStreaming = proto.Bool(true)
+	upload := lintMethod("UploadOrders", nil)
+	upload.ClientStreaming = proto.Bool(true)
+	set := lintFixture(lintService("OrderService", "api.orders", watch, upload))
+	out := generateGo(t, set, Params{Reproducible: true})
+	// Handler errors keep their status on the wire and come back as service errors
+	for _, want := range []string{
+		"sender.closeWithStatus(err)",
+		"code, message, details := natsErrorFields(err)",
+		`return newOrderServiceError(code, "WatchOrders", message, details)`,
+		`return nil, newOrderServiceError(code, "UploadOrders", natsMsg.Header.Get("Nats-Service-Error"), details)`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output missing %q", want)
+		}
+	}
+	shared := generateGoShared(t, set, Params{Reproducible: true})
+	for _, want := range []string{
+		"var ErrStreamEOF = io.EOF",
+		"return nil, r.endOfStream()",
+		"return ErrStreamEOF",
+		`return nil, fmt.Errorf("%w: subscription closed", ErrStreamBroken)`,
+		"return errors.Is(err, ErrStreamEOF)",
+	} {
+		if !strings.Contains(shared, want) {
+			t.Errorf("shared file missing %q", want)
+		}
+	}
+	if strings.Contains(shared, `fmt.Errorf("EOF")`) {
+		t.Error("shared file still builds EOF from a string")
+	}
+}
+
+func TestGenerateStreamGaps(t *testing.T) {
+	watch := lintMethod("WatchOrders", nil)
+	watch.ServerStreaming = proto.Bool(true)
+	upload := lintMethod("UploadOrders", nil)
+	upload.ClientStreaming = proto.Bool(true)
+	set := lintFixture(lintService("OrderService", "api.orders", watch, upload))
+	out := generateGo(t, set, Params{Reproducible: true})
+	// Receivers on both sides check sequence numbers unless told to allow gaps
+	for _, want := range []string{
+		"receiver, err := newClientStreamReceiver(nc, inbox, c.streamAllowGaps)",
+		"receiver, err := newClientStreamReceiver(h.nc, inbox, h.streamAllowGaps)",
+		"m.Header.Set(natsStreamSeqHeader, strconv.Itoa(s.seq)) // Lets the server notice lost trailing messages",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output missing %q", want)
+		}
+	}
+	shared := generateGoShared(t, set, Params{Reproducible: true})
+	for _, want := range []string{
+		"return &ErrStreamMessageLost{Expected: expected, Got: seq}",
+		"func WithStreamAllowGaps() RegisterOption {",
+		"func WithClientStreamAllowGaps() NatsClientOption {",
+	} {
+		if !strings.Contains(shared, want) {
+			t.Errorf("shared file missing %q", want)
+		}
+	}
+}
+
+func TestGeneratePythonStreams(t *testing.T) {
+	watch := lintMethod("WatchOrders", nil)
+	watch.ServerStreaming = proto.Bool(true)
+	upload := lintMethod("UploadOrders", nil)
+	upload.ClientStreaming = proto.Bool(true)
+	sync := lintMethod("SyncOrders", nil)
+	sync.ClientStreaming = proto.Bool(true)
+	sync.ServerStreaming = proto.Bool(true)
+	set := lintFixture(lintService("OrderService", "api.orders", watch, upload, sync))
+	set.File[0].Options = &descriptorpb.FileOptions{GoPackage: proto.String("example.com/fixture/v1")}
+	_, out := runPlugin(t, "language=python", set.File...)
+	// Every streaming kind gets a handler method, an endpoint and a client method
+	service := out["fixture/v1/service_nats_pb2.py"]
+	for _, want := range []string{
+		"        stream: StreamSender,\n",
+		"        stream: ClientStreamReceiver,\n        info: ServerInfo\n    ) -> pb.Resp:",
+		"        stream: BidiStream,\n        info: ServerInfo\n    ) -> None:",
+		"# Register UploadOrders endpoint (client-streaming)",
+		"# Register SyncOrders endpoint (bidirectional streaming)",
+		"await req.respond(b'', headers={STREAM_INBOX_HEADER: receiver.inbox})",
+		"await sender.close_with_error(code, message, details)",
+		"    ) -> ClientStream:",
+		"    ) -> BidiStream:",
+		`error_factory=lambda code, message, data: OrderServiceError(code, "WatchOrders", message, data),`,
+	} {
+		if !strings.Contains(service, want) {
+			t.Errorf("Python output missing %q", want)
+		}
+	}
+	// The stream types live in the shared file, on the wire protocol of the Go output
+	shared := out["fixture/v1/shared_nats_pb2.py"]
+	for _, want := range []string{
+		"class StreamSender:",
+		"class ClientStreamReceiver:",
+		"class ClientStream:",
+		"class BidiStream:",
+		`STREAM_CANCEL_HEADER = "Nats-Stream-Cancel"`,
+		`headers={STREAM_END_HEADER: "true", STREAM_SEQ_HEADER: str(self._seq)}`,
+		`headers={STREAM_END_HEADER: "true", ERROR_CODE_HEADER: code, ERROR_HEADER: message}`,
+	} {
+		if !strings.Contains(shared, want) {
+			t.Errorf("Python shared file missing %q", want)
+		}
+	}
+}
+
+func TestGenerateTypeScriptStreams(t *testing.T) {
+	watch := lintMethod("WatchOrders", nil)
+	watch.ServerStreaming = proto.Bool(true)
+	upload := lintMethod("UploadOrders", nil)
+	upload.ClientStreaming = proto.Bool(true)
+	sync := lintMethod("SyncOrders", nil)
+	sync.ClientStreaming = proto.Bool(true)
+	sync.ServerStreaming = proto.Bool(true)
+	set := lintFixture(lintService("OrderService", "api.orders", watch, upload, sync))
+	set.File[0].Options = &descriptorpb.FileOptions{GoPackage: proto.String("example.com/fixture/v1")}
+	_, out := runPlugin(t, "language=ts", set.File...)
+	// Every streaming kind gets a handler, an interface method and a client method
+	service := out["fixture/v1/service_nats.pb.ts"]
+	for _, want := range []string{
+		"uploadOrders(stream: ClientStreamReceiver<pb.Req>): Promise<pb.Resp>;",
+		"syncOrders(recvStream: ClientStreamReceiver<pb.Req>, sendStream: ServerStreamSender<pb.Resp>): Promise<void>;",
+		"ack.set(STREAM_INBOX_HEADER, receiver.inbox);",
+		"await sender.closeWithError(code, message, data);",
+		"h.set(REPLY_TO_HEADER, receiver.inbox);",
+		"uploadOrders(opts?: StreamCallOptions): Promise<ClientStream<pb.Req, pb.Resp>>;",
+		"syncOrders(opts?: StreamCallOptions): Promise<BidiStream<pb.Req, pb.Resp>>;",
+		"(code, message, data) => new OrderServiceError(code, 'UploadOrders', message, data)",
+		"const timeout = options?.timeout || 0; // milliseconds",
+	} {
+		if !strings.Contains(service, want) {
+			t.Errorf("TypeScript output missing %q", want)
+		}
+	}
+	if strings.Contains(service, "respondWith") {
+		t.Error("TypeScript output uses msg.respondWith, which nats.js does not provide")
+	}
+	// The stream types live in the shared file, on the wire protocol of the Go output
+	shared := out["fixture/v1/shared_nats.pb.ts"]
+	for _, want := range []string{
+		"export class StreamSender<T> {",
+		"export class ClientStreamReceiver<T> implements AsyncIterableIterator<T> {",
+		"export class ClientStream<TReq, TResp> {",
+		"export class BidiStream<TSend, TRecv> implements AsyncIterableIterator<TRecv> {",
+		"export const STREAM_CANCEL_HEADER = 'Nats-Stream-Cancel';",
+	} {
+		if !strings.Contains(shared, want) {
+			t.Errorf("TypeScript shared file missing %q", want)
+		}
+	}
+}
+
+func TestGenerateWebTSTransport(t *testing.T) {
+	get := lintMethod("GetOrder", nil)
+	watch := lintMethod("WatchOrders", nil)
+	watch.ServerStreaming = proto.Bool(true)
+	set := lintFixture(lintService("OrderService", "api.orders", get, watch))
+	set.File[0].Options = &descriptorpb.FileOptions{GoPackage: proto.String("example.com/fixture/v1")}
+	_, out := runPlugin(t, "language=web-ts", set.File...)
+	// Clients take a raw connection or a transport, and stream through it
+	service := out["fixture/v1/service_nats.pb.ts"]
+	for _, want := range []string{
+		"constructor(nc: NatsConnection | ClientTransport, options?: OrderServiceClientOptions) {",
+		"this.transport = toClientTransport(nc);",
+		"const msg = await this.transport.request(subject, data, requestOpts);",
+		"this.transport.createInbox(),",
+		"export class OrderServiceError extends NatsServiceError {",
+	} {
+		if !strings.Contains(service, want) {
+			t.Errorf("web-ts output missing %q", want)
+		}
+	}
+	if strings.Contains(service, "this.nc.") {
+		t.Error("web-ts client still uses the connection directly")
+	}
+	// The transport and the typed errors live in the shared file
+	shared := out["fixture/v1/shared_nats.pb.ts"]
+	for _, want := range []string{
+		"export class NatsTransport implements ClientTransport {",
+		"onAuthExpired?: () => Promise<string>;",
+		"export class NotFoundError extends NatsServiceError {",
+		"return hasCode(value, 'NOT_FOUND');",
+		"export class ClientStreamReceiver<T> implements AsyncIterableIterator<T> {",
+	} {
+		if !strings.Contains(shared, want) {
+			t.Errorf("web-ts shared file missing %q", want)
+		}
+	}
+}
+
+func TestGenerateVersionInSubject(t *testing.T) {
+	svc := lintService("OrderService", "", lintMethod("GetOrder", nil))
+	svc.Options = &descriptorpb.ServiceOptions{}
+	proto.SetExtension(svc.Options, natspb.E_Service, &natspb.ServiceOptions{SubjectPrefix: "api.orders", Version: "2.1.0", VersionInSubject: true})
+	set := lintFixture(svc)
+	out := generateGo(t, set, Params{Reproducible: true})
+	// The server derives the token from its version, the client from the one it calls
+	for _, want := range []string{
+		"cfg.subjectPrefix = versionedSubjectPrefix(cfg.subjectPrefix, cfg.version)",
+		`version := "2.1.0"`,
+		"// Subject prefix: api.orders.v2",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output missing %q", want)
+		}
+	}
+	shared := generateGoShared(t, set, Params{Reproducible: true})
+	if !strings.Contains(shared, "func WithServiceVersion(version string) NatsClientOption {") {
+		t.Error("shared file missing WithServiceVersion")
+	}
+
+	// Without the option nothing is versioned
+	if out := generateGo(t, lintFixture(lintService("OrderService", "api.orders", lintMethod("GetOrder", nil))), Params{Reproducible: true}); strings.Contains(out, "versionedSubjectPrefix(") {
+		t.Error("output versions the subject without version_in_subject")
+	}
+}
+
+func TestGenerateStreamPersistence(t *testing.T) {
+	export := lintMethod("ExportOrders", func(o *descriptorpb.MethodOptions) {
+		proto.SetExtension(o, natspb.E_Stream, &natspb.StreamOptions{Persistence: true, PersistenceTtl: durationpb.New(10 * time.Minute)})
+	})
+	export.ServerStreaming = proto.Bool(true)
+	watch := lintMethod("WatchOrders", nil)
+	watch.ServerStreaming = proto.Bool(true)
+	out := generateGo(t, lintFixture(lintService("OrderService", "api.orders", export, watch)), Params{Reproducible: true})
+	// Only the persistent method writes to JetStream; its client resumes from there
+	for _, want := range []string{
+		`persistentStreams["ExportOrders"], err = newPersistentStream(context.Background(), cfg.js, cfg.subjectPrefix+".export_orders", 600000000000*time.Nanosecond)`,
+		`sender := h.persistentStreams["ExportOrders"].open(ctx, mintID(h.idGenerator))`,
+		"receiver, err := openPersistentStreamReceiver(ctx, c.js, reply)",
+		"func (s *OrderService_ExportOrders_ClientStream) ResumeFrom(ctx context.Context, seq uint64) error {",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output missing %q", want)
+		}
+	}
+	if strings.Contains(out, "OrderService_WatchOrders_ClientStream) ResumeFrom") || strings.Count(out, "sender := newServerStreamSender(h.nc, replySubject)") != 1 {
+		t.Error("WatchOrders is generated as a persistent stream")
+	}
+}
+
+func TestGeneratePagination(t *testing.T) {
+	field := func(name string, number int32, typ descriptorpb.FieldDescriptorProto_Type, label descriptorpb.FieldDescriptorProto_Label) *descriptorpb.FieldDescriptorProto {
+		return &descriptorpb.FieldDescriptorProto{Name: proto.String(name), Number: proto.Int32(number), Type: typ.Enum(), Label: label.Enum(), JsonName: proto.String(name)}
+	}
+	list := lintMethod("ListOrders", func(o *descriptorpb.MethodOptions) {
+		proto.SetExtension(o, natspb.E_Endpoint, &natspb.EndpointOptions{Paginated: true})
+	})
+	list.InputType, list.OutputType = proto.String(".fixture.v1.ListReq"), proto.String(".fixture.v1.ListResp")
+	set := lintFixture(lintService("OrderService", "api.orders", list))
+	set.File[0].MessageType = append(set.File[0].MessageType,
+		&descriptorpb.DescriptorProto{Name: proto.String("ListReq"), Field: []*descriptorpb.FieldDescriptorProto{
+			field("page_token", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING, descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL),
+		}},
+		&descriptorpb.DescriptorProto{Name: proto.String("ListResp"), Field: []*descriptorpb.FieldDescriptorProto{
+			field("ids", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING, descriptorpb.FieldDescriptorProto_LABEL_REPEATED),
+			field("next_page_token", 2, descriptorpb.FieldDescriptorProto_TYPE_STRING, descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL),
+		}},
+	)
+	out := generateGo(t, set, Params{Reproducible: true})
+	for _, want := range []string{
+		"ListOrdersAll(ctx context.Context, req *ListReq, opts ...CallOption) iter.Seq2[string, error]",
+		"func(req *ListReq, token string) { req.PageToken = token },",
+		"func(resp *ListResp) ([]string, string) { return resp.Ids, resp.NextPageToken })",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output missing %q", want)
+		}
+	}
+}
+
+func TestGenerateIDGenerator(t *testing.T) {
+	unary := lintMethod("GetOrder", nil)
+	watch := lintMethod("WatchOrders", nil)
+	watch.ServerStreaming = proto.Bool(true)
+	upload := lintMethod("UploadOrders", nil)
+	upload.ClientStreaming = proto.Bool(true)
+	fixture := lintFixture(lintService("OrderService", "api.orders", unary, watch, upload))
+	out := generateGo(t, fixture, Params{Reproducible: true})
+	for _, want := range []string{
+		"propagateCancel(invokerCtx, nc, headers, mintID(c.idGenerator))",
+		"receiver.enableFlowControl(nc, c.streamWindow, msg.Header, mintInbox(c.idGenerator))",
+		"inbox := mintInbox(h.idGenerator)",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output missing %q", want)
+		}
+	}
+	if shared := generateGoShared(t, fixture, Params{Reproducible: true}); !strings.Contains(shared, "inflight := newInflightTracker(withIDGenerator(context.Background(), cfg.idGenerator))") {
+		t.Error("shared file does not hand handlers the ID generator")
+	}
+	if strings.Contains(out, "nats.NewInbox()") {
+		t.Error("output mints an inbox without the ID generator")
+	}
+}
+
+func TestGenerateStreamDrain(t *testing.T) {
+	watch := lintMethod("WatchOrders", nil)
+	watch.ServerStreaming = proto.Bool(true)
+	chat := lintMethod("Chat", nil)
+	chat.ClientStreaming = proto.Bool(true)
+	chat.ServerStreaming = proto.Bool(true)
+	fixture := lintFixture(lintService("OrderService", "api.orders", watch, chat))
+	out := generateGo(t, fixture, Params{Reproducible: true})
+	// Server and bidi streams get a GOAWAY; the client's acknowledgement ends them
+	if n := strings.Count(out, "sender.goAway(deadline, mintInbox(h.idGenerator), func() {"); n != 2 {
+		t.Errorf("output sends a GOAWAY on %d streams, want 2", n)
+	}
+	for _, want := range []string{
+		"func (s *OrderService_WatchOrders_Stream) SetResumeToken(token string) {",
+		"func (s *OrderService_WatchOrders_ClientStream) ResumeToken() string {",
+		"return c.openWatchOrders(ctx, req, append(opts[:len(opts):len(opts)], WithResumeFrom(token))...)",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output missing %q", want)
+		}
+	}
+	shared := generateGoShared(t, fixture, Params{Reproducible: true})
+	for _, want := range []string{
+		"func WithStreamDrainGrace(grace time.Duration) RegisterOption {",
+		"func WithClientStreamResumeOnDrain() NatsClientOption {",
+		"type ErrServerDraining struct {",
+	} {
+		if !strings.Contains(shared, want) {
+			t.Errorf("shared file missing %q", want)
+		}
+	}
+}
+
+func TestGenerateRequestImmutabilityCheck(t *testing.T) {
+	get := lintMethod("GetOrder", nil)
+	notify := lintMethod("NotifyOrder", func(o *descriptorpb.MethodOptions) {
+		proto.SetExtension(o, natspb.E_Endpoint, &natspb.EndpointOptions{FireAndForget: true})
+	})
+	fixture := lintFixture(lintService("OrderService", "api.orders", get, notify))
+	out := generateGo(t, fixture, Params{Reproducible: true})
+	// The check brackets the implementation call, inside the interceptor chain
+	for _, want := range []string{
+		`defer h.requestCheck.check("OrderService.GetOrder", typedReq)()
+		return h.impl.GetOrder(ctx, typedReq)`,
+		`defer h.requestCheck.check("OrderService.NotifyOrder", typedReq)()
+			return nil, h.impl.NotifyOrder(ctx, typedReq)`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output missing %q", want)
+		}
+	}
+	shared := generateGoShared(t, fixture, Params{Reproducible: true})
+	for _, want := range []string{
+		"func WithRequestImmutabilityCheck(reporter RequestMutationReporter, maxBytes int) RegisterOption {",
+		"if c.maxBytes > 0 && proto.Size(req) > c.maxBytes {",
+	} {
+		if !strings.Contains(shared, want) {
+			t.Errorf("shared file missing %q", want)
+		}
+	}
+}
+
+func TestGeneratePanicDiagnostics(t *testing.T) {
+	get := lintMethod("GetOrder", nil)
+	notify := lintMethod("NotifyOrder", func(o *descriptorpb.MethodOptions) {
+		proto.SetExtension(o, natspb.E_Endpoint, &natspb.EndpointOptions{FireAndForget: true})
+	})
+	fixture := lintFixture(lintService("OrderService", "api.orders", get, notify))
+	out := generateGo(t, fixture, Params{Reproducible: true})
+	// Panics are recovered around the implementation, inside the interceptor chain
+	for _, want := range []string{
+		`handler = h.panics.wrap("OrderService", "GetOrder", "fixture.v1.OrderService.GetOrder", false, handler)`,
+		`handler = h.panics.wrap("OrderService", "NotifyOrder", "fixture.v1.OrderService.NotifyOrder", false, handler)`,
+		"panics:            cfg.panicDiagnostics,",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output missing %q", want)
+		}
+	}
+	shared := generateGoShared(t, fixture, Params{Reproducible: true})
+	for _, want := range []string{
+		"func WithPanicDiagnostics(maxDumps int, sink func(PanicReport)) RegisterOption {",
+		"if d.sink == nil || (d.maxReports > 0 && d.reported >= d.maxReports) {",
+		`pprof.Lookup("goroutine").WriteTo(&goroutines, 2)`,
+	} {
+		if !strings.Contains(shared, want) {
+			t.Errorf("shared file missing %q", want)
+		}
+	}
+}
+
+func TestGenerateStreamInterceptors(t *testing.T) {
+	watch := lintMethod("WatchOrders", nil)
+	watch.ServerStreaming = proto.Bool(true)
+	upload := lintMethod("UploadOrders", nil)
+	upload.ClientStreaming = proto.Bool(true)
+	chat := lintMethod("Chat", nil)
+	chat.ClientStreaming = proto.Bool(true)
+	chat.ServerStreaming = proto.Bool(true)
+	fixture := lintFixture(lintService("OrderService", "api.orders", lintMethod("GetOrder", nil), watch, upload, chat))
+	out := generateGo(t, fixture, Params{Reproducible: true})
+	// Every stream handler and client constructor runs through the stream interceptors
+	if n := strings.Count(out, "err = interceptStream(ctx, h.streamInterceptor, stream, &StreamInfo{"); n != 3 {
+		t.Errorf("output intercepts %d stream handlers, want 3", n)
+	}
+	if n := strings.Count(out, "return openClientStream(ctx, c.streamInterceptor, info, "); n != 3 {
+		t.Errorf("output intercepts %d client streams, want 3", n)
+	}
+	for _, want := range []string{
+		`&StreamInfo{Service: "OrderService", Method: "Chat", FullMethod: "fixture.v1.OrderService.Chat", IsClientStream: true, IsServerStream: true}`,
+		"req, err := interceptedRecv[*Req](ctx, ss)",
+		"return ss.SendMsg(resp)",
+		"func (s *OrderService_UploadOrders_ClientStream) RecvMsg(ctx context.Context) (proto.Message, error) {",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output missing %q", want)
+		}
+	}
+	shared := generateGoShared(t, fixture, Params{Reproducible: true})
+	for _, want := range []string{
+		"func WithServerStreamInterceptor(interceptor StreamServerInterceptor) RegisterOption {",
+		"func WithClientStreamInterceptor(interceptor StreamClientInterceptor) NatsClientOption {",
+	} {
+		if !strings.Contains(shared, want) {
+			t.Errorf("shared file missing %q", want)
+		}
+	}
+}
+
+func TestGenerateStreamCancel(t *testing.T) {
+	upload := lintMethod("UploadOrders", nil)
+	upload.ClientStreaming = proto.Bool(true)
+	chat := lintMethod("Chat", nil)
+	chat.ClientStreaming = proto.Bool(true)
+	chat.ServerStreaming = proto.Bool(true)
+	out := generateGo(t, lintFixture(lintService("OrderService", "api.orders", upload, chat)), Params{Reproducible: true})
+	// Both stream kinds cancel the handler when the opening context ends
+	if n := strings.Count(out, "stopCancel: context.AfterFunc(ctx, func() { publishStreamCancel(nc, serverInbox, ctx.Err()) }),"); n != 2 {
+		t.Errorf("output watches the opening context in %d streams, want 2", n)
+	}
+	if n := strings.Count(out, "receiver.setCancelFunc(cancelStream)"); n != 2 {
+		t.Errorf("output cancels handlers on a cancel frame in %d streams, want 2", n)
+	}
+	if !strings.Contains(out, "publishStreamCancel(s.nc, s.sendTo, context.Canceled)") {
+		t.Error("bidi Close does not cancel the stream")
+	}
+}
+
+func TestGenerateStreamPipes(t *testing.T) {
+	watch := lintMethod("WatchOrders", nil)
+	watch.ServerStreaming = proto.Bool(true)
+	record := lintMethod("RecordOrders", nil)
+	record.InputType = proto.String(".fixture.v1.Resp")
+	record.ClientStreaming = proto.Bool(true)
+	upload := lintMethod("UploadOrders", nil) // Takes Req, so no pipe from WatchOrders
+	upload.ClientStreaming = proto.Bool(true)
+	chat := lintMethod("Chat", nil) // Bidirectional methods never pair
+	chat.InputType = proto.String(".fixture.v1.Resp")
+	chat.ClientStreaming = proto.Bool(true)
+	chat.ServerStreaming = proto.Bool(true)
+	set := lintFixture(lintService("OrderService", "api.orders", watch, record, upload, chat))
+
+	out := generateGo(t, set, Params{Reproducible: true})
+	want := "func PipeOrderService_WatchOrders_To_OrderService_RecordOrders(ctx context.Context, from *OrderService_WatchOrders_ClientStream, to *OrderService_RecordOrders_ClientStream, opts ...PipeOption) (int, error) {"
+	if !strings.Contains(out, want) {
+		t.Errorf("output missing %q", want)
+	}
+	if n := strings.Count(out, "func Pipe"); n != 1 {
+		t.Errorf("generated %d pipes, want 1", n)
+	}
+	shared := generateGoShared(t, lintFixture(lintService("OrderService", "api.orders", watch)), Params{Reproducible: true})
+	for _, want := range []string{
+		"func Pipe[T any](ctx context.Context, recv StreamReceiver[T], send StreamSender[T], opts ...PipeOption) (int, error) {",
+		"func PipeMap[In, Out any](",
+	} {
+		if !strings.Contains(shared, want) {
+			t.Errorf("shared file missing %q", want)
+		}
+	}
+}
+
+func TestGenerateHeaderPolicy(t *testing.T) {
+	watch := lintMethod("WatchOrders", nil)
+	watch.ServerStreaming = proto.Bool(true)
+	set := lintFixture(lintService("OrderService", "api.orders", lintMethod("GetOrder", nil), watch))
+	out := generateGo(t, set, Params{Reproducible: true})
+	// Response headers pass the policy, by endpoint, before the server adds its own
+	for _, want := range []string{
+		`outgoingHeaders = h.responseHeaders.strip(h.endpointPrefix+"get_order", outgoingHeaders)`,
+		`return h.responseHeaders.strip(h.endpointPrefix+"watch_orders", *outgoingHeadersPtr)`,
+		"withContentType(c.outgoingHeaders(invokerCtx)",
+		"if headers := c.outgoingHeaders(ctx); headers != nil {",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output missing %q", want)
+		}
+	}
+	// Only the client's outgoingHeaders reads them unfiltered
+	if n := len(regexp.MustCompile(`[^.\w]OutgoingHeaders\(`).FindAllString(out, -1)); n != 1 {
+		t.Errorf("client reads OutgoingHeaders in %d places, want 1", n)
+	}
+	shared := generateGoShared(t, set, Params{Reproducible: true})
+	for _, want := range []string{
+		"func WithResponseHeaderPolicy(allow, deny []string) RegisterOption {",
+		"func WithOutgoingHeaderPolicy(allow, deny []string) NatsClientOption {",
+		"type HeaderPolicyStats struct {",
+		"statsHandler = cfg.responseHeaders.statsHandler(cfg.statsHandler)",
+	} {
+		if !strings.Contains(shared, want) {
+			t.Errorf("shared file missing %q", want)
+		}
+	}
+}
+
+// generateGoShared runs GenerateShared over the last file in set and returns the shared file
+func TestGenerateServiceSchema(t *testing.T) {
+	// schemaOf returns the schema blob and hash generated for a fixture
+	schemaOf := func(set *descriptorpb.FileDescriptorSet) (string, string) {
+		t.Helper()
+		out := generateGo(t, set, Params{Reproducible: true})
+		var blob, hash string
+		for _, line := range strings.Split(out, "\n") {
+			if v, ok := strings.CutPrefix(line, "const orderServiceSchema = "); ok {
+				blob, _ = strconv.Unquote(v)
+			}
+			if v, ok := strings.CutPrefix(line, "const OrderServiceSchemaHash = "); ok {
+				hash, _ = strconv.Unquote(v)
+			}
+		}
+		if blob == "" || hash == "" {
+			t.Fatal("output missing the schema constants")
+		}
+		for _, want := range []string{
+			`schemaEndpointMetadata(orderServiceSchema, "fixture.v1.OrderService")`,
+			`mergeMetadata(cfg.metadata, map[string]string{"schema_hash": OrderServiceSchemaHash})`,
+			`"get_order": mergeMetadata(mergeMetadata(schemaMetadata["GetOrder"], map[string]string{`,
+			"newReflectHandler(orderServiceSchema, OrderServiceSchemaHash)",
+		} {
+			if !strings.Contains(out, want) {
+				t.Errorf("output missing %q", want)
+			}
+		}
+		return blob, hash
+	}
+
+	v1 := lintFixture(lintService("OrderService", "api.orders", lintMethod("GetOrder", nil)))
+	blob, hash := schemaOf(v1)
+	sum := sha256.Sum256([]byte(blob))
+	if want := "sha256:" + hex.EncodeToString(sum[:]); hash != want {
+		t.Errorf("hash = %s, want %s of the blob", hash, want)
+	}
+	var set descriptorpb.FileDescriptorSet
+	if err := proto.Unmarshal([]byte(blob), &set); err != nil {
+		t.Fatal(err)
+	}
+	if len(set.File) != 1 || set.File[0].GetService()[0].GetName() != "OrderService" || set.File[0].SourceCodeInfo != nil {
+		t.Errorf("schema = %v", &set)
+	}
+	if _, again := schemaOf(v1); again != hash {
+		t.Error("hash changed between identical generations")
+	}
+
+	// A new request field is a new schema
+	v2 := lintFixture(lintService("OrderService", "api.orders", lintMethod("GetOrder", nil)))
+	req := v2.File[0].MessageType[0]
+	req.Field = append(req.Field, &descriptorpb.FieldDescriptorProto{
+		Name:     proto.String("revision"),
+		Number:   proto.Int32(2),
+		Type:     descriptorpb.FieldDescriptorProto_TYPE_INT64.Enum(),
+		Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+		JsonName: proto.String("revision"),
+	})
+	if _, changed := schemaOf(v2); changed == hash {
+		t.Error("hash did not change with the proto")
+	}
+}
+
+func TestGenerateSchemaDocument(t *testing.T) {
+	watch := lintMethod("WatchOrders", nil)
+	watch.ServerStreaming = proto.Bool(true)
+	exact := lintMethod("Ping", func(o *descriptorpb.MethodOptions) {
+		proto.SetExtension(o, natspb.E_Endpoint, &natspb.EndpointOptions{Subject: "ops.ping"})
+	})
+	skipped := lintMethod("Internal", func(o *descriptorpb.MethodOptions) {
+		proto.SetExtension(o, natspb.E_Endpoint, &natspb.EndpointOptions{Skip: true})
+	})
+	fixture := lintFixture(lintService("OrderService", "api.orders", lintMethod("GetOrder", nil), watch, exact, skipped))
+	out := generateGo(t, fixture, Params{Reproducible: true})
+	for _, want := range []string{
+		"newSchemaHandler(orderServiceSchemaDocument, cfg)",
+		`schemaSubject(cfg.subjectPrefix, "order_service")`,
+		`FetchServiceSchema(ctx, c.conn(), schemaSubject(c.subjectPrefix, "order_service"))`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output missing %q", want)
+		}
+	}
+
+	var blob, document string
+	for _, line := range strings.Split(out, "\n") {
+		if v, ok := strings.CutPrefix(line, "const orderServiceSchema = "); ok {
+			blob, _ = strconv.Unquote(v)
+		}
+		if v, ok := strings.CutPrefix(line, "const orderServiceSchemaDocument = "); ok {
+			document, _ = strconv.Unquote(v)
+		}
+	}
+	var doc schemaDocument
+	if err := json.Unmarshal([]byte(document), &doc); err != nil {
+		t.Fatalf("document %q: %v", document, err)
+	}
+	if doc.Service != "fixture.v1.OrderService" || !strings.HasPrefix(doc.SchemaHash, "sha256:") {
+		t.Errorf("document = %+v", doc)
+	}
+	want := []schemaDocumentMethod{
+		{Name: "GetOrder", Endpoint: "get_order", Streaming: "unary", RequestType: "fixture.v1.Req", ResponseType: "fixture.v1.Resp"},
+		{Name: "WatchOrders", Endpoint: "watch_orders", Streaming: "server", RequestType: "fixture.v1.Req", ResponseType: "fixture.v1.Resp"},
+		{Name: "Ping", Endpoint: "ping", Subject: "ops.ping", Streaming: "unary", RequestType: "fixture.v1.Req", ResponseType: "fixture.v1.Resp"},
+	}
+	if !reflect.DeepEqual(doc.Methods, want) {
+		t.Errorf("methods = %+v, want %+v", doc.Methods, want)
+	}
+
+	// The descriptor is the $reflect blob, gzipped
+	r, err := gzip.NewReader(bytes.NewReader(doc.Descriptor))
+	if err != nil {
+		t.Fatal(err)
+	}
+	unzipped, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(unzipped) != blob {
+		t.Error("descriptor does not decompress to the schema blob")
+	}
+}
+
+func TestGenerateDeadlineScheduling(t *testing.T) {
+	watch := lintMethod("WatchOrders", nil)
+	watch.ServerStreaming = proto.Bool(true)
+	fixture := lintFixture(lintService("OrderService", "api.orders", lintMethod("GetOrder", nil), watch))
+	out := generateGo(t, fixture, Params{Reproducible: true})
+	for _, want := range []string{
+		`"get_order": pool.wrap(endpointPrefix+"get_order", idempotency.wrap(endpointPrefix+"get_order", micro.HandlerFunc(handlers.GetOrder))),`,
+		`"watch_orders": micro.HandlerFunc(handlers.WatchOrders),`, // Streams are never queued
+		"setDeadlineHeaders(invokerCtx, headers)",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output missing %q", want)
+		}
+	}
+	shared := generateGoShared(t, fixture, Params{Reproducible: true})
+	if !strings.Contains(shared, "statsHandler = pool.statsHandler(statsHandler)") {
+		t.Error("shared file does not report scheduling stats")
+	}
+	if !strings.Contains(shared, "headers.Set(natsDeadlineHeader, deadline.UTC().Format(time.RFC3339Nano))") {
+		t.Error("shared file does not send the absolute deadline")
+	}
+}
+
+func TestGenerateDeadlinePropagation(t *testing.T) {
+	notify := lintMethod("NotifyOrder", func(o *descriptorpb.MethodOptions) {
+		proto.SetExtension(o, natspb.E_Endpoint, &natspb.EndpointOptions{FireAndForget: true})
+	})
+	watch := lintMethod("WatchOrders", nil)
+	watch.ServerStreaming = proto.Bool(true)
+	upload := lintMethod("UploadOrders", nil)
+	upload.ClientStreaming = proto.Bool(true)
+	chat := lintMethod("ChatOrders", nil)
+	chat.ClientStreaming, chat.ServerStreaming = proto.Bool(true), proto.Bool(true)
+	fixture := lintFixture(lintService("OrderService", "api.orders", lintMethod("GetOrder", nil), notify, watch, upload, chat))
+	out := generateGo(t, fixture, Params{Reproducible: true})
+
+	// Every handler derives its context from the propagated deadline, and every
+	// call and stream open sends it
+	if n := strings.Count(out, "ctx, cancelDeadline := withPropagatedDeadline(ctx, req.Headers(), h.maxServerDeadline)"); n != 3 {
+		t.Errorf("%d handlers take the propagated deadline, want 3", n)
+	}
+	// Client and bidi streams end as if the client's cancel frame arrived
+	if n := strings.Count(out, `expire := time.AfterFunc(timeout, func() { receiver.cancel("DEADLINE_EXCEEDED") })`); n != 2 {
+		t.Errorf("%d client streams end at the propagated deadline, want 2", n)
+	}
+	if n := strings.Count(out, "setDeadlineHeaders(invokerCtx, headers)"); n != 2 {
+		t.Errorf("%d unary calls send their deadline, want 2", n)
+	}
+	if n := strings.Count(out, "setDeadlineHeaders(ctx, msg.Header)"); n != 3 {
+		t.Errorf("%d stream opens send their deadline, want 3", n)
+	}
+
+	shared := generateGoShared(t, fixture, Params{Reproducible: true})
+	for _, want := range []string{
+		`const natsDeadlineMsHeader = "Nats-Deadline-Ms"`,
+		"func WithMaxServerDeadline(d time.Duration) RegisterOption {",
+		"if maxDeadline > 0 && timeout > maxDeadline {",
+	} {
+		if !strings.Contains(shared, want) {
+			t.Errorf("shared file missing %q", want)
+		}
+	}
+}
+
+func generateGoShared(t *testing.T, set *descriptorpb.FileDescriptorSet, params Params) string {
+	t.Helper()
+	for _, f := range set.File {
+		if f.Options == nil {
+			f.Options = &descriptorpb.FileOptions{GoPackage: proto.String("example.com/fixture/v1;fixturev1")}
+		}
+	}
+	last := len(set.File) - 1
+	gen, err := protogen.Options{}.New(&pluginpb.CodeGeneratorRequest{
+		FileToGenerate: []string{set.File[last].GetName()},
+		ProtoFile:      set.File,
+	})
+	if err != nil {
+		t.Fatalf("protogen: %v", err)
+	}
+	file := gen.Files[last]
+	lang := NewGoLanguage()
+	lang.SetParams(params)
+	if err := lang.GenerateShared(gen.NewGeneratedFile("shared_nats.pb.go", file.GoImportPath), file); err != nil {
+		t.Fatalf("GenerateShared: %v", err)
+	}
+	resp := gen.Response()
+	if resp.Error != nil {
+		t.Fatalf("generated code is not valid Go: %s", resp.GetError())
+	}
+	return resp.File[0].GetContent()
+}
+
+func TestGenerateMiddlewares(t *testing.T) {
+	withMiddlewares := func(names ...string) func(*descriptorpb.MethodOptions) {
+		return func(o *descriptorpb.MethodOptions) {
+			proto.SetExtension(o, natspb.E_Endpoint, &natspb.EndpointOptions{Middlewares: names})
+		}
+	}
+	out := generateGo(t, lintFixture(lintService("OrderService", "api.orders",
+		lintMethod("GetOrder", nil),
+		lintMethod("DeleteOrder", withMiddlewares("auth", "audit")),
+	)), Params{Reproducible: true})
+	for _, want := range []string{
+		`{"DeleteOrder", []string{"auth", "audit"}},`,
+		`interceptor = h.methodInterceptors["DeleteOrder"]`,
+		// InterceptorChain lists the middlewares after the service's interceptors
+		`case "DeleteOrder":
+		chain = append(chain, "auth", "audit")`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output missing %q", want)
+		}
+	}
+	if strings.Contains(out, `h.methodInterceptors["GetOrder"]`) {
+		t.Error("GetOrder uses method interceptors without middlewares")
+	}
+
+	plain := generateGo(t, lintFixture(lintService("OrderService", "api.orders", lintMethod("GetOrder", nil))), Params{Reproducible: true})
+	if strings.Contains(plain, "resolveMiddlewares(m.method") {
+		t.Error("service without middlewares resolves them")
+	}
+	if strings.Contains(plain, "switch method {") {
+		t.Error("service without middlewares adds them to InterceptorChain")
+	}
+}
+
+func TestGenerateRequiredScopes(t *testing.T) {
+	withScopes := func(scopes ...string) func(*descriptorpb.MethodOptions) {
+		return func(o *descriptorpb.MethodOptions) {
+			proto.SetExtension(o, natspb.E_Endpoint, &natspb.EndpointOptions{RequiredScopes: scopes})
+		}
+	}
+	watch := lintMethod("WatchOrders", withScopes("orders:watch"))
+	watch.ServerStreaming = proto.Bool(true)
+	out := generateGo(t, lintFixture(lintService("OrderService", "api.orders",
+		lintMethod("GetOrder", nil),
+		lintMethod("DeleteOrder", withScopes("orders:write", "admin")),
+		watch,
+	)), Params{Reproducible: true})
+	for _, want := range []string{
+		`MethodDescriptors["fixture.v1.OrderService.DeleteOrder"] = MethodDescriptor{`,
+		`RequiredScopes: []string{"orders:write", "admin"},`,
+		`RequiredScopes:  []string{"orders:watch"},`,
+		`ServerStreaming: true,`,
+		`FullMethod: "fixture.v1.OrderService.DeleteOrder",`,
+		`FullMethod: "fixture.v1.OrderService.WatchOrders", IsServerStream: true}`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output missing %q", want)
+		}
+	}
+	if n := strings.Count(out, "RequiredScopes:"); n != 2 {
+		t.Errorf("RequiredScopes set %d times, want 2 (GetOrder is public)", n)
+	}
+
+	err := generateGoErr(t, lintFixture(lintService("OrderService", "api.orders", lintMethod("GetOrder", withScopes("")))))
+	if err == nil || !strings.Contains(err.Error(), "required_scopes contains an empty scope") {
+		t.Errorf("empty scope: %v", err)
+	}
+}
+
+func TestGenerateSampling(t *testing.T) {
+	notify := lintMethod("NotifyOrder", func(o *descriptorpb.MethodOptions) {
+		proto.SetExtension(o, natspb.E_Endpoint, &natspb.EndpointOptions{FireAndForget: true})
+	})
+	fixture := lintFixture(lintService("OrderService", "api.orders", lintMethod("GetOrder", nil), notify))
+	out := generateGo(t, fixture, Params{Reproducible: true})
+	// Servers sample by request ID before the handler runs and record its outcome;
+	// clients mint the ID the server samples by
+	for _, want := range []string{
+		`sample := h.sampler.begin("OrderService", "GetOrder", incomingRequestID(req), false, nats.Header(req.Headers()), &msg, false)`,
+		`sample = h.sampler.begin("OrderService", "NotifyOrder", incomingRequestID(req), false, nats.Header(req.Headers()), &msg, false)`,
+		"sample.end(resp, err)",
+		"sample.end(nil, err)",
+		"ctx, requestID = withRequestID(ctx, c.idGenerator)",
+		"sample.end(&resp, err)",
+		"Reconfigure(opts ...RegisterOption) error",
+		"return reconfigureSampling(s.sampler, cfg)",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output missing %q", want)
+		}
+	}
+
+	// With service_options, Reconfigure takes the service's own option type
+	out = generateGo(t, fixture, Params{Reproducible: true, ServiceOptions: true})
+	if !strings.Contains(out, "Reconfigure(opts ...OrderServiceRegisterOption) error") {
+		t.Error("Reconfigure does not take OrderServiceRegisterOption with service_options")
+	}
+
+	shared := generateGoShared(t, fixture, Params{Reproducible: true})
+	for _, want := range []string{
+		`const RequestIDHeader = "X-Request-Id"`,
+		"func WithSampling(rate float64, sink func(Sample)) RegisterOption {",
+		"func WithClientSampling(rate float64, sink func(Sample)) NatsClientOption {",
+		"settings.threshold = uint64(config.rate * (1 << 64))",
+		`name != "sampling" && name != "samplingSalt"`,
+	} {
+		if !strings.Contains(shared, want) {
+			t.Errorf("shared file missing %q", want)
+		}
+	}
+}
+
+func TestGenerateConnectionMonitor(t *testing.T) {
+	fixture := lintFixture(lintService("OrderService", "api.orders", lintMethod("GetOrder", nil)))
+	out := generateGo(t, fixture, Params{Reproducible: true})
+	for _, want := range []string{
+		"startConnectionMonitor(cfg.connMonitor, monitoredConns(nc, cfg.poolConns)...),",
+		"c.poolConns = conns",
+		"func (c *OrderServiceNatsClient) Close() {",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output missing %q", want)
+		}
+	}
+
+	// Services sample from when they are added until they stop
+	shared := generateGoShared(t, fixture, Params{Reproducible: true})
+	for _, want := range []string{
+		"func WithConnectionMonitor(interval time.Duration, sink func(ConnectionSample)) RegisterOption {",
+		"func WithConnectionAlarm(thresholds ConnectionThresholds, alarm func(ConnectionSample)) RegisterOption {",
+		"func WithClientConnectionMonitor(interval time.Duration, sink func(ConnectionSample)) NatsClientOption {",
+		"monitor = startConnectionMonitor(cfg.connMonitor, nc)",
+		"monitor.close()",
+	} {
+		if !strings.Contains(shared, want) {
+			t.Errorf("shared file missing %q", want)
+		}
+	}
+	if strings.Contains(shared, "NewPrometheusConnectionSink") {
+		t.Error("Prometheus connection sink generated without metrics=prometheus")
+	}
+	shared = generateGoShared(t, fixture, Params{Reproducible: true, Metrics: "prometheus"})
+	if !strings.Contains(shared, "func NewPrometheusConnectionSink(reg prometheus.Registerer, connection string) func(ConnectionSample) {") {
+		t.Error("shared file missing NewPrometheusConnectionSink with metrics=prometheus")
+	}
+}
+
+func TestGenerateClientHeaderViews(t *testing.T) {
+	fixture := lintFixture(lintService("OrderService", "api.orders", lintMethod("GetOrder", nil)))
+	out := generateGo(t, fixture, Params{Reproducible: true})
+	// Views layer their headers beneath the context's, through the one place calls read them
+	for _, want := range []string{
+		"WithHeaders(headers nats.Header) OrderServiceNatsClientInterface\n",
+		"func (c *OrderServiceNatsClient) WithHeaders(headers nats.Header) OrderServiceNatsClientInterface {",
+		"c.headerPolicy.filter(layerHeaders(c.headers, OutgoingHeaders(ctx)))",
+		"view.monitor = nil",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output missing %q", want)
+		}
+	}
+}
+
+func TestGenerateScatterGather(t *testing.T) {
+	fixture := func(opts *natspb.EndpointOptions) *descriptorpb.FileDescriptorSet {
+		return lintFixture(lintService("OrderService", "api.orders",
+			lintMethod("GetOrder", nil),
+			lintMethod("GetStatus", func(o *descriptorpb.MethodOptions) {
+				proto.SetExtension(o, natspb.E_Endpoint, opts)
+			}),
+		))
+	}
+	out := generateGo(t, fixture(&natspb.EndpointOptions{ScatterGather: true}), Params{Reproducible: true})
+	// Each instance answers in a queue group of its own
+	for _, want := range []string{
+		"GetStatusGather(ctx context.Context, req *Req, opts ...GatherOption) ([]*Resp, error)\n",
+		"func (c *OrderServiceNatsClient) GetStatusGather(ctx context.Context, req *Req, opts ...GatherOption) ([]*Resp, error) {",
+		`"get_status": true`,
+		"micro.WithEndpointQueueGroup(instanceQueueGroup(cfg.queueGroup, svc))",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output missing %q", want)
+		}
+	}
+	if strings.Contains(out, "GetOrderGather") {
+		t.Error("Gather generated for a method without scatter_gather")
+	}
+	shared := generateGoShared(t, fixture(&natspb.EndpointOptions{ScatterGather: true}), Params{Reproducible: true})
+	for _, want := range []string{
+		"func instanceQueueGroup(queueGroup string, svc micro.Service) string {",
+		"func WithGatherMaxResponders(n int) GatherOption {",
+		"func (e *GatherError) Unwrap() []error { return e.Errors }",
+	} {
+		if !strings.Contains(shared, want) {
+			t.Errorf("shared file missing %q", want)
+		}
+	}
+
+	err := generateGoErr(t, fixture(&natspb.EndpointOptions{ScatterGather: true, FireAndForget: true}))
+	if err == nil || !strings.Contains(err.Error(), "cannot be combined with fire_and_forget") {
+		t.Errorf("scatter_gather with fire_and_forget: got %v", err)
+	}
+}
+
+func TestGenerateSubjectTemplate(t *testing.T) {
+	fixture := func(opts *natspb.EndpointOptions, streaming bool) *descriptorpb.FileDescriptorSet {
+		method := lintMethod("CreateOrder", func(o *descriptorpb.MethodOptions) {
+			proto.SetExtension(o, natspb.E_Endpoint, opts)
+		})
+		method.ServerStreaming = proto.Bool(streaming)
+		set := lintFixture(lintService("OrderService", "api.orders", lintMethod("GetOrder", nil), method))
+		set.File[0].MessageType[0].Field = append(set.File[0].MessageType[0].Field,
+			&descriptorpb.FieldDescriptorProto{Name: proto.String("tags"), Number: proto.Int32(2), Type: descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum(), Label: descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()},
+			&descriptorpb.FieldDescriptorProto{Name: proto.String("weight"), Number: proto.Int32(3), Type: descriptorpb.FieldDescriptorProto_TYPE_DOUBLE.Enum(), Label: descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum()},
+		)
+		return set
+	}
+
+	out := generateGo(t, fixture(&natspb.EndpointOptions{SubjectTemplate: "orders.{id}.create"}, false), Params{Reproducible: true})
+	// Registered at the wildcard form, filled by the client and checked by the service
+	for _, want := range []string{
+		`{Name: "CreateOrder", Subject: "orders.*.create", QueueGroup: s.queueGroup, MaxRequestSize: s.maxRequestSize},`,
+		`"CreateOrder": "orders.*.create",`,
+		`"create_order": "orders.*.create",`,
+		`subject, subjectErr := fillSubjectTemplate("orders.{id}.create", map[string]string{"id": subjectTemplateToken(typedReq.GetId())})`,
+		`subjectParams, paramsErr := checkSubjectTemplate("orders.{id}.create", req.Subject(), map[string]string{"id": subjectTemplateToken(msg.GetId())})`,
+		"ctx = withSubjectParams(ctx, subjectParams)",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output missing %q", want)
+		}
+	}
+	if strings.Count(out, "fillSubjectTemplate(") != 1 {
+		t.Error("subject filled for a method without subject_template")
+	}
+
+	tests := []struct {
+		name      string
+		opts      *natspb.EndpointOptions
+		streaming bool
+		want      string
+	}{
+		{"streaming", &natspb.EndpointOptions{SubjectTemplate: "orders.{id}"}, true, "only applies to unary methods"},
+		{"with subject", &natspb.EndpointOptions{SubjectTemplate: "orders.{id}", Subject: "orders.create"}, false, "cannot be combined with subject"},
+		{"with fire_and_forget", &natspb.EndpointOptions{SubjectTemplate: "orders.{id}", FireAndForget: true}, false, "cannot be combined with fire_and_forget"},
+		{"unknown field", &natspb.EndpointOptions{SubjectTemplate: "orders.{region}"}, false, "references field {region} which does not exist on input message Req (available fields: [id, tags, weight])"},
+		{"partial token", &natspb.EndpointOptions{SubjectTemplate: "orders.id-{id}"}, false, "placeholder id-{id} must be a whole token"},
+		{"empty token", &natspb.EndpointOptions{SubjectTemplate: "orders..{id}"}, false, "contains an empty token"},
+		{"trailing dot", &natspb.EndpointOptions{SubjectTemplate: "orders.{id}."}, false, "contains an empty token"},
+		{"wildcard", &natspb.EndpointOptions{SubjectTemplate: "orders.*.{id}"}, false, "contains wildcard characters"},
+		{"whitespace", &natspb.EndpointOptions{SubjectTemplate: "orders. {id}"}, false, "contains whitespace"},
+		{"no placeholders", &natspb.EndpointOptions{SubjectTemplate: "orders.create"}, false, "has no {field} placeholders"},
+		{"repeated field", &natspb.EndpointOptions{SubjectTemplate: "orders.{tags}"}, false, "{tags} is a repeated field"},
+		{"double field", &natspb.EndpointOptions{SubjectTemplate: "orders.{weight}"}, false, "{weight} is a double field"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := generateGoErr(t, fixture(tt.opts, tt.streaming))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got %v, want an error containing %q", err, tt.want)
+			}
+		})
+	}
+
+	// Clients of other languages would call the wrong subject
+	gen := goPlugin(t, fixture(&natspb.EndpointOptions{SubjectTemplate: "orders.{id}.create"}, false))
+	err := GenerateFile(gen, gen.Files[len(gen.Files)-1], NewTypeScriptLanguage())
+	if err == nil || !strings.Contains(err.Error(), "subject_template is not supported for language typescript") {
+		t.Errorf("TypeScript: got %v", err)
+	}
+}
+
+func TestGenerateRequestCoalescing(t *testing.T) {
+	fixture := lintFixture(lintService("OrderService", "api.orders",
+		lintMethod("GetOrder", func(o *descriptorpb.MethodOptions) {
+			o.IdempotencyLevel = descriptorpb.MethodOptions_NO_SIDE_EFFECTS.Enum()
+		}),
+		lintMethod("CreateOrder", nil),
+	))
+	out := generateGo(t, fixture, Params{Reproducible: true})
+	// Only idempotent methods are coalesced, ahead of the handler pool
+	if !strings.Contains(out, `host.coalescer.wrap(endpointPrefix+"get_order", pool.wrap(endpointPrefix+"get_order", idempotency.wrap(endpointPrefix+"get_order", micro.HandlerFunc(handlers.GetOrder))))`) {
+		t.Error("idempotent GetOrder is not coalesced")
+	}
+	if strings.Contains(out, `host.coalescer.wrap(endpointPrefix+"create_order"`) {
+		t.Error("CreateOrder coalesced without an idempotency level")
+	}
+	shared := generateGoShared(t, fixture, Params{Reproducible: true})
+	for _, want := range []string{
+		"func WithRequestCoalescing(window time.Duration) RegisterOption {",
+		"statsHandler = coalescer.statsHandler(statsHandler)",
+		// Callers that differ in credentials or other headers don't share a response
+		"key := coalescedKey{endpoint: endpoint, sum: coalescedSum(req)}",
+	} {
+		if !strings.Contains(shared, want) {
+			t.Errorf("shared file missing %q", want)
+		}
+	}
+}
+
+func TestGenerateIdempotencyKeys(t *testing.T) {
+	watch := lintMethod("WatchOrders", nil)
+	watch.ServerStreaming = proto.Bool(true)
+	fixture := lintFixture(lintService("OrderService", "api.orders",
+		lintMethod("CreateOrder", nil),
+		lintMethod("NotifyOrder", func(o *descriptorpb.MethodOptions) {
+			proto.SetExtension(o, natspb.E_Endpoint, &natspb.EndpointOptions{FireAndForget: true})
+		}),
+		watch,
+	))
+	out := generateGo(t, fixture, Params{Reproducible: true})
+	// Only unary methods with a response are deduplicated, inside the handler pool
+	for _, want := range []string{
+		"idempotency := newIdempotencyGuard(cfg)",
+		`"create_order": pool.wrap(endpointPrefix+"create_order", idempotency.wrap(endpointPrefix+"create_order", micro.HandlerFunc(handlers.CreateOrder))),`,
+		`"notify_order": pool.wrap(endpointPrefix+"notify_order", micro.HandlerFunc(handlers.NotifyOrder)),`,
+		`"watch_orders": micro.HandlerFunc(handlers.WatchOrders),`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output missing %q", want)
+		}
+	}
+
+	// Services without such methods declare no guard
+	streamOnly := generateGo(t, lintFixture(lintService("OrderService", "api.orders", watch)), Params{Reproducible: true})
+	if strings.Contains(streamOnly, "newIdempotencyGuard") {
+		t.Error("stream-only service declares an idempotency guard")
+	}
+
+	shared := generateGoShared(t, fixture, Params{Reproducible: true})
+	for _, want := range []string{
+		`IdempotencyKeyHeader   = "Idempotency-Key"`,
+		"func WithIdempotencyKey(ctx context.Context, key string) context.Context {",
+		"func WithIdempotencyStore(kv jetstream.KeyValue, ttl time.Duration) RegisterOption {",
+		"revision, err := g.kv.Create(ctx, storeKey, pending)",
+	} {
+		if !strings.Contains(shared, want) {
+			t.Errorf("shared file missing %q", want)
+		}
+	}
+}
+
+func TestGenerateStreamCompression(t *testing.T) {
+	watch := lintMethod("WatchOrders", nil)
+	watch.ServerStreaming = proto.Bool(true)
+	upload := lintMethod("UploadOrders", nil)
+	upload.ClientStreaming = proto.Bool(true)
+	chat := lintMethod("ChatOrders", nil)
+	chat.ClientStreaming, chat.ServerStreaming = proto.Bool(true), proto.Bool(true)
+	fixture := lintFixture(lintService("OrderService", "api.orders", watch, upload, chat))
+	out := generateGo(t, fixture, Params{Reproducible: true})
+	// Clients offer their codecs on every stream kind, servers agree only when configured
+	if n := strings.Count(out, "msg.Header.Set(natsStreamAcceptEncodingHeader, compressorNames())"); n != 3 {
+		t.Errorf("%d streams offer compression, want 3", n)
+	}
+	for _, want := range []string{
+		"sender.compressor, sender.encoding = negotiateStreamCompression(req.Headers(), h.streamCompression, h.compressor)",
+		"if h.streamCompression > 0 && acceptsStreamEncoding(req.Headers(), h.compressor.Name()) {",
+		"compressor: acceptedStreamCompression(ackMsg.Header, c.streamCompression),",
+		"m.Data = s.compressor.encode(data, m.Header)",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output missing %q", want)
+		}
+	}
+
+	shared := generateGoShared(t, fixture, Params{Reproducible: true})
+	for _, want := range []string{
+		`StreamEncodingHeader           = "Nats-Stream-Encoding"`,
+		"func WithStreamCompression(threshold int) RegisterOption {",
+		"func WithClientStreamCompression(threshold int) NatsClientOption {",
+		"func decodeStreamFrame(",
+	} {
+		if !strings.Contains(shared, want) {
+			t.Errorf("shared file missing %q", want)
+		}
+	}
+}
+
+func TestGeneratePayloadCompression(t *testing.T) {
+	fixture := lintFixture(lintService("OrderService", "api.orders",
+		lintMethod("SearchOrders", nil),
+		lintMethod("NotifyOrder", func(o *descriptorpb.MethodOptions) {
+			proto.SetExtension(o, natspb.E_Endpoint, &natspb.EndpointOptions{FireAndForget: true})
+		})))
+	out := generateGo(t, fixture, Params{Reproducible: true})
+	// Every request is decompressed before decoding, whatever the service's options
+	if n := strings.Count(out, `readPayload("request", req.Headers().Get(ContentEncodingHeader), req.Data(), h.maxRequestSize)`); n != 2 {
+		t.Errorf("%d handlers decompress requests, want 2", n)
+	}
+	for _, want := range []string{
+		`return nil, fmt.Errorf("WithCompression: codec %q is not registered; add it with RegisterCompressor", cfg.compressionCodec)`,
+		"if h.compression > 0 && acceptsEncoding(req.Headers().Get(acceptEncodingHeader), h.compressor.Name()) {",
+		"data = compressPayload(h.compressor, h.compression, data, outgoingHeaders)",
+		"headers.Set(acceptEncodingHeader, compressorNames())",
+		`body, err := readPayload("response", msg.Header.Get(ContentEncodingHeader), msg.Data, c.maxResponseSize)`,
+		"func (c *OrderServiceNatsClient) compress(data []byte, headers nats.Header) ([]byte, error) {",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output missing %q", want)
+		}
+	}
+	// The unary call and the notification both compress their requests
+	if n := strings.Count(out, "if data, err = c.compress(data, headers); err != nil {"); n != 2 {
+		t.Errorf("%d calls compress requests, want 2", n)
+	}
+
+	shared := generateGoShared(t, fixture, Params{Reproducible: true})
+	for _, want := range []string{
+		`const ContentEncodingHeader = "Content-Encoding"`,
+		"func RegisterCompressor(c Compressor) {",
+		"}{byName: map[string]Compressor{streamEncodingGzip: gzipCompressor{}}}",
+		"func WithCompression(codec string, minSize int) RegisterOption {",
+		"func WithClientCompression(codec string, minSize int) NatsClientOption {",
+		"return decompressPayload(encoding, data, limit)",
+	} {
+		if !strings.Contains(shared, want) {
+			t.Errorf("shared file missing %q", want)
+		}
+	}
+}
+
+func TestGenerateSensitiveFields(t *testing.T) {
+	// String fields are marked sensitive, message fields refer to typeName
+	field := func(name, typeName string, label descriptorpb.FieldDescriptorProto_Label, number int32) *descriptorpb.FieldDescriptorProto {
+		field := &descriptorpb.FieldDescriptorProto{
+			Name:     proto.String(name),
+			Number:   proto.Int32(number),
+			Label:    label.Enum(),
+			JsonName: proto.String(name),
+			Options:  &descriptorpb.FieldOptions{},
+		}
+		if typeName != "" {
+			field.Type, field.TypeName = descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum(), proto.String(typeName)
+		} else {
+			field.Type = descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum()
+			proto.SetExtension(field.Options, natspb.E_Field, &natspb.FieldOptions{Sensitive: true})
+		}
+		return field
+	}
+	fixture := lintFixture(lintService("UserService", "api.users", lintMethod("ListUsers", nil)))
+	file := fixture.File[0]
+	// Req.token directly, User.email through Resp's repeated users
+	file.MessageType[0].Field = append(file.MessageType[0].Field, field("token", "", descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL, 2))
+	file.MessageType[1].Field = append(file.MessageType[1].Field, field("users", ".fixture.v1.User", descriptorpb.FieldDescriptorProto_LABEL_REPEATED, 1))
+	file.MessageType = append(file.MessageType, &descriptorpb.DescriptorProto{
+		Name:  proto.String("User"),
+		Field: []*descriptorpb.FieldDescriptorProto{field("email", "", descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL, 1)},
+	})
+
+	out := generateGo(t, fixture, Params{Reproducible: true})
+	if !strings.Contains(out, "markSensitiveFields(\n\t\t\"fixture.v1.Req.token\",\n\t\t\"fixture.v1.User.email\",\n\t)") {
+		t.Errorf("output does not mark Req.token and User.email sensitive:\n%s", out[strings.Index(out, "func init()"):][:300])
+	}
+	// Services without sensitive fields register none
+	if plain := generateGo(t, lintFixture(lintService("OrderService", "api.orders", lintMethod("GetOrder", nil))), Params{Reproducible: true}); strings.Contains(plain, "markSensitiveFields(") {
+		t.Error("service without sensitive fields marks some")
+	}
+
+	shared := generateGoShared(t, fixture, Params{Reproducible: true})
+	for _, want := range []string{
+		"opts.GetDebugRedact() || sensitiveFields[fd.FullName()] {",
+		"func NewPayloadLoggingInterceptor(logger *slog.Logger, opts ...PayloadLoggingOption) UnaryServerInterceptor {",
+		"func NewPayloadLoggingClientInterceptor(logger *slog.Logger, opts ...PayloadLoggingOption) UnaryClientInterceptor {",
+		"data, err := marshalJSON(RedactMessage(msg), false)",
+	} {
+		if !strings.Contains(shared, want) {
+			t.Errorf("shared file missing %q", want)
+		}
+	}
+}
+
+func TestGenerateFailureRecorder(t *testing.T) {
+	fixture := lintFixture(lintService("OrderService", "api.orders",
+		lintMethod("CreateOrder", nil),
+		lintMethod("NotifyOrder", func(o *descriptorpb.MethodOptions) {
+			proto.SetExtension(o, natspb.E_Endpoint, &natspb.EndpointOptions{FireAndForget: true})
+		}),
+	))
+	// Unary and fire-and-forget handlers record inside the interceptor chain
+	out := generateGo(t, fixture, Params{Reproducible: true})
+	if n := strings.Count(out, "handler = h.failures.wrap(\"OrderService\", "); n != 2 {
+		t.Errorf("%d handlers record failures, want 2", n)
+	}
+	shared := generateGoShared(t, fixture, Params{Reproducible: true})
+	for _, want := range []string{
+		"func WithFailureRecorder(store RecorderStore) RegisterOption {",
+		"func NewObjectRecorderStore(obs jetstream.ObjectStore) RecorderStore {",
+		"func replayFailure(ctx context.Context, store RecorderStore, id, service string, methods map[string]failureReplayMethod) (*FailureReplay, error) {",
+	} {
+		if !strings.Contains(shared, want) {
+			t.Errorf("shared file missing %q", want)
+		}
+	}
+
+	// mocks=true replays records against an implementation
+	gen := goPlugin(t, fixture)
+	lang := NewGoLanguage()
+	lang.SetParams(Params{Reproducible: true, Mocks: true})
+	if err := lang.GenerateMocks(gen.NewGeneratedFile("order_nats_mock.pb.go", gen.Files[len(gen.Files)-1].GoImportPath), gen.Files[len(gen.Files)-1]); err != nil {
+		t.Fatalf("GenerateMocks: %v", err)
+	}
+	resp := gen.Response()
+	if resp.Error != nil {
+		t.Fatalf("generated mocks are not valid Go: %s", resp.GetError())
+	}
+	mocks := resp.File[0].GetContent()
+	for _, want := range []string{
+		"func ReplayOrderServiceFailure(ctx context.Context, store RecorderStore, id string, impl OrderServiceNats) (*FailureReplay, error) {",
+		"return impl.CreateOrder(ctx, req.(*Req))",
+		"return nil, impl.NotifyOrder(ctx, req.(*Req))",
+	} {
+		if !strings.Contains(mocks, want) {
+			t.Errorf("mocks missing %q", want)
+		}
+	}
+}
+
+// emptyFixture adds google/protobuf/empty.proto to set and returns a method using
+// Empty for the sides selected by in and out
+func emptyFixture(set *descriptorpb.FileDescriptorSet) func(name string, in, out bool) *descriptorpb.MethodDescriptorProto {
+	set.File[0].Dependency = append(set.File[0].Dependency, "google/protobuf/empty.proto")
+	set.File = append([]*descriptorpb.FileDescriptorProto{protodesc.ToFileDescriptorProto(emptypb.File_google_protobuf_empty_proto)}, set.File...)
+	return func(name string, in, out bool) *descriptorpb.MethodDescriptorProto {
+		m := lintMethod(name, nil)
+		if in {
+			m.InputType = proto.String(".google.protobuf.Empty")
+		}
+		if out {
+			m.OutputType = proto.String(".google.protobuf.Empty")
+		}
+		return m
+	}
+}
+
+func TestGenerateEmptyShortcuts(t *testing.T) {
+	build := func(params Params) string {
+		svc := lintService("JobService", "api.jobs")
+		set := lintFixture(svc)
+		method := emptyFixture(set)
+		svc.Method = []*descriptorpb.MethodDescriptorProto{
+			method("Reset", true, true),
+			method("Count", true, false),
+			method("Clear", false, true),
+			method("Get", false, false),
+		}
+		return generateGo(t, set, params)
+	}
+
+	out := build(Params{Reproducible: true, EmptyShortcuts: true})
+	for _, want := range []string{
+		`"google.golang.org/protobuf/types/known/emptypb"`,
+		"Reset(context.Context) error",
+		"Count(context.Context) (*Resp, error)",
+		"Clear(context.Context, *Req) error",
+		"Get(context.Context, *Req) (*Resp, error)",
+		"Reset(context.Context, ...CallOption) error",
+		"Count(context.Context, ...CallOption) (*Resp, error)",
+		"return &emptypb.Empty{}, nil",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output missing %q", want)
+		}
+	}
+
+	full := build(Params{Reproducible: true})
+	for _, want := range []string{
+		"Reset(context.Context, *emptypb.Empty) (*emptypb.Empty, error)",
+		"Clear(context.Context, *Req) (*emptypb.Empty, error)",
+	} {
+		if !strings.Contains(full, want) {
+			t.Errorf("empty_shortcuts=false output missing %q", want)
+		}
+	}
+
+	plain := generateGo(t, lintFixture(lintService("JobService", "api.jobs", lintMethod("Get", nil))), Params{Reproducible: true, EmptyShortcuts: true})
+	if strings.Contains(plain, "emptypb") {
+		t.Error("service without Empty imports emptypb")
+	}
+}
+
+func TestGenerateHeadersShareNatsrpc(t *testing.T) {
+	fixture := lintFixture(lintService("OrderService", "api.orders", lintMethod("GetOrder", nil)))
+	shared := generateGoShared(t, fixture, Params{Reproducible: true})
+	for _, want := range []string{
+		"\"github.com/toyz/protoc-gen-nats-micro/natsrpc\"",
+		"return natsrpc.IncomingHeaders(ctx)",
+		"return natsrpc.WithOutgoingHeaders(ctx, headers)",
+		"natsrpc.SetResponseHeaders(ctx, headers)",
+	} {
+		if !strings.Contains(shared, want) {
+			t.Errorf("shared output is missing %q", want)
+		}
+	}
+	// Headers are no longer keyed by this package's own context keys
+	if strings.Contains(shared, "incomingHeadersKey") {
+		t.Error("shared output still declares its own header context keys")
+	}
+
+	out := generateGo(t, fixture, Params{Reproducible: true})
+	for _, want := range []string{
+		"ctx = withPendingResponseHeaders(ctx, outgoingHeadersPtr)",
+		"storeResponseHeaders(invokerCtx, msg.Header)",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("service output is missing %q", want)
+		}
+	}
+}
+
+func TestGenerateHeaders(t *testing.T) {
+	withHeaders := func(headers ...*natspb.HeaderOptions) func(*descriptorpb.MethodOptions) {
+		return func(o *descriptorpb.MethodOptions) {
+			proto.SetExtension(o, natspb.E_Endpoint, &natspb.EndpointOptions{Headers: headers})
+		}
+	}
+	tenant := &natspb.HeaderOptions{Name: "x-tenant-id", Required: true}
+	fixture := func() *descriptorpb.FileDescriptorSet {
+		return lintFixture(lintService("OrderService", "api.orders",
+			lintMethod("GetOrder", withHeaders(tenant, &natspb.HeaderOptions{Name: "Accept-Language", Accessor: "locale"})),
+			lintMethod("ListOrders", withHeaders(&natspb.HeaderOptions{Name: "X-Tenant-ID"}))))
+	}
+
+	out := generateGo(t, fixture(), Params{Reproducible: true})
+	for _, want := range []string{
+		"func TenantIDFromContext(ctx context.Context) (string, bool) {",
+		`return headerValue(nats.Header(IncomingHeaders(ctx)), "x-tenant-id")`,
+		"func WithTenantID(ctx context.Context, value string) context.Context {",
+		"func LocaleFromContext(ctx context.Context) (string, bool) {",
+		"func WithLocale(ctx context.Context, value string) context.Context {",
+		`if missing := missingHeader(nats.Header(req.Headers()), "x-tenant-id"); missing != "" {`,
+		`"headers":          "x-tenant-id,Accept-Language",`,
+		`"required_headers": "x-tenant-id",`,
+		`\"headers\":[{\"name\":\"x-tenant-id\",\"required\":true},{\"name\":\"Accept-Language\"}]`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output missing %q", want)
+		}
+	}
+	// X-Tenant-ID on ListOrders is the same header, so the accessors are declared once
+	if n := strings.Count(out, "func TenantIDFromContext("); n != 1 {
+		t.Errorf("TenantIDFromContext declared %d times", n)
+	}
+	// Only GetOrder requires a header
+	if n := strings.Count(out, "missingHeader("); n != 1 {
+		t.Errorf("required headers checked %d times, want 1", n)
+	}
+
+	// Each declaration fails generation with an error mentioning want
+	for want, headers := range map[string][]*natspb.HeaderOptions{
+		"without a name":                 {{Required: true}},
+		"separated by single hyphens":    {{Name: "x--tenant"}},
+		"is reserved":                    {{Name: "Nats-Tenant"}},
+		"declared more than once":        {{Name: "x-tenant"}, {Name: "X-Tenant"}},
+		`accessor name "9lives"`:         {{Name: "x-9lives"}},
+		`accessor name "Has space"`:      {{Name: "x-a", Accessor: "has space"}},
+		`"tenant" and header "x-tenant"`: {{Name: "x-tenant"}, {Name: "tenant"}},
+		`"x-b" and header "x-a"`:         {{Name: "x-a", Accessor: "shared"}, {Name: "x-b", Accessor: "shared"}},
+	} {
+		err := generateGoErr(t, lintFixture(lintService("OrderService", "api.orders", lintMethod("GetOrder", withHeaders(headers...)))))
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%v: got %v, want an error mentioning %q", headers, err, want)
+		}
+	}
+	// Streams cannot require headers, but may declare them
+	stream := lintMethod("Watch", withHeaders(tenant))
+	stream.ServerStreaming = proto.Bool(true)
+	if err := generateGoErr(t, lintFixture(lintService("OrderService", "api.orders", stream))); err == nil || !strings.Contains(err.Error(), "only unary methods") {
+		t.Errorf("required header on a stream: got %v", err)
+	}
+}
+
+func TestGenerateInstanceSubjects(t *testing.T) {
+	templated := lintMethod("CreateOrder", func(o *descriptorpb.MethodOptions) {
+		proto.SetExtension(o, natspb.E_Endpoint, &natspb.EndpointOptions{SubjectTemplate: "orders.{id}.create"})
+	})
+	stream := lintMethod("WatchOrders", nil)
+	stream.ServerStreaming = proto.Bool(true)
+	fixture := lintFixture(lintService("OrderService", "api.orders", lintMethod("GetOrder", nil), templated, stream))
+
+	out := generateGo(t, fixture, Params{Reproducible: true})
+	for _, want := range []string{
+		"subject, subjectErr := targetSubject(c.subjectPrefix+\".get_order\", opts)",
+		`if targetInstance(opts) != "" {`,
+		"info.responder(msg.Header)",
+		"handler = respondAsInstance(svc.Info().ID, handler)",
+		`adder.AddEndpoint(endpointPrefix+name+"-instance", holdRequests(cfg.hold, handler), opts...)`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output missing %q", want)
+		}
+	}
+	// Only unary methods on their own subject get an instance subject
+	endpoints := out[strings.Index(out, "instanceEndpoints := map[string]bool{"):]
+	endpoints = endpoints[:strings.Index(endpoints, "}")]
+	if !strings.Contains(endpoints, `"get_order": true`) || strings.Contains(endpoints, "create_order") || strings.Contains(endpoints, "watch_orders") {
+		t.Errorf("unexpected instance endpoints:\n%s", endpoints)
+	}
+
+	shared := generateGoShared(t, fixture, Params{Reproducible: true})
+	for _, want := range []string{
+		"func WithInstanceSubjects() RegisterOption",
+		"func WithTargetInstance(id string) CallOption",
+		"func ResponderInstance(ctx context.Context) string",
+		`const ServiceInstanceHeader = "Nats-Service-Instance"`,
+	} {
+		if !strings.Contains(shared, want) {
+			t.Errorf("shared output missing %q", want)
+		}
+	}
+}
+
+// eventFixture is a proto file with an OrderCreated event and no services
+func eventFixture(opts ...*natspb.EventOptions) *descriptorpb.FileDescriptorSet {
+	set := lintFixture()
+	for i, o := range opts {
+		msg := &descriptorpb.DescriptorProto{
+			Name:    proto.String("OrderCreated"),
+			Options: &descriptorpb.MessageOptions{},
+		}
+		if i > 0 {
+			msg.Name = proto.String(fmt.Sprintf("OrderCreated%d", i))
+		}
+		proto.SetExtension(msg.Options, natspb.E_Event, o)
+		set.File[0].MessageType = append(set.File[0].MessageType, msg)
+	}
+	return set
+}
+
+func TestGenerateEvents(t *testing.T) {
+	out := generateGo(t, eventFixture(&natspb.EventOptions{Subject: "events.order.created"}), Params{Reproducible: true})
+	for _, want := range []string{
+		`const OrderCreatedSubject = "events.order.created"`,
+		"func PublishOrderCreated(ctx context.Context, nc *nats.Conn, event *OrderCreated, opts ...PublishOption) error {",
+		`return publishEvent(ctx, nc, OrderCreatedSubject, "OrderCreated", event, false, opts)`,
+		"func SubscribeOrderCreated(nc *nats.Conn, handler func(context.Context, *OrderCreated) error, opts ...SubscribeOption) (Subscription, error) {",
+		`subscribeEvent(nc, OrderCreatedSubject, "OrderCreated", "fixture.v1.OrderCreated", false,`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output missing %q", want)
+		}
+	}
+	// A file of events imports only what the event functions use
+	if strings.Contains(out, `"github.com/nats-io/nats.go/micro"`) || strings.Contains(out, "Register") {
+		t.Errorf("event-only output has service code:\n%s", out)
+	}
+
+	out = generateGo(t, eventFixture(&natspb.EventOptions{Subject: "events.order.created", Encoding: "json"}), Params{Reproducible: true})
+	if !strings.Contains(out, `publishEvent(ctx, nc, OrderCreatedSubject, "OrderCreated", event, true, opts)`) {
+		t.Error("JSON event is not published as JSON")
+	}
+
+	// Events next to a service go into the service's file
+	set := eventFixture(&natspb.EventOptions{Subject: "events.order.created"})
+	set.File[0].Service = []*descriptorpb.ServiceDescriptorProto{lintService("OrderService", "api.orders", lintMethod("GetOrder", nil))}
+	out = generateGo(t, set, Params{Reproducible: true})
+	if !strings.Contains(out, "func PublishOrderCreated(") || !strings.Contains(out, "func RegisterOrderServiceHandlers(") {
+		t.Error("events and services are not generated together")
+	}
+
+	shared := generateGoShared(t, eventFixture(&natspb.EventOptions{Subject: "events.order.created"}), Params{Reproducible: true})
+	for _, want := range []string{
+		"func WithSubscribeDurable(js jetstream.JetStream, stream, durable string) SubscribeOption",
+		"func WithPublishInterceptor(interceptor UnaryClientInterceptor) PublishOption",
+		"func subscribeEvent[T proto.Message](",
+	} {
+		if !strings.Contains(shared, want) {
+			t.Errorf("shared output missing %q", want)
+		}
+	}
+
+	for _, tt := range []struct {
+		name   string
+		opts   []*natspb.EventOptions
+		substr string
+	}{
+		{"no subject", []*natspb.EventOptions{{}}, "subject is empty"},
+		{"wildcard", []*natspb.EventOptions{{Subject: "events.order.*"}}, "wildcard"},
+		{"encoding", []*natspb.EventOptions{{Subject: "events.order.created", Encoding: "xml"}}, `unknown encoding "xml"`},
+		{"shared subject", []*natspb.EventOptions{{Subject: "events.order.created"}, {Subject: "events.order.created"}}, "is also the subject of fixture.v1.OrderCreated"},
+	} {
+		if err := generateGoErr(t, eventFixture(tt.opts...)); err == nil || !strings.Contains(err.Error(), tt.substr) {
+			t.Errorf("%s: got %v, want an error containing %q", tt.name, err, tt.substr)
+		}
+	}
+}
+
+func TestGenerateDeadLetters(t *testing.T) {
+	out := generateGo(t, eventFixture(&natspb.EventOptions{Subject: "events.order.created", Encoding: "json"}), Params{Reproducible: true})
+	for _, want := range []string{
+		"func SubscribeOrderCreatedDLQ(nc *nats.Conn, subject string, handler func(context.Context, *OrderCreated, DeadLetter) error, opts ...SubscribeOption) (Subscription, error) {",
+		`subscribeDeadLetters(nc, subject, "OrderCreated", "fixture.v1.OrderCreated", true,`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output missing %q", want)
+		}
+	}
+
+	shared := generateGoShared(t, eventFixture(&natspb.EventOptions{Subject: "events.order.created"}), Params{Reproducible: true})
+	for _, want := range []string{
+		"func WithDeadLetter(subject string, maxDeliveries int) SubscribeOption",
+		`DeadLetterDeliveriesHeader = "Nats-Dead-Letter-Deliveries"`,
+		"func subscribeDeadLetters[T proto.Message](",
+		"deliveries = int(meta.NumDelivered)",
+	} {
+		if !strings.Contains(shared, want) {
+			t.Errorf("shared output missing %q", want)
+		}
+	}
+}
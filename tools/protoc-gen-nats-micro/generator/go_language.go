@@ -1,5 +1,7 @@
 package generator
 
+import "google.golang.org/protobuf/compiler/protogen"
+
 // GoLanguage implements Language for Go code generation
 type GoLanguage struct{ BaseLanguage }
 
@@ -9,8 +11,21 @@ func (g *GoLanguage) IsGoLike() bool { return true }
 // NewGoLanguage creates a new Go language generator
 func NewGoLanguage() *GoLanguage {
 	return &GoLanguage{newBaseLanguage("go", "_nats.pb.go", "templates/go/*.tmpl",
-		[]string{"header.go.tmpl"},
-		[]string{"shared_header.go.tmpl", "shared.go.tmpl", "stream_helpers.go.tmpl"},
-		[]string{"errors.go.tmpl", "service.go.tmpl", "stream.go.tmpl", "client.go.tmpl"},
+		[]string{"header.go.tmpl", "enrich.go.tmpl", "headers.go.tmpl", "stream_pipes.go.tmpl", "random_messages.go.tmpl", "event_messages.go.tmpl"},
+		[]string{"shared_header.go.tmpl", "shared.go.tmpl", "stream_helpers.go.tmpl", "journal.go.tmpl", "otel.go.tmpl", "metrics.go.tmpl", "validate.go.tmpl", "grpc.go.tmpl", "pipe.go.tmpl", "sampling.go.tmpl", "connmonitor.go.tmpl", "gather.go.tmpl", "coalesce.go.tmpl", "idempotency.go.tmpl", "panics.go.tmpl", "failures.go.tmpl", "httpgateway.go.tmpl", "kvwatch.go.tmpl", "random.go.tmpl", "subject_template.go.tmpl", "bench.go.tmpl", "compression.go.tmpl", "payloadlog.go.tmpl", "events.go.tmpl"},
+		[]string{"errors.go.tmpl", "service.go.tmpl", "stream.go.tmpl", "client.go.tmpl", "grpc_shim.go.tmpl", "grpc_bridge.go.tmpl", "http_gateway.go.tmpl", "random_requests.go.tmpl", "bench_service.go.tmpl"},
 	)}
 }
+
+// MockFileExtension returns the extension of the mocks=true output file
+func (g *GoLanguage) MockFileExtension() string { return "_nats_mock.pb.go" }
+
+// GenerateMocks generates a client mock and an in-memory client per service
+func (g *GoLanguage) GenerateMocks(gf *protogen.GeneratedFile, file *protogen.File) error {
+	return g.executeTemplates(gf, TemplateData{File: file}, []string{"mock.go.tmpl"})
+}
+
+// GenerateCLI generates a main package that calls one service from the command line
+func (g *GoLanguage) GenerateCLI(gf *protogen.GeneratedFile, file *protogen.File, service *protogen.Service) error {
+	return g.executeTemplates(gf, TemplateData{File: file, Service: service, Options: GetServiceOptions(service)}, []string{"cli.go.tmpl"})
+}
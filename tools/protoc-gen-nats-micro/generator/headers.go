@@ -0,0 +1,113 @@
+package generator
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// headerNameRe matches declared header names: letters and digits separated by
+// single hyphens
+var headerNameRe = regexp.MustCompile(`^[A-Za-z0-9]+(-[A-Za-z0-9]+)*$`)
+
+// headerAccessor derives the accessor prefix of a header name: its words in camel
+// case, with commonInitialisms in upper case and any "x-" prefix dropped, e.g.,
+// "x-tenant-id" -> "TenantID"
+func headerAccessor(name string) string {
+	if len(name) > 2 && strings.EqualFold(name[:2], "x-") {
+		name = name[2:]
+	}
+	var b strings.Builder
+	for _, word := range strings.Split(name, "-") {
+		if upper := strings.ToUpper(word); slices.Contains(commonInitialisms, upper) {
+			b.WriteString(upper)
+		} else {
+			b.WriteString(ToUpperFirst(strings.ToLower(word)))
+		}
+	}
+	return b.String()
+}
+
+// validateHeaders checks a method's (natsmicro.endpoint).headers: valid names,
+// none declared twice, accessors that are Go identifiers, and required headers
+// only on methods that respond to single requests.
+func validateHeaders(method protoreflect.MethodDescriptor, eopts EndpointOptions) error {
+	seen := make(map[string]bool, len(eopts.Headers))
+	for _, header := range eopts.Headers {
+		switch {
+		case header.Name == "":
+			return fmt.Errorf("headers contains a header without a name")
+		case !headerNameRe.MatchString(header.Name):
+			return fmt.Errorf("header name %q must be letters and digits separated by single hyphens", header.Name)
+		case len(header.Name) >= 5 && strings.EqualFold(header.Name[:5], "nats-"):
+			return fmt.Errorf("header name %q is reserved: Nats- headers belong to NATS", header.Name)
+		case seen[strings.ToLower(header.Name)]:
+			return fmt.Errorf("header %q is declared more than once", header.Name)
+		case !contextKeyRe.MatchString(header.Accessor):
+			return fmt.Errorf("header %q gets the accessor name %q, which is not a Go identifier; set accessor", header.Name, header.Accessor)
+		case header.Required && (method.IsStreamingClient() || method.IsStreamingServer() || eopts.FireAndForget):
+			return fmt.Errorf("header %q is required, but only unary methods that respond check required headers", header.Name)
+		}
+		seen[strings.ToLower(header.Name)] = true
+	}
+	return nil
+}
+
+// HeaderAccessors returns one header declaration per distinct accessor used by
+// the file's generated services, in declaration order. Each entry produces a
+// <Accessor>FromContext and a With<Accessor> function in the generated Go code.
+func HeaderAccessors(file *protogen.File) []HeaderOpts {
+	accessors, _ := headerAccessors(file)
+	return accessors
+}
+
+// headerAccessors collects header declarations per accessor and reports an
+// accessor bound to two different headers, or to an enrichment's context_key.
+func headerAccessors(file *protogen.File) ([]HeaderOpts, error) {
+	var accessors []HeaderOpts
+	seen := make(map[string]string)
+	enriched := make(map[string]bool)
+	for _, enrich := range EnrichAccessors(file) {
+		enriched[enrich.Accessor] = true
+	}
+	for _, service := range file.Services {
+		if GetServiceOptions(service).Skip {
+			continue
+		}
+		for _, method := range service.Methods {
+			eopts := GetEndpointOptions(method)
+			if eopts.Skip {
+				continue
+			}
+			for _, header := range eopts.Headers {
+				if enriched[header.Accessor] {
+					return nil, fmt.Errorf("%s: header %q and a (natsmicro.enrich) context_key both generate %sFromContext; set accessor", method.Desc.FullName(), header.Name, header.Accessor)
+				}
+				if name, ok := seen[header.Accessor]; ok {
+					if !strings.EqualFold(name, header.Name) {
+						return nil, fmt.Errorf("%s: header %q and header %q both generate %sFromContext; set accessor", method.Desc.FullName(), header.Name, name, header.Accessor)
+					}
+					continue
+				}
+				seen[header.Accessor] = header.Name
+				accessors = append(accessors, header)
+			}
+		}
+	}
+	return accessors, nil
+}
+
+// RequiredHeaders returns the names of the headers a method requires
+func RequiredHeaders(method *protogen.Method) []string {
+	var names []string
+	for _, header := range GetEndpointOptions(method).Headers {
+		if header.Required {
+			names = append(names, header.Name)
+		}
+	}
+	return names
+}
@@ -0,0 +1,251 @@
+package generator
+
+import (
+	"fmt"
+	"strings"
+
+	"google.golang.org/genproto/googleapis/api/annotations"
+	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// HTTPBinding is one google.api.http binding of a method, as http_gateway=true
+// registers it on an http.ServeMux
+type HTTPBinding struct {
+	Pattern      string          // http.ServeMux pattern, e.g. "GET /v1/orders/{id}"
+	Body         string          // Request field the body decodes into: "*" for the whole request, "" for none
+	ResponseBody string          // Response field sent as the body, "" for the whole response
+	Params       []HTTPPathParam // Request fields set from the path
+}
+
+// HTTPPathParam is a request field set from the path of an HTTPBinding
+type HTTPPathParam struct {
+	Field    string   // Request field path, e.g. "order.id"
+	Segments []string // Path segments joined with "/" into the value; "{name}" segments are wildcards
+}
+
+// HTTPBindings returns the google.api.http bindings of method, additional bindings
+// included, or nil if it has none or they do not fit (see validateHTTPGateway).
+// Only unary and server-streaming methods are served.
+func HTTPBindings(method *protogen.Method) []HTTPBinding {
+	bindings, err := httpBindings(method.Desc)
+	if err != nil {
+		return nil
+	}
+	return bindings
+}
+
+// validateHTTPGateway checks that the google.api.http bindings of the methods
+// file serves can be registered by the http_gateway=true handlers
+func validateHTTPGateway(file *protogen.File) error {
+	for _, service := range file.Services {
+		if GetServiceOptions(service).Skip {
+			continue
+		}
+		for _, method := range service.Methods {
+			if GetEndpointOptions(method).Skip {
+				continue
+			}
+			if _, err := httpBindings(method.Desc); err != nil {
+				return fmt.Errorf("%s: %w", method.Desc.FullName(), err)
+			}
+		}
+	}
+	return nil
+}
+
+// httpRule returns the google.api.http annotation of method, or nil
+func httpRule(method protoreflect.MethodDescriptor) *annotations.HttpRule {
+	opts := method.Options()
+	if opts == nil || !proto.HasExtension(opts, annotations.E_Http) {
+		return nil
+	}
+	rule, _ := proto.GetExtension(opts, annotations.E_Http).(*annotations.HttpRule)
+	return rule
+}
+
+func httpBindings(method protoreflect.MethodDescriptor) ([]HTTPBinding, error) {
+	rule := httpRule(method)
+	if rule == nil || method.IsStreamingClient() {
+		return nil, nil
+	}
+	rules := append([]*annotations.HttpRule{rule}, rule.GetAdditionalBindings()...)
+	bindings := make([]HTTPBinding, 0, len(rules))
+	for _, r := range rules {
+		b, err := httpBinding(method, r)
+		if err != nil {
+			return nil, fmt.Errorf("google.api.http: %w", err)
+		}
+		bindings = append(bindings, b)
+	}
+	return bindings, nil
+}
+
+// httpBinding converts one HttpRule to the ServeMux pattern and fields it binds
+func httpBinding(method protoreflect.MethodDescriptor, rule *annotations.HttpRule) (HTTPBinding, error) {
+	var verb, path string
+	switch p := rule.GetPattern().(type) {
+	case *annotations.HttpRule_Get:
+		verb, path = "GET", p.Get
+	case *annotations.HttpRule_Put:
+		verb, path = "PUT", p.Put
+	case *annotations.HttpRule_Post:
+		verb, path = "POST", p.Post
+	case *annotations.HttpRule_Delete:
+		verb, path = "DELETE", p.Delete
+	case *annotations.HttpRule_Patch:
+		verb, path = "PATCH", p.Patch
+	case *annotations.HttpRule_Custom:
+		verb, path = p.Custom.GetKind(), p.Custom.GetPath()
+	default:
+		return HTTPBinding{}, fmt.Errorf("no HTTP method")
+	}
+
+	pattern, params, err := parseHTTPPath(path)
+	if err != nil {
+		return HTTPBinding{}, fmt.Errorf("path %q: %w", path, err)
+	}
+	for _, param := range params {
+		fd, err := httpField(method.Input(), param.Field)
+		if err != nil {
+			return HTTPBinding{}, fmt.Errorf("path %q: %w", path, err)
+		}
+		if fd.IsList() || fd.IsMap() {
+			return HTTPBinding{}, fmt.Errorf("path %q: field %q is repeated", path, param.Field)
+		}
+	}
+	if verb != "*" {
+		pattern = verb + " " + pattern
+	}
+
+	b := HTTPBinding{Pattern: pattern, Body: rule.GetBody(), ResponseBody: rule.GetResponseBody(), Params: params}
+	if b.Body != "" && b.Body != "*" {
+		fd, err := httpField(method.Input(), b.Body)
+		if err != nil {
+			return HTTPBinding{}, fmt.Errorf("body: %w", err)
+		}
+		if fd.Message() == nil || fd.IsList() || fd.IsMap() {
+			return HTTPBinding{}, fmt.Errorf("body: field %q is not a message", b.Body)
+		}
+	}
+	if b.ResponseBody != "" && method.Output().Fields().ByName(protoreflect.Name(b.ResponseBody)) == nil {
+		return HTTPBinding{}, fmt.Errorf("response_body: %s has no field %q", method.Output().FullName(), b.ResponseBody)
+	}
+	return b, nil
+}
+
+// parseHTTPPath converts a google.api.http path template to an http.ServeMux
+// path, returning the request fields its variables set. ServeMux wildcards match
+// whole segments and "{name...}" only the rest of the path, so "**" must come last
+// and a custom verb (":verb") must follow a literal segment.
+func parseHTTPPath(path string) (string, []HTTPPathParam, error) {
+	if !strings.HasPrefix(path, "/") {
+		return "", nil, fmt.Errorf("must start with /")
+	}
+	var segments []string
+	var params []HTTPPathParam
+	wildcards := make(map[string]bool)
+	anonymous := 0
+	wildcard := func(name string, rest bool) string {
+		wildcards[name] = true
+		if rest {
+			return "{" + name + "...}"
+		}
+		return "{" + name + "}"
+	}
+
+	rest := path[1:]
+	for rest != "" {
+		var segment string
+		if strings.HasPrefix(rest, "{") {
+			end := strings.Index(rest, "}")
+			if end < 0 {
+				return "", nil, fmt.Errorf("unterminated variable")
+			}
+			field, template, hasTemplate := strings.Cut(rest[1:end], "=")
+			if !hasTemplate {
+				template = "*"
+			}
+			rest = rest[end+1:]
+			name := strings.ReplaceAll(field, ".", "_")
+			param := HTTPPathParam{Field: field}
+			parts := strings.Split(template, "/")
+			for i, part := range parts {
+				switch {
+				case part == "*" || part == "**":
+					partName := name
+					if len(parts) > 1 {
+						partName = fmt.Sprintf("%s_%d", name, i)
+					}
+					if wildcards[partName] {
+						return "", nil, fmt.Errorf("variable %q bound twice", field)
+					}
+					segments = append(segments, wildcard(partName, part == "**"))
+					param.Segments = append(param.Segments, "{"+partName+"}")
+				case part == "" || strings.ContainsAny(part, "{}*"):
+					return "", nil, fmt.Errorf("invalid variable %q", field)
+				default:
+					segments = append(segments, part)
+					param.Segments = append(param.Segments, part)
+				}
+			}
+			params = append(params, param)
+		} else {
+			segment, rest, _ = strings.Cut(rest, "/")
+			if rest == "" && strings.HasSuffix(path, "/") {
+				return "", nil, fmt.Errorf("trailing /")
+			}
+			switch {
+			case segment == "*" || segment == "**":
+				segments = append(segments, wildcard(fmt.Sprintf("_%d", anonymous), segment == "**"))
+				anonymous++
+			case segment == "" || strings.ContainsAny(segment, "{}*"):
+				return "", nil, fmt.Errorf("invalid segment %q", segment)
+			default:
+				segments = append(segments, segment)
+			}
+			continue
+		}
+
+		// After a variable: the next segment, a custom verb or the end
+		switch {
+		case rest == "":
+		case strings.HasPrefix(rest, "/") && rest != "/":
+			rest = rest[1:]
+		case strings.HasPrefix(rest, ":"):
+			return "", nil, fmt.Errorf("custom verb %q after a variable", rest)
+		default:
+			return "", nil, fmt.Errorf("variable followed by %q", rest)
+		}
+	}
+	for i, segment := range segments {
+		if strings.HasSuffix(segment, "...}") && i != len(segments)-1 {
+			return "", nil, fmt.Errorf("** must be the last segment")
+		}
+	}
+	if len(segments) == 0 {
+		return "/{$}", nil, nil // "/" alone would match every path
+	}
+	return "/" + strings.Join(segments, "/"), params, nil
+}
+
+// httpField resolves a dotted request field path; every field but the last must
+// be a singular message
+func httpField(msg protoreflect.MessageDescriptor, path string) (protoreflect.FieldDescriptor, error) {
+	names := strings.Split(path, ".")
+	for i, name := range names {
+		fd := msg.Fields().ByName(protoreflect.Name(name))
+		if fd == nil {
+			return nil, fmt.Errorf("%s has no field %q", msg.FullName(), path)
+		}
+		if i == len(names)-1 {
+			return fd, nil
+		}
+		if fd.Message() == nil || fd.IsList() || fd.IsMap() {
+			return nil, fmt.Errorf("field %q of %q is not a message", name, path)
+		}
+		msg = fd.Message()
+	}
+	return nil, fmt.Errorf("empty field path")
+}
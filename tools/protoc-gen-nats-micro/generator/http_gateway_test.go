@@ -0,0 +1,48 @@
+package generator
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseHTTPPath(t *testing.T) {
+	tests := []struct {
+		path        string
+		wantPattern string
+		wantParams  []HTTPPathParam
+		wantErr     bool
+	}{
+		{"/v1/orders", "/v1/orders", nil, false},
+		{"/v1/orders/{id}", "/v1/orders/{id}", []HTTPPathParam{{Field: "id", Segments: []string{"{id}"}}}, false},
+		{"/v1/shelves/{shelf}/items/{item.id}", "/v1/shelves/{shelf}/items/{item_id}", []HTTPPathParam{
+			{Field: "shelf", Segments: []string{"{shelf}"}},
+			{Field: "item.id", Segments: []string{"{item_id}"}},
+		}, false},
+		{"/v1/{name=shelves/*/books/*}", "/v1/shelves/{name_1}/books/{name_3}", []HTTPPathParam{
+			{Field: "name", Segments: []string{"shelves", "{name_1}", "books", "{name_3}"}},
+		}, false},
+		{"/v1/files/{path=**}", "/v1/files/{path...}", []HTTPPathParam{{Field: "path", Segments: []string{"{path}"}}}, false},
+		{"/v1/*/latest", "/v1/{_0}/latest", nil, false},
+		{"/v1/orders:batchGet", "/v1/orders:batchGet", nil, false},
+		{"/", "/{$}", nil, false},
+		{"v1/orders", "", nil, true},
+		{"/v1/orders/{id}:cancel", "", nil, true},
+		{"/v1/{path=**}/raw", "", nil, true},
+		{"/v1/{id", "", nil, true},
+		{"/v1/{id}/{id}", "", nil, true},
+		{"/v1/orders/", "", nil, true},
+		{"/v1//orders", "", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			pattern, params, err := parseHTTPPath(tt.path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseHTTPPath(%q) error = %v, wantErr %v", tt.path, err, tt.wantErr)
+			}
+			if pattern != tt.wantPattern || !reflect.DeepEqual(params, tt.wantParams) {
+				t.Errorf("parseHTTPPath(%q) = %q, %+v; want %q, %+v", tt.path, pattern, params, tt.wantPattern, tt.wantParams)
+			}
+		})
+	}
+}
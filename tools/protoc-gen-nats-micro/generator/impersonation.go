@@ -0,0 +1,16 @@
+package generator
+
+import (
+	"fmt"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// validateImpersonation checks that (natsmicro.endpoint).allow_impersonation is set on
+// a unary method, the only kind that runs server interceptors
+func validateImpersonation(method protoreflect.MethodDescriptor) error {
+	if method.IsStreamingClient() || method.IsStreamingServer() {
+		return fmt.Errorf("allow_impersonation only applies to unary methods")
+	}
+	return nil
+}
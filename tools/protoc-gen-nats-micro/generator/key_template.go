@@ -3,17 +3,59 @@ package generator
 import (
 	"fmt"
 	"regexp"
+	"strconv"
 	"strings"
 
 	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/reflect/protoreflect"
 )
 
-var keyTemplatePlaceholderRe = regexp.MustCompile(`\{(\w+)\}`)
+var keyTemplatePlaceholderRe = regexp.MustCompile(`\{(\w+)((?::\w+)*)\}`)
+
+// keyModifiers lists the modifiers a placeholder can apply, as error messages show them
+var keyModifiers = []string{"sha256", "md5", "lower", "upper", "truncN"}
+
+// keyPlaceholder is one {field} or {field:modifier:...} of a key template
+type keyPlaceholder struct {
+	Field     string
+	Modifiers []string // Applied left to right, e.g., ["lower", "sha256"]
+}
+
+// parseKeyPlaceholder parses a keyTemplatePlaceholderRe submatch
+func parseKeyPlaceholder(match []string) keyPlaceholder {
+	p := keyPlaceholder{Field: match[1]}
+	if match[2] != "" {
+		p.Modifiers = strings.Split(match[2][1:], ":")
+	}
+	return p
+}
+
+// validKeyModifier reports whether name is sha256, md5, lower, upper or trunc<N>
+// with N at least 1
+func validKeyModifier(name string) bool {
+	switch name {
+	case "sha256", "md5", "lower", "upper":
+		return true
+	}
+	digits, ok := strings.CutPrefix(name, "trunc")
+	if !ok || digits == "" || digits[0] == '0' {
+		return false
+	}
+	n, err := strconv.Atoi(digits)
+	return err == nil && n > 0
+}
 
 // ValidateKeyTemplate checks that every {field} placeholder in the template
-// refers to an actual field on the method's input message. Returns an error
-// with a clear message listing available fields if a placeholder is invalid.
+// refers to an actual field on the method's input message, and that its
+// modifiers are known. Returns an error with a clear message listing available
+// fields or modifiers if a placeholder is invalid.
 func ValidateKeyTemplate(template string, method *protogen.Method) error {
+	return validateKeyTemplate(template, method.Input.Desc, method.Input.GoIdent.GoName)
+}
+
+// validateKeyTemplate checks key template placeholders against a raw message
+// descriptor. inputName is the message name used in error messages.
+func validateKeyTemplate(template string, input protoreflect.MessageDescriptor, inputName string) error {
 	matches := keyTemplatePlaceholderRe.FindAllStringSubmatch(template, -1)
 	if len(matches) == 0 {
 		return nil // No placeholders, nothing to validate
@@ -22,83 +64,142 @@ func ValidateKeyTemplate(template string, method *protogen.Method) error {
 	// Build a set of valid field names from the input message
 	validFields := make(map[string]bool)
 	var fieldNames []string
-	for _, f := range method.Input.Fields {
-		name := string(f.Desc.Name())
+	fields := input.Fields()
+	for i := 0; i < fields.Len(); i++ {
+		name := string(fields.Get(i).Name())
 		validFields[name] = true
 		fieldNames = append(fieldNames, name)
 	}
 
 	// Check each placeholder
 	for _, m := range matches {
-		fieldName := m[1]
-		if !validFields[fieldName] {
+		p := parseKeyPlaceholder(m)
+		if !validFields[p.Field] {
 			return fmt.Errorf(
 				"key_template %q references field {%s} which does not exist on input message %s (available fields: [%s])",
 				template,
-				fieldName,
-				method.Input.GoIdent.GoName,
+				p.Field,
+				inputName,
 				strings.Join(fieldNames, ", "),
 			)
 		}
+		for _, modifier := range p.Modifiers {
+			if !validKeyModifier(modifier) {
+				return fmt.Errorf(
+					"key_template %q applies unknown modifier %q to {%s} (available modifiers: [%s])",
+					template,
+					modifier,
+					p.Field,
+					strings.Join(keyModifiers, ", "),
+				)
+			}
+		}
 	}
 	return nil
 }
 
-// ResolveKeyTemplateGo converts a key template like "user.{id}" into Go code:
-// fmt.Sprintf("user.%v", msg.GetId())
+// ResolveKeyTemplateGo converts a key template like "user.{id}" into Go code for the
+// server handlers, escaping each field with the handler's token sanitizer:
+// fmt.Sprintf("user.%s", h.keyToken(msg.GetId()))
 // Panics at code-gen time if a placeholder references a nonexistent field.
 func ResolveKeyTemplateGo(template string, method *protogen.Method) string {
 	if err := ValidateKeyTemplate(template, method); err != nil {
 		panic(fmt.Sprintf("protoc-gen-nats-micro: %v", err))
 	}
+	return resolveKeyTemplateGo(template, "msg", "h.keyToken")
+}
 
+// ResolveClientKeyTemplateGo is ResolveKeyTemplateGo for the client's key helpers,
+// which build keys from req with the client's token sanitizer.
+func ResolveClientKeyTemplateGo(template string, method *protogen.Method) string {
+	if err := ValidateKeyTemplate(template, method); err != nil {
+		panic(fmt.Sprintf("protoc-gen-nats-micro: %v", err))
+	}
+	return resolveKeyTemplateGo(template, "req", "c.keyToken")
+}
+
+// resolveKeyTemplateGo renders a validated key template, passing each field of
+// msgExpr through its modifiers, then the tokenFunc expression.
+func resolveKeyTemplateGo(template, msgExpr, tokenFunc string) string {
 	matches := keyTemplatePlaceholderRe.FindAllStringSubmatch(template, -1)
 	if len(matches) == 0 {
 		return fmt.Sprintf("%q", template)
 	}
 
-	format := keyTemplatePlaceholderRe.ReplaceAllString(template, "%v")
+	format := keyTemplatePlaceholderRe.ReplaceAllString(strings.ReplaceAll(template, "%", "%%"), "%s")
 	var args []string
 	for _, m := range matches {
-		fieldName := m[1]
-		goFieldName := fieldNameToGoGetter(fieldName)
-		args = append(args, fmt.Sprintf("msg.Get%s()", goFieldName))
+		p := parseKeyPlaceholder(m)
+		field := fmt.Sprintf("%s.Get%s()", msgExpr, fieldNameToGoGetter(p.Field))
+		if len(p.Modifiers) > 0 {
+			field = fmt.Sprintf("modifyKeyField(%s, %s)", field, quoteModifiers(p.Modifiers, `"`))
+		}
+		args = append(args, fmt.Sprintf("%s(%s)", tokenFunc, field))
 	}
 
 	return fmt.Sprintf("fmt.Sprintf(%q, %s)", format, strings.Join(args, ", "))
 }
 
-// ResolveKeyTemplateTS converts a key template like "user.{id}" into TypeScript code:
-// `user.${req.id}`
+// ResolveKeyTemplateTS converts a key template like "user.{id}" into TypeScript code
+// for the server handlers: `user.${this.keyToken(req.id)}`
 // Panics at code-gen time if a placeholder references a nonexistent field.
 func ResolveKeyTemplateTS(template string, method *protogen.Method) string {
 	if err := ValidateKeyTemplate(template, method); err != nil {
 		panic(fmt.Sprintf("protoc-gen-nats-micro: %v", err))
 	}
+	return resolveKeyTemplateTS(template)
+}
 
-	result := keyTemplatePlaceholderRe.ReplaceAllStringFunc(template, func(match string) string {
-		fieldName := match[1 : len(match)-1] // strip { }
-		tsFieldName := fieldNameToTSAccessor(fieldName)
-		return fmt.Sprintf("${req.%s}", tsFieldName)
+// resolveKeyTemplateTS renders a validated key template for ResolveKeyTemplateTS
+func resolveKeyTemplateTS(template string) string {
+	result := replaceKeyPlaceholders(template, func(p keyPlaceholder) string {
+		field := "req." + fieldNameToTSAccessor(p.Field)
+		if len(p.Modifiers) > 0 {
+			field = fmt.Sprintf("modifyKeyField(%s, %s)", field, quoteModifiers(p.Modifiers, "'"))
+		}
+		return fmt.Sprintf("${this.keyToken(%s)}", field)
 	})
 	return fmt.Sprintf("`%s`", result)
 }
 
-// ResolveKeyTemplatePy converts a key template like "user.{id}" into Python code:
-// f"user.{request_msg.id}"
+// ResolveKeyTemplatePy converts a key template like "user.{id}" into Python code
+// for the server handlers: f"user.{token_sanitizer(str(request_msg.id))}"
 // Panics at code-gen time if a placeholder references a nonexistent field.
 func ResolveKeyTemplatePy(template string, method *protogen.Method) string {
 	if err := ValidateKeyTemplate(template, method); err != nil {
 		panic(fmt.Sprintf("protoc-gen-nats-micro: %v", err))
 	}
+	return resolveKeyTemplatePy(template)
+}
 
-	result := keyTemplatePlaceholderRe.ReplaceAllStringFunc(template, func(match string) string {
-		fieldName := match[1 : len(match)-1] // strip { }
-		return fmt.Sprintf("{request_msg.%s}", fieldName)
+// resolveKeyTemplatePy renders a validated key template for ResolveKeyTemplatePy
+func resolveKeyTemplatePy(template string) string {
+	result := replaceKeyPlaceholders(template, func(p keyPlaceholder) string {
+		if len(p.Modifiers) > 0 {
+			return fmt.Sprintf("{token_sanitizer(modify_key_field(request_msg.%s, %s))}", p.Field, quoteModifiers(p.Modifiers, "'"))
+		}
+		return fmt.Sprintf("{token_sanitizer(str(request_msg.%s))}", p.Field)
 	})
 	return fmt.Sprintf("f\"%s\"", result)
 }
 
+// replaceKeyPlaceholders replaces each placeholder of template with render's output
+func replaceKeyPlaceholders(template string, render func(keyPlaceholder) string) string {
+	return keyTemplatePlaceholderRe.ReplaceAllStringFunc(template, func(match string) string {
+		return render(parseKeyPlaceholder(keyTemplatePlaceholderRe.FindStringSubmatch(match)))
+	})
+}
+
+// quoteModifiers renders modifiers as a comma-separated list of string literals.
+// Modifier names are word characters, so they need no escaping.
+func quoteModifiers(modifiers []string, quote string) string {
+	quoted := make([]string, len(modifiers))
+	for i, m := range modifiers {
+		quoted[i] = quote + m + quote
+	}
+	return strings.Join(quoted, ", ")
+}
+
 // GetInputFields returns a list of field names from the method's input message type
 func GetInputFields(method *protogen.Method) []string {
 	var fields []string
@@ -45,3 +45,78 @@ func TestFieldNameToTSAccessor(t *testing.T) {
 		})
 	}
 }
+
+func TestResolveKeyTemplateGo(t *testing.T) {
+	tests := []struct {
+		template string
+		expected string
+	}{
+		{"static", `"static"`},
+		{"user.{id}", `fmt.Sprintf("user.%s", h.keyToken(msg.GetId()))`},
+		{"{org_id}.{user_id}.100%", `fmt.Sprintf("%s.%s.100%%", h.keyToken(msg.GetOrgId()), h.keyToken(msg.GetUserId()))`},
+		{"user.{email:lower:sha256}", `fmt.Sprintf("user.%s", h.keyToken(modifyKeyField(msg.GetEmail(), "lower", "sha256")))`},
+		{"{name:trunc16}.{id}", `fmt.Sprintf("%s.%s", h.keyToken(modifyKeyField(msg.GetName(), "trunc16")), h.keyToken(msg.GetId()))`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.template, func(t *testing.T) {
+			if got := resolveKeyTemplateGo(tt.template, "msg", "h.keyToken"); got != tt.expected {
+				t.Errorf("resolveKeyTemplateGo(%q) = %s, want %s", tt.template, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestResolveKeyTemplateModifiers(t *testing.T) {
+	tests := []struct {
+		template string
+		ts       string
+		py       string
+	}{
+		{
+			"user.{id}",
+			"`user.${this.keyToken(req.id)}`",
+			`f"user.{token_sanitizer(str(request_msg.id))}"`,
+		},
+		{
+			"user.{user_email:lower:md5}.{name:trunc8}",
+			"`user.${this.keyToken(modifyKeyField(req.userEmail, 'lower', 'md5'))}.${this.keyToken(modifyKeyField(req.name, 'trunc8'))}`",
+			`f"user.{token_sanitizer(modify_key_field(request_msg.user_email, 'lower', 'md5'))}.{token_sanitizer(modify_key_field(request_msg.name, 'trunc8'))}"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.template, func(t *testing.T) {
+			if got := resolveKeyTemplateTS(tt.template); got != tt.ts {
+				t.Errorf("resolveKeyTemplateTS(%q) = %s, want %s", tt.template, got, tt.ts)
+			}
+			if got := resolveKeyTemplatePy(tt.template); got != tt.py {
+				t.Errorf("resolveKeyTemplatePy(%q) = %s, want %s", tt.template, got, tt.py)
+			}
+		})
+	}
+}
+
+func TestValidKeyModifier(t *testing.T) {
+	tests := map[string]bool{
+		"sha256":  true,
+		"md5":     true,
+		"lower":   true,
+		"upper":   true,
+		"trunc1":  true,
+		"trunc16": true,
+		"trunc":   false,
+		"trunc0":  false,
+		"trunc08": false,
+		"sha1":    false,
+		"Lower":   false,
+	}
+
+	for name, want := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := validKeyModifier(name); got != want {
+				t.Errorf("validKeyModifier(%q) = %v, want %v", name, got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package generator
+
+import (
+	"fmt"
+	"time"
+)
+
+// maxKVHistory is the largest max_history JetStream KV accepts
+const maxKVHistory = 64
+
+// maxBucketReplicas is the most replicas JetStream keeps of a stream
+const maxBucketReplicas = 5
+
+// validateKVBucketSettings checks the bucket settings of a (natsmicro.kv_store)
+// option: the generated registration code creates the bucket with them
+func validateKVBucketSettings(kv *KVStoreOpts) error {
+	if kv.TTL < 0 {
+		return fmt.Errorf("kv_store ttl must not be negative")
+	}
+	if kv.MaxHistory < 0 || kv.MaxHistory > maxKVHistory {
+		return fmt.Errorf("kv_store max_history %d is out of range (0-%d)", kv.MaxHistory, maxKVHistory)
+	}
+	if kv.Replicas < 0 || kv.Replicas > maxBucketReplicas {
+		return fmt.Errorf("kv_store replicas %d is out of range (0-%d)", kv.Replicas, maxBucketReplicas)
+	}
+	if kv.LimitMarkerTTL != 0 {
+		if kv.TTL <= 0 {
+			return fmt.Errorf("kv_store limit_marker_ttl requires a ttl for entries to expire")
+		}
+		if kv.LimitMarkerTTL < time.Second {
+			return fmt.Errorf("kv_store limit_marker_ttl must be at least 1s")
+		}
+	}
+	return nil
+}
+
+// kvBucketSettingsConflict describes how prev, persisting to the same bucket as
+// kv, declares the bucket differently, or returns "" if they agree. Each method
+// creates or checks the bucket at registration, so they must.
+func kvBucketSettingsConflict(prev, kv *KVStoreOpts) string {
+	switch {
+	case prev.TTL != kv.TTL:
+		return fmt.Sprintf("ttl %s", prev.TTL)
+	case max(prev.MaxHistory, 1) != max(kv.MaxHistory, 1):
+		return fmt.Sprintf("max_history %d", max(prev.MaxHistory, 1))
+	case prev.LimitMarkerTTL != kv.LimitMarkerTTL:
+		return fmt.Sprintf("limit_marker_ttl %s", prev.LimitMarkerTTL)
+	case max(prev.Replicas, 1) != max(kv.Replicas, 1):
+		return fmt.Sprintf("replicas %d", max(prev.Replicas, 1))
+	case prev.MemoryStorage != kv.MemoryStorage:
+		return "storage " + storageName(prev.MemoryStorage)
+	}
+	return ""
+}
+
+// storageName is the StorageType value name of a storage setting
+func storageName(memory bool) string {
+	if memory {
+		return "MEMORY_STORAGE"
+	}
+	return "FILE_STORAGE"
+}
@@ -0,0 +1,15 @@
+package generator
+
+import "fmt"
+
+// validateKVConcurrency checks that (natsmicro.kv_store).concurrency = REVISION_CHECK
+// has a server-side write to check, and that retry_on_conflict is only set with it
+func validateKVConcurrency(kv *KVStoreOpts) error {
+	if kv.RetryOnConflict && !kv.RevisionCheck {
+		return fmt.Errorf("kv_store retry_on_conflict requires concurrency REVISION_CHECK")
+	}
+	if kv.RevisionCheck && kv.ClientOnly {
+		return fmt.Errorf("kv_store concurrency REVISION_CHECK does not apply to client_only buckets")
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package generator
+
+import (
+	"fmt"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// kvBuckets records the methods persisting to each KV bucket, so that methods
+// writing different response types to one bucket are caught before their readers
+// decode each other's entries
+type kvBuckets map[string][]protoreflect.MethodDescriptor
+
+// add records that method persists to kv.Bucket. A bucket holding more than one
+// response type needs a (natsmicro.kv_store).type_tag on every method, one tag
+// per type, which the generated readers check. Methods sharing a bucket must also
+// declare the same bucket settings.
+func (b kvBuckets) add(method protoreflect.MethodDescriptor, kv *KVStoreOpts) error {
+	if err := validateKVTypeTag(kv.TypeTag); err != nil {
+		return err
+	}
+	if err := validateKVBucketSettings(kv); err != nil {
+		return err
+	}
+	for _, prev := range b[kv.Bucket] {
+		prevKV := endpointOptionsFromDesc(prev).KVStore
+		if conflict := kvBucketSettingsConflict(prevKV, kv); conflict != "" {
+			return fmt.Errorf("kv_store bucket %q is declared with %s by %s; methods sharing a bucket must declare the same ttl, max_history, limit_marker_ttl, replicas and storage", kv.Bucket, conflict, prev.FullName())
+		}
+		prevTag := prevKV.TypeTag
+		prevType, typ := prev.Output().FullName(), method.Output().FullName()
+		switch {
+		case prevType == typ && prevTag != kv.TypeTag:
+			return fmt.Errorf("kv_store bucket %q holds %s with type_tag %q from %s; use the same tag", kv.Bucket, typ, prevTag, prev.FullName())
+		case prevType != typ && (prevTag == "" || kv.TypeTag == ""):
+			return fmt.Errorf("kv_store bucket %q already holds %s from %s; set a distinct type_tag on both methods", kv.Bucket, prevType, prev.FullName())
+		case prevType != typ && prevTag == kv.TypeTag:
+			return fmt.Errorf("kv_store type_tag %q in bucket %q already marks %s from %s", kv.TypeTag, kv.Bucket, prevType, prev.FullName())
+		}
+	}
+	b[kv.Bucket] = append(b[kv.Bucket], method)
+	return nil
+}
+
+// validateKVTypeTag checks that a type_tag is safe in a header and a Go string literal
+func validateKVTypeTag(tag string) error {
+	for _, r := range tag {
+		if !('a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9' || r == '-' || r == '_' || r == '.' || r == '/') {
+			return fmt.Errorf("kv_store type_tag %q may only contain letters, digits, '-', '_', '.' and '/'", tag)
+		}
+	}
+	return nil
+}
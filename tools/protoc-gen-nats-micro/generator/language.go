@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"embed"
 	"fmt"
+	"io/fs"
 	"strings"
 	"text/template"
 
@@ -38,6 +39,33 @@ type Language interface {
 	// PostGenerate is called after shared file generation for any language-specific
 	// post-processing (e.g., Python __init__.py). Default no-op in BaseLanguage.
 	PostGenerate(gen *protogen.Plugin, file *protogen.File, pkgDir string) error
+
+	// SetParams configures plugin parameters exposed to every template
+	SetParams(params Params)
+
+	// Params returns the plugin parameters set with SetParams
+	Params() Params
+}
+
+// MockLanguage is implemented by languages that can generate test doubles for
+// their clients when the mocks=true parameter is set.
+type MockLanguage interface {
+	Language
+
+	// MockFileExtension returns the extension of the mock file (e.g., "_nats_mock.pb.go")
+	MockFileExtension() string
+
+	// GenerateMocks generates test doubles for every service in file
+	GenerateMocks(g *protogen.GeneratedFile, file *protogen.File) error
+}
+
+// CLILanguage is implemented by languages that can generate a command-line client
+// per service when the cli=true parameter is set.
+type CLILanguage interface {
+	Language
+
+	// GenerateCLI generates the command-line client of one service
+	GenerateCLI(g *protogen.GeneratedFile, file *protogen.File, service *protogen.Service) error
 }
 
 // TemplateData holds data passed to templates
@@ -45,6 +73,8 @@ type TemplateData struct {
 	File    *protogen.File
 	Service *protogen.Service
 	Options ServiceOptions
+	Params  Params
+	Layout  OutputLayout // Where the generated code goes relative to the .pb files
 }
 
 // BaseLanguage provides a reusable implementation of Language backed by Go templates.
@@ -56,11 +86,12 @@ type BaseLanguage struct {
 	headerTemplates  []string // Templates to execute for GenerateHeader
 	sharedTemplates  []string // Templates to execute for GenerateShared
 	serviceTemplates []string // Templates to execute for Generate (per-service)
+	params           Params   // Plugin parameters passed to every template
 }
 
 // newBaseLanguage constructs a BaseLanguage with parsed templates from the embedded FS.
 func newBaseLanguage(name, extension, glob string, headerTmpls, sharedTmpls, serviceTmpls []string) BaseLanguage {
-	tmpl := template.Must(template.New(name).Funcs(FuncMap()).ParseFS(templatesFS, glob))
+	tmpl := template.Must(parseTemplates(templatesFS, name, glob))
 	return BaseLanguage{
 		name:             name,
 		extension:        extension,
@@ -75,6 +106,9 @@ func (b *BaseLanguage) Name() string          { return b.name }
 func (b *BaseLanguage) FileExtension() string { return b.extension }
 func (b *BaseLanguage) IsGoLike() bool        { return false }
 
+func (b *BaseLanguage) SetParams(params Params) { b.params = params }
+func (b *BaseLanguage) Params() Params          { return b.params }
+
 func (b *BaseLanguage) PostGenerate(gen *protogen.Plugin, file *protogen.File, pkgDir string) error {
 	return nil
 }
@@ -91,11 +125,44 @@ func (b *BaseLanguage) Generate(g *protogen.GeneratedFile, file *protogen.File,
 	return b.executeTemplates(g, TemplateData{File: file, Service: service, Options: opts}, b.serviceTemplates)
 }
 
+// parseTemplates parses the templates matching glob with CRLF line endings normalized,
+// so a checkout with Windows line endings generates byte-identical output.
+func parseTemplates(fsys fs.FS, name, glob string) (*template.Template, error) {
+	paths, err := fs.Glob(fsys, glob)
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no templates match %s", glob)
+	}
+
+	tmpl := template.New(name).Funcs(FuncMap())
+	for _, path := range paths {
+		content, err := fs.ReadFile(fsys, path)
+		if err != nil {
+			return nil, err
+		}
+		text := strings.ReplaceAll(string(content), "\r\n", "\n")
+		if _, err := tmpl.New(path[strings.LastIndex(path, "/")+1:]).Parse(text); err != nil {
+			return nil, err
+		}
+	}
+	return tmpl, nil
+}
+
 // executeTemplates runs each named template in order, writing output to g.
 func (b *BaseLanguage) executeTemplates(g *protogen.GeneratedFile, data TemplateData, templateNames []string) error {
+	data.Params = b.params
+	data.Layout = newOutputLayout(data.File, b.params, b.extension)
+	tmpl := b.templates
+	if data.Layout.Subdir != "" {
+		// Go code under out_dir_template is a package of its own; qualify the
+		// identifiers of the .pb.go and _grpc.pb.go files with their import
+		tmpl = template.Must(tmpl.Clone()).Funcs(qualifiedFuncMap(g, data.File))
+	}
 	for _, name := range templateNames {
 		var buf bytes.Buffer
-		if err := b.templates.ExecuteTemplate(&buf, name, data); err != nil {
+		if err := tmpl.ExecuteTemplate(&buf, name, data); err != nil {
 			return fmt.Errorf("execute template %s: %w", name, err)
 		}
 		g.P(buf.String())
@@ -113,23 +180,97 @@ func FuncMap() template.FuncMap {
 		"ToCamelCase":        ToCamelCase,
 		"ToPascalCase":       ToPascalCase,
 		"ToKebabCase":        ToKebabCase,
+		"ToUpper":            strings.ToUpper,
+		"GetServiceOptions":  GetServiceOptions,
 		"GetEndpointOptions": GetEndpointOptions,
 		"GetMethodOptions":   GetEndpointOptions, // Alias for consistency
 		"ProtoBasename":      ProtoBasename,
+		"SourcePath":         SourcePath,
 		// Streaming detection
 		"IsServerStreaming": IsServerStreaming,
 		"IsClientStreaming": IsClientStreaming,
 		"IsBidiStreaming":   IsBidiStreaming,
 		"IsUnary":           IsUnary,
 		// KV/ObjectStore key template resolution
-		"ResolveKeyTemplateGo": ResolveKeyTemplateGo,
-		"ResolveKeyTemplateTS": ResolveKeyTemplateTS,
-		"ResolveKeyTemplatePy": ResolveKeyTemplatePy,
+		"ResolveKeyTemplateGo":       ResolveKeyTemplateGo,
+		"ResolveClientKeyTemplateGo": ResolveClientKeyTemplateGo,
+		"ResolveKeyTemplateTS":       ResolveKeyTemplateTS,
+		"ResolveKeyTemplatePy":       ResolveKeyTemplatePy,
+		// persist_if conditions on the response
+		"PersistIfGo": PersistIfGo,
+		"PersistIfTS": PersistIfTS,
+		"PersistIfPy": PersistIfPy,
+		// KV and Object Store buckets a service provisions
+		"ServiceBuckets": ServiceBuckets,
 		// Method field accessors
 		"GetInputFields": GetInputFields,
+		// Subject resolution (honors per-method subject overrides)
+		"MethodSubject":   MethodSubject,
+		"EndpointName":    EndpointName,
+		"ServiceSubjects": ServiceSubjects,
+		"SubjectExprGo":   SubjectExprGo,
+		"SubjectExprTS":   SubjectExprTS,
+		"SubjectExprPy":   SubjectExprPy,
+		"SubjectExprCs":   SubjectExprCs,
+		// subject_template endpoints
+		"SubjectTemplateFieldsGo": SubjectTemplateFieldsGo,
+		// Request enrichment accessors
+		"EnrichAccessors": EnrichAccessors,
+		// (natsmicro.endpoint).headers accessors and checks
+		"HeaderAccessors": HeaderAccessors,
+		"RequiredHeaders": RequiredHeaders,
+		// google.api.http bindings served with http_gateway=true
+		"HTTPBindings": HTTPBindings,
+		// Typed pipes between stream pairs
+		"StreamPipes": StreamPipes,
+		// Page-following helpers for paginated methods
+		"GetPagination": GetPagination,
+		// Service descriptor blob behind $reflect and endpoint metadata
+		"GetServiceSchema": GetServiceSchema,
+		// Literals of (natsmicro.endpoint).required_scopes
+		"GoStringSlice": GoStringSlice,
+		// Command-line clients (cli=true)
+		"CLIName": CLIName,
+		// Random message constructors (fuzz_helpers=true)
+		"RandomMessages": RandomMessages,
+		// (natsmicro.field).sensitive fields for RedactMessage
+		"SensitiveFields": SensitiveFields,
+		// (natsmicro.event) messages
+		"Events":               Events,
+		"EventSubject":         EventSubject,
+		"EventUseJSON":         EventUseJSON,
+		"EventSensitiveFields": EventSensitiveFields,
+		// natsmicro.Code tables, the same in every language
+		"StatusCodes": StatusCodes,
+		// google.protobuf.Empty handling
+		"IsEmptyMessage": IsEmptyMessage,
+		"GoMessageType":  GoMessageType,
+		"PBIdent":        PBIdent,
+		"PyMessageType":  PyMessageType,
+		"CsMessageType":  CsMessageType,
+		"CsNamespace":    CsNamespace,
+		"MethodUseJSON":  MethodUseJSON,
+		"EmptyShortcuts": EmptyShortcuts,
+	}
+}
+
+// qualifiedFuncMap returns the helpers of FuncMap that write Go identifiers,
+// qualified for g when they live in another package than g's own
+func qualifiedFuncMap(g *protogen.GeneratedFile, file *protogen.File) template.FuncMap {
+	qualify := func(ident protogen.GoIdent) string { return g.QualifiedGoIdent(ident) }
+	return template.FuncMap{
+		"GoMessageType": func(msg *protogen.Message) string { return goMessageType(msg, qualify) },
+		"GetPagination": func(method *protogen.Method) *Pagination { return getPagination(method, qualify) },
+		"PBIdent": func(name string) string {
+			return qualify(protogen.GoIdent{GoName: name, GoImportPath: file.GoImportPath})
+		},
 	}
 }
 
+// PBIdent returns a Go identifier declared by the .pb.go or _grpc.pb.go code of
+// the proto file, as written in generated Go code in its package
+func PBIdent(name string) string { return name }
+
 // ProtoBasename returns the base name of a proto file without extension
 // e.g., "path/to/service.proto" -> "service"
 func ProtoBasename(filename string) string {
@@ -167,24 +308,6 @@ func ToPascalCase(s string) string {
 	return strings.Join(parts, "")
 }
 
-// ToKebabCase converts CamelCase to kebab-case
-func ToKebabCase(s string) string {
-	var result strings.Builder
-	runes := []rune(s)
-	for i, r := range runes {
-		if i > 0 && r >= 'A' && r <= 'Z' {
-			prev := runes[i-1]
-			if prev >= 'a' && prev <= 'z' {
-				result.WriteByte('-')
-			} else if i+1 < len(runes) && runes[i+1] >= 'a' && runes[i+1] <= 'z' {
-				result.WriteByte('-')
-			}
-		}
-		result.WriteRune(r)
-	}
-	return strings.ToLower(result.String())
-}
-
 // GetLanguage returns a language generator by name
 func GetLanguage(name string) (Language, error) {
 	switch strings.ToLower(name) {
@@ -196,6 +319,8 @@ func GetLanguage(name string) (Language, error) {
 		return NewPythonLanguage(), nil
 	case "web-ts", "webts":
 		return NewWebTSLanguage(), nil
+	case "csharp", "cs", "c#":
+		return NewCSharpLanguage(), nil
 	default:
 		return nil, fmt.Errorf("unsupported language: %s", name)
 	}
@@ -0,0 +1,135 @@
+package generator
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"google.golang.org/protobuf/compiler/protogen"
+)
+
+// outDirPlaceholderRe matches the placeholders of out_dir_template
+var outDirPlaceholderRe = regexp.MustCompile(`\{[^}]*\}`)
+
+// OutputLayout describes where the generated code of one proto file goes, as
+// arranged by the out_suffix=, out_dir_template= and file_per_service= parameters.
+// Templates use it to import the .pb files and the shared file.
+type OutputLayout struct {
+	Subdir        string                 // out_dir_template expanded for the file ("" = the default output directory)
+	Suffix        string                 // Ends the name of every generated file, e.g. "_nats.pb.go"
+	GoImportPath  protogen.GoImportPath  // Import path of the generated Go package
+	GoPackageName protogen.GoPackageName // Name of the generated Go package
+}
+
+// newOutputLayout returns the layout of file for params, with extension as the
+// language's default suffix
+func newOutputLayout(file *protogen.File, params Params, extension string) OutputLayout {
+	layout := OutputLayout{
+		Subdir:        expandOutDirTemplate(params.OutDirTemplate, file),
+		Suffix:        extension,
+		GoImportPath:  file.GoImportPath,
+		GoPackageName: file.GoPackageName,
+	}
+	if params.OutSuffix != "" {
+		layout.Suffix = params.OutSuffix
+	}
+	if layout.Subdir != "" {
+		// A subdirectory is a Go package of its own, named after its last element
+		layout.GoImportPath = protogen.GoImportPath(path.Join(string(file.GoImportPath), layout.Subdir))
+		layout.GoPackageName = goPackageName(path.Base(layout.Subdir))
+	}
+	return layout
+}
+
+// SharedModule returns the module name TypeScript and Python code imports the
+// shared file by, e.g., "shared_nats.pb"
+func (l OutputLayout) SharedModule() string {
+	name := "shared" + l.Suffix
+	return strings.TrimSuffix(name, path.Ext(name))
+}
+
+// ProtoDir returns the directory of the .pb files relative to the generated
+// code, as a TypeScript import path prefix: "." or, under out_dir_template, "..", "../.." etc.
+func (l OutputLayout) ProtoDir() string {
+	if l.Subdir == "" {
+		return "."
+	}
+	return strings.TrimSuffix(strings.Repeat("../", strings.Count(l.Subdir, "/")+1), "/")
+}
+
+// PyProtoPackage returns the relative Python package of the _pb2 modules as seen
+// from the generated code: "." or, under out_dir_template, "..", "..." etc.
+func (l OutputLayout) PyProtoPackage() string {
+	if l.Subdir == "" {
+		return "."
+	}
+	return strings.Repeat(".", strings.Count(l.Subdir, "/")+2)
+}
+
+// outputDir returns the directory of lang's generated code for file: the
+// directory of its default output, plus the expanded out_dir_template
+func outputDir(file *protogen.File, lang Language) string {
+	return path.Join(path.Dir(outputFilenamePrefix(file, lang)), expandOutDirTemplate(lang.Params().OutDirTemplate, file))
+}
+
+// outputSuffix returns the suffix of lang's generated file names
+func outputSuffix(lang Language) string {
+	if suffix := lang.Params().OutSuffix; suffix != "" {
+		return suffix
+	}
+	return lang.FileExtension()
+}
+
+// outputFilename returns the path of lang's generated code for file or, with
+// file_per_service=true, for service, e.g., "order/v1/natsrpc/order_service.nats.go"
+func outputFilename(file *protogen.File, service *protogen.Service, lang Language) string {
+	name := path.Base(outputFilenamePrefix(file, lang))
+	if service != nil {
+		name = ToSnakeCase(service.GoName)
+	}
+	return path.Join(outputDir(file, lang), name+outputSuffix(lang))
+}
+
+// expandOutDirTemplate fills in the placeholders of an out_dir_template for file:
+// {package} is the proto package as a path (order.v1 -> order/v1), {proto} the
+// proto file's base name without extension
+func expandOutDirTemplate(template string, file *protogen.File) string {
+	if template == "" {
+		return ""
+	}
+	dir := strings.NewReplacer(
+		"{package}", strings.ReplaceAll(string(file.Desc.Package()), ".", "/"),
+		"{proto}", ProtoBasename(file.Desc.Path()),
+	).Replace(template)
+	if dir = path.Clean(dir); dir == "." {
+		return ""
+	}
+	return dir
+}
+
+// validateOutDirTemplate checks that an out_dir_template stays inside the default
+// output directory and uses only the {package} and {proto} placeholders
+func validateOutDirTemplate(template string) error {
+	for _, placeholder := range outDirPlaceholderRe.FindAllString(template, -1) {
+		if placeholder != "{package}" && placeholder != "{proto}" {
+			return fmt.Errorf("invalid value %q for parameter out_dir_template: unknown placeholder %s, want {package} or {proto}", template, placeholder)
+		}
+	}
+	clean := path.Clean(template)
+	if template == "" || path.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, "../") {
+		return fmt.Errorf("invalid value %q for parameter out_dir_template: want a relative directory inside the default output directory", template)
+	}
+	return nil
+}
+
+// goPackageName turns a directory name into a Go package name
+func goPackageName(dir string) protogen.GoPackageName {
+	name := []rune(dir)
+	for i, r := range name {
+		if !(r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || i > 0 && r >= '0' && r <= '9') {
+			name[i] = '_'
+		}
+	}
+	return protogen.GoPackageName(name)
+}
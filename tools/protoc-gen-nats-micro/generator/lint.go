@@ -0,0 +1,423 @@
+package generator
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	natspb "github.com/toyz/protoc-gen-nats-micro/tools/protoc-gen-nats-micro/nats/micro"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// Severity classifies a lint finding
+type Severity string
+
+const (
+	SeverityError   Severity = "error"
+	SeverityWarning Severity = "warning"
+)
+
+// Lint rule identifiers reported in Finding.Rule
+const (
+	RuleKeyTemplate       = "key-template"
+	RuleSubjectCollision  = "subject-collision"
+	RuleInvalidSubject    = "invalid-subject"
+	RuleInvalidQueueGroup = "invalid-queue-group"
+	RuleMissingPrefix     = "missing-subject-prefix"
+	RulePersistenceConfig = "persistence-config"
+	RuleEmptyService      = "empty-service"
+	RuleJSONInt64         = "json-int64"
+	RuleEnrichConfig      = "enrich-config"
+	RuleFireAndForget     = "fire-and-forget"
+	RuleMiddlewares       = "middlewares"
+	RuleImpersonation     = "impersonation"
+	RuleEncoding          = "encoding"
+	RuleVersionInSubject  = "version-in-subject"
+	RulePagination        = "pagination"
+	RuleRequiredScopes    = "required-scopes"
+	RuleScatterGather     = "scatter-gather"
+	RuleSubjectTemplate   = "subject-template"
+	RuleHeaders           = "headers"
+)
+
+// Finding is a single lint result with a source location resolved from SourceCodeInfo.
+// Line and Column are 1-based; both are 0 when the descriptor set carries no source info.
+type Finding struct {
+	File     string   `json:"file"`
+	Line     int      `json:"line"`
+	Column   int      `json:"column"`
+	Severity Severity `json:"severity"`
+	Rule     string   `json:"rule"`
+	Message  string   `json:"message"`
+}
+
+func (f Finding) String() string {
+	return fmt.Sprintf("%s:%d:%d: %s: %s [%s]", f.File, f.Line, f.Column, f.Severity, f.Message, f.Rule)
+}
+
+// HasErrors reports whether any finding has error severity
+func HasErrors(findings []Finding) bool {
+	for _, f := range findings {
+		if f.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+// LintDescriptorSet runs all generation-time validations against a FileDescriptorSet
+// (e.g., produced by `buf build -o set.binpb` or `protoc --include_imports -o`).
+// The set must be self-contained: every import has to be present.
+func LintDescriptorSet(set *descriptorpb.FileDescriptorSet) ([]Finding, error) {
+	files, err := protodesc.NewFiles(set)
+	if err != nil {
+		return nil, fmt.Errorf("build descriptors: %w", err)
+	}
+
+	var fds []protoreflect.FileDescriptor
+	for _, fdp := range set.GetFile() {
+		fd, err := files.FindFileByPath(fdp.GetName())
+		if err != nil {
+			return nil, fmt.Errorf("find file %s: %w", fdp.GetName(), err)
+		}
+		fds = append(fds, fd)
+	}
+	return Lint(fds), nil
+}
+
+// Lint runs all generation-time validations against the given files.
+// Subject collisions are checked across every file, since NATS subjects share one namespace.
+func Lint(files []protoreflect.FileDescriptor) []Finding {
+	l := &linter{
+		subjects:   make(map[string]protoreflect.FullName),
+		enrichKeys: make(map[string]protoreflect.MethodDescriptor),
+		headerKeys: make(map[string]protoreflect.MethodDescriptor),
+		kvBuckets:  make(kvBuckets),
+		objBuckets: make(objectBuckets),
+	}
+	for _, fd := range files {
+		services := fd.Services()
+		for i := 0; i < services.Len(); i++ {
+			l.lintService(services.Get(i))
+		}
+	}
+
+	sort.SliceStable(l.findings, func(i, j int) bool {
+		a, b := l.findings[i], l.findings[j]
+		if a.File != b.File {
+			return a.File < b.File
+		}
+		return a.Line < b.Line
+	})
+	return l.findings
+}
+
+type linter struct {
+	findings   []Finding
+	subjects   map[string]protoreflect.FullName         // subject -> method that first claimed it
+	enrichKeys map[string]protoreflect.MethodDescriptor // package/context_key -> method that first claimed it
+	headerKeys map[string]protoreflect.MethodDescriptor // package/header accessor -> method that first claimed it
+	kvBuckets  kvBuckets                                // bucket -> methods persisting to it
+	objBuckets objectBuckets                            // Object Store bucket -> method that first declared it
+}
+
+func (l *linter) report(desc protoreflect.Descriptor, severity Severity, rule, format string, args ...any) {
+	file := desc.ParentFile()
+	f := Finding{
+		File:     file.Path(),
+		Severity: severity,
+		Rule:     rule,
+		Message:  fmt.Sprintf(format, args...),
+	}
+	if loc := file.SourceLocations().ByDescriptor(desc); loc.Path != nil {
+		f.Line = loc.StartLine + 1
+		f.Column = loc.StartColumn + 1
+	}
+	l.findings = append(l.findings, f)
+}
+
+func (l *linter) lintService(svc protoreflect.ServiceDescriptor) {
+	opts := serviceOptionsFromDesc(svc, string(svc.Name()))
+	if opts.Skip {
+		return
+	}
+
+	if svcOpts, ok := getExtension[*natspb.ServiceOptions](svc.Options(), natspb.E_Service); !ok || svcOpts.SubjectPrefix == "" {
+		l.report(svc, SeverityWarning, RuleMissingPrefix,
+			"service %s has no (natsmicro.service).subject_prefix; defaulting to %q", svc.FullName(), opts.SubjectPrefix)
+	}
+
+	if opts.QueueGroup != "" {
+		if err := ValidateSubject(opts.QueueGroup); err != nil {
+			l.report(svc, SeverityError, RuleInvalidQueueGroup,
+				"%s: invalid (natsmicro.service).queue_group: %v", svc.FullName(), err)
+		}
+	}
+
+	if opts.VersionToken != "" {
+		if err := ValidateSubject(opts.VersionToken); err != nil || opts.VersionToken == "v" {
+			l.report(svc, SeverityError, RuleVersionInSubject,
+				"%s: version %q has no major component usable as a subject token for version_in_subject", svc.FullName(), opts.Version)
+		}
+	}
+
+	methods := svc.Methods()
+	generated := 0
+	for i := 0; i < methods.Len(); i++ {
+		method := methods.Get(i)
+		eopts := endpointOptionsFromDesc(method)
+		if eopts.Skip {
+			continue
+		}
+		generated++
+
+		subject := opts.VersionedPrefix() + "." + endpointName(string(method.Name()))
+		if eopts.Subject != "" {
+			subject = eopts.Subject
+			if err := ValidateSubject(subject); err != nil {
+				l.report(method, SeverityError, RuleInvalidSubject,
+					"%s: invalid (natsmicro.endpoint).subject: %v", method.FullName(), err)
+			}
+		}
+		if eopts.SubjectTemplate != "" {
+			subject = eopts.ExactSubject()
+			if err := validateSubjectTemplate(method, eopts); err != nil {
+				l.report(method, SeverityError, RuleSubjectTemplate, "%s: %v", method.FullName(), err)
+			}
+		}
+		if owner, exists := l.subjects[subject]; exists {
+			l.report(method, SeverityError, RuleSubjectCollision,
+				"subject %q of %s collides with %s", subject, method.FullName(), owner)
+		} else {
+			l.subjects[subject] = method.FullName()
+		}
+
+		l.lintPersistence(method, eopts)
+		if eopts.FireAndForget {
+			if err := validateFireAndForget(method, eopts); err != nil {
+				l.report(method, SeverityError, RuleFireAndForget, "%s: %v", method.FullName(), err)
+			}
+		}
+		if len(eopts.Middlewares) > 0 {
+			if err := validateMiddlewares(method, eopts.Middlewares); err != nil {
+				l.report(method, SeverityError, RuleMiddlewares, "%s: %v", method.FullName(), err)
+			}
+		}
+		if err := validateRequiredScopes(eopts.RequiredScopes); err != nil {
+			l.report(method, SeverityError, RuleRequiredScopes, "%s: %v", method.FullName(), err)
+		}
+		if err := validateEncoding(eopts.Encoding); err != nil {
+			l.report(method, SeverityError, RuleEncoding, "%s: %v", method.FullName(), err)
+		}
+		if eopts.AllowImpersonation {
+			if err := validateImpersonation(method); err != nil {
+				l.report(method, SeverityError, RuleImpersonation, "%s: %v", method.FullName(), err)
+			}
+		}
+		if eopts.Enrich != nil {
+			l.lintEnrich(method, eopts.Enrich)
+		}
+		if len(eopts.Headers) > 0 {
+			l.lintHeaders(method, eopts)
+		}
+		if eopts.Paginated {
+			if err := validatePagination(method); err != nil {
+				l.report(method, SeverityError, RulePagination, "%s: %v", method.FullName(), err)
+			}
+		}
+		if eopts.ScatterGather {
+			if err := validateScatterGather(method, eopts); err != nil {
+				l.report(method, SeverityError, RuleScatterGather, "%s: %v", method.FullName(), err)
+			}
+		}
+	}
+
+	if msg := jsonInt64Warning(svc, opts); msg != "" {
+		l.report(svc, SeverityWarning, RuleJSONInt64, "%s", msg)
+	}
+
+	if generated == 0 {
+		l.report(svc, SeverityWarning, RuleEmptyService,
+			"service %s has no methods to generate", svc.FullName())
+	}
+}
+
+func (l *linter) lintPersistence(method protoreflect.MethodDescriptor, eopts EndpointOptions) {
+	streaming := method.IsStreamingClient() || method.IsStreamingServer()
+
+	if kvOpts, ok := getExtension[*natspb.KVStoreOptions](method.Options(), natspb.E_KvStore); ok {
+		switch {
+		case kvOpts.Bucket == "":
+			l.report(method, SeverityError, RulePersistenceConfig,
+				"%s sets (natsmicro.kv_store) without a bucket", method.FullName())
+		case kvOpts.KeyTemplate == "":
+			l.report(method, SeverityError, RulePersistenceConfig,
+				"%s sets (natsmicro.kv_store) without a key_template", method.FullName())
+		}
+		if streaming {
+			l.report(method, SeverityError, RulePersistenceConfig,
+				"%s is a streaming method; (natsmicro.kv_store) only applies to unary methods", method.FullName())
+		}
+	}
+	if eopts.KVStore != nil {
+		if err := validateKVConcurrency(eopts.KVStore); err != nil {
+			l.report(method, SeverityError, RulePersistenceConfig, "%s: %v", method.FullName(), err)
+		}
+		if err := l.kvBuckets.add(method, eopts.KVStore); err != nil {
+			l.report(method, SeverityError, RulePersistenceConfig, "%s: %v", method.FullName(), err)
+		}
+		if err := validatePersistIf(method, "kv_store", eopts.KVStore.PersistIf, eopts.KVStore.ClientOnly); err != nil {
+			l.report(method, SeverityError, RulePersistenceConfig, "%s: %v", method.FullName(), err)
+		}
+	}
+	if eopts.KVStore != nil && eopts.KVStore.KeyTemplate != "" {
+		if err := validateKeyTemplate(eopts.KVStore.KeyTemplate, method.Input(), string(method.Input().Name())); err != nil {
+			l.report(method, SeverityError, RuleKeyTemplate, "%s: %v", method.FullName(), err)
+		}
+	}
+
+	if objOpts, ok := getExtension[*natspb.ObjectStoreOptions](method.Options(), natspb.E_ObjectStore); ok {
+		if objOpts.Bucket == "" {
+			l.report(method, SeverityError, RulePersistenceConfig,
+				"%s sets (natsmicro.object_store) without a bucket", method.FullName())
+		}
+		if streaming {
+			l.report(method, SeverityError, RulePersistenceConfig,
+				"%s is a streaming method; (natsmicro.object_store) only applies to unary methods", method.FullName())
+		}
+	}
+	if eopts.ObjectStore != nil {
+		if err := validateKeyTemplate(eopts.ObjectStore.KeyTemplate, method.Input(), string(method.Input().Name())); err != nil {
+			l.report(method, SeverityError, RuleKeyTemplate, "%s: %v", method.FullName(), err)
+		}
+		if err := validatePersistIf(method, "object_store", eopts.ObjectStore.PersistIf, eopts.ObjectStore.ClientOnly); err != nil {
+			l.report(method, SeverityError, RulePersistenceConfig, "%s: %v", method.FullName(), err)
+		}
+		if err := l.objBuckets.add(method, eopts.ObjectStore); err != nil {
+			l.report(method, SeverityError, RulePersistenceConfig, "%s: %v", method.FullName(), err)
+		}
+	}
+	if eopts.Stream != nil {
+		if err := validateStreamPersistence(method, eopts.Stream); err != nil {
+			l.report(method, SeverityError, RulePersistenceConfig, "%s: %v", method.FullName(), err)
+		}
+	}
+}
+
+func (l *linter) lintEnrich(method protoreflect.MethodDescriptor, enrich *EnrichOpts) {
+	if err := validateEnrich(method, enrich); err != nil {
+		l.report(method, SeverityError, RuleEnrichConfig, "%s: %v", method.FullName(), err)
+		return
+	}
+
+	// Each context_key generates one accessor per package, declared by a single file
+	key := string(method.ParentFile().Package()) + "/" + enrich.ContextKey
+	owner, exists := l.enrichKeys[key]
+	if !exists {
+		l.enrichKeys[key] = method
+		return
+	}
+	ownerType := endpointOptionsFromDesc(owner).Enrich.Type
+	switch {
+	case ownerType != enrich.Type:
+		l.report(method, SeverityError, RuleEnrichConfig,
+			"%s: (natsmicro.enrich) context_key %q is already bound to %s by %s", method.FullName(), enrich.ContextKey, ownerType, owner.FullName())
+	case owner.ParentFile().Path() != method.ParentFile().Path():
+		l.report(method, SeverityError, RuleEnrichConfig,
+			"%s: (natsmicro.enrich) context_key %q is already declared in %s; generated accessors would collide", method.FullName(), enrich.ContextKey, owner.ParentFile().Path())
+	}
+}
+
+func (l *linter) lintHeaders(method protoreflect.MethodDescriptor, eopts EndpointOptions) {
+	if err := validateHeaders(method, eopts); err != nil {
+		l.report(method, SeverityError, RuleHeaders, "%s: %v", method.FullName(), err)
+		return
+	}
+
+	// Each accessor is declared once per package, by a single file
+	for _, header := range eopts.Headers {
+		key := string(method.ParentFile().Package()) + "/" + header.Accessor
+		owner, exists := l.headerKeys[key]
+		if !exists {
+			l.headerKeys[key] = method
+			continue
+		}
+		var ownerName string
+		for _, declared := range endpointOptionsFromDesc(owner).Headers {
+			if declared.Accessor == header.Accessor {
+				ownerName = declared.Name
+			}
+		}
+		switch {
+		case !strings.EqualFold(ownerName, header.Name):
+			l.report(method, SeverityError, RuleHeaders,
+				"%s: header %q generates %sFromContext, as header %q of %s does; set accessor", method.FullName(), header.Name, header.Accessor, ownerName, owner.FullName())
+		case owner.ParentFile().Path() != method.ParentFile().Path():
+			l.report(method, SeverityError, RuleHeaders,
+				"%s: header %q is already declared in %s; generated accessors would collide", method.FullName(), header.Name, owner.ParentFile().Path())
+		}
+	}
+}
+
+// jsonInt64Warning describes the precision risk of a JSON service that emits 64-bit
+// integers as numbers, or returns "" when the service is not affected.
+func jsonInt64Warning(svc protoreflect.ServiceDescriptor, opts ServiceOptions) string {
+	if !opts.UseJSON || !opts.JSONInt64AsNumber {
+		return ""
+	}
+	fields := int64JSONFields(svc)
+	if len(fields) == 0 {
+		return ""
+	}
+	names := make([]string, len(fields))
+	for i, f := range fields {
+		names[i] = string(f)
+	}
+	return fmt.Sprintf("service %s encodes 64-bit integers as JSON numbers (json_int64_as_number); "+
+		"values above 2^53 lose precision in JavaScript: %s", svc.FullName(), strings.Join(names, ", "))
+}
+
+// int64JSONFields returns the 64-bit integer fields reachable from the request and
+// response messages of the service's generated methods, in declaration order.
+// Well-known types are skipped since they keep their own JSON mapping.
+func int64JSONFields(svc protoreflect.ServiceDescriptor) []protoreflect.FullName {
+	var fields []protoreflect.FullName
+	seen := make(map[protoreflect.FullName]bool)
+
+	var walk func(md protoreflect.MessageDescriptor)
+	walk = func(md protoreflect.MessageDescriptor) {
+		if seen[md.FullName()] || md.ParentFile().Package() == "google.protobuf" {
+			return
+		}
+		seen[md.FullName()] = true
+
+		mdFields := md.Fields()
+		for i := 0; i < mdFields.Len(); i++ {
+			fd := mdFields.Get(i)
+			value := fd
+			if fd.IsMap() {
+				value = fd.MapValue() // Map keys are always JSON strings
+			}
+			switch value.Kind() {
+			case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind,
+				protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+				fields = append(fields, fd.FullName())
+			case protoreflect.MessageKind, protoreflect.GroupKind:
+				walk(value.Message())
+			}
+		}
+	}
+
+	methods := svc.Methods()
+	for i := 0; i < methods.Len(); i++ {
+		method := methods.Get(i)
+		if endpointOptionsFromDesc(method).Skip {
+			continue
+		}
+		walk(method.Input())
+		walk(method.Output())
+	}
+	return fields
+}
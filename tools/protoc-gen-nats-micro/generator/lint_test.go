@@ -0,0 +1,662 @@
+package generator
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	natspb "github.com/toyz/protoc-gen-nats-micro/tools/protoc-gen-nats-micro/nats/micro"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// lintFixture builds a single-file descriptor set with one request/response pair
+// and the given services.
+func lintFixture(services ...*descriptorpb.ServiceDescriptorProto) *descriptorpb.FileDescriptorSet {
+	return &descriptorpb.FileDescriptorSet{
+		File: []*descriptorpb.FileDescriptorProto{{
+			Name:    proto.String("fixture/v1/service.proto"),
+			Package: proto.String("fixture.v1"),
+			Syntax:  proto.String("proto3"),
+			MessageType: []*descriptorpb.DescriptorProto{
+				{
+					Name: proto.String("Req"),
+					Field: []*descriptorpb.FieldDescriptorProto{{
+						Name:     proto.String("id"),
+						Number:   proto.Int32(1),
+						Type:     descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum(),
+						Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+						JsonName: proto.String("id"),
+					}},
+				},
+				{Name: proto.String("Resp")},
+			},
+			Service: services,
+		}},
+	}
+}
+
+func lintService(name, prefix string, methods ...*descriptorpb.MethodDescriptorProto) *descriptorpb.ServiceDescriptorProto {
+	svc := &descriptorpb.ServiceDescriptorProto{Name: proto.String(name), Method: methods}
+	if prefix != "" {
+		svc.Options = &descriptorpb.ServiceOptions{}
+		proto.SetExtension(svc.Options, natspb.E_Service, &natspb.ServiceOptions{SubjectPrefix: prefix})
+	}
+	return svc
+}
+
+func lintMethod(name string, configure func(*descriptorpb.MethodOptions)) *descriptorpb.MethodDescriptorProto {
+	m := &descriptorpb.MethodDescriptorProto{
+		Name:       proto.String(name),
+		InputType:  proto.String(".fixture.v1.Req"),
+		OutputType: proto.String(".fixture.v1.Resp"),
+	}
+	if configure != nil {
+		m.Options = &descriptorpb.MethodOptions{}
+		configure(m.Options)
+	}
+	return m
+}
+
+func runLintFixture(t *testing.T, set *descriptorpb.FileDescriptorSet) []Finding {
+	t.Helper()
+	findings, err := LintDescriptorSet(set)
+	if err != nil {
+		t.Fatalf("LintDescriptorSet returned unexpected error: %v", err)
+	}
+	return findings
+}
+
+func findingsByRule(findings []Finding, rule string) []Finding {
+	var out []Finding
+	for _, f := range findings {
+		if f.Rule == rule {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+func TestLintClean(t *testing.T) {
+	set := lintFixture(lintService("OrderService", "api.orders", lintMethod("GetOrder", nil)))
+	if findings := runLintFixture(t, set); len(findings) != 0 {
+		t.Errorf("expected no findings, got %v", findings)
+	}
+}
+
+func TestLintViolations(t *testing.T) {
+	tests := []struct {
+		name     string
+		services []*descriptorpb.ServiceDescriptorProto
+		rule     string
+		severity Severity
+		contains string
+	}{
+		{
+			name:     "missing prefix",
+			services: []*descriptorpb.ServiceDescriptorProto{lintService("OrderService", "", lintMethod("GetOrder", nil))},
+			rule:     RuleMissingPrefix,
+			severity: SeverityWarning,
+			contains: `defaulting to "order_service"`,
+		},
+		{
+			name:     "empty service",
+			services: []*descriptorpb.ServiceDescriptorProto{lintService("OrderService", "api.orders")},
+			rule:     RuleEmptyService,
+			severity: SeverityWarning,
+			contains: "no methods",
+		},
+		{
+			name: "subject collision within service",
+			services: []*descriptorpb.ServiceDescriptorProto{
+				lintService("OrderService", "api.orders", lintMethod("GetOrder", nil), lintMethod("getOrder", nil)),
+			},
+			rule:     RuleSubjectCollision,
+			severity: SeverityError,
+			contains: `"api.orders.get_order"`,
+		},
+		{
+			name: "subject collision across services",
+			services: []*descriptorpb.ServiceDescriptorProto{
+				lintService("OrderService", "api", lintMethod("Get", nil)),
+				lintService("ProductService", "api", lintMethod("Get", nil)),
+			},
+			rule:     RuleSubjectCollision,
+			severity: SeverityError,
+			contains: "fixture.v1.OrderService.Get",
+		},
+		{
+			name: "subject override collides with prefixed subject",
+			services: []*descriptorpb.ServiceDescriptorProto{
+				lintService("OrderService", "api.orders",
+					lintMethod("GetOrder", nil),
+					lintMethod("FetchOrder", func(o *descriptorpb.MethodOptions) {
+						proto.SetExtension(o, natspb.E_Endpoint, &natspb.EndpointOptions{Subject: "api.orders.get_order"})
+					}),
+				),
+			},
+			rule:     RuleSubjectCollision,
+			severity: SeverityError,
+			contains: "fixture.v1.OrderService.FetchOrder",
+		},
+		{
+			name: "wildcard subject override",
+			services: []*descriptorpb.ServiceDescriptorProto{
+				lintService("OrderService", "api.orders", lintMethod("GetOrder", func(o *descriptorpb.MethodOptions) {
+					proto.SetExtension(o, natspb.E_Endpoint, &natspb.EndpointOptions{Subject: "orders.*"})
+				})),
+			},
+			rule:     RuleInvalidSubject,
+			severity: SeverityError,
+			contains: "wildcard",
+		},
+		{
+			name: "queue group with whitespace",
+			services: []*descriptorpb.ServiceDescriptorProto{func() *descriptorpb.ServiceDescriptorProto {
+				svc := lintService("OrderService", "", lintMethod("GetOrder", nil))
+				svc.Options = &descriptorpb.ServiceOptions{}
+				proto.SetExtension(svc.Options, natspb.E_Service, &natspb.ServiceOptions{SubjectPrefix: "api.orders", QueueGroup: "order workers"})
+				return svc
+			}()},
+			rule:     RuleInvalidQueueGroup,
+			severity: SeverityError,
+			contains: "whitespace",
+		},
+		{
+			name: "version in subject without a usable major version",
+			services: []*descriptorpb.ServiceDescriptorProto{func() *descriptorpb.ServiceDescriptorProto {
+				svc := lintService("OrderService", "", lintMethod("GetOrder", nil))
+				svc.Options = &descriptorpb.ServiceOptions{}
+				proto.SetExtension(svc.Options, natspb.E_Service, &natspb.ServiceOptions{SubjectPrefix: "api.orders", Version: "next release", VersionInSubject: true})
+				return svc
+			}()},
+			rule:     RuleVersionInSubject,
+			severity: SeverityError,
+			contains: `"next release"`,
+		},
+		{
+			name: "key template references unknown field",
+			services: []*descriptorpb.ServiceDescriptorProto{
+				lintService("OrderService", "api.orders", lintMethod("GetOrder", func(o *descriptorpb.MethodOptions) {
+					proto.SetExtension(o, natspb.E_KvStore, &natspb.KVStoreOptions{Bucket: "orders", KeyTemplate: "order.{order_id}"})
+				})),
+			},
+			rule:     RuleKeyTemplate,
+			severity: SeverityError,
+			contains: "{order_id}",
+		},
+		{
+			name: "key template applies unknown modifier",
+			services: []*descriptorpb.ServiceDescriptorProto{
+				lintService("OrderService", "api.orders", lintMethod("GetOrder", func(o *descriptorpb.MethodOptions) {
+					proto.SetExtension(o, natspb.E_KvStore, &natspb.KVStoreOptions{Bucket: "orders", KeyTemplate: "order.{id:sha1}"})
+				})),
+			},
+			rule:     RuleKeyTemplate,
+			severity: SeverityError,
+			contains: `unknown modifier "sha1" to {id} (available modifiers: [sha256, md5, lower, upper, truncN])`,
+		},
+		{
+			name: "kv store without bucket",
+			services: []*descriptorpb.ServiceDescriptorProto{
+				lintService("OrderService", "api.orders", lintMethod("GetOrder", func(o *descriptorpb.MethodOptions) {
+					proto.SetExtension(o, natspb.E_KvStore, &natspb.KVStoreOptions{KeyTemplate: "order.{id}"})
+				})),
+			},
+			rule:     RulePersistenceConfig,
+			severity: SeverityError,
+			contains: "without a bucket",
+		},
+		{
+			name: "kv store max history out of range",
+			services: []*descriptorpb.ServiceDescriptorProto{
+				lintService("OrderService", "api.orders", lintMethod("GetOrder", func(o *descriptorpb.MethodOptions) {
+					proto.SetExtension(o, natspb.E_KvStore, &natspb.KVStoreOptions{Bucket: "orders", KeyTemplate: "order.{id}", MaxHistory: 100})
+				})),
+			},
+			rule:     RulePersistenceConfig,
+			severity: SeverityError,
+			contains: "max_history 100",
+		},
+		{
+			name: "object store on streaming method",
+			services: []*descriptorpb.ServiceDescriptorProto{
+				lintService("OrderService", "api.orders", func() *descriptorpb.MethodDescriptorProto {
+					m := lintMethod("WatchOrders", func(o *descriptorpb.MethodOptions) {
+						proto.SetExtension(o, natspb.E_ObjectStore, &natspb.ObjectStoreOptions{Bucket: "orders"})
+					})
+					m.ServerStreaming = proto.Bool(true)
+					return m
+				}()),
+			},
+			rule:     RulePersistenceConfig,
+			severity: SeverityError,
+			contains: "only applies to unary methods",
+		},
+		{
+			name: "enrich type not defined",
+			services: []*descriptorpb.ServiceDescriptorProto{
+				lintService("OrderService", "api.orders", lintMethod("GetOrder", func(o *descriptorpb.MethodOptions) {
+					proto.SetExtension(o, natspb.E_Enrich, &natspb.EnrichOptions{Bucket: "profiles", KeyTemplate: "user.{id}", ContextKey: "profile", Type: "fixture.v1.Profile"})
+				})),
+			},
+			rule:     RuleEnrichConfig,
+			severity: SeverityError,
+			contains: `"fixture.v1.Profile" is not defined`,
+		},
+		{
+			name: "enrich key template references unknown field",
+			services: []*descriptorpb.ServiceDescriptorProto{
+				lintService("OrderService", "api.orders", lintMethod("GetOrder", func(o *descriptorpb.MethodOptions) {
+					proto.SetExtension(o, natspb.E_Enrich, &natspb.EnrichOptions{Bucket: "profiles", KeyTemplate: "user.{customer_id}", ContextKey: "profile", Type: "fixture.v1.Resp"})
+				})),
+			},
+			rule:     RuleEnrichConfig,
+			severity: SeverityError,
+			contains: "{customer_id}",
+		},
+		{
+			name: "enrich context key bound to two types",
+			services: []*descriptorpb.ServiceDescriptorProto{
+				lintService("OrderService", "api.orders",
+					lintMethod("GetOrder", func(o *descriptorpb.MethodOptions) {
+						proto.SetExtension(o, natspb.E_Enrich, &natspb.EnrichOptions{Bucket: "profiles", KeyTemplate: "user.{id}", ContextKey: "profile", Type: "fixture.v1.Resp"})
+					}),
+					lintMethod("ListOrders", func(o *descriptorpb.MethodOptions) {
+						proto.SetExtension(o, natspb.E_Enrich, &natspb.EnrichOptions{Bucket: "profiles", KeyTemplate: "user.{id}", ContextKey: "profile", Type: "fixture.v1.Req"})
+					}),
+				),
+			},
+			rule:     RuleEnrichConfig,
+			severity: SeverityError,
+			contains: "already bound to fixture.v1.Resp",
+		},
+		{
+			name: "enrich on streaming method",
+			services: []*descriptorpb.ServiceDescriptorProto{
+				lintService("OrderService", "api.orders", func() *descriptorpb.MethodDescriptorProto {
+					m := lintMethod("WatchOrders", func(o *descriptorpb.MethodOptions) {
+						proto.SetExtension(o, natspb.E_Enrich, &natspb.EnrichOptions{Bucket: "profiles", KeyTemplate: "user.{id}", ContextKey: "profile", Type: "fixture.v1.Resp"})
+					})
+					m.ServerStreaming = proto.Bool(true)
+					return m
+				}()),
+			},
+			rule:     RuleEnrichConfig,
+			severity: SeverityError,
+			contains: "only applies to unary methods",
+		},
+		{
+			name: "fire and forget with response fields",
+			services: []*descriptorpb.ServiceDescriptorProto{
+				lintService("OrderService", "api.orders", func() *descriptorpb.MethodDescriptorProto {
+					m := lintMethod("NotifyOrder", func(o *descriptorpb.MethodOptions) {
+						proto.SetExtension(o, natspb.E_Endpoint, &natspb.EndpointOptions{FireAndForget: true})
+					})
+					m.OutputType = proto.String(".fixture.v1.Req")
+					return m
+				}()),
+			},
+			rule:     RuleFireAndForget,
+			severity: SeverityError,
+			contains: "got fixture.v1.Req",
+		},
+		{
+			name: "fire and forget on streaming method",
+			services: []*descriptorpb.ServiceDescriptorProto{
+				lintService("OrderService", "api.orders", func() *descriptorpb.MethodDescriptorProto {
+					m := lintMethod("WatchOrders", func(o *descriptorpb.MethodOptions) {
+						proto.SetExtension(o, natspb.E_Endpoint, &natspb.EndpointOptions{FireAndForget: true})
+					})
+					m.ServerStreaming = proto.Bool(true)
+					return m
+				}()),
+			},
+			rule:     RuleFireAndForget,
+			severity: SeverityError,
+			contains: "only applies to unary methods",
+		},
+		{
+			name: "middlewares on streaming method",
+			services: []*descriptorpb.ServiceDescriptorProto{
+				lintService("OrderService", "api.orders", func() *descriptorpb.MethodDescriptorProto {
+					m := lintMethod("WatchOrders", func(o *descriptorpb.MethodOptions) {
+						proto.SetExtension(o, natspb.E_Endpoint, &natspb.EndpointOptions{Middlewares: []string{"auth"}})
+					})
+					m.ServerStreaming = proto.Bool(true)
+					return m
+				}()),
+			},
+			rule:     RuleMiddlewares,
+			severity: SeverityError,
+			contains: "only apply to unary methods",
+		},
+		{
+			name: "duplicate middleware",
+			services: []*descriptorpb.ServiceDescriptorProto{
+				lintService("OrderService", "api.orders",
+					lintMethod("GetOrder", func(o *descriptorpb.MethodOptions) {
+						proto.SetExtension(o, natspb.E_Endpoint, &natspb.EndpointOptions{Middlewares: []string{"auth", "audit", "auth"}})
+					}),
+				),
+			},
+			rule:     RuleMiddlewares,
+			severity: SeverityError,
+			contains: `middleware "auth" is listed more than once`,
+		},
+		{
+			name: "impersonation on streaming method",
+			services: []*descriptorpb.ServiceDescriptorProto{
+				lintService("OrderService", "api.orders", func() *descriptorpb.MethodDescriptorProto {
+					m := lintMethod("UploadOrders", func(o *descriptorpb.MethodOptions) {
+						proto.SetExtension(o, natspb.E_Endpoint, &natspb.EndpointOptions{AllowImpersonation: true})
+					})
+					m.ClientStreaming = proto.Bool(true)
+					return m
+				}()),
+			},
+			rule:     RuleImpersonation,
+			severity: SeverityError,
+			contains: "allow_impersonation only applies to unary methods",
+		},
+		{
+			name: "duplicate required scope",
+			services: []*descriptorpb.ServiceDescriptorProto{
+				lintService("OrderService", "api.orders",
+					lintMethod("GetOrder", func(o *descriptorpb.MethodOptions) {
+						proto.SetExtension(o, natspb.E_Endpoint, &natspb.EndpointOptions{RequiredScopes: []string{"orders:read", "orders:read"}})
+					}),
+				),
+			},
+			rule:     RuleRequiredScopes,
+			severity: SeverityError,
+			contains: `required scope "orders:read" is listed more than once`,
+		},
+		{
+			name: "required scope with whitespace",
+			services: []*descriptorpb.ServiceDescriptorProto{
+				lintService("OrderService", "api.orders",
+					lintMethod("GetOrder", func(o *descriptorpb.MethodOptions) {
+						proto.SetExtension(o, natspb.E_Endpoint, &natspb.EndpointOptions{RequiredScopes: []string{"orders:read orders:write"}})
+					}),
+				),
+			},
+			rule:     RuleRequiredScopes,
+			severity: SeverityError,
+			contains: "contains whitespace or a comma",
+		},
+		{
+			name: "scatter_gather on a streaming method",
+			services: []*descriptorpb.ServiceDescriptorProto{
+				lintService("OrderService", "api.orders", func() *descriptorpb.MethodDescriptorProto {
+					m := lintMethod("WatchOrders", func(o *descriptorpb.MethodOptions) {
+						proto.SetExtension(o, natspb.E_Endpoint, &natspb.EndpointOptions{ScatterGather: true})
+					})
+					m.ServerStreaming = proto.Bool(true)
+					return m
+				}()),
+			},
+			rule:     RuleScatterGather,
+			severity: SeverityError,
+			contains: "only applies to unary methods",
+		},
+		{
+			name: "subject_template on an unknown field",
+			services: []*descriptorpb.ServiceDescriptorProto{
+				lintService("OrderService", "api.orders",
+					lintMethod("CreateOrder", func(o *descriptorpb.MethodOptions) {
+						proto.SetExtension(o, natspb.E_Endpoint, &natspb.EndpointOptions{SubjectTemplate: "orders.{region}.create"})
+					}),
+				),
+			},
+			rule:     RuleSubjectTemplate,
+			severity: SeverityError,
+			contains: "references field {region} which does not exist",
+		},
+		{
+			name: "header declared twice",
+			services: []*descriptorpb.ServiceDescriptorProto{
+				lintService("OrderService", "api.orders",
+					lintMethod("GetOrder", func(o *descriptorpb.MethodOptions) {
+						proto.SetExtension(o, natspb.E_Endpoint, &natspb.EndpointOptions{Headers: []*natspb.HeaderOptions{{Name: "x-tenant-id"}, {Name: "X-Tenant-Id"}}})
+					}),
+				),
+			},
+			rule:     RuleHeaders,
+			severity: SeverityError,
+			contains: `header "X-Tenant-Id" is declared more than once`,
+		},
+		{
+			name: "header accessors colliding across methods",
+			services: []*descriptorpb.ServiceDescriptorProto{
+				lintService("OrderService", "api.orders",
+					lintMethod("GetOrder", func(o *descriptorpb.MethodOptions) {
+						proto.SetExtension(o, natspb.E_Endpoint, &natspb.EndpointOptions{Headers: []*natspb.HeaderOptions{{Name: "x-tenant"}}})
+					}),
+					lintMethod("ListOrders", func(o *descriptorpb.MethodOptions) {
+						proto.SetExtension(o, natspb.E_Endpoint, &natspb.EndpointOptions{Headers: []*natspb.HeaderOptions{{Name: "tenant"}}})
+					}),
+				),
+			},
+			rule:     RuleHeaders,
+			severity: SeverityError,
+			contains: `header "tenant" generates TenantFromContext, as header "x-tenant"`,
+		},
+		{
+			name: "unknown encoding",
+			services: []*descriptorpb.ServiceDescriptorProto{
+				lintService("OrderService", "api.orders",
+					lintMethod("GetOrder", func(o *descriptorpb.MethodOptions) {
+						proto.SetExtension(o, natspb.E_Endpoint, &natspb.EndpointOptions{Encoding: "xml"})
+					}),
+				),
+			},
+			rule:     RuleEncoding,
+			severity: SeverityError,
+			contains: `unknown encoding "xml"`,
+		},
+		{
+			name: "retry_on_conflict without revision check",
+			services: []*descriptorpb.ServiceDescriptorProto{
+				lintService("ProfileService", "api.profiles",
+					lintMethod("SaveProfile", func(o *descriptorpb.MethodOptions) {
+						proto.SetExtension(o, natspb.E_KvStore, &natspb.KVStoreOptions{Bucket: "profiles", KeyTemplate: "p.{id}", RetryOnConflict: true})
+					}),
+				),
+			},
+			rule:     RulePersistenceConfig,
+			severity: SeverityError,
+			contains: "retry_on_conflict requires concurrency REVISION_CHECK",
+		},
+		{
+			name: "revision check on a client_only bucket",
+			services: []*descriptorpb.ServiceDescriptorProto{
+				lintService("ProfileService", "api.profiles",
+					lintMethod("SaveProfile", func(o *descriptorpb.MethodOptions) {
+						proto.SetExtension(o, natspb.E_KvStore, &natspb.KVStoreOptions{Bucket: "profiles", KeyTemplate: "p.{id}", Concurrency: natspb.KVStoreOptions_REVISION_CHECK, ClientOnly: true})
+					}),
+				),
+			},
+			rule:     RulePersistenceConfig,
+			severity: SeverityError,
+			contains: "REVISION_CHECK does not apply to client_only buckets",
+		},
+		{
+			name: "persist_if names a missing field",
+			services: []*descriptorpb.ServiceDescriptorProto{
+				lintService("ProfileService", "api.profiles", lintMethod("SaveProfile", func(o *descriptorpb.MethodOptions) {
+					proto.SetExtension(o, natspb.E_KvStore, &natspb.KVStoreOptions{Bucket: "profiles", KeyTemplate: "p.{id}", PersistIf: "complete"})
+				})),
+			},
+			rule:     RulePersistenceConfig,
+			severity: SeverityError,
+			contains: `persist_if "complete" is not a field of fixture.v1.Resp`,
+		},
+		{
+			name: "bucket shared with different ttls",
+			services: []*descriptorpb.ServiceDescriptorProto{
+				lintService("ProfileService", "api.profiles",
+					lintMethod("SaveProfile", func(o *descriptorpb.MethodOptions) {
+						proto.SetExtension(o, natspb.E_KvStore, &natspb.KVStoreOptions{Bucket: "profiles", KeyTemplate: "p.{id}", Ttl: durationpb.New(time.Hour)})
+					}),
+					lintMethod("TouchProfile", func(o *descriptorpb.MethodOptions) {
+						proto.SetExtension(o, natspb.E_KvStore, &natspb.KVStoreOptions{Bucket: "profiles", KeyTemplate: "p.{id}", Ttl: durationpb.New(time.Minute)})
+					}),
+				),
+			},
+			rule:     RulePersistenceConfig,
+			severity: SeverityError,
+			contains: `bucket "profiles" is declared with ttl 1h0m0s by fixture.v1.ProfileService.SaveProfile`,
+		},
+		{
+			name: "bucket shared by two response types without type tags",
+			services: []*descriptorpb.ServiceDescriptorProto{
+				lintService("ProfileService", "api.profiles",
+					lintMethod("SaveProfile", func(o *descriptorpb.MethodOptions) {
+						proto.SetExtension(o, natspb.E_KvStore, &natspb.KVStoreOptions{Bucket: "profiles", KeyTemplate: "p.{id}"})
+					}),
+				),
+				lintService("DraftService", "api.drafts", func() *descriptorpb.MethodDescriptorProto {
+					m := lintMethod("SaveDraft", func(o *descriptorpb.MethodOptions) {
+						proto.SetExtension(o, natspb.E_KvStore, &natspb.KVStoreOptions{Bucket: "profiles", KeyTemplate: "d.{id}"})
+					})
+					m.OutputType = proto.String(".fixture.v1.Req")
+					return m
+				}()),
+			},
+			rule:     RulePersistenceConfig,
+			severity: SeverityError,
+			contains: `bucket "profiles" already holds fixture.v1.Resp from fixture.v1.ProfileService.SaveProfile`,
+		},
+		{
+			name: "paginated without a page token",
+			services: []*descriptorpb.ServiceDescriptorProto{
+				lintService("OrderService", "api.orders", lintMethod("ListOrders", func(o *descriptorpb.MethodOptions) {
+					proto.SetExtension(o, natspb.E_Endpoint, &natspb.EndpointOptions{Paginated: true})
+				})),
+			},
+			rule:     RulePagination,
+			severity: SeverityError,
+			contains: "string page_token field in fixture.v1.Req",
+		},
+		{
+			name: "stream persistence on a bidi stream",
+			services: []*descriptorpb.ServiceDescriptorProto{
+				lintService("ChatService", "api.chat", func() *descriptorpb.MethodDescriptorProto {
+					m := lintMethod("Chat", func(o *descriptorpb.MethodOptions) {
+						proto.SetExtension(o, natspb.E_Stream, &natspb.StreamOptions{Persistence: true})
+					})
+					m.ClientStreaming = proto.Bool(true)
+					m.ServerStreaming = proto.Bool(true)
+					return m
+				}()),
+			},
+			rule:     RulePersistenceConfig,
+			severity: SeverityError,
+			contains: "persistence only applies to server-streaming methods",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			findings := findingsByRule(runLintFixture(t, lintFixture(tt.services...)), tt.rule)
+			if len(findings) != 1 {
+				t.Fatalf("expected 1 %s finding, got %v", tt.rule, findings)
+			}
+			f := findings[0]
+			if f.Severity != tt.severity {
+				t.Errorf("severity = %q, want %q", f.Severity, tt.severity)
+			}
+			if !strings.Contains(f.Message, tt.contains) {
+				t.Errorf("message %q does not contain %q", f.Message, tt.contains)
+			}
+			if f.File != "fixture/v1/service.proto" {
+				t.Errorf("file = %q, want fixture/v1/service.proto", f.File)
+			}
+			if HasErrors(findings) != (tt.severity == SeverityError) {
+				t.Errorf("HasErrors = %v for severity %q", HasErrors(findings), tt.severity)
+			}
+		})
+	}
+}
+
+func TestLintSkipsSkippedServicesAndMethods(t *testing.T) {
+	skipped := lintService("LegacyService", "")
+	skipped.Options = &descriptorpb.ServiceOptions{}
+	proto.SetExtension(skipped.Options, natspb.E_Service, &natspb.ServiceOptions{Skip: true})
+
+	set := lintFixture(
+		skipped,
+		lintService("OrderService", "api.orders",
+			lintMethod("GetOrder", nil),
+			lintMethod("getOrder", func(o *descriptorpb.MethodOptions) {
+				proto.SetExtension(o, natspb.E_Endpoint, &natspb.EndpointOptions{Skip: true})
+			}),
+		),
+	)
+	if findings := runLintFixture(t, set); len(findings) != 0 {
+		t.Errorf("expected no findings, got %v", findings)
+	}
+}
+
+func TestLintSourceLocation(t *testing.T) {
+	set := lintFixture(lintService("OrderService", "api.orders"))
+	// Service 0 of the file lives at path [6, 0] (FileDescriptorProto.service = 6)
+	set.File[0].SourceCodeInfo = &descriptorpb.SourceCodeInfo{
+		Location: []*descriptorpb.SourceCodeInfo_Location{{
+			Path: []int32{6, 0},
+			Span: []int32{11, 0, 14, 1},
+		}},
+	}
+
+	findings := runLintFixture(t, set)
+	if len(findings) != 1 {
+		t.Fatalf("expected 1 finding, got %v", findings)
+	}
+	if findings[0].Line != 12 || findings[0].Column != 1 {
+		t.Errorf("location = %d:%d, want 12:1", findings[0].Line, findings[0].Column)
+	}
+	if got, want := findings[0].String(), "fixture/v1/service.proto:12:1: warning:"; !strings.HasPrefix(got, want) {
+		t.Errorf("String() = %q, want prefix %q", got, want)
+	}
+}
+
+func TestLintJSONInt64(t *testing.T) {
+	tests := []struct {
+		name          string
+		int64AsNumber bool
+		want          int
+	}{
+		{"strings by default", false, 0},
+		{"numbers opt-in", true, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := lintService("LedgerService", "", lintMethod("GetBalance", nil))
+			svc.Options = &descriptorpb.ServiceOptions{}
+			proto.SetExtension(svc.Options, natspb.E_Service, &natspb.ServiceOptions{
+				SubjectPrefix:     "api.ledger",
+				Json:              true,
+				JsonInt64AsNumber: tt.int64AsNumber,
+			})
+			set := lintFixture(svc)
+			set.File[0].MessageType[1].Field = []*descriptorpb.FieldDescriptorProto{{
+				Name:     proto.String("units"),
+				Number:   proto.Int32(1),
+				Type:     descriptorpb.FieldDescriptorProto_TYPE_INT64.Enum(),
+				Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+				JsonName: proto.String("units"),
+			}}
+
+			findings := findingsByRule(runLintFixture(t, set), RuleJSONInt64)
+			if len(findings) != tt.want {
+				t.Fatalf("expected %d %s findings, got %v", tt.want, RuleJSONInt64, findings)
+			}
+			if tt.want > 0 && !strings.Contains(findings[0].Message, "fixture.v1.Resp.units") {
+				t.Errorf("message %q does not name fixture.v1.Resp.units", findings[0].Message)
+			}
+		})
+	}
+}
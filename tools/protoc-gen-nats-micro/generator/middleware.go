@@ -0,0 +1,26 @@
+package generator
+
+import (
+	"fmt"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// validateMiddlewares checks that (natsmicro.endpoint).middlewares is set on a unary
+// method and names each middleware once
+func validateMiddlewares(method protoreflect.MethodDescriptor, names []string) error {
+	if method.IsStreamingClient() || method.IsStreamingServer() {
+		return fmt.Errorf("middlewares only apply to unary methods")
+	}
+	seen := make(map[string]bool, len(names))
+	for _, name := range names {
+		if name == "" {
+			return fmt.Errorf("middlewares contains an empty name")
+		}
+		if seen[name] {
+			return fmt.Errorf("middleware %q is listed more than once", name)
+		}
+		seen[name] = true
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package generator
+
+import (
+	"fmt"
+
+	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// objectBuckets records the first method declaring each Object Store bucket, so
+// that methods sharing a bucket are caught declaring it differently
+type objectBuckets map[string]protoreflect.MethodDescriptor
+
+// add records that method declares obj.Bucket. Registration provisions each
+// bucket once, from the settings of the first method declaring it, so methods
+// sharing a bucket must declare the same settings.
+func (b objectBuckets) add(method protoreflect.MethodDescriptor, obj *ObjectStoreOpts) error {
+	if obj.TTL < 0 {
+		return fmt.Errorf("object_store ttl must not be negative")
+	}
+	if obj.Replicas < 0 || obj.Replicas > maxBucketReplicas {
+		return fmt.Errorf("object_store replicas %d is out of range (0-%d)", obj.Replicas, maxBucketReplicas)
+	}
+	prev, ok := b[obj.Bucket]
+	if !ok {
+		b[obj.Bucket] = method
+		return nil
+	}
+	prevObj := endpointOptionsFromDesc(prev).ObjectStore
+	var conflict string
+	switch {
+	case prevObj.TTL != obj.TTL:
+		conflict = fmt.Sprintf("ttl %s", prevObj.TTL)
+	case max(prevObj.Replicas, 1) != max(obj.Replicas, 1):
+		conflict = fmt.Sprintf("replicas %d", max(prevObj.Replicas, 1))
+	case prevObj.MemoryStorage != obj.MemoryStorage:
+		conflict = "storage " + storageName(prevObj.MemoryStorage)
+	default:
+		return nil
+	}
+	return fmt.Errorf("object_store bucket %q is declared with %s by %s; methods sharing a bucket must declare the same ttl, replicas and storage", obj.Bucket, conflict, prev.FullName())
+}
+
+// StoreBucket is a KV or Object Store bucket declared by a service's method
+// options, which its registration provisions
+type StoreBucket struct {
+	KV      *KVStoreOpts     // Settings of the first method declaring a KV bucket (nil for Object Store)
+	Object  *ObjectStoreOpts // Settings of the first method declaring an Object Store bucket (nil for KV)
+	Methods string           // Go names of the methods declaring it, comma-separated
+}
+
+// ServiceBuckets returns the buckets the (natsmicro.kv_store) and
+// (natsmicro.object_store) options of service's methods declare, each once, in
+// the order they are first declared
+func ServiceBuckets(service *protogen.Service) []*StoreBucket {
+	var buckets []*StoreBucket
+	byName := make(map[string]*StoreBucket)
+	declare := func(key string, method *protogen.Method, bucket StoreBucket) {
+		if b, ok := byName[key]; ok {
+			b.Methods += ", " + method.GoName
+			return
+		}
+		bucket.Methods = method.GoName
+		byName[key] = &bucket
+		buckets = append(buckets, &bucket)
+	}
+	for _, method := range service.Methods {
+		eopts := GetEndpointOptions(method)
+		if eopts.KVStore != nil {
+			declare("kv:"+eopts.KVStore.Bucket, method, StoreBucket{KV: eopts.KVStore})
+		}
+		if eopts.ObjectStore != nil {
+			declare("object:"+eopts.ObjectStore.Bucket, method, StoreBucket{Object: eopts.ObjectStore})
+		}
+	}
+	return buckets
+}
@@ -9,6 +9,7 @@ import (
 	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/reflect/protoreflect"
 	"google.golang.org/protobuf/runtime/protoimpl"
+	"google.golang.org/protobuf/types/descriptorpb"
 )
 
 // getExtension is a generic helper that checks for and retrieves a proto extension
@@ -33,11 +34,22 @@ type ServiceOptions struct {
 	Skip          bool     // Skip generation for this service
 	UseJSON       bool     // Use JSON encoding instead of binary protobuf
 	ErrorCodes    []string // Custom application-specific error codes
+	QueueGroup    string   // Endpoint queue group ("" = NATS micro default)
+	RequireTLS    bool     // Refuse plaintext connections (Go only)
+	VersionToken  string   // Subject token from version_in_subject, e.g. "v2" ("" = none)
+
+	JSONInt64AsNumber bool // Emit 64-bit integers as JSON numbers (Go only)
 }
 
 // GetServiceOptions extracts service options from proto service definition
 func GetServiceOptions(service *protogen.Service) ServiceOptions {
-	serviceName := service.GoName
+	return serviceOptionsFromDesc(service.Desc, service.GoName)
+}
+
+// serviceOptionsFromDesc extracts service options from a raw service descriptor.
+// serviceName is used to derive the defaults (name, subject prefix, description),
+// so callers without protogen can pass the descriptor's own name.
+func serviceOptionsFromDesc(desc protoreflect.ServiceDescriptor, serviceName string) ServiceOptions {
 	subjectPrefix := ToSnakeCase(serviceName)
 
 	// Defaults
@@ -51,7 +63,7 @@ func GetServiceOptions(service *protogen.Service) ServiceOptions {
 	}
 
 	// Try to read the nats.micro.service extension
-	if svcOpts, ok := getExtension[*natspb.ServiceOptions](service.Desc.Options(), natspb.E_Service); ok {
+	if svcOpts, ok := getExtension[*natspb.ServiceOptions](desc.Options(), natspb.E_Service); ok {
 		// Check skip first - if true, mark it and return early
 		if svcOpts.Skip {
 			opts.Skip = true
@@ -77,6 +89,12 @@ func GetServiceOptions(service *protogen.Service) ServiceOptions {
 			opts.Timeout = svcOpts.Timeout.AsDuration()
 		}
 		opts.UseJSON = svcOpts.Json
+		opts.JSONInt64AsNumber = svcOpts.JsonInt64AsNumber
+		opts.QueueGroup = svcOpts.QueueGroup
+		opts.RequireTLS = svcOpts.RequireTls
+		if svcOpts.VersionInSubject {
+			opts.VersionToken = SubjectVersionToken(opts.Version)
+		}
 		if len(svcOpts.ErrorCodes) > 0 {
 			opts.ErrorCodes = svcOpts.ErrorCodes
 		}
@@ -85,14 +103,48 @@ func GetServiceOptions(service *protogen.Service) ServiceOptions {
 	return opts
 }
 
+// VersionedPrefix returns the subject prefix endpoints are registered under:
+// SubjectPrefix, followed by VersionToken with version_in_subject
+func (o ServiceOptions) VersionedPrefix() string {
+	return VersionedSubjectPrefix(o.SubjectPrefix, o.VersionToken)
+}
+
 // EndpointOptions contains metadata about an endpoint
 type EndpointOptions struct {
-	Skip        bool              // Skip generation for this endpoint
-	Timeout     time.Duration     // Endpoint-specific timeout (0 = use service default)
-	Metadata    map[string]string // Endpoint-specific metadata
-	KVStore     *KVStoreOpts      // KV store options (nil if not set)
-	ObjectStore *ObjectStoreOpts  // Object store options (nil if not set)
-	Stream      *StreamOpts       // Streaming options (nil if not set)
+	Skip               bool              // Skip generation for this endpoint
+	Timeout            time.Duration     // Endpoint-specific timeout (0 = use service default)
+	Metadata           map[string]string // Endpoint-specific metadata
+	Subject            string            // Exact subject override ("" = <prefix>.<method>)
+	FireAndForget      bool              // Publish without waiting for a response
+	Middlewares        []string          // Named server middlewares, in order
+	AllowImpersonation bool              // Accept X-Impersonate requests
+	Encoding           string            // "json" or "binary" ("" = service default)
+	Paginated          bool              // Generate a <Method>All client helper that follows page tokens
+	RequiredScopes     []string          // Scopes a caller must all hold (empty = public)
+	ScatterGather      bool              // Generate a <Method>Gather client call answered by every instance
+	SubjectTemplate    string            // Subject with {field} tokens filled from the request ("" = none)
+	Headers            []HeaderOpts      // Request headers the endpoint declares, in order
+	Idempotent         bool              // idempotency_level is NO_SIDE_EFFECTS or IDEMPOTENT, so identical calls can share a response
+	KVStore            *KVStoreOpts      // KV store options (nil if not set)
+	ObjectStore        *ObjectStoreOpts  // Object store options (nil if not set)
+	Stream             *StreamOpts       // Streaming options (nil if not set)
+	Enrich             *EnrichOpts       // Request enrichment options (nil if not set)
+}
+
+// ExactSubject returns the subject the endpoint is registered at outside the
+// service prefix: the subject override, or the wildcard form of the subject
+// template. "" means <prefix>.<method>.
+func (o EndpointOptions) ExactSubject() string {
+	if o.SubjectTemplate != "" && o.Subject == "" {
+		return SubjectTemplateWildcard(o.SubjectTemplate)
+	}
+	return o.Subject
+}
+
+// PersistentStream reports whether the method is a server stream backed by
+// JetStream, per (natsmicro.stream).persistence
+func (o EndpointOptions) PersistentStream() bool {
+	return o.Stream != nil && o.Stream.Persistence
 }
 
 // KVStoreOpts contains KV store persistence options for a method
@@ -103,6 +155,17 @@ type KVStoreOpts struct {
 	Description string        // Human-readable bucket description
 	MaxHistory  int32         // Revisions per key (0 = default 1, max 64)
 	ClientOnly  bool          // Skip server auto-persist; only generate client read/write
+
+	RevisionCheck   bool // Persist with the revision read before the handler (concurrency = REVISION_CHECK)
+	RetryOnConflict bool // Re-run the handler when the revision check fails
+
+	TypeTag string // Stored with each entry and checked by readers ("" = untagged)
+
+	LimitMarkerTTL time.Duration // How long expiry markers are kept (0 = no markers)
+	PersistIf      string        // Bool response field that must be true to persist ("" = always)
+
+	Replicas      int32 // Bucket replicas (0 = default 1)
+	MemoryStorage bool  // Keep the bucket in memory (storage = MEMORY_STORAGE)
 }
 
 // ObjectStoreOpts contains object store options for a method
@@ -112,23 +175,57 @@ type ObjectStoreOpts struct {
 	TTL         time.Duration // TTL for objects (0 = no expiry)
 	Description string        // Human-readable bucket description
 	ClientOnly  bool          // Skip server auto-persist; only generate client read/write
+	PersistIf   string        // Bool response field that must be true to persist ("" = always)
+
+	Replicas      int32 // Bucket replicas (0 = default 1)
+	MemoryStorage bool  // Keep the bucket in memory (storage = MEMORY_STORAGE)
 }
 
 // StreamOpts contains streaming fine-tuning options
 type StreamOpts struct {
-	MaxInflight int32 // Max concurrent in-flight messages (0 = unlimited)
-	Ordered     bool  // Guarantee ordering via sequence headers
+	MaxInflight    int32         // Max concurrent in-flight messages (0 = unlimited)
+	Ordered        bool          // Guarantee ordering via sequence headers
+	Persistence    bool          // Write messages to JetStream so clients can resume
+	PersistenceTTL time.Duration // How long persisted messages are kept (0 = default)
+}
+
+// HeaderOpts declares a request header of a method, per (natsmicro.endpoint).headers
+type HeaderOpts struct {
+	Name     string // Header name as declared, e.g., "x-tenant-id"
+	Required bool   // Reject requests without it
+	Accessor string // Accessor prefix, e.g., "TenantID" for TenantIDFromContext
+}
+
+// EventOpts contains the (natsmicro.event) options of a message
+type EventOpts struct {
+	Subject  string // Subject the events are published on
+	Encoding string // "json" or "binary" ("" = binary)
+}
+
+// EnrichOpts contains request enrichment options for a method
+type EnrichOpts struct {
+	Bucket      string // KV bucket name
+	KeyTemplate string // Key template with {field} placeholders
+	ContextKey  string // Context value name (e.g., "profile")
+	Type        string // Fully-qualified proto message name stored in the bucket
+	GoName      string // Go type name of Type ("" if it does not resolve)
+	Accessor    string // Accessor prefix, e.g. "Profile" for ProfileFromContext
 }
 
 // GetEndpointOptions extracts endpoint options from proto method definition
 func GetEndpointOptions(method *protogen.Method) EndpointOptions {
+	return endpointOptionsFromDesc(method.Desc)
+}
+
+// endpointOptionsFromDesc extracts endpoint options from a raw method descriptor.
+func endpointOptionsFromDesc(desc protoreflect.MethodDescriptor) EndpointOptions {
 	opts := EndpointOptions{
 		Skip:     false,
 		Timeout:  0, // 0 means use service default
 		Metadata: make(map[string]string),
 	}
 
-	methodOpts := method.Desc.Options()
+	methodOpts := desc.Options()
 
 	// Endpoint options
 	if endpointOpts, ok := getExtension[*natspb.EndpointOptions](methodOpts, natspb.E_Endpoint); ok {
@@ -139,6 +236,27 @@ func GetEndpointOptions(method *protogen.Method) EndpointOptions {
 		if len(endpointOpts.Metadata) > 0 {
 			opts.Metadata = endpointOpts.Metadata
 		}
+		opts.Subject = endpointOpts.Subject
+		opts.FireAndForget = endpointOpts.FireAndForget
+		opts.Middlewares = endpointOpts.Middlewares
+		opts.AllowImpersonation = endpointOpts.AllowImpersonation
+		opts.Paginated = endpointOpts.Paginated
+		opts.RequiredScopes = endpointOpts.RequiredScopes
+		opts.ScatterGather = endpointOpts.ScatterGather
+		opts.Encoding = endpointOpts.Encoding
+		opts.SubjectTemplate = endpointOpts.SubjectTemplate
+		for _, header := range endpointOpts.Headers {
+			accessor := headerAccessor(header.Name)
+			if header.Accessor != "" {
+				accessor = ToCamelCase(header.Accessor)
+			}
+			opts.Headers = append(opts.Headers, HeaderOpts{Name: header.Name, Required: header.Required, Accessor: accessor})
+		}
+	}
+
+	// The standard idempotency_level option marks methods requests can be coalesced for
+	if mo, ok := methodOpts.(*descriptorpb.MethodOptions); ok {
+		opts.Idempotent = mo.GetIdempotencyLevel() != descriptorpb.MethodOptions_IDEMPOTENCY_UNKNOWN
 	}
 
 	// KV Store options
@@ -149,10 +267,21 @@ func GetEndpointOptions(method *protogen.Method) EndpointOptions {
 			Description: kvOpts.Description,
 			MaxHistory:  kvOpts.MaxHistory,
 			ClientOnly:  kvOpts.ClientOnly,
+
+			RevisionCheck:   kvOpts.Concurrency == natspb.KVStoreOptions_REVISION_CHECK,
+			RetryOnConflict: kvOpts.RetryOnConflict,
+			TypeTag:         kvOpts.TypeTag,
+			PersistIf:       kvOpts.PersistIf,
+
+			Replicas:      kvOpts.Replicas,
+			MemoryStorage: kvOpts.Storage == natspb.StorageType_MEMORY_STORAGE,
 		}
 		if kvOpts.Ttl != nil {
 			kv.TTL = kvOpts.Ttl.AsDuration()
 		}
+		if kvOpts.LimitMarkerTtl != nil {
+			kv.LimitMarkerTTL = kvOpts.LimitMarkerTtl.AsDuration()
+		}
 		opts.KVStore = kv
 	}
 
@@ -163,6 +292,10 @@ func GetEndpointOptions(method *protogen.Method) EndpointOptions {
 			KeyTemplate: objOpts.KeyTemplate,
 			Description: objOpts.Description,
 			ClientOnly:  objOpts.ClientOnly,
+			PersistIf:   objOpts.PersistIf,
+
+			Replicas:      objOpts.Replicas,
+			MemoryStorage: objOpts.Storage == natspb.StorageType_MEMORY_STORAGE,
 		}
 		if objOpts.Ttl != nil {
 			obj.TTL = objOpts.Ttl.AsDuration()
@@ -175,12 +308,41 @@ func GetEndpointOptions(method *protogen.Method) EndpointOptions {
 		opts.Stream = &StreamOpts{
 			MaxInflight: streamOpts.MaxInflight,
 			Ordered:     streamOpts.Ordered,
+			Persistence: streamOpts.Persistence,
+		}
+		if streamOpts.PersistenceTtl != nil {
+			opts.Stream.PersistenceTTL = streamOpts.PersistenceTtl.AsDuration()
 		}
 	}
 
+	// Enrich options
+	if enrichOpts, ok := getExtension[*natspb.EnrichOptions](methodOpts, natspb.E_Enrich); ok {
+		enrich := &EnrichOpts{
+			Bucket:      enrichOpts.Bucket,
+			KeyTemplate: enrichOpts.KeyTemplate,
+			ContextKey:  enrichOpts.ContextKey,
+			Type:        enrichOpts.Type,
+			Accessor:    ToCamelCase(enrichOpts.ContextKey),
+		}
+		if msg := findMessage(desc.ParentFile(), protoreflect.FullName(enrichOpts.Type)); msg != nil {
+			enrich.GoName = goMessageName(msg)
+		}
+		opts.Enrich = enrich
+	}
+
 	return opts
 }
 
+// GetEventOptions extracts the (natsmicro.event) options of a message, and
+// reports whether it has them
+func GetEventOptions(msg *protogen.Message) (EventOpts, bool) {
+	eventOpts, ok := getExtension[*natspb.EventOptions](msg.Desc.Options(), natspb.E_Event)
+	if !ok {
+		return EventOpts{}, false
+	}
+	return EventOpts{Subject: eventOpts.Subject, Encoding: eventOpts.Encoding}, true
+}
+
 // IsServerStreaming returns true if the method has server-side streaming
 func IsServerStreaming(method *protogen.Method) bool {
 	return method.Desc.IsStreamingServer()
@@ -0,0 +1,105 @@
+package generator
+
+import (
+	"fmt"
+
+	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// Pagination describes a (natsmicro.endpoint).paginated method: the request field
+// holding the page token and the response fields holding the next one and the items
+type Pagination struct {
+	PageToken     string // Go name of the request's page_token field
+	NextPageToken string // Go name of the response's next_page_token field
+	Items         string // Go name of the response's repeated field
+	ItemType      string // Go type of one item, e.g. "*Order" or "string"
+}
+
+// GetPagination returns the pagination fields of a paginated method, or nil when
+// the method is not paginated or its messages do not fit (see validatePagination)
+func GetPagination(method *protogen.Method) *Pagination {
+	return getPagination(method, func(ident protogen.GoIdent) string { return ident.GoName })
+}
+
+// getPagination is GetPagination with message and enum identifiers written by qualify
+func getPagination(method *protogen.Method, qualify func(protogen.GoIdent) string) *Pagination {
+	if !GetEndpointOptions(method).Paginated || validatePagination(method.Desc) != nil {
+		return nil
+	}
+	p := &Pagination{}
+	for _, field := range method.Input.Fields {
+		if field.Desc.Name() == "page_token" {
+			p.PageToken = field.GoName
+		}
+	}
+	for _, field := range method.Output.Fields {
+		switch {
+		case field.Desc.Name() == "next_page_token":
+			p.NextPageToken = field.GoName
+		case field.Desc.IsList():
+			p.Items = field.GoName
+			p.ItemType = goElemType(field, qualify)
+		}
+	}
+	return p
+}
+
+// goElemType returns the Go type of one element of a repeated field
+func goElemType(field *protogen.Field, qualify func(protogen.GoIdent) string) string {
+	switch field.Desc.Kind() {
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		return "*" + qualify(field.Message.GoIdent)
+	case protoreflect.EnumKind:
+		return qualify(field.Enum.GoIdent)
+	case protoreflect.BoolKind:
+		return "bool"
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		return "int32"
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		return "uint32"
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		return "int64"
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		return "uint64"
+	case protoreflect.FloatKind:
+		return "float32"
+	case protoreflect.DoubleKind:
+		return "float64"
+	case protoreflect.BytesKind:
+		return "[]byte"
+	}
+	return "string"
+}
+
+// validatePagination checks that a (natsmicro.endpoint).paginated method is unary,
+// takes a string page_token, and returns a string next_page_token alongside exactly
+// one repeated field holding the page's items
+func validatePagination(method protoreflect.MethodDescriptor) error {
+	if method.IsStreamingClient() || method.IsStreamingServer() {
+		return fmt.Errorf("paginated only applies to unary methods")
+	}
+	if !isStringField(method.Input().Fields().ByName("page_token")) {
+		return fmt.Errorf("paginated requires a string page_token field in %s", method.Input().FullName())
+	}
+	output := method.Output()
+	if !isStringField(output.Fields().ByName("next_page_token")) {
+		return fmt.Errorf("paginated requires a string next_page_token field in %s", output.FullName())
+	}
+	repeated := 0
+	fields := output.Fields()
+	for i := 0; i < fields.Len(); i++ {
+		if fields.Get(i).IsList() {
+			repeated++
+		}
+	}
+	if repeated != 1 {
+		return fmt.Errorf("paginated requires exactly one repeated field in %s, found %d", output.FullName(), repeated)
+	}
+	return nil
+}
+
+// isStringField reports whether field is a singular string field
+func isStringField(field protoreflect.FieldDescriptor) bool {
+	return field != nil && field.Kind() == protoreflect.StringKind && !field.IsList() && !field.IsMap()
+}
@@ -0,0 +1,188 @@
+package generator
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+// Params holds plugin parameters (--nats-micro_opt=key=value,...) shared by every generated file
+type Params struct {
+	Language       string   // Target language from language= or lang= ("" = caller default)
+	Reproducible   bool     // Omit tool versions from headers so output depends only on the inputs
+	Mocks          bool     // Also generate test doubles (Go only)
+	ServiceOptions bool     // Register<Service>Handlers takes a per-service option type (Go only)
+	Dashboards     string   // Also generate monitoring dashboards per service ("grafana", "" = none)
+	EmptyShortcuts bool     // Leave google.protobuf.Empty out of unary signatures (default true)
+	OTel           bool     // Also generate OpenTelemetry tracing interceptors (Go only)
+	Metrics        string   // Also generate metrics interceptors ("prometheus", "" = none; Go only)
+	Validate       bool     // Check requests against their buf.validate constraints (Go only)
+	CLI            bool     // Also generate a command-line client per service (Go only)
+	GRPCShim       bool     // Also generate shims implementing the protoc-gen-go-grpc client interfaces (Go only)
+	GRPCBridge     bool     // Also generate adapters implementing the protoc-gen-go-grpc server interfaces over NATS clients (Go only)
+	HTTPGateway    bool     // Also generate HTTP/JSON handlers for the google.api.http annotations (Go only)
+	FuzzHelpers    bool     // Also generate random message constructors for fuzzing and load tests (Go only)
+	Bench          bool     // Also generate load and latency tests of each service's unary methods (Go only)
+	OutSuffix      string   // Replaces the language's file suffix, e.g. ".nats.go" ("" = "_nats.pb.go" etc.)
+	OutDirTemplate string   // Subdirectory of the default output directory, with {package} and {proto} placeholders
+	FilePerService bool     // Generate one file per service, named after it, instead of one per proto file
+	SharedPackages []string // Proto files that own the shared file of their output directory (shared_package=, repeatable)
+	LegacyCasing   bool     // Derive snake_case and kebab-case names as releases before run detection did
+	Version        string   // Plugin version, set by main
+	ProtocVersion  string   // Compiler version from the CodeGeneratorRequest, e.g., "v5.29.3"
+}
+
+// ParseParams parses the comma-separated plugin parameter string.
+// Unknown keys are ignored so options shared with other plugins (e.g., module=, paths=) pass through.
+func ParseParams(parameter string) (Params, error) {
+	params := Params{EmptyShortcuts: true}
+	for _, param := range strings.Split(parameter, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+		switch key {
+		case "language", "lang":
+			params.Language = value
+		case "reproducible":
+			b, err := parseBoolParam(key, value)
+			if err != nil {
+				return Params{}, err
+			}
+			params.Reproducible = b
+		case "mocks":
+			b, err := parseBoolParam(key, value)
+			if err != nil {
+				return Params{}, err
+			}
+			params.Mocks = b
+		case "service_options":
+			b, err := parseBoolParam(key, value)
+			if err != nil {
+				return Params{}, err
+			}
+			params.ServiceOptions = b
+		case "dashboards":
+			if value != "grafana" {
+				return Params{}, fmt.Errorf("invalid value %q for parameter dashboards: want grafana", value)
+			}
+			params.Dashboards = value
+		case "empty_shortcuts":
+			b, err := parseBoolParam(key, value)
+			if err != nil {
+				return Params{}, err
+			}
+			params.EmptyShortcuts = b
+		case "otel":
+			b, err := parseBoolParam(key, value)
+			if err != nil {
+				return Params{}, err
+			}
+			params.OTel = b
+		case "metrics":
+			if value != "prometheus" {
+				return Params{}, fmt.Errorf("invalid value %q for parameter metrics: want prometheus", value)
+			}
+			params.Metrics = value
+		case "validate":
+			b, err := parseBoolParam(key, value)
+			if err != nil {
+				return Params{}, err
+			}
+			params.Validate = b
+		case "cli":
+			b, err := parseBoolParam(key, value)
+			if err != nil {
+				return Params{}, err
+			}
+			params.CLI = b
+		case "grpc_shim":
+			b, err := parseBoolParam(key, value)
+			if err != nil {
+				return Params{}, err
+			}
+			params.GRPCShim = b
+		case "grpc_bridge":
+			b, err := parseBoolParam(key, value)
+			if err != nil {
+				return Params{}, err
+			}
+			params.GRPCBridge = b
+		case "http_gateway":
+			b, err := parseBoolParam(key, value)
+			if err != nil {
+				return Params{}, err
+			}
+			params.HTTPGateway = b
+		case "fuzz_helpers":
+			b, err := parseBoolParam(key, value)
+			if err != nil {
+				return Params{}, err
+			}
+			params.FuzzHelpers = b
+		case "bench":
+			b, err := parseBoolParam(key, value)
+			if err != nil {
+				return Params{}, err
+			}
+			params.Bench = b
+		case "out_suffix":
+			if value == "" || strings.Contains(value, "/") {
+				return Params{}, fmt.Errorf("invalid value %q for parameter out_suffix: want a file name suffix such as .nats.go", value)
+			}
+			params.OutSuffix = value
+		case "out_dir_template":
+			if err := validateOutDirTemplate(value); err != nil {
+				return Params{}, err
+			}
+			params.OutDirTemplate = value
+		case "file_per_service":
+			b, err := parseBoolParam(key, value)
+			if err != nil {
+				return Params{}, err
+			}
+			params.FilePerService = b
+		case "shared_package":
+			if !strings.HasSuffix(value, ".proto") {
+				return Params{}, fmt.Errorf("invalid value %q for parameter shared_package: want a proto file, e.g. order/v1/order.proto", value)
+			}
+			params.SharedPackages = append(params.SharedPackages, value)
+		case "legacy_casing":
+			b, err := parseBoolParam(key, value)
+			if err != nil {
+				return Params{}, err
+			}
+			params.LegacyCasing = b
+		}
+	}
+	return params, nil
+}
+
+// parseBoolParam accepts a bare key (e.g., "reproducible") as true
+func parseBoolParam(key, value string) (bool, error) {
+	if value == "" {
+		return true, nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid value %q for parameter %s: want true or false", value, key)
+	}
+	return b, nil
+}
+
+// FormatCompilerVersion renders the protoc version like protoc-gen-go does (e.g., "v5.29.3").
+// Returns "(unknown)" when the compiler did not report its version.
+func FormatCompilerVersion(v *pluginpb.Version) string {
+	if v == nil {
+		return "(unknown)"
+	}
+	version := fmt.Sprintf("v%d.%d.%d", v.GetMajor(), v.GetMinor(), v.GetPatch())
+	if suffix := v.GetSuffix(); suffix != "" {
+		version += "-" + suffix
+	}
+	return version
+}
+
+// SourcePath returns the proto source path with forward slashes on every OS
+func SourcePath(path string) string {
+	return strings.ReplaceAll(path, `\`, "/")
+}
@@ -0,0 +1,105 @@
+package generator
+
+import (
+	"reflect"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+func TestParseParams(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    Params
+		wantErr bool
+	}{
+		{"", Params{EmptyShortcuts: true}, false},
+		{"language=ts", Params{Language: "ts", EmptyShortcuts: true}, false},
+		{"lang=python,reproducible=true", Params{Language: "python", Reproducible: true, EmptyShortcuts: true}, false},
+		{"reproducible", Params{Reproducible: true, EmptyShortcuts: true}, false},
+		{"reproducible=false", Params{EmptyShortcuts: true}, false},
+		{"module=example/gen,paths=source_relative", Params{EmptyShortcuts: true}, false},
+		{"reproducible=yes", Params{}, true},
+		{"lang=go,mocks", Params{Language: "go", Mocks: true, EmptyShortcuts: true}, false},
+		{"mocks=1", Params{Mocks: true, EmptyShortcuts: true}, false},
+		{"mocks=maybe", Params{}, true},
+		{"service_options", Params{ServiceOptions: true, EmptyShortcuts: true}, false},
+		{"lang=go,service_options=false", Params{Language: "go", EmptyShortcuts: true}, false},
+		{"dashboards=grafana", Params{Dashboards: "grafana", EmptyShortcuts: true}, false},
+		{"dashboards", Params{}, true},
+		{"dashboards=kibana", Params{}, true},
+		{"empty_shortcuts=false", Params{}, false},
+		{"empty_shortcuts=no", Params{}, true},
+		{"otel=true", Params{OTel: true, EmptyShortcuts: true}, false},
+		{"otel=on", Params{}, true},
+		{"validate", Params{Validate: true, EmptyShortcuts: true}, false},
+		{"lang=go,cli", Params{Language: "go", CLI: true, EmptyShortcuts: true}, false},
+		{"grpc_shim=true", Params{GRPCShim: true, EmptyShortcuts: true}, false},
+		{"grpc_shim=grpc", Params{}, true},
+		{"grpc_bridge=true", Params{GRPCBridge: true, EmptyShortcuts: true}, false},
+		{"grpc_bridge=maybe", Params{}, true},
+		{"http_gateway=true", Params{HTTPGateway: true, EmptyShortcuts: true}, false},
+		{"http_gateway=yes", Params{}, true},
+		{"fuzz_helpers", Params{FuzzHelpers: true, EmptyShortcuts: true}, false},
+		{"fuzz_helpers=yes", Params{}, true},
+		{"bench=true", Params{Bench: true, EmptyShortcuts: true}, false},
+		{"bench=on", Params{}, true},
+		{"metrics=prometheus", Params{Metrics: "prometheus", EmptyShortcuts: true}, false},
+		{"metrics", Params{}, true},
+		{"metrics=statsd", Params{}, true},
+		{"out_suffix=.nats.go", Params{OutSuffix: ".nats.go", EmptyShortcuts: true}, false},
+		{"out_suffix", Params{}, true},
+		{"out_suffix=nats/x.go", Params{}, true},
+		{"out_dir_template=natsrpc/{proto}", Params{OutDirTemplate: "natsrpc/{proto}", EmptyShortcuts: true}, false},
+		{"out_dir_template={package}", Params{OutDirTemplate: "{package}", EmptyShortcuts: true}, false},
+		{"out_dir_template=../natsrpc", Params{}, true},
+		{"out_dir_template=/natsrpc", Params{}, true},
+		{"out_dir_template=natsrpc/{service}", Params{}, true},
+		{"out_dir_template", Params{}, true},
+		{"file_per_service", Params{FilePerService: true, EmptyShortcuts: true}, false},
+		{"file_per_service=maybe", Params{}, true},
+		{"shared_package=order/v1/order.proto", Params{SharedPackages: []string{"order/v1/order.proto"}, EmptyShortcuts: true}, false},
+		{"shared_package=a/a.proto,shared_package=b/b.proto", Params{SharedPackages: []string{"a/a.proto", "b/b.proto"}, EmptyShortcuts: true}, false},
+		{"shared_package=order.v1", Params{}, true},
+		{"legacy_casing", Params{LegacyCasing: true, EmptyShortcuts: true}, false},
+		{"legacy_casing=sometimes", Params{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := ParseParams(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseParams(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseParams(%q) = %+v, want %+v", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormatCompilerVersion(t *testing.T) {
+	tests := []struct {
+		input    *pluginpb.Version
+		expected string
+	}{
+		{nil, "(unknown)"},
+		{&pluginpb.Version{Major: proto.Int32(5), Minor: proto.Int32(29), Patch: proto.Int32(3)}, "v5.29.3"},
+		{&pluginpb.Version{Major: proto.Int32(4), Minor: proto.Int32(0), Patch: proto.Int32(0), Suffix: proto.String("rc1")}, "v4.0.0-rc1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expected, func(t *testing.T) {
+			if got := FormatCompilerVersion(tt.input); got != tt.expected {
+				t.Errorf("FormatCompilerVersion() = %q, want %q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestSourcePath(t *testing.T) {
+	if got := SourcePath(`order\v1\service.proto`); got != "order/v1/service.proto" {
+		t.Errorf("SourcePath = %q, want order/v1/service.proto", got)
+	}
+}
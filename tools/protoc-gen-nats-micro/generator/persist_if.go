@@ -0,0 +1,51 @@
+package generator
+
+import (
+	"fmt"
+
+	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// validatePersistIf checks that the persist_if of the option named store
+// ("kv_store" or "object_store") names a singular bool field of the response,
+// which the generated handlers test before persisting it
+func validatePersistIf(method protoreflect.MethodDescriptor, store, field string, clientOnly bool) error {
+	if field == "" {
+		return nil
+	}
+	if clientOnly {
+		return fmt.Errorf("%s persist_if does not apply to client_only buckets", store)
+	}
+	f := method.Output().Fields().ByName(protoreflect.Name(field))
+	if f == nil {
+		return fmt.Errorf("%s persist_if %q is not a field of %s", store, field, method.Output().FullName())
+	}
+	if f.Kind() != protoreflect.BoolKind || f.IsList() {
+		return fmt.Errorf("%s persist_if %q must name a bool field of %s", store, field, method.Output().FullName())
+	}
+	return nil
+}
+
+// PersistIfGo renders the Go condition for a persist_if field of the handler's
+// typedResp, e.g., "typedResp.GetComplete()"
+func PersistIfGo(field string, method *protogen.Method) string {
+	for _, f := range method.Output.Fields {
+		if string(f.Desc.Name()) == field {
+			return "typedResp.Get" + f.GoName + "()"
+		}
+	}
+	panic(fmt.Sprintf("protoc-gen-nats-micro: persist_if %q is not a field of %s", field, method.Output.Desc.FullName()))
+}
+
+// PersistIfTS renders the TypeScript condition for a persist_if field of the
+// handler's response, e.g., "response.complete"
+func PersistIfTS(field string) string {
+	return "response." + fieldNameToTSAccessor(field)
+}
+
+// PersistIfPy renders the Python condition for a persist_if field of the
+// handler's response_msg, e.g., "response_msg.complete"
+func PersistIfPy(field string) string {
+	return "response_msg." + field
+}
@@ -0,0 +1,42 @@
+package generator
+
+import "google.golang.org/protobuf/compiler/protogen"
+
+// StreamPipe pairs a server-streaming method with a client-streaming method whose
+// request type is the first method's response type, so the stream one returns can
+// feed the other. Each pair produces a typed Pipe wrapper in the generated Go code.
+type StreamPipe struct {
+	From *protogen.Method // Server-streaming; its client stream is received from
+	To   *protogen.Method // Client-streaming; its client stream is sent to
+}
+
+// StreamPipes returns the stream pairs among the file's generated services, in
+// declaration order. Bidirectional methods are left out.
+func StreamPipes(file *protogen.File) []StreamPipe {
+	var from, to []*protogen.Method
+	for _, service := range file.Services {
+		if GetServiceOptions(service).Skip {
+			continue
+		}
+		for _, method := range service.Methods {
+			if GetEndpointOptions(method).Skip || IsBidiStreaming(method) {
+				continue
+			}
+			if IsServerStreaming(method) {
+				from = append(from, method)
+			}
+			if IsClientStreaming(method) {
+				to = append(to, method)
+			}
+		}
+	}
+	var pipes []StreamPipe
+	for _, f := range from {
+		for _, t := range to {
+			if f.Output.Desc.FullName() == t.Input.Desc.FullName() {
+				pipes = append(pipes, StreamPipe{From: f, To: t})
+			}
+		}
+	}
+	return pipes
+}
@@ -1,6 +1,10 @@
 package generator
 
-import "google.golang.org/protobuf/compiler/protogen"
+import (
+	"path"
+
+	"google.golang.org/protobuf/compiler/protogen"
+)
 
 // PythonLanguage implements Language for Python code generation
 type PythonLanguage struct{ BaseLanguage }
@@ -16,7 +20,7 @@ func NewPythonLanguage() *PythonLanguage {
 
 // PostGenerate emits a Python __init__.py so the generated directory is a proper package.
 func (p *PythonLanguage) PostGenerate(gen *protogen.Plugin, file *protogen.File, pkgDir string) error {
-	initFilename := pkgDir + "/__init__.py"
+	initFilename := path.Join(pkgDir, "__init__.py")
 	initFile := gen.NewGeneratedFile(initFilename, "")
 	initFile.P("# Generated by protoc-gen-nats-micro. DO NOT EDIT.")
 	return nil
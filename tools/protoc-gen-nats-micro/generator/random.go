@@ -0,0 +1,23 @@
+package generator
+
+import "google.golang.org/protobuf/compiler/protogen"
+
+// RandomMessages returns the messages of file that get a NewRandom<Message>
+// constructor with fuzz_helpers=true: every message declared in the file,
+// nested ones included, in declaration order. Map entries are left out since
+// they have no Go type.
+func RandomMessages(file *protogen.File) []*protogen.Message {
+	var messages []*protogen.Message
+	var walk func([]*protogen.Message)
+	walk = func(msgs []*protogen.Message) {
+		for _, msg := range msgs {
+			if msg.Desc.IsMapEntry() {
+				continue
+			}
+			messages = append(messages, msg)
+			walk(msg.Messages)
+		}
+	}
+	walk(file.Messages)
+	return messages
+}
@@ -0,0 +1,51 @@
+package generator
+
+import (
+	"sort"
+
+	natspb "github.com/toyz/protoc-gen-nats-micro/tools/protoc-gen-nats-micro/nats/micro"
+	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// SensitiveFields returns the full names of the (natsmicro.field).sensitive
+// fields of every message a service's methods send or receive, nested and
+// imported messages included, sorted. Generated Go code registers them for
+// RedactMessage, which cannot read the option at runtime without importing
+// this module's options package.
+func SensitiveFields(service *protogen.Service) []string {
+	var roots []protoreflect.MessageDescriptor
+	for _, method := range service.Methods {
+		roots = append(roots, method.Desc.Input(), method.Desc.Output())
+	}
+	return sensitiveFields(roots)
+}
+
+// sensitiveFields returns the full names of the (natsmicro.field).sensitive fields
+// of roots and the messages they contain, sorted
+func sensitiveFields(roots []protoreflect.MessageDescriptor) []string {
+	var names []string
+	seen := make(map[protoreflect.FullName]bool)
+	var walk func(md protoreflect.MessageDescriptor)
+	walk = func(md protoreflect.MessageDescriptor) {
+		if seen[md.FullName()] {
+			return
+		}
+		seen[md.FullName()] = true
+		fields := md.Fields()
+		for i := 0; i < fields.Len(); i++ {
+			field := fields.Get(i)
+			if opts, ok := getExtension[*natspb.FieldOptions](field.Options(), natspb.E_Field); ok && opts.GetSensitive() {
+				names = append(names, string(field.FullName()))
+			}
+			if field.Message() != nil {
+				walk(field.Message())
+			}
+		}
+	}
+	for _, root := range roots {
+		walk(root)
+	}
+	sort.Strings(names)
+	return names
+}
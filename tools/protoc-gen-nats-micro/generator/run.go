@@ -0,0 +1,269 @@
+package generator
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"strings"
+
+	"google.golang.org/protobuf/compiler/protogen"
+)
+
+// Run generates every requested file of gen for params. params.Language must
+// be set; main fills it in from -lang when the parameter string leaves it out.
+//
+// Output paths follow protoc-gen-go: Go files are placed by go_package, or next
+// to their proto with paths=source_relative, and other languages always next to
+// their proto. With module=, protogen strips the module prefix from every
+// generated file and rejects files outside it.
+func Run(gen *protogen.Plugin, params Params) error {
+	lang, err := GetLanguage(params.Language)
+	if err != nil {
+		return fmt.Errorf("get language: %w", err)
+	}
+	lang.SetParams(params)
+	legacyCasing = params.LegacyCasing
+
+	var mockLang MockLanguage
+	if params.Mocks {
+		ml, ok := lang.(MockLanguage)
+		if !ok {
+			return fmt.Errorf("mocks=true is not supported for language %s", lang.Name())
+		}
+		mockLang = ml
+	}
+	var cliLang CLILanguage
+	if params.CLI {
+		cl, ok := lang.(CLILanguage)
+		if !ok {
+			return fmt.Errorf("cli=true is not supported for language %s", lang.Name())
+		}
+		cliLang = cl
+	}
+	if params.OTel && lang.Name() != "go" {
+		return fmt.Errorf("otel=true is not supported for language %s", lang.Name())
+	}
+	if params.Metrics != "" && lang.Name() != "go" {
+		return fmt.Errorf("metrics=%s is not supported for language %s", params.Metrics, lang.Name())
+	}
+	if params.Validate && lang.Name() != "go" {
+		return fmt.Errorf("validate=true is not supported for language %s", lang.Name())
+	}
+	if params.GRPCShim && lang.Name() != "go" {
+		return fmt.Errorf("grpc_shim=true is not supported for language %s", lang.Name())
+	}
+	if params.GRPCBridge && lang.Name() != "go" {
+		return fmt.Errorf("grpc_bridge=true is not supported for language %s", lang.Name())
+	}
+	if params.HTTPGateway && lang.Name() != "go" {
+		return fmt.Errorf("http_gateway=true is not supported for language %s", lang.Name())
+	}
+	if params.FuzzHelpers && lang.Name() != "go" {
+		return fmt.Errorf("fuzz_helpers=true is not supported for language %s", lang.Name())
+	}
+	if params.Bench && lang.Name() != "go" {
+		return fmt.Errorf("bench=true is not supported for language %s", lang.Name())
+	}
+
+	if params.OutSuffix != "" {
+		if err := validateOutSuffix(params.OutSuffix, lang); err != nil {
+			return err
+		}
+	}
+
+	if err := checkOutputNames(gen, lang); err != nil {
+		return err
+	}
+	owners, err := sharedOwners(gen, lang, params.SharedPackages)
+	if err != nil {
+		return err
+	}
+
+	// Shared files of this run by output directory, with the proto each came from
+	shared := make(map[string]*protogen.GeneratedFile)
+	sharedSource := make(map[string]string)
+
+	for _, f := range gen.Files {
+		if !f.Generate {
+			continue
+		}
+
+		// The shared file sits next to the first file with services or events in
+		// each output directory ("." for protos at the root), or the
+		// shared_package file naming it
+		pkgDir := outputDir(f, lang)
+		if hasGeneratedCode(f) {
+			// Only Go-like languages use the Go import path for generated files
+			var importPath protogen.GoImportPath
+			if lang.IsGoLike() {
+				importPath = newOutputLayout(f, params, lang.FileExtension()).GoImportPath
+			}
+
+			owner, owned := owners[pkgDir]
+			switch {
+			case shared[pkgDir] != nil:
+				// Each file must produce the same shared file, so the one written
+				// does not depend on which file, or which run, wrote it
+				if err := checkSharedContent(gen, f, lang, importPath, shared[pkgDir], sharedSource[pkgDir]); err != nil {
+					return err
+				}
+			case owners != nil && !owned:
+				fmt.Fprintf(os.Stderr, "protoc-gen-nats-micro: warning: no shared_package file of %s is in this run, so %s is not generated\n", pkgDir, SharedFilename(f, lang))
+				owners[pkgDir] = ""
+			case owners == nil || owner == f.Desc.Path():
+				sharedFile := gen.NewGeneratedFile(SharedFilename(f, lang), importPath)
+				if err := lang.GenerateShared(sharedFile, f); err != nil {
+					return fmt.Errorf("generate shared: %w", err)
+				}
+				shared[pkgDir] = sharedFile
+				sharedSource[pkgDir] = f.Desc.Path()
+
+				// Allow language-specific post-generation (e.g., Python __init__.py)
+				if err := lang.PostGenerate(gen, f, pkgDir); err != nil {
+					return fmt.Errorf("post generate: %w", err)
+				}
+			}
+		}
+
+		if params.HTTPGateway {
+			if err := validateHTTPGateway(f); err != nil {
+				return err
+			}
+		}
+		if err := GenerateFile(gen, f, lang); err != nil {
+			return fmt.Errorf("generate file %s: %w", f.Desc.Path(), err)
+		}
+		if mockLang != nil {
+			if err := GenerateMockFile(gen, f, mockLang); err != nil {
+				return fmt.Errorf("generate mocks %s: %w", f.Desc.Path(), err)
+			}
+		}
+		if cliLang != nil {
+			if err := GenerateCLIFiles(gen, f, cliLang); err != nil {
+				return fmt.Errorf("generate cli %s: %w", f.Desc.Path(), err)
+			}
+		}
+		if params.Dashboards != "" {
+			if err := GenerateDashboards(gen, f, lang); err != nil {
+				return fmt.Errorf("generate dashboards %s: %w", f.Desc.Path(), err)
+			}
+		}
+	}
+	return nil
+}
+
+// sharedOwners maps each output directory to the shared_package file that generates
+// its shared file, or returns nil when shared_package is not set. Owners may come
+// from any file of the request, so a run that only depends on the owner leaves the
+// shared file to the run generating it.
+func sharedOwners(gen *protogen.Plugin, lang Language, files []string) (map[string]string, error) {
+	if len(files) == 0 {
+		return nil, nil
+	}
+	owners := make(map[string]string)
+	for _, f := range gen.Files {
+		if !hasGeneratedCode(f) || !slices.Contains(files, f.Desc.Path()) {
+			continue
+		}
+		dir := outputDir(f, lang)
+		if other, ok := owners[dir]; ok {
+			return nil, fmt.Errorf("shared_package names both %s and %s, which share the output directory %s", other, f.Desc.Path(), dir)
+		}
+		owners[dir] = f.Desc.Path()
+	}
+	return owners, nil
+}
+
+// checkSharedContent fails generation when file would generate a different shared
+// file than the one source generated for their output directory. Whichever file
+// or run writes it, the shared file must come out byte-identical.
+func checkSharedContent(gen *protogen.Plugin, file *protogen.File, lang Language, importPath protogen.GoImportPath, generated *protogen.GeneratedFile, source string) error {
+	scratch := gen.NewGeneratedFile(SharedFilename(file, lang), importPath)
+	scratch.Skip()
+	if err := lang.GenerateShared(scratch, file); err != nil {
+		return fmt.Errorf("generate shared: %w", err)
+	}
+	want, err := generated.Content()
+	if err != nil {
+		return err
+	}
+	got, err := scratch.Content()
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(got, want) {
+		return fmt.Errorf("%s and %s share %s but generate different contents for it; give them the same package options", source, file.Desc.Path(), SharedFilename(file, lang))
+	}
+	return nil
+}
+
+// checkOutputNames fails generation when two protos or services of the request
+// would write the same file, which would leave only one of them behind
+func checkOutputNames(gen *protogen.Plugin, lang Language) error {
+	params := lang.Params()
+	sources := make(map[string]string)
+	claim := func(name, source string) error {
+		if other, ok := sources[name]; ok && other != source {
+			return fmt.Errorf("%s would be generated for both %s and %s; give them different output directories or file names", name, other, source)
+		}
+		sources[name] = source
+		return nil
+	}
+	for _, f := range gen.Files {
+		if !f.Generate || !hasGeneratedCode(f) {
+			continue
+		}
+		if !params.FilePerService || len(f.Services) == 0 {
+			if err := claim(outputFilename(f, nil, lang), f.Desc.Path()); err != nil {
+				return err
+			}
+		}
+		if ml, ok := lang.(MockLanguage); ok && params.Mocks {
+			if err := claim(mockFilename(f, ml), f.Desc.Path()); err != nil {
+				return err
+			}
+		}
+		for _, service := range f.Services {
+			if GetServiceOptions(service).Skip {
+				continue
+			}
+			var names []string
+			if params.FilePerService {
+				names = append(names, outputFilename(f, service, lang))
+			}
+			if params.CLI {
+				names = append(names, cliFilename(f, service, lang))
+			}
+			if params.Dashboards != "" {
+				names = append(names, dashboardFilename(f, service, lang))
+			}
+			for _, name := range names {
+				if err := claim(name, string(service.Desc.FullName())); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// validateOutSuffix checks that an out_suffix keeps lang's file type and, for
+// Python, names modules that can be imported
+func validateOutSuffix(suffix string, lang Language) error {
+	ext := path.Ext(lang.FileExtension())
+	if !strings.HasSuffix(suffix, ext) {
+		return fmt.Errorf("out_suffix=%s does not end in %s, the file type of language %s", suffix, ext, lang.Name())
+	}
+	if lang.Name() == "python" && strings.ContainsAny(strings.TrimSuffix(suffix, ext), ".-") {
+		return fmt.Errorf("out_suffix=%s does not name importable Python modules: use letters, digits and underscores before .py", suffix)
+	}
+	return nil
+}
+
+// SharedFilename returns the path of lang's shared file for the output
+// directory of file, e.g., "order/v1/shared_nats.pb.go"
+func SharedFilename(file *protogen.File, lang Language) string {
+	return path.Join(outputDir(file, lang), "shared"+outputSuffix(lang))
+}
@@ -0,0 +1,528 @@
+package generator
+
+import (
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+
+	natspb "github.com/toyz/protoc-gen-nats-micro/tools/protoc-gen-nats-micro/nats/micro"
+	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+// runFile is a proto file with one service, for checking output placement
+func runFile(name, pkg, goPackage string) *descriptorpb.FileDescriptorProto {
+	msg := "." + pkg + ".Msg"
+	return &descriptorpb.FileDescriptorProto{
+		Name:        proto.String(name),
+		Package:     proto.String(pkg),
+		Syntax:      proto.String("proto3"),
+		Options:     &descriptorpb.FileOptions{GoPackage: proto.String(goPackage)},
+		MessageType: []*descriptorpb.DescriptorProto{{Name: proto.String("Msg")}},
+		Service: []*descriptorpb.ServiceDescriptorProto{{
+			Name: proto.String("OrderService"),
+			Method: []*descriptorpb.MethodDescriptorProto{{
+				Name:       proto.String("Get"),
+				InputType:  proto.String(msg),
+				OutputType: proto.String(msg),
+			}},
+		}},
+	}
+}
+
+// renameService renames the service and message of a runFile descriptor, so it
+// can share a package with another
+func renameService(file *descriptorpb.FileDescriptorProto, name string) {
+	msg := strings.TrimSuffix(name, "Service") + "Msg"
+	file.MessageType[0].Name = proto.String(msg)
+	file.Service[0].Name = proto.String(name)
+	method := file.Service[0].Method[0]
+	method.InputType = proto.String("." + file.GetPackage() + "." + msg)
+	method.OutputType = method.InputType
+}
+
+// runPlugin runs the plugin over files with parameter as protoc would, and
+// returns the response with the generated files by name
+func runPlugin(t *testing.T, parameter string, files ...*descriptorpb.FileDescriptorProto) (*pluginpb.CodeGeneratorResponse, map[string]string) {
+	t.Helper()
+	req := &pluginpb.CodeGeneratorRequest{Parameter: proto.String(parameter), ProtoFile: files}
+	for _, f := range files {
+		req.FileToGenerate = append(req.FileToGenerate, f.GetName())
+	}
+	gen, err := protogen.Options{}.New(req)
+	if err != nil {
+		t.Fatalf("protogen: %v", err)
+	}
+	params, err := ParseParams(parameter)
+	if err != nil {
+		t.Fatalf("ParseParams: %v", err)
+	}
+	if params.Language == "" {
+		params.Language = "go"
+	}
+	params.Reproducible = true
+	if err := Run(gen, params); err != nil {
+		gen.Error(err)
+	}
+	resp := gen.Response()
+	out := make(map[string]string)
+	for _, f := range resp.File {
+		out[f.GetName()] = f.GetContent()
+	}
+	return resp, out
+}
+
+func TestRunOutputPaths(t *testing.T) {
+	order := func() *descriptorpb.FileDescriptorProto {
+		return runFile("order/v1/order.proto", "order.v1", "example.com/api/gen/order/v1;orderv1")
+	}
+	health := func() *descriptorpb.FileDescriptorProto {
+		return runFile("health.proto", "health", "example.com/api/gen/health;health")
+	}
+
+	tests := []struct {
+		name      string
+		parameter string
+		files     []*descriptorpb.FileDescriptorProto
+		want      []string
+	}{
+		{
+			name:      "go import paths",
+			parameter: "mocks,cli",
+			files:     []*descriptorpb.FileDescriptorProto{order(), health()},
+			want: []string{
+				"example.com/api/gen/health/health_nats.pb.go",
+				"example.com/api/gen/health/shared_nats.pb.go",
+				"example.com/api/gen/health/health_nats_mock.pb.go",
+				"example.com/api/gen/health/cmd/ordercli/order_service_cli.pb.go",
+				"example.com/api/gen/order/v1/order_nats.pb.go",
+				"example.com/api/gen/order/v1/shared_nats.pb.go",
+				"example.com/api/gen/order/v1/order_nats_mock.pb.go",
+				"example.com/api/gen/order/v1/cmd/ordercli/order_service_cli.pb.go",
+			},
+		},
+		{
+			name:      "go source relative",
+			parameter: "paths=source_relative,mocks",
+			files:     []*descriptorpb.FileDescriptorProto{order(), health()},
+			want: []string{
+				"health_nats.pb.go",
+				"shared_nats.pb.go",
+				"health_nats_mock.pb.go",
+				"order/v1/order_nats.pb.go",
+				"order/v1/shared_nats.pb.go",
+				"order/v1/order_nats_mock.pb.go",
+			},
+		},
+		{
+			name:      "go module",
+			parameter: "module=example.com/api/gen,mocks,cli",
+			files:     []*descriptorpb.FileDescriptorProto{order(), health()},
+			want: []string{
+				"health/health_nats.pb.go",
+				"health/shared_nats.pb.go",
+				"health/health_nats_mock.pb.go",
+				"health/cmd/ordercli/order_service_cli.pb.go",
+				"order/v1/order_nats.pb.go",
+				"order/v1/shared_nats.pb.go",
+				"order/v1/order_nats_mock.pb.go",
+				"order/v1/cmd/ordercli/order_service_cli.pb.go",
+			},
+		},
+		{
+			name:      "python",
+			parameter: "language=python",
+			files:     []*descriptorpb.FileDescriptorProto{order(), health()},
+			want: []string{
+				"health_nats_pb2.py",
+				"shared_nats_pb2.py",
+				"__init__.py",
+				"order/v1/order_nats_pb2.py",
+				"order/v1/shared_nats_pb2.py",
+				"order/v1/__init__.py",
+			},
+		},
+		{
+			name:      "python module",
+			parameter: "language=python,module=order",
+			files:     []*descriptorpb.FileDescriptorProto{order()},
+			want:      []string{"v1/order_nats_pb2.py", "v1/shared_nats_pb2.py", "v1/__init__.py"},
+		},
+		{
+			name:      "ts source relative",
+			parameter: "language=ts,paths=source_relative",
+			files:     []*descriptorpb.FileDescriptorProto{order(), health()},
+			want: []string{
+				"health_nats.pb.ts",
+				"shared_nats.pb.ts",
+				"order/v1/order_nats.pb.ts",
+				"order/v1/shared_nats.pb.ts",
+			},
+		},
+		{
+			name:      "csharp",
+			parameter: "language=csharp",
+			files:     []*descriptorpb.FileDescriptorProto{order(), health()},
+			want: []string{
+				"health_nats.pb.cs",
+				"shared_nats.pb.cs",
+				"order/v1/order_nats.pb.cs",
+				"order/v1/shared_nats.pb.cs",
+			},
+		},
+		{
+			name:      "ts module",
+			parameter: "language=ts,module=order",
+			files:     []*descriptorpb.FileDescriptorProto{order()},
+			want:      []string{"v1/order_nats.pb.ts", "v1/shared_nats.pb.ts"},
+		},
+		{
+			name:      "go layout",
+			parameter: "module=example.com/api/gen,out_dir_template=natsrpc,out_suffix=.nats.go,file_per_service,mocks,cli",
+			files:     []*descriptorpb.FileDescriptorProto{order(), health()},
+			want: []string{
+				"health/natsrpc/order_service.nats.go",
+				"health/natsrpc/shared.nats.go",
+				"health/natsrpc/health_nats_mock.pb.go",
+				"health/natsrpc/cmd/ordercli/order_service_cli.pb.go",
+				"order/v1/natsrpc/order_service.nats.go",
+				"order/v1/natsrpc/shared.nats.go",
+				"order/v1/natsrpc/order_nats_mock.pb.go",
+				"order/v1/natsrpc/cmd/ordercli/order_service_cli.pb.go",
+			},
+		},
+		{
+			name:      "go dir template placeholders",
+			parameter: "paths=source_relative,out_dir_template=nats/{package}/{proto}",
+			files:     []*descriptorpb.FileDescriptorProto{order(), health()},
+			want: []string{
+				"nats/health/health/health_nats.pb.go",
+				"nats/health/health/shared_nats.pb.go",
+				"order/v1/nats/order/v1/order/order_nats.pb.go",
+				"order/v1/nats/order/v1/order/shared_nats.pb.go",
+			},
+		},
+		{
+			name:      "python layout",
+			parameter: "language=python,out_dir_template=natsrpc,out_suffix=_rpc.py",
+			files:     []*descriptorpb.FileDescriptorProto{order()},
+			want: []string{
+				"order/v1/natsrpc/order_rpc.py",
+				"order/v1/natsrpc/shared_rpc.py",
+				"order/v1/natsrpc/__init__.py",
+			},
+		},
+		{
+			name:      "ts file per service",
+			parameter: "language=ts,file_per_service,out_suffix=.nats.ts",
+			files:     []*descriptorpb.FileDescriptorProto{order()},
+			want:      []string{"order/v1/order_service.nats.ts", "order/v1/shared.nats.ts"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, out := runPlugin(t, tt.parameter, tt.files...)
+			if resp.Error != nil {
+				t.Fatalf("plugin error: %s", resp.GetError())
+			}
+			var got []string
+			for name := range out {
+				got = append(got, name)
+			}
+			slices.Sort(got)
+			want := slices.Clone(tt.want)
+			slices.Sort(want)
+			if !slices.Equal(got, want) {
+				t.Errorf("generated files:\ngot  %q\nwant %q", got, want)
+			}
+		})
+	}
+}
+
+func TestRunModuleMismatch(t *testing.T) {
+	resp, _ := runPlugin(t, "module=example.com/other", runFile("order/v1/order.proto", "order.v1", "example.com/api/gen/order/v1;orderv1"))
+	if !strings.Contains(resp.GetError(), "does not match prefix") {
+		t.Errorf("error = %q, want a module prefix mismatch", resp.GetError())
+	}
+}
+
+func TestRunLayoutErrors(t *testing.T) {
+	order := runFile("order/v1/order.proto", "order.v1", "example.com/api/gen/order/v1;orderv1")
+	tests := []struct {
+		parameter string
+		want      string
+	}{
+		{"out_suffix=.nats.ts", "does not end in .go"},
+		{"language=python,out_suffix=.nats.py", "importable Python modules"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.parameter, func(t *testing.T) {
+			resp, _ := runPlugin(t, tt.parameter, order)
+			if !strings.Contains(resp.GetError(), tt.want) {
+				t.Errorf("error = %q, want it to contain %q", resp.GetError(), tt.want)
+			}
+		})
+	}
+}
+
+func TestRunImports(t *testing.T) {
+	order := func() *descriptorpb.FileDescriptorProto {
+		return runFile("order/v1/order.proto", "order.v1", "example.com/api/gen/order/v1;orderv1")
+	}
+
+	// The CLI imports its service package by import path, which module= does not change
+	for _, parameter := range []string{"cli", "module=example.com/api/gen,cli"} {
+		_, out := runPlugin(t, parameter, order())
+		var cli string
+		for name, content := range out {
+			if strings.HasSuffix(name, CLIFileSuffix) {
+				cli = content
+			}
+		}
+		if !strings.Contains(cli, `pb "example.com/api/gen/order/v1"`) {
+			t.Errorf("%s: CLI does not import the service package by import path:\n%s", parameter, cli)
+		}
+	}
+
+	// Python and TypeScript import the messages and shared file relative to the
+	// generated file, so they keep working when module= moves the output
+	for _, parameter := range []string{"language=python", "language=python,module=order"} {
+		_, out := runPlugin(t, parameter, order())
+		var py string
+		for name, content := range out {
+			if strings.HasSuffix(name, "order_nats_pb2.py") {
+				py = content
+			}
+		}
+		for _, want := range []string{"from . import order_pb2 as pb", "from .shared_nats_pb2 import ("} {
+			if !strings.Contains(py, want) {
+				t.Errorf("%s: Python output missing %q", parameter, want)
+			}
+		}
+		if strings.Contains(py, "from order.v1 import") {
+			t.Errorf("%s: Python output imports the messages by proto package", parameter)
+		}
+	}
+	for _, parameter := range []string{"language=ts", "language=ts,module=order"} {
+		_, out := runPlugin(t, parameter, order())
+		var ts string
+		for name, content := range out {
+			if strings.HasSuffix(name, "order_nats.pb.ts") {
+				ts = content
+			}
+		}
+		for _, want := range []string{"import * as pb from './order';", "} from './shared_nats.pb';"} {
+			if !strings.Contains(ts, want) {
+				t.Errorf("%s: TypeScript output missing %q", parameter, want)
+			}
+		}
+	}
+}
+
+func TestRunLayoutImports(t *testing.T) {
+	order := func() *descriptorpb.FileDescriptorProto {
+		return runFile("order/v1/order.proto", "order.v1", "example.com/api/gen/order/v1;orderv1")
+	}
+
+	// Go code under out_dir_template is a package of its own that imports the messages
+	resp, out := runPlugin(t, "module=example.com/api/gen,out_dir_template=natsrpc,mocks,cli", order())
+	if resp.Error != nil {
+		t.Fatalf("plugin error: %s", resp.GetError())
+	}
+	for _, name := range []string{"order/v1/natsrpc/order_nats.pb.go", "order/v1/natsrpc/shared_nats.pb.go", "order/v1/natsrpc/order_nats_mock.pb.go"} {
+		if !strings.Contains(out[name], "package natsrpc") {
+			t.Errorf("%s is not in package natsrpc", name)
+		}
+	}
+	for _, want := range []string{`v1 "example.com/api/gen/order/v1"`, "*v1.Msg"} {
+		if !strings.Contains(out["order/v1/natsrpc/order_nats.pb.go"], want) {
+			t.Errorf("service file missing %q", want)
+		}
+	}
+	cli := out["order/v1/natsrpc/cmd/ordercli/order_service_cli.pb.go"]
+	for _, want := range []string{`pb "example.com/api/gen/order/v1/natsrpc"`, "&v1.Msg{}"} {
+		if !strings.Contains(cli, want) {
+			t.Errorf("CLI missing %q:\n%s", want, cli)
+		}
+	}
+
+	// Python and TypeScript reach the messages in the parent directories and the
+	// shared file by its suffix
+	_, out = runPlugin(t, "language=python,out_dir_template=nats/rpc,out_suffix=_rpc.py", order())
+	py := out["order/v1/nats/rpc/order_rpc.py"]
+	for _, want := range []string{"from ... import order_pb2 as pb", "from .shared_rpc import ("} {
+		if !strings.Contains(py, want) {
+			t.Errorf("Python output missing %q", want)
+		}
+	}
+	_, out = runPlugin(t, "language=ts,out_dir_template=natsrpc,out_suffix=.nats.ts,file_per_service", order())
+	ts := out["order/v1/natsrpc/order_service.nats.ts"]
+	for _, want := range []string{"import * as pb from '../order';", "} from './shared.nats';"} {
+		if !strings.Contains(ts, want) {
+			t.Errorf("TypeScript output missing %q", want)
+		}
+	}
+}
+
+func TestRunSharedFileAcrossRuns(t *testing.T) {
+	// Two protos of one package, as buf passes them to separate plugin runs
+	orders := runFile("order/v1/orders.proto", "order.v1", "example.com/api/gen/order/v1;orderv1")
+	returns := runFile("order/v1/returns.proto", "order.v1", "example.com/api/gen/order/v1;orderv1")
+	renameService(returns, "ReturnService")
+
+	tests := []struct {
+		language string
+		shared   string
+	}{
+		{"go", "example.com/api/gen/order/v1/shared_nats.pb.go"},
+		{"ts", "order/v1/shared_nats.pb.ts"},
+		{"python", "order/v1/shared_nats_pb2.py"},
+		{"csharp", "order/v1/shared_nats.pb.cs"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.language, func(t *testing.T) {
+			parameter := "language=" + tt.language
+			_, first := runPlugin(t, parameter, orders)
+			_, second := runPlugin(t, parameter, returns)
+			resp, both := runPlugin(t, parameter, orders, returns)
+			if resp.Error != nil {
+				t.Fatalf("plugin error: %s", resp.GetError())
+			}
+			if first[tt.shared] == "" || first[tt.shared] != second[tt.shared] || first[tt.shared] != both[tt.shared] {
+				t.Errorf("%s differs between runs, so the last run to write it wins", tt.shared)
+			}
+		})
+	}
+
+	// shared_package leaves the shared file to the run generating the named proto
+	_, out := runPlugin(t, "shared_package=order/v1/returns.proto", orders)
+	if _, ok := out["example.com/api/gen/order/v1/shared_nats.pb.go"]; ok {
+		t.Error("run without the shared_package file generated the shared file")
+	}
+	_, out = runPlugin(t, "shared_package=order/v1/returns.proto", returns)
+	if _, ok := out["example.com/api/gen/order/v1/shared_nats.pb.go"]; !ok {
+		t.Error("run with the shared_package file did not generate the shared file")
+	}
+	resp, out := runPlugin(t, "shared_package=order/v1/returns.proto", orders, returns)
+	if resp.Error != nil {
+		t.Fatalf("plugin error: %s", resp.GetError())
+	}
+	if _, ok := out["example.com/api/gen/order/v1/shared_nats.pb.go"]; !ok {
+		t.Error("shared file missing when both protos are generated")
+	}
+}
+
+func TestRunDuplicateOutput(t *testing.T) {
+	v1 := runFile("order/v1/order.proto", "order.v1", "example.com/api/gen/order;order")
+	v2 := runFile("order/v2/order.proto", "order.v2", "example.com/api/gen/order;order")
+	renameService(v2, "OrderV2Service")
+	other := runFile("order/v1/returns.proto", "order.v1", "example.com/api/gen/order;order")
+	renameService(other, "ReturnService")
+	nsA := runFile("order/v1/order.proto", "order.v1", "example.com/api/gen/order/v1;orderv1")
+	nsA.Options.CsharpNamespace = proto.String("Acme.Orders")
+	nsB := runFile("order/v1/returns.proto", "order.v1", "example.com/api/gen/order/v1;orderv1")
+	renameService(nsB, "ReturnService")
+	nsB.Options.CsharpNamespace = proto.String("Acme.Returns")
+
+	tests := []struct {
+		name      string
+		parameter string
+		files     []*descriptorpb.FileDescriptorProto
+		want      string
+	}{
+		{
+			name:  "same file name",
+			files: []*descriptorpb.FileDescriptorProto{v1, v2},
+			want:  "example.com/api/gen/order/order_nats.pb.go would be generated for both order/v1/order.proto and order/v2/order.proto",
+		},
+		{
+			name:      "same service file name",
+			parameter: "file_per_service,out_dir_template=natsrpc",
+			files:     []*descriptorpb.FileDescriptorProto{v1, other, runFile("order/v2/other.proto", "order.v2", "example.com/api/gen/order;order")},
+			want:      "order_service_nats.pb.go would be generated for both order.v1.OrderService and order.v2.OrderService",
+		},
+		{
+			name:      "two shared_package files",
+			parameter: "shared_package=order/v1/order.proto,shared_package=order/v1/returns.proto",
+			files:     []*descriptorpb.FileDescriptorProto{v1, other},
+			want:      "shared_package names both order/v1/order.proto and order/v1/returns.proto",
+		},
+		{
+			name:      "different shared contents",
+			parameter: "language=csharp",
+			files:     []*descriptorpb.FileDescriptorProto{nsA, nsB},
+			want:      "order/v1/order.proto and order/v1/returns.proto share order/v1/shared_nats.pb.cs but generate different contents",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, _ := runPlugin(t, tt.parameter, tt.files...)
+			if !strings.Contains(resp.GetError(), tt.want) {
+				t.Errorf("error = %q, want it to contain %q", resp.GetError(), tt.want)
+			}
+		})
+	}
+}
+
+func TestRunLegacyCasing(t *testing.T) {
+	file := runFile("api/v1/api.proto", "api.v1", "example.com/api/gen/api/v1;apiv1")
+	renameService(file, "MyAPIV2Service")
+	file.Service[0].Method[0].Name = proto.String("GetHTTPSURL")
+	t.Cleanup(func() { legacyCasing = false })
+
+	tests := []struct {
+		parameter string
+		want      string
+	}{
+		{"", `"my_api_v2_service.get_https_url"`},
+		{"legacy_casing=true", `"my_apiv2_service.get_httpsurl"`},
+		{"language=python", `"my_api_v2_service.get_https_url"`},
+		{"language=python,legacy_casing=true", `"my_apiv2_service.get_httpsurl"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.parameter, func(t *testing.T) {
+			resp, out := runPlugin(t, tt.parameter, file)
+			if resp.Error != nil {
+				t.Fatalf("plugin error: %s", resp.GetError())
+			}
+			var found bool
+			for _, content := range out {
+				found = found || strings.Contains(content, tt.want)
+			}
+			if !found {
+				t.Errorf("no generated file has the subject %s", tt.want)
+			}
+		})
+	}
+}
+
+func TestRunEventOnlyFile(t *testing.T) {
+	events := func() *descriptorpb.FileDescriptorProto {
+		file := runFile("events/v1/events.proto", "events.v1", "example.com/api/gen/events/v1;eventsv1")
+		file.Service = nil
+		file.MessageType[0].Options = &descriptorpb.MessageOptions{}
+		proto.SetExtension(file.MessageType[0].Options, natspb.E_Event, &natspb.EventOptions{Subject: "events.msg"})
+		return file
+	}
+
+	for _, parameter := range []string{"paths=source_relative", "paths=source_relative,file_per_service=true"} {
+		resp, out := runPlugin(t, parameter, events())
+		if resp.Error != nil {
+			t.Fatalf("%s: plugin error: %s", parameter, resp.GetError())
+		}
+		if !strings.Contains(out["events/v1/events_nats.pb.go"], "func PublishMsg(") {
+			t.Errorf("%s: events/v1/events_nats.pb.go does not publish Msg; generated %v", parameter, slices.Sorted(maps.Keys(out)))
+		}
+		if !strings.Contains(out["events/v1/shared_nats.pb.go"], "func publishEvent(") {
+			t.Errorf("%s: no shared file with the event helpers", parameter)
+		}
+	}
+
+	resp, _ := runPlugin(t, "lang=python", events())
+	if !strings.Contains(resp.GetError(), "(natsmicro.event) is not supported for language python") {
+		t.Errorf("python: got %q", resp.GetError())
+	}
+}
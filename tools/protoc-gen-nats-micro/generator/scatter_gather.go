@@ -0,0 +1,19 @@
+package generator
+
+import (
+	"fmt"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// validateScatterGather checks that a (natsmicro.endpoint).scatter_gather method is
+// unary and answered, since every instance's response is collected
+func validateScatterGather(method protoreflect.MethodDescriptor, eopts EndpointOptions) error {
+	if method.IsStreamingClient() || method.IsStreamingServer() {
+		return fmt.Errorf("scatter_gather only applies to unary methods")
+	}
+	if eopts.FireAndForget {
+		return fmt.Errorf("scatter_gather cannot be combined with fire_and_forget")
+	}
+	return nil
+}
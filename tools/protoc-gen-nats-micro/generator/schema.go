@@ -0,0 +1,178 @@
+package generator
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+
+	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// ServiceSchema is the descriptor blob a generated service serves from its
+// $reflect endpoint and derives its endpoint metadata from.
+type ServiceSchema struct {
+	Blob     string // FileDescriptorSet wire bytes, as a quoted Go string literal
+	Hash     string // "sha256:<hex>" of the blob
+	Document string // JSON served from the $schema endpoint, as a quoted Go string literal
+}
+
+// schemaDocument is the part of a $schema document known at generation time.
+// Services fill in their name, version and subjects when they register.
+type schemaDocument struct {
+	Service    string                 `json:"service"`
+	SchemaHash string                 `json:"schema_hash"`
+	Methods    []schemaDocumentMethod `json:"methods"`
+	Descriptor []byte                 `json:"descriptor"` // Gzipped blob
+}
+
+type schemaDocumentMethod struct {
+	Name         string                 `json:"name"`
+	Endpoint     string                 `json:"endpoint"`
+	Subject      string                 `json:"subject,omitempty"` // Only (natsmicro.endpoint).subject
+	Streaming    string                 `json:"streaming"`
+	RequestType  string                 `json:"request_type"`
+	ResponseType string                 `json:"response_type"`
+	Headers      []schemaDocumentHeader `json:"headers,omitempty"`
+}
+
+type schemaDocumentHeader struct {
+	Name     string `json:"name"`
+	Required bool   `json:"required,omitempty"`
+}
+
+// GetServiceSchema builds the schema of a service: a FileDescriptorSet holding
+// the service's file and every file declaring a message or enum its methods
+// reach, dependencies first, with source info stripped. The encoding is
+// deterministic, so the hash only changes when the schema does.
+func GetServiceSchema(service *protogen.Service) (ServiceSchema, error) {
+	files := schemaFiles(service.Desc)
+	set := &descriptorpb.FileDescriptorSet{}
+	for _, file := range files {
+		fdp := protodesc.ToFileDescriptorProto(file)
+		fdp.SourceCodeInfo = nil
+		set.File = append(set.File, fdp)
+	}
+	blob, err := proto.MarshalOptions{Deterministic: true}.Marshal(set)
+	if err != nil {
+		return ServiceSchema{}, err
+	}
+	sum := sha256.Sum256(blob)
+	hash := "sha256:" + hex.EncodeToString(sum[:])
+	document, err := schemaDocumentOf(service, blob, hash)
+	if err != nil {
+		return ServiceSchema{}, err
+	}
+	return ServiceSchema{
+		Blob:     strconv.Quote(string(blob)),
+		Hash:     hash,
+		Document: strconv.Quote(string(document)),
+	}, nil
+}
+
+// schemaDocumentOf builds the $schema document of a service. Skipped methods are
+// left out, as they have no endpoint.
+func schemaDocumentOf(service *protogen.Service, blob []byte, hash string) ([]byte, error) {
+	var gz bytes.Buffer
+	w, err := gzip.NewWriterLevel(&gz, gzip.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(blob); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	doc := schemaDocument{
+		Service:    string(service.Desc.FullName()),
+		SchemaHash: hash,
+		Methods:    []schemaDocumentMethod{},
+		Descriptor: gz.Bytes(),
+	}
+	for _, method := range service.Methods {
+		eopts := GetEndpointOptions(method)
+		if eopts.Skip {
+			continue
+		}
+		streaming := "unary"
+		switch {
+		case IsBidiStreaming(method):
+			streaming = "bidi"
+		case IsClientStreaming(method):
+			streaming = "client"
+		case IsServerStreaming(method):
+			streaming = "server"
+		}
+		var headers []schemaDocumentHeader
+		for _, header := range eopts.Headers {
+			headers = append(headers, schemaDocumentHeader{Name: header.Name, Required: header.Required})
+		}
+		doc.Methods = append(doc.Methods, schemaDocumentMethod{
+			Name:         string(method.Desc.Name()),
+			Endpoint:     EndpointName(method),
+			Subject:      eopts.ExactSubject(),
+			Streaming:    streaming,
+			RequestType:  string(method.Input.Desc.FullName()),
+			ResponseType: string(method.Output.Desc.FullName()),
+			Headers:      headers,
+		})
+	}
+	return json.Marshal(doc)
+}
+
+// schemaFiles returns the files a service's schema needs, each after the
+// files it imports
+func schemaFiles(service protoreflect.ServiceDescriptor) []protoreflect.FileDescriptor {
+	needed := map[string]bool{service.ParentFile().Path(): true}
+	seen := make(map[protoreflect.FullName]bool)
+	var walk func(md protoreflect.MessageDescriptor)
+	walk = func(md protoreflect.MessageDescriptor) {
+		if seen[md.FullName()] {
+			return
+		}
+		seen[md.FullName()] = true
+		needed[md.ParentFile().Path()] = true
+		fields := md.Fields()
+		for i := 0; i < fields.Len(); i++ {
+			field := fields.Get(i)
+			if field.Message() != nil {
+				walk(field.Message())
+			}
+			if field.Enum() != nil {
+				needed[field.Enum().ParentFile().Path()] = true
+			}
+		}
+	}
+	methods := service.Methods()
+	for i := 0; i < methods.Len(); i++ {
+		walk(methods.Get(i).Input())
+		walk(methods.Get(i).Output())
+	}
+
+	var ordered []protoreflect.FileDescriptor
+	visited := make(map[string]bool)
+	var visit func(fd protoreflect.FileDescriptor)
+	visit = func(fd protoreflect.FileDescriptor) {
+		if visited[fd.Path()] {
+			return
+		}
+		visited[fd.Path()] = true
+		imports := fd.Imports()
+		for i := 0; i < imports.Len(); i++ {
+			visit(imports.Get(i).FileDescriptor)
+		}
+		if needed[fd.Path()] {
+			ordered = append(ordered, fd)
+		}
+	}
+	// Walking imports from the service's file reaches every file its messages use
+	visit(service.ParentFile())
+	return ordered
+}
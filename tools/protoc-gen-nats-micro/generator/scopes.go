@@ -0,0 +1,35 @@
+package generator
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// validateRequiredScopes checks that (natsmicro.endpoint).required_scopes names each
+// scope once. Scopes cannot contain whitespace or commas, which separate them in headers.
+func validateRequiredScopes(scopes []string) error {
+	seen := make(map[string]bool, len(scopes))
+	for _, scope := range scopes {
+		if scope == "" {
+			return fmt.Errorf("required_scopes contains an empty scope")
+		}
+		if strings.ContainsAny(scope, " \t\r\n,") {
+			return fmt.Errorf("required scope %q contains whitespace or a comma", scope)
+		}
+		if seen[scope] {
+			return fmt.Errorf("required scope %q is listed more than once", scope)
+		}
+		seen[scope] = true
+	}
+	return nil
+}
+
+// GoStringSlice renders values as a Go []string literal, e.g., []string{"orders:read"}
+func GoStringSlice(values []string) string {
+	quoted := make([]string, len(values))
+	for i, v := range values {
+		quoted[i] = strconv.Quote(v)
+	}
+	return "[]string{" + strings.Join(quoted, ", ") + "}"
+}
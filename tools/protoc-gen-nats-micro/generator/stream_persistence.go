@@ -0,0 +1,25 @@
+package generator
+
+import (
+	"fmt"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// validateStreamPersistence checks that (natsmicro.stream).persistence is only set on
+// server-streaming methods, whose messages flow one way and can be read again
+func validateStreamPersistence(method protoreflect.MethodDescriptor, stream *StreamOpts) error {
+	if !stream.Persistence {
+		if stream.PersistenceTTL != 0 {
+			return fmt.Errorf("(natsmicro.stream).persistence_ttl requires persistence")
+		}
+		return nil
+	}
+	if method.IsStreamingClient() || !method.IsStreamingServer() {
+		return fmt.Errorf("(natsmicro.stream).persistence only applies to server-streaming methods")
+	}
+	if stream.PersistenceTTL < 0 {
+		return fmt.Errorf("(natsmicro.stream).persistence_ttl must not be negative, got %s", stream.PersistenceTTL)
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package generator
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"google.golang.org/protobuf/compiler/protogen"
+)
+
+// ValidateSubject checks that a literal subject from proto options is a valid,
+// non-wildcard NATS subject: no whitespace, no empty tokens, no "*" or ">" tokens.
+func ValidateSubject(subject string) error {
+	if subject == "" {
+		return fmt.Errorf("subject is empty")
+	}
+	if i := strings.IndexFunc(subject, unicode.IsSpace); i >= 0 {
+		return fmt.Errorf("subject %q contains whitespace", subject)
+	}
+	for _, token := range strings.Split(subject, ".") {
+		switch {
+		case token == "":
+			return fmt.Errorf("subject %q contains an empty token", subject)
+		case strings.ContainsAny(token, "*>"):
+			return fmt.Errorf("subject %q contains wildcard characters", subject)
+		}
+	}
+	return nil
+}
+
+// SubjectVersionToken returns the subject token version_in_subject inserts for a
+// service version: "v" followed by its major component.
+// e.g., "2.1.0" -> "v2", "v1" -> "v1"
+func SubjectVersionToken(version string) string {
+	major, _, _ := strings.Cut(strings.TrimPrefix(version, "v"), ".")
+	return "v" + major
+}
+
+// VersionedSubjectPrefix appends a version token to a subject prefix. An empty
+// token leaves the prefix alone; an empty prefix leaves just the token.
+// e.g., ("api.orders", "v2") -> "api.orders.v2"
+func VersionedSubjectPrefix(prefix, token string) string {
+	switch {
+	case token == "":
+		return prefix
+	case prefix == "":
+		return token
+	}
+	return prefix + "." + token
+}
+
+// EndpointName returns a method's endpoint name, the subject token that follows
+// the service's prefix. Every language's templates take it from here, so clients
+// and services of different languages agree on subjects.
+// e.g., CreateProduct -> "create_product", searchProducts -> "search_products"
+func EndpointName(method *protogen.Method) string {
+	return endpointName(string(method.Desc.Name()))
+}
+
+// endpointName is EndpointName for a proto method name. It goes through the Go
+// name protoc-gen-go gives the method, so both agree for every proto name.
+func endpointName(name string) string {
+	return ToSnakeCase(goCamelCase(name))
+}
+
+// MethodSubject returns the full subject for a method under the given prefix,
+// honoring a (natsmicro.endpoint).subject override or the wildcard form of a
+// subject_template.
+// e.g., ("api.v1", CreateProduct) -> "api.v1.create_product"
+func MethodSubject(method *protogen.Method, prefix string) string {
+	if subject := GetEndpointOptions(method).ExactSubject(); subject != "" {
+		return subject
+	}
+	return prefix + "." + EndpointName(method)
+}
+
+// SubjectEntry is a method's subject under its service's default prefix
+type SubjectEntry struct {
+	Method  string // Go name of the method, e.g., "CreateProduct"
+	Subject string // e.g., "api.v1.create_product"
+}
+
+// ServiceSubjects returns the subjects of a service's methods under its default
+// (versioned) prefix, in declaration order, skipping (natsmicro.endpoint).skip
+// methods. Each language emits them as its subject table.
+func ServiceSubjects(service *protogen.Service) []SubjectEntry {
+	prefix := GetServiceOptions(service).VersionedPrefix()
+	var entries []SubjectEntry
+	for _, method := range service.Methods {
+		if GetEndpointOptions(method).Skip {
+			continue
+		}
+		entries = append(entries, SubjectEntry{Method: method.GoName, Subject: MethodSubject(method, prefix)})
+	}
+	return entries
+}
+
+// SubjectExprGo returns a Go expression evaluating to the method's subject,
+// where prefixExpr is a Go expression holding the runtime subject prefix.
+// e.g., c.subjectPrefix + ".create_product", or "orders.legacy.create" for overrides
+func SubjectExprGo(method *protogen.Method, prefixExpr string) string {
+	if subject := GetEndpointOptions(method).ExactSubject(); subject != "" {
+		return fmt.Sprintf("%q", subject)
+	}
+	return fmt.Sprintf("%s + %q", prefixExpr, "."+EndpointName(method))
+}
+
+// SubjectExprTS returns a TypeScript expression evaluating to the method's subject,
+// where prefixExpr is a TS expression holding the runtime subject prefix.
+// e.g., `${this.subjectPrefix}.create_product`
+func SubjectExprTS(method *protogen.Method, prefixExpr string) string {
+	if subject := GetEndpointOptions(method).ExactSubject(); subject != "" {
+		return fmt.Sprintf("'%s'", subject)
+	}
+	return fmt.Sprintf("`${%s}.%s`", prefixExpr, EndpointName(method))
+}
+
+// SubjectExprPy returns a Python expression evaluating to the method's subject,
+// where prefixExpr is a Python expression holding the runtime subject prefix.
+// e.g., f"{self._subject_prefix}.create_product"
+func SubjectExprPy(method *protogen.Method, prefixExpr string) string {
+	if subject := GetEndpointOptions(method).ExactSubject(); subject != "" {
+		return fmt.Sprintf("%q", subject)
+	}
+	return fmt.Sprintf("f\"{%s}.%s\"", prefixExpr, EndpointName(method))
+}
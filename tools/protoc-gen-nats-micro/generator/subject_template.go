@@ -0,0 +1,116 @@
+package generator
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// subjectTemplatePlaceholderRe matches a {field} token of a subject template
+var subjectTemplatePlaceholderRe = regexp.MustCompile(`^\{(\w+)\}$`)
+
+// SubjectTemplateWildcard returns the subject a subject_template endpoint is
+// registered at, with each placeholder token replaced by "*".
+// e.g., "orders.{region}.create" -> "orders.*.create"
+func SubjectTemplateWildcard(template string) string {
+	tokens := strings.Split(template, ".")
+	for i, token := range tokens {
+		if subjectTemplatePlaceholderRe.MatchString(token) {
+			tokens[i] = "*"
+		}
+	}
+	return strings.Join(tokens, ".")
+}
+
+// subjectTemplateFields returns the fields of a validated subject template's
+// placeholders, in template order
+func subjectTemplateFields(template string) []string {
+	var fields []string
+	for _, token := range strings.Split(template, ".") {
+		if m := subjectTemplatePlaceholderRe.FindStringSubmatch(token); m != nil {
+			fields = append(fields, m[1])
+		}
+	}
+	return fields
+}
+
+// validateSubjectTemplate checks a (natsmicro.endpoint).subject_template: a unary
+// method, a subject without whitespace, empty tokens or wildcards, and at least one
+// placeholder. Each placeholder must be a whole token naming a singular string,
+// integer, bool or enum field of the input, so every call fills it.
+func validateSubjectTemplate(method protoreflect.MethodDescriptor, eopts EndpointOptions) error {
+	template := eopts.SubjectTemplate
+	switch {
+	case method.IsStreamingClient() || method.IsStreamingServer():
+		return fmt.Errorf("subject_template only applies to unary methods")
+	case eopts.Subject != "":
+		return fmt.Errorf("subject_template cannot be combined with subject")
+	case eopts.FireAndForget:
+		return fmt.Errorf("subject_template cannot be combined with fire_and_forget")
+	case eopts.ScatterGather:
+		return fmt.Errorf("subject_template cannot be combined with scatter_gather")
+	}
+	if strings.IndexFunc(template, unicode.IsSpace) >= 0 {
+		return fmt.Errorf("subject_template %q contains whitespace", template)
+	}
+
+	placeholders := 0
+	for _, token := range strings.Split(template, ".") {
+		m := subjectTemplatePlaceholderRe.FindStringSubmatch(token)
+		switch {
+		case token == "":
+			return fmt.Errorf("subject_template %q contains an empty token", template)
+		case m == nil && strings.ContainsAny(token, "{}"):
+			return fmt.Errorf("subject_template %q: placeholder %s must be a whole token", template, token)
+		case m == nil && strings.ContainsAny(token, "*>"):
+			return fmt.Errorf("subject_template %q contains wildcard characters", template)
+		case m == nil:
+			continue
+		}
+		if err := validateSubjectTemplateField(template, method.Input(), m[1]); err != nil {
+			return err
+		}
+		placeholders++
+	}
+	if placeholders == 0 {
+		return fmt.Errorf("subject_template %q has no {field} placeholders; use subject for a fixed subject", template)
+	}
+	return nil
+}
+
+// validateSubjectTemplateField checks that a placeholder names an input field
+// that always renders as one non-empty token, strings aside, which clients check
+func validateSubjectTemplateField(template string, input protoreflect.MessageDescriptor, name string) error {
+	field := input.Fields().ByName(protoreflect.Name(name))
+	if field == nil {
+		var fieldNames []string
+		for i := 0; i < input.Fields().Len(); i++ {
+			fieldNames = append(fieldNames, string(input.Fields().Get(i).Name()))
+		}
+		return fmt.Errorf("subject_template %q references field {%s} which does not exist on input message %s (available fields: [%s])",
+			template, name, input.Name(), strings.Join(fieldNames, ", "))
+	}
+	if field.IsList() || field.IsMap() {
+		return fmt.Errorf("subject_template %q: {%s} is a repeated field; placeholders need a singular field", template, name)
+	}
+	switch field.Kind() {
+	case protoreflect.MessageKind, protoreflect.GroupKind, protoreflect.BytesKind, protoreflect.FloatKind, protoreflect.DoubleKind:
+		return fmt.Errorf("subject_template %q: {%s} is a %s field; placeholders need a string, integer, bool or enum field", template, name, field.Kind())
+	}
+	return nil
+}
+
+// SubjectTemplateFieldsGo returns a Go map literal of the request fields filling
+// the method's subject_template placeholders, read from msgExpr.
+// e.g., map[string]string{"region": subjectTemplateToken(req.GetRegion())}
+func SubjectTemplateFieldsGo(method *protogen.Method, msgExpr string) string {
+	var entries []string
+	for _, field := range subjectTemplateFields(GetEndpointOptions(method).SubjectTemplate) {
+		entries = append(entries, fmt.Sprintf("%q: subjectTemplateToken(%s.Get%s())", field, msgExpr, fieldNameToGoGetter(field)))
+	}
+	return "map[string]string{" + strings.Join(entries, ", ") + "}"
+}
@@ -0,0 +1,182 @@
+package generator
+
+import (
+	"encoding/json"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+
+	natspb "github.com/toyz/protoc-gen-nats-micro/tools/protoc-gen-nats-micro/nats/micro"
+)
+
+func TestValidateSubject(t *testing.T) {
+	tests := []struct {
+		subject string
+		wantErr bool
+	}{
+		{"orders.legacy.create", false},
+		{"orders", false},
+		{"api.v1-beta.get_order", false},
+		{"", true},
+		{"orders legacy", true},
+		{"orders..create", true},
+		{".orders", true},
+		{"orders.", true},
+		{"orders.*", true},
+		{"orders.>", true},
+		{"orders.a*b", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.subject, func(t *testing.T) {
+			err := ValidateSubject(tt.subject)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateSubject(%q) error = %v, wantErr %v", tt.subject, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestVersionedSubjectPrefix(t *testing.T) {
+	tests := []struct {
+		prefix, version, want string
+	}{
+		{"api.orders", "2.1.0", "api.orders.v2"},
+		{"api.orders", "v1", "api.orders.v1"},
+		{"api.orders", "v3.0", "api.orders.v3"},
+		{"", "1.0.0", "v1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.prefix+"@"+tt.version, func(t *testing.T) {
+			got := VersionedSubjectPrefix(tt.prefix, SubjectVersionToken(tt.version))
+			if got != tt.want {
+				t.Errorf("VersionedSubjectPrefix(%q, SubjectVersionToken(%q)) = %q, want %q", tt.prefix, tt.version, got, tt.want)
+			}
+		})
+	}
+	if got := VersionedSubjectPrefix("api.orders", ""); got != "api.orders" {
+		t.Errorf("empty token changed the prefix to %q", got)
+	}
+}
+
+// subjectVectors is testdata/subject_vectors.json, the subject math every
+// language's generated code must agree on
+type subjectVectors struct {
+	EndpointNames []struct {
+		Method   string `json:"method"`
+		Endpoint string `json:"endpoint"`
+	} `json:"endpoint_names"`
+	VersionedPrefixes []struct {
+		Prefix  string `json:"prefix"`
+		Version string `json:"version"`
+		Want    string `json:"want"`
+	} `json:"versioned_prefixes"`
+}
+
+func TestSubjectVectors(t *testing.T) {
+	data, err := os.ReadFile("testdata/subject_vectors.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var vectors subjectVectors
+	if err := json.Unmarshal(data, &vectors); err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range vectors.EndpointNames {
+		if got := endpointName(v.Method); got != v.Endpoint {
+			t.Errorf("endpointName(%q) = %q, want %q", v.Method, got, v.Endpoint)
+		}
+	}
+	for _, v := range vectors.VersionedPrefixes {
+		if got := VersionedSubjectPrefix(v.Prefix, SubjectVersionToken(v.Version)); got != v.Want {
+			t.Errorf("VersionedSubjectPrefix(%q, SubjectVersionToken(%q)) = %q, want %q", v.Prefix, v.Version, got, v.Want)
+		}
+	}
+
+	// Each language's runtime helper is the same math as the Go one
+	for _, tt := range []struct {
+		language, file, helper string
+	}{
+		{"go", "example.com/fixture/v1/shared_nats.pb.go", "major, _, _ := strings.Cut(strings.TrimPrefix(version, \"v\"), \".\")"},
+		{"ts", "fixture/v1/shared_nats.pb.ts", "const major = (version.startsWith('v') ? version.slice(1) : version).split('.')[0];"},
+		{"web-ts", "fixture/v1/shared_nats.pb.ts", "const major = (version.startsWith('v') ? version.slice(1) : version).split('.')[0];"},
+		{"python", "fixture/v1/shared_nats_pb2.py", `major = (version[1:] if version.startswith("v") else version).split(".")[0]`},
+		{"csharp", "fixture/v1/shared_nats.pb.cs", `var major = (version.StartsWith("v", StringComparison.Ordinal) ? version.Substring(1) : version).Split('.')[0];`},
+	} {
+		_, out := runPlugin(t, "language="+tt.language, subjectFixture().File...)
+		if !strings.Contains(out[tt.file], tt.helper) {
+			t.Errorf("%s: %s does not compute the version token as Go does", tt.language, tt.file)
+		}
+	}
+}
+
+// subjectFixture has the method names, overrides and options subjects depend on
+func subjectFixture() *descriptorpb.FileDescriptorSet {
+	products := lintService("ProductService", "api.products",
+		lintMethod("CreateProduct", nil),
+		lintMethod("searchProducts", nil),
+		lintMethod("GetHTTPStatus", nil),
+		lintMethod("LegacyDelete", func(o *descriptorpb.MethodOptions) {
+			proto.SetExtension(o, natspb.E_Endpoint, &natspb.EndpointOptions{Subject: "products.legacy.delete"})
+		}),
+		lintMethod("Internal", func(o *descriptorpb.MethodOptions) {
+			proto.SetExtension(o, natspb.E_Endpoint, &natspb.EndpointOptions{Skip: true})
+		}),
+	)
+	orders := lintService("OrderService", "", lintMethod("GetOrder", nil), lintMethod("list_order_items", nil))
+	orders.Options = &descriptorpb.ServiceOptions{}
+	proto.SetExtension(orders.Options, natspb.E_Service, &natspb.ServiceOptions{SubjectPrefix: "api.orders", Version: "2.1.0", VersionInSubject: true})
+	set := lintFixture(products, orders)
+	set.File[0].Options = &descriptorpb.FileOptions{GoPackage: proto.String("example.com/fixture/v1;fixturev1")}
+	return set
+}
+
+// TestSubjectTablesConformance checks that every language emits the same subject
+// table for the same protos, and that it matches testdata/subjects.golden
+func TestSubjectTablesConformance(t *testing.T) {
+	golden, err := os.ReadFile("testdata/subjects.golden")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		language string
+		table    *regexp.Regexp // Service name and entries of a table
+		entry    *regexp.Regexp // Method and subject of an entry
+	}{
+		{"go", regexp.MustCompile(`(?s)var (\w+)Subjects = map\[string\]string\{\n(.*?)\n\}`), regexp.MustCompile(`"(\w+)":\s+"([^"]+)"`)},
+		{"ts", regexp.MustCompile(`(?s)export const (\w+)Subjects = \{\n(.*?)\n\} as const;`), regexp.MustCompile(`(\w+): '([^']+)'`)},
+		{"web-ts", regexp.MustCompile(`(?s)export const (\w+)Subjects = \{\n(.*?)\n\} as const;`), regexp.MustCompile(`(\w+): '([^']+)'`)},
+		{"python", regexp.MustCompile(`(?s)(\w+)_SUBJECTS: Dict\[str, str\] = \{\n(.*?)\n\}`), regexp.MustCompile(`"(\w+)": "([^"]+)"`)},
+		{"csharp", regexp.MustCompile(`(?s)public static class (\w+)Subjects\n\{\n(.*?)\n\}`), regexp.MustCompile(`public const string (\w+) = "([^"]+)";`)},
+	} {
+		t.Run(tt.language, func(t *testing.T) {
+			_, out := runPlugin(t, "language="+tt.language, subjectFixture().File...)
+			var b strings.Builder
+			for _, name := range []string{"ProductService", "OrderService"} {
+				tables := 0
+				for _, content := range out {
+					for _, table := range tt.table.FindAllStringSubmatch(content, -1) {
+						if table[1] != name && table[1] != strings.ToUpper(ToSnakeCase(name)) {
+							continue
+						}
+						tables++
+						for _, entry := range tt.entry.FindAllStringSubmatch(table[2], -1) {
+							b.WriteString(name + "." + entry[1] + " " + entry[2] + "\n")
+						}
+					}
+				}
+				if tables != 1 {
+					t.Errorf("found %d subject tables for %s, want 1", tables, name)
+				}
+			}
+			if got := b.String(); got != string(golden) {
+				t.Errorf("subject table differs from testdata/subjects.golden\ngot:\n%swant:\n%s", got, golden)
+			}
+		})
+	}
+}
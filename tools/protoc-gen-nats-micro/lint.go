@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/toyz/protoc-gen-nats-micro/tools/protoc-gen-nats-micro/generator"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// runLint implements the standalone `lint` subcommand. It reads a FileDescriptorSet,
+// runs every generation-time validation and prints the findings.
+// Returns the process exit code: 0 when clean (warnings allowed), 1 on errors, 2 on usage failures.
+func runLint(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("lint", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	descriptors := fs.String("descriptors", "", "path to a FileDescriptorSet (buf build -o set.binpb), or - for stdin")
+	asJSON := fs.Bool("json", false, "print findings as a JSON array")
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "usage: protoc-gen-nats-micro lint --descriptors set.binpb [--json]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *descriptors == "" {
+		fs.Usage()
+		return 2
+	}
+
+	var data []byte
+	var err error
+	if *descriptors == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(*descriptors)
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "lint: read descriptors: %v\n", err)
+		return 2
+	}
+
+	var set descriptorpb.FileDescriptorSet
+	if err := proto.Unmarshal(data, &set); err != nil {
+		fmt.Fprintf(stderr, "lint: decode descriptors: %v\n", err)
+		return 2
+	}
+
+	findings, err := generator.LintDescriptorSet(&set)
+	if err != nil {
+		fmt.Fprintf(stderr, "lint: %v\n", err)
+		return 2
+	}
+
+	if *asJSON {
+		if findings == nil {
+			findings = []generator.Finding{}
+		}
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(findings); err != nil {
+			fmt.Fprintf(stderr, "lint: encode findings: %v\n", err)
+			return 2
+		}
+	} else {
+		for _, f := range findings {
+			fmt.Fprintln(stdout, f)
+		}
+	}
+
+	if generator.HasErrors(findings) {
+		return 1
+	}
+	return 0
+}